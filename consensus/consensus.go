@@ -28,6 +28,20 @@ type Consenter interface {
 	StateUpdating(tag uint64, id []byte)                    // Called when SkipTo causes state transfer to start serial with StateUpdated
 }
 
+// Reconfigurer is implemented by the plugins which can change the set of
+// validators without restarting the network
+type Reconfigurer interface {
+	AddValidator(handle *pb.PeerID) error    // Votes for adding a validator to the network
+	RemoveValidator(handle *pb.PeerID) error // Votes for removing a validator from the network
+}
+
+// Tuner is implemented by the plugins whose parameters can be adjusted
+// without restarting the network
+type Tuner interface {
+	GetParameters() (*pb.ConsensusParameters, error)    // Returns the parameters in effect
+	SetParameters(params *pb.ConsensusParameters) error // Requests new parameters, adjusted on every validator once agreed on
+}
+
 // Inquirer is used to retrieve info about the validating network
 type Inquirer interface {
 	GetNetworkInfo() (self *pb.PeerEndpoint, network []*pb.PeerEndpoint, err error)
@@ -52,6 +66,28 @@ type SecurityUtils interface {
 	Verify(peerID *pb.PeerID, signature []byte, message []byte) error
 }
 
+// SigningCert is a short-lived certificate of the validator, with its signing key
+type SigningCert interface {
+	GetCertificate() []byte          // Returns the DER of the certificate
+	Sign(msg []byte) ([]byte, error) // Signs with the key of the certificate
+}
+
+// TCertSigner is implemented by the stacks whose validator can sign with
+// TCerts in place of its enrollment key
+type TCertSigner interface {
+	GetNextTCert() (SigningCert, error)                           // Draws the next TCert of the pool of the validator
+	VerifyWithTCert(cert []byte, signature, message []byte) error // Verifies a signature under a TCert issued by the TCA
+}
+
+// ChainInquirer is implemented by the stacks which tell the chain they
+// execute on, the other stacks execute on the default chain. The stack of a
+// chain other than the default chain names its validators vp0 to vp<N-1>, N
+// being their number.
+type ChainInquirer interface {
+	GetChainID() string     // Returns the ID of the chain, empty for the default chain
+	GetValidatorCount() int // Returns the number of validators of the chain, 0 for the default chain whose validators are set by the plugin configuration
+}
+
 // ReadOnlyLedger is used for interrogating the blockchain
 type ReadOnlyLedger interface {
 	GetBlock(id uint64) (block *pb.Block, err error)
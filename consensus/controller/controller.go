@@ -17,14 +17,18 @@ limitations under the License.
 package controller
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
 
 	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/consensus/kafka"
 	"github.com/hyperledger/fabric/consensus/noops"
 	"github.com/hyperledger/fabric/consensus/obcpbft"
+	"github.com/hyperledger/fabric/consensus/orderer"
+	"github.com/hyperledger/fabric/consensus/raft"
 )
 
 var logger *logging.Logger // package-level logger
@@ -42,7 +46,38 @@ func NewConsenter(stack consensus.Stack) consensus.Consenter {
 		logger.Info("Creating consensus plugin %s", plugin)
 		return obcpbft.GetPlugin(stack)
 	}
+	if plugin == "raft" {
+		logger.Info("Creating consensus plugin %s", plugin)
+		return raft.GetPlugin(stack)
+	}
+	if plugin == "kafka" {
+		logger.Info("Creating consensus plugin %s", plugin)
+		return kafka.GetPlugin(stack)
+	}
+	if plugin == "orderer" {
+		logger.Info("Creating consensus plugin %s", plugin)
+		return orderer.GetPlugin(stack)
+	}
 	logger.Info("Creating default consensus plugin (noops)")
 	return noops.GetNoops(stack)
 
 }
+
+// NewChainConsenter constructs a new Consenter object for a chain other than
+// the default chain, which NewConsenter keeps a single Consenter for
+func NewChainConsenter(stack consensus.Stack) consensus.Consenter {
+	plugin := strings.ToLower(viper.GetString("peer.validator.consensus.plugin"))
+	logger.Info("Creating consensus plugin %s for a chain", plugin)
+	switch plugin {
+	case "pbft":
+		return obcpbft.New(stack)
+	case "raft":
+		return raft.New(stack)
+	case "kafka":
+		return kafka.New(stack)
+	case "orderer":
+		// the ordering service delivers the batches of a single chain
+		panic(fmt.Errorf("Consensus plugin %s only orders the default chain", plugin))
+	}
+	return noops.New(stack)
+}
@@ -0,0 +1,154 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/peer"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// chainStack is the stack state transfer syncs a chain other than the
+// default chain with: the ledger of the chain, and the ledgers of the chain
+// kept by its other validators
+type chainStack struct {
+	coordinator peer.MessageHandlerCoordinator
+	chainID     string
+}
+
+func (s *chainStack) getLedger() (*ledger.Ledger, error) {
+	return ledger.GetChainLedger(s.chainID)
+}
+
+// GetBlockByNumber returns the block of the chain with the given number
+func (s *chainStack) GetBlockByNumber(blockNumber uint64) (*pb.Block, error) {
+	l, err := s.getLedger()
+	if err != nil {
+		return nil, err
+	}
+	return l.GetBlockByNumber(blockNumber)
+}
+
+// GetBlockchainSize returns the height of the chain
+func (s *chainStack) GetBlockchainSize() uint64 {
+	l, err := s.getLedger()
+	if err != nil {
+		logger.Error("Failed to get the ledger of chain %s: %v", s.chainID, err)
+		return 0
+	}
+	return l.GetBlockchainSize()
+}
+
+// GetCurrentStateHash returns the hash of the state of the chain, with its changes not committed
+func (s *chainStack) GetCurrentStateHash() ([]byte, error) {
+	l, err := s.getLedger()
+	if err != nil {
+		return nil, err
+	}
+	return l.GetTempStateHash()
+}
+
+// ApplyStateDelta applies a state delta to the state of the chain
+func (s *chainStack) ApplyStateDelta(id interface{}, delta *statemgmt.StateDelta) error {
+	l, err := s.getLedger()
+	if err != nil {
+		return err
+	}
+	return l.ApplyStateDelta(id, delta)
+}
+
+// CommitStateDelta commits the state delta applied with the given ID
+func (s *chainStack) CommitStateDelta(id interface{}) error {
+	l, err := s.getLedger()
+	if err != nil {
+		return err
+	}
+	return l.CommitStateDelta(id)
+}
+
+// RollbackStateDelta discards the state delta applied with the given ID
+func (s *chainStack) RollbackStateDelta(id interface{}) error {
+	l, err := s.getLedger()
+	if err != nil {
+		return err
+	}
+	return l.RollbackStateDelta(id)
+}
+
+// EmptyState deletes the whole state of the chain
+func (s *chainStack) EmptyState() error {
+	l, err := s.getLedger()
+	if err != nil {
+		return err
+	}
+	return l.DeleteALLStateKeysAndValues()
+}
+
+// PutBlock puts a block fetched from another validator on the chain
+func (s *chainStack) PutBlock(blockNumber uint64, block *pb.Block) error {
+	l, err := s.getLedger()
+	if err != nil {
+		return err
+	}
+	return l.PutRawBlock(block, blockNumber)
+}
+
+// HashBlock returns the hash of the block
+func (s *chainStack) HashBlock(block *pb.Block) ([]byte, error) {
+	return block.GetHash()
+}
+
+// VerifyBlockchain checks the hashes linking the blocks of the chain from start down to finish
+func (s *chainStack) VerifyBlockchain(start, finish uint64) (uint64, error) {
+	l, err := s.getLedger()
+	if err != nil {
+		return 0, err
+	}
+	return l.VerifyChain(start, finish)
+}
+
+// GetPeers returns the connected peers, leaving out the validating peers
+// which are not validators of the chain
+func (s *chainStack) GetPeers() (*pb.PeersMessage, error) {
+	peersMsg, err := s.coordinator.GetPeers()
+	if err != nil {
+		return nil, err
+	}
+	peers := []*pb.PeerEndpoint{}
+	for _, endpoint := range peersMsg.GetPeers() {
+		if endpoint.Type != pb.PeerEndpoint_VALIDATOR || peer.IsChainValidator(s.chainID, endpoint.ID) {
+			peers = append(peers, endpoint)
+		}
+	}
+	return &pb.PeersMessage{Peers: peers}, nil
+}
+
+// GetPeerEndpoint returns the endpoint of this peer
+func (s *chainStack) GetPeerEndpoint() (*pb.PeerEndpoint, error) {
+	return s.coordinator.GetPeerEndpoint()
+}
+
+// GetRemoteLedger returns the ledger of the chain kept by another validator of the chain
+func (s *chainStack) GetRemoteLedger(receiver *pb.PeerID) (peer.RemoteLedger, error) {
+	if !peer.IsChainValidator(s.chainID, receiver) {
+		return nil, fmt.Errorf("%s is not a validator of chain %s", receiver.Name, s.chainID)
+	}
+	return s.coordinator.GetRemoteChainLedger(receiver, s.chainID)
+}
@@ -35,6 +35,7 @@ type EngineImpl struct {
 	helper       *Helper
 	peerEndpoint *pb.PeerEndpoint
 	consensusFan *util.MessageFan
+	chainID      string // chain the engine orders the transactions of, empty for the default chain
 }
 
 // GetHandlerFactory returns new NewConsensusHandler
@@ -44,9 +45,20 @@ func (eng *EngineImpl) GetHandlerFactory() peer.HandlerFactory {
 
 // ProcessTransactionMsg processes a Message in context of a Transaction
 func (eng *EngineImpl) ProcessTransactionMsg(msg *pb.Message, tx *pb.Transaction) (response *pb.Response) {
+	// The peer hands every transaction to the engine of the default chain,
+	// pass on the transactions of the other chains to their engines
+	if tx.ChainID != eng.chainID {
+		chainEngine := getEngineImpl(tx.ChainID)
+		if chainEngine == nil {
+			return &pb.Response{Status: pb.Response_FAILURE,
+				Msg: []byte(fmt.Sprintf("Error: no consensus on chain [%s]", tx.ChainID))}
+		}
+		return chainEngine.ProcessTransactionMsg(msg, tx)
+	}
+
 	//TODO: Do we always verify security, or can we supply a flag on the invoke ot this functions so to bypass check for locally generated transactions?
 	if tx.Type == pb.Transaction_CHAINCODE_QUERY {
-		if !engine.helper.valid {
+		if !eng.helper.valid {
 			logger.Warning("Rejecting query because state is currently not valid")
 			return &pb.Response{Status: pb.Response_FAILURE,
 				Msg: []byte("Error: state may be inconsistent, cannot query")}
@@ -55,7 +67,7 @@ func (eng *EngineImpl) ProcessTransactionMsg(msg *pb.Message, tx *pb.Transaction
 		// The secHelper is set during creat ChaincodeSupport, so we don't need this step
 		// cxt := context.WithValue(context.Background(), "security", secHelper)
 		cxt := context.Background()
-		result, err := chaincode.Execute(cxt, chaincode.GetChain(chaincode.DefaultChain), tx)
+		result, err := chaincode.Execute(cxt, chaincode.GetChain(chaincode.GetChainName(eng.chainID)), tx)
 		if err != nil {
 			response = &pb.Response{Status: pb.Response_FAILURE,
 				Msg: []byte(fmt.Sprintf("Error:%s", err))}
@@ -79,7 +91,7 @@ func (eng *EngineImpl) ProcessTransactionMsg(msg *pb.Message, tx *pb.Transaction
 		// TODO, do we want to put these requests into a queue? This will block until
 		// the consenter gets around to handling the message, but it also provides some
 		// natural feedback to the REST API to determine how long it takes to queue messages
-		err := eng.consenter.RecvMsg(msg, eng.peerEndpoint.ID)
+		err := eng.consenter.RecvMsg(msg, eng.helper.replicaHandle(eng.peerEndpoint.ID))
 		if err != nil {
 			response = &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(err.Error())}
 		}
@@ -87,6 +99,14 @@ func (eng *EngineImpl) ProcessTransactionMsg(msg *pb.Message, tx *pb.Transaction
 	return response
 }
 
+// GetTuner returns the consenter if its parameters can be adjusted at runtime, nil otherwise
+func (eng *EngineImpl) GetTuner() consensus.Tuner {
+	if tuner, ok := eng.consenter.(consensus.Tuner); ok {
+		return tuner
+	}
+	return nil
+}
+
 func (eng *EngineImpl) setConsenter(consenter consensus.Consenter) *EngineImpl {
 	eng.consenter = consenter
 	return eng
@@ -97,33 +117,53 @@ func (eng *EngineImpl) setPeerEndpoint(peerEndpoint *pb.PeerEndpoint) *EngineImp
 	return eng
 }
 
-var engineOnce sync.Once
+// engines holds the engine of each chain, by chain ID
+var engines = make(map[string]*EngineImpl)
+var enginesLock sync.Mutex
 
-var engine *EngineImpl
-
-func getEngineImpl() *EngineImpl {
-	return engine
+// getEngineImpl returns the engine of the chain with the given ID, nil if
+// consensus does not run on the chain
+func getEngineImpl(chainID string) *EngineImpl {
+	enginesLock.Lock()
+	defer enginesLock.Unlock()
+	return engines[chainID]
 }
 
-// GetEngine returns initialized peer.Engine
+// GetEngine returns initialized peer.Engine of the default chain
 func GetEngine(coord peer.MessageHandlerCoordinator) (peer.Engine, error) {
+	return GetChainEngine(coord, "")
+}
+
+// GetChainEngine returns initialized peer.Engine of the chain with the given
+// ID, the empty ID being the default chain. Every chain runs a consenter of
+// its own, which orders and executes the transactions of the chain only.
+func GetChainEngine(coord peer.MessageHandlerCoordinator, chainID string) (peer.Engine, error) {
+	enginesLock.Lock()
+	defer enginesLock.Unlock()
+	if engine, ok := engines[chainID]; ok {
+		return engine, nil
+	}
+
 	var err error
-	engineOnce.Do(func() {
-		engine = new(EngineImpl)
-		engine.helper = NewHelper(coord)
+	engine := &EngineImpl{chainID: chainID}
+	engine.helper = NewHelper(coord, chainID)
+	if chainID == "" {
 		engine.consenter = controller.NewConsenter(engine.helper)
-		engine.helper.setConsenter(engine.consenter)
-		engine.peerEndpoint, err = coord.GetPeerEndpoint()
-		engine.consensusFan = util.NewMessageFan()
-
-		go func() {
-			logger.Debug("Starting up message thread for consenter")
-
-			// The channel never closes, so this should never break
-			for msg := range engine.consensusFan.GetOutChannel() {
-				engine.consenter.RecvMsg(msg.Msg, msg.Sender)
-			}
-		}()
-	})
+	} else {
+		engine.consenter = controller.NewChainConsenter(engine.helper)
+	}
+	engine.helper.setConsenter(engine.consenter)
+	engine.peerEndpoint, err = coord.GetPeerEndpoint()
+	engine.consensusFan = util.NewMessageFan()
+	engines[chainID] = engine
+
+	go func() {
+		logger.Debug("Starting up message thread for consenter of chain [%s]", chainID)
+
+		// The channel never closes, so this should never break
+		for msg := range engine.consensusFan.GetOutChannel() {
+			engine.consenter.RecvMsg(msg.Msg, engine.helper.replicaHandle(msg.Sender))
+		}
+	}()
 	return engine, err
 }
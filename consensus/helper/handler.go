@@ -18,6 +18,7 @@ package helper
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
@@ -43,8 +44,10 @@ const (
 // It also implements the Stack.
 type ConsensusHandler struct {
 	peer.MessageHandler
-	consenterChan chan *util.Message
-	coordinator   peer.MessageHandlerCoordinator
+	consenterChans     map[string]chan *util.Message // queue of the consensus messages of each chain
+	consenterChansLock sync.Mutex
+	consensusQueueSize int
+	coordinator        peer.MessageHandlerCoordinator
 }
 
 // NewConsensusHandler constructs a new MessageHandler for the plugin.
@@ -60,6 +63,7 @@ func NewConsensusHandler(coord peer.MessageHandlerCoordinator,
 
 	handler := &ConsensusHandler{
 		MessageHandler: peerHandler,
+		consenterChans: make(map[string]chan *util.Message),
 		coordinator:    coord,
 	}
 
@@ -70,20 +74,51 @@ func NewConsensusHandler(coord peer.MessageHandlerCoordinator,
 		consensusQueueSize = DefaultConsensusQueueSize
 	}
 
-	pe, _ := handler.To()
-
-	handler.consenterChan = make(chan *util.Message, consensusQueueSize)
-	getEngineImpl().consensusFan.RegisterChannel(pe.ID, handler.consenterChan)
+	handler.consensusQueueSize = consensusQueueSize
+	handler.consenterChan("")
 
 	return handler, nil
 }
 
+// consenterChan returns the queue of the consensus messages of the chain with
+// the given ID, registered with the engine of the chain on the first message
+// of the chain. Returns nil if consensus does not run on the chain.
+func (handler *ConsensusHandler) consenterChan(chainID string) chan *util.Message {
+	handler.consenterChansLock.Lock()
+	defer handler.consenterChansLock.Unlock()
+	if consenterChan, ok := handler.consenterChans[chainID]; ok {
+		return consenterChan
+	}
+	engine := getEngineImpl(chainID)
+	if engine == nil {
+		return nil
+	}
+
+	pe, _ := handler.To()
+
+	consenterChan := make(chan *util.Message, handler.consensusQueueSize)
+	engine.consensusFan.RegisterChannel(pe.ID, consenterChan)
+	handler.consenterChans[chainID] = consenterChan
+	return consenterChan
+}
+
 // HandleMessage handles the incoming Fabric messages for the Peer
 func (handler *ConsensusHandler) HandleMessage(msg *pb.Message) error {
 	if msg.Type == pb.Message_CONSENSUS {
 		senderPE, _ := handler.To()
+		if !peer.IsChainValidator(msg.ChainID, senderPE.ID) {
+			err := fmt.Errorf("Sender %v is not a validator of chain [%s], rejecting message", senderPE.ID, msg.ChainID)
+			logger.Error("Failed to queue consensus message because: %v", err)
+			return err
+		}
+		consenterChan := handler.consenterChan(msg.ChainID)
+		if consenterChan == nil {
+			err := fmt.Errorf("No consensus on chain [%s], rejecting message from %v", msg.ChainID, senderPE.ID)
+			logger.Error("Failed to queue consensus message because: %v", err)
+			return err
+		}
 		select {
-		case handler.consenterChan <- &util.Message{
+		case consenterChan <- &util.Message{
 			Msg:    msg,
 			Sender: senderPE.ID,
 		}:
@@ -18,6 +18,9 @@ package helper
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/spf13/viper"
@@ -40,37 +43,99 @@ type Helper struct {
 	secOn        bool
 	valid        bool // Whether we believe the state is up to date
 	secHelper    crypto.Peer
+	tcertLock    sync.Mutex
+	tcertClient  crypto.Client           // client whose TCerts the validator signs consensus messages with
 	curBatch     []*pb.Transaction       // TODO, remove after issue 579
 	curBatchErrs []*pb.TransactionResult // TODO, remove after issue 579
+	chainID      string                  // chain the helper executes on, empty for the default chain
 	persist.Helper
 
 	sts *statetransfer.StateTransferState
 }
 
-// NewHelper constructs the consensus helper object
-func NewHelper(mhc peer.MessageHandlerCoordinator) *Helper {
+// NewHelper constructs the consensus helper object of the chain with the
+// given ID, the empty ID being the default chain
+func NewHelper(mhc peer.MessageHandlerCoordinator, chainID string) *Helper {
 	h := &Helper{
 		coordinator: mhc,
 		secOn:       viper.GetBool("security.enabled"),
 		secHelper:   mhc.GetSecHelper(),
 		valid:       true, // Assume our state is consistent until we are told otherwise, TODO: revisit
+		chainID:     chainID,
+		Helper:      persist.NewHelper(chainID),
+	}
+	// state transfer syncs the ledger of the chain from its other validators
+	if chainID == "" {
+		h.sts = statetransfer.NewStateTransferState(mhc)
+	} else {
+		h.sts = statetransfer.NewStateTransferState(&chainStack{coordinator: mhc, chainID: chainID})
 	}
-	h.sts = statetransfer.NewStateTransferState(mhc)
 	h.sts.RegisterListener(h)
 	return h
 }
 
+// GetChainID returns the ID of the chain the helper executes on, empty for the default chain
+func (h *Helper) GetChainID() string {
+	return h.chainID
+}
+
+// GetValidatorCount returns the number of validators of the chain, 0 for
+// the default chain
+func (h *Helper) GetValidatorCount() int {
+	return len(peer.GetChainValidators(h.chainID))
+}
+
+// The consenter of a chain other than the default chain knows the
+// validators of the chain as vp0 to vp<N-1>, numbered in the order of
+// peer.chainValidators.<chainID>, so that the plugins numbering their
+// replicas by peer ID run on any subset of the validating peers. The helper
+// translates these replica handles from and to peer handles.
+
+// replicaHandle returns the handle the consenter knows a validator by
+func (h *Helper) replicaHandle(handle *pb.PeerID) *pb.PeerID {
+	if h.chainID == "" || handle == nil {
+		return handle
+	}
+	for i, id := range peer.GetChainValidators(h.chainID) {
+		if id == handle.Name {
+			return &pb.PeerID{Name: "vp" + strconv.Itoa(i)}
+		}
+	}
+	return handle
+}
+
+// peerHandle returns the handle of the validator the consenter knows by the
+// given replica handle
+func (h *Helper) peerHandle(handle *pb.PeerID) (*pb.PeerID, error) {
+	if h.chainID == "" {
+		return handle, nil
+	}
+	ids := peer.GetChainValidators(h.chainID)
+	if strings.HasPrefix(handle.Name, "vp") {
+		if i, err := strconv.Atoi(handle.Name[2:]); err == nil && i >= 0 && i < len(ids) {
+			return &pb.PeerID{Name: ids[i]}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not a replica of chain %s", handle.Name, h.chainID)
+}
+
+func (h *Helper) getLedger() (*ledger.Ledger, error) {
+	return ledger.GetChainLedger(h.chainID)
+}
+
 func (h *Helper) setConsenter(c consensus.Consenter) {
 	h.consenter = c
 }
 
-// GetNetworkInfo returns the PeerEndpoints of the current validator and the entire validating network
+// GetNetworkInfo returns the PeerEndpoints of the current validator and the
+// entire validating network of the chain, the validators of the chain listed
+// in peer.chainValidators, with their replica handles
 func (h *Helper) GetNetworkInfo() (self *pb.PeerEndpoint, network []*pb.PeerEndpoint, err error) {
 	ep, err := h.coordinator.GetPeerEndpoint()
 	if err != nil {
 		return self, network, fmt.Errorf("Couldn't retrieve own endpoint: %v", err)
 	}
-	self = ep
+	self = h.replicaEndpoint(ep)
 
 	peersMsg, err := h.coordinator.GetPeers()
 	if err != nil {
@@ -78,8 +143,8 @@ func (h *Helper) GetNetworkInfo() (self *pb.PeerEndpoint, network []*pb.PeerEndp
 	}
 	peers := peersMsg.GetPeers()
 	for _, endpoint := range peers {
-		if endpoint.Type == pb.PeerEndpoint_VALIDATOR {
-			network = append(network, endpoint)
+		if endpoint.Type == pb.PeerEndpoint_VALIDATOR && peer.IsChainValidator(h.chainID, endpoint.ID) {
+			network = append(network, h.replicaEndpoint(endpoint))
 		}
 	}
 	network = append(network, self)
@@ -87,6 +152,16 @@ func (h *Helper) GetNetworkInfo() (self *pb.PeerEndpoint, network []*pb.PeerEndp
 	return
 }
 
+// replicaEndpoint returns a copy of the endpoint with the replica handle of the validator
+func (h *Helper) replicaEndpoint(endpoint *pb.PeerEndpoint) *pb.PeerEndpoint {
+	if h.chainID == "" {
+		return endpoint
+	}
+	replica := *endpoint
+	replica.ID = h.replicaHandle(endpoint.ID)
+	return &replica
+}
+
 // GetNetworkHandles returns the PeerIDs of the current validator and the entire validating network
 func (h *Helper) GetNetworkHandles() (self *pb.PeerID, network []*pb.PeerID, err error) {
 	selfEP, networkEP, err := h.GetNetworkInfo()
@@ -104,18 +179,47 @@ func (h *Helper) GetNetworkHandles() (self *pb.PeerID, network []*pb.PeerID, err
 	return
 }
 
-// Broadcast sends a message to all validating peers
+// Broadcast sends a message to all validating peers, only to the validators
+// of the chain for a chain other than the default chain
 func (h *Helper) Broadcast(msg *pb.Message, peerType pb.PeerEndpoint_Type) error {
-	errors := h.coordinator.Broadcast(msg, peerType)
-	if len(errors) > 0 {
+	msg.ChainID = h.chainID
+	if h.chainID == "" {
+		errors := h.coordinator.Broadcast(msg, peerType)
+		if len(errors) > 0 {
+			return fmt.Errorf("Couldn't broadcast successfully")
+		}
+		return nil
+	}
+
+	self, network, err := h.GetNetworkHandles()
+	if err != nil {
+		return err
+	}
+	failed := false
+	for _, receiverHandle := range network {
+		if *receiverHandle == *self {
+			continue
+		}
+		if err := h.Unicast(msg, receiverHandle); err != nil {
+			logger.Error("Couldn't send message to validator %s of chain %s: %v", receiverHandle.Name, h.chainID, err)
+			failed = true
+		}
+	}
+	if failed {
 		return fmt.Errorf("Couldn't broadcast successfully")
 	}
 	return nil
 }
 
-// Unicast sends a message to a specified receiver
+// Unicast sends a message to a specified receiver, which must be a validator
+// of the chain
 func (h *Helper) Unicast(msg *pb.Message, receiverHandle *pb.PeerID) error {
-	return h.coordinator.Unicast(msg, receiverHandle)
+	receiver, err := h.peerHandle(receiverHandle)
+	if err != nil {
+		return err
+	}
+	msg.ChainID = h.chainID
+	return h.coordinator.Unicast(msg, receiver)
 }
 
 // Sign a message with this validator's signing key
@@ -154,10 +258,44 @@ func (h *Helper) Verify(replicaID *pb.PeerID, signature []byte, message []byte)
 	return fmt.Errorf("Could not verify message from %s (unknown peer)", replicaID.Name)
 }
 
+// GetNextTCert draws the next TCert of the client configured under
+// peer.validator.consensus.tcerts.enrollid, which must have been registered
+// on this validator
+func (h *Helper) GetNextTCert() (consensus.SigningCert, error) {
+	if !h.secOn {
+		return nil, fmt.Errorf("Security is disabled, there are no TCerts to sign with")
+	}
+
+	h.tcertLock.Lock()
+	defer h.tcertLock.Unlock()
+	if h.tcertClient == nil {
+		name := viper.GetString("peer.validator.consensus.tcerts.enrollid")
+		if name == "" {
+			return nil, fmt.Errorf("No client configured to draw TCerts from")
+		}
+		client, err := crypto.InitClient(name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Could not initialize TCert client %s: %s", name, err)
+		}
+		h.tcertClient = client
+	}
+	return h.tcertClient.GetTCertificateHandlerNext()
+}
+
+// VerifyWithTCert checks that a signature is valid under a TCert issued by
+// the TCA
+func (h *Helper) VerifyWithTCert(cert []byte, signature, message []byte) error {
+	if !h.secOn {
+		logger.Debug("Security is disabled")
+		return nil
+	}
+	return h.secHelper.VerifyWithTCert(cert, signature, message)
+}
+
 // BeginTxBatch gets invoked when the next round
 // of transaction-batch execution begins
 func (h *Helper) BeginTxBatch(id interface{}) error {
-	ledger, err := ledger.GetLedger()
+	ledger, err := h.getLedger()
 	if err != nil {
 		return fmt.Errorf("Failed to get the ledger: %v", err)
 	}
@@ -179,7 +317,7 @@ func (h *Helper) ExecTxs(id interface{}, txs []*pb.Transaction) ([]byte, error)
 	// cxt := context.WithValue(context.Background(), "security", h.coordinator.GetSecHelper())
 	// TODO return directly once underlying implementation no longer returns []error
 
-	res, txerrs, err := chaincode.ExecuteTransactions(context.Background(), chaincode.DefaultChain, txs)
+	res, txerrs, err := chaincode.ExecuteTransactions(context.Background(), chaincode.GetChainName(h.chainID), txs)
 	h.curBatch = append(h.curBatch, txs...) // TODO, remove after issue 579
 
 	//copy errs to results
@@ -205,7 +343,7 @@ func (h *Helper) ExecTxs(id interface{}, txs []*pb.Transaction) ([]byte, error)
 // during execution of this transaction-batch) have been committed to
 // permanent storage.
 func (h *Helper) CommitTxBatch(id interface{}, metadata []byte) (*pb.Block, error) {
-	ledger, err := ledger.GetLedger()
+	ledger, err := h.getLedger()
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get the ledger: %v", err)
 	}
@@ -229,7 +367,7 @@ func (h *Helper) CommitTxBatch(id interface{}, metadata []byte) (*pb.Block, erro
 // RollbackTxBatch discards all the state changes that may have taken
 // place during the execution of current transaction-batch
 func (h *Helper) RollbackTxBatch(id interface{}) error {
-	ledger, err := ledger.GetLedger()
+	ledger, err := h.getLedger()
 	if err != nil {
 		return fmt.Errorf("Failed to get the ledger: %v", err)
 	}
@@ -246,7 +384,7 @@ func (h *Helper) RollbackTxBatch(id interface{}) error {
 // blockchain if CommitTxBatch were invoked.  The blockinfo will
 // change if additional ExecTXs calls are invoked.
 func (h *Helper) PreviewCommitTxBatch(id interface{}, metadata []byte) ([]byte, error) {
-	ledger, err := ledger.GetLedger()
+	ledger, err := h.getLedger()
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get the ledger: %v", err)
 	}
@@ -261,7 +399,7 @@ func (h *Helper) PreviewCommitTxBatch(id interface{}, metadata []byte) ([]byte,
 
 // GetBlock returns a block from the chain
 func (h *Helper) GetBlock(blockNumber uint64) (block *pb.Block, err error) {
-	ledger, err := ledger.GetLedger()
+	ledger, err := h.getLedger()
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get the ledger :%v", err)
 	}
@@ -270,7 +408,7 @@ func (h *Helper) GetBlock(blockNumber uint64) (block *pb.Block, err error) {
 
 // GetCurrentStateHash returns the current/temporary state hash
 func (h *Helper) GetCurrentStateHash() (stateHash []byte, err error) {
-	ledger, err := ledger.GetLedger()
+	ledger, err := h.getLedger()
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get the ledger :%v", err)
 	}
@@ -279,24 +417,32 @@ func (h *Helper) GetCurrentStateHash() (stateHash []byte, err error) {
 
 // GetBlockchainSize returns the current size of the blockchain
 func (h *Helper) GetBlockchainSize() uint64 {
-	return h.coordinator.GetBlockchainSize()
+	if h.chainID == "" {
+		return h.coordinator.GetBlockchainSize()
+	}
+	ledger, _ := h.getLedger()
+	return ledger.GetBlockchainSize()
 }
 
 // GetBlockchainInfoBlob marshals a ledger's BlockchainInfo into a protobuf
 func (h *Helper) GetBlockchainInfoBlob() []byte {
-	ledger, _ := ledger.GetLedger()
+	ledger, _ := h.getLedger()
 	info, _ := ledger.GetBlockchainInfo()
 	rawInfo, _ := proto.Marshal(info)
 	return rawInfo
 }
 
-// GetBlockHeadMetadata returns metadata from block at the head of the blockchain
+// GetBlockHeadMetadata returns metadata from block at the head of the blockchain.
+// The metadata of the genesis block is the genesis config, not consensus metadata.
 func (h *Helper) GetBlockHeadMetadata() ([]byte, error) {
-	ledger, err := ledger.GetLedger()
+	ledger, err := h.getLedger()
 	if err != nil {
 		return nil, err
 	}
 	head := ledger.GetBlockchainSize()
+	if head == 1 {
+		return nil, nil
+	}
 	block, err := ledger.GetBlockByNumber(head - 1)
 	if err != nil {
 		return nil, err
@@ -311,7 +457,16 @@ func (h *Helper) SkipTo(tag uint64, id []byte, peers []*pb.PeerID) {
 	}
 	info := &pb.BlockchainInfo{}
 	proto.Unmarshal(id, info)
-	h.sts.AddTarget(info.Height-1, info.CurrentBlockHash, peers, tag)
+	var handles []*pb.PeerID
+	for _, replica := range peers {
+		handle, err := h.peerHandle(replica)
+		if err != nil {
+			logger.Warning("Not syncing from %s: %v", replica.Name, err)
+			continue
+		}
+		handles = append(handles, handle)
+	}
+	h.sts.AddTarget(info.Height-1, info.CurrentBlockHash, handles, tag)
 }
 
 // InvalidateState is invoked to tell us that consensus realizes the ledger is out of sync
@@ -0,0 +1,63 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/peer"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestReplicaHandles(t *testing.T) {
+	defer func() {
+		viper.Set("peer.chains", []string{})
+		peer.CacheConfiguration()
+	}()
+	viper.Set("peer.validator.enabled", false)
+	viper.Set("peer.chains", []string{"chain1"})
+	viper.Set("peer.chainValidators.chain1", []string{"vp2", "vp5", "vp7", "vp9"})
+	if err := peer.CacheConfiguration(); err != nil {
+		t.Fatalf("Error caching the configuration: %s", err)
+	}
+
+	h := &Helper{chainID: "chain1"}
+	if n := h.GetValidatorCount(); n != 4 {
+		t.Fatalf("Got %d validators of chain1, expected 4", n)
+	}
+	replica := h.replicaHandle(&pb.PeerID{Name: "vp7"})
+	if replica.Name != "vp2" {
+		t.Fatalf("Got replica %s for vp7, expected vp2", replica.Name)
+	}
+	handle, err := h.peerHandle(replica)
+	if err != nil || handle.Name != "vp7" {
+		t.Fatalf("Got peer %v for replica vp2, expected vp7: %v", handle, err)
+	}
+	for _, name := range []string{"vp4", "vp-1", "validator"} {
+		if _, err := h.peerHandle(&pb.PeerID{Name: name}); err == nil {
+			t.Fatalf("Got a peer for %s, which is not a replica of chain1", name)
+		}
+	}
+
+	// the consenter of the default chain knows the validators by peer ID
+	h = &Helper{}
+	if h.GetValidatorCount() != 0 || h.replicaHandle(&pb.PeerID{Name: "vp7"}).Name != "vp7" {
+		t.Fatal("The handles of the default chain must not be translated")
+	}
+}
@@ -21,40 +21,48 @@ import (
 )
 
 // Helper provides an abstraction to access the Persist column family
-// in the database.
-type Helper struct{}
+// in the database of a chain.
+type Helper struct {
+	chainID string
+}
+
+// NewHelper returns a Helper persisting in the database of the chain
+// with the given ID, the empty ID being the default chain
+func NewHelper(chainID string) Helper {
+	return Helper{chainID: chainID}
+}
 
 // StoreState stores a key,value pair
 func (h *Helper) StoreState(key string, value []byte) error {
-	db := db.GetDBHandle()
+	db := db.GetChainDBHandle(h.chainID)
 	return db.Put(db.PersistCF, []byte("consensus."+key), value)
 }
 
 // DelState removes a key,value pair
 func (h *Helper) DelState(key string) {
-	db := db.GetDBHandle()
+	db := db.GetChainDBHandle(h.chainID)
 	db.Delete(db.PersistCF, []byte("consensus."+key))
 }
 
 // ReadState retrieves a value to a key
 func (h *Helper) ReadState(key string) ([]byte, error) {
-	db := db.GetDBHandle()
+	db := db.GetChainDBHandle(h.chainID)
 	return db.Get(db.PersistCF, []byte("consensus."+key))
 }
 
 // ReadStateSet retrieves all key,value pairs where the key starts with prefix
 func (h *Helper) ReadStateSet(prefix string) (map[string][]byte, error) {
-	db := db.GetDBHandle()
+	db := db.GetChainDBHandle(h.chainID)
 	prefixRaw := []byte("consensus." + prefix)
 
 	ret := make(map[string][]byte)
 	it := db.GetIterator(db.PersistCF)
 	defer it.Close()
 	for it.Seek(prefixRaw); it.ValidForPrefix(prefixRaw); it.Next() {
-		key := string(it.Key().Data())
+		key := string(it.Key())
 		key = key[len("consensus."):]
 		// copy data from the slice!
-		ret[key] = append([]byte(nil), it.Value().Data()...)
+		ret[key] = append([]byte(nil), it.Value()...)
 	}
 	return ret, nil
 }
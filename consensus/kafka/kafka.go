@@ -0,0 +1,530 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/protobuf/proto"
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/consensus/util"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+const configPrefix = "CORE_KAFKA"
+
+var logger *logging.Logger // package-level logger
+
+var pluginInstance consensus.Consenter // singleton service
+var config *viper.Viper
+
+func init() {
+	logger = logging.MustGetLogger("consensus/kafka")
+	config = loadConfig()
+}
+
+// GetPlugin returns the handle to the Consenter singleton
+func GetPlugin(c consensus.Stack) consensus.Consenter {
+	if pluginInstance == nil {
+		pluginInstance = New(c)
+	}
+	return pluginInstance
+}
+
+// New creates a Consenter which has a Kafka cluster order the transactions.
+// Validators append batches of the transactions they receive to the
+// partition of their chain, and execute the messages of the partition in
+// order as blocks. Kafka tolerates crashed brokers, not byzantine ones.
+func New(stack consensus.Stack) consensus.Consenter {
+	handle, _, _ := stack.GetNetworkHandles()
+	chain := viper.GetString("peer.networkId")
+	if inquirer, ok := stack.(consensus.ChainInquirer); ok && inquirer.GetChainID() != "" {
+		chain += "-" + inquirer.GetChainID()
+	}
+	op := newObcKafka(handle.Name, chain, config, stack)
+	op.start()
+	return op
+}
+
+func loadConfig() (config *viper.Viper) {
+	config = viper.New()
+
+	// for environment variables
+	config.SetEnvPrefix(configPrefix)
+	config.AutomaticEnv()
+	replacer := strings.NewReplacer(".", "_")
+	config.SetEnvKeyReplacer(replacer)
+
+	config.SetConfigName("config")
+	config.AddConfigPath("./")
+	config.AddConfigPath("../consensus/kafka/")
+	config.AddConfigPath("../../consensus/kafka")
+	// Path to look for the config file in based on GOPATH
+	gopath := os.Getenv("GOPATH")
+	for _, p := range filepath.SplitList(gopath) {
+		kafkapath := filepath.Join(p, "src/github.com/hyperledger/fabric/consensus/kafka")
+		config.AddConfigPath(kafkapath)
+	}
+
+	err := config.ReadInConfig()
+	if err != nil {
+		panic(fmt.Errorf("Error reading %s plugin config: %s", configPrefix, err))
+	}
+	return
+}
+
+// =============================================================================
+// obcKafka
+// =============================================================================
+
+type obcKafka struct {
+	stack   consensus.Stack
+	brokers []string
+	topic   string
+	chain   string
+	mapped  int32 // partition the chain is mapped to, -1 to hash its name
+
+	batchSize     int
+	batchTimeouts *util.BatchTimeout // used by produceLoop only
+	reconnect     time.Duration
+
+	// newProducer and newConsumer connect to the cluster, tests replace
+	// them by the mocks of sarama
+	newProducer func() (sarama.SyncProducer, error)
+	newConsumer func() (sarama.Consumer, error)
+
+	queue  chan *pb.Transaction
+	closed chan struct{}
+	done   chan struct{}
+}
+
+func newObcKafka(clientID, chain string, config *viper.Viper, stack consensus.Stack) *obcKafka {
+	op := &obcKafka{
+		stack:   stack,
+		brokers: config.GetStringSlice("general.brokers"),
+		topic:   config.GetString("general.topic"),
+		chain:   chain,
+		mapped:  -1,
+	}
+	if len(op.brokers) == 0 || op.topic == "" {
+		panic(fmt.Errorf("The Kafka brokers and topic must be set"))
+	}
+	if key := "general.partitions." + chain; config.IsSet(key) {
+		op.mapped = int32(config.GetInt(key))
+	}
+
+	parse := func(key string) time.Duration {
+		d, err := time.ParseDuration(config.GetString(key))
+		if err != nil || d <= 0 {
+			panic(fmt.Errorf("Cannot parse %s: %s", key, config.GetString(key)))
+		}
+		return d
+	}
+	var batchTimeoutMin time.Duration
+	if config.IsSet("general.timeout.batchmin") {
+		batchTimeoutMin = parse("general.timeout.batchmin")
+	}
+	op.batchTimeouts = util.NewBatchTimeout(batchTimeoutMin, parse("general.timeout.batch"))
+	op.reconnect = parse("general.timeout.reconnect")
+
+	op.batchSize = config.GetInt("general.batchsize")
+	queueSize := config.GetInt("general.queuesize")
+	maxFetch := config.GetInt("general.maxfetch")
+	if op.batchSize <= 0 || queueSize <= 0 || maxFetch <= 0 {
+		panic(fmt.Errorf("The Kafka batch size, queue size and maxfetch must be positive"))
+	}
+
+	kafkaConfig := sarama.NewConfig()
+	kafkaConfig.ClientID = clientID
+	kafkaConfig.Net.DialTimeout = parse("general.timeout.request")
+	kafkaConfig.Net.ReadTimeout = kafkaConfig.Net.DialTimeout
+	kafkaConfig.Net.WriteTimeout = kafkaConfig.Net.DialTimeout
+	if err := setKafkaSecurity(kafkaConfig, config); err != nil {
+		panic(err)
+	}
+	if err := setKafkaVersion(kafkaConfig, config.GetString("general.version")); err != nil {
+		panic(err)
+	}
+	if err := setKafkaCompression(kafkaConfig, config.GetString("general.compression")); err != nil {
+		panic(err)
+	}
+	// a batch is appended once every in-sync replica has it
+	kafkaConfig.Producer.RequiredAcks = sarama.WaitForAll
+	kafkaConfig.Producer.MaxMessageBytes = maxFetch
+	kafkaConfig.Producer.Partitioner = func(topic string) sarama.Partitioner {
+		return chainPartitioner{op}
+	}
+	kafkaConfig.Consumer.Fetch.Default = int32(maxFetch)
+	kafkaConfig.Consumer.MaxWaitTime = parse("general.timeout.fetch")
+	kafkaConfig.Consumer.Return.Errors = true
+	if err := kafkaConfig.Validate(); err != nil {
+		panic(fmt.Errorf("Invalid Kafka configuration: %s", err))
+	}
+
+	op.newProducer = func() (sarama.SyncProducer, error) {
+		return sarama.NewSyncProducer(op.brokers, kafkaConfig)
+	}
+	op.newConsumer = func() (sarama.Consumer, error) {
+		return sarama.NewConsumer(op.brokers, kafkaConfig)
+	}
+
+	op.queue = make(chan *pb.Transaction, queueSize)
+	op.closed = make(chan struct{})
+	op.done = make(chan struct{})
+	return op
+}
+
+// start produces and consumes until the plugin is closed
+func (op *obcKafka) start() {
+	go op.produceLoop()
+	go op.consumeLoop()
+}
+
+// kafkaVersions are the versions of the Kafka protocol the brokers may speak
+var kafkaVersions = map[string]sarama.KafkaVersion{
+	"0.8.2.0":  sarama.V0_8_2_0,
+	"0.8.2.1":  sarama.V0_8_2_1,
+	"0.8.2.2":  sarama.V0_8_2_2,
+	"0.9.0.0":  sarama.V0_9_0_0,
+	"0.9.0.1":  sarama.V0_9_0_1,
+	"0.10.0.0": sarama.V0_10_0_0,
+}
+
+// setKafkaVersion sets the version of the protocol spoken to the brokers,
+// the oldest one if version is empty
+func setKafkaVersion(kafkaConfig *sarama.Config, version string) error {
+	if version == "" {
+		return nil
+	}
+	v, ok := kafkaVersions[version]
+	if !ok {
+		return fmt.Errorf("Unsupported Kafka version %s", version)
+	}
+	kafkaConfig.Version = v
+	return nil
+}
+
+// setKafkaCompression sets the codec compressing the batches appended
+func setKafkaCompression(kafkaConfig *sarama.Config, codec string) error {
+	switch codec {
+	case "", "none":
+		kafkaConfig.Producer.Compression = sarama.CompressionNone
+	case "gzip":
+		kafkaConfig.Producer.Compression = sarama.CompressionGZIP
+	case "snappy":
+		kafkaConfig.Producer.Compression = sarama.CompressionSnappy
+	default:
+		return fmt.Errorf("Unsupported Kafka compression %s", codec)
+	}
+	return nil
+}
+
+// setKafkaSecurity sets up TLS and SASL/PLAIN authentication with the
+// brokers, as configured under general.tls and general.sasl
+func setKafkaSecurity(kafkaConfig *sarama.Config, config *viper.Viper) error {
+	if config.GetBool("general.tls.enabled") {
+		tlsConfig := &tls.Config{}
+		if certFile := config.GetString("general.tls.cert.file"); certFile != "" {
+			cert, err := tls.LoadX509KeyPair(certFile, config.GetString("general.tls.key.file"))
+			if err != nil {
+				return fmt.Errorf("Could not load the TLS key pair of the Kafka client: %s", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		if rootFile := config.GetString("general.tls.rootcert.file"); rootFile != "" {
+			pem, err := ioutil.ReadFile(rootFile)
+			if err != nil {
+				return fmt.Errorf("Could not read the root certificates of the brokers: %s", err)
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("No certificate found in %s", rootFile)
+			}
+		}
+		tlsConfig.ServerName = config.GetString("general.tls.serverhostoverride")
+		kafkaConfig.Net.TLS.Enable = true
+		kafkaConfig.Net.TLS.Config = tlsConfig
+	}
+	if config.GetBool("general.sasl.enabled") {
+		kafkaConfig.Net.SASL.Enable = true
+		kafkaConfig.Net.SASL.User = config.GetString("general.sasl.user")
+		kafkaConfig.Net.SASL.Password = config.GetString("general.sasl.password")
+	}
+	return nil
+}
+
+// RecvMsg is called by the stack when a new message is received
+func (op *obcKafka) RecvMsg(ocMsg *pb.Message, senderHandle *pb.PeerID) error {
+	if ocMsg.Type != pb.Message_CHAIN_TRANSACTION {
+		return fmt.Errorf("Unexpected message type: %s", ocMsg.Type)
+	}
+	tx := &pb.Transaction{}
+	if err := proto.Unmarshal(ocMsg.Payload, tx); err != nil {
+		return fmt.Errorf("Error unpacking payload from message: %s", err)
+	}
+	select {
+	case op.queue <- tx:
+		return nil
+	default:
+		return fmt.Errorf("Too many transactions waiting to be appended to Kafka, turning down %s", tx.Uuid)
+	}
+}
+
+// StateUpdated is a signal from the stack that it has fast-forwarded its
+// state, which never happens as blocks are only executed from Kafka
+func (op *obcKafka) StateUpdated(tag uint64, id []byte) {
+	logger.Warning("Unexpected state transfer to %d", tag)
+}
+
+// StateUpdating is a signal from the stack that state transfer has started
+func (op *obcKafka) StateUpdating(tag uint64, id []byte) {
+	logger.Warning("Unexpected state transfer to %d", tag)
+}
+
+// Close stops producing and consuming
+func (op *obcKafka) Close() {
+	close(op.closed)
+	<-op.done
+	<-op.done
+}
+
+// partitionFor returns the partition ordering the chain, of the count the
+// topic has
+func (op *obcKafka) partitionFor(count int32) (int32, error) {
+	if op.mapped >= 0 {
+		if op.mapped >= count {
+			return 0, fmt.Errorf("Chain %s is mapped to partition %d, topic %s has %d", op.chain, op.mapped, op.topic, count)
+		}
+		return op.mapped, nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(op.chain))
+	return int32(h.Sum32() % uint32(count)), nil
+}
+
+// chainPartitioner appends every batch to the partition of the chain,
+// whether its leader is available or not
+type chainPartitioner struct {
+	op *obcKafka
+}
+
+func (p chainPartitioner) Partition(msg *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	return p.op.partitionFor(numPartitions)
+}
+
+func (p chainPartitioner) RequiresConsistency() bool {
+	return true
+}
+
+// wait returns false if the plugin is closed before the reconnect timeout
+func (op *obcKafka) wait() bool {
+	select {
+	case <-op.closed:
+		return false
+	case <-time.After(op.reconnect):
+		return true
+	}
+}
+
+// produceLoop batches the transactions received, and appends the batches
+// to the partition
+func (op *obcKafka) produceLoop() {
+	defer func() { op.done <- struct{}{} }()
+
+	var producer sarama.SyncProducer
+	defer func() {
+		if producer != nil {
+			producer.Close()
+		}
+	}()
+
+	var pending []*pb.Transaction
+	var timer <-chan time.Time
+	for {
+		select {
+		case tx := <-op.queue:
+			pending = append(pending, tx)
+			if len(pending) < op.batchSize {
+				if timer == nil {
+					timer = time.After(op.batchTimeouts.Timeout())
+				}
+				continue
+			}
+		case <-timer:
+		case <-op.closed:
+			return
+		}
+
+		timer = nil
+		op.batchTimeouts.Sent(len(pending), op.batchSize)
+		data, err := proto.Marshal(&pb.TransactionBlock{Transactions: pending})
+		if err != nil {
+			logger.Error("Could not pack batch: %s", err)
+			pending = nil
+			continue
+		}
+		// retry until the batch is appended, transactions queue up meanwhile.
+		// A batch the connection was lost before acknowledging may be
+		// appended twice, every validator executes it twice alike.
+		for {
+			if producer == nil {
+				producer, err = op.newProducer()
+			}
+			if err == nil {
+				var partition int32
+				var offset int64
+				msg := &sarama.ProducerMessage{Topic: op.topic, Value: sarama.ByteEncoder(data)}
+				if partition, offset, err = producer.SendMessage(msg); err == nil {
+					logger.Debug("Appended batch of %d transactions to partition %d at offset %d", len(pending), partition, offset)
+					break
+				}
+				producer.Close()
+				producer = nil
+			}
+			logger.Warning("Could not append batch of %d transactions to Kafka: %s", len(pending), err)
+			if !op.wait() {
+				return
+			}
+		}
+		pending = nil
+	}
+}
+
+// consumeLoop executes the messages of the partition, consuming them
+// again from the last one executed whenever it stops
+func (op *obcKafka) consumeLoop() {
+	defer func() { op.done <- struct{}{} }()
+	for {
+		if err := op.consume(); err != nil {
+			logger.Warning("Consumption from Kafka stopped: %s", err)
+		}
+		if !op.wait() {
+			return
+		}
+	}
+}
+
+func (op *obcKafka) consume() error {
+	last, err := op.getCheckpoint()
+	if err != nil {
+		return err
+	}
+	consumer, err := op.newConsumer()
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+
+	partitions, err := consumer.Partitions(op.topic)
+	if err != nil {
+		return err
+	}
+	partition, err := op.partitionFor(int32(len(partitions)))
+	if err != nil {
+		return err
+	}
+
+	offset := sarama.OffsetOldest
+	if last != nil {
+		if last.Partition != partition {
+			return fmt.Errorf("Chain %s was ordered by partition %d, not %d", op.chain, last.Partition, partition)
+		}
+		offset = last.NextOffset
+	}
+	logger.Info("Consuming partition %d of %s from offset %d", partition, op.topic, offset)
+
+	pc, err := consumer.ConsumePartition(op.topic, partition, offset)
+	if err != nil {
+		return err
+	}
+	defer pc.AsyncClose()
+
+	for {
+		select {
+		case msg, ok := <-pc.Messages():
+			if !ok {
+				return fmt.Errorf("Consumer of partition %d closed", partition)
+			}
+			if err := op.execute(partition, msg); err != nil {
+				return err
+			}
+		case err := <-pc.Errors():
+			// the consumer resumes from the checkpoint, on another broker
+			// if the leader of the partition moved
+			return err
+		case <-op.closed:
+			return nil
+		}
+	}
+}
+
+// execute commits the transactions of a message as a block of the ledger,
+// along with its offset
+func (op *obcKafka) execute(partition int32, msg *sarama.ConsumerMessage) error {
+	block := &pb.TransactionBlock{}
+	if err := proto.Unmarshal(msg.Value, block); err != nil || len(block.Transactions) == 0 {
+		// every validator skips the message alike
+		logger.Warning("Skipping message at offset %d, not a batch of transactions", msg.Offset)
+		return nil
+	}
+	meta, _ := proto.Marshal(&Metadata{Partition: partition, NextOffset: msg.Offset + 1})
+
+	id := msg.Offset
+	if err := op.stack.BeginTxBatch(id); err != nil {
+		return fmt.Errorf("Could not begin batch at offset %d: %s", id, err)
+	}
+	// errors of ExecTxs are ledger errors, transaction errors are recorded in the block
+	if _, err := op.stack.ExecTxs(id, block.Transactions); err != nil {
+		op.stack.RollbackTxBatch(id)
+		return fmt.Errorf("Could not execute batch at offset %d: %s", id, err)
+	}
+	if _, err := op.stack.CommitTxBatch(id, meta); err != nil {
+		op.stack.RollbackTxBatch(id)
+		return fmt.Errorf("Could not commit batch at offset %d: %s", id, err)
+	}
+	logger.Debug("Committed batch of %d transactions at offset %d", len(block.Transactions), id)
+	return nil
+}
+
+// getCheckpoint returns the metadata of the message executed last, nil if
+// none was
+func (op *obcKafka) getCheckpoint() (*Metadata, error) {
+	raw, err := op.stack.GetBlockHeadMetadata()
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	meta := &Metadata{}
+	if err = proto.Unmarshal(raw, meta); err != nil {
+		return nil, fmt.Errorf("Could not unmarshal the metadata of the last block: %s", err)
+	}
+	return meta, nil
+}
@@ -0,0 +1,289 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/golang/protobuf/proto"
+
+	"github.com/hyperledger/fabric/consensus"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// mockStack keeps the transactions and metadata of the blocks committed
+type mockStack struct {
+	consensus.Stack
+	lock     sync.Mutex
+	pending  []*pb.Transaction
+	blocks   [][]*pb.Transaction
+	metadata [][]byte
+}
+
+func (s *mockStack) BeginTxBatch(id interface{}) error {
+	return nil
+}
+
+func (s *mockStack) ExecTxs(id interface{}, txs []*pb.Transaction) ([]byte, error) {
+	s.pending = txs
+	return nil, nil
+}
+
+func (s *mockStack) CommitTxBatch(id interface{}, metadata []byte) (*pb.Block, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.blocks = append(s.blocks, s.pending)
+	s.metadata = append(s.metadata, metadata)
+	return nil, nil
+}
+
+func (s *mockStack) RollbackTxBatch(id interface{}) error {
+	s.pending = nil
+	return nil
+}
+
+func (s *mockStack) GetBlockHeadMetadata() ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.metadata) == 0 {
+		return nil, nil
+	}
+	return s.metadata[len(s.metadata)-1], nil
+}
+
+func (s *mockStack) height() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.blocks)
+}
+
+// newTestKafka returns a plugin connecting with the producers and the
+// consumers passed, in turn, and not started
+func newTestKafka(t *testing.T, stack *mockStack, producers []*mocks.SyncProducer, consumers []*mocks.Consumer) *obcKafka {
+	config := loadConfig()
+	config.Set("general.batchsize", 1)
+	config.Set("general.timeout.reconnect", "10ms")
+	op := newObcKafka("vp0", "dev", config, stack)
+
+	var lock sync.Mutex
+	op.newProducer = func() (sarama.SyncProducer, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if len(producers) == 0 {
+			return nil, errors.New("no producer left")
+		}
+		producer := producers[0]
+		producers = producers[1:]
+		return producer, nil
+	}
+	op.newConsumer = func() (sarama.Consumer, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if len(consumers) == 0 {
+			return nil, errors.New("no consumer left")
+		}
+		consumer := consumers[0]
+		consumers = consumers[1:]
+		return consumer, nil
+	}
+	return op
+}
+
+// newTestConsumer returns a consumer of a topic of two partitions, expecting
+// the partition of the chain to be consumed from offset
+func newTestConsumer(t *testing.T, offset int64) (*mocks.Consumer, *mocks.PartitionConsumer) {
+	consumer := mocks.NewConsumer(t, nil)
+	consumer.SetTopicMetadata(map[string][]int32{"fabric": {0, 1}})
+	return consumer, consumer.ExpectConsumePartition("fabric", 0, offset)
+}
+
+func batch(i int) []byte {
+	raw, _ := proto.Marshal(&pb.TransactionBlock{Transactions: []*pb.Transaction{{Uuid: fmt.Sprintf("tx%d", i)}}})
+	return raw
+}
+
+func broadcast(t *testing.T, op *obcKafka, i int) {
+	raw, _ := proto.Marshal(&pb.Transaction{Uuid: fmt.Sprintf("tx%d", i)})
+	if err := op.RecvMsg(&pb.Message{Type: pb.Message_CHAIN_TRANSACTION, Payload: raw}, nil); err != nil {
+		t.Fatalf("Could not broadcast transaction %d: %s", i, err)
+	}
+}
+
+func waitHeight(t *testing.T, stack *mockStack, height int) {
+	for i := 0; stack.height() < height; i++ {
+		if i == 200 {
+			t.Fatalf("Expected %d blocks, got %d", height, stack.height())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPartitionForChain(t *testing.T) {
+	op := &obcKafka{chain: "dev", mapped: 2}
+	if p, err := op.partitionFor(4); err != nil || p != 2 {
+		t.Fatalf("Expected the mapped partition 2, got %d, %v", p, err)
+	}
+	if _, err := op.partitionFor(2); err == nil {
+		t.Fatal("Expected a partition beyond the topic to be rejected")
+	}
+
+	op.mapped = -1
+	p, _ := op.partitionFor(16)
+	for i := 0; i < 3; i++ {
+		if q, _ := (chainPartitioner{op}).Partition(&sarama.ProducerMessage{}, 16); q != p {
+			t.Fatalf("Expected the chain to hash to partition %d, got %d", p, q)
+		}
+	}
+	if !(chainPartitioner{op}).RequiresConsistency() {
+		t.Fatal("Expected the partition of the chain to be used even if unavailable")
+	}
+}
+
+func TestProduce(t *testing.T) {
+	var lock sync.Mutex
+	var appended []string
+	check := func(val []byte) error {
+		block := &pb.TransactionBlock{}
+		if err := proto.Unmarshal(val, block); err != nil {
+			return err
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		for _, tx := range block.Transactions {
+			appended = append(appended, tx.Uuid)
+		}
+		return nil
+	}
+
+	// the batch the first producer fails to append is appended by the next
+	failing := mocks.NewSyncProducer(t, nil)
+	failing.ExpectSendMessageWithCheckerFunctionAndSucceed(check)
+	failing.ExpectSendMessageAndFail(sarama.ErrNotLeaderForPartition)
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageWithCheckerFunctionAndSucceed(check)
+	producer.ExpectSendMessageWithCheckerFunctionAndSucceed(check)
+
+	consumer, _ := newTestConsumer(t, sarama.OffsetOldest)
+	op := newTestKafka(t, &mockStack{}, []*mocks.SyncProducer{failing, producer}, []*mocks.Consumer{consumer})
+	op.start()
+	broadcast(t, op, 0)
+	time.Sleep(50 * time.Millisecond)
+	broadcast(t, op, 1)
+	time.Sleep(50 * time.Millisecond)
+	broadcast(t, op, 2)
+	for i := 0; ; i++ {
+		lock.Lock()
+		n := len(appended)
+		lock.Unlock()
+		if n == 3 {
+			break
+		}
+		if i == 200 {
+			t.Fatalf("Expected 3 transactions appended, got %v", appended)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	op.Close()
+
+	for i, uuid := range appended {
+		if uuid != fmt.Sprintf("tx%d", i) {
+			t.Fatalf("Expected tx%d appended, got %v", i, appended)
+		}
+	}
+}
+
+func TestConsume(t *testing.T) {
+	stack := &mockStack{}
+	first, pc := newTestConsumer(t, sarama.OffsetOldest)
+	pc.YieldMessage(&sarama.ConsumerMessage{Value: batch(0)})
+	pc.YieldMessage(&sarama.ConsumerMessage{Value: []byte("not a batch")})
+	pc.YieldMessage(&sarama.ConsumerMessage{Value: batch(1)})
+
+	// the consumption resumes from the offset after the checkpoint
+	second, resumed := newTestConsumer(t, 4)
+
+	op := newTestKafka(t, stack, nil, []*mocks.Consumer{first, second})
+	op.start()
+	defer op.Close()
+	waitHeight(t, stack, 2)
+
+	for i, offset := range []int64{2, 4} {
+		if block := stack.blocks[i]; len(block) != 1 || block[0].Uuid != fmt.Sprintf("tx%d", i) {
+			t.Fatalf("Block %d does not hold tx%d: %v", i, i, block)
+		}
+		meta := &Metadata{}
+		proto.Unmarshal(stack.metadata[i], meta)
+		if meta.Partition != 0 || meta.NextOffset != offset {
+			t.Fatalf("Expected block %d to checkpoint offset %d of partition 0, got %v", i, offset, meta)
+		}
+	}
+
+	pc.YieldError(sarama.ErrNotLeaderForPartition)
+	resumed.YieldMessage(&sarama.ConsumerMessage{Value: batch(2)})
+	waitHeight(t, stack, 3)
+}
+
+func TestConsumeOtherPartition(t *testing.T) {
+	stack := &mockStack{}
+	meta, _ := proto.Marshal(&Metadata{Partition: 1, NextOffset: 3})
+	stack.metadata = [][]byte{meta}
+
+	consumer := mocks.NewConsumer(t, nil)
+	consumer.SetTopicMetadata(map[string][]int32{"fabric": {0, 1}})
+	op := newTestKafka(t, stack, nil, []*mocks.Consumer{consumer})
+	if err := op.consume(); err == nil {
+		t.Fatal("Expected the chain ordered by another partition to be refused")
+	}
+}
+
+func TestKafkaConfig(t *testing.T) {
+	config := loadConfig()
+	kafkaConfig := sarama.NewConfig()
+	if err := setKafkaVersion(kafkaConfig, "0.9.0.1"); err != nil || kafkaConfig.Version != sarama.V0_9_0_1 {
+		t.Fatalf("Expected version 0.9.0.1, got %v, %v", kafkaConfig.Version, err)
+	}
+	if err := setKafkaVersion(kafkaConfig, "0.7"); err == nil {
+		t.Fatal("Expected an unknown version to be refused")
+	}
+	if err := setKafkaCompression(kafkaConfig, "snappy"); err != nil || kafkaConfig.Producer.Compression != sarama.CompressionSnappy {
+		t.Fatalf("Expected snappy compression, got %v, %v", kafkaConfig.Producer.Compression, err)
+	}
+	if err := setKafkaCompression(kafkaConfig, "lzma"); err == nil {
+		t.Fatal("Expected an unknown codec to be refused")
+	}
+
+	config.Set("general.tls.enabled", true)
+	config.Set("general.sasl.enabled", true)
+	config.Set("general.sasl.user", "fabric")
+	config.Set("general.sasl.password", "secret")
+	if err := setKafkaSecurity(kafkaConfig, config); err != nil {
+		t.Fatal(err)
+	}
+	if !kafkaConfig.Net.TLS.Enable || !kafkaConfig.Net.SASL.Enable || kafkaConfig.Net.SASL.User != "fabric" || kafkaConfig.Net.SASL.Password != "secret" {
+		t.Fatalf("Expected TLS and SASL to be set up, got %+v", kafkaConfig.Net)
+	}
+	config.Set("general.tls.rootcert.file", "missing.pem")
+	if err := setKafkaSecurity(kafkaConfig, config); err == nil {
+		t.Fatal("Expected missing root certificates to be reported")
+	}
+}
@@ -0,0 +1,34 @@
+// Code generated by protoc-gen-go.
+// source: kafka/messages.proto
+// DO NOT EDIT!
+
+/*
+Package kafka is a generated protocol buffer package.
+
+It is generated from these files:
+	kafka/messages.proto
+
+It has these top-level messages:
+	Metadata
+*/
+package kafka
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// Consensus metadata of the blocks, the Kafka message each block executes.
+// The last block checkpoints the offset consumption resumes from.
+type Metadata struct {
+	Partition  int32 `protobuf:"varint,1,opt,name=partition" json:"partition,omitempty"`
+	NextOffset int64 `protobuf:"varint,2,opt,name=nextOffset" json:"nextOffset,omitempty"`
+}
+
+func (m *Metadata) Reset()         { *m = Metadata{} }
+func (m *Metadata) String() string { return proto.CompactTextString(m) }
+func (*Metadata) ProtoMessage()    {}
@@ -0,0 +1,158 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consensus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// LeaderPolicy chooses which replica leads a round of a consensus plugin.
+// The choice must be deterministic, so that the replicas agree on the
+// leader from the same round, failures and replicas.
+type LeaderPolicy interface {
+	// Name identifies the policy, as recorded in the block metadata
+	Name() string
+
+	// Leader returns the replica leading round, once failures leaders were
+	// found faulty, among replicas, which must not be empty
+	Leader(round, failures uint64, replicas []uint64) uint64
+}
+
+// Names of the leader policies
+const (
+	RoundRobinPolicy    = "roundrobin"
+	StakeWeightedPolicy = "stake"
+	StickyPolicy        = "sticky"
+)
+
+// NewLeaderPolicy returns the leader policy called name. The stakes of the
+// replicas by ID only matter to the stake weighted policy, replicas without
+// a stake count as a stake of 1.
+func NewLeaderPolicy(name string, stakes map[uint64]uint64) (LeaderPolicy, error) {
+	switch name {
+	case "", RoundRobinPolicy:
+		return roundRobin{}, nil
+	case StakeWeightedPolicy:
+		return stakeWeighted{stakes}, nil
+	case StickyPolicy:
+		return sticky{}, nil
+	}
+	return nil, fmt.Errorf("Unknown leader policy %q", name)
+}
+
+// ParseStakes reads the stakes of the validators from their handle names,
+// vpX, to their ID X
+func ParseStakes(config map[string]string) (map[uint64]uint64, error) {
+	stakes := make(map[uint64]uint64)
+	for name, value := range config {
+		if !strings.HasPrefix(name, "vp") {
+			return nil, fmt.Errorf("Stake given to %s, expected a vpX handle", name)
+		}
+		id, err := strconv.ParseUint(name[2:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Error extracting ID from \"%s\" handle: %v", name, err)
+		}
+		stake, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot parse stake of %s: %v", name, err)
+		}
+		stakes[id] = stake
+	}
+	return stakes, nil
+}
+
+// roundRobin moves the leadership to the next replica every round, and
+// every time the leader fails
+type roundRobin struct{}
+
+func (roundRobin) Name() string {
+	return RoundRobinPolicy
+}
+
+func (roundRobin) Leader(round, failures uint64, replicas []uint64) uint64 {
+	return replicas[(round+failures)%uint64(len(replicas))]
+}
+
+// sticky keeps the same leader until it fails, the next replica leads then
+type sticky struct{}
+
+func (sticky) Name() string {
+	return StickyPolicy
+}
+
+func (sticky) Leader(round, failures uint64, replicas []uint64) uint64 {
+	return replicas[failures%uint64(len(replicas))]
+}
+
+// stakeWeighted draws the leader of every round and after every failure,
+// each replica with a chance in proportion to its stake
+type stakeWeighted struct {
+	stakes map[uint64]uint64
+}
+
+func (stakeWeighted) Name() string {
+	return StakeWeightedPolicy
+}
+
+func (p stakeWeighted) Leader(round, failures uint64, replicas []uint64) uint64 {
+	i := p.draw(round, failures, replicas)
+	if failures > 0 && i == p.draw(round, failures-1, replicas) {
+		// do not draw the leader which just failed again, but the next
+		// replica with a stake
+		for j := 1; j < len(replicas); j++ {
+			if next := (i + j) % len(replicas); p.stake(replicas[next]) > 0 {
+				i = next
+				break
+			}
+		}
+	}
+	return replicas[i]
+}
+
+func (p stakeWeighted) stake(replica uint64) uint64 {
+	if stake, ok := p.stakes[replica]; ok {
+		return stake
+	}
+	return 1
+}
+
+// draw returns the index of the replica drawn for round and failures
+func (p stakeWeighted) draw(round, failures uint64, replicas []uint64) int {
+	var total uint64
+	for _, replica := range replicas {
+		total += p.stake(replica)
+	}
+	if total == 0 {
+		return int((round + failures) % uint64(len(replicas)))
+	}
+
+	h := fnv.New64a()
+	binary.Write(h, binary.BigEndian, round)
+	binary.Write(h, binary.BigEndian, failures)
+	x := h.Sum64() % total
+	for i, replica := range replicas {
+		if x < p.stake(replica) {
+			return i
+		}
+		x -= p.stake(replica)
+	}
+	return len(replicas) - 1
+}
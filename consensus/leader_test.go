@@ -0,0 +1,80 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consensus
+
+import (
+	"testing"
+)
+
+func TestRoundRobinPolicy(t *testing.T) {
+	p, _ := NewLeaderPolicy("roundrobin", nil)
+	replicas := []uint64{0, 1, 2, 3}
+	for round := uint64(0); round < 8; round++ {
+		if leader := p.Leader(round, 0, replicas); leader != round%4 {
+			t.Fatalf("Expected replica %d to lead round %d, got %d", round%4, round, leader)
+		}
+	}
+	if leader := p.Leader(2, 1, replicas); leader != 3 {
+		t.Fatalf("Expected the next replica to lead once the leader failed, got %d", leader)
+	}
+}
+
+func TestStickyPolicy(t *testing.T) {
+	p, _ := NewLeaderPolicy("sticky", nil)
+	replicas := []uint64{0, 1, 2, 3}
+	for round := uint64(0); round < 8; round++ {
+		if leader := p.Leader(round, 5, replicas); leader != 1 {
+			t.Fatalf("Expected replica 1 to keep leading round %d, got %d", round, leader)
+		}
+	}
+}
+
+func TestStakeWeightedPolicy(t *testing.T) {
+	stakes, err := ParseStakes(map[string]string{"vp0": "0", "vp1": "1", "vp2": "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := NewLeaderPolicy("stake", stakes)
+	replicas := []uint64{0, 1, 2}
+
+	led := make(map[uint64]int)
+	for round := uint64(0); round < 4000; round++ {
+		leader := p.Leader(round, 0, replicas)
+		if leader != p.Leader(round, 0, replicas) {
+			t.Fatalf("Expected the leader of round %d to be deterministic", round)
+		}
+		if again := p.Leader(round, 1, replicas); again == leader {
+			t.Fatalf("Expected another leader once replica %d failed in round %d", leader, round)
+		}
+		led[leader]++
+	}
+	if led[0] != 0 {
+		t.Fatalf("Expected replica 0 without stake never to lead, led %d rounds", led[0])
+	}
+	if led[2] < 2*led[1] || led[2] > 4*led[1] {
+		t.Fatalf("Expected replica 2 to lead about three times as many rounds as replica 1, got %d and %d", led[2], led[1])
+	}
+}
+
+func TestUnknownLeaderPolicy(t *testing.T) {
+	if _, err := NewLeaderPolicy("oldest", nil); err == nil {
+		t.Fatal("Expected an unknown policy to be rejected")
+	}
+	if _, err := ParseStakes(map[string]string{"peer0": "1"}); err == nil {
+		t.Fatal("Expected a stake for a handle other than vpX to be rejected")
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consensus
+
+import (
+	"sync"
+	"time"
+)
+
+// Metrics receives the measurements of the consensus plugins so that they
+// can be exported to a monitoring system. Every measurement is labelled
+// with the name of the plugin taking it.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	// SetView reports the view the replica is in
+	SetView(plugin string, view uint64)
+
+	// IncViewChanges counts the view changes the replica started
+	IncViewChanges(plugin string)
+
+	// ObserveViewChange reports how long the replica took to move to a
+	// new view once it started a view change
+	ObserveViewChange(plugin string, duration time.Duration)
+
+	// SetQueueDepth reports the number of requests waiting to be ordered
+	SetQueueDepth(plugin string, depth int)
+
+	// ObserveBatchFill reports how full a batch was when sent, between 0
+	// and 1, from the number of requests it carries and the batch size
+	ObserveBatchFill(plugin string, ratio float64)
+
+	// ObserveCommitLatency reports how long a request took from reception
+	// to being committed
+	ObserveCommitLatency(plugin string, latency time.Duration)
+
+	// SetDedupEntries reports the number of executed requests the replica
+	// remembers to detect duplicates
+	SetDedupEntries(plugin string, entries int)
+
+	// IncDuplicates counts the requests discarded as already executed
+	IncDuplicates(plugin string)
+}
+
+var (
+	metrics     Metrics = noopMetrics{}
+	metricsLock sync.RWMutex
+)
+
+// SetMetrics sets the receiver of the consensus plugins measurements.
+// Passing nil discards them, which is the default.
+func SetMetrics(m Metrics) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+
+	if m == nil {
+		m = noopMetrics{}
+	}
+	metrics = m
+}
+
+// GetMetrics returns the receiver the consensus plugins report their
+// measurements to
+func GetMetrics() Metrics {
+	metricsLock.RLock()
+	defer metricsLock.RUnlock()
+
+	return metrics
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) SetView(plugin string, view uint64)                        {}
+func (noopMetrics) IncViewChanges(plugin string)                              {}
+func (noopMetrics) ObserveViewChange(plugin string, duration time.Duration)   {}
+func (noopMetrics) SetQueueDepth(plugin string, depth int)                    {}
+func (noopMetrics) ObserveBatchFill(plugin string, ratio float64)             {}
+func (noopMetrics) ObserveCommitLatency(plugin string, latency time.Duration) {}
+func (noopMetrics) SetDedupEntries(plugin string, entries int)                {}
+func (noopMetrics) IncDuplicates(plugin string)                               {}
@@ -0,0 +1,33 @@
+// Code generated by protoc-gen-go.
+// source: noops/messages.proto
+// DO NOT EDIT!
+
+/*
+Package noops is a generated protocol buffer package.
+
+It is generated from these files:
+	noops/messages.proto
+
+It has these top-level messages:
+	Metadata
+*/
+package noops
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// block metadata
+type Metadata struct {
+	LeaderPolicy string `protobuf:"bytes,1,opt,name=leaderPolicy" json:"leaderPolicy,omitempty"`
+	Leader       uint64 `protobuf:"varint,2,opt,name=leader" json:"leader,omitempty"`
+}
+
+func (m *Metadata) Reset()         { *m = Metadata{} }
+func (m *Metadata) String() string { return proto.CompactTextString(m) }
+func (*Metadata) ProtoMessage()    {}
@@ -18,7 +18,10 @@ package noops
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/protobuf/proto"
@@ -33,6 +36,9 @@ import (
 
 var logger *logging.Logger // package-level logger
 
+// pluginName labels the measurements reported to the consensus metrics
+const pluginName = "noops"
+
 func init() {
 	logger = logging.MustGetLogger("consensus/noops")
 }
@@ -44,6 +50,8 @@ type Noops struct {
 	timer    *time.Timer
 	duration time.Duration
 	channel  chan *pb.Transaction
+	leaders  consensus.LeaderPolicy
+	failures uint64 // leaders which could not be reached, accessed atomically
 }
 
 // Setting up a singleton NOOPS consenter
@@ -57,6 +65,11 @@ func GetNoops(c consensus.Stack) consensus.Consenter {
 	return iNoops
 }
 
+// New creates a NOOPS consenter, unlike GetNoops a new one on every call
+func New(c consensus.Stack) consensus.Consenter {
+	return newNoops(c)
+}
+
 // newNoops is a constructor returning a consensus.Consenter object.
 func newNoops(c consensus.Stack) consensus.Consenter {
 	var err error
@@ -76,9 +89,18 @@ func newNoops(c consensus.Stack) consensus.Consenter {
 		panic(fmt.Errorf("Cannot parse block timeout: %s", err))
 	}
 
+	stakes, err := consensus.ParseStakes(config.GetStringMapString("leader.stakes"))
+	if err != nil {
+		panic(fmt.Errorf("Cannot parse stakes: %s", err))
+	}
+	if i.leaders, err = consensus.NewLeaderPolicy(config.GetString("leader.policy"), stakes); err != nil {
+		panic(err)
+	}
+
 	logger.Info("NOOPS consensus type = %T", i)
 	logger.Info("NOOPS block size = %v", blockSize)
 	logger.Info("NOOPS block timeout = %v", i.duration)
+	logger.Info("NOOPS leader policy = %v", i.leaders.Name())
 
 	i.txQ = newTXQ(blockSize)
 
@@ -95,6 +117,9 @@ func (i *Noops) RecvMsg(msg *pb.Message, senderHandle *pb.PeerID) error {
 		logger.Debug("Handling Message of type: %s ", msg.Type)
 	}
 	if msg.Type == pb.Message_CHAIN_TRANSACTION {
+		if i.forwardToLeader(msg, senderHandle) {
+			return nil
+		}
 		if err := i.broadcastConsensusMsg(msg); nil != err {
 			return err
 		}
@@ -122,6 +147,98 @@ func (i *Noops) StateUpdated(seqNo uint64, id []byte) {
 	// ignored as it is never initiated
 }
 
+// forwardToLeader sends a transaction submitted to this validator to the
+// leader of the next block, and reports whether it did. The transaction is
+// broadcast by this validator instead if it leads, if another validator
+// forwarded it, or if no leader can be reached.
+func (i *Noops) forwardToLeader(msg *pb.Message, senderHandle *pb.PeerID) bool {
+	self, replicas, handles, err := i.getValidators()
+	if err != nil {
+		if logger.IsEnabledFor(logging.DEBUG) {
+			logger.Debug("Not forwarding to the leader: %v", err)
+		}
+		return false
+	}
+	if senderHandle != nil {
+		if id, err := getValidatorID(senderHandle); err == nil && handles[id] != nil {
+			return false
+		}
+	}
+
+	round := i.stack.GetBlockchainSize()
+	for range replicas {
+		leader := i.leaders.Leader(round, atomic.LoadUint64(&i.failures), replicas)
+		if leader == self {
+			return false
+		}
+		err := i.stack.Unicast(msg, handles[leader])
+		if err == nil {
+			if logger.IsEnabledFor(logging.DEBUG) {
+				logger.Debug("Forwarded transaction to leader %s", handles[leader].Name)
+			}
+			return true
+		}
+		logger.Warning("Could not forward transaction to leader %s: %v", handles[leader].Name, err)
+		atomic.AddUint64(&i.failures, 1)
+	}
+	return false
+}
+
+// getValidators returns the ID of this validator, and the sorted IDs and
+// the handles of all validators
+func (i *Noops) getValidators() (self uint64, replicas []uint64, handles map[uint64]*pb.PeerID, err error) {
+	selfHandle, network, err := i.stack.GetNetworkHandles()
+	if err != nil {
+		return
+	}
+	if self, err = getValidatorID(selfHandle); err != nil {
+		return
+	}
+	handles = make(map[uint64]*pb.PeerID)
+	for _, handle := range network {
+		if id, err := getValidatorID(handle); err == nil {
+			handles[id] = handle
+			replicas = append(replicas, id)
+		}
+	}
+	sort.Sort(sortableUint64Slice(replicas))
+	return
+}
+
+// getMetadata returns the metadata of the block at height
+func (i *Noops) getMetadata(height uint64) []byte {
+	meta := &Metadata{LeaderPolicy: i.leaders.Name()}
+	if _, replicas, _, err := i.getValidators(); err == nil {
+		meta.Leader = i.leaders.Leader(height, atomic.LoadUint64(&i.failures), replicas)
+	}
+	raw, _ := proto.Marshal(meta)
+	return raw
+}
+
+// Returns the uint64 ID corresponding to a peer handle, vpX having ID X
+func getValidatorID(handle *pb.PeerID) (uint64, error) {
+	if !strings.HasPrefix(handle.Name, "vp") {
+		return 0, fmt.Errorf("Handle %s is not named vpX", handle.Name)
+	}
+	id, err := strconv.ParseUint(handle.Name[2:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Error extracting ID from \"%s\" handle: %v", handle.Name, err)
+	}
+	return id, nil
+}
+
+type sortableUint64Slice []uint64
+
+func (a sortableUint64Slice) Len() int {
+	return len(a)
+}
+func (a sortableUint64Slice) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+func (a sortableUint64Slice) Less(i, j int) bool {
+	return a[i] < a[j]
+}
+
 func (i *Noops) broadcastConsensusMsg(msg *pb.Message) error {
 	t := &pb.Transaction{}
 	if err := proto.Unmarshal(msg.Payload, t); err != nil {
@@ -154,6 +271,7 @@ func (i *Noops) canProcessBlock(tx *pb.Transaction) bool {
 	// TODO: Ask coordinator if we need to start sync
 
 	i.txQ.append(tx)
+	consensus.GetMetrics().SetQueueDepth(pluginName, i.txQ.size())
 
 	// start timer if we get a tx
 	if i.txQ.size() == 1 {
@@ -215,12 +333,17 @@ func (i *Noops) processTransactions() error {
 	if logger.IsEnabledFor(logging.DEBUG) {
 		logger.Debug("Starting TX batch with timestamp: %v", timestamp)
 	}
+	meta := i.getMetadata(i.stack.GetBlockchainSize())
 	if err := i.stack.BeginTxBatch(timestamp); err != nil {
 		return err
 	}
 
 	// Grab all transactions from the FIFO queue and run them in order
+	metrics := consensus.GetMetrics()
+	metrics.ObserveBatchFill(pluginName, float64(i.txQ.size())/float64(i.txQ.capacity()))
+	received := i.txQ.getReceived()
 	txarr := i.txQ.getTXs()
+	metrics.SetQueueDepth(pluginName, 0)
 	if logger.IsEnabledFor(logging.DEBUG) {
 		logger.Debug("Executing batch of %d transactions with timestamp %v", len(txarr), timestamp)
 	}
@@ -236,11 +359,14 @@ func (i *Noops) processTransactions() error {
 	if logger.IsEnabledFor(logging.DEBUG) {
 		logger.Debug("Committing TX batch with timestamp: %v", timestamp)
 	}
-	if _, err := i.stack.CommitTxBatch(timestamp, nil); err != nil {
+	if _, err := i.stack.CommitTxBatch(timestamp, meta); err != nil {
 		logger.Debug("Rolling back TX batch with timestamp: %v", timestamp)
 		i.stack.RollbackTxBatch(timestamp)
 		return err
 	}
+	for _, t := range received {
+		metrics.ObserveCommitLatency(pluginName, time.Since(t))
+	}
 	return nil
 }
 
@@ -253,7 +379,11 @@ func (i *Noops) getTxFromMsg(msg *pb.Message) (*pb.Transaction, error) {
 }
 
 func (i *Noops) getBlockData() (*pb.Block, *statemgmt.StateDelta, error) {
-	ledger, err := ledger.GetLedger()
+	var chainID string
+	if inquirer, ok := i.stack.(consensus.ChainInquirer); ok {
+		chainID = inquirer.GetChainID()
+	}
+	ledger, err := ledger.GetChainLedger(chainID)
 	if err != nil {
 		return nil, nil, fmt.Errorf("Fail to get the ledger: %v", err)
 	}
@@ -17,12 +17,15 @@ limitations under the License.
 package noops
 
 import (
+	"time"
+
 	pb "github.com/hyperledger/fabric/protos"
 )
 
 type txq struct {
 	i int
 	q []*pb.Transaction
+	t []time.Time // when each transaction of q was appended
 }
 
 func newTXQ(size int) *txq {
@@ -32,12 +35,14 @@ func newTXQ(size int) *txq {
 		size = 1
 	}
 	o.q = make([]*pb.Transaction, size)
+	o.t = make([]time.Time, size)
 	return o
 }
 
 func (o *txq) append(tx *pb.Transaction) {
 	if cap(o.q) > o.i {
 		o.q[o.i] = tx
+		o.t[o.i] = time.Now()
 		o.i++
 	}
 }
@@ -48,6 +53,11 @@ func (o *txq) getTXs() []*pb.Transaction {
 	return o.q[:length]
 }
 
+// getReceived returns when the transactions getTXs returns next were appended
+func (o *txq) getReceived() []time.Time {
+	return o.t[:o.i]
+}
+
 func (o *txq) isFull() bool {
 	if cap(o.q) == o.i {
 		return true
@@ -59,6 +69,10 @@ func (o *txq) size() int {
 	return o.i
 }
 
+func (o *txq) capacity() int {
+	return cap(o.q)
+}
+
 func (o *txq) reset() {
 	o.i = 0
 }
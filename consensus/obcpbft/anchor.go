@@ -0,0 +1,266 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// maxAnchorSize bounds the anchor read from a notary
+const maxAnchorSize = 1 << 20
+
+// notary keeps the anchors of the network out of it, a trail of the stable
+// checkpoints the replicas cannot rewrite once published
+type notary interface {
+	publish(anchor *Anchor) error
+	latest() (*Anchor, error) // Returns nil if no anchor was published yet
+}
+
+// anchorStack is implemented by the consumers of pbftCore whose ledger can
+// be checked against an anchor
+type anchorStack interface {
+	// checkAnchor returns an error if the ledger diverges from the state
+	// identified by id, the ID of an anchored checkpoint
+	checkAnchor(id []byte) error
+}
+
+// httpNotary publishes the anchors with a POST of the marshalled anchor to
+// url, and reads the latest one with a GET of url, which returns 404 if
+// there is none yet
+type httpNotary struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPNotary(url string, timeout time.Duration) *httpNotary {
+	return &httpNotary{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (n *httpNotary) publish(anchor *Anchor) error {
+	raw, err := proto.Marshal(anchor)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(n.url, "application/octet-stream", bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Notary %s refused the anchor of seqNo %d: %s", n.url, anchor.SequenceNumber, resp.Status)
+	}
+	return nil
+}
+
+func (n *httpNotary) latest() (*Anchor, error) {
+	resp, err := n.client.Get(n.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("Notary %s did not return the latest anchor: %s", n.url, resp.Status)
+	}
+	raw, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxAnchorSize))
+	if err != nil {
+		return nil, err
+	}
+	anchor := &Anchor{}
+	if err = proto.Unmarshal(raw, anchor); err != nil {
+		return nil, fmt.Errorf("Notary %s returned an anchor which could not be unmarshaled: %v", n.url, err)
+	}
+	return anchor, nil
+}
+
+// anchorer gathers the signatures of the replicas on the stable checkpoints
+// every period sequence numbers, and publishes those of a quorum to the
+// notary
+type anchorer struct {
+	period    uint64
+	notary    notary
+	signed    uint64                                 // latest sequence number signed
+	published uint64                                 // latest sequence number published
+	sigs      map[uint64]map[uint64]*AnchorSignature // signatures by sequence number and replica
+}
+
+func newAnchorer(period uint64, notary notary) *anchorer {
+	return &anchorer{
+		period: period,
+		notary: notary,
+		sigs:   make(map[uint64]map[uint64]*AnchorSignature),
+	}
+}
+
+// anchorCheckpoint signs the stable checkpoint at seqNo, if it is to be
+// anchored, and broadcasts the signature
+func (instance *pbftCore) anchorCheckpoint(seqNo uint64, id string) {
+	a := instance.anchors
+	if a == nil || seqNo%a.period != 0 || seqNo <= a.signed {
+		return
+	}
+	a.signed = seqNo
+
+	sig := &AnchorSignature{
+		SequenceNumber: seqNo,
+		Id:             id,
+		ReplicaId:      instance.id,
+	}
+	if err := instance.sign(sig); err != nil {
+		logger.Error("Replica %d could not sign the anchor of seqNo %d: %s", instance.id, seqNo, err)
+		return
+	}
+	logger.Debug("Replica %d signed the anchor of seqNo %d", instance.id, seqNo)
+	instance.innerBroadcast(&Message{&Message_AnchorSignature{sig}})
+	instance.recvAnchorSignature(sig)
+}
+
+func (instance *pbftCore) recvAnchorSignature(sig *AnchorSignature) error {
+	a := instance.anchors
+	if a == nil {
+		return nil
+	}
+	if sig.SequenceNumber%a.period != 0 || sig.SequenceNumber <= a.published || sig.SequenceNumber > instance.h+instance.L {
+		logger.Debug("Replica %d ignoring the anchor signature of replica %d for seqNo %d",
+			instance.id, sig.ReplicaId, sig.SequenceNumber)
+		return nil
+	}
+	if !instance.isMember(sig.ReplicaId) {
+		return fmt.Errorf("Replica %d received an anchor signature from replica %d, which is not a member", instance.id, sig.ReplicaId)
+	}
+	if err := instance.verify(sig); err != nil {
+		return fmt.Errorf("Replica %d received an anchor signature from replica %d which did not verify: %s", instance.id, sig.ReplicaId, err)
+	}
+
+	sigs, ok := a.sigs[sig.SequenceNumber]
+	if !ok {
+		sigs = make(map[uint64]*AnchorSignature)
+		a.sigs[sig.SequenceNumber] = sigs
+	}
+	sigs[sig.ReplicaId] = sig
+
+	anchor := &Anchor{SequenceNumber: sig.SequenceNumber, Id: sig.Id}
+	for _, s := range sigs {
+		if s.Id == sig.Id {
+			anchor.Signatures = append(anchor.Signatures, s)
+		}
+	}
+	if len(anchor.Signatures) < instance.intersectionQuorum() {
+		return nil
+	}
+	sort.Sort(sortableAnchorSignatures(anchor.Signatures))
+
+	a.published = sig.SequenceNumber
+	for n := range a.sigs {
+		if n <= a.published {
+			delete(a.sigs, n)
+		}
+	}
+	logger.Info("Replica %d publishing the anchor of seqNo %d, signed by %d replicas",
+		instance.id, anchor.SequenceNumber, len(anchor.Signatures))
+	go func() {
+		if err := a.notary.publish(anchor); err != nil {
+			logger.Warning("Replica %d could not publish the anchor of seqNo %d: %s", instance.id, anchor.SequenceNumber, err)
+		}
+	}()
+	return nil
+}
+
+// verifyAnchor checks that an anchor is signed by a quorum of the replicas
+func (instance *pbftCore) verifyAnchor(anchor *Anchor) error {
+	signers := make(map[uint64]bool)
+	for _, sig := range anchor.Signatures {
+		if sig.SequenceNumber != anchor.SequenceNumber || sig.Id != anchor.Id || !instance.isMember(sig.ReplicaId) {
+			continue
+		}
+		if err := instance.verify(sig); err != nil {
+			logger.Warning("Anchor of seqNo %d carries a signature of replica %d which did not verify: %s",
+				anchor.SequenceNumber, sig.ReplicaId, err)
+			continue
+		}
+		signers[sig.ReplicaId] = true
+	}
+	if len(signers) < instance.intersectionQuorum() {
+		return fmt.Errorf("Anchor of seqNo %d is signed by %d replicas, expected %d",
+			anchor.SequenceNumber, len(signers), instance.intersectionQuorum())
+	}
+	return nil
+}
+
+// checkAnchors verifies the latest anchor of the notary, and the ledger
+// against it. A ledger which diverges from a valid anchor was tampered with.
+func (instance *pbftCore) checkAnchors() error {
+	a := instance.anchors
+	if a == nil {
+		return nil
+	}
+	anchor, err := a.notary.latest()
+	if err != nil {
+		logger.Warning("Replica %d could not fetch the latest anchor: %s", instance.id, err)
+		return nil
+	}
+	if anchor == nil {
+		logger.Info("Replica %d found no anchor to check", instance.id)
+		return nil
+	}
+	if err = instance.verifyAnchor(anchor); err != nil {
+		return err
+	}
+	a.published = anchor.SequenceNumber
+	a.signed = anchor.SequenceNumber
+
+	stack, ok := instance.consumer.(anchorStack)
+	if !ok {
+		logger.Warning("PBFT consumer %T cannot check its ledger against anchors", instance.consumer)
+		return nil
+	}
+	id, err := base64.StdEncoding.DecodeString(anchor.Id)
+	if err != nil {
+		return fmt.Errorf("Anchor of seqNo %d has an ID which could not be decoded: %s", anchor.SequenceNumber, err)
+	}
+	if err = stack.checkAnchor(id); err != nil {
+		return fmt.Errorf("Ledger diverges from the anchor of seqNo %d: %s", anchor.SequenceNumber, err)
+	}
+	logger.Info("Replica %d checked its ledger against the anchor of seqNo %d", instance.id, anchor.SequenceNumber)
+	return nil
+}
+
+type sortableAnchorSignatures []*AnchorSignature
+
+func (a sortableAnchorSignatures) Len() int {
+	return len(a)
+}
+func (a sortableAnchorSignatures) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+func (a sortableAnchorSignatures) Less(i, j int) bool {
+	return a[i].ReplicaId < a[j].ReplicaId
+}
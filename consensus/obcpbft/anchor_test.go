@@ -0,0 +1,168 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// memNotary keeps the anchors published in memory
+type memNotary struct {
+	lock    sync.Mutex
+	anchors []*Anchor
+}
+
+func (n *memNotary) publish(anchor *Anchor) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.anchors = append(n.anchors, anchor)
+	return nil
+}
+
+func (n *memNotary) latest() (*Anchor, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if len(n.anchors) == 0 {
+		return nil, nil
+	}
+	return n.anchors[len(n.anchors)-1], nil
+}
+
+// newNotaryServer serves the anchors of a memNotary over HTTP
+func newNotaryServer(n *memNotary) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			raw, _ := ioutil.ReadAll(r.Body)
+			anchor := &Anchor{}
+			if err := proto.Unmarshal(raw, anchor); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			n.publish(anchor)
+			return
+		}
+		anchor, _ := n.latest()
+		if anchor == nil {
+			http.NotFound(w, r)
+			return
+		}
+		raw, _ := proto.Marshal(anchor)
+		w.Write(raw)
+	}))
+}
+
+func TestAnchorPublished(t *testing.T) {
+	notary := &memNotary{}
+	server := newNotaryServer(notary)
+	defer server.Close()
+
+	validatorCount := 4
+	net := makePBFTNetwork(validatorCount, func(pe *pbftEndpoint) {
+		pe.pbft.K = 2
+		pe.pbft.L = 4
+		pe.pbft.anchors = newAnchorer(2, newHTTPNotary(server.URL, time.Second))
+	})
+	defer net.stop()
+
+	broadcaster := uint64(generateBroadcaster(validatorCount))
+	for i := int64(1); i <= 3; i++ {
+		net.pbftEndpoints[0].pbft.manager.queue() <- createPbftRequestWithChainTx(i, broadcaster)
+		if err := net.process(); err != nil {
+			t.Fatalf("Processing failed: %s", err)
+		}
+	}
+
+	// every replica publishes the anchor of seqNo 2, asynchronously
+	var anchors []*Anchor
+	for i := 0; i < 100 && len(anchors) < validatorCount; i++ {
+		time.Sleep(10 * time.Millisecond)
+		notary.lock.Lock()
+		anchors = append([]*Anchor(nil), notary.anchors...)
+		notary.lock.Unlock()
+	}
+	if len(anchors) != validatorCount {
+		t.Fatalf("Expected %d anchors to be published, got %d", validatorCount, len(anchors))
+	}
+
+	pbft := net.pbftEndpoints[0].pbft
+	for _, anchor := range anchors {
+		if anchor.SequenceNumber != 2 {
+			t.Fatalf("Expected the checkpoint of seqNo 2 to be anchored, got %d", anchor.SequenceNumber)
+		}
+		if err := pbft.verifyAnchor(anchor); err != nil {
+			t.Fatalf("Expected the anchor to verify: %s", err)
+		}
+	}
+
+	latest, err := newHTTPNotary(server.URL, time.Second).latest()
+	if err != nil || latest == nil || latest.SequenceNumber != 2 {
+		t.Fatalf("Expected to read back the anchor of seqNo 2, got %v (%v)", latest, err)
+	}
+}
+
+func TestAnchorCheck(t *testing.T) {
+	validatorCount := 4
+	net := makeConsumerNetwork(validatorCount, obcBatchHelper, func(ce *consumerEndpoint) {
+		op := ce.consumer.(*obcBatch)
+		op.batchSize = 1
+		op.batchTimeouts.SetBounds(0, op.batchTimeout)
+	})
+	defer net.stop()
+
+	broadcaster := net.endpoints[generateBroadcaster(validatorCount)].getHandle()
+	net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), broadcaster)
+	net.process()
+
+	op := net.endpoints[0].(*consumerEndpoint).consumer.(*obcBatch)
+	anchor := &Anchor{SequenceNumber: 2, Id: base64.StdEncoding.EncodeToString(op.getState())}
+	for _, ep := range net.endpoints[:3] {
+		sig := &AnchorSignature{SequenceNumber: anchor.SequenceNumber, Id: anchor.Id}
+		signer := ep.(*consumerEndpoint).consumer.(*obcBatch).pbft
+		sig.ReplicaId = signer.id
+		if err := signer.sign(sig); err != nil {
+			t.Fatalf("Failed to sign: %s", err)
+		}
+		anchor.Signatures = append(anchor.Signatures, sig)
+	}
+
+	notary := &memNotary{}
+	notary.publish(anchor)
+	op.pbft.anchors = newAnchorer(2, notary)
+	if err := op.pbft.checkAnchors(); err != nil {
+		t.Fatalf("Expected the ledger to match the anchor: %s", err)
+	}
+
+	notary.publish(&Anchor{SequenceNumber: 4, Id: anchor.Id, Signatures: anchor.Signatures[:2]})
+	if err := op.pbft.checkAnchors(); err == nil {
+		t.Fatal("Expected an anchor without a quorum of signatures to be rejected")
+	}
+
+	notary.publish(anchor)
+	block, _ := op.stack.GetBlock(1)
+	block.Transactions = nil
+	if err := op.pbft.checkAnchors(); err == nil {
+		t.Fatal("Expected a tampered ledger to diverge from the anchor")
+	}
+}
@@ -74,6 +74,13 @@ func (c *complainer) Stop() {
 	c.complaints.Stop()
 }
 
+// SetTimeouts changes the custody and complaint timeouts of the
+// Requests registered from then on.
+func (c *complainer) SetTimeouts(custodyTimeout time.Duration, complaintTimeout time.Duration) {
+	c.custody.SetTimeout(custodyTimeout)
+	c.complaints.SetTimeout(complaintTimeout)
+}
+
 // Custody adds a Request into custody of the complainer.  When the
 // custody timeout expires, the complaintHandler will be invoked with
 // the bool argument set to false.  The Request stays in custody until
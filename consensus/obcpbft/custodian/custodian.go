@@ -82,17 +82,26 @@ func (c *Custodian) Stop() {
 	close(c.stopCh)
 }
 
+// SetTimeout changes the timeout of the objects registered from then
+// on.  Objects already in custody keep their deadline, and still
+// expire before the objects registered after them.
+func (c *Custodian) SetTimeout(timeout time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.timeout = timeout
+}
+
 // Register enqueues a new object to the custodian.  The data object
 // is referred to by id.
 func (c *Custodian) Register(id string, data interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	obj := &custody{
 		id:       id,
 		data:     data,
 		deadline: time.Now().Add(c.timeout),
 	}
 	logger.Debug("Registering %s into custody with timeout %v", id, obj.deadline)
-	c.lock.Lock()
-	defer c.lock.Unlock()
 	c.requests[obj.id] = obj
 	c.seq = append(c.seq, obj)
 	if len(c.seq) == 1 {
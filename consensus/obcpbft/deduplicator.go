@@ -17,32 +17,161 @@ limitations under the License.
 package obcpbft
 
 import (
+	"fmt"
 	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/consensus"
 )
 
 // deduplicator maintains the most recent Request timestamp for each
 // replica.  Two timestamps are maintained per replica.  One timestamp
 // tracks the most recent Request received from a replica, the other
 // timeout tracks the most recent executed Request.
+//
+// It also remembers the digests of the requests executed within ttl,
+// persisting them so that they are still detected after a restart.
+// Requests issued ttl before the latest executed one are stale.  The
+// ttl is measured on the timestamps of the requests rather than on the
+// clock of the replica, so that all replicas executing the same requests
+// discard the same ones.
 type deduplicator struct {
 	reqTimestamps  map[uint64]time.Time
 	execTimestamps map[uint64]time.Time
+
+	persistor consensus.StatePersistor // nil when nothing is persisted
+	ttl       time.Duration
+	executed  map[string]time.Time // timestamps of the executed requests, by digest
+	latest    time.Time            // timestamp of the latest executed request
+	swept     time.Time            // latest when expired requests were last forgotten
+	stats     dedupStats
+}
+
+// dedupStats reports the activity of a deduplicator
+type dedupStats struct {
+	Entries    int    // executed requests remembered
+	Duplicates uint64 // requests discarded as already executed
+	Stale      uint64 // requests discarded as issued too long ago
+	Expired    uint64 // executed requests forgotten after ttl
 }
 
-// newDeduplicator creates a new deduplicator.
-func newDeduplicator() *deduplicator {
+// newDeduplicator creates a new deduplicator, remembering the requests
+// executed within ttl in persistor, and restoring those it remembered
+// before a restart.  A nil persistor remembers them in memory only.
+func newDeduplicator(persistor consensus.StatePersistor, ttl time.Duration) *deduplicator {
 	d := &deduplicator{}
 	d.reqTimestamps = make(map[uint64]time.Time)
 	d.execTimestamps = make(map[uint64]time.Time)
+	d.persistor = persistor
+	d.ttl = ttl
+	d.executed = make(map[string]time.Time)
+	d.restore()
 	return d
 }
 
+// dedupTTL reads how long executed requests are remembered
+func dedupTTL(config *viper.Viper) time.Duration {
+	ttl, err := time.ParseDuration(config.GetString("general.dedup.ttl"))
+	if err != nil {
+		panic(fmt.Errorf("Cannot parse dedup TTL: %s", err))
+	}
+	return ttl
+}
+
+func (d *deduplicator) restore() {
+	if d.persistor == nil {
+		return
+	}
+	entries, err := d.persistor.ReadStateSet("dedup.")
+	if err != nil {
+		logger.Debug("No executed requests to restore: %s", err)
+		return
+	}
+	for key, raw := range entries {
+		req := &Request{}
+		if err := proto.Unmarshal(raw, req); err != nil || req.Timestamp == nil {
+			logger.Warning("Could not restore executed request %s", key)
+			continue
+		}
+		reqTime := time.Unix(req.Timestamp.Seconds, int64(req.Timestamp.Nanos))
+		d.executed[key[len("dedup."):]] = reqTime
+		if reqTime.After(d.execTimestamps[req.ReplicaId]) {
+			d.execTimestamps[req.ReplicaId] = reqTime
+		}
+		if reqTime.After(d.latest) {
+			d.latest = reqTime
+		}
+	}
+	d.expire()
+	logger.Info("Restored %d executed requests", len(d.executed))
+}
+
+// seen returns whether a request is already executed, or stale
+func (d *deduplicator) seen(req *Request, reqTime time.Time) bool {
+	if _, ok := d.executed[hashReq(req)]; ok {
+		d.stats.Duplicates++
+		consensus.GetMetrics().IncDuplicates(pluginName)
+		return true
+	}
+	if d.ttl > 0 && !reqTime.After(d.latest.Add(-d.ttl)) {
+		d.stats.Stale++
+		consensus.GetMetrics().IncDuplicates(pluginName)
+		return true
+	}
+	return false
+}
+
+// remember records an executed request
+func (d *deduplicator) remember(req *Request, reqTime time.Time) {
+	digest := hashReq(req)
+	d.executed[digest] = reqTime
+	if reqTime.After(d.latest) {
+		d.latest = reqTime
+	}
+	if d.persistor != nil {
+		raw, err := proto.Marshal(&Request{Timestamp: req.Timestamp, ReplicaId: req.ReplicaId})
+		if err == nil {
+			err = d.persistor.StoreState("dedup."+digest, raw)
+		}
+		if err != nil {
+			logger.Warning("Could not persist executed request %s: %s", digest, err)
+		}
+	}
+	d.expire()
+}
+
+// expire forgets the requests issued ttl before the latest executed one,
+// sweeping them every tenth of ttl
+func (d *deduplicator) expire() {
+	if d.ttl > 0 && d.latest.Sub(d.swept) >= d.ttl/10 {
+		d.swept = d.latest
+		horizon := d.latest.Add(-d.ttl)
+		for digest, reqTime := range d.executed {
+			if reqTime.After(horizon) {
+				continue
+			}
+			delete(d.executed, digest)
+			if d.persistor != nil {
+				d.persistor.DelState("dedup." + digest)
+			}
+			d.stats.Expired++
+		}
+	}
+	d.stats.Entries = len(d.executed)
+	consensus.GetMetrics().SetDedupEntries(pluginName, len(d.executed))
+}
+
 // Request updates the received request timestamp for the submitting
 // replica.  If the request is older than any previously received or
-// executed request, Request() will return false, indicating a stale
-// request.
+// executed request, or was executed already, Request() will return
+// false, indicating a stale request.
 func (d *deduplicator) Request(req *Request) bool {
 	reqTime := time.Unix(req.Timestamp.Seconds, int64(req.Timestamp.Nanos))
+	if d.seen(req, reqTime) {
+		return false
+	}
 	if !reqTime.After(d.reqTimestamps[req.ReplicaId]) ||
 		!reqTime.After(d.execTimestamps[req.ReplicaId]) {
 		return false
@@ -53,14 +182,18 @@ func (d *deduplicator) Request(req *Request) bool {
 
 // Execute updates the executed request timestamp for the submitting
 // replica.  If the request is older than any previously executed
-// request from the same replica, Execute() will return false,
-// indicating a stale request.
+// request from the same replica, or was executed already, Execute()
+// will return false, indicating a stale request.
 func (d *deduplicator) Execute(req *Request) bool {
 	reqTime := time.Unix(req.Timestamp.Seconds, int64(req.Timestamp.Nanos))
+	if d.seen(req, reqTime) {
+		return false
+	}
 	if !reqTime.After(d.execTimestamps[req.ReplicaId]) {
 		return false
 	}
 	d.execTimestamps[req.ReplicaId] = reqTime
+	d.remember(req, reqTime)
 	return true
 }
 
@@ -68,5 +201,8 @@ func (d *deduplicator) Execute(req *Request) bool {
 // executed request of the submitting replica.
 func (d *deduplicator) IsNew(req *Request) bool {
 	reqTime := time.Unix(req.Timestamp.Seconds, int64(req.Timestamp.Nanos))
+	if _, ok := d.executed[hashReq(req)]; ok {
+		return false
+	}
 	return reqTime.After(d.execTimestamps[req.ReplicaId])
 }
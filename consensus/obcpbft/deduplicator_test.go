@@ -0,0 +1,86 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"testing"
+	"time"
+
+	gp "google/protobuf"
+)
+
+func makeDedupRequest(replica uint64, seconds int64, payload string) *Request {
+	return &Request{
+		Timestamp: &gp.Timestamp{Seconds: seconds},
+		Payload:   []byte(payload),
+		ReplicaId: replica,
+	}
+}
+
+func TestDeduplicatorRestart(t *testing.T) {
+	persist := &mockPersist{}
+	d := newDeduplicator(persist, 10*time.Minute)
+
+	a := makeDedupRequest(1, 1000, "a")
+	b := makeDedupRequest(2, 1001, "b")
+	if !d.Request(a) || !d.Execute(a) || !d.Execute(b) {
+		t.Fatal("Expected new requests to be executed")
+	}
+	if d.Request(a) || d.Execute(a) {
+		t.Fatal("Expected an executed request to be detected")
+	}
+
+	// a restarted replica still detects them
+	d = newDeduplicator(persist, 10*time.Minute)
+	if d.stats.Entries != 2 {
+		t.Fatalf("Expected 2 executed requests restored, got %d", d.stats.Entries)
+	}
+	if d.Request(a) || d.Execute(b) || d.IsNew(a) {
+		t.Fatal("Expected requests executed before the restart to be detected")
+	}
+	if !d.Request(makeDedupRequest(1, 1002, "c")) {
+		t.Fatal("Expected a new request to be accepted after the restart")
+	}
+	if d.stats.Duplicates != 2 {
+		t.Fatalf("Expected 2 duplicates, got %d", d.stats.Duplicates)
+	}
+}
+
+func TestDeduplicatorTTL(t *testing.T) {
+	persist := &mockPersist{}
+	d := newDeduplicator(persist, 10*time.Minute)
+
+	old := makeDedupRequest(1, 1000, "old")
+	d.Execute(old)
+	if !d.Execute(makeDedupRequest(2, 1000+int64((10*time.Minute).Seconds()), "new")) {
+		t.Fatal("Expected a new request to be executed")
+	}
+	if d.stats.Entries != 1 || d.stats.Expired != 1 {
+		t.Fatalf("Expected the old request to expire, got %+v", d.stats)
+	}
+	if keys, _ := persist.ReadStateSet("dedup."); len(keys) != 1 {
+		t.Fatalf("Expected the old request to be deleted from the persisted state, got %d entries", len(keys))
+	}
+
+	// requests issued before the ttl are stale, even from another replica
+	if d.Request(makeDedupRequest(3, 1000, "replay")) {
+		t.Fatal("Expected a request issued before the ttl to be stale")
+	}
+	if d.stats.Stale != 1 {
+		t.Fatalf("Expected a stale request, got %+v", d.stats)
+	}
+}
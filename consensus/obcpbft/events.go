@@ -298,3 +298,19 @@ type batchExecEvent execInfo
 
 // returnRequestEvent is sent by pbft when we are forwarded a request
 type returnRequestEvent *Request
+
+// reconfigureEvent is sent when this replica votes for a change of the membership
+type reconfigureEvent struct {
+	action    Reconfiguration_Action
+	validator uint64
+	result    chan error
+}
+
+// tuneEvent is sent when new parameters are requested through this replica
+type tuneEvent struct {
+	params *Parameters
+	result chan error
+}
+
+// parametersEvent is sent to read the parameters in effect
+type parametersEvent chan *Parameters
@@ -17,6 +17,9 @@ limitations under the License.
 package obcpbft
 
 import (
+	"fmt"
+	"time"
+
 	pb "github.com/hyperledger/fabric/protos"
 )
 
@@ -55,3 +58,97 @@ func (eer *externalEventReceiver) StateUpdating(seqNo uint64, id []byte) {
 		id:    id,
 	}
 }
+
+// AddValidator votes for adding a validator to the replicas
+func (eer *externalEventReceiver) AddValidator(handle *pb.PeerID) error {
+	return reconfigure(eer.manager, Reconfiguration_ADD, handle)
+}
+
+// RemoveValidator votes for removing a validator from the replicas
+func (eer *externalEventReceiver) RemoveValidator(handle *pb.PeerID) error {
+	return reconfigure(eer.manager, Reconfiguration_REMOVE, handle)
+}
+
+// reconfigure hands a vote for a change of the membership to the main
+// thread, and waits for it to be cast
+func reconfigure(manager eventManager, action Reconfiguration_Action, handle *pb.PeerID) error {
+	validator, err := getValidatorID(handle)
+	if err != nil {
+		return err
+	}
+	result := make(chan error, 1)
+	manager.queue() <- reconfigureEvent{
+		action:    action,
+		validator: validator,
+		result:    result,
+	}
+	return <-result
+}
+
+// GetParameters returns the tunable parameters in effect
+func (eer *externalEventReceiver) GetParameters() (*pb.ConsensusParameters, error) {
+	return getParameters(eer.manager)
+}
+
+// SetParameters requests new values of the tunable parameters
+func (eer *externalEventReceiver) SetParameters(params *pb.ConsensusParameters) error {
+	return tune(eer.manager, params)
+}
+
+// getParameters reads the parameters in effect from the main thread
+func getParameters(manager eventManager) (*pb.ConsensusParameters, error) {
+	result := make(chan *Parameters, 1)
+	manager.queue() <- parametersEvent(result)
+	params := <-result
+	return &pb.ConsensusParameters{
+		BatchSize:      uint32(params.BatchSize),
+		BatchTimeout:   formatDuration(params.BatchTimeout),
+		RequestTimeout: formatDuration(params.RequestTimeout),
+	}, nil
+}
+
+// tune hands a request for new parameters to the main thread, and
+// waits for it to be sent
+func tune(manager eventManager, params *pb.ConsensusParameters) error {
+	batchTimeout, err := parseDuration(params.BatchTimeout)
+	if err != nil {
+		return fmt.Errorf("Cannot parse batch timeout: %s", err)
+	}
+	requestTimeout, err := parseDuration(params.RequestTimeout)
+	if err != nil {
+		return fmt.Errorf("Cannot parse request timeout: %s", err)
+	}
+	result := make(chan error, 1)
+	manager.queue() <- tuneEvent{
+		params: &Parameters{
+			BatchSize:      uint64(params.BatchSize),
+			BatchTimeout:   batchTimeout,
+			RequestTimeout: requestTimeout,
+		},
+		result: result,
+	}
+	return <-result
+}
+
+// parseDuration returns the nanoseconds of a duration, 0 if empty
+func parseDuration(s string) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("duration %s is not positive", s)
+	}
+	return uint64(d), nil
+}
+
+// formatDuration returns a duration in nanoseconds as text, empty if 0
+func formatDuration(ns uint64) string {
+	if ns == 0 {
+		return ""
+	}
+	return time.Duration(ns).String()
+}
@@ -20,6 +20,15 @@ It has these top-level messages:
 	PQset
 	NewView
 	FetchRequest
+	Reconfiguration
+	Membership
+	FetchMembership
+	MembershipHistory
+	Parameters
+	TcertAnnouncement
+	TcertSignature
+	AnchorSignature
+	Anchor
 	RequestBlock
 	BatchMessage
 	SieveMessage
@@ -42,6 +51,26 @@ var _ = proto.Marshal
 var _ = fmt.Errorf
 var _ = math.Inf
 
+type Reconfiguration_Action int32
+
+const (
+	Reconfiguration_ADD    Reconfiguration_Action = 0
+	Reconfiguration_REMOVE Reconfiguration_Action = 1
+)
+
+var Reconfiguration_Action_name = map[int32]string{
+	0: "ADD",
+	1: "REMOVE",
+}
+var Reconfiguration_Action_value = map[string]int32{
+	"ADD":    0,
+	"REMOVE": 1,
+}
+
+func (x Reconfiguration_Action) String() string {
+	return proto.EnumName(Reconfiguration_Action_name, int32(x))
+}
+
 type Message struct {
 	// Types that are valid to be assigned to Payload:
 	//	*Message_Request
@@ -53,6 +82,10 @@ type Message struct {
 	//	*Message_NewView
 	//	*Message_FetchRequest
 	//	*Message_ReturnRequest
+	//	*Message_Reconfiguration
+	//	*Message_FetchMembership
+	//	*Message_MembershipHistory
+	//	*Message_AnchorSignature
 	Payload isMessage_Payload `protobuf_oneof:"payload"`
 }
 
@@ -91,16 +124,32 @@ type Message_FetchRequest struct {
 type Message_ReturnRequest struct {
 	ReturnRequest *Request `protobuf:"bytes,9,opt,name=return_request,oneof"`
 }
+type Message_Reconfiguration struct {
+	Reconfiguration *Reconfiguration `protobuf:"bytes,10,opt,name=reconfiguration,oneof"`
+}
+type Message_FetchMembership struct {
+	FetchMembership *FetchMembership `protobuf:"bytes,11,opt,name=fetch_membership,oneof"`
+}
+type Message_MembershipHistory struct {
+	MembershipHistory *MembershipHistory `protobuf:"bytes,12,opt,name=membership_history,oneof"`
+}
+type Message_AnchorSignature struct {
+	AnchorSignature *AnchorSignature `protobuf:"bytes,13,opt,name=anchor_signature,oneof"`
+}
 
-func (*Message_Request) isMessage_Payload()       {}
-func (*Message_PrePrepare) isMessage_Payload()    {}
-func (*Message_Prepare) isMessage_Payload()       {}
-func (*Message_Commit) isMessage_Payload()        {}
-func (*Message_Checkpoint) isMessage_Payload()    {}
-func (*Message_ViewChange) isMessage_Payload()    {}
-func (*Message_NewView) isMessage_Payload()       {}
-func (*Message_FetchRequest) isMessage_Payload()  {}
-func (*Message_ReturnRequest) isMessage_Payload() {}
+func (*Message_Request) isMessage_Payload()           {}
+func (*Message_PrePrepare) isMessage_Payload()        {}
+func (*Message_Prepare) isMessage_Payload()           {}
+func (*Message_Commit) isMessage_Payload()            {}
+func (*Message_Checkpoint) isMessage_Payload()        {}
+func (*Message_ViewChange) isMessage_Payload()        {}
+func (*Message_NewView) isMessage_Payload()           {}
+func (*Message_FetchRequest) isMessage_Payload()      {}
+func (*Message_ReturnRequest) isMessage_Payload()     {}
+func (*Message_Reconfiguration) isMessage_Payload()   {}
+func (*Message_FetchMembership) isMessage_Payload()   {}
+func (*Message_MembershipHistory) isMessage_Payload() {}
+func (*Message_AnchorSignature) isMessage_Payload()   {}
 
 func (m *Message) GetPayload() isMessage_Payload {
 	if m != nil {
@@ -172,6 +221,34 @@ func (m *Message) GetReturnRequest() *Request {
 	return nil
 }
 
+func (m *Message) GetReconfiguration() *Reconfiguration {
+	if x, ok := m.GetPayload().(*Message_Reconfiguration); ok {
+		return x.Reconfiguration
+	}
+	return nil
+}
+
+func (m *Message) GetFetchMembership() *FetchMembership {
+	if x, ok := m.GetPayload().(*Message_FetchMembership); ok {
+		return x.FetchMembership
+	}
+	return nil
+}
+
+func (m *Message) GetMembershipHistory() *MembershipHistory {
+	if x, ok := m.GetPayload().(*Message_MembershipHistory); ok {
+		return x.MembershipHistory
+	}
+	return nil
+}
+
+func (m *Message) GetAnchorSignature() *AnchorSignature {
+	if x, ok := m.GetPayload().(*Message_AnchorSignature); ok {
+		return x.AnchorSignature
+	}
+	return nil
+}
+
 // XXX_OneofFuncs is for the internal use of the proto package.
 func (*Message) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), []interface{}) {
 	return _Message_OneofMarshaler, _Message_OneofUnmarshaler, []interface{}{
@@ -184,6 +261,10 @@ func (*Message) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error
 		(*Message_NewView)(nil),
 		(*Message_FetchRequest)(nil),
 		(*Message_ReturnRequest)(nil),
+		(*Message_Reconfiguration)(nil),
+		(*Message_FetchMembership)(nil),
+		(*Message_MembershipHistory)(nil),
+		(*Message_AnchorSignature)(nil),
 	}
 }
 
@@ -236,6 +317,26 @@ func _Message_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
 		if err := b.EncodeMessage(x.ReturnRequest); err != nil {
 			return err
 		}
+	case *Message_Reconfiguration:
+		b.EncodeVarint(10<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Reconfiguration); err != nil {
+			return err
+		}
+	case *Message_FetchMembership:
+		b.EncodeVarint(11<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.FetchMembership); err != nil {
+			return err
+		}
+	case *Message_MembershipHistory:
+		b.EncodeVarint(12<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.MembershipHistory); err != nil {
+			return err
+		}
+	case *Message_AnchorSignature:
+		b.EncodeVarint(13<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.AnchorSignature); err != nil {
+			return err
+		}
 	case nil:
 	default:
 		return fmt.Errorf("Message.Payload has unexpected type %T", x)
@@ -318,16 +419,50 @@ func _Message_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer
 		err := b.DecodeMessage(msg)
 		m.Payload = &Message_ReturnRequest{msg}
 		return true, err
+	case 10: // payload.reconfiguration
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Reconfiguration)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_Reconfiguration{msg}
+		return true, err
+	case 11: // payload.fetch_membership
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(FetchMembership)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_FetchMembership{msg}
+		return true, err
+	case 12: // payload.membership_history
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(MembershipHistory)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_MembershipHistory{msg}
+		return true, err
+	case 13: // payload.anchor_signature
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(AnchorSignature)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_AnchorSignature{msg}
+		return true, err
 	default:
 		return false, nil
 	}
 }
 
 type Request struct {
-	Timestamp *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=timestamp" json:"timestamp,omitempty"`
-	Payload   []byte                     `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
-	ReplicaId uint64                     `protobuf:"varint,3,opt,name=replica_id" json:"replica_id,omitempty"`
-	Signature []byte                     `protobuf:"bytes,4,opt,name=signature,proto3" json:"signature,omitempty"`
+	Timestamp       *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=timestamp" json:"timestamp,omitempty"`
+	Payload         []byte                     `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+	ReplicaId       uint64                     `protobuf:"varint,3,opt,name=replica_id" json:"replica_id,omitempty"`
+	Signature       []byte                     `protobuf:"bytes,4,opt,name=signature,proto3" json:"signature,omitempty"`
+	Reconfiguration []*Reconfiguration         `protobuf:"bytes,5,rep,name=reconfiguration" json:"reconfiguration,omitempty"`
+	Parameters      *Parameters                `protobuf:"bytes,6,opt,name=parameters" json:"parameters,omitempty"`
 }
 
 func (m *Request) Reset()         { *m = Request{} }
@@ -341,12 +476,27 @@ func (m *Request) GetTimestamp() *google_protobuf.Timestamp {
 	return nil
 }
 
+func (m *Request) GetReconfiguration() []*Reconfiguration {
+	if m != nil {
+		return m.Reconfiguration
+	}
+	return nil
+}
+
+func (m *Request) GetParameters() *Parameters {
+	if m != nil {
+		return m.Parameters
+	}
+	return nil
+}
+
 type PrePrepare struct {
 	View           uint64   `protobuf:"varint,1,opt,name=view" json:"view,omitempty"`
 	SequenceNumber uint64   `protobuf:"varint,2,opt,name=sequence_number" json:"sequence_number,omitempty"`
 	RequestDigest  string   `protobuf:"bytes,3,opt,name=request_digest" json:"request_digest,omitempty"`
 	Request        *Request `protobuf:"bytes,4,opt,name=request" json:"request,omitempty"`
 	ReplicaId      uint64   `protobuf:"varint,5,opt,name=replica_id" json:"replica_id,omitempty"`
+	Epoch          uint64   `protobuf:"varint,6,opt,name=epoch" json:"epoch,omitempty"`
 }
 
 func (m *PrePrepare) Reset()         { *m = PrePrepare{} }
@@ -365,6 +515,7 @@ type Prepare struct {
 	SequenceNumber uint64 `protobuf:"varint,2,opt,name=sequence_number" json:"sequence_number,omitempty"`
 	RequestDigest  string `protobuf:"bytes,3,opt,name=request_digest" json:"request_digest,omitempty"`
 	ReplicaId      uint64 `protobuf:"varint,4,opt,name=replica_id" json:"replica_id,omitempty"`
+	Epoch          uint64 `protobuf:"varint,5,opt,name=epoch" json:"epoch,omitempty"`
 }
 
 func (m *Prepare) Reset()         { *m = Prepare{} }
@@ -376,6 +527,7 @@ type Commit struct {
 	SequenceNumber uint64 `protobuf:"varint,2,opt,name=sequence_number" json:"sequence_number,omitempty"`
 	RequestDigest  string `protobuf:"bytes,3,opt,name=request_digest" json:"request_digest,omitempty"`
 	ReplicaId      uint64 `protobuf:"varint,4,opt,name=replica_id" json:"replica_id,omitempty"`
+	Epoch          uint64 `protobuf:"varint,5,opt,name=epoch" json:"epoch,omitempty"`
 }
 
 func (m *Commit) Reset()         { *m = Commit{} }
@@ -395,6 +547,7 @@ type Checkpoint struct {
 	SequenceNumber uint64 `protobuf:"varint,1,opt,name=sequence_number" json:"sequence_number,omitempty"`
 	ReplicaId      uint64 `protobuf:"varint,2,opt,name=replica_id" json:"replica_id,omitempty"`
 	Id             string `protobuf:"bytes,3,opt,name=id" json:"id,omitempty"`
+	Epoch          uint64 `protobuf:"varint,4,opt,name=epoch" json:"epoch,omitempty"`
 }
 
 func (m *Checkpoint) Reset()         { *m = Checkpoint{} }
@@ -409,6 +562,7 @@ type ViewChange struct {
 	Qset      []*ViewChange_PQ `protobuf:"bytes,5,rep,name=qset" json:"qset,omitempty"`
 	ReplicaId uint64           `protobuf:"varint,6,opt,name=replica_id" json:"replica_id,omitempty"`
 	Signature []byte           `protobuf:"bytes,7,opt,name=signature,proto3" json:"signature,omitempty"`
+	Epoch     uint64           `protobuf:"varint,8,opt,name=epoch" json:"epoch,omitempty"`
 }
 
 func (m *ViewChange) Reset()         { *m = ViewChange{} }
@@ -476,6 +630,7 @@ type NewView struct {
 	Vset      []*ViewChange     `protobuf:"bytes,2,rep,name=vset" json:"vset,omitempty"`
 	Xset      map[uint64]string `protobuf:"bytes,3,rep,name=xset" json:"xset,omitempty" protobuf_key:"varint,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 	ReplicaId uint64            `protobuf:"varint,4,opt,name=replica_id" json:"replica_id,omitempty"`
+	Epoch     uint64            `protobuf:"varint,5,opt,name=epoch" json:"epoch,omitempty"`
 }
 
 func (m *NewView) Reset()         { *m = NewView{} }
@@ -505,6 +660,125 @@ func (m *FetchRequest) Reset()         { *m = FetchRequest{} }
 func (m *FetchRequest) String() string { return proto.CompactTextString(m) }
 func (*FetchRequest) ProtoMessage()    {}
 
+type Reconfiguration struct {
+	Action    Reconfiguration_Action `protobuf:"varint,1,opt,name=action,enum=obcpbft.Reconfiguration_Action" json:"action,omitempty"`
+	Validator uint64                 `protobuf:"varint,2,opt,name=validator" json:"validator,omitempty"`
+	Epoch     uint64                 `protobuf:"varint,3,opt,name=epoch" json:"epoch,omitempty"`
+	ReplicaId uint64                 `protobuf:"varint,4,opt,name=replica_id" json:"replica_id,omitempty"`
+	Signature []byte                 `protobuf:"bytes,5,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *Reconfiguration) Reset()         { *m = Reconfiguration{} }
+func (m *Reconfiguration) String() string { return proto.CompactTextString(m) }
+func (*Reconfiguration) ProtoMessage()    {}
+
+type Membership struct {
+	Epoch           uint64             `protobuf:"varint,1,opt,name=epoch" json:"epoch,omitempty"`
+	Replicas        []uint64           `protobuf:"varint,2,rep,name=replicas" json:"replicas,omitempty"`
+	SequenceNumber  uint64             `protobuf:"varint,3,opt,name=sequence_number" json:"sequence_number,omitempty"`
+	Reconfiguration []*Reconfiguration `protobuf:"bytes,4,rep,name=reconfiguration" json:"reconfiguration,omitempty"`
+}
+
+func (m *Membership) Reset()         { *m = Membership{} }
+func (m *Membership) String() string { return proto.CompactTextString(m) }
+func (*Membership) ProtoMessage()    {}
+
+func (m *Membership) GetReconfiguration() []*Reconfiguration {
+	if m != nil {
+		return m.Reconfiguration
+	}
+	return nil
+}
+
+type FetchMembership struct {
+	Epoch     uint64 `protobuf:"varint,1,opt,name=epoch" json:"epoch,omitempty"`
+	ReplicaId uint64 `protobuf:"varint,2,opt,name=replica_id" json:"replica_id,omitempty"`
+}
+
+func (m *FetchMembership) Reset()         { *m = FetchMembership{} }
+func (m *FetchMembership) String() string { return proto.CompactTextString(m) }
+func (*FetchMembership) ProtoMessage()    {}
+
+type MembershipHistory struct {
+	Memberships []*Membership `protobuf:"bytes,1,rep,name=memberships" json:"memberships,omitempty"`
+	ReplicaId   uint64        `protobuf:"varint,2,opt,name=replica_id" json:"replica_id,omitempty"`
+}
+
+func (m *MembershipHistory) Reset()         { *m = MembershipHistory{} }
+func (m *MembershipHistory) String() string { return proto.CompactTextString(m) }
+func (*MembershipHistory) ProtoMessage()    {}
+
+func (m *MembershipHistory) GetMemberships() []*Membership {
+	if m != nil {
+		return m.Memberships
+	}
+	return nil
+}
+
+type Parameters struct {
+	BatchSize      uint64 `protobuf:"varint,1,opt,name=batch_size" json:"batch_size,omitempty"`
+	BatchTimeout   uint64 `protobuf:"varint,2,opt,name=batch_timeout" json:"batch_timeout,omitempty"`
+	RequestTimeout uint64 `protobuf:"varint,3,opt,name=request_timeout" json:"request_timeout,omitempty"`
+}
+
+func (m *Parameters) Reset()         { *m = Parameters{} }
+func (m *Parameters) String() string { return proto.CompactTextString(m) }
+func (*Parameters) ProtoMessage()    {}
+
+type TcertAnnouncement struct {
+	ReplicaId uint64 `protobuf:"varint,1,opt,name=replica_id" json:"replica_id,omitempty"`
+	Cert      []byte `protobuf:"bytes,2,opt,name=cert,proto3" json:"cert,omitempty"`
+	Signature []byte `protobuf:"bytes,3,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *TcertAnnouncement) Reset()         { *m = TcertAnnouncement{} }
+func (m *TcertAnnouncement) String() string { return proto.CompactTextString(m) }
+func (*TcertAnnouncement) ProtoMessage()    {}
+
+type TcertSignature struct {
+	Announcement *TcertAnnouncement `protobuf:"bytes,1,opt,name=announcement" json:"announcement,omitempty"`
+	Signature    []byte             `protobuf:"bytes,2,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *TcertSignature) Reset()         { *m = TcertSignature{} }
+func (m *TcertSignature) String() string { return proto.CompactTextString(m) }
+func (*TcertSignature) ProtoMessage()    {}
+
+func (m *TcertSignature) GetAnnouncement() *TcertAnnouncement {
+	if m != nil {
+		return m.Announcement
+	}
+	return nil
+}
+
+type AnchorSignature struct {
+	SequenceNumber uint64 `protobuf:"varint,1,opt,name=sequence_number" json:"sequence_number,omitempty"`
+	Id             string `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
+	ReplicaId      uint64 `protobuf:"varint,3,opt,name=replica_id" json:"replica_id,omitempty"`
+	Signature      []byte `protobuf:"bytes,4,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *AnchorSignature) Reset()         { *m = AnchorSignature{} }
+func (m *AnchorSignature) String() string { return proto.CompactTextString(m) }
+func (*AnchorSignature) ProtoMessage()    {}
+
+type Anchor struct {
+	SequenceNumber uint64             `protobuf:"varint,1,opt,name=sequence_number" json:"sequence_number,omitempty"`
+	Id             string             `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
+	Signatures     []*AnchorSignature `protobuf:"bytes,3,rep,name=signatures" json:"signatures,omitempty"`
+}
+
+func (m *Anchor) Reset()         { *m = Anchor{} }
+func (m *Anchor) String() string { return proto.CompactTextString(m) }
+func (*Anchor) ProtoMessage()    {}
+
+func (m *Anchor) GetSignatures() []*AnchorSignature {
+	if m != nil {
+		return m.Signatures
+	}
+	return nil
+}
+
 type RequestBlock struct {
 	Requests []*Request `protobuf:"bytes,1,rep,name=requests" json:"requests,omitempty"`
 }
@@ -977,9 +1251,15 @@ func (m *Flush) String() string { return proto.CompactTextString(m) }
 func (*Flush) ProtoMessage()    {}
 
 type Metadata struct {
-	SeqNo uint64 `protobuf:"varint,1,opt,name=seqNo" json:"seqNo,omitempty"`
+	SeqNo        uint64 `protobuf:"varint,1,opt,name=seqNo" json:"seqNo,omitempty"`
+	LeaderPolicy string `protobuf:"bytes,2,opt,name=leaderPolicy" json:"leaderPolicy,omitempty"`
+	Leader       uint64 `protobuf:"varint,3,opt,name=leader" json:"leader,omitempty"`
 }
 
 func (m *Metadata) Reset()         { *m = Metadata{} }
 func (m *Metadata) String() string { return proto.CompactTextString(m) }
 func (*Metadata) ProtoMessage()    {}
+
+func init() {
+	proto.RegisterEnum("obcpbft.Reconfiguration_Action", Reconfiguration_Action_name, Reconfiguration_Action_value)
+}
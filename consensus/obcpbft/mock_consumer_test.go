@@ -87,7 +87,7 @@ func (cs *completeStack) SkipTo(tag uint64, id []byte, peers []*pb.PeerID) {
 			cs.consumer.StateUpdating(tag, id)
 			// State transfer takes time, not simulating this hides bugs
 			time.Sleep(time.Duration((MaxStateTransferTime/2)+rand.Intn(MaxStateTransferTime/2)) * time.Millisecond)
-			meta := &Metadata{tag}
+			meta := &Metadata{SeqNo: tag}
 			metaRaw, _ := proto.Marshal(meta)
 			cs.simulateStateTransfer(metaRaw, id, peers)
 			cs.consumer.StateUpdated(tag, id)
@@ -141,6 +141,7 @@ func makeConsumerNetwork(N int, makeConsumer func(id uint64, config *viper.Viper
 		ce.consumer = makeConsumer(id, loadConfig(), cs)
 		ce.consumer.getPBFTCore().N = N
 		ce.consumer.getPBFTCore().f = (N - 1) / 3
+		ce.consumer.getPBFTCore().initMembership(N, (N-1)/3)
 
 		for _, fn := range initFNs {
 			fn(ce)
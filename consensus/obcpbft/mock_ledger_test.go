@@ -296,7 +296,7 @@ func (mock *MockLedger) simulateStateTransfer(meta []byte, id []byte, peers []*p
 			continue
 		}
 
-		block.ConsensusMetadata = meta
+		// blocks are transferred with the metadata they were committed with
 		mock.blocks[n] = block
 	}
 	mock.blockHeight = info.Height
@@ -0,0 +1,539 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"container/heap"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/hyperledger/fabric/core/util"
+	gp "google/protobuf"
+)
+
+// The simulator runs PBFT replicas over an in-memory network in simulated
+// time. All of them run on the thread of the test: messages, timers and
+// executions are events ordered by the time they happen at, and the delay
+// and loss of every message are drawn from the seed, so that a run can be
+// repeated.
+
+// simEvent is something which happens at a point of the simulated time
+type simEvent struct {
+	at   time.Duration
+	seq  uint64 // orders events happening at the same time
+	fire func()
+}
+
+type simQueue []*simEvent
+
+func (q simQueue) Len() int { return len(q) }
+func (q simQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+func (q simQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *simQueue) Push(x interface{}) { *q = append(*q, x.(*simEvent)) }
+func (q *simQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// simBehavior is how a Byzantine replica tampers with the messages it
+// sends, returning what dst receives instead of msg, or nil for nothing
+type simBehavior func(dst uint64, msg *Message) *Message
+
+// silent sends nothing at all
+func silent(dst uint64, msg *Message) *Message {
+	return nil
+}
+
+// equivocate sends to odd replicas a pre-prepare for another request than
+// to the others
+func equivocate(dst uint64, msg *Message) *Message {
+	preprep := msg.GetPrePrepare()
+	if preprep == nil || preprep.Request == nil || dst%2 == 0 {
+		return msg
+	}
+	forged := *preprep.Request
+	forged.Payload = append([]byte("forged "), forged.Payload...)
+	tampered := *preprep
+	tampered.Request = &forged
+	tampered.RequestDigest = hashReq(&forged)
+	return &Message{&Message_PrePrepare{&tampered}}
+}
+
+type simulator struct {
+	seed     int64
+	now      time.Duration
+	seq      uint64
+	events   simQueue
+	replicas []*simReplica
+	trace    []string
+
+	minLatency time.Duration // delay of the messages, drawn between min and max
+	maxLatency time.Duration
+	execute    time.Duration // how long an execution takes
+	transfer   time.Duration // how long a state transfer takes
+	dropRate   float64       // fraction of the messages lost
+
+	partition map[uint64]int         // group of each replica, messages between groups are lost
+	byzantine map[uint64]simBehavior // behavior of the Byzantine replicas
+	sent      map[string]int         // times each message was sent, by link
+	requests  int
+}
+
+// simReplica is a PBFT replica, along with the application it executes
+// requests for
+type simReplica struct {
+	mockPersist
+	id          uint64
+	sim         *simulator
+	pbft        *pbftCore
+	crashed     bool
+	incarnation int          // changes on crash, to discard the events of the previous one
+	executing   bool         // whether an execution is in progress
+	execs       chan simExec // executions requested by pbftCore
+	transfer    bool         // whether a state transfer is in progress
+	ledger      []simExec
+	state       []byte
+}
+
+type simExec struct {
+	seqNo   uint64
+	payload []byte
+}
+
+func newSimulator(N int, seed int64) *simulator {
+	sim := &simulator{
+		seed:       seed,
+		minLatency: 5 * time.Millisecond,
+		maxLatency: 50 * time.Millisecond,
+		execute:    10 * time.Millisecond,
+		transfer:   500 * time.Millisecond,
+		byzantine:  make(map[uint64]simBehavior),
+		sent:       make(map[string]int),
+	}
+	for id := uint64(0); id < uint64(N); id++ {
+		r := &simReplica{id: id, sim: sim, execs: make(chan simExec, 1)}
+		sim.replicas = append(sim.replicas, r)
+	}
+	for _, r := range sim.replicas {
+		r.start()
+	}
+	return sim
+}
+
+func (sim *simulator) schedule(after time.Duration, fire func()) {
+	sim.seq++
+	heap.Push(&sim.events, &simEvent{at: sim.now + after, seq: sim.seq, fire: fire})
+}
+
+func (sim *simulator) tracef(format string, args ...interface{}) {
+	sim.trace = append(sim.trace, fmt.Sprintf("%v ", sim.now)+fmt.Sprintf(format, args...))
+}
+
+// draw returns a number in [0, 1) which only depends on the seed and parts
+func (sim *simulator) draw(parts ...interface{}) float64 {
+	h := fnv.New64a()
+	fmt.Fprint(h, sim.seed, parts)
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
+
+// step fires the next event, and returns false if there is none
+func (sim *simulator) step() bool {
+	if len(sim.events) == 0 {
+		return false
+	}
+	e := heap.Pop(&sim.events).(*simEvent)
+	sim.now = e.at
+	e.fire()
+	return true
+}
+
+// run fires the events which happen during d
+func (sim *simulator) run(d time.Duration) {
+	end := sim.now + d
+	for len(sim.events) > 0 && sim.events[0].at <= end {
+		sim.step()
+	}
+	sim.now = end
+}
+
+// runUntil fires events until cond holds, or limit elapsed, and returns
+// whether cond holds
+func (sim *simulator) runUntil(cond func() bool, limit time.Duration) bool {
+	end := sim.now + limit
+	for !cond() {
+		if len(sim.events) == 0 || sim.events[0].at > end {
+			sim.now = end
+			return false
+		}
+		sim.step()
+	}
+	return true
+}
+
+// request submits a request to all the replicas, as clients do
+func (sim *simulator) request(payload string) {
+	sim.requests++
+	req := &Request{
+		Timestamp: &gp.Timestamp{Seconds: int64(sim.now / time.Second), Nanos: int32(sim.now % time.Second)},
+		Payload:   []byte(payload),
+		ReplicaId: uint64(sim.requests) % uint64(len(sim.replicas)),
+	}
+	for _, r := range sim.replicas {
+		r.enqueue(sim.latency("client", r.id, payload), req)
+	}
+}
+
+func (sim *simulator) latency(parts ...interface{}) time.Duration {
+	return sim.minLatency + time.Duration(sim.draw(append(parts, "latency")...)*float64(sim.maxLatency-sim.minLatency))
+}
+
+// send carries a message from src to dst, unless it is lost
+func (sim *simulator) send(src, dst uint64, payload []byte) {
+	msg := &Message{}
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		panic(fmt.Sprintf("Replica %d sent a message which did not unmarshal: %s", src, err))
+	}
+	if behavior, ok := sim.byzantine[src]; ok {
+		if msg = behavior(dst, msg); msg == nil {
+			return
+		}
+	}
+	if sim.partition != nil && sim.partition[src] != sim.partition[dst] {
+		return
+	}
+
+	// the view change messages carry sets built from maps, and would
+	// otherwise be delayed differently from one run to the next
+	var key string
+	if vc := msg.GetViewChange(); vc != nil {
+		key = fmt.Sprintf("view-change %d %d", vc.View, vc.ReplicaId)
+	} else if nv := msg.GetNewView(); nv != nil {
+		key = fmt.Sprintf("new-view %d %d", nv.View, nv.ReplicaId)
+	} else {
+		key = msg.String()
+	}
+	link := fmt.Sprintf("%d %d %s", src, dst, key)
+	sim.sent[link]++
+	if sim.draw(link, sim.sent[link], "drop") < sim.dropRate {
+		return
+	}
+	sim.replicas[dst].enqueue(sim.latency(link, sim.sent[link]), &pbftMessage{msg: msg, sender: src})
+}
+
+// crash stops a replica, which loses everything but its persisted state
+// and ledger
+func (sim *simulator) crash(id uint64) {
+	r := sim.replicas[id]
+	r.crashed = true
+	r.incarnation++
+	r.pbft.close()
+	sim.tracef("replica %d crashed", id)
+}
+
+// restart recovers a crashed replica from its persisted state
+func (sim *simulator) restart(id uint64) {
+	r := sim.replicas[id]
+	r.start()
+	sim.tracef("replica %d restarted", id)
+}
+
+// isolate partitions the network into groups, unlisted replicas are
+// isolated alone
+func (sim *simulator) isolate(groups ...[]uint64) {
+	sim.partition = make(map[uint64]int)
+	for _, r := range sim.replicas {
+		sim.partition[r.id] = -1 - int(r.id)
+	}
+	for i, group := range groups {
+		for _, id := range group {
+			sim.partition[id] = i
+		}
+	}
+}
+
+// heal ends the partition of the network
+func (sim *simulator) heal() {
+	sim.partition = nil
+}
+
+// correct returns the replicas which are neither crashed nor Byzantine
+func (sim *simulator) correct() []*simReplica {
+	var replicas []*simReplica
+	for _, r := range sim.replicas {
+		if _, ok := sim.byzantine[r.id]; !ok && !r.crashed {
+			replicas = append(replicas, r)
+		}
+	}
+	return replicas
+}
+
+// executed returns whether a quorum of correct replicas executed n
+// requests, others may lag behind until the next checkpoint
+func (sim *simulator) executed(n int) func() bool {
+	quorum := len(sim.replicas) - (len(sim.replicas)-1)/3
+	return func() bool {
+		count := 0
+		for _, r := range sim.correct() {
+			if len(r.ledger) >= n {
+				count++
+			}
+		}
+		return count >= quorum
+	}
+}
+
+// checkLedgers returns an error unless the ledgers of the correct replicas
+// are prefixes of one another
+func (sim *simulator) checkLedgers() error {
+	var longest []simExec
+	for _, r := range sim.correct() {
+		if len(r.ledger) > len(longest) {
+			longest = r.ledger
+		}
+	}
+	for _, r := range sim.correct() {
+		for i, e := range r.ledger {
+			if e.seqNo != longest[i].seqNo || string(e.payload) != string(longest[i].payload) {
+				return fmt.Errorf("Replica %d executed %s at seqNo %d, another replica %s at seqNo %d",
+					r.id, e.payload, e.seqNo, longest[i].payload, longest[i].seqNo)
+			}
+		}
+	}
+	return nil
+}
+
+func (sim *simulator) stop() {
+	for _, r := range sim.replicas {
+		if !r.crashed {
+			r.pbft.close()
+		}
+	}
+}
+
+// start creates the pbftCore of the replica, driven by the simulator
+// in place of its event thread and timers
+func (r *simReplica) start() {
+	config := loadConfig()
+	config.Set("general.N", len(r.sim.replicas))
+	config.Set("general.f", (len(r.sim.replicas)-1)/3)
+
+	r.crashed = false
+	r.incarnation++
+	r.executing = false
+	r.transfer = false
+	r.pbft = newPbftCore(r.id, config, r)
+	r.pbft.close()
+	r.pbft.manager = &simManager{replica: r, events: make(chan interface{}, 100)}
+	r.pbft.newViewTimer = &simTimer{replica: r}
+}
+
+// enqueue delivers an event to the replica after d
+func (r *simReplica) enqueue(d time.Duration, event interface{}) {
+	incarnation := r.incarnation
+	r.sim.schedule(d, func() {
+		if r.incarnation == incarnation && !r.crashed {
+			r.process(event)
+		}
+	})
+}
+
+// process delivers an event to pbftCore, then the events pbftCore queued
+func (r *simReplica) process(event interface{}) {
+	sendEvent(r.pbft, event)
+	for {
+		select {
+		case queued := <-r.pbft.manager.(*simManager).events:
+			sendEvent(r.pbft, queued)
+			continue
+		default:
+		}
+		break
+	}
+
+	// pbftCore executes requests on another thread, wait for it
+	if r.pbft.currentExec != nil && !r.executing {
+		exec := <-r.execs
+		r.executing = true
+		incarnation := r.incarnation
+		r.sim.schedule(r.sim.execute, func() {
+			if r.incarnation != incarnation {
+				return
+			}
+			r.ledger = append(r.ledger, exec)
+			r.state = util.ComputeCryptoHash(append(r.state, exec.payload...))
+			r.executing = false
+			r.sim.tracef("replica %d executed %s at seqNo %d", r.id, exec.payload, exec.seqNo)
+			if !r.crashed {
+				r.process(execDoneEvent{})
+			}
+		})
+	}
+}
+
+func (r *simReplica) broadcast(msgPayload []byte) {
+	for _, dst := range r.sim.replicas {
+		if dst.id != r.id {
+			r.sim.send(r.id, dst.id, msgPayload)
+		}
+	}
+}
+
+func (r *simReplica) unicast(msgPayload []byte, receiverID uint64) error {
+	if receiverID >= uint64(len(r.sim.replicas)) {
+		return fmt.Errorf("No replica %d", receiverID)
+	}
+	r.sim.send(r.id, receiverID, msgPayload)
+	return nil
+}
+
+func (r *simReplica) execute(seqNo uint64, txRaw []byte) {
+	r.execs <- simExec{seqNo, txRaw}
+}
+
+func (r *simReplica) getState() []byte {
+	return r.state
+}
+
+func (r *simReplica) getLastSeqNo() (uint64, error) {
+	if len(r.ledger) == 0 {
+		return 0, fmt.Errorf("no execution yet")
+	}
+	return r.ledger[len(r.ledger)-1].seqNo, nil
+}
+
+// skipTo transfers the ledger up to seqNo from one of the replicas
+func (r *simReplica) skipTo(seqNo uint64, snapshotID []byte, peers []uint64) {
+	if r.transfer {
+		return
+	}
+	r.transfer = true
+	r.sim.tracef("replica %d transferring state to seqNo %d", r.id, seqNo)
+
+	incarnation := r.incarnation
+	var transfer func()
+	transfer = func() {
+		if r.incarnation != incarnation {
+			return
+		}
+		for _, id := range peers {
+			from := r.sim.replicas[id]
+			last, err := from.getLastSeqNo()
+			if err != nil || last < seqNo {
+				continue
+			}
+			r.ledger = nil
+			r.state = nil
+			for _, e := range from.ledger {
+				if e.seqNo > seqNo {
+					break
+				}
+				r.ledger = append(r.ledger, e)
+				r.state = util.ComputeCryptoHash(append(r.state, e.payload...))
+			}
+			r.transfer = false
+			r.sim.tracef("replica %d transferred state to seqNo %d", r.id, seqNo)
+			r.process(stateUpdatedEvent{seqNo: seqNo, id: r.state})
+			return
+		}
+		r.sim.schedule(r.sim.transfer, transfer)
+	}
+	r.sim.schedule(r.sim.transfer, transfer)
+}
+
+func (r *simReplica) validate(txRaw []byte) error {
+	return nil
+}
+
+func (r *simReplica) viewChange(curView uint64) {
+	r.sim.tracef("replica %d moved to view %d", r.id, curView)
+}
+
+func (r *simReplica) sign(msg []byte) ([]byte, error) {
+	return msg, nil
+}
+
+func (r *simReplica) verify(senderID uint64, signature []byte, message []byte) error {
+	return nil
+}
+
+func (r *simReplica) invalidateState() {}
+func (r *simReplica) validateState()   {}
+
+// simManager delivers the events pbftCore queues itself once the event
+// it is processing is done
+type simManager struct {
+	replica *simReplica
+	events  chan interface{}
+}
+
+func (m *simManager) inject(event interface{}) {
+	sendEvent(m.replica.pbft, event)
+}
+
+func (m *simManager) queue() chan<- interface{} {
+	return m.events
+}
+
+func (m *simManager) start() {}
+func (m *simManager) halt()  {}
+
+// simTimer counts down in simulated time
+type simTimer struct {
+	replica    *simReplica
+	running    bool
+	generation int // changes when the countdown does, to discard the previous one
+}
+
+func (t *simTimer) softReset(timeout time.Duration, event interface{}) {
+	if !t.running {
+		t.reset(timeout, event)
+	}
+}
+
+func (t *simTimer) reset(timeout time.Duration, event interface{}) {
+	t.generation++
+	t.running = true
+	generation := t.generation
+	incarnation := t.replica.incarnation
+	t.replica.sim.schedule(timeout, func() {
+		r := t.replica
+		if t.generation != generation || r.incarnation != incarnation || r.crashed {
+			return
+		}
+		t.running = false
+		r.process(event)
+	})
+}
+
+func (t *simTimer) stop() {
+	t.generation++
+	t.running = false
+}
+
+func (t *simTimer) halt() {
+	t.stop()
+}
@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/consensus"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
 
 	pb "github.com/hyperledger/fabric/protos"
@@ -164,6 +165,8 @@ type omniProto struct {
 	viewChangeImpl      func(curView uint64)
 	signImpl            func(msg []byte) ([]byte, error)
 	verifyImpl          func(senderID uint64, signature []byte, message []byte) error
+	getNextTCertImpl    func() (consensus.SigningCert, error)
+	verifyWithTCertImpl func(cert []byte, signature, message []byte) error
 	getLastSeqNoImpl    func() (uint64, error)
 	validateStateImpl   func()
 	invalidateStateImpl func()
@@ -421,6 +424,20 @@ func (op *omniProto) verify(senderID uint64, signature []byte, message []byte) e
 
 	panic("Unimplemented")
 }
+func (op *omniProto) getNextTCert() (consensus.SigningCert, error) {
+	if nil != op.getNextTCertImpl {
+		return op.getNextTCertImpl()
+	}
+
+	panic("Unimplemented")
+}
+func (op *omniProto) verifyWithTCert(cert []byte, signature, message []byte) error {
+	if nil != op.verifyWithTCertImpl {
+		return op.verifyWithTCertImpl(cert, signature, message)
+	}
+
+	panic("Unimplemented")
+}
 
 func (op *omniProto) RecvMsg(ocMsg *pb.Message, senderHandle *pb.PeerID) error {
 	if nil != op.RecvMsgImpl {
@@ -21,6 +21,7 @@ import (
 	"time"
 
 	"github.com/hyperledger/fabric/consensus"
+	cutil "github.com/hyperledger/fabric/consensus/util"
 	pb "github.com/hyperledger/fabric/protos"
 
 	"github.com/golang/protobuf/proto"
@@ -37,7 +38,8 @@ type obcBatch struct {
 	batchStore       []*Request
 	batchTimer       eventTimer
 	batchTimerActive bool
-	batchTimeout     time.Duration
+	batchTimeout     time.Duration       // longest the batch timer runs, under high load
+	batchTimeouts    *cutil.BatchTimeout // adapts the batch timer to the load
 	inViewChange     bool
 
 	incomingChan chan *batchMessage // Queues messages for processing by main thread
@@ -90,14 +92,23 @@ func newObcBatch(id uint64, config *viper.Viper, stack consensus.Stack) *obcBatc
 	if err != nil {
 		panic(fmt.Errorf("Cannot parse batch timeout: %s", err))
 	}
+	var batchTimeoutMin time.Duration
+	if min := config.GetString("general.timeout.batchmin"); min != "" {
+		if batchTimeoutMin, err = time.ParseDuration(min); err != nil {
+			panic(fmt.Errorf("Cannot parse minimum batch timeout: %s", err))
+		}
+	}
+	op.batchTimeouts = cutil.NewBatchTimeout(batchTimeoutMin, op.batchTimeout)
 
 	op.incomingChan = make(chan *batchMessage)
 
 	op.complainer = newComplainer(op, op.pbft.requestTimeout, op.pbft.requestTimeout)
-	op.deduplicator = newDeduplicator()
+	op.deduplicator = newDeduplicator(stack, dedupTTL(config))
 
 	op.batchTimer = etf.createTimer()
 
+	op.pbft.restoreParameters()
+
 	op.idleChan = make(chan struct{})
 	close(op.idleChan) // TODO remove eventually
 
@@ -182,6 +193,14 @@ func (op *obcBatch) verify(senderID uint64, signature []byte, message []byte) er
 	return op.stack.Verify(senderHandle, signature, message)
 }
 
+func (op *obcBatch) getNextTCert() (consensus.SigningCert, error) {
+	return getNextTCert(op.stack)
+}
+
+func (op *obcBatch) verifyWithTCert(cert []byte, signature, message []byte) error {
+	return verifyWithTCert(op.stack, cert, signature, message)
+}
+
 // validate checks whether the request is valid syntactically
 // not used in obc-batch at the moment
 func (op *obcBatch) validate(txRaw []byte) error {
@@ -224,7 +243,7 @@ func (op *obcBatch) executeImpl(seqNo uint64, raw []byte) {
 		txs = append(txs, tx)
 	}
 
-	meta, _ := proto.Marshal(&Metadata{seqNo})
+	meta := op.pbft.blockMetadata(seqNo)
 
 	id := []byte("foo")
 	op.stack.BeginTxBatch(id)
@@ -257,6 +276,7 @@ func (op *obcBatch) leaderProcReq(req *Request) error {
 
 	logger.Debug("Batch primary %d queueing new request %s", op.pbft.id, hash)
 	op.batchStore = append(op.batchStore, req)
+	consensus.GetMetrics().SetQueueDepth(pluginName, len(op.batchStore))
 
 	if !op.batchTimerActive {
 		op.startBatchTimer()
@@ -275,6 +295,11 @@ func (op *obcBatch) sendBatch() error {
 	reqBlock := &RequestBlock{op.batchStore}
 	op.batchStore = nil
 
+	metrics := consensus.GetMetrics()
+	metrics.SetQueueDepth(pluginName, 0)
+	metrics.ObserveBatchFill(pluginName, float64(len(reqBlock.Requests))/float64(op.batchSize))
+	op.batchTimeouts.Sent(len(reqBlock.Requests), op.batchSize)
+
 	reqsPacked, err := proto.Marshal(reqBlock)
 	if err != nil {
 		err = fmt.Errorf("Unable to pack block for new batch request")
@@ -447,8 +472,33 @@ func (op *obcBatch) processEvent(event interface{}) interface{} {
 	return nil
 }
 
+// parameters returns the batch parameters in effect
+func (op *obcBatch) parameters() *Parameters {
+	return &Parameters{
+		BatchSize:    uint64(op.batchSize),
+		BatchTimeout: uint64(op.batchTimeout),
+	}
+}
+
+// applyParameters adjusts the batch parameters, and the custody of
+// requests to the request timeout
+func (op *obcBatch) applyParameters(params *Parameters) {
+	if params.BatchSize != 0 {
+		op.batchSize = int(params.BatchSize)
+	}
+	if params.BatchTimeout != 0 {
+		op.batchTimeout = time.Duration(params.BatchTimeout)
+		min, _ := op.batchTimeouts.Bounds()
+		op.batchTimeouts.SetBounds(min, op.batchTimeout)
+	}
+	if params.RequestTimeout != 0 {
+		timeout := time.Duration(params.RequestTimeout)
+		op.complainer.SetTimeouts(timeout, timeout)
+	}
+}
+
 func (op *obcBatch) startBatchTimer() {
-	op.batchTimer.reset(op.batchTimeout, batchTimerEvent{})
+	op.batchTimer.reset(op.batchTimeouts.Timeout(), batchTimerEvent{})
 	logger.Debug("Replica %d started the batch timer", op.pbft.id)
 	op.batchTimerActive = true
 }
@@ -41,7 +41,10 @@ func TestNetworkBatch(t *testing.T) {
 	batchSize := 2
 	validatorCount := 4
 	net := makeConsumerNetwork(validatorCount, obcBatchHelper, func(ce *consumerEndpoint) {
-		ce.consumer.(*obcBatch).batchSize = batchSize
+		op := ce.consumer.(*obcBatch)
+		op.batchSize = batchSize
+		// the batch is sent once full, not on the adaptive timeout
+		op.batchTimeouts.SetBounds(0, op.batchTimeout)
 	})
 	defer net.stop()
 
@@ -81,6 +84,43 @@ func TestNetworkBatch(t *testing.T) {
 	}
 }
 
+func TestBatchLeaderPolicy(t *testing.T) {
+	validatorCount := 4
+	net := makeConsumerNetwork(validatorCount, obcBatchHelper, func(ce *consumerEndpoint) {
+		op := ce.consumer.(*obcBatch)
+		op.batchSize = 2
+		op.batchTimeouts.SetBounds(0, op.batchTimeout)
+		// only replica 2 has a stake, it must be the primary
+		op.pbft.leaders, _ = consensus.NewLeaderPolicy(consensus.StakeWeightedPolicy, map[uint64]uint64{0: 0, 1: 0, 2: 1, 3: 0})
+	})
+	defer net.stop()
+
+	broadcaster := net.endpoints[generateBroadcaster(validatorCount)].getHandle()
+	net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), broadcaster)
+	net.endpoints[3].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(2), broadcaster)
+	net.process()
+
+	for _, ep := range net.endpoints {
+		ce := ep.(*consumerEndpoint)
+		op := ce.consumer.(*obcBatch)
+		if primary := op.pbft.primary(op.pbft.view); primary != 2 {
+			t.Fatalf("Replica %d expected replica 2 to be the primary, got %d", ce.id, primary)
+		}
+		block, err := op.stack.GetBlock(1)
+		if err != nil {
+			t.Fatalf("Replica %d executed requests, expected a new block on the chain, but could not retrieve it : %s", ce.id, err)
+		}
+		meta := &Metadata{}
+		if err = proto.Unmarshal(block.ConsensusMetadata, meta); err != nil {
+			t.Fatalf("Replica %d committed a block with bad metadata: %s", ce.id, err)
+		}
+		if meta.LeaderPolicy != consensus.StakeWeightedPolicy || meta.Leader != 2 {
+			t.Fatalf("Replica %d recorded leader %d chosen by %q, expected 2 chosen by %q",
+				ce.id, meta.Leader, meta.LeaderPolicy, consensus.StakeWeightedPolicy)
+		}
+	}
+}
+
 func TestBatchCustody(t *testing.T) {
 	t.Skip("test is racy")
 	validatorCount := 4
@@ -197,3 +237,40 @@ func TestBatchStaleCustody(t *testing.T) {
 		t.Error("expected resubmitted request")
 	}
 }
+
+func TestBatchTuneParameters(t *testing.T) {
+	validatorCount := 4
+	net := makeConsumerNetwork(validatorCount, obcBatchHelper, func(ce *consumerEndpoint) {
+		ce.consumer.(*obcBatch).batchSize = 2
+	})
+	defer net.stop()
+
+	op := net.endpoints[1].(*consumerEndpoint).consumer.(*obcBatch)
+	if err := op.SetParameters(&pb.ConsensusParameters{RequestTimeout: "1ms"}); err == nil {
+		t.Fatal("Expected a request timeout this short to be rejected")
+	}
+	if err := op.SetParameters(&pb.ConsensusParameters{BatchSize: 3, RequestTimeout: "5s"}); err != nil {
+		t.Fatalf("Failed to request new parameters: %s", err)
+	}
+	net.process()
+
+	for _, ep := range net.endpoints {
+		ce := ep.(*consumerEndpoint)
+		params, err := ce.consumer.(*obcBatch).GetParameters()
+		if err != nil {
+			t.Fatalf("Replica %d failed to return its parameters: %s", ce.id, err)
+		}
+		if params.BatchSize != 3 || params.RequestTimeout != "5s" || params.BatchTimeout != "2s" {
+			t.Fatalf("Replica %d did not adjust its parameters: %+v", ce.id, params)
+		}
+	}
+
+	// the parameters agreed on survive a restart
+	op.batchSize = 2
+	op.pbft.requestTimeout = time.Second
+	op.pbft.restoreParameters()
+	if op.batchSize != 3 || op.pbft.requestTimeout != 5*time.Second {
+		t.Fatalf("Expected the parameters to be restored, got batch size %d and request timeout %v",
+			op.batchSize, op.pbft.requestTimeout)
+	}
+}
@@ -46,6 +46,7 @@ func newObcClassic(id uint64, config *viper.Viper, stack consensus.Stack) *obcCl
 	logger.Debug("Replica %d obtaining startup information", id)
 
 	op.pbft = legacyPbftShim{newPbftCore(id, config, op)}
+	op.pbft.restoreParameters()
 	op.pbft.manager.start()
 
 	op.idleChan = make(chan struct{})
@@ -84,6 +85,27 @@ func (op *obcClassic) RecvMsg(ocMsg *pb.Message, senderHandle *pb.PeerID) error
 	return nil
 }
 
+// AddValidator votes for adding a validator to the replicas
+func (op *obcClassic) AddValidator(handle *pb.PeerID) error {
+	return reconfigure(op.pbft.manager, Reconfiguration_ADD, handle)
+}
+
+// RemoveValidator votes for removing a validator from the replicas
+func (op *obcClassic) RemoveValidator(handle *pb.PeerID) error {
+	return reconfigure(op.pbft.manager, Reconfiguration_REMOVE, handle)
+}
+
+// GetParameters returns the tunable parameters in effect
+func (op *obcClassic) GetParameters() (*pb.ConsensusParameters, error) {
+	return getParameters(op.pbft.manager)
+}
+
+// SetParameters requests a new request timeout, the only parameter
+// tunable in classic mode
+func (op *obcClassic) SetParameters(params *pb.ConsensusParameters) error {
+	return tune(op.pbft.manager, params)
+}
+
 // Close tells us to release resources we are holding
 func (op *obcClassic) Close() {
 	op.pbft.close()
@@ -127,6 +149,14 @@ func (op *obcClassic) verify(senderID uint64, signature []byte, message []byte)
 	return op.stack.Verify(senderHandle, signature, message)
 }
 
+func (op *obcClassic) getNextTCert() (consensus.SigningCert, error) {
+	return getNextTCert(op.stack)
+}
+
+func (op *obcClassic) verifyWithTCert(cert []byte, signature, message []byte) error {
+	return verifyWithTCert(op.stack, cert, signature, message)
+}
+
 // validate checks whether the request is valid syntactically
 func (op *obcClassic) validate(txRaw []byte) error {
 	tx := &pb.Transaction{}
@@ -143,7 +173,7 @@ func (op *obcClassic) execute(seqNo uint64, txRaw []byte) {
 		return
 	}
 
-	meta, _ := proto.Marshal(&Metadata{seqNo})
+	meta := op.pbft.blockMetadata(seqNo)
 
 	id := []byte("foo")
 	op.stack.BeginTxBatch(id)
@@ -17,6 +17,7 @@ limitations under the License.
 package obcpbft
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -32,6 +33,9 @@ import (
 
 const configPrefix = "CORE_PBFT"
 
+// pluginName labels the measurements reported to the consensus metrics
+const pluginName = "pbft"
+
 var pluginInstance consensus.Consenter // singleton service
 var config *viper.Viper
 
@@ -53,18 +57,32 @@ func New(stack consensus.Stack) consensus.Consenter {
 	handle, _, _ := stack.GetNetworkHandles()
 	id, _ := getValidatorID(handle)
 
-	switch strings.ToLower(config.GetString("general.mode")) {
+	conf := config
+	if inquirer, ok := stack.(consensus.ChainInquirer); ok && inquirer.GetValidatorCount() > 0 {
+		conf = chainConfig(inquirer.GetValidatorCount())
+	}
+
+	switch strings.ToLower(conf.GetString("general.mode")) {
 	case "classic":
-		return newObcClassic(id, config, stack)
+		return newObcClassic(id, conf, stack)
 	case "batch":
-		return newObcBatch(id, config, stack)
+		return newObcBatch(id, conf, stack)
 	case "sieve":
-		return newObcSieve(id, config, stack)
+		return newObcSieve(id, conf, stack)
 	default:
-		panic(fmt.Errorf("Invalid PBFT mode: %s", config.GetString("general.mode")))
+		panic(fmt.Errorf("Invalid PBFT mode: %s", conf.GetString("general.mode")))
 	}
 }
 
+// chainConfig returns the configuration of the PBFT network of a chain with
+// n validators, tolerating as many byzantine faults as they can
+func chainConfig(n int) *viper.Viper {
+	conf := loadConfig()
+	conf.Set("general.N", n)
+	conf.Set("general.f", (n-1)/3)
+	return conf
+}
+
 func loadConfig() (config *viper.Viper) {
 	config = viper.New()
 
@@ -155,3 +173,27 @@ func (op *obcGeneric) getLastSeqNo() (uint64, error) {
 	proto.Unmarshal(raw, meta)
 	return meta.SeqNo, nil
 }
+
+// checkAnchor verifies that the block at the height of the blockchain info
+// id, if the ledger reaches it, has the hash of its current block
+func (op *obcGeneric) checkAnchor(id []byte) error {
+	info := &pb.BlockchainInfo{}
+	if err := proto.Unmarshal(id, info); err != nil {
+		return fmt.Errorf("Could not unmarshal blockchain info: %v", err)
+	}
+	if info.Height == 0 || op.stack.GetBlockchainSize() < info.Height {
+		return nil
+	}
+	block, err := op.stack.GetBlock(info.Height - 1)
+	if err != nil {
+		return err
+	}
+	hash, err := block.GetHash()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash, info.CurrentBlockHash) {
+		return fmt.Errorf("Block %d has hash %x, anchored as %x", info.Height-1, hash, info.CurrentBlockHash)
+	}
+	return nil
+}
@@ -87,7 +87,7 @@ func newObcSieve(id uint64, config *viper.Viper, stack consensus.Stack) *obcSiev
 	op.pbft = legacyPbftShim{newPbftCore(id, config, op)}
 	op.pbft.manager.start()
 	op.complainer = newComplainer(op, op.pbft.requestTimeout, op.pbft.requestTimeout)
-	op.deduplicator = newDeduplicator()
+	op.deduplicator = newDeduplicator(stack, dedupTTL(config))
 
 	op.executeChan = make(chan *pbftExecute)
 	op.incomingChan = make(chan *msgWithSender)
@@ -237,6 +237,14 @@ func (op *obcSieve) verify(senderID uint64, signature []byte, message []byte) er
 	return op.stack.Verify(senderHandle, signature, message)
 }
 
+func (op *obcSieve) getNextTCert() (consensus.SigningCert, error) {
+	return getNextTCert(op.stack)
+}
+
+func (op *obcSieve) verifyWithTCert(cert []byte, signature, message []byte) error {
+	return verifyWithTCert(op.stack, cert, signature, message)
+}
+
 // called by pbft-core to signal when a view change happened
 func (op *obcSieve) viewChange(newView uint64) {
 	logger.Info("Replica %d observing pbft view change to %d", op.id, newView)
@@ -422,7 +430,7 @@ func (op *obcSieve) processExecute() {
 
 	logger.Debug("Sieve replica %d results=%x err=%v using lastPbftExec of %d", op.id, results, err, op.lastExecPbftSeqNo)
 
-	meta, _ := proto.Marshal(&Metadata{op.lastExecPbftSeqNo})
+	meta := op.pbft.blockMetadata(op.lastExecPbftSeqNo)
 	op.currentResult, err = op.stack.PreviewCommitTxBatch(op.currentReq, meta)
 	if err != nil {
 		logger.Error("could not preview next block: %s", err)
@@ -835,7 +843,7 @@ func (op *obcSieve) rollback() {
 }
 
 func (op *obcSieve) commit() {
-	meta, _ := proto.Marshal(&Metadata{op.lastExecPbftSeqNo})
+	meta := op.pbft.blockMetadata(op.lastExecPbftSeqNo)
 	op.stack.CommitTxBatch(op.currentReq, meta)
 	op.currentReq = ""
 }
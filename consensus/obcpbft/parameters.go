@@ -0,0 +1,142 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	google_protobuf "google/protobuf"
+)
+
+// --------------------------------------------------------------
+//
+// The batch size, the batch timeout and the request timeout may be
+// adjusted while the network runs. New values requested through a
+// replica are ordered like any other request, and every replica adjusts
+// its parameters when the request executes, so that they all agree on
+// the values in effect from then on. The values agreed on are persisted
+// and survive a restart; a replica catching up by state transfer keeps
+// its own until the next adjustment.
+//
+// --------------------------------------------------------------
+
+// minTunedTimeout is the shortest batch or request timeout accepted
+const minTunedTimeout = 10 * time.Millisecond
+
+// tunable is implemented by the consumers of pbftCore with tunable
+// parameters of their own
+type tunable interface {
+	parameters() *Parameters            // returns its parameters in effect
+	applyParameters(params *Parameters) // adjusts its parameters set in params
+}
+
+// parameters returns the tunable parameters in effect
+func (instance *pbftCore) parameters() *Parameters {
+	params := &Parameters{}
+	if t, ok := instance.consumer.(tunable); ok {
+		params = t.parameters()
+	}
+	params.RequestTimeout = uint64(instance.requestTimeout)
+	return params
+}
+
+// checkParameters verifies that the values of a request for new
+// parameters are acceptable
+func checkParameters(params *Parameters) error {
+	if params.BatchSize == 0 && params.BatchTimeout == 0 && params.RequestTimeout == 0 {
+		return fmt.Errorf("No parameter to adjust")
+	}
+	if params.BatchTimeout != 0 && time.Duration(params.BatchTimeout) < minTunedTimeout {
+		return fmt.Errorf("Batch timeout %v is shorter than %v", time.Duration(params.BatchTimeout), minTunedTimeout)
+	}
+	if params.RequestTimeout != 0 && time.Duration(params.RequestTimeout) < minTunedTimeout {
+		return fmt.Errorf("Request timeout %v is shorter than %v", time.Duration(params.RequestTimeout), minTunedTimeout)
+	}
+	return nil
+}
+
+// tune requests new values of the parameters through this replica
+func (instance *pbftCore) tune(params *Parameters) error {
+	if err := checkParameters(params); err != nil {
+		return err
+	}
+	current := instance.parameters()
+	if params.BatchSize != 0 && current.BatchSize == 0 || params.BatchTimeout != 0 && current.BatchTimeout == 0 {
+		return fmt.Errorf("Batch parameters cannot be adjusted in this mode")
+	}
+
+	now := time.Now()
+	req := &Request{
+		Timestamp: &google_protobuf.Timestamp{
+			Seconds: now.Unix(),
+			Nanos:   int32(now.UnixNano() % 1000000000),
+		},
+		ReplicaId:  instance.id,
+		Parameters: params,
+	}
+
+	logger.Info("Replica %d requesting new parameters: %+v", instance.id, params)
+
+	instance.innerBroadcast(&Message{&Message_Request{req}})
+	return instance.recvRequest(req)
+}
+
+// executeParameters adjusts the parameters to the values of a request
+// executed at n
+func (instance *pbftCore) executeParameters(n uint64, params *Parameters) {
+	instance.applyParameters(params)
+	instance.persistParameters()
+
+	logger.Info("Replica %d agreed at seqNo %d on new parameters: %+v", instance.id, n, instance.parameters())
+}
+
+func (instance *pbftCore) applyParameters(params *Parameters) {
+	if params.RequestTimeout != 0 {
+		instance.requestTimeout = time.Duration(params.RequestTimeout)
+	}
+	if t, ok := instance.consumer.(tunable); ok {
+		t.applyParameters(params)
+	}
+}
+
+func (instance *pbftCore) persistParameters() {
+	raw, err := proto.Marshal(instance.parameters())
+	if err != nil {
+		logger.Warning("Replica %d could not persist parameters: %s", instance.id, err)
+		return
+	}
+	instance.consumer.StoreState("parameters", raw)
+}
+
+// restoreParameters adjusts the parameters to the values last agreed
+// on, once the consumer has read its own from the configuration
+func (instance *pbftCore) restoreParameters() {
+	raw, err := instance.consumer.ReadState("parameters")
+	if err != nil {
+		logger.Debug("Replica %d found no parameters to restore: %s", instance.id, err)
+		return
+	}
+	params := &Parameters{}
+	if err = proto.Unmarshal(raw, params); err != nil {
+		logger.Error("Replica %d could not unmarshal parameters - local state is damaged: %s", instance.id, err)
+		return
+	}
+	instance.applyParameters(params)
+	logger.Info("Replica %d restored parameters: %+v", instance.id, params)
+}
@@ -119,14 +119,16 @@ type pbftCore struct {
 	skipInProgress bool              // Set when we have detected a fall behind scenario until we pick a new starting point
 	hChkpts        map[uint64]uint64 // highest checkpoint sequence number observed for each replica
 
-	currentExec        *uint64             // currently executing request
-	timerActive        bool                // is the timer running?
-	newViewTimer       eventTimer          // timeout triggering a view change
-	manager            eventManager        // TODO, remove eventually, the event manager which sends events to pbft
-	requestTimeout     time.Duration       // progress timeout for requests
-	newViewTimeout     time.Duration       // progress timeout for new views
-	lastNewViewTimeout time.Duration       // last timeout we used during this view change
-	outstandingReqs    map[string]*Request // track whether we are waiting for requests to execute
+	currentExec        *uint64              // currently executing request
+	timerActive        bool                 // is the timer running?
+	newViewTimer       eventTimer           // timeout triggering a view change
+	manager            eventManager         // TODO, remove eventually, the event manager which sends events to pbft
+	requestTimeout     time.Duration        // progress timeout for requests
+	newViewTimeout     time.Duration        // progress timeout for new views
+	lastNewViewTimeout time.Duration        // last timeout we used during this view change
+	outstandingReqs    map[string]*Request  // track whether we are waiting for requests to execute
+	received           map[string]time.Time // when outstanding requests were received, to measure their commit latency
+	viewChangeStart    time.Time            // when the view change in progress started
 
 	missingReqs map[string]bool // for all the assigned, non-checkpointed requests we might be missing during view-change
 
@@ -136,6 +138,24 @@ type pbftCore struct {
 	checkpointStore map[Checkpoint]bool   // track checkpoints as set
 	viewChangeStore map[vcidx]*ViewChange // track view-change messages
 	newViewStore    map[uint64]*NewView   // track last new-view we received or sent
+
+	// write-ahead log of certStore
+	walIndex   uint64            // index of the next record
+	walRecords map[uint64]uint64 // sequence number of each record, by index
+
+	tcerts *tcertSigner // signs with TCerts in place of the enrollment key, if enabled
+
+	leaders    consensus.LeaderPolicy // chooses the primary of each view
+	anchors    *anchorer              // publishes the stable checkpoints to a notary, if enabled
+	execLeader uint64                 // primary which ordered the request executing
+
+	// reconfiguration
+	epoch             uint64                                    // membership in effect
+	initialF          int                                       // faults tolerated by the initial membership
+	memberships       []*Membership                             // memberships agreed on, by epoch
+	votes             map[reconfidx]map[uint64]*Reconfiguration // votes for changes of the latest membership, by voter
+	deferred          []pbftMessageEvent                        // messages held back until their membership takes effect
+	fetchedMembership map[uint64]uint64                         // latest membership fetched from each replica
 }
 
 type qidx struct {
@@ -178,6 +198,26 @@ func (a sortableUint64Slice) Less(i, j int) bool {
 	return a[i] < a[j]
 }
 
+// requestOrder sorts the digests of requests by timestamp, then digest
+type requestOrder struct {
+	digests []string
+	reqs    map[string]*Request
+}
+
+func (o requestOrder) Len() int {
+	return len(o.digests)
+}
+func (o requestOrder) Swap(i, j int) {
+	o.digests[i], o.digests[j] = o.digests[j], o.digests[i]
+}
+func (o requestOrder) Less(i, j int) bool {
+	ti, tj := o.reqs[o.digests[i]].Timestamp, o.reqs[o.digests[j]].Timestamp
+	if ti != nil && tj != nil && (ti.Seconds != tj.Seconds || ti.Nanos != tj.Nanos) {
+		return ti.Seconds < tj.Seconds || (ti.Seconds == tj.Seconds && ti.Nanos < tj.Nanos)
+	}
+	return o.digests[i] < o.digests[j]
+}
+
 // =============================================================================
 // constructors
 // =============================================================================
@@ -226,8 +266,39 @@ func newPbftCore(id uint64, config *viper.Viper, consumer innerStack) *pbftCore
 		panic(fmt.Errorf("Cannot parse new view timeout: %s", err))
 	}
 
+	if config.GetBool("general.tcerts.enabled") {
+		period, err := time.ParseDuration(config.GetString("general.tcerts.period"))
+		if err != nil {
+			panic(fmt.Errorf("Cannot parse TCert period: %s", err))
+		}
+		if instance.tcerts, err = newTCertSigner(id, consumer, period); err != nil {
+			panic(err)
+		}
+	}
+
+	stakes, err := consensus.ParseStakes(config.GetStringMapString("general.leader.stakes"))
+	if err != nil {
+		panic(fmt.Errorf("Cannot parse stakes: %s", err))
+	}
+	if instance.leaders, err = consensus.NewLeaderPolicy(config.GetString("general.leader.policy"), stakes); err != nil {
+		panic(err)
+	}
+
+	if url := config.GetString("general.anchor.url"); url != "" {
+		period := uint64(config.GetInt("general.anchor.period"))
+		if period == 0 || period%instance.K != 0 {
+			panic(fmt.Errorf("Anchor period (%d) must be a positive multiple of the checkpoint period (%d)", period, instance.K))
+		}
+		timeout, err := time.ParseDuration(config.GetString("general.anchor.timeout"))
+		if err != nil {
+			panic(fmt.Errorf("Cannot parse anchor timeout: %s", err))
+		}
+		instance.anchors = newAnchorer(period, newHTTPNotary(url, timeout))
+	}
+
 	instance.activeView = true
 	instance.replicaCount = instance.N
+	instance.initMembership(instance.N, instance.f)
 
 	logger.Info("PBFT type = %T", instance.consumer)
 	logger.Info("PBFT Max number of validating peers (N) = %v", instance.N)
@@ -238,6 +309,11 @@ func newPbftCore(id uint64, config *viper.Viper, consumer innerStack) *pbftCore
 	logger.Info("PBFT Checkpoint period (K) = %v", instance.K)
 	logger.Info("PBFT Log multiplier = %v", instance.logMultiplier)
 	logger.Info("PBFT log size (L) = %v", instance.L)
+	logger.Info("PBFT signing with TCerts = %v", instance.tcerts != nil)
+	logger.Info("PBFT leader policy = %v", instance.leaders.Name())
+	if instance.anchors != nil {
+		logger.Info("PBFT anchor period = %v", instance.anchors.period)
+	}
 
 	// init the logs
 	instance.certStore = make(map[msgID]*msgCert)
@@ -248,6 +324,7 @@ func newPbftCore(id uint64, config *viper.Viper, consumer innerStack) *pbftCore
 	instance.pset = make(map[uint64]*ViewChange_PQ)
 	instance.qset = make(map[qidx]*ViewChange_PQ)
 	instance.newViewStore = make(map[uint64]*NewView)
+	instance.walRecords = make(map[uint64]uint64)
 
 	// initialize state transfer
 	instance.hChkpts = make(map[uint64]uint64)
@@ -256,10 +333,15 @@ func newPbftCore(id uint64, config *viper.Viper, consumer innerStack) *pbftCore
 
 	instance.lastNewViewTimeout = instance.newViewTimeout
 	instance.outstandingReqs = make(map[string]*Request)
+	instance.received = make(map[string]time.Time)
 	instance.missingReqs = make(map[string]bool)
 
 	instance.restoreState()
 
+	if err := instance.checkAnchors(); err != nil {
+		panic(err)
+	}
+
 	return instance
 }
 
@@ -309,6 +391,20 @@ func (instance *pbftCore) processEvent(e interface{}) interface{} {
 		err = instance.recvFetchRequest(et)
 	case returnRequestEvent:
 		err = instance.recvReturnRequest(et)
+	case *Reconfiguration:
+		err = instance.recvReconfiguration(et)
+	case *FetchMembership:
+		err = instance.recvFetchMembership(et)
+	case *MembershipHistory:
+		err = instance.recvMembershipHistory(et)
+	case *AnchorSignature:
+		err = instance.recvAnchorSignature(et)
+	case reconfigureEvent:
+		et.result <- instance.reconfigure(et.action, et.validator)
+	case tuneEvent:
+		et.result <- instance.tune(et.params)
+	case parametersEvent:
+		et <- instance.parameters()
 	case stateUpdatingEvent:
 		update := et
 		instance.skipInProgress = true
@@ -322,6 +418,7 @@ func (instance *pbftCore) processEvent(e interface{}) interface{} {
 		instance.lastExec = seqNo
 		instance.moveWatermarks(instance.lastExec) // The watermark movement handles moving this to a checkpoint boundary
 		instance.skipInProgress = false
+		instance.activateMembership()
 		instance.consumer.validateState()
 		instance.executeOutstanding()
 	case execDoneEvent:
@@ -352,7 +449,19 @@ func (instance *pbftCore) inject(work func()) {
 
 // Given a certain view n, what is the expected primary?
 func (instance *pbftCore) primary(n uint64) uint64 {
-	return n % uint64(instance.replicaCount)
+	// every view change is a failure of the primary
+	return instance.leaders.Leader(0, n, instance.membership().Replicas)
+}
+
+// blockMetadata returns the metadata of the block committing the request
+// executing, ordered at seqNo
+func (instance *pbftCore) blockMetadata(seqNo uint64) []byte {
+	meta, _ := proto.Marshal(&Metadata{
+		SeqNo:        seqNo,
+		LeaderPolicy: instance.leaders.Name(),
+		Leader:       instance.execLeader,
+	})
+	return meta
 }
 
 // Is the sequence number between watermarks?
@@ -510,32 +619,32 @@ func (instance *pbftCore) recvMsg(msg *Message, senderID uint64) (interface{}, e
 		if senderID != preprep.ReplicaId {
 			return nil, fmt.Errorf("Sender ID included in pre-prepare message (%v) doesn't match ID corresponding to the receiving stream (%v)", preprep.ReplicaId, senderID)
 		}
-		return preprep, nil
+		return instance.screen(preprep.Epoch, senderID, msg, preprep)
 	} else if prep := msg.GetPrepare(); prep != nil {
 		if senderID != prep.ReplicaId {
 			return nil, fmt.Errorf("Sender ID included in prepare message (%v) doesn't match ID corresponding to the receiving stream (%v)", prep.ReplicaId, senderID)
 		}
-		return prep, nil
+		return instance.screen(prep.Epoch, senderID, msg, prep)
 	} else if commit := msg.GetCommit(); commit != nil {
 		if senderID != commit.ReplicaId {
 			return nil, fmt.Errorf("Sender ID included in commit message (%v) doesn't match ID corresponding to the receiving stream (%v)", commit.ReplicaId, senderID)
 		}
-		return commit, nil
+		return instance.screen(commit.Epoch, senderID, msg, commit)
 	} else if chkpt := msg.GetCheckpoint(); chkpt != nil {
 		if senderID != chkpt.ReplicaId {
 			return nil, fmt.Errorf("Sender ID included in checkpoint message (%v) doesn't match ID corresponding to the receiving stream (%v)", chkpt.ReplicaId, senderID)
 		}
-		return chkpt, nil
+		return instance.screenCheckpoint(chkpt)
 	} else if vc := msg.GetViewChange(); vc != nil {
 		if senderID != vc.ReplicaId {
 			return nil, fmt.Errorf("Sender ID included in view-change message (%v) doesn't match ID corresponding to the receiving stream (%v)", vc.ReplicaId, senderID)
 		}
-		return vc, nil
+		return instance.screen(vc.Epoch, senderID, msg, vc)
 	} else if nv := msg.GetNewView(); nv != nil {
 		if senderID != nv.ReplicaId {
 			return nil, fmt.Errorf("Sender ID included in new-view message (%v) doesn't match ID corresponding to the receiving stream (%v)", nv.ReplicaId, senderID)
 		}
-		return nv, nil
+		return instance.screen(nv.Epoch, senderID, msg, nv)
 	} else if fr := msg.GetFetchRequest(); fr != nil {
 		if senderID != fr.ReplicaId {
 			return nil, fmt.Errorf("Sender ID included in fetch-request message (%v) doesn't match ID corresponding to the receiving stream (%v)", fr.ReplicaId, senderID)
@@ -544,6 +653,26 @@ func (instance *pbftCore) recvMsg(msg *Message, senderID uint64) (interface{}, e
 	} else if req := msg.GetReturnRequest(); req != nil {
 		// it's ok for sender ID and replica ID to differ; we're sending the original request message
 		return returnRequestEvent(req), nil
+	} else if vote := msg.GetReconfiguration(); vote != nil {
+		if senderID != vote.ReplicaId {
+			return nil, fmt.Errorf("Sender ID included in reconfiguration message (%v) doesn't match ID corresponding to the receiving stream (%v)", vote.ReplicaId, senderID)
+		}
+		return vote, nil
+	} else if fm := msg.GetFetchMembership(); fm != nil {
+		if senderID != fm.ReplicaId {
+			return nil, fmt.Errorf("Sender ID included in fetch-membership message (%v) doesn't match ID corresponding to the receiving stream (%v)", fm.ReplicaId, senderID)
+		}
+		return fm, nil
+	} else if mh := msg.GetMembershipHistory(); mh != nil {
+		if senderID != mh.ReplicaId {
+			return nil, fmt.Errorf("Sender ID included in membership-history message (%v) doesn't match ID corresponding to the receiving stream (%v)", mh.ReplicaId, senderID)
+		}
+		return mh, nil
+	} else if sig := msg.GetAnchorSignature(); sig != nil {
+		if senderID != sig.ReplicaId {
+			return nil, fmt.Errorf("Sender ID included in anchor-signature message (%v) doesn't match ID corresponding to the receiving stream (%v)", sig.ReplicaId, senderID)
+		}
+		return sig, nil
 	}
 
 	return nil, fmt.Errorf("Invalid message: %v", msg)
@@ -553,13 +682,14 @@ func (instance *pbftCore) recvRequest(req *Request) error {
 	digest := hashReq(req)
 	logger.Debug("Replica %d received request: %s", instance.id, digest)
 
-	if err := instance.consumer.validate(req.Payload); err != nil {
+	if err := instance.validateRequest(req); err != nil {
 		logger.Warning("Request %s did not verify: %s", digest, err)
 		return err
 	}
 
 	instance.reqStore[digest] = req
 	instance.outstandingReqs[digest] = req
+	instance.received[digest] = time.Now()
 	instance.persistRequest(digest)
 	if instance.activeView {
 		instance.softStartTimer(instance.requestTimeout, fmt.Sprintf("new request %s", digest))
@@ -580,6 +710,12 @@ func (instance *pbftCore) recvRequest(req *Request) error {
 			}
 		}
 
+		// The sequence numbers after the checkpoint a new membership takes effect at are assigned by it
+		if pending := instance.pendingMembership(); pending != nil && n > pending.SequenceNumber {
+			logger.Debug("Replica %d is primary, not sending pre-prepare for request %s until membership %d takes effect at seqNo %d", instance.id, digest, pending.Epoch, pending.SequenceNumber)
+			return nil
+		}
+
 		// If we are the primary, have not already processed this request, and are within the first half of the log
 		if instance.inWV(instance.view, n) && !haveOther && n <= instance.h+instance.L/2 {
 			logger.Debug("Primary %d broadcasting pre-prepare for view=%d/seqNo=%d and digest %s",
@@ -591,10 +727,12 @@ func (instance *pbftCore) recvRequest(req *Request) error {
 				RequestDigest:  digest,
 				Request:        req,
 				ReplicaId:      instance.id,
+				Epoch:          instance.epoch,
 			}
 			cert := instance.getCert(instance.view, n)
 			cert.prePrepare = preprep
 			cert.digest = digest
+			instance.walAppend(&Message{&Message_PrePrepare{preprep}})
 			instance.persistQSet()
 
 			instance.innerBroadcast(&Message{&Message_PrePrepare{preprep}})
@@ -614,8 +752,17 @@ func (instance *pbftCore) resubmitRequests() {
 		return
 	}
 
+	// resubmit in the order the requests were issued, so that the primary
+	// assigns them the same sequence numbers however the map is iterated
+	var digests []string
+	for d := range instance.outstandingReqs {
+		digests = append(digests, d)
+	}
+	sort.Sort(requestOrder{digests, instance.outstandingReqs})
+
 outer:
-	for d, req := range instance.outstandingReqs {
+	for _, d := range digests {
+		req := instance.outstandingReqs[d]
 		for _, cert := range instance.certStore {
 			if cert.digest == d {
 				logger.Debug("Replica %d already has certificate for request %s not going to resubmit", instance.id, d)
@@ -673,7 +820,7 @@ func (instance *pbftCore) recvPrePrepare(preprep *PrePrepare) error {
 				digest, preprep.RequestDigest)
 			return nil
 		}
-		if err := instance.consumer.validate(preprep.Request.Payload); err != nil {
+		if err := instance.validateRequest(preprep.Request); err != nil {
 			logger.Warning("Request %s did not verify: %s", digest, err)
 			return err
 		}
@@ -681,8 +828,10 @@ func (instance *pbftCore) recvPrePrepare(preprep *PrePrepare) error {
 		instance.reqStore[digest] = preprep.Request
 		logger.Debug("Replica %d storing request %s in outstanding request store", instance.id, digest)
 		instance.outstandingReqs[digest] = preprep.Request
+		instance.received[digest] = time.Now()
 		instance.persistRequest(digest)
 	}
+	instance.walAppend(&Message{&Message_PrePrepare{preprep}})
 
 	instance.softStartTimer(instance.requestTimeout, fmt.Sprintf("new pre-prepare for %s", preprep.RequestDigest))
 
@@ -695,6 +844,7 @@ func (instance *pbftCore) recvPrePrepare(preprep *PrePrepare) error {
 			SequenceNumber: preprep.SequenceNumber,
 			RequestDigest:  preprep.RequestDigest,
 			ReplicaId:      instance.id,
+			Epoch:          instance.epoch,
 		}
 
 		cert.sentPrepare = true
@@ -734,6 +884,7 @@ func (instance *pbftCore) recvPrepare(prep *Prepare) error {
 		}
 	}
 	cert.prepare = append(cert.prepare, prep)
+	instance.walAppend(&Message{&Message_Prepare{prep}})
 	instance.persistPSet()
 
 	return instance.maybeSendCommit(prep.RequestDigest, prep.View, prep.SequenceNumber)
@@ -751,6 +902,7 @@ func (instance *pbftCore) maybeSendCommit(digest string, v uint64, n uint64) err
 			SequenceNumber: n,
 			RequestDigest:  digest,
 			ReplicaId:      instance.id,
+			Epoch:          instance.epoch,
 		}
 
 		cert.sentCommit = true
@@ -784,11 +936,16 @@ func (instance *pbftCore) recvCommit(commit *Commit) error {
 		}
 	}
 	cert.commit = append(cert.commit, commit)
+	instance.walAppend(&Message{&Message_Commit{commit}})
 
 	if instance.committed(commit.RequestDigest, commit.View, commit.SequenceNumber) {
 		instance.stopTimer()
 		instance.lastNewViewTimeout = instance.newViewTimeout
 		delete(instance.outstandingReqs, commit.RequestDigest)
+		if received, ok := instance.received[commit.RequestDigest]; ok {
+			consensus.GetMetrics().ObserveCommitLatency(pluginName, time.Since(received))
+			delete(instance.received, commit.RequestDigest)
+		}
 		instance.startTimerIfOutstandingRequests()
 
 		instance.executeOutstanding()
@@ -839,12 +996,23 @@ func (instance *pbftCore) executeOne(idx msgID) bool {
 	// we have a commit certificate for this request
 	currentExec := idx.n
 	instance.currentExec = &currentExec
+	instance.execLeader = instance.primary(idx.v)
 
 	// null request
 	if digest == "" {
 		logger.Info("Replica %d executing/committing null request for view=%d/seqNo=%d",
 			instance.id, idx.v, idx.n)
 		instance.execDoneSync()
+	} else if len(req.Reconfiguration) > 0 {
+		logger.Info("Replica %d executing/committing reconfiguration for view=%d/seqNo=%d and digest %s",
+			instance.id, idx.v, idx.n, digest)
+		instance.executeReconfiguration(idx.n, req.Reconfiguration)
+		instance.execDoneSync()
+	} else if req.Parameters != nil {
+		logger.Info("Replica %d executing/committing new parameters for view=%d/seqNo=%d and digest %s",
+			instance.id, idx.v, idx.n, digest)
+		instance.executeParameters(idx.n, req.Parameters)
+		instance.execDoneSync()
 	} else {
 		logger.Info("Replica %d executing/committing request for view=%d/seqNo=%d and digest %s",
 			instance.id, idx.v, idx.n, digest)
@@ -872,6 +1040,7 @@ func (instance *pbftCore) Checkpoint(seqNo uint64, id []byte) {
 		SequenceNumber: seqNo,
 		ReplicaId:      instance.id,
 		Id:             idAsString,
+		Epoch:          instance.epoch,
 	}
 	instance.chkpts[seqNo] = idAsString
 
@@ -889,6 +1058,7 @@ func (instance *pbftCore) execDoneSync() {
 	if instance.currentExec != nil {
 		logger.Info("Replica %d finished execution %d, trying next", instance.id, *instance.currentExec)
 		instance.lastExec = *instance.currentExec
+		instance.activateMembership()
 		if instance.lastExec%instance.K == 0 {
 			instance.Checkpoint(instance.lastExec, instance.consumer.getState())
 		}
@@ -913,6 +1083,7 @@ func (instance *pbftCore) moveWatermarks(n uint64) {
 				instance.id, idx.v, idx.n)
 			instance.persistDelRequest(cert.digest)
 			delete(instance.reqStore, cert.digest)
+			delete(instance.received, cert.digest)
 			delete(instance.certStore, idx)
 		}
 	}
@@ -944,6 +1115,8 @@ func (instance *pbftCore) moveWatermarks(n uint64) {
 		}
 	}
 
+	instance.walTruncate(h)
+
 	instance.h = h
 
 	logger.Debug("Replica %d updated low watermark to %d",
@@ -988,6 +1161,7 @@ func (instance *pbftCore) weakCheckpointSetOutOfRange(chkpt *Checkpoint) bool {
 				instance.persistDelAllRequests()
 				instance.moveWatermarks(m)
 				instance.outstandingReqs = make(map[string]*Request)
+				instance.received = make(map[string]time.Time)
 				instance.skipInProgress = true
 				instance.consumer.invalidateState()
 				instance.stopTimer()
@@ -1085,6 +1259,7 @@ func (instance *pbftCore) recvCheckpoint(chkpt *Checkpoint) error {
 		instance.id, chkpt.SequenceNumber, chkpt.Id)
 
 	instance.moveWatermarks(chkpt.SequenceNumber)
+	instance.anchorCheckpoint(chkpt.SequenceNumber, chkpt.Id)
 
 	return instance.processNewView()
 }
@@ -1160,11 +1335,11 @@ func (instance *pbftCore) innerBroadcast(msg *Message) error {
 	if doByzantine {
 		rand2 := rand.New(rand.NewSource(time.Now().UnixNano()))
 		ignoreidx := rand2.Intn(instance.N)
-		for i := 0; i < instance.N; i++ {
-			if i != ignoreidx && uint64(i) != instance.id { //Pick a random replica and do not send message
-				instance.consumer.unicast(msgRaw, uint64(i))
+		for i, id := range instance.membership().Replicas {
+			if i != ignoreidx && id != instance.id { //Pick a random replica and do not send message
+				instance.consumer.unicast(msgRaw, id)
 			} else {
-				logger.Debug("PBFT byzantine: not broadcasting to replica %v", i)
+				logger.Debug("PBFT byzantine: not broadcasting to replica %v", id)
 			}
 		}
 	} else {
@@ -1173,6 +1348,15 @@ func (instance *pbftCore) innerBroadcast(msg *Message) error {
 	return nil
 }
 
+// Marshals a Message and hands it to the Stack for a single replica
+func (instance *pbftCore) innerUnicast(msg *Message, receiverID uint64) error {
+	msgRaw, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("[innerUnicast] Cannot marshal message: %s", err)
+	}
+	return instance.consumer.unicast(msgRaw, receiverID)
+}
+
 func (instance *pbftCore) startTimerIfOutstandingRequests() {
 
 	if len(instance.outstandingReqs) > 0 {
@@ -153,6 +153,7 @@ func makePBFTNetwork(N int, initFNs ...func(pe *pbftEndpoint)) *pbftNetwork {
 		pe.pbft = newPbftCore(id, loadConfig(), pe.sc)
 		pe.pbft.N = N
 		pe.pbft.f = (N - 1) / 3
+		pe.pbft.initMembership(N, pe.pbft.f)
 
 		for _, fn := range initFNs {
 			fn(pe)
@@ -29,6 +29,7 @@ import (
 	"github.com/golang/protobuf/proto"
 	"github.com/op/go-logging"
 
+	"github.com/hyperledger/fabric/consensus"
 	pb "github.com/hyperledger/fabric/protos"
 )
 
@@ -36,6 +37,15 @@ func init() {
 	logging.SetLevel(logging.DEBUG, "")
 }
 
+func TestChainConfig(t *testing.T) {
+	for _, c := range []struct{ n, f int }{{4, 1}, {6, 1}, {7, 2}} {
+		config := chainConfig(c.n)
+		if config.GetInt("general.N") != c.n || config.GetInt("general.f") != c.f {
+			t.Errorf("Got N=%d f=%d for a chain of %d validators, expected f=%d", config.GetInt("general.N"), config.GetInt("general.f"), c.n, c.f)
+		}
+	}
+}
+
 func TestEnvOverride(t *testing.T) {
 	config := loadConfig()
 
@@ -1291,7 +1301,490 @@ func TestReplicaPersistDelete(t *testing.T) {
 	}
 }
 
+func TestReplicaRestoreWAL(t *testing.T) {
+	persist := make(map[string][]byte)
+
+	stack := &omniProto{
+		validateImpl: func(b []byte) error {
+			return nil
+		},
+		broadcastImpl: func(msg []byte) {
+		},
+		executeImpl: func(seqNo uint64, txRaw []byte) {
+		},
+		StoreStateImpl: func(key string, value []byte) error {
+			persist[key] = value
+			return nil
+		},
+		DelStateImpl: func(key string) {
+			delete(persist, key)
+		},
+		ReadStateImpl: func(key string) ([]byte, error) {
+			if val, ok := persist[key]; ok {
+				return val, nil
+			}
+			return nil, fmt.Errorf("key not found")
+		},
+		ReadStateSetImpl: func(prefix string) (map[string][]byte, error) {
+			r := make(map[string][]byte)
+			for k, v := range persist {
+				if strings.HasPrefix(k, prefix) {
+					r[k] = v
+				}
+			}
+			return r, nil
+		},
+	}
+	p := newPbftCore(1, loadConfig(), stack)
+	req := &Request{
+		Timestamp: &gp.Timestamp{Seconds: 1, Nanos: 0},
+		Payload:   []byte("foo"),
+		ReplicaId: uint64(0),
+	}
+	digest := hashReq(req)
+	sendEvent(p, &PrePrepare{View: 0, SequenceNumber: 1, RequestDigest: digest, Request: req, ReplicaId: 0})
+	sendEvent(p, &Prepare{View: 0, SequenceNumber: 1, RequestDigest: digest, ReplicaId: 2})
+	sendEvent(p, &Commit{View: 0, SequenceNumber: 1, RequestDigest: digest, ReplicaId: 0})
+	p.close()
+
+	// the replica crashed prepared, before the request committed
+	p = newPbftCore(1, loadConfig(), stack)
+	cert := p.certStore[msgID{0, 1}]
+	if cert == nil || cert.prePrepare == nil || !cert.sentPrepare || !cert.sentCommit || len(cert.prepare) != 2 || len(cert.commit) != 2 {
+		t.Fatalf("Expected the certificate to be restored, got %+v", cert)
+	}
+	if p.seqNo != 1 {
+		t.Errorf("Expected seqNo 1 to be restored, got %d", p.seqNo)
+	}
+	sendEvent(p, &Commit{View: 0, SequenceNumber: 1, RequestDigest: digest, ReplicaId: 2})
+	if !p.committed(digest, 0, 1) {
+		t.Errorf("Expected the request to commit with the commits received before the crash")
+	}
+
+	p.moveWatermarks(p.K)
+	for key := range persist {
+		if strings.HasPrefix(key, "wal.") {
+			t.Errorf("Expected the write-ahead log to be truncated, found %s", key)
+		}
+	}
+	p.close()
+}
+
 func TestNilCurrentExec(t *testing.T) {
 	p := newPbftCore(1, loadConfig(), &omniProto{})
 	p.execDoneSync() // Per issue 1538, this would cause a Nil pointer dereference
 }
+
+// recordingMetrics keeps the measurements of the consensus plugins
+type recordingMetrics struct {
+	sync.Mutex
+	view           uint64
+	viewChanges    int
+	viewChangeDone int
+	batchFills     []float64
+	commits        int
+}
+
+func (m *recordingMetrics) SetView(plugin string, view uint64) {
+	m.Lock()
+	defer m.Unlock()
+	m.view = view
+}
+
+func (m *recordingMetrics) IncViewChanges(plugin string) {
+	m.Lock()
+	defer m.Unlock()
+	m.viewChanges++
+}
+
+func (m *recordingMetrics) ObserveViewChange(plugin string, duration time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.viewChangeDone++
+}
+
+func (m *recordingMetrics) SetQueueDepth(plugin string, depth int) {}
+
+func (m *recordingMetrics) ObserveBatchFill(plugin string, ratio float64) {
+	m.Lock()
+	defer m.Unlock()
+	m.batchFills = append(m.batchFills, ratio)
+}
+
+func (m *recordingMetrics) ObserveCommitLatency(plugin string, latency time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.commits++
+}
+
+func (m *recordingMetrics) SetDedupEntries(plugin string, entries int) {}
+
+func (m *recordingMetrics) IncDuplicates(plugin string) {}
+
+func TestMetrics(t *testing.T) {
+	metrics := &recordingMetrics{}
+	consensus.SetMetrics(metrics)
+	defer consensus.SetMetrics(nil)
+
+	validatorCount := 4
+	net := makePBFTNetwork(validatorCount)
+	defer net.stop()
+
+	execReconfiguredNetwork(t, net, 1)
+	if metrics.commits != validatorCount {
+		t.Fatalf("Expected the commit latency of the request on each replica, got %d", metrics.commits)
+	}
+
+	for i := 0; i < validatorCount; i++ {
+		net.pbftEndpoints[i].pbft.sendViewChange()
+	}
+	if err := net.process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	if metrics.viewChanges != validatorCount || metrics.viewChangeDone != validatorCount {
+		t.Fatalf("Expected %d view changes started and completed, got %d and %d",
+			validatorCount, metrics.viewChanges, metrics.viewChangeDone)
+	}
+	if metrics.view != 1 {
+		t.Fatalf("Expected view 1 reported, got %d", metrics.view)
+	}
+}
+
+func reconfigureNetwork(t *testing.T, net *pbftNetwork, action Reconfiguration_Action, validator uint64, voters ...int) {
+	for _, id := range voters {
+		result := make(chan error, 1)
+		net.pbftEndpoints[id].pbft.manager.queue() <- reconfigureEvent{action: action, validator: validator, result: result}
+		if err := <-result; err != nil {
+			t.Fatalf("Replica %d failed to vote: %s", id, err)
+		}
+	}
+	if err := net.process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+}
+
+func execReconfiguredNetwork(t *testing.T, net *pbftNetwork, iter int64) {
+	txTime := &gp.Timestamp{Seconds: iter, Nanos: 0}
+	tx := &pb.Transaction{Type: pb.Transaction_CHAINCODE_DEPLOY, Timestamp: txTime}
+	txPacked, err := proto.Marshal(tx)
+	if err != nil {
+		t.Fatalf("Failed to marshal TX block: %s", err)
+	}
+	msg := &Message{&Message_Request{&Request{Timestamp: txTime, Payload: txPacked, ReplicaId: 0}}}
+	net.pbftEndpoints[0].pbft.manager.queue() <- pbftMessageEvent{msg: msg, sender: 0}
+	if err := net.process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+}
+
+func TestReconfigurationRemoveReplica(t *testing.T) {
+	validatorCount := 4
+	net := makePBFTNetwork(validatorCount, func(pep *pbftEndpoint) {
+		pep.pbft.K = 2
+		pep.pbft.L = pep.pbft.K * 2
+	})
+	defer net.stop()
+
+	reconfigureNetwork(t, net, Reconfiguration_REMOVE, 3, 0, 1, 2)
+	for _, pep := range net.pbftEndpoints {
+		if len(pep.pbft.memberships) != 2 {
+			t.Fatalf("Replica %d did not agree on the reconfiguration", pep.id)
+		}
+		if n := pep.pbft.memberships[1].SequenceNumber; n%pep.pbft.K != 0 {
+			t.Fatalf("Replica %d expected the membership to take effect at a checkpoint, not seqNo %d", pep.id, n)
+		}
+	}
+
+	execReconfiguredNetwork(t, net, 1)
+	execReconfiguredNetwork(t, net, 2)
+
+	for _, pep := range net.pbftEndpoints[:3] {
+		if pep.pbft.epoch != 1 || pep.pbft.N != 3 || pep.pbft.f != 0 {
+			t.Fatalf("Replica %d expected in membership 1 with N=3, f=0, got membership %d with N=%d, f=%d",
+				pep.id, pep.pbft.epoch, pep.pbft.N, pep.pbft.f)
+		}
+		if pep.sc.executions != 2 {
+			t.Errorf("Replica %d expected to execute both requests, executed %d", pep.id, pep.sc.executions)
+		}
+	}
+	if net.pbftEndpoints[0].pbft.isMember(3) {
+		t.Fatalf("Replica 3 should have been removed")
+	}
+}
+
+func TestReconfigurationAddReplica(t *testing.T) {
+	validatorCount := 5
+	net := makePBFTNetwork(validatorCount, func(pep *pbftEndpoint) {
+		pep.pbft.K = 2
+		pep.pbft.L = pep.pbft.K * 2
+		pep.pbft.N = 4
+		pep.pbft.f = 1
+		pep.pbft.initMembership(4, 1)
+	})
+	defer net.stop()
+
+	reconfigureNetwork(t, net, Reconfiguration_ADD, 4, 0, 1)
+	if len(net.pbftEndpoints[0].pbft.memberships) != 1 {
+		t.Fatalf("Two votes are not a quorum of four replicas")
+	}
+	reconfigureNetwork(t, net, Reconfiguration_ADD, 4, 2)
+
+	execReconfiguredNetwork(t, net, 1)
+	execReconfiguredNetwork(t, net, 2)
+	execReconfiguredNetwork(t, net, 3)
+
+	for _, pep := range net.pbftEndpoints {
+		if pep.pbft.epoch != 1 || pep.pbft.N != 5 || !pep.pbft.isMember(4) {
+			t.Fatalf("Replica %d expected in membership 1 with replica 4, got membership %d with N=%d",
+				pep.id, pep.pbft.epoch, pep.pbft.N)
+		}
+		if pep.sc.executions != 3 {
+			t.Errorf("Replica %d expected to execute three requests, executed %d", pep.id, pep.sc.executions)
+		}
+	}
+}
+
+func TestReconfigurationRejectInvalidVotes(t *testing.T) {
+	mock := &omniProto{
+		verifyImpl: func(senderID uint64, signature []byte, message []byte) error {
+			return nil
+		},
+	}
+	instance := newPbftCore(1, loadConfig(), mock)
+	defer instance.close()
+
+	vote := func(id uint64, validator uint64) *Reconfiguration {
+		return &Reconfiguration{Action: Reconfiguration_ADD, Validator: validator, ReplicaId: id}
+	}
+
+	if err := instance.verifyReconfiguration([]*Reconfiguration{vote(0, 4), vote(1, 4)}); err == nil {
+		t.Errorf("Expected to reject a reconfiguration without a quorum of votes")
+	}
+	if err := instance.verifyReconfiguration([]*Reconfiguration{vote(0, 4), vote(0, 4), vote(1, 4)}); err == nil {
+		t.Errorf("Expected to reject a reconfiguration with duplicate votes")
+	}
+	if err := instance.verifyReconfiguration([]*Reconfiguration{vote(0, 4), vote(1, 4), vote(2, 5)}); err == nil {
+		t.Errorf("Expected to reject a reconfiguration with votes for different changes")
+	}
+	if err := instance.verifyReconfiguration([]*Reconfiguration{vote(0, 4), vote(1, 4), vote(7, 4)}); err == nil {
+		t.Errorf("Expected to reject a reconfiguration with votes of non-members")
+	}
+	if err := instance.verifyReconfiguration([]*Reconfiguration{vote(0, 4), vote(1, 4), vote(2, 4)}); err != nil {
+		t.Errorf("Expected to accept a quorum of votes: %s", err)
+	}
+
+	m := &Membership{
+		Epoch:           1,
+		Replicas:        []uint64{0, 1, 2, 3, 4},
+		SequenceNumber:  instance.K,
+		Reconfiguration: []*Reconfiguration{vote(0, 4), vote(1, 4), vote(2, 4)},
+	}
+	forged := &Membership{
+		Epoch:           1,
+		Replicas:        []uint64{0, 1, 2, 3, 5},
+		SequenceNumber:  instance.K,
+		Reconfiguration: m.Reconfiguration,
+	}
+	if err := instance.recvMembershipHistory(&MembershipHistory{Memberships: []*Membership{forged}, ReplicaId: 0}); err == nil {
+		t.Errorf("Expected to reject a membership which does not follow from its votes")
+	}
+	if err := instance.recvMembershipHistory(&MembershipHistory{Memberships: []*Membership{m}, ReplicaId: 0}); err != nil {
+		t.Fatalf("Expected to accept a membership following from its votes: %s", err)
+	}
+	if len(instance.memberships) != 2 || instance.epoch != 0 {
+		t.Fatalf("Expected membership 1 to be known but not in effect before seqNo %d", m.SequenceNumber)
+	}
+}
+
+type testTCert struct {
+	cert []byte
+}
+
+func (tc *testTCert) GetCertificate() []byte {
+	return tc.cert
+}
+
+func (tc *testTCert) Sign(msg []byte) ([]byte, error) {
+	return append(append([]byte{}, tc.cert...), msg...), nil
+}
+
+func TestTCertSignatures(t *testing.T) {
+	drawn := 0
+	verifiedAnnouncements := 0
+	stack := &omniProto{
+		signImpl: func(msg []byte) ([]byte, error) {
+			return msg, nil
+		},
+		verifyImpl: func(senderID uint64, signature []byte, message []byte) error {
+			verifiedAnnouncements++
+			if !reflect.DeepEqual(signature, message) {
+				return fmt.Errorf("bad signature")
+			}
+			return nil
+		},
+		getNextTCertImpl: func() (consensus.SigningCert, error) {
+			drawn++
+			return &testTCert{[]byte(fmt.Sprintf("tcert%d", drawn))}, nil
+		},
+		verifyWithTCertImpl: func(cert []byte, signature, message []byte) error {
+			if !reflect.DeepEqual(signature, append(append([]byte{}, cert...), message...)) {
+				return fmt.Errorf("bad TCert signature")
+			}
+			return nil
+		},
+	}
+	config := loadConfig()
+	config.Set("general.tcerts.enabled", true)
+	config.Set("general.tcerts.period", "1h")
+	instance := newPbftCore(1, config, stack)
+	defer instance.close()
+
+	vc := &ViewChange{View: 1, ReplicaId: 1}
+	if err := instance.sign(vc); err != nil {
+		t.Fatalf("Failed to sign: %s", err)
+	}
+	if err := instance.verify(vc); err != nil {
+		t.Fatalf("Failed to verify: %s", err)
+	}
+	vc.View = 2
+	if err := instance.verify(vc); err == nil {
+		t.Fatal("Expected a tampered message to be rejected")
+	}
+	vc.View = 1
+	vc.ReplicaId = 2
+	if err := instance.verify(vc); err == nil {
+		t.Fatal("Expected a TCert announced by another replica to be rejected")
+	}
+	vc.ReplicaId = 1
+
+	if err := instance.sign(&ViewChange{View: 3, ReplicaId: 1}); err != nil {
+		t.Fatalf("Failed to sign: %s", err)
+	}
+	if drawn != 1 {
+		t.Fatalf("Expected a single TCert within the period, drew %d", drawn)
+	}
+	if err := instance.verify(vc); err != nil || verifiedAnnouncements != 1 {
+		t.Fatalf("Expected the announcement to be verified once, got %d times, %v", verifiedAnnouncements, err)
+	}
+
+	instance.tcerts.period = 0
+	vc2 := &ViewChange{View: 4, ReplicaId: 1}
+	if err := instance.sign(vc2); err != nil {
+		t.Fatalf("Failed to sign: %s", err)
+	}
+	if drawn != 2 {
+		t.Fatalf("Expected the next TCert to be drawn after the period, drew %d", drawn)
+	}
+	if err := instance.verify(vc2); err != nil {
+		t.Fatalf("Failed to verify with the next TCert: %s", err)
+	}
+}
+
+func simulateRequests(t *testing.T, sim *simulator, from, to int) {
+	for i := from; i < to; i++ {
+		sim.request(fmt.Sprintf("request %d", i))
+		sim.run(10 * time.Millisecond)
+	}
+	if !sim.runUntil(sim.executed(to), time.Minute) {
+		for _, r := range sim.correct() {
+			t.Logf("Replica %d executed %d requests", r.id, len(r.ledger))
+		}
+		t.Log(strings.Join(sim.trace, "\n"))
+		t.Fatalf("Expected a quorum of correct replicas to execute %d requests", to)
+	}
+	if err := sim.checkLedgers(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSimulatorRepeatable(t *testing.T) {
+	var traces [][]string
+	for i := 0; i < 2; i++ {
+		sim := newSimulator(4, 42)
+		simulateRequests(t, sim, 0, 25)
+		sim.stop()
+		traces = append(traces, sim.trace)
+	}
+	if !reflect.DeepEqual(traces[0], traces[1]) {
+		t.Fatalf("Expected runs with the same seed to repeat, got\n%s\nthen\n%s", strings.Join(traces[0], "\n"), strings.Join(traces[1], "\n"))
+	}
+}
+
+func TestSimulatorPartitionedPrimary(t *testing.T) {
+	sim := newSimulator(4, 1)
+	defer sim.stop()
+	simulateRequests(t, sim, 0, 5)
+
+	sim.isolate([]uint64{1, 2, 3})
+	simulateRequests(t, sim, 5, 10)
+	for _, r := range sim.replicas[1:] {
+		if r.pbft.view == 0 {
+			t.Fatalf("Expected replica %d to move on from the view of the partitioned primary", r.id)
+		}
+	}
+
+	// the former primary catches up by state transfer once it sees
+	// checkpoints beyond its watermarks
+	sim.heal()
+	simulateRequests(t, sim, 10, 70)
+	sim.runUntil(func() bool { return len(sim.replicas[0].ledger) >= 50 }, time.Minute)
+	if len(sim.replicas[0].ledger) < 50 {
+		t.Fatalf("Expected replica 0 to catch up once the partition healed, executed %d requests", len(sim.replicas[0].ledger))
+	}
+}
+
+func TestSimulatorEquivocatingPrimary(t *testing.T) {
+	sim := newSimulator(4, 2)
+	defer sim.stop()
+	sim.byzantine[0] = equivocate
+	simulateRequests(t, sim, 0, 10)
+
+	for _, r := range sim.correct() {
+		if r.pbft.view == 0 {
+			t.Fatalf("Expected replica %d to move on from the view of the equivocating primary", r.id)
+		}
+	}
+}
+
+func TestSimulatorSilentBackup(t *testing.T) {
+	sim := newSimulator(4, 5)
+	defer sim.stop()
+	sim.byzantine[3] = silent
+	simulateRequests(t, sim, 0, 20)
+}
+
+func TestSimulatorCrashedReplica(t *testing.T) {
+	sim := newSimulator(4, 3)
+	defer sim.stop()
+	simulateRequests(t, sim, 0, 10)
+
+	sim.crash(3)
+	simulateRequests(t, sim, 10, 20)
+	sim.restart(3)
+	simulateRequests(t, sim, 20, 70)
+	sim.runUntil(func() bool { return len(sim.replicas[3].ledger) >= 60 }, time.Minute)
+	if len(sim.replicas[3].ledger) < 60 {
+		t.Fatalf("Expected replica 3 to catch up once restarted, executed %d requests", len(sim.replicas[3].ledger))
+	}
+}
+
+func TestSimulatorLossyNetwork(t *testing.T) {
+	sim := newSimulator(4, 4)
+	defer sim.stop()
+	sim.dropRate = 0.02
+	for i := 0; i < 40; i++ {
+		sim.request(fmt.Sprintf("request %d", i))
+		sim.run(10 * time.Millisecond)
+	}
+	sim.run(time.Minute)
+
+	if err := sim.checkLedgers(); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range sim.replicas {
+		if len(r.ledger) == 0 {
+			t.Fatalf("Expected replica %d to execute requests", r.id)
+		}
+	}
+}
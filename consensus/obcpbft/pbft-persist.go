@@ -160,12 +160,54 @@ func (instance *pbftCore) restoreState() {
 		logger.Warning("Replica %d could not restore checkpoints: %s", instance.id, err)
 	}
 
+	instance.restoreWAL()
+
 	instance.restoreLastSeqNo()
+	instance.restoreMemberships()
 
 	logger.Info("Replica %d restored state: view: %d, seqNo: %d, pset: %d, qset: %d, reqs: %d, chkpts: %d",
 		instance.id, instance.view, instance.seqNo, len(instance.pset), len(instance.qset), len(instance.reqStore), len(instance.chkpts))
 }
 
+func (instance *pbftCore) persistMembership(m *Membership) {
+	raw, err := proto.Marshal(m)
+	if err != nil {
+		logger.Warning("Replica %d could not persist membership %d: %s", instance.id, m.Epoch, err)
+		return
+	}
+	instance.consumer.StoreState(fmt.Sprintf("membership.%d", m.Epoch), raw)
+}
+
+func (instance *pbftCore) restoreMemberships() {
+	memberships, err := instance.consumer.ReadStateSet("membership.")
+	if err != nil {
+		logger.Warning("Replica %d could not restore memberships: %s", instance.id, err)
+		return
+	}
+
+	restored := make(map[uint64]*Membership)
+	for key, raw := range memberships {
+		m := &Membership{}
+		if err = proto.Unmarshal(raw, m); err != nil {
+			logger.Warning("Replica %d could not restore membership %s", instance.id, key)
+			continue
+		}
+		restored[m.Epoch] = m
+	}
+	for {
+		m, ok := restored[uint64(len(instance.memberships))]
+		if !ok {
+			break
+		}
+		instance.memberships = append(instance.memberships, m)
+	}
+
+	for m := instance.pendingMembership(); m != nil && m.SequenceNumber <= instance.lastExec; m = instance.pendingMembership() {
+		instance.installMembership(m)
+	}
+	logger.Info("Replica %d restored memberships: latest %d, in effect %d", instance.id, instance.latestMembership().Epoch, instance.epoch)
+}
+
 func (instance *pbftCore) restoreLastSeqNo() {
 	var err error
 	if instance.lastExec, err = instance.consumer.getLastSeqNo(); err != nil {
@@ -0,0 +1,152 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// The write-ahead log records every pre-prepare, prepare and commit a
+// replica accepts, before it acts on it. A replica restarting replays the
+// log to rebuild its certificates within the watermarks, along with its
+// view and sequence number, and carries on where it crashed instead of
+// waiting for a view change or state transfer to catch up. Records are
+// deleted as the low watermark moves past their sequence number.
+
+// walAppend records a protocol message in the write-ahead log
+func (instance *pbftCore) walAppend(msg *Message) {
+	raw, err := proto.Marshal(msg)
+	if err != nil {
+		logger.Warning("Replica %d could not marshal write-ahead log record: %s", instance.id, err)
+		return
+	}
+	index := instance.walIndex
+	instance.walIndex++
+	if err = instance.consumer.StoreState(fmt.Sprintf("wal.%d", index), raw); err != nil {
+		logger.Warning("Replica %d could not persist write-ahead log record %d: %s", instance.id, index, err)
+		return
+	}
+	instance.walRecords[index] = walSeqNo(msg)
+}
+
+// walTruncate deletes the records up to a sequence number
+func (instance *pbftCore) walTruncate(h uint64) {
+	for index, n := range instance.walRecords {
+		if n <= h {
+			instance.consumer.DelState(fmt.Sprintf("wal.%d", index))
+			delete(instance.walRecords, index)
+		}
+	}
+}
+
+// walSeqNo returns the sequence number of a record
+func walSeqNo(msg *Message) uint64 {
+	switch {
+	case msg.GetPrePrepare() != nil:
+		return msg.GetPrePrepare().SequenceNumber
+	case msg.GetPrepare() != nil:
+		return msg.GetPrepare().SequenceNumber
+	case msg.GetCommit() != nil:
+		return msg.GetCommit().SequenceNumber
+	}
+	return 0
+}
+
+// restoreWAL replays the write-ahead log into the certificate store. It
+// follows the restoration of the checkpoints, records below the low
+// watermark they set are dropped.
+func (instance *pbftCore) restoreWAL() {
+	records, err := instance.consumer.ReadStateSet("wal.")
+	if err != nil {
+		logger.Warning("Replica %d could not restore write-ahead log: %s", instance.id, err)
+		return
+	}
+
+	var indices sortableUint64Slice
+	msgs := make(map[uint64]*Message)
+	for key, raw := range records {
+		var index uint64
+		msg := &Message{}
+		if _, err = fmt.Sscanf(key, "wal.%d", &index); err != nil {
+			logger.Warning("Replica %d could not restore write-ahead log key %s", instance.id, key)
+			continue
+		}
+		if err = proto.Unmarshal(raw, msg); err != nil {
+			logger.Warning("Replica %d could not restore write-ahead log record %d - local state is damaged: %s", instance.id, index, err)
+			instance.consumer.DelState(key)
+			continue
+		}
+		indices = append(indices, index)
+		msgs[index] = msg
+	}
+	sort.Sort(indices)
+
+	for _, index := range indices {
+		msg := msgs[index]
+		if index >= instance.walIndex {
+			instance.walIndex = index + 1
+		}
+		n := walSeqNo(msg)
+		if n <= instance.h {
+			instance.consumer.DelState(fmt.Sprintf("wal.%d", index))
+			continue
+		}
+		instance.walRecords[index] = n
+		instance.replay(msg)
+	}
+
+	logger.Info("Replica %d restored write-ahead log: %d records, view: %d, seqNo: %d, certs: %d",
+		instance.id, len(instance.walRecords), instance.view, instance.seqNo, len(instance.certStore))
+}
+
+// replay rebuilds the certificate a record is part of, as it was when the
+// record was appended
+func (instance *pbftCore) replay(msg *Message) {
+	var v uint64
+	if preprep := msg.GetPrePrepare(); preprep != nil {
+		v = preprep.View
+		cert := instance.getCert(preprep.View, preprep.SequenceNumber)
+		cert.prePrepare = preprep
+		cert.digest = preprep.RequestDigest
+		if _, ok := instance.reqStore[preprep.RequestDigest]; !ok && preprep.Request != nil {
+			instance.reqStore[preprep.RequestDigest] = preprep.Request
+		}
+		if preprep.SequenceNumber > instance.seqNo {
+			instance.seqNo = preprep.SequenceNumber
+		}
+	} else if prep := msg.GetPrepare(); prep != nil {
+		v = prep.View
+		cert := instance.getCert(prep.View, prep.SequenceNumber)
+		cert.prepare = append(cert.prepare, prep)
+		if prep.ReplicaId == instance.id {
+			cert.sentPrepare = true
+		}
+	} else if commit := msg.GetCommit(); commit != nil {
+		v = commit.View
+		cert := instance.getCert(commit.View, commit.SequenceNumber)
+		cert.commit = append(cert.commit, commit)
+		if commit.ReplicaId == instance.id {
+			cert.sentCommit = true
+		}
+	}
+	if v > instance.view {
+		instance.view = v
+	}
+}
@@ -0,0 +1,481 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	google_protobuf "google/protobuf"
+)
+
+// --------------------------------------------------------------
+//
+// The replicas form a membership per epoch: epoch 0 is made of the
+// replicas 0 to N-1 configured, every following one adds or removes
+// a single replica from its predecessor.
+//
+// Members vote for a change by broadcasting a signed reconfiguration.
+// Once a replica holds the votes of a quorum of the latest membership
+// for the same change, it requests them to be ordered like any other
+// request. When the request executes, the new membership is agreed on,
+// and it takes effect at the first checkpoint from then on: the
+// sequence numbers after that checkpoint are only agreed on by the new
+// membership, whose messages are held back by the replicas that did
+// not reach the checkpoint yet.
+//
+// A replica which does not know the membership of a checkpoint it
+// witnesses, because it fell behind or joined the network, fetches the
+// memberships it missed with the votes for each of them, so it can
+// verify them from the one it knows.
+//
+// --------------------------------------------------------------
+
+type reconfidx struct {
+	action    Reconfiguration_Action
+	validator uint64
+	epoch     uint64
+}
+
+func (m *Membership) contains(id uint64) bool {
+	for _, replica := range m.Replicas {
+		if replica == id {
+			return true
+		}
+	}
+	return false
+}
+
+// change returns the replicas of the membership following m for a change
+func (m *Membership) change(action Reconfiguration_Action, validator uint64) (replicas []uint64, err error) {
+	switch action {
+	case Reconfiguration_ADD:
+		if m.contains(validator) {
+			return nil, fmt.Errorf("Replica %d is already a member of membership %d", validator, m.Epoch)
+		}
+		replicas = append(replicas, m.Replicas...)
+		replicas = append(replicas, validator)
+	case Reconfiguration_REMOVE:
+		if !m.contains(validator) {
+			return nil, fmt.Errorf("Replica %d is not a member of membership %d", validator, m.Epoch)
+		}
+		if len(m.Replicas) == 1 {
+			return nil, fmt.Errorf("Cannot remove the last member of membership %d", m.Epoch)
+		}
+		for _, replica := range m.Replicas {
+			if replica != validator {
+				replicas = append(replicas, replica)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("Unknown reconfiguration action %v", action)
+	}
+	sort.Sort(sortableUint64Slice(replicas))
+	return replicas, nil
+}
+
+func (instance *pbftCore) initMembership(N int, f int) {
+	instance.initialF = f
+	initial := &Membership{}
+	for id := 0; id < N; id++ {
+		initial.Replicas = append(initial.Replicas, uint64(id))
+	}
+	instance.memberships = []*Membership{initial}
+	instance.votes = make(map[reconfidx]map[uint64]*Reconfiguration)
+	instance.fetchedMembership = make(map[uint64]uint64)
+}
+
+// membership returns the membership in effect
+func (instance *pbftCore) membership() *Membership {
+	return instance.memberships[instance.epoch]
+}
+
+// latestMembership returns the membership last agreed on, which may not be in effect yet
+func (instance *pbftCore) latestMembership() *Membership {
+	return instance.memberships[len(instance.memberships)-1]
+}
+
+// pendingMembership returns the membership to take effect next, if any
+func (instance *pbftCore) pendingMembership() *Membership {
+	if instance.epoch+1 < uint64(len(instance.memberships)) {
+		return instance.memberships[instance.epoch+1]
+	}
+	return nil
+}
+
+func (instance *pbftCore) isMember(id uint64) bool {
+	return instance.membership().contains(id)
+}
+
+// faults returns the number of faults a membership tolerates: as many
+// as configured for the initial one, as many as its size allows for the
+// following ones
+func (instance *pbftCore) faults(m *Membership) int {
+	if m.Epoch == 0 {
+		return instance.initialF
+	}
+	return (len(m.Replicas) - 1) / 3
+}
+
+// quorum returns the intersection quorum of a membership
+func (instance *pbftCore) quorum(m *Membership) int {
+	return (len(m.Replicas) + instance.faults(m) + 2) / 2
+}
+
+func (instance *pbftCore) installMembership(m *Membership) {
+	instance.epoch = m.Epoch
+	instance.N = len(m.Replicas)
+	instance.f = instance.faults(m)
+	instance.replicaCount = instance.N
+
+	logger.Info("Replica %d in membership %d from seqNo %d: replicas %v, N=%d, f=%d",
+		instance.id, m.Epoch, m.SequenceNumber, m.Replicas, instance.N, instance.f)
+	if !instance.isMember(instance.id) {
+		logger.Warning("Replica %d is not a member of membership %d", instance.id, m.Epoch)
+	}
+}
+
+// activateMembership puts in effect the memberships agreed on for the
+// checkpoints up to the last executed request
+func (instance *pbftCore) activateMembership() {
+	pending := instance.pendingMembership()
+	if pending == nil || pending.SequenceNumber > instance.lastExec {
+		return
+	}
+	for pending != nil && pending.SequenceNumber <= instance.lastExec {
+		instance.installMembership(pending)
+		pending = instance.pendingMembership()
+	}
+
+	// The sequence numbers after the checkpoint are agreed on again,
+	// by the new membership
+	for idx := range instance.certStore {
+		if idx.n > instance.lastExec {
+			delete(instance.certStore, idx)
+		}
+	}
+	for n := range instance.pset {
+		if n > instance.lastExec {
+			delete(instance.pset, n)
+		}
+	}
+	for idx := range instance.qset {
+		if idx.n > instance.lastExec {
+			delete(instance.qset, idx)
+		}
+	}
+	instance.persistPSet()
+	instance.persistQSet()
+	if instance.seqNo > instance.lastExec {
+		instance.seqNo = instance.lastExec
+	}
+
+	deferred := instance.deferred
+	instance.deferred = nil
+	for _, msg := range deferred {
+		sendEvent(instance, msg)
+	}
+
+	instance.resubmitRequests()
+}
+
+// screen lets through the messages of the members of the membership in
+// effect. Those of a membership agreed on which did not take effect yet
+// are held back until it does, the others are dropped.
+func (instance *pbftCore) screen(epoch uint64, senderID uint64, msg *Message, payload interface{}) (interface{}, error) {
+	if epoch > instance.epoch {
+		if epoch >= uint64(len(instance.memberships)) {
+			return nil, fmt.Errorf("Replica %d dropping message of unknown membership %d from replica %d", instance.id, epoch, senderID)
+		}
+		logger.Debug("Replica %d holding back message of membership %d from replica %d until it takes effect",
+			instance.id, epoch, senderID)
+		instance.deferred = append(instance.deferred, pbftMessageEvent{msg: msg, sender: senderID})
+		return nil, nil
+	}
+	if epoch < instance.epoch {
+		return nil, fmt.Errorf("Replica %d dropping message of membership %d from replica %d, membership %d is in effect", instance.id, epoch, senderID, instance.epoch)
+	}
+	if !instance.isMember(senderID) {
+		return nil, fmt.Errorf("Replica %d dropping message from replica %d, which is not a member of membership %d", instance.id, senderID, epoch)
+	}
+	return payload, nil
+}
+
+// screenCheckpoint lets through the checkpoints of the members of any
+// membership known, so that a replica behind can tell it is. It fetches
+// the memberships it misses from the sender of a checkpoint of an
+// unknown one.
+func (instance *pbftCore) screenCheckpoint(chkpt *Checkpoint) (interface{}, error) {
+	if chkpt.Epoch >= uint64(len(instance.memberships)) {
+		instance.fetchMembership(chkpt.Epoch, chkpt.ReplicaId)
+		return nil, fmt.Errorf("Replica %d dropping checkpoint of unknown membership %d from replica %d", instance.id, chkpt.Epoch, chkpt.ReplicaId)
+	}
+	if !instance.memberships[chkpt.Epoch].contains(chkpt.ReplicaId) {
+		return nil, fmt.Errorf("Replica %d dropping checkpoint from replica %d, which is not a member of membership %d", instance.id, chkpt.ReplicaId, chkpt.Epoch)
+	}
+	return chkpt, nil
+}
+
+// =============================================================================
+// votes
+// =============================================================================
+
+// reconfigure casts the vote of this replica for a change of the latest membership
+func (instance *pbftCore) reconfigure(action Reconfiguration_Action, validator uint64) error {
+	latest := instance.latestMembership()
+	if !latest.contains(instance.id) {
+		return fmt.Errorf("Replica %d is not a member of membership %d, and cannot vote for its reconfiguration", instance.id, latest.Epoch)
+	}
+	if _, err := latest.change(action, validator); err != nil {
+		return err
+	}
+
+	vote := &Reconfiguration{
+		Action:    action,
+		Validator: validator,
+		Epoch:     latest.Epoch,
+		ReplicaId: instance.id,
+	}
+	if err := instance.sign(vote); err != nil {
+		return fmt.Errorf("Replica %d could not sign its vote: %s", instance.id, err)
+	}
+
+	logger.Info("Replica %d voting to %s replica %d, membership %d",
+		instance.id, action, validator, latest.Epoch)
+
+	instance.innerBroadcast(&Message{&Message_Reconfiguration{vote}})
+	return instance.recvReconfiguration(vote)
+}
+
+// checkVote verifies that a vote is a valid change of a membership, cast by one of its members
+func (instance *pbftCore) checkVote(m *Membership, vote *Reconfiguration) error {
+	if vote.Epoch != m.Epoch {
+		return fmt.Errorf("Vote of replica %d is for membership %d, not %d", vote.ReplicaId, vote.Epoch, m.Epoch)
+	}
+	if !m.contains(vote.ReplicaId) {
+		return fmt.Errorf("Replica %d voted but is not a member of membership %d", vote.ReplicaId, m.Epoch)
+	}
+	if _, err := m.change(vote.Action, vote.Validator); err != nil {
+		return err
+	}
+	if err := instance.verify(vote); err != nil {
+		return fmt.Errorf("Vote of replica %d has an incorrect signature: %s", vote.ReplicaId, err)
+	}
+	return nil
+}
+
+func (instance *pbftCore) recvReconfiguration(vote *Reconfiguration) error {
+	logger.Debug("Replica %d received vote from replica %d to %s replica %d, membership %d",
+		instance.id, vote.ReplicaId, vote.Action, vote.Validator, vote.Epoch)
+
+	latest := instance.latestMembership()
+	if vote.Epoch != latest.Epoch {
+		logger.Debug("Replica %d ignoring vote for membership %d, the latest is %d",
+			instance.id, vote.Epoch, latest.Epoch)
+		return nil
+	}
+	if err := instance.checkVote(latest, vote); err != nil {
+		logger.Warning("Replica %d ignoring vote: %s", instance.id, err)
+		return nil
+	}
+
+	idx := reconfidx{vote.Action, vote.Validator, vote.Epoch}
+	voters, ok := instance.votes[idx]
+	if !ok {
+		voters = make(map[uint64]*Reconfiguration)
+		instance.votes[idx] = voters
+	}
+	if _, ok := voters[vote.ReplicaId]; ok {
+		logger.Debug("Replica %d ignoring duplicate vote from %d", instance.id, vote.ReplicaId)
+		return nil
+	}
+	voters[vote.ReplicaId] = vote
+
+	// request the reconfiguration once, as the quorum is reached
+	if len(voters) != instance.quorum(latest) {
+		return nil
+	}
+
+	var ids []uint64
+	for id := range voters {
+		ids = append(ids, id)
+	}
+	sort.Sort(sortableUint64Slice(ids))
+	now := time.Now()
+	req := &Request{
+		Timestamp: &google_protobuf.Timestamp{
+			Seconds: now.Unix(),
+			Nanos:   int32(now.UnixNano() % 1000000000),
+		},
+		ReplicaId: instance.id,
+	}
+	for _, id := range ids {
+		req.Reconfiguration = append(req.Reconfiguration, voters[id])
+	}
+
+	logger.Info("Replica %d has a quorum of votes to %s replica %d, requesting the reconfiguration of membership %d",
+		instance.id, vote.Action, vote.Validator, vote.Epoch)
+
+	instance.innerBroadcast(&Message{&Message_Request{req}})
+	return instance.recvRequest(req)
+}
+
+// verifyReconfiguration verifies that the votes of a request are those
+// of a quorum of a membership known for the same change
+func (instance *pbftCore) verifyReconfiguration(votes []*Reconfiguration) error {
+	if len(votes) == 0 {
+		return fmt.Errorf("Reconfiguration without votes")
+	}
+	first := votes[0]
+	if first.Epoch >= uint64(len(instance.memberships)) {
+		return fmt.Errorf("Reconfiguration of unknown membership %d", first.Epoch)
+	}
+	m := instance.memberships[first.Epoch]
+
+	voters := make(map[uint64]bool)
+	for _, vote := range votes {
+		if vote.Action != first.Action || vote.Validator != first.Validator {
+			return fmt.Errorf("Reconfiguration with votes for different changes")
+		}
+		if voters[vote.ReplicaId] {
+			return fmt.Errorf("Reconfiguration with several votes of replica %d", vote.ReplicaId)
+		}
+		if err := instance.checkVote(m, vote); err != nil {
+			return err
+		}
+		voters[vote.ReplicaId] = true
+	}
+	if len(voters) < instance.quorum(m) {
+		return fmt.Errorf("Reconfiguration with %d votes, %d needed", len(voters), instance.quorum(m))
+	}
+	return nil
+}
+
+// validateRequest checks a request before it is ordered
+func (instance *pbftCore) validateRequest(req *Request) error {
+	if len(req.Reconfiguration) > 0 {
+		return instance.verifyReconfiguration(req.Reconfiguration)
+	}
+	if req.Parameters != nil {
+		return checkParameters(req.Parameters)
+	}
+	return instance.consumer.validate(req.Payload)
+}
+
+// executeReconfiguration agrees on the membership that the votes of a
+// request executed at n change the latest one to. It takes effect at
+// the first checkpoint from n on.
+func (instance *pbftCore) executeReconfiguration(n uint64, votes []*Reconfiguration) {
+	latest := instance.latestMembership()
+	vote := votes[0]
+	if vote.Epoch != latest.Epoch {
+		logger.Info("Replica %d ignoring reconfiguration of membership %d at seqNo %d, the latest is %d",
+			instance.id, vote.Epoch, n, latest.Epoch)
+		return
+	}
+	replicas, err := latest.change(vote.Action, vote.Validator)
+	if err != nil {
+		logger.Error("Replica %d could not reconfigure membership %d: %s", instance.id, latest.Epoch, err)
+		return
+	}
+
+	m := &Membership{
+		Epoch:           latest.Epoch + 1,
+		Replicas:        replicas,
+		SequenceNumber:  (n + instance.K - 1) / instance.K * instance.K,
+		Reconfiguration: votes,
+	}
+	instance.memberships = append(instance.memberships, m)
+	instance.persistMembership(m)
+
+	for idx := range instance.votes {
+		if idx.epoch < m.Epoch {
+			delete(instance.votes, idx)
+		}
+	}
+
+	logger.Info("Replica %d agreed at seqNo %d to %s replica %d: membership %d is %v from seqNo %d",
+		instance.id, n, vote.Action, vote.Validator, m.Epoch, m.Replicas, m.SequenceNumber)
+}
+
+// =============================================================================
+// membership transfer
+// =============================================================================
+
+// fetchMembership asks a replica for the memberships after the latest
+// known, once per membership it reported
+func (instance *pbftCore) fetchMembership(epoch uint64, replicaID uint64) {
+	if instance.fetchedMembership[replicaID] >= epoch {
+		return
+	}
+	instance.fetchedMembership[replicaID] = epoch
+
+	latest := instance.latestMembership()
+	logger.Info("Replica %d fetching the memberships after %d from replica %d, which is in membership %d",
+		instance.id, latest.Epoch, replicaID, epoch)
+
+	instance.innerUnicast(&Message{&Message_FetchMembership{&FetchMembership{
+		Epoch:     latest.Epoch,
+		ReplicaId: instance.id,
+	}}}, replicaID)
+}
+
+func (instance *pbftCore) recvFetchMembership(fm *FetchMembership) error {
+	if fm.Epoch+1 >= uint64(len(instance.memberships)) {
+		return nil // we don't know any later membership either
+	}
+
+	return instance.innerUnicast(&Message{&Message_MembershipHistory{&MembershipHistory{
+		Memberships: instance.memberships[fm.Epoch+1:],
+		ReplicaId:   instance.id,
+	}}}, fm.ReplicaId)
+}
+
+// recvMembershipHistory verifies and adds the memberships following the
+// latest known, each from the votes of its predecessor
+func (instance *pbftCore) recvMembershipHistory(mh *MembershipHistory) error {
+	for _, m := range mh.Memberships {
+		latest := instance.latestMembership()
+		if m.Epoch <= latest.Epoch {
+			continue
+		}
+		if m.Epoch != latest.Epoch+1 {
+			return fmt.Errorf("Replica %d received membership %d from replica %d, but only knows up to %d", instance.id, m.Epoch, mh.ReplicaId, latest.Epoch)
+		}
+
+		if err := instance.verifyReconfiguration(m.Reconfiguration); err != nil {
+			return fmt.Errorf("Replica %d received membership %d from replica %d with invalid votes: %s", instance.id, m.Epoch, mh.ReplicaId, err)
+		}
+		vote := m.Reconfiguration[0]
+		replicas, _ := latest.change(vote.Action, vote.Validator)
+		if vote.Epoch != latest.Epoch || fmt.Sprint(replicas) != fmt.Sprint(m.Replicas) {
+			return fmt.Errorf("Replica %d received membership %d from replica %d which does not follow from its votes", instance.id, m.Epoch, mh.ReplicaId)
+		}
+		if m.SequenceNumber%instance.K != 0 || m.SequenceNumber <= latest.SequenceNumber {
+			return fmt.Errorf("Replica %d received membership %d from replica %d taking effect at invalid seqNo %d", instance.id, m.Epoch, mh.ReplicaId, m.SequenceNumber)
+		}
+
+		logger.Info("Replica %d learned from replica %d of membership %d: %v from seqNo %d",
+			instance.id, mh.ReplicaId, m.Epoch, m.Replicas, m.SequenceNumber)
+		instance.memberships = append(instance.memberships, m)
+		instance.persistMembership(m)
+	}
+
+	instance.activateMembership()
+	return nil
+}
@@ -32,7 +32,12 @@ func (instance *pbftCore) sign(s signable) error {
 	if err != nil {
 		return err
 	}
-	signedRaw, err := instance.consumer.sign(raw)
+	var signedRaw []byte
+	if instance.tcerts != nil {
+		signedRaw, err = instance.tcerts.sign(raw)
+	} else {
+		signedRaw, err = instance.consumer.sign(raw)
+	}
 	if err != nil {
 		return err
 	}
@@ -49,6 +54,9 @@ func (instance *pbftCore) verify(s signable) error {
 	if err != nil {
 		return err
 	}
+	if instance.tcerts != nil {
+		return instance.tcerts.verify(s.getID(), origSig, raw)
+	}
 	return instance.consumer.verify(s.getID(), origSig, raw)
 }
 
@@ -131,3 +139,43 @@ func (msg *Flush) setID(id uint64) {
 func (msg *Flush) serialize() ([]byte, error) {
 	return pb.Marshal(msg)
 }
+
+func (msg *Reconfiguration) getSignature() []byte {
+	return msg.Signature
+}
+
+func (msg *Reconfiguration) setSignature(sig []byte) {
+	msg.Signature = sig
+}
+
+func (msg *Reconfiguration) getID() uint64 {
+	return msg.ReplicaId
+}
+
+func (msg *Reconfiguration) setID(id uint64) {
+	msg.ReplicaId = id
+}
+
+func (msg *Reconfiguration) serialize() ([]byte, error) {
+	return pb.Marshal(msg)
+}
+
+func (msg *AnchorSignature) getSignature() []byte {
+	return msg.Signature
+}
+
+func (msg *AnchorSignature) setSignature(sig []byte) {
+	msg.Signature = sig
+}
+
+func (msg *AnchorSignature) getID() uint64 {
+	return msg.ReplicaId
+}
+
+func (msg *AnchorSignature) setID(id uint64) {
+	msg.ReplicaId = id
+}
+
+func (msg *AnchorSignature) serialize() ([]byte, error) {
+	return pb.Marshal(msg)
+}
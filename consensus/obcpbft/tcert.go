@@ -0,0 +1,176 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/core/util"
+)
+
+// tcertStack is implemented by the consumers of pbftCore whose stack can
+// sign with TCerts
+type tcertStack interface {
+	getNextTCert() (consensus.SigningCert, error)
+	verifyWithTCert(cert []byte, signature, message []byte) error
+}
+
+// getNextTCert draws the next TCert of the validator of a stack
+func getNextTCert(stack consensus.Stack) (consensus.SigningCert, error) {
+	signer, ok := stack.(consensus.TCertSigner)
+	if !ok {
+		return nil, fmt.Errorf("Stack cannot sign with TCerts")
+	}
+	return signer.GetNextTCert()
+}
+
+// verifyWithTCert verifies a signature under a TCert with a stack
+func verifyWithTCert(stack consensus.Stack, cert []byte, signature, message []byte) error {
+	signer, ok := stack.(consensus.TCertSigner)
+	if !ok {
+		return fmt.Errorf("Stack cannot verify signatures with TCerts")
+	}
+	return signer.VerifyWithTCert(cert, signature, message)
+}
+
+// maxAnnouncements bounds the announcements whose signature is remembered
+// as verified
+const maxAnnouncements = 1024
+
+// tcertSigner signs the messages of a replica with a TCert in place of its
+// enrollment key, drawing another one every period. Every signature carries
+// the announcement of its TCert, signed once with the enrollment key, which
+// binds the TCert to the replica.
+type tcertSigner struct {
+	lock     sync.Mutex
+	id       uint64
+	consumer innerStack
+	stack    tcertStack
+	period   time.Duration
+
+	current      consensus.SigningCert
+	announcement *TcertAnnouncement
+	drawn        time.Time
+
+	verified map[string]bool // announcements verified, by hash
+}
+
+func newTCertSigner(id uint64, consumer innerStack, period time.Duration) (*tcertSigner, error) {
+	stack, ok := consumer.(tcertStack)
+	if !ok {
+		return nil, fmt.Errorf("PBFT consumer %T cannot sign with TCerts", consumer)
+	}
+	return &tcertSigner{
+		id:       id,
+		consumer: consumer,
+		stack:    stack,
+		period:   period,
+		verified: make(map[string]bool),
+	}, nil
+}
+
+// rotate draws the next TCert, and announces it under the enrollment key
+func (ts *tcertSigner) rotate() error {
+	cert, err := ts.stack.getNextTCert()
+	if err != nil {
+		return fmt.Errorf("Could not draw a TCert: %s", err)
+	}
+	announcement := &TcertAnnouncement{ReplicaId: ts.id, Cert: cert.GetCertificate()}
+	raw, err := proto.Marshal(announcement)
+	if err != nil {
+		return err
+	}
+	if announcement.Signature, err = ts.consumer.sign(raw); err != nil {
+		return fmt.Errorf("Could not sign the announcement of a TCert: %s", err)
+	}
+
+	ts.current = cert
+	ts.announcement = announcement
+	ts.drawn = time.Now()
+	logger.Info("Replica %d signing with a new TCert", ts.id)
+	return nil
+}
+
+func (ts *tcertSigner) sign(msg []byte) ([]byte, error) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	if ts.current == nil || time.Since(ts.drawn) >= ts.period {
+		if err := ts.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	signature, err := ts.current.Sign(msg)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&TcertSignature{Announcement: ts.announcement, Signature: signature})
+}
+
+func (ts *tcertSigner) verify(senderID uint64, signature []byte, message []byte) error {
+	sig := &TcertSignature{}
+	if err := proto.Unmarshal(signature, sig); err != nil {
+		return fmt.Errorf("Could not unmarshal TCert signature: %s", err)
+	}
+	announcement := sig.GetAnnouncement()
+	if announcement == nil || announcement.ReplicaId != senderID {
+		return fmt.Errorf("Signature of replica %d without announcement of its TCert", senderID)
+	}
+	if err := ts.verifyAnnouncement(announcement); err != nil {
+		return err
+	}
+	return ts.stack.verifyWithTCert(announcement.Cert, sig.Signature, message)
+}
+
+// verifyAnnouncement checks that a TCert was announced by the replica it is
+// bound to, remembering the announcements verified
+func (ts *tcertSigner) verifyAnnouncement(announcement *TcertAnnouncement) error {
+	raw, err := proto.Marshal(announcement)
+	if err != nil {
+		return err
+	}
+	hash := string(util.ComputeCryptoHash(raw))
+
+	ts.lock.Lock()
+	ok := ts.verified[hash]
+	ts.lock.Unlock()
+	if ok {
+		return nil
+	}
+
+	unsigned := *announcement
+	unsigned.Signature = nil
+	if raw, err = proto.Marshal(&unsigned); err != nil {
+		return err
+	}
+	if err = ts.consumer.verify(announcement.ReplicaId, announcement.Signature, raw); err != nil {
+		return fmt.Errorf("Announcement of a TCert of replica %d did not verify: %s", announcement.ReplicaId, err)
+	}
+
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	if len(ts.verified) >= maxAnnouncements {
+		ts.verified = make(map[string]bool)
+	}
+	ts.verified[hash] = true
+	return nil
+}
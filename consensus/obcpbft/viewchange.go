@@ -20,6 +20,9 @@ import (
 	"encoding/base64"
 	"fmt"
 	"reflect"
+	"time"
+
+	"github.com/hyperledger/fabric/consensus"
 )
 
 func (instance *pbftCore) correctViewChange(vc *ViewChange) bool {
@@ -122,8 +125,15 @@ func (instance *pbftCore) sendViewChange() error {
 
 	delete(instance.newViewStore, instance.view)
 	instance.view++
+	if instance.activeView {
+		instance.viewChangeStart = time.Now()
+	}
 	instance.activeView = false
 
+	metrics := consensus.GetMetrics()
+	metrics.IncViewChanges(pluginName)
+	metrics.SetView(pluginName, instance.view)
+
 	instance.pset = instance.calcPSet()
 	instance.qset = instance.calcQSet()
 
@@ -143,6 +153,7 @@ func (instance *pbftCore) sendViewChange() error {
 		View:      instance.view,
 		H:         instance.h,
 		ReplicaId: instance.id,
+		Epoch:     instance.epoch,
 	}
 
 	for n, id := range instance.chkpts {
@@ -269,6 +280,7 @@ func (instance *pbftCore) sendNewView() (err error) {
 		Vset:      vset,
 		Xset:      msgList,
 		ReplicaId: instance.id,
+		Epoch:     instance.epoch,
 	}
 
 	logger.Info("Replica %d is new primary, sending new-view, v:%d, X:%+v",
@@ -293,6 +305,10 @@ func (instance *pbftCore) recvNewView(nv *NewView) error {
 	}
 
 	for _, vc := range nv.Vset {
+		if vc.Epoch != nv.Epoch {
+			logger.Warning("Replica %d found view-change of membership %d in new-view of membership %d", instance.id, vc.Epoch, nv.Epoch)
+			return nil
+		}
 		if err := instance.verify(vc); err != nil {
 			logger.Warning("Replica %d found incorrect view-change signature in new-view message: %s", instance.id, err)
 			return nil
@@ -395,16 +411,22 @@ func (instance *pbftCore) processNewView2(nv *NewView) error {
 	instance.activeView = true
 	delete(instance.newViewStore, instance.view-1)
 
+	metrics := consensus.GetMetrics()
+	metrics.ObserveViewChange(pluginName, time.Since(instance.viewChangeStart))
+	metrics.SetView(pluginName, instance.view)
+
 	for n, d := range nv.Xset {
 		preprep := &PrePrepare{
 			View:           instance.view,
 			SequenceNumber: n,
 			RequestDigest:  d,
 			ReplicaId:      instance.id,
+			Epoch:          instance.epoch,
 		}
 		cert := instance.getCert(instance.view, n)
 		cert.prePrepare = preprep
 		cert.digest = d
+		instance.walAppend(&Message{&Message_PrePrepare{preprep}})
 		if n > instance.seqNo {
 			instance.seqNo = n
 		}
@@ -418,6 +440,7 @@ func (instance *pbftCore) processNewView2(nv *NewView) error {
 				SequenceNumber: n,
 				RequestDigest:  d,
 				ReplicaId:      instance.id,
+				Epoch:          instance.epoch,
 			}
 			cert := instance.getCert(instance.view, n)
 			cert.sentPrepare = true
@@ -0,0 +1,34 @@
+// Code generated by protoc-gen-go.
+// source: orderer/messages.proto
+// DO NOT EDIT!
+
+/*
+Package orderer is a generated protocol buffer package.
+
+It is generated from these files:
+	orderer/messages.proto
+
+It has these top-level messages:
+	Metadata
+*/
+package orderer
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// Consensus metadata of the blocks, the batch of the ordering service
+// each block executes
+type Metadata struct {
+	BatchNumber uint64 `protobuf:"varint,1,opt,name=batchNumber" json:"batchNumber,omitempty"`
+	BatchHash   []byte `protobuf:"bytes,2,opt,name=batchHash,proto3" json:"batchHash,omitempty"`
+}
+
+func (m *Metadata) Reset()         { *m = Metadata{} }
+func (m *Metadata) String() string { return proto.CompactTextString(m) }
+func (*Metadata) ProtoMessage()    {}
@@ -0,0 +1,303 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orderer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/hyperledger/fabric/consensus"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+const configPrefix = "CORE_ORDERER"
+
+var logger *logging.Logger // package-level logger
+
+var pluginInstance consensus.Consenter // singleton service
+var config *viper.Viper
+
+func init() {
+	logger = logging.MustGetLogger("consensus/orderer")
+	config = loadConfig()
+}
+
+// GetPlugin returns the handle to the Consenter singleton
+func GetPlugin(c consensus.Stack) consensus.Consenter {
+	if pluginInstance == nil {
+		pluginInstance = New(c)
+	}
+	return pluginInstance
+}
+
+// New creates a Consenter which leaves ordering to an ordering service:
+// transactions are broadcast to it, and the batches it delivers are
+// executed in order as blocks. Validators then agree on the ledger as
+// long as the ordering service delivers them the same batches.
+func New(stack consensus.Stack) consensus.Consenter {
+	parse := func(key string) time.Duration {
+		d, err := time.ParseDuration(config.GetString(key))
+		if err != nil || d <= 0 {
+			panic(fmt.Errorf("Cannot parse %s: %s", key, config.GetString(key)))
+		}
+		return d
+	}
+
+	conn, err := dial(config.GetString("general.address"))
+	if err != nil {
+		panic(fmt.Errorf("Cannot connect to the ordering service: %s", err))
+	}
+	return newObcOrderer(stack, pb.NewAtomicBroadcastClient(conn),
+		parse("general.timeout.broadcast"), parse("general.timeout.reconnect"))
+}
+
+func loadConfig() (config *viper.Viper) {
+	config = viper.New()
+
+	// for environment variables
+	config.SetEnvPrefix(configPrefix)
+	config.AutomaticEnv()
+	replacer := strings.NewReplacer(".", "_")
+	config.SetEnvKeyReplacer(replacer)
+
+	config.SetConfigName("config")
+	config.AddConfigPath("./")
+	config.AddConfigPath("../consensus/orderer/")
+	config.AddConfigPath("../../consensus/orderer")
+	// Path to look for the config file in based on GOPATH
+	gopath := os.Getenv("GOPATH")
+	for _, p := range filepath.SplitList(gopath) {
+		ordererpath := filepath.Join(p, "src/github.com/hyperledger/fabric/consensus/orderer")
+		config.AddConfigPath(ordererpath)
+	}
+
+	err := config.ReadInConfig()
+	if err != nil {
+		panic(fmt.Errorf("Error reading %s plugin config: %s", configPrefix, err))
+	}
+	return
+}
+
+// dial connects to the ordering service. The connection is not waited
+// for, gRPC reconnects in the background whenever it is lost.
+func dial(address string) (*grpc.ClientConn, error) {
+	var opts []grpc.DialOption
+	if config.GetBool("general.tls.enabled") {
+		sn := config.GetString("general.tls.serverhostoverride")
+		var creds credentials.TransportAuthenticator
+		if file := config.GetString("general.tls.rootcert.file"); file != "" {
+			var err error
+			creds, err = credentials.NewClientTLSFromFile(file, sn)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to create TLS credentials: %s", err)
+			}
+		} else {
+			creds = credentials.NewClientTLSFromCert(nil, sn)
+		}
+		opts = append(opts, grpc.WithTransportCredentials(creds))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	return grpc.Dial(address, opts...)
+}
+
+// =============================================================================
+// obcOrderer
+// =============================================================================
+
+type obcOrderer struct {
+	stack     consensus.Stack
+	client    pb.AtomicBroadcastClient
+	broadcast time.Duration
+	reconnect time.Duration
+	closed    chan struct{}
+	done      chan struct{}
+}
+
+func newObcOrderer(stack consensus.Stack, client pb.AtomicBroadcastClient, broadcast, reconnect time.Duration) *obcOrderer {
+	op := &obcOrderer{
+		stack:     stack,
+		client:    client,
+		broadcast: broadcast,
+		reconnect: reconnect,
+		closed:    make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go op.main()
+	return op
+}
+
+// RecvMsg is called by the stack when a new message is received
+func (op *obcOrderer) RecvMsg(ocMsg *pb.Message, senderHandle *pb.PeerID) error {
+	if ocMsg.Type != pb.Message_CHAIN_TRANSACTION {
+		return fmt.Errorf("Unexpected message type: %s", ocMsg.Type)
+	}
+	tx := &pb.Transaction{}
+	if err := proto.Unmarshal(ocMsg.Payload, tx); err != nil {
+		return fmt.Errorf("Error unpacking payload from message: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), op.broadcast)
+	defer cancel()
+	resp, err := op.client.Broadcast(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("Could not broadcast transaction %s to the ordering service: %s", tx.Uuid, err)
+	}
+	if resp.Status != pb.BroadcastResponse_SUCCESS {
+		return fmt.Errorf("Ordering service turned down transaction %s: %s %s", tx.Uuid, resp.Status, resp.Info)
+	}
+	return nil
+}
+
+// StateUpdated is a signal from the stack that it has fast-forwarded its
+// state, which never happens as batches are only taken from the ordering
+// service
+func (op *obcOrderer) StateUpdated(tag uint64, id []byte) {
+	logger.Warning("Unexpected state transfer to %d", tag)
+}
+
+// StateUpdating is a signal from the stack that state transfer has started
+func (op *obcOrderer) StateUpdating(tag uint64, id []byte) {
+	logger.Warning("Unexpected state transfer to %d", tag)
+}
+
+// Close stops the delivery of batches
+func (op *obcOrderer) Close() {
+	close(op.closed)
+	<-op.done
+}
+
+// main has the batches delivered and executes them, requesting delivery
+// again from the next batch whenever it stops
+func (op *obcOrderer) main() {
+	defer close(op.done)
+	for {
+		if err := op.deliver(); err != nil {
+			logger.Warning("Delivery from the ordering service stopped: %s", err)
+		}
+		select {
+		case <-op.closed:
+			return
+		case <-time.After(op.reconnect):
+		}
+	}
+}
+
+func (op *obcOrderer) deliver() error {
+	last, err := op.getLastBatch()
+	if err != nil {
+		return err
+	}
+	start := uint64(0)
+	if last != nil {
+		start = last.BatchNumber + 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-op.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	stream, err := op.client.Deliver(ctx, &pb.DeliverRequest{Start: start})
+	if err != nil {
+		return err
+	}
+	logger.Info("Requested delivery of the batches from %d", start)
+	for {
+		batch, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if last, err = op.execute(batch, last); err != nil {
+			return err
+		}
+	}
+}
+
+// execute commits a batch as a block, once checked that it follows the
+// last one, and returns the metadata of the block
+func (op *obcOrderer) execute(batch *pb.OrderedBatch, last *Metadata) (*Metadata, error) {
+	var number uint64
+	var previous []byte
+	if last != nil {
+		number, previous = last.BatchNumber+1, last.BatchHash
+	}
+	if batch.Number != number {
+		return nil, fmt.Errorf("Ordering service delivered batch %d, expected %d", batch.Number, number)
+	}
+	if !bytes.Equal(batch.PreviousBatchHash, previous) {
+		return nil, fmt.Errorf("Batch %d is not chained to the batch executed last", batch.Number)
+	}
+	hash, err := batch.GetHash()
+	if err != nil {
+		return nil, err
+	}
+	meta := &Metadata{BatchNumber: batch.Number, BatchHash: hash}
+	raw, err := proto.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	id := batch.Number
+	if err = op.stack.BeginTxBatch(id); err != nil {
+		return nil, fmt.Errorf("Could not begin batch %d: %s", id, err)
+	}
+	// errors of ExecTxs are ledger errors, transaction errors are recorded in the block
+	if _, err = op.stack.ExecTxs(id, batch.Transactions); err != nil {
+		op.stack.RollbackTxBatch(id)
+		return nil, fmt.Errorf("Could not execute batch %d: %s", id, err)
+	}
+	if _, err = op.stack.CommitTxBatch(id, raw); err != nil {
+		op.stack.RollbackTxBatch(id)
+		return nil, fmt.Errorf("Could not commit batch %d: %s", id, err)
+	}
+	logger.Debug("Committed batch %d of %d transactions", id, len(batch.Transactions))
+	return meta, nil
+}
+
+// getLastBatch returns the metadata of the batch executed last, nil if
+// none was
+func (op *obcOrderer) getLastBatch() (*Metadata, error) {
+	raw, err := op.stack.GetBlockHeadMetadata()
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	meta := &Metadata{}
+	if err = proto.Unmarshal(raw, meta); err != nil {
+		return nil, fmt.Errorf("Could not unmarshal the metadata of the last block: %s", err)
+	}
+	return meta, nil
+}
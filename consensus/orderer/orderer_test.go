@@ -0,0 +1,178 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orderer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+
+	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/consensus/util"
+	"github.com/hyperledger/fabric/orderer/solo"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// mockStack keeps the transactions and metadata of the blocks committed
+type mockStack struct {
+	consensus.Stack
+	lock     sync.Mutex
+	pending  []*pb.Transaction
+	blocks   [][]*pb.Transaction
+	metadata [][]byte
+}
+
+func (s *mockStack) BeginTxBatch(id interface{}) error {
+	return nil
+}
+
+func (s *mockStack) ExecTxs(id interface{}, txs []*pb.Transaction) ([]byte, error) {
+	s.pending = txs
+	return nil, nil
+}
+
+func (s *mockStack) CommitTxBatch(id interface{}, metadata []byte) (*pb.Block, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.blocks = append(s.blocks, s.pending)
+	s.metadata = append(s.metadata, metadata)
+	return nil, nil
+}
+
+func (s *mockStack) RollbackTxBatch(id interface{}) error {
+	s.pending = nil
+	return nil
+}
+
+func (s *mockStack) GetBlockHeadMetadata() ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.metadata) == 0 {
+		return nil, nil
+	}
+	return s.metadata[len(s.metadata)-1], nil
+}
+
+func (s *mockStack) height() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.blocks)
+}
+
+// startOrderer serves a solo ordering service, and returns a client of it
+func startOrderer(t *testing.T) (pb.AtomicBroadcastClient, func()) {
+	dir, err := ioutil.TempDir("", "orderer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err := solo.NewLog(filepath.Join(dir, "batches"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := solo.NewOrderer(log, 1, util.NewBatchTimeout(0, time.Second), 10)
+	srv := grpc.NewServer()
+	pb.RegisterAtomicBroadcastServer(srv, o)
+	sock, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(sock)
+
+	conn, err := grpc.Dial(sock.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pb.NewAtomicBroadcastClient(conn), func() {
+		conn.Close()
+		srv.Stop()
+		o.Stop()
+		log.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func broadcast(t *testing.T, op *obcOrderer, i int) {
+	raw, _ := proto.Marshal(&pb.Transaction{Uuid: fmt.Sprintf("tx%d", i)})
+	if err := op.RecvMsg(&pb.Message{Type: pb.Message_CHAIN_TRANSACTION, Payload: raw}, nil); err != nil {
+		t.Fatalf("Could not broadcast transaction %d: %s", i, err)
+	}
+}
+
+func waitHeight(t *testing.T, stack *mockStack, height int) {
+	for i := 0; stack.height() < height; i++ {
+		if i == 100 {
+			t.Fatalf("Expected %d blocks, got %d", height, stack.height())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExecuteDelivered(t *testing.T) {
+	client, stop := startOrderer(t)
+	defer stop()
+
+	stack := &mockStack{}
+	op := newObcOrderer(stack, client, time.Second, 10*time.Millisecond)
+	broadcast(t, op, 0)
+	broadcast(t, op, 1)
+	waitHeight(t, stack, 2)
+	op.Close()
+
+	// a validator restarting resumes from the batch after its last block
+	op = newObcOrderer(stack, client, time.Second, 10*time.Millisecond)
+	defer op.Close()
+	broadcast(t, op, 2)
+	waitHeight(t, stack, 3)
+
+	time.Sleep(50 * time.Millisecond)
+	if stack.height() != 3 {
+		t.Fatalf("Expected 3 blocks, got %d", stack.height())
+	}
+	for i, block := range stack.blocks {
+		if len(block) != 1 || block[0].Uuid != fmt.Sprintf("tx%d", i) {
+			t.Fatalf("Block %d does not hold tx%d: %v", i, i, block)
+		}
+	}
+}
+
+func TestExecuteOutOfOrder(t *testing.T) {
+	stack := &mockStack{}
+	op := &obcOrderer{stack: stack}
+
+	first := &pb.OrderedBatch{Number: 0, Transactions: []*pb.Transaction{{Uuid: "tx0"}}}
+	last, err := op.execute(first, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = op.execute(&pb.OrderedBatch{Number: 2}, last); err == nil {
+		t.Fatal("Expected a gap in the batches to be rejected")
+	}
+	if _, err = op.execute(&pb.OrderedBatch{Number: 1, PreviousBatchHash: []byte("forged")}, last); err == nil {
+		t.Fatal("Expected a batch not chained to the last one to be rejected")
+	}
+	if stack.height() != 1 {
+		t.Fatalf("Expected 1 block, got %d", stack.height())
+	}
+}
@@ -0,0 +1,134 @@
+// Code generated by protoc-gen-go.
+// source: raft/messages.proto
+// DO NOT EDIT!
+
+/*
+Package raft is a generated protocol buffer package.
+
+It is generated from these files:
+	raft/messages.proto
+
+It has these top-level messages:
+	Message
+	Entry
+	Snapshot
+	HardState
+	Metadata
+*/
+package raft
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+type Message_Type int32
+
+const (
+	Message_REQUEST         Message_Type = 0
+	Message_APPEND          Message_Type = 1
+	Message_APPEND_RESPONSE Message_Type = 2
+	Message_VOTE            Message_Type = 3
+	Message_VOTE_RESPONSE   Message_Type = 4
+	Message_SNAPSHOT        Message_Type = 5
+)
+
+var Message_Type_name = map[int32]string{
+	0: "REQUEST",
+	1: "APPEND",
+	2: "APPEND_RESPONSE",
+	3: "VOTE",
+	4: "VOTE_RESPONSE",
+	5: "SNAPSHOT",
+}
+var Message_Type_value = map[string]int32{
+	"REQUEST":         0,
+	"APPEND":          1,
+	"APPEND_RESPONSE": 2,
+	"VOTE":            3,
+	"VOTE_RESPONSE":   4,
+	"SNAPSHOT":        5,
+}
+
+func (x Message_Type) String() string {
+	return proto.EnumName(Message_Type_name, int32(x))
+}
+
+type Message struct {
+	Type     Message_Type `protobuf:"varint,1,opt,name=type,enum=raft.Message_Type" json:"type,omitempty"`
+	Term     uint64       `protobuf:"varint,2,opt,name=term" json:"term,omitempty"`
+	From     uint64       `protobuf:"varint,3,opt,name=from" json:"from,omitempty"`
+	Index    uint64       `protobuf:"varint,4,opt,name=index" json:"index,omitempty"`
+	LogTerm  uint64       `protobuf:"varint,5,opt,name=log_term" json:"log_term,omitempty"`
+	Entries  []*Entry     `protobuf:"bytes,6,rep,name=entries" json:"entries,omitempty"`
+	Commit   uint64       `protobuf:"varint,7,opt,name=commit" json:"commit,omitempty"`
+	Reject   bool         `protobuf:"varint,8,opt,name=reject" json:"reject,omitempty"`
+	Snapshot *Snapshot    `protobuf:"bytes,9,opt,name=snapshot" json:"snapshot,omitempty"`
+	Payload  []byte       `protobuf:"bytes,10,opt,name=payload,proto3" json:"payload,omitempty"`
+}
+
+func (m *Message) Reset()         { *m = Message{} }
+func (m *Message) String() string { return proto.CompactTextString(m) }
+func (*Message) ProtoMessage()    {}
+
+func (m *Message) GetEntries() []*Entry {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
+func (m *Message) GetSnapshot() *Snapshot {
+	if m != nil {
+		return m.Snapshot
+	}
+	return nil
+}
+
+type Entry struct {
+	Term  uint64 `protobuf:"varint,1,opt,name=term" json:"term,omitempty"`
+	Index uint64 `protobuf:"varint,2,opt,name=index" json:"index,omitempty"`
+	Data  []byte `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+}
+
+func (m *Entry) Reset()         { *m = Entry{} }
+func (m *Entry) String() string { return proto.CompactTextString(m) }
+func (*Entry) ProtoMessage()    {}
+
+// The log up to index is applied to the ledger, whose blockchain info is id
+type Snapshot struct {
+	Index uint64 `protobuf:"varint,1,opt,name=index" json:"index,omitempty"`
+	Term  uint64 `protobuf:"varint,2,opt,name=term" json:"term,omitempty"`
+	Id    []byte `protobuf:"bytes,3,opt,name=id,proto3" json:"id,omitempty"`
+}
+
+func (m *Snapshot) Reset()         { *m = Snapshot{} }
+func (m *Snapshot) String() string { return proto.CompactTextString(m) }
+func (*Snapshot) ProtoMessage()    {}
+
+type HardState struct {
+	Term uint64 `protobuf:"varint,1,opt,name=term" json:"term,omitempty"`
+	Vote uint64 `protobuf:"varint,2,opt,name=vote" json:"vote,omitempty"`
+}
+
+func (m *HardState) Reset()         { *m = HardState{} }
+func (m *HardState) String() string { return proto.CompactTextString(m) }
+func (*HardState) ProtoMessage()    {}
+
+// Consensus metadata of the blocks
+type Metadata struct {
+	Index uint64 `protobuf:"varint,1,opt,name=index" json:"index,omitempty"`
+	Term  uint64 `protobuf:"varint,2,opt,name=term" json:"term,omitempty"`
+}
+
+func (m *Metadata) Reset()         { *m = Metadata{} }
+func (m *Metadata) String() string { return proto.CompactTextString(m) }
+func (*Metadata) ProtoMessage()    {}
+
+func init() {
+	proto.RegisterEnum("raft.Message_Type", Message_Type_name, Message_Type_value)
+}
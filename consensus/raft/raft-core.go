@@ -0,0 +1,596 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raft
+
+import (
+	"math/rand"
+
+	"github.com/hyperledger/fabric/consensus"
+	"github.com/op/go-logging"
+)
+
+var logger *logging.Logger // package-level logger
+
+func init() {
+	logger = logging.MustGetLogger("consensus/raft")
+}
+
+// =============================================================================
+// custom interfaces and structure definitions
+// =============================================================================
+
+// All methods are called from the thread driving raftCore, and should
+// therefore not call back into raftCore
+type innerStack interface {
+	send(to uint64, msg *Message)
+	execute(entry *Entry) // applies a committed entry to the ledger
+	getState() []byte     // blockchain info of the ledger
+	getLastApplied() (*Metadata, error)
+	skipTo(index uint64, snapshotID []byte, peers []uint64)
+
+	invalidateState()
+	validateState()
+
+	consensus.StatePersistor
+}
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+// noLeader is the leader of a replica that does not know it
+const noLeader = ^uint64(0)
+
+type raftCore struct {
+	consumer innerStack
+
+	id               uint64 // replica ID
+	N                int    // number of replicas in the network
+	electionTicks    int    // ticks without hearing from a leader before campaigning, randomized up to twice this
+	heartbeatTicks   int    // ticks between two heartbeats of the leader
+	snapshotInterval uint64 // entries applied between two snapshots
+	maxAppend        int    // entries sent at once
+
+	// persistent state
+	term     uint64
+	vote     uint64    // replica voted for in term, plus one, 0 if none
+	entries  []*Entry  // the log after the snapshot
+	snapshot *Snapshot // the log up to the snapshot is applied to the ledger
+
+	// volatile state
+	state           role
+	leader          uint64
+	commit          uint64 // highest index known to be committed
+	applied         uint64 // highest index applied to the ledger
+	elapsed         int    // ticks since the last reset of the election or heartbeat timer
+	electionTimeout int    // randomized election timeout of the current term
+
+	votes      map[uint64]bool   // votes granted to the candidate
+	nextIndex  map[uint64]uint64 // next index to send to each replica
+	matchIndex map[uint64]uint64 // highest index replicated on each replica
+
+	skipInProgress bool      // set while the ledger is brought to skipTarget by state transfer
+	skipTarget     *Snapshot // snapshot being transferred
+}
+
+// =============================================================================
+// constructors
+// =============================================================================
+
+func newRaftCore(id uint64, N int, electionTicks, heartbeatTicks int, snapshotInterval uint64, maxAppend int, consumer innerStack) *raftCore {
+	instance := &raftCore{
+		consumer:         consumer,
+		id:               id,
+		N:                N,
+		electionTicks:    electionTicks,
+		heartbeatTicks:   heartbeatTicks,
+		snapshotInterval: snapshotInterval,
+		maxAppend:        maxAppend,
+		snapshot:         &Snapshot{},
+		leader:           noLeader,
+	}
+
+	instance.restoreState()
+	instance.resetElectionTimeout()
+
+	logger.Info("Raft replica %d of %d: election ticks %d, heartbeat ticks %d, snapshot interval %d",
+		id, N, electionTicks, heartbeatTicks, snapshotInterval)
+
+	return instance
+}
+
+// =============================================================================
+// helper functions
+// =============================================================================
+
+// quorum is the number of replicas making a majority
+func (instance *raftCore) quorum() int {
+	return instance.N/2 + 1
+}
+
+func (instance *raftCore) lastIndex() uint64 {
+	return instance.snapshot.Index + uint64(len(instance.entries))
+}
+
+func (instance *raftCore) lastTerm() uint64 {
+	term, _ := instance.termAt(instance.lastIndex())
+	return term
+}
+
+// termAt returns the term of the entry at index, if it is in the log or
+// is the last one of the snapshot
+func (instance *raftCore) termAt(index uint64) (uint64, bool) {
+	if index == instance.snapshot.Index {
+		return instance.snapshot.Term, true
+	}
+	if index < instance.snapshot.Index || index > instance.lastIndex() {
+		return 0, false
+	}
+	return instance.entries[index-instance.snapshot.Index-1].Term, true
+}
+
+func (instance *raftCore) entry(index uint64) *Entry {
+	return instance.entries[index-instance.snapshot.Index-1]
+}
+
+// isUpToDate tells whether a log ending with an entry at index in term is
+// at least as up to date as the log of the replica
+func (instance *raftCore) isUpToDate(index, term uint64) bool {
+	lastTerm := instance.lastTerm()
+	return term > lastTerm || (term == lastTerm && index >= instance.lastIndex())
+}
+
+func (instance *raftCore) resetElectionTimeout() {
+	instance.elapsed = 0
+	instance.electionTimeout = instance.electionTicks + rand.Intn(instance.electionTicks)
+}
+
+func (instance *raftCore) peers() []uint64 {
+	var peers []uint64
+	for i := uint64(0); i < uint64(instance.N); i++ {
+		if i != instance.id {
+			peers = append(peers, i)
+		}
+	}
+	return peers
+}
+
+func (instance *raftCore) isLeader() bool {
+	return instance.state == leader
+}
+
+// =============================================================================
+// state transitions
+// =============================================================================
+
+func (instance *raftCore) becomeFollower(term uint64, leader uint64) {
+	if term != instance.term {
+		instance.term = term
+		instance.vote = 0
+		instance.persistHardState()
+	}
+	if instance.state != follower {
+		logger.Info("Raft replica %d becomes follower in term %d", instance.id, term)
+	}
+	instance.state = follower
+	instance.leader = leader
+	instance.resetElectionTimeout()
+}
+
+func (instance *raftCore) campaign() {
+	instance.state = candidate
+	instance.term++
+	instance.vote = instance.id + 1
+	instance.leader = noLeader
+	instance.persistHardState()
+	instance.resetElectionTimeout()
+	instance.votes = map[uint64]bool{instance.id: true}
+
+	logger.Info("Raft replica %d campaigns in term %d", instance.id, instance.term)
+
+	if instance.quorum() == 1 {
+		instance.becomeLeader()
+		return
+	}
+	for _, peer := range instance.peers() {
+		instance.consumer.send(peer, &Message{Type: Message_VOTE, Term: instance.term, From: instance.id,
+			Index: instance.lastIndex(), LogTerm: instance.lastTerm()})
+	}
+}
+
+func (instance *raftCore) becomeLeader() {
+	logger.Info("Raft replica %d becomes leader in term %d", instance.id, instance.term)
+
+	instance.state = leader
+	instance.leader = instance.id
+	instance.elapsed = 0
+	instance.nextIndex = make(map[uint64]uint64)
+	instance.matchIndex = make(map[uint64]uint64)
+	for _, peer := range instance.peers() {
+		instance.nextIndex[peer] = instance.lastIndex() + 1
+	}
+
+	// entries of previous terms are only committed along with an entry of
+	// the current term
+	instance.appendEntry(nil)
+}
+
+// =============================================================================
+// receive methods
+// =============================================================================
+
+// tick advances the election and heartbeat timers by one tick
+func (instance *raftCore) tick() {
+	instance.elapsed++
+
+	if instance.state == leader {
+		if instance.elapsed >= instance.heartbeatTicks {
+			instance.elapsed = 0
+			instance.broadcastAppend()
+		}
+		return
+	}
+
+	if instance.elapsed >= instance.electionTimeout {
+		instance.campaign()
+	}
+}
+
+// propose appends data to the log of the leader, returning false if the
+// replica is not the leader
+func (instance *raftCore) propose(data []byte) bool {
+	if instance.state != leader {
+		return false
+	}
+	instance.appendEntry(data)
+	return true
+}
+
+// step handles a message of another replica
+func (instance *raftCore) step(msg *Message) {
+	if msg.Term > instance.term {
+		leader := noLeader
+		if msg.Type == Message_APPEND || msg.Type == Message_SNAPSHOT {
+			leader = msg.From
+		}
+		logger.Debug("Raft replica %d received %s of term %d from %d while in term %d",
+			instance.id, msg.Type, msg.Term, msg.From, instance.term)
+		instance.becomeFollower(msg.Term, leader)
+	}
+
+	if msg.Term < instance.term {
+		// let a stale leader or candidate learn the current term
+		switch msg.Type {
+		case Message_APPEND, Message_SNAPSHOT:
+			instance.consumer.send(msg.From, &Message{Type: Message_APPEND_RESPONSE, Term: instance.term, From: instance.id, Reject: true, Index: msg.Index})
+		case Message_VOTE:
+			instance.consumer.send(msg.From, &Message{Type: Message_VOTE_RESPONSE, Term: instance.term, From: instance.id, Reject: true})
+		}
+		return
+	}
+
+	switch msg.Type {
+	case Message_VOTE:
+		instance.recvVote(msg)
+	case Message_VOTE_RESPONSE:
+		instance.recvVoteResponse(msg)
+	case Message_APPEND:
+		instance.becomeFollower(msg.Term, msg.From)
+		instance.recvAppend(msg)
+	case Message_APPEND_RESPONSE:
+		instance.recvAppendResponse(msg)
+	case Message_SNAPSHOT:
+		instance.becomeFollower(msg.Term, msg.From)
+		instance.recvSnapshot(msg)
+	default:
+		logger.Warning("Raft replica %d received unexpected message %s from %d", instance.id, msg.Type, msg.From)
+	}
+}
+
+func (instance *raftCore) recvVote(msg *Message) {
+	grant := (instance.vote == 0 || instance.vote == msg.From+1) && instance.isUpToDate(msg.Index, msg.LogTerm)
+	if grant {
+		instance.vote = msg.From + 1
+		instance.persistHardState()
+		instance.resetElectionTimeout()
+	}
+	logger.Debug("Raft replica %d votes for %d in term %d: %v", instance.id, msg.From, instance.term, grant)
+	instance.consumer.send(msg.From, &Message{Type: Message_VOTE_RESPONSE, Term: instance.term, From: instance.id, Reject: !grant})
+}
+
+func (instance *raftCore) recvVoteResponse(msg *Message) {
+	if instance.state != candidate {
+		return
+	}
+	instance.votes[msg.From] = !msg.Reject
+
+	granted := 0
+	for _, vote := range instance.votes {
+		if vote {
+			granted++
+		}
+	}
+	if granted >= instance.quorum() {
+		instance.becomeLeader()
+	}
+}
+
+func (instance *raftCore) recvAppend(msg *Message) {
+	if instance.skipInProgress {
+		logger.Debug("Raft replica %d ignores entries from %d while transferring state", instance.id, msg.From)
+		return
+	}
+
+	reply := &Message{Type: Message_APPEND_RESPONSE, Term: instance.term, From: instance.id}
+	if msg.Index < instance.commit {
+		// the committed entries match those of the leader
+		reply.Index = instance.commit
+		instance.consumer.send(msg.From, reply)
+		return
+	}
+
+	if term, ok := instance.termAt(msg.Index); !ok || term != msg.LogTerm {
+		reply.Reject = true
+		reply.Index = msg.Index - 1
+		if last := instance.lastIndex(); last < reply.Index {
+			reply.Index = last
+		}
+		logger.Debug("Raft replica %d rejects entries from %d after index %d, probing from %d", instance.id, msg.From, msg.Index, reply.Index)
+		instance.consumer.send(msg.From, reply)
+		return
+	}
+
+	for i, entry := range msg.Entries {
+		if term, ok := instance.termAt(entry.Index); ok {
+			if term == entry.Term {
+				continue
+			}
+			logger.Info("Raft replica %d discards conflicting entries from index %d", instance.id, entry.Index)
+			instance.truncate(entry.Index)
+		}
+		instance.appendEntries(msg.Entries[i:])
+		break
+	}
+
+	last := msg.Index + uint64(len(msg.Entries))
+	if commit := min(msg.Commit, last); commit > instance.commit {
+		instance.commit = commit
+		instance.applyCommitted()
+	}
+
+	reply.Index = last
+	instance.consumer.send(msg.From, reply)
+}
+
+func (instance *raftCore) recvAppendResponse(msg *Message) {
+	if instance.state != leader {
+		return
+	}
+
+	if msg.Reject {
+		next := instance.nextIndex[msg.From]
+		if msg.Index+1 < next {
+			next = msg.Index + 1
+		} else if next > 1 {
+			next--
+		}
+		instance.nextIndex[msg.From] = next
+		instance.sendAppend(msg.From)
+		return
+	}
+
+	if msg.Index > instance.matchIndex[msg.From] {
+		instance.matchIndex[msg.From] = msg.Index
+	}
+	if msg.Index+1 > instance.nextIndex[msg.From] {
+		instance.nextIndex[msg.From] = msg.Index + 1
+	}
+	if instance.maybeCommit() {
+		instance.broadcastAppend()
+	} else if instance.nextIndex[msg.From] <= instance.lastIndex() {
+		instance.sendAppend(msg.From)
+	}
+}
+
+func (instance *raftCore) recvSnapshot(msg *Message) {
+	snapshot := msg.Snapshot
+	if snapshot == nil {
+		return
+	}
+
+	reply := &Message{Type: Message_APPEND_RESPONSE, Term: instance.term, From: instance.id}
+	if snapshot.Index <= instance.commit {
+		reply.Index = instance.commit
+		instance.consumer.send(msg.From, reply)
+		return
+	}
+	if term, ok := instance.termAt(snapshot.Index); ok && term == snapshot.Term {
+		// the log holds the entries of the snapshot
+		instance.commit = snapshot.Index
+		instance.applyCommitted()
+		reply.Index = snapshot.Index
+		instance.consumer.send(msg.From, reply)
+		return
+	}
+	if instance.skipInProgress && instance.skipTarget.Index >= snapshot.Index {
+		return
+	}
+
+	logger.Info("Raft replica %d is behind the snapshot at index %d of %d, transferring state", instance.id, snapshot.Index, msg.From)
+	instance.skipTo(snapshot, []uint64{msg.From})
+}
+
+// stateUpdated is called once state transfer brought the ledger to the
+// snapshot at index
+func (instance *raftCore) stateUpdated(index uint64, id []byte) {
+	if !instance.skipInProgress || index != instance.skipTarget.Index {
+		logger.Debug("Raft replica %d ignores state transfer to index %d", instance.id, index)
+		return
+	}
+
+	snapshot := instance.skipTarget
+	instance.skipInProgress = false
+	instance.skipTarget = nil
+
+	// the entries following the snapshot are kept if they match it
+	first, last := instance.snapshot.Index+1, instance.lastIndex()
+	if term, ok := instance.termAt(snapshot.Index); ok && term == snapshot.Term {
+		instance.entries = instance.entries[snapshot.Index-instance.snapshot.Index:]
+		last = snapshot.Index
+	} else {
+		instance.entries = nil
+	}
+	instance.snapshot = &Snapshot{Index: snapshot.Index, Term: snapshot.Term, Id: instance.consumer.getState()}
+	instance.persistSnapshot()
+	instance.persistDelEntries(first, last)
+	instance.commit = max(instance.commit, snapshot.Index)
+	instance.applied = snapshot.Index
+	instance.consumer.validateState()
+
+	logger.Info("Raft replica %d caught up to index %d by state transfer", instance.id, snapshot.Index)
+
+	if instance.leader != noLeader && instance.leader != instance.id {
+		instance.consumer.send(instance.leader, &Message{Type: Message_APPEND_RESPONSE, Term: instance.term, From: instance.id, Index: snapshot.Index})
+	}
+	instance.applyCommitted()
+}
+
+// =============================================================================
+// log replication
+// =============================================================================
+
+func (instance *raftCore) appendEntry(data []byte) {
+	instance.appendEntries([]*Entry{{Term: instance.term, Index: instance.lastIndex() + 1, Data: data}})
+	instance.maybeCommit()
+	instance.broadcastAppend()
+}
+
+func (instance *raftCore) appendEntries(entries []*Entry) {
+	instance.entries = append(instance.entries, entries...)
+	instance.persistEntries(entries)
+}
+
+// truncate discards the entries of the log from index on
+func (instance *raftCore) truncate(index uint64) {
+	instance.persistDelEntries(index, instance.lastIndex())
+	instance.entries = instance.entries[:index-instance.snapshot.Index-1]
+}
+
+func (instance *raftCore) broadcastAppend() {
+	for _, peer := range instance.peers() {
+		instance.sendAppend(peer)
+	}
+}
+
+// sendAppend sends the entries a replica is missing, or the snapshot if
+// they were discarded
+func (instance *raftCore) sendAppend(to uint64) {
+	next := instance.nextIndex[to]
+	prevTerm, ok := instance.termAt(next - 1)
+	if !ok {
+		logger.Debug("Raft replica %d sends the snapshot at index %d to %d", instance.id, instance.snapshot.Index, to)
+		instance.consumer.send(to, &Message{Type: Message_SNAPSHOT, Term: instance.term, From: instance.id, Snapshot: instance.snapshot})
+		return
+	}
+
+	var entries []*Entry
+	for index := next; index <= instance.lastIndex() && len(entries) < instance.maxAppend; index++ {
+		entries = append(entries, instance.entry(index))
+	}
+	instance.consumer.send(to, &Message{Type: Message_APPEND, Term: instance.term, From: instance.id,
+		Index: next - 1, LogTerm: prevTerm, Entries: entries, Commit: instance.commit})
+}
+
+// maybeCommit commits the highest entry of the current term a majority
+// of replicas have, and returns whether the commit index advanced
+func (instance *raftCore) maybeCommit() bool {
+	for index := instance.lastIndex(); index > instance.commit; index-- {
+		if term, _ := instance.termAt(index); term != instance.term {
+			break
+		}
+		replicated := 1
+		for _, peer := range instance.peers() {
+			if instance.matchIndex[peer] >= index {
+				replicated++
+			}
+		}
+		if replicated >= instance.quorum() {
+			instance.commit = index
+			instance.applyCommitted()
+			return true
+		}
+	}
+	return false
+}
+
+// applyCommitted applies the committed entries not applied yet, then takes
+// a snapshot if snapshotInterval entries were applied since the last one
+func (instance *raftCore) applyCommitted() {
+	if instance.skipInProgress {
+		return
+	}
+	for instance.applied < instance.commit {
+		instance.applied++
+		if entry := instance.entry(instance.applied); entry.Data != nil {
+			instance.consumer.execute(entry)
+		}
+	}
+
+	if instance.snapshotInterval > 0 && instance.applied-instance.snapshot.Index >= instance.snapshotInterval {
+		instance.takeSnapshot()
+	}
+}
+
+// takeSnapshot discards the applied entries of the log, the ledger holding
+// their outcome
+func (instance *raftCore) takeSnapshot() {
+	term, _ := instance.termAt(instance.applied)
+	snapshot := &Snapshot{Index: instance.applied, Term: term, Id: instance.consumer.getState()}
+	first := instance.snapshot.Index + 1
+	instance.entries = instance.entries[instance.applied-instance.snapshot.Index:]
+	instance.snapshot = snapshot
+	instance.persistSnapshot()
+	instance.persistDelEntries(first, snapshot.Index)
+
+	logger.Debug("Raft replica %d took a snapshot at index %d", instance.id, snapshot.Index)
+}
+
+// skipTo starts transferring the state of snapshot from peers
+func (instance *raftCore) skipTo(snapshot *Snapshot, peers []uint64) {
+	instance.skipInProgress = true
+	instance.skipTarget = snapshot
+	instance.consumer.invalidateState()
+	instance.consumer.skipTo(snapshot.Index, snapshot.Id, peers)
+}
+
+func min(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
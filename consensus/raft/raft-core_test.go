@@ -0,0 +1,370 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raft
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// testReplica is a replica whose ledger is the list of entries it applied
+type testReplica struct {
+	id     uint64
+	net    *testNetwork
+	core   *raftCore
+	state  map[string][]byte
+	ledger []*Entry
+	valid  bool
+
+	skipIndex uint64
+	skipPeers []uint64
+}
+
+type testMessage struct {
+	to  uint64
+	msg *Message
+}
+
+type testNetwork struct {
+	replicas []*testReplica
+	down     map[uint64]bool
+	queue    []testMessage
+}
+
+func newTestNetwork(N int, snapshotInterval uint64) *testNetwork {
+	net := &testNetwork{down: make(map[uint64]bool)}
+	for id := uint64(0); id < uint64(N); id++ {
+		r := &testReplica{id: id, net: net, state: make(map[string][]byte), valid: true}
+		net.replicas = append(net.replicas, r)
+		r.core = newRaftCore(id, N, 10, 2, snapshotInterval, 4, r)
+	}
+	return net
+}
+
+// restart recreates the core of a replica from its persisted state and ledger
+func (net *testNetwork) restart(id uint64) {
+	r := net.replicas[id]
+	r.core = newRaftCore(id, len(net.replicas), 10, 2, r.core.snapshotInterval, 4, r)
+	delete(net.down, id)
+}
+
+func (net *testNetwork) deliver() {
+	for len(net.queue) > 0 {
+		m := net.queue[0]
+		net.queue = net.queue[1:]
+		if net.down[m.to] || net.down[m.msg.From] {
+			continue
+		}
+		net.replicas[m.to].core.step(m.msg)
+	}
+}
+
+func (net *testNetwork) tick(n int) {
+	for i := 0; i < n; i++ {
+		for _, r := range net.replicas {
+			if !net.down[r.id] {
+				r.core.tick()
+			}
+		}
+		net.deliver()
+	}
+}
+
+// leader ticks until a single replica up leads, and returns it
+func (net *testNetwork) leader(t *testing.T) *testReplica {
+	for i := 0; i < 200; i++ {
+		var leaders []*testReplica
+		for _, r := range net.replicas {
+			if !net.down[r.id] && r.core.isLeader() {
+				leaders = append(leaders, r)
+			}
+		}
+		if len(leaders) == 1 {
+			return leaders[0]
+		}
+		net.tick(1)
+	}
+	t.Fatal("No leader elected")
+	return nil
+}
+
+func (net *testNetwork) propose(t *testing.T, r *testReplica, data string) {
+	if !r.core.propose([]byte(data)) {
+		t.Fatalf("Replica %d failed to propose %s", r.id, data)
+	}
+	net.deliver()
+}
+
+func (r *testReplica) send(to uint64, msg *Message) {
+	// messages go through the wire format, as between peers
+	raw, err := proto.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	copy := &Message{}
+	proto.Unmarshal(raw, copy)
+	r.net.queue = append(r.net.queue, testMessage{to, copy})
+}
+
+func (r *testReplica) execute(entry *Entry) {
+	r.ledger = append(r.ledger, entry)
+}
+
+func (r *testReplica) getState() []byte {
+	return []byte(fmt.Sprint(len(r.ledger)))
+}
+
+func (r *testReplica) getLastApplied() (*Metadata, error) {
+	if len(r.ledger) == 0 {
+		return &Metadata{}, nil
+	}
+	last := r.ledger[len(r.ledger)-1]
+	return &Metadata{Index: last.Index, Term: last.Term}, nil
+}
+
+func (r *testReplica) skipTo(index uint64, snapshotID []byte, peers []uint64) {
+	r.skipIndex = index
+	r.skipPeers = peers
+}
+
+func (r *testReplica) invalidateState() {
+	r.valid = false
+}
+
+func (r *testReplica) validateState() {
+	r.valid = true
+}
+
+func (r *testReplica) StoreState(key string, value []byte) error {
+	r.state[key] = value
+	return nil
+}
+
+func (r *testReplica) ReadState(key string) ([]byte, error) {
+	if value, ok := r.state[key]; ok {
+		return value, nil
+	}
+	return nil, fmt.Errorf("No state for key %s", key)
+}
+
+func (r *testReplica) ReadStateSet(prefix string) (map[string][]byte, error) {
+	set := make(map[string][]byte)
+	for key, value := range r.state {
+		if strings.HasPrefix(key, prefix) {
+			set[key] = value
+		}
+	}
+	return set, nil
+}
+
+func (r *testReplica) DelState(key string) {
+	delete(r.state, key)
+}
+
+// transfer brings the ledger of r to the index it skips to, from the ledger
+// of another replica, as state transfer does
+func (r *testReplica) transfer(from *testReplica) {
+	r.ledger = nil
+	for _, entry := range from.ledger {
+		if entry.Index <= r.skipIndex {
+			r.ledger = append(r.ledger, entry)
+		}
+	}
+	r.core.stateUpdated(r.skipIndex, r.getState())
+	r.net.deliver()
+}
+
+func checkLedgers(t *testing.T, net *testNetwork, expected ...string) {
+	for _, r := range net.replicas {
+		if net.down[r.id] {
+			continue
+		}
+		if len(r.ledger) != len(expected) {
+			t.Fatalf("Replica %d applied %d entries, expected %d", r.id, len(r.ledger), len(expected))
+		}
+		for i, entry := range r.ledger {
+			if !bytes.Equal(entry.Data, []byte(expected[i])) {
+				t.Fatalf("Replica %d applied %s at %d, expected %s", r.id, entry.Data, i, expected[i])
+			}
+		}
+	}
+}
+
+func TestElection(t *testing.T) {
+	net := newTestNetwork(3, 0)
+	leader := net.leader(t)
+
+	net.tick(20)
+	for _, r := range net.replicas {
+		if r.core.term != leader.core.term {
+			t.Fatalf("Replica %d is in term %d, the leader in term %d", r.id, r.core.term, leader.core.term)
+		}
+		if r.core.leader != leader.id {
+			t.Fatalf("Replica %d follows %d instead of %d", r.id, r.core.leader, leader.id)
+		}
+	}
+	if !leader.core.isLeader() {
+		t.Fatal("Heartbeats must keep the leader in place")
+	}
+}
+
+func TestReplication(t *testing.T) {
+	net := newTestNetwork(3, 0)
+	leader := net.leader(t)
+
+	var expected []string
+	for i := 0; i < 10; i++ {
+		data := fmt.Sprintf("block%d", i)
+		net.propose(t, leader, data)
+		expected = append(expected, data)
+	}
+	net.tick(4)
+	checkLedgers(t, net, expected...)
+
+	for _, r := range net.replicas {
+		if r.id != leader.id && r.core.propose([]byte("follower")) {
+			t.Fatal("Only the leader must append to the log")
+		}
+	}
+}
+
+func TestLeaderFailover(t *testing.T) {
+	net := newTestNetwork(3, 0)
+	old := net.leader(t)
+	net.propose(t, old, "a")
+	net.propose(t, old, "b")
+	net.tick(4)
+
+	net.down[old.id] = true
+	leader := net.leader(t)
+	if leader.core.term <= old.core.term {
+		t.Fatalf("Expected a new term, got %d after %d", leader.core.term, old.core.term)
+	}
+	net.propose(t, leader, "c")
+	net.tick(4)
+	checkLedgers(t, net, "a", "b", "c")
+
+	// the old leader applied a and b, and catches up as follower once restarted
+	net.restart(old.id)
+	if applied := old.ledger[len(old.ledger)-1].Index; old.core.applied != applied {
+		t.Fatalf("Expected the old leader to restore its applied index %d, got %d", applied, old.core.applied)
+	}
+	net.tick(10)
+	if old.core.isLeader() || old.core.leader != leader.id {
+		t.Fatalf("Expected the old leader to follow %d", leader.id)
+	}
+	checkLedgers(t, net, "a", "b", "c")
+}
+
+func TestUncommittedEntriesDiscarded(t *testing.T) {
+	net := newTestNetwork(3, 0)
+	old := net.leader(t)
+	net.propose(t, old, "a")
+	net.tick(4)
+
+	// entries the isolated leader appends are never committed
+	for _, r := range net.replicas {
+		if r.id != old.id {
+			net.down[r.id] = true
+		}
+	}
+	net.propose(t, old, "lost")
+	net.tick(4)
+	if old.core.commit != old.core.lastIndex()-1 {
+		t.Fatal("An entry not replicated on a majority must not be committed")
+	}
+
+	net.down = map[uint64]bool{old.id: true}
+	leader := net.leader(t)
+	net.propose(t, leader, "b")
+	net.tick(4)
+
+	delete(net.down, old.id)
+	net.tick(20)
+	checkLedgers(t, net, "a", "b")
+	if old.core.lastIndex() != leader.core.lastIndex() {
+		t.Fatalf("Expected the logs to match, got %d and %d", old.core.lastIndex(), leader.core.lastIndex())
+	}
+}
+
+func TestSnapshotTransfer(t *testing.T) {
+	net := newTestNetwork(3, 3)
+	leader := net.leader(t)
+	var lagging *testReplica
+	for _, r := range net.replicas {
+		if r.id != leader.id {
+			lagging = r
+			break
+		}
+	}
+	net.down[lagging.id] = true
+
+	var expected []string
+	for i := 0; i < 7; i++ {
+		data := fmt.Sprintf("block%d", i)
+		net.propose(t, leader, data)
+		expected = append(expected, data)
+	}
+	net.tick(4)
+
+	if leader.core.snapshot.Index == 0 {
+		t.Fatal("Expected the leader to take a snapshot")
+	}
+	if _, ok := leader.state[fmt.Sprintf("raft.entry.%d", leader.core.snapshot.Index)]; ok {
+		t.Fatal("Expected the entries of the snapshot to be deleted")
+	}
+
+	delete(net.down, lagging.id)
+	net.tick(4)
+	if lagging.skipIndex != leader.core.snapshot.Index || lagging.valid {
+		t.Fatalf("Expected replica %d to transfer the state of the snapshot at %d, got %d", lagging.id, leader.core.snapshot.Index, lagging.skipIndex)
+	}
+	if len(lagging.skipPeers) != 1 || lagging.skipPeers[0] != leader.id {
+		t.Fatalf("Expected the state to be transferred from the leader, got %v", lagging.skipPeers)
+	}
+
+	lagging.transfer(leader)
+	if !lagging.valid {
+		t.Fatal("Expected the state to be validated once transferred")
+	}
+	net.tick(4)
+	checkLedgers(t, net, expected...)
+}
+
+func TestRestoreState(t *testing.T) {
+	net := newTestNetwork(1, 2)
+	r := net.leader(t)
+	net.propose(t, r, "a")
+	net.propose(t, r, "b")
+	net.propose(t, r, "c")
+
+	term, last := r.core.term, r.core.lastIndex()
+	net.restart(r.id)
+	if r.core.term != term || r.core.lastIndex() != last || r.core.applied != last {
+		t.Fatalf("Expected term %d and index %d restored, got term %d, index %d, applied %d",
+			term, last, r.core.term, r.core.lastIndex(), r.core.applied)
+	}
+
+	// nothing is applied twice
+	r = net.leader(t)
+	net.propose(t, r, "d")
+	checkLedgers(t, net, "a", "b", "c", "d")
+}
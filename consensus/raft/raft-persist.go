@@ -0,0 +1,148 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raft
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func (instance *raftCore) persistHardState() {
+	raw, err := proto.Marshal(&HardState{Term: instance.term, Vote: instance.vote})
+	if err != nil {
+		logger.Warning("Raft replica %d could not persist hard state: %s", instance.id, err)
+		return
+	}
+	instance.consumer.StoreState("raft.state", raw)
+}
+
+func (instance *raftCore) persistSnapshot() {
+	raw, err := proto.Marshal(instance.snapshot)
+	if err != nil {
+		logger.Warning("Raft replica %d could not persist snapshot: %s", instance.id, err)
+		return
+	}
+	instance.consumer.StoreState("raft.snapshot", raw)
+}
+
+func (instance *raftCore) persistEntries(entries []*Entry) {
+	for _, entry := range entries {
+		raw, err := proto.Marshal(entry)
+		if err != nil {
+			logger.Warning("Raft replica %d could not persist entry %d: %s", instance.id, entry.Index, err)
+			continue
+		}
+		instance.consumer.StoreState(fmt.Sprintf("raft.entry.%d", entry.Index), raw)
+	}
+}
+
+// persistDelEntries deletes the persisted entries from index first to last
+func (instance *raftCore) persistDelEntries(first, last uint64) {
+	for index := first; index <= last; index++ {
+		instance.consumer.DelState(fmt.Sprintf("raft.entry.%d", index))
+	}
+}
+
+func (instance *raftCore) restoreState() {
+	if raw, err := instance.consumer.ReadState("raft.state"); err == nil {
+		hs := &HardState{}
+		if err = proto.Unmarshal(raw, hs); err != nil {
+			logger.Error("Raft replica %d could not unmarshal hard state - local state is damaged: %s", instance.id, err)
+		} else {
+			instance.term, instance.vote = hs.Term, hs.Vote
+		}
+	}
+
+	if raw, err := instance.consumer.ReadState("raft.snapshot"); err == nil {
+		snapshot := &Snapshot{}
+		if err = proto.Unmarshal(raw, snapshot); err != nil {
+			logger.Error("Raft replica %d could not unmarshal snapshot - local state is damaged: %s", instance.id, err)
+		} else {
+			instance.snapshot = snapshot
+		}
+	}
+
+	entries, err := instance.consumer.ReadStateSet("raft.entry.")
+	if err != nil {
+		logger.Warning("Raft replica %d could not restore entries: %s", instance.id, err)
+	}
+	var restored []*Entry
+	for key, raw := range entries {
+		entry := &Entry{}
+		if err := proto.Unmarshal(raw, entry); err != nil {
+			logger.Warning("Raft replica %d could not restore entry %s", instance.id, key)
+			continue
+		}
+		if entry.Index > instance.snapshot.Index {
+			restored = append(restored, entry)
+		}
+	}
+	sort.Sort(entriesByIndex(restored))
+	for _, entry := range restored {
+		// only the entries following the snapshot without a gap make the log
+		if entry.Index != instance.lastIndex()+1 {
+			break
+		}
+		instance.entries = append(instance.entries, entry)
+	}
+
+	instance.restoreApplied()
+
+	logger.Info("Raft replica %d restored state: term: %d, snapshot: %d, last index: %d, applied: %d",
+		instance.id, instance.term, instance.snapshot.Index, instance.lastIndex(), instance.applied)
+}
+
+// restoreApplied reads the index of the last entry applied to the ledger
+// from the metadata of its head block
+func (instance *raftCore) restoreApplied() {
+	meta, err := instance.consumer.getLastApplied()
+	if err != nil {
+		logger.Warning("Raft replica %d could not restore the last applied entry: %s", instance.id, err)
+		meta = &Metadata{}
+	}
+
+	switch {
+	case meta.Index > instance.lastIndex():
+		// the ledger is ahead of the log, which becomes a snapshot of the ledger
+		instance.persistDelEntries(instance.snapshot.Index+1, instance.lastIndex())
+		instance.entries = nil
+		instance.snapshot = &Snapshot{Index: meta.Index, Term: meta.Term, Id: instance.consumer.getState()}
+		instance.persistSnapshot()
+		instance.applied = meta.Index
+	case meta.Index < instance.snapshot.Index:
+		// the ledger is behind the snapshot, as state transfer was interrupted
+		instance.applied = meta.Index
+		instance.skipTo(instance.snapshot, instance.peers())
+	default:
+		instance.applied = meta.Index
+	}
+	instance.commit = max(instance.applied, instance.snapshot.Index)
+}
+
+type entriesByIndex []*Entry
+
+func (a entriesByIndex) Len() int {
+	return len(a)
+}
+func (a entriesByIndex) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+func (a entriesByIndex) Less(i, j int) bool {
+	return a[i].Index < a[j].Index
+}
@@ -0,0 +1,413 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raft
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/consensus/util"
+	pb "github.com/hyperledger/fabric/protos"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+)
+
+const configPrefix = "CORE_RAFT"
+
+var pluginInstance consensus.Consenter // singleton service
+var config *viper.Viper
+
+func init() {
+	config = loadConfig()
+}
+
+// GetPlugin returns the handle to the Consenter singleton
+func GetPlugin(c consensus.Stack) consensus.Consenter {
+	if pluginInstance == nil {
+		pluginInstance = New(c)
+	}
+	return pluginInstance
+}
+
+// New creates a new Raft instance that provides the Consenter interface.
+// Raft tolerates the crash of a minority of the validators, but not
+// byzantine ones.
+func New(stack consensus.Stack) consensus.Consenter {
+	handle, _, _ := stack.GetNetworkHandles()
+	id, err := getValidatorID(handle)
+	if err != nil {
+		panic(err)
+	}
+
+	conf := config
+	if inquirer, ok := stack.(consensus.ChainInquirer); ok && inquirer.GetValidatorCount() > 0 {
+		// the Raft network of a chain is made of the validators of the chain
+		conf = loadConfig()
+		conf.Set("general.N", inquirer.GetValidatorCount())
+	}
+
+	return newObcRaft(id, conf, stack)
+}
+
+func loadConfig() (config *viper.Viper) {
+	config = viper.New()
+
+	// for environment variables
+	config.SetEnvPrefix(configPrefix)
+	config.AutomaticEnv()
+	replacer := strings.NewReplacer(".", "_")
+	config.SetEnvKeyReplacer(replacer)
+
+	config.SetConfigName("config")
+	config.AddConfigPath("./")
+	config.AddConfigPath("../consensus/raft/")
+	config.AddConfigPath("../../consensus/raft")
+	// Path to look for the config file in based on GOPATH
+	gopath := os.Getenv("GOPATH")
+	for _, p := range filepath.SplitList(gopath) {
+		raftpath := filepath.Join(p, "src/github.com/hyperledger/fabric/consensus/raft")
+		config.AddConfigPath(raftpath)
+	}
+
+	err := config.ReadInConfig()
+	if err != nil {
+		panic(fmt.Errorf("Error reading %s plugin config: %s", configPrefix, err))
+	}
+	return
+}
+
+// Returns the uint64 ID corresponding to a peer handle
+func getValidatorID(handle *pb.PeerID) (id uint64, err error) {
+	if startsWith := strings.HasPrefix(handle.Name, "vp"); startsWith {
+		id, err = strconv.ParseUint(handle.Name[2:], 10, 64)
+		if err != nil {
+			return id, fmt.Errorf("Error extracting ID from \"%s\" handle: %v", handle.Name, err)
+		}
+		return
+	}
+
+	err = fmt.Errorf(`For Raft, set the VP's peer.id to vpX,
+		where X is a unique integer between 0 and N-1
+		(N being the number of VPs in the network)`)
+	return
+}
+
+// Returns the peer handle that corresponds to a validator ID
+func getValidatorHandle(id uint64) *pb.PeerID {
+	return &pb.PeerID{Name: "vp" + strconv.FormatUint(id, 10)}
+}
+
+// Returns the peer handles corresponding to a list of replica ids
+func getValidatorHandles(ids []uint64) (handles []*pb.PeerID) {
+	for _, id := range ids {
+		handles = append(handles, getValidatorHandle(id))
+	}
+	return
+}
+
+// =============================================================================
+// obcRaft
+// =============================================================================
+
+type obcRaft struct {
+	stack consensus.Stack
+	consensus.StatePersistor
+	raft *raftCore
+	id   uint64
+
+	tick             time.Duration
+	batchSize        int
+	batchTimeouts    *util.BatchTimeout
+	batchStore       [][]byte // transactions the leader batches
+	batchTimer       *time.Timer
+	batchTimerActive bool
+	pending          [][]byte // transactions waiting for a leader to be known
+
+	events chan interface{}
+	closed chan struct{}
+}
+
+type raftMessageEvent struct {
+	msg *Message
+}
+
+type requestEvent struct {
+	payload []byte
+}
+
+type stateUpdatedEvent struct {
+	index uint64
+	id    []byte
+}
+
+func newObcRaft(id uint64, config *viper.Viper, stack consensus.Stack) *obcRaft {
+	op := &obcRaft{stack: stack, StatePersistor: stack, id: id}
+
+	parse := func(key string) time.Duration {
+		d, err := time.ParseDuration(config.GetString(key))
+		if err != nil || d <= 0 {
+			panic(fmt.Errorf("Cannot parse %s: %s", key, config.GetString(key)))
+		}
+		return d
+	}
+	op.tick = parse("general.timeout.tick")
+	election := parse("general.timeout.election")
+	heartbeat := parse("general.timeout.heartbeat")
+	var batchTimeoutMin time.Duration
+	if config.IsSet("general.timeout.batchmin") {
+		batchTimeoutMin = parse("general.timeout.batchmin")
+	}
+	op.batchTimeouts = util.NewBatchTimeout(batchTimeoutMin, parse("general.timeout.batch"))
+	if heartbeat < op.tick || election <= heartbeat {
+		panic(fmt.Errorf("The Raft heartbeat timeout must be at least a tick and less than the election timeout"))
+	}
+
+	op.batchSize = config.GetInt("general.batchsize")
+	maxAppend := config.GetInt("general.maxappend")
+	if op.batchSize <= 0 || maxAppend <= 0 {
+		panic(fmt.Errorf("The Raft batch size and maxappend must be positive"))
+	}
+
+	N := config.GetInt("general.N")
+	if N <= 0 || id >= uint64(N) {
+		panic(fmt.Errorf("Raft replica %d does not fit in a network of %d replicas", id, N))
+	}
+
+	op.raft = newRaftCore(id, N, int(election/op.tick), int(heartbeat/op.tick),
+		uint64(config.GetInt("general.snapshotinterval")), maxAppend, op)
+
+	op.batchTimer = time.NewTimer(op.batchTimeouts.Timeout())
+	op.batchTimer.Stop()
+	op.events = make(chan interface{})
+	op.closed = make(chan struct{})
+	go op.main()
+
+	return op
+}
+
+// RecvMsg is called by the stack when a new message is received
+func (op *obcRaft) RecvMsg(ocMsg *pb.Message, senderHandle *pb.PeerID) error {
+	switch ocMsg.Type {
+	case pb.Message_CHAIN_TRANSACTION:
+		op.events <- requestEvent{ocMsg.Payload}
+	case pb.Message_CONSENSUS:
+		msg := &Message{}
+		if err := proto.Unmarshal(ocMsg.Payload, msg); err != nil {
+			return fmt.Errorf("Error unpacking payload from message: %s", err)
+		}
+		senderID, err := getValidatorID(senderHandle)
+		if err != nil {
+			return err
+		}
+		msg.From = senderID
+		op.events <- raftMessageEvent{msg}
+	default:
+		return fmt.Errorf("Unexpected message type: %s", ocMsg.Type)
+	}
+	return nil
+}
+
+// StateUpdated is a signal from the stack that it has fast-forwarded its state
+func (op *obcRaft) StateUpdated(index uint64, id []byte) {
+	op.events <- stateUpdatedEvent{index, id}
+}
+
+// StateUpdating is a signal from the stack that state transfer has started
+func (op *obcRaft) StateUpdating(index uint64, id []byte) {
+	logger.Debug("Raft replica %d is transferring state to index %d", op.id, index)
+}
+
+// Close tells us to release resources we are holding
+func (op *obcRaft) Close() {
+	close(op.closed)
+}
+
+// main drives raftCore, all of its methods are called from here
+func (op *obcRaft) main() {
+	ticker := time.NewTicker(op.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-op.closed:
+			op.batchTimer.Stop()
+			return
+		case event := <-op.events:
+			switch et := event.(type) {
+			case requestEvent:
+				op.pending = append(op.pending, et.payload)
+			case raftMessageEvent:
+				if et.msg.Type == Message_REQUEST {
+					op.pending = append(op.pending, et.msg.Payload)
+				} else {
+					op.raft.step(et.msg)
+				}
+			case stateUpdatedEvent:
+				op.raft.stateUpdated(et.index, et.id)
+			}
+		case <-ticker.C:
+			op.raft.tick()
+		case <-op.batchTimer.C:
+			op.batchTimerActive = false
+			if op.raft.isLeader() && len(op.batchStore) > 0 {
+				op.sendBatch()
+			}
+		}
+
+		op.dispatch()
+	}
+}
+
+// dispatch batches the pending transactions on the leader, or forwards
+// them to the leader
+func (op *obcRaft) dispatch() {
+	if !op.raft.isLeader() && len(op.batchStore) > 0 {
+		// leadership was lost before the batch was proposed
+		op.stopBatchTimer()
+		op.pending = append(op.batchStore, op.pending...)
+		op.batchStore = nil
+	}
+
+	switch {
+	case len(op.pending) == 0:
+	case op.raft.isLeader():
+		for _, tx := range op.pending {
+			op.batchStore = append(op.batchStore, tx)
+			if len(op.batchStore) >= op.batchSize {
+				op.sendBatch()
+			}
+		}
+		op.pending = nil
+		if len(op.batchStore) > 0 && !op.batchTimerActive {
+			op.startBatchTimer()
+		}
+	case op.raft.leader != noLeader:
+		for _, tx := range op.pending {
+			op.send(op.raft.leader, &Message{Type: Message_REQUEST, Payload: tx})
+		}
+		op.pending = nil
+	}
+}
+
+// sendBatch proposes the batched transactions as an entry of the log
+func (op *obcRaft) sendBatch() {
+	op.stopBatchTimer()
+
+	block := &pb.TransactionBlock{}
+	for _, raw := range op.batchStore {
+		tx := &pb.Transaction{}
+		if err := proto.Unmarshal(raw, tx); err != nil {
+			logger.Warning("Raft replica %d could not unmarshal transaction: %s", op.id, err)
+			continue
+		}
+		block.Transactions = append(block.Transactions, tx)
+	}
+
+	data, err := proto.Marshal(block)
+	if err != nil {
+		logger.Error("Raft replica %d could not pack batch: %s", op.id, err)
+		return
+	}
+	logger.Info("Raft replica %d proposing batch of %d transactions", op.id, len(block.Transactions))
+	op.batchTimeouts.Sent(len(op.batchStore), op.batchSize)
+	if op.raft.propose(data) {
+		op.batchStore = nil
+	}
+}
+
+func (op *obcRaft) startBatchTimer() {
+	op.batchTimer.Reset(op.batchTimeouts.Timeout())
+	op.batchTimerActive = true
+}
+
+func (op *obcRaft) stopBatchTimer() {
+	op.batchTimer.Stop()
+	op.batchTimerActive = false
+}
+
+// =============================================================================
+// innerStack interface (functions called by raftCore)
+// =============================================================================
+
+func (op *obcRaft) send(to uint64, msg *Message) {
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		logger.Error("Raft replica %d could not marshal %s: %s", op.id, msg.Type, err)
+		return
+	}
+	op.stack.Unicast(&pb.Message{Type: pb.Message_CONSENSUS, Payload: payload}, getValidatorHandle(to))
+}
+
+// execute commits the transactions of an entry as a block of the ledger
+func (op *obcRaft) execute(entry *Entry) {
+	block := &pb.TransactionBlock{}
+	if err := proto.Unmarshal(entry.Data, block); err != nil {
+		logger.Error("Raft replica %d could not unmarshal entry %d: %s", op.id, entry.Index, err)
+		return
+	}
+	meta, _ := proto.Marshal(&Metadata{Index: entry.Index, Term: entry.Term})
+
+	id := entry.Index
+	if err := op.stack.BeginTxBatch(id); err != nil {
+		logger.Error("Raft replica %d could not begin batch of entry %d: %s", op.id, entry.Index, err)
+		return
+	}
+	// errors of ExecTxs are ledger errors, transaction errors are recorded in the block
+	if _, err := op.stack.ExecTxs(id, block.Transactions); err != nil {
+		logger.Error("Raft replica %d could not execute entry %d: %s", op.id, entry.Index, err)
+		op.stack.RollbackTxBatch(id)
+		return
+	}
+	if _, err := op.stack.CommitTxBatch(id, meta); err != nil {
+		logger.Error("Raft replica %d could not commit entry %d: %s", op.id, entry.Index, err)
+		op.stack.RollbackTxBatch(id)
+	}
+}
+
+func (op *obcRaft) getState() []byte {
+	return op.stack.GetBlockchainInfoBlob()
+}
+
+func (op *obcRaft) getLastApplied() (*Metadata, error) {
+	raw, err := op.stack.GetBlockHeadMetadata()
+	if err != nil {
+		return nil, err
+	}
+	meta := &Metadata{}
+	if err := proto.Unmarshal(raw, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (op *obcRaft) skipTo(index uint64, snapshotID []byte, peers []uint64) {
+	op.stack.SkipTo(index, snapshotID, getValidatorHandles(peers))
+}
+
+func (op *obcRaft) invalidateState() {
+	op.stack.InvalidateState()
+}
+
+func (op *obcRaft) validateState() {
+	op.stack.ValidateState()
+}
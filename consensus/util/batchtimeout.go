@@ -0,0 +1,72 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import "time"
+
+// loadWeight is how much the fill of the latest batch weighs in the load
+const loadWeight = 0.25
+
+// BatchTimeout adapts how long a batch may wait to be filled to the load.
+// The load is the moving average of how full the batches were when sent.
+// Under low load, the batches are sent after Min, as waiting longer would
+// delay the requests without filling them much more. As the load rises,
+// the timeout lengthens up to Max, so that fuller batches are sent, each
+// one costing as much to order whatever its size.
+// A BatchTimeout is not safe for concurrent use.
+type BatchTimeout struct {
+	min  time.Duration
+	max  time.Duration
+	load float64
+}
+
+// NewBatchTimeout creates a BatchTimeout between min and max. A min of 0,
+// or above max, keeps the timeout to max whatever the load.
+func NewBatchTimeout(min, max time.Duration) *BatchTimeout {
+	bt := &BatchTimeout{}
+	bt.SetBounds(min, max)
+	return bt
+}
+
+// SetBounds changes the bounds of the timeout
+func (bt *BatchTimeout) SetBounds(min, max time.Duration) {
+	bt.min = min
+	bt.max = max
+}
+
+// Bounds returns the bounds of the timeout
+func (bt *BatchTimeout) Bounds() (min, max time.Duration) {
+	return bt.min, bt.max
+}
+
+// Sent adapts the timeout to a batch of size requests, out of at most
+// batchSize, being sent
+func (bt *BatchTimeout) Sent(size, batchSize int) {
+	fill := 1.0
+	if size < batchSize {
+		fill = float64(size) / float64(batchSize)
+	}
+	bt.load += loadWeight * (fill - bt.load)
+}
+
+// Timeout returns how long the next batch may wait to be filled
+func (bt *BatchTimeout) Timeout() time.Duration {
+	if bt.min <= 0 || bt.min > bt.max {
+		return bt.max
+	}
+	return bt.min + time.Duration(bt.load*float64(bt.max-bt.min))
+}
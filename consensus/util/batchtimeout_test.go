@@ -0,0 +1,58 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBatchTimeoutAdapts(t *testing.T) {
+	bt := NewBatchTimeout(100*time.Millisecond, 2*time.Second)
+	if timeout := bt.Timeout(); timeout != 100*time.Millisecond {
+		t.Fatalf("Expected to start at the minimum, got %v", timeout)
+	}
+
+	for i := 0; i < 50; i++ {
+		bt.Sent(500, 500)
+	}
+	high := bt.Timeout()
+	if high < 1900*time.Millisecond || high > 2*time.Second {
+		t.Fatalf("Expected full batches to lengthen the timeout close to the maximum, got %v", high)
+	}
+
+	bt.Sent(1, 500)
+	if timeout := bt.Timeout(); timeout >= high {
+		t.Fatalf("Expected an almost empty batch to shorten the timeout, got %v after %v", timeout, high)
+	}
+	for i := 0; i < 50; i++ {
+		bt.Sent(1, 500)
+	}
+	if timeout := bt.Timeout(); timeout > 110*time.Millisecond {
+		t.Fatalf("Expected almost empty batches to shorten the timeout close to the minimum, got %v", timeout)
+	}
+}
+
+func TestBatchTimeoutStatic(t *testing.T) {
+	bt := NewBatchTimeout(0, time.Second)
+	for _, size := range []int{0, 10, 1} {
+		bt.Sent(size, 10)
+		if timeout := bt.Timeout(); timeout != time.Second {
+			t.Fatalf("Expected a static timeout without minimum, got %v", timeout)
+		}
+	}
+}
@@ -17,15 +17,26 @@ limitations under the License.
 package core
 
 import (
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"os"
 	"runtime"
+	"sync"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
 	"golang.org/x/net/context"
 
 	google_protobuf "google/protobuf"
 
+	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/core/crypto"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/hyperledger/fabric/core/peer"
 	pb "github.com/hyperledger/fabric/protos"
 )
 
@@ -37,10 +48,32 @@ func NewAdminServer() *ServerAdmin {
 	return s
 }
 
-// ServerAdmin implementation of the Admin service for the Peer
+// NewAdminServerWithConsensus creates and returns a Admin service instance
+// which also adjusts the parameters of the consensus plugins of a validator,
+// tuners holding those of each chain by chain ID. The administrators
+// requesting new parameters are authenticated with secHelper, which is nil
+// if security is disabled.
+func NewAdminServerWithConsensus(tuners map[string]consensus.Tuner, secHelper crypto.Peer) *ServerAdmin {
+	s := new(ServerAdmin)
+	s.tuners = tuners
+	s.secHelper = secHelper
+	return s
+}
+
+// ServerAdmin implementation of the Admin service for the Peer. A request on
+// the ledger or the consensus is for the chain set in its context with
+// peer.NewChainContext, the default chain if none is set.
 type ServerAdmin struct {
+	tuners    map[string]consensus.Tuner
+	secHelper crypto.Peer
 }
 
+// errNotTunable is returned when the peer has no consensus parameters to adjust
+var errNotTunable = errors.New("The consensus plugin of this peer has no tunable parameters")
+
+// compactLock serializes the compactions of the ledger
+var compactLock sync.Mutex
+
 func worker(id int, die chan struct{}) {
 	for {
 		select {
@@ -79,3 +112,176 @@ func (*ServerAdmin) StopServer(context.Context, *google_protobuf.Empty) (*pb.Ser
 	defer os.Exit(0)
 	return status, nil
 }
+
+// getTuner returns the tuner of the consensus plugin of the chain the
+// request is for
+func (s *ServerAdmin) getTuner(ctx context.Context) (consensus.Tuner, error) {
+	tuner := s.tuners[peer.GetChainIDFromContext(ctx)]
+	if tuner == nil {
+		return nil, errNotTunable
+	}
+	return tuner, nil
+}
+
+// GetConsensusParameters returns the parameters of the consensus plugin in effect
+func (s *ServerAdmin) GetConsensusParameters(ctx context.Context, e *google_protobuf.Empty) (*pb.ConsensusParameters, error) {
+	tuner, err := s.getTuner(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tuner.GetParameters()
+}
+
+// SetConsensusParameters requests new parameters of the consensus plugin
+// on behalf of an administrator. The validators adjust their parameters
+// once they agree on the request, after this call returns the parameters
+// still in effect.
+func (s *ServerAdmin) SetConsensusParameters(ctx context.Context, req *pb.ConsensusParametersRequest) (*pb.ConsensusParameters, error) {
+	tuner, err := s.getTuner(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.Parameters == nil {
+		return nil, errors.New("No consensus parameters requested")
+	}
+	if err := s.authenticate(req); err != nil {
+		log.Warning("Rejecting consensus parameters %s: %s", req.Parameters, err)
+		return nil, err
+	}
+
+	log.Info("Requesting consensus parameters %s", req.Parameters)
+	if err := tuner.SetParameters(req.Parameters); err != nil {
+		return nil, err
+	}
+	return tuner.GetParameters()
+}
+
+// authenticate checks that the parameters are signed with the enrollment
+// key of an administrator listed in peer.validator.consensus.admins
+func (s *ServerAdmin) authenticate(req *pb.ConsensusParametersRequest) error {
+	if s.secHelper == nil {
+		return nil
+	}
+
+	cert, err := x509.ParseCertificate(req.Cert)
+	if err != nil {
+		return fmt.Errorf("Invalid enrollment certificate: %s", err)
+	}
+	admin := false
+	for _, id := range viper.GetStringSlice("peer.validator.consensus.admins") {
+		if id == cert.Subject.CommonName {
+			admin = true
+			break
+		}
+	}
+	if !admin {
+		return fmt.Errorf("User %s is not a consensus administrator", cert.Subject.CommonName)
+	}
+
+	raw, err := proto.Marshal(req.Parameters)
+	if err != nil {
+		return err
+	}
+	// The ECA knows the certificate by its hash only if it issued it
+	if err = s.secHelper.Verify(primitives.Hash(req.Cert), req.Signature, raw); err != nil {
+		return fmt.Errorf("Invalid signature of user %s: %s", cert.Subject.CommonName, err)
+	}
+	return nil
+}
+
+// CompactLedger archives and prunes the ledger, keeping the blocks
+// configured in ledger.archive.blocks and ledger.pruning.blocks, and
+// compacts its DB
+func (*ServerAdmin) CompactLedger(ctx context.Context, e *google_protobuf.Empty) (*pb.LedgerCompaction, error) {
+	return compactLedger(peer.GetChainIDFromContext(ctx))
+}
+
+// StartLedgerCompaction compacts the ledgers of all the chains every
+// interval, for as long as the peer runs
+func StartLedgerCompaction(interval time.Duration) {
+	chainIDs := append([]string{""}, viper.GetStringSlice("peer.chains")...)
+	go func() {
+		for range time.Tick(interval) {
+			for _, chainID := range chainIDs {
+				if _, err := compactLedger(chainID); err != nil {
+					log.Error("Error compacting the ledger of chain [%s]: %s", chainID, err)
+				}
+			}
+		}
+	}()
+}
+
+func compactLedger(chainID string) (*pb.LedgerCompaction, error) {
+	compactLock.Lock()
+	defer compactLock.Unlock()
+
+	l, err := peer.GetChainLedger(chainID)
+	if err != nil {
+		return nil, err
+	}
+	archive := viper.GetInt("ledger.archive.blocks")
+	if archive < 0 {
+		return nil, fmt.Errorf("The number of blocks kept by archiving must not be negative, got %d", archive)
+	}
+	archived, err := l.Archive(uint64(archive))
+	if err != nil {
+		return nil, fmt.Errorf("Error archiving the ledger: %s", err)
+	}
+	retain := viper.GetInt("ledger.pruning.blocks")
+	if retain < 0 {
+		return nil, fmt.Errorf("The number of blocks kept by pruning must not be negative, got %d", retain)
+	}
+	blocks, deltas, err := l.Prune(uint64(retain))
+	if err != nil {
+		return nil, fmt.Errorf("Error pruning the ledger: %s", err)
+	}
+	start := time.Now()
+	l.Compact()
+	log.Info("Compacted the ledger in %s", time.Since(start))
+	return &pb.LedgerCompaction{BlocksPruned: blocks, DeltasPruned: deltas, PrunedBelow: l.GetPrunedBelow(),
+		BlocksArchived: archived, ArchivedBelow: l.GetArchivedBelow()}, nil
+}
+
+// ExportLedgerSnapshot writes a snapshot of the ledger at the height
+// requested to a new file of the peer
+func (*ServerAdmin) ExportLedgerSnapshot(ctx context.Context, req *pb.LedgerSnapshotRequest) (*pb.BlockchainInfo, error) {
+	if req.Path == "" {
+		return nil, errors.New("No file given for the snapshot")
+	}
+	l, err := peer.GetChainLedger(peer.GetChainIDFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(req.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	info, err := l.ExportSnapshot(req.Height, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(req.Path)
+		return nil, fmt.Errorf("Error exporting a snapshot of the ledger: %s", err)
+	}
+	return info, nil
+}
+
+// GetDBStats returns the statistics of the column families of the DB, for
+// tuning its store in peer.db.rocksdb
+func (*ServerAdmin) GetDBStats(ctx context.Context, e *google_protobuf.Empty) (*pb.DBStats, error) {
+	chainID := peer.GetChainIDFromContext(ctx)
+	if !peer.HostsChain(chainID) {
+		return nil, fmt.Errorf("The peer does not keep the ledger of chain %s", chainID)
+	}
+	cfStats, err := db.GetChainDBHandle(chainID).GetStats()
+	if err != nil {
+		return nil, err
+	}
+	stats := &pb.DBStats{Backend: db.BackendName()}
+	for _, cf := range cfStats {
+		stats.ColumnFamilies = append(stats.ColumnFamilies, &pb.ColumnFamilyStats{Name: string(cf.Name),
+			Keys: cf.Keys, DiskSize: cf.DiskSize, MemorySize: cf.MemorySize, Details: cf.Details})
+	}
+	return stats, nil
+}
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"strings"
 	"sync"
 	"time"
 
@@ -45,6 +46,9 @@ const (
 	chaincodeStartupTimeoutDefault int    = 5000
 	chaincodeInstallPathDefault    string = "/opt/gopath/bin/"
 	peerAddressDefault             string = "0.0.0.0:30303"
+	// chainIDSeparator separates the name of a chaincode from the chain it
+	// runs on in the name chaincodes of the other chains register with
+	chainIDSeparator string = "@"
 )
 
 // chains is a map between different blockchains and their ChaincodeSupport.
@@ -73,6 +77,48 @@ func GetChain(name ChainName) *ChaincodeSupport {
 	return chains[name]
 }
 
+// GetChainName returns the name of the chaincode support of the chain with
+// the given ID, the empty ID being the default chain
+func GetChainName(chainID string) ChainName {
+	if chainID == "" {
+		return DefaultChain
+	}
+	return ChainName(chainID)
+}
+
+// chainID returns the ID of the chain of the chaincode support, empty for the default chain
+func (chaincodeSupport *ChaincodeSupport) chainID() string {
+	if chaincodeSupport.name == DefaultChain {
+		return ""
+	}
+	return string(chaincodeSupport.name)
+}
+
+// getLedger returns the ledger of the chain of the chaincode support
+func (chaincodeSupport *ChaincodeSupport) getLedger() (*ledger.Ledger, error) {
+	return ledger.GetChainLedger(chaincodeSupport.chainID())
+}
+
+// registrationName returns the name the chaincode registers with. Chaincodes of
+// the default chain register with their name, the others qualify it with their
+// chain, e.g. mycc@chain1, so that the peer hands them to the right chain
+func (chaincodeSupport *ChaincodeSupport) registrationName(chaincode string) string {
+	if chaincodeSupport.name == DefaultChain {
+		return chaincode
+	}
+	return chaincode + chainIDSeparator + chaincodeSupport.chainID()
+}
+
+// splitRegistrationName returns the name of the chaincode and the chain
+// it runs on from the name the chaincode registered with
+func splitRegistrationName(name string) (string, ChainName) {
+	i := strings.LastIndex(name, chainIDSeparator)
+	if i < 0 {
+		return name, DefaultChain
+	}
+	return name[:i], GetChainName(name[i+len(chainIDSeparator):])
+}
+
 //call this under lock
 func (chaincodeSupport *ChaincodeSupport) preLaunchSetup(chaincode string) chan bool {
 	//register placeholder Handler. This will be transferred in registerHandler
@@ -249,7 +295,7 @@ func (chaincodeSupport *ChaincodeSupport) sendInitOrReady(context context.Contex
 
 //get args and env given chaincodeID
 func (chaincodeSupport *ChaincodeSupport) getArgsAndEnv(cID *pb.ChaincodeID) (args []string, envs []string, err error) {
-	envs = []string{"CORE_CHAINCODE_ID_NAME=" + cID.Name}
+	envs = []string{"CORE_CHAINCODE_ID_NAME=" + chaincodeSupport.registrationName(cID.Name)}
 
 	//chaincode executable will be same as the name of the chaincode
 	args = []string{chaincodeSupport.chaincodeInstallPath + cID.Name, fmt.Sprintf("-peer.address=%s", chaincodeSupport.peerAddress)}
@@ -289,7 +335,7 @@ func (chaincodeSupport *ChaincodeSupport) launchAndWaitForRegister(ctxt context.
 
 	vmtype, _ := chaincodeSupport.getVMType(cds)
 
-	sir := container.StartImageReq{CCID: ccintf.CCID{ChaincodeSpec: cds.ChaincodeSpec, NetworkID: chaincodeSupport.peerNetworkID, PeerID: chaincodeSupport.peerID}, Args: args, Env: env}
+	sir := container.StartImageReq{CCID: ccintf.CCID{ChaincodeSpec: cds.ChaincodeSpec, NetworkID: chaincodeSupport.peerNetworkID, PeerID: chaincodeSupport.peerID, ChainID: chaincodeSupport.chainID()}, Args: args, Env: env}
 
 	ipcCtxt := context.WithValue(ctxt, ccintf.GetCCHandlerKey(), chaincodeSupport)
 
@@ -332,7 +378,7 @@ func (chaincodeSupport *ChaincodeSupport) Stop(context context.Context, cds *pb.
 	}
 
 	//stop the chaincode
-	sir := container.StopImageReq{CCID: ccintf.CCID{ChaincodeSpec: cds.ChaincodeSpec, NetworkID: chaincodeSupport.peerNetworkID, PeerID: chaincodeSupport.peerID}, Timeout: 0}
+	sir := container.StopImageReq{CCID: ccintf.CCID{ChaincodeSpec: cds.ChaincodeSpec, NetworkID: chaincodeSupport.peerNetworkID, PeerID: chaincodeSupport.peerID, ChainID: chaincodeSupport.chainID()}, Timeout: 0}
 
 	vmtype, _ := chaincodeSupport.getVMType(cds)
 
@@ -423,7 +469,7 @@ func (chaincodeSupport *ChaincodeSupport) Launch(context context.Context, t *pb.
 	// See issue #710
 
 	if t.Type != pb.Transaction_CHAINCODE_DEPLOY {
-		ledger, ledgerErr := ledger.GetLedger()
+		ledger, ledgerErr := chaincodeSupport.getLedger()
 		if ledgerErr != nil {
 			return cID, cMsg, fmt.Errorf("Failed to get handle to ledger (%s)", ledgerErr)
 		}
@@ -528,7 +574,7 @@ func (chaincodeSupport *ChaincodeSupport) Deploy(context context.Context, t *pb.
 	}
 
 	var targz io.Reader = bytes.NewBuffer(cds.CodePackage)
-	cir := &container.CreateImageReq{CCID: ccintf.CCID{ChaincodeSpec: cds.ChaincodeSpec, NetworkID: chaincodeSupport.peerNetworkID, PeerID: chaincodeSupport.peerID}, Args: args, Reader: targz, Env: envs}
+	cir := &container.CreateImageReq{CCID: ccintf.CCID{ChaincodeSpec: cds.ChaincodeSpec, NetworkID: chaincodeSupport.peerNetworkID, PeerID: chaincodeSupport.peerID, ChainID: chaincodeSupport.chainID()}, Args: args, Reader: targz, Env: envs}
 
 	vmtype, _ := chaincodeSupport.getVMType(cds)
 
@@ -19,9 +19,11 @@ package chaincode
 import (
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
 	"golang.org/x/net/context"
 
 	"github.com/hyperledger/fabric/core/ledger"
@@ -32,8 +34,12 @@ import (
 func Execute(ctxt context.Context, chain *ChaincodeSupport, t *pb.Transaction) ([]byte, error) {
 	var err error
 
+	if t.ChainID != chain.chainID() {
+		return nil, fmt.Errorf("Transaction %s of chain [%s] cannot execute on chain %s", t.Uuid, t.ChainID, chain.name)
+	}
+
 	// get a handle to ledger to mark the begin/finish of a tx
-	ledger, ledgerErr := ledger.GetLedger()
+	ledger, ledgerErr := chain.getLedger()
 	if ledgerErr != nil {
 		return nil, fmt.Errorf("Failed to get handle to ledger (%s)", ledgerErr)
 	}
@@ -45,6 +51,15 @@ func Execute(ctxt context.Context, chain *ChaincodeSupport, t *pb.Transaction) (
 		if nil != err {
 			return nil, err
 		}
+
+		// Reject replayed transactions. Queries do not change the state
+		// and are not tracked, nor are the blocks replayed to rebuild the
+		// state
+		if t.Type != pb.Transaction_CHAINCODE_QUERY && !isReplay(ctxt) {
+			if err = secHelper.ConsumeTransactionNonce(t); nil != err {
+				return nil, err
+			}
+		}
 	}
 
 	if t.Type == pb.Transaction_CHAINCODE_DEPLOY {
@@ -77,7 +92,7 @@ func Execute(ctxt context.Context, chain *ChaincodeSupport, t *pb.Transaction) (
 
 		// TODO: Need to comment next line and uncomment call to getTimeout, when transaction blocks are being created
 		timeout := time.Duration(30000) * time.Millisecond
-		//timeout, err := getTimeout(cID)
+		//timeout, err := chain.getTimeout(cID)
 
 		if err != nil {
 			return nil, fmt.Errorf("Failed to retrieve chaincode spec(%s)", err)
@@ -127,9 +142,11 @@ func Execute(ctxt context.Context, chain *ChaincodeSupport, t *pb.Transaction) (
 }
 
 //ExecuteTransactions - will execute transactions on the array one by one
-//will return an array of errors one for each transaction. If the execution
-//succeeded, array element will be nil. returns []byte of state hash or
-//error
+//once their signatures are verified. will return an array of errors one for
+//each transaction. If the execution succeeded, array element will be nil.
+//With 'ledger.state.mvcc.enabled' the invoke transactions are simulated
+//concurrently instead, see simulateTransactions.
+//returns []byte of state hash or error
 func ExecuteTransactions(ctxt context.Context, cname ChainName, xacts []*pb.Transaction) (stateHash []byte, txerrs []error, err error) {
 	var chain = GetChain(cname)
 	if chain == nil {
@@ -137,18 +154,96 @@ func ExecuteTransactions(ctxt context.Context, cname ChainName, xacts []*pb.Tran
 		panic(fmt.Sprintf("[ExecuteTransactions]Chain %s not found\n", cname))
 	}
 	txerrs = make([]error, len(xacts))
-	for i, t := range xacts {
-		_, txerrs[i] = Execute(ctxt, chain, t)
+	if secHelper := chain.getSecHelper(); nil != secHelper {
+		// verify the signatures of the whole batch concurrently
+		txerrs = secHelper.TransactionsPreValidation(xacts)
 	}
 
 	var lgr *ledger.Ledger
-	lgr, err = ledger.GetLedger()
-	if err == nil {
-		stateHash, err = lgr.GetTempStateHash()
+	lgr, err = chain.getLedger()
+	if err != nil {
+		return nil, txerrs, err
 	}
+
+	if viper.GetBool("ledger.state.mvcc.enabled") {
+		simulateTransactions(ctxt, chain, lgr, xacts, txerrs)
+	} else {
+		for i, t := range xacts {
+			if txerrs[i] != nil {
+				continue
+			}
+			_, txerrs[i] = Execute(ctxt, chain, t)
+		}
+	}
+
+	stateHash, err = lgr.GetTempStateHash()
 	return stateHash, txerrs, err
 }
 
+// simulateTransactions executes the runs of consecutive invoke transactions of xacts concurrently,
+// each against its own simulator, then validates and applies them in the order of xacts. The other
+// transactions, deploys, execute one by one between the runs
+func simulateTransactions(ctxt context.Context, chain *ChaincodeSupport, lgr *ledger.Ledger, xacts []*pb.Transaction, txerrs []error) {
+	var run []int
+	for i, t := range xacts {
+		if txerrs[i] != nil {
+			continue
+		}
+		if t.Type == pb.Transaction_CHAINCODE_INVOKE {
+			run = append(run, i)
+			continue
+		}
+		simulateRun(ctxt, chain, lgr, xacts, txerrs, run)
+		run = nil
+		_, txerrs[i] = Execute(ctxt, chain, t)
+	}
+	simulateRun(ctxt, chain, lgr, xacts, txerrs, run)
+}
+
+// simulateRun simulates the transactions of xacts at indexes run concurrently, up to
+// 'ledger.state.mvcc.parallelism' at once, and ends their simulations in order, recording
+// in txerrs the transactions which failed or read keys changed by a previous transaction
+func simulateRun(ctxt context.Context, chain *ChaincodeSupport, lgr *ledger.Ledger, xacts []*pb.Transaction, txerrs []error, run []int) {
+	if len(run) == 0 {
+		return
+	}
+	parallelism := viper.GetInt("ledger.state.mvcc.parallelism")
+	if parallelism <= 0 || parallelism > len(run) {
+		parallelism = len(run)
+	}
+	chaincodeLogger.Debug("Simulating %d transactions, %d at once", len(run), parallelism)
+
+	simulated := make([]bool, len(run))
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for j, i := range run {
+		if txerrs[i] = lgr.BeginTxSimulation(xacts[i].Uuid); txerrs[i] != nil {
+			continue
+		}
+		simulated[j] = true
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			_, txerrs[i] = Execute(ctxt, chain, xacts[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for j, i := range run {
+		if !simulated[j] {
+			continue
+		}
+		err := lgr.EndTxSimulation(xacts[i].Uuid, txerrs[i] == nil)
+		if txerrs[i] == nil {
+			txerrs[i] = err
+		}
+	}
+}
+
 // GetSecureContext returns the security context from the context object or error
 // Security context is nil if security is off from core.yaml file
 // func GetSecureContext(ctxt context.Context) (crypto.Peer, error) {
@@ -165,8 +260,8 @@ func ExecuteTransactions(ctxt context.Context, cname ChainName, xacts []*pb.Tran
 
 var errFailedToGetChainCodeSpecForTransaction = errors.New("Failed to get ChainCodeSpec from Transaction")
 
-func getTimeout(cID *pb.ChaincodeID) (time.Duration, error) {
-	ledger, err := ledger.GetLedger()
+func (chaincodeSupport *ChaincodeSupport) getTimeout(cID *pb.ChaincodeID) (time.Duration, error) {
+	ledger, err := chaincodeSupport.getLedger()
 	if err == nil {
 		chaincodeID := cID.Name
 		txUUID, err := ledger.GetState(chaincodeID, "github.com_openblockchain_obc-peer_chaincode_id", true)
@@ -186,14 +281,15 @@ func getTimeout(cID *pb.ChaincodeID) (time.Duration, error) {
 }
 
 func markTxBegin(ledger *ledger.Ledger, t *pb.Transaction) {
-	if t.Type == pb.Transaction_CHAINCODE_QUERY {
+	// the simulated transactions are applied to the ledger when their simulation ends
+	if t.Type == pb.Transaction_CHAINCODE_QUERY || ledger.IsTxSimulated(t.Uuid) {
 		return
 	}
 	ledger.TxBegin(t.Uuid)
 }
 
 func markTxFinish(ledger *ledger.Ledger, t *pb.Transaction, successful bool) {
-	if t.Type == pb.Transaction_CHAINCODE_QUERY {
+	if t.Type == pb.Transaction_CHAINCODE_QUERY || ledger.IsTxSimulated(t.Uuid) {
 		return
 	}
 	ledger.TxFinished(t.Uuid, successful)
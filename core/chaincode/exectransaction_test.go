@@ -1258,6 +1258,80 @@ func TestExecuteDeploySysChaincode(t *testing.T) {
 	closeListenerAndSleep(lis)
 }
 
+// Test the deployment and the query of a system chaincode on a chain other than the default chain
+func TestExecuteDeploySysChaincodeOnChain(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	ccStartupTimeout := time.Duration(chaincodeStartupTimeoutDefault) * time.Millisecond
+	chain := NewChaincodeSupport(ChainName("chain1"), getPeerEndpoint, false, ccStartupTimeout, nil)
+	defer delete(chains, ChainName("chain1"))
+
+	var ctxt = context.Background()
+
+	system_chaincode.RegisterSysCCs()
+
+	url := "github.com/hyperledger/fabric/core/system_chaincode/sample_syscc"
+
+	args := []string{"greeting", "hello chain1"}
+	cds := &pb.ChaincodeDeploymentSpec{ExecEnv: 1, ChaincodeSpec: &pb.ChaincodeSpec{Type: 1, ChaincodeID: &pb.ChaincodeID{Name: "sample_syscc", Path: url}, CtorMsg: &pb.ChaincodeInput{Args: args}, ChainID: "chain1"}}
+	defer chain.Stop(ctxt, cds)
+
+	transaction, err := createDeployTransaction(cds, cds.ChaincodeSpec.ChaincodeID.Name)
+	if err != nil {
+		t.Fatalf("Error creating the deploy transaction: %s", err)
+	}
+	if transaction.ChainID != "chain1" {
+		t.Fatalf("The deploy transaction is on chain [%s], expected chain1", transaction.ChainID)
+	}
+	if _, err = Execute(ctxt, &ChaincodeSupport{name: DefaultChain}, transaction); err == nil {
+		t.Fatal("Executed a transaction of chain1 on the default chain")
+	}
+
+	lgr, err := ledger.GetChainLedger("chain1")
+	if err != nil {
+		t.Fatalf("Error getting the ledger of chain1: %s", err)
+	}
+	lgr.BeginTxBatch("1")
+	if _, err = Execute(ctxt, chain, transaction); err != nil {
+		lgr.RollbackTxBatch("1")
+		t.Fatalf("Error deploying sample_syscc on chain1: %s", err)
+	}
+	lgr.CommitTxBatch("1", []*pb.Transaction{transaction}, nil, nil)
+
+	chain.runningChaincodes.Lock()
+	_, launched := chain.chaincodeHasBeenLaunched("sample_syscc")
+	chain.runningChaincodes.Unlock()
+	if !launched {
+		t.Fatal("sample_syscc did not register with the chaincode support of chain1")
+	}
+
+	spec := &pb.ChaincodeSpec{Type: 1, ChaincodeID: &pb.ChaincodeID{Name: "sample_syscc"}, CtorMsg: &pb.ChaincodeInput{Function: "getval", Args: []string{"greeting"}}, ChainID: "chain1"}
+	value, err := queryOnChain(ctxt, chain, spec)
+	if err != nil {
+		t.Fatalf("Error querying sample_syscc on chain1: %s", err)
+	}
+	if string(value) != "hello chain1" {
+		t.Fatalf("Got [%s] from sample_syscc on chain1, expected [hello chain1]", value)
+	}
+
+	defaultLedger, _ := ledger.GetLedger()
+	if value, _ = defaultLedger.GetState("sample_syscc", "greeting", true); string(value) == "hello chain1" {
+		t.Fatal("The state of chain1 is in the ledger of the default chain")
+	}
+}
+
+// Query a chaincode on a chain
+func queryOnChain(ctx context.Context, chain *ChaincodeSupport, spec *pb.ChaincodeSpec) ([]byte, error) {
+	transaction, err := createTransaction(false, &pb.ChaincodeInvocationSpec{ChaincodeSpec: spec}, util.GenerateUUID())
+	if err != nil {
+		return nil, fmt.Errorf("Error creating the query transaction: %s", err)
+	}
+	return Execute(ctx, chain, transaction)
+}
+
 // Test the execution of a chaincode query that queries another chaincode with security enabled
 // NOTE: this really needs to be a behave test. Remove when we have support in behave for multiple chaincodes
 func TestChaincodeQueryChaincodeWithSec(t *testing.T) {
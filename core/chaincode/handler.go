@@ -31,8 +31,6 @@ import (
 	"github.com/looplab/fsm"
 	"github.com/op/go-logging"
 	"golang.org/x/net/context"
-
-	"github.com/hyperledger/fabric/core/ledger"
 )
 
 const (
@@ -41,8 +39,8 @@ const (
 	initstate        = "init"        //in:ESTABLISHED, rcv:-, send: INIT
 	readystate       = "ready"       //in:ESTABLISHED,TRANSACTION, rcv:COMPLETED
 	transactionstate = "transaction" //in:READY, rcv: xact from consensus, send: TRANSACTION
-	busyinitstate    = "busyinit"    //in:INIT, rcv: PUT_STATE, DEL_STATE, INVOKE_CHAINCODE
-	busyxactstate    = "busyxact"    //in:TRANSACION, rcv: PUT_STATE, DEL_STATE, INVOKE_CHAINCODE
+	busyinitstate    = "busyinit"    //in:INIT, rcv: PUT_STATE, PUT_STATE_MULTI, DEL_STATE, INVOKE_CHAINCODE
+	busyxactstate    = "busyxact"    //in:TRANSACION, rcv: PUT_STATE, PUT_STATE_MULTI, DEL_STATE, INVOKE_CHAINCODE
 	endstate         = "end"         //in:INIT,ESTABLISHED, rcv: error, terminate container
 
 )
@@ -354,9 +352,11 @@ func newChaincodeSupportHandler(chaincodeSupport *ChaincodeSupport, peerChatStre
 			{Name: pb.ChaincodeMessage_READY.String(), Src: []string{establishedstate}, Dst: readystate},
 			{Name: pb.ChaincodeMessage_TRANSACTION.String(), Src: []string{readystate}, Dst: transactionstate},
 			{Name: pb.ChaincodeMessage_PUT_STATE.String(), Src: []string{transactionstate}, Dst: busyxactstate},
+			{Name: pb.ChaincodeMessage_PUT_STATE_MULTI.String(), Src: []string{transactionstate}, Dst: busyxactstate},
 			{Name: pb.ChaincodeMessage_DEL_STATE.String(), Src: []string{transactionstate}, Dst: busyxactstate},
 			{Name: pb.ChaincodeMessage_INVOKE_CHAINCODE.String(), Src: []string{transactionstate}, Dst: busyxactstate},
 			{Name: pb.ChaincodeMessage_PUT_STATE.String(), Src: []string{initstate}, Dst: busyinitstate},
+			{Name: pb.ChaincodeMessage_PUT_STATE_MULTI.String(), Src: []string{initstate}, Dst: busyinitstate},
 			{Name: pb.ChaincodeMessage_DEL_STATE.String(), Src: []string{initstate}, Dst: busyinitstate},
 			{Name: pb.ChaincodeMessage_INVOKE_CHAINCODE.String(), Src: []string{initstate}, Dst: busyinitstate},
 			{Name: pb.ChaincodeMessage_COMPLETED.String(), Src: []string{initstate, readystate, transactionstate}, Dst: readystate},
@@ -365,11 +365,36 @@ func newChaincodeSupportHandler(chaincodeSupport *ChaincodeSupport, peerChatStre
 			{Name: pb.ChaincodeMessage_GET_STATE.String(), Src: []string{busyinitstate}, Dst: busyinitstate},
 			{Name: pb.ChaincodeMessage_GET_STATE.String(), Src: []string{transactionstate}, Dst: transactionstate},
 			{Name: pb.ChaincodeMessage_GET_STATE.String(), Src: []string{busyxactstate}, Dst: busyxactstate},
+			{Name: pb.ChaincodeMessage_GET_STATE_MULTI.String(), Src: []string{readystate}, Dst: readystate},
+			{Name: pb.ChaincodeMessage_GET_STATE_MULTI.String(), Src: []string{initstate}, Dst: initstate},
+			{Name: pb.ChaincodeMessage_GET_STATE_MULTI.String(), Src: []string{busyinitstate}, Dst: busyinitstate},
+			{Name: pb.ChaincodeMessage_GET_STATE_MULTI.String(), Src: []string{transactionstate}, Dst: transactionstate},
+			{Name: pb.ChaincodeMessage_GET_STATE_MULTI.String(), Src: []string{busyxactstate}, Dst: busyxactstate},
 			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE.String(), Src: []string{readystate}, Dst: readystate},
 			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE.String(), Src: []string{initstate}, Dst: initstate},
 			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE.String(), Src: []string{busyinitstate}, Dst: busyinitstate},
 			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE.String(), Src: []string{transactionstate}, Dst: transactionstate},
 			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE.String(), Src: []string{busyxactstate}, Dst: busyxactstate},
+			{Name: pb.ChaincodeMessage_QUERY_STATE.String(), Src: []string{readystate}, Dst: readystate},
+			{Name: pb.ChaincodeMessage_QUERY_STATE.String(), Src: []string{initstate}, Dst: initstate},
+			{Name: pb.ChaincodeMessage_QUERY_STATE.String(), Src: []string{busyinitstate}, Dst: busyinitstate},
+			{Name: pb.ChaincodeMessage_QUERY_STATE.String(), Src: []string{transactionstate}, Dst: transactionstate},
+			{Name: pb.ChaincodeMessage_QUERY_STATE.String(), Src: []string{busyxactstate}, Dst: busyxactstate},
+			{Name: pb.ChaincodeMessage_GET_STATE_BY_PARTIAL_COMPOSITE_KEY.String(), Src: []string{readystate}, Dst: readystate},
+			{Name: pb.ChaincodeMessage_GET_STATE_BY_PARTIAL_COMPOSITE_KEY.String(), Src: []string{initstate}, Dst: initstate},
+			{Name: pb.ChaincodeMessage_GET_STATE_BY_PARTIAL_COMPOSITE_KEY.String(), Src: []string{busyinitstate}, Dst: busyinitstate},
+			{Name: pb.ChaincodeMessage_GET_STATE_BY_PARTIAL_COMPOSITE_KEY.String(), Src: []string{transactionstate}, Dst: transactionstate},
+			{Name: pb.ChaincodeMessage_GET_STATE_BY_PARTIAL_COMPOSITE_KEY.String(), Src: []string{busyxactstate}, Dst: busyxactstate},
+			{Name: pb.ChaincodeMessage_GET_STATE_BY_INDEX.String(), Src: []string{readystate}, Dst: readystate},
+			{Name: pb.ChaincodeMessage_GET_STATE_BY_INDEX.String(), Src: []string{initstate}, Dst: initstate},
+			{Name: pb.ChaincodeMessage_GET_STATE_BY_INDEX.String(), Src: []string{busyinitstate}, Dst: busyinitstate},
+			{Name: pb.ChaincodeMessage_GET_STATE_BY_INDEX.String(), Src: []string{transactionstate}, Dst: transactionstate},
+			{Name: pb.ChaincodeMessage_GET_STATE_BY_INDEX.String(), Src: []string{busyxactstate}, Dst: busyxactstate},
+			{Name: pb.ChaincodeMessage_GET_HISTORY_FOR_KEY.String(), Src: []string{readystate}, Dst: readystate},
+			{Name: pb.ChaincodeMessage_GET_HISTORY_FOR_KEY.String(), Src: []string{initstate}, Dst: initstate},
+			{Name: pb.ChaincodeMessage_GET_HISTORY_FOR_KEY.String(), Src: []string{busyinitstate}, Dst: busyinitstate},
+			{Name: pb.ChaincodeMessage_GET_HISTORY_FOR_KEY.String(), Src: []string{transactionstate}, Dst: transactionstate},
+			{Name: pb.ChaincodeMessage_GET_HISTORY_FOR_KEY.String(), Src: []string{busyxactstate}, Dst: busyxactstate},
 			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT.String(), Src: []string{readystate}, Dst: readystate},
 			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT.String(), Src: []string{initstate}, Dst: initstate},
 			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT.String(), Src: []string{busyinitstate}, Dst: busyinitstate},
@@ -388,22 +413,28 @@ func newChaincodeSupportHandler(chaincodeSupport *ChaincodeSupport, peerChatStre
 			{Name: pb.ChaincodeMessage_RESPONSE.String(), Src: []string{busyxactstate}, Dst: transactionstate},
 		},
 		fsm.Callbacks{
-			"before_" + pb.ChaincodeMessage_REGISTER.String():               func(e *fsm.Event) { v.beforeRegisterEvent(e, v.FSM.Current()) },
-			"before_" + pb.ChaincodeMessage_COMPLETED.String():              func(e *fsm.Event) { v.beforeCompletedEvent(e, v.FSM.Current()) },
-			"before_" + pb.ChaincodeMessage_INIT.String():                   func(e *fsm.Event) { v.beforeInitState(e, v.FSM.Current()) },
-			"after_" + pb.ChaincodeMessage_GET_STATE.String():               func(e *fsm.Event) { v.afterGetState(e, v.FSM.Current()) },
-			"after_" + pb.ChaincodeMessage_RANGE_QUERY_STATE.String():       func(e *fsm.Event) { v.afterRangeQueryState(e, v.FSM.Current()) },
-			"after_" + pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT.String():  func(e *fsm.Event) { v.afterRangeQueryStateNext(e, v.FSM.Current()) },
-			"after_" + pb.ChaincodeMessage_RANGE_QUERY_STATE_CLOSE.String(): func(e *fsm.Event) { v.afterRangeQueryStateClose(e, v.FSM.Current()) },
-			"after_" + pb.ChaincodeMessage_PUT_STATE.String():               func(e *fsm.Event) { v.afterPutState(e, v.FSM.Current()) },
-			"after_" + pb.ChaincodeMessage_DEL_STATE.String():               func(e *fsm.Event) { v.afterDelState(e, v.FSM.Current()) },
-			"after_" + pb.ChaincodeMessage_INVOKE_CHAINCODE.String():        func(e *fsm.Event) { v.afterInvokeChaincode(e, v.FSM.Current()) },
-			"enter_" + establishedstate:                                     func(e *fsm.Event) { v.enterEstablishedState(e, v.FSM.Current()) },
-			"enter_" + initstate:                                            func(e *fsm.Event) { v.enterInitState(e, v.FSM.Current()) },
-			"enter_" + readystate:                                           func(e *fsm.Event) { v.enterReadyState(e, v.FSM.Current()) },
-			"enter_" + busyinitstate:                                        func(e *fsm.Event) { v.enterBusyState(e, v.FSM.Current()) },
-			"enter_" + busyxactstate:                                        func(e *fsm.Event) { v.enterBusyState(e, v.FSM.Current()) },
-			"enter_" + endstate:                                             func(e *fsm.Event) { v.enterEndState(e, v.FSM.Current()) },
+			"before_" + pb.ChaincodeMessage_REGISTER.String():                          func(e *fsm.Event) { v.beforeRegisterEvent(e, v.FSM.Current()) },
+			"before_" + pb.ChaincodeMessage_COMPLETED.String():                         func(e *fsm.Event) { v.beforeCompletedEvent(e, v.FSM.Current()) },
+			"before_" + pb.ChaincodeMessage_INIT.String():                              func(e *fsm.Event) { v.beforeInitState(e, v.FSM.Current()) },
+			"after_" + pb.ChaincodeMessage_GET_STATE.String():                          func(e *fsm.Event) { v.afterGetState(e, v.FSM.Current()) },
+			"after_" + pb.ChaincodeMessage_GET_STATE_MULTI.String():                    func(e *fsm.Event) { v.afterGetStateMulti(e, v.FSM.Current()) },
+			"after_" + pb.ChaincodeMessage_RANGE_QUERY_STATE.String():                  func(e *fsm.Event) { v.afterRangeQueryState(e, v.FSM.Current()) },
+			"after_" + pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT.String():             func(e *fsm.Event) { v.afterRangeQueryStateNext(e, v.FSM.Current()) },
+			"after_" + pb.ChaincodeMessage_RANGE_QUERY_STATE_CLOSE.String():            func(e *fsm.Event) { v.afterRangeQueryStateClose(e, v.FSM.Current()) },
+			"after_" + pb.ChaincodeMessage_QUERY_STATE.String():                        func(e *fsm.Event) { v.afterQueryState(e, v.FSM.Current()) },
+			"after_" + pb.ChaincodeMessage_GET_STATE_BY_PARTIAL_COMPOSITE_KEY.String(): func(e *fsm.Event) { v.afterGetStateByPartialCompositeKey(e, v.FSM.Current()) },
+			"after_" + pb.ChaincodeMessage_GET_STATE_BY_INDEX.String():                 func(e *fsm.Event) { v.afterGetStateByIndex(e, v.FSM.Current()) },
+			"after_" + pb.ChaincodeMessage_GET_HISTORY_FOR_KEY.String():                func(e *fsm.Event) { v.afterGetHistoryForKey(e, v.FSM.Current()) },
+			"after_" + pb.ChaincodeMessage_PUT_STATE.String():                          func(e *fsm.Event) { v.afterPutState(e, v.FSM.Current()) },
+			"after_" + pb.ChaincodeMessage_PUT_STATE_MULTI.String():                    func(e *fsm.Event) { v.afterPutStateMulti(e, v.FSM.Current()) },
+			"after_" + pb.ChaincodeMessage_DEL_STATE.String():                          func(e *fsm.Event) { v.afterDelState(e, v.FSM.Current()) },
+			"after_" + pb.ChaincodeMessage_INVOKE_CHAINCODE.String():                   func(e *fsm.Event) { v.afterInvokeChaincode(e, v.FSM.Current()) },
+			"enter_" + establishedstate:                                                func(e *fsm.Event) { v.enterEstablishedState(e, v.FSM.Current()) },
+			"enter_" + initstate:                                                       func(e *fsm.Event) { v.enterInitState(e, v.FSM.Current()) },
+			"enter_" + readystate:                                                      func(e *fsm.Event) { v.enterReadyState(e, v.FSM.Current()) },
+			"enter_" + busyinitstate:                                                   func(e *fsm.Event) { v.enterBusyState(e, v.FSM.Current()) },
+			"enter_" + busyxactstate:                                                   func(e *fsm.Event) { v.enterBusyState(e, v.FSM.Current()) },
+			"enter_" + endstate:                                                        func(e *fsm.Event) { v.enterEndState(e, v.FSM.Current()) },
 		},
 	)
 
@@ -486,6 +517,19 @@ func (handler *Handler) beforeRegisterEvent(e *fsm.Event, state string) {
 		return
 	}
 
+	// Chaincodes of the other chains register with their name qualified with
+	// their chain, hand them to the chaincodeSupport of that chain
+	name, chainName := splitRegistrationName(chaincodeID.Name)
+	if chainName != handler.chaincodeSupport.name {
+		chaincodeSupport := GetChain(chainName)
+		if chaincodeSupport == nil {
+			e.Cancel(fmt.Errorf("Error registering chaincode %s, chain %s not found", name, chainName))
+			return
+		}
+		handler.chaincodeSupport = chaincodeSupport
+	}
+	chaincodeID.Name = name
+
 	// Now register with the chaincodeSupport
 	handler.ChaincodeID = chaincodeID
 	err = handler.chaincodeSupport.registerHandler(handler)
@@ -574,7 +618,7 @@ func (handler *Handler) handleGetState(msg *pb.ChaincodeMessage) {
 		}()
 
 		key := string(msg.Payload)
-		ledgerObj, ledgerErr := ledger.GetLedger()
+		ledgerObj, ledgerErr := handler.chaincodeSupport.getLedger()
 		if ledgerErr != nil {
 			// Send error msg back to chaincode. GetState will not trigger event
 			payload := []byte(ledgerErr.Error())
@@ -588,7 +632,7 @@ func (handler *Handler) handleGetState(msg *pb.ChaincodeMessage) {
 		chaincodeID := handler.ChaincodeID.Name
 
 		readCommittedState := !handler.getIsTransaction(msg.Uuid)
-		res, err := ledgerObj.GetState(chaincodeID, key, readCommittedState)
+		res, err := ledgerObj.GetTxState(msg.Uuid).GetState(chaincodeID, key, readCommittedState)
 		if err != nil {
 			// Send error msg back to chaincode. GetState will not trigger event
 			payload := []byte(err.Error())
@@ -612,6 +656,93 @@ func (handler *Handler) handleGetState(msg *pb.ChaincodeMessage) {
 	}()
 }
 
+// afterGetStateMulti handles a GET_STATE_MULTI request from the chaincode.
+func (handler *Handler) afterGetStateMulti(e *fsm.Event, state string) {
+	msg, ok := e.Args[0].(*pb.ChaincodeMessage)
+	if !ok {
+		e.Cancel(fmt.Errorf("Received unexpected message type"))
+		return
+	}
+	chaincodeLogger.Debug("[%s]Received %s, invoking get state multi from ledger", shortuuid(msg.Uuid), pb.ChaincodeMessage_GET_STATE_MULTI)
+
+	// Query ledger for state
+	handler.handleGetStateMulti(msg)
+}
+
+// Handles query to ledger to get the state of many keys at once
+func (handler *Handler) handleGetStateMulti(msg *pb.ChaincodeMessage) {
+	// The defer followed by triggering a go routine dance is needed to ensure that the previous state transition
+	// is completed before the next one is triggered. The previous state transition is deemed complete only when
+	// the afterGetStateMulti function is exited.
+	go func() {
+		// Check if this is the unique state request from this chaincode uuid
+		uniqueReq := handler.createUUIDEntry(msg.Uuid)
+		if !uniqueReq {
+			// Drop this request
+			chaincodeLogger.Debug("Another state request pending for this Uuid. Cannot process.")
+			return
+		}
+
+		var serialSendMsg *pb.ChaincodeMessage
+
+		defer func() {
+			handler.deleteUUIDEntry(msg.Uuid)
+			chaincodeLogger.Debug("[%s]handleGetStateMulti serial send %s", shortuuid(serialSendMsg.Uuid), serialSendMsg.Type)
+			handler.serialSend(serialSendMsg)
+		}()
+
+		getStateMultiInfo := &pb.GetStateMultiInfo{}
+		unmarshalErr := proto.Unmarshal(msg.Payload, getStateMultiInfo)
+		if unmarshalErr != nil {
+			payload := []byte(unmarshalErr.Error())
+			chaincodeLogger.Error(fmt.Sprintf("Failed to unmarshall get state multi request. Sending %s", pb.ChaincodeMessage_ERROR))
+			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			return
+		}
+
+		ledgerObj, ledgerErr := handler.chaincodeSupport.getLedger()
+		if ledgerErr != nil {
+			payload := []byte(ledgerErr.Error())
+			chaincodeLogger.Error(fmt.Sprintf("Failed to get chaincode state(%s). Sending %s", ledgerErr, pb.ChaincodeMessage_ERROR))
+			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			return
+		}
+
+		// Invoke ledger to get state
+		chaincodeID := handler.ChaincodeID.Name
+
+		readCommittedState := !handler.getIsTransaction(msg.Uuid)
+		values, err := ledgerObj.GetTxState(msg.Uuid).GetStateMultipleKeys(chaincodeID, getStateMultiInfo.Keys, readCommittedState)
+		if err != nil {
+			payload := []byte(err.Error())
+			chaincodeLogger.Error(fmt.Sprintf("[%s]Failed to get chaincode state(%s). Sending %s", shortuuid(msg.Uuid), err, pb.ChaincodeMessage_ERROR))
+			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			return
+		}
+
+		for i := range values {
+			// Decrypt the data if the confidential is enabled
+			if values[i], err = handler.decrypt(msg.Uuid, values[i]); err != nil {
+				chaincodeLogger.Error(fmt.Sprintf("[%s]Got error (%s) while decrypting. Sending %s", shortuuid(msg.Uuid), err, pb.ChaincodeMessage_ERROR))
+				payload := []byte(err.Error())
+				serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+				return
+			}
+		}
+
+		payloadBytes, err := proto.Marshal(&pb.GetStateMultiResponse{Values: values})
+		if err != nil {
+			payload := []byte(err.Error())
+			chaincodeLogger.Error(fmt.Sprintf("Failed marshall response. Sending %s", pb.ChaincodeMessage_ERROR))
+			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			return
+		}
+
+		chaincodeLogger.Debug("[%s]Got state of %d keys. Sending %s", shortuuid(msg.Uuid), len(values), pb.ChaincodeMessage_RESPONSE)
+		serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: payloadBytes, Uuid: msg.Uuid}
+	}()
+}
+
 const maxRangeQueryStateLimit = 100
 
 // afterRangeQueryState handles a RANGE_QUERY_STATE request from the chaincode.
@@ -659,9 +790,7 @@ func (handler *Handler) handleRangeQueryState(msg *pb.ChaincodeMessage) {
 			return
 		}
 
-		hasNext := true
-
-		ledger, ledgerErr := ledger.GetLedger()
+		ledger, ledgerErr := handler.chaincodeSupport.getLedger()
 		if ledgerErr != nil {
 			// Send error msg back to chaincode. GetState will not trigger event
 			payload := []byte(ledgerErr.Error())
@@ -673,7 +802,7 @@ func (handler *Handler) handleRangeQueryState(msg *pb.ChaincodeMessage) {
 		chaincodeID := handler.ChaincodeID.Name
 
 		readCommittedState := !handler.getIsTransaction(msg.Uuid)
-		rangeIter, err := ledger.GetStateRangeScanIterator(chaincodeID, rangeQueryState.StartKey, rangeQueryState.EndKey, readCommittedState)
+		rangeIter, err := ledger.GetTxState(msg.Uuid).GetStateRangeScanIterator(chaincodeID, rangeQueryState.StartKey, rangeQueryState.EndKey, readCommittedState)
 		if err != nil {
 			// Send error msg back to chaincode. GetState will not trigger event
 			payload := []byte(err.Error())
@@ -682,55 +811,345 @@ func (handler *Handler) handleRangeQueryState(msg *pb.ChaincodeMessage) {
 			return
 		}
 
-		iterID := util.GenerateUUID()
-		txContext := handler.getTxContext(msg.Uuid)
-		handler.putRangeQueryIterator(txContext, iterID, rangeIter)
+		serialSendMsg = handler.rangeQueryStateResponse(msg, rangeIter)
+	}()
+}
+
+// rangeQueryStateResponse returns the message sending the first results of the iterator to the chaincode.
+// The iterator is kept for the RANGE_QUERY_STATE_NEXT requests if it has more results.
+func (handler *Handler) rangeQueryStateResponse(msg *pb.ChaincodeMessage, rangeIter statemgmt.RangeScanIterator) *pb.ChaincodeMessage {
+	iterID := util.GenerateUUID()
+	txContext := handler.getTxContext(msg.Uuid)
+	handler.putRangeQueryIterator(txContext, iterID, rangeIter)
+
+	hasNext := rangeIter.Next()
+
+	var keysAndValues []*pb.RangeQueryStateKeyValue
+	var i = uint32(0)
+	for ; hasNext && i < maxRangeQueryStateLimit; i++ {
+		key, value := rangeIter.GetKeyValue()
+		// Decrypt the data if the confidential is enabled
+		decryptedValue, err := handler.decrypt(msg.Uuid, value)
+		if err != nil {
+			payload := []byte(err.Error())
+			chaincodeLogger.Debug("Failed decrypt value. Sending %s", pb.ChaincodeMessage_ERROR)
+
+			rangeIter.Close()
+			handler.deleteRangeQueryIterator(txContext, iterID)
+
+			return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+		}
+		keyAndValue := pb.RangeQueryStateKeyValue{Key: key, Value: decryptedValue}
+		keysAndValues = append(keysAndValues, &keyAndValue)
 
 		hasNext = rangeIter.Next()
+	}
 
-		var keysAndValues []*pb.RangeQueryStateKeyValue
-		var i = uint32(0)
-		for ; hasNext && i < maxRangeQueryStateLimit; i++ {
-			key, value := rangeIter.GetKeyValue()
-			// Decrypt the data if the confidential is enabled
-			decryptedValue, err := handler.decrypt(msg.Uuid, value)
-			if err != nil {
-				payload := []byte(unmarshalErr.Error())
-				chaincodeLogger.Debug("Failed decrypt value. Sending %s", pb.ChaincodeMessage_ERROR)
-				serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+	if !hasNext {
+		rangeIter.Close()
+		handler.deleteRangeQueryIterator(txContext, iterID)
+	}
 
-				rangeIter.Close()
-				handler.deleteRangeQueryIterator(txContext, iterID)
+	payload := &pb.RangeQueryStateResponse{KeysAndValues: keysAndValues, HasMore: hasNext, ID: iterID}
+	payloadBytes, err := proto.Marshal(payload)
+	if err != nil {
+		rangeIter.Close()
+		handler.deleteRangeQueryIterator(txContext, iterID)
 
-				return
-			}
-			keyAndValue := pb.RangeQueryStateKeyValue{Key: key, Value: decryptedValue}
-			keysAndValues = append(keysAndValues, &keyAndValue)
+		// Send error msg back to chaincode. GetState will not trigger event
+		payload := []byte(err.Error())
+		chaincodeLogger.Debug("Failed marshall resopnse. Sending %s", pb.ChaincodeMessage_ERROR)
+		return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+	}
 
-			hasNext = rangeIter.Next()
+	chaincodeLogger.Debug("Got keys and values. Sending %s", pb.ChaincodeMessage_RESPONSE)
+	return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: payloadBytes, Uuid: msg.Uuid}
+}
+
+// afterQueryState handles a QUERY_STATE request from the chaincode.
+func (handler *Handler) afterQueryState(e *fsm.Event, state string) {
+	msg, ok := e.Args[0].(*pb.ChaincodeMessage)
+	if !ok {
+		e.Cancel(fmt.Errorf("Received unexpected message type"))
+		return
+	}
+	chaincodeLogger.Debug("Received %s, invoking query state from ledger", pb.ChaincodeMessage_QUERY_STATE)
+
+	// Query ledger for state
+	handler.handleQueryState(msg)
+	chaincodeLogger.Debug("Exiting QUERY_STATE")
+}
+
+// Handles query to ledger to query state by value
+func (handler *Handler) handleQueryState(msg *pb.ChaincodeMessage) {
+	// The defer followed by triggering a go routine dance is needed to ensure that the previous state transition
+	// is completed before the next one is triggered. The previous state transition is deemed complete only when
+	// the afterQueryState function is exited.
+	go func() {
+		// Check if this is the unique state request from this chaincode uuid
+		uniqueReq := handler.createUUIDEntry(msg.Uuid)
+		if !uniqueReq {
+			// Drop this request
+			chaincodeLogger.Debug("Another state request pending for this Uuid. Cannot process.")
+			return
 		}
 
-		if !hasNext {
-			rangeIter.Close()
-			handler.deleteRangeQueryIterator(txContext, iterID)
+		var serialSendMsg *pb.ChaincodeMessage
+
+		defer func() {
+			handler.deleteUUIDEntry(msg.Uuid)
+			chaincodeLogger.Debug("[%s]handleQueryState serial send %s", shortuuid(serialSendMsg.Uuid), serialSendMsg.Type)
+			handler.serialSend(serialSendMsg)
+		}()
+
+		queryState := &pb.QueryState{}
+		unmarshalErr := proto.Unmarshal(msg.Payload, queryState)
+		if unmarshalErr != nil {
+			payload := []byte(unmarshalErr.Error())
+			chaincodeLogger.Debug("Failed to unmarshall query request. Sending %s", pb.ChaincodeMessage_ERROR)
+			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			return
 		}
 
-		payload := &pb.RangeQueryStateResponse{KeysAndValues: keysAndValues, HasMore: hasNext, ID: iterID}
-		payloadBytes, err := proto.Marshal(payload)
+		ledger, ledgerErr := handler.chaincodeSupport.getLedger()
+		if ledgerErr != nil {
+			payload := []byte(ledgerErr.Error())
+			chaincodeLogger.Debug("Failed to get ledger. Sending %s", pb.ChaincodeMessage_ERROR)
+			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			return
+		}
+
+		// The values of confidential chaincodes are encrypted, so they are never JSON documents
+		chaincodeID := handler.ChaincodeID.Name
+
+		readCommittedState := !handler.getIsTransaction(msg.Uuid)
+		queryIter, err := ledger.GetTxState(msg.Uuid).GetStateQueryIterator(chaincodeID, queryState.Query, readCommittedState)
 		if err != nil {
-			rangeIter.Close()
-			handler.deleteRangeQueryIterator(txContext, iterID)
+			payload := []byte(err.Error())
+			chaincodeLogger.Debug("Failed to query ledger. Sending %s", pb.ChaincodeMessage_ERROR)
+			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			return
+		}
 
-			// Send error msg back to chaincode. GetState will not trigger event
+		serialSendMsg = handler.rangeQueryStateResponse(msg, queryIter)
+	}()
+}
+
+// afterGetStateByPartialCompositeKey handles a GET_STATE_BY_PARTIAL_COMPOSITE_KEY request from the chaincode.
+func (handler *Handler) afterGetStateByPartialCompositeKey(e *fsm.Event, state string) {
+	msg, ok := e.Args[0].(*pb.ChaincodeMessage)
+	if !ok {
+		e.Cancel(fmt.Errorf("Received unexpected message type"))
+		return
+	}
+	chaincodeLogger.Debug("Received %s, invoking partial composite key query to ledger", pb.ChaincodeMessage_GET_STATE_BY_PARTIAL_COMPOSITE_KEY)
+
+	// Query ledger for state
+	handler.handleGetStateByPartialCompositeKey(msg)
+	chaincodeLogger.Debug("Exiting GET_STATE_BY_PARTIAL_COMPOSITE_KEY")
+}
+
+// Handles query to ledger to get the state of the composite keys starting with some attributes
+func (handler *Handler) handleGetStateByPartialCompositeKey(msg *pb.ChaincodeMessage) {
+	// The defer followed by triggering a go routine dance is needed to ensure that the previous state transition
+	// is completed before the next one is triggered. The previous state transition is deemed complete only when
+	// the afterGetStateByPartialCompositeKey function is exited.
+	go func() {
+		// Check if this is the unique state request from this chaincode uuid
+		uniqueReq := handler.createUUIDEntry(msg.Uuid)
+		if !uniqueReq {
+			// Drop this request
+			chaincodeLogger.Debug("Another state request pending for this Uuid. Cannot process.")
+			return
+		}
+
+		var serialSendMsg *pb.ChaincodeMessage
+
+		defer func() {
+			handler.deleteUUIDEntry(msg.Uuid)
+			chaincodeLogger.Debug("[%s]handleGetStateByPartialCompositeKey serial send %s", shortuuid(serialSendMsg.Uuid), serialSendMsg.Type)
+			handler.serialSend(serialSendMsg)
+		}()
+
+		partialCompositeKey := &pb.PartialCompositeKey{}
+		unmarshalErr := proto.Unmarshal(msg.Payload, partialCompositeKey)
+		if unmarshalErr != nil {
+			payload := []byte(unmarshalErr.Error())
+			chaincodeLogger.Debug("Failed to unmarshall partial composite key request. Sending %s", pb.ChaincodeMessage_ERROR)
+			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			return
+		}
+
+		ledger, ledgerErr := handler.chaincodeSupport.getLedger()
+		if ledgerErr != nil {
+			payload := []byte(ledgerErr.Error())
+			chaincodeLogger.Debug("Failed to get ledger. Sending %s", pb.ChaincodeMessage_ERROR)
+			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			return
+		}
+
+		chaincodeID := handler.ChaincodeID.Name
+
+		readCommittedState := !handler.getIsTransaction(msg.Uuid)
+		keysIter, err := ledger.GetTxState(msg.Uuid).GetStateByPartialCompositeKey(chaincodeID, partialCompositeKey.ObjectType, partialCompositeKey.Attributes, readCommittedState)
+		if err != nil {
 			payload := []byte(err.Error())
-			chaincodeLogger.Debug("Failed marshall resopnse. Sending %s", pb.ChaincodeMessage_ERROR)
+			chaincodeLogger.Debug("Failed to get state by partial composite key from ledger. Sending %s", pb.ChaincodeMessage_ERROR)
 			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
 			return
 		}
 
-		chaincodeLogger.Debug("Got keys and values. Sending %s", pb.ChaincodeMessage_RESPONSE)
-		serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: payloadBytes, Uuid: msg.Uuid}
+		serialSendMsg = handler.rangeQueryStateResponse(msg, keysIter)
+	}()
+}
+
+// afterGetStateByIndex handles a GET_STATE_BY_INDEX request from the chaincode.
+func (handler *Handler) afterGetStateByIndex(e *fsm.Event, state string) {
+	msg, ok := e.Args[0].(*pb.ChaincodeMessage)
+	if !ok {
+		e.Cancel(fmt.Errorf("Received unexpected message type"))
+		return
+	}
+	chaincodeLogger.Debug("Received %s, invoking index scan to ledger", pb.ChaincodeMessage_GET_STATE_BY_INDEX)
+
+	// Query ledger for state
+	handler.handleGetStateByIndex(msg)
+	chaincodeLogger.Debug("Exiting GET_STATE_BY_INDEX")
+}
+
+// Handles query to ledger to get the state of the keys whose indexed field is in a range
+func (handler *Handler) handleGetStateByIndex(msg *pb.ChaincodeMessage) {
+	// The defer followed by triggering a go routine dance is needed to ensure that the previous state transition
+	// is completed before the next one is triggered. The previous state transition is deemed complete only when
+	// the afterGetStateByIndex function is exited.
+	go func() {
+		// Check if this is the unique state request from this chaincode uuid
+		uniqueReq := handler.createUUIDEntry(msg.Uuid)
+		if !uniqueReq {
+			// Drop this request
+			chaincodeLogger.Debug("Another state request pending for this Uuid. Cannot process.")
+			return
+		}
+
+		var serialSendMsg *pb.ChaincodeMessage
+
+		defer func() {
+			handler.deleteUUIDEntry(msg.Uuid)
+			chaincodeLogger.Debug("[%s]handleGetStateByIndex serial send %s", shortuuid(serialSendMsg.Uuid), serialSendMsg.Type)
+			handler.serialSend(serialSendMsg)
+		}()
+
+		indexRange := &pb.IndexRange{}
+		unmarshalErr := proto.Unmarshal(msg.Payload, indexRange)
+		if unmarshalErr != nil {
+			payload := []byte(unmarshalErr.Error())
+			chaincodeLogger.Debug("Failed to unmarshall index range request. Sending %s", pb.ChaincodeMessage_ERROR)
+			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			return
+		}
+
+		ledger, ledgerErr := handler.chaincodeSupport.getLedger()
+		if ledgerErr != nil {
+			payload := []byte(ledgerErr.Error())
+			chaincodeLogger.Debug("Failed to get ledger. Sending %s", pb.ChaincodeMessage_ERROR)
+			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			return
+		}
+
+		chaincodeID := handler.ChaincodeID.Name
+
+		readCommittedState := !handler.getIsTransaction(msg.Uuid)
+		keysIter, err := ledger.GetTxState(msg.Uuid).GetStateByIndex(chaincodeID, indexRange.Index, indexRange.StartValue, indexRange.EndValue, readCommittedState)
+		if err != nil {
+			payload := []byte(err.Error())
+			chaincodeLogger.Debug("Failed to get state by index from ledger. Sending %s", pb.ChaincodeMessage_ERROR)
+			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			return
+		}
+
+		serialSendMsg = handler.rangeQueryStateResponse(msg, keysIter)
+	}()
+}
+
+// afterGetHistoryForKey handles a GET_HISTORY_FOR_KEY request from the chaincode.
+func (handler *Handler) afterGetHistoryForKey(e *fsm.Event, state string) {
+	msg, ok := e.Args[0].(*pb.ChaincodeMessage)
+	if !ok {
+		e.Cancel(fmt.Errorf("Received unexpected message type"))
+		return
+	}
+	chaincodeLogger.Debug("Received %s, invoking get history from ledger", pb.ChaincodeMessage_GET_HISTORY_FOR_KEY)
+
+	// Query ledger for history
+	handler.handleGetHistoryForKey(msg)
+	chaincodeLogger.Debug("Exiting GET_HISTORY_FOR_KEY")
+}
+
+// Handles query to ledger to get the history of a key
+func (handler *Handler) handleGetHistoryForKey(msg *pb.ChaincodeMessage) {
+	// The defer followed by triggering a go routine dance is needed to ensure that the previous state transition
+	// is completed before the next one is triggered. The previous state transition is deemed complete only when
+	// the afterGetHistoryForKey function is exited.
+	go func() {
+		// Check if this is the unique state request from this chaincode uuid
+		uniqueReq := handler.createUUIDEntry(msg.Uuid)
+		if !uniqueReq {
+			// Drop this request
+			chaincodeLogger.Debug("Another state request pending for this Uuid. Cannot process.")
+			return
+		}
+
+		var serialSendMsg *pb.ChaincodeMessage
+
+		defer func() {
+			handler.deleteUUIDEntry(msg.Uuid)
+			chaincodeLogger.Debug("[%s]handleGetHistoryForKey serial send %s", shortuuid(serialSendMsg.Uuid), serialSendMsg.Type)
+			handler.serialSend(serialSendMsg)
+		}()
+
+		key := string(msg.Payload)
+		ledgerObj, ledgerErr := handler.chaincodeSupport.getLedger()
+		if ledgerErr != nil {
+			payload := []byte(ledgerErr.Error())
+			chaincodeLogger.Debug("Failed to get ledger. Sending %s", pb.ChaincodeMessage_ERROR)
+			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			return
+		}
+
+		chaincodeID := handler.ChaincodeID.Name
+
+		// The history only holds the committed modifications of the key
+		modifications, err := ledgerObj.GetHistoryForKey(chaincodeID, key)
+		if err != nil {
+			payload := []byte(err.Error())
+			chaincodeLogger.Error(fmt.Sprintf("[%s]Failed to get history of key(%s). Sending %s", shortuuid(msg.Uuid), err, pb.ChaincodeMessage_ERROR))
+			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			return
+		}
+
+		for _, modification := range modifications {
+			if modification.IsDelete {
+				continue
+			}
+			// Decrypt the data if the confidential is enabled
+			if modification.Value, err = handler.decrypt(msg.Uuid, modification.Value); err != nil {
+				chaincodeLogger.Error(fmt.Sprintf("[%s]Got error (%s) while decrypting. Sending %s", shortuuid(msg.Uuid), err, pb.ChaincodeMessage_ERROR))
+				payload := []byte(err.Error())
+				serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+				return
+			}
+		}
+
+		payloadBytes, err := proto.Marshal(&pb.KeyHistory{Modifications: modifications})
+		if err != nil {
+			payload := []byte(err.Error())
+			chaincodeLogger.Debug("Failed marshall response. Sending %s", pb.ChaincodeMessage_ERROR)
+			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			return
+		}
 
+		chaincodeLogger.Debug("[%s]Got history. Sending %s", shortuuid(msg.Uuid), pb.ChaincodeMessage_RESPONSE)
+		serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: payloadBytes, Uuid: msg.Uuid}
 	}()
 }
 
@@ -917,6 +1336,18 @@ func (handler *Handler) afterPutState(e *fsm.Event, state string) {
 	// Put state into ledger handled within enterBusyState
 }
 
+// afterPutStateMulti handles a PUT_STATE_MULTI request from the chaincode.
+func (handler *Handler) afterPutStateMulti(e *fsm.Event, state string) {
+	_, ok := e.Args[0].(*pb.ChaincodeMessage)
+	if !ok {
+		e.Cancel(fmt.Errorf("Received unexpected message type"))
+		return
+	}
+	chaincodeLogger.Debug("Received %s in state %s, invoking put state multi to ledger", pb.ChaincodeMessage_PUT_STATE_MULTI, state)
+
+	// Put state into ledger handled within enterBusyState
+}
+
 // afterDelState handles a DEL_STATE request from the chaincode.
 func (handler *Handler) afterDelState(e *fsm.Event, state string) {
 	_, ok := e.Args[0].(*pb.ChaincodeMessage)
@@ -971,7 +1402,7 @@ func (handler *Handler) enterBusyState(e *fsm.Event, state string) {
 			handler.triggerNextState(triggerNextStateMsg, true)
 		}()
 
-		ledgerObj, ledgerErr := ledger.GetLedger()
+		ledgerObj, ledgerErr := handler.chaincodeSupport.getLedger()
 		if ledgerErr != nil {
 			// Send error msg back to chaincode and trigger event
 			payload := []byte(ledgerErr.Error())
@@ -998,12 +1429,33 @@ func (handler *Handler) enterBusyState(e *fsm.Event, state string) {
 			// Encrypt the data if the confidential is enabled
 			if pVal, err = handler.encrypt(msg.Uuid, putStateInfo.Value); err == nil {
 				// Invoke ledger to put state
-				err = ledgerObj.SetState(chaincodeID, putStateInfo.Key, pVal)
+				err = ledgerObj.GetTxState(msg.Uuid).SetState(chaincodeID, putStateInfo.Key, pVal)
+			}
+		} else if msg.Type.String() == pb.ChaincodeMessage_PUT_STATE_MULTI.String() {
+			putStateMultiInfo := &pb.PutStateMultiInfo{}
+			unmarshalErr := proto.Unmarshal(msg.Payload, putStateMultiInfo)
+			if unmarshalErr != nil {
+				payload := []byte(unmarshalErr.Error())
+				chaincodeLogger.Debug("[%s]Unable to decipher payload. Sending %s", shortuuid(msg.Uuid), pb.ChaincodeMessage_ERROR)
+				triggerNextStateMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+				return
+			}
+
+			kvs := make(map[string][]byte, len(putStateMultiInfo.KeyValues))
+			for _, putStateInfo := range putStateMultiInfo.KeyValues {
+				// Encrypt the data if the confidential is enabled
+				if kvs[putStateInfo.Key], err = handler.encrypt(msg.Uuid, putStateInfo.Value); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				// Invoke ledger to put the state of all the keys
+				err = ledgerObj.GetTxState(msg.Uuid).SetStateMultipleKeys(chaincodeID, kvs)
 			}
 		} else if msg.Type.String() == pb.ChaincodeMessage_DEL_STATE.String() {
 			// Invoke ledger to delete state
 			key := string(msg.Payload)
-			err = ledgerObj.DeleteState(chaincodeID, key)
+			err = ledgerObj.GetTxState(msg.Uuid).DeleteState(chaincodeID, key)
 		} else if msg.Type.String() == pb.ChaincodeMessage_INVOKE_CHAINCODE.String() {
 			//check and prohibit C-call-C for CONFIDENTIAL txs
 			if triggerNextStateMsg = handler.canCallChaincode(msg.Uuid); triggerNextStateMsg != nil {
@@ -1021,7 +1473,8 @@ func (handler *Handler) enterBusyState(e *fsm.Event, state string) {
 			// Get the chaincodeID to invoke
 			newChaincodeID := chaincodeSpec.ChaincodeID.Name
 
-			// Create the transaction object
+			// Create the transaction object, chaincodes only call the chaincodes of their chain
+			chaincodeSpec.ChainID = handler.chaincodeSupport.chainID()
 			chaincodeInvocationSpec := &pb.ChaincodeInvocationSpec{ChaincodeSpec: chaincodeSpec}
 			transaction, _ := pb.NewChaincodeExecute(chaincodeInvocationSpec, msg.Uuid, pb.Transaction_CHAINCODE_INVOKE)
 
@@ -1289,7 +1742,8 @@ func (handler *Handler) handleQueryChaincode(msg *pb.ChaincodeMessage) {
 		// Get the chaincodeID to invoke
 		newChaincodeID := chaincodeSpec.ChaincodeID.Name
 
-		// Create the transaction object
+		// Create the transaction object, chaincodes only call the chaincodes of their chain
+		chaincodeSpec.ChainID = handler.chaincodeSupport.chainID()
 		chaincodeInvocationSpec := &pb.ChaincodeInvocationSpec{ChaincodeSpec: chaincodeSpec}
 		transaction, _ := pb.NewChaincodeExecute(chaincodeInvocationSpec, msg.Uuid, pb.Transaction_CHAINCODE_QUERY)
 
@@ -1363,7 +1817,7 @@ func (handler *Handler) HandleMessage(msg *pb.ChaincodeMessage) error {
 	}
 	if handler.FSM.Cannot(msg.Type.String()) {
 		// Check if this is a request from validator in query context
-		if msg.Type.String() == pb.ChaincodeMessage_PUT_STATE.String() || msg.Type.String() == pb.ChaincodeMessage_DEL_STATE.String() || msg.Type.String() == pb.ChaincodeMessage_INVOKE_CHAINCODE.String() {
+		if msg.Type.String() == pb.ChaincodeMessage_PUT_STATE.String() || msg.Type.String() == pb.ChaincodeMessage_PUT_STATE_MULTI.String() || msg.Type.String() == pb.ChaincodeMessage_DEL_STATE.String() || msg.Type.String() == pb.ChaincodeMessage_INVOKE_CHAINCODE.String() {
 			// Check if this UUID is a transaction
 			if !handler.getIsTransaction(msg.Uuid) {
 				payload := []byte(fmt.Sprintf("[%s]Cannot handle %s in query context", msg.Uuid, msg.Type.String()))
@@ -0,0 +1,77 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+)
+
+// replayKey marks in the context the transactions replayed from the blocks
+// of the blockchain, whose nonces were consumed when they first executed
+type replayKey struct{}
+
+func isReplay(ctxt context.Context) bool {
+	replay, _ := ctxt.Value(replayKey{}).(bool)
+	return replay
+}
+
+// RebuildState rebuilds the world state deleted by ledger.ResetState,
+// executing again the transactions of the blocks left to replay, one block
+// after the other as they first executed. It fails on the first block whose
+// state hash differs from the one replayed, e.g. if a chaincode is not
+// deterministic. Returns the number of blocks replayed.
+func RebuildState(ctxt context.Context, cname ChainName) (uint64, error) {
+	chain := GetChain(cname)
+	if chain == nil {
+		return 0, fmt.Errorf("Chain %s not found", cname)
+	}
+	lgr, err := chain.getLedger()
+	if err != nil {
+		return 0, err
+	}
+	next, rebuilding, err := lgr.GetStateRebuildFrom()
+	if err != nil || !rebuilding {
+		return 0, err
+	}
+	ctxt = context.WithValue(ctxt, replayKey{}, true)
+
+	size := lgr.GetBlockchainSize()
+	chaincodeLogger.Info("Rebuilding the state from block %d to %d", next, size-1)
+	var replayed uint64
+	for blockNumber := next; blockNumber < size; blockNumber++ {
+		block, err := lgr.GetBlockByNumber(blockNumber)
+		if err != nil {
+			return replayed, fmt.Errorf("Error fetching block %d to replay: %s", blockNumber, err)
+		}
+		if err = lgr.BeginTxBatch(blockNumber); err != nil {
+			return replayed, err
+		}
+		if _, _, err = ExecuteTransactions(ctxt, cname, block.Transactions); err != nil {
+			lgr.RollbackTxBatch(blockNumber)
+			return replayed, fmt.Errorf("Error replaying block %d: %s", blockNumber, err)
+		}
+		if err = lgr.CommitRebuiltBlock(blockNumber, blockNumber); err != nil {
+			return replayed, err
+		}
+		replayed++
+		chaincodeLogger.Debug("Replayed block %d", blockNumber)
+	}
+	chaincodeLogger.Info("Rebuilt the state from %d blocks", replayed)
+	return replayed, nil
+}
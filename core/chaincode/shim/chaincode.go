@@ -32,7 +32,9 @@ import (
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/chaincode/shim/crypto/ecdsa"
+	"github.com/hyperledger/fabric/core/crypto/attributes"
 	"github.com/hyperledger/fabric/core/crypto/utils"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/compositekey"
 	pb "github.com/hyperledger/fabric/protos"
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
@@ -297,6 +299,20 @@ func (stub *ChaincodeStub) PutState(key string, value []byte) error {
 	return handler.handlePutState(key, value, stub.UUID)
 }
 
+// GetStateMulti returns the values of `keys`, in the same order, nil for the
+// keys not set. The keys are read in a single request to the validator,
+// which is cheaper than calling GetState for each key.
+func (stub *ChaincodeStub) GetStateMulti(keys []string) ([][]byte, error) {
+	return handler.handleGetStateMulti(keys, stub.UUID)
+}
+
+// PutStateMulti writes all the `keyValues` into the ledger in a single
+// request to the validator. None of them is written if one of the keys is
+// empty or one of the values is nil or empty.
+func (stub *ChaincodeStub) PutStateMulti(keyValues map[string][]byte) error {
+	return handler.handlePutStateMulti(keyValues, stub.UUID)
+}
+
 // DelState removes the specified `key` and its value from the ledger.
 func (stub *ChaincodeStub) DelState(key string) error {
 	return handler.handleDelState(key, stub.UUID)
@@ -390,6 +406,18 @@ func (stub *ChaincodeStub) ReadCertAttribute(attributeName string) ([]byte, erro
 	return value, nil
 }
 
+// DecryptCertAttribute returns the decrypted value of `attributeName` from the
+// transaction tCert. `preKeys` holds the keys of the caller's affiliation by
+// attribute master key id, as read from the TCA.
+func (stub *ChaincodeStub) DecryptCertAttribute(attributeName string, preKeys map[uint32][]byte) ([]byte, error) {
+	tcert, err := utils.DERToX509Certificate(stub.securityContext.CallerCert)
+	if err != nil {
+		return nil, err
+	}
+
+	return attributes.DecryptTCertAttribute(tcert, attributeName, preKeys)
+}
+
 // StateRangeQueryIterator allows a chaincode to iterate over a range of
 // key/value pairs in the state.
 type StateRangeQueryIterator struct {
@@ -412,6 +440,72 @@ func (stub *ChaincodeStub) RangeQueryState(startKey, endKey string) (*StateRange
 	return &StateRangeQueryIterator{handler, stub.UUID, response, 0}, nil
 }
 
+// GetQueryResult function can be invoked by a chaincode to query the state
+// by value. The values of the chaincode which are JSON documents matching the
+// query are returned by an iterator, in lexical order of their keys. The
+// query is a JSON object such as
+//	{"selector": {"owner": "alice", "size": {"$gt": 10}}, "limit": 20}
+// whose syntax is described in package core/ledger/statemgmt/query.
+func (stub *ChaincodeStub) GetQueryResult(query string) (*StateRangeQueryIterator, error) {
+	response, err := handler.handleQueryState(query, stub.UUID)
+	if err != nil {
+		return nil, err
+	}
+	return &StateRangeQueryIterator{handler, stub.UUID, response, 0}, nil
+}
+
+// CreateCompositeKey returns the key of the state made of `objectType` and
+// `attributes`, none of which may contain U+0000. The composite keys of an
+// object type sort in lexical order of their attributes, and can be looked
+// up by their leading attributes with GetStateByPartialCompositeKey.
+func (stub *ChaincodeStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	return compositekey.Create(objectType, attributes)
+}
+
+// SplitCompositeKey returns the object type and attributes of a key made by
+// CreateCompositeKey.
+func (stub *ChaincodeStub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	return compositekey.Split(compositeKey)
+}
+
+// GetStateByPartialCompositeKey function can be invoked by a chaincode to
+// query the state by the leading attributes of composite keys. The composite
+// keys of `objectType` starting with `attributes` and their values are
+// returned by an iterator, in lexical order of the keys, and so of their
+// attributes. No attributes returns all the composite keys of `objectType`.
+func (stub *ChaincodeStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (*StateRangeQueryIterator, error) {
+	response, err := handler.handleGetStateByPartialCompositeKey(objectType, attributes, stub.UUID)
+	if err != nil {
+		return nil, err
+	}
+	return &StateRangeQueryIterator{handler, stub.UUID, response, 0}, nil
+}
+
+// GetStateByIndex function can be invoked by a chaincode to query the state
+// by a secondary index, declared for the chaincode in the configuration of
+// the peers ('ledger.state.indexes') on a field of the JSON documents held by
+// its values. The keys whose field is between `startValue` and `endValue`,
+// inclusive, and their values are returned by an iterator, in order of the
+// values of the field, then of the keys. The values of the field compare as
+// strings, numbers and booleans by their JSON text, and an empty `endValue`
+// sets no upper bound: a `startValue` equal to `endValue` returns the keys
+// whose field holds that value.
+func (stub *ChaincodeStub) GetStateByIndex(index, startValue, endValue string) (*StateRangeQueryIterator, error) {
+	response, err := handler.handleGetStateByIndex(index, startValue, endValue, stub.UUID)
+	if err != nil {
+		return nil, err
+	}
+	return &StateRangeQueryIterator{handler, stub.UUID, response, 0}, nil
+}
+
+// GetHistoryForKey function can be invoked by a chaincode to get the values
+// the committed transactions wrote to `key`, from the oldest to the latest,
+// each with the UUID of the transaction and the number of its block. The
+// history is only kept by the peers whose ledger.history.enabled is set.
+func (stub *ChaincodeStub) GetHistoryForKey(key string) ([]*pb.KeyModification, error) {
+	return handler.handleGetHistoryForKey(key, stub.UUID)
+}
+
 // HasNext returns true if the range query iterator contains additional keys
 // and values.
 func (iter *StateRangeQueryIterator) HasNext() bool {
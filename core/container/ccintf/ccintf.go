@@ -42,9 +42,19 @@ func GetCCHandlerKey() string {
 	return "CCHANDLER"
 }
 
-//CCID encapsulates chaincode ID
+//CCID encapsulates chaincode ID and the chain the chaincode runs on
 type CCID struct {
 	ChaincodeSpec *pb.ChaincodeSpec
 	NetworkID     string
 	PeerID        string
+	ChainID       string
+}
+
+// GetName returns the name of the chaincode, qualified with the chain
+// unless the chaincode runs on the default chain
+func (ccid CCID) GetName() string {
+	if ccid.ChainID == "" {
+		return ccid.ChaincodeSpec.ChaincodeID.Name
+	}
+	return ccid.ChaincodeSpec.ChaincodeID.Name + "-" + ccid.ChainID
 }
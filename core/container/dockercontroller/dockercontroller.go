@@ -151,10 +151,10 @@ func (vm *DockerVM) stopInternal(ctxt context.Context, client *docker.Client, id
 //keep image name's unique in a single host, multi-peer environment (such as a development environment)
 func (vm *DockerVM) GetVMName(ccid ccintf.CCID) (string, error) {
 	if ccid.NetworkID != "" {
-		return fmt.Sprintf("%s-%s-%s", ccid.NetworkID, ccid.PeerID, ccid.ChaincodeSpec.ChaincodeID.Name), nil
+		return fmt.Sprintf("%s-%s-%s", ccid.NetworkID, ccid.PeerID, ccid.GetName()), nil
 	} else if ccid.PeerID != "" {
-		return fmt.Sprintf("%s-%s", ccid.PeerID, ccid.ChaincodeSpec.ChaincodeID.Name), nil
+		return fmt.Sprintf("%s-%s", ccid.PeerID, ccid.GetName()), nil
 	} else {
-		return ccid.ChaincodeSpec.ChaincodeID.Name, nil
+		return ccid.GetName(), nil
 	}
 }
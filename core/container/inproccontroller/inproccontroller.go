@@ -59,14 +59,14 @@ type InprocVM struct {
 }
 
 func (vm *InprocVM) getInstance(ctxt context.Context, ipctemplate *inprocContainer, ccid ccintf.CCID, args []string, env []string) (*inprocContainer, error) {
-	ipc := instRegistry[ccid.ChaincodeSpec.ChaincodeID.Name]
+	ipc := instRegistry[ccid.GetName()]
 	if ipc != nil {
-		inprocLogger.Warning(fmt.Sprintf("chaincode instance exists for %s", ccid.ChaincodeSpec.ChaincodeID.Name))
+		inprocLogger.Warning(fmt.Sprintf("chaincode instance exists for %s", ccid.GetName()))
 		return ipc, nil
 	}
 	ipc = &inprocContainer{args: args, env: env, chaincode: ipctemplate.chaincode, stopChan: make(chan struct{})}
-	instRegistry[ccid.ChaincodeSpec.ChaincodeID.Name] = ipc
-	inprocLogger.Debug("chaincode instance created for %s", ccid.ChaincodeSpec.ChaincodeID.Name)
+	instRegistry[ccid.GetName()] = ipc
+	inprocLogger.Debug("chaincode instance created for %s", ccid.GetName())
 	return ipc, nil
 }
 
@@ -155,7 +155,7 @@ func (vm *InprocVM) Start(ctxt context.Context, ccid ccintf.CCID, args []string,
 	ipc, err := vm.getInstance(ctxt, ipctemplate, ccid, args, env)
 
 	if err != nil {
-		return fmt.Errorf(fmt.Sprintf("could not create instance for %s", ccid.ChaincodeSpec.ChaincodeID.Name))
+		return fmt.Errorf(fmt.Sprintf("could not create instance for %s", ccid.GetName()))
 	}
 
 	if ipc.running {
@@ -174,10 +174,10 @@ func (vm *InprocVM) Start(ctxt context.Context, ccid ccintf.CCID, args []string,
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
-				inprocLogger.Critical("caught panic from chaincode  %s", ccid.ChaincodeSpec.ChaincodeID.Name)
+				inprocLogger.Critical("caught panic from chaincode  %s", ccid.GetName())
 			}
 		}()
-		ipc.launchInProc(ctxt, ccid.ChaincodeSpec.ChaincodeID.Name, args, env, ccSupport)
+		ipc.launchInProc(ctxt, ccid.GetName(), args, env, ccSupport)
 	}()
 
 	return nil
@@ -192,24 +192,24 @@ func (vm *InprocVM) Stop(ctxt context.Context, ccid ccintf.CCID, timeout uint, d
 		return fmt.Errorf("%s not registered", path)
 	}
 
-	ipc := instRegistry[ccid.ChaincodeSpec.ChaincodeID.Name]
+	ipc := instRegistry[ccid.GetName()]
 
 	if ipc == nil {
-		return fmt.Errorf("%s not found", ccid.ChaincodeSpec.ChaincodeID.Name)
+		return fmt.Errorf("%s not found", ccid.GetName())
 	}
 
 	if !ipc.running {
-		return fmt.Errorf("%s not running", ccid.ChaincodeSpec.ChaincodeID.Name)
+		return fmt.Errorf("%s not running", ccid.GetName())
 	}
 
 	ipc.stopChan <- struct{}{}
 
-	delete(instRegistry, ccid.ChaincodeSpec.ChaincodeID.Name)
+	delete(instRegistry, ccid.GetName())
 	//TODO stop
 	return nil
 }
 
 //GetVMName ignores the peer and network name as it just needs to be unique in process
 func (vm *InprocVM) GetVMName(ccid ccintf.CCID) (string, error) {
-	return ccid.ChaincodeSpec.ChaincodeID.Name, nil
+	return ccid.GetName(), nil
 }
@@ -0,0 +1,148 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attributes
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// padding is appended by the TCA to the attribute values before encrypting them
+var padding = bytes.Repeat([]byte{255}, 16)
+
+// GetKeyID returns the id of the attribute master key the extensions of tcert
+// are encrypted under. TCerts issued before the master key was first rotated
+// carry no id and are encrypted under key 0.
+func GetKeyID(tcert *x509.Certificate) (uint32, error) {
+	for _, ext := range tcert.Extensions {
+		if !ext.Id.Equal(utils.TCertAttributesKeyID) {
+			continue
+		}
+
+		var id int64
+		if _, err := asn1.Unmarshal(ext.Value, &id); err != nil {
+			return 0, err
+		}
+		if id < 0 || id > int64(^uint32(0)) {
+			return 0, errors.New("Invalid attribute key id.")
+		}
+		return uint32(id), nil
+	}
+
+	return 0, nil
+}
+
+// GetPreK0 derives the key of tcert from preK1, the key of the affiliation
+// of its owner.
+func GetPreK0(preK1 []byte, tcert *x509.Certificate) []byte {
+	mac := hmac.New(primitives.GetDefaultHash(), preK1)
+	mac.Write(tcert.SerialNumber.Bytes())
+	return mac.Sum(nil)
+}
+
+// GetKForAttribute returns the key encrypting attributeName in the TCert
+// whose key is preK0.
+func GetKForAttribute(attributeName string, preK0 []byte) []byte {
+	mac := hmac.New(primitives.GetDefaultHash(), preK0)
+	mac.Write([]byte(attributeName))
+	return mac.Sum(nil)[:32]
+}
+
+// DecryptAttributeValue decrypts value, an attribute encrypted by the TCA
+// under attributeKey.
+func DecryptAttributeValue(attributeKey []byte, value []byte) ([]byte, error) {
+	pt, err := primitives.CBCPKCS7Decrypt(attributeKey, value)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasSuffix(pt, padding) {
+		return nil, errors.New("Invalid attribute key.")
+	}
+
+	return pt[:len(pt)-len(padding)], nil
+}
+
+// ParseAttributesHeader parses the header listing the position of each
+// attribute in the TCert.
+func ParseAttributesHeader(header string) (map[string]int, error) {
+	positions := make(map[string]int)
+	for _, token := range strings.Split(header, "#") {
+		pair := strings.Split(token, "->")
+		if len(pair) != 2 {
+			continue
+		}
+
+		position, err := strconv.Atoi(pair[1])
+		if err != nil {
+			return nil, err
+		}
+		positions[pair[0]] = position
+	}
+
+	return positions, nil
+}
+
+// ReadTCertAttribute returns the value of attributeName as carried by tcert,
+// encrypted if the TCA encrypts attributes.
+func ReadTCertAttribute(tcert *x509.Certificate, attributeName string) ([]byte, error) {
+	header, err := utils.GetCriticalExtension(tcert, utils.TCertAttributesHeaders)
+	if err != nil {
+		return nil, err
+	}
+	positions, err := ParseAttributesHeader(string(header))
+	if err != nil {
+		return nil, err
+	}
+
+	position := positions[attributeName]
+	if position == 0 {
+		return nil, errors.New("Failed attribute doesn't exists in the TCert.")
+	}
+
+	oid := asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 9 + position}
+	return utils.GetCriticalExtension(tcert, oid)
+}
+
+// DecryptTCertAttribute decrypts attributeName in tcert. preKeys holds the
+// keys of the affiliation of the owner of tcert by master key id, as read
+// from the TCA; the one tcert has been issued under is used, so that TCerts
+// issued before and after a rotation can be decrypted alike.
+func DecryptTCertAttribute(tcert *x509.Certificate, attributeName string, preKeys map[uint32][]byte) ([]byte, error) {
+	id, err := GetKeyID(tcert)
+	if err != nil {
+		return nil, err
+	}
+	preK1, ok := preKeys[id]
+	if !ok {
+		return nil, errors.New("Unknown attribute key [" + strconv.FormatUint(uint64(id), 10) + "].")
+	}
+
+	value, err := ReadTCertAttribute(tcert, attributeName)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecryptAttributeValue(GetKForAttribute(attributeName, GetPreK0(preK1, tcert)), value)
+}
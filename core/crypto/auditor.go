@@ -0,0 +1,141 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"github.com/op/go-logging"
+)
+
+var (
+	auditLogger = logging.MustGetLogger("crypto/audit")
+)
+
+// AuditRecord describes an access of an auditor to confidential data
+type AuditRecord struct {
+	// Auditor is the enrollment id of the auditor
+	Auditor string
+	// Operation is the name of the Auditor method invoked
+	Operation string
+	// UUID is the uuid of the transaction accessed
+	UUID string
+	// Chaincode is the name of the chaincode accessed, when known
+	Chaincode string
+	// Granted tells whether the access has been granted
+	Granted bool
+	// Err is the reason of a refusal or a failure
+	Err error
+	// Time is the time of the access
+	Time time.Time
+}
+
+// AuditLog keeps the records of the accesses of the auditors, for instance
+// in tamper-evident storage. Implementations must be safe for concurrent use.
+type AuditLog interface {
+	Record(record *AuditRecord)
+}
+
+var (
+	auditLog     AuditLog
+	auditLogLock sync.RWMutex
+)
+
+// SetAuditLog sets the log receiving the records of the accesses of the
+// auditors, in addition to the crypto/audit logger. Passing nil removes it.
+func SetAuditLog(log AuditLog) {
+	auditLogLock.Lock()
+	defer auditLogLock.Unlock()
+
+	auditLog = log
+}
+
+func getAuditLog() AuditLog {
+	auditLogLock.RLock()
+	defer auditLogLock.RUnlock()
+
+	return auditLog
+}
+
+// Public Methods
+
+// RegisterAuditor registers an auditor to the PKI infrastructure
+func RegisterAuditor(name string, pwd []byte, enrollID, enrollPWD string) error {
+	log.Info("Registering auditor [%s] with name [%s]...", enrollID, name)
+
+	err := auditorRegistry.register(name, func() error {
+		auditor := newAuditor()
+		if err := auditor.register(name, pwd, enrollID, enrollPWD); err != nil {
+			if err != utils.ErrAlreadyRegistered && err != utils.ErrAlreadyInitialized {
+				log.Error("Failed registering auditor [%s] with name [%s] [%s].", enrollID, name, err)
+				return err
+			}
+			log.Info("Registering auditor [%s] with name [%s]...done. Already registered or initiliazed.", enrollID, name)
+		}
+		if err := auditor.close(); err != nil {
+			// It is not necessary to report this error to the caller
+			log.Warning("Registering auditor [%s] with name [%s]. Failed closing [%s].", enrollID, name, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info("Registering auditor [%s] with name [%s]...done!", enrollID, name)
+
+	return nil
+}
+
+// InitAuditor initializes an auditor named name with password pwd. Every
+// call must be balanced by a call to CloseAuditor.
+func InitAuditor(name string, pwd []byte) (Auditor, error) {
+	log.Info("Initializing auditor [%s]...", name)
+
+	node, err := auditorRegistry.acquire(name, true, func() (registryNode, error) {
+		auditor := newAuditor()
+		if err := auditor.init(name, pwd); err != nil {
+			log.Error("Failed auditor initialization [%s]: [%s]", name, err)
+
+			return nil, err
+		}
+
+		return auditor, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("Initializing auditor [%s]...done!", name)
+
+	return node.(Auditor), nil
+}
+
+// CloseAuditor releases a reference on auditor, obtained by InitAuditor.
+// The resources allocated by auditor are released with the last reference.
+func CloseAuditor(auditor Auditor) error {
+	return auditorRegistry.release(auditor, false)
+}
+
+// Private Methods
+
+func newAuditor() *auditorImpl {
+	return &auditorImpl{validator: newValidator()}
+}
@@ -0,0 +1,235 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	obc "github.com/hyperledger/fabric/protos"
+)
+
+// auditorImpl decrypts transactions and state with the chain private key,
+// as validators do, within the chaincodes of its scope
+type auditorImpl struct {
+	validator *validatorImpl
+
+	// chaincodes is the scope of the auditor, unless anyChaincode is set
+	chaincodes   map[string]bool
+	anyChaincode bool
+}
+
+// GetType returns NodeAuditor
+func (auditor *auditorImpl) GetType() NodeType {
+	return auditor.validator.GetType()
+}
+
+// GetName returns the name of the auditor
+func (auditor *auditorImpl) GetName() string {
+	return auditor.validator.GetName()
+}
+
+// GetTLSBinding returns the TLS binding of the auditor
+func (auditor *auditorImpl) GetTLSBinding() ([]byte, error) {
+	return auditor.validator.GetTLSBinding()
+}
+
+// GetEnrollmentID returns this auditor's enrollment id
+func (auditor *auditorImpl) GetEnrollmentID() string {
+	return auditor.validator.GetEnrollmentID()
+}
+
+// DecryptTransaction returns a clone of tx whose chaincode ID, payload and
+// metadata are in the clear
+func (auditor *auditorImpl) DecryptTransaction(tx *obc.Transaction) (*obc.Transaction, error) {
+	if !auditor.validator.isInitialized {
+		return nil, utils.ErrNotInitialized
+	}
+
+	clone, chaincode, err := auditor.decrypt(tx)
+	auditor.audit("DecryptTransaction", tx, chaincode, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return clone, nil
+}
+
+// GetStateDecryptor returns a StateDecryptor for the state written by
+// executeTx to the chaincode deployed by deployTx
+func (auditor *auditorImpl) GetStateDecryptor(deployTx, executeTx *obc.Transaction) (StateDecryptor, error) {
+	if !auditor.validator.isInitialized {
+		return nil, utils.ErrNotInitialized
+	}
+
+	se, chaincode, err := auditor.getStateEncryptor(deployTx, executeTx)
+	auditor.audit("GetStateDecryptor", executeTx, chaincode, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auditStateDecryptor{auditor, executeTx, chaincode, se}, nil
+}
+
+func (auditor *auditorImpl) getStateEncryptor(deployTx, executeTx *obc.Transaction) (StateEncryptor, string, error) {
+	if deployTx.ConfidentialityLevel != obc.ConfidentialityLevel_CONFIDENTIAL {
+		return nil, "", utils.ErrInvalidConfidentialityLevel
+	}
+
+	deployClone, chaincode, err := auditor.decrypt(deployTx)
+	if err != nil {
+		return nil, chaincode, err
+	}
+	executeClone, executeChaincode, err := auditor.decrypt(executeTx)
+	if err != nil {
+		return nil, executeChaincode, err
+	}
+	if chaincode != executeChaincode {
+		return nil, chaincode, utils.ErrDifferentChaincodeID
+	}
+
+	se, err := auditor.validator.GetStateEncryptor(deployClone, executeClone)
+	if err != nil {
+		auditor.validator.error("Failed getting state encryptor [%s].", err.Error())
+
+		return nil, chaincode, err
+	}
+
+	return se, chaincode, nil
+}
+
+// decrypt clones and decrypts tx, provided its chaincode is in scope. The
+// name of the chaincode is returned as soon as it is known.
+func (auditor *auditorImpl) decrypt(tx *obc.Transaction) (*obc.Transaction, string, error) {
+	var clone *obc.Transaction
+	var err error
+	switch tx.ConfidentialityLevel {
+	case obc.ConfidentialityLevel_PUBLIC:
+		clone, err = auditor.validator.deepCloneTransaction(tx)
+	case obc.ConfidentialityLevel_CONFIDENTIAL:
+		clone, err = auditor.validator.deepCloneAndDecryptTx(tx)
+	default:
+		err = utils.ErrInvalidConfidentialityLevel
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	chaincodeID := &obc.ChaincodeID{}
+	if err := proto.Unmarshal(clone.ChaincodeID, chaincodeID); err != nil {
+		auditor.validator.error("Failed unmarshalling chaincode ID [%s].", err.Error())
+
+		return nil, "", err
+	}
+	if !auditor.anyChaincode && !auditor.chaincodes[chaincodeID.Name] {
+		return nil, chaincodeID.Name, utils.ErrAuditAccessDenied
+	}
+
+	return clone, chaincodeID.Name, nil
+}
+
+// audit records an access to tx in the audit log
+func (auditor *auditorImpl) audit(operation string, tx *obc.Transaction, chaincode string, err error) {
+	record := &AuditRecord{
+		Auditor:   auditor.GetEnrollmentID(),
+		Operation: operation,
+		UUID:      tx.Uuid,
+		Chaincode: chaincode,
+		Granted:   err == nil,
+		Err:       err,
+		Time:      time.Now(),
+	}
+
+	if record.Granted {
+		auditLogger.Info("Auditor [%s] %s uuid [%s] chaincode [%s]: granted", record.Auditor, operation, record.UUID, chaincode)
+	} else {
+		auditLogger.Warning("Auditor [%s] %s uuid [%s] chaincode [%s]: refused [%s]", record.Auditor, operation, record.UUID, chaincode, err)
+	}
+
+	if sink := getAuditLog(); sink != nil {
+		sink.Record(record)
+	}
+}
+
+func (auditor *auditorImpl) register(id string, pwd []byte, enrollID, enrollPWD string) error {
+	if auditor.validator.isInitialized {
+		auditor.validator.error("Registering...done! Initialization already performed", enrollID)
+
+		return utils.ErrAlreadyInitialized
+	}
+
+	// Register node
+	if err := auditor.validator.peerImpl.register(NodeAuditor, id, pwd, enrollID, enrollPWD); err != nil {
+		log.Error("Failed registering [%s]: [%s]", enrollID, err)
+		return err
+	}
+
+	return nil
+}
+
+func (auditor *auditorImpl) init(name string, pwd []byte) error {
+	if auditor.validator.isInitialized {
+		auditor.validator.error("Already initializaed.")
+
+		return utils.ErrAlreadyInitialized
+	}
+
+	// Register node
+	if err := auditor.validator.peerImpl.init(NodeAuditor, name, pwd); err != nil {
+		return err
+	}
+
+	// Init crypto engine
+	if err := auditor.validator.initCryptoEngine(); err != nil {
+		auditor.validator.error("Failed initiliazing crypto engine [%s].", err.Error())
+		return err
+	}
+
+	// Load the scope
+	auditor.chaincodes = make(map[string]bool)
+	for _, chaincode := range auditor.validator.conf.getAuditChaincodes() {
+		if chaincode == "*" {
+			auditor.anyChaincode = true
+		}
+		auditor.chaincodes[chaincode] = true
+	}
+
+	// initialized
+	auditor.validator.isInitialized = true
+
+	return nil
+}
+
+func (auditor *auditorImpl) close() error {
+	return auditor.validator.close()
+}
+
+// auditStateDecryptor records every decryption in the audit log
+type auditStateDecryptor struct {
+	auditor   *auditorImpl
+	tx        *obc.Transaction
+	chaincode string
+	se        StateEncryptor
+}
+
+func (sd *auditStateDecryptor) Decrypt(ct []byte) ([]byte, error) {
+	pt, err := sd.se.Decrypt(ct)
+	sd.auditor.audit("DecryptState", sd.tx, sd.chaincode, err)
+
+	return pt, err
+}
@@ -18,51 +18,32 @@ package crypto
 
 import (
 	"github.com/hyperledger/fabric/core/crypto/utils"
-	"sync"
-)
-
-// Private Variables
-
-type clientEntry struct {
-	client  Client
-	counter int64
-}
-
-var (
-	// Map of initialized clients
-	clients = make(map[string]clientEntry)
-
-	// Sync
-	clientMutex sync.Mutex
 )
 
 // Public Methods
 
 // RegisterClient registers a client to the PKI infrastructure
 func RegisterClient(name string, pwd []byte, enrollID, enrollPWD string) error {
-	clientMutex.Lock()
-	defer clientMutex.Unlock()
-
 	log.Info("Registering client [%s] with name [%s]...", enrollID, name)
 
-	if _, ok := clients[name]; ok {
-		log.Info("Registering client [%s] with name [%s]...done. Already initialized.", enrollID, name)
+	err := clientRegistry.register(name, func() error {
+		client := newClient()
+		if err := client.register(name, pwd, enrollID, enrollPWD); err != nil {
+			if err != utils.ErrAlreadyRegistered && err != utils.ErrAlreadyInitialized {
+				log.Error("Failed registering client [%s] with name [%s] [%s].", enrollID, name, err)
+				return err
+			}
+			log.Info("Registering client [%s] with name [%s]...done. Already registered or initiliazed.", enrollID, name)
+		}
+		if err := client.close(); err != nil {
+			// It is not necessary to report this error to the caller
+			log.Warning("Registering client [%s] with name [%s]. Failed closing [%s].", enrollID, name, err)
+		}
 
 		return nil
-	}
-
-	client := newClient()
-	if err := client.register(name, pwd, enrollID, enrollPWD); err != nil {
-		if err != utils.ErrAlreadyRegistered && err != utils.ErrAlreadyInitialized {
-			log.Error("Failed registering client [%s] with name [%s] [%s].", enrollID, name, err)
-			return err
-		}
-		log.Info("Registering client [%s] with name [%s]...done. Already registered or initiliazed.", enrollID, name)
-	}
-	err := client.close()
+	})
 	if err != nil {
-		// It is not necessary to report this error to the caller
-		log.Warning("Registering client [%s] with name [%s]. Failed closing [%s].", enrollID, name, err)
+		return err
 	}
 
 	log.Info("Registering client [%s] with name [%s]...done!", enrollID, name)
@@ -70,90 +51,105 @@ func RegisterClient(name string, pwd []byte, enrollID, enrollPWD string) error {
 	return nil
 }
 
-// InitClient initializes a client named name with password pwd
+// InitClient initializes a client named name with password pwd. Every call
+// must be balanced by a call to CloseClient.
 func InitClient(name string, pwd []byte) (Client, error) {
-	clientMutex.Lock()
-	defer clientMutex.Unlock()
-
 	log.Info("Initializing client [%s]...", name)
 
-	if entry, ok := clients[name]; ok {
-		log.Info("Client already initiliazied [%s]. Increasing counter from [%d]", name, clients[name].counter)
-		entry.counter++
-		clients[name] = entry
-
-		return clients[name].client, nil
-	}
+	node, err := clientRegistry.acquire(name, true, func() (registryNode, error) {
+		client := newClient()
+		if err := client.init(name, pwd); err != nil {
+			log.Error("Failed client initialization [%s]: [%s].", name, err)
 
-	client := newClient()
-	if err := client.init(name, pwd); err != nil {
-		log.Error("Failed client initialization [%s]: [%s].", name, err)
+			return nil, err
+		}
 
+		return client, nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	clients[name] = clientEntry{client, 1}
 	log.Info("Initializing client [%s]...done!", name)
 
-	return client, nil
+	return node.(Client), nil
 }
 
-// CloseClient releases all the resources allocated by clients
+// CloseClient releases a reference on client, obtained by InitClient. The resources
+// allocated by client are released with the last reference.
 func CloseClient(client Client) error {
-	clientMutex.Lock()
-	defer clientMutex.Unlock()
-
-	return closeClientInternal(client, false)
+	return clientRegistry.release(client, false)
 }
 
 // CloseAllClients closes all the clients initialized so far
 func CloseAllClients() (bool, []error) {
-	clientMutex.Lock()
-	defer clientMutex.Unlock()
-
 	log.Info("Closing all clients...")
+	failed, errs := clientRegistry.releaseAll()
+	log.Info("Closing all clients...done!")
 
-	errs := make([]error, len(clients))
-	for _, value := range clients {
-		err := closeClientInternal(value.client, true)
+	return failed, errs
+}
 
-		errs = append(errs, err)
+// ExportClient exports the keystore of the client named name, protected by
+// pwd: its enrollment key and certificate, its keys and its unused TCerts.
+// The archive returned is encrypted under a key derived from passphrase.
+// The client must not be initialized, by this process or any other.
+func ExportClient(name string, pwd, passphrase []byte) ([]byte, error) {
+	log.Info("Exporting client [%s]...", name)
+
+	var archive []byte
+	err := clientRegistry.exclusive(name, func() error {
+		client := newClient()
+		defer client.close()
+
+		if err := client.openKeyStore(name, pwd, true); err != nil {
+			return err
+		}
+
+		var err error
+		archive, err = client.exportKeyStore(passphrase)
+		return err
+	})
+	if err != nil {
+		log.Error("Failed exporting client [%s]: [%s].", name, err)
+
+		return nil, err
 	}
 
-	log.Info("Closing all clients...done!")
+	log.Info("Exporting client [%s]...done!", name)
 
-	return len(errs) != 0, errs
+	return archive, nil
 }
 
-// Private Methods
+// ImportClient creates the keystore of the client named name, protected by
+// pwd, out of an archive returned by ExportClient. The client can then be
+// initialized by InitClient without registering.
+func ImportClient(name string, pwd, passphrase, archive []byte) error {
+	log.Info("Importing client [%s]...", name)
 
-func newClient() *clientImpl {
-	return &clientImpl{&nodeImpl{}, false, nil, nil, nil, nil}
-}
+	err := clientRegistry.exclusive(name, func() error {
+		client := newClient()
+		defer client.close()
 
-func closeClientInternal(client Client, force bool) error {
-	if client == nil {
-		return utils.ErrNilArgument
-	}
+		if err := client.openKeyStore(name, pwd, false); err != nil {
+			return err
+		}
 
-	name := client.GetName()
-	log.Info("Closing client [%s]...", name)
-	entry, ok := clients[name]
-	if !ok {
-		return utils.ErrInvalidReference
-	}
-	if entry.counter == 1 || force {
-		defer delete(clients, name)
-		err := clients[name].client.(*clientImpl).close()
-		log.Debug("Closing client [%s]...cleanup! [%s].", name, utils.ErrToString(err))
+		return client.importKeyStore(archive, passphrase)
+	})
+	if err != nil {
+		log.Error("Failed importing client [%s]: [%s].", name, err)
 
 		return err
 	}
 
-	// decrease counter
-	entry.counter--
-	clients[name] = entry
-	log.Debug("Closing client [%s]...decreased counter at [%d].", name, clients[name].counter)
+	log.Info("Importing client [%s]...done!", name)
 
 	return nil
 }
+
+// Private Methods
+
+func newClient() *clientImpl {
+	return &clientImpl{&nodeImpl{}, false, nil, nil, nil, nil, tCertRevocationList{}, nil, nil}
+}
@@ -39,6 +39,9 @@ func (client *clientImpl) encryptTx(tx *obc.Transaction) error {
 	case "1.2":
 		client.debug("Using confidentiality protocol version 1.2")
 		return client.encryptTxVersion1_2(tx)
+	case "1.3":
+		client.debug("Using confidentiality protocol version 1.3")
+		return client.encryptTxVersion1_3(tx)
 	}
 
 	return utils.ErrInvalidProtocolVersion
@@ -57,7 +60,7 @@ func (client *clientImpl) encryptTxVersion1_1(tx *obc.Transaction) error {
 
 	// Encrypt Payload
 	payloadKey := primitives.HMACAESTruncated(txKey, []byte{1})
-	encryptedPayload, err := primitives.CBCPKCS7Encrypt(payloadKey, tx.Payload)
+	encryptedPayload, err := client.csp.Encrypt(payloadKey, tx.Payload)
 	if err != nil {
 		return err
 	}
@@ -65,7 +68,7 @@ func (client *clientImpl) encryptTxVersion1_1(tx *obc.Transaction) error {
 
 	// Encrypt ChaincodeID
 	chaincodeIDKey := primitives.HMACAESTruncated(txKey, []byte{2})
-	encryptedChaincodeID, err := primitives.CBCPKCS7Encrypt(chaincodeIDKey, tx.ChaincodeID)
+	encryptedChaincodeID, err := client.csp.Encrypt(chaincodeIDKey, tx.ChaincodeID)
 	if err != nil {
 		return err
 	}
@@ -74,7 +77,7 @@ func (client *clientImpl) encryptTxVersion1_1(tx *obc.Transaction) error {
 	// Encrypt Metadata
 	if len(tx.Metadata) != 0 {
 		metadataKey := primitives.HMACAESTruncated(txKey, []byte{3})
-		encryptedMetadata, err := primitives.CBCPKCS7Encrypt(metadataKey, tx.Metadata)
+		encryptedMetadata, err := client.csp.Encrypt(metadataKey, tx.Metadata)
 		if err != nil {
 			return err
 		}
@@ -212,3 +215,103 @@ func (client *clientImpl) encryptTxVersion1_2(tx *obc.Transaction) error {
 
 	return nil
 }
+
+// chainCodeValidatorMessage1_3 represents a message to validators
+type chainCodeValidatorMessage1_3 struct {
+	StateKey   []byte
+	PayloadKey []byte
+}
+
+// confidentialityAD1_3 returns the additional data authenticated together
+// with the field of index index of the transaction with the passed nonce
+func confidentialityAD1_3(nonce []byte, index byte) []byte {
+	return append([]byte{index}, nonce...)
+}
+
+// encryptTxVersion1_3 is like encryptTxVersion1_2 but the fields of the
+// transaction are encrypted and authenticated with AES-GCM under a fresh
+// key sent to the validators
+func (client *clientImpl) encryptTxVersion1_3(tx *obc.Transaction) error {
+	// Prepare message to the validators
+	var (
+		stateKey []byte
+		err      error
+	)
+
+	switch tx.Type {
+	case obc.Transaction_CHAINCODE_DEPLOY:
+		stateKey, err = primitives.GenAESKey()
+		if err != nil {
+			client.error("Failed creating state key: [%s]", err)
+
+			return err
+		}
+	case obc.Transaction_CHAINCODE_QUERY:
+		stateKey = primitives.HMACAESTruncated(client.queryStateKey, append([]byte{6}, tx.Nonce...))
+	case obc.Transaction_CHAINCODE_INVOKE:
+		stateKey = make([]byte, 0)
+	}
+
+	payloadKey, err := primitives.GenAESKey()
+	if err != nil {
+		client.error("Failed creating payload key: [%s]", err)
+
+		return err
+	}
+
+	// Encrypt message to the validators
+	cipher, err := client.eciesSPI.NewAsymmetricCipherFromPublicKey(client.chainPublicKey)
+	if err != nil {
+		client.error("Failed creating new encryption scheme: [%s]", err)
+
+		return err
+	}
+
+	msgToValidators, err := asn1.Marshal(chainCodeValidatorMessage1_3{stateKey, payloadKey})
+	if err != nil {
+		client.error("Failed preparing message to the validators: [%s]", err)
+
+		return err
+	}
+
+	encMsgToValidators, err := cipher.Process(msgToValidators)
+	if err != nil {
+		client.error("Failed encrypting message to the validators: [%s]", err)
+
+		return err
+	}
+	tx.ToValidators = encMsgToValidators
+
+	// Encrypt the rest of the fields
+
+	// Encrypt chaincodeID
+	encryptedChaincodeID, err := primitives.GCMEncrypt(payloadKey, tx.ChaincodeID, confidentialityAD1_3(tx.Nonce, 1))
+	if err != nil {
+		client.error("Failed encrypting chaincodeID: [%s]", err)
+
+		return err
+	}
+	tx.ChaincodeID = encryptedChaincodeID
+
+	// Encrypt payload
+	encryptedPayload, err := primitives.GCMEncrypt(payloadKey, tx.Payload, confidentialityAD1_3(tx.Nonce, 2))
+	if err != nil {
+		client.error("Failed encrypting payload: [%s]", err)
+
+		return err
+	}
+	tx.Payload = encryptedPayload
+
+	// Encrypt metadata
+	if len(tx.Metadata) != 0 {
+		encryptedMetadata, err := primitives.GCMEncrypt(payloadKey, tx.Metadata, confidentialityAD1_3(tx.Nonce, 3))
+		if err != nil {
+			client.error("Failed encrypting metadata: [%s]", err)
+
+			return err
+		}
+		tx.Metadata = encryptedMetadata
+	}
+
+	return nil
+}
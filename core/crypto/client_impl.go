@@ -19,7 +19,9 @@ package crypto
 import (
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/hyperledger/fabric/core/crypto/utils"
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
 	obc "github.com/hyperledger/fabric/protos"
+	"golang.org/x/net/context"
 )
 
 type clientImpl struct {
@@ -33,7 +35,14 @@ type clientImpl struct {
 
 	// TCA KDFKey
 	tCertOwnerKDFKey []byte
-	tCertPool        tCertPool
+	tCertPool        TCertPool
+
+	// Serial numbers of the revoked TCerts
+	revokedTCerts tCertRevocationList
+
+	// Compaction of the used TCerts, stopped by closing compaction
+	compaction     chan struct{}
+	compactionDone chan struct{}
 }
 
 // NewChaincodeDeployTransaction is used to deploy chaincode.
@@ -54,15 +63,35 @@ func (client *clientImpl) NewChaincodeDeployTransaction(chaincodeDeploymentSpec
 	return client.newChaincodeDeployUsingTCert(chaincodeDeploymentSpec, uuid, tCert, nil)
 }
 
-// GetNextTCert Gets next available (not yet used) transaction certificate.
-func (client *clientImpl) GetNextTCert() (tCert, error) {
+// GetNextTCert Gets next available (not yet used) transaction certificate
+// carrying the passed attributes.
+func (client *clientImpl) GetNextTCert(attributes ...string) (TCert, error) {
 	// Verify that the client is initialized
 	if !client.isInitialized {
 		return nil, utils.ErrNotInitialized
 	}
 
 	// Get next available (not yet used) transaction certificate
-	tCert, err := client.tCertPool.GetNextTCert()
+	tCert, err := client.tCertPool.GetNextTCert(attributes...)
+	if err != nil {
+		client.error("Failed getting next transaction certificate [%s].", err.Error())
+		return nil, err
+	}
+
+	return tCert, err
+}
+
+// GetNextTCertContext gets next available (not yet used) transaction certificate
+// carrying the passed attributes. It gives up as soon as ctx is cancelled or its
+// deadline expires.
+func (client *clientImpl) GetNextTCertContext(ctx context.Context, attributes ...string) (TCert, error) {
+	// Verify that the client is initialized
+	if !client.isInitialized {
+		return nil, utils.ErrNotInitialized
+	}
+
+	// Get next available (not yet used) transaction certificate
+	tCert, err := client.tCertPool.GetNextTCertContext(ctx, attributes...)
 	if err != nil {
 		client.error("Failed getting next transaction certificate [%s].", err.Error())
 		return nil, err
@@ -71,6 +100,100 @@ func (client *clientImpl) GetNextTCert() (tCert, error) {
 	return tCert, err
 }
 
+// RotateEnrollmentKey replaces the enrollment key pair of the client
+// with a new one certified by the ECA
+func (client *clientImpl) RotateEnrollmentKey() error {
+	// Verify that the client is initialized
+	if !client.isInitialized {
+		return utils.ErrNotInitialized
+	}
+
+	return client.rotateEnrollmentKey()
+}
+
+// RegisterUsers registers users in bulk with the ECA on behalf of the
+// client, which must be an auditor
+func (client *clientImpl) RegisterUsers(users []*membersrvc.RegisterUserReq) ([]*membersrvc.RegisterUserResult, error) {
+	// Verify that the client is initialized
+	if !client.isInitialized {
+		return nil, utils.ErrNotInitialized
+	}
+
+	return client.registerUsers(users)
+}
+
+// RotateKeyStorePassphrase seals the keystore of the client
+// under a key derived from the new passphrase pwd
+func (client *clientImpl) RotateKeyStorePassphrase(pwd []byte) error {
+	// Verify that the client is initialized
+	if !client.isInitialized {
+		return utils.ErrNotInitialized
+	}
+
+	return client.ks.rotatePassphrase(pwd)
+}
+
+// GetTCertPoolHealthStatus returns the outcome of the health checks
+// of the TCert pool
+func (client *clientImpl) GetTCertPoolHealthStatus() TCertPoolHealthStatus {
+	return client.tCertPool.HealthStatus()
+}
+
+// GetNextTCertFor gets next available (not yet used) transaction certificate
+// to transact with the chaincode chaincodeID. The TCert carries the attributes
+// configured for the chaincode under security.tcert.chaincodes, or all the
+// attributes configured for the client if the chaincode has none.
+func (client *clientImpl) GetNextTCertFor(chaincodeID string) (TCert, error) {
+	pool, ok := client.conf.getTCertChaincodePool(chaincodeID)
+	if !ok {
+		client.debug("No TCert pool configured for chaincode [%s]. Using the default one.", chaincodeID)
+
+		return client.GetNextTCert()
+	}
+
+	return client.GetNextTCert(pool.attributes...)
+}
+
+// GetNextTCertAsync gets next available (not yet used) transaction certificate
+// carrying the passed attributes without blocking. The outcome is delivered
+// on the returned channel.
+func (client *clientImpl) GetNextTCertAsync(ctx context.Context, attributes ...string) <-chan TCertResult {
+	result := make(chan TCertResult, 1)
+
+	go func() {
+		tCert, err := client.GetNextTCertContext(ctx, attributes...)
+		result <- TCertResult{tCert, err}
+	}()
+
+	return result
+}
+
+// Prefetch warms up the TCert pool with at least n transaction certificates
+// carrying the passed attributes.
+func (client *clientImpl) Prefetch(n int, attributes ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), tCertPoolWaitRetries*tCertPoolWaitPeriod)
+	defer cancel()
+
+	return client.PrefetchContext(ctx, n, attributes...)
+}
+
+// PrefetchContext warms up the TCert pool with at least n transaction certificates
+// carrying the passed attributes. It gives up as soon as ctx is cancelled or its
+// deadline expires.
+func (client *clientImpl) PrefetchContext(ctx context.Context, n int, attributes ...string) error {
+	// Verify that the client is initialized
+	if !client.isInitialized {
+		return utils.ErrNotInitialized
+	}
+
+	if err := client.tCertPool.Prefetch(ctx, n, attributes...); err != nil {
+		client.error("Failed prefetching [%d] transaction certificates [%s].", n, err.Error())
+		return err
+	}
+
+	return nil
+}
+
 // NewChaincodeInvokeTransaction is used to invoke chaincode's functions.
 func (client *clientImpl) NewChaincodeExecute(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string) (*obc.Transaction, error) {
 	// Verify that the client is initialized
@@ -126,14 +249,15 @@ func (client *clientImpl) GetEnrollmentCertificateHandler() (CertificateHandler,
 }
 
 // GetTCertHandlerNext returns a CertificateHandler whose certificate is the next available TCert
-func (client *clientImpl) GetTCertificateHandlerNext() (CertificateHandler, error) {
+// carrying the passed attributes
+func (client *clientImpl) GetTCertificateHandlerNext(attributes ...string) (CertificateHandler, error) {
 	// Verify that the client is initialized
 	if !client.isInitialized {
 		return nil, utils.ErrNotInitialized
 	}
 
 	// Get next TCert
-	tCert, err := client.tCertPool.GetNextTCert()
+	tCert, err := client.tCertPool.GetNextTCert(attributes...)
 	if err != nil {
 		client.error("Failed getting next transaction certificate [%s].", err.Error())
 		return nil, err
@@ -233,10 +357,14 @@ func (client *clientImpl) init(id string, pwd []byte) error {
 	// initialized
 	client.isInitialized = true
 
+	client.startUsedTCertsCompaction()
+
 	return nil
 }
 
 func (client *clientImpl) close() (err error) {
+	client.stopUsedTCertsCompaction()
+
 	if client.tCertPool != nil {
 		if err = client.tCertPool.Stop(); err != nil {
 			client.debug("Failed closing TCertPool [%s]", err)
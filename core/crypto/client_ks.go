@@ -17,173 +17,484 @@ limitations under the License.
 package crypto
 
 import (
-	"database/sql"
+	"bytes"
+	"encoding/asn1"
+	"errors"
 	"os"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+)
+
+const (
+	// unusedTCertsVersion is the version of the format unused TCerts are
+	// stored with. Version 0 is the legacy format, without integrity protection.
+	// Version 1 adds to every TCert a MAC, keyed by a key derived from the
+	// TCertOwnerKDFKey, over the version and the TCert.
+	// Version 2 encrypts, in addition, every TCert with AES-GCM under a data
+	// key. The data key is stored in the table TCertsKeys wrapped under a
+	// master key kept out of the database, so that the database alone does
+	// not yield the TCerts.
+	unusedTCertsVersion = 2
 )
 
+// unusedTCertsKeys are the keys protecting the unused TCerts
+type unusedTCertsKeys struct {
+	mac  []byte
+	data []byte
+}
+
+// reservedTCert is a TCert of the reserve along with the TCertIndex its
+// keys derive from. It is stored encrypted with AES-GCM under the data key
+// protecting the unused TCerts.
+type reservedTCert struct {
+	Cert  []byte
+	Index []byte
+}
+
 func (client *clientImpl) initKeyStore() error {
 	// Create TCerts directory
 	os.MkdirAll(client.conf.getTCertsPath(), 0755)
 
 	// create tables
-	client.debug("Create Table if not exists [TCert] at [%s].", client.conf.getKeyStorePath())
-	if _, err := client.ks.sqlDB.Exec("CREATE TABLE IF NOT EXISTS TCerts (id INTEGER, cert BLOB, PRIMARY KEY (id))"); err != nil {
-		client.debug("Failed creating table [%s].", err)
+	return client.ks.backend.initClient()
+}
+
+// storeNonce records a nonce issued by the client. It fails with
+// ErrNonceReplayed if the nonce was already issued.
+func (ks *keyStore) storeNonce(nonce []byte) error {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	return ks.backend.insertClientNonce(nonce)
+}
+
+func (ks *keyStore) storeUsedTCert(tCert TCert) (err error) {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	ks.node.debug("Storing used TCert...")
+
+	if err = ks.backend.insertUsedTCert(tCert.GetCertificate().Raw, time.Now()); err != nil {
+		return
+	}
+
+	ks.node.debug("Storing used TCert...done!")
+
+	return
+}
+
+// deleteUsedTCerts removes the used TCerts stored before the passed time,
+// unless it is zero, and, if keep is positive, the oldest ones beyond the
+// keep most recent
+func (ks *keyStore) deleteUsedTCerts(before time.Time, keep int) (int64, error) {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	return ks.backend.deleteUsedTCerts(before, keep)
+}
+
+// getUnusedTCertsKeys returns the keys protecting the unused TCerts
+func (client *clientImpl) getUnusedTCertsKeys() (*unusedTCertsKeys, error) {
+	masterKey, err := client.getTCertsMasterKey()
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := client.ks.loadTCertsDataKey(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &unusedTCertsKeys{
+		mac:  primitives.HMAC(client.tCertOwnerKDFKey, []byte{3}),
+		data: dataKey,
+	}, nil
+}
+
+// getTCertsMasterKey returns the master key, generating it the first time
+func (client *clientImpl) getTCertsMasterKey() ([]byte, error) {
+	alias := client.conf.getTCertsMasterKeyFilename()
+	if client.ks.isAliasSet(alias) {
+		return client.ks.loadKey(alias)
+	}
+
+	client.debug("Generating TCerts master key...")
+
+	masterKey, err := primitives.GenAESKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := client.ks.storeKey(alias, masterKey); err != nil {
+		return nil, err
+	}
+
+	return masterKey, nil
+}
+
+// RotateTCertsKey replaces the master key protecting the unused TCerts.
+// The data key is wrapped under the new master key, the TCerts are left as they are.
+func (client *clientImpl) RotateTCertsKey() error {
+	client.debug("Rotating TCerts master key...")
+
+	oldMasterKey, err := client.getTCertsMasterKey()
+	if err != nil {
+		return err
+	}
+	newMasterKey, err := primitives.GenAESKey()
+	if err != nil {
+		return err
+	}
+
+	// Store the new master key aside until the data key is wrapped under it
+	alias := client.conf.getTCertsMasterKeyFilename()
+	if err := client.ks.storeKey(alias+".next", newMasterKey); err != nil {
+		return err
+	}
+	if err := client.ks.rewrapTCertsDataKey(oldMasterKey, newMasterKey); err != nil {
+		os.Remove(client.conf.getPathForAlias(alias + ".next"))
+
 		return err
 	}
+	if err := os.Rename(client.conf.getPathForAlias(alias+".next"), client.conf.getPathForAlias(alias)); err != nil {
+		client.error("Failed replacing TCerts master key [%s].", err)
 
-	client.debug("Create Table if not exists [UsedTCert] at [%s].", client.conf.getKeyStorePath())
-	if _, err := client.ks.sqlDB.Exec("CREATE TABLE IF NOT EXISTS UsedTCert (id INTEGER, cert BLOB, PRIMARY KEY (id))"); err != nil {
-		client.debug("Failed creating table [%s].", err)
 		return err
 	}
 
+	client.debug("Rotating TCerts master key...done!")
+
 	return nil
 }
 
-func (ks *keyStore) storeUsedTCert(tCert tCert) (err error) {
+func (client *clientImpl) storeUnusedTCerts(tCerts []TCert) error {
+	keys, err := client.getUnusedTCertsKeys()
+	if err != nil {
+		client.error("Failed getting unused TCerts keys [%s].", err)
+
+		return err
+	}
+
+	return client.ks.storeUnusedTCerts(tCerts, keys)
+}
+
+func (client *clientImpl) loadUnusedTCerts() ([][]byte, error) {
+	keys, err := client.getUnusedTCertsKeys()
+	if err != nil {
+		client.error("Failed getting unused TCerts keys [%s].", err)
+
+		return nil, err
+	}
+
+	return client.ks.loadUnusedTCerts(keys)
+}
+
+// loadTCertsDataKey returns the data key unwrapped with masterKey,
+// generating it the first time
+func (ks *keyStore) loadTCertsDataKey(masterKey []byte) ([]byte, error) {
 	ks.m.Lock()
 	defer ks.m.Unlock()
 
-	ks.node.debug("Storing used TCert...")
-
-	// Open transaction
-	tx, err := ks.sqlDB.Begin()
+	wrapped, err := ks.backend.selectTCertsDataKey()
 	if err != nil {
-		ks.node.error("Failed beginning transaction [%s].", err)
+		return nil, err
+	}
+	if wrapped != nil {
+		return primitives.GCMDecrypt(masterKey, wrapped, nil)
+	}
 
-		return
+	ks.node.debug("Generating TCerts data key...")
+
+	dataKey, err := primitives.GenAESKey()
+	if err != nil {
+		return nil, err
+	}
+	if wrapped, err = primitives.GCMEncrypt(masterKey, dataKey, nil); err != nil {
+		return nil, err
+	}
+	if err = ks.backend.storeTCertsDataKey(wrapped); err != nil {
+		return nil, err
 	}
 
-	// Insert into UsedTCert
-	if _, err = tx.Exec("INSERT INTO UsedTCert (cert) VALUES (?)", tCert.GetCertificate().Raw); err != nil {
-		ks.node.error("Failed inserting TCert to UsedTCert: [%s].", err)
+	return dataKey, nil
+}
+
+// rewrapTCertsDataKey wraps the data key under newMasterKey
+func (ks *keyStore) rewrapTCertsDataKey(oldMasterKey, newMasterKey []byte) error {
+	dataKey, err := ks.loadTCertsDataKey(oldMasterKey)
+	if err != nil {
+		return err
+	}
 
-		tx.Rollback()
+	ks.m.Lock()
+	defer ks.m.Unlock()
 
-		return
+	wrapped, err := primitives.GCMEncrypt(newMasterKey, dataKey, nil)
+	if err != nil {
+		return err
 	}
 
-	// Finalize
-	err = tx.Commit()
+	return ks.backend.storeTCertsDataKey(wrapped)
+}
+
+// unusedTCertMAC returns the MAC of tCertDER stored with version
+func unusedTCertMAC(key []byte, version int, tCertDER []byte) []byte {
+	return primitives.HMAC(key, append([]byte{byte(version)}, tCertDER...))
+}
+
+// sealUnusedTCert returns the form tCertDER is stored in, along with its MAC
+func sealUnusedTCert(keys *unusedTCertsKeys, tCertDER []byte) ([]byte, []byte, error) {
+	ct, err := primitives.GCMEncrypt(keys.data, tCertDER, []byte{unusedTCertsVersion})
 	if err != nil {
-		ks.node.error("Failed commiting [%s].", err)
-		tx.Rollback()
+		return nil, nil, err
+	}
 
-		return
+	return ct, unusedTCertMAC(keys.mac, unusedTCertsVersion, tCertDER), nil
+}
+
+// openUnusedTCert returns the TCert stored as cert with version and mac,
+// once verified
+func openUnusedTCert(keys *unusedTCertsKeys, version int, cert, mac []byte) ([]byte, error) {
+	switch {
+	case version == 0:
+		return cert, nil
+	case version > unusedTCertsVersion:
+		return nil, errors.New("Unknown version")
 	}
 
-	ks.node.debug("Storing used TCert...done!")
+	tCertDER := cert
+	if version >= 2 {
+		var err error
+		if tCertDER, err = primitives.GCMDecrypt(keys.data, cert, []byte{byte(version)}); err != nil {
+			return nil, err
+		}
+	}
+	if !bytes.Equal(mac, unusedTCertMAC(keys.mac, version, tCertDER)) {
+		return nil, errors.New("Invalid MAC")
+	}
 
-	//name, err := utils.TempFile(ks.conf.getTCertsPath(), "tcert_")
-	//if err != nil {
-	//	ks.node.error("Failed storing TCert: [%s]", err)
-	//	return
-	//}
-	//
-	//err = ioutil.WriteFile(name, tCert.GetCertificate().Raw, 0700)
-	//if err != nil {
-	//	ks.node.error("Failed storing TCert: [%s]", err)
-	//	return
-	//}
+	return tCertDER, nil
+}
 
-	return
+func (ks *keyStore) storeUnusedTCerts(tCerts []TCert, keys *unusedTCertsKeys) error {
+	tCertDERs := make([][]byte, 0, len(tCerts))
+	for _, tCert := range tCerts {
+		tCertDERs = append(tCertDERs, tCert.GetCertificate().Raw)
+	}
+
+	return ks.storeUnusedTCertDERs(tCertDERs, keys)
 }
 
-func (ks *keyStore) storeUnusedTCerts(tCerts []tCert) (err error) {
+func (ks *keyStore) storeUnusedTCertDERs(tCertDERs [][]byte, keys *unusedTCertsKeys) (err error) {
 	ks.node.debug("Storing unused TCerts...")
 
-	if len(tCerts) == 0 {
+	if len(tCertDERs) == 0 {
 		ks.node.debug("Empty list of unused TCerts.")
 		return
 	}
 
-	// Open transaction
-	tx, err := ks.sqlDB.Begin()
-	if err != nil {
-		ks.node.error("Failed beginning transaction [%s].", err)
+	entries := make([]*unusedTCertEntry, 0, len(tCertDERs))
+	for _, tCertDER := range tCertDERs {
+		var cert, mac []byte
+		if cert, mac, err = sealUnusedTCert(keys, tCertDER); err != nil {
+			ks.node.error("Failed encrypting unused TCert: [%s].", err)
 
+			return
+		}
+		entries = append(entries, &unusedTCertEntry{cert: cert, version: unusedTCertsVersion, mac: mac})
+	}
+
+	if err = ks.backend.insertUnusedTCerts(entries); err != nil {
 		return
 	}
 
-	for _, tCert := range tCerts {
-		// Insert into UsedTCert
-		if _, err = tx.Exec("INSERT INTO TCerts (cert) VALUES (?)", tCert.GetCertificate().Raw); err != nil {
-			ks.node.error("Failed inserting unused TCert to TCerts: [%s].", err)
+	ks.node.debug("Storing unused TCerts...done!")
 
-			tx.Rollback()
+	return
+}
 
-			return
+// deleteUnusedTCerts removes the unused TCerts matching match
+func (ks *keyStore) deleteUnusedTCerts(keys *unusedTCertsKeys, match func(tCertDER []byte) bool) error {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	entries, err := ks.backend.selectUnusedTCerts()
+	if err != nil {
+		return err
+	}
+
+	ids := []int64{}
+	for _, entry := range entries {
+		tCertDER, err := openUnusedTCert(keys, entry.version, entry.cert, entry.mac)
+		if err != nil {
+			continue
+		}
+		if match(tCertDER) {
+			ids = append(ids, entry.id)
 		}
 	}
 
-	// Finalize
-	err = tx.Commit()
-	if err != nil {
-		ks.node.error("Failed commiting [%s].", err)
-		tx.Rollback()
+	return ks.backend.deleteUnusedTCerts(ids)
+}
 
-		return
+// selectUnusedTCerts returns the unused TCerts, leaving them in
+// the keystore. TCerts that do not verify under keys are skipped.
+func (ks *keyStore) selectUnusedTCerts(keys *unusedTCertsKeys) ([][]byte, error) {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	entries, err := ks.backend.selectUnusedTCerts()
+	if err != nil {
+		return nil, err
 	}
 
-	ks.node.debug("Storing unused TCerts...done!")
+	tCertDERs := [][]byte{}
+	for _, entry := range entries {
+		tCertDER, err := openUnusedTCert(keys, entry.version, entry.cert, entry.mac)
+		if err != nil {
+			ks.node.error("Unused TCert [% x] cannot be opened: [%s]. Skipping it.", entry.cert, err)
 
-	return
+			continue
+		}
+		tCertDERs = append(tCertDERs, tCertDER)
+	}
+
+	return tCertDERs, nil
 }
 
-func (ks *keyStore) loadUnusedTCert() ([]byte, error) {
-	// Get the first row available
-	var id int
-	var cert []byte
-	row := ks.sqlDB.QueryRow("SELECT id, cert FROM TCerts")
-	err := row.Scan(&id, &cert)
+// storeReservedTCerts stores TCerts of the reserve carrying the attributes key
+func (ks *keyStore) storeReservedTCerts(attributes string, reserved []*reservedTCert, keys *unusedTCertsKeys) error {
+	ks.node.debug("Storing [%d] reserved TCerts...", len(reserved))
 
-	if err == sql.ErrNoRows {
-		return nil, nil
-	} else if err != nil {
-		ks.node.error("Error during select [%s].", err.Error())
+	materials := make([][]byte, 0, len(reserved))
+	for _, tCert := range reserved {
+		raw, err := asn1.Marshal(*tCert)
+		if err != nil {
+			return err
+		}
+		material, err := primitives.GCMEncrypt(keys.data, raw, []byte(attributes))
+		if err != nil {
+			ks.node.error("Failed encrypting reserved TCert: [%s].", err)
+
+			return err
+		}
+		materials = append(materials, material)
+	}
+
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	if err := ks.backend.insertReservedTCerts(attributes, materials); err != nil {
+		return err
+	}
+
+	ks.node.debug("Storing [%d] reserved TCerts...done!", len(reserved))
 
+	return nil
+}
+
+// takeReservedTCerts loads and removes up to num TCerts of the reserve
+// carrying the attributes key. TCerts that do not open under keys are
+// discarded.
+func (ks *keyStore) takeReservedTCerts(attributes string, num int, keys *unusedTCertsKeys) ([]*reservedTCert, error) {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	entries, err := ks.backend.selectReservedTCerts(attributes, num)
+	if err != nil {
 		return nil, err
 	}
 
-	// Remove from TCert
-	if _, err := ks.sqlDB.Exec("DELETE FROM TCerts WHERE id = ?", id); err != nil {
-		ks.node.error("Failed removing row [%d] from TCert: [%s].", id, err.Error())
+	reserved := []*reservedTCert{}
+	ids := []int64{}
+	for _, entry := range entries {
+		ids = append(ids, entry.id)
+
+		raw, err := primitives.GCMDecrypt(keys.data, entry.material, []byte(attributes))
+		if err != nil {
+			ks.node.error("Reserved TCert [%d] has been tampered with: [%s]. Discarding it.", entry.id, err)
+
+			continue
+		}
+		tCert := &reservedTCert{}
+		if _, err := asn1.Unmarshal(raw, tCert); err != nil {
+			ks.node.error("Reserved TCert [%d] cannot be parsed: [%s]. Discarding it.", entry.id, err)
+
+			continue
+		}
+		reserved = append(reserved, tCert)
+	}
+
+	if err = ks.backend.deleteReservedTCerts(ids); err != nil {
+		ks.node.error("Failed cleaning up reserved TCert entries: [%s].", err)
 
 		return nil, err
 	}
 
-	return cert, nil
+	return reserved, nil
 }
 
-func (ks *keyStore) loadUnusedTCerts() ([][]byte, error) {
-	// Get unused TCerts
-	rows, err := ks.sqlDB.Query("SELECT cert FROM TCerts")
-	if err == sql.ErrNoRows {
+// countReservedTCerts returns how many TCerts of the reserve carry the
+// attributes key
+func (ks *keyStore) countReservedTCerts(attributes string) (int, error) {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	return ks.backend.countReservedTCerts(attributes)
+}
+
+func (ks *keyStore) loadUnusedTCert() ([]byte, error) {
+	// Get the first entry available
+	entries, err := ks.backend.selectUnusedTCerts()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
 		return nil, nil
-	} else if err != nil {
-		ks.node.error("Error during select [%s].", err)
+	}
+
+	// Remove from TCert
+	if err := ks.backend.deleteUnusedTCerts([]int64{entries[0].id}); err != nil {
+		return nil, err
+	}
+
+	return entries[0].cert, nil
+}
 
+// loadUnusedTCerts loads and removes the unused TCerts. TCerts whose MAC
+// does not verify under key are discarded. TCerts stored in a format
+// newer than this release understands are left untouched.
+func (ks *keyStore) loadUnusedTCerts(keys *unusedTCertsKeys) ([][]byte, error) {
+	// Get unused TCerts
+	entries, err := ks.backend.selectUnusedTCerts()
+	if err != nil {
 		return nil, err
 	}
 
 	tCertDERs := [][]byte{}
-	for {
-		if rows.Next() {
-			var tCertDER []byte
-			if err := rows.Scan(&tCertDER); err != nil {
-				ks.node.error("Error during scan [%s].", err)
-
-				continue
-			}
-			tCertDERs = append(tCertDERs, tCertDER)
-		} else {
-			break
+	ids := []int64{}
+	for _, entry := range entries {
+		switch {
+		case entry.version < unusedTCertsVersion:
+			ks.node.debug("Migrating unused TCert from version [%d].", entry.version)
+		case entry.version > unusedTCertsVersion:
+			ks.node.error("Unused TCert stored with unknown version [%d]. Skipping it.", entry.version)
+
+			continue
+		}
+		// Entries understood are deleted, whether they verify or not
+		ids = append(ids, entry.id)
+
+		tCertDER, err := openUnusedTCert(keys, entry.version, entry.cert, entry.mac)
+		if err != nil {
+			ks.node.error("Unused TCert [% x] has been tampered with: [%s]. Discarding it.", entry.cert, err)
+
+			continue
 		}
+		tCertDERs = append(tCertDERs, tCertDER)
 	}
 
-	// Delete all entries
-	if _, err = ks.sqlDB.Exec("DELETE FROM TCerts"); err != nil {
+	if err = ks.backend.deleteUnusedTCerts(ids); err != nil {
 		ks.node.error("Failed cleaning up unused TCert entries: [%s].", err)
 
 		return nil, err
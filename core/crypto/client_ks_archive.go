@@ -0,0 +1,255 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// keyStoreArchiveVersion is the version of the format keystores
+	// are exported with
+	keyStoreArchiveVersion = 1
+
+	// keyStoreArchivePEMType is the PEM type of exported keystores
+	keyStoreArchivePEMType = "KEYSTORE ARCHIVE"
+)
+
+// keyStoreArchive is an exported keystore. Its content is encrypted with
+// AES-GCM under a key derived by scrypt from the archive passphrase.
+type keyStoreArchive struct {
+	Version int
+	Salt    []byte
+	N, R, P int
+	Content []byte
+}
+
+// keyStoreArchiveContent is the content of an exported keystore: its
+// entries in clear and the unused TCerts
+type keyStoreArchiveContent struct {
+	Entries      []keyStoreArchiveEntry
+	UnusedTCerts [][]byte
+}
+
+type keyStoreArchiveEntry struct {
+	Alias string
+	Value []byte
+}
+
+// openKeyStore opens the keystore of the client, without initializing its
+// crypto engine. It fails unless the client is registered as expected.
+func (client *clientImpl) openKeyStore(name string, pwd []byte, registered bool) error {
+	client.eType = NodeClient
+	if err := client.initConfiguration(name); err != nil {
+		return err
+	}
+	switch {
+	case registered && !client.isRegistered():
+		return utils.ErrRegistrationRequired
+	case !registered && client.isRegistered():
+		return utils.ErrAlreadyRegistered
+	}
+	if err := client.nodeImpl.initKeyStore(pwd); err != nil {
+		return err
+	}
+
+	return client.initKeyStore()
+}
+
+// isExportedAlias returns true if the entry alias is moved along with the
+// keystore. The TCerts master key and the passphrase key parameters
+// belong to the keystore they are found in.
+func (client *clientImpl) isExportedAlias(alias string) bool {
+	return alias != client.conf.getTCertsMasterKeyFilename() &&
+		alias != client.conf.getKeyStoreKDFParamsFilename() &&
+		!isPendingAlias(alias)
+}
+
+// exportKeyStore returns the keystore of the client, encrypted under passphrase
+func (client *clientImpl) exportKeyStore(passphrase []byte) ([]byte, error) {
+	client.debug("Exporting keystore...")
+
+	files, err := ioutil.ReadDir(client.conf.getRawsPath())
+	if err != nil {
+		return nil, err
+	}
+
+	content := keyStoreArchiveContent{}
+	for _, file := range files {
+		alias := file.Name()
+		if file.IsDir() || !client.isExportedAlias(alias) {
+			continue
+		}
+
+		raw, err := ioutil.ReadFile(client.conf.getPathForAlias(alias))
+		if err != nil {
+			return nil, err
+		}
+		clear, _, err := client.ks.openEntry(alias, raw)
+		if err != nil {
+			return nil, err
+		}
+		content.Entries = append(content.Entries, keyStoreArchiveEntry{alias, clear})
+	}
+
+	if err := client.loadTCertOwnerKDFKey(); err != nil {
+		return nil, err
+	}
+	if client.tCertOwnerKDFKey != nil {
+		keys, err := client.getUnusedTCertsKeys()
+		if err != nil {
+			return nil, err
+		}
+		if content.UnusedTCerts, err = client.ks.selectUnusedTCerts(keys); err != nil {
+			return nil, err
+		}
+	}
+
+	raw, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := keyStoreArchive{Version: keyStoreArchiveVersion}
+	if archive.Salt, err = primitives.GetRandomBytes(32); err != nil {
+		return nil, err
+	}
+	archive.N, archive.R, archive.P = client.conf.getKeyStoreScryptParams()
+	key, err := scrypt.Key(passphrase, archive.Salt, archive.N, archive.R, archive.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	if archive.Content, err = primitives.GCMEncrypt(key, raw, []byte{keyStoreArchiveVersion}); err != nil {
+		return nil, err
+	}
+
+	der, err := asn1.Marshal(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	client.debug("Exporting keystore...done! [%d] entries, [%d] unused TCerts.", len(content.Entries), len(content.UnusedTCerts))
+
+	return pem.EncodeToMemory(&pem.Block{Type: keyStoreArchivePEMType, Bytes: der}), nil
+}
+
+// importKeyStore fills the keystore of the client with
+// the content of archive, encrypted under passphrase
+func (client *clientImpl) importKeyStore(archive, passphrase []byte) error {
+	client.debug("Importing keystore...")
+
+	content, err := openKeyStoreArchive(archive, passphrase)
+	if err != nil {
+		return err
+	}
+
+	// The enrollment ID marks the client as registered; write it last
+	var enrollmentID *keyStoreArchiveEntry
+	for i, entry := range content.Entries {
+		if entry.Alias == client.conf.getEnrollmentIDFilename() {
+			enrollmentID = &content.Entries[i]
+			continue
+		}
+		if err := client.importEntry(entry); err != nil {
+			return err
+		}
+	}
+	if enrollmentID == nil {
+		return errors.New("Invalid keystore archive: enrollment ID missing")
+	}
+
+	if err := client.loadTCertOwnerKDFKey(); err != nil {
+		return err
+	}
+	if len(content.UnusedTCerts) != 0 {
+		if client.tCertOwnerKDFKey == nil {
+			return errors.New("Invalid keystore archive: TCertOwnerKDFKey missing")
+		}
+
+		keys, err := client.getUnusedTCertsKeys()
+		if err != nil {
+			return err
+		}
+		if err := client.ks.storeUnusedTCertDERs(content.UnusedTCerts, keys); err != nil {
+			return err
+		}
+	}
+
+	if err := client.importEntry(*enrollmentID); err != nil {
+		return err
+	}
+
+	client.debug("Importing keystore...done! [%d] entries, [%d] unused TCerts.", len(content.Entries), len(content.UnusedTCerts))
+
+	return nil
+}
+
+// importEntry stores entry, protected as the keystore requires
+func (client *clientImpl) importEntry(entry keyStoreArchiveEntry) error {
+	if !client.isExportedAlias(entry.Alias) || entry.Alias != filepath.Base(entry.Alias) {
+		return errors.New("Invalid keystore archive: unexpected entry [" + entry.Alias + "]")
+	}
+
+	raw, err := client.ks.protectEntry(entry.Alias, entry.Value)
+	if err != nil {
+		client.error("Failed protecting keystore entry [%s]: [%s].", entry.Alias, err)
+
+		return err
+	}
+
+	return ioutil.WriteFile(client.conf.getPathForAlias(entry.Alias), raw, 0700)
+}
+
+// openKeyStoreArchive returns the content of archive, encrypted under passphrase
+func openKeyStoreArchive(archive, passphrase []byte) (*keyStoreArchiveContent, error) {
+	block, _ := pem.Decode(archive)
+	if block == nil || block.Type != keyStoreArchivePEMType {
+		return nil, errors.New("Invalid keystore archive")
+	}
+
+	sealed := new(keyStoreArchive)
+	if _, err := asn1.Unmarshal(block.Bytes, sealed); err != nil {
+		return nil, err
+	}
+	if sealed.Version != keyStoreArchiveVersion {
+		return nil, errors.New("Unknown keystore archive version")
+	}
+
+	key, err := scrypt.Key(passphrase, sealed.Salt, sealed.N, sealed.R, sealed.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := primitives.GCMDecrypt(key, sealed.Content, []byte{byte(sealed.Version)})
+	if err != nil {
+		return nil, utils.ErrInvalidPassphrase
+	}
+
+	content := new(keyStoreArchiveContent)
+	if _, err := asn1.Unmarshal(raw, content); err != nil {
+		return nil, err
+	}
+
+	return content, nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// PurgeUsedTCerts removes from the keystore the used TCerts stored
+// before the passed time and returns how many were removed
+func (client *clientImpl) PurgeUsedTCerts(before time.Time) (int, error) {
+	// Verify that the client is initialized
+	if !client.isInitialized {
+		return 0, utils.ErrNotInitialized
+	}
+	if before.IsZero() {
+		return 0, utils.ErrNilArgument
+	}
+
+	client.debug("Purging used TCerts stored before [%s]...", before)
+
+	deleted, err := client.ks.deleteUsedTCerts(before, 0)
+	if err != nil {
+		client.error("Failed purging used TCerts [%s].", err)
+
+		return int(deleted), err
+	}
+
+	client.debug("Purging used TCerts stored before [%s]...done! [%d] removed.", before, deleted)
+
+	return int(deleted), nil
+}
+
+// compactUsedTCerts removes the used TCerts the retention policy does not keep
+func (client *clientImpl) compactUsedTCerts() (int64, error) {
+	age, count := client.conf.getUsedTCertsRetention()
+
+	var before time.Time
+	if age > 0 {
+		before = time.Now().Add(-age)
+	}
+
+	return client.ks.deleteUsedTCerts(before, count)
+}
+
+// startUsedTCertsCompaction starts compacting the used TCerts, once at
+// start and then periodically, if a retention policy is configured
+func (client *clientImpl) startUsedTCertsCompaction() {
+	if age, count := client.conf.getUsedTCertsRetention(); age == 0 && count == 0 {
+		client.debug("No used TCerts retention policy. Used TCerts are kept.")
+
+		return
+	}
+
+	client.compaction = make(chan struct{})
+	client.compactionDone = make(chan struct{})
+	go client.usedTCertsCompactor(client.compaction, client.compactionDone)
+}
+
+// stopUsedTCertsCompaction stops the compaction and waits for it to return
+func (client *clientImpl) stopUsedTCertsCompaction() {
+	if client.compaction == nil {
+		return
+	}
+
+	close(client.compaction)
+	<-client.compactionDone
+	client.compaction = nil
+}
+
+func (client *clientImpl) usedTCertsCompactor(stop, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(client.conf.getUsedTCertsCompaction())
+	defer ticker.Stop()
+
+	for {
+		deleted, err := client.compactUsedTCerts()
+		if err != nil {
+			client.error("Failed compacting used TCerts [%s].", err)
+		} else if deleted != 0 {
+			client.debug("Compacting used TCerts...[%d] removed.", deleted)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
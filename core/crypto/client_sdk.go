@@ -0,0 +1,175 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"errors"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"github.com/spf13/viper"
+)
+
+// Logger is the logging interface of the crypto layer. A *logging.Logger
+// from github.com/op/go-logging satisfies it.
+type Logger interface {
+	Debug(format string, args ...interface{})
+	Info(format string, args ...interface{})
+	Warning(format string, args ...interface{})
+	Error(format string, args ...interface{})
+}
+
+// ClientOptions carries everything a client needs to sign and encrypt
+// transactions outside of a peer. Unlike clients obtained with InitClient,
+// a client created from options does not read the peer configuration.
+type ClientOptions struct {
+	// Name identifies the client locally. Its keys and certificates are
+	// stored under DataPath/crypto/client/<Name>.
+	Name string
+
+	// Pwd protects the keystore of the client
+	Pwd []byte
+
+	// EnrollID and EnrollPWD enroll the client with the ECA the first
+	// time it is created. They can be omitted once the client is enrolled.
+	EnrollID  string
+	EnrollPWD string
+
+	// DataPath is the root directory of the keystore
+	DataPath string
+
+	// ECAAddr, TCAAddr and TLSCAAddr are the host:port endpoints of the
+	// membership services
+	ECAAddr   string
+	TCAAddr   string
+	TLSCAAddr string
+
+	// TLSEnabled, TLSRootCertFile and TLSServerName configure the
+	// connections to the membership services
+	TLSEnabled      bool
+	TLSRootCertFile string
+	TLSServerName   string
+
+	// SecurityLevel and HashAlgorithm default to 256 and SHA3. They are
+	// set once per process, by the first client or node initialized.
+	SecurityLevel int
+	HashAlgorithm string
+
+	// Settings holds any other configuration key, named as in core.yaml
+	// (e.g. "security.tcert.batch.size")
+	Settings map[string]interface{}
+
+	// Logger receives the log of the client. The crypto logger is used
+	// when nil.
+	Logger Logger
+}
+
+func (opts *ClientOptions) source() *viper.Viper {
+	v := viper.New()
+	for key, value := range opts.Settings {
+		v.Set(key, value)
+	}
+
+	v.Set("peer.fileSystemPath", opts.DataPath)
+	v.Set("peer.pki.eca.paddr", opts.ECAAddr)
+	v.Set("peer.pki.tca.paddr", opts.TCAAddr)
+	v.Set("peer.pki.tlsca.paddr", opts.TLSCAAddr)
+	v.Set("peer.pki.tls.enabled", opts.TLSEnabled)
+	v.Set("peer.pki.tls.rootcert.file", opts.TLSRootCertFile)
+	if opts.TLSServerName != "" {
+		v.Set("peer.pki.tls.serverhostoverride", opts.TLSServerName)
+	}
+	if opts.SecurityLevel != 0 {
+		v.Set("security.level", opts.SecurityLevel)
+	}
+	if opts.HashAlgorithm != "" {
+		v.Set("security.hashAlgorithm", opts.HashAlgorithm)
+	}
+
+	return v
+}
+
+// NewClient creates a client from explicit options, enrolling it first if
+// EnrollID is given and the client is not registered yet. The client is
+// released with CloseClient.
+func NewClient(opts *ClientOptions) (Client, error) {
+	if opts == nil {
+		return nil, utils.ErrNilArgument
+	}
+	if opts.Name == "" {
+		return nil, errors.New("Client name not specified.")
+	}
+
+	log.Info("Creating client [%s] from options...", opts.Name)
+
+	node, err := clientRegistry.acquire(opts.Name, false, func() (registryNode, error) {
+		securityLevel := 256
+		if opts.SecurityLevel != 0 {
+			securityLevel = opts.SecurityLevel
+		}
+		hashAlgorithm := "SHA3"
+		if opts.HashAlgorithm != "" {
+			hashAlgorithm = opts.HashAlgorithm
+		}
+		if err := primitives.InitSecurityLevel(hashAlgorithm, securityLevel); err != nil {
+			log.Error("Failed setting security level [%s]: [%s].", opts.Name, err)
+
+			return nil, err
+		}
+
+		source := opts.source()
+
+		if opts.EnrollID != "" {
+			client := newClientFromSource(source, opts.Logger)
+			if err := client.register(opts.Name, opts.Pwd, opts.EnrollID, opts.EnrollPWD); err != nil {
+				if err != utils.ErrAlreadyRegistered {
+					log.Error("Failed registering client [%s] with name [%s] [%s].", opts.EnrollID, opts.Name, err)
+
+					return nil, err
+				}
+			}
+			if err := client.close(); err != nil {
+				// It is not necessary to report this error to the caller
+				log.Warning("Registering client [%s] with name [%s]. Failed closing [%s].", opts.EnrollID, opts.Name, err)
+			}
+		}
+
+		client := newClientFromSource(source, opts.Logger)
+		if err := client.init(opts.Name, opts.Pwd); err != nil {
+			log.Error("Failed client initialization [%s]: [%s].", opts.Name, err)
+
+			return nil, err
+		}
+
+		return client, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("Creating client [%s] from options...done!", opts.Name)
+
+	return node.(Client), nil
+}
+
+func newClientFromSource(source configSource, logger Logger) *clientImpl {
+	client := newClient()
+	client.confSource = source
+	client.logger = logger
+
+	return client
+}
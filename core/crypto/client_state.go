@@ -39,7 +39,7 @@ func (client *clientImpl) DecryptQueryResult(queryTx *obc.Transaction, ct []byte
 		queryKey = primitives.HMACAESTruncated(enrollChainKey, append([]byte{6}, queryTx.Nonce...))
 		//	client.log.Info("QUERY Decrypting with key: ", utils.EncodeBase64(queryKey))
 		break
-	case "1.2":
+	case "1.2", "1.3":
 		queryKey = primitives.HMACAESTruncated(client.queryStateKey, append([]byte{6}, queryTx.Nonce...))
 	}
 
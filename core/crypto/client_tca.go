@@ -21,13 +21,13 @@ import (
 
 	"bytes"
 	"crypto/ecdsa"
-	"crypto/hmac"
 	"encoding/asn1"
 
 	"errors"
 	"fmt"
 	"google/protobuf"
-	"math/big"
+	"io"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -36,6 +36,8 @@ import (
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/hyperledger/fabric/core/crypto/utils"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 func (client *clientImpl) initTCertEngine() (err error) {
@@ -45,20 +47,17 @@ func (client *clientImpl) initTCertEngine() (err error) {
 	}
 
 	// init TCerPool
-	client.debug("Using multithreading [%t]", client.conf.IsMultithreadingEnabled())
+	client.debug("Using TCert pool provider [%s]", client.conf.getTCertPoolProvider())
 	client.debug("TCert batch size [%d]", client.conf.getTCertBatchSize())
 
-	if client.conf.IsMultithreadingEnabled() {
-		client.tCertPool = new(tCertPoolMultithreadingImpl)
-	} else {
-		client.tCertPool = new(tCertPoolSingleThreadImpl)
-	}
-
-	if err = client.tCertPool.init(client); err != nil {
+	if client.tCertPool, err = newTCertPool(client.conf.getTCertPoolProvider(), client); err != nil {
 		client.error("Failied inizializing TCertPool: [%s]", err)
 
 		return
 	}
+	client.debug("TCert reuse policy [%s]", client.conf.getTCertReusePolicy())
+	client.tCertPool = newTCertPoolMetered(client, newTCertPoolReuse(client, client.tCertPool))
+
 	if err = client.tCertPool.Start(); err != nil {
 		client.error("Failied starting TCertPool: [%s]", err)
 
@@ -99,7 +98,7 @@ func (client *clientImpl) loadTCertOwnerKDFKey() error {
 	return nil
 }
 
-func (client *clientImpl) getTCertFromExternalDER(der []byte) (tCert, error) {
+func (client *clientImpl) getTCertFromExternalDER(der []byte) (TCert, error) {
 	// DER to x509
 	x509Cert, err := utils.DERToX509Certificate(der)
 	if err != nil {
@@ -136,7 +135,7 @@ func (client *clientImpl) getTCertFromExternalDER(der []byte) (tCert, error) {
 	//	}
 
 	// Verify certificate against root
-	if _, err := utils.CheckCertAgainRoot(x509Cert, client.tcaCertPool); err != nil {
+	if _, err := client.verifyCertificate(x509Cert, client.tcaCertPool); err != nil {
 		client.warning("Warning verifing certificate [% x]: [%s].", der, err)
 
 		return nil, err
@@ -160,47 +159,11 @@ func (client *clientImpl) getTCertFromExternalDER(der []byte) (tCert, error) {
 		// TODO: verify that TCertIndex has right format.
 
 		client.debug("TCertIndex: [% x].", TCertIndex)
-		mac := hmac.New(primitives.NewHash, ExpansionKey)
-		mac.Write(TCertIndex)
-		ExpansionValue := mac.Sum(nil)
-
-		// Derive tpk and tsk accordingly to ExpansionValue from enrollment pk,sk
-		// Computable by TCA / Auditor: TCertPub_Key = EnrollPub_Key + ExpansionValue G
-		// using elliptic curve point addition per NIST FIPS PUB 186-4- specified P-384
-
-		// Compute temporary secret key
-		tempSK := &ecdsa.PrivateKey{
-			PublicKey: ecdsa.PublicKey{
-				Curve: client.enrollPrivKey.Curve,
-				X:     new(big.Int),
-				Y:     new(big.Int),
-			},
-			D: new(big.Int),
-		}
+		tempSK, err := client.deriveTCertKey(ExpansionKey, TCertIndex)
+		if err != nil {
+			client.warning("Failed deriving TCert key [%s]. This is an foreign certificate.", err.Error())
 
-		var k = new(big.Int).SetBytes(ExpansionValue)
-		var one = new(big.Int).SetInt64(1)
-		n := new(big.Int).Sub(client.enrollPrivKey.Params().N, one)
-		k.Mod(k, n)
-		k.Add(k, one)
-
-		tempSK.D.Add(client.enrollPrivKey.D, k)
-		tempSK.D.Mod(tempSK.D, client.enrollPrivKey.PublicKey.Params().N)
-
-		// Compute temporary public key
-		tempX, tempY := client.enrollPrivKey.PublicKey.ScalarBaseMult(k.Bytes())
-		tempSK.PublicKey.X, tempSK.PublicKey.Y =
-			tempSK.PublicKey.Add(
-				client.enrollPrivKey.PublicKey.X, client.enrollPrivKey.PublicKey.Y,
-				tempX, tempY,
-			)
-
-		// Verify temporary public key is a valid point on the reference curve
-		isOn := tempSK.Curve.IsOnCurve(tempSK.PublicKey.X, tempSK.PublicKey.Y)
-		if !isOn {
-			client.warning("Failed temporary public key IsOnCurve check. This is an foreign certificate.")
-
-			return &tCertImpl{client, x509Cert, nil}, nil
+			return &tCertImpl{client, x509Cert, nil, nil}, nil
 		}
 
 		// Check that the derived public key is the same as the one in the certificate
@@ -209,13 +172,13 @@ func (client *clientImpl) getTCertFromExternalDER(der []byte) (tCert, error) {
 		if certPK.X.Cmp(tempSK.PublicKey.X) != 0 {
 			client.warning("Derived public key is different on X. This is an foreign certificate.")
 
-			return &tCertImpl{client, x509Cert, nil}, nil
+			return &tCertImpl{client, x509Cert, nil, nil}, nil
 		}
 
 		if certPK.Y.Cmp(tempSK.PublicKey.Y) != 0 {
 			client.warning("Derived public key is different on Y. This is an foreign certificate.")
 
-			return &tCertImpl{client, x509Cert, nil}, nil
+			return &tCertImpl{client, x509Cert, nil, nil}, nil
 		}
 
 		// Verify the signing capability of tempSK
@@ -223,31 +186,38 @@ func (client *clientImpl) getTCertFromExternalDER(der []byte) (tCert, error) {
 		if err != nil {
 			client.warning("Failed verifing signing capability [%s]. This is an foreign certificate.", err.Error())
 
-			return &tCertImpl{client, x509Cert, nil}, nil
+			return &tCertImpl{client, x509Cert, nil, nil}, nil
 		}
 
 		// Marshall certificate and secret key to be stored in the database
 		if err != nil {
 			client.warning("Failed marshalling private key [%s]. This is an foreign certificate.", err.Error())
 
-			return &tCertImpl{client, x509Cert, nil}, nil
+			return &tCertImpl{client, x509Cert, nil, nil}, nil
 		}
 
 		if err = utils.CheckCertPKAgainstSK(x509Cert, interface{}(tempSK)); err != nil {
 			client.warning("Failed checking TCA cert PK against private key [%s]. This is an foreign certificate.", err.Error())
 
-			return &tCertImpl{client, x509Cert, nil}, nil
+			return &tCertImpl{client, x509Cert, nil, nil}, nil
+		}
+
+		encSK, err := client.deriveTCertEncryptionKey(x509Cert, TCertIndex)
+		if err != nil {
+			client.warning("Failed deriving TCert encryption key [%s]. This is an foreign certificate.", err.Error())
+
+			return &tCertImpl{client, x509Cert, nil, nil}, nil
 		}
 
-		return &tCertImpl{client, x509Cert, tempSK}, nil
+		return &tCertImpl{client, x509Cert, tempSK, encSK}, nil
 	}
 
 	client.warning("Failed decrypting extension TCERT_ENC_TCERTINDEX [%s]. This is an foreign certificate.", err.Error())
 
-	return &tCertImpl{client, x509Cert, nil}, nil
+	return &tCertImpl{client, x509Cert, nil, nil}, nil
 }
 
-func (client *clientImpl) getTCertFromDER(der []byte) (tCert tCert, err error) {
+func (client *clientImpl) getTCertFromDER(der []byte) (tCert TCert, err error) {
 	if client.tCertOwnerKDFKey == nil {
 		return nil, fmt.Errorf("KDF key not initialized yet")
 	}
@@ -272,7 +242,7 @@ func (client *clientImpl) getTCertFromDER(der []byte) (tCert tCert, err error) {
 	}
 
 	// Verify certificate against root
-	if _, err = utils.CheckCertAgainRoot(x509Cert, client.tcaCertPool); err != nil {
+	if _, err = client.verifyCertificate(x509Cert, client.tcaCertPool); err != nil {
 		client.warning("Warning verifing certificate [%s].", err.Error())
 
 		return
@@ -297,47 +267,11 @@ func (client *clientImpl) getTCertFromDER(der []byte) (tCert tCert, err error) {
 	//		TCertIndex := []byte(strconv.Itoa(i))
 
 	client.debug("TCertIndex: [% x].", TCertIndex)
-	mac := hmac.New(primitives.NewHash, ExpansionKey)
-	mac.Write(TCertIndex)
-	ExpansionValue := mac.Sum(nil)
-
-	// Derive tpk and tsk accordingly to ExpansionValue from enrollment pk,sk
-	// Computable by TCA / Auditor: TCertPub_Key = EnrollPub_Key + ExpansionValue G
-	// using elliptic curve point addition per NIST FIPS PUB 186-4- specified P-384
-
-	// Compute temporary secret key
-	tempSK := &ecdsa.PrivateKey{
-		PublicKey: ecdsa.PublicKey{
-			Curve: client.enrollPrivKey.Curve,
-			X:     new(big.Int),
-			Y:     new(big.Int),
-		},
-		D: new(big.Int),
-	}
-
-	var k = new(big.Int).SetBytes(ExpansionValue)
-	var one = new(big.Int).SetInt64(1)
-	n := new(big.Int).Sub(client.enrollPrivKey.Params().N, one)
-	k.Mod(k, n)
-	k.Add(k, one)
-
-	tempSK.D.Add(client.enrollPrivKey.D, k)
-	tempSK.D.Mod(tempSK.D, client.enrollPrivKey.PublicKey.Params().N)
-
-	// Compute temporary public key
-	tempX, tempY := client.enrollPrivKey.PublicKey.ScalarBaseMult(k.Bytes())
-	tempSK.PublicKey.X, tempSK.PublicKey.Y =
-		tempSK.PublicKey.Add(
-			client.enrollPrivKey.PublicKey.X, client.enrollPrivKey.PublicKey.Y,
-			tempX, tempY,
-		)
-
-	// Verify temporary public key is a valid point on the reference curve
-	isOn := tempSK.Curve.IsOnCurve(tempSK.PublicKey.X, tempSK.PublicKey.Y)
-	if !isOn {
-		client.error("Failed temporary public key IsOnCurve check.")
+	tempSK, err := client.deriveTCertKey(ExpansionKey, TCertIndex)
+	if err != nil {
+		client.error("Failed deriving TCert key [%s].", err.Error())
 
-		return nil, fmt.Errorf("Failed temporary public key IsOnCurve check.")
+		return nil, err
 	}
 
 	// Check that the derived public key is the same as the one in the certificate
@@ -376,16 +310,45 @@ func (client *clientImpl) getTCertFromDER(der []byte) (tCert tCert, err error) {
 		return
 	}
 
-	tCert = &tCertImpl{client, x509Cert, tempSK}
+	encSK, err := client.deriveTCertEncryptionKey(x509Cert, TCertIndex)
+	if err != nil {
+		client.error("Failed deriving TCert encryption key [%s].", err.Error())
+
+		return
+	}
+
+	sk, err := client.protectTCertKey(x509Cert.SerialNumber, tempSK)
+	if err != nil {
+		client.error("Failed storing TCert key on the HSM [%s].", err.Error())
+
+		return
+	}
+
+	tCert = &tCertImpl{client, x509Cert, sk, encSK}
 
 	return
 }
 
-func (client *clientImpl) getTCertsFromTCA(num int) error {
-	client.debug("Get [%d] certificates from the TCA...", num)
+func (client *clientImpl) getTCertsFromTCA(ctx context.Context, num int, attributes []string) error {
+	client.debug("Get [%d] certificates with attributes [%v] from the TCA...", num, attributes)
+
+	tCertAttributes, err := client.getTCertAttributes(attributes)
+	if err != nil {
+		client.error("Failed getting TCert attributes [%s].", err.Error())
+
+		return err
+	}
+
+	if client.conf.isTCertOfflineEnabled() {
+		return client.getTCertsOffline(ctx, num, attributes, tCertAttributes)
+	}
+
+	if client.conf.isTCertBatchStreamEnabled() {
+		return client.getTCertsFromTCAStream(ctx, num, tCertAttributes)
+	}
 
 	// Contact the TCA
-	TCertOwnerKDFKey, certDERs, err := client.callTCACreateCertificateSet(num)
+	TCertOwnerKDFKey, certDERs, err := client.callTCACreateCertificateSet(ctx, num, tCertAttributes)
 	if err != nil {
 		client.debug("Failed contacting TCA [%s].", err.Error())
 
@@ -394,173 +357,275 @@ func (client *clientImpl) getTCertsFromTCA(num int) error {
 
 	//	client.debug("TCertOwnerKDFKey [%s].", utils.EncodeBase64(TCertOwnerKDFKey))
 
-	// Store TCertOwnerKDFKey and checks that every time it is always the same key
-	if client.tCertOwnerKDFKey != nil {
-		// Check that the keys are the same
-		equal := bytes.Equal(client.tCertOwnerKDFKey, TCertOwnerKDFKey)
-		if !equal {
-			return errors.New("Failed reciving kdf key from TCA. The keys are different.")
+	if err := client.setTCertOwnerKDFKey(TCertOwnerKDFKey); err != nil {
+		return err
+	}
+
+	// Validate the Certificates obtained
+	tCerts := []TCert{}
+	for i, certDER := range certDERs {
+		tCert, err := client.validateTCert(certDER, i)
+		if err != nil {
+			continue
 		}
-	} else {
-		client.tCertOwnerKDFKey = TCertOwnerKDFKey
 
-		// TODO: handle this situation more carefully
-		if err := client.storeTCertOwnerKDFKey(); err != nil {
-			client.error("Failed storing TCertOwnerKDFKey [%s].", err.Error())
+		tCerts = append(tCerts, tCert)
+	}
 
-			return err
-		}
+	if len(tCerts) == 0 {
+		client.error("No valid TCert was sent")
+
+		return errors.New("No valid TCert was sent.")
 	}
 
-	// Validate the Certificates obtained
+	return client.tCertPool.AddTCerts(tCerts)
+}
 
-	TCertOwnerEncryptKey := primitives.HMACAESTruncated(client.tCertOwnerKDFKey, []byte{1})
-	ExpansionKey := primitives.HMAC(client.tCertOwnerKDFKey, []byte{2})
+// getTCertsFromTCAStream receives the TCerts of the batch one by one as the
+// TCA issues them, adding each to the pool as soon as it is validated.
+// Cancelling ctx stops the batch; the TCerts received so far are kept.
+func (client *clientImpl) getTCertsFromTCAStream(ctx context.Context, num int, attributes []*membersrvc.TCertAttribute) error {
+	// Get a TCA Client
+	sock, tcaP, err := client.getTCAClient()
+	if err != nil {
+		client.error("Failed getting TCA client [%s].", err.Error())
 
-	j := 0
-	for i := 0; i < num; i++ {
-		// DER to x509
-		x509Cert, err := utils.DERToX509Certificate(certDERs[i].Cert)
-		if err != nil {
-			client.debug("Failed parsing certificate [% x]: [%s].", certDERs[i].Cert, err)
+		return utils.ErrTCAUnreachable
+	}
+	defer sock.Close()
 
-			continue
-		}
+	req, err := client.newTCertCreateSetReq(num, attributes)
+	if err != nil {
+		return err
+	}
+
+	stream, err := tcaP.CreateCertificateSetStream(ctx, req)
+	if err != nil {
+		client.error("Failed requesting tca create certificate set stream [%s].", err.Error())
 
-		// Handle Critical Extenstion TCertEncTCertIndex
-		tCertIndexCT, err := utils.GetCriticalExtension(x509Cert, utils.TCertEncTCertIndex)
+		return tcaError(ctx, err)
+	}
+
+	received, added := 0, 0
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			client.error("Failed getting extension TCERT_ENC_TCERTINDEX [% x]: [%s].", err)
+			if ctx.Err() != nil {
+				client.debug("TCert batch cancelled after [%d] TCerts.", received)
 
-			continue
+				return ctx.Err()
+			}
+			client.error("Failed receiving TCerts [%s].", err.Error())
+
+			return tcaError(ctx, err)
+		}
+
+		if err := client.setTCertOwnerKDFKey(resp.Certs.Key); err != nil {
+			return err
 		}
 
-		// Verify certificate against root
-		if _, err := utils.CheckCertAgainRoot(x509Cert, client.tcaCertPool); err != nil {
-			client.warning("Warning verifing certificate [%s].", err.Error())
+		for _, certDER := range resp.Certs.Certs {
+			tCert, err := client.validateTCert(certDER, received)
+			received++
+			if err != nil {
+				continue
+			}
 
-			continue
+			if err := client.tCertPool.AddTCerts([]TCert{tCert}); err != nil {
+				return err
+			}
+			added++
 		}
+	}
 
-		// Verify public key
+	if added == 0 {
+		client.error("No valid TCert was sent")
 
-		// 384-bit ExpansionValue = HMAC(Expansion_Key, TCertIndex)
-		// Let TCertIndex = Timestamp, RandValue, 1,2,…
-		// Timestamp assigned, RandValue assigned and counter reinitialized to 1 per batch
+		return errors.New("No valid TCert was sent.")
+	}
 
-		// Decrypt ct to TCertIndex (TODO: || EnrollPub_Key || EnrollID ?)
-		pt, err := primitives.CBCPKCS7Decrypt(TCertOwnerEncryptKey, tCertIndexCT)
-		if err != nil {
-			client.error("Failed decrypting extension TCERT_ENC_TCERTINDEX [%s].", err.Error())
+	return nil
+}
 
-			continue
+// setTCertOwnerKDFKey stores the TCertOwnerKDFKey sent by the TCA the first
+// time, and checks that every time after it is always the same key
+func (client *clientImpl) setTCertOwnerKDFKey(TCertOwnerKDFKey []byte) error {
+	if client.tCertOwnerKDFKey != nil {
+		// Check that the keys are the same
+		equal := bytes.Equal(client.tCertOwnerKDFKey, TCertOwnerKDFKey)
+		if !equal {
+			return errors.New("Failed reciving kdf key from TCA. The keys are different.")
 		}
 
-		// Compute ExpansionValue based on TCertIndex
-		TCertIndex := pt
-		//		TCertIndex := []byte(strconv.Itoa(i))
+		return nil
+	}
 
-		client.debug("TCertIndex: [% x].", TCertIndex)
-		mac := hmac.New(primitives.NewHash, ExpansionKey)
-		mac.Write(TCertIndex)
-		ExpansionValue := mac.Sum(nil)
-
-		// Derive tpk and tsk accordingly to ExpansionValue from enrollment pk,sk
-		// Computable by TCA / Auditor: TCertPub_Key = EnrollPub_Key + ExpansionValue G
-		// using elliptic curve point addition per NIST FIPS PUB 186-4- specified P-384
-
-		// Compute temporary secret key
-		tempSK := &ecdsa.PrivateKey{
-			PublicKey: ecdsa.PublicKey{
-				Curve: client.enrollPrivKey.Curve,
-				X:     new(big.Int),
-				Y:     new(big.Int),
-			},
-			D: new(big.Int),
-		}
+	client.tCertOwnerKDFKey = TCertOwnerKDFKey
 
-		var k = new(big.Int).SetBytes(ExpansionValue)
-		var one = new(big.Int).SetInt64(1)
-		n := new(big.Int).Sub(client.enrollPrivKey.Params().N, one)
-		k.Mod(k, n)
-		k.Add(k, one)
+	// TODO: handle this situation more carefully
+	if err := client.storeTCertOwnerKDFKey(); err != nil {
+		client.error("Failed storing TCertOwnerKDFKey [%s].", err.Error())
 
-		tempSK.D.Add(client.enrollPrivKey.D, k)
-		tempSK.D.Mod(tempSK.D, client.enrollPrivKey.PublicKey.Params().N)
+		return err
+	}
 
-		// Compute temporary public key
-		tempX, tempY := client.enrollPrivKey.PublicKey.ScalarBaseMult(k.Bytes())
-		tempSK.PublicKey.X, tempSK.PublicKey.Y =
-			tempSK.PublicKey.Add(
-				client.enrollPrivKey.PublicKey.X, client.enrollPrivKey.PublicKey.Y,
-				tempX, tempY,
-			)
+	return nil
+}
 
-		// Verify temporary public key is a valid point on the reference curve
-		isOn := tempSK.Curve.IsOnCurve(tempSK.PublicKey.X, tempSK.PublicKey.Y)
-		if !isOn {
-			client.error("Failed temporary public key IsOnCurve check.")
+// validateTCert checks the i-th TCert sent by the TCA against the
+// TCertOwnerKDFKey and derives its keys
+func (client *clientImpl) validateTCert(certDER *membersrvc.TCert, i int) (TCert, error) {
+	TCertOwnerEncryptKey := primitives.HMACAESTruncated(client.tCertOwnerKDFKey, []byte{1})
+	ExpansionKey := primitives.HMAC(client.tCertOwnerKDFKey, []byte{2})
 
-			continue
-		}
+	// DER to x509
+	x509Cert, err := utils.DERToX509Certificate(certDER.Cert)
+	if err != nil {
+		client.debug("Failed parsing certificate [% x]: [%s].", certDER.Cert, err)
 
-		// Check that the derived public key is the same as the one in the certificate
-		certPK := x509Cert.PublicKey.(*ecdsa.PublicKey)
+		return nil, err
+	}
 
-		if certPK.X.Cmp(tempSK.PublicKey.X) != 0 {
-			client.error("Derived public key is different on X")
+	// Handle Critical Extenstion TCertEncTCertIndex
+	tCertIndexCT, err := utils.GetCriticalExtension(x509Cert, utils.TCertEncTCertIndex)
+	if err != nil {
+		client.error("Failed getting extension TCERT_ENC_TCERTINDEX [% x]: [%s].", err)
 
-			continue
-		}
+		return nil, err
+	}
 
-		if certPK.Y.Cmp(tempSK.PublicKey.Y) != 0 {
-			client.error("Derived public key is different on Y")
+	// Verify certificate against root
+	if _, err := client.verifyCertificate(x509Cert, client.tcaCertPool); err != nil {
+		client.warning("Warning verifing certificate [%s].", err.Error())
 
-			continue
-		}
+		return nil, err
+	}
 
-		// Verify the signing capability of tempSK
-		err = primitives.VerifySignCapability(tempSK, x509Cert.PublicKey)
-		if err != nil {
-			client.error("Failed verifing signing capability [%s].", err.Error())
+	// Verify public key
 
-			continue
-		}
+	// 384-bit ExpansionValue = HMAC(Expansion_Key, TCertIndex)
+	// Let TCertIndex = Timestamp, RandValue, 1,2,…
+	// Timestamp assigned, RandValue assigned and counter reinitialized to 1 per batch
 
-		// Marshall certificate and secret key to be stored in the database
-		if err != nil {
-			client.error("Failed marshalling private key [%s].", err.Error())
+	// Decrypt ct to TCertIndex (TODO: || EnrollPub_Key || EnrollID ?)
+	pt, err := primitives.CBCPKCS7Decrypt(TCertOwnerEncryptKey, tCertIndexCT)
+	if err != nil {
+		client.error("Failed decrypting extension TCERT_ENC_TCERTINDEX [%s].", err.Error())
 
-			continue
-		}
+		return nil, err
+	}
+
+	// Compute ExpansionValue based on TCertIndex
+	TCertIndex := pt
+	//		TCertIndex := []byte(strconv.Itoa(i))
 
-		if err := utils.CheckCertPKAgainstSK(x509Cert, interface{}(tempSK)); err != nil {
-			client.error("Failed checking TCA cert PK against private key [%s].", err.Error())
+	client.debug("TCertIndex: [% x].", TCertIndex)
+	tempSK, err := client.deriveTCertKey(ExpansionKey, TCertIndex)
+	if err != nil {
+		client.error("Failed deriving TCert key [%s].", err.Error())
 
-			continue
-		}
+		return nil, err
+	}
+
+	// Check that the derived public key is the same as the one in the certificate
+	certPK := x509Cert.PublicKey.(*ecdsa.PublicKey)
 
-		client.debug("Sub index [%d]", j)
-		j++
-		client.debug("Certificate [%d] validated.", i)
+	if certPK.X.Cmp(tempSK.PublicKey.X) != 0 {
+		client.error("Derived public key is different on X")
 
-		client.tCertPool.AddTCert(&tCertImpl{client, x509Cert, tempSK})
+		return nil, errors.New("Derived public key is different on X")
 	}
 
-	if j == 0 {
-		client.error("No valid TCert was sent")
+	if certPK.Y.Cmp(tempSK.PublicKey.Y) != 0 {
+		client.error("Derived public key is different on Y")
 
-		return errors.New("No valid TCert was sent.")
+		return nil, errors.New("Derived public key is different on Y")
 	}
 
-	return nil
+	// Verify the signing capability of tempSK
+	err = primitives.VerifySignCapability(tempSK, x509Cert.PublicKey)
+	if err != nil {
+		client.error("Failed verifing signing capability [%s].", err.Error())
+
+		return nil, err
+	}
+
+	// Marshall certificate and secret key to be stored in the database
+	if err != nil {
+		client.error("Failed marshalling private key [%s].", err.Error())
+
+		return nil, err
+	}
+
+	if err := utils.CheckCertPKAgainstSK(x509Cert, interface{}(tempSK)); err != nil {
+		client.error("Failed checking TCA cert PK against private key [%s].", err.Error())
+
+		return nil, err
+	}
+
+	client.debug("Certificate [%d] validated.", i)
+
+	encSK, err := client.deriveTCertEncryptionKey(x509Cert, TCertIndex)
+	if err != nil {
+		client.error("Failed deriving TCert encryption key [%s].", err.Error())
+
+		return nil, err
+	}
+
+	sk, err := client.protectTCertKey(x509Cert.SerialNumber, tempSK)
+	if err != nil {
+		client.error("Failed storing TCert key on the HSM [%s].", err.Error())
+
+		return nil, err
+	}
+
+	return &tCertImpl{client, x509Cert, sk, encSK}, nil
 }
 
-func (client *clientImpl) callTCACreateCertificateSet(num int) ([]byte, []*membersrvc.TCert, error) {
+func (client *clientImpl) callTCACreateCertificateSet(ctx context.Context, num int, attributes []*membersrvc.TCertAttribute) ([]byte, []*membersrvc.TCert, error) {
 	// Get a TCA Client
 	sock, tcaP, err := client.getTCAClient()
+	if err != nil {
+		client.error("Failed getting TCA client [%s].", err.Error())
+
+		return nil, nil, utils.ErrTCAUnreachable
+	}
 	defer sock.Close()
 
+	req, err := client.newTCertCreateSetReq(num, attributes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Send request
+	certSet, err := tcaP.CreateCertificateSet(ctx, req)
+	if err != nil {
+		client.error("Failed requesting tca create certificate set [%s].", err.Error())
+
+		return nil, nil, tcaError(ctx, err)
+	}
+
+	return certSet.Certs.Key, certSet.Certs.Certs, nil
+}
+
+// tcaError returns the error of the crypto layer meant by the error err of
+// a TCert request made within ctx
+func tcaError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if grpc.Code(err) == codes.ResourceExhausted {
+		return utils.ErrRateLimited
+	}
+
+	return utils.ErrTCAUnreachable
+}
+
+// newTCertCreateSetReq returns the request of num TCerts, signed with the enrollment key
+func (client *clientImpl) newTCertCreateSetReq(num int, attributes []*membersrvc.TCertAttribute) (*membersrvc.TCertCreateSetReq, error) {
 	// Execute the protocol
 	now := time.Now()
 	timestamp := google_protobuf.Timestamp{Seconds: int64(now.Second()), Nanos: int32(now.Nanosecond())}
@@ -568,21 +633,22 @@ func (client *clientImpl) callTCACreateCertificateSet(num int) ([]byte, []*membe
 		Ts:         &timestamp,
 		Id:         &membersrvc.Identity{Id: client.enrollID},
 		Num:        uint32(num),
-		Attributes: client.conf.getTCertAttributes(),
+		Attributes: attributes,
 		Sig:        nil,
+		DualKey:    client.conf.isTCertDualKeyEnabled(),
 	}
 
 	rawReq, err := proto.Marshal(req)
 	if err != nil {
 		client.error("Failed marshaling request [%s] [%s].", err.Error())
-		return nil, nil, err
+		return nil, err
 	}
 
 	// 2. Sign rawReq
 	r, s, err := client.ecdsaSignWithEnrollmentKey(rawReq)
 	if err != nil {
 		client.error("Failed creating signature for [% x]: [%s].", rawReq, err.Error())
-		return nil, nil, err
+		return nil, err
 	}
 
 	R, _ := r.MarshalText()
@@ -591,15 +657,7 @@ func (client *clientImpl) callTCACreateCertificateSet(num int) ([]byte, []*membe
 	// 3. Append the signature
 	req.Sig = &membersrvc.Signature{Type: membersrvc.CryptoType_ECDSA, R: R, S: S}
 
-	// 4. Send request
-	certSet, err := tcaP.CreateCertificateSet(context.Background(), req)
-	if err != nil {
-		client.error("Failed requesting tca create certificate set [%s].", err.Error())
-
-		return nil, nil, err
-	}
-
-	return certSet.Certs.Key, certSet.Certs.Certs, nil
+	return req, nil
 }
 
 func (client *clientImpl) parseHeader(header string) (map[string]int, error) {
@@ -624,6 +682,81 @@ func (client *clientImpl) parseHeader(header string) (map[string]int, error) {
 
 }
 
+// getTCertAttributes returns the configured attributes whose names are
+// in attributeNames. If attributeNames is empty, all the configured
+// attributes are returned.
+func (client *clientImpl) getTCertAttributes(attributeNames []string) ([]*membersrvc.TCertAttribute, error) {
+	if len(attributeNames) == 0 {
+		return client.conf.getTCertAttributes(), nil
+	}
+
+	attributes := []*membersrvc.TCertAttribute{}
+	for _, name := range attributeNames {
+		found := false
+		for _, attribute := range client.conf.getTCertAttributes() {
+			if attribute.AttributeName == name {
+				attributes = append(attributes, attribute)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Attribute [%s] not configured", name)
+		}
+	}
+
+	return attributes, nil
+}
+
+// getRequestedTCertAttributeNames returns attributeNames or, if empty,
+// the names of all the configured attributes.
+func (client *clientImpl) getRequestedTCertAttributeNames(attributeNames []string) []string {
+	if len(attributeNames) != 0 {
+		return attributeNames
+	}
+
+	names := []string{}
+	for _, attribute := range client.conf.getTCertAttributes() {
+		names = append(names, attribute.AttributeName)
+	}
+
+	return names
+}
+
+// tCertAttributesKey returns the key identifying the TCerts carrying
+// exactly the attributes named attributeNames.
+func tCertAttributesKey(attributeNames []string) string {
+	names := make([]string, len(attributeNames))
+	copy(names, attributeNames)
+	sort.Strings(names)
+
+	return strings.Join(names, "#")
+}
+
+// getTCertAttributeNames returns the names of the attributes carried by tCert
+func (client *clientImpl) getTCertAttributeNames(tCert TCert) []string {
+	headerRaw, err := utils.GetCriticalExtension(tCert.GetCertificate(), utils.TCertAttributesHeaders)
+	if err != nil {
+		// No attributes
+		return []string{}
+	}
+
+	header, err := client.parseHeader(string(headerRaw))
+	if err != nil {
+		client.warning("Failed parsing TCert attributes header [%s].", err.Error())
+
+		return []string{}
+	}
+
+	names := []string{}
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
 // Read the attribute with name 'attributeName' from the der encoded x509.Certificate 'tcertder'.
 func (client *clientImpl) ReadAttribute(attributeName string, tcertder []byte) ([]byte, error) {
 	tcert, err := utils.DERToX509Certificate(tcertder)
@@ -17,22 +17,37 @@ limitations under the License.
 package crypto
 
 import (
+	"crypto/ecdsa"
 	"crypto/x509"
+	"time"
+
 	"github.com/hyperledger/fabric/core/crypto/utils"
 )
 
-type tCert interface {
+// TCert is a transaction certificate together with its signing key, if any
+type TCert interface {
+	// GetCertificate returns the underlying x509 certificate
 	GetCertificate() *x509.Certificate
 
+	// Sign signs msg using the signing key corresponding to the certificate
 	Sign(msg []byte) ([]byte, error)
 
+	// Verify verifies msg using the verifying key corresponding to the certificate
 	Verify(signature, msg []byte) error
+
+	// GetEncryptionKey returns the encryption key of a dual-key TCert, or
+	// nil if the certificate key is used for both signing and encryption
+	GetEncryptionKey() (*ecdsa.PublicKey, error)
+
+	// Decrypt decrypts ct, encrypted under the encryption key of the TCert
+	Decrypt(ct []byte) ([]byte, error)
 }
 
 type tCertImpl struct {
 	client *clientImpl
 	cert   *x509.Certificate
 	sk     interface{}
+	encSK  *ecdsa.PrivateKey
 }
 
 func (tCert *tCertImpl) GetCertificate() *x509.Certificate {
@@ -57,3 +72,50 @@ func (tCert *tCertImpl) Verify(signature, msg []byte) (err error) {
 	}
 	return
 }
+
+func (tCert *tCertImpl) GetEncryptionKey() (*ecdsa.PublicKey, error) {
+	return utils.GetTCertEncryptionKey(tCert.cert)
+}
+
+func (tCert *tCertImpl) Decrypt(ct []byte) ([]byte, error) {
+	if tCert.encSK == nil {
+		return nil, utils.ErrNoTCertEncryptionKey
+	}
+
+	sk, err := tCert.client.eciesSPI.NewPrivateKey(nil, tCert.encSK)
+	if err != nil {
+		return nil, err
+	}
+	cipher, err := tCert.client.eciesSPI.NewAsymmetricCipherFromPrivateKey(sk)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.Process(ct)
+}
+
+// isTCertUsable returns true if tCert is already valid, not revoked
+// and does not expire within the configured margin
+func (client *clientImpl) isTCertUsable(tCert TCert) bool {
+	cert := tCert.GetCertificate()
+	now := time.Now()
+
+	if now.Before(cert.NotBefore) {
+		return false
+	}
+	if client.isTCertRevoked(tCert) {
+		return false
+	}
+
+	return now.Add(client.conf.getTCertExpiryMargin()).Before(cert.NotAfter)
+}
+
+// evictTCert logs that tCert has been discarded because it is expired,
+// about to expire or revoked
+func (client *clientImpl) evictTCert(tCert TCert) {
+	cert := tCert.GetCertificate()
+
+	client.warning("Evicting TCert [%s] valid from [%s] to [%s].", cert.SerialNumber, cert.NotBefore, cert.NotAfter)
+
+	getTCertPoolMetrics().IncEvictions(client.GetName())
+}
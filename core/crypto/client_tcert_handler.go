@@ -25,7 +25,7 @@ import (
 type tCertHandlerImpl struct {
 	client *clientImpl
 
-	tCert tCert
+	tCert TCert
 }
 
 type tCertTransactionHandlerImpl struct {
@@ -35,7 +35,7 @@ type tCertTransactionHandlerImpl struct {
 	binding []byte
 }
 
-func (handler *tCertHandlerImpl) init(client *clientImpl, tCert tCert) error {
+func (handler *tCertHandlerImpl) init(client *clientImpl, tCert TCert) error {
 	handler.client = client
 	handler.tCert = tCert
 
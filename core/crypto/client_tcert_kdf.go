@@ -0,0 +1,143 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/x509"
+	"errors"
+	"math/big"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// deriveTCertKey derives from the enrollment key the private key of the
+// TCert whose index is tCertIndex.
+//
+// 384-bit ExpansionValue = HMAC(Expansion_Key, TCertIndex)
+// TCertPriv_Key = EnrollPriv_Key + ExpansionValue mod n
+//
+// The TCA and the auditors compute the matching public key as
+// TCertPub_Key = EnrollPub_Key + ExpansionValue G, using elliptic curve
+// point addition per NIST FIPS PUB 186-4- specified P-384.
+func (client *clientImpl) deriveTCertKey(expansionKey, tCertIndex []byte) (*ecdsa.PrivateKey, error) {
+	if client.enrollPrivKey == nil {
+		client.error("TCert keys derive from an ECDSA enrollment key.")
+
+		return nil, utils.ErrInvalidKey
+	}
+
+	mac := hmac.New(primitives.NewHash, expansionKey)
+	mac.Write(tCertIndex)
+	expansionValue := mac.Sum(nil)
+
+	// Compute temporary secret key
+	tempSK := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: client.enrollPrivKey.Curve,
+			X:     new(big.Int),
+			Y:     new(big.Int),
+		},
+		D: new(big.Int),
+	}
+
+	var k = new(big.Int).SetBytes(expansionValue)
+	var one = new(big.Int).SetInt64(1)
+	n := new(big.Int).Sub(client.enrollPrivKey.Params().N, one)
+	k.Mod(k, n)
+	k.Add(k, one)
+
+	tempSK.D.Add(client.enrollPrivKey.D, k)
+	tempSK.D.Mod(tempSK.D, client.enrollPrivKey.PublicKey.Params().N)
+
+	// Compute temporary public key
+	tempX, tempY := client.enrollPrivKey.PublicKey.ScalarBaseMult(k.Bytes())
+	tempSK.PublicKey.X, tempSK.PublicKey.Y =
+		tempSK.PublicKey.Add(
+			client.enrollPrivKey.PublicKey.X, client.enrollPrivKey.PublicKey.Y,
+			tempX, tempY,
+		)
+
+	// Verify temporary public key is a valid point on the reference curve
+	if !tempSK.Curve.IsOnCurve(tempSK.PublicKey.X, tempSK.PublicKey.Y) {
+		return nil, errors.New("Failed temporary public key IsOnCurve check.")
+	}
+
+	return tempSK, nil
+}
+
+// deriveTCertEncryptionKey derives the encryption key of a dual-key TCert,
+// as deriveTCertKey does but under its own expansion key, and checks it
+// against the one the TCert carries. It returns nil if the TCert has a
+// single key pair.
+func (client *clientImpl) deriveTCertEncryptionKey(cert *x509.Certificate, tCertIndex []byte) (*ecdsa.PrivateKey, error) {
+	pub, err := utils.GetTCertEncryptionKey(cert)
+	if err != nil || pub == nil {
+		return nil, err
+	}
+
+	encSK, err := client.deriveTCertKey(primitives.HMAC(client.tCertOwnerKDFKey, []byte{3}), tCertIndex)
+	if err != nil {
+		return nil, err
+	}
+	if encSK.X.Cmp(pub.X) != 0 || encSK.Y.Cmp(pub.Y) != 0 {
+		return nil, utils.ErrInvalidTCertEncryptionKey
+	}
+
+	return encSK, nil
+}
+
+// openTCertIndex verifies the TCert sent by the TCA against the root and
+// returns the TCertIndex its keys derive from, decrypted with the
+// TCertOwnerKDFKey. It fails if the TCert is not owned by the client.
+func (client *clientImpl) openTCertIndex(x509Cert *x509.Certificate) ([]byte, error) {
+	tCertIndexCT, err := utils.GetCriticalExtension(x509Cert, utils.TCertEncTCertIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := client.verifyCertificate(x509Cert, client.tcaCertPool); err != nil {
+		return nil, err
+	}
+
+	return primitives.CBCPKCS7Decrypt(primitives.HMACAESTruncated(client.tCertOwnerKDFKey, []byte{1}), tCertIndexCT)
+}
+
+// deriveTCertKeys derives the keys of the TCert from its TCertIndex and
+// checks them against the public keys the TCert carries
+func (client *clientImpl) deriveTCertKeys(x509Cert *x509.Certificate, tCertIndex []byte) (*ecdsa.PrivateKey, *ecdsa.PrivateKey, error) {
+	sk, err := client.deriveTCertKey(primitives.HMAC(client.tCertOwnerKDFKey, []byte{2}), tCertIndex)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := utils.CheckCertPKAgainstSK(x509Cert, interface{}(sk)); err != nil {
+		return nil, nil, err
+	}
+	if err := primitives.VerifySignCapability(sk, x509Cert.PublicKey); err != nil {
+		return nil, nil, err
+	}
+
+	encSK, err := client.deriveTCertEncryptionKey(x509Cert, tCertIndex)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return sk, encSK, nil
+}
@@ -0,0 +1,190 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"errors"
+
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/net/context"
+)
+
+// In offline mode the pool is refilled from a reserve of TCerts fetched from
+// the TCA ahead of time and kept in the keystore, instead of a round trip to
+// the TCA per batch. The TCerts of the reserve are verified when fetched,
+// and their keys are derived locally from the TCertOwnerKDFKey only when
+// they are minted, that is moved from the reserve to the pool:
+//
+// TCertPriv_Key = EnrollPriv_Key + HMAC(Expansion_Key, TCertIndex) mod n
+//
+// The keystore holds the TCerts and their TCertIndex, never their private
+// keys. The TCA is contacted again once the reserve falls short of a batch.
+
+// getTCertsOffline mints num TCerts carrying attributes from the reserve,
+// replenishing it first if it holds fewer. If the TCA cannot be reached,
+// the TCerts left in the reserve are minted.
+func (client *clientImpl) getTCertsOffline(ctx context.Context, num int, attributeNames []string, attributes []*membersrvc.TCertAttribute) error {
+	key := tCertAttributesKey(client.getRequestedTCertAttributeNames(attributeNames))
+
+	count, err := client.ks.countReservedTCerts(key)
+	if err != nil {
+		client.error("Failed counting reserved TCerts [%s].", err.Error())
+
+		return err
+	}
+	if count < num {
+		if err := client.reserveTCerts(ctx, key, attributes); err != nil {
+			if count == 0 {
+				return err
+			}
+			client.warning("Failed replenishing the TCert reserve [%s]. Minting the [%d] TCerts left.", err.Error(), count)
+		}
+	}
+
+	tCerts, err := client.mintTCerts(key, num)
+	if err != nil {
+		return err
+	}
+	if len(tCerts) == 0 {
+		client.error("No valid TCert was reserved")
+
+		return errors.New("No valid TCert was reserved.")
+	}
+
+	return client.tCertPool.AddTCerts(tCerts)
+}
+
+// reserveTCerts fetches from the TCA, in a single request, the TCerts of the
+// reserve carrying attributes and stores those owned by the client
+func (client *clientImpl) reserveTCerts(ctx context.Context, key string, attributes []*membersrvc.TCertAttribute) error {
+	num := client.conf.getTCertReserve()
+	client.debug("Reserving [%d] TCerts carrying [%s]...", num, key)
+
+	TCertOwnerKDFKey, certDERs, err := client.callTCACreateCertificateSet(ctx, num, attributes)
+	if err != nil {
+		client.debug("Failed contacting TCA [%s].", err.Error())
+
+		return err
+	}
+	if err := client.setTCertOwnerKDFKey(TCertOwnerKDFKey); err != nil {
+		return err
+	}
+
+	reserved := []*reservedTCert{}
+	for i, certDER := range certDERs {
+		x509Cert, err := utils.DERToX509Certificate(certDER.Cert)
+		if err != nil {
+			client.error("Failed parsing certificate [%d]: [%s].", i, err)
+
+			continue
+		}
+		tCertIndex, err := client.openTCertIndex(x509Cert)
+		if err != nil {
+			client.error("Failed opening the TCertIndex of certificate [%d]: [%s].", i, err)
+
+			continue
+		}
+		reserved = append(reserved, &reservedTCert{certDER.Cert, tCertIndex})
+	}
+	if len(reserved) == 0 {
+		client.error("No valid TCert was sent")
+
+		return errors.New("No valid TCert was sent.")
+	}
+
+	keys, err := client.getUnusedTCertsKeys()
+	if err != nil {
+		client.error("Failed getting unused TCerts keys [%s].", err)
+
+		return err
+	}
+	if err := client.ks.storeReservedTCerts(key, reserved, keys); err != nil {
+		client.error("Failed storing reserved TCerts [%s].", err)
+
+		return err
+	}
+
+	client.debug("Reserving [%d] TCerts carrying [%s]...done!", len(reserved), key)
+
+	return nil
+}
+
+// mintTCerts takes up to num TCerts carrying the attributes key from the
+// reserve and derives their keys. The TCerts no longer usable are dropped.
+func (client *clientImpl) mintTCerts(key string, num int) ([]TCert, error) {
+	keys, err := client.getUnusedTCertsKeys()
+	if err != nil {
+		client.error("Failed getting unused TCerts keys [%s].", err)
+
+		return nil, err
+	}
+
+	reserved, err := client.ks.takeReservedTCerts(key, num, keys)
+	if err != nil {
+		client.error("Failed loading reserved TCerts [%s].", err)
+
+		return nil, err
+	}
+
+	tCerts := []TCert{}
+	for _, r := range reserved {
+		x509Cert, err := utils.DERToX509Certificate(r.Cert)
+		if err != nil {
+			client.error("Failed parsing reserved TCert [%s].", err)
+
+			continue
+		}
+
+		sk, encSK, err := client.deriveTCertKeys(x509Cert, r.Index)
+		if err != nil {
+			client.error("Failed deriving the keys of reserved TCert [%s]: [%s].", x509Cert.SerialNumber, err)
+
+			continue
+		}
+		protected, err := client.protectTCertKey(x509Cert.SerialNumber, sk)
+		if err != nil {
+			client.error("Failed storing TCert key on the HSM [%s].", err.Error())
+
+			continue
+		}
+
+		tCert := &tCertImpl{client, x509Cert, protected, encSK}
+		if !client.isTCertUsable(tCert) {
+			client.evictTCert(tCert)
+
+			continue
+		}
+		tCerts = append(tCerts, tCert)
+	}
+	client.debug("Minted [%d] TCerts carrying [%s] from [%d] reserved.", len(tCerts), key, len(reserved))
+
+	return tCerts, nil
+}
+
+// hasReservedTCerts returns true if TCerts carrying the attributes named
+// attributeNames can be minted without contacting the TCA
+func (client *clientImpl) hasReservedTCerts(attributeNames []string) bool {
+	if !client.conf.isTCertOfflineEnabled() {
+		return false
+	}
+
+	count, err := client.ks.countReservedTCerts(tCertAttributesKey(client.getRequestedTCertAttributeNames(attributeNames)))
+
+	return err == nil && count > 0
+}
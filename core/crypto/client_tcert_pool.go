@@ -16,14 +16,199 @@ limitations under the License.
 
 package crypto
 
-type tCertPool interface {
-	init(client *clientImpl) error
+import (
+	"fmt"
+	"sort"
+	"sync"
 
+	"golang.org/x/net/context"
+)
+
+const (
+	// TCertPoolSingleThread is the name of the default TCert pool
+	TCertPoolSingleThread = "singlethread"
+
+	// TCertPoolMultithreading is the name of the TCert pool backed by a filler goroutine
+	TCertPoolMultithreading = "multithreading"
+
+	// TCertPoolShared is the name of the TCert pool whose filler is shared
+	// by all the clients of the process
+	TCertPoolShared = "shared"
+)
+
+// TCertPool provides a client with a supply of not yet used TCerts
+type TCertPool interface {
+	// Start starts the pool. It is invoked once when the client is initialized
 	Start() error
 
+	// Stop stops the pool. It is invoked once when the client is closed
 	Stop() error
 
-	GetNextTCert() (tCert, error)
+	// GetNextTCert returns the next available (not yet used) TCert carrying
+	// the passed attributes. If no attribute is passed, the TCert carries
+	// all the attributes configured for the client.
+	GetNextTCert(attributes ...string) (TCert, error)
+
+	// GetNextTCertContext is like GetNextTCert but it gives up
+	// as soon as ctx is cancelled or its deadline expires.
+	//
+	// Both methods fail with utils.ErrPoolExhausted when no TCert could be
+	// obtained, utils.ErrTCAUnreachable when this is due to the TCA not
+	// answering and utils.ErrPoolStopped once the pool has been stopped.
+	GetNextTCertContext(ctx context.Context, attributes ...string) (TCert, error)
+
+	// Prefetch fills the pool with at least n TCerts carrying the passed
+	// attributes. It blocks until they are available or ctx is done.
+	Prefetch(ctx context.Context, n int, attributes ...string) error
+
+	// AddTCert adds a TCert to the pool
+	AddTCert(tCert TCert) error
+
+	// AddTCerts adds a batch of TCerts to the pool. It is invoked by the
+	// client with the valid TCerts of every batch obtained from the TCA.
+	// It must not block: the TCerts that do not fit in the pool are
+	// stored in the keystore.
+	AddTCerts(tCerts []TCert) error
+
+	// HealthStatus returns the outcome of the periodic health checks
+	// of the TCerts buffered by the pool
+	HealthStatus() TCertPoolHealthStatus
+}
+
+// TCertPoolClient exposes to a TCertPool the services of the client owning it
+type TCertPoolClient interface {
+	// GetName returns the name of the client
+	GetName() string
+
+	// GetTCertBatchSize returns the configured TCert batch size
+	GetTCertBatchSize() int
+
+	// RequestTCerts requests num TCerts carrying the passed attributes to
+	// the TCA. The valid ones are handed back to the pool via AddTCerts.
+	// The request is aborted if ctx is cancelled.
+	RequestTCerts(ctx context.Context, num int, attributes ...string) error
+
+	// GetTCertAttributes returns the names of the attributes carried by tCert
+	GetTCertAttributes(tCert TCert) []string
+
+	// LoadUnusedTCerts loads and removes from the keystore the TCerts
+	// stored by a previous call to StoreUnusedTCerts
+	LoadUnusedTCerts() ([]TCert, error)
+
+	// StoreUnusedTCerts stores in the keystore TCerts that have not been used
+	StoreUnusedTCerts(tCerts []TCert) error
+
+	// StoreUsedTCert stores in the keystore a TCert that has been used
+	StoreUsedTCert(tCert TCert) error
+}
+
+// tCertChaincodePool describes the TCerts used to transact with a chaincode:
+// the attributes they carry and how many of them the pool keeps at hand
+type tCertChaincodePool struct {
+	attributes []string
+	size       int
+}
+
+// TCertPoolProvider creates a TCertPool for the passed client
+type TCertPoolProvider func(client TCertPoolClient) (TCertPool, error)
+
+var (
+	tCertPoolProviders     = make(map[string]TCertPoolProvider)
+	tCertPoolProvidersLock sync.RWMutex
+)
+
+func init() {
+	RegisterTCertPoolProvider(TCertPoolSingleThread, newTCertPoolSingleThread)
+	RegisterTCertPoolProvider(TCertPoolMultithreading, newTCertPoolMultithreading)
+	RegisterTCertPoolProvider(TCertPoolShared, newTCertPoolShared)
+}
+
+// RegisterTCertPoolProvider registers provider under name. Clients select
+// the provider to use by setting the property security.tcert.pool.provider.
+func RegisterTCertPoolProvider(name string, provider TCertPoolProvider) error {
+	if name == "" || provider == nil {
+		return fmt.Errorf("Invalid TCert pool provider [%s]", name)
+	}
+
+	tCertPoolProvidersLock.Lock()
+	defer tCertPoolProvidersLock.Unlock()
+
+	if _, ok := tCertPoolProviders[name]; ok {
+		return fmt.Errorf("TCert pool provider [%s] already registered", name)
+	}
+	tCertPoolProviders[name] = provider
+
+	return nil
+}
+
+// GetTCertPoolProviders returns the names of the registered TCert pool providers
+func GetTCertPoolProviders() []string {
+	tCertPoolProvidersLock.RLock()
+	defer tCertPoolProvidersLock.RUnlock()
+
+	names := make([]string, 0, len(tCertPoolProviders))
+	for name := range tCertPoolProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func newTCertPool(name string, client TCertPoolClient) (TCertPool, error) {
+	tCertPoolProvidersLock.RLock()
+	provider, ok := tCertPoolProviders[name]
+	tCertPoolProvidersLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("TCert pool provider [%s] not registered", name)
+	}
+
+	return provider(client)
+}
+
+// GetTCertBatchSize returns the configured TCert batch size
+func (client *clientImpl) GetTCertBatchSize() int {
+	return client.conf.getTCertBatchSize()
+}
+
+// RequestTCerts requests num TCerts to the TCA and adds the valid ones to the pool
+func (client *clientImpl) RequestTCerts(ctx context.Context, num int, attributes ...string) error {
+	return client.getTCertsFromTCA(ctx, num, attributes)
+}
+
+// GetTCertAttributes returns the names of the attributes carried by tCert
+func (client *clientImpl) GetTCertAttributes(tCert TCert) []string {
+	return client.getTCertAttributeNames(tCert)
+}
+
+// LoadUnusedTCerts loads the unused TCerts stored in the keystore
+func (client *clientImpl) LoadUnusedTCerts() ([]TCert, error) {
+	tCertDERs, err := client.loadUnusedTCerts()
+	if err != nil {
+		return nil, err
+	}
+
+	tCerts := []TCert{}
+	for _, tCertDER := range tCertDERs {
+		tCert, err := client.getTCertFromDER(tCertDER)
+		if err != nil {
+			client.error("Failed paring TCert [% x]: [%s]", tCertDER, err)
+
+			continue
+		}
+		tCerts = append(tCerts, tCert)
+	}
+
+	return tCerts, nil
+}
+
+// StoreUnusedTCerts stores the passed unused TCerts in the keystore
+func (client *clientImpl) StoreUnusedTCerts(tCerts []TCert) error {
+	return client.storeUnusedTCerts(tCerts)
+}
 
-	AddTCert(tCert tCert) error
+// StoreUsedTCert stores the passed used TCert in the keystore
+func (client *clientImpl) StoreUsedTCert(tCert TCert) error {
+	return client.ks.storeUsedTCert(tCert)
 }
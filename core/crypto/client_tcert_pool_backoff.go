@@ -0,0 +1,143 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// tCertPoolBackoff paces the refill attempts of a TCert pool. After
+// a failure, the next attempt is delayed by an interval that grows
+// exponentially, up to a maximum, and is randomized by a jitter factor.
+// After too many consecutive failures, the circuit opens and no attempt
+// is allowed for a cool-down period. Then a single attempt is let
+// through: if it succeeds the circuit closes, otherwise it opens again.
+type tCertPoolBackoff struct {
+	m sync.Mutex
+
+	initial    time.Duration
+	multiplier float64
+	max        time.Duration
+	jitter     float64
+
+	breakerThreshold int
+	breakerCooldown  time.Duration
+
+	failures    int
+	interval    time.Duration
+	nextAttempt time.Time
+
+	// limited is set while the TCA turns down refills because of its rate limits
+	limited bool
+}
+
+func newTCertPoolBackoff(conf *configuration) *tCertPoolBackoff {
+	return &tCertPoolBackoff{
+		initial:          conf.tCertBackoffInitial,
+		multiplier:       conf.tCertBackoffMultiplier,
+		max:              conf.tCertBackoffMax,
+		jitter:           conf.tCertBackoffJitter,
+		breakerThreshold: conf.tCertBreakerThreshold,
+		breakerCooldown:  conf.tCertBreakerCooldown,
+	}
+}
+
+// ready returns true if a refill can be attempted now
+func (backoff *tCertPoolBackoff) ready() bool {
+	backoff.m.Lock()
+	defer backoff.m.Unlock()
+
+	return !time.Now().Before(backoff.nextAttempt)
+}
+
+// isOpen returns true if the circuit is open, that is the TCA
+// failed too many times in a row and no refill is allowed
+func (backoff *tCertPoolBackoff) isOpen() bool {
+	backoff.m.Lock()
+	defer backoff.m.Unlock()
+
+	return backoff.breakerThreshold > 0 &&
+		backoff.failures >= backoff.breakerThreshold &&
+		time.Now().Before(backoff.nextAttempt)
+}
+
+// success records a successful refill and resets the backoff
+func (backoff *tCertPoolBackoff) success() {
+	backoff.m.Lock()
+	defer backoff.m.Unlock()
+
+	backoff.failures = 0
+	backoff.interval = 0
+	backoff.limited = false
+	backoff.nextAttempt = time.Time{}
+}
+
+// failure records a failed refill and schedules the next attempt
+func (backoff *tCertPoolBackoff) failure() {
+	backoff.m.Lock()
+	defer backoff.m.Unlock()
+
+	backoff.failures++
+	backoff.limited = false
+
+	if backoff.breakerThreshold > 0 && backoff.failures >= backoff.breakerThreshold {
+		backoff.nextAttempt = time.Now().Add(backoff.breakerCooldown)
+
+		return
+	}
+
+	backoff.delay()
+}
+
+// rateLimited records a refill turned down by the TCA because of its rate
+// limits. The next attempt is delayed as after a failure, but the circuit
+// stays closed since the TCA is up.
+func (backoff *tCertPoolBackoff) rateLimited() {
+	backoff.m.Lock()
+	defer backoff.m.Unlock()
+
+	backoff.limited = true
+	backoff.delay()
+}
+
+// isRateLimited returns true if refills are postponed because of the rate
+// limits of the TCA
+func (backoff *tCertPoolBackoff) isRateLimited() bool {
+	backoff.m.Lock()
+	defer backoff.m.Unlock()
+
+	return backoff.limited && time.Now().Before(backoff.nextAttempt)
+}
+
+// delay grows the backoff interval and schedules the next attempt after
+// it. It must be invoked holding the lock.
+func (backoff *tCertPoolBackoff) delay() {
+	if backoff.interval == 0 {
+		backoff.interval = backoff.initial
+	} else {
+		backoff.interval = time.Duration(float64(backoff.interval) * backoff.multiplier)
+	}
+	if backoff.interval > backoff.max {
+		backoff.interval = backoff.max
+	}
+
+	// Randomize in [interval * (1 - jitter), interval * (1 + jitter)]
+	delay := float64(backoff.interval) * (1 + backoff.jitter*(2*rand.Float64()-1))
+	backoff.nextAttempt = time.Now().Add(time.Duration(delay))
+}
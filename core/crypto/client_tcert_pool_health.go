@@ -0,0 +1,127 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// TCertPoolHealthStatus is the outcome of the health checks of a TCert pool.
+// A check verifies a sample of the buffered TCerts against the TCA chain and
+// their signing keys. If any of them is corrupted, for instance after the
+// keystore has been restored from a backup, the pool discards all its TCerts
+// and refills from the TCA.
+type TCertPoolHealthStatus struct {
+	// Healthy is false if the last check found corrupted TCerts
+	Healthy bool
+
+	// LastCheck is the time of the last check, zero if none was performed yet
+	LastCheck time.Time
+
+	// Checked and Corrupted are the number of TCerts verified by the last
+	// check and the number of those found corrupted
+	Checked   int
+	Corrupted int
+
+	// Repairs is the number of times the pool has been flushed
+	Repairs int
+}
+
+// tCertPoolHealth tracks the health checks of a pool
+type tCertPoolHealth struct {
+	m      sync.Mutex
+	status TCertPoolHealthStatus
+}
+
+// due returns true, and marks the check as performed, if the last
+// check is older than period
+func (health *tCertPoolHealth) due(period time.Duration) bool {
+	health.m.Lock()
+	defer health.m.Unlock()
+
+	now := time.Now()
+	if now.Sub(health.status.LastCheck) < period {
+		return false
+	}
+	health.status.LastCheck = now
+
+	return true
+}
+
+func (health *tCertPoolHealth) record(checked, corrupted int) {
+	health.m.Lock()
+	defer health.m.Unlock()
+
+	health.status.Checked = checked
+	health.status.Corrupted = corrupted
+}
+
+func (health *tCertPoolHealth) repaired() {
+	health.m.Lock()
+	defer health.m.Unlock()
+
+	health.status.Repairs++
+}
+
+func (health *tCertPoolHealth) get() TCertPoolHealthStatus {
+	health.m.Lock()
+	defer health.m.Unlock()
+
+	status := health.status
+	status.Healthy = status.Corrupted == 0
+
+	return status
+}
+
+// checkTCert verifies that tCert is signed by the TCA and that its
+// signing key matches its public key
+func (client *clientImpl) checkTCert(tCert TCert) error {
+	impl, ok := tCert.(*tCertImpl)
+	if !ok || impl.sk == nil {
+		return utils.ErrInvalidKey
+	}
+
+	// Keys held by an HSM are checked through their public part
+	if key, ok := impl.sk.(*hsmPrivateKey); ok {
+		pub, ok := impl.cert.PublicKey.(*ecdsa.PublicKey)
+		if !ok || pub.X.Cmp(key.pub.X) != 0 || pub.Y.Cmp(key.pub.Y) != 0 {
+			return utils.ErrInvalidKey
+		}
+		_, err := client.verifyCertificate(impl.cert, client.tcaCertPool)
+
+		return err
+	}
+
+	return client.verifyCertificateAndKey(impl.cert, impl.sk, client.tcaCertPool)
+}
+
+// discardUnusedTCerts removes from the keystore the unused TCerts
+// without giving them back to the pool
+func (client *clientImpl) discardUnusedTCerts() {
+	tCertDERs, err := client.loadUnusedTCerts()
+	if err != nil {
+		client.error("Failed discarding unused TCerts: [%s]", err)
+
+		return
+	}
+
+	client.debug("Discarded [%d] unused TCerts.", len(tCertDERs))
+}
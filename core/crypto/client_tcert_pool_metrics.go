@@ -0,0 +1,114 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// TCertPoolMetrics receives the measurements of the TCert pools so that
+// they can be exported to a monitoring system. Every measurement is
+// labelled with the name of the client owning the pool.
+// Implementations must be safe for concurrent use.
+type TCertPoolMetrics interface {
+	// SetPoolSize reports the number of TCerts currently buffered
+	SetPoolSize(client string, size int)
+
+	// IncRefills counts a successful refill from the TCA
+	IncRefills(client string)
+
+	// IncRefillFailures counts a failed refill from the TCA
+	IncRefillFailures(client string)
+
+	// ObserveGetNextTCert reports how long getting a TCert took
+	ObserveGetNextTCert(client string, latency time.Duration)
+
+	// IncTimeouts counts the requests for a TCert that timed out
+	IncTimeouts(client string)
+
+	// IncEvictions counts the TCerts discarded because expired
+	// or about to expire
+	IncEvictions(client string)
+}
+
+var (
+	tCertPoolMetrics     TCertPoolMetrics = noopTCertPoolMetrics{}
+	tCertPoolMetricsLock sync.RWMutex
+)
+
+// SetTCertPoolMetrics sets the receiver of the TCert pools measurements.
+// Passing nil discards them, which is the default.
+func SetTCertPoolMetrics(metrics TCertPoolMetrics) {
+	tCertPoolMetricsLock.Lock()
+	defer tCertPoolMetricsLock.Unlock()
+
+	if metrics == nil {
+		metrics = noopTCertPoolMetrics{}
+	}
+	tCertPoolMetrics = metrics
+}
+
+func getTCertPoolMetrics() TCertPoolMetrics {
+	tCertPoolMetricsLock.RLock()
+	defer tCertPoolMetricsLock.RUnlock()
+
+	return tCertPoolMetrics
+}
+
+type noopTCertPoolMetrics struct{}
+
+func (noopTCertPoolMetrics) SetPoolSize(client string, size int)                      {}
+func (noopTCertPoolMetrics) IncRefills(client string)                                 {}
+func (noopTCertPoolMetrics) IncRefillFailures(client string)                          {}
+func (noopTCertPoolMetrics) ObserveGetNextTCert(client string, latency time.Duration) {}
+func (noopTCertPoolMetrics) IncTimeouts(client string)                                {}
+func (noopTCertPoolMetrics) IncEvictions(client string)                               {}
+
+// tCertPoolMeteredImpl wraps a TCertPool to measure the requests for TCerts
+type tCertPoolMeteredImpl struct {
+	TCertPool
+
+	client *clientImpl
+}
+
+func newTCertPoolMetered(client *clientImpl, tCertPool TCertPool) TCertPool {
+	return &tCertPoolMeteredImpl{TCertPool: tCertPool, client: client}
+}
+
+func (tCertPool *tCertPoolMeteredImpl) GetNextTCert(attributes ...string) (TCert, error) {
+	defer tCertPool.observe(time.Now())
+
+	return tCertPool.TCertPool.GetNextTCert(attributes...)
+}
+
+func (tCertPool *tCertPoolMeteredImpl) GetNextTCertContext(ctx context.Context, attributes ...string) (TCert, error) {
+	defer tCertPool.observe(time.Now())
+
+	tCert, err := tCertPool.TCertPool.GetNextTCertContext(ctx, attributes...)
+	if err == context.DeadlineExceeded {
+		getTCertPoolMetrics().IncTimeouts(tCertPool.client.GetName())
+	}
+
+	return tCert, err
+}
+
+func (tCertPool *tCertPoolMeteredImpl) observe(start time.Time) {
+	getTCertPoolMetrics().ObserveGetNextTCert(tCertPool.client.GetName(), time.Since(start))
+}
@@ -17,21 +17,149 @@ limitations under the License.
 package crypto
 
 import (
-	"errors"
+	"fmt"
+	"sync"
 	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/net/context"
+)
+
+const (
+	// tCertPoolWaitPeriod is how long GetNextTCert waits on an empty buffer
+	// before logging, tCertPoolWaitRetries how many times it does so.
+	tCertPoolWaitPeriod  = 30 * time.Second
+	tCertPoolWaitRetries = 3
+
+	// tCertPoolFillerPeriod is how often the filler checks the sub-pools
+	tCertPoolFillerPeriod = 1 * time.Second
+
+	// tCertPoolPrefetchPeriod is how often Prefetch checks a sub-pool
+	tCertPoolPrefetchPeriod = 100 * time.Millisecond
 )
 
+// tCertSubPool buffers the TCerts carrying a given set of attributes
+type tCertSubPool struct {
+	attributes   []string
+	tCertChannel chan TCert
+	sizer        *tCertPoolSizer
+
+	// refillErr is the outcome of the last refill
+	refillErr     error
+	refillErrLock sync.Mutex
+
+	// prefetch is the size requested by pending calls to Prefetch,
+	// reserve the size configured for the chaincodes using the sub-pool
+	prefetch     int
+	reserve      int
+	prefetchLock sync.Mutex
+}
+
+func (subPool *tCertSubPool) setRefillErr(err error) {
+	subPool.refillErrLock.Lock()
+	defer subPool.refillErrLock.Unlock()
+
+	subPool.refillErr = err
+}
+
+func (subPool *tCertSubPool) getRefillErr() error {
+	subPool.refillErrLock.Lock()
+	defer subPool.refillErrLock.Unlock()
+
+	return subPool.refillErr
+}
+
+func (subPool *tCertSubPool) setPrefetch(n int) {
+	subPool.prefetchLock.Lock()
+	defer subPool.prefetchLock.Unlock()
+
+	subPool.prefetch = n
+}
+
+// getPrefetch returns the size the sub-pool must be kept at, regardless
+// of the demand
+func (subPool *tCertSubPool) getPrefetch() int {
+	subPool.prefetchLock.Lock()
+	defer subPool.prefetchLock.Unlock()
+
+	if subPool.reserve > subPool.prefetch {
+		return subPool.reserve
+	}
+	return subPool.prefetch
+}
+
+func (subPool *tCertSubPool) setReserve(n int) {
+	subPool.prefetchLock.Lock()
+	defer subPool.prefetchLock.Unlock()
+
+	if n > subPool.reserve {
+		subPool.reserve = n
+	}
+}
+
 // The Multi-threaded tCertPool is currently not used.
 // It plays only a role in testing.
 type tCertPoolMultithreadingImpl struct {
 	client *clientImpl
 
-	tCertChannel         chan tCert
+	// subPools maps attributes keys to sub-pools
+	subPools             map[string]*tCertSubPool
+	subPoolsLock         sync.Mutex
 	tCertChannelFeedback chan struct{}
-	done                 chan struct{}
+
+	// backoff paces the refills when the TCA fails
+	backoff *tCertPoolBackoff
+
+	// health tracks the checks of the buffered TCerts
+	health tCertPoolHealth
+
+	// manager, if not nil, fills the pool in place of its own filler.
+	// quota bounds then the number of TCerts buffered by the pool.
+	manager   *tCertPoolManager
+	quota     int
+	lastSweep time.Time
+
+	// ctx is cancelled by Stop to terminate the filler,
+	// which closes stopped upon exit.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped chan struct{}
+
+	// addLock keeps the TCerts of a batch together. overflow is set
+	// when TCerts are stored in the keystore for lack of room.
+	addLock  sync.Mutex
+	overflow bool
+
+	// callers tracks the calls in progress so that Stop can wait for
+	// them to return before storing the unused TCerts
+	callers     sync.WaitGroup
+	callersLock sync.RWMutex
+}
+
+func newTCertPoolMultithreading(client TCertPoolClient) (TCertPool, error) {
+	c, ok := client.(*clientImpl)
+	if !ok {
+		return nil, utils.ErrInvalidReference
+	}
+
+	tCertPool := new(tCertPoolMultithreadingImpl)
+	if err := tCertPool.init(c); err != nil {
+		return nil, err
+	}
+
+	return tCertPool, nil
 }
 
 func (tCertPool *tCertPoolMultithreadingImpl) Start() (err error) {
+	if tCertPool.manager != nil {
+		if err = tCertPool.manager.register(tCertPool); err != nil {
+			return
+		}
+		tCertPool.loadUnusedTCerts()
+
+		return
+	}
+
 	// Start the filler
 	go tCertPool.filler()
 
@@ -39,54 +167,102 @@ func (tCertPool *tCertPoolMultithreadingImpl) Start() (err error) {
 }
 
 func (tCertPool *tCertPoolMultithreadingImpl) Stop() (err error) {
-	// Stop the filler
-	tCertPool.done <- struct{}{}
+	// Reject new calls and wake up the blocked ones
+	tCertPool.callersLock.Lock()
+	tCertPool.cancel()
+	tCertPool.callersLock.Unlock()
+	tCertPool.callers.Wait()
+
+	// Stop the filler and wait for it to quit
+	if tCertPool.manager != nil {
+		tCertPool.manager.unregister(tCertPool)
+	} else {
+		<-tCertPool.stopped
+	}
 
 	// Store unused TCert
 	tCertPool.client.debug("Store unused TCerts...")
 
-	tCerts := []tCert{}
-	for {
-		if len(tCertPool.tCertChannel) > 0 {
-			tCerts = append(tCerts, <-tCertPool.tCertChannel)
-		} else {
-			break
+	tCerts := []TCert{}
+	for _, subPool := range tCertPool.getSubPools() {
+		for len(subPool.tCertChannel) > 0 {
+			tCerts = append(tCerts, <-subPool.tCertChannel)
 		}
 	}
 
 	tCertPool.client.debug("Found %d unused TCerts...", len(tCerts))
 
-	tCertPool.client.ks.storeUnusedTCerts(tCerts)
+	tCertPool.client.storeUnusedTCerts(tCerts)
 
 	tCertPool.client.debug("Store unused TCerts...done!")
 
 	return
 }
 
-func (tCertPool *tCertPoolMultithreadingImpl) GetNextTCert() (tCert tCert, err error) {
-	for i := 0; i < 3; i++ {
-		tCertPool.client.debug("Getting next TCert... %d out of 3", i)
-		select {
-		case tCert = <-tCertPool.tCertChannel:
-			break
-		case <-time.After(30 * time.Second):
-			tCertPool.client.error("Failed getting a new TCert. Buffer is empty!")
-
-			//return nil, errors.New("Failed getting a new TCert. Buffer is empty!")
-		}
-		if tCert != nil {
-			// Send feedback to the filler
-			tCertPool.tCertChannelFeedback <- struct{}{}
-			break
+func (tCertPool *tCertPoolMultithreadingImpl) GetNextTCert(attributes ...string) (tCert TCert, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tCertPoolWaitRetries*tCertPoolWaitPeriod)
+	defer cancel()
+
+	tCert, err = tCertPool.GetNextTCertContext(ctx, attributes...)
+	if err == context.DeadlineExceeded {
+		// Waited long enough
+		getTCertPoolMetrics().IncTimeouts(tCertPool.client.GetName())
+		subPool := tCertPool.getSubPool(tCertPool.client.getRequestedTCertAttributeNames(attributes))
+		if subPool.getRefillErr() == utils.ErrTCAUnreachable {
+			return nil, utils.ErrTCAUnreachable
 		}
+
+		return nil, utils.ErrPoolExhausted
 	}
 
-	if tCert == nil {
-		// TODO: change error here
-		return nil, errors.New("Failed getting a new TCert. Buffer is empty!")
+	return
+}
+
+func (tCertPool *tCertPoolMultithreadingImpl) GetNextTCertContext(ctx context.Context, attributes ...string) (tCert TCert, err error) {
+	if err = tCertPool.enter(); err != nil {
+		return nil, err
+	}
+	defer tCertPool.callers.Done()
+
+	if _, err = tCertPool.client.getTCertAttributes(attributes); err != nil {
+		return nil, err
+	}
+
+	subPool := tCertPool.getSubPool(tCertPool.client.getRequestedTCertAttributeNames(attributes))
+	subPool.sizer.recordRequest()
+
+	// Fail fast if there is nothing to wait for
+	if len(subPool.tCertChannel) == 0 && tCertPool.backoff.isOpen() && !tCertPool.client.hasReservedTCerts(subPool.attributes) {
+		return nil, utils.ErrTCAUnreachable
+	}
+
+	for i := 0; tCert == nil; i++ {
+		tCertPool.client.debug("Getting next TCert... attempt %d", i)
+		select {
+		case tCert = <-subPool.tCertChannel:
+			// Send feedback to the filler
+			select {
+			case tCertPool.tCertChannelFeedback <- struct{}{}:
+			default:
+			}
+
+			if !tCertPool.client.isTCertUsable(tCert) {
+				tCertPool.client.evictTCert(tCert)
+				tCert = nil
+			}
+		case <-ctx.Done():
+			tCertPool.client.error("Failed getting a new TCert [%s]", ctx.Err())
+
+			return nil, ctx.Err()
+		case <-tCertPool.ctx.Done():
+			return nil, utils.ErrPoolStopped
+		case <-time.After(tCertPoolWaitPeriod):
+			tCertPool.client.error("Failed getting a new TCert. Buffer is empty!")
+		}
 	}
 
 	tCertPool.client.debug("Cert [% x].", tCert.GetCertificate().Raw)
+	getTCertPoolMetrics().SetPoolSize(tCertPool.client.GetName(), tCertPool.size())
 
 	// Store the TCert permanently
 	tCertPool.client.ks.storeUsedTCert(tCert)
@@ -96,108 +272,462 @@ func (tCertPool *tCertPoolMultithreadingImpl) GetNextTCert() (tCert tCert, err e
 	return
 }
 
-func (tCertPool *tCertPoolMultithreadingImpl) AddTCert(tCert tCert) (err error) {
+func (tCertPool *tCertPoolMultithreadingImpl) Prefetch(ctx context.Context, n int, attributes ...string) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if err = tCertPool.enter(); err != nil {
+		return err
+	}
+	defer tCertPool.callers.Done()
+
+	if _, err = tCertPool.client.getTCertAttributes(attributes); err != nil {
+		return err
+	}
+
+	subPool := tCertPool.getSubPool(tCertPool.client.getRequestedTCertAttributeNames(attributes))
+	if n > cap(subPool.tCertChannel) {
+		return fmt.Errorf("Cannot prefetch [%d] TCerts, pool capacity is [%d]", n, cap(subPool.tCertChannel))
+	}
+	if tCertPool.quota > 0 && n > tCertPool.quota {
+		return fmt.Errorf("Cannot prefetch [%d] TCerts, pool quota is [%d]", n, tCertPool.quota)
+	}
+
+	// Have the filler fill the sub-pool up to n
+	if n > subPool.getPrefetch() {
+		subPool.setPrefetch(n)
+	}
+	defer subPool.setPrefetch(0)
+
+	ticker := time.NewTicker(tCertPoolPrefetchPeriod)
+	defer ticker.Stop()
+	for len(subPool.tCertChannel) < n {
+		// Wake up the filler
+		select {
+		case tCertPool.tCertChannelFeedback <- struct{}{}:
+		default:
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			tCertPool.client.error("Failed prefetching [%d] TCerts [%s]", n, ctx.Err())
+
+			return ctx.Err()
+		case <-tCertPool.ctx.Done():
+			return utils.ErrPoolStopped
+		}
+	}
+
+	return
+}
+
+// enter registers a call in progress. It fails with
+// utils.ErrPoolStopped once Stop has been invoked.
+func (tCertPool *tCertPoolMultithreadingImpl) enter() error {
+	tCertPool.callersLock.RLock()
+	defer tCertPool.callersLock.RUnlock()
+
+	if tCertPool.ctx.Err() != nil {
+		return utils.ErrPoolStopped
+	}
+	tCertPool.callers.Add(1)
+
+	return nil
+}
+
+func (tCertPool *tCertPoolMultithreadingImpl) AddTCert(tCert TCert) (err error) {
 	tCertPool.client.debug("New TCert added.")
-	tCertPool.tCertChannel <- tCert
+
+	if !tCertPool.client.isTCertUsable(tCert) {
+		tCertPool.client.evictTCert(tCert)
+
+		return
+	}
+
+	subPool := tCertPool.getSubPool(tCertPool.client.getTCertAttributeNames(tCert))
+	select {
+	case subPool.tCertChannel <- tCert:
+	case <-tCertPool.ctx.Done():
+		return utils.ErrPoolStopped
+	}
 
 	return
 }
 
+func (tCertPool *tCertPoolMultithreadingImpl) AddTCerts(tCerts []TCert) (err error) {
+	tCertPool.client.debug("Adding [%d] new TCerts.", len(tCerts))
+
+	tCertPool.addLock.Lock()
+	defer tCertPool.addLock.Unlock()
+
+	overflow := []TCert{}
+	for _, tCert := range tCerts {
+		if !tCertPool.client.isTCertUsable(tCert) {
+			tCertPool.client.evictTCert(tCert)
+
+			continue
+		}
+
+		// Try to send the tCert to the channel if not full
+		subPool := tCertPool.getSubPool(tCertPool.client.getTCertAttributeNames(tCert))
+		select {
+		case subPool.tCertChannel <- tCert:
+		default:
+			overflow = append(overflow, tCert)
+		}
+	}
+
+	if len(overflow) != 0 {
+		tCertPool.client.debug("Channel full! Storing [%d] TCerts.", len(overflow))
+
+		// Put aside what did not fit
+		if err = tCertPool.client.storeUnusedTCerts(overflow); err != nil {
+			return
+		}
+		tCertPool.overflow = true
+	}
+
+	return
+}
+
+// hasOverflow returns true if TCerts have been stored
+// in the keystore since they were last loaded
+func (tCertPool *tCertPoolMultithreadingImpl) hasOverflow() bool {
+	tCertPool.addLock.Lock()
+	defer tCertPool.addLock.Unlock()
+
+	return tCertPool.overflow
+}
+
 func (tCertPool *tCertPoolMultithreadingImpl) init(client *clientImpl) (err error) {
 	tCertPool.client = client
 
-	tCertPool.tCertChannel = make(chan tCert, client.conf.getTCertBatchSize()*2)
+	tCertPool.subPools = make(map[string]*tCertSubPool)
 	tCertPool.tCertChannelFeedback = make(chan struct{}, client.conf.getTCertBatchSize()*2)
-	tCertPool.done = make(chan struct{})
+	tCertPool.ctx, tCertPool.cancel = context.WithCancel(context.Background())
+	tCertPool.stopped = make(chan struct{})
+	tCertPool.backoff = newTCertPoolBackoff(client.conf)
+
+	// The sub-pool of TCerts carrying the configured attributes is always there
+	tCertPool.getSubPool(client.getRequestedTCertAttributeNames(nil))
+
+	// So are those of the chaincodes
+	for chaincodeID, pool := range client.conf.getTCertChaincodePools() {
+		if _, err := client.getTCertAttributes(pool.attributes); err != nil {
+			client.error("Invalid TCert pool for chaincode [%s]: [%s]", chaincodeID, err)
+
+			return err
+		}
+
+		subPool := tCertPool.getSubPool(client.getRequestedTCertAttributeNames(pool.attributes))
+		if pool.size > cap(subPool.tCertChannel) {
+			subPool.tCertChannel = make(chan TCert, pool.size)
+		}
+		subPool.setReserve(pool.size)
+	}
+
+	return
+}
+
+// getSubPool returns the sub-pool of TCerts carrying the attributes named
+// attributeNames, creating it if it does not exist yet.
+func (tCertPool *tCertPoolMultithreadingImpl) getSubPool(attributeNames []string) *tCertSubPool {
+	tCertPool.subPoolsLock.Lock()
+	defer tCertPool.subPoolsLock.Unlock()
+
+	key := tCertAttributesKey(attributeNames)
+	subPool, ok := tCertPool.subPools[key]
+	if !ok {
+		tCertPool.client.debug("New TCert sub-pool for attributes [%s].", key)
+
+		sizer := newTCertPoolSizer(tCertPool.client.conf)
+		subPool = &tCertSubPool{
+			attributes:   attributeNames,
+			tCertChannel: make(chan TCert, sizer.capacity()),
+			sizer:        sizer,
+		}
+		tCertPool.subPools[key] = subPool
+	}
+
+	return subPool
+}
+
+func (tCertPool *tCertPoolMultithreadingImpl) getSubPools() []*tCertSubPool {
+	tCertPool.subPoolsLock.Lock()
+	defer tCertPool.subPoolsLock.Unlock()
+
+	subPools := make([]*tCertSubPool, 0, len(tCertPool.subPools))
+	for _, subPool := range tCertPool.subPools {
+		subPools = append(subPools, subPool)
+	}
+
+	return subPools
+}
+
+// size returns the number of TCerts buffered by all the sub-pools
+func (tCertPool *tCertPoolMultithreadingImpl) size() (size int) {
+	for _, subPool := range tCertPool.getSubPools() {
+		size += len(subPool.tCertChannel)
+	}
 
 	return
 }
 
 func (tCertPool *tCertPoolMultithreadingImpl) filler() {
-	// Load unused TCerts
-	stop := false
-	full := false
+	defer close(tCertPool.stopped)
+
+	tCertPool.loadUnusedTCerts()
+
+	ticker := time.NewTicker(tCertPoolFillerPeriod)
+	defer ticker.Stop()
+	sweeper := time.NewTicker(tCertPool.client.conf.getTCertExpirySweep())
+	defer sweeper.Stop()
 	for {
-		// Check if Stop was called
 		select {
-		case <-tCertPool.done:
-			tCertPool.client.debug("Force stop!")
-			stop = true
-		default:
-		}
-		if stop {
-			break
+		case <-tCertPool.ctx.Done():
+			tCertPool.client.debug("Quitting filler...")
+			tCertPool.client.debug("TCert filler stopped.")
+
+			return
+		case <-tCertPool.tCertChannelFeedback:
+			tCertPool.client.debug("Feedback received. Time to check for tcerts")
+		case <-ticker.C:
+			tCertPool.client.debug("Time elapsed. Time to check for tcerts")
+
+			tCertPool.updateSizers()
+			tCertPool.checkHealth()
+		case <-sweeper.C:
+			tCertPool.client.debug("Time to evict expiring and revoked tcerts")
+
+			tCertPool.client.pollTCertRevocations(tCertPool.ctx)
+
+			for _, subPool := range tCertPool.getSubPools() {
+				tCertPool.sweep(subPool)
+			}
 		}
 
-		tCertDER, err := tCertPool.client.ks.loadUnusedTCert()
-		if err != nil {
-			tCertPool.client.error("Failed loading TCert: [%s]", err)
-			break
+		tCertPool.fill()
+	}
+}
+
+// fill refills the sub-pools running low, one refill each
+func (tCertPool *tCertPoolMultithreadingImpl) fill() {
+	for _, subPool := range tCertPool.getSubPools() {
+		tCertPool.refill(subPool)
+	}
+	getTCertPoolMetrics().SetPoolSize(tCertPool.client.GetName(), tCertPool.size())
+}
+
+func (tCertPool *tCertPoolMultithreadingImpl) updateSizers() {
+	for _, subPool := range tCertPool.getSubPools() {
+		subPool.sizer.update(tCertPoolFillerPeriod)
+	}
+}
+
+// sweepIfDue evicts the expiring and revoked TCerts if the last sweep is older than
+// the configured sweep period
+func (tCertPool *tCertPoolMultithreadingImpl) sweepIfDue() {
+	if time.Since(tCertPool.lastSweep) < tCertPool.client.conf.getTCertExpirySweep() {
+		return
+	}
+	tCertPool.lastSweep = time.Now()
+
+	tCertPool.client.pollTCertRevocations(tCertPool.ctx)
+
+	for _, subPool := range tCertPool.getSubPools() {
+		tCertPool.sweep(subPool)
+	}
+}
+
+func (tCertPool *tCertPoolMultithreadingImpl) HealthStatus() TCertPoolHealthStatus {
+	return tCertPool.health.get()
+}
+
+// checkHealth verifies a sample of the TCerts of every sub-pool, if the last
+// check is older than the configured period. If any of them is corrupted,
+// the pool is flushed and refilled from the TCA.
+func (tCertPool *tCertPoolMultithreadingImpl) checkHealth() {
+	if !tCertPool.health.due(tCertPool.client.conf.getTCertHealthPeriod()) {
+		return
+	}
+
+	checked, corrupted := 0, 0
+	for _, subPool := range tCertPool.getSubPools() {
+	sample:
+		for i := tCertPool.client.conf.getTCertHealthSamples(); i > 0; i-- {
+			var tCert TCert
+			select {
+			case tCert = <-subPool.tCertChannel:
+			default:
+				// Drained by the consumers
+				break sample
+			}
+
+			checked++
+			if err := tCertPool.client.checkTCert(tCert); err != nil {
+				tCertPool.client.error("Corrupted TCert [%s]: [%s]", tCert.GetCertificate().SerialNumber, err)
+				corrupted++
+
+				continue
+			}
+
+			// Put it back at the end, the next check samples the following ones
+			select {
+			case subPool.tCertChannel <- tCert:
+			default:
+				tCertPool.AddTCerts([]TCert{tCert})
+			}
 		}
-		if tCertDER == nil {
-			tCertPool.client.debug("No more TCerts in cache!")
-			break
+	}
+	tCertPool.health.record(checked, corrupted)
+
+	if corrupted == 0 {
+		return
+	}
+
+	tCertPool.client.warning("Found [%d] corrupted TCerts out of [%d]. Flushing the pool...", corrupted, checked)
+
+	tCertPool.flush()
+	tCertPool.health.repaired()
+}
+
+// flush discards all the TCerts of the pool, including
+// those put aside in the keystore
+func (tCertPool *tCertPoolMultithreadingImpl) flush() {
+	tCertPool.addLock.Lock()
+	defer tCertPool.addLock.Unlock()
+
+	for _, subPool := range tCertPool.getSubPools() {
+		for len(subPool.tCertChannel) > 0 {
+			select {
+			case <-subPool.tCertChannel:
+			default:
+			}
 		}
+	}
+	tCertPool.overflow = false
+	tCertPool.client.discardUnusedTCerts()
+}
+
+func (tCertPool *tCertPoolMultithreadingImpl) loadUnusedTCerts() {
+	tCertPool.addLock.Lock()
+	tCertPool.overflow = false
+	tCertPool.addLock.Unlock()
 
+	// Load unused TCerts
+	tCertDERs, err := tCertPool.client.loadUnusedTCerts()
+	if err != nil {
+		tCertPool.client.error("Failed loading TCerts: [%s]", err)
+	}
+
+	tCerts := []TCert{}
+	for _, tCertDER := range tCertDERs {
 		tCert, err := tCertPool.client.getTCertFromDER(tCertDER)
 		if err != nil {
 			tCertPool.client.error("Failed paring TCert [% x]: [%s]", tCertDER, err)
 
 			continue
 		}
+		tCerts = append(tCerts, tCert)
+	}
 
-		// Try to send the tCert to the channel if not full
-		select {
-		case tCertPool.tCertChannel <- tCert:
-			tCertPool.client.debug("TCert send to the channel!")
-		default:
-			tCertPool.client.debug("Channell Full!")
-			full = true
-		}
-		if full {
-			break
-		}
+	// What does not fit is put back
+	if err := tCertPool.AddTCerts(tCerts); err != nil {
+		tCertPool.client.error("Failed adding TCerts: [%s]", err)
 	}
 
 	tCertPool.client.debug("Load unused TCerts...done!")
+}
 
-	if !stop {
-		ticker := time.NewTicker(1 * time.Second)
-		for {
-			select {
-			case <-tCertPool.done:
-				stop = true
-				tCertPool.client.debug("Done signal.")
-			case <-tCertPool.tCertChannelFeedback:
-				tCertPool.client.debug("Feedback received. Time to check for tcerts")
-			case <-ticker.C:
-				tCertPool.client.debug("Time elapsed. Time to check for tcerts")
-			}
+func (tCertPool *tCertPoolMultithreadingImpl) refill(subPool *tCertSubPool) {
+	prefetch := subPool.getPrefetch()
+	if len(subPool.tCertChannel) >= subPool.sizer.target() && len(subPool.tCertChannel) >= prefetch {
+		return
+	}
 
-			if stop {
-				tCertPool.client.debug("Quitting filler...")
-				break
-			}
+	// Take back first the TCerts put aside
+	if tCertPool.hasOverflow() {
+		tCertPool.loadUnusedTCerts()
 
-			if len(tCertPool.tCertChannel) < tCertPool.client.conf.getTCertBatchSize() {
-				tCertPool.client.debug("Refill TCert Pool. Current size [%d].",
-					len(tCertPool.tCertChannel),
-				)
+		if len(subPool.tCertChannel) >= subPool.sizer.target() && len(subPool.tCertChannel) >= prefetch {
+			return
+		}
+	}
 
-				var numTCerts = cap(tCertPool.tCertChannel) - len(tCertPool.tCertChannel)
-				if len(tCertPool.tCertChannel) == 0 {
-					numTCerts = cap(tCertPool.tCertChannel) / 10
-				}
+	if !tCertPool.backoff.ready() && !tCertPool.client.hasReservedTCerts(subPool.attributes) {
+		tCertPool.client.debug("Refill of TCert Pool [%v] postponed.", subPool.attributes)
 
-				tCertPool.client.info("Refilling [%d] TCerts.", numTCerts)
+		return
+	}
 
-				err := tCertPool.client.getTCertsFromTCA(numTCerts)
-				if err != nil {
-					tCertPool.client.error("Failed getting TCerts from the TCA: [%s]", err)
-				}
-			}
+	tCertPool.client.debug("Refill TCert Pool [%v]. Current size [%d], target size [%d].",
+		subPool.attributes, len(subPool.tCertChannel), subPool.sizer.target(),
+	)
+
+	numTCerts := subPool.sizer.refillSize(len(subPool.tCertChannel))
+	if missing := prefetch - len(subPool.tCertChannel); missing > numTCerts {
+		numTCerts = missing
+	}
+	if tCertPool.quota > 0 {
+		room := tCertPool.quota - tCertPool.size()
+		if room <= 0 {
+			tCertPool.client.debug("Refill of TCert Pool [%v] skipped. Quota [%d] reached.", subPool.attributes, tCertPool.quota)
+
+			return
+		}
+		if numTCerts > room {
+			numTCerts = room
+		}
+	}
+
+	tCertPool.client.info("Refilling [%d] TCerts.", numTCerts)
+
+	start := time.Now()
+	err := tCertPool.client.getTCertsFromTCA(tCertPool.ctx, numTCerts, subPool.attributes)
+	subPool.setRefillErr(err)
+	if err != nil {
+		tCertPool.client.error("Failed getting TCerts from the TCA: [%s]", err)
+
+		switch {
+		case tCertPool.ctx.Err() != nil:
+		case err == utils.ErrRateLimited:
+			tCertPool.backoff.rateLimited()
+		default:
+			tCertPool.backoff.failure()
+			getTCertPoolMetrics().IncRefillFailures(tCertPool.client.GetName())
 		}
+
+		return
 	}
+	tCertPool.backoff.success()
+	getTCertPoolMetrics().IncRefills(tCertPool.client.GetName())
+	subPool.sizer.recordRefill(time.Since(start))
+}
+
+// sweep evicts from subPool the TCerts that are expired, about to expire
+// or revoked
+func (tCertPool *tCertPoolMultithreadingImpl) sweep(subPool *tCertSubPool) {
+	for i := len(subPool.tCertChannel); i > 0; i-- {
+		var tCert TCert
+		select {
+		case tCert = <-subPool.tCertChannel:
+		default:
+			// Drained by the consumers
+			return
+		}
+
+		if !tCertPool.client.isTCertUsable(tCert) {
+			tCertPool.client.evictTCert(tCert)
 
-	tCertPool.client.debug("TCert filler stopped.")
+			continue
+		}
+
+		select {
+		case subPool.tCertChannel <- tCert:
+		default:
+			// Refilled in the meantime, keep it for later
+			tCertPool.AddTCerts([]TCert{tCert})
+		}
+	}
 }
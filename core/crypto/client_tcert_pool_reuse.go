@@ -0,0 +1,174 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// TCertReuseSingle uses every TCert for a single transaction
+	TCertReuseSingle = "single"
+
+	// TCertReuseCount uses every TCert for a given number of transactions
+	TCertReuseCount = "count"
+
+	// TCertReuseTime uses every TCert for a given period of time
+	TCertReuseTime = "time"
+)
+
+// tCertReused is a TCert handed out more than once
+type tCertReused struct {
+	tCert TCert
+	uses  int
+	since time.Time
+}
+
+// tCertFetch is a TCert being obtained from the wrapped pool for an
+// attributes key. The callers asking for the same key meanwhile wait
+// for it instead of obtaining one each.
+type tCertFetch struct {
+	done chan struct{}
+}
+
+// tCertPoolReuseImpl wraps a TCertPool to hand out the same TCert
+// until the configured reuse policy expires it. This trades privacy,
+// since the transactions signed with the same TCert are linkable,
+// for a lower load on the TCA.
+type tCertPoolReuseImpl struct {
+	TCertPool
+
+	client *clientImpl
+
+	// current maps attributes keys to the TCert being reused, fetching
+	// to the TCert being obtained. m is never held while obtaining one.
+	current  map[string]*tCertReused
+	fetching map[string]*tCertFetch
+	stopped  bool
+	m        sync.Mutex
+}
+
+func newTCertPoolReuse(client *clientImpl, tCertPool TCertPool) TCertPool {
+	if client.conf.getTCertReusePolicy() == TCertReuseSingle {
+		return tCertPool
+	}
+
+	return &tCertPoolReuseImpl{
+		TCertPool: tCertPool,
+		client:    client,
+		current:   make(map[string]*tCertReused),
+		fetching:  make(map[string]*tCertFetch),
+	}
+}
+
+func (tCertPool *tCertPoolReuseImpl) Stop() error {
+	tCertPool.m.Lock()
+	tCertPool.stopped = true
+	tCertPool.current = make(map[string]*tCertReused)
+	tCertPool.m.Unlock()
+
+	// The wrapped pool wakes up the callers blocked obtaining a TCert
+	return tCertPool.TCertPool.Stop()
+}
+
+func (tCertPool *tCertPoolReuseImpl) GetNextTCert(attributes ...string) (TCert, error) {
+	return tCertPool.next(context.Background(), attributes, func() (TCert, error) {
+		return tCertPool.TCertPool.GetNextTCert(attributes...)
+	})
+}
+
+func (tCertPool *tCertPoolReuseImpl) GetNextTCertContext(ctx context.Context, attributes ...string) (TCert, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return tCertPool.next(ctx, attributes, func() (TCert, error) {
+		return tCertPool.TCertPool.GetNextTCertContext(ctx, attributes...)
+	})
+}
+
+// next returns the TCert being reused for attributes, if the policy
+// allows it, otherwise a new one obtained by calling get. Only one
+// caller per attributes key calls get at a time, the others wait
+// for it as long as ctx is not done and then reuse its TCert.
+func (tCertPool *tCertPoolReuseImpl) next(ctx context.Context, attributes []string, get func() (TCert, error)) (TCert, error) {
+	key := tCertAttributesKey(tCertPool.client.getRequestedTCertAttributeNames(attributes))
+
+	for {
+		tCertPool.m.Lock()
+		if tCertPool.stopped {
+			tCertPool.m.Unlock()
+
+			// The wrapped pool rejects the call
+			return get()
+		}
+
+		if reused, ok := tCertPool.current[key]; ok {
+			if tCertPool.allows(reused) && tCertPool.client.isTCertUsable(reused.tCert) {
+				reused.uses++
+				tCertPool.m.Unlock()
+
+				return reused.tCert, nil
+			}
+			delete(tCertPool.current, key)
+		}
+
+		fetch, ok := tCertPool.fetching[key]
+		if !ok {
+			break
+		}
+		tCertPool.m.Unlock()
+
+		select {
+		case <-fetch.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// m is still held, register the fetch and obtain the TCert without it
+	fetch := &tCertFetch{done: make(chan struct{})}
+	tCertPool.fetching[key] = fetch
+	tCertPool.m.Unlock()
+
+	tCert, err := get()
+
+	tCertPool.m.Lock()
+	delete(tCertPool.fetching, key)
+	if err == nil && !tCertPool.stopped {
+		tCertPool.current[key] = &tCertReused{tCert: tCert, uses: 1, since: time.Now()}
+	}
+	tCertPool.m.Unlock()
+	close(fetch.done)
+
+	return tCert, err
+}
+
+// allows returns true if the policy allows reused to be used once more
+func (tCertPool *tCertPoolReuseImpl) allows(reused *tCertReused) bool {
+	switch tCertPool.client.conf.getTCertReusePolicy() {
+	case TCertReuseCount:
+		return reused.uses < tCertPool.client.conf.getTCertReuseCount()
+	case TCertReuseTime:
+		return time.Since(reused.since) < tCertPool.client.conf.getTCertReusePeriod()
+	}
+
+	return false
+}
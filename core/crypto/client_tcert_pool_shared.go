@@ -0,0 +1,185 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/net/context"
+)
+
+// tCertPoolSharedManager fills the shared TCert pools of the process
+var tCertPoolSharedManager = newTCertPoolManager()
+
+// tCertPoolManager fills the TCert pools of several clients from a single
+// goroutine. The pools are keyed by the name of their client, that is its
+// enrollment ID, and served in turn so that a hungry client cannot starve
+// the others.
+type tCertPoolManager struct {
+	// m guards pools, next and the filler state
+	m     sync.Mutex
+	pools []*tCertPoolMultithreadingImpl
+	next  int
+
+	// busy is held while the filler works on a pool
+	busy sync.Mutex
+
+	feedback chan struct{}
+	cancel   context.CancelFunc
+	stopped  chan struct{}
+}
+
+func newTCertPoolManager() *tCertPoolManager {
+	return &tCertPoolManager{feedback: make(chan struct{}, 1)}
+}
+
+func newTCertPoolShared(client TCertPoolClient) (TCertPool, error) {
+	c, ok := client.(*clientImpl)
+	if !ok {
+		return nil, utils.ErrInvalidReference
+	}
+
+	tCertPool := new(tCertPoolMultithreadingImpl)
+	if err := tCertPool.init(c); err != nil {
+		return nil, err
+	}
+	tCertPool.manager = tCertPoolSharedManager
+	tCertPool.quota = c.conf.getTCertPoolQuota()
+	tCertPool.tCertChannelFeedback = tCertPoolSharedManager.feedback
+
+	return tCertPool, nil
+}
+
+// register adds tCertPool to the pools to fill, starting the filler
+// if tCertPool is the first one
+func (manager *tCertPoolManager) register(tCertPool *tCertPoolMultithreadingImpl) error {
+	manager.m.Lock()
+	defer manager.m.Unlock()
+
+	name := tCertPool.client.GetName()
+	for _, other := range manager.pools {
+		if other.client.GetName() == name {
+			return fmt.Errorf("Shared TCert pool for [%s] already registered", name)
+		}
+	}
+	manager.pools = append(manager.pools, tCertPool)
+
+	if len(manager.pools) == 1 {
+		var ctx context.Context
+		ctx, manager.cancel = context.WithCancel(context.Background())
+		manager.stopped = make(chan struct{})
+
+		go manager.filler(ctx, manager.stopped)
+	}
+
+	return nil
+}
+
+// unregister removes tCertPool from the pools to fill and waits
+// until the filler is done with it. The filler is stopped when
+// no pool is left.
+func (manager *tCertPoolManager) unregister(tCertPool *tCertPoolMultithreadingImpl) {
+	manager.m.Lock()
+	for i, other := range manager.pools {
+		if other == tCertPool {
+			manager.pools = append(manager.pools[:i], manager.pools[i+1:]...)
+
+			break
+		}
+	}
+
+	var stopped chan struct{}
+	if len(manager.pools) == 0 && manager.cancel != nil {
+		manager.cancel()
+		manager.cancel = nil
+		stopped = manager.stopped
+	}
+	manager.m.Unlock()
+
+	if stopped != nil {
+		<-stopped
+	}
+
+	// Wait for the filler to leave tCertPool
+	manager.busy.Lock()
+	manager.busy.Unlock()
+}
+
+// round returns the pools to fill, starting from the one
+// following the first served in the previous round
+func (manager *tCertPoolManager) round() []*tCertPoolMultithreadingImpl {
+	manager.m.Lock()
+	defer manager.m.Unlock()
+
+	round := make([]*tCertPoolMultithreadingImpl, 0, len(manager.pools))
+	if len(manager.pools) == 0 {
+		return round
+	}
+
+	start := manager.next % len(manager.pools)
+	manager.next = start + 1
+	round = append(round, manager.pools[start:]...)
+	round = append(round, manager.pools[:start]...)
+
+	return round
+}
+
+func (manager *tCertPoolManager) isRegistered(tCertPool *tCertPoolMultithreadingImpl) bool {
+	manager.m.Lock()
+	defer manager.m.Unlock()
+
+	for _, other := range manager.pools {
+		if other == tCertPool {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (manager *tCertPoolManager) filler(ctx context.Context, stopped chan struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(tCertPoolFillerPeriod)
+	defer ticker.Stop()
+	for {
+		tick := false
+		select {
+		case <-ctx.Done():
+			return
+		case <-manager.feedback:
+		case <-ticker.C:
+			tick = true
+		}
+
+		for _, tCertPool := range manager.round() {
+			manager.busy.Lock()
+			if manager.isRegistered(tCertPool) && tCertPool.ctx.Err() == nil {
+				if tick {
+					tCertPool.updateSizers()
+					tCertPool.sweepIfDue()
+					tCertPool.checkHealth()
+				}
+				tCertPool.fill()
+			}
+			manager.busy.Unlock()
+		}
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// tCertPoolSizerSmoothing is the weight given to the most recent sample
+	// by the exponentially weighted moving averages of the sizer
+	tCertPoolSizerSmoothing = 0.3
+
+	// tCertPoolSizerHeadroom is the number of refill rounds the target size
+	// of the pool is meant to cover
+	tCertPoolSizerHeadroom = 2
+)
+
+// tCertPoolSizer adapts the target size of a TCert buffer to the demand.
+// It tracks the rate at which TCerts are requested and the latency of
+// the TCA, and sizes the buffer so that it does not run dry while a
+// refill is in flight.
+type tCertPoolSizer struct {
+	m sync.Mutex
+
+	adaptive bool
+	min, max int
+
+	// Moving averages of requests per second and TCA latency in seconds
+	rate    float64
+	latency float64
+
+	requests   int
+	lastUpdate time.Time
+	current    int
+}
+
+func newTCertPoolSizer(conf *configuration) *tCertPoolSizer {
+	sizer := &tCertPoolSizer{
+		adaptive:   conf.isTCertPoolAdaptive(),
+		min:        conf.getTCertBatchSize(),
+		max:        conf.getTCertBatchSize(),
+		lastUpdate: time.Now(),
+	}
+	if sizer.adaptive {
+		sizer.min = conf.getTCertPoolMinSize()
+		sizer.max = conf.getTCertPoolMaxSize()
+	}
+	sizer.current = sizer.min
+
+	return sizer
+}
+
+// capacity returns the number of TCerts the buffer must be able to hold
+func (sizer *tCertPoolSizer) capacity() int {
+	return sizer.max * 2
+}
+
+// recordRequest records that a TCert has been requested
+func (sizer *tCertPoolSizer) recordRequest() {
+	sizer.m.Lock()
+	defer sizer.m.Unlock()
+
+	sizer.requests++
+}
+
+// recordRefill records how long the TCA took to serve a refill
+func (sizer *tCertPoolSizer) recordRefill(latency time.Duration) {
+	sizer.m.Lock()
+	defer sizer.m.Unlock()
+
+	sizer.latency = ewma(sizer.latency, latency.Seconds())
+}
+
+// update folds the requests recorded since the last call into the
+// request rate and recomputes the target size
+func (sizer *tCertPoolSizer) update(period time.Duration) {
+	sizer.m.Lock()
+	defer sizer.m.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(sizer.lastUpdate).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	sizer.rate = ewma(sizer.rate, float64(sizer.requests)/elapsed)
+	sizer.requests = 0
+	sizer.lastUpdate = now
+
+	if !sizer.adaptive {
+		return
+	}
+
+	// Cover the demand expected while waiting for the next check and
+	// for the TCA to answer
+	target := int(math.Ceil(sizer.rate * (sizer.latency + period.Seconds()) * tCertPoolSizerHeadroom))
+	if target < sizer.min {
+		target = sizer.min
+	}
+	if target > sizer.max {
+		target = sizer.max
+	}
+	sizer.current = target
+}
+
+// target returns the size below which the buffer must be refilled
+func (sizer *tCertPoolSizer) target() int {
+	sizer.m.Lock()
+	defer sizer.m.Unlock()
+
+	return sizer.current
+}
+
+// refillSize returns how many TCerts to request from the TCA when
+// the buffer holds size TCerts
+func (sizer *tCertPoolSizer) refillSize(size int) int {
+	target := sizer.target()
+
+	if size == 0 {
+		// Get something quickly
+		num := target * 2 / 10
+		if num < 1 {
+			num = 1
+		}
+		return num
+	}
+
+	return target*2 - size
+}
+
+func ewma(average, sample float64) float64 {
+	return tCertPoolSizerSmoothing*sample + (1-tCertPoolSizerSmoothing)*average
+}
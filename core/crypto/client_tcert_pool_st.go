@@ -17,16 +17,43 @@ limitations under the License.
 package crypto
 
 import (
-	"fmt"
 	"sync"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/net/context"
 )
 
 type tCertPoolSingleThreadImpl struct {
 	client *clientImpl
 
-	len    int
-	tCerts []tCert
+	// tCerts maps attributes keys to the TCerts carrying those attributes
+	tCerts map[string][]TCert
 	m      sync.Mutex
+
+	// stopping is closed by Stop to reject new calls and
+	// to abort the reload in progress, if any
+	stopping chan struct{}
+	stopOnce sync.Once
+
+	// backoff paces the reloads when the TCA fails
+	backoff *tCertPoolBackoff
+
+	// health tracks the checks of the buffered TCerts
+	health tCertPoolHealth
+}
+
+func newTCertPoolSingleThread(client TCertPoolClient) (TCertPool, error) {
+	c, ok := client.(*clientImpl)
+	if !ok {
+		return nil, utils.ErrInvalidReference
+	}
+
+	tCertPool := new(tCertPoolSingleThreadImpl)
+	if err := tCertPool.init(c); err != nil {
+		return nil, err
+	}
+
+	return tCertPool, nil
 }
 
 func (tCertPool *tCertPoolSingleThreadImpl) Start() (err error) {
@@ -36,7 +63,7 @@ func (tCertPool *tCertPoolSingleThreadImpl) Start() (err error) {
 	tCertPool.client.debug("Starting TCert Pool...")
 
 	// Load unused TCerts if any
-	tCertDERs, err := tCertPool.client.ks.loadUnusedTCerts()
+	tCertDERs, err := tCertPool.client.loadUnusedTCerts()
 	if err != nil {
 		tCertPool.client.error("Failed loading TCerts from cache: [%s]", err)
 
@@ -45,7 +72,7 @@ func (tCertPool *tCertPoolSingleThreadImpl) Start() (err error) {
 	if len(tCertDERs) == 0 {
 		tCertPool.client.debug("No more TCerts in cache! Load new from TCA.")
 
-		tCertPool.client.getTCertsFromTCA(tCertPool.client.conf.getTCertBatchSize())
+		tCertPool.client.getTCertsFromTCA(context.Background(), tCertPool.client.conf.getTCertBatchSize(), nil)
 	} else {
 		tCertPool.client.debug("TCerts in cache found! Loading them...")
 
@@ -64,52 +91,254 @@ func (tCertPool *tCertPoolSingleThreadImpl) Start() (err error) {
 }
 
 func (tCertPool *tCertPoolSingleThreadImpl) Stop() (err error) {
+	// Wake up the caller reloading, if any, before waiting for it
+	tCertPool.stopOnce.Do(func() { close(tCertPool.stopping) })
+
 	tCertPool.m.Lock()
 	defer tCertPool.m.Unlock()
 
-	tCertPool.client.debug("Found %d unused TCerts...", tCertPool.len)
+	tCerts := []TCert{}
+	for _, subPool := range tCertPool.tCerts {
+		tCerts = append(tCerts, subPool...)
+	}
+
+	tCertPool.client.debug("Found %d unused TCerts...", len(tCerts))
 
-	tCertPool.client.ks.storeUnusedTCerts(tCertPool.tCerts[:tCertPool.len])
+	tCertPool.client.storeUnusedTCerts(tCerts)
 
 	tCertPool.client.debug("Store unused TCerts...done!")
 
 	return
 }
 
-func (tCertPool *tCertPoolSingleThreadImpl) GetNextTCert() (tCert tCert, err error) {
+func (tCertPool *tCertPoolSingleThreadImpl) GetNextTCert(attributes ...string) (tCert TCert, err error) {
+	return tCertPool.GetNextTCertContext(context.Background(), attributes...)
+}
+
+func (tCertPool *tCertPoolSingleThreadImpl) GetNextTCertContext(ctx context.Context, attributes ...string) (tCert TCert, err error) {
 	tCertPool.m.Lock()
 	defer tCertPool.m.Unlock()
+	defer tCertPool.reportSize()
 
-	if tCertPool.len <= 0 {
-		// Reload
-		if err := tCertPool.client.getTCertsFromTCA(tCertPool.client.conf.getTCertBatchSize()); err != nil {
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	if tCertPool.isStopping() {
+		return nil, utils.ErrPoolStopped
+	}
 
-			return nil, fmt.Errorf("Failed loading TCerts from TCA")
-		}
+	tCertPool.client.pollTCertRevocations(ctx)
+	tCertPool.checkHealth()
+
+	key := tCertAttributesKey(tCertPool.client.getRequestedTCertAttributeNames(attributes))
+	if tCert = tCertPool.popUsable(key); tCert != nil {
+		return
+	}
+
+	// Reload
+	if err = tCertPool.reload(ctx, tCertPool.client.conf.getTCertBatchSize(), attributes); err != nil {
+		return nil, err
 	}
 
-	tCert = tCertPool.tCerts[tCertPool.len-1]
-	tCertPool.len--
+	if tCert = tCertPool.popUsable(key); tCert == nil {
+		tCertPool.client.error("No valid TCert carrying attributes [%s] received from TCA.", key)
+
+		return nil, utils.ErrPoolExhausted
+	}
 
 	return
 }
 
-func (tCertPool *tCertPoolSingleThreadImpl) AddTCert(tCert tCert) (err error) {
+func (tCertPool *tCertPoolSingleThreadImpl) Prefetch(ctx context.Context, n int, attributes ...string) (err error) {
+	tCertPool.m.Lock()
+	defer tCertPool.m.Unlock()
+	defer tCertPool.reportSize()
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if tCertPool.isStopping() {
+		return utils.ErrPoolStopped
+	}
+	if _, err = tCertPool.client.getTCertAttributes(attributes); err != nil {
+		return err
+	}
+	tCertPool.checkHealth()
+
+	key := tCertAttributesKey(tCertPool.client.getRequestedTCertAttributeNames(attributes))
+	for len(tCertPool.tCerts[key]) < n {
+		size := len(tCertPool.tCerts[key])
+		if err = tCertPool.reload(ctx, n-size, attributes); err != nil {
+			return err
+		}
+		if len(tCertPool.tCerts[key]) <= size {
+			tCertPool.client.error("No valid TCert carrying attributes [%s] received from TCA.", key)
+
+			return utils.ErrPoolExhausted
+		}
+	}
+
+	return
+}
+
+// reload requests num TCerts carrying attributes to the TCA, unless the
+// TCA failed recently and they cannot be minted from the reserve. It must be
+// invoked holding the lock.
+func (tCertPool *tCertPoolSingleThreadImpl) reload(ctx context.Context, num int, attributes []string) error {
+	if !tCertPool.backoff.ready() && !tCertPool.client.hasReservedTCerts(attributes) {
+		if tCertPool.backoff.isRateLimited() {
+			return utils.ErrRateLimited
+		}
+		return utils.ErrTCAUnreachable
+	}
+
+	// Abort if the pool is stopped meanwhile
+	reloadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-tCertPool.stopping:
+			cancel()
+		case <-reloadCtx.Done():
+		}
+	}()
+
+	if err := tCertPool.client.getTCertsFromTCA(reloadCtx, num, attributes); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if tCertPool.isStopping() {
+			return utils.ErrPoolStopped
+		}
+		if err == utils.ErrRateLimited {
+			tCertPool.backoff.rateLimited()
+
+			return err
+		}
+		tCertPool.backoff.failure()
+		getTCertPoolMetrics().IncRefillFailures(tCertPool.client.GetName())
+		if err == utils.ErrTCAUnreachable {
+			return err
+		}
+
+		tCertPool.client.error("Failed loading TCerts from TCA [%s].", err)
+
+		return utils.ErrPoolExhausted
+	}
+	tCertPool.backoff.success()
+	getTCertPoolMetrics().IncRefills(tCertPool.client.GetName())
+
+	return nil
+}
+
+func (tCertPool *tCertPoolSingleThreadImpl) AddTCert(tCert TCert) (err error) {
 	tCertPool.client.debug("Adding new Cert [% x].", tCert.GetCertificate().Raw)
 
-	tCertPool.len++
-	tCertPool.tCerts[tCertPool.len-1] = tCert
+	if !tCertPool.client.isTCertUsable(tCert) {
+		tCertPool.client.evictTCert(tCert)
+
+		return nil
+	}
+
+	key := tCertAttributesKey(tCertPool.client.getTCertAttributeNames(tCert))
+	tCertPool.tCerts[key] = append(tCertPool.tCerts[key], tCert)
 
 	return nil
 }
 
+func (tCertPool *tCertPoolSingleThreadImpl) AddTCerts(tCerts []TCert) (err error) {
+	tCertPool.client.debug("Adding [%d] new TCerts.", len(tCerts))
+
+	for _, tCert := range tCerts {
+		tCertPool.AddTCert(tCert)
+	}
+
+	return nil
+}
+
+func (tCertPool *tCertPoolSingleThreadImpl) HealthStatus() TCertPoolHealthStatus {
+	return tCertPool.health.get()
+}
+
+// checkHealth verifies the TCerts next in line of every sub-pool, if the
+// last check is older than the configured period. If any of them is
+// corrupted, the pool is flushed and reloaded on demand.
+// It must be invoked holding the lock.
+func (tCertPool *tCertPoolSingleThreadImpl) checkHealth() {
+	if !tCertPool.health.due(tCertPool.client.conf.getTCertHealthPeriod()) {
+		return
+	}
+
+	checked, corrupted := 0, 0
+	for _, subPool := range tCertPool.tCerts {
+		for i := len(subPool) - 1; i >= 0 && i >= len(subPool)-tCertPool.client.conf.getTCertHealthSamples(); i-- {
+			checked++
+			if err := tCertPool.client.checkTCert(subPool[i]); err != nil {
+				tCertPool.client.error("Corrupted TCert [%s]: [%s]", subPool[i].GetCertificate().SerialNumber, err)
+				corrupted++
+			}
+		}
+	}
+	tCertPool.health.record(checked, corrupted)
+
+	if corrupted == 0 {
+		return
+	}
+
+	tCertPool.client.warning("Found [%d] corrupted TCerts out of [%d]. Flushing the pool...", corrupted, checked)
+
+	tCertPool.tCerts = make(map[string][]TCert)
+	tCertPool.client.discardUnusedTCerts()
+	tCertPool.health.repaired()
+}
+
 func (tCertPool *tCertPoolSingleThreadImpl) init(client *clientImpl) (err error) {
 	tCertPool.client = client
 
 	tCertPool.client.debug("Init TCert Pool...")
 
-	tCertPool.tCerts = make([]tCert, tCertPool.client.conf.getTCertBatchSize())
-	tCertPool.len = 0
+	tCertPool.tCerts = make(map[string][]TCert)
+	tCertPool.stopping = make(chan struct{})
+	tCertPool.backoff = newTCertPoolBackoff(client.conf)
 
 	return
 }
+
+func (tCertPool *tCertPoolSingleThreadImpl) isStopping() bool {
+	select {
+	case <-tCertPool.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// reportSize reports the number of buffered TCerts.
+// It must be invoked holding the lock.
+func (tCertPool *tCertPoolSingleThreadImpl) reportSize() {
+	size := 0
+	for _, subPool := range tCertPool.tCerts {
+		size += len(subPool)
+	}
+	getTCertPoolMetrics().SetPoolSize(tCertPool.client.GetName(), size)
+}
+
+// popUsable removes and returns the last TCert of the sub-pool identified
+// by key, evicting those that are expired or about to expire.
+// It returns nil if no usable TCert is left.
+func (tCertPool *tCertPoolSingleThreadImpl) popUsable(key string) TCert {
+	subPool := tCertPool.tCerts[key]
+	defer func() { tCertPool.tCerts[key] = subPool }()
+
+	for len(subPool) > 0 {
+		tCert := subPool[len(subPool)-1]
+		subPool = subPool[:len(subPool)-1]
+
+		if tCertPool.client.isTCertUsable(tCert) {
+			return tCert
+		}
+		tCertPool.client.evictTCert(tCert)
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"math/big"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// TCertRevocationFeed tells the clients which TCerts have been revoked,
+// for instance by fetching the CRL published by the TCA
+type TCertRevocationFeed interface {
+	// Revoked returns the serial numbers of the TCerts revoked for
+	// the client named name
+	Revoked(ctx context.Context, name string) ([]*big.Int, error)
+}
+
+var (
+	tCertRevocationFeed     TCertRevocationFeed
+	tCertRevocationFeedLock sync.RWMutex
+)
+
+// SetTCertRevocationFeed sets the feed the clients poll, at every expiry
+// sweep of their TCert pool, for revoked TCerts. Passing nil disables polling,
+// which is the default.
+func SetTCertRevocationFeed(feed TCertRevocationFeed) {
+	tCertRevocationFeedLock.Lock()
+	defer tCertRevocationFeedLock.Unlock()
+
+	tCertRevocationFeed = feed
+}
+
+func getTCertRevocationFeed() TCertRevocationFeed {
+	tCertRevocationFeedLock.RLock()
+	defer tCertRevocationFeedLock.RUnlock()
+
+	return tCertRevocationFeed
+}
+
+// tCertRevocationList holds the serial numbers of the revoked TCerts
+// of a client. The zero value is an empty list.
+type tCertRevocationList struct {
+	m        sync.RWMutex
+	serials  map[string]bool
+	lastPoll time.Time
+}
+
+func (list *tCertRevocationList) add(serialNumbers []*big.Int) {
+	list.m.Lock()
+	defer list.m.Unlock()
+
+	if list.serials == nil {
+		list.serials = make(map[string]bool)
+	}
+	for _, serialNumber := range serialNumbers {
+		list.serials[serialNumber.String()] = true
+	}
+}
+
+func (list *tCertRevocationList) contains(serialNumber *big.Int) bool {
+	list.m.RLock()
+	defer list.m.RUnlock()
+
+	return serialNumber != nil && list.serials[serialNumber.String()]
+}
+
+// pollDue returns true, and records the poll, if the last poll
+// is older than period
+func (list *tCertRevocationList) pollDue(period time.Duration) bool {
+	list.m.Lock()
+	defer list.m.Unlock()
+
+	if time.Since(list.lastPoll) < period {
+		return false
+	}
+	list.lastPoll = time.Now()
+
+	return true
+}
+
+// RevokeTCerts marks as revoked the TCerts with the passed serial numbers.
+// They are not handed out anymore and are removed from the keystore.
+// It is meant to be invoked when the TCA pushes a revocation.
+func (client *clientImpl) RevokeTCerts(serialNumbers ...*big.Int) error {
+	client.debug("Revoking [%d] TCerts.", len(serialNumbers))
+
+	client.revokedTCerts.add(serialNumbers)
+
+	keys, err := client.getUnusedTCertsKeys()
+	if err != nil {
+		return err
+	}
+
+	return client.ks.deleteUnusedTCerts(keys, func(tCertDER []byte) bool {
+		tCert, err := client.getTCertFromDER(tCertDER)
+		if err != nil {
+			return false
+		}
+
+		return client.isTCertRevoked(tCert)
+	})
+}
+
+// isTCertRevoked returns true if tCert has been revoked
+func (client *clientImpl) isTCertRevoked(tCert TCert) bool {
+	return client.revokedTCerts.contains(tCert.GetCertificate().SerialNumber)
+}
+
+// pollTCertRevocations polls the revocation feed, if any and if the last
+// poll is older than the expiry sweep period
+func (client *clientImpl) pollTCertRevocations(ctx context.Context) {
+	feed := getTCertRevocationFeed()
+	if feed == nil || !client.revokedTCerts.pollDue(client.conf.getTCertExpirySweep()) {
+		return
+	}
+
+	serialNumbers, err := feed.Revoked(ctx, client.GetName())
+	if err != nil {
+		client.error("Failed polling TCert revocations [%s].", err)
+
+		return
+	}
+	if len(serialNumbers) == 0 {
+		return
+	}
+
+	if err := client.RevokeTCerts(serialNumbers...); err != nil {
+		client.error("Failed removing revoked TCerts [%s].", err)
+	}
+}
@@ -23,6 +23,8 @@ import (
 	obc "github.com/hyperledger/fabric/protos"
 )
 
+// createTransactionNonce generates a fresh nonce and records it in the
+// keystore, so that the client never issues the same nonce twice
 func (client *clientImpl) createTransactionNonce() ([]byte, error) {
 	nonce, err := primitives.GetRandomNonce()
 	if err != nil {
@@ -30,6 +32,11 @@ func (client *clientImpl) createTransactionNonce() ([]byte, error) {
 		return nil, err
 	}
 
+	if err := client.ks.storeNonce(nonce); err != nil {
+		client.error("Failed recording nonce [%s].", err.Error())
+		return nil, err
+	}
+
 	return nonce, err
 }
 
@@ -45,13 +52,15 @@ func (client *clientImpl) createDeployTx(chaincodeDeploymentSpec *obc.ChaincodeD
 	tx.Metadata = chaincodeDeploymentSpec.ChaincodeSpec.Metadata
 
 	if nonce == nil {
-		tx.Nonce, err = primitives.GetRandomNonce()
+		tx.Nonce, err = client.createTransactionNonce()
 		if err != nil {
-			client.error("Failed creating nonce [%s].", err.Error())
 			return nil, err
 		}
 	} else {
-		// TODO: check that it is a well formed nonce
+		if len(nonce) != primitives.NonceSize {
+			client.error("Failed creating transaction [%s].", utils.ErrInvalidNonce)
+			return nil, utils.ErrInvalidNonce
+		}
 		tx.Nonce = nonce
 	}
 
@@ -61,7 +70,7 @@ func (client *clientImpl) createDeployTx(chaincodeDeploymentSpec *obc.ChaincodeD
 		tx.ConfidentialityLevel = obc.ConfidentialityLevel_CONFIDENTIAL
 
 		// 2. set confidentiality protocol version
-		tx.ConfidentialityProtocolVersion = "1.2"
+		tx.ConfidentialityProtocolVersion = client.conf.getConfidentialityProtocolVersion()
 
 		// 3. encrypt tx
 		err = client.encryptTx(tx)
@@ -87,13 +96,15 @@ func (client *clientImpl) createExecuteTx(chaincodeInvocation *obc.ChaincodeInvo
 	tx.Metadata = chaincodeInvocation.ChaincodeSpec.Metadata
 
 	if nonce == nil {
-		tx.Nonce, err = primitives.GetRandomNonce()
+		tx.Nonce, err = client.createTransactionNonce()
 		if err != nil {
-			client.error("Failed creating nonce [%s].", err.Error())
 			return nil, err
 		}
 	} else {
-		// TODO: check that it is a well formed nonce
+		if len(nonce) != primitives.NonceSize {
+			client.error("Failed creating transaction [%s].", utils.ErrInvalidNonce)
+			return nil, utils.ErrInvalidNonce
+		}
 		tx.Nonce = nonce
 	}
 
@@ -103,7 +114,7 @@ func (client *clientImpl) createExecuteTx(chaincodeInvocation *obc.ChaincodeInvo
 		tx.ConfidentialityLevel = obc.ConfidentialityLevel_CONFIDENTIAL
 
 		// 2. set confidentiality protocol version
-		tx.ConfidentialityProtocolVersion = "1.2"
+		tx.ConfidentialityProtocolVersion = client.conf.getConfidentialityProtocolVersion()
 
 		// 3. encrypt tx
 		err = client.encryptTx(tx)
@@ -129,13 +140,15 @@ func (client *clientImpl) createQueryTx(chaincodeInvocation *obc.ChaincodeInvoca
 	tx.Metadata = chaincodeInvocation.ChaincodeSpec.Metadata
 
 	if nonce == nil {
-		tx.Nonce, err = primitives.GetRandomNonce()
+		tx.Nonce, err = client.createTransactionNonce()
 		if err != nil {
-			client.error("Failed creating nonce [%s].", err.Error())
 			return nil, err
 		}
 	} else {
-		// TODO: check that it is a well formed nonce
+		if len(nonce) != primitives.NonceSize {
+			client.error("Failed creating transaction [%s].", utils.ErrInvalidNonce)
+			return nil, utils.ErrInvalidNonce
+		}
 		tx.Nonce = nonce
 	}
 
@@ -145,7 +158,7 @@ func (client *clientImpl) createQueryTx(chaincodeInvocation *obc.ChaincodeInvoca
 		tx.ConfidentialityLevel = obc.ConfidentialityLevel_CONFIDENTIAL
 
 		// 2. set confidentiality protocol version
-		tx.ConfidentialityProtocolVersion = "1.2"
+		tx.ConfidentialityProtocolVersion = client.conf.getConfidentialityProtocolVersion()
 
 		// 3. encrypt tx
 		err = client.encryptTx(tx)
@@ -159,7 +172,7 @@ func (client *clientImpl) createQueryTx(chaincodeInvocation *obc.ChaincodeInvoca
 	return tx, nil
 }
 
-func (client *clientImpl) newChaincodeDeployUsingTCert(chaincodeDeploymentSpec *obc.ChaincodeDeploymentSpec, uuid string, tCert tCert, nonce []byte) (*obc.Transaction, error) {
+func (client *clientImpl) newChaincodeDeployUsingTCert(chaincodeDeploymentSpec *obc.ChaincodeDeploymentSpec, uuid string, tCert TCert, nonce []byte) (*obc.Transaction, error) {
 	// Create a new transaction
 	tx, err := client.createDeployTx(chaincodeDeploymentSpec, uuid, nonce)
 	if err != nil {
@@ -196,7 +209,7 @@ func (client *clientImpl) newChaincodeDeployUsingTCert(chaincodeDeploymentSpec *
 	return tx, nil
 }
 
-func (client *clientImpl) newChaincodeExecuteUsingTCert(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string, tCert tCert, nonce []byte) (*obc.Transaction, error) {
+func (client *clientImpl) newChaincodeExecuteUsingTCert(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string, tCert TCert, nonce []byte) (*obc.Transaction, error) {
 	/// Create a new transaction
 	tx, err := client.createExecuteTx(chaincodeInvocation, uuid, nonce)
 	if err != nil {
@@ -233,7 +246,7 @@ func (client *clientImpl) newChaincodeExecuteUsingTCert(chaincodeInvocation *obc
 	return tx, nil
 }
 
-func (client *clientImpl) newChaincodeQueryUsingTCert(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string, tCert tCert, nonce []byte) (*obc.Transaction, error) {
+func (client *clientImpl) newChaincodeQueryUsingTCert(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string, tCert TCert, nonce []byte) (*obc.Transaction, error) {
 	// Create a new transaction
 	tx, err := client.createQueryTx(chaincodeInvocation, uuid, nonce)
 	if err != nil {
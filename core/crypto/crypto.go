@@ -17,7 +17,13 @@ limitations under the License.
 package crypto
 
 import (
+	"crypto/x509"
+	"math/big"
+	"time"
+
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
 	obc "github.com/hyperledger/fabric/protos"
+	"golang.org/x/net/context"
 )
 
 // Public Interfaces
@@ -32,6 +38,8 @@ const (
 	NodePeer NodeType = 1
 	// NodeValidator a validator
 	NodeValidator NodeType = 2
+	// NodeAuditor an auditor
+	NodeAuditor NodeType = 3
 )
 
 // Node represents a crypto object having a name
@@ -42,6 +50,11 @@ type Node interface {
 
 	// GetName returns this entity's name
 	GetName() string
+
+	// GetTLSBinding returns a binding between this entity's TLS certificate
+	// and its enrollment certificate, signed by the enrollment key.
+	// It is to be sent to peers under the TLSBindingMetadataKey.
+	GetTLSBinding() ([]byte, error)
 }
 
 // Client is an entity able to deploy and invoke chaincode
@@ -64,7 +77,9 @@ type Client interface {
 	GetEnrollmentCertificateHandler() (CertificateHandler, error)
 
 	// GetTCertHandlerNext returns a CertificateHandler whose certificate is the next available TCert
-	GetTCertificateHandlerNext() (CertificateHandler, error)
+	// carrying the passed attributes. If no attribute is passed, the TCert carries all the
+	// attributes configured for the client.
+	GetTCertificateHandlerNext(attributes ...string) (CertificateHandler, error)
 
 	// GetTCertHandlerFromDER returns a CertificateHandler whose certificate is the one passed
 	GetTCertificateHandlerFromDER(der []byte) (CertificateHandler, error)
@@ -72,8 +87,74 @@ type Client interface {
 	// ReadAttribute reads the attribute with name 'attributeName' from the der encoded x509.Certificate 'tcertder'.
 	ReadAttribute(attributeName string, tcertder []byte) ([]byte, error)
 
-	// GetNextTCert gets next available (not yet used) transaction certificate.
-	GetNextTCert() (tCert, error)
+	// GetNextTCert gets next available (not yet used) transaction certificate
+	// carrying the passed attributes.
+	GetNextTCert(attributes ...string) (TCert, error)
+
+	// GetNextTCertContext gets next available (not yet used) transaction certificate
+	// carrying the passed attributes. It gives up as soon as ctx is cancelled or its
+	// deadline expires.
+	GetNextTCertContext(ctx context.Context, attributes ...string) (TCert, error)
+
+	// RotateEnrollmentKey replaces the enrollment key pair of the client with a
+	// new one certified by the ECA, without registering the user again. The
+	// previous enrollment certificate remains valid for the grace period
+	// configured at the ECA.
+	RotateEnrollmentKey() error
+
+	// RegisterUsers registers users in bulk with the ECA on behalf of the
+	// client, which must be an auditor. Each user is registered on its own;
+	// the results tell, in the order of users, the tokens of the users
+	// registered and why the others were not.
+	RegisterUsers(users []*membersrvc.RegisterUserReq) ([]*membersrvc.RegisterUserResult, error)
+
+	// RotateKeyStorePassphrase seals the keystore of the client under
+	// a key derived from the new passphrase pwd. The keystore must be
+	// protected by a passphrase, see security.keystore.kdf.
+	RotateKeyStorePassphrase(pwd []byte) error
+
+	// GetTCertPoolHealthStatus returns the outcome of the health checks
+	// of the TCert pool
+	GetTCertPoolHealthStatus() TCertPoolHealthStatus
+
+	// GetNextTCertFor gets next available (not yet used) transaction certificate
+	// to transact with the chaincode chaincodeID, carrying the attributes
+	// configured for that chaincode.
+	GetNextTCertFor(chaincodeID string) (TCert, error)
+
+	// GetNextTCertAsync is like GetNextTCertContext but it does not block.
+	// The outcome is delivered on the returned channel, which receives
+	// exactly one value.
+	GetNextTCertAsync(ctx context.Context, attributes ...string) <-chan TCertResult
+
+	// Prefetch warms up the TCert pool with at least n transaction certificates
+	// carrying the passed attributes. It blocks until they are available or
+	// the pool gives up.
+	Prefetch(n int, attributes ...string) error
+
+	// PrefetchContext is like Prefetch but it gives up as soon as ctx is
+	// cancelled or its deadline expires.
+	PrefetchContext(ctx context.Context, n int, attributes ...string) error
+
+	// RevokeTCerts marks as revoked the transaction certificates with the
+	// passed serial numbers, so that they are not used anymore.
+	RevokeTCerts(serialNumbers ...*big.Int) error
+
+	// RotateTCertsKey replaces the master key protecting the
+	// transaction certificates stored for later use.
+	RotateTCertsKey() error
+
+	// PurgeUsedTCerts removes from the keystore the used TCerts stored
+	// before the passed time and returns how many were removed. Used
+	// TCerts are also removed in the background according to the
+	// retention policy configured under security.tcert.used.
+	PurgeUsedTCerts(before time.Time) (int, error)
+}
+
+// TCertResult is the outcome of an asynchronous request for a TCert
+type TCertResult struct {
+	TCert TCert
+	Err   error
 }
 
 // Peer is an entity able to verify transactions
@@ -91,6 +172,11 @@ type Peer interface {
 	// prescriptions (i.e. signature verification).
 	TransactionPreValidation(tx *obc.Transaction) (*obc.Transaction, error)
 
+	// TransactionsPreValidation verifies the transactions of a block as
+	// TransactionPreValidation does, concurrently. The i-th error is the
+	// outcome for the i-th transaction.
+	TransactionsPreValidation(txs []*obc.Transaction) []error
+
 	// TransactionPreExecution verifies that the transaction is
 	// well formed with the respect to the security layer
 	// prescriptions (i.e. signature verification). If this is the case,
@@ -107,12 +193,54 @@ type Peer interface {
 	// If vkID is nil, then the signature is verified against this validator's verification key.
 	Verify(vkID, signature, message []byte) error
 
+	// VerifyWithTCert checks that signature is a valid signature of message
+	// under the transaction certificate tcertDER, issued by the TCA and not
+	// revoked.
+	VerifyWithTCert(tcertDER, signature, message []byte) error
+
 	// GetStateEncryptor returns a StateEncryptor linked to pair defined by
 	// the deploy transaction and the execute transaction. Notice that,
 	// executeTx can also correspond to a deploy transaction.
 	GetStateEncryptor(deployTx, executeTx *obc.Transaction) (StateEncryptor, error)
 
 	GetTransactionBinding(tx *obc.Transaction) ([]byte, error)
+
+	// ConsumeTransactionNonce records the nonce of tx as used, and fails
+	// with ErrNonceReplayed if it was already used by the same certificate.
+	// It must be invoked once per transaction, in ledger order.
+	ConsumeTransactionNonce(tx *obc.Transaction) error
+
+	// VerifyTLSBinding checks that the TLS certificate the remote party
+	// presented on the connection of ctx is bound to a valid enrollment
+	// certificate, and returns the latter. If the request carries no
+	// binding and none is required, it returns nil and no error.
+	VerifyTLSBinding(ctx context.Context) (*x509.Certificate, error)
+}
+
+// Auditor is an entity able to decrypt confidential transactions and
+// chaincode state for compliance review, using the chain key escrowed
+// by membersrvc. Access is restricted to the chaincodes listed under
+// security.audit.chaincodes and every access is recorded in the audit log.
+type Auditor interface {
+	Node
+
+	// GetEnrollmentID returns this auditor's enrollment id
+	GetEnrollmentID() string
+
+	// DecryptTransaction returns a clone of tx whose chaincode ID,
+	// payload and metadata are in the clear.
+	DecryptTransaction(tx *obc.Transaction) (*obc.Transaction, error)
+
+	// GetStateDecryptor returns a StateDecryptor for the state written
+	// by executeTx to the chaincode deployed by deployTx.
+	GetStateDecryptor(deployTx, executeTx *obc.Transaction) (StateDecryptor, error)
+}
+
+// StateDecryptor is used by auditors to decrypt chaincode's state
+type StateDecryptor interface {
+
+	// Decrypt decrypts ciphertext ct
+	Decrypt(ct []byte) ([]byte, error)
 }
 
 // StateEncryptor is used to encrypt chaincode's state
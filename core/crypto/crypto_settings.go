@@ -68,5 +68,18 @@ func Init() (err error) {
 		return
 	}
 
+	// Init nonce generation of ECDSA signatures
+	deterministic := false
+	if viper.IsSet("security.signature.deterministic") {
+		deterministic = viper.GetBool("security.signature.deterministic")
+	}
+	log.Debug("Deterministic ECDSA signatures [%t]", deterministic)
+	primitives.SetDeterministicECDSA(deterministic)
+
+	// Init eviction of the idle nodes
+	if viper.IsSet("security.registry.idleTimeout") {
+		SetRegistryIdleTimeout(viper.GetDuration("security.registry.idleTimeout"))
+	}
+
 	return
 }
@@ -22,21 +22,38 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/primitives/secp256k1"
 	"github.com/hyperledger/fabric/core/crypto/utils"
 	"github.com/hyperledger/fabric/core/util"
 	"github.com/hyperledger/fabric/membersrvc/ca"
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
+	"golang.org/x/crypto/ocsp"
+	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 )
 
@@ -317,6 +334,2082 @@ func TestClientGetAttributesFromTCert(t *testing.T) {
 	}
 }
 
+func TestClientGetNextTCertWithAttributes(t *testing.T) {
+	tCert, err := deployer.GetNextTCert("company")
+	if err != nil {
+		t.Fatalf("Failed getting tcert: [%s]", err)
+	}
+
+	attributeBytes, err := deployer.ReadAttribute("company", tCert.GetCertificate().Raw)
+	if err != nil {
+		t.Fatalf("Error retrieving attribute from TCert: [%s]", err)
+	}
+	if string(attributeBytes) != "IBM" {
+		t.Fatalf("Wrong attribute retrieved from TCert. Expected [%s], Actual [%s]", "IBM", string(attributeBytes))
+	}
+
+	if _, err := deployer.ReadAttribute("position", tCert.GetCertificate().Raw); err == nil {
+		t.Fatal("TCert must carry only the requested attributes")
+	}
+
+	if _, err := deployer.GetNextTCert("unknown"); err == nil {
+		t.Fatal("Requesting a TCert with an attribute not configured must fail")
+	}
+}
+
+func TestClientTCertExpiryEviction(t *testing.T) {
+	tCert, err := deployer.GetNextTCert()
+	if err != nil {
+		t.Fatalf("Failed getting tcert: [%s]", err)
+	}
+
+	client := deployer.(*clientImpl)
+	if !client.isTCertUsable(tCert) {
+		t.Fatal("A fresh TCert must be usable")
+	}
+
+	cert := *tCert.GetCertificate()
+	cert.NotAfter = time.Now().Add(client.conf.getTCertExpiryMargin() / 2)
+	expiring := &tCertImpl{client, &cert, nil, nil}
+	if client.isTCertUsable(expiring) {
+		t.Fatal("A TCert expiring within the margin must not be usable")
+	}
+
+	tCertPool := &tCertPoolSingleThreadImpl{}
+	tCertPool.init(client)
+	tCertPool.AddTCert(tCert)
+	tCertPool.tCerts[tCertAttributesKey(client.getTCertAttributeNames(tCert))] = append(
+		tCertPool.tCerts[tCertAttributesKey(client.getTCertAttributeNames(tCert))], expiring,
+	)
+	if next := tCertPool.popUsable(tCertAttributesKey(client.getTCertAttributeNames(tCert))); next != tCert {
+		t.Fatal("The expiring TCert must be evicted")
+	}
+}
+
+func TestClientTCertReusePolicy(t *testing.T) {
+	client := deployer.(*clientImpl)
+	defer func(policy string, count int) {
+		client.conf.tCertReusePolicy, client.conf.tCertReuseCount = policy, count
+	}(client.conf.tCertReusePolicy, client.conf.tCertReuseCount)
+	client.conf.tCertReusePolicy, client.conf.tCertReuseCount = TCertReuseCount, 3
+
+	inner, err := newTCertPool(TCertPoolSingleThread, client)
+	if err != nil {
+		t.Fatalf("Failed creating TCert pool [%s]", err)
+	}
+	// The TCerts obtained from the TCA go to the client's pool,
+	// fill the inner pool by hand
+	for i := 0; i < 2; i++ {
+		tCert, err := deployer.GetNextTCert()
+		if err != nil {
+			t.Fatalf("Failed getting tcert: [%s]", err)
+		}
+		inner.AddTCert(tCert)
+	}
+	tCertPool := newTCertPoolReuse(client, inner)
+	defer tCertPool.Stop()
+
+	first, err := tCertPool.GetNextTCert()
+	if err != nil {
+		t.Fatalf("Failed getting tcert: [%s]", err)
+	}
+	for i := 1; i < 3; i++ {
+		tCert, err := tCertPool.GetNextTCert()
+		if err != nil {
+			t.Fatalf("Failed getting tcert: [%s]", err)
+		}
+		if tCert != first {
+			t.Fatalf("The TCert must be reused [%d] times", 3)
+		}
+	}
+	tCert, err := tCertPool.GetNextTCert()
+	if err != nil {
+		t.Fatalf("Failed getting tcert: [%s]", err)
+	}
+	if tCert == first {
+		t.Fatal("The TCert must not be reused beyond the count")
+	}
+}
+
+// blockingTCertPool hands out a new TCert on each call, except for the
+// callers asking for the blocked attribute, which wait until it is stopped.
+type blockingTCertPool struct {
+	TCertPool
+
+	blocked string
+	stopped chan struct{}
+}
+
+func (tCertPool *blockingTCertPool) Stop() error {
+	close(tCertPool.stopped)
+
+	return nil
+}
+
+func (tCertPool *blockingTCertPool) GetNextTCert(attributes ...string) (TCert, error) {
+	return tCertPool.GetNextTCertContext(context.Background(), attributes...)
+}
+
+func (tCertPool *blockingTCertPool) GetNextTCertContext(ctx context.Context, attributes ...string) (TCert, error) {
+	if len(attributes) == 1 && attributes[0] == tCertPool.blocked {
+		select {
+		case <-tCertPool.stopped:
+			return nil, utils.ErrPoolStopped
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return &tCertImpl{cert: &x509.Certificate{
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(time.Hour),
+	}}, nil
+}
+
+func TestClientTCertReuseConcurrent(t *testing.T) {
+	client := &clientImpl{nodeImpl: &nodeImpl{conf: &configuration{
+		tCertReusePolicy: TCertReuseCount,
+		tCertReuseCount:  10,
+	}}}
+	inner := &blockingTCertPool{blocked: "a", stopped: make(chan struct{})}
+	tCertPool := newTCertPoolReuse(client, inner)
+
+	// A caller waiting on the inner pool must not hold up the others
+	blocked := make(chan error, 1)
+	go func() {
+		_, err := tCertPool.GetNextTCert("a")
+		blocked <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := tCertPool.GetNextTCertContext(ctx, "a"); err != context.DeadlineExceeded {
+		t.Fatalf("Waiting for a TCert being obtained must honour the context, got [%v]", err)
+	}
+
+	first, err := tCertPool.GetNextTCert("b")
+	if err != nil {
+		t.Fatalf("Failed getting tcert: [%s]", err)
+	}
+	if second, err := tCertPool.GetNextTCert("b"); err != nil || second != first {
+		t.Fatalf("The TCert must be reused, got [%v]", err)
+	}
+
+	// Stop must not wait for the blocked caller, which must then fail
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- tCertPool.Stop()
+	}()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatalf("Failed stopping TCert pool [%s]", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stopping the TCert pool must not wait for a blocked caller")
+	}
+	if err := <-blocked; err != utils.ErrPoolStopped {
+		t.Fatalf("A blocked caller must fail with [%s], got [%v]", utils.ErrPoolStopped, err)
+	}
+}
+
+type testTCertPoolMetrics struct {
+	noopTCertPoolMetrics
+
+	m         sync.Mutex
+	requests  int
+	evictions int
+}
+
+func (metrics *testTCertPoolMetrics) ObserveGetNextTCert(client string, latency time.Duration) {
+	metrics.m.Lock()
+	defer metrics.m.Unlock()
+
+	metrics.requests++
+}
+
+func (metrics *testTCertPoolMetrics) IncEvictions(client string) {
+	metrics.m.Lock()
+	defer metrics.m.Unlock()
+
+	metrics.evictions++
+}
+
+func TestClientTCertPoolMetrics(t *testing.T) {
+	metrics := &testTCertPoolMetrics{}
+	SetTCertPoolMetrics(metrics)
+	defer SetTCertPoolMetrics(nil)
+
+	tCert, err := deployer.GetNextTCert()
+	if err != nil {
+		t.Fatalf("Failed getting tcert: [%s]", err)
+	}
+	client := deployer.(*clientImpl)
+	client.evictTCert(tCert)
+
+	metrics.m.Lock()
+	defer metrics.m.Unlock()
+	if metrics.requests != 1 {
+		t.Fatalf("Requests for TCerts must be measured. Expected [%d], Actual [%d]", 1, metrics.requests)
+	}
+	if metrics.evictions != 1 {
+		t.Fatalf("Evictions must be counted. Expected [%d], Actual [%d]", 1, metrics.evictions)
+	}
+}
+
+func TestClientUnusedTCertsIntegrity(t *testing.T) {
+	tCert, err := deployer.GetNextTCert()
+	if err != nil {
+		t.Fatalf("Failed getting tcert: [%s]", err)
+	}
+	client := deployer.(*clientImpl)
+	key, err := client.getUnusedTCertsKeys()
+	if err != nil {
+		t.Fatalf("Failed getting unused tcerts keys: [%s]", err)
+	}
+	raw := tCert.GetCertificate().Raw
+
+	// Put aside what is stored
+	stored, err := client.ks.loadUnusedTCerts(key)
+	if err != nil {
+		t.Fatalf("Failed loading unused tcerts: [%s]", err)
+	}
+	if err := client.ks.storeUnusedTCerts([]TCert{tCert}, key); err != nil {
+		t.Fatalf("Failed storing unused tcerts: [%s]", err)
+	}
+	if tCertDERs, err := client.ks.loadUnusedTCerts(key); err != nil || len(tCertDERs) != 1 || !bytes.Equal(tCertDERs[0], raw) {
+		t.Fatalf("Stored TCert must be loaded back [%s]", err)
+	}
+
+	// Tampered
+	client.ks.storeUnusedTCerts([]TCert{tCert}, key)
+	client.ks.backend.(*sqliteKeyStore).db.Exec("UPDATE TCerts SET mac = ?", []byte{0})
+	if tCertDERs, _ := client.ks.loadUnusedTCerts(key); len(tCertDERs) != 0 {
+		t.Fatal("Tampered TCert must be discarded")
+	}
+
+	// Legacy and future versions
+	client.ks.backend.(*sqliteKeyStore).db.Exec("INSERT INTO TCerts (cert) VALUES (?)", raw)
+	client.ks.backend.(*sqliteKeyStore).db.Exec("INSERT INTO TCerts (cert, version) VALUES (?, ?)", raw, unusedTCertsVersion+1)
+	if tCertDERs, _ := client.ks.loadUnusedTCerts(key); len(tCertDERs) != 1 {
+		t.Fatal("Legacy TCert must be migrated")
+	}
+	var left int
+	client.ks.backend.(*sqliteKeyStore).db.QueryRow("SELECT COUNT(*) FROM TCerts").Scan(&left)
+	if left != 1 {
+		t.Fatalf("TCert stored with an unknown version must be left untouched. Expected [%d], Actual [%d]", 1, left)
+	}
+
+	client.ks.backend.(*sqliteKeyStore).db.Exec("DELETE FROM TCerts")
+	tCerts := []TCert{}
+	for _, tCertDER := range stored {
+		if tCert, err := client.getTCertFromDER(tCertDER); err == nil {
+			tCerts = append(tCerts, tCert)
+		}
+	}
+	client.storeUnusedTCerts(tCerts)
+}
+
+type testTCertRevocationFeed []*big.Int
+
+func (feed testTCertRevocationFeed) Revoked(ctx context.Context, name string) ([]*big.Int, error) {
+	return feed, nil
+}
+
+func TestClientUnusedTCertsEncryption(t *testing.T) {
+	tCert, err := deployer.GetNextTCert()
+	if err != nil {
+		t.Fatalf("Failed getting tcert: [%s]", err)
+	}
+	client := deployer.(*clientImpl)
+	keys, err := client.getUnusedTCertsKeys()
+	if err != nil {
+		t.Fatalf("Failed getting unused tcerts keys: [%s]", err)
+	}
+
+	cert, mac, err := sealUnusedTCert(keys, tCert.GetCertificate().Raw)
+	if err != nil {
+		t.Fatalf("Failed sealing tcert: [%s]", err)
+	}
+	if bytes.Contains(cert, tCert.GetCertificate().Raw) {
+		t.Fatal("The TCert must be stored encrypted")
+	}
+	if der, err := openUnusedTCert(keys, unusedTCertsVersion, cert, mac); err != nil || !bytes.Equal(der, tCert.GetCertificate().Raw) {
+		t.Fatalf("Failed opening tcert: [%s]", err)
+	}
+
+	// The data key survives the rotation of the master key
+	if err := deployer.RotateTCertsKey(); err != nil {
+		t.Fatalf("Failed rotating master key: [%s]", err)
+	}
+	rotated, err := client.getUnusedTCertsKeys()
+	if err != nil {
+		t.Fatalf("Failed getting unused tcerts keys: [%s]", err)
+	}
+	if !bytes.Equal(rotated.data, keys.data) {
+		t.Fatal("The data key must be preserved by the rotation")
+	}
+	if der, err := openUnusedTCert(rotated, unusedTCertsVersion, cert, mac); err != nil || !bytes.Equal(der, tCert.GetCertificate().Raw) {
+		t.Fatalf("Failed opening tcert after rotation: [%s]", err)
+	}
+}
+
+func TestClientRevokeTCerts(t *testing.T) {
+	client := deployer.(*clientImpl)
+
+	tCert, err := deployer.GetNextTCert()
+	if err != nil {
+		t.Fatalf("Failed getting tcert: [%s]", err)
+	}
+	var stored int
+	client.ks.backend.(*sqliteKeyStore).db.QueryRow("SELECT COUNT(*) FROM TCerts").Scan(&stored)
+	if err := client.storeUnusedTCerts([]TCert{tCert}); err != nil {
+		t.Fatalf("Failed storing unused tcerts: [%s]", err)
+	}
+
+	if err := deployer.RevokeTCerts(tCert.GetCertificate().SerialNumber); err != nil {
+		t.Fatalf("Failed revoking tcerts: [%s]", err)
+	}
+	if client.isTCertUsable(tCert) {
+		t.Fatal("A revoked TCert must not be usable")
+	}
+	var left int
+	client.ks.backend.(*sqliteKeyStore).db.QueryRow("SELECT COUNT(*) FROM TCerts").Scan(&left)
+	if left != stored {
+		t.Fatal("A revoked TCert must be removed from the keystore")
+	}
+
+	// Feed
+	tCert, err = deployer.GetNextTCert()
+	if err != nil {
+		t.Fatalf("Failed getting tcert: [%s]", err)
+	}
+	SetTCertRevocationFeed(testTCertRevocationFeed{tCert.GetCertificate().SerialNumber})
+	defer SetTCertRevocationFeed(nil)
+
+	client.revokedTCerts.lastPoll = time.Time{}
+	client.pollTCertRevocations(context.Background())
+	if client.isTCertUsable(tCert) {
+		t.Fatal("A TCert revoked by the feed must not be usable")
+	}
+}
+
+func TestClientDeriveTCertKey(t *testing.T) {
+	client := deployer.(*clientImpl)
+	expansionKey := primitives.HMAC(client.tCertOwnerKDFKey, []byte{2})
+
+	sk1, err := client.deriveTCertKey(expansionKey, []byte{1})
+	if err != nil {
+		t.Fatalf("Failed deriving TCert key [%s]", err)
+	}
+	sk2, err := client.deriveTCertKey(expansionKey, []byte{1})
+	if err != nil {
+		t.Fatalf("Failed deriving TCert key [%s]", err)
+	}
+	if sk1.D.Cmp(sk2.D) != 0 {
+		t.Fatal("The derivation must be deterministic")
+	}
+	sk3, err := client.deriveTCertKey(expansionKey, []byte{2})
+	if err != nil {
+		t.Fatalf("Failed deriving TCert key [%s]", err)
+	}
+	if sk1.D.Cmp(sk3.D) == 0 {
+		t.Fatal("Different indices must give different keys")
+	}
+}
+
+func TestClientTCertOffline(t *testing.T) {
+	client := deployer.(*clientImpl)
+	defer func(offline bool, reserve int) {
+		client.conf.tCertOffline, client.conf.tCertReserve = offline, reserve
+	}(client.conf.tCertOffline, client.conf.tCertReserve)
+	client.conf.tCertOffline, client.conf.tCertReserve = true, 10
+
+	key := tCertAttributesKey(client.getRequestedTCertAttributeNames(nil))
+	if client.hasReservedTCerts(nil) {
+		t.Fatal("The reserve must be empty at first")
+	}
+
+	// The reserve is fetched in a single request, then the TCerts are
+	// minted from it without contacting the TCA
+	if err := client.RequestTCerts(context.Background(), 4); err != nil {
+		t.Fatalf("Failed requesting tcerts offline [%s]", err)
+	}
+	if count, err := client.ks.countReservedTCerts(key); err != nil || count != 6 {
+		t.Fatalf("The reserve must hold the TCerts not minted. Expected [%d], Actual [%d] [%v]", 6, count, err)
+	}
+	if err := client.RequestTCerts(context.Background(), 4); err != nil {
+		t.Fatalf("Failed requesting tcerts offline [%s]", err)
+	}
+	if count, _ := client.ks.countReservedTCerts(key); count != 2 {
+		t.Fatalf("The TCerts must be minted from the reserve. Expected [%d], Actual [%d]", 2, count)
+	}
+
+	// The keys of the minted TCerts are derived locally
+	tCerts, err := client.mintTCerts(key, 1)
+	if err != nil || len(tCerts) != 1 {
+		t.Fatalf("Failed minting tcert [%v]", err)
+	}
+	sig, err := tCerts[0].Sign([]byte("msg"))
+	if err != nil {
+		t.Fatalf("Failed signing with minted tcert [%s]", err)
+	}
+	if err := tCerts[0].Verify(sig, []byte("msg")); err != nil {
+		t.Fatalf("Failed verifying signature of minted tcert [%s]", err)
+	}
+
+	// The reserve is fetched again once it falls short of the request
+	if err := client.RequestTCerts(context.Background(), 4); err != nil {
+		t.Fatalf("Failed requesting tcerts offline [%s]", err)
+	}
+	if count, _ := client.ks.countReservedTCerts(key); count != 7 {
+		t.Fatalf("The reserve must be fetched again. Expected [%d], Actual [%d]", 7, count)
+	}
+
+	// Leave the reserve empty for the other tests
+	keys, _ := client.getUnusedTCertsKeys()
+	client.ks.takeReservedTCerts(key, 7, keys)
+}
+
+func TestClientTCertPoolStopped(t *testing.T) {
+	tCertPool, err := newTCertPool(TCertPoolSingleThread, deployer.(*clientImpl))
+	if err != nil {
+		t.Fatalf("Failed creating TCert pool [%s]", err)
+	}
+	if err := tCertPool.Stop(); err != nil {
+		t.Fatalf("Failed stopping TCert pool [%s]", err)
+	}
+
+	if _, err := tCertPool.GetNextTCert(); err != utils.ErrPoolStopped {
+		t.Fatalf("Getting a TCert from a stopped pool must fail with [%s], got [%s]", utils.ErrPoolStopped, err)
+	}
+}
+
+func TestClientTCertPoolStopWakesCallers(t *testing.T) {
+	tCertPool, err := newTCertPool(TCertPoolMultithreading, deployer.(*clientImpl))
+	if err != nil {
+		t.Fatalf("Failed creating TCert pool [%s]", err)
+	}
+	// No filler, callers block on the empty pool
+	close(tCertPool.(*tCertPoolMultithreadingImpl).stopped)
+
+	errs := make(chan error)
+	go func() {
+		_, err := tCertPool.GetNextTCertContext(context.Background())
+		errs <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	if err := tCertPool.Stop(); err != nil {
+		t.Fatalf("Failed stopping TCert pool [%s]", err)
+	}
+	select {
+	case err := <-errs:
+		if err != utils.ErrPoolStopped {
+			t.Fatalf("A blocked caller must fail with [%s], got [%s]", utils.ErrPoolStopped, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Stop must wake up the blocked callers")
+	}
+
+	if _, err := tCertPool.GetNextTCert(); err != utils.ErrPoolStopped {
+		t.Fatalf("Getting a TCert from a stopped pool must fail with [%s], got [%s]", utils.ErrPoolStopped, err)
+	}
+}
+
+func TestClientTCertPoolAddTCertsOverflow(t *testing.T) {
+	client := deployer.(*clientImpl)
+	tCertPool := new(tCertPoolMultithreadingImpl)
+	tCertPool.init(client)
+
+	tCerts := []TCert{}
+	for i := 0; i < 2; i++ {
+		tCert, err := deployer.GetNextTCert()
+		if err != nil {
+			t.Fatalf("Failed getting tcert: [%s]", err)
+		}
+		tCerts = append(tCerts, tCert)
+	}
+	subPool := tCertPool.getSubPool(client.getTCertAttributeNames(tCerts[0]))
+	subPool.tCertChannel = make(chan TCert, 1)
+
+	if err := tCertPool.AddTCerts(tCerts); err != nil {
+		t.Fatalf("Failed adding tcerts: [%s]", err)
+	}
+	if len(subPool.tCertChannel) != 1 || !tCertPool.hasOverflow() {
+		t.Fatal("The TCerts that do not fit must be stored")
+	}
+
+	<-subPool.tCertChannel
+	tCertPool.loadUnusedTCerts()
+	if len(subPool.tCertChannel) != 1 || tCertPool.hasOverflow() {
+		t.Fatal("The TCerts stored must be loaded back")
+	}
+}
+
+func TestClientGetNextTCertContext(t *testing.T) {
+	tCert, err := deployer.GetNextTCertContext(context.Background())
+	if err != nil {
+		t.Fatalf("Failed getting tcert: [%s]", err)
+	}
+	if tCert == nil {
+		t.Fatalf("TCert should be different from nil")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := deployer.GetNextTCertContext(ctx); err != context.Canceled {
+		t.Fatalf("Getting a TCert with a cancelled context must fail with [%s], got [%s]", context.Canceled, err)
+	}
+}
+
+func TestClientGetNextTCertAsync(t *testing.T) {
+	result := <-deployer.GetNextTCertAsync(context.Background())
+	if result.Err != nil {
+		t.Fatalf("Failed getting tcert: [%s]", result.Err)
+	}
+	if result.TCert == nil {
+		t.Fatalf("TCert should be different from nil")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if result := <-deployer.GetNextTCertAsync(ctx); result.Err != context.Canceled {
+		t.Fatalf("Getting a TCert with a cancelled context must fail with [%s], got [%s]", context.Canceled, result.Err)
+	}
+}
+
+func TestClientPrefetch(t *testing.T) {
+	n := deployer.(*clientImpl).conf.getTCertBatchSize() + 1
+	if err := deployer.Prefetch(n); err != nil {
+		t.Fatalf("Failed prefetching [%d] tcerts: [%s]", n, err)
+	}
+	for i := 0; i < n; i++ {
+		if _, err := deployer.GetNextTCertContext(context.Background()); err != nil {
+			t.Fatalf("Failed getting prefetched tcert: [%s]", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := deployer.PrefetchContext(ctx, n); err != context.Canceled {
+		t.Fatalf("Prefetching with a cancelled context must fail with [%s], got [%s]", context.Canceled, err)
+	}
+}
+
+func TestClientTCertPoolHealthCheck(t *testing.T) {
+	client := deployer.(*clientImpl)
+	tCertPool, err := newTCertPoolSingleThread(client)
+	if err != nil {
+		t.Fatalf("Failed creating pool: [%s]", err)
+	}
+	stPool := tCertPool.(*tCertPoolSingleThreadImpl)
+
+	tCert, err := deployer.GetNextTCert()
+	if err != nil {
+		t.Fatalf("Failed getting tcert: [%s]", err)
+	}
+	if err := client.checkTCert(tCert); err != nil {
+		t.Fatalf("Valid tcert must pass the check: [%s]", err)
+	}
+
+	// A TCert whose key does not match its certificate
+	other, err := deployer.GetNextTCert()
+	if err != nil {
+		t.Fatalf("Failed getting tcert: [%s]", err)
+	}
+	corrupted := &tCertImpl{client, tCert.GetCertificate(), other.(*tCertImpl).sk, nil}
+	if err := client.checkTCert(corrupted); err == nil {
+		t.Fatal("Corrupted tcert must fail the check")
+	}
+
+	stPool.AddTCerts([]TCert{tCert, corrupted})
+	stPool.m.Lock()
+	stPool.checkHealth()
+	stPool.m.Unlock()
+
+	status := tCertPool.HealthStatus()
+	if status.Healthy || status.Corrupted != 1 || status.Repairs != 1 {
+		t.Fatalf("Health check must report the corrupted tcert, got [%+v]", status)
+	}
+	if len(stPool.tCerts) != 0 {
+		t.Fatal("Pool must be flushed after finding a corrupted tcert")
+	}
+
+	// Checks are paced by the configured period
+	stPool.AddTCert(corrupted)
+	stPool.m.Lock()
+	stPool.checkHealth()
+	stPool.m.Unlock()
+	if tCertPool.HealthStatus().Repairs != 1 {
+		t.Fatal("Health check must not run again before the period elapsed")
+	}
+}
+
+func TestClientGetNextTCertFor(t *testing.T) {
+	client := deployer.(*clientImpl)
+	client.conf.tCertChaincodePools["TestClientGetNextTCertFor"] = &tCertChaincodePool{attributes: []string{"company"}, size: 1}
+	defer delete(client.conf.tCertChaincodePools, "TestClientGetNextTCertFor")
+
+	tCert, err := deployer.GetNextTCertFor("TestClientGetNextTCertFor")
+	if err != nil {
+		t.Fatalf("Failed getting tcert for chaincode: [%s]", err)
+	}
+	if names := client.getTCertAttributeNames(tCert); len(names) != 1 || names[0] != "company" {
+		t.Fatalf("TCert for chaincode must carry only the configured attributes, got [%v]", names)
+	}
+
+	tCert, err = deployer.GetNextTCertFor("unknown")
+	if err != nil {
+		t.Fatalf("Failed getting tcert for unconfigured chaincode: [%s]", err)
+	}
+	if names := client.getTCertAttributeNames(tCert); len(names) != len(client.conf.tCertAttributes) {
+		t.Fatalf("TCert for unconfigured chaincode must carry all the attributes, got [%v]", names)
+	}
+}
+
+func TestRegisterCSPProvider(t *testing.T) {
+	if err := RegisterCSPProvider("", newSoftwareCSP); err == nil {
+		t.Fatal("Registering a provider with an empty name must fail")
+	}
+	if err := RegisterCSPProvider(CSPSoftware, newSoftwareCSP); err == nil {
+		t.Fatal("Registering a provider twice must fail")
+	}
+	if _, err := newCSP("TestRegisterCSPProvider", "node"); err == nil {
+		t.Fatal("Creating an unregistered provider must fail")
+	}
+
+	csp, err := newCSP(CSPSoftware, "node")
+	if err != nil {
+		t.Fatalf("Failed creating software provider [%s]", err)
+	}
+	key, err := csp.GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s]", err)
+	}
+	msg := []byte("Hello World")
+	signature, err := csp.Sign(key, msg)
+	if err != nil {
+		t.Fatalf("Failed signing [%s]", err)
+	}
+	ok, err := csp.Verify(&key.(*ecdsa.PrivateKey).PublicKey, msg, signature)
+	if err != nil || !ok {
+		t.Fatalf("Failed verifying signature [%s]", err)
+	}
+
+	aesKey, err := primitives.GenAESKey()
+	if err != nil {
+		t.Fatalf("Failed generating AES key [%s]", err)
+	}
+	ct, err := csp.Encrypt(aesKey, msg)
+	if err != nil {
+		t.Fatalf("Failed encrypting [%s]", err)
+	}
+	pt, err := csp.Decrypt(aesKey, ct)
+	if err != nil || !reflect.DeepEqual(pt, msg) {
+		t.Fatalf("Failed decrypting [%s]", err)
+	}
+}
+
+func TestEd25519Signatures(t *testing.T) {
+	csp, err := newCSP(CSPSoftware, "node")
+	if err != nil {
+		t.Fatalf("Failed creating software provider [%s]", err)
+	}
+	key, err := primitives.NewEd25519Key()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s]", err)
+	}
+
+	msg := []byte("Hello World")
+	signature, err := csp.Sign(key, msg)
+	if err != nil {
+		t.Fatalf("Failed signing [%s]", err)
+	}
+	if ok, err := csp.Verify(key.Public(), msg, signature); err != nil || !ok {
+		t.Fatalf("Failed verifying signature [%s]", err)
+	}
+	if ok, _ := csp.Verify(key.Public(), []byte("Hello"), signature); ok {
+		t.Fatal("Signature over a different message must not verify")
+	}
+
+	// Enrollment keys are stored in the keystore as PEM
+	for _, pwd := range [][]byte{nil, []byte("pwd")} {
+		raw, err := utils.PrivateKeyToPEM(key, pwd)
+		if err != nil {
+			t.Fatalf("Failed converting key to PEM [%s]", err)
+		}
+		loaded, err := utils.PEMtoPrivateKey(raw, pwd)
+		if err != nil {
+			t.Fatalf("Failed parsing key from PEM [%s]", err)
+		}
+		if !reflect.DeepEqual(loaded, key) {
+			t.Fatal("Key loaded from PEM differs")
+		}
+	}
+
+	// TCerts cannot derive from an Ed25519 enrollment key
+	client := &clientImpl{nodeImpl: &nodeImpl{conf: &configuration{}, enrollSignKey: key}}
+	if _, err := client.deriveTCertKey([]byte("key"), []byte("index")); err != utils.ErrInvalidKey {
+		t.Fatalf("Deriving a TCert key from an Ed25519 enrollment key must fail, got [%v]", err)
+	}
+}
+
+type testHSM struct {
+	keys map[string]*ecdsa.PrivateKey
+}
+
+func (hsm *testHSM) ImportKey(label string, key *ecdsa.PrivateKey, persistent bool) error {
+	hsm.keys[label] = key
+	return nil
+}
+
+func (hsm *testHSM) FindKey(label string) (*ecdsa.PublicKey, error) {
+	key, ok := hsm.keys[label]
+	if !ok {
+		return nil, fmt.Errorf("Key [%s] not found", label)
+	}
+	return &key.PublicKey, nil
+}
+
+func (hsm *testHSM) Sign(label string, digest []byte) ([]byte, error) {
+	return hsm.keys[label].Sign(rand.Reader, digest, nil)
+}
+
+func (hsm *testHSM) GenerateKey(label string) (*ecdsa.PublicKey, error) {
+	key, err := primitives.NewECDSAKey()
+	if err != nil {
+		return nil, err
+	}
+	hsm.keys[label] = key
+	return &key.PublicKey, nil
+}
+
+func (hsm *testHSM) Close() error {
+	return nil
+}
+
+func TestConfidentialityProtocolVersion1_3(t *testing.T) {
+	csp, err := newCSP(CSPSoftware, "node")
+	if err != nil {
+		t.Fatalf("Failed creating software provider [%s]", err)
+	}
+	chainKey, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating chain key [%s]", err)
+	}
+	eciesSPI := csp.GetAsymmetricCipherSPI()
+	chainPublicKey, err := eciesSPI.NewPublicKey(nil, &chainKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed creating chain public key [%s]", err)
+	}
+	chainPrivateKey, err := eciesSPI.NewPrivateKey(nil, chainKey)
+	if err != nil {
+		t.Fatalf("Failed creating chain private key [%s]", err)
+	}
+
+	node := &nodeImpl{conf: &configuration{name: "node"}, csp: csp, eciesSPI: eciesSPI}
+	client := &clientImpl{nodeImpl: node, chainPublicKey: chainPublicKey}
+	validator := &validatorImpl{peerImpl: &peerImpl{nodeImpl: node}, chainPrivateKey: chainPrivateKey}
+
+	newTx := func() *obc.Transaction {
+		return &obc.Transaction{
+			Type:                           obc.Transaction_CHAINCODE_INVOKE,
+			ChaincodeID:                    []byte("chaincode"),
+			Payload:                        []byte("payload"),
+			Metadata:                       []byte("metadata"),
+			Nonce:                          []byte("nonce"),
+			ConfidentialityLevel:           obc.ConfidentialityLevel_CONFIDENTIAL,
+			ConfidentialityProtocolVersion: "1.3",
+		}
+	}
+
+	tx := newTx()
+	if err := client.encryptTx(tx); err != nil {
+		t.Fatalf("Failed encrypting transaction [%s]", err)
+	}
+	clear, err := validator.deepCloneAndDecryptTx(tx)
+	if err != nil {
+		t.Fatalf("Failed decrypting transaction [%s]", err)
+	}
+	expected := newTx()
+	if !bytes.Equal(clear.ChaincodeID, expected.ChaincodeID) ||
+		!bytes.Equal(clear.Payload, expected.Payload) ||
+		!bytes.Equal(clear.Metadata, expected.Metadata) {
+		t.Fatal("Decrypted transaction differs from the original one")
+	}
+
+	// Fields are bound to the transaction nonce
+	tx.Nonce = []byte("other nonce")
+	if _, err := validator.deepCloneAndDecryptTx(tx); err == nil {
+		t.Fatal("Decrypting a transaction with a different nonce must fail")
+	}
+}
+
+func TestDeterministicECDSA(t *testing.T) {
+	// RFC 6979, A.2.5: P-256 with SHA-256, message "sample"
+	hexInt := func(s string) *big.Int {
+		i, _ := new(big.Int).SetString(s, 16)
+		return i
+	}
+	key := &ecdsa.PrivateKey{D: hexInt("C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721")}
+	key.Curve = elliptic.P256()
+	key.X, key.Y = key.Curve.ScalarBaseMult(key.D.Bytes())
+
+	digest := sha256.Sum256([]byte("sample"))
+	r, s, err := primitives.ECDSASignRFC6979(key, digest[:], sha256.New)
+	if err != nil {
+		t.Fatalf("Failed signing [%s]", err)
+	}
+	if r.Cmp(hexInt("EFD48B2AACB6A8FD1140DD9CD45E81D69D2C877B56AAF991C34D0EA84EAF3716")) != 0 ||
+		s.Cmp(hexInt("F7CB1C942D657C41D436C7A1B6E29F65F3E900DBB9AFF4064DC4AB2F843ACDA8")) != 0 {
+		t.Fatalf("Signature differs from the RFC 6979 test vector: r [%x], s [%x]", r, s)
+	}
+
+	primitives.SetDeterministicECDSA(true)
+	defer primitives.SetDeterministicECDSA(false)
+
+	msg := []byte("Hello World")
+	sigma1, err := primitives.ECDSASign(key, msg)
+	if err != nil {
+		t.Fatalf("Failed signing [%s]", err)
+	}
+	sigma2, err := primitives.ECDSASign(key, msg)
+	if err != nil {
+		t.Fatalf("Failed signing [%s]", err)
+	}
+	if !bytes.Equal(sigma1, sigma2) {
+		t.Fatal("Deterministic signatures of the same message must be equal")
+	}
+	if ok, err := primitives.ECDSAVerify(&key.PublicKey, msg, sigma1); err != nil || !ok {
+		t.Fatalf("Failed verifying deterministic signature [%s]", err)
+	}
+}
+
+func TestOCSPCertificateStatus(t *testing.T) {
+	caKey, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s].", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	raw, _ := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &caKey.PublicKey, caKey)
+	issuer, _ := x509.ParseCertificate(raw)
+	tmpl = &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "member"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	raw, _ = x509.CreateCertificate(rand.Reader, tmpl, issuer, &caKey.PublicKey, caKey)
+	cert, _ := x509.ParseCertificate(raw)
+
+	status := ocsp.Good
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, err := ocsp.CreateResponse(issuer, issuer, ocsp.Response{
+			Status:       status,
+			SerialNumber: cert.SerialNumber,
+			ThisUpdate:   time.Now(),
+			NextUpdate:   time.Now().Add(time.Hour),
+			RevokedAt:    time.Now(),
+		}, caKey)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(resp)
+	}))
+	defer responder.Close()
+
+	conf := &configuration{ocspEnabled: true, ocspResponder: responder.URL, ocspPolicy: OCSPPolicyHardFail, ocspTimeout: time.Second, ocspCacheTTL: time.Minute}
+	node := &nodeImpl{conf: conf}
+	if err := node.checkCertificateStatus(cert, issuer); err != nil {
+		t.Fatalf("Good certificates must be accepted [%s].", err)
+	}
+
+	// the status is cached until the responder's next update
+	status = ocsp.Revoked
+	if err := node.checkCertificateStatus(cert, issuer); err != nil {
+		t.Fatalf("Cached statuses must be used [%s].", err)
+	}
+	node = &nodeImpl{conf: conf}
+	if err := node.checkCertificateStatus(cert, issuer); err != utils.ErrRevokedCertificate {
+		t.Fatalf("Revoked certificates must be rejected [%v].", err)
+	}
+
+	// unreachable responder
+	responder.Close()
+	node = &nodeImpl{conf: conf}
+	if err := node.checkCertificateStatus(cert, issuer); err != utils.ErrCertificateStatusUnknown {
+		t.Fatalf("Hard-fail must reject certificates with unknown status [%v].", err)
+	}
+	conf.ocspPolicy = OCSPPolicySoftFail
+	if err := node.checkCertificateStatus(cert, issuer); err != nil {
+		t.Fatalf("Soft-fail must accept certificates with unknown status [%s].", err)
+	}
+}
+
+func TestTransactionsPreValidation(t *testing.T) {
+	newCert := func(serial int64, key *ecdsa.PrivateKey, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) *x509.Certificate {
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: fmt.Sprintf("cert%d", serial)},
+			NotBefore:             time.Now().Add(-time.Minute),
+			NotAfter:              time.Now().Add(time.Hour),
+			BasicConstraintsValid: true,
+			IsCA:                  parent == nil,
+		}
+		if parent == nil {
+			parent, parentKey = tmpl, key
+		}
+		raw, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatalf("Failed creating certificate [%s].", err)
+		}
+		cert, _ := x509.ParseCertificate(raw)
+		return cert
+	}
+	caKey, _ := primitives.NewECDSAKey()
+	ca := newCert(1, caKey, nil, nil)
+	otherCAKey, _ := primitives.NewECDSAKey()
+	otherCA := newCert(2, otherCAKey, nil, nil)
+	key, _ := primitives.NewECDSAKey()
+	cert := newCert(3, key, ca, caKey)
+	foreignCert := newCert(4, key, otherCA, otherCAKey)
+
+	csp, _ := newSoftwareCSP("test")
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	peer := &peerImpl{
+		nodeImpl:      &nodeImpl{conf: &configuration{validationWorkers: 3}, csp: csp, ecaCertPool: pool, tcaCertPool: pool},
+		isInitialized: true,
+	}
+
+	var txs []*obc.Transaction
+	for i := 0; i < 10; i++ {
+		tx := &obc.Transaction{Uuid: util.GenerateUUID(), Payload: []byte{byte(i)}, Cert: cert.Raw}
+		if i == 7 {
+			tx.Cert = foreignCert.Raw
+		}
+		raw, _ := proto.Marshal(tx)
+		tx.Signature, _ = csp.Sign(key, raw)
+		txs = append(txs, tx)
+	}
+	txs[3].Payload = []byte("tampered")
+
+	errs := peer.TransactionsPreValidation(txs)
+	for i, err := range errs {
+		switch i {
+		case 3:
+			if err != utils.ErrInvalidTransactionSignature {
+				t.Fatalf("Transaction [%d] must fail signature verification [%v].", i, err)
+			}
+		case 7:
+			if err != utils.ErrInvalidTransactionCertificate {
+				t.Fatalf("Transaction [%d] must fail chain verification [%v].", i, err)
+			}
+		default:
+			if err != nil {
+				t.Fatalf("Transaction [%d] must be valid [%s].", i, err)
+			}
+		}
+	}
+}
+
+type testAuditLog struct {
+	records []*AuditRecord
+}
+
+func (l *testAuditLog) Record(record *AuditRecord) {
+	l.records = append(l.records, record)
+}
+
+func TestAuditorScope(t *testing.T) {
+	auditLog := &testAuditLog{}
+	SetAuditLog(auditLog)
+	defer SetAuditLog(nil)
+
+	auditor := &auditorImpl{validator: newValidator(), chaincodes: map[string]bool{"mycc": true}}
+	auditor.validator.conf = &configuration{}
+	auditor.validator.isInitialized = true
+
+	newTx := func(chaincode string) *obc.Transaction {
+		chaincodeID, _ := proto.Marshal(&obc.ChaincodeID{Name: chaincode})
+		return &obc.Transaction{Uuid: util.GenerateUUID(), ChaincodeID: chaincodeID, ConfidentialityLevel: obc.ConfidentialityLevel_PUBLIC}
+	}
+
+	tx := newTx("mycc")
+	if _, err := auditor.DecryptTransaction(tx); err != nil {
+		t.Fatalf("Transactions in scope must be accessible [%s].", err)
+	}
+	if _, err := auditor.DecryptTransaction(newTx("othercc")); err != utils.ErrAuditAccessDenied {
+		t.Fatalf("Transactions out of scope must be refused [%v].", err)
+	}
+
+	if len(auditLog.records) != 2 {
+		t.Fatalf("Every access must be recorded, got [%d].", len(auditLog.records))
+	}
+	if r := auditLog.records[0]; !r.Granted || r.UUID != tx.Uuid || r.Chaincode != "mycc" {
+		t.Fatalf("Invalid record of a granted access [%v].", r)
+	}
+	if r := auditLog.records[1]; r.Granted || r.Chaincode != "othercc" || r.Err != utils.ErrAuditAccessDenied {
+		t.Fatalf("Invalid record of a refused access [%v].", r)
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	opts := &ClientOptions{
+		Name:      "sdk",
+		DataPath:  filepath.Join(os.TempDir(), "sdk"),
+		ECAAddr:   "eca:7054",
+		TCAAddr:   "tca:7054",
+		TLSCAAddr: "tlsca:7054",
+		Settings:  map[string]interface{}{"security.tcert.batch.size": 7},
+	}
+
+	node := &nodeImpl{eType: NodeClient, confSource: opts.source()}
+	if err := node.initConfiguration(opts.Name); err != nil {
+		t.Fatalf("Failed configuring from options [%s].", err)
+	}
+	if node.conf.getECAPAddr() != "eca:7054" || node.conf.getTCAPAddr() != "tca:7054" || node.conf.getTLSCAPAddr() != "tlsca:7054" {
+		t.Fatal("Endpoints must be taken from the options.")
+	}
+	if node.conf.getKeyStorePath() != filepath.Join(opts.DataPath, "crypto", "client", "sdk", "ks") {
+		t.Fatalf("Invalid keystore path [%s].", node.conf.getKeyStorePath())
+	}
+	if node.conf.tCertBatchSize != 7 || node.conf.isTLSEnabled() {
+		t.Fatal("Settings must be taken from the options.")
+	}
+
+	opts.TCAAddr = ""
+	node = &nodeImpl{eType: NodeClient, confSource: opts.source()}
+	if err := node.initConfiguration(opts.Name); err == nil {
+		t.Fatal("A missing endpoint must be reported.")
+	}
+}
+
+func TestNonceReplay(t *testing.T) {
+	opts := &ClientOptions{
+		DataPath:  filepath.Join(os.TempDir(), "nonces"),
+		ECAAddr:   "eca:7054",
+		TCAAddr:   "tca:7054",
+		TLSCAAddr: "tlsca:7054",
+	}
+	defer os.RemoveAll(opts.DataPath)
+
+	peer := &peerImpl{nodeImpl: &nodeImpl{eType: NodeValidator, confSource: opts.source()}}
+	if err := peer.initConfiguration("nonces"); err != nil {
+		t.Fatalf("Failed configuring [%s].", err)
+	}
+	if err := peer.nodeImpl.initKeyStore(nil); err != nil {
+		t.Fatalf("Failed initializing keystore [%s].", err)
+	}
+	defer peer.close()
+	if err := peer.initKeyStore(); err != nil {
+		t.Fatalf("Failed initializing keystore [%s].", err)
+	}
+	peer.isInitialized = true
+
+	nonce, _ := primitives.GetRandomNonce()
+	tx := &obc.Transaction{Uuid: util.GenerateUUID(), Cert: []byte("cert"), Nonce: nonce}
+	if err := peer.ConsumeTransactionNonce(tx); err != nil {
+		t.Fatalf("A fresh nonce must be accepted [%s].", err)
+	}
+	if err := peer.ConsumeTransactionNonce(tx); err != utils.ErrNonceReplayed {
+		t.Fatalf("A replayed nonce must be rejected [%v].", err)
+	}
+	if err := peer.ConsumeTransactionNonce(&obc.Transaction{Cert: []byte("other"), Nonce: nonce}); err != nil {
+		t.Fatalf("Nonces must be bound to the certificate [%s].", err)
+	}
+	if err := peer.ConsumeTransactionNonce(&obc.Transaction{Cert: []byte("cert"), Nonce: nonce[:4]}); err != utils.ErrInvalidNonce {
+		t.Fatalf("A malformed nonce must be rejected [%v].", err)
+	}
+}
+
+func TestTLSBinding(t *testing.T) {
+	newCert := func(serial int64, key *ecdsa.PrivateKey, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) *x509.Certificate {
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			NotBefore:             time.Now().Add(-time.Minute),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+			BasicConstraintsValid: true,
+			IsCA:                  parent == nil,
+		}
+		if parent == nil {
+			parent, parentKey = tmpl, key
+		}
+		raw, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatalf("Failed creating certificate [%s].", err)
+		}
+		cert, _ := x509.ParseCertificate(raw)
+		return cert
+	}
+	ecaKey, _ := primitives.NewECDSAKey()
+	eca := newCert(1, ecaKey, nil, nil)
+	enrollKey, _ := primitives.NewECDSAKey()
+	tlsKey, _ := primitives.NewECDSAKey()
+
+	csp, _ := newSoftwareCSP("")
+	client := &nodeImpl{conf: &configuration{}, csp: csp, enrollSignKey: enrollKey,
+		enrollCert: newCert(2, enrollKey, eca, ecaKey), tlsCert: newCert(3, tlsKey, nil, nil)}
+	binding, err := client.GetTLSBinding()
+	if err != nil {
+		t.Fatalf("Failed creating TLS binding [%s].", err)
+	}
+
+	peer := &peerImpl{nodeImpl: &nodeImpl{conf: &configuration{}, csp: csp, ecaCertPool: x509.NewCertPool()}}
+	peer.ecaCertPool.AddCert(eca)
+	eCert, err := peer.verifyTLSBinding(client.tlsCert, binding)
+	if err != nil || !eCert.Equal(client.enrollCert) {
+		t.Fatalf("A valid TLS binding must be accepted [%v].", err)
+	}
+	if _, err := peer.verifyTLSBinding(newCert(4, tlsKey, nil, nil), binding); err != utils.ErrInvalidTLSBinding {
+		t.Fatalf("A TLS binding must not be accepted for another TLS certificate [%v].", err)
+	}
+
+	if _, err := peer.VerifyTLSBinding(context.Background()); err != nil {
+		t.Fatalf("Requests without TLS binding must be accepted unless required [%s].", err)
+	}
+	peer.conf.tlsBindingRequired = true
+	if _, err := peer.VerifyTLSBinding(context.Background()); err != utils.ErrInvalidTLSBinding {
+		t.Fatalf("Requests without TLS binding must be rejected when required [%v].", err)
+	}
+}
+
+type testRegistryNode struct {
+	name   string
+	closed int32
+}
+
+func (n *testRegistryNode) GetType() NodeType              { return NodeClient }
+func (n *testRegistryNode) GetName() string                { return n.name }
+func (n *testRegistryNode) GetTLSBinding() ([]byte, error) { return nil, nil }
+func (n *testRegistryNode) close() error                   { atomic.AddInt32(&n.closed, 1); return nil }
+
+func TestRegistry(t *testing.T) {
+	r := newRegistry(NodeClient)
+	var inits int32
+	init := func() (registryNode, error) {
+		atomic.AddInt32(&inits, 1)
+		return &testRegistryNode{name: "node"}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.acquire("node", true, init); err != nil {
+				t.Errorf("Failed acquiring node [%s].", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if inits != 1 {
+		t.Fatalf("Concurrent acquisitions must share the node, initialized [%d] times.", inits)
+	}
+	if refs := r.list(); len(refs) != 1 || refs[0].References != 10 {
+		t.Fatalf("Invalid references [%v].", refs)
+	}
+	if _, err := r.acquire("node", false, init); err != utils.ErrAlreadyInitialized {
+		t.Fatalf("Exclusive acquisitions must fail on initialized nodes [%v].", err)
+	}
+
+	node, _ := r.acquire("node", true, init)
+	if err := r.release(&testRegistryNode{name: "node"}, false); err != utils.ErrInvalidReference {
+		t.Fatalf("Only the registered instance can be released [%v].", err)
+	}
+	for i := 0; i < 11; i++ {
+		if err := r.release(node, false); err != nil {
+			t.Fatalf("Failed releasing node [%s].", err)
+		}
+	}
+	if atomic.LoadInt32(&node.(*testRegistryNode).closed) != 1 || len(r.list()) != 0 {
+		t.Fatal("The node must be closed with its last reference.")
+	}
+	if err := r.release(node, false); err != utils.ErrInvalidReference {
+		t.Fatalf("Closed nodes can not be released [%v].", err)
+	}
+
+	SetRegistryIdleTimeout(50 * time.Millisecond)
+	defer SetRegistryIdleTimeout(0)
+	node, _ = r.acquire("node", true, init)
+	r.release(node, false)
+	if again, _ := r.acquire("node", true, init); again != node {
+		t.Fatal("Idle nodes must be reused.")
+	}
+	r.release(node, false)
+	time.Sleep(200 * time.Millisecond)
+	if atomic.LoadInt32(&node.(*testRegistryNode).closed) != 1 || len(r.list()) != 0 {
+		t.Fatal("Idle nodes must be evicted.")
+	}
+}
+
+func TestCertCache(t *testing.T) {
+	newCert := func(serial int64, notAfter time.Time) *x509.Certificate {
+		key, _ := primitives.NewECDSAKey()
+		tmpl := &x509.Certificate{SerialNumber: big.NewInt(serial), NotBefore: time.Now().Add(-time.Hour), NotAfter: notAfter}
+		raw, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		if err != nil {
+			t.Fatalf("Failed creating certificate [%s].", err)
+		}
+		cert, _ := x509.ParseCertificate(raw)
+		return cert
+	}
+	valid := time.Now().Add(time.Hour)
+	first, second, third := newCert(1, valid), newCert(2, valid), newCert(3, valid)
+
+	cache := &certCache{}
+	cache.put(first, 2)
+	cache.put(second, 2)
+	if _, ok := cache.get(first.Raw); !ok {
+		t.Fatal("Cached certificates must be found.")
+	}
+	cache.put(third, 2)
+	if _, ok := cache.get(second.Raw); ok {
+		t.Fatal("The least recently used certificate must be evicted.")
+	}
+	if _, ok := cache.get(first.Raw); !ok {
+		t.Fatal("Recently used certificates must be kept.")
+	}
+
+	expired := newCert(4, time.Now().Add(-time.Minute))
+	cache.put(expired, 2)
+	if _, ok := cache.get(expired.Raw); ok {
+		t.Fatal("Expired certificates must not be returned.")
+	}
+
+	cache.purge()
+	if _, ok := cache.get(first.Raw); ok {
+		t.Fatal("Purged certificates must not be returned.")
+	}
+}
+
+func TestSecp256k1(t *testing.T) {
+	curve, err := primitives.GetCurve("secp256k1")
+	if err != nil {
+		t.Fatalf("Failed getting curve [%s].", err)
+	}
+	x, y := curve.ScalarBaseMult([]byte{2})
+	if fmt.Sprintf("%X", x.Bytes()) != "C6047F9441ED7D6D3045406E95C07CD85C778E4B8CEF3CA7ABAC09B95C709EE5" ||
+		fmt.Sprintf("%X", y.Bytes()) != "1AE168FEA63DC339A3C58419466CEAEEF7F632653266D0E1236431A950CFE52A" {
+		t.Fatal("2G does not match the secp256k1 test vector.")
+	}
+
+	key, err := primitives.NewECDSAKeyOnCurve(curve)
+	if err != nil {
+		t.Fatalf("Failed generating key [%s].", err)
+	}
+	signature, err := primitives.ECDSASign(key, []byte("msg"))
+	if err != nil {
+		t.Fatalf("Failed signing [%s].", err)
+	}
+	if ok, _ := primitives.ECDSAVerify(&key.PublicKey, []byte("msg"), signature); !ok {
+		t.Fatal("secp256k1 signatures must verify.")
+	}
+
+	caKey, _ := primitives.NewECDSAKey()
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	raw, _ := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	caCert, _ := x509.ParseCertificate(raw)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "member"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	raw, err = secp256k1.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed creating certificate [%s].", err)
+	}
+	cert, err := utils.DERToX509Certificate(raw)
+	if err != nil {
+		t.Fatalf("Failed parsing certificate [%s].", err)
+	}
+	if err := cert.CheckSignatureFrom(caCert); err != nil {
+		t.Fatalf("Failed verifying certificate [%s].", err)
+	}
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok || pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 || !secp256k1.IsCurve(pub.Curve) {
+		t.Fatal("The certificate must carry the secp256k1 key.")
+	}
+}
+
+func TestKeyStoreBackends(t *testing.T) {
+	for _, backend := range []string{KeyStoreBackendSQLite, KeyStoreBackendFiles} {
+		dir, err := ioutil.TempDir("", "TestKeyStoreBackends")
+		if err != nil {
+			t.Fatalf("Failed creating directory [%s]", err)
+		}
+		defer os.RemoveAll(dir)
+
+		node := &nodeImpl{conf: &configuration{name: "node", keystorePath: dir, rawsPath: filepath.Join(dir, "raw"), keyStoreBackend: backend}}
+		open := func() keyStoreBackend {
+			ks := &keyStore{}
+			if err := ks.init(node, nil); err != nil {
+				t.Fatalf("Failed opening [%s] keystore [%s]", backend, err)
+			}
+			if err := ks.backend.initClient(); err != nil {
+				t.Fatalf("Failed creating [%s] client tables [%s]", backend, err)
+			}
+			node.ks = ks
+			return ks.backend
+		}
+
+		store := open()
+		if err := store.insertClientNonce([]byte("nonce")); err != nil {
+			t.Fatalf("Failed inserting nonce [%s]", err)
+		}
+		if err := store.insertClientNonce([]byte("nonce")); err != utils.ErrNonceReplayed {
+			t.Fatalf("[%s] Replayed nonces must be rejected [%v]", backend, err)
+		}
+		if err := store.storeTCertsDataKey([]byte("old")); err != nil {
+			t.Fatalf("Failed storing data key [%s]", err)
+		}
+		store.storeTCertsDataKey([]byte("new"))
+		if wrapped, _ := store.selectTCertsDataKey(); string(wrapped) != "new" {
+			t.Fatalf("[%s] The data key must be replaced, got [%s]", backend, wrapped)
+		}
+		entries := []*unusedTCertEntry{{cert: []byte("a"), version: 2, mac: []byte{1}}, {cert: []byte("b"), version: 2, mac: []byte{2}}}
+		if err := store.insertUnusedTCerts(entries); err != nil {
+			t.Fatalf("Failed inserting unused TCerts [%s]", err)
+		}
+		dataKey, _ := primitives.GenAESKey()
+		keys := &unusedTCertsKeys{data: dataKey}
+		reserved := []*reservedTCert{{Cert: []byte("a"), Index: []byte{1}}, {Cert: []byte("b"), Index: []byte{2}}, {Cert: []byte("c"), Index: []byte{3}}}
+		if err := node.ks.storeReservedTCerts("x", reserved, keys); err != nil {
+			t.Fatalf("Failed storing reserved TCerts [%s]", err)
+		}
+		node.ks.storeReservedTCerts("y", reserved[:1], keys)
+		node.ks.close()
+
+		// Entries survive reopening
+		store = open()
+		defer node.ks.close()
+		loaded, err := store.selectUnusedTCerts()
+		if err != nil || len(loaded) != 2 || string(loaded[0].cert) != "a" || loaded[1].version != 2 || !bytes.Equal(loaded[1].mac, []byte{2}) {
+			t.Fatalf("[%s] Failed selecting unused TCerts [%v]", backend, err)
+		}
+		store.insertUnusedTCerts([]*unusedTCertEntry{{cert: []byte("c"), version: 2}})
+		if err := store.deleteUnusedTCerts([]int64{loaded[0].id}); err != nil {
+			t.Fatalf("Failed deleting unused TCerts [%s]", err)
+		}
+		if loaded, _ = store.selectUnusedTCerts(); len(loaded) != 2 || string(loaded[0].cert) != "b" || string(loaded[1].cert) != "c" {
+			t.Fatalf("[%s] Deleted TCerts must be gone and new ones must not overwrite others", backend)
+		}
+
+		// Reserved TCerts are taken by attributes, the oldest first
+		if count, err := node.ks.countReservedTCerts("x"); err != nil || count != 3 {
+			t.Fatalf("[%s] Failed counting reserved TCerts, [%d] counted [%v]", backend, count, err)
+		}
+		taken, err := node.ks.takeReservedTCerts("x", 2, keys)
+		if err != nil || len(taken) != 2 || string(taken[0].Cert) != "a" || !bytes.Equal(taken[1].Index, []byte{2}) {
+			t.Fatalf("[%s] Failed taking reserved TCerts [%v]", backend, err)
+		}
+		if count, _ := node.ks.countReservedTCerts("x"); count != 1 {
+			t.Fatalf("[%s] Taken TCerts must leave the reserve, [%d] left", backend, count)
+		}
+		if count, _ := node.ks.countReservedTCerts("y"); count != 1 {
+			t.Fatalf("[%s] TCerts carrying other attributes must stay in the reserve, [%d] left", backend, count)
+		}
+		otherKey, _ := primitives.GenAESKey()
+		if taken, _ = node.ks.takeReservedTCerts("y", 2, &unusedTCertsKeys{data: otherKey}); len(taken) != 0 {
+			t.Fatalf("[%s] Reserved TCerts not opening must be discarded", backend)
+		}
+		if count, _ := node.ks.countReservedTCerts("y"); count != 0 {
+			t.Fatalf("[%s] Discarded TCerts must leave the reserve, [%d] left", backend, count)
+		}
+
+		// Used TCerts are removed by age, then by count, the oldest first
+		now := time.Now()
+		for i := 4; i > 0; i-- {
+			if err := store.insertUsedTCert([]byte{byte(i)}, now.Add(-time.Duration(i)*time.Hour)); err != nil {
+				t.Fatalf("Failed inserting used TCert [%s]", err)
+			}
+		}
+		if deleted, err := store.deleteUsedTCerts(now.Add(-150*time.Minute), 0); err != nil || deleted != 2 {
+			t.Fatalf("[%s] Used TCerts older than the limit must be removed, [%d] removed [%v]", backend, deleted, err)
+		}
+		if deleted, err := store.deleteUsedTCerts(time.Time{}, 1); err != nil || deleted != 1 {
+			t.Fatalf("[%s] Used TCerts beyond the count must be removed, [%d] removed [%v]", backend, deleted, err)
+		}
+		if deleted, _ := store.deleteUsedTCerts(now, 0); deleted != 1 {
+			t.Fatalf("[%s] The remaining used TCert must be removed, [%d] removed", backend, deleted)
+		}
+	}
+}
+
+func TestCACertsChain(t *testing.T) {
+	newCA := func(serial int64, key *ecdsa.PrivateKey, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) *x509.Certificate {
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: fmt.Sprintf("ca%d", serial)},
+			NotBefore:             time.Now().Add(-time.Minute),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		if parent == nil {
+			parent, parentKey = tmpl, key
+		}
+		raw, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatalf("Failed creating certificate [%s].", err)
+		}
+		cert, _ := x509.ParseCertificate(raw)
+		return cert
+	}
+	rootKey, _ := primitives.NewECDSAKey()
+	root := newCA(1, rootKey, nil, nil)
+	interKey, _ := primitives.NewECDSAKey()
+	inter := newCA(2, interKey, root, rootKey)
+	ecaKey, _ := primitives.NewECDSAKey()
+	eca := newCA(3, ecaKey, inter, interKey)
+	subKey, _ := primitives.NewECDSAKey()
+	sub := newCA(4, subKey, eca, ecaKey)
+	member := newCA(5, subKey, sub, subKey)
+
+	dir, err := ioutil.TempDir("", "TestCACertsChain")
+	if err != nil {
+		t.Fatalf("Failed creating directory [%s].", err)
+	}
+	defer os.RemoveAll(dir)
+	rootsPath := filepath.Join(dir, "roots.pem")
+	intermediatesPath := filepath.Join(dir, "intermediates.pem")
+	ioutil.WriteFile(rootsPath, utils.DERCertToPEM(root.Raw), 0600)
+	ioutil.WriteFile(intermediatesPath, utils.DERCertToPEM(sub.Raw), 0600)
+
+	node := &nodeImpl{conf: &configuration{chainRootsPath: rootsPath, chainIntermediatesPath: intermediatesPath}}
+	node.ks = &keyStore{node: node}
+	if err := node.initCertsChains(); err != nil {
+		t.Fatalf("Failed loading certificates chains [%s].", err)
+	}
+
+	// the ECA certificate alone does not chain to the root
+	if _, err := node.verifyCACertsChain(eca.Raw); err == nil {
+		t.Fatal("Incomplete chains must be rejected.")
+	}
+	chain, err := node.verifyCACertsChain(append(append([]byte(nil), eca.Raw...), inter.Raw...))
+	if err != nil {
+		t.Fatalf("Complete chains must be accepted [%s].", err)
+	}
+	if len(chain) != 2 || !chain[0].Equal(eca) {
+		t.Fatal("The CA certificate must come first.")
+	}
+
+	// certificates issued by an intermediate below the ECA
+	ecaCertPool := x509.NewCertPool()
+	ecaCertPool.AddCert(eca)
+	if _, err := node.verifyCertificate(member, ecaCertPool); err != nil {
+		t.Fatalf("Certificates issued by intermediates must be accepted [%s].", err)
+	}
+	if _, err := utils.CheckCertAgainRoot(member, ecaCertPool); err == nil {
+		t.Fatal("Certificates must not chain without intermediates.")
+	}
+}
+
+func TestKeyStorePassphrase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestKeyStorePassphrase")
+	if err != nil {
+		t.Fatalf("Failed creating directory [%s]", err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := &configuration{
+		name:            "node",
+		keystorePath:    dir,
+		rawsPath:        filepath.Join(dir, "raw"),
+		keyStoreBackend: KeyStoreBackendSQLite,
+		keyStoreScryptN: 1 << 10,
+		keyStoreScryptR: 8,
+		keyStoreScryptP: 1,
+	}
+	node := &nodeImpl{conf: conf}
+	openKeyStore := func(pwd string) error {
+		ks := &keyStore{}
+		if err := ks.init(node, []byte(pwd)); err != nil {
+			return err
+		}
+		node.ks = ks
+		return nil
+	}
+
+	// An entry stored before the keystore is protected by a passphrase key
+	if err := openKeyStore("old"); err != nil {
+		t.Fatalf("Failed opening keystore [%s]", err)
+	}
+	key, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s]", err)
+	}
+	if err := node.ks.storePrivateKey("key", key); err != nil {
+		t.Fatalf("Failed storing key [%s]", err)
+	}
+	node.ks.close()
+
+	conf.keyStoreKDF = KeyStoreKDFScrypt
+	if err := openKeyStore("old"); err != nil {
+		t.Fatalf("Failed unlocking keystore [%s]", err)
+	}
+	raw, _ := ioutil.ReadFile(conf.getPathForAlias("key"))
+	if !bytes.Contains(raw, []byte(sealedKeyStoreEntry)) {
+		t.Fatal("Entries must be sealed once the keystore is protected by a passphrase key")
+	}
+	if err := node.ks.rotatePassphrase([]byte("new")); err != nil {
+		t.Fatalf("Failed rotating passphrase [%s]", err)
+	}
+	node.ks.close()
+
+	if err := openKeyStore("old"); err != utils.ErrInvalidPassphrase {
+		t.Fatalf("Unlocking with the old passphrase must fail [%v]", err)
+	}
+	if err := openKeyStore("new"); err != nil {
+		t.Fatalf("Failed unlocking keystore with the new passphrase [%s]", err)
+	}
+	defer node.ks.close()
+	loaded, err := node.ks.loadPrivateKey("key")
+	if err != nil {
+		t.Fatalf("Failed loading key [%s]", err)
+	}
+	if loaded.(*ecdsa.PrivateKey).D.Cmp(key.D) != 0 {
+		t.Fatal("Loaded key differs from the stored one")
+	}
+}
+
+type testKeyring map[string][]byte
+
+func (keyring testKeyring) Get(service, user string) ([]byte, error) {
+	return keyring[service+"/"+user], nil
+}
+
+func (keyring testKeyring) Set(service, user string, secret []byte) error {
+	keyring[service+"/"+user] = secret
+	return nil
+}
+
+func TestKeyStoreCipherKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestKeyStoreCipherKey")
+	if err != nil {
+		t.Fatalf("Failed creating directory [%s]", err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := &configuration{
+		prefix:                 "client",
+		name:                   "node",
+		keystorePath:           dir,
+		keyStoreScryptN:        1 << 10,
+		keyStoreScryptR:        8,
+		keyStoreScryptP:        1,
+		keyStoreCipherKey:      KeyStoreCipherKeyPassphrase,
+		keyStoreKeyringService: "test",
+	}
+	node := &nodeImpl{conf: conf}
+
+	// The key derived from the passphrase must be stable
+	key, err := node.getKeyStoreCipherKey([]byte("pwd"))
+	if err != nil {
+		t.Fatalf("Failed deriving keystore key [%s]", err)
+	}
+	again, err := node.getKeyStoreCipherKey([]byte("pwd"))
+	if err != nil || !bytes.Equal(key, again) {
+		t.Fatalf("The keystore key must be derived again from the passphrase [%v]", err)
+	}
+	if _, err := node.getKeyStoreCipherKey([]byte("wrong")); err != utils.ErrInvalidPassphrase {
+		t.Fatalf("A wrong passphrase must be rejected [%v]", err)
+	}
+	if _, err := node.getKeyStoreCipherKey(nil); err != utils.ErrInvalidPassphrase {
+		t.Fatalf("A passphrase must be required [%v]", err)
+	}
+
+	// The key kept in the keyring is generated once
+	conf.keyStoreCipherKey = KeyStoreCipherKeyKeyring
+	if _, err := node.getKeyStoreCipherKey(nil); err == nil {
+		t.Fatal("A keyring must be required")
+	}
+	keyring := testKeyring{}
+	SetKeyStoreKeyring(keyring)
+	defer SetKeyStoreKeyring(nil)
+
+	key, err = node.getKeyStoreCipherKey(nil)
+	if err != nil || len(key) != 32 {
+		t.Fatalf("Failed generating keystore key [%v]", err)
+	}
+	if !bytes.Equal(keyring["test/client.node"], key) {
+		t.Fatal("The keystore key must be stored in the keyring")
+	}
+	if again, err := node.getKeyStoreCipherKey(nil); err != nil || !bytes.Equal(key, again) {
+		t.Fatalf("The keystore key must be read from the keyring [%v]", err)
+	}
+}
+
+func TestClientExportImport(t *testing.T) {
+	conf := utils.NodeConfiguration{Type: "client", Name: "TestClientExportImport"}
+	if err := RegisterClient(conf.Name, ksPwd, conf.GetEnrollmentID(), conf.GetEnrollmentPWD()); err != nil {
+		t.Fatalf("Failed client registration [%s]", err)
+	}
+	client, err := InitClient(conf.Name, ksPwd)
+	if err != nil {
+		t.Fatalf("Failed client initialization [%s]", err)
+	}
+	eCert, _ := client.GetEnrollmentCertificateHandler()
+	if _, err := client.GetNextTCert(); err != nil {
+		t.Fatalf("Failed getting tcert [%s]", err)
+	}
+	if _, err := ExportClient(conf.Name, ksPwd, []byte("passphrase")); err != utils.ErrAlreadyInitialized {
+		t.Fatalf("Exporting an initialized client must fail [%v]", err)
+	}
+	// Closing stores the unused TCerts in the keystore
+	CloseClient(client)
+
+	archive, err := ExportClient(conf.Name, ksPwd, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("Failed exporting client [%s]", err)
+	}
+	exported, err := openKeyStoreArchive(archive, []byte("passphrase"))
+	if err != nil || len(exported.UnusedTCerts) == 0 {
+		t.Fatalf("The archive must hold the unused TCerts [%v]", err)
+	}
+	for _, entry := range exported.Entries {
+		if entry.Alias == "tcerts.master.key" {
+			t.Fatal("The TCerts master key must not be exported")
+		}
+	}
+
+	name := conf.Name + "Imported"
+	if err := ImportClient(name, ksPwd, []byte("wrong"), archive); err != utils.ErrInvalidPassphrase {
+		t.Fatalf("Importing with a wrong passphrase must fail [%v]", err)
+	}
+	if err := ImportClient(name, ksPwd, []byte("passphrase"), archive); err != nil {
+		t.Fatalf("Failed importing client [%s]", err)
+	}
+	if err := ImportClient(name, ksPwd, []byte("passphrase"), archive); err != utils.ErrAlreadyRegistered {
+		t.Fatalf("Importing over a registered client must fail [%v]", err)
+	}
+
+	// The unused TCerts are preserved
+	reexported, err := ExportClient(name, ksPwd, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("Failed exporting imported client [%s]", err)
+	}
+	if content, _ := openKeyStoreArchive(reexported, []byte("passphrase")); len(content.UnusedTCerts) != len(exported.UnusedTCerts) {
+		t.Fatalf("Unused TCerts must be imported, [%d] out of [%d]", len(content.UnusedTCerts), len(exported.UnusedTCerts))
+	}
+
+	imported, err := InitClient(name, ksPwd)
+	if err != nil {
+		t.Fatalf("Failed initializing imported client [%s]", err)
+	}
+	defer CloseClient(imported)
+	handler, err := imported.GetEnrollmentCertificateHandler()
+	if err != nil || !bytes.Equal(handler.GetCertificate(), eCert.GetCertificate()) {
+		t.Fatalf("The imported client must hold the enrollment certificate [%v]", err)
+	}
+	if _, err := imported.GetNextTCert(); err != nil {
+		t.Fatalf("Failed getting tcert from the imported client [%s]", err)
+	}
+}
+
+func TestNodeHSMKeys(t *testing.T) {
+	hsm := &testHSM{make(map[string]*ecdsa.PrivateKey)}
+	if err := RegisterHSMProvider("TestNodeHSMKeys", func(name string) (HSM, error) { return hsm, nil }); err != nil {
+		t.Fatalf("Failed registering HSM provider [%s]", err)
+	}
+	if err := RegisterHSMProvider("TestNodeHSMKeys", func(name string) (HSM, error) { return hsm, nil }); err == nil {
+		t.Fatal("Registering a provider twice must fail")
+	}
+
+	csp, err := newCSP(CSPSoftware, "node")
+	if err != nil {
+		t.Fatalf("Failed creating software provider [%s]", err)
+	}
+	node := &nodeImpl{
+		eType: NodeValidator,
+		conf:  &configuration{name: "node", hsmProvider: "TestNodeHSMKeys", hsmKeys: map[string]bool{HSMKeyEnrollment: true}},
+		csp:   csp,
+	}
+	if err := node.initHSM(); err != nil {
+		t.Fatalf("Failed opening HSM [%s]", err)
+	}
+
+	key, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s]", err)
+	}
+	if err := node.storePrivateKeyOfClass(HSMKeyEnrollment, "enrollment.key", key); err != nil {
+		t.Fatalf("Failed storing key on the HSM [%s]", err)
+	}
+	handle, err := node.loadPrivateKeyOfClass(HSMKeyEnrollment, "enrollment.key")
+	if err != nil {
+		t.Fatalf("Failed loading key from the HSM [%s]", err)
+	}
+	if _, ok := handle.(*hsmPrivateKey); !ok {
+		t.Fatal("Key held by the HSM must be loaded as a handle")
+	}
+
+	node.enrollSignKey = handle
+	msg := []byte("Hello World")
+	signature, err := node.signWithEnrollmentKey(msg)
+	if err != nil {
+		t.Fatalf("Failed signing on the HSM [%s]", err)
+	}
+	if ok, err := csp.Verify(&key.PublicKey, msg, signature); err != nil || !ok {
+		t.Fatalf("Failed verifying signature made on the HSM [%s]", err)
+	}
+	r, s, err := node.ecdsaSignWithEnrollmentKey(msg)
+	if err != nil || !ecdsa.Verify(&key.PublicKey, primitives.Hash(msg), r, s) {
+		t.Fatalf("Failed verifying ECDSA signature made on the HSM [%s]", err)
+	}
+
+	// Clients derive their TCert keys from the enrollment key, which an
+	// HSM unable to export it cannot keep
+	node.eType = NodeClient
+	if err := node.initHSM(); err == nil {
+		t.Fatal("Keeping the enrollment key of a client on the HSM must fail")
+	}
+}
+
+func TestEnrollmentErrors(t *testing.T) {
+	errs := map[error]error{
+		grpc.Errorf(codes.ResourceExhausted, "Maximum number of enrollments reached."): utils.ErrEnrollmentLimitReached,
+		grpc.Errorf(codes.PermissionDenied, "Registration expired."):                   utils.ErrRegistrationExpired,
+		grpc.Errorf(codes.ResourceExhausted, "Too many requests, retry in 1s."):        utils.ErrRateLimited,
+		utils.ErrInvalidSignature: utils.ErrInvalidSignature,
+	}
+	for err, expected := range errs {
+		if actual := enrollmentError(err); actual != expected {
+			t.Fatalf("Expected [%s] for [%s], got [%s]", expected, err, actual)
+		}
+	}
+}
+
+func TestEnrollmentCSRWithHSMKey(t *testing.T) {
+	hsm := &testHSM{make(map[string]*ecdsa.PrivateKey)}
+	if err := RegisterHSMProvider("TestEnrollmentCSRWithHSMKey", func(name string) (HSM, error) { return hsm, nil }); err != nil {
+		t.Fatalf("Failed registering HSM provider [%s]", err)
+	}
+
+	node := &nodeImpl{
+		eType: NodeValidator,
+		conf:  &configuration{name: "node", hsmProvider: "TestEnrollmentCSRWithHSMKey", hsmKeys: map[string]bool{HSMKeyEnrollment: true}},
+	}
+	if err := node.initHSM(); err != nil {
+		t.Fatalf("Failed opening HSM [%s]", err)
+	}
+
+	// The enrollment key is generated on the device
+	signer, err := node.generateEnrollmentSigner()
+	if err != nil {
+		t.Fatalf("Failed generating enrollment key [%s]", err)
+	}
+	key, ok := signer.(*hsmPrivateKey)
+	if !ok || hsm.keys[key.label] == nil {
+		t.Fatal("The enrollment key must be generated on the HSM")
+	}
+
+	raw, err := newCertificateRequest("node", signer)
+	if err != nil {
+		t.Fatalf("Failed creating certificate request [%s]", err)
+	}
+	csr, err := x509.ParseCertificateRequest(raw)
+	if err != nil {
+		t.Fatalf("Failed parsing certificate request [%s]", err)
+	}
+	if err := csr.CheckSignature(); err != nil || csr.Subject.CommonName != "node" {
+		t.Fatalf("The certificate request must be signed on the HSM for the node [%v]", err)
+	}
+
+	// Storing a key generated on the device leaves it there
+	if err := node.storePrivateKeyOfClass(HSMKeyEnrollment, node.conf.getEnrollmentKeyFilename(), signer); err != nil {
+		t.Fatalf("Failed storing key generated on the HSM [%s]", err)
+	}
+	handle, err := node.loadPrivateKeyOfClass(HSMKeyEnrollment, node.conf.getEnrollmentKeyFilename())
+	if err != nil || handle.(*hsmPrivateKey).pub.X.Cmp(key.pub.X) != 0 {
+		t.Fatalf("Failed loading key generated on the HSM [%v]", err)
+	}
+}
+
+type testSecretStore struct {
+	lock    sync.Mutex
+	secrets map[string]string
+	renewed int
+	closed  bool
+}
+
+func (store *testSecretStore) Read(path string) (*Secret, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	value, ok := store.secrets[path]
+	if !ok {
+		return nil, fmt.Errorf("Secret [%s] not found", path)
+	}
+	return &Secret{Value: []byte(value), LeaseID: path, Lease: 30 * time.Millisecond, Renewable: true}, nil
+}
+
+func (store *testSecretStore) Renew(secret *Secret) (*Secret, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.renewed++
+	return secret, nil
+}
+
+func (store *testSecretStore) Close() error {
+	store.closed = true
+	return nil
+}
+
+func TestNodeSecretStore(t *testing.T) {
+	store := &testSecretStore{secrets: map[string]string{"fabric/pwd": "passphrase"}}
+	if err := RegisterSecretStoreProvider("TestNodeSecretStore", func(name string) (SecretStore, error) { return store, nil }); err != nil {
+		t.Fatalf("Failed registering secret store provider [%s]", err)
+	}
+	if err := RegisterSecretStoreProvider("TestNodeSecretStore", func(name string) (SecretStore, error) { return store, nil }); err == nil {
+		t.Fatal("Registering a provider twice must fail")
+	}
+
+	node := &nodeImpl{conf: &configuration{
+		name:            "node",
+		secretsProvider: "TestNodeSecretStore",
+		secretsPaths:    map[string]string{SecretKeyStorePassphrase: "fabric/pwd", SecretEnrollPassword: "fabric/missing"},
+	}}
+	if err := node.initSecretStore(); err != nil {
+		t.Fatalf("Failed connecting to secret store [%s]", err)
+	}
+
+	pwd, err := node.readSecret(SecretKeyStorePassphrase)
+	if err != nil || string(pwd) != "passphrase" {
+		t.Fatalf("Failed reading secret [%v]", err)
+	}
+	if _, err := node.readSecret(SecretEnrollPassword); err == nil {
+		t.Fatal("Reading a missing secret must fail")
+	}
+	if secret, err := node.readSecret(SecretTLSKey); err != nil || secret != nil {
+		t.Fatalf("Secrets without path must not be read [%v]", err)
+	}
+
+	// The leases on the secrets read are renewed until the node is closed
+	node.startSecretsRenewal()
+	time.Sleep(100 * time.Millisecond)
+	if err := node.closeSecretStore(); err != nil {
+		t.Fatalf("Failed closing secret store [%s]", err)
+	}
+	if store.renewed == 0 {
+		t.Fatal("The lease on the secret must be renewed")
+	}
+	if !store.closed {
+		t.Fatal("The secret store must be closed")
+	}
+}
+
+func TestClientRegisterTCertPoolProvider(t *testing.T) {
+	provider := func(client TCertPoolClient) (TCertPool, error) {
+		return newTCertPoolSingleThread(client)
+	}
+
+	if err := RegisterTCertPoolProvider("", provider); err == nil {
+		t.Fatal("Registering a provider with an empty name must fail")
+	}
+	if err := RegisterTCertPoolProvider(TCertPoolSingleThread, provider); err == nil {
+		t.Fatal("Registering a provider twice must fail")
+	}
+	if err := RegisterTCertPoolProvider("TestClientRegisterTCertPoolProvider", provider); err != nil {
+		t.Fatalf("Failed registering provider [%s]", err)
+	}
+
+	found := false
+	for _, name := range GetTCertPoolProviders() {
+		if name == "TestClientRegisterTCertPoolProvider" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("Registered provider not listed")
+	}
+
+	tCertPool, err := newTCertPool("TestClientRegisterTCertPoolProvider", deployer.(*clientImpl))
+	if err != nil {
+		t.Fatalf("Failed creating TCert pool [%s]", err)
+	}
+	if tCertPool == nil {
+		t.Fatal("TCert pool should be different from nil")
+	}
+
+	if _, err := newTCertPool("unknown", deployer.(*clientImpl)); err == nil {
+		t.Fatal("Creating a TCert pool from an unknown provider must fail")
+	}
+}
+
+func TestTCertPoolSizer(t *testing.T) {
+	conf := &configuration{
+		tCertBatchSize:    100,
+		tCertPoolAdaptive: true,
+		tCertPoolMinSize:  10,
+		tCertPoolMaxSize:  500,
+	}
+	sizer := newTCertPoolSizer(conf)
+
+	if sizer.target() != 10 {
+		t.Fatalf("Target size must start at the minimum. Expected [%d], Actual [%d]", 10, sizer.target())
+	}
+
+	// Burst of requests
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 100; j++ {
+			sizer.recordRequest()
+		}
+		sizer.recordRefill(500 * time.Millisecond)
+		sizer.lastUpdate = time.Now().Add(-time.Second)
+		sizer.update(time.Second)
+	}
+	grown := sizer.target()
+	if grown <= 10 {
+		t.Fatalf("Target size must grow under load. Actual [%d]", grown)
+	}
+	if grown > 500 {
+		t.Fatalf("Target size must not exceed the maximum. Actual [%d]", grown)
+	}
+
+	// Idle
+	for i := 0; i < 50; i++ {
+		sizer.lastUpdate = time.Now().Add(-time.Second)
+		sizer.update(time.Second)
+	}
+	if sizer.target() != 10 {
+		t.Fatalf("Target size must shrink to the minimum when idle. Actual [%d]", sizer.target())
+	}
+
+	// Not adaptive
+	conf.tCertPoolAdaptive = false
+	sizer = newTCertPoolSizer(conf)
+	for j := 0; j < 1000; j++ {
+		sizer.recordRequest()
+	}
+	sizer.lastUpdate = time.Now().Add(-time.Second)
+	sizer.update(time.Second)
+	if sizer.target() != 100 || sizer.refillSize(50) != 150 {
+		t.Fatalf("Non adaptive sizer must stick to the batch size. Actual [%d]", sizer.target())
+	}
+}
+
+func TestTCertPoolManagerRound(t *testing.T) {
+	manager := newTCertPoolManager()
+	a, b, c := new(tCertPoolMultithreadingImpl), new(tCertPoolMultithreadingImpl), new(tCertPoolMultithreadingImpl)
+	manager.pools = []*tCertPoolMultithreadingImpl{a, b, c}
+
+	for i, first := range []*tCertPoolMultithreadingImpl{a, b, c, a} {
+		round := manager.round()
+		if len(round) != 3 {
+			t.Fatalf("A round must serve all the pools. Expected [%d], Actual [%d]", 3, len(round))
+		}
+		if round[0] != first {
+			t.Fatalf("Round [%d] must start from the next pool", i)
+		}
+	}
+
+	manager.pools = nil
+	if len(manager.round()) != 0 {
+		t.Fatal("A round without pools must be empty")
+	}
+}
+
+func TestTCertPoolBackoff(t *testing.T) {
+	backoff := newTCertPoolBackoff(&configuration{
+		tCertBackoffInitial:    time.Hour,
+		tCertBackoffMultiplier: 2,
+		tCertBackoffMax:        4 * time.Hour,
+		tCertBackoffJitter:     0.5,
+		tCertBreakerThreshold:  4,
+		tCertBreakerCooldown:   time.Hour,
+	})
+
+	if !backoff.ready() || backoff.isOpen() {
+		t.Fatal("A fresh backoff must allow refills")
+	}
+
+	backoff.failure()
+	if backoff.ready() {
+		t.Fatal("A refill must not be allowed right after a failure")
+	}
+	if backoff.isOpen() {
+		t.Fatal("The circuit must not open before the threshold")
+	}
+	for i := 0; i < 2; i++ {
+		backoff.failure()
+	}
+	if backoff.interval != 4*time.Hour {
+		t.Fatalf("The interval must grow up to the maximum. Expected [%s], Actual [%s]", 4*time.Hour, backoff.interval)
+	}
+	delay := backoff.nextAttempt.Sub(time.Now())
+	if delay < 2*time.Hour-time.Minute || delay > 6*time.Hour {
+		t.Fatalf("The delay must be randomized within the jitter. Actual [%s]", delay)
+	}
+
+	backoff.failure()
+	if !backoff.isOpen() {
+		t.Fatal("The circuit must open at the threshold")
+	}
+
+	backoff.success()
+	if !backoff.ready() || backoff.isOpen() {
+		t.Fatal("A success must reset the backoff")
+	}
+}
+
+func TestTCertPoolRateLimited(t *testing.T) {
+	backoff := newTCertPoolBackoff(&configuration{
+		tCertBackoffInitial:    time.Hour,
+		tCertBackoffMultiplier: 2,
+		tCertBackoffMax:        4 * time.Hour,
+		tCertBreakerThreshold:  2,
+		tCertBreakerCooldown:   time.Hour,
+	})
+
+	ctx := context.Background()
+	if err := tcaError(ctx, grpc.Errorf(codes.ResourceExhausted, "Too many requests, retry in 1s.")); err != utils.ErrRateLimited {
+		t.Fatalf("Rate limited TCert requests must fail with ErrRateLimited, got [%v]", err)
+	}
+	if err := tcaError(ctx, grpc.Errorf(codes.Unavailable, "down")); err != utils.ErrTCAUnreachable {
+		t.Fatalf("Failed TCert requests must fail with ErrTCAUnreachable, got [%v]", err)
+	}
+
+	// the pool backs off, but the TCA is up
+	for i := 0; i < 3; i++ {
+		backoff.rateLimited()
+	}
+	if backoff.ready() || !backoff.isRateLimited() {
+		t.Fatal("Refills must be postponed while rate limited")
+	}
+	if backoff.isOpen() {
+		t.Fatal("The circuit must not open because of rate limits")
+	}
+
+	backoff.success()
+	if !backoff.ready() || backoff.isRateLimited() {
+		t.Fatal("A success must reset the backoff")
+	}
+}
+
 func TestClientGetTCertHandlerNext(t *testing.T) {
 	handler, err := deployer.GetTCertificateHandlerNext()
 
@@ -989,6 +3082,40 @@ func TestValidatorVerify(t *testing.T) {
 	}
 }
 
+func TestValidatorRevokedCertificate(t *testing.T) {
+	_, tx, err := createPublicExecuteTransaction(t)
+	if err != nil {
+		t.Fatalf("Failed creating transaction [%s].", err)
+	}
+
+	// revoke the certificate of the transaction
+	node := invoker.(*clientImpl).nodeImpl
+	req := &membersrvc.TCertRevokeReq{Id: &membersrvc.Identity{Id: node.enrollID}, Cert: &membersrvc.Cert{Cert: tx.Cert}}
+	raw, _ := proto.Marshal(req)
+	if req.Sig, err = node.signECARequest(node.enrollSignKey, raw); err != nil {
+		t.Fatalf("Failed signing revocation request [%s].", err)
+	}
+	sock, tcaP, err := node.getTCAClient()
+	if err != nil {
+		t.Fatalf("Failed getting TCA client [%s].", err)
+	}
+	defer sock.Close()
+	if _, err := tcaP.RevokeCertificate(context.Background(), req); err != nil {
+		t.Fatalf("Failed revoking certificate [%s].", err)
+	}
+
+	peer := validator.(*validatorImpl).peerImpl
+	if _, err := peer.TransactionPreValidation(tx); err != nil {
+		t.Fatalf("Revoked certificates must be accepted if CRLs are disabled [%s].", err)
+	}
+
+	peer.conf.crlEnabled = true
+	defer func() { peer.conf.crlEnabled = false }()
+	if _, err := peer.TransactionPreValidation(tx); err != utils.ErrRevokedCertificate {
+		t.Fatalf("Revoked certificates must be rejected [%v].", err)
+	}
+}
+
 func BenchmarkTransactionCreation(b *testing.B) {
 	b.StopTimer()
 	b.ResetTimer()
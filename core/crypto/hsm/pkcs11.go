@@ -0,0 +1,586 @@
+// +build cgo
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hsm
+
+import (
+	"crypto/aes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/primitives/secp256k1"
+	"github.com/miekg/pkcs11"
+)
+
+// PKCS11Config locates the token of an HSM accessed via PKCS#11
+type PKCS11Config struct {
+	// Library is the path of the PKCS#11 module of the HSM
+	Library string
+
+	// Label is the label of the token. If empty, the token in Slot is used
+	Label string
+	Slot  uint
+
+	// Pin is the PIN of the user of the token
+	Pin string
+}
+
+// pkcs11Module is a PKCS#11 module loaded by the process, shared by
+// all the sessions opened with it
+type pkcs11Module struct {
+	ctx  *pkcs11.Ctx
+	refs int
+}
+
+var (
+	pkcs11Modules     = make(map[string]*pkcs11Module)
+	pkcs11ModulesLock sync.Mutex
+)
+
+// pkcs11CurveOIDs are the object identifiers of the curves of the keys
+// kept on the token
+var pkcs11CurveOIDs = map[string]asn1.ObjectIdentifier{
+	"P-256":     {1, 2, 840, 10045, 3, 1, 7},
+	"P-384":     {1, 3, 132, 0, 34},
+	"secp256k1": secp256k1.OIDNamedCurve,
+}
+
+// PKCS11 is an HSM accessed through its PKCS#11 module. The keys are
+// stored on the token under their label, as a private key object and a
+// public key object. The private keys are sensitive and, unless imported
+// with ImportExtractableKey, cannot be extracted.
+type PKCS11 struct {
+	// m guards the session, which PKCS#11 does not let be used concurrently
+	m       sync.Mutex
+	library string
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+}
+
+// OpenPKCS11 opens a session with the token configured by config and logs
+// in as its user
+func OpenPKCS11(config *PKCS11Config) (*PKCS11, error) {
+	ctx, err := loadPKCS11Module(config.Library)
+	if err != nil {
+		return nil, err
+	}
+	hsm := &PKCS11{library: config.Library, ctx: ctx}
+
+	slot, err := hsm.findSlot(config)
+	if err == nil {
+		hsm.session, err = ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	}
+	if err != nil {
+		unloadPKCS11Module(config.Library)
+
+		return nil, err
+	}
+	if err := ctx.Login(hsm.session, pkcs11.CKU_USER, config.Pin); err != nil && err != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		ctx.CloseSession(hsm.session)
+		unloadPKCS11Module(config.Library)
+
+		return nil, fmt.Errorf("Failed logging in to the token: [%s]", err)
+	}
+
+	return hsm, nil
+}
+
+func loadPKCS11Module(library string) (*pkcs11.Ctx, error) {
+	pkcs11ModulesLock.Lock()
+	defer pkcs11ModulesLock.Unlock()
+
+	if module, ok := pkcs11Modules[library]; ok {
+		module.refs++
+
+		return module.ctx, nil
+	}
+
+	ctx := pkcs11.New(library)
+	if ctx == nil {
+		return nil, fmt.Errorf("Failed loading PKCS#11 module [%s]", library)
+	}
+	if err := ctx.Initialize(); err != nil && err != pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		ctx.Destroy()
+
+		return nil, fmt.Errorf("Failed initializing PKCS#11 module [%s]: [%s]", library, err)
+	}
+	pkcs11Modules[library] = &pkcs11Module{ctx, 1}
+
+	return ctx, nil
+}
+
+func unloadPKCS11Module(library string) {
+	pkcs11ModulesLock.Lock()
+	defer pkcs11ModulesLock.Unlock()
+
+	module := pkcs11Modules[library]
+	if module.refs--; module.refs == 0 {
+		module.ctx.Finalize()
+		module.ctx.Destroy()
+		delete(pkcs11Modules, library)
+	}
+}
+
+// findSlot returns the slot of the token labelled config.Label, or
+// config.Slot if no label is configured
+func (hsm *PKCS11) findSlot(config *PKCS11Config) (uint, error) {
+	if config.Label == "" {
+		return config.Slot, nil
+	}
+
+	slots, err := hsm.ctx.GetSlotList(true)
+	if err != nil {
+		return 0, err
+	}
+	for _, slot := range slots {
+		info, err := hsm.ctx.GetTokenInfo(slot)
+		if err == nil && strings.TrimSpace(info.Label) == config.Label {
+			return slot, nil
+		}
+	}
+
+	return 0, fmt.Errorf("PKCS#11 token [%s] not found", config.Label)
+}
+
+// ImportKey stores key on the token under label, replacing the key stored
+// under label if any. The key is kept after the session closes if
+// persistent.
+func (hsm *PKCS11) ImportKey(label string, key *ecdsa.PrivateKey, persistent bool) error {
+	return hsm.importKey(label, key, persistent, false)
+}
+
+// ImportExtractableKey stores key under label as ImportKey does, but lets
+// ExportKey hand it out, wrapped
+func (hsm *PKCS11) ImportExtractableKey(label string, key *ecdsa.PrivateKey) error {
+	return hsm.importKey(label, key, true, true)
+}
+
+func (hsm *PKCS11) importKey(label string, key *ecdsa.PrivateKey, persistent, extractable bool) error {
+	params, err := pkcs11CurveParams(key.Curve)
+	if err != nil {
+		return err
+	}
+	point, err := asn1.Marshal(pkcs11MarshalPoint(&key.PublicKey))
+	if err != nil {
+		return err
+	}
+	value := key.D.Bytes()
+	if size := (key.Params().N.BitLen() + 7) / 8; len(value) < size {
+		value = append(make([]byte, size-len(value)), value...)
+	}
+
+	hsm.m.Lock()
+	defer hsm.m.Unlock()
+
+	// Replace the key stored under label, if any
+	if err := hsm.destroyObjects(label); err != nil {
+		return err
+	}
+
+	if _, err := hsm.ctx.CreateObject(hsm.session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, persistent),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, point),
+	}); err != nil {
+		return fmt.Errorf("Failed storing public key [%s] on the token: [%s]", label, err)
+	}
+	if _, err := hsm.ctx.CreateObject(hsm.session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, persistent),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, extractable),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, value),
+	}); err != nil {
+		hsm.destroyObjects(label)
+
+		return fmt.Errorf("Failed storing private key [%s] on the token: [%s]", label, err)
+	}
+
+	return nil
+}
+
+// GenerateKey generates on the token a persistent key pair under label,
+// on the curve of the security level
+func (hsm *PKCS11) GenerateKey(label string) (*ecdsa.PublicKey, error) {
+	params, err := pkcs11CurveParams(primitives.GetDefaultCurve())
+	if err != nil {
+		return nil, err
+	}
+
+	hsm.m.Lock()
+	defer hsm.m.Unlock()
+
+	if err := hsm.destroyObjects(label); err != nil {
+		return nil, err
+	}
+
+	pubHandle, _, err := hsm.ctx.GenerateKeyPair(hsm.session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params),
+		},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+			pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		})
+	if err != nil {
+		return nil, fmt.Errorf("Failed generating key [%s] on the token: [%s]", label, err)
+	}
+
+	return hsm.publicKey(pubHandle)
+}
+
+// FindKey returns the public part of the key stored under label
+func (hsm *PKCS11) FindKey(label string) (*ecdsa.PublicKey, error) {
+	hsm.m.Lock()
+	defer hsm.m.Unlock()
+
+	handle, err := hsm.findObject(pkcs11.CKO_PUBLIC_KEY, label)
+	if err != nil {
+		return nil, err
+	}
+
+	return hsm.publicKey(handle)
+}
+
+// Sign signs digest on the token with the key stored under label. The
+// signature is ASN.1 encoded, as for ecdsa keys.
+func (hsm *PKCS11) Sign(label string, digest []byte) ([]byte, error) {
+	hsm.m.Lock()
+	defer hsm.m.Unlock()
+
+	handle, err := hsm.findObject(pkcs11.CKO_PRIVATE_KEY, label)
+	if err != nil {
+		return nil, err
+	}
+	if err := hsm.ctx.SignInit(hsm.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}, handle); err != nil {
+		return nil, fmt.Errorf("Failed signing with key [%s]: [%s]", label, err)
+	}
+	raw, err := hsm.ctx.Sign(hsm.session, digest)
+	if err != nil {
+		return nil, fmt.Errorf("Failed signing with key [%s]: [%s]", label, err)
+	}
+	if len(raw) == 0 || len(raw)%2 != 0 {
+		return nil, fmt.Errorf("Invalid signature of key [%s] returned by the token", label)
+	}
+
+	// PKCS#11 returns r and s concatenated
+	half := len(raw) / 2
+
+	return asn1.Marshal(primitives.ECDSASignature{
+		R: new(big.Int).SetBytes(raw[:half]),
+		S: new(big.Int).SetBytes(raw[half:]),
+	})
+}
+
+// ExportKey returns the key stored under label by ImportExtractableKey.
+// The key leaves the token wrapped with AES key wrap under a session key,
+// and is unwrapped in memory.
+func (hsm *PKCS11) ExportKey(label string) (*ecdsa.PrivateKey, error) {
+	kek, err := primitives.GenAESKey()
+	if err != nil {
+		return nil, err
+	}
+
+	hsm.m.Lock()
+	defer hsm.m.Unlock()
+
+	handle, err := hsm.findObject(pkcs11.CKO_PRIVATE_KEY, label)
+	if err != nil {
+		return nil, err
+	}
+	kekHandle, err := hsm.ctx.CreateObject(hsm.session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false),
+		pkcs11.NewAttribute(pkcs11.CKA_WRAP, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, kek),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed creating wrapping key: [%s]", err)
+	}
+	defer hsm.ctx.DestroyObject(hsm.session, kekHandle)
+
+	wrapped, err := hsm.ctx.WrapKey(hsm.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_WRAP_PAD, nil)}, kekHandle, handle)
+	if err != nil {
+		return nil, fmt.Errorf("Failed exporting key [%s]: [%s]", label, err)
+	}
+	der, err := aesKeyUnwrapPad(kek, wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePKCS8ECPrivateKey(der)
+}
+
+// Close closes the session with the token
+func (hsm *PKCS11) Close() error {
+	hsm.m.Lock()
+	defer hsm.m.Unlock()
+
+	err := hsm.ctx.CloseSession(hsm.session)
+	unloadPKCS11Module(hsm.library)
+
+	return err
+}
+
+// findObjects returns the objects stored under label, of class unless zero
+func (hsm *PKCS11) findObjects(class uint, label string) ([]pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_LABEL, label)}
+	if class != 0 {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_CLASS, class))
+	}
+	if err := hsm.ctx.FindObjectsInit(hsm.session, template); err != nil {
+		return nil, err
+	}
+	handles, _, err := hsm.ctx.FindObjects(hsm.session, 16)
+	if ferr := hsm.ctx.FindObjectsFinal(hsm.session); err == nil {
+		err = ferr
+	}
+
+	return handles, err
+}
+
+func (hsm *PKCS11) findObject(class uint, label string) (pkcs11.ObjectHandle, error) {
+	handles, err := hsm.findObjects(class, label)
+	if err != nil {
+		return 0, err
+	}
+	if len(handles) == 0 {
+		return 0, fmt.Errorf("Key [%s] not found on the token", label)
+	}
+
+	return handles[0], nil
+}
+
+// destroyObjects removes from the token the objects stored under label
+func (hsm *PKCS11) destroyObjects(label string) error {
+	handles, err := hsm.findObjects(0, label)
+	if err != nil {
+		return err
+	}
+	for _, handle := range handles {
+		if err := hsm.ctx.DestroyObject(hsm.session, handle); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// publicKey reads the public key object handle
+func (hsm *PKCS11) publicKey(handle pkcs11.ObjectHandle) (*ecdsa.PublicKey, error) {
+	attrs, err := hsm.ctx.GetAttributeValue(hsm.session, handle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var params, point []byte
+	for _, attr := range attrs {
+		switch attr.Type {
+		case pkcs11.CKA_EC_PARAMS:
+			params = attr.Value
+		case pkcs11.CKA_EC_POINT:
+			point = attr.Value
+		}
+	}
+
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(params, &oid); err != nil {
+		return nil, fmt.Errorf("Invalid curve of the key on the token: [%s]", err)
+	}
+	curve, err := pkcs11Curve(oid)
+	if err != nil {
+		return nil, err
+	}
+
+	// The point is an uncompressed point in an octet string
+	var raw []byte
+	if _, err := asn1.Unmarshal(point, &raw); err != nil {
+		return nil, fmt.Errorf("Invalid point of the key on the token: [%s]", err)
+	}
+
+	return pkcs11UnmarshalPoint(curve, raw)
+}
+
+// pkcs11CurveParams returns the DER encoded object identifier of curve
+func pkcs11CurveParams(curve elliptic.Curve) ([]byte, error) {
+	oid, ok := pkcs11CurveOIDs[curve.Params().Name]
+	if !ok {
+		return nil, fmt.Errorf("Elliptic curve not supported by the HSM [%s]", curve.Params().Name)
+	}
+
+	return asn1.Marshal(oid)
+}
+
+func pkcs11Curve(oid asn1.ObjectIdentifier) (elliptic.Curve, error) {
+	for name, curveOID := range pkcs11CurveOIDs {
+		if curveOID.Equal(oid) {
+			return primitives.GetCurve(name)
+		}
+	}
+
+	return nil, fmt.Errorf("Elliptic curve not supported by the HSM [%s]", oid)
+}
+
+// pkcs11MarshalPoint returns the uncompressed form of pub
+func pkcs11MarshalPoint(pub *ecdsa.PublicKey) []byte {
+	size := (pub.Params().BitSize + 7) / 8
+	raw := make([]byte, 1+2*size)
+	raw[0] = 4
+	x, y := pub.X.Bytes(), pub.Y.Bytes()
+	copy(raw[1+size-len(x):], x)
+	copy(raw[1+2*size-len(y):], y)
+
+	return raw
+}
+
+func pkcs11UnmarshalPoint(curve elliptic.Curve, raw []byte) (*ecdsa.PublicKey, error) {
+	size := (curve.Params().BitSize + 7) / 8
+	if len(raw) != 1+2*size || raw[0] != 4 {
+		return nil, errors.New("Invalid point of the key on the token")
+	}
+	pub := &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(raw[1 : 1+size]),
+		Y:     new(big.Int).SetBytes(raw[1+size:]),
+	}
+	if !curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, errors.New("Invalid point of the key on the token")
+	}
+
+	return pub, nil
+}
+
+// aesKeyUnwrapPad unwraps wrapped with kek as specified by RFC 5649
+func aesKeyUnwrapPad(kek, wrapped []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < 16 || len(wrapped)%8 != 0 {
+		return nil, errors.New("Invalid wrapped key")
+	}
+
+	n := len(wrapped)/8 - 1
+	a := make([]byte, 8)
+	r := make([]byte, 8*n)
+	b := make([]byte, 16)
+	if n == 1 {
+		block.Decrypt(b, wrapped)
+		copy(a, b[:8])
+		copy(r, b[8:])
+	} else {
+		copy(a, wrapped[:8])
+		copy(r, wrapped[8:])
+		for j := 5; j >= 0; j-- {
+			for i := n; i >= 1; i-- {
+				t := uint64(n*j + i)
+				binary.BigEndian.PutUint64(b[:8], binary.BigEndian.Uint64(a)^t)
+				copy(b[8:], r[8*(i-1):8*i])
+				block.Decrypt(b, b)
+				copy(a, b[:8])
+				copy(r[8*(i-1):8*i], b[8:])
+			}
+		}
+	}
+
+	// The integrity check value holds the length of the key
+	if binary.BigEndian.Uint32(a[:4]) != 0xA65959A6 {
+		return nil, errors.New("Invalid wrapped key")
+	}
+	length := int(binary.BigEndian.Uint32(a[4:]))
+	if length <= 8*(n-1) || length > 8*n {
+		return nil, errors.New("Invalid wrapped key")
+	}
+	for _, pad := range r[length:] {
+		if pad != 0 {
+			return nil, errors.New("Invalid wrapped key")
+		}
+	}
+
+	return r[:length], nil
+}
+
+// parsePKCS8ECPrivateKey parses the PKCS#8 encoded EC private key der,
+// on any curve the HSM supports
+func parsePKCS8ECPrivateKey(der []byte) (*ecdsa.PrivateKey, error) {
+	var info struct {
+		Version    int
+		Algo       pkix.AlgorithmIdentifier
+		PrivateKey []byte
+	}
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("Invalid exported key: [%s]", err)
+	}
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(info.Algo.Parameters.FullBytes, &oid); err != nil {
+		return nil, fmt.Errorf("Invalid curve of the exported key: [%s]", err)
+	}
+	curve, err := pkcs11Curve(oid)
+	if err != nil {
+		return nil, err
+	}
+
+	// ECPrivateKey of RFC 5915, whose optional fields are not needed
+	var ecKey struct {
+		Version    int
+		PrivateKey []byte
+		Params     asn1.RawValue `asn1:"optional,explicit,tag:0"`
+		PublicKey  asn1.RawValue `asn1:"optional,explicit,tag:1"`
+	}
+	if _, err := asn1.Unmarshal(info.PrivateKey, &ecKey); err != nil {
+		return nil, fmt.Errorf("Invalid exported key: [%s]", err)
+	}
+
+	key := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(ecKey.PrivateKey)}
+	key.Curve = curve
+	key.X, key.Y = curve.ScalarBaseMult(ecKey.PrivateKey)
+
+	return key, nil
+}
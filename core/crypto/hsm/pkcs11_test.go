@@ -0,0 +1,267 @@
+// +build cgo
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hsm
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/miekg/pkcs11"
+)
+
+func TestMain(m *testing.M) {
+	if err := primitives.InitSecurityLevel("SHA2", 256); err != nil {
+		fmt.Printf("Failed setting security level [%s]\n", err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+const (
+	testSoftHSMLabel = "fabric"
+	testSoftHSMPin   = "98765432"
+)
+
+// softHSMLibrary returns the path of the SoftHSM module, set by
+// SOFTHSM2_LIB or found where the distributions install it
+func softHSMLibrary() string {
+	if lib := os.Getenv("SOFTHSM2_LIB"); lib != "" {
+		return lib
+	}
+	for _, lib := range []string{
+		"/usr/lib/softhsm/libsofthsm2.so",
+		"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+		"/usr/local/lib/softhsm/libsofthsm2.so",
+		"/usr/lib64/pkcs11/libsofthsm2.so",
+	} {
+		if _, err := os.Stat(lib); err == nil {
+			return lib
+		}
+	}
+
+	return ""
+}
+
+// initSoftHSM creates a SoftHSM token in dir, labelled testSoftHSMLabel
+func initSoftHSM(t *testing.T, lib, dir string) {
+	conf := filepath.Join(dir, "softhsm2.conf")
+	tokens := filepath.Join(dir, "tokens")
+	if err := os.Mkdir(tokens, 0700); err != nil {
+		t.Fatalf("Failed creating token directory [%s]", err)
+	}
+	if err := ioutil.WriteFile(conf, []byte(fmt.Sprintf("directories.tokendir = %s\nobjectstore.backend = file\n", tokens)), 0600); err != nil {
+		t.Fatalf("Failed writing SoftHSM configuration [%s]", err)
+	}
+	os.Setenv("SOFTHSM2_CONF", conf)
+
+	ctx := pkcs11.New(lib)
+	if ctx == nil {
+		t.Fatalf("Failed loading [%s]", lib)
+	}
+	defer ctx.Destroy()
+	if err := ctx.Initialize(); err != nil {
+		t.Fatalf("Failed initializing SoftHSM [%s]", err)
+	}
+	defer ctx.Finalize()
+
+	slots, err := ctx.GetSlotList(true)
+	if err != nil || len(slots) == 0 {
+		t.Fatalf("Failed listing slots [%s]", err)
+	}
+	if err := ctx.InitToken(slots[0], testSoftHSMPin, testSoftHSMLabel); err != nil {
+		t.Fatalf("Failed initializing token [%s]", err)
+	}
+
+	// The token moves to another slot once initialized
+	slots, err = ctx.GetSlotList(true)
+	if err != nil {
+		t.Fatalf("Failed listing slots [%s]", err)
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil || info.Label != testSoftHSMLabel {
+			continue
+		}
+		session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+		if err != nil {
+			t.Fatalf("Failed opening session [%s]", err)
+		}
+		defer ctx.CloseSession(session)
+		if err := ctx.Login(session, pkcs11.CKU_SO, testSoftHSMPin); err != nil {
+			t.Fatalf("Failed logging in [%s]", err)
+		}
+		if err := ctx.InitPIN(session, testSoftHSMPin); err != nil {
+			t.Fatalf("Failed setting PIN [%s]", err)
+		}
+		ctx.Logout(session)
+
+		return
+	}
+	t.Fatal("Initialized token not found")
+}
+
+func TestPKCS11(t *testing.T) {
+	lib := softHSMLibrary()
+	if lib == "" {
+		t.Skip("SoftHSM not installed")
+	}
+	dir, err := ioutil.TempDir("", "TestPKCS11")
+	if err != nil {
+		t.Fatalf("Failed creating directory [%s]", err)
+	}
+	defer os.RemoveAll(dir)
+	initSoftHSM(t, lib, dir)
+
+	token, err := OpenPKCS11(&PKCS11Config{Library: lib, Label: testSoftHSMLabel, Pin: testSoftHSMPin})
+	if err != nil {
+		t.Fatalf("Failed opening HSM [%s]", err)
+	}
+	defer token.Close()
+
+	if _, err := OpenPKCS11(&PKCS11Config{Library: lib, Label: "missing", Pin: testSoftHSMPin}); err == nil {
+		t.Fatal("Opening a missing token must fail")
+	}
+
+	// An imported key signs on the token
+	key, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s]", err)
+	}
+	if err := token.ImportKey("imported", key, true); err != nil {
+		t.Fatalf("Failed importing key [%s]", err)
+	}
+	pub, err := token.FindKey("imported")
+	if err != nil {
+		t.Fatalf("Failed finding key [%s]", err)
+	}
+	if pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+		t.Fatal("Found public key does not match the imported key")
+	}
+	verifyPKCS11Signature(t, token, "imported", pub)
+
+	if _, err := token.FindKey("missing"); err == nil {
+		t.Fatal("Finding a missing key must fail")
+	}
+
+	// A key generated on the token signs there too
+	generated, err := token.GenerateKey("generated")
+	if err != nil {
+		t.Fatalf("Failed generating key on the token [%s]", err)
+	}
+	verifyPKCS11Signature(t, token, "generated", generated)
+
+	// Only the keys imported as extractable can be exported
+	if _, err := token.ExportKey("imported"); err == nil {
+		t.Fatal("Exporting a key not extractable must fail")
+	}
+	if err := token.ImportExtractableKey("extractable", key); err != nil {
+		t.Fatalf("Failed importing extractable key [%s]", err)
+	}
+	exported, err := token.ExportKey("extractable")
+	if err != nil {
+		t.Fatalf("Failed exporting key [%s]", err)
+	}
+	if exported.D.Cmp(key.D) != 0 || exported.X.Cmp(key.X) != 0 {
+		t.Fatal("Exported key does not match the imported key")
+	}
+}
+
+func verifyPKCS11Signature(t *testing.T, token *PKCS11, label string, pub *ecdsa.PublicKey) {
+	digest := primitives.Hash([]byte("Hello World"))
+	raw, err := token.Sign(label, digest)
+	if err != nil {
+		t.Fatalf("Failed signing with [%s] [%s]", label, err)
+	}
+	signature := new(primitives.ECDSASignature)
+	if _, err := asn1.Unmarshal(raw, signature); err != nil {
+		t.Fatalf("Failed decoding signature [%s]", err)
+	}
+	if !ecdsa.Verify(pub, digest, signature.R, signature.S) {
+		t.Fatalf("Failed verifying signature of [%s]", label)
+	}
+}
+
+func TestAESKeyUnwrapPad(t *testing.T) {
+	// Test vectors of RFC 5649
+	kek, _ := hex.DecodeString("5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8")
+	vectors := []struct{ key, wrapped string }{
+		{"c37b7e6492584340bed12207808941155068f738", "138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a"},
+		{"466f7250617369", "afbeb0f07dfbf5419200f2ccb50bb24f"},
+	}
+	for _, vector := range vectors {
+		key, _ := hex.DecodeString(vector.key)
+		wrapped, _ := hex.DecodeString(vector.wrapped)
+		unwrapped, err := aesKeyUnwrapPad(kek, wrapped)
+		if err != nil {
+			t.Fatalf("Failed unwrapping key [%s]", err)
+		}
+		if !bytes.Equal(unwrapped, key) {
+			t.Fatalf("Unwrapped key [%x] does not match [%x]", unwrapped, key)
+		}
+
+		wrapped[0] ^= 1
+		if _, err := aesKeyUnwrapPad(kek, wrapped); err == nil {
+			t.Fatal("Unwrapping a tampered key must fail")
+		}
+	}
+}
+
+func TestParsePKCS8ECPrivateKey(t *testing.T) {
+	key, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s]", err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed marshalling key [%s]", err)
+	}
+	params, err := pkcs11CurveParams(key.Curve)
+	if err != nil {
+		t.Fatalf("Failed marshalling curve [%s]", err)
+	}
+	der, err := asn1.Marshal(struct {
+		Version    int
+		Algo       pkix.AlgorithmIdentifier
+		PrivateKey []byte
+	}{0, pkix.AlgorithmIdentifier{
+		Algorithm:  asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1},
+		Parameters: asn1.RawValue{FullBytes: params},
+	}, ecDER})
+	if err != nil {
+		t.Fatalf("Failed marshalling key [%s]", err)
+	}
+
+	parsed, err := parsePKCS8ECPrivateKey(der)
+	if err != nil {
+		t.Fatalf("Failed parsing key [%s]", err)
+	}
+	if parsed.D.Cmp(key.D) != 0 || parsed.X.Cmp(key.X) != 0 || parsed.Y.Cmp(key.Y) != 0 {
+		t.Fatal("Parsed key does not match")
+	}
+}
@@ -26,6 +26,14 @@ func eTypeToString(eType NodeType) string {
 		return "peer"
 	case NodeValidator:
 		return "validator"
+	case NodeAuditor:
+		return "auditor"
 	}
 	return "Invalid Type"
 }
+
+// holdsChainPrivateKey tells whether membersrvc hands the chain private key,
+// rather than the public one, to nodes of this type
+func (node *nodeImpl) holdsChainPrivateKey() bool {
+	return node.eType == NodeValidator || node.eType == NodeAuditor
+}
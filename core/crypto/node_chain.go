@@ -0,0 +1,179 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/x509"
+	"errors"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// initCertsChains loads the roots and the intermediate CAs configured
+// under security.chain
+func (node *nodeImpl) initCertsChains() error {
+	node.rootsCertPool = x509.NewCertPool()
+	node.intermediatesCertPool = x509.NewCertPool()
+
+	if path := node.conf.getChainRootsPath(); path != "" {
+		if err := node.loadExternalCertsChain(path, node.rootsCertPool); err != nil {
+			node.error("Failed loading roots [%s].", err.Error())
+
+			return err
+		}
+	}
+
+	if path := node.conf.getChainIntermediatesPath(); path != "" {
+		if err := node.loadExternalCertsChain(path, node.intermediatesCertPool); err != nil {
+			node.error("Failed loading intermediate CAs [%s].", err.Error())
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (node *nodeImpl) loadExternalCertsChain(path string, pool *x509.CertPool) error {
+	pem, err := node.ks.loadExternalCert(path)
+	if err != nil {
+		return err
+	}
+
+	certs, err := utils.PEMtoCertificates(pem)
+	if err != nil {
+		return err
+	}
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+
+	return nil
+}
+
+// verifyCACertsChain parses the certificate of the ECA or the TCA followed by
+// its intermediate CAs, as sent by membersrvc, and verifies it against the
+// configured roots. The intermediates are made available to later
+// verifications.
+func (node *nodeImpl) verifyCACertsChain(raw []byte) ([]*x509.Certificate, error) {
+	chain, err := x509.ParseCertificates(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("Empty certificates chain.")
+	}
+
+	for _, cert := range chain[1:] {
+		node.intermediatesCertPool.AddCert(cert)
+	}
+
+	// Without roots the CA certificate is trusted as it is
+	if node.conf.getChainRootsPath() == "" {
+		return chain, nil
+	}
+
+	if _, err := utils.CheckCertChain(chain[0], node.rootsCertPool, node.intermediatesCertPool); err != nil {
+		node.error("Failed verifying CA certificate [%s] against roots [%s].", chain[0].Subject.CommonName, err.Error())
+
+		return nil, err
+	}
+
+	return chain, nil
+}
+
+// storeCACertsChain stores the CA certificate followed by its intermediate
+// CAs as a PEM bundle
+func (node *nodeImpl) storeCACertsChain(alias string, chain []*x509.Certificate) error {
+	ders := make([][]byte, len(chain))
+	for i, cert := range chain {
+		ders[i] = cert.Raw
+	}
+
+	return node.ks.storeCertsChain(alias, ders)
+}
+
+// loadCACertsChain loads the PEM bundle stored by storeCACertsChain. The CA
+// certificate is added to pool, the intermediates to the node's intermediate
+// pool.
+func (node *nodeImpl) loadCACertsChain(alias string, pool *x509.CertPool) error {
+	pem, err := node.ks.loadCert(alias)
+	if err != nil {
+		return err
+	}
+
+	chain, err := utils.PEMtoCertificates(pem)
+	if err != nil {
+		return err
+	}
+
+	for _, cert := range chain[1:] {
+		node.intermediatesCertPool.AddCert(cert)
+	}
+
+	if node.conf.getChainRootsPath() != "" {
+		if _, err := utils.CheckCertChain(chain[0], node.rootsCertPool, node.intermediatesCertPool); err != nil {
+			return err
+		}
+	}
+	pool.AddCert(chain[0])
+
+	return nil
+}
+
+// loadCACerts returns the ECA and TCA certificates along with the intermediate
+// CAs sent by membersrvc and the configured ones
+func (node *nodeImpl) loadCACerts() ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for _, alias := range []string{node.conf.getECACertsChainFilename(), node.conf.getTCACertsChainFilename()} {
+		pem, err := node.ks.loadCert(alias)
+		if err != nil {
+			return nil, err
+		}
+		chain, err := utils.PEMtoCertificates(pem)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, chain...)
+	}
+
+	if path := node.conf.getChainIntermediatesPath(); path != "" {
+		pem, err := node.ks.loadExternalCert(path)
+		if err != nil {
+			return nil, err
+		}
+		chain, err := utils.PEMtoCertificates(pem)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, chain...)
+	}
+
+	return certs, nil
+}
+
+// verifyCertificate verifies cert against roots, building the chain through
+// the known intermediate CAs
+func (node *nodeImpl) verifyCertificate(cert *x509.Certificate, roots *x509.CertPool) ([][]*x509.Certificate, error) {
+	return utils.CheckCertChain(cert, roots, node.intermediatesCertPool)
+}
+
+// verifyCertificateAndKey verifies cert as verifyCertificate does and checks
+// that it certifies the public part of privateKey
+func (node *nodeImpl) verifyCertificateAndKey(cert *x509.Certificate, privateKey interface{}, roots *x509.CertPool) error {
+	return utils.CheckCertAgainstSKAndChain(cert, privateKey, roots, node.intermediatesCertPool)
+}
@@ -18,8 +18,12 @@ package crypto
 
 import (
 	"errors"
+	"fmt"
 	"path/filepath"
+	"runtime"
+	"time"
 
+	"github.com/hyperledger/fabric/core/crypto/primitives"
 	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
 	"github.com/spf13/viper"
 )
@@ -29,7 +33,7 @@ func (node *nodeImpl) initConfiguration(name string) (err error) {
 	prefix := eTypeToString(node.eType)
 
 	// Set configuration
-	node.conf = &configuration{prefix: prefix, name: name}
+	node.conf = &configuration{prefix: prefix, name: name, source: node.confSource}
 	if err = node.conf.init(); err != nil {
 		return
 	}
@@ -39,10 +43,66 @@ func (node *nodeImpl) initConfiguration(name string) (err error) {
 	return
 }
 
+// configSource is where the configuration of a node is read from. By
+// default it is the global viper configuration of the peer; clients created
+// with NewClient read it from their own options instead.
+type configSource interface {
+	IsSet(key string) bool
+	GetString(key string) string
+	GetInt(key string) int
+	GetBool(key string) bool
+	GetFloat64(key string) float64
+	GetDuration(key string) time.Duration
+	GetStringSlice(key string) []string
+	GetStringMap(key string) map[string]interface{}
+	GetStringMapString(key string) map[string]string
+}
+
+// viperSource reads from the global viper configuration
+type viperSource struct{}
+
+func (viperSource) IsSet(key string) bool {
+	return viper.IsSet(key)
+}
+
+func (viperSource) GetString(key string) string {
+	return viper.GetString(key)
+}
+
+func (viperSource) GetInt(key string) int {
+	return viper.GetInt(key)
+}
+
+func (viperSource) GetBool(key string) bool {
+	return viper.GetBool(key)
+}
+
+func (viperSource) GetFloat64(key string) float64 {
+	return viper.GetFloat64(key)
+}
+
+func (viperSource) GetStringSlice(key string) []string {
+	return viper.GetStringSlice(key)
+}
+
+func (viperSource) GetDuration(key string) time.Duration {
+	return viper.GetDuration(key)
+}
+
+func (viperSource) GetStringMap(key string) map[string]interface{} {
+	return viper.GetStringMap(key)
+}
+
+func (viperSource) GetStringMapString(key string) map[string]string {
+	return viper.GetStringMapString(key)
+}
+
 type configuration struct {
 	prefix string
 	name   string
 
+	source configSource
+
 	logPrefix string
 
 	rootDataPath      string
@@ -61,12 +121,96 @@ type configuration struct {
 
 	tlsServerName string
 
-	multiThreading  bool
-	tCertBatchSize  int
-	tCertAttributes []*membersrvc.TCertAttribute
+	cspProvider     string
+	signatureScheme string
+	signatureCurve  string
+
+	confidentialityProtocolVersion string
+
+	hsmProvider string
+	hsmKeys     map[string]bool
+
+	enrollmentCSR bool
+
+	secretsProvider string
+	secretsPaths    map[string]string
+
+	keyStoreBackend string
+	keyStoreKDF     string
+	keyStoreScryptN int
+	keyStoreScryptR int
+	keyStoreScryptP int
+
+	keyStoreCipherKey      string
+	keyStoreKeyringService string
+
+	crlEnabled bool
+	crlPeriod  time.Duration
+
+	ocspEnabled   bool
+	ocspResponder string
+	ocspPolicy    string
+	ocspTimeout   time.Duration
+	ocspCacheTTL  time.Duration
+
+	ecaHealthAttempts int
+	ecaHealthInterval time.Duration
+
+	chainRootsPath         string
+	chainIntermediatesPath string
+
+	auditChaincodes []string
+
+	nonceReplayProtection bool
+
+	tlsBindingRequired bool
+
+	multiThreading    bool
+	validationWorkers int
+	certCacheSize     int
+	tCertBatchSize    int
+	tCertBatchStream  bool
+	tCertDualKey      bool
+	tCertOffline      bool
+	tCertReserve      int
+	tCertAttributes   []*membersrvc.TCertAttribute
+	tCertPoolProvider string
+
+	tCertPoolAdaptive bool
+	tCertPoolMinSize  int
+	tCertPoolMaxSize  int
+
+	tCertExpiryMargin time.Duration
+	tCertExpirySweep  time.Duration
+
+	tCertBackoffInitial    time.Duration
+	tCertBackoffMultiplier float64
+	tCertBackoffMax        time.Duration
+	tCertBackoffJitter     float64
+	tCertBreakerThreshold  int
+	tCertBreakerCooldown   time.Duration
+
+	tCertPoolQuota int
+
+	tCertReusePolicy string
+	tCertReuseCount  int
+	tCertReusePeriod time.Duration
+
+	tCertHealthPeriod  time.Duration
+	tCertHealthSamples int
+
+	usedTCertsRetentionAge   time.Duration
+	usedTCertsRetentionCount int
+	usedTCertsCompaction     time.Duration
+
+	tCertChaincodePools map[string]*tCertChaincodePool
 }
 
 func (conf *configuration) init() error {
+	if conf.source == nil {
+		conf.source = viperSource{}
+	}
+
 	conf.configurationPathProperty = "peer.fileSystemPath"
 	conf.ecaPAddressProperty = "peer.pki.eca.paddr"
 	conf.tcaPAddressProperty = "peer.pki.tca.paddr"
@@ -87,7 +231,7 @@ func (conf *configuration) init() error {
 		return err
 	}
 
-	conf.configurationPath = viper.GetString(conf.configurationPathProperty)
+	conf.configurationPath = conf.source.GetString(conf.configurationPathProperty)
 	conf.rootDataPath = conf.configurationPath
 
 	// Set configuration path
@@ -106,16 +250,16 @@ func (conf *configuration) init() error {
 	conf.tCertsPath = filepath.Join(conf.keystorePath, "tcerts")
 
 	conf.securityLevel = 384
-	if viper.IsSet("security.level") {
-		ovveride := viper.GetInt("security.level")
+	if conf.source.IsSet("security.level") {
+		ovveride := conf.source.GetInt("security.level")
 		if ovveride != 0 {
 			conf.securityLevel = ovveride
 		}
 	}
 
 	conf.hashAlgorithm = "SHA3"
-	if viper.IsSet("security.hashAlgorithm") {
-		ovveride := viper.GetString("security.hashAlgorithm")
+	if conf.source.IsSet("security.hashAlgorithm") {
+		ovveride := conf.source.GetString("security.hashAlgorithm")
 		if ovveride != "" {
 			conf.hashAlgorithm = ovveride
 		}
@@ -123,8 +267,8 @@ func (conf *configuration) init() error {
 
 	// Set TLS host override
 	conf.tlsServerName = "tlsca"
-	if viper.IsSet("peer.pki.tls.serverhostoverride") {
-		ovveride := viper.GetString("peer.pki.tls.serverhostoverride")
+	if conf.source.IsSet("peer.pki.tls.serverhostoverride") {
+		ovveride := conf.source.GetString("peer.pki.tls.serverhostoverride")
 		if ovveride != "" {
 			conf.tlsServerName = ovveride
 		}
@@ -132,33 +276,488 @@ func (conf *configuration) init() error {
 
 	// Set tCertBatchSize
 	conf.tCertBatchSize = 200
-	if viper.IsSet("security.tcert.batch.size") {
-		ovveride := viper.GetInt("security.tcert.batch.size")
+	if conf.source.IsSet("security.tcert.batch.size") {
+		ovveride := conf.source.GetInt("security.tcert.batch.size")
 		if ovveride != 0 {
 			conf.tCertBatchSize = ovveride
 		}
 	}
 
+	// Receive the TCerts of a batch one by one as the TCA issues them
+	conf.tCertBatchStream = false
+	if conf.source.IsSet("security.tcert.batch.stream") {
+		conf.tCertBatchStream = conf.source.GetBool("security.tcert.batch.stream")
+	}
+
+	// Request TCerts with separate signing and encryption key pairs
+	conf.tCertDualKey = false
+	if conf.source.IsSet("security.tcert.dualKey") {
+		conf.tCertDualKey = conf.source.GetBool("security.tcert.dualKey")
+	}
+
+	// Mint TCerts from a reserve fetched from the TCA ahead of time
+	conf.tCertOffline = false
+	if conf.source.IsSet("security.tcert.offline.enabled") {
+		conf.tCertOffline = conf.source.GetBool("security.tcert.offline.enabled")
+	}
+	conf.tCertReserve = 10 * conf.tCertBatchSize
+	if conf.source.IsSet("security.tcert.offline.reserve") {
+		ovveride := conf.source.GetInt("security.tcert.offline.reserve")
+		if ovveride != 0 {
+			conf.tCertReserve = ovveride
+		}
+	}
+	if conf.tCertReserve < conf.tCertBatchSize {
+		return fmt.Errorf("Invalid TCert reserve: [%d] smaller than the batch size [%d]", conf.tCertReserve, conf.tCertBatchSize)
+	}
+
+	// Set crypto service provider
+	conf.cspProvider = CSPSoftware
+	if conf.source.IsSet("security.csp.provider") {
+		ovveride := conf.source.GetString("security.csp.provider")
+		if ovveride != "" {
+			conf.cspProvider = ovveride
+		}
+	}
+
+	// Set signature scheme
+	conf.signatureScheme = SignatureSchemeECDSA
+	if conf.source.IsSet("security.signature.scheme") {
+		ovveride := conf.source.GetString("security.signature.scheme")
+		if ovveride != "" {
+			conf.signatureScheme = ovveride
+		}
+	}
+	switch conf.signatureScheme {
+	case SignatureSchemeECDSA, SignatureSchemeEd25519:
+	default:
+		return fmt.Errorf("Invalid signature scheme [%s]", conf.signatureScheme)
+	}
+
+	// Set the curve of ECDSA enrollment keys. Empty means the default curve
+	// of the security level
+	if conf.source.IsSet("security.signature.curve") {
+		conf.signatureCurve = conf.source.GetString("security.signature.curve")
+		if conf.signatureCurve != "" {
+			if _, err := primitives.GetCurve(conf.signatureCurve); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Set confidentiality protocol version of the transactions
+	conf.confidentialityProtocolVersion = "1.2"
+	if conf.source.IsSet("security.confidentialityProtocolVersion") {
+		ovveride := conf.source.GetString("security.confidentialityProtocolVersion")
+		if ovveride != "" {
+			conf.confidentialityProtocolVersion = ovveride
+		}
+	}
+	switch conf.confidentialityProtocolVersion {
+	case "1.1", "1.2", "1.3":
+	default:
+		return fmt.Errorf("Invalid confidentiality protocol version [%s]", conf.confidentialityProtocolVersion)
+	}
+
+	// Set HSM
+	conf.hsmProvider = ""
+	if conf.source.IsSet("security.hsm.provider") {
+		conf.hsmProvider = conf.source.GetString("security.hsm.provider")
+	}
+	conf.hsmKeys = make(map[string]bool)
+	if conf.hsmProvider != "" {
+		for _, class := range conf.source.GetStringSlice("security.hsm.keys") {
+			switch class {
+			case HSMKeyEnrollment, HSMKeyTLS, HSMKeyTCert:
+				conf.hsmKeys[class] = true
+			default:
+				return fmt.Errorf("Invalid HSM key class [%s]", class)
+			}
+		}
+	}
+
+	// Set enrollment by PKCS#10 certificate requests
+	conf.enrollmentCSR = false
+	if conf.source.IsSet("security.enrollment.csr") {
+		conf.enrollmentCSR = conf.source.GetBool("security.enrollment.csr")
+	}
+
+	// Set secret store
+	conf.secretsProvider = ""
+	if conf.source.IsSet("security.secrets.provider") {
+		conf.secretsProvider = conf.source.GetString("security.secrets.provider")
+	}
+	conf.secretsPaths = make(map[string]string)
+	if conf.secretsProvider != "" {
+		for _, class := range []string{SecretEnrollPassword, SecretKeyStorePassphrase, SecretTLSKey} {
+			conf.secretsPaths[class] = conf.source.GetString("security.secrets.paths." + class)
+		}
+	}
+
+	// Set keystore backend
+	conf.keyStoreBackend = defaultKeyStoreBackend()
+	if conf.source.IsSet("security.keystore.backend") {
+		ovveride := conf.source.GetString("security.keystore.backend")
+		if ovveride != "" {
+			conf.keyStoreBackend = ovveride
+		}
+	}
+	if _, ok := keyStoreBackends[conf.keyStoreBackend]; !ok {
+		return fmt.Errorf("Invalid keystore backend [%s]", conf.keyStoreBackend)
+	}
+
+	// Set keystore passphrase protection
+	conf.keyStoreKDF = ""
+	if conf.source.IsSet("security.keystore.kdf") {
+		conf.keyStoreKDF = conf.source.GetString("security.keystore.kdf")
+	}
+	switch conf.keyStoreKDF {
+	case "", KeyStoreKDFScrypt:
+	default:
+		return fmt.Errorf("Invalid keystore key derivation function [%s]", conf.keyStoreKDF)
+	}
+	conf.keyStoreScryptN = 1 << 15
+	if conf.source.IsSet("security.keystore.scrypt.n") {
+		conf.keyStoreScryptN = conf.source.GetInt("security.keystore.scrypt.n")
+	}
+	conf.keyStoreScryptR = 8
+	if conf.source.IsSet("security.keystore.scrypt.r") {
+		conf.keyStoreScryptR = conf.source.GetInt("security.keystore.scrypt.r")
+	}
+	conf.keyStoreScryptP = 1
+	if conf.source.IsSet("security.keystore.scrypt.p") {
+		conf.keyStoreScryptP = conf.source.GetInt("security.keystore.scrypt.p")
+	}
+
+	// Set keystore database encryption
+	conf.keyStoreCipherKey = KeyStoreCipherKeyPassphrase
+	if conf.source.IsSet("security.keystore.sqlcipher.key") {
+		ovveride := conf.source.GetString("security.keystore.sqlcipher.key")
+		if ovveride != "" {
+			conf.keyStoreCipherKey = ovveride
+		}
+	}
+	switch conf.keyStoreCipherKey {
+	case KeyStoreCipherKeyPassphrase, KeyStoreCipherKeyKeyring:
+	default:
+		return fmt.Errorf("Invalid keystore database key source [%s]", conf.keyStoreCipherKey)
+	}
+	conf.keyStoreKeyringService = "hyperledger"
+	if conf.source.IsSet("security.keystore.sqlcipher.keyring.service") {
+		ovveride := conf.source.GetString("security.keystore.sqlcipher.keyring.service")
+		if ovveride != "" {
+			conf.keyStoreKeyringService = ovveride
+		}
+	}
+
+	// Set CRL enforcement
+	conf.crlEnabled = false
+	if conf.source.IsSet("security.crl.enabled") {
+		conf.crlEnabled = conf.source.GetBool("security.crl.enabled")
+	}
+	conf.crlPeriod = 5 * time.Minute
+	if conf.source.IsSet("security.crl.period") {
+		ovveride := conf.source.GetDuration("security.crl.period")
+		if ovveride > 0 {
+			conf.crlPeriod = ovveride
+		}
+	}
+
+	// Set OCSP status checking
+	conf.ocspEnabled = false
+	if conf.source.IsSet("security.ocsp.enabled") {
+		conf.ocspEnabled = conf.source.GetBool("security.ocsp.enabled")
+	}
+	conf.ocspResponder = ""
+	if conf.source.IsSet("security.ocsp.responder") {
+		conf.ocspResponder = conf.source.GetString("security.ocsp.responder")
+	}
+	conf.ocspPolicy = OCSPPolicySoftFail
+	if conf.source.IsSet("security.ocsp.policy") {
+		conf.ocspPolicy = conf.source.GetString("security.ocsp.policy")
+	}
+	switch conf.ocspPolicy {
+	case OCSPPolicySoftFail, OCSPPolicyHardFail:
+	default:
+		return fmt.Errorf("Invalid OCSP policy [%s]", conf.ocspPolicy)
+	}
+	conf.ocspTimeout = 5 * time.Second
+	if conf.source.IsSet("security.ocsp.timeout") {
+		ovveride := conf.source.GetDuration("security.ocsp.timeout")
+		if ovveride > 0 {
+			conf.ocspTimeout = ovveride
+		}
+	}
+	conf.ocspCacheTTL = 10 * time.Minute
+	if conf.source.IsSet("security.ocsp.cache.ttl") {
+		ovveride := conf.source.GetDuration("security.ocsp.cache.ttl")
+		if ovveride > 0 {
+			conf.ocspCacheTTL = ovveride
+		}
+	}
+
+	// Set the probing of the health of the ECA before enrolling
+	conf.ecaHealthAttempts = 1
+	if conf.source.IsSet("security.ecahealth.attempts") {
+		ovveride := conf.source.GetInt("security.ecahealth.attempts")
+		if ovveride > 0 {
+			conf.ecaHealthAttempts = ovveride
+		}
+	}
+	conf.ecaHealthInterval = 2 * time.Second
+	if conf.source.IsSet("security.ecahealth.interval") {
+		ovveride := conf.source.GetDuration("security.ecahealth.interval")
+		if ovveride > 0 {
+			conf.ecaHealthInterval = ovveride
+		}
+	}
+
+	// Set the PEM bundles of the roots and the intermediate CAs the
+	// ECA and TCA certificates chain to
+	conf.chainRootsPath = ""
+	if conf.source.IsSet("security.chain.roots") {
+		conf.chainRootsPath = conf.source.GetString("security.chain.roots")
+	}
+	conf.chainIntermediatesPath = ""
+	if conf.source.IsSet("security.chain.intermediates") {
+		conf.chainIntermediatesPath = conf.source.GetString("security.chain.intermediates")
+	}
+
+	// Set the chaincodes auditors may decrypt
+	conf.auditChaincodes = nil
+	if conf.source.IsSet("security.audit.chaincodes") {
+		conf.auditChaincodes = conf.source.GetStringSlice("security.audit.chaincodes")
+	}
+
+	// Set replay protection
+	conf.nonceReplayProtection = true
+	if conf.source.IsSet("security.nonce.replayProtection") {
+		conf.nonceReplayProtection = conf.source.GetBool("security.nonce.replayProtection")
+	}
+
+	// Set whether connections must carry a TLS binding
+	conf.tlsBindingRequired = false
+	if conf.source.IsSet("security.tls.binding.required") {
+		conf.tlsBindingRequired = conf.source.GetBool("security.tls.binding.required")
+	}
+
+	// Set the number of workers verifying the transactions of a block
+	conf.validationWorkers = runtime.NumCPU()
+	if conf.source.IsSet("security.validation.workers") {
+		ovveride := conf.source.GetInt("security.validation.workers")
+		if ovveride > 0 {
+			conf.validationWorkers = ovveride
+		}
+	}
+
+	// Set the number of verified certificates cached
+	conf.certCacheSize = 1024
+	if conf.source.IsSet("security.validation.cache.size") {
+		conf.certCacheSize = conf.source.GetInt("security.validation.cache.size")
+	}
+
 	// Set multithread
 	conf.multiThreading = false
-	if viper.IsSet("security.multithreading.enabled") {
-		conf.multiThreading = viper.GetBool("security.multithreading.enabled")
+	if conf.source.IsSet("security.multithreading.enabled") {
+		conf.multiThreading = conf.source.GetBool("security.multithreading.enabled")
+	}
+
+	// Set TCert pool provider
+	conf.tCertPoolProvider = TCertPoolSingleThread
+	if conf.multiThreading {
+		conf.tCertPoolProvider = TCertPoolMultithreading
+	}
+	if conf.source.IsSet("security.tcert.pool.provider") {
+		ovveride := conf.source.GetString("security.tcert.pool.provider")
+		if ovveride != "" {
+			conf.tCertPoolProvider = ovveride
+		}
+	}
+
+	// Set adaptive TCert pool sizing
+	conf.tCertPoolAdaptive = false
+	if conf.source.IsSet("security.tcert.pool.adaptive.enabled") {
+		conf.tCertPoolAdaptive = conf.source.GetBool("security.tcert.pool.adaptive.enabled")
+	}
+
+	conf.tCertPoolMinSize = conf.tCertBatchSize / 10
+	if conf.tCertPoolMinSize < 1 {
+		conf.tCertPoolMinSize = 1
+	}
+	if conf.source.IsSet("security.tcert.pool.adaptive.min") {
+		ovveride := conf.source.GetInt("security.tcert.pool.adaptive.min")
+		if ovveride > 0 {
+			conf.tCertPoolMinSize = ovveride
+		}
+	}
+
+	conf.tCertPoolMaxSize = conf.tCertBatchSize * 5
+	if conf.source.IsSet("security.tcert.pool.adaptive.max") {
+		ovveride := conf.source.GetInt("security.tcert.pool.adaptive.max")
+		if ovveride > 0 {
+			conf.tCertPoolMaxSize = ovveride
+		}
+	}
+	if conf.tCertPoolMaxSize < conf.tCertPoolMinSize {
+		return fmt.Errorf("Invalid adaptive TCert pool bounds: min [%d] greater than max [%d]", conf.tCertPoolMinSize, conf.tCertPoolMaxSize)
+	}
+
+	// Set TCert expiration handling
+	conf.tCertExpiryMargin = 1 * time.Hour
+	if conf.source.IsSet("security.tcert.pool.expiry.margin") {
+		conf.tCertExpiryMargin = conf.source.GetDuration("security.tcert.pool.expiry.margin")
+	}
+	conf.tCertExpirySweep = 1 * time.Minute
+	if conf.source.IsSet("security.tcert.pool.expiry.sweep") {
+		ovveride := conf.source.GetDuration("security.tcert.pool.expiry.sweep")
+		if ovveride > 0 {
+			conf.tCertExpirySweep = ovveride
+		}
+	}
+
+	// Set TCA refill backoff
+	conf.tCertBackoffInitial = 1 * time.Second
+	if conf.source.IsSet("security.tcert.pool.backoff.initial") {
+		ovveride := conf.source.GetDuration("security.tcert.pool.backoff.initial")
+		if ovveride > 0 {
+			conf.tCertBackoffInitial = ovveride
+		}
+	}
+	conf.tCertBackoffMultiplier = 2
+	if conf.source.IsSet("security.tcert.pool.backoff.multiplier") {
+		ovveride := conf.source.GetFloat64("security.tcert.pool.backoff.multiplier")
+		if ovveride >= 1 {
+			conf.tCertBackoffMultiplier = ovveride
+		}
+	}
+	conf.tCertBackoffMax = 1 * time.Minute
+	if conf.source.IsSet("security.tcert.pool.backoff.max") {
+		ovveride := conf.source.GetDuration("security.tcert.pool.backoff.max")
+		if ovveride > 0 {
+			conf.tCertBackoffMax = ovveride
+		}
+	}
+	conf.tCertBackoffJitter = 0.2
+	if conf.source.IsSet("security.tcert.pool.backoff.jitter") {
+		ovveride := conf.source.GetFloat64("security.tcert.pool.backoff.jitter")
+		if ovveride >= 0 && ovveride < 1 {
+			conf.tCertBackoffJitter = ovveride
+		}
+	}
+	conf.tCertBreakerThreshold = 5
+	if conf.source.IsSet("security.tcert.pool.backoff.breaker.threshold") {
+		conf.tCertBreakerThreshold = conf.source.GetInt("security.tcert.pool.backoff.breaker.threshold")
+	}
+	conf.tCertBreakerCooldown = 30 * time.Second
+	if conf.source.IsSet("security.tcert.pool.backoff.breaker.cooldown") {
+		ovveride := conf.source.GetDuration("security.tcert.pool.backoff.breaker.cooldown")
+		if ovveride > 0 {
+			conf.tCertBreakerCooldown = ovveride
+		}
+	}
+
+	// Set the quota of the shared TCert pool
+	conf.tCertPoolQuota = 0
+	if conf.source.IsSet("security.tcert.pool.shared.quota") {
+		ovveride := conf.source.GetInt("security.tcert.pool.shared.quota")
+		if ovveride > 0 {
+			conf.tCertPoolQuota = ovveride
+		}
+	}
+
+	// Set TCert reuse policy
+	conf.tCertReusePolicy = TCertReuseSingle
+	if conf.source.IsSet("security.tcert.pool.reuse.policy") {
+		ovveride := conf.source.GetString("security.tcert.pool.reuse.policy")
+		if ovveride != "" {
+			conf.tCertReusePolicy = ovveride
+		}
+	}
+	switch conf.tCertReusePolicy {
+	case TCertReuseSingle, TCertReuseCount, TCertReuseTime:
+	default:
+		return fmt.Errorf("Invalid TCert reuse policy [%s]", conf.tCertReusePolicy)
+	}
+	conf.tCertReuseCount = 10
+	if conf.source.IsSet("security.tcert.pool.reuse.count") {
+		ovveride := conf.source.GetInt("security.tcert.pool.reuse.count")
+		if ovveride > 0 {
+			conf.tCertReuseCount = ovveride
+		}
+	}
+	conf.tCertReusePeriod = 1 * time.Minute
+	if conf.source.IsSet("security.tcert.pool.reuse.period") {
+		ovveride := conf.source.GetDuration("security.tcert.pool.reuse.period")
+		if ovveride > 0 {
+			conf.tCertReusePeriod = ovveride
+		}
+	}
+
+	// Set TCert pool health check
+	conf.tCertHealthPeriod = 5 * time.Minute
+	if conf.source.IsSet("security.tcert.pool.health.period") {
+		ovveride := conf.source.GetDuration("security.tcert.pool.health.period")
+		if ovveride > 0 {
+			conf.tCertHealthPeriod = ovveride
+		}
+	}
+	conf.tCertHealthSamples = 3
+	if conf.source.IsSet("security.tcert.pool.health.samples") {
+		ovveride := conf.source.GetInt("security.tcert.pool.health.samples")
+		if ovveride > 0 {
+			conf.tCertHealthSamples = ovveride
+		}
+	}
+
+	// Set used TCerts retention policy
+	conf.usedTCertsRetentionAge = 0
+	if conf.source.IsSet("security.tcert.used.retention.age") {
+		ovveride := conf.source.GetDuration("security.tcert.used.retention.age")
+		if ovveride > 0 {
+			conf.usedTCertsRetentionAge = ovveride
+		}
+	}
+	conf.usedTCertsRetentionCount = 0
+	if conf.source.IsSet("security.tcert.used.retention.count") {
+		ovveride := conf.source.GetInt("security.tcert.used.retention.count")
+		if ovveride > 0 {
+			conf.usedTCertsRetentionCount = ovveride
+		}
+	}
+	conf.usedTCertsCompaction = 1 * time.Hour
+	if conf.source.IsSet("security.tcert.used.compaction") {
+		ovveride := conf.source.GetDuration("security.tcert.used.compaction")
+		if ovveride > 0 {
+			conf.usedTCertsCompaction = ovveride
+		}
 	}
 
 	// Set attributes
 	conf.tCertAttributes = []*membersrvc.TCertAttribute{}
-	if viper.IsSet("security.tcert.attributes") {
-		attributes := viper.GetStringMapString("security.tcert.attributes")
+	if conf.source.IsSet("security.tcert.attributes") {
+		attributes := conf.source.GetStringMapString("security.tcert.attributes")
 		for key, value := range attributes {
 			conf.tCertAttributes = append(conf.tCertAttributes, &membersrvc.TCertAttribute{key, value})
 		}
 	}
 
+	// Set per-chaincode TCert pools
+	conf.tCertChaincodePools = make(map[string]*tCertChaincodePool)
+	if conf.source.IsSet("security.tcert.chaincodes") {
+		for chaincodeID := range conf.source.GetStringMap("security.tcert.chaincodes") {
+			property := "security.tcert.chaincodes." + chaincodeID
+			conf.tCertChaincodePools[chaincodeID] = &tCertChaincodePool{
+				attributes: conf.source.GetStringSlice(property + ".attributes"),
+				size:       conf.source.GetInt(property + ".size"),
+			}
+		}
+	}
+
 	return nil
 }
 
 func (conf *configuration) checkProperty(property string) error {
-	res := viper.GetString(property)
+	res := conf.source.GetString(property)
 	if res == "" {
 		return errors.New("Property not specified in configuration file. Please check that property is set: " + property)
 	}
@@ -166,15 +765,15 @@ func (conf *configuration) checkProperty(property string) error {
 }
 
 func (conf *configuration) getTCAPAddr() string {
-	return viper.GetString(conf.tcaPAddressProperty)
+	return conf.source.GetString(conf.tcaPAddressProperty)
 }
 
 func (conf *configuration) getECAPAddr() string {
-	return viper.GetString(conf.ecaPAddressProperty)
+	return conf.source.GetString(conf.ecaPAddressProperty)
 }
 
 func (conf *configuration) getTLSCAPAddr() string {
-	return viper.GetString(conf.tlscaPAddressProperty)
+	return conf.source.GetString(conf.tlscaPAddressProperty)
 }
 
 func (conf *configuration) getConfPath() string {
@@ -197,6 +796,10 @@ func (conf *configuration) getRawsPath() string {
 	return conf.rawsPath
 }
 
+func (conf *configuration) getKeyStoreBackend() string {
+	return conf.keyStoreBackend
+}
+
 func (conf *configuration) getKeyStoreFilename() string {
 	return "db"
 }
@@ -209,6 +812,10 @@ func (conf *configuration) getPathForAlias(alias string) string {
 	return filepath.Join(conf.getRawsPath(), alias)
 }
 
+func (conf *configuration) getKeyStoreKDFParamsFilename() string {
+	return "keystore.kdf"
+}
+
 func (conf *configuration) getQueryStateKeyFilename() string {
 	return "query.key"
 }
@@ -242,15 +849,15 @@ func (conf *configuration) getTLSCACertsChainFilename() string {
 }
 
 func (conf *configuration) getTLSCACertsExternalPath() string {
-	return viper.GetString("peer.pki.tls.rootcert.file")
+	return conf.source.GetString("peer.pki.tls.rootcert.file")
 }
 
 func (conf *configuration) isTLSEnabled() bool {
-	return viper.GetBool("peer.pki.tls.enabled")
+	return conf.source.GetBool("peer.pki.tls.enabled")
 }
 
 func (conf *configuration) isTLSClientAuthEnabled() bool {
-	return viper.GetBool("peer.pki.tls.client.auth.enabled")
+	return conf.source.GetBool("peer.pki.tls.client.auth.enabled")
 }
 
 func (conf *configuration) IsMultithreadingEnabled() bool {
@@ -289,10 +896,215 @@ func (conf *configuration) getTCertOwnerKDFKeyFilename() string {
 	return "tca.kdf.key"
 }
 
+func (conf *configuration) getTCertsMasterKeyFilename() string {
+	return "tcerts.master.key"
+}
+
 func (conf *configuration) getTCertBatchSize() int {
 	return conf.tCertBatchSize
 }
 
+func (conf *configuration) isTCertBatchStreamEnabled() bool {
+	return conf.tCertBatchStream
+}
+
+func (conf *configuration) isTCertDualKeyEnabled() bool {
+	return conf.tCertDualKey
+}
+
+func (conf *configuration) isTCertOfflineEnabled() bool {
+	return conf.tCertOffline
+}
+
+func (conf *configuration) getTCertReserve() int {
+	return conf.tCertReserve
+}
+
+func (conf *configuration) getCSPProvider() string {
+	return conf.cspProvider
+}
+
+func (conf *configuration) getKeyStoreKDF() string {
+	return conf.keyStoreKDF
+}
+
+func (conf *configuration) getKeyStoreScryptParams() (int, int, int) {
+	return conf.keyStoreScryptN, conf.keyStoreScryptR, conf.keyStoreScryptP
+}
+
+func (conf *configuration) getKeyStoreCipherKey() string {
+	return conf.keyStoreCipherKey
+}
+
+func (conf *configuration) getKeyStoreKeyringService() string {
+	return conf.keyStoreKeyringService
+}
+
+func (conf *configuration) getKeyStoreKeyringUser() string {
+	return conf.prefix + "." + conf.name
+}
+
+func (conf *configuration) getConfidentialityProtocolVersion() string {
+	return conf.confidentialityProtocolVersion
+}
+
+func (conf *configuration) getSignatureScheme() string {
+	return conf.signatureScheme
+}
+
+func (conf *configuration) getSignatureCurve() string {
+	return conf.signatureCurve
+}
+
+func (conf *configuration) getHSMProvider() string {
+	return conf.hsmProvider
+}
+
+func (conf *configuration) isKeyInHSM(class string) bool {
+	return conf.hsmKeys[class]
+}
+
+func (conf *configuration) isEnrollmentCSREnabled() bool {
+	return conf.enrollmentCSR
+}
+
+func (conf *configuration) getSecretStoreProvider() string {
+	return conf.secretsProvider
+}
+
+func (conf *configuration) getSecretPath(class string) string {
+	return conf.secretsPaths[class]
+}
+
+func (conf *configuration) getTCertPoolProvider() string {
+	return conf.tCertPoolProvider
+}
+
+func (conf *configuration) isTCertPoolAdaptive() bool {
+	return conf.tCertPoolAdaptive
+}
+
+func (conf *configuration) getTCertPoolMinSize() int {
+	return conf.tCertPoolMinSize
+}
+
+func (conf *configuration) getTCertPoolMaxSize() int {
+	return conf.tCertPoolMaxSize
+}
+
+func (conf *configuration) getTCertExpiryMargin() time.Duration {
+	return conf.tCertExpiryMargin
+}
+
+func (conf *configuration) getTCertExpirySweep() time.Duration {
+	return conf.tCertExpirySweep
+}
+
+func (conf *configuration) getValidationWorkers() int {
+	return conf.validationWorkers
+}
+
+func (conf *configuration) getCertCacheSize() int {
+	return conf.certCacheSize
+}
+
+func (conf *configuration) isCRLEnabled() bool {
+	return conf.crlEnabled
+}
+
+func (conf *configuration) getCRLPeriod() time.Duration {
+	return conf.crlPeriod
+}
+
+func (conf *configuration) isOCSPEnabled() bool {
+	return conf.ocspEnabled
+}
+
+func (conf *configuration) getOCSPResponder() string {
+	return conf.ocspResponder
+}
+
+func (conf *configuration) getOCSPPolicy() string {
+	return conf.ocspPolicy
+}
+
+func (conf *configuration) getOCSPTimeout() time.Duration {
+	return conf.ocspTimeout
+}
+
+func (conf *configuration) getOCSPCacheTTL() time.Duration {
+	return conf.ocspCacheTTL
+}
+
+func (conf *configuration) getECAHealthAttempts() int {
+	return conf.ecaHealthAttempts
+}
+
+func (conf *configuration) getECAHealthInterval() time.Duration {
+	return conf.ecaHealthInterval
+}
+
+func (conf *configuration) getChainRootsPath() string {
+	return conf.chainRootsPath
+}
+
+func (conf *configuration) getChainIntermediatesPath() string {
+	return conf.chainIntermediatesPath
+}
+
+func (conf *configuration) getAuditChaincodes() []string {
+	return conf.auditChaincodes
+}
+
+func (conf *configuration) isNonceReplayProtectionEnabled() bool {
+	return conf.nonceReplayProtection
+}
+
+func (conf *configuration) isTLSBindingRequired() bool {
+	return conf.tlsBindingRequired
+}
+
+func (conf *configuration) getTCertPoolQuota() int {
+	return conf.tCertPoolQuota
+}
+
+func (conf *configuration) getTCertReusePolicy() string {
+	return conf.tCertReusePolicy
+}
+
+func (conf *configuration) getTCertReuseCount() int {
+	return conf.tCertReuseCount
+}
+
+func (conf *configuration) getTCertReusePeriod() time.Duration {
+	return conf.tCertReusePeriod
+}
+
+func (conf *configuration) getTCertHealthPeriod() time.Duration {
+	return conf.tCertHealthPeriod
+}
+
+func (conf *configuration) getTCertHealthSamples() int {
+	return conf.tCertHealthSamples
+}
+
+func (conf *configuration) getUsedTCertsRetention() (time.Duration, int) {
+	return conf.usedTCertsRetentionAge, conf.usedTCertsRetentionCount
+}
+
+func (conf *configuration) getUsedTCertsCompaction() time.Duration {
+	return conf.usedTCertsCompaction
+}
+
+func (conf *configuration) getTCertChaincodePool(chaincodeID string) (*tCertChaincodePool, bool) {
+	pool, ok := conf.tCertChaincodePools[chaincodeID]
+	return pool, ok
+}
+
+func (conf *configuration) getTCertChaincodePools() map[string]*tCertChaincodePool {
+	return conf.tCertChaincodePools
+}
+
 func (conf *configuration) getTCertAttributes() []*membersrvc.TCertAttribute {
 	return conf.tCertAttributes
 }
@@ -0,0 +1,132 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/x509"
+	"errors"
+	"sync"
+	"time"
+
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// crlCache holds the serial numbers of the certificates listed by the
+// last CRLs fetched from the ECA and the TCA, by issuer. The zero value
+// is an empty cache.
+type crlCache struct {
+	m        sync.RWMutex
+	revoked  map[string]map[string]bool
+	lastPoll time.Time
+}
+
+func (cache *crlCache) set(issuer []byte, serials map[string]bool) {
+	cache.m.Lock()
+	defer cache.m.Unlock()
+
+	if cache.revoked == nil {
+		cache.revoked = make(map[string]map[string]bool)
+	}
+	cache.revoked[string(issuer)] = serials
+}
+
+func (cache *crlCache) contains(cert *x509.Certificate) bool {
+	cache.m.RLock()
+	defer cache.m.RUnlock()
+
+	return cert.SerialNumber != nil && cache.revoked[string(cert.RawIssuer)][cert.SerialNumber.String()]
+}
+
+// pollDue returns true, and records the poll, if the last poll
+// is older than period
+func (cache *crlCache) pollDue(period time.Duration) bool {
+	cache.m.Lock()
+	defer cache.m.Unlock()
+
+	if time.Since(cache.lastPoll) < period {
+		return false
+	}
+	cache.lastPoll = time.Now()
+
+	return true
+}
+
+// isCertificateRevoked returns true if cert is listed by the CRL of the
+// ECA or the TCA. The CRLs are fetched again once the period configured
+// under security.crl.period has elapsed.
+func (node *nodeImpl) isCertificateRevoked(cert *x509.Certificate) bool {
+	if !node.conf.isCRLEnabled() {
+		return false
+	}
+
+	if node.crls.pollDue(node.conf.getCRLPeriod()) {
+		// On failure, the CRLs fetched before stay in place
+		if err := node.fetchCRL(node.conf.getECACertsChainFilename(), node.callECAReadCRL); err != nil {
+			node.error("Failed fetching ECA CRL [%s].", err.Error())
+		}
+		if err := node.fetchCRL(node.conf.getTCACertsChainFilename(), node.callTCAReadCRL); err != nil {
+			node.error("Failed fetching TCA CRL [%s].", err.Error())
+		}
+	}
+
+	return node.crls.contains(cert)
+}
+
+// fetchCRL reads the CRL of the CA whose certificate is stored under
+// chainFilename, verifies it and caches the revoked serial numbers.
+func (node *nodeImpl) fetchCRL(chainFilename string, readCRL func(context.Context, ...grpc.CallOption) (*membersrvc.CRL, error)) error {
+	raw, err := node.ks.loadCert(chainFilename)
+	if err != nil {
+		return err
+	}
+	caCert, err := utils.PEMtoCertificate(raw)
+	if err != nil {
+		return err
+	}
+
+	resp, err := readCRL(context.Background())
+	if err != nil {
+		return err
+	}
+	crl, err := x509.ParseCRL(resp.Crl)
+	if err != nil {
+		return err
+	}
+	if err := caCert.CheckCRLSignature(crl); err != nil {
+		return err
+	}
+	if crl.HasExpired(time.Now()) {
+		return errors.New("CRL expired.")
+	}
+
+	serials := make(map[string]bool)
+	for _, revoked := range crl.TBSCertList.RevokedCertificates {
+		serials[revoked.SerialNumber.String()] = true
+	}
+	node.crls.set(caCert.RawSubject, serials)
+
+	// Verify again the certificates verified before this CRL
+	node.certs.purge()
+
+	node.debug("Fetched CRL listing [%d] certificates.", len(serials))
+
+	return nil
+}
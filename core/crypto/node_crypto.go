@@ -18,15 +18,26 @@ package crypto
 
 import (
 	"crypto/x509"
-
-	ecies "github.com/hyperledger/fabric/core/crypto/primitives/ecies"
 )
 
 func (node *nodeImpl) registerCryptoEngine(enrollID, enrollPWD string) error {
 	node.debug("Registering node crypto engine...")
 
-	// Init CLI
-	node.eciesSPI = ecies.NewSPI()
+	// Init CSP
+	if err := node.initCSP(); err != nil {
+		return err
+	}
+
+	// Init HSM
+	if err := node.initHSM(); err != nil {
+		return err
+	}
+
+	if err := node.initCertsChains(); err != nil {
+		node.error("Failed initializing certificates chains [%s].", err.Error())
+
+		return err
+	}
 
 	if err := node.initTLS(); err != nil {
 		node.error("Failed initliazing TLS [%s].", err.Error())
@@ -66,15 +77,26 @@ func (node *nodeImpl) registerCryptoEngine(enrollID, enrollPWD string) error {
 func (node *nodeImpl) initCryptoEngine() error {
 	node.debug("Initializing node crypto engine...")
 
-	// Init CLI
-	node.eciesSPI = ecies.NewSPI()
+	// Init CSP
+	if err := node.initCSP(); err != nil {
+		return err
+	}
+
+	// Init HSM
+	if err := node.initHSM(); err != nil {
+		return err
+	}
 
 	// Init certPools
-	node.rootsCertPool = x509.NewCertPool()
 	node.tlsCertPool = x509.NewCertPool()
 	node.ecaCertPool = x509.NewCertPool()
 	node.tcaCertPool = x509.NewCertPool()
 
+	// Load roots and intermediate CAs
+	if err := node.initCertsChains(); err != nil {
+		return err
+	}
+
 	// Load ECA certs chain
 	if err := node.loadECACertsChain(); err != nil {
 		return err
@@ -85,6 +107,11 @@ func (node *nodeImpl) initCryptoEngine() error {
 		return err
 	}
 
+	// Complete an interrupted enrollment key rotation
+	if err := node.completeEnrollmentKeyRotation(); err != nil {
+		return err
+	}
+
 	// Load enrollment secret key
 	if err := node.loadEnrollmentKey(); err != nil {
 		return err
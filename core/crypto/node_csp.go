@@ -0,0 +1,195 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/primitives/ecies"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/crypto/ed25519"
+)
+
+// CSPSoftware is the name of the default crypto service provider,
+// implemented in software on top of the primitives package
+const CSPSoftware = "sw"
+
+// CSP is a crypto service provider. It performs the cryptographic operations
+// of clients, peers and validators, so that they can be carried out by an HSM
+// or with alternative algorithms.
+type CSP interface {
+	// GenerateKey generates a new signing key
+	GenerateKey() (interface{}, error)
+
+	// Sign signs msg using signKey
+	Sign(signKey interface{}, msg []byte) ([]byte, error)
+
+	// Verify verifies signature over msg using verKey
+	Verify(verKey interface{}, msg, signature []byte) (bool, error)
+
+	// Encrypt encrypts msg using the symmetric key
+	Encrypt(key, msg []byte) ([]byte, error)
+
+	// Decrypt decrypts ct using the symmetric key
+	Decrypt(key, ct []byte) ([]byte, error)
+
+	// Hash hashes msg
+	Hash(msg []byte) []byte
+
+	// GetAsymmetricCipherSPI returns the SPI used to encrypt under public keys
+	GetAsymmetricCipherSPI() primitives.AsymmetricCipherSPI
+}
+
+// CSPProvider creates a CSP for the node named name
+type CSPProvider func(name string) (CSP, error)
+
+var (
+	cspProviders     = make(map[string]CSPProvider)
+	cspProvidersLock sync.RWMutex
+)
+
+func init() {
+	RegisterCSPProvider(CSPSoftware, newSoftwareCSP)
+}
+
+// RegisterCSPProvider registers provider under name. Nodes select
+// the provider to use by setting the property security.csp.provider.
+func RegisterCSPProvider(name string, provider CSPProvider) error {
+	if name == "" || provider == nil {
+		return fmt.Errorf("Invalid crypto service provider [%s]", name)
+	}
+
+	cspProvidersLock.Lock()
+	defer cspProvidersLock.Unlock()
+
+	if _, ok := cspProviders[name]; ok {
+		return fmt.Errorf("Crypto service provider [%s] already registered", name)
+	}
+	cspProviders[name] = provider
+
+	return nil
+}
+
+// GetCSPProviders returns the names of the registered crypto service providers
+func GetCSPProviders() []string {
+	cspProvidersLock.RLock()
+	defer cspProvidersLock.RUnlock()
+
+	names := make([]string, 0, len(cspProviders))
+	for name := range cspProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func newCSP(provider, name string) (CSP, error) {
+	cspProvidersLock.RLock()
+	newProvider, ok := cspProviders[provider]
+	cspProvidersLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Crypto service provider [%s] not registered", provider)
+	}
+
+	return newProvider(name)
+}
+
+// initCSP sets up the crypto service provider configured for the node
+func (node *nodeImpl) initCSP() (err error) {
+	node.debug("Using crypto service provider [%s]", node.conf.getCSPProvider())
+
+	if node.csp, err = newCSP(node.conf.getCSPProvider(), node.conf.name); err != nil {
+		node.error("Failed initializing crypto service provider [%s]", err)
+
+		return
+	}
+	node.eciesSPI = node.csp.GetAsymmetricCipherSPI()
+
+	return
+}
+
+// generateECDSAKey generates a new ECDSA key, the kind of key
+// the ECA and the TLSCA certify
+func (node *nodeImpl) generateECDSAKey() (*ecdsa.PrivateKey, error) {
+	key, err := node.csp.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, utils.ErrInvalidKey
+	}
+
+	return ecdsaKey, nil
+}
+
+// softwareCSP is the default crypto service provider:
+// ECDSA, AES-CBC, ECIES and the configured hash function
+type softwareCSP struct {
+	eciesSPI primitives.AsymmetricCipherSPI
+}
+
+func newSoftwareCSP(name string) (CSP, error) {
+	return &softwareCSP{ecies.NewSPI()}, nil
+}
+
+func (csp *softwareCSP) GenerateKey() (interface{}, error) {
+	return primitives.NewECDSAKey()
+}
+
+func (csp *softwareCSP) Sign(signKey interface{}, msg []byte) ([]byte, error) {
+	switch key := signKey.(type) {
+	case *hsmPrivateKey:
+		return key.Sign(rand.Reader, primitives.Hash(msg), nil)
+	case ed25519.PrivateKey:
+		return primitives.Ed25519Sign(key, msg)
+	}
+
+	return primitives.ECDSASign(signKey, msg)
+}
+
+func (csp *softwareCSP) Verify(verKey interface{}, msg, signature []byte) (bool, error) {
+	if _, ok := verKey.(ed25519.PublicKey); ok {
+		return primitives.Ed25519Verify(verKey, msg, signature)
+	}
+
+	return primitives.ECDSAVerify(verKey, msg, signature)
+}
+
+func (csp *softwareCSP) Encrypt(key, msg []byte) ([]byte, error) {
+	return primitives.CBCPKCS7Encrypt(key, msg)
+}
+
+func (csp *softwareCSP) Decrypt(key, ct []byte) ([]byte, error) {
+	return primitives.CBCPKCS7Decrypt(key, ct)
+}
+
+func (csp *softwareCSP) Hash(msg []byte) []byte {
+	return primitives.Hash(msg)
+}
+
+func (csp *softwareCSP) GetAsymmetricCipherSPI() primitives.AsymmetricCipherSPI {
+	return csp.eciesSPI
+}
@@ -18,7 +18,6 @@ package crypto
 
 import (
 	"crypto/ecdsa"
-	"crypto/rand"
 	"crypto/x509"
 	protobuf "google/protobuf"
 	"time"
@@ -26,15 +25,17 @@ import (
 	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
 
 	"encoding/asn1"
-	"errors"
 	"io/ioutil"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/hyperledger/fabric/core/crypto/primitives/ecies"
+	"github.com/hyperledger/fabric/core/crypto/primitives/secp256k1"
 	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/crypto/ed25519"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 var (
@@ -52,23 +53,21 @@ func (node *nodeImpl) retrieveECACertsChain(userID string) error {
 	}
 	node.debug("ECA certificate [% x].", ecaCertRaw)
 
-	// TODO: Test ECA cert againt root CA
-	// TODO: check response.Cert against rootCA
-	x509ECACert, err := utils.DERToX509Certificate(ecaCertRaw)
+	chain, err := node.verifyCACertsChain(ecaCertRaw)
 	if err != nil {
-		node.error("Failed parsing ECA certificate [%s].", err.Error())
+		node.error("Failed verifying ECA certificate [%s].", err.Error())
 
 		return err
 	}
 
 	// Prepare ecaCertPool
 	node.ecaCertPool = x509.NewCertPool()
-	node.ecaCertPool.AddCert(x509ECACert)
+	node.ecaCertPool.AddCert(chain[0])
 
 	// Store ECA cert
 	node.debug("Storing ECA certificate for [%s]...", userID)
 
-	if err := node.ks.storeCert(node.conf.getECACertsChainFilename(), ecaCertRaw); err != nil {
+	if err := node.storeCACertsChain(node.conf.getECACertsChainFilename(), chain); err != nil {
 		node.error("Failed storing eca certificate [%s].", err.Error())
 		return err
 	}
@@ -77,6 +76,10 @@ func (node *nodeImpl) retrieveECACertsChain(userID string) error {
 }
 
 func (node *nodeImpl) retrieveEnrollmentData(enrollID, enrollPWD string) error {
+	if err := node.waitECAHealth(); err != nil {
+		return err
+	}
+
 	key, enrollCertRaw, enrollChainKey, err := node.getEnrollmentCertificateFromECA(enrollID, enrollPWD)
 	if err != nil {
 		node.error("Failed getting enrollment certificate [id=%s]: [%s]", enrollID, err)
@@ -95,7 +98,7 @@ func (node *nodeImpl) retrieveEnrollmentData(enrollID, enrollPWD string) error {
 	}
 
 	// Store enrollment key
-	if err := node.ks.storePrivateKey(node.conf.getEnrollmentKeyFilename(), key); err != nil {
+	if err := node.storePrivateKeyOfClass(HSMKeyEnrollment, node.conf.getEnrollmentKeyFilename(), key); err != nil {
 		node.error("Failed storing enrollment key [id=%s]: [%s]", enrollID, err)
 		return err
 	}
@@ -113,7 +116,7 @@ func (node *nodeImpl) retrieveEnrollmentData(enrollID, enrollPWD string) error {
 
 	// Code for confidentiality 1.2
 	// Store enrollment chain key
-	if node.eType == NodeValidator {
+	if node.holdsChainPrivateKey() {
 		node.debug("Enrollment chain key for validator [%s]...", enrollID)
 		// enrollChainKey is a secret key
 
@@ -152,14 +155,28 @@ func (node *nodeImpl) retrieveEnrollmentData(enrollID, enrollPWD string) error {
 func (node *nodeImpl) loadEnrollmentKey() error {
 	node.debug("Loading enrollment key...")
 
-	enrollPrivKey, err := node.ks.loadPrivateKey(node.conf.getEnrollmentKeyFilename())
+	enrollPrivKey, err := node.loadPrivateKeyOfClass(HSMKeyEnrollment, node.conf.getEnrollmentKeyFilename())
 	if err != nil {
 		node.error("Failed loading enrollment private key [%s].", err.Error())
 
 		return err
 	}
 
-	node.enrollPrivKey = enrollPrivKey.(*ecdsa.PrivateKey)
+	// A key held by an HSM can only sign
+	node.enrollSignKey = enrollPrivKey
+	if key, ok := enrollPrivKey.(*ecdsa.PrivateKey); ok {
+		node.enrollPrivKey = key
+	}
+
+	// unless it is the key of a client, exported to derive the TCert keys.
+	// The exported key stays in memory.
+	if node.isEnrollmentKeyExtractable() {
+		if node.enrollPrivKey, err = node.exportPrivateKeyOfClass(HSMKeyEnrollment, node.conf.getEnrollmentKeyFilename()); err != nil {
+			node.error("Failed exporting enrollment private key [%s].", err.Error())
+
+			return err
+		}
+	}
 
 	return nil
 }
@@ -176,8 +193,8 @@ func (node *nodeImpl) loadEnrollmentCertificate() error {
 	node.enrollCert = cert
 
 	// TODO: move this to retrieve
-	pk := node.enrollCert.PublicKey.(*ecdsa.PublicKey)
-	err = primitives.VerifySignCapability(node.enrollPrivKey, pk)
+	pk := node.enrollCert.PublicKey
+	err = primitives.VerifySignCapability(node.enrollSignKey, pk)
 	if err != nil {
 		node.error("Failed checking enrollment certificate against enrollment key [%s].", err.Error())
 
@@ -185,11 +202,11 @@ func (node *nodeImpl) loadEnrollmentCertificate() error {
 	}
 
 	// Set node ID
-	node.id = primitives.Hash(der)
+	node.id = node.csp.Hash(der)
 	node.debug("Setting id to [% x].", node.id)
 
 	// Set eCertHash
-	node.enrollCertHash = primitives.Hash(der)
+	node.enrollCertHash = node.csp.Hash(der)
 	node.debug("Setting enrollCertHash to [% x].", node.enrollCertHash)
 
 	return nil
@@ -225,7 +242,7 @@ func (node *nodeImpl) loadEnrollmentChainKey() error {
 	//node.enrollChainKey = enrollChainKey
 
 	// Code for confidentiality 1.1
-	if node.eType == NodeValidator {
+	if node.holdsChainPrivateKey() {
 		// enrollChainKey is a secret key
 		enrollChainKey, err := node.ks.loadPrivateKey(node.conf.getEnrollmentChainKeyFilename())
 		if err != nil {
@@ -249,20 +266,12 @@ func (node *nodeImpl) loadEnrollmentChainKey() error {
 func (node *nodeImpl) loadECACertsChain() error {
 	node.debug("Loading ECA certificates chain...")
 
-	pem, err := node.ks.loadCert(node.conf.getECACertsChainFilename())
-	if err != nil {
+	if err := node.loadCACertsChain(node.conf.getECACertsChainFilename(), node.ecaCertPool); err != nil {
 		node.error("Failed loading ECA certificates chain [%s].", err.Error())
 
 		return err
 	}
 
-	ok := node.ecaCertPool.AppendCertsFromPEM(pem)
-	if !ok {
-		node.error("Failed appending ECA certificates chain.")
-
-		return errors.New("Failed appending ECA certificates chain.")
-	}
-
 	return nil
 }
 
@@ -297,6 +306,25 @@ func (node *nodeImpl) callECAReadCACertificate(ctx context.Context, opts ...grpc
 	return cert, nil
 }
 
+func (node *nodeImpl) callECAReadCRL(ctx context.Context, opts ...grpc.CallOption) (*membersrvc.CRL, error) {
+	// Get an ECA Client
+	sock, ecaP, err := node.getECAClient()
+	if err != nil {
+		return nil, err
+	}
+	defer sock.Close()
+
+	// Issue the request
+	crl, err := ecaP.ReadCRL(ctx, &membersrvc.Empty{}, opts...)
+	if err != nil {
+		node.error("Failed requesting eca CRL [%s].", err.Error())
+
+		return nil, err
+	}
+
+	return crl, nil
+}
+
 func (node *nodeImpl) callECAReadCertificate(ctx context.Context, in *membersrvc.ECertReadReq, opts ...grpc.CallOption) (*membersrvc.CertPair, error) {
 	// Get an ECA Client
 	sock, ecaP, err := node.getECAClient()
@@ -329,27 +357,88 @@ func (node *nodeImpl) callECAReadCertificateByHash(ctx context.Context, in *memb
 	return &membersrvc.CertPair{Sign: resp.Cert, Enc: nil}, nil
 }
 
+func (node *nodeImpl) callECACheckHealth(ctx context.Context, opts ...grpc.CallOption) (*membersrvc.CAHealth, error) {
+	// Get an ECA Client
+	sock, ecaP, err := node.getECAClient()
+	if err != nil {
+		return nil, err
+	}
+	defer sock.Close()
+
+	// Issue the request
+	return ecaP.CheckHealth(ctx, &membersrvc.Empty{}, opts...)
+}
+
+// waitECAHealth probes the health of the ECA until it can serve requests,
+// as many times as configured, and returns ErrECAUnavailable if it never
+// could. ECAs without the health check are taken as serving.
+func (node *nodeImpl) waitECAHealth() error {
+	attempts := node.conf.getECAHealthAttempts()
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(node.conf.getECAHealthInterval())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), node.conf.getECAHealthInterval())
+		health, err := node.callECACheckHealth(ctx)
+		cancel()
+		switch {
+		case grpc.Code(err) == codes.Unimplemented:
+			return nil
+		case err != nil:
+			node.warning("Failed probing the health of the ECA [%s].", err.Error())
+		case health.Status != membersrvc.CAHealth_SERVING:
+			node.warning("The ECA cannot serve requests [%s].", health.Reason)
+		default:
+			return nil
+		}
+	}
+
+	return utils.ErrECAUnavailable
+}
+
+// enrollmentError returns the error of the crypto layer meant by the
+// error err of the ECA to an enrollment request
+func enrollmentError(err error) error {
+	switch grpc.Code(err) {
+	case codes.ResourceExhausted:
+		// the ECA also turns down users enrolling too often
+		if grpc.ErrorDesc(err) == utils.ErrEnrollmentLimitReached.Error() {
+			return utils.ErrEnrollmentLimitReached
+		}
+		return utils.ErrRateLimited
+	case codes.PermissionDenied:
+		return utils.ErrRegistrationExpired
+	}
+
+	return err
+}
+
 func (node *nodeImpl) getEnrollmentCertificateFromECA(id, pw string) (interface{}, []byte, []byte, error) {
+	if node.conf.isEnrollmentCSREnabled() {
+		return node.getEnrollmentCertificateFromECAByCSR(id, pw)
+	}
+
 	// Get a new ECA Client
 	sock, ecaP, err := node.getECAClient()
 	defer sock.Close()
 
 	// Run the protocol
 
-	signPriv, err := primitives.NewECDSAKey()
+	signPriv, signPK, err := node.generateEnrollmentKey()
 	if err != nil {
-		node.error("Failed generating ECDSA key [%s].", err.Error())
+		node.error("Failed generating signing key [%s].", err.Error())
 
 		return nil, nil, nil, err
 	}
-	signPub, err := x509.MarshalPKIXPublicKey(&signPriv.PublicKey)
+	signPub, err := secp256k1.MarshalPKIXPublicKey(signPK)
 	if err != nil {
-		node.error("Failed mashalling ECDSA key [%s].", err.Error())
+		node.error("Failed mashalling signing key [%s].", err.Error())
 
 		return nil, nil, nil, err
 	}
 
-	encPriv, err := primitives.NewECDSAKey()
+	encPriv, err := node.generateECDSAKey()
 	if err != nil {
 		node.error("Failed generating Encryption key [%s].", err.Error())
 
@@ -362,11 +451,16 @@ func (node *nodeImpl) getEnrollmentCertificateFromECA(id, pw string) (interface{
 		return nil, nil, nil, err
 	}
 
+	signType := membersrvc.CryptoType_ECDSA
+	if node.conf.getSignatureScheme() == SignatureSchemeEd25519 {
+		signType = membersrvc.CryptoType_ED25519
+	}
+
 	req := &membersrvc.ECertCreateReq{
 		Ts:   &protobuf.Timestamp{Seconds: time.Now().Unix(), Nanos: 0},
 		Id:   &membersrvc.Identity{Id: id},
 		Tok:  &membersrvc.Token{Tok: []byte(pw)},
-		Sign: &membersrvc.PublicKey{Type: membersrvc.CryptoType_ECDSA, Key: signPub},
+		Sign: &membersrvc.PublicKey{Type: signType, Key: signPub},
 		Enc:  &membersrvc.PublicKey{Type: membersrvc.CryptoType_ECDSA, Key: encPub},
 		Sig:  nil}
 
@@ -374,7 +468,7 @@ func (node *nodeImpl) getEnrollmentCertificateFromECA(id, pw string) (interface{
 	if err != nil {
 		node.error("Failed invoking CreateCertficatePair [%s].", err.Error())
 
-		return nil, nil, nil, err
+		return nil, nil, nil, enrollmentError(err)
 	}
 
 	//out, err := rsa.DecryptPKCS1v15(rand.Reader, encPriv, resp.Tok.Tok)
@@ -403,78 +497,101 @@ func (node *nodeImpl) getEnrollmentCertificateFromECA(id, pw string) (interface{
 	req.Tok.Tok = out
 	req.Sig = nil
 
-	hash := primitives.NewHash()
 	raw, _ := proto.Marshal(req)
-	hash.Write(raw)
 
-	r, s, err := ecdsa.Sign(rand.Reader, signPriv, hash.Sum(nil))
+	req.Sig, err = node.signECARequest(signPriv, raw)
 	if err != nil {
 		node.error("Failed signing [%s].", err.Error())
 
 		return nil, nil, nil, err
 	}
-	R, _ := r.MarshalText()
-	S, _ := s.MarshalText()
-	req.Sig = &membersrvc.Signature{Type: membersrvc.CryptoType_ECDSA, R: R, S: S}
 
 	resp, err = ecaP.CreateCertificatePair(context.Background(), req)
 	if err != nil {
 		node.error("Failed invoking CreateCertificatePair [%s].", err.Error())
 
+		return nil, nil, nil, enrollmentError(err)
+	}
+
+	if err := node.verifyEnrollmentCertificates(resp.Certs, signPriv, encPriv); err != nil {
 		return nil, nil, nil, err
 	}
 
-	// Verify response
+	return signPriv, resp.Certs.Sign, resp.Pkchain, nil
+}
 
+// verifyEnrollmentCertificates checks that the enrollment certificates
+// issued by the ECA certify the passed keys
+func (node *nodeImpl) verifyEnrollmentCertificates(certs *membersrvc.CertPair, signPriv, encPriv interface{}) error {
 	// Verify cert for signing
-	node.debug("Enrollment certificate for signing [% x]", primitives.Hash(resp.Certs.Sign))
+	node.debug("Enrollment certificate for signing [% x]", primitives.Hash(certs.Sign))
 
-	x509SignCert, err := utils.DERToX509Certificate(resp.Certs.Sign)
+	x509SignCert, err := utils.DERToX509Certificate(certs.Sign)
 	if err != nil {
 		node.error("Failed parsing signing enrollment certificate for signing: [%s]", err)
 
-		return nil, nil, nil, err
+		return err
 	}
 
 	_, err = utils.GetCriticalExtension(x509SignCert, ECertSubjectRole)
 	if err != nil {
 		node.error("Failed parsing ECertSubjectRole in enrollment certificate for signing: [%s]", err)
 
-		return nil, nil, nil, err
+		return err
 	}
 
-	err = utils.CheckCertAgainstSKAndRoot(x509SignCert, signPriv, node.ecaCertPool)
+	err = node.verifyCertificateAndKey(x509SignCert, signPriv, node.ecaCertPool)
 	if err != nil {
 		node.error("Failed checking signing enrollment certificate for signing: [%s]", err)
 
-		return nil, nil, nil, err
+		return err
 	}
 
 	// Verify cert for encrypting
-	node.debug("Enrollment certificate for encrypting [% x]", primitives.Hash(resp.Certs.Enc))
+	node.debug("Enrollment certificate for encrypting [% x]", primitives.Hash(certs.Enc))
 
-	x509EncCert, err := utils.DERToX509Certificate(resp.Certs.Enc)
+	x509EncCert, err := utils.DERToX509Certificate(certs.Enc)
 	if err != nil {
 		node.error("Failed parsing signing enrollment certificate for encrypting: [%s]", err)
 
-		return nil, nil, nil, err
+		return err
 	}
 
 	_, err = utils.GetCriticalExtension(x509EncCert, ECertSubjectRole)
 	if err != nil {
 		node.error("Failed parsing ECertSubjectRole in enrollment certificate for encrypting: [%s]", err)
 
-		return nil, nil, nil, err
+		return err
 	}
 
-	err = utils.CheckCertAgainstSKAndRoot(x509EncCert, encPriv, node.ecaCertPool)
+	err = node.verifyCertificateAndKey(x509EncCert, encPriv, node.ecaCertPool)
 	if err != nil {
 		node.error("Failed checking signing enrollment certificate for encrypting: [%s]", err)
 
-		return nil, nil, nil, err
+		return err
 	}
 
-	return signPriv, resp.Certs.Sign, resp.Pkchain, nil
+	return nil
+}
+
+// signECARequest signs the marshalled ECA request raw with the enrollment key key
+func (node *nodeImpl) signECARequest(key interface{}, raw []byte) (*membersrvc.Signature, error) {
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		// R and S are the two halves of the signature
+		sig := ed25519.Sign(key, raw)
+		return &membersrvc.Signature{Type: membersrvc.CryptoType_ED25519, R: sig[:32], S: sig[32:]}, nil
+	case *ecdsa.PrivateKey:
+		r, s, err := primitives.ECDSASignDirect(key, raw)
+		if err != nil {
+			return nil, err
+		}
+		R, _ := r.MarshalText()
+		S, _ := s.MarshalText()
+		return &membersrvc.Signature{Type: membersrvc.CryptoType_ECDSA, R: R, S: S}, nil
+	}
+
+	return nil, utils.ErrInvalidKey
 }
 
 func (node *nodeImpl) getECACertificate() ([]byte, error) {
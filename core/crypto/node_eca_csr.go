@@ -0,0 +1,124 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	protobuf "google/protobuf"
+	"time"
+
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
+	"golang.org/x/net/context"
+)
+
+// getEnrollmentCertificateFromECAByCSR enrolls the node by sending the ECA
+// PKCS#10 requests for the keys it generates. Signing the requests proves
+// possession of the keys, so that the ECA sends no challenge.
+func (node *nodeImpl) getEnrollmentCertificateFromECAByCSR(id, pw string) (interface{}, []byte, []byte, error) {
+	// Get a new ECA Client
+	sock, ecaP, err := node.getECAClient()
+	if err != nil {
+		node.error("Failed getting ECA client [%s].", err.Error())
+
+		return nil, nil, nil, err
+	}
+	defer sock.Close()
+
+	signPriv, err := node.generateEnrollmentSigner()
+	if err != nil {
+		node.error("Failed generating signing key [%s].", err.Error())
+
+		return nil, nil, nil, err
+	}
+	signCSR, err := newCertificateRequest(id, signPriv)
+	if err != nil {
+		node.error("Failed creating certificate request for signing key [%s].", err.Error())
+
+		return nil, nil, nil, err
+	}
+
+	encPriv, err := node.generateECDSAKey()
+	if err != nil {
+		node.error("Failed generating Encryption key [%s].", err.Error())
+
+		return nil, nil, nil, err
+	}
+	encCSR, err := newCertificateRequest(id, encPriv)
+	if err != nil {
+		node.error("Failed creating certificate request for Encryption key [%s].", err.Error())
+
+		return nil, nil, nil, err
+	}
+
+	req := &membersrvc.ECertCSRReq{
+		Ts:   &protobuf.Timestamp{Seconds: time.Now().Unix(), Nanos: 0},
+		Id:   &membersrvc.Identity{Id: id},
+		Tok:  &membersrvc.Token{Tok: []byte(pw)},
+		Sign: signCSR,
+		Enc:  encCSR}
+
+	resp, err := ecaP.CreateCertificatePairFromCSR(context.Background(), req)
+	if err != nil {
+		node.error("Failed invoking CreateCertificatePairFromCSR [%s].", err.Error())
+
+		return nil, nil, nil, enrollmentError(err)
+	}
+
+	if err := node.verifyEnrollmentCertificates(resp.Certs, signPriv, encPriv); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return signPriv, resp.Certs.Sign, resp.Pkchain, nil
+}
+
+// generateEnrollmentSigner generates the enrollment key, on the HSM if it
+// holds the enrollment key and can generate keys. The keys generated there
+// cannot be exported, so the enrollment key of a client is generated in
+// software and imported as extractable.
+func (node *nodeImpl) generateEnrollmentSigner() (crypto.Signer, error) {
+	if node.conf.isKeyInHSM(HSMKeyEnrollment) && !node.isEnrollmentKeyExtractable() {
+		if generator, ok := node.hsm.(HSMKeyGenerator); ok {
+			node.debug("Generating enrollment key on HSM...")
+
+			label := node.hsmLabel(node.conf.getEnrollmentKeyFilename())
+			pub, err := generator.GenerateKey(label)
+			if err != nil {
+				return nil, err
+			}
+
+			return &hsmPrivateKey{node.hsm, label, pub}, nil
+		}
+	}
+
+	key, _, err := node.generateEnrollmentKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return key.(crypto.Signer), nil
+}
+
+// newCertificateRequest returns the DER encoded PKCS#10 request for the
+// key of signer, on behalf of id
+func newCertificateRequest(id string, signer crypto.Signer) ([]byte, error) {
+	template := &x509.CertificateRequest{Subject: pkix.Name{CommonName: id}}
+
+	return x509.CreateCertificateRequest(rand.Reader, template, signer)
+}
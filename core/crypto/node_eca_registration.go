@@ -0,0 +1,74 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"github.com/golang/protobuf/proto"
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+func (node *nodeImpl) getECAAClient() (*grpc.ClientConn, membersrvc.ECAAClient, error) {
+	node.debug("Getting ECAA client...")
+
+	conn, err := node.getClientConn(node.conf.getECAPAddr(), node.conf.getECAServerName())
+	if err != nil {
+		node.error("Failed getting client connection: [%s]", err)
+
+		return nil, nil, err
+	}
+
+	client := membersrvc.NewECAAClient(conn)
+
+	node.debug("Getting ECAA client...done")
+
+	return conn, client, nil
+}
+
+// registerUsers registers users with the ECA on behalf of the node, over
+// a request signed with its enrollment key. The ECA registers each user on
+// its own and tells, in the order of users, which were registered.
+func (node *nodeImpl) registerUsers(users []*membersrvc.RegisterUserReq) ([]*membersrvc.RegisterUserResult, error) {
+	sock, ecaA, err := node.getECAAClient()
+	if err != nil {
+		return nil, err
+	}
+	defer sock.Close()
+
+	req := &membersrvc.RegisterUserSetReq{
+		Id:    &membersrvc.Identity{Id: node.enrollID},
+		Users: users,
+	}
+
+	raw, _ := proto.Marshal(req)
+	req.Sig, err = node.signECARequest(node.enrollSignKey, raw)
+	if err != nil {
+		node.error("Failed signing registration request [%s].", err.Error())
+
+		return nil, err
+	}
+
+	resp, err := ecaA.RegisterUserSet(context.Background(), req)
+	if err != nil {
+		node.error("Failed invoking RegisterUserSet [%s].", err.Error())
+
+		return nil, err
+	}
+
+	return resp.Results, nil
+}
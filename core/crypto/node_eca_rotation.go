@@ -0,0 +1,202 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/x509"
+	protobuf "google/protobuf"
+	"os"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/crypto/primitives/secp256k1"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
+	"golang.org/x/net/context"
+)
+
+// rotateEnrollmentKey replaces the enrollment key pair of the node.
+// A new key pair is certified by the ECA over a request signed with the
+// current enrollment key and carrying a proof of possession of the new one.
+// The keystore then switches to the new key and certificate at once.
+func (node *nodeImpl) rotateEnrollmentKey() error {
+	if node.conf.isKeyInHSM(HSMKeyEnrollment) {
+		node.error("Cannot rotate an enrollment key held by an HSM.")
+
+		return utils.ErrInvalidKey
+	}
+
+	key, certRaw, err := node.getRotatedEnrollmentCertificateFromECA()
+	if err != nil {
+		node.error("Failed rotating enrollment key: [%s]", err)
+
+		return err
+	}
+
+	if err := node.switchEnrollmentKey(key, certRaw); err != nil {
+		node.error("Failed switching enrollment key: [%s]", err)
+
+		return err
+	}
+
+	// Reload the enrollment key and certificate
+	if err := node.loadEnrollmentKey(); err != nil {
+		return err
+	}
+
+	return node.loadEnrollmentCertificate()
+}
+
+func (node *nodeImpl) getRotatedEnrollmentCertificateFromECA() (interface{}, []byte, error) {
+	// Get a new ECA Client
+	sock, ecaP, err := node.getECAClient()
+	defer sock.Close()
+
+	signPriv, signPK, err := node.generateEnrollmentKey()
+	if err != nil {
+		node.error("Failed generating signing key [%s].", err.Error())
+
+		return nil, nil, err
+	}
+	signPub, err := secp256k1.MarshalPKIXPublicKey(signPK)
+	if err != nil {
+		node.error("Failed mashalling signing key [%s].", err.Error())
+
+		return nil, nil, err
+	}
+
+	encPriv, err := node.generateECDSAKey()
+	if err != nil {
+		node.error("Failed generating Encryption key [%s].", err.Error())
+
+		return nil, nil, err
+	}
+	encPub, err := x509.MarshalPKIXPublicKey(&encPriv.PublicKey)
+	if err != nil {
+		node.error("Failed marshalling Encryption key [%s].", err.Error())
+
+		return nil, nil, err
+	}
+
+	signType := membersrvc.CryptoType_ECDSA
+	if node.conf.getSignatureScheme() == SignatureSchemeEd25519 {
+		signType = membersrvc.CryptoType_ED25519
+	}
+
+	req := &membersrvc.ECertRotateReq{
+		Ts:   &protobuf.Timestamp{Seconds: time.Now().Unix(), Nanos: 0},
+		Id:   &membersrvc.Identity{Id: node.enrollID},
+		Cert: node.enrollCert.Raw,
+		Sign: &membersrvc.PublicKey{Type: signType, Key: signPub},
+		Enc:  &membersrvc.PublicKey{Type: membersrvc.CryptoType_ECDSA, Key: encPub},
+	}
+
+	// Prove possession of the new key
+	raw, _ := proto.Marshal(req)
+	req.Pop, err = node.signECARequest(signPriv, raw)
+	if err != nil {
+		node.error("Failed signing with the new key [%s].", err.Error())
+
+		return nil, nil, err
+	}
+
+	// Authorize the rotation with the current key
+	raw, _ = proto.Marshal(req)
+	req.Sig, err = node.signECARequest(node.enrollSignKey, raw)
+	if err != nil {
+		node.error("Failed signing with the current key [%s].", err.Error())
+
+		return nil, nil, err
+	}
+
+	resp, err := ecaP.RotateCertificatePair(context.Background(), req)
+	if err != nil {
+		node.error("Failed invoking RotateCertificatePair [%s].", err.Error())
+
+		return nil, nil, err
+	}
+
+	// Verify cert for signing
+	x509SignCert, err := utils.DERToX509Certificate(resp.Certs.Sign)
+	if err != nil {
+		node.error("Failed parsing signing enrollment certificate for signing: [%s]", err)
+
+		return nil, nil, err
+	}
+
+	_, err = utils.GetCriticalExtension(x509SignCert, ECertSubjectRole)
+	if err != nil {
+		node.error("Failed parsing ECertSubjectRole in enrollment certificate for signing: [%s]", err)
+
+		return nil, nil, err
+	}
+
+	err = node.verifyCertificateAndKey(x509SignCert, signPriv, node.ecaCertPool)
+	if err != nil {
+		node.error("Failed checking signing enrollment certificate for signing: [%s]", err)
+
+		return nil, nil, err
+	}
+
+	return signPriv, resp.Certs.Sign, nil
+}
+
+// switchEnrollmentKey replaces the enrollment key and certificate in the
+// keystore. Both are first stored aside; moving the certificate aside in
+// place commits the switch, so that an interrupted one is completed by
+// completeEnrollmentKeyRotation the next time the node starts.
+func (node *nodeImpl) switchEnrollmentKey(key interface{}, certRaw []byte) error {
+	keyAlias := node.conf.getEnrollmentKeyFilename() + ".new"
+	certAlias := node.conf.getEnrollmentCertFilename() + ".new"
+
+	if err := node.ks.storePrivateKey(keyAlias, key); err != nil {
+		return err
+	}
+	if err := node.ks.storeCert(certAlias+".tmp", certRaw); err != nil {
+		return err
+	}
+	if err := os.Rename(node.conf.getPathForAlias(certAlias+".tmp"), node.conf.getPathForAlias(certAlias)); err != nil {
+		return err
+	}
+
+	return node.completeEnrollmentKeyRotation()
+}
+
+// completeEnrollmentKeyRotation moves in place the enrollment key and
+// certificate stored aside by a committed rotation, and drops those of a
+// rotation that was interrupted before committing.
+func (node *nodeImpl) completeEnrollmentKeyRotation() error {
+	keyPath := node.conf.getPathForAlias(node.conf.getEnrollmentKeyFilename())
+	certPath := node.conf.getPathForAlias(node.conf.getEnrollmentCertFilename())
+
+	if missing, _ := utils.FilePathMissing(certPath + ".new"); missing {
+		os.Remove(keyPath + ".new")
+		os.Remove(certPath + ".new.tmp")
+
+		return nil
+	}
+
+	node.debug("Completing enrollment key rotation...")
+
+	if missing, _ := utils.FilePathMissing(keyPath + ".new"); !missing {
+		if err := os.Rename(keyPath+".new", keyPath); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(certPath+".new", certPath)
+}
@@ -73,6 +73,9 @@ func (node *nodeImpl) getClientConn(address string, serverName string) (*grpc.Cl
 		}
 
 		creds := credentials.NewTLS(&config)
+		if node.conf.isOCSPEnabled() {
+			creds = &ocspCredentials{creds, node}
+		}
 		opts = append(opts, grpc.WithTransportCredentials(creds))
 		opts = append(opts, grpc.WithTimeout(time.Second*3))
 
@@ -0,0 +1,266 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+const (
+	// HSMKeyEnrollment is the class of the enrollment key
+	HSMKeyEnrollment = "enrollment"
+
+	// HSMKeyTLS is the class of the TLS key
+	HSMKeyTLS = "tls"
+
+	// HSMKeyTCert is the class of the TCert keys
+	HSMKeyTCert = "tcert"
+)
+
+// HSM is a hardware security module, typically accessed via PKCS#11,
+// holding private keys that never leave the device
+type HSM interface {
+	// ImportKey stores key on the device under label. If persistent is
+	// false, the key is discarded when the HSM is closed.
+	ImportKey(label string, key *ecdsa.PrivateKey, persistent bool) error
+
+	// FindKey returns the public part of the key stored under label
+	FindKey(label string) (*ecdsa.PublicKey, error)
+
+	// Sign signs digest on the device with the key stored under label.
+	// The signature is ASN.1 encoded, as for ecdsa keys.
+	Sign(label string, digest []byte) ([]byte, error)
+
+	// Close releases the session with the device
+	Close() error
+}
+
+// HSMKeyGenerator is implemented by the HSMs able to generate keys on the
+// device. Enrollment keys requested by certificate requests are then
+// generated there, instead of being generated in software and imported.
+type HSMKeyGenerator interface {
+	// GenerateKey generates a persistent key under label, on the curve
+	// of the security level, and returns its public part
+	GenerateKey(label string) (*ecdsa.PublicKey, error)
+}
+
+// HSMKeyExporter is implemented by the HSMs able to hand out, wrapped, the
+// keys imported as extractable. The enrollment key of a client can only be
+// kept on such a device: signing with it happens there, but the TCert keys
+// are derived from it in software, which PKCS#11 mechanisms cannot do.
+type HSMKeyExporter interface {
+	// ImportExtractableKey stores key on the device under label, as a
+	// persistent key that ExportKey can return
+	ImportExtractableKey(label string, key *ecdsa.PrivateKey) error
+
+	// ExportKey returns the key stored under label by ImportExtractableKey
+	ExportKey(label string) (*ecdsa.PrivateKey, error)
+}
+
+// HSMProvider opens a session with an HSM on behalf of the node named name.
+// The provider reads the settings it needs, such as the PKCS#11 library,
+// the slot and the PIN, from the properties under security.hsm.
+type HSMProvider func(name string) (HSM, error)
+
+var (
+	hsmProviders     = make(map[string]HSMProvider)
+	hsmProvidersLock sync.RWMutex
+)
+
+// RegisterHSMProvider registers provider under name. Nodes select the
+// provider to use by setting the property security.hsm.provider and the
+// classes of keys it holds by setting security.hsm.keys.
+func RegisterHSMProvider(name string, provider HSMProvider) error {
+	if name == "" || provider == nil {
+		return fmt.Errorf("Invalid HSM provider [%s]", name)
+	}
+
+	hsmProvidersLock.Lock()
+	defer hsmProvidersLock.Unlock()
+
+	if _, ok := hsmProviders[name]; ok {
+		return fmt.Errorf("HSM provider [%s] already registered", name)
+	}
+	hsmProviders[name] = provider
+
+	return nil
+}
+
+// GetHSMProviders returns the names of the registered HSM providers
+func GetHSMProviders() []string {
+	hsmProvidersLock.RLock()
+	defer hsmProvidersLock.RUnlock()
+
+	names := make([]string, 0, len(hsmProviders))
+	for name := range hsmProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func newHSM(provider, name string) (HSM, error) {
+	hsmProvidersLock.RLock()
+	newProvider, ok := hsmProviders[provider]
+	hsmProvidersLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("HSM provider [%s] not registered", provider)
+	}
+
+	return newProvider(name)
+}
+
+// OpenHSM opens a session with an HSM through the provider registered under
+// provider, on behalf of name. Components other than nodes, such as the CAs
+// of the membership service, keep their keys on an HSM this way.
+func OpenHSM(provider, name string) (HSM, error) {
+	return newHSM(provider, name)
+}
+
+// hsmPrivateKey is a private key held by an HSM. It signs on the device.
+type hsmPrivateKey struct {
+	hsm   HSM
+	label string
+	pub   *ecdsa.PublicKey
+}
+
+func (key *hsmPrivateKey) Public() crypto.PublicKey {
+	return key.pub
+}
+
+func (key *hsmPrivateKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return key.hsm.Sign(key.label, digest)
+}
+
+// initHSM opens the session with the HSM, if the node keeps keys in one
+func (node *nodeImpl) initHSM() (err error) {
+	if node.conf.getHSMProvider() == "" {
+		return
+	}
+
+	node.debug("Using HSM provider [%s]", node.conf.getHSMProvider())
+
+	if node.hsm, err = newHSM(node.conf.getHSMProvider(), node.conf.name); err != nil {
+		node.error("Failed opening HSM [%s]", err)
+
+		return
+	}
+
+	// The TCert keys of a client are derived from its enrollment key
+	if node.isEnrollmentKeyExtractable() {
+		if _, ok := node.hsm.(HSMKeyExporter); !ok {
+			node.error("The enrollment key of a client can only be kept in an HSM able to export it, TCert keys derive from it.")
+			node.hsm.Close()
+			node.hsm = nil
+
+			return utils.ErrInvalidKey
+		}
+	}
+
+	return
+}
+
+// isEnrollmentKeyExtractable returns true if the enrollment key of the node
+// is kept on the HSM and must be exportable, which is the case of clients
+func (node *nodeImpl) isEnrollmentKeyExtractable() bool {
+	return node.eType == NodeClient && node.conf.isKeyInHSM(HSMKeyEnrollment)
+}
+
+// hsmLabel returns the label of the key stored under alias on the HSM
+func (node *nodeImpl) hsmLabel(alias string) string {
+	return node.conf.name + "." + alias
+}
+
+// storePrivateKeyOfClass stores key under alias, on the HSM if
+// the node keeps keys of the passed class in one
+func (node *nodeImpl) storePrivateKeyOfClass(class, alias string, key interface{}) error {
+	if !node.conf.isKeyInHSM(class) {
+		if class == HSMKeyTLS {
+			return node.ks.storePrivateKeyInClear(alias, key)
+		}
+		return node.ks.storePrivateKey(alias, key)
+	}
+
+	// A key generated on the device is already stored under alias
+	if key, ok := key.(*hsmPrivateKey); ok && key.label == node.hsmLabel(alias) {
+		return nil
+	}
+
+	ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return utils.ErrInvalidKey
+	}
+
+	if class == HSMKeyEnrollment && node.isEnrollmentKeyExtractable() {
+		return node.hsm.(HSMKeyExporter).ImportExtractableKey(node.hsmLabel(alias), ecdsaKey)
+	}
+
+	return node.hsm.ImportKey(node.hsmLabel(alias), ecdsaKey, true)
+}
+
+// exportPrivateKeyOfClass returns the key stored under alias on the HSM
+// by storePrivateKeyOfClass as extractable
+func (node *nodeImpl) exportPrivateKeyOfClass(class, alias string) (*ecdsa.PrivateKey, error) {
+	exporter, ok := node.hsm.(HSMKeyExporter)
+	if !ok || !node.conf.isKeyInHSM(class) {
+		return nil, utils.ErrInvalidKey
+	}
+
+	return exporter.ExportKey(node.hsmLabel(alias))
+}
+
+// loadPrivateKeyOfClass loads the key stored under alias, returning
+// a handle to it if the node keeps keys of the passed class on the HSM
+func (node *nodeImpl) loadPrivateKeyOfClass(class, alias string) (interface{}, error) {
+	if !node.conf.isKeyInHSM(class) {
+		return node.ks.loadPrivateKey(alias)
+	}
+
+	label := node.hsmLabel(alias)
+	pub, err := node.hsm.FindKey(label)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hsmPrivateKey{node.hsm, label, pub}, nil
+}
+
+// protectTCertKey moves the signing key of the TCert with the passed
+// serial number to the HSM, if the client keeps TCert keys in one.
+// The key lives on the device until the HSM is closed.
+func (client *clientImpl) protectTCertKey(serialNumber *big.Int, key *ecdsa.PrivateKey) (interface{}, error) {
+	if !client.conf.isKeyInHSM(HSMKeyTCert) {
+		return key, nil
+	}
+
+	label := client.hsmLabel("tcert." + serialNumber.String())
+	if err := client.hsm.ImportKey(label, key, false); err != nil {
+		return nil, err
+	}
+
+	return &hsmPrivateKey{client.hsm, label, &key.PublicKey}, nil
+}
@@ -0,0 +1,43 @@
+// +build cgo
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"github.com/hyperledger/fabric/core/crypto/hsm"
+	"github.com/spf13/viper"
+)
+
+// HSMProviderPKCS11 is the name of the HSM provider accessing the device
+// through its PKCS#11 module
+const HSMProviderPKCS11 = "pkcs11"
+
+func init() {
+	RegisterHSMProvider(HSMProviderPKCS11, func(name string) (HSM, error) {
+		token, err := hsm.OpenPKCS11(&hsm.PKCS11Config{
+			Library: viper.GetString("security.hsm.library"),
+			Label:   viper.GetString("security.hsm.label"),
+			Slot:    uint(viper.GetInt("security.hsm.slot")),
+			Pin:     viper.GetString("security.hsm.pin"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return token, nil
+	})
+}
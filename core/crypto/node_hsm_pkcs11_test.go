@@ -0,0 +1,170 @@
+// +build cgo
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/miekg/pkcs11"
+	"github.com/spf13/viper"
+)
+
+const (
+	testSoftHSMLabel = "fabric"
+	testSoftHSMPin   = "98765432"
+)
+
+// softHSMLibrary returns the path of the SoftHSM module, set by
+// SOFTHSM2_LIB or found where the distributions install it
+func softHSMLibrary() string {
+	if lib := os.Getenv("SOFTHSM2_LIB"); lib != "" {
+		return lib
+	}
+	for _, lib := range []string{
+		"/usr/lib/softhsm/libsofthsm2.so",
+		"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+		"/usr/local/lib/softhsm/libsofthsm2.so",
+		"/usr/lib64/pkcs11/libsofthsm2.so",
+	} {
+		if _, err := os.Stat(lib); err == nil {
+			return lib
+		}
+	}
+
+	return ""
+}
+
+// initSoftHSM creates a SoftHSM token in dir, labelled testSoftHSMLabel
+func initSoftHSM(t *testing.T, lib, dir string) {
+	conf := filepath.Join(dir, "softhsm2.conf")
+	tokens := filepath.Join(dir, "tokens")
+	if err := os.Mkdir(tokens, 0700); err != nil {
+		t.Fatalf("Failed creating token directory [%s]", err)
+	}
+	if err := ioutil.WriteFile(conf, []byte(fmt.Sprintf("directories.tokendir = %s\nobjectstore.backend = file\n", tokens)), 0600); err != nil {
+		t.Fatalf("Failed writing SoftHSM configuration [%s]", err)
+	}
+	os.Setenv("SOFTHSM2_CONF", conf)
+
+	ctx := pkcs11.New(lib)
+	if ctx == nil {
+		t.Fatalf("Failed loading [%s]", lib)
+	}
+	defer ctx.Destroy()
+	if err := ctx.Initialize(); err != nil {
+		t.Fatalf("Failed initializing SoftHSM [%s]", err)
+	}
+	defer ctx.Finalize()
+
+	slots, err := ctx.GetSlotList(true)
+	if err != nil || len(slots) == 0 {
+		t.Fatalf("Failed listing slots [%s]", err)
+	}
+	if err := ctx.InitToken(slots[0], testSoftHSMPin, testSoftHSMLabel); err != nil {
+		t.Fatalf("Failed initializing token [%s]", err)
+	}
+
+	// The token moves to another slot once initialized
+	slots, err = ctx.GetSlotList(true)
+	if err != nil {
+		t.Fatalf("Failed listing slots [%s]", err)
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil || info.Label != testSoftHSMLabel {
+			continue
+		}
+		session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+		if err != nil {
+			t.Fatalf("Failed opening session [%s]", err)
+		}
+		defer ctx.CloseSession(session)
+		if err := ctx.Login(session, pkcs11.CKU_SO, testSoftHSMPin); err != nil {
+			t.Fatalf("Failed logging in [%s]", err)
+		}
+		if err := ctx.InitPIN(session, testSoftHSMPin); err != nil {
+			t.Fatalf("Failed setting PIN [%s]", err)
+		}
+		ctx.Logout(session)
+
+		return
+	}
+	t.Fatal("Initialized token not found")
+}
+
+func TestPKCS11HSM(t *testing.T) {
+	lib := softHSMLibrary()
+	if lib == "" {
+		t.Skip("SoftHSM not installed")
+	}
+	dir, err := ioutil.TempDir("", "TestPKCS11HSM")
+	if err != nil {
+		t.Fatalf("Failed creating directory [%s]", err)
+	}
+	defer os.RemoveAll(dir)
+	initSoftHSM(t, lib, dir)
+
+	key, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s]", err)
+	}
+
+	// The enrollment key of a client lives on the token, which signs with
+	// it, and is exported to derive the TCert keys
+	viper.Set("security.hsm.library", lib)
+	viper.Set("security.hsm.label", testSoftHSMLabel)
+	viper.Set("security.hsm.pin", testSoftHSMPin)
+	node := &nodeImpl{
+		eType: NodeClient,
+		conf:  &configuration{name: "client", hsmProvider: HSMProviderPKCS11, hsmKeys: map[string]bool{HSMKeyEnrollment: true}},
+	}
+	if err := node.initHSM(); err != nil {
+		t.Fatalf("Failed opening HSM [%s]", err)
+	}
+	defer node.hsm.Close()
+	if _, ok := node.hsm.(HSMKeyGenerator); !ok {
+		t.Fatal("The PKCS#11 HSM must generate keys")
+	}
+	if _, ok := node.hsm.(HSMKeyExporter); !ok {
+		t.Fatal("The PKCS#11 HSM must export the extractable keys")
+	}
+	if err := node.storePrivateKeyOfClass(HSMKeyEnrollment, node.conf.getEnrollmentKeyFilename(), key); err != nil {
+		t.Fatalf("Failed storing enrollment key [%s]", err)
+	}
+	if err := node.loadEnrollmentKey(); err != nil {
+		t.Fatalf("Failed loading enrollment key [%s]", err)
+	}
+	if _, ok := node.enrollSignKey.(*hsmPrivateKey); !ok {
+		t.Fatal("Enrollment key must sign on the HSM")
+	}
+	if node.enrollPrivKey == nil || node.enrollPrivKey.D.Cmp(key.D) != 0 {
+		t.Fatal("Enrollment key must be exported")
+	}
+	msg := []byte("Hello World")
+	r, s, err := node.ecdsaSignWithEnrollmentKey(msg)
+	if err != nil || !ecdsa.Verify(&key.PublicKey, primitives.Hash(msg), r, s) {
+		t.Fatalf("Failed verifying ECDSA signature made on the HSM [%s]", err)
+	}
+}
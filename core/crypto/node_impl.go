@@ -34,6 +34,11 @@ type nodeImpl struct {
 	// Configuration
 	conf *configuration
 
+	// confSource and logger, when set, replace the global viper
+	// configuration and the crypto logger
+	confSource configSource
+	logger     Logger
+
 	// keyStore
 	ks *keyStore
 
@@ -43,6 +48,10 @@ type nodeImpl struct {
 	ecaCertPool   *x509.CertPool
 	tcaCertPool   *x509.CertPool
 
+	// intermediatesCertPool holds the intermediate CAs chaining
+	// certificates to the pools above
+	intermediatesCertPool *x509.CertPool
+
 	// 48-bytes identifier
 	id []byte
 
@@ -52,14 +61,36 @@ type nodeImpl struct {
 	enrollPrivKey  *ecdsa.PrivateKey
 	enrollCertHash []byte
 
+	// enrollSignKey signs with the enrollment key, wherever it is kept
+	enrollSignKey interface{}
+
 	// Enrollment Chain
 	enrollChainKey interface{}
 
 	// TLS
 	tlsCert *x509.Certificate
 
-	// Crypto SPI
+	// Crypto service provider and the SPI it provides
+	csp      CSP
 	eciesSPI primitives.AsymmetricCipherSPI
+
+	// hsm holds the keys of the classes configured under security.hsm.keys
+	hsm HSM
+
+	// secrets is the store the node reads the secrets configured under
+	// security.secrets.paths from. The leases on them are kept renewed.
+	secrets                            SecretStore
+	secretLeases                       []*secretLease
+	secretsRenewal, secretsRenewalDone chan struct{}
+
+	// crls caches the CRLs published by the ECA and the TCA
+	crls crlCache
+
+	// ocsp caches the certificate statuses reported by the OCSP responder
+	ocsp ocspCache
+
+	// certs caches the transaction certificates whose chain has been verified
+	certs certCache
 }
 
 func (node *nodeImpl) GetType() NodeType {
@@ -98,10 +129,29 @@ func (node *nodeImpl) register(eType NodeType, name string, pwd []byte, enrollID
 
 	node.debug("Registering node [%s]...", enrollID)
 
+	// Read the secrets not passed from the secret store
+	if err := node.initSecretStore(); err != nil {
+		return err
+	}
+	if pwd == nil {
+		secret, err := node.readSecret(SecretKeyStorePassphrase)
+		if err != nil {
+			return err
+		}
+		pwd = secret
+	}
+	if enrollPWD == "" {
+		secret, err := node.readSecret(SecretEnrollPassword)
+		if err != nil {
+			return err
+		}
+		enrollPWD = string(secret)
+	}
+
 	// Initialize keystore
 	err := node.initKeyStore(pwd)
 	if err != nil {
-		if err != utils.ErrKeyStoreAlreadyInitialized {
+		if err == utils.ErrKeyStoreAlreadyInitialized {
 			node.error("Keystore already initialized.")
 		} else {
 			node.error("Failed initiliazing keystore [%s].", err.Error())
@@ -143,11 +193,23 @@ func (node *nodeImpl) init(eType NodeType, name string, pwd []byte) error {
 		return utils.ErrRegistrationRequired
 	}
 
+	// Read the keystore passphrase from the secret store, if not passed
+	if err := node.initSecretStore(); err != nil {
+		return err
+	}
+	if pwd == nil {
+		secret, err := node.readSecret(SecretKeyStorePassphrase)
+		if err != nil {
+			return err
+		}
+		pwd = secret
+	}
+
 	// Initialize keystore
 	node.debug("Init keystore...")
 	err := node.initKeyStore(pwd)
 	if err != nil {
-		if err != utils.ErrKeyStoreAlreadyInitialized {
+		if err == utils.ErrKeyStoreAlreadyInitialized {
 			node.error("Keystore already initialized.")
 		} else {
 			node.error("Failed initiliazing keystore [%s].", err.Error())
@@ -164,6 +226,9 @@ func (node *nodeImpl) init(eType NodeType, name string, pwd []byte) error {
 		return err
 	}
 
+	// Keep the leased secrets read at initialization valid
+	node.startSecretsRenewal()
+
 	// Initialisation complete
 	node.isInitialized = true
 
@@ -180,5 +245,23 @@ func (node *nodeImpl) close() error {
 		err = node.ks.close()
 	}
 
+	// Close HSM
+	if node.hsm != nil {
+		if hsmErr := node.hsm.Close(); hsmErr != nil {
+			node.error("Failed closing HSM [%s]", hsmErr)
+			if err == nil {
+				err = hsmErr
+			}
+		}
+	}
+
+	// Close secret store
+	if secretsErr := node.closeSecretStore(); secretsErr != nil {
+		node.error("Failed closing secret store [%s]", secretsErr)
+		if err == nil {
+			err = secretsErr
+		}
+	}
+
 	return err
 }
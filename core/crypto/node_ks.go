@@ -18,15 +18,10 @@ package crypto
 
 import (
 	"crypto/x509"
-	"database/sql"
 	"github.com/hyperledger/fabric/core/crypto/utils"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"sync"
-
-	// Required to successfully initialized the driver
-	_ "github.com/mattn/go-sqlite3"
 )
 
 /*
@@ -75,8 +70,12 @@ type keyStore struct {
 
 	pwd []byte
 
-	// backend
-	sqlDB *sql.DB
+	// kek is the key derived from pwd sealing the entries, if
+	// the keystore is protected by a passphrase key
+	kek []byte
+
+	// backend keeps the tables of the keystore
+	backend keyStoreBackend
 
 	// Sync
 	m sync.Mutex
@@ -103,6 +102,13 @@ func (ks *keyStore) init(node *nodeImpl, pwd []byte) error {
 		return err
 	}
 
+	if node.conf.getKeyStoreKDF() == KeyStoreKDFScrypt {
+		if err := ks.unlock(); err != nil {
+			ks.close()
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -116,11 +122,15 @@ func (ks *keyStore) isAliasSet(alias string) bool {
 }
 
 func (ks *keyStore) storePrivateKey(alias string, privateKey interface{}) error {
-	rawKey, err := utils.PrivateKeyToPEM(privateKey, ks.pwd)
+	rawKey, err := utils.PrivateKeyToPEM(privateKey, ks.pemPwd())
 	if err != nil {
 		ks.node.error("Failed converting private key to PEM [%s]: [%s]", alias, err)
 		return err
 	}
+	if rawKey, err = ks.seal(rawKey); err != nil {
+		ks.node.error("Failed sealing private key [%s]: [%s]", alias, err)
+		return err
+	}
 
 	err = ioutil.WriteFile(ks.node.conf.getPathForAlias(alias), rawKey, 0700)
 	if err != nil {
@@ -158,6 +168,12 @@ func (ks *keyStore) loadPrivateKey(alias string) (interface{}, error) {
 		return nil, err
 	}
 
+	if raw, err = ks.unseal(raw); err != nil {
+		ks.node.error("Failed unsealing private key [%s]: [%s].", alias, err.Error())
+
+		return nil, err
+	}
+
 	privateKey, err := utils.PEMtoPrivateKey(raw, ks.pwd)
 	if err != nil {
 		ks.node.error("Failed parsing private key [%s]: [%s].", alias, err.Error())
@@ -169,7 +185,7 @@ func (ks *keyStore) loadPrivateKey(alias string) (interface{}, error) {
 }
 
 func (ks *keyStore) storePublicKey(alias string, publicKey interface{}) error {
-	rawKey, err := utils.PublicKeyToPEM(publicKey, ks.pwd)
+	rawKey, err := utils.PublicKeyToPEM(publicKey, ks.pemPwd())
 	if err != nil {
 		ks.node.error("Failed converting public key to PEM [%s]: [%s]", alias, err)
 		return err
@@ -206,7 +222,13 @@ func (ks *keyStore) loadPublicKey(alias string) (interface{}, error) {
 }
 
 func (ks *keyStore) storeKey(alias string, key []byte) error {
-	pem, err := utils.AEStoEncryptedPEM(key, ks.pwd)
+	var pem []byte
+	var err error
+	if ks.kek != nil {
+		pem, err = ks.seal(utils.AEStoPEM(key))
+	} else {
+		pem, err = utils.AEStoEncryptedPEM(key, ks.pwd)
+	}
 	if err != nil {
 		ks.node.error("Failed converting key to PEM [%s]: [%s]", alias, err)
 		return err
@@ -232,6 +254,12 @@ func (ks *keyStore) loadKey(alias string) ([]byte, error) {
 		return nil, err
 	}
 
+	if pem, err = ks.unseal(pem); err != nil {
+		ks.node.error("Failed unsealing key [%s]: [%s]", alias, err)
+
+		return nil, err
+	}
+
 	key, err := utils.PEMtoAES(pem, ks.pwd)
 	if err != nil {
 		ks.node.error("Failed parsing key [%s]: [%s]", alias, err)
@@ -252,6 +280,16 @@ func (ks *keyStore) storeCert(alias string, der []byte) error {
 	return nil
 }
 
+func (ks *keyStore) storeCertsChain(alias string, ders [][]byte) error {
+	err := ioutil.WriteFile(ks.node.conf.getPathForAlias(alias), utils.DERCertsToPEM(ders), 0700)
+	if err != nil {
+		ks.node.error("Failed storing certificates chain [%s]: [%s]", alias, err)
+		return err
+	}
+
+	return nil
+}
+
 func (ks *keyStore) loadCert(alias string) ([]byte, error) {
 	path := ks.node.conf.getPathForAlias(alias)
 	ks.node.debug("Loading certificate [%s] at [%s]...", alias, path)
@@ -302,7 +340,7 @@ func (ks *keyStore) loadCertX509AndDer(alias string) (*x509.Certificate, []byte,
 
 func (ks *keyStore) close() error {
 	ks.node.debug("Closing keystore...")
-	err := ks.sqlDB.Close()
+	err := ks.backend.close()
 
 	if err != nil {
 		ks.node.error("Failed closing keystore [%s].", err.Error())
@@ -320,16 +358,10 @@ func (ks *keyStore) createKeyStoreIfNotExists() error {
 	missing, err := utils.DirMissingOrEmpty(ksPath)
 	ks.node.debug("Keystore path [%s] missing [%t]: [%s]", ksPath, missing, utils.ErrToString(err))
 
-	if !missing {
-		// Check keystore file
-		missing, err = utils.FileMissing(ks.node.conf.getKeyStorePath(), ks.node.conf.getKeyStoreFilename())
-		ks.node.debug("Keystore [%s] missing [%t]:[%s]", ks.node.conf.getKeyStoreFilePath(), missing, utils.ErrToString(err))
-	}
-
 	if missing {
 		err := ks.createKeyStore()
 		if err != nil {
-			ks.node.debug("Failed creating db At [%s]: ", ks.node.conf.getKeyStoreFilePath(), err.Error())
+			ks.node.debug("Failed creating keystore at [%s]: [%s]", ksPath, err.Error())
 			return nil
 		}
 	}
@@ -342,32 +374,14 @@ func (ks *keyStore) createKeyStore() error {
 	ksPath := ks.node.conf.getKeyStorePath()
 	ks.node.debug("Creating Keystore at [%s]...", ksPath)
 
-	missing, err := utils.FileMissing(ksPath, ks.node.conf.getKeyStoreFilename())
-	if !missing {
-		ks.node.debug("Creating Keystore at [%s]. Keystore already there", ksPath)
-		return nil
-	}
-
-	os.MkdirAll(ksPath, 0755)
-
-	// Create Raw material folder
-	os.MkdirAll(ks.node.conf.getRawsPath(), 0755)
-
-	// Create DB
-	ks.node.debug("Open Keystore DB...")
-	db, err := sql.Open("sqlite3", filepath.Join(ksPath, ks.node.conf.getKeyStoreFilename()))
-	if err != nil {
+	if err := os.MkdirAll(ksPath, 0755); err != nil {
 		return err
 	}
 
-	ks.node.debug("Ping Keystore DB...")
-	err = db.Ping()
-	if err != nil {
-		ks.node.error("Failend pinged keystore DB: [%s]", err)
-
+	// Create Raw material folder
+	if err := os.MkdirAll(ks.node.conf.getRawsPath(), 0755); err != nil {
 		return err
 	}
-	defer db.Close()
 
 	ks.node.debug("Keystore created at [%s].", ksPath)
 	return nil
@@ -384,16 +398,15 @@ func (ks *keyStore) openKeyStore() error {
 		return nil
 	}
 
-	// Open DB
+	// Open the backend, creating its files if needed
 	ksPath := ks.node.conf.getKeyStorePath()
-
-	sqlDB, err := sql.Open("sqlite3", filepath.Join(ksPath, ks.node.conf.getKeyStoreFilename()))
+	backend, err := ks.node.openKeyStoreBackend(ks.pwd)
 	if err != nil {
-		ks.node.error("Error opening keystore%s", err.Error())
+		ks.node.error("Error opening keystore [%s]", err.Error())
 		return err
 	}
 	ks.isOpen = true
-	ks.sqlDB = sqlDB
+	ks.backend = backend
 
 	ks.node.debug("Keystore opened at [%s]...done", ksPath)
 
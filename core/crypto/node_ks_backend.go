@@ -0,0 +1,145 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// KeyStoreBackendSQLite keeps the tables of the keystore in a SQLite
+	// database. It is available in builds with cgo only
+	KeyStoreBackendSQLite = "sqlite"
+
+	// KeyStoreBackendFiles keeps the tables of the keystore in plain
+	// files, one per entry. It is written in pure Go
+	KeyStoreBackendFiles = "files"
+
+	// KeyStoreBackendSQLCipher keeps the tables of the keystore in a
+	// SQLite database encrypted by SQLCipher. It is available in builds
+	// with cgo and the sqlcipher build tag only
+	KeyStoreBackendSQLCipher = "sqlcipher"
+)
+
+// keyStoreBackends are the available keystore backends, by name. They
+// are opened with the passphrase the keystore is initialized with.
+var keyStoreBackends = make(map[string]func(node *nodeImpl, pwd []byte) (keyStoreBackend, error))
+
+// defaultKeyStoreBackend returns the backend used when the
+// configuration names none, SQLite if available
+func defaultKeyStoreBackend() string {
+	if _, ok := keyStoreBackends[KeyStoreBackendSQLite]; ok {
+		return KeyStoreBackendSQLite
+	}
+
+	return KeyStoreBackendFiles
+}
+
+// unusedTCertEntry is an unused TCert as stored by a keystore backend
+type unusedTCertEntry struct {
+	id      int64
+	cert    []byte
+	version int
+	mac     []byte
+}
+
+// reservedTCertEntry is a TCert of the reserve as stored by a keystore
+// backend, sealed along with the material its keys derive from
+type reservedTCertEntry struct {
+	id       int64
+	material []byte
+}
+
+// keyStoreBackend keeps the tables of the keystore: the TCerts of the
+// clients, the nonces and the enrollment certificates of the other nodes.
+// Keys and certificates of the node itself are files under the keystore
+// path whatever the backend.
+type keyStoreBackend interface {
+	// initClient creates, if needed, the tables of a client
+	initClient() error
+
+	// initPeer creates, if needed, the tables of a peer
+	initPeer() error
+
+	// insertClientNonce records a nonce issued by the client. It fails
+	// with ErrNonceReplayed if the nonce is already there
+	insertClientNonce(nonce []byte) error
+
+	// selectPeerNonce returns the uuid of the transaction which consumed
+	// the nonce with the passed digest, if any
+	selectPeerNonce(digest []byte) (string, bool, error)
+
+	// insertPeerNonce records that the transaction uuid consumed the
+	// nonce with the passed digest
+	insertPeerNonce(digest []byte, uuid string) error
+
+	// insertUsedTCert records der as used at the passed time
+	insertUsedTCert(der []byte, stored time.Time) error
+
+	// deleteUsedTCerts removes the used TCerts stored before the passed
+	// time, unless it is zero, and, if keep is positive, the oldest ones
+	// beyond the keep most recent. It returns how many were removed.
+	deleteUsedTCerts(before time.Time, keep int) (int64, error)
+
+	// selectTCertsDataKey returns the wrapped TCerts data key, nil if none
+	selectTCertsDataKey() ([]byte, error)
+
+	// storeTCertsDataKey inserts or replaces the wrapped TCerts data key
+	storeTCertsDataKey(wrapped []byte) error
+
+	// insertUnusedTCerts stores entries, all or none of them
+	insertUnusedTCerts(entries []*unusedTCertEntry) error
+
+	selectUnusedTCerts() ([]*unusedTCertEntry, error)
+
+	deleteUnusedTCerts(ids []int64) error
+
+	// insertReservedTCerts stores the sealed materials of TCerts of the
+	// reserve carrying the attributes key, all or none of them
+	insertReservedTCerts(attributes string, materials [][]byte) error
+
+	// selectReservedTCerts returns, oldest first, up to limit TCerts of the
+	// reserve carrying the attributes key
+	selectReservedTCerts(attributes string, limit int) ([]*reservedTCertEntry, error)
+
+	// countReservedTCerts returns how many TCerts of the reserve carry the
+	// attributes key
+	countReservedTCerts(attributes string) (int, error)
+
+	deleteReservedTCerts(ids []int64) error
+
+	// selectEnrollmentCert returns the signing enrollment certificate of
+	// the node id, nil if unknown
+	selectEnrollmentCert(id string) ([]byte, error)
+
+	insertEnrollmentCert(id string, certSign, certEnc []byte) error
+
+	close() error
+}
+
+// openKeyStoreBackend opens the backend the node is configured with
+func (node *nodeImpl) openKeyStoreBackend(pwd []byte) (keyStoreBackend, error) {
+	name := node.conf.getKeyStoreBackend()
+	open, ok := keyStoreBackends[name]
+	if !ok {
+		return nil, fmt.Errorf("Keystore backend not available [%s]", name)
+	}
+	node.debug("Using keystore backend [%s]", name)
+
+	return open(node, pwd)
+}
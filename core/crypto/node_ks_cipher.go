@@ -0,0 +1,170 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+const (
+	// KeyStoreCipherKeyPassphrase derives the key of an encrypted keystore
+	// database by scrypt from the passphrase the node is initialized with
+	KeyStoreCipherKeyPassphrase = "passphrase"
+
+	// KeyStoreCipherKeyKeyring keeps the key of an encrypted keystore
+	// database in the keyring of the operating system
+	KeyStoreCipherKeyKeyring = "keyring"
+)
+
+// KeyStoreKeyring gives access to the keyring of the operating system,
+// for instance the macOS Keychain or the Secret Service on Linux
+type KeyStoreKeyring interface {
+	// Get returns the secret of user under service, nil if there is none
+	Get(service, user string) ([]byte, error)
+
+	// Set stores secret as the secret of user under service
+	Set(service, user string, secret []byte) error
+}
+
+var (
+	keyStoreKeyring     KeyStoreKeyring
+	keyStoreKeyringLock sync.RWMutex
+)
+
+// SetKeyStoreKeyring sets the keyring the keys of encrypted keystore
+// databases are kept in, when security.keystore.sqlcipher.key is keyring
+func SetKeyStoreKeyring(keyring KeyStoreKeyring) {
+	keyStoreKeyringLock.Lock()
+	defer keyStoreKeyringLock.Unlock()
+
+	keyStoreKeyring = keyring
+}
+
+func getKeyStoreKeyring() KeyStoreKeyring {
+	keyStoreKeyringLock.RLock()
+	defer keyStoreKeyringLock.RUnlock()
+
+	return keyStoreKeyring
+}
+
+// getKeyStoreCipherKey returns the key the keystore database is encrypted
+// with, generating it the first time
+func (node *nodeImpl) getKeyStoreCipherKey(pwd []byte) ([]byte, error) {
+	switch node.conf.getKeyStoreCipherKey() {
+	case KeyStoreCipherKeyKeyring:
+		return node.getKeyStoreCipherKeyFromKeyring()
+	default:
+		return node.deriveKeyStoreCipherKey(pwd)
+	}
+}
+
+// getKeyStoreCipherKeyFromKeyring returns the key kept in the keyring
+// for the node, storing a fresh one there the first time
+func (node *nodeImpl) getKeyStoreCipherKeyFromKeyring() ([]byte, error) {
+	keyring := getKeyStoreKeyring()
+	if keyring == nil {
+		return nil, errors.New("No keyring set. Use crypto.SetKeyStoreKeyring")
+	}
+
+	service, user := node.conf.getKeyStoreKeyringService(), node.conf.getKeyStoreKeyringUser()
+	key, err := keyring.Get(service, user)
+	if err != nil {
+		node.error("Failed getting keystore key from keyring [%s].", err)
+
+		return nil, err
+	}
+	if key != nil {
+		return key, nil
+	}
+
+	node.debug("Generating keystore key in keyring...")
+
+	if key, err = primitives.GenAESKey(); err != nil {
+		return nil, err
+	}
+	if err := keyring.Set(service, user, key); err != nil {
+		node.error("Failed storing keystore key in keyring [%s].", err)
+
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// deriveKeyStoreCipherKey derives the key from pwd. The parameters of
+// the derivation are stored next to the database the first time.
+func (node *nodeImpl) deriveKeyStoreCipherKey(pwd []byte) ([]byte, error) {
+	if len(pwd) == 0 {
+		node.error("A passphrase is required to open the encrypted keystore.")
+
+		return nil, utils.ErrInvalidPassphrase
+	}
+
+	path := node.conf.getKeyStoreFilePath() + ".kdf"
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		key, params, err := newKeyStoreKDFParams(node.conf, pwd)
+		if err != nil {
+			return nil, err
+		}
+		if raw, err = asn1.Marshal(*params); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(path, raw, 0600); err != nil {
+			return nil, err
+		}
+
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	params := new(keyStoreKDFParams)
+	if _, err := asn1.Unmarshal(raw, params); err != nil {
+		node.error("Failed parsing keystore key derivation parameters [%s].", err)
+
+		return nil, err
+	}
+
+	return params.derive(pwd)
+}
+
+// isPlainSQLiteFile returns true if the file at path is
+// a SQLite database that is not encrypted
+func isPlainSQLiteFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	header := make([]byte, 16)
+	if _, err := f.Read(header); err != nil {
+		return false
+	}
+
+	return bytes.Equal(header, []byte("SQLite format 3\x00"))
+}
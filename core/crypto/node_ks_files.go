@@ -0,0 +1,567 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+func init() {
+	keyStoreBackends[KeyStoreBackendFiles] = newFileKeyStore
+}
+
+// The tables of the file backend are directories holding a file per entry
+const (
+	fileKeyStoreTCerts        = "tcerts"
+	fileKeyStoreUsedTCerts    = "usedtcerts"
+	fileKeyStoreTCertsKeys    = "tcertskeys"
+	fileKeyStoreTCertsReserve = "tcertsreserve"
+	fileKeyStoreNonces        = "nonces"
+	fileKeyStoreCertificates  = "certificates"
+)
+
+// fileTCertEntry is the content of the files of the table tcerts
+type fileTCertEntry struct {
+	Version int
+	Cert    []byte
+	Mac     []byte
+}
+
+// fileReservedTCertEntry is the content of the files of the table tcertsreserve
+type fileReservedTCertEntry struct {
+	Attributes string
+	Material   []byte
+}
+
+// fileCertificatesEntry is the content of the files of the table certificates
+type fileCertificatesEntry struct {
+	CertSign []byte
+	CertEnc  []byte
+}
+
+// fileKeyStore keeps the tables of the keystore in plain files. Entries
+// are written to a temporary file first and then renamed, so that they
+// are never seen partially written.
+type fileKeyStore struct {
+	node *nodeImpl
+	path string
+
+	// m guards nextID, the id of the next TCert entry
+	m      sync.Mutex
+	nextID int64
+}
+
+func newFileKeyStore(node *nodeImpl, pwd []byte) (keyStoreBackend, error) {
+	store := &fileKeyStore{node: node, path: node.conf.getKeyStoreFilePath() + ".d"}
+	if err := os.MkdirAll(store.path, 0700); err != nil {
+		node.error("Failed creating keystore directory [%s].", err)
+
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (store *fileKeyStore) createTables(tables ...string) error {
+	for _, table := range tables {
+		store.node.debug("Create Table if not exists [%s] at [%s].", table, store.path)
+		if err := os.MkdirAll(filepath.Join(store.path, table), 0700); err != nil {
+			store.node.debug("Failed creating table [%s].", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (store *fileKeyStore) initClient() error {
+	if err := store.createTables(fileKeyStoreTCerts, fileKeyStoreUsedTCerts, fileKeyStoreTCertsKeys, fileKeyStoreTCertsReserve, fileKeyStoreNonces); err != nil {
+		return err
+	}
+
+	// Continue the ids after the largest one in use
+	for _, table := range []string{fileKeyStoreTCerts, fileKeyStoreUsedTCerts, fileKeyStoreTCertsReserve} {
+		ids, err := store.ids(table)
+		if err != nil {
+			return err
+		}
+		if len(ids) != 0 && ids[len(ids)-1] >= store.nextID {
+			store.nextID = ids[len(ids)-1] + 1
+		}
+	}
+
+	return nil
+}
+
+func (store *fileKeyStore) initPeer() error {
+	return store.createTables(fileKeyStoreCertificates, fileKeyStoreNonces)
+}
+
+func (store *fileKeyStore) entryPath(table, name string) string {
+	return filepath.Join(store.path, table, name)
+}
+
+// writeTemp writes data to a temporary file of table and returns its path
+func (store *fileKeyStore) writeTemp(table string, data []byte) (string, error) {
+	f, err := ioutil.TempFile(filepath.Join(store.path, table), ".tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+// write stores data as the entry name of table, replacing it if present
+func (store *fileKeyStore) write(table, name string, data []byte) error {
+	tmp, err := store.writeTemp(table, data)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, store.entryPath(table, name)); err != nil {
+		os.Remove(tmp)
+
+		return err
+	}
+
+	return nil
+}
+
+// create stores data as the entry name of table. It returns false if the
+// entry is already present.
+func (store *fileKeyStore) create(table, name string, data []byte) (bool, error) {
+	tmp, err := store.writeTemp(table, data)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp)
+
+	// Linking fails, atomically, if the entry exists
+	if err := os.Link(tmp, store.entryPath(table, name)); err != nil {
+		if os.IsExist(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+// read returns the entry name of table, nil if not present
+func (store *fileKeyStore) read(table, name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(store.entryPath(table, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	return data, err
+}
+
+// ids returns, in increasing order, the ids of the entries of table
+func (store *fileKeyStore) ids(table string) ([]int64, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(store.path, table))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int64{}
+	for _, info := range infos {
+		// Skip the temporary files
+		id, err := strconv.ParseInt(info.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Sort(int64s(ids))
+
+	return ids, nil
+}
+
+// newIDs reserves n ids
+func (store *fileKeyStore) newIDs(n int) int64 {
+	store.m.Lock()
+	defer store.m.Unlock()
+
+	id := store.nextID
+	store.nextID += int64(n)
+
+	return id
+}
+
+func idName(id int64) string {
+	return fmt.Sprintf("%020d", id)
+}
+
+func (store *fileKeyStore) insertClientNonce(nonce []byte) error {
+	created, err := store.create(fileKeyStoreNonces, hex.EncodeToString(nonce), nil)
+	if err != nil {
+		store.node.error("Failed inserting nonce [%s].", err)
+
+		return err
+	}
+	if !created {
+		return utils.ErrNonceReplayed
+	}
+
+	return nil
+}
+
+func (store *fileKeyStore) selectPeerNonce(digest []byte) (string, bool, error) {
+	data, err := store.read(fileKeyStoreNonces, hex.EncodeToString(digest))
+	if err != nil {
+		store.node.error("Failed selecting nonce [%s].", err)
+
+		return "", false, err
+	}
+	if data == nil {
+		return "", false, nil
+	}
+
+	return string(data), true, nil
+}
+
+func (store *fileKeyStore) insertPeerNonce(digest []byte, uuid string) error {
+	created, err := store.create(fileKeyStoreNonces, hex.EncodeToString(digest), []byte(uuid))
+	if err != nil {
+		store.node.error("Failed inserting nonce [%s].", err)
+
+		return err
+	}
+	if !created {
+		return utils.ErrNonceReplayed
+	}
+
+	return nil
+}
+
+// insertUsedTCert stores der, recording in the modification
+// time of its file the time it is stored at
+func (store *fileKeyStore) insertUsedTCert(der []byte, stored time.Time) error {
+	name := idName(store.newIDs(1))
+	if err := store.write(fileKeyStoreUsedTCerts, name, der); err != nil {
+		store.node.error("Failed inserting TCert to UsedTCert: [%s].", err)
+
+		return err
+	}
+	if err := os.Chtimes(store.entryPath(fileKeyStoreUsedTCerts, name), stored, stored); err != nil {
+		store.node.error("Failed recording storage time of used TCert: [%s].", err)
+
+		return err
+	}
+
+	return nil
+}
+
+func (store *fileKeyStore) deleteUsedTCerts(before time.Time, keep int) (int64, error) {
+	ids, err := store.ids(fileKeyStoreUsedTCerts)
+	if err != nil {
+		store.node.error("Error during select [%s].", err)
+
+		return 0, err
+	}
+
+	var deleted int64
+	for i, id := range ids {
+		path := store.entryPath(fileKeyStoreUsedTCerts, idName(id))
+		// Ids increase with time, the oldest TCerts come first
+		expired := keep > 0 && i < len(ids)-keep
+		if !expired && !before.IsZero() {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			expired = info.ModTime().Before(before)
+		}
+		if !expired {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			store.node.error("Failed removing row [%d] from UsedTCert: [%s].", id, err)
+
+			return deleted, err
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+func (store *fileKeyStore) selectTCertsDataKey() ([]byte, error) {
+	wrapped, err := store.read(fileKeyStoreTCertsKeys, idName(1))
+	if err != nil {
+		store.node.error("Error during select [%s].", err)
+
+		return nil, err
+	}
+
+	return wrapped, nil
+}
+
+func (store *fileKeyStore) storeTCertsDataKey(wrapped []byte) error {
+	if err := store.write(fileKeyStoreTCertsKeys, idName(1), wrapped); err != nil {
+		store.node.error("Failed storing TCerts data key: [%s].", err)
+
+		return err
+	}
+
+	return nil
+}
+
+func (store *fileKeyStore) insertUnusedTCerts(entries []*unusedTCertEntry) error {
+	datas := make([][]byte, 0, len(entries))
+	for _, entry := range entries {
+		data, err := asn1.Marshal(fileTCertEntry{entry.version, entry.cert, entry.mac})
+		if err != nil {
+			return err
+		}
+		datas = append(datas, data)
+	}
+
+	if err := store.insert(fileKeyStoreTCerts, datas); err != nil {
+		store.node.error("Failed inserting unused TCert to TCerts: [%s].", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// insert stores datas as new entries of table, all or none of them
+func (store *fileKeyStore) insert(table string, datas [][]byte) error {
+	// Write all the entries aside, then move them in place
+	tmps := make([]string, 0, len(datas))
+	defer func() {
+		for _, tmp := range tmps {
+			os.Remove(tmp)
+		}
+	}()
+	for _, data := range datas {
+		tmp, err := store.writeTemp(table, data)
+		if err != nil {
+			return err
+		}
+		tmps = append(tmps, tmp)
+	}
+
+	id := store.newIDs(len(tmps))
+	for i, tmp := range tmps {
+		if err := os.Rename(tmp, store.entryPath(table, idName(id+int64(i)))); err != nil {
+			for j := 0; j < i; j++ {
+				os.Remove(store.entryPath(table, idName(id+int64(j))))
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (store *fileKeyStore) selectUnusedTCerts() ([]*unusedTCertEntry, error) {
+	ids, err := store.ids(fileKeyStoreTCerts)
+	if err != nil {
+		store.node.error("Error during select [%s].", err)
+
+		return nil, err
+	}
+
+	entries := []*unusedTCertEntry{}
+	for _, id := range ids {
+		data, err := store.read(fileKeyStoreTCerts, idName(id))
+		if err != nil || data == nil {
+			continue
+		}
+		var entry fileTCertEntry
+		if _, err := asn1.Unmarshal(data, &entry); err != nil {
+			store.node.error("Error during scan [%s].", err)
+
+			continue
+		}
+		entries = append(entries, &unusedTCertEntry{id, entry.Cert, entry.Version, entry.Mac})
+	}
+
+	return entries, nil
+}
+
+func (store *fileKeyStore) deleteUnusedTCerts(ids []int64) error {
+	for _, id := range ids {
+		if err := os.Remove(store.entryPath(fileKeyStoreTCerts, idName(id))); err != nil && !os.IsNotExist(err) {
+			store.node.error("Failed removing row [%d] from TCert: [%s].", id, err)
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (store *fileKeyStore) insertReservedTCerts(attributes string, materials [][]byte) error {
+	datas := make([][]byte, 0, len(materials))
+	for _, material := range materials {
+		data, err := asn1.Marshal(fileReservedTCertEntry{attributes, material})
+		if err != nil {
+			return err
+		}
+		datas = append(datas, data)
+	}
+
+	if err := store.insert(fileKeyStoreTCertsReserve, datas); err != nil {
+		store.node.error("Failed inserting TCert to TCertsReserve: [%s].", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// scanReservedTCerts calls f, oldest first, with the TCerts of the reserve
+// carrying the attributes key, until it returns false
+func (store *fileKeyStore) scanReservedTCerts(attributes string, f func(id int64, material []byte) bool) error {
+	ids, err := store.ids(fileKeyStoreTCertsReserve)
+	if err != nil {
+		store.node.error("Error during select [%s].", err)
+
+		return err
+	}
+
+	for _, id := range ids {
+		data, err := store.read(fileKeyStoreTCertsReserve, idName(id))
+		if err != nil || data == nil {
+			continue
+		}
+		var entry fileReservedTCertEntry
+		if _, err := asn1.Unmarshal(data, &entry); err != nil {
+			store.node.error("Error during scan [%s].", err)
+
+			continue
+		}
+		if entry.Attributes == attributes && !f(id, entry.Material) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (store *fileKeyStore) selectReservedTCerts(attributes string, limit int) ([]*reservedTCertEntry, error) {
+	entries := []*reservedTCertEntry{}
+	err := store.scanReservedTCerts(attributes, func(id int64, material []byte) bool {
+		if len(entries) >= limit {
+			return false
+		}
+		entries = append(entries, &reservedTCertEntry{id, material})
+
+		return true
+	})
+
+	return entries, err
+}
+
+func (store *fileKeyStore) countReservedTCerts(attributes string) (int, error) {
+	count := 0
+	err := store.scanReservedTCerts(attributes, func(id int64, material []byte) bool {
+		count++
+
+		return true
+	})
+
+	return count, err
+}
+
+func (store *fileKeyStore) deleteReservedTCerts(ids []int64) error {
+	for _, id := range ids {
+		if err := os.Remove(store.entryPath(fileKeyStoreTCertsReserve, idName(id))); err != nil && !os.IsNotExist(err) {
+			store.node.error("Failed removing row [%d] from TCertsReserve: [%s].", id, err)
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (store *fileKeyStore) selectEnrollmentCert(id string) ([]byte, error) {
+	data, err := store.read(fileKeyStoreCertificates, hex.EncodeToString([]byte(id)))
+	if err != nil {
+		store.node.error("Error during select [%s].", err.Error())
+
+		return nil, err
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	var entry fileCertificatesEntry
+	if _, err := asn1.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+
+	return entry.CertSign, nil
+}
+
+func (store *fileKeyStore) insertEnrollmentCert(id string, certSign, certEnc []byte) error {
+	data, err := asn1.Marshal(fileCertificatesEntry{certSign, certEnc})
+	if err != nil {
+		return err
+	}
+
+	created, err := store.create(fileKeyStoreCertificates, hex.EncodeToString([]byte(id)), data)
+	if err != nil {
+		store.node.error("Failed inserting cert [%s].", err.Error())
+
+		return err
+	}
+	if !created {
+		return fmt.Errorf("Enrollment certificate of [%s] already stored", id)
+	}
+
+	return nil
+}
+
+func (store *fileKeyStore) close() error {
+	return nil
+}
+
+type int64s []int64
+
+func (s int64s) Len() int           { return len(s) }
+func (s int64s) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
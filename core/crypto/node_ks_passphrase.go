@@ -0,0 +1,365 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// KeyStoreKDFScrypt protects the keystore with a key derived
+	// from the passphrase by scrypt
+	KeyStoreKDFScrypt = "scrypt"
+
+	// sealedKeyStoreEntry is the PEM type of the keystore entries
+	// encrypted with AES-GCM under the passphrase key
+	sealedKeyStoreEntry = "SEALED KEYSTORE ENTRY"
+
+	// keyStoreCheck is sealed under the passphrase key to recognise it
+	keyStoreCheck = "keystore"
+)
+
+// keyStoreKDFParams are the parameters the passphrase key of
+// a keystore is derived with
+type keyStoreKDFParams struct {
+	Salt    []byte
+	N, R, P int
+	Check   []byte
+}
+
+// secretPEMTypes are the PEM types of the keystore entries to seal
+var secretPEMTypes = map[string]bool{
+	"ECDSA PRIVATE KEY": true,
+	"PRIVATE KEY":       true,
+	"AES PRIVATE KEY":   true,
+}
+
+// newKeyStoreKDFParams derives a passphrase key under a fresh salt
+func newKeyStoreKDFParams(conf *configuration, pwd []byte) ([]byte, *keyStoreKDFParams, error) {
+	salt, err := primitives.GetRandomBytes(32)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	params := &keyStoreKDFParams{Salt: salt}
+	params.N, params.R, params.P = conf.getKeyStoreScryptParams()
+
+	kek, err := scrypt.Key(pwd, params.Salt, params.N, params.R, params.P, 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	if params.Check, err = primitives.GCMEncrypt(kek, []byte(keyStoreCheck), nil); err != nil {
+		return nil, nil, err
+	}
+
+	return kek, params, nil
+}
+
+// derive returns the passphrase key derived from pwd. It fails
+// with ErrInvalidPassphrase if pwd is not the passphrase.
+func (params *keyStoreKDFParams) derive(pwd []byte) ([]byte, error) {
+	kek, err := scrypt.Key(pwd, params.Salt, params.N, params.R, params.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	check, err := primitives.GCMDecrypt(kek, params.Check, nil)
+	if err != nil || string(check) != keyStoreCheck {
+		return nil, utils.ErrInvalidPassphrase
+	}
+
+	return kek, nil
+}
+
+// unlock derives the passphrase key of the keystore from ks.pwd. The first
+// time, it seals under the key the entries stored so far.
+func (ks *keyStore) unlock() error {
+	if len(ks.pwd) == 0 {
+		ks.node.error("A passphrase is required to unlock the keystore.")
+
+		return utils.ErrInvalidPassphrase
+	}
+
+	if err := ks.completeRewrap(); err != nil {
+		ks.node.error("Failed completing keystore passphrase rotation [%s].", err)
+
+		return err
+	}
+
+	path := ks.node.conf.getPathForAlias(ks.node.conf.getKeyStoreKDFParamsFilename())
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		ks.node.debug("Sealing keystore under passphrase...")
+
+		kek, params, err := newKeyStoreKDFParams(ks.node.conf, ks.pwd)
+		if err != nil {
+			return err
+		}
+
+		return ks.rewrap(kek, params)
+	}
+	if err != nil {
+		return err
+	}
+
+	params := new(keyStoreKDFParams)
+	if _, err := asn1.Unmarshal(raw, params); err != nil {
+		ks.node.error("Failed parsing keystore key derivation parameters [%s].", err)
+
+		return err
+	}
+
+	kek, err := params.derive(ks.pwd)
+	if err != nil {
+		return err
+	}
+	ks.kek = kek
+
+	return nil
+}
+
+// rotatePassphrase seals all the entries of the keystore
+// under a key derived from the new passphrase pwd
+func (ks *keyStore) rotatePassphrase(pwd []byte) error {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	if ks.kek == nil {
+		return utils.ErrNotInitialized
+	}
+	if len(pwd) == 0 {
+		return utils.ErrInvalidPassphrase
+	}
+
+	kek, params, err := newKeyStoreKDFParams(ks.node.conf, pwd)
+	if err != nil {
+		return err
+	}
+	if err := ks.rewrap(kek, params); err != nil {
+		return err
+	}
+	ks.pwd = utils.Clone(pwd)
+
+	return nil
+}
+
+// rewrap seals the secret entries of the keystore under kek. The entries are
+// first written aside; replacing the key derivation parameters commits the
+// rotation, whose entries are then moved in place by completeRewrap.
+func (ks *keyStore) rewrap(kek []byte, params *keyStoreKDFParams) error {
+	files, err := ioutil.ReadDir(ks.node.conf.getRawsPath())
+	if err != nil {
+		return err
+	}
+
+	paramsAlias := ks.node.conf.getKeyStoreKDFParamsFilename()
+	for _, file := range files {
+		alias := file.Name()
+		if file.IsDir() || alias == paramsAlias || isPendingAlias(alias) {
+			continue
+		}
+
+		raw, err := ioutil.ReadFile(ks.node.conf.getPathForAlias(alias))
+		if err != nil {
+			return err
+		}
+		clear, block, err := ks.openEntry(alias, raw)
+		if err != nil {
+			return err
+		}
+		if block == nil {
+			continue
+		}
+
+		entry := clear
+		// The TLS key is kept in clear
+		if secretPEMTypes[block.Type] && alias != ks.node.conf.getTLSKeyFilename() {
+			if entry, err = sealKeyStoreEntry(kek, clear); err != nil {
+				return err
+			}
+		}
+		if bytes.Equal(entry, raw) {
+			continue
+		}
+
+		if err := ioutil.WriteFile(ks.node.conf.getPathForAlias(alias+".rewrap"), entry, 0700); err != nil {
+			return err
+		}
+	}
+
+	raw, err := asn1.Marshal(*params)
+	if err != nil {
+		return err
+	}
+	paramsPath := ks.node.conf.getPathForAlias(paramsAlias)
+	if err := ioutil.WriteFile(paramsPath+".new", raw, 0700); err != nil {
+		return err
+	}
+	if err := os.Rename(paramsPath+".new", paramsPath); err != nil {
+		return err
+	}
+	ks.kek = kek
+
+	return ks.completeRewrap()
+}
+
+// completeRewrap moves in place the entries written aside by a committed
+// rotation, and drops those of a rotation that was not committed.
+func (ks *keyStore) completeRewrap() error {
+	paramsPath := ks.node.conf.getPathForAlias(ks.node.conf.getKeyStoreKDFParamsFilename())
+	missing, _ := utils.FilePathMissing(paramsPath + ".new")
+
+	files, err := ioutil.ReadDir(ks.node.conf.getRawsPath())
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".rewrap") {
+			continue
+		}
+
+		path := ks.node.conf.getPathForAlias(file.Name())
+		if !missing {
+			os.Remove(path)
+			continue
+		}
+		if err := os.Rename(path, strings.TrimSuffix(path, ".rewrap")); err != nil {
+			return err
+		}
+	}
+
+	if !missing {
+		return os.Remove(paramsPath + ".new")
+	}
+
+	return nil
+}
+
+// openEntry returns the entry alias stored as raw in clear, along with its
+// PEM block. The block is nil if the entry is not PEM encoded.
+func (ks *keyStore) openEntry(alias string, raw []byte) ([]byte, *pem.Block, error) {
+	clear, err := ks.unseal(raw)
+	if err != nil {
+		ks.node.error("Failed opening keystore entry [%s]: [%s].", alias, err)
+
+		return nil, nil, err
+	}
+
+	block, _ := pem.Decode(clear)
+	if block == nil {
+		return clear, nil, nil
+	}
+	if x509.IsEncryptedPEMBlock(block) {
+		der, err := x509.DecryptPEMBlock(block, ks.pwd)
+		if err != nil {
+			ks.node.error("Failed decrypting keystore entry [%s]: [%s].", alias, err)
+
+			return nil, nil, err
+		}
+		block = &pem.Block{Type: block.Type, Bytes: der}
+		clear = pem.EncodeToMemory(block)
+	}
+
+	return clear, block, nil
+}
+
+// protectEntry returns the form the entry alias, given in clear, is
+// stored in: sealed under the passphrase key, if any, or else encrypted
+// under the password of the keystore.
+func (ks *keyStore) protectEntry(alias string, clear []byte) ([]byte, error) {
+	block, _ := pem.Decode(clear)
+	// The TLS key is kept in clear
+	if block == nil || !secretPEMTypes[block.Type] || alias == ks.node.conf.getTLSKeyFilename() {
+		return clear, nil
+	}
+	if ks.kek != nil {
+		return sealKeyStoreEntry(ks.kek, clear)
+	}
+	if len(ks.pwd) == 0 {
+		return clear, nil
+	}
+
+	block, err := x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, ks.pwd, x509.PEMCipherAES256)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(block), nil
+}
+
+// isPendingAlias returns true if alias is written aside by a rotation
+func isPendingAlias(alias string) bool {
+	return strings.HasSuffix(alias, ".rewrap") || strings.HasSuffix(alias, ".new") || strings.HasSuffix(alias, ".tmp")
+}
+
+func sealKeyStoreEntry(kek, clear []byte) ([]byte, error) {
+	ct, err := primitives.GCMEncrypt(kek, clear, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: sealedKeyStoreEntry, Bytes: ct}), nil
+}
+
+// seal encrypts the PEM encoded entry clear under the passphrase
+// key, if the keystore is protected by one
+func (ks *keyStore) seal(clear []byte) ([]byte, error) {
+	if ks.kek == nil {
+		return clear, nil
+	}
+
+	return sealKeyStoreEntry(ks.kek, clear)
+}
+
+// unseal returns the PEM encoded entry sealed in raw.
+// Entries that are not sealed are returned as they are.
+func (ks *keyStore) unseal(raw []byte) ([]byte, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil || block.Type != sealedKeyStoreEntry {
+		return raw, nil
+	}
+	if ks.kek == nil {
+		return nil, utils.ErrInvalidPassphrase
+	}
+
+	clear, err := primitives.GCMDecrypt(ks.kek, block.Bytes, nil)
+	if err != nil {
+		return nil, utils.ErrDecrypt
+	}
+
+	return clear, nil
+}
+
+// pemPwd returns the password PEM entries are encrypted with.
+// Entries are sealed instead when the keystore is protected by a passphrase key.
+func (ks *keyStore) pemPwd() []byte {
+	if ks.kek != nil {
+		return nil
+	}
+
+	return ks.pwd
+}
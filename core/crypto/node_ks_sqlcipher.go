@@ -0,0 +1,107 @@
+// +build cgo,sqlcipher
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+
+	// Registers the sqlite3 driver, built with SQLCipher
+	_ "github.com/mutecomm/go-sqlcipher"
+)
+
+func init() {
+	keyStoreBackends[KeyStoreBackendSQLCipher] = newSQLCipherKeyStore
+}
+
+// newSQLCipherKeyStore opens the keystore database encrypted under the
+// key configured by security.keystore.sqlcipher.key. A database left in
+// clear by the sqlite backend is encrypted the first time.
+func newSQLCipherKeyStore(node *nodeImpl, pwd []byte) (keyStoreBackend, error) {
+	key, err := node.getKeyStoreCipherKey(pwd)
+	if err != nil {
+		return nil, err
+	}
+	rawKey := "x'" + hex.EncodeToString(key) + "'"
+
+	path := filepath.Join(node.conf.getKeyStorePath(), node.conf.getKeyStoreFilename())
+	if isPlainSQLiteFile(path) {
+		if err := encryptSQLiteFile(node, path, rawKey); err != nil {
+			node.error("Failed encrypting keystore DB [%s].", err)
+
+			return nil, err
+		}
+	}
+
+	store, err := openSQLiteKeyStore(node, path+"?_pragma_key="+rawKey+"&_pragma_cipher_page_size=4096")
+	if err != nil {
+		return nil, err
+	}
+
+	// A wrong key shows only once the database is read
+	if _, err := store.db.Exec("SELECT count(*) FROM sqlite_master"); err != nil {
+		node.error("Failed reading keystore DB. Wrong key? [%s]", err)
+		store.db.Close()
+
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// encryptSQLiteFile replaces the database in clear at path with
+// a copy encrypted under rawKey
+func encryptSQLiteFile(node *nodeImpl, path, rawKey string) error {
+	node.debug("Encrypting keystore DB...")
+
+	tmp := path + ".sqlcipher"
+	os.Remove(tmp)
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("ATTACH DATABASE '" + tmp + "' AS encrypted KEY \"" + rawKey + "\""); err != nil {
+		return err
+	}
+	if _, err := db.Exec("PRAGMA encrypted.cipher_page_size = 4096"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("SELECT sqlcipher_export('encrypted')"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if _, err := db.Exec("DETACH DATABASE encrypted"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	db.Close()
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	node.debug("Encrypting keystore DB...done!")
+
+	return nil
+}
@@ -0,0 +1,103 @@
+// +build cgo,sqlcipher
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+func TestSQLCipherKeyStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestSQLCipherKeyStore")
+	if err != nil {
+		t.Fatalf("Failed creating directory [%s]", err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := &configuration{
+		prefix:            "client",
+		name:              "node",
+		keystorePath:      dir,
+		keyStoreScryptN:   1 << 10,
+		keyStoreScryptR:   8,
+		keyStoreScryptP:   1,
+		keyStoreCipherKey: KeyStoreCipherKeyPassphrase,
+	}
+	node := &nodeImpl{conf: conf}
+
+	// A database left in clear by the sqlite backend
+	path := filepath.Join(dir, conf.getKeyStoreFilename())
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed opening database [%s]", err)
+	}
+	if _, err := db.Exec("CREATE TABLE Nonces (nonce BLOB, PRIMARY KEY (nonce))"); err != nil {
+		t.Fatalf("Failed creating table [%s]", err)
+	}
+	if _, err := db.Exec("INSERT INTO Nonces (nonce) VALUES (?)", []byte("nonce")); err != nil {
+		t.Fatalf("Failed inserting nonce [%s]", err)
+	}
+	db.Close()
+	if !isPlainSQLiteFile(path) {
+		t.Fatal("The database must be in clear")
+	}
+
+	// is encrypted the first time it is opened, keeping its content
+	store, err := newSQLCipherKeyStore(node, []byte("pwd"))
+	if err != nil {
+		t.Fatalf("Failed opening encrypted keystore [%s]", err)
+	}
+	if err := store.initClient(); err != nil {
+		t.Fatalf("Failed initializing encrypted keystore [%s]", err)
+	}
+	if err := store.insertClientNonce([]byte("nonce")); err != utils.ErrNonceReplayed {
+		t.Fatalf("The content of the database must be kept [%v]", err)
+	}
+	store.close()
+	if isPlainSQLiteFile(path) {
+		t.Fatal("The database must be encrypted")
+	}
+
+	// and can be opened under the right key only
+	if _, err := newSQLCipherKeyStore(node, []byte("wrong")); err != utils.ErrInvalidPassphrase {
+		t.Fatalf("A wrong passphrase must be rejected [%v]", err)
+	}
+	keyring := testKeyring{}
+	SetKeyStoreKeyring(keyring)
+	defer SetKeyStoreKeyring(nil)
+	conf.keyStoreCipherKey = KeyStoreCipherKeyKeyring
+	if _, err := newSQLCipherKeyStore(node, nil); err == nil {
+		t.Fatal("A wrong key must be rejected")
+	}
+
+	conf.keyStoreCipherKey = KeyStoreCipherKeyPassphrase
+	store, err = newSQLCipherKeyStore(node, []byte("pwd"))
+	if err != nil {
+		t.Fatalf("Failed opening encrypted keystore again [%s]", err)
+	}
+	defer store.close()
+	if err := store.insertClientNonce([]byte("nonce")); err != utils.ErrNonceReplayed {
+		t.Fatalf("The content of the database must be kept [%v]", err)
+	}
+}
@@ -0,0 +1,495 @@
+// +build cgo
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"database/sql"
+	"path/filepath"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+func init() {
+	keyStoreBackends[KeyStoreBackendSQLite] = newSQLiteKeyStore
+}
+
+// sqliteKeyStore keeps the tables of the keystore in a SQLite database
+type sqliteKeyStore struct {
+	node *nodeImpl
+	db   *sql.DB
+}
+
+func newSQLiteKeyStore(node *nodeImpl, pwd []byte) (keyStoreBackend, error) {
+	return openSQLiteKeyStore(node, filepath.Join(node.conf.getKeyStorePath(), node.conf.getKeyStoreFilename()))
+}
+
+// openSQLiteKeyStore opens the database named by dsn
+func openSQLiteKeyStore(node *nodeImpl, dsn string) (*sqliteKeyStore, error) {
+	node.debug("Open Keystore DB...")
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		node.error("Error opening keystore%s", err.Error())
+		return nil, err
+	}
+
+	node.debug("Ping Keystore DB...")
+	if err = db.Ping(); err != nil {
+		node.error("Failend pinged keystore DB: [%s]", err)
+		db.Close()
+
+		return nil, err
+	}
+
+	return &sqliteKeyStore{node, db}, nil
+}
+
+func (store *sqliteKeyStore) initClient() error {
+	store.node.debug("Create Table if not exists [TCert] at [%s].", store.node.conf.getKeyStorePath())
+	if _, err := store.db.Exec("CREATE TABLE IF NOT EXISTS TCerts (id INTEGER, cert BLOB, version INTEGER NOT NULL DEFAULT 0, mac BLOB, PRIMARY KEY (id))"); err != nil {
+		store.node.debug("Failed creating table [%s].", err)
+		return err
+	}
+	if err := store.migrateTCertsTable(); err != nil {
+		store.node.debug("Failed migrating table [%s].", err)
+		return err
+	}
+
+	store.node.debug("Create Table if not exists [TCertsKeys] at [%s].", store.node.conf.getKeyStorePath())
+	if _, err := store.db.Exec("CREATE TABLE IF NOT EXISTS TCertsKeys (id INTEGER, wrapped BLOB, PRIMARY KEY (id))"); err != nil {
+		store.node.debug("Failed creating table [%s].", err)
+		return err
+	}
+
+	store.node.debug("Create Table if not exists [TCertsReserve] at [%s].", store.node.conf.getKeyStorePath())
+	if _, err := store.db.Exec("CREATE TABLE IF NOT EXISTS TCertsReserve (id INTEGER, attributes VARCHAR, material BLOB, PRIMARY KEY (id))"); err != nil {
+		store.node.debug("Failed creating table [%s].", err)
+		return err
+	}
+
+	store.node.debug("Create Table if not exists [UsedTCert] at [%s].", store.node.conf.getKeyStorePath())
+	if _, err := store.db.Exec("CREATE TABLE IF NOT EXISTS UsedTCert (id INTEGER, cert BLOB, stored INTEGER NOT NULL DEFAULT 0, PRIMARY KEY (id))"); err != nil {
+		store.node.debug("Failed creating table [%s].", err)
+		return err
+	}
+	if err := store.migrateUsedTCertTable(); err != nil {
+		store.node.debug("Failed migrating table [%s].", err)
+		return err
+	}
+
+	store.node.debug("Create Table if not exists [Nonces] at [%s].", store.node.conf.getKeyStorePath())
+	if _, err := store.db.Exec("CREATE TABLE IF NOT EXISTS Nonces (nonce BLOB, PRIMARY KEY (nonce))"); err != nil {
+		store.node.debug("Failed creating table [%s].", err)
+		return err
+	}
+
+	return nil
+}
+
+// columns returns the names of the columns of table
+func (store *sqliteKeyStore) columns(table string) (map[string]bool, error) {
+	rows, err := store.db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, ctype string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+
+	return columns, nil
+}
+
+// migrateTCertsTable adds to a TCerts table created by a previous
+// release the columns of the versioned format. The TCerts it holds are
+// then seen as version 0.
+func (store *sqliteKeyStore) migrateTCertsTable() error {
+	columns, err := store.columns("TCerts")
+	if err != nil {
+		return err
+	}
+
+	if !columns["version"] {
+		store.node.debug("Migrating table [TCerts]: adding column [version].")
+
+		if _, err := store.db.Exec("ALTER TABLE TCerts ADD COLUMN version INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+	}
+	if !columns["mac"] {
+		store.node.debug("Migrating table [TCerts]: adding column [mac].")
+
+		if _, err := store.db.Exec("ALTER TABLE TCerts ADD COLUMN mac BLOB"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateUsedTCertTable adds to a UsedTCert table created by a previous
+// release the time the TCerts are stored at. The TCerts it holds are
+// then seen as stored at the epoch.
+func (store *sqliteKeyStore) migrateUsedTCertTable() error {
+	columns, err := store.columns("UsedTCert")
+	if err != nil {
+		return err
+	}
+
+	if !columns["stored"] {
+		store.node.debug("Migrating table [UsedTCert]: adding column [stored].")
+
+		if _, err := store.db.Exec("ALTER TABLE UsedTCert ADD COLUMN stored INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (store *sqliteKeyStore) initPeer() error {
+	store.node.debug("Create Table [%s] if not exists", "Certificates")
+	if _, err := store.db.Exec("CREATE TABLE IF NOT EXISTS Certificates (id VARCHAR, certsign BLOB, certenc BLOB, PRIMARY KEY (id))"); err != nil {
+		store.node.debug("Failed creating table [%s].", err.Error())
+		return err
+	}
+
+	store.node.debug("Create Table [%s] if not exists", "Nonces")
+	if _, err := store.db.Exec("CREATE TABLE IF NOT EXISTS Nonces (digest BLOB, uuid VARCHAR, PRIMARY KEY (digest))"); err != nil {
+		store.node.debug("Failed creating table [%s].", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (store *sqliteKeyStore) insertClientNonce(nonce []byte) error {
+	var count int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM Nonces WHERE nonce = ?", nonce).Scan(&count); err != nil {
+		store.node.error("Failed selecting nonce [%s].", err)
+
+		return err
+	}
+	if count != 0 {
+		return utils.ErrNonceReplayed
+	}
+
+	if _, err := store.db.Exec("INSERT INTO Nonces (nonce) VALUES (?)", nonce); err != nil {
+		store.node.error("Failed inserting nonce [%s].", err)
+
+		return err
+	}
+
+	return nil
+}
+
+func (store *sqliteKeyStore) selectPeerNonce(digest []byte) (string, bool, error) {
+	var used string
+	err := store.db.QueryRow("SELECT uuid FROM Nonces WHERE digest = ?", digest).Scan(&used)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", false, nil
+	case err != nil:
+		store.node.error("Failed selecting nonce [%s].", err)
+
+		return "", false, err
+	}
+
+	return used, true, nil
+}
+
+func (store *sqliteKeyStore) insertPeerNonce(digest []byte, uuid string) error {
+	if _, err := store.db.Exec("INSERT INTO Nonces (digest, uuid) VALUES (?, ?)", digest, uuid); err != nil {
+		store.node.error("Failed inserting nonce [%s].", err)
+
+		return err
+	}
+
+	return nil
+}
+
+func (store *sqliteKeyStore) insertUsedTCert(der []byte, stored time.Time) (err error) {
+	// Open transaction
+	tx, err := store.db.Begin()
+	if err != nil {
+		store.node.error("Failed beginning transaction [%s].", err)
+
+		return
+	}
+
+	// Insert into UsedTCert
+	if _, err = tx.Exec("INSERT INTO UsedTCert (cert, stored) VALUES (?, ?)", der, stored.Unix()); err != nil {
+		store.node.error("Failed inserting TCert to UsedTCert: [%s].", err)
+
+		tx.Rollback()
+
+		return
+	}
+
+	// Finalize
+	err = tx.Commit()
+	if err != nil {
+		store.node.error("Failed commiting [%s].", err)
+		tx.Rollback()
+	}
+
+	return
+}
+
+func (store *sqliteKeyStore) deleteUsedTCerts(before time.Time, keep int) (int64, error) {
+	var deleted int64
+	if !before.IsZero() {
+		res, err := store.db.Exec("DELETE FROM UsedTCert WHERE stored < ?", before.Unix())
+		if err != nil {
+			store.node.error("Failed removing used TCerts stored before [%s]: [%s].", before, err)
+
+			return deleted, err
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+	}
+	if keep > 0 {
+		res, err := store.db.Exec("DELETE FROM UsedTCert WHERE id NOT IN (SELECT id FROM UsedTCert ORDER BY id DESC LIMIT ?)", keep)
+		if err != nil {
+			store.node.error("Failed removing used TCerts beyond the [%d] most recent: [%s].", keep, err)
+
+			return deleted, err
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+	}
+
+	return deleted, nil
+}
+
+func (store *sqliteKeyStore) selectTCertsDataKey() ([]byte, error) {
+	var wrapped []byte
+	err := store.db.QueryRow("SELECT wrapped FROM TCertsKeys WHERE id = 1").Scan(&wrapped)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		store.node.error("Error during select [%s].", err)
+
+		return nil, err
+	}
+
+	return wrapped, nil
+}
+
+func (store *sqliteKeyStore) storeTCertsDataKey(wrapped []byte) error {
+	if _, err := store.db.Exec("INSERT OR REPLACE INTO TCertsKeys (id, wrapped) VALUES (1, ?)", wrapped); err != nil {
+		store.node.error("Failed storing TCerts data key: [%s].", err)
+
+		return err
+	}
+
+	return nil
+}
+
+func (store *sqliteKeyStore) insertUnusedTCerts(entries []*unusedTCertEntry) (err error) {
+	// Open transaction
+	tx, err := store.db.Begin()
+	if err != nil {
+		store.node.error("Failed beginning transaction [%s].", err)
+
+		return
+	}
+
+	for _, entry := range entries {
+		if _, err = tx.Exec("INSERT INTO TCerts (cert, version, mac) VALUES (?, ?, ?)", entry.cert, entry.version, entry.mac); err != nil {
+			store.node.error("Failed inserting unused TCert to TCerts: [%s].", err)
+
+			tx.Rollback()
+
+			return
+		}
+	}
+
+	// Finalize
+	err = tx.Commit()
+	if err != nil {
+		store.node.error("Failed commiting [%s].", err)
+		tx.Rollback()
+	}
+
+	return
+}
+
+func (store *sqliteKeyStore) selectUnusedTCerts() ([]*unusedTCertEntry, error) {
+	rows, err := store.db.Query("SELECT id, cert, version, mac FROM TCerts")
+	if err != nil {
+		store.node.error("Error during select [%s].", err)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*unusedTCertEntry{}
+	for rows.Next() {
+		entry := &unusedTCertEntry{}
+		if err := rows.Scan(&entry.id, &entry.cert, &entry.version, &entry.mac); err != nil {
+			store.node.error("Error during scan [%s].", err)
+
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (store *sqliteKeyStore) deleteUnusedTCerts(ids []int64) error {
+	for _, id := range ids {
+		if _, err := store.db.Exec("DELETE FROM TCerts WHERE id = ?", id); err != nil {
+			store.node.error("Failed removing row [%d] from TCert: [%s].", id, err)
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (store *sqliteKeyStore) insertReservedTCerts(attributes string, materials [][]byte) (err error) {
+	// Open transaction
+	tx, err := store.db.Begin()
+	if err != nil {
+		store.node.error("Failed beginning transaction [%s].", err)
+
+		return
+	}
+
+	for _, material := range materials {
+		if _, err = tx.Exec("INSERT INTO TCertsReserve (attributes, material) VALUES (?, ?)", attributes, material); err != nil {
+			store.node.error("Failed inserting TCert to TCertsReserve: [%s].", err)
+
+			tx.Rollback()
+
+			return
+		}
+	}
+
+	// Finalize
+	err = tx.Commit()
+	if err != nil {
+		store.node.error("Failed commiting [%s].", err)
+		tx.Rollback()
+	}
+
+	return
+}
+
+func (store *sqliteKeyStore) selectReservedTCerts(attributes string, limit int) ([]*reservedTCertEntry, error) {
+	rows, err := store.db.Query("SELECT id, material FROM TCertsReserve WHERE attributes = ? ORDER BY id LIMIT ?", attributes, limit)
+	if err != nil {
+		store.node.error("Error during select [%s].", err)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*reservedTCertEntry{}
+	for rows.Next() {
+		entry := &reservedTCertEntry{}
+		if err := rows.Scan(&entry.id, &entry.material); err != nil {
+			store.node.error("Error during scan [%s].", err)
+
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (store *sqliteKeyStore) countReservedTCerts(attributes string) (int, error) {
+	var count int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM TCertsReserve WHERE attributes = ?", attributes).Scan(&count); err != nil {
+		store.node.error("Error during select [%s].", err)
+
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (store *sqliteKeyStore) deleteReservedTCerts(ids []int64) error {
+	for _, id := range ids {
+		if _, err := store.db.Exec("DELETE FROM TCertsReserve WHERE id = ?", id); err != nil {
+			store.node.error("Failed removing row [%d] from TCertsReserve: [%s].", id, err)
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (store *sqliteKeyStore) selectEnrollmentCert(id string) ([]byte, error) {
+	var cert []byte
+	err := store.db.QueryRow("SELECT certsign FROM Certificates where id = ?", id).Scan(&cert)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		store.node.error("Error during select [%s].", err.Error())
+
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+func (store *sqliteKeyStore) insertEnrollmentCert(id string, certSign, certEnc []byte) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		store.node.error("Failed beginning transaction [%s].", err.Error())
+
+		return err
+	}
+
+	if _, err = tx.Exec("INSERT INTO Certificates (id, certsign, certenc) VALUES (?, ?, ?)", id, certSign, certEnc); err != nil {
+		store.node.error("Failed inserting cert [%s].", err.Error())
+
+		tx.Rollback()
+
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		store.node.error("Failed committing transaction [%s].", err.Error())
+
+		tx.Rollback()
+
+		return err
+	}
+
+	return nil
+}
+
+func (store *sqliteKeyStore) close() error {
+	return store.db.Close()
+}
@@ -17,17 +17,24 @@ limitations under the License.
 package crypto
 
 func (node *nodeImpl) info(format string, args ...interface{}) {
-	log.Info(node.conf.logPrefix+format, args...)
+	node.getLogger().Info(node.conf.logPrefix+format, args...)
 }
 
 func (node *nodeImpl) debug(format string, args ...interface{}) {
-	log.Debug(node.conf.logPrefix+format, args...)
+	node.getLogger().Debug(node.conf.logPrefix+format, args...)
 }
 
 func (node *nodeImpl) error(format string, args ...interface{}) {
-	log.Error(node.conf.logPrefix+format, args...)
+	node.getLogger().Error(node.conf.logPrefix+format, args...)
 }
 
 func (node *nodeImpl) warning(format string, args ...interface{}) {
-	log.Warning(node.conf.logPrefix+format, args...)
+	node.getLogger().Warning(node.conf.logPrefix+format, args...)
+}
+
+func (node *nodeImpl) getLogger() Logger {
+	if node.logger != nil {
+		return node.logger
+	}
+	return log
 }
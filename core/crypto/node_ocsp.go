@@ -0,0 +1,253 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/crypto/ocsp"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	// OCSPPolicySoftFail accepts the certificates whose status
+	// cannot be established
+	OCSPPolicySoftFail = "soft"
+
+	// OCSPPolicyHardFail rejects the certificates whose status
+	// cannot be established
+	OCSPPolicyHardFail = "hard"
+)
+
+// ocspCache holds the certificate statuses reported by the OCSP
+// responder until they expire. The zero value is an empty cache.
+type ocspCache struct {
+	m         sync.RWMutex
+	responses map[string]*ocspCacheEntry
+}
+
+type ocspCacheEntry struct {
+	status  int
+	expires time.Time
+}
+
+func ocspCacheKey(cert *x509.Certificate) string {
+	return string(cert.RawIssuer) + cert.SerialNumber.String()
+}
+
+func (cache *ocspCache) get(cert *x509.Certificate) (int, bool) {
+	cache.m.RLock()
+	defer cache.m.RUnlock()
+
+	entry, ok := cache.responses[ocspCacheKey(cert)]
+	if !ok || time.Now().After(entry.expires) {
+		return 0, false
+	}
+
+	return entry.status, true
+}
+
+func (cache *ocspCache) put(cert *x509.Certificate, status int, expires time.Time) {
+	cache.m.Lock()
+	defer cache.m.Unlock()
+
+	if cache.responses == nil {
+		cache.responses = make(map[string]*ocspCacheEntry)
+	}
+	cache.responses[ocspCacheKey(cert)] = &ocspCacheEntry{status, expires}
+}
+
+// checkCertificateStatus asks the OCSP responder the status of cert, issued
+// by issuer. Revoked certificates are rejected with ErrRevokedCertificate.
+// When the status cannot be established, the certificate is accepted under
+// the soft-fail policy and rejected with ErrCertificateStatusUnknown under
+// the hard-fail one.
+func (node *nodeImpl) checkCertificateStatus(cert, issuer *x509.Certificate) error {
+	if !node.conf.isOCSPEnabled() {
+		return nil
+	}
+
+	status, err := 0, errors.New("Unknown certificate issuer.")
+	if issuer != nil {
+		status, err = node.getOCSPStatus(cert, issuer)
+	}
+	if err == nil {
+		switch status {
+		case ocsp.Good:
+			return nil
+		case ocsp.Revoked:
+			node.error("Certificate [%s] revoked.", cert.SerialNumber.String())
+
+			return utils.ErrRevokedCertificate
+		}
+		err = errors.New("Unknown to the OCSP responder.")
+	}
+
+	if node.conf.getOCSPPolicy() == OCSPPolicySoftFail {
+		node.warning("Failed checking status of certificate [%s], accepting it [%s].", cert.SerialNumber.String(), err.Error())
+
+		return nil
+	}
+	node.error("Failed checking status of certificate [%s] [%s].", cert.SerialNumber.String(), err.Error())
+
+	return utils.ErrCertificateStatusUnknown
+}
+
+func (node *nodeImpl) getOCSPStatus(cert, issuer *x509.Certificate) (int, error) {
+	if status, ok := node.ocsp.get(cert); ok {
+		return status, nil
+	}
+
+	responder := node.conf.getOCSPResponder()
+	if responder == "" {
+		if len(cert.OCSPServer) == 0 {
+			return 0, errors.New("No OCSP responder.")
+		}
+		responder = cert.OCSPServer[0]
+	}
+
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	client := &http.Client{Timeout: node.conf.getOCSPTimeout()}
+	httpResp, err := client.Post(responder, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return 0, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("OCSP responder replied [%s].", httpResp.Status)
+	}
+	raw, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := ocsp.ParseResponse(raw, issuer)
+	if err != nil {
+		return 0, err
+	}
+	if resp.SerialNumber == nil || resp.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		return 0, errors.New("OCSP response for another certificate.")
+	}
+
+	// Keep the status until the responder's next update, if any
+	expires := time.Now().Add(node.conf.getOCSPCacheTTL())
+	if !resp.NextUpdate.IsZero() {
+		if resp.NextUpdate.Before(time.Now()) {
+			return 0, errors.New("OCSP response expired.")
+		}
+		expires = resp.NextUpdate
+	}
+	node.ocsp.put(cert, resp.Status, expires)
+
+	return resp.Status, nil
+}
+
+// getCertificateIssuer returns the certificate of the ECA or the TCA,
+// whichever issued cert
+func (node *nodeImpl) getCertificateIssuer(cert *x509.Certificate) (*x509.Certificate, error) {
+	issuers, err := node.loadCACerts()
+	if err != nil {
+		return nil, err
+	}
+	for _, issuer := range issuers {
+		if bytes.Equal(issuer.RawSubject, cert.RawIssuer) {
+			return issuer, nil
+		}
+	}
+
+	return nil, errors.New("Unknown certificate issuer.")
+}
+
+// checkMemberCertificateStatus checks the status of the enrollment or transaction
+// certificate cert
+func (node *nodeImpl) checkMemberCertificateStatus(cert *x509.Certificate) error {
+	if !node.conf.isOCSPEnabled() {
+		return nil
+	}
+
+	issuer, err := node.getCertificateIssuer(cert)
+	if err != nil {
+		node.error("Failed getting issuer of certificate [%s] [%s].", cert.SerialNumber.String(), err.Error())
+
+		return err
+	}
+
+	return node.checkCertificateStatus(cert, issuer)
+}
+
+// checkTLSCertificateStatus checks the status of the TLS certificate
+// presented by a server, once the TLS handshake verified its chain
+func (node *nodeImpl) checkTLSCertificateStatus(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("No TLS certificate presented.")
+	}
+	cert := state.PeerCertificates[0]
+
+	// The issuer is sent by the server or found in the TLSCA chain
+	// the handshake verified the certificate against
+	if len(state.PeerCertificates) > 1 {
+		return node.checkCertificateStatus(cert, state.PeerCertificates[1])
+	}
+	for _, chain := range state.VerifiedChains {
+		if len(chain) > 1 {
+			return node.checkCertificateStatus(cert, chain[1])
+		}
+	}
+
+	return node.checkCertificateStatus(cert, nil)
+}
+
+// ocspCredentials checks, once the TLS handshake done, the status
+// of the certificate presented by the server
+type ocspCredentials struct {
+	credentials.TransportAuthenticator
+
+	node *nodeImpl
+}
+
+func (creds *ocspCredentials) ClientHandshake(addr string, rawConn net.Conn, timeout time.Duration) (net.Conn, credentials.AuthInfo, error) {
+	conn, info, err := creds.TransportAuthenticator.ClientHandshake(addr, rawConn, timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		conn.Close()
+		return nil, nil, errors.New("Not a TLS connection.")
+	}
+	if err := creds.node.checkTLSCertificateStatus(tlsConn.ConnectionState()); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return conn, info, nil
+}
@@ -0,0 +1,281 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// SecretEnrollPassword is the class of the enrollment password
+	SecretEnrollPassword = "enrollPassword"
+
+	// SecretKeyStorePassphrase is the class of the keystore passphrase
+	SecretKeyStorePassphrase = "keyStorePassphrase"
+
+	// SecretTLSKey is the class of the PEM encoded TLS server key
+	SecretTLSKey = "tlsKey"
+)
+
+// Secret is a secret read from a secret store. A leased secret is valid
+// for Lease only, unless renewed before.
+type Secret struct {
+	Value []byte
+
+	// LeaseID identifies the lease to the store, Lease is its duration.
+	// A zero Lease means the secret does not expire.
+	LeaseID   string
+	Lease     time.Duration
+	Renewable bool
+}
+
+// SecretStore is a store of secrets, such as HashiCorp Vault or the
+// key management service of a cloud provider
+type SecretStore interface {
+	// Read returns the secret stored at path
+	Read(path string) (*Secret, error)
+
+	// Renew extends the lease of secret and returns the renewed secret
+	Renew(secret *Secret) (*Secret, error)
+
+	// Close releases the connection with the store, revoking
+	// the leases it holds if the store supports it
+	Close() error
+}
+
+// SecretStoreProvider connects to a secret store on behalf of the node
+// named name. The provider reads the settings it needs, such as the
+// address of the store and how to authenticate, from the properties
+// under security.secrets.
+type SecretStoreProvider func(name string) (SecretStore, error)
+
+var (
+	secretStoreProviders     = make(map[string]SecretStoreProvider)
+	secretStoreProvidersLock sync.RWMutex
+)
+
+// RegisterSecretStoreProvider registers provider under name. Nodes select
+// the provider to use by setting the property security.secrets.provider
+// and where their secrets are by setting security.secrets.paths.
+func RegisterSecretStoreProvider(name string, provider SecretStoreProvider) error {
+	if name == "" || provider == nil {
+		return fmt.Errorf("Invalid secret store provider [%s]", name)
+	}
+
+	secretStoreProvidersLock.Lock()
+	defer secretStoreProvidersLock.Unlock()
+
+	if _, ok := secretStoreProviders[name]; ok {
+		return fmt.Errorf("Secret store provider [%s] already registered", name)
+	}
+	secretStoreProviders[name] = provider
+
+	return nil
+}
+
+// GetSecretStoreProviders returns the names of the registered secret store providers
+func GetSecretStoreProviders() []string {
+	secretStoreProvidersLock.RLock()
+	defer secretStoreProvidersLock.RUnlock()
+
+	names := make([]string, 0, len(secretStoreProviders))
+	for name := range secretStoreProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func newSecretStore(provider, name string) (SecretStore, error) {
+	secretStoreProvidersLock.RLock()
+	newProvider, ok := secretStoreProviders[provider]
+	secretStoreProvidersLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Secret store provider [%s] not registered", provider)
+	}
+
+	return newProvider(name)
+}
+
+// ReadSecret reads once the secret of the passed class of the node named
+// name from the secret store configured under security.secrets. It
+// returns nil if the configuration names no store or no path for class.
+func ReadSecret(name, class string) ([]byte, error) {
+	provider := viper.GetString("security.secrets.provider")
+	path := viper.GetString("security.secrets.paths." + class)
+	if provider == "" || path == "" {
+		return nil, nil
+	}
+
+	store, err := newSecretStore(provider, name)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	secret, err := store.Read(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret.Value, nil
+}
+
+// secretLease is a leased secret the node keeps renewed
+type secretLease struct {
+	path    string
+	secret  *Secret
+	renewAt time.Time
+}
+
+// initSecretStore connects to the secret store, if the node reads secrets from one
+func (node *nodeImpl) initSecretStore() (err error) {
+	if node.conf.getSecretStoreProvider() == "" || node.secrets != nil {
+		return
+	}
+
+	node.debug("Using secret store provider [%s]", node.conf.getSecretStoreProvider())
+
+	if node.secrets, err = newSecretStore(node.conf.getSecretStoreProvider(), node.conf.name); err != nil {
+		node.error("Failed connecting to secret store [%s]", err)
+	}
+
+	return
+}
+
+// readSecret returns the secret of the passed class from the secret
+// store, nil if the node does not read secrets of that class from one.
+// Renewable leases are renewed once the node is initialized.
+func (node *nodeImpl) readSecret(class string) ([]byte, error) {
+	path := node.conf.getSecretPath(class)
+	if node.secrets == nil || path == "" {
+		return nil, nil
+	}
+
+	node.debug("Reading secret [%s] from secret store...", class)
+
+	secret, err := node.secrets.Read(path)
+	if err != nil {
+		node.error("Failed reading secret [%s] from secret store [%s].", class, err)
+
+		return nil, err
+	}
+	if secret.Lease > 0 && secret.Renewable {
+		node.secretLeases = append(node.secretLeases, &secretLease{path, secret, renewTime(secret)})
+	}
+
+	return secret.Value, nil
+}
+
+// renewTime returns when the lease of secret is renewed,
+// once two thirds of it have elapsed
+func renewTime(secret *Secret) time.Time {
+	return time.Now().Add(secret.Lease * 2 / 3)
+}
+
+// startSecretsRenewal starts renewing the leased secrets read by the node
+func (node *nodeImpl) startSecretsRenewal() {
+	if len(node.secretLeases) == 0 {
+		return
+	}
+
+	node.secretsRenewal = make(chan struct{})
+	node.secretsRenewalDone = make(chan struct{})
+	go node.secretsRenewer(node.secretsRenewal, node.secretsRenewalDone)
+}
+
+// closeSecretStore stops the renewal of the leases and closes the secret store
+func (node *nodeImpl) closeSecretStore() error {
+	if node.secretsRenewal != nil {
+		close(node.secretsRenewal)
+		<-node.secretsRenewalDone
+		node.secretsRenewal = nil
+	}
+	node.secretLeases = nil
+
+	if node.secrets == nil {
+		return nil
+	}
+	err := node.secrets.Close()
+	node.secrets = nil
+
+	return err
+}
+
+func (node *nodeImpl) secretsRenewer(stop, done chan struct{}) {
+	defer close(done)
+
+	for {
+		next := node.secretLeases[0].renewAt
+		for _, lease := range node.secretLeases[1:] {
+			if lease.renewAt.Before(next) {
+				next = lease.renewAt
+			}
+		}
+
+		timer := time.NewTimer(next.Sub(time.Now()))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		leases := node.secretLeases[:0]
+		for _, lease := range node.secretLeases {
+			if !time.Now().Before(lease.renewAt) && !node.renewSecretLease(lease) {
+				continue
+			}
+			leases = append(leases, lease)
+		}
+		node.secretLeases = leases
+		if len(leases) == 0 {
+			return
+		}
+	}
+}
+
+// renewSecretLease renews lease, reading the secret again if the store
+// does not renew it anymore. It returns false once the lease is not renewable.
+func (node *nodeImpl) renewSecretLease(lease *secretLease) bool {
+	secret, err := node.secrets.Renew(lease.secret)
+	if err != nil {
+		node.warning("Failed renewing lease on secret [%s], reading it again [%s].", lease.path, err)
+
+		if secret, err = node.secrets.Read(lease.path); err != nil {
+			node.error("Failed reading secret [%s] [%s].", lease.path, err)
+
+			// Try again once a tenth of the lease has elapsed
+			lease.renewAt = time.Now().Add(lease.secret.Lease / 10)
+			return true
+		}
+	}
+
+	node.debug("Renewed lease on secret [%s] for [%s].", lease.path, secret.Lease)
+
+	lease.secret = secret
+	lease.renewAt = renewTime(secret)
+
+	return secret.Lease > 0 && secret.Renewable
+}
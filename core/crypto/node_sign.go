@@ -17,26 +17,92 @@ limitations under the License.
 package crypto
 
 import (
-	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"encoding/asn1"
 	"math/big"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+const (
+	// SignatureSchemeECDSA is the default signature scheme of the enrollment key
+	SignatureSchemeECDSA = "ecdsa"
+
+	// SignatureSchemeEd25519 is the Ed25519 signature scheme. Clients
+	// using it cannot obtain TCerts, which derive from ECDSA keys.
+	SignatureSchemeEd25519 = "ed25519"
 )
 
+// generateEnrollmentKey generates the signing key of the enrollment
+// certificate for the configured signature scheme
+func (node *nodeImpl) generateEnrollmentKey() (interface{}, interface{}, error) {
+	if node.conf.getSignatureScheme() == SignatureSchemeEd25519 {
+		key, err := primitives.NewEd25519Key()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return key, key.Public(), nil
+	}
+
+	if name := node.conf.getSignatureCurve(); name != "" {
+		// Keys on a curve other than the one of the security level,
+		// e.g. secp256k1, are generated in software
+		curve, err := primitives.GetCurve(name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		key, err := primitives.NewECDSAKeyOnCurve(curve)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return key, &key.PublicKey, nil
+	}
+
+	key, err := node.generateECDSAKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, &key.PublicKey, nil
+}
+
 func (node *nodeImpl) sign(signKey interface{}, msg []byte) ([]byte, error) {
-	return primitives.ECDSASign(signKey, msg)
+	return node.csp.Sign(signKey, msg)
 }
 
 func (node *nodeImpl) signWithEnrollmentKey(msg []byte) ([]byte, error) {
-	return primitives.ECDSASign(node.enrollPrivKey, msg)
+	return node.csp.Sign(node.enrollSignKey, msg)
 }
 
 func (node *nodeImpl) ecdsaSignWithEnrollmentKey(msg []byte) (*big.Int, *big.Int, error) {
-	return primitives.ECDSASignDirect(node.enrollPrivKey, msg)
+	if _, ok := node.enrollSignKey.(*hsmPrivateKey); !ok {
+		if node.enrollPrivKey != nil {
+			return primitives.ECDSASignDirect(node.enrollPrivKey, msg)
+		}
+		return nil, nil, utils.ErrInvalidKey
+	}
+
+	// The enrollment key is held by an HSM, which signs even if the key
+	// was exported to derive the TCert keys
+	raw, err := node.csp.Sign(node.enrollSignKey, msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	signature := new(primitives.ECDSASignature)
+	if _, err := asn1.Unmarshal(raw, signature); err != nil {
+		return nil, nil, err
+	}
+
+	return signature.R, signature.S, nil
 }
 
 func (node *nodeImpl) verify(verKey interface{}, msg, signature []byte) (bool, error) {
-	return primitives.ECDSAVerify(verKey, msg, signature)
+	return node.csp.Verify(verKey, msg, signature)
 }
 
 func (node *nodeImpl) verifyWithEnrollmentCert(msg, signature []byte) (bool, error) {
-	return primitives.ECDSAVerify(node.enrollCert.PublicKey, msg, signature)
+	return node.csp.Verify(node.enrollCert.PublicKey, msg, signature)
 }
@@ -19,8 +19,6 @@ package crypto
 import (
 	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
 
-	"errors"
-	"github.com/hyperledger/fabric/core/crypto/utils"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 )
@@ -35,10 +33,9 @@ func (node *nodeImpl) retrieveTCACertsChain(userID string) error {
 	}
 	node.debug("TCA certificate [% x]", tcaCertRaw)
 
-	// TODO: Test TCA cert againt root CA
-	_, err = utils.DERToX509Certificate(tcaCertRaw)
+	chain, err := node.verifyCACertsChain(tcaCertRaw)
 	if err != nil {
-		node.error("Failed parsing TCA certificate [%s].", err.Error())
+		node.error("Failed verifying TCA certificate [%s].", err.Error())
 
 		return err
 	}
@@ -46,7 +43,7 @@ func (node *nodeImpl) retrieveTCACertsChain(userID string) error {
 	// Store TCA cert
 	node.debug("Storing TCA certificate for [%s]...", userID)
 
-	if err := node.ks.storeCert(node.conf.getTCACertsChainFilename(), tcaCertRaw); err != nil {
+	if err := node.storeCACertsChain(node.conf.getTCACertsChainFilename(), chain); err != nil {
 		node.error("Failed storing tca certificate [%s].", err.Error())
 		return err
 	}
@@ -58,21 +55,12 @@ func (node *nodeImpl) loadTCACertsChain() error {
 	// Load TCA certs chain
 	node.debug("Loading TCA certificates chain...")
 
-	cert, err := node.ks.loadCert(node.conf.getTCACertsChainFilename())
-	if err != nil {
+	if err := node.loadCACertsChain(node.conf.getTCACertsChainFilename(), node.tcaCertPool); err != nil {
 		node.error("Failed loading TCA certificates chain [%s].", err.Error())
 
 		return err
 	}
 
-	// Prepare ecaCertPool
-	ok := node.tcaCertPool.AppendCertsFromPEM(cert)
-	if !ok {
-		node.error("Failed appending TCA certificates chain.")
-
-		return errors.New("Failed appending TCA certificates chain.")
-	}
-
 	return nil
 }
 
@@ -82,6 +70,8 @@ func (node *nodeImpl) getTCAClient() (*grpc.ClientConn, membersrvc.TCAPClient, e
 	conn, err := node.getClientConn(node.conf.getTCAPAddr(), node.conf.getTCAServerName())
 	if err != nil {
 		node.error("Failed getting client connection: [%s]", err)
+
+		return nil, nil, err
 	}
 
 	client := membersrvc.NewTCAPClient(conn)
@@ -94,6 +84,9 @@ func (node *nodeImpl) getTCAClient() (*grpc.ClientConn, membersrvc.TCAPClient, e
 func (node *nodeImpl) callTCAReadCACertificate(ctx context.Context, opts ...grpc.CallOption) (*membersrvc.Cert, error) {
 	// Get a TCA Client
 	sock, tcaP, err := node.getTCAClient()
+	if err != nil {
+		return nil, err
+	}
 	defer sock.Close()
 
 	// Issue the request
@@ -107,6 +100,25 @@ func (node *nodeImpl) callTCAReadCACertificate(ctx context.Context, opts ...grpc
 	return cert, nil
 }
 
+func (node *nodeImpl) callTCAReadCRL(ctx context.Context, opts ...grpc.CallOption) (*membersrvc.CRL, error) {
+	// Get a TCA Client
+	sock, tcaP, err := node.getTCAClient()
+	if err != nil {
+		return nil, err
+	}
+	defer sock.Close()
+
+	// Issue the request
+	crl, err := tcaP.ReadCRL(ctx, &membersrvc.Empty{}, opts...)
+	if err != nil {
+		node.error("Failed requesting tca CRL [%s].", err.Error())
+
+		return nil, err
+	}
+
+	return crl, nil
+}
+
 func (node *nodeImpl) getTCACertificate() ([]byte, error) {
 	response, err := node.callTCAReadCACertificate(context.Background())
 	if err != nil {
@@ -0,0 +1,162 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+// TLSBindingMetadataKey is the gRPC metadata key a TLS binding is sent under
+const TLSBindingMetadataKey = "tls-binding"
+
+// tlsBinding binds a TLS certificate to the enrollment certificate ECert:
+// Signature is the signature, under the enrollment key, of the
+// TLS certificate prefixed by tlsBindingPrefix.
+type tlsBinding struct {
+	ECert     []byte
+	Signature []byte
+}
+
+var tlsBindingPrefix = []byte("TLS binding")
+
+func tlsBindingMessage(tlsCertRaw []byte) []byte {
+	return append(append([]byte{}, tlsBindingPrefix...), tlsCertRaw...)
+}
+
+// GetTLSBinding returns a binding between this node's TLS certificate
+// and its enrollment certificate, signed by the enrollment key
+func (node *nodeImpl) GetTLSBinding() ([]byte, error) {
+	if node.tlsCert == nil {
+		return nil, utils.ErrTLSCertificateMissing
+	}
+
+	signature, err := node.signWithEnrollmentKey(tlsBindingMessage(node.tlsCert.Raw))
+	if err != nil {
+		node.error("Failed signing TLS binding [%s].", err.Error())
+
+		return nil, err
+	}
+
+	return asn1.Marshal(tlsBinding{node.enrollCert.Raw, signature})
+}
+
+// tlsBindingCredentials attaches a TLS binding to every request
+type tlsBindingCredentials struct {
+	binding string
+}
+
+// NewTLSBindingCredentials returns per-RPC credentials sending the TLS
+// binding of node to the peers it connects to. They are meant to be passed
+// with grpc.WithPerRPCCredentials, along with the TLS certificate of node.
+func NewTLSBindingCredentials(node Node) (credentials.Credentials, error) {
+	binding, err := node.GetTLSBinding()
+	if err != nil {
+		return nil, err
+	}
+
+	return &tlsBindingCredentials{utils.EncodeBase64(binding)}, nil
+}
+
+func (c *tlsBindingCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{TLSBindingMetadataKey: c.binding}, nil
+}
+
+func (c *tlsBindingCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+// VerifyTLSBinding checks that the TLS certificate the remote party
+// presented on the connection of ctx is bound to a valid enrollment
+// certificate, and returns the latter. If the request carries no
+// binding and none is required, it returns nil and no error.
+func (peer *peerImpl) VerifyTLSBinding(ctx context.Context) (*x509.Certificate, error) {
+	var encoded []string
+	if md, ok := metadata.FromContext(ctx); ok {
+		encoded = md[TLSBindingMetadataKey]
+	}
+	if len(encoded) == 0 {
+		if peer.conf.isTLSBindingRequired() {
+			peer.error("TLS binding missing.")
+
+			return nil, utils.ErrInvalidTLSBinding
+		}
+
+		return nil, nil
+	}
+
+	authInfo, ok := credentials.FromContext(ctx)
+	if !ok {
+		return nil, utils.ErrInvalidTLSBinding
+	}
+	tlsInfo, ok := authInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		peer.error("TLS binding sent on a connection without client certificate.")
+
+		return nil, utils.ErrInvalidTLSBinding
+	}
+
+	raw, err := utils.DecodeBase64(encoded[0])
+	if err != nil {
+		return nil, utils.ErrInvalidTLSBinding
+	}
+
+	return peer.verifyTLSBinding(tlsInfo.State.PeerCertificates[0], raw)
+}
+
+func (peer *peerImpl) verifyTLSBinding(tlsCert *x509.Certificate, raw []byte) (*x509.Certificate, error) {
+	binding := new(tlsBinding)
+	if _, err := asn1.Unmarshal(raw, binding); err != nil {
+		peer.error("Failed unmarshalling TLS binding [%s].", err.Error())
+
+		return nil, utils.ErrInvalidTLSBinding
+	}
+
+	eCert, err := utils.DERToX509Certificate(binding.ECert)
+	if err != nil {
+		peer.error("Failed parsing enrollment certificate of TLS binding [%s].", err.Error())
+
+		return nil, utils.ErrInvalidTLSBinding
+	}
+	if _, err := peer.verifyCertificate(eCert, peer.ecaCertPool); err != nil {
+		peer.error("Failed verifying enrollment certificate of TLS binding [%s].", err.Error())
+
+		return nil, utils.ErrInvalidTLSBinding
+	}
+	if peer.isCertificateRevoked(eCert) {
+		peer.error("Enrollment certificate of TLS binding revoked [%s].", eCert.SerialNumber.String())
+
+		return nil, utils.ErrRevokedCertificate
+	}
+	if err := peer.checkMemberCertificateStatus(eCert); err != nil {
+		return nil, err
+	}
+
+	ok, err := peer.verify(eCert.PublicKey, tlsBindingMessage(tlsCert.Raw), binding.Signature)
+	if err != nil || !ok {
+		peer.error("Invalid signature of TLS binding.")
+
+		return nil, utils.ErrInvalidTLSBinding
+	}
+
+	return eCert, nil
+}
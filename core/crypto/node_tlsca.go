@@ -20,7 +20,6 @@ import (
 	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
 
 	"crypto/ecdsa"
-	"crypto/rand"
 	"crypto/x509"
 	"errors"
 	"google/protobuf"
@@ -46,7 +45,7 @@ func (node *nodeImpl) retrieveTLSCertificate(id, affiliation string) error {
 	node.debug("Storing TLS key and certificate for user [%s]...", id)
 
 	// Store tls key.
-	if err := node.ks.storePrivateKeyInClear(node.conf.getTLSKeyFilename(), key); err != nil {
+	if err := node.storePrivateKeyOfClass(HSMKeyTLS, node.conf.getTLSKeyFilename(), key); err != nil {
 		node.error("Failed storing tls key [id=%s]: %s", id, err)
 		return err
 	}
@@ -92,6 +91,12 @@ func (node *nodeImpl) loadTLSCACertsChain() error {
 			return errors.New("Failed appending TLSCA certificates chain.")
 		}
 
+		// The roots of a TLSCA rotation, old and new, are trusted
+		// while it overlaps
+		if err := node.loadTLSCARoots(); err != nil {
+			node.warning("Failed loading the roots of the TLSCA [%s].", err.Error())
+		}
+
 		node.debug("Loading TLSCA certificates chain...done")
 
 	} else {
@@ -101,10 +106,36 @@ func (node *nodeImpl) loadTLSCACertsChain() error {
 	return nil
 }
 
+func (node *nodeImpl) loadTLSCARoots() error {
+	node.debug("Loading TLSCA roots...")
+
+	conn, tlscaP, err := node.getTLSCAClient()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	roots, err := tlscaP.ReadCACertificates(context.Background(), &membersrvc.Empty{})
+	if err != nil {
+		return err
+	}
+	for _, root := range roots.Roots {
+		cert, err := utils.DERToX509Certificate(root.Cert.Cert)
+		if err != nil {
+			return err
+		}
+		node.tlsCertPool.AddCert(cert)
+	}
+
+	node.debug("Loading TLSCA roots...done")
+
+	return nil
+}
+
 func (node *nodeImpl) getTLSCertificateFromTLSCA(id, affiliation string) (interface{}, []byte, error) {
 	node.debug("getTLSCertificate...")
 
-	priv, err := primitives.NewECDSAKey()
+	priv, err := node.generateECDSAKey()
 
 	if err != nil {
 		node.error("Failed generating key: %s", err)
@@ -127,7 +158,7 @@ func (node *nodeImpl) getTLSCertificateFromTLSCA(id, affiliation string) (interf
 			Key:  pubraw,
 		}, Sig: nil}
 	rawreq, _ := proto.Marshal(req)
-	r, s, err := ecdsa.Sign(rand.Reader, priv, primitives.Hash(rawreq))
+	r, s, err := primitives.ECDSASignDirect(priv, rawreq)
 	if err != nil {
 		panic(err)
 	}
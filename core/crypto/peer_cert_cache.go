@@ -0,0 +1,127 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"container/list"
+	"crypto/x509"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// certCache is a LRU cache of the certificates whose chain has been
+// verified, keyed by the hash of their DER encoding. Entries expire with
+// their certificate. The zero value is an empty cache holding nothing.
+type certCache struct {
+	m       sync.Mutex
+	entries map[string]*list.Element
+	lru     list.List
+}
+
+type certCacheEntry struct {
+	key  string
+	cert *x509.Certificate
+}
+
+func (cache *certCache) get(raw []byte) (*x509.Certificate, bool) {
+	cache.m.Lock()
+	defer cache.m.Unlock()
+
+	key := string(primitives.Hash(raw))
+	element, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*certCacheEntry)
+	if time.Now().After(entry.cert.NotAfter) {
+		cache.lru.Remove(element)
+		delete(cache.entries, key)
+
+		return nil, false
+	}
+	cache.lru.MoveToFront(element)
+
+	return entry.cert, true
+}
+
+// put adds cert, evicting the least recently used certificates beyond size
+func (cache *certCache) put(cert *x509.Certificate, size int) {
+	cache.m.Lock()
+	defer cache.m.Unlock()
+
+	if size <= 0 {
+		return
+	}
+	if cache.entries == nil {
+		cache.entries = make(map[string]*list.Element)
+	}
+
+	key := string(primitives.Hash(cert.Raw))
+	if element, ok := cache.entries[key]; ok {
+		cache.lru.MoveToFront(element)
+		return
+	}
+	cache.entries[key] = cache.lru.PushFront(&certCacheEntry{key, cert})
+
+	for cache.lru.Len() > size {
+		oldest := cache.lru.Back()
+		cache.lru.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*certCacheEntry).key)
+	}
+}
+
+// purge empties the cache
+func (cache *certCache) purge() {
+	cache.m.Lock()
+	defer cache.m.Unlock()
+
+	cache.entries = nil
+	cache.lru.Init()
+}
+
+// getVerifiedCertificate parses the transaction certificate raw and verifies
+// its chain, unless it has been verified already
+func (peer *peerImpl) getVerifiedCertificate(raw []byte) (*x509.Certificate, error) {
+	if cert, ok := peer.certs.get(raw); ok {
+		return cert, nil
+	}
+
+	cert, err := utils.DERToX509Certificate(raw)
+	if err != nil {
+		peer.error("Failed unmarshalling cert [%s].", err.Error())
+		return nil, err
+	}
+
+	if err := peer.verifyCertificateChain(cert); err != nil {
+		peer.error("Failed verifying cert chain [%s].", err.Error())
+		return nil, utils.ErrInvalidTransactionCertificate
+	}
+
+	// Transactions are signed with the subject key of dual-key TCerts; their
+	// encryption key must be well formed and distinct from it
+	if _, err := utils.GetTCertEncryptionKey(cert); err != nil {
+		peer.error("Failed checking TCert encryption key [%s].", err.Error())
+		return nil, err
+	}
+
+	peer.certs.put(cert, peer.conf.getCertCacheSize())
+
+	return cert, nil
+}
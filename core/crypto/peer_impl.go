@@ -19,10 +19,12 @@ package crypto
 import (
 	"crypto/ecdsa"
 	"crypto/x509"
+	"encoding/asn1"
 	"fmt"
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/hyperledger/fabric/core/crypto/utils"
+	"github.com/hyperledger/fabric/events/producer"
 	obc "github.com/hyperledger/fabric/protos"
 	"sync"
 )
@@ -61,14 +63,26 @@ func (peer *peerImpl) TransactionPreValidation(tx *obc.Transaction) (*obc.Transa
 
 	if tx.Cert != nil && tx.Signature != nil {
 		// Verify the transaction
-		// 1. Unmarshal cert
-		cert, err := utils.DERToX509Certificate(tx.Cert)
+		// 1. Unmarshal cert and verify its chain
+		cert, err := peer.getVerifiedCertificate(tx.Cert)
 		if err != nil {
-			peer.error("TransactionPreExecution: failed unmarshalling cert [%s] [%s].", err.Error())
+			peer.error("TransactionPreValidation: failed verifying cert [%s].", err.Error())
 			return tx, err
 		}
 
-		// TODO: verify cert
+		// 2. Reject revoked certs, as listed by the CRLs or reported by the OCSP responder
+		if peer.isCertificateRevoked(cert) {
+			err = utils.ErrRevokedCertificate
+		} else {
+			err = peer.checkMemberCertificateStatus(cert)
+		}
+		if err == utils.ErrRevokedCertificate {
+			peer.error("TransactionPreValidation: certificate [%s] revoked.", cert.SerialNumber.String())
+			producer.Send(producer.CreateRevokedCertificateEvent(tx.Uuid, cert.SerialNumber.String()))
+		}
+		if err != nil {
+			return tx, err
+		}
 
 		// 3. Marshall tx without signature
 		signature := tx.Signature
@@ -80,7 +94,7 @@ func (peer *peerImpl) TransactionPreValidation(tx *obc.Transaction) (*obc.Transa
 		}
 		tx.Signature = signature
 
-		// 2. Verify signature
+		// 4. Verify signature
 		ok, err := peer.verify(cert.PublicKey, rawTx, tx.Signature)
 		if err != nil {
 			peer.error("TransactionPreExecution: failed marshaling tx [%s] [%s].", err.Error())
@@ -103,6 +117,39 @@ func (peer *peerImpl) TransactionPreValidation(tx *obc.Transaction) (*obc.Transa
 	return tx, nil
 }
 
+// TransactionsPreValidation verifies the transactions of a block as
+// TransactionPreValidation does, spreading them over a bounded pool
+// of workers. The i-th error is the outcome for the i-th transaction,
+// so that the result does not depend on the scheduling of the workers.
+func (peer *peerImpl) TransactionsPreValidation(txs []*obc.Transaction) []error {
+	errs := make([]error, len(txs))
+
+	workers := peer.conf.getValidationWorkers()
+	if workers > len(txs) {
+		workers = len(txs)
+	}
+
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range indices {
+				_, errs[i] = peer.TransactionPreValidation(txs[i])
+			}
+		}()
+	}
+	for i := range txs {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	return errs
+}
+
 // TransactionPreValidation verifies that the transaction is
 // well formed with the respect to the security layer
 // prescriptions (i.e. signature verification). If this is the case,
@@ -138,6 +185,10 @@ func (peer *peerImpl) Verify(vkID, signature, message []byte) error {
 		return err
 	}
 
+	if err := peer.checkMemberCertificateStatus(cert); err != nil {
+		return err
+	}
+
 	vk := cert.PublicKey.(*ecdsa.PublicKey)
 
 	ok, err := peer.verify(vk, message, signature)
@@ -156,6 +207,41 @@ func (peer *peerImpl) Verify(vkID, signature, message []byte) error {
 	return nil
 }
 
+// VerifyWithTCert checks that signature is a valid signature of message
+// under the transaction certificate tcertDER, issued by the TCA and not
+// revoked.
+func (peer *peerImpl) VerifyWithTCert(tcertDER, signature, message []byte) error {
+	if !peer.isInitialized {
+		return utils.ErrNotInitialized
+	}
+	if len(signature) == 0 {
+		return fmt.Errorf("Invalid signature. It is empty.")
+	}
+
+	cert, err := peer.getVerifiedCertificate(tcertDER)
+	if err != nil {
+		return err
+	}
+	if peer.isCertificateRevoked(cert) {
+		return utils.ErrRevokedCertificate
+	}
+	if err = peer.checkMemberCertificateStatus(cert); err != nil {
+		return err
+	}
+
+	ok, err := peer.verify(cert.PublicKey, message, signature)
+	if err != nil {
+		peer.error("Failed verifying signature under TCert [%s]: [%s]", cert.SerialNumber.String(), err)
+
+		return err
+	}
+	if !ok {
+		return utils.ErrInvalidSignature
+	}
+
+	return nil
+}
+
 func (peer *peerImpl) GetStateEncryptor(deployTx, invokeTx *obc.Transaction) (StateEncryptor, error) {
 	return nil, utils.ErrNotImplemented
 }
@@ -166,6 +252,22 @@ func (peer *peerImpl) GetTransactionBinding(tx *obc.Transaction) ([]byte, error)
 
 // Private methods
 
+// verifyCertificateChain verifies that cert has been issued by the TCA or,
+// for transactions signed with an enrollment certificate, by the ECA
+func (peer *peerImpl) verifyCertificateChain(cert *x509.Certificate) error {
+	// The critical extensions are meant for the owner of the certificate
+	for _, oid := range []asn1.ObjectIdentifier{utils.TCertEncTCertIndex, ECertSubjectRole} {
+		utils.GetCriticalExtension(cert, oid)
+	}
+
+	if _, err := peer.verifyCertificate(cert, peer.tcaCertPool); err == nil {
+		return nil
+	}
+	_, err := peer.verifyCertificate(cert, peer.ecaCertPool)
+
+	return err
+}
+
 func (peer *peerImpl) register(eType NodeType, name string, pwd []byte, enrollID, enrollPWD string) error {
 	if peer.isInitialized {
 		peer.error("Registering [%s]...done! Initialization already performed", enrollID)
@@ -17,20 +17,13 @@ limitations under the License.
 package crypto
 
 import (
-	"database/sql"
 	"fmt"
 	"github.com/hyperledger/fabric/core/crypto/utils"
 )
 
 func (peer *peerImpl) initKeyStore() error {
 	// create tables
-	peer.debug("Create Table [%s] if not exists", "Certificates")
-	if _, err := peer.ks.sqlDB.Exec("CREATE TABLE IF NOT EXISTS Certificates (id VARCHAR, certsign BLOB, certenc BLOB, PRIMARY KEY (id))"); err != nil {
-		peer.debug("Failed creating table [%s].", err.Error())
-		return err
-	}
-
-	return nil
+	return peer.ks.backend.initPeer()
 }
 
 func (ks *keyStore) GetSignEnrollmentCert(id []byte, certFetcher func(id []byte) ([]byte, []byte, error)) ([]byte, error) {
@@ -64,32 +57,10 @@ func (ks *keyStore) GetSignEnrollmentCert(id []byte, certFetcher func(id []byte)
 
 		// 2. Store
 		ks.node.debug("Store certificate...")
-		tx, err := ks.sqlDB.Begin()
-		if err != nil {
-			ks.node.error("Failed beginning transaction [%s].", err.Error())
-
-			return nil, err
-		}
-
 		ks.node.debug("Insert id [%s].", sid)
 		ks.node.debug("Insert cert [% x].", certSign)
 
-		_, err = tx.Exec("INSERT INTO Certificates (id, certsign, certenc) VALUES (?, ?, ?)", sid, certSign, certEnc)
-
-		if err != nil {
-			ks.node.error("Failed inserting cert [%s].", err.Error())
-
-			tx.Rollback()
-
-			return nil, err
-		}
-
-		err = tx.Commit()
-		if err != nil {
-			ks.node.error("Failed committing transaction [%s].", err.Error())
-
-			tx.Rollback()
-
+		if err = ks.backend.insertEnrollmentCert(sid, certSign, certEnc); err != nil {
 			return nil, err
 		}
 
@@ -111,16 +82,8 @@ func (ks *keyStore) GetSignEnrollmentCert(id []byte, certFetcher func(id []byte)
 func (ks *keyStore) selectSignEnrollmentCert(id string) ([]byte, []byte, error) {
 	ks.node.debug("Select Sign Enrollment Cert for id [%s]", id)
 
-	// Get the first row available
-	var cert []byte
-	row := ks.sqlDB.QueryRow("SELECT certsign FROM Certificates where id = ?", id)
-	err := row.Scan(&cert)
-
-	if err == sql.ErrNoRows {
-		return nil, nil, nil
-	} else if err != nil {
-		ks.node.error("Error during select [%s].", err.Error())
-
+	cert, err := ks.backend.selectEnrollmentCert(id)
+	if err != nil {
 		return nil, nil, err
 	}
 
@@ -0,0 +1,71 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	obc "github.com/hyperledger/fabric/protos"
+)
+
+// ConsumeTransactionNonce records the nonce of tx as used, and fails
+// with ErrNonceReplayed if it was already used by the same certificate.
+// It must be invoked once per transaction, in ledger order, so that all the
+// validators reject the same replayed transactions.
+func (peer *peerImpl) ConsumeTransactionNonce(tx *obc.Transaction) error {
+	if !peer.isInitialized {
+		return utils.ErrNotInitialized
+	}
+	if tx == nil {
+		return utils.ErrNilArgument
+	}
+	if len(tx.Nonce) != primitives.NonceSize {
+		peer.error("Invalid nonce for [%s].", tx.Uuid)
+
+		return utils.ErrInvalidNonce
+	}
+
+	if !peer.conf.isNonceReplayProtectionEnabled() {
+		return nil
+	}
+
+	// The nonce is bound to the certificate of tx so that a nonce
+	// can not be burned by transactions signed by others
+	digest, err := peer.GetTransactionBinding(tx)
+	if err != nil {
+		return err
+	}
+
+	return peer.ks.consumeNonce(digest, tx.Uuid)
+}
+
+func (ks *keyStore) consumeNonce(digest []byte, uuid string) error {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	used, found, err := ks.backend.selectPeerNonce(digest)
+	if err != nil {
+		return err
+	}
+	if found {
+		ks.node.warning("Nonce of [%s] already used by [%s].", uuid, used)
+
+		return utils.ErrNonceReplayed
+	}
+
+	return ks.backend.insertPeerNonce(digest, uuid)
+}
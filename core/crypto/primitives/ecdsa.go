@@ -18,6 +18,7 @@ package primitives
 
 import (
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"encoding/asn1"
 	"math/big"
@@ -33,11 +34,23 @@ func NewECDSAKey() (*ecdsa.PrivateKey, error) {
 	return ecdsa.GenerateKey(GetDefaultCurve(), rand.Reader)
 }
 
+// NewECDSAKeyOnCurve generates a new ECDSA Key on the given curve
+func NewECDSAKeyOnCurve(curve elliptic.Curve) (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(curve, rand.Reader)
+}
+
+func ecdsaSignDigest(key *ecdsa.PrivateKey, digest []byte) (*big.Int, *big.Int, error) {
+	if IsDeterministicECDSA() {
+		return ECDSASignRFC6979(key, digest, GetDefaultHash())
+	}
+
+	return ecdsa.Sign(rand.Reader, key, digest)
+}
+
 // ECDSASignDirect signs
 func ECDSASignDirect(signKey interface{}, msg []byte) (*big.Int, *big.Int, error) {
 	temp := signKey.(*ecdsa.PrivateKey)
-	h := Hash(msg)
-	r, s, err := ecdsa.Sign(rand.Reader, temp, h)
+	r, s, err := ecdsaSignDigest(temp, Hash(msg))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -48,8 +61,7 @@ func ECDSASignDirect(signKey interface{}, msg []byte) (*big.Int, *big.Int, error
 // ECDSASign signs
 func ECDSASign(signKey interface{}, msg []byte) ([]byte, error) {
 	temp := signKey.(*ecdsa.PrivateKey)
-	h := Hash(msg)
-	r, s, err := ecdsa.Sign(rand.Reader, temp, h)
+	r, s, err := ecdsaSignDigest(temp, Hash(msg))
 	if err != nil {
 		return nil, err
 	}
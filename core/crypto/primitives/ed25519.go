@@ -0,0 +1,50 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package primitives
+
+import (
+	"crypto/rand"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// NewEd25519Key generates a new Ed25519 Key
+func NewEd25519Key() (ed25519.PrivateKey, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+
+	return priv, err
+}
+
+// Ed25519Sign signs. Ed25519 hashes msg itself.
+func Ed25519Sign(signKey interface{}, msg []byte) ([]byte, error) {
+	temp, ok := signKey.(ed25519.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidSecretKeyType
+	}
+
+	return ed25519.Sign(temp, msg), nil
+}
+
+// Ed25519Verify verifies
+func Ed25519Verify(verKey interface{}, msg, signature []byte) (bool, error) {
+	temp, ok := verKey.(ed25519.PublicKey)
+	if !ok {
+		return false, ErrInvalidPublicKeyType
+	}
+
+	return ed25519.Verify(temp, msg, signature), nil
+}
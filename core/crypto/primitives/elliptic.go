@@ -18,6 +18,9 @@ package primitives
 
 import (
 	"crypto/elliptic"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives/secp256k1"
 )
 
 var (
@@ -28,3 +31,17 @@ var (
 func GetDefaultCurve() elliptic.Curve {
 	return defaultCurve
 }
+
+// GetCurve returns the elliptic curve with the given name. Besides the NIST
+// curves, secp256k1 is supported for enrollment and transaction certificates
+func GetCurve(name string) (elliptic.Curve, error) {
+	switch name {
+	case "P-256", "P256":
+		return elliptic.P256(), nil
+	case "P-384", "P384":
+		return elliptic.P384(), nil
+	case "secp256k1":
+		return secp256k1.Curve(), nil
+	}
+	return nil, fmt.Errorf("Elliptic curve not supported [%s]", name)
+}
@@ -0,0 +1,154 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package primitives
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"errors"
+	"hash"
+	"math/big"
+	"sync/atomic"
+)
+
+var deterministicECDSA int32
+
+// SetDeterministicECDSA switches ECDSA signing between random nonces and
+// the deterministic nonces of RFC 6979
+func SetDeterministicECDSA(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&deterministicECDSA, v)
+}
+
+// IsDeterministicECDSA returns true if ECDSA nonces are derived per RFC 6979
+func IsDeterministicECDSA() bool {
+	return atomic.LoadInt32(&deterministicECDSA) == 1
+}
+
+// ECDSASignRFC6979 signs digest with a nonce derived from the private key
+// and digest as in RFC 6979, using HMAC over hashFunc
+func ECDSASignRFC6979(priv *ecdsa.PrivateKey, digest []byte, hashFunc func() hash.Hash) (r, s *big.Int, err error) {
+	n := priv.Curve.Params().N
+	if n.Sign() == 0 {
+		return nil, nil, errors.New("Invalid curve order")
+	}
+
+	e := bits2int(digest, n)
+	nonces := newRFC6979Nonces(priv.D, digest, n, hashFunc)
+	for {
+		k := nonces.next()
+
+		x, _ := priv.Curve.ScalarBaseMult(k.Bytes())
+		r = new(big.Int).Mod(x, n)
+		if r.Sign() == 0 {
+			continue
+		}
+
+		// s = k^-1 (e + r d) mod n
+		s = new(big.Int).Mul(priv.D, r)
+		s.Add(s, e)
+		s.Mul(s, new(big.Int).ModInverse(k, n))
+		s.Mod(s, n)
+		if s.Sign() != 0 {
+			return r, s, nil
+		}
+	}
+}
+
+// rfc6979Nonces is the HMAC_DRBG of RFC 6979, section 3.2
+type rfc6979Nonces struct {
+	n        *big.Int
+	hashFunc func() hash.Hash
+	k, v     []byte
+	started  bool
+}
+
+func newRFC6979Nonces(x *big.Int, digest []byte, n *big.Int, hashFunc func() hash.Hash) *rfc6979Nonces {
+	size := hashFunc().Size()
+	g := &rfc6979Nonces{
+		n:        n,
+		hashFunc: hashFunc,
+		k:        make([]byte, size),
+		v:        make([]byte, size),
+	}
+	for i := range g.v {
+		g.v[i] = 0x01
+	}
+
+	seed := append(int2octets(x, n), bits2octets(digest, n)...)
+	g.k = g.mac(g.k, g.v, []byte{0x00}, seed)
+	g.v = g.mac(g.k, g.v)
+	g.k = g.mac(g.k, g.v, []byte{0x01}, seed)
+	g.v = g.mac(g.k, g.v)
+
+	return g
+}
+
+// next returns the next candidate nonce in [1, n-1]
+func (g *rfc6979Nonces) next() *big.Int {
+	for {
+		if g.started {
+			g.k = g.mac(g.k, g.v, []byte{0x00})
+			g.v = g.mac(g.k, g.v)
+		}
+		g.started = true
+
+		t := make([]byte, 0, (g.n.BitLen()+7)/8)
+		for len(t)*8 < g.n.BitLen() {
+			g.v = g.mac(g.k, g.v)
+			t = append(t, g.v...)
+		}
+
+		k := bits2int(t, g.n)
+		if k.Sign() > 0 && k.Cmp(g.n) < 0 {
+			return k
+		}
+	}
+}
+
+func (g *rfc6979Nonces) mac(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(g.hashFunc, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+// bits2int keeps the leftmost qlen bits of b
+func bits2int(b []byte, n *big.Int) *big.Int {
+	i := new(big.Int).SetBytes(b)
+	if excess := len(b)*8 - n.BitLen(); excess > 0 {
+		i.Rsh(i, uint(excess))
+	}
+	return i
+}
+
+func int2octets(i, n *big.Int) []byte {
+	raw := i.Bytes()
+	size := (n.BitLen() + 7) / 8
+	if len(raw) >= size {
+		return raw[len(raw)-size:]
+	}
+	return append(make([]byte, size-len(raw)), raw...)
+}
+
+func bits2octets(b []byte, n *big.Int) []byte {
+	return int2octets(new(big.Int).Mod(bits2int(b, n), n), n)
+}
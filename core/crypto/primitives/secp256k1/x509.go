@@ -0,0 +1,284 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secp256k1
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// crypto/x509 knows the NIST curves only, and Ed25519 from Go 1.13 only.
+// The functions below handle, in addition, the secp256k1 keys of enrollment
+// and transaction certificates and the Ed25519 keys of enrollment
+// certificates, as defined in RFC 8410.
+
+var (
+	oidPublicKeyECDSA   = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidPublicKeyEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+	// oidPublicKeyPlaceholder has the length of oidPublicKeyECDSA and is
+	// unknown to crypto/x509, which leaves keys carrying it unparsed
+	oidPublicKeyPlaceholder = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 127}
+)
+
+type pkixPublicKey struct {
+	Algo      pkix.AlgorithmIdentifier
+	BitString asn1.BitString
+}
+
+func isSecp256k1PublicKey(pub interface{}) bool {
+	key, ok := pub.(*ecdsa.PublicKey)
+	return ok && IsCurve(key.Curve)
+}
+
+// MarshalPKIXPublicKey serialises a public key to DER-encoded PKIX format
+func MarshalPKIXPublicKey(pub interface{}) ([]byte, error) {
+	if key, ok := pub.(ed25519.PublicKey); ok {
+		return marshalEd25519PublicKey(key)
+	}
+	if !isSecp256k1PublicKey(pub) {
+		return x509.MarshalPKIXPublicKey(pub)
+	}
+
+	key := pub.(*ecdsa.PublicKey)
+	params, err := asn1.Marshal(OIDNamedCurve)
+	if err != nil {
+		return nil, err
+	}
+	point := elliptic.Marshal(key.Curve, key.X, key.Y)
+
+	return asn1.Marshal(pkixPublicKey{
+		Algo:      pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyECDSA, Parameters: asn1.RawValue{FullBytes: params}},
+		BitString: asn1.BitString{Bytes: point, BitLength: 8 * len(point)},
+	})
+}
+
+func marshalEd25519PublicKey(key ed25519.PublicKey) ([]byte, error) {
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("Invalid Ed25519 public key length")
+	}
+
+	return asn1.Marshal(pkixPublicKey{
+		Algo:      pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyEd25519},
+		BitString: asn1.BitString{Bytes: key, BitLength: 8 * len(key)},
+	})
+}
+
+// ParsePKIXPublicKey parses a DER encoded public key
+func ParsePKIXPublicKey(der []byte) (interface{}, error) {
+	if key, err := parseEd25519PublicKey(der); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err == nil {
+		return key, nil
+	}
+
+	secp256k1Key, secp256k1Err := parseSecp256k1PublicKey(der)
+	if secp256k1Err != nil {
+		// Report the error of crypto/x509
+		return nil, err
+	}
+
+	return secp256k1Key, nil
+}
+
+func parseEd25519PublicKey(der []byte) (ed25519.PublicKey, error) {
+	var spki pkixPublicKey
+	if rest, err := asn1.Unmarshal(der, &spki); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, errors.New("Trailing data after public key")
+	}
+	if !spki.Algo.Algorithm.Equal(oidPublicKeyEd25519) {
+		return nil, errors.New("Not an Ed25519 public key")
+	}
+
+	key := spki.BitString.RightAlign()
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("Invalid Ed25519 public key length")
+	}
+
+	return ed25519.PublicKey(key), nil
+}
+
+func parseSecp256k1PublicKey(der []byte) (*ecdsa.PublicKey, error) {
+	var spki pkixPublicKey
+	if rest, err := asn1.Unmarshal(der, &spki); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, errors.New("Trailing data after public key")
+	}
+
+	var curve asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(spki.Algo.Parameters.FullBytes, &curve); err != nil {
+		return nil, err
+	}
+	if !spki.Algo.Algorithm.Equal(oidPublicKeyECDSA) && !spki.Algo.Algorithm.Equal(oidPublicKeyPlaceholder) {
+		return nil, errors.New("Not an ECDSA public key")
+	}
+	if !curve.Equal(OIDNamedCurve) {
+		return nil, errors.New("Not a secp256k1 public key")
+	}
+
+	x, y := elliptic.Unmarshal(Curve(), spki.BitString.RightAlign())
+	if x == nil {
+		return nil, errors.New("Invalid secp256k1 point")
+	}
+
+	return &ecdsa.PublicKey{Curve: Curve(), X: x, Y: y}, nil
+}
+
+// ParseCertificate parses a DER encoded certificate, whose key may
+// be a secp256k1 or an Ed25519 key
+func ParseCertificate(der []byte) (*x509.Certificate, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err == nil {
+		// crypto/x509 leaves the keys it does not know unparsed
+		if key, err := parseEd25519PublicKey(cert.RawSubjectPublicKeyInfo); err == nil {
+			cert.PublicKey = key
+		}
+
+		return cert, nil
+	}
+
+	// Hide the key from crypto/x509, swapping its algorithm for one
+	// of the same length, and parse it separately
+	ecdsaOID, _ := asn1.Marshal(oidPublicKeyECDSA)
+	curveOID, _ := asn1.Marshal(OIDNamedCurve)
+	i := bytes.Index(der, append(ecdsaOID, curveOID...))
+	if i < 0 {
+		return nil, err
+	}
+	placeholderOID, _ := asn1.Marshal(oidPublicKeyPlaceholder)
+	hidden := append([]byte{}, der...)
+	copy(hidden[i:], placeholderOID)
+
+	cert, err = x509.ParseCertificate(hidden)
+	if err != nil {
+		return nil, err
+	}
+
+	// Point the raw fields back to the original encoding
+	cert.Raw = der
+	tbs := bytes.Index(hidden, cert.RawTBSCertificate)
+	cert.RawTBSCertificate = der[tbs : tbs+len(cert.RawTBSCertificate)]
+	spki := bytes.Index(hidden, cert.RawSubjectPublicKeyInfo)
+	cert.RawSubjectPublicKeyInfo = der[spki : spki+len(cert.RawSubjectPublicKeyInfo)]
+
+	key, err := parseSecp256k1PublicKey(cert.RawSubjectPublicKeyInfo)
+	if err != nil {
+		return nil, err
+	}
+	cert.PublicKeyAlgorithm = x509.ECDSA
+	cert.PublicKey = key
+
+	return cert, nil
+}
+
+// CreateCertificate creates a new certificate as x509.CreateCertificate
+// does, pub being possibly a secp256k1 or an Ed25519 key. priv, the key
+// of the issuer, must be on a curve known to crypto/x509.
+func CreateCertificate(rand io.Reader, template, parent *x509.Certificate, pub, priv interface{}) ([]byte, error) {
+	if _, ok := pub.(ed25519.PublicKey); !ok && !isSecp256k1PublicKey(pub) {
+		return x509.CreateCertificate(rand, template, parent, pub, priv)
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("The issuer key does not implement crypto.Signer")
+	}
+
+	// Let crypto/x509 encode the certificate for a placeholder key,
+	// then replace the key and sign again
+	placeholder, err := ecdsa.GenerateKey(elliptic.P256(), rand)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := x509.CreateCertificate(rand, template, parent, &placeholder.PublicKey, priv)
+	if err != nil {
+		return nil, err
+	}
+	placeholderCert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	spki, err := MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	var tbs asn1.RawValue
+	if _, err := asn1.Unmarshal(placeholderCert.RawTBSCertificate, &tbs); err != nil {
+		return nil, err
+	}
+	tbsRaw, err := asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassUniversal,
+		Tag:        asn1.TagSequence,
+		IsCompound: true,
+		Bytes:      bytes.Replace(tbs.Bytes, placeholderCert.RawSubjectPublicKeyInfo, spki, 1),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var hash crypto.Hash
+	switch placeholderCert.SignatureAlgorithm {
+	case x509.ECDSAWithSHA256, x509.SHA256WithRSA:
+		hash = crypto.SHA256
+	case x509.ECDSAWithSHA384, x509.SHA384WithRSA:
+		hash = crypto.SHA384
+	case x509.ECDSAWithSHA512, x509.SHA512WithRSA:
+		hash = crypto.SHA512
+	default:
+		return nil, errors.New("Signature algorithm not supported")
+	}
+	h := hash.New()
+	h.Write(tbsRaw)
+	signature, err := signer.Sign(rand, h.Sum(nil), hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var certificate struct {
+		TBS                asn1.RawValue
+		SignatureAlgorithm asn1.RawValue
+		Signature          asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(raw, &certificate); err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(struct {
+		TBS                asn1.RawValue
+		SignatureAlgorithm asn1.RawValue
+		Signature          asn1.BitString
+	}{
+		asn1.RawValue{FullBytes: tbsRaw},
+		certificate.SignatureAlgorithm,
+		asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)},
+	})
+}
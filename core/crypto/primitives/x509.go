@@ -17,6 +17,7 @@ limitations under the License.
 package primitives
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/rsa"
@@ -25,7 +26,9 @@ import (
 	"encoding/asn1"
 	"encoding/pem"
 	"errors"
+	"github.com/hyperledger/fabric/core/crypto/primitives/secp256k1"
 	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/crypto/ed25519"
 	"math/big"
 	"net"
 	"time"
@@ -38,7 +41,7 @@ var (
 
 // DERToX509Certificate converts der to x509
 func DERToX509Certificate(asn1Data []byte) (*x509.Certificate, error) {
-	return x509.ParseCertificate(asn1Data)
+	return secp256k1.ParseCertificate(asn1Data)
 }
 
 // PEMtoCertificate converts pem to x509
@@ -52,7 +55,7 @@ func PEMtoCertificate(raw []byte) (*x509.Certificate, error) {
 		return nil, errors.New("Not a valid CERTIFICATE PEM block")
 	}
 
-	cert, err := x509.ParseCertificate(block.Bytes)
+	cert, err := secp256k1.ParseCertificate(block.Bytes)
 	if err != nil {
 		return nil, err
 	}
@@ -85,7 +88,7 @@ func PEMtoCertificateAndDER(raw []byte) (*x509.Certificate, []byte, error) {
 		return nil, nil, errors.New("Not a valid CERTIFICATE PEM block")
 	}
 
-	cert, err := x509.ParseCertificate(block.Bytes)
+	cert, err := secp256k1.ParseCertificate(block.Bytes)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -214,6 +217,14 @@ func CheckCertPKAgainstSK(x509Cert *x509.Certificate, privateKey interface{}) er
 		if pub.X.Cmp(priv.X) != 0 || pub.Y.Cmp(priv.Y) != 0 {
 			return errors.New("Private key does not match public key")
 		}
+	case ed25519.PublicKey:
+		priv, ok := privateKey.(ed25519.PrivateKey)
+		if !ok {
+			return errors.New("Private key type does not match public key type")
+		}
+		if !bytes.Equal(pub, priv.Public().(ed25519.PublicKey)) {
+			return errors.New("Private key does not match public key")
+		}
 	default:
 		return errors.New("Unknown public key algorithm")
 	}
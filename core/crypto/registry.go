@@ -0,0 +1,292 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// NodeReference describes a node held by the registries of the process
+type NodeReference struct {
+	Name string
+	Type NodeType
+	// References is the number of Init calls not balanced by a Close yet.
+	// A node with no references is idle, waiting for eviction.
+	References int64
+}
+
+var (
+	registryIdleTimeout     time.Duration
+	registryIdleTimeoutLock sync.RWMutex
+)
+
+// SetRegistryIdleTimeout sets how long a node no longer referenced is kept
+// initialized, so that a later Init reuses it. Zero, the default, closes a
+// node as soon as its last reference is released.
+func SetRegistryIdleTimeout(timeout time.Duration) {
+	registryIdleTimeoutLock.Lock()
+	defer registryIdleTimeoutLock.Unlock()
+
+	registryIdleTimeout = timeout
+}
+
+func getRegistryIdleTimeout() time.Duration {
+	registryIdleTimeoutLock.RLock()
+	defer registryIdleTimeoutLock.RUnlock()
+
+	return registryIdleTimeout
+}
+
+// ListNodes returns the nodes initialized by the process, sorted by type and name
+func ListNodes() []NodeReference {
+	var refs []NodeReference
+	for _, r := range []*registry{clientRegistry, peerRegistry, validatorRegistry, auditorRegistry} {
+		refs = append(refs, r.list()...)
+	}
+
+	return refs
+}
+
+// registryNode is a node managed by a registry
+type registryNode interface {
+	Node
+	close() error
+}
+
+type registryEntry struct {
+	node registryNode
+	refs int64
+
+	// eviction closes the entry once idle for the registry idle timeout
+	eviction *time.Timer
+}
+
+// registry keeps the nodes of a type initialized by the process, by name.
+// Operations on a name are serialized by a lock of their own, so that the
+// slow ones (enrollment, keystore opening) do not hold up the other names.
+type registry struct {
+	eType NodeType
+
+	mutex   sync.Mutex
+	entries map[string]*registryEntry
+	names   map[string]*sync.Mutex
+}
+
+var (
+	clientRegistry    = newRegistry(NodeClient)
+	peerRegistry      = newRegistry(NodePeer)
+	validatorRegistry = newRegistry(NodeValidator)
+	auditorRegistry   = newRegistry(NodeAuditor)
+)
+
+func newRegistry(eType NodeType) *registry {
+	return &registry{
+		eType:   eType,
+		entries: make(map[string]*registryEntry),
+		names:   make(map[string]*sync.Mutex),
+	}
+}
+
+func (r *registry) lockName(name string) func() {
+	r.mutex.Lock()
+	lock, ok := r.names[name]
+	if !ok {
+		lock = &sync.Mutex{}
+		r.names[name] = lock
+	}
+	r.mutex.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+func (r *registry) get(name string) (*registryEntry, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	entry, ok := r.entries[name]
+	return entry, ok
+}
+
+// register runs register unless a node named name is initialized already
+func (r *registry) register(name string, register func() error) error {
+	defer r.lockName(name)()
+
+	if _, ok := r.get(name); ok {
+		return nil
+	}
+
+	return register()
+}
+
+// exclusive runs fn unless a node named name is initialized, in
+// which case it fails with ErrAlreadyInitialized
+func (r *registry) exclusive(name string, fn func() error) error {
+	defer r.lockName(name)()
+
+	if _, ok := r.get(name); ok {
+		return utils.ErrAlreadyInitialized
+	}
+
+	return fn()
+}
+
+// acquire returns the node named name, taking a reference on it. If the
+// node is not initialized yet, it is created by init; otherwise, the node
+// is returned if shared is set, and ErrAlreadyInitialized is returned if not.
+func (r *registry) acquire(name string, shared bool, init func() (registryNode, error)) (registryNode, error) {
+	defer r.lockName(name)()
+
+	if entry, ok := r.get(name); ok {
+		if !shared {
+			return nil, utils.ErrAlreadyInitialized
+		}
+
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		if entry.eviction != nil {
+			entry.eviction.Stop()
+			entry.eviction = nil
+		}
+		entry.refs++
+		log.Debug("Node [%s] already initialized. References increased to [%d].", name, entry.refs)
+
+		return entry.node, nil
+	}
+
+	node, err := init()
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	r.entries[name] = &registryEntry{node: node, refs: 1}
+	r.mutex.Unlock()
+
+	return node, nil
+}
+
+// release releases a reference on node, closing it when no reference is
+// left, right away or once idle for the registry idle timeout. If force is
+// set, node is closed regardless of its references.
+func (r *registry) release(node Node, force bool) error {
+	if node == nil {
+		return utils.ErrNilArgument
+	}
+
+	name := node.GetName()
+	defer r.lockName(name)()
+
+	entry, ok := r.get(name)
+	if !ok || Node(entry.node) != node {
+		// node has been closed already, or it is not the instance
+		// initialized under name
+		return utils.ErrInvalidReference
+	}
+
+	r.mutex.Lock()
+	if !force && entry.refs == 0 {
+		// node has been released already and waits for eviction
+		r.mutex.Unlock()
+
+		return utils.ErrInvalidReference
+	}
+	if !force && entry.refs > 1 {
+		entry.refs--
+		log.Debug("Releasing node [%s]...decreased references at [%d].", name, entry.refs)
+		r.mutex.Unlock()
+
+		return nil
+	}
+	if timeout := getRegistryIdleTimeout(); !force && timeout > 0 && entry.refs == 1 {
+		entry.refs = 0
+		entry.eviction = time.AfterFunc(timeout, func() { r.evict(name, entry) })
+		log.Debug("Releasing node [%s]...idle, evicted in [%s].", name, timeout)
+		r.mutex.Unlock()
+
+		return nil
+	}
+	if entry.eviction != nil {
+		entry.eviction.Stop()
+	}
+	delete(r.entries, name)
+	r.mutex.Unlock()
+
+	err := entry.node.close()
+	log.Debug("Releasing node [%s]...closed [%s].", name, utils.ErrToString(err))
+
+	return err
+}
+
+// evict closes entry if it is still idle
+func (r *registry) evict(name string, entry *registryEntry) {
+	defer r.lockName(name)()
+
+	r.mutex.Lock()
+	if current, ok := r.entries[name]; !ok || current != entry || entry.refs != 0 {
+		r.mutex.Unlock()
+		return
+	}
+	delete(r.entries, name)
+	r.mutex.Unlock()
+
+	err := entry.node.close()
+	log.Debug("Evicting idle node [%s]...closed [%s].", name, utils.ErrToString(err))
+}
+
+// releaseAll closes all the nodes, regardless of their references
+func (r *registry) releaseAll() (bool, []error) {
+	r.mutex.Lock()
+	nodes := make([]registryNode, 0, len(r.entries))
+	for _, entry := range r.entries {
+		nodes = append(nodes, entry.node)
+	}
+	r.mutex.Unlock()
+
+	var errs []error
+	for _, node := range nodes {
+		if err := r.release(node, true); err != nil && err != utils.ErrInvalidReference {
+			errs = append(errs, err)
+		}
+	}
+
+	return len(errs) != 0, errs
+}
+
+func (r *registry) list() []NodeReference {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	refs := make([]NodeReference, 0, len(r.entries))
+	for name, entry := range r.entries {
+		refs = append(refs, NodeReference{Name: name, Type: r.eType, References: entry.refs})
+	}
+	sort.Sort(nodeReferencesByName(refs))
+
+	return refs
+}
+
+type nodeReferencesByName []NodeReference
+
+func (refs nodeReferencesByName) Len() int           { return len(refs) }
+func (refs nodeReferencesByName) Swap(i, j int)      { refs[i], refs[j] = refs[j], refs[i] }
+func (refs nodeReferencesByName) Less(i, j int) bool { return refs[i].Name < refs[j].Name }
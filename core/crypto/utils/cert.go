@@ -17,12 +17,17 @@ limitations under the License.
 package utils
 
 import (
+	"bytes"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/asn1"
 	"encoding/pem"
 	"errors"
+
+	"golang.org/x/crypto/ed25519"
+	"github.com/hyperledger/fabric/core/crypto/primitives/secp256k1"
 )
 
 var (
@@ -39,11 +44,20 @@ var (
 
 	// TCertAttributesHeaders is the ASN1 object identifier of attributes header.
 	TCertAttributesHeaders = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 9}
+
+	// TCertAttributesKeyID is the ASN1 object identifier of the id of the
+	// attribute master key the TCert extensions are encrypted under.
+	TCertAttributesKeyID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 7, 1}
+
+	// TCertEncryptionKey is the ASN1 object identifier of the encryption
+	// public key of dual-key TCerts. The subject key of these TCerts is
+	// used for signing only.
+	TCertEncryptionKey = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 7, 2}
 )
 
 // DERToX509Certificate converts der to x509
 func DERToX509Certificate(asn1Data []byte) (*x509.Certificate, error) {
-	return x509.ParseCertificate(asn1Data)
+	return secp256k1.ParseCertificate(asn1Data)
 }
 
 // PEMtoCertificate converts pem to x509
@@ -57,7 +71,7 @@ func PEMtoCertificate(raw []byte) (*x509.Certificate, error) {
 		return nil, errors.New("Not a valid CERTIFICATE PEM block")
 	}
 
-	cert, err := x509.ParseCertificate(block.Bytes)
+	cert, err := secp256k1.ParseCertificate(block.Bytes)
 	if err != nil {
 		return nil, err
 	}
@@ -90,7 +104,7 @@ func PEMtoCertificateAndDER(raw []byte) (*x509.Certificate, []byte, error) {
 		return nil, nil, errors.New("Not a valid CERTIFICATE PEM block")
 	}
 
-	cert, err := x509.ParseCertificate(block.Bytes)
+	cert, err := secp256k1.ParseCertificate(block.Bytes)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -98,6 +112,44 @@ func PEMtoCertificateAndDER(raw []byte) (*x509.Certificate, []byte, error) {
 	return cert, block.Bytes, nil
 }
 
+// PEMtoCertificates converts a pem bundle to the x509 certificates it contains, in order
+func PEMtoCertificates(raw []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, raw = pem.Decode(raw)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" || len(block.Headers) != 0 {
+			return nil, errors.New("Not a valid CERTIFICATE PEM block")
+		}
+
+		cert, err := secp256k1.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("No PEM block available")
+	}
+
+	return certs, nil
+}
+
+// DERCertsToPEM converts a list of der certificates to a pem bundle
+func DERCertsToPEM(ders [][]byte) []byte {
+	var bundle []byte
+	for _, der := range ders {
+		bundle = append(bundle, DERCertToPEM(der)...)
+	}
+
+	return bundle
+}
+
 // DERCertToPEM converts der to pem
 func DERCertToPEM(der []byte) []byte {
 	return pem.EncodeToMemory(
@@ -128,6 +180,33 @@ func GetCriticalExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier) ([]
 	return nil, errors.New("Failed retrieving extension.")
 }
 
+// GetTCertEncryptionKey returns the encryption public key of a dual-key
+// TCert, or nil if the TCert has a single key pair
+func GetTCertEncryptionKey(cert *x509.Certificate) (*ecdsa.PublicKey, error) {
+	for _, ext := range cert.Extensions {
+		if !IntArrayEquals(ext.Id, TCertEncryptionKey) {
+			continue
+		}
+
+		key, err := secp256k1.ParsePKIXPublicKey(ext.Value)
+		if err != nil {
+			return nil, ErrInvalidTCertEncryptionKey
+		}
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, ErrInvalidTCertEncryptionKey
+		}
+		// The encryption key must not be the signing key
+		if signPub, ok := cert.PublicKey.(*ecdsa.PublicKey); ok && signPub.X.Cmp(pub.X) == 0 && signPub.Y.Cmp(pub.Y) == 0 {
+			return nil, ErrInvalidTCertEncryptionKey
+		}
+
+		return pub, nil
+	}
+
+	return nil, nil
+}
+
 // GetExtension returns a requested extension.
 //func GetExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier) ([]byte, error) {
 //	for _, ext := range cert.Extensions {
@@ -151,12 +230,26 @@ func CheckCertPKAgainstSK(x509Cert *x509.Certificate, privateKey interface{}) er
 			return errors.New("Private key does not match public key")
 		}
 	case *ecdsa.PublicKey:
-		priv, ok := privateKey.(*ecdsa.PrivateKey)
+		var privPub *ecdsa.PublicKey
+		switch priv := privateKey.(type) {
+		case *ecdsa.PrivateKey:
+			privPub = &priv.PublicKey
+		case crypto.Signer:
+			// Keys held by an HSM expose their public part only
+			privPub, _ = priv.Public().(*ecdsa.PublicKey)
+		}
+		if privPub == nil {
+			return errors.New("Private key type does not match public key type")
+		}
+		if pub.X.Cmp(privPub.X) != 0 || pub.Y.Cmp(privPub.Y) != 0 {
+			return errors.New("Private key does not match public key")
+		}
+	case ed25519.PublicKey:
+		priv, ok := privateKey.(ed25519.PrivateKey)
 		if !ok {
 			return errors.New("Private key type does not match public key type")
-
 		}
-		if pub.X.Cmp(priv.X) != 0 || pub.Y.Cmp(priv.Y) != 0 {
+		if !bytes.Equal(pub, priv.Public().(ed25519.PublicKey)) {
 			return errors.New("Private key does not match public key")
 		}
 	default:
@@ -168,9 +261,16 @@ func CheckCertPKAgainstSK(x509Cert *x509.Certificate, privateKey interface{}) er
 
 // CheckCertAgainRoot check the validity of the passed certificate against the passed certPool
 func CheckCertAgainRoot(x509Cert *x509.Certificate, certPool *x509.CertPool) ([][]*x509.Certificate, error) {
+	return CheckCertChain(x509Cert, certPool, nil)
+}
+
+// CheckCertChain check the validity of the passed certificate against the passed roots,
+// building the chain through the passed intermediates
+func CheckCertChain(x509Cert *x509.Certificate, roots, intermediates *x509.CertPool) ([][]*x509.Certificate, error) {
 	opts := x509.VerifyOptions{
 		// TODO		DNSName: "test.example.com",
-		Roots: certPool,
+		Roots:         roots,
+		Intermediates: intermediates,
 	}
 
 	return x509Cert.Verify(opts)
@@ -188,3 +288,17 @@ func CheckCertAgainstSKAndRoot(x509Cert *x509.Certificate, privateKey interface{
 
 	return nil
 }
+
+// CheckCertAgainstSKAndChain checks the passed certificate against the passed secretkey,
+// roots and intermediates
+func CheckCertAgainstSKAndChain(x509Cert *x509.Certificate, privateKey interface{}, roots, intermediates *x509.CertPool) error {
+	if err := CheckCertPKAgainstSK(x509Cert, privateKey); err != nil {
+		return err
+	}
+
+	if _, err := CheckCertChain(x509Cert, roots, intermediates); err != nil {
+		return err
+	}
+
+	return nil
+}
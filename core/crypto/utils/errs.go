@@ -37,6 +37,15 @@ var (
 	// ErrInvalidTransactionSignature Invalid Transaction Signature
 	ErrInvalidTransactionSignature = errors.New("Invalid Transaction Signature.")
 
+	// ErrInvalidTransactionCertificate Invalid Transaction Certificate
+	ErrInvalidTransactionCertificate = errors.New("Invalid Transaction Certificate.")
+
+	// ErrRevokedCertificate The certificate has been revoked
+	ErrRevokedCertificate = errors.New("Certificate revoked.")
+
+	// ErrCertificateStatusUnknown The certificate status could not be established
+	ErrCertificateStatusUnknown = errors.New("Certificate status unknown.")
+
 	// ErrTransactionCertificate Missing Transaction Certificate
 	ErrTransactionCertificate = errors.New("Missing Transaction Certificate.")
 
@@ -61,6 +70,9 @@ var (
 	// ErrKeyStoreAlreadyInitialized Keystore already Initilized
 	ErrKeyStoreAlreadyInitialized = errors.New("Keystore already Initilized.")
 
+	// ErrInvalidPassphrase Invalid keystore passphrase
+	ErrInvalidPassphrase = errors.New("Invalid keystore passphrase.")
+
 	// ErrEncrypt Encryption failed
 	ErrEncrypt = errors.New("Encryption failed.")
 
@@ -84,6 +96,48 @@ var (
 
 	// ErrInvalidProtocolVersion Invalid protocol version
 	ErrInvalidProtocolVersion = errors.New("Invalid protocol version")
+
+	// ErrPoolExhausted No TCert available in the pool
+	ErrPoolExhausted = errors.New("TCert pool exhausted.")
+
+	// ErrTCAUnreachable The TCA could not be contacted
+	ErrTCAUnreachable = errors.New("TCA unreachable.")
+
+	// ErrPoolStopped The TCert pool has been stopped
+	ErrPoolStopped = errors.New("TCert pool stopped.")
+
+	// ErrAuditAccessDenied The chaincode is outside the auditor's scope
+	ErrAuditAccessDenied = errors.New("Chaincode not in audit scope.")
+
+	// ErrInvalidNonce Transaction nonce not well formed
+	ErrInvalidNonce = errors.New("Invalid transaction nonce.")
+
+	// ErrNonceReplayed Transaction nonce already used
+	ErrNonceReplayed = errors.New("Transaction nonce already used.")
+
+	// ErrTLSCertificateMissing No TLS certificate available to bind
+	ErrTLSCertificateMissing = errors.New("TLS certificate not available.")
+
+	// ErrInvalidTLSBinding The TLS binding is missing or does not match the connection
+	ErrInvalidTLSBinding = errors.New("Invalid TLS binding.")
+
+	// ErrInvalidTCertEncryptionKey The encryption key of a dual-key TCert is not well formed
+	ErrInvalidTCertEncryptionKey = errors.New("Invalid TCert encryption key.")
+
+	// ErrNoTCertEncryptionKey The TCert has no separate encryption key
+	ErrNoTCertEncryptionKey = errors.New("TCert without encryption key.")
+
+	// ErrEnrollmentLimitReached The registration allows no more enrollments
+	ErrEnrollmentLimitReached = errors.New("Maximum number of enrollments reached.")
+
+	// ErrRegistrationExpired The registration does not allow to enroll anymore
+	ErrRegistrationExpired = errors.New("Registration expired.")
+
+	// ErrRateLimited The Membership Service turned down the request of an identity sending too many
+	ErrRateLimited = errors.New("Too many requests to the Membership Service.")
+
+	// ErrECAUnavailable The ECA cannot serve requests
+	ErrECAUnavailable = errors.New("ECA unavailable.")
 )
 
 // ErrToString converts and error to a string. If the error is nil, it returns the string "<clean>"
@@ -21,8 +21,13 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/pem"
 	"errors"
+
+	"golang.org/x/crypto/ed25519"
+	"github.com/hyperledger/fabric/core/crypto/primitives/secp256k1"
 )
 
 // PrivateKeyToDER marshals a private key to der
@@ -50,6 +55,19 @@ func PrivateKeyToPEM(privateKey interface{}, pwd []byte) ([]byte, error) {
 				Bytes: raw,
 			},
 		), nil
+	case ed25519.PrivateKey:
+		raw, err := marshalEd25519PrivateKey(x)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return pem.EncodeToMemory(
+			&pem.Block{
+				Type:  "PRIVATE KEY",
+				Bytes: raw,
+			},
+		), nil
 	default:
 		return nil, ErrInvalidKey
 	}
@@ -78,11 +96,76 @@ func PrivateKeyToEncryptedPEM(privateKey interface{}, pwd []byte) ([]byte, error
 
 		return pem.EncodeToMemory(block), nil
 
+	case ed25519.PrivateKey:
+		raw, err := marshalEd25519PrivateKey(x)
+
+		if err != nil {
+			return nil, err
+		}
+
+		block, err := x509.EncryptPEMBlock(
+			rand.Reader,
+			"PRIVATE KEY",
+			raw,
+			pwd,
+			x509.PEMCipherAES256)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return pem.EncodeToMemory(block), nil
+
 	default:
 		return nil, ErrInvalidKey
 	}
 }
 
+// oidEd25519 identifies Ed25519 keys, see RFC 8410
+var oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+// pkcs8 is the PKCS#8 wrapping of a private key
+type pkcs8 struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// marshalEd25519PrivateKey marshals an Ed25519 private key to PKCS#8,
+// wrapping its seed as defined in RFC 8410
+func marshalEd25519PrivateKey(privateKey ed25519.PrivateKey) ([]byte, error) {
+	seed, err := asn1.Marshal(privateKey.Seed())
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(pkcs8{
+		Algo:       pkix.AlgorithmIdentifier{Algorithm: oidEd25519},
+		PrivateKey: seed,
+	})
+}
+
+// parseEd25519PrivateKey unmarshals an Ed25519 private key from PKCS#8
+func parseEd25519PrivateKey(der []byte) (ed25519.PrivateKey, error) {
+	var key pkcs8
+	if _, err := asn1.Unmarshal(der, &key); err != nil {
+		return nil, err
+	}
+	if !key.Algo.Algorithm.Equal(oidEd25519) {
+		return nil, errors.New("Not an Ed25519 private key")
+	}
+
+	var seed []byte
+	if _, err := asn1.Unmarshal(key.PrivateKey, &seed); err != nil {
+		return nil, err
+	}
+	if len(seed) != 32 {
+		return nil, errors.New("Invalid Ed25519 private key length")
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
 // DERToPrivateKey unmarshals a der to private key
 func DERToPrivateKey(der []byte) (key interface{}, err error) {
 	//fmt.Printf("DER [%s]\n", EncodeBase64(der))
@@ -91,6 +174,10 @@ func DERToPrivateKey(der []byte) (key interface{}, err error) {
 		return key, nil
 	}
 	//fmt.Printf("DERToPrivateKey Err [%s]\n", err)
+	if key, err = parseEd25519PrivateKey(der); err == nil {
+		return
+	}
+	//fmt.Printf("DERToPrivateKey Err [%s]\n", err)
 	if key, err = x509.ParsePKCS8PrivateKey(der); err == nil {
 		switch key.(type) {
 		case *rsa.PrivateKey, *ecdsa.PrivateKey:
@@ -188,7 +275,7 @@ func PublicKeyToDER(publicKey interface{}) ([]byte, error) {
 }
 
 func DERToPublicKey(derBytes []byte) (pub interface{}, err error) {
-	key, err := x509.ParsePKIXPublicKey(derBytes)
+	key, err := ParsePKIXPublicKey(derBytes)
 
 	return key, err
 }
@@ -202,7 +289,7 @@ func PublicKeyToPEM(publicKey interface{}, pwd []byte) ([]byte, error) {
 
 	switch x := publicKey.(type) {
 	case *ecdsa.PublicKey:
-		PubASN1, err := x509.MarshalPKIXPublicKey(x)
+		PubASN1, err := secp256k1.MarshalPKIXPublicKey(x)
 		if err != nil {
 			return nil, err
 		}
@@ -223,7 +310,7 @@ func PublicKeyToPEM(publicKey interface{}, pwd []byte) ([]byte, error) {
 func PublicKeyToEncryptedPEM(publicKey interface{}, pwd []byte) ([]byte, error) {
 	switch x := publicKey.(type) {
 	case *ecdsa.PublicKey:
-		raw, err := x509.MarshalPKIXPublicKey(x)
+		raw, err := secp256k1.MarshalPKIXPublicKey(x)
 
 		if err != nil {
 			return nil, err
@@ -278,7 +365,7 @@ func PEMtoPublicKey(raw []byte, pwd []byte) (interface{}, error) {
 
 // DERToPublicKey unmarshals a der to public key
 func DERToPublicKey(derBytes []byte) (pub interface{}, err error) {
-	key, err := x509.ParsePKIXPublicKey(derBytes)
+	key, err := secp256k1.ParsePKIXPublicKey(derBytes)
 
 	return key, err
 }
@@ -21,48 +21,30 @@ import (
 	"sync"
 )
 
-// Private type and variables
-
-type validatorEntry struct {
-	validator Peer
-	counter   int64
-}
-
-var (
-	// Map of initialized validators
-	validators = make(map[string]validatorEntry)
-
-	// Sync
-	mutex sync.Mutex
-)
-
 // Public Methods
 
 // RegisterValidator registers a validator to the PKI infrastructure
 func RegisterValidator(name string, pwd []byte, enrollID, enrollPWD string) error {
-	mutex.Lock()
-	defer mutex.Unlock()
-
 	log.Info("Registering validator [%s] with name [%s]...", enrollID, name)
 
-	if _, ok := validators[name]; ok {
-		log.Info("Registering validator [%s] with name [%s]...done. Already initialized.", enrollID, name)
+	err := validatorRegistry.register(name, func() error {
+		validator := newValidator()
+		if err := validator.register(name, pwd, enrollID, enrollPWD); err != nil {
+			if err != utils.ErrAlreadyRegistered && err != utils.ErrAlreadyInitialized {
+				log.Error("Failed registering validator [%s] with name [%s] [%s].", enrollID, name, err)
+				return err
+			}
+			log.Info("Registering validator [%s] with name [%s]...done. Already registered or initiliazed.", enrollID, name)
+		}
+		if err := validator.close(); err != nil {
+			// It is not necessary to report this error to the caller
+			log.Warning("Registering validator [%s] with name [%s]. Failed closing [%s].", enrollID, name, err)
+		}
 
 		return nil
-	}
-
-	validator := newValidator()
-	if err := validator.register(name, pwd, enrollID, enrollPWD); err != nil {
-		if err != utils.ErrAlreadyRegistered && err != utils.ErrAlreadyInitialized {
-			log.Error("Failed registering validator [%s] with name [%s] [%s].", enrollID, name, err)
-			return err
-		}
-		log.Info("Registering vlidator [%s] with name [%s]...done. Already registered or initiliazed.", enrollID, name)
-	}
-	err := validator.close()
+	})
 	if err != nil {
-		// It is not necessary to report this error to the caller
-		log.Warning("Registering validator [%s] with name [%s]. Failed closing [%s].", enrollID, name, err)
+		return err
 	}
 
 	log.Info("Registering validator [%s] with name [%s]...done!", enrollID, name)
@@ -70,59 +52,43 @@ func RegisterValidator(name string, pwd []byte, enrollID, enrollPWD string) erro
 	return nil
 }
 
-// InitValidator initializes a validator named name with password pwd
+// InitValidator initializes a validator named name with password pwd. Every call
+// must be balanced by a call to CloseValidator.
 func InitValidator(name string, pwd []byte) (Peer, error) {
-	mutex.Lock()
-	defer mutex.Unlock()
-
 	log.Info("Initializing validator [%s]...", name)
 
-	if entry, ok := validators[name]; ok {
-		log.Info("Validator already initiliazied [%s]. Increasing counter from [%d]", name, validators[name].counter)
-		entry.counter++
-		validators[name] = entry
-
-		return validators[name].validator, nil
-	}
+	node, err := validatorRegistry.acquire(name, true, func() (registryNode, error) {
+		validator := newValidator()
+		if err := validator.init(name, pwd); err != nil {
+			log.Error("Failed validator initialization [%s]: [%s].", name, err)
 
-	validator := newValidator()
-	if err := validator.init(name, pwd); err != nil {
-		log.Error("Failed validator initialization [%s]: [%s]", name, err)
+			return nil, err
+		}
 
+		return validator, nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	validators[name] = validatorEntry{validator, 1}
 	log.Info("Initializing validator [%s]...done!", name)
 
-	return validator, nil
+	return node.(Peer), nil
 }
 
-// CloseValidator releases all the resources allocated by the validator
-func CloseValidator(peer Peer) error {
-	mutex.Lock()
-	defer mutex.Unlock()
-
-	return closeValidatorInternal(peer, false)
+// CloseValidator releases a reference on validator, obtained by InitValidator. The resources
+// allocated by validator are released with the last reference.
+func CloseValidator(validator Peer) error {
+	return validatorRegistry.release(validator, false)
 }
 
 // CloseAllValidators closes all the validators initialized so far
 func CloseAllValidators() (bool, []error) {
-	mutex.Lock()
-	defer mutex.Unlock()
-
 	log.Info("Closing all validators...")
-
-	errs := make([]error, len(validators))
-	for _, value := range validators {
-		err := closeValidatorInternal(value.validator, true)
-
-		errs = append(errs, err)
-	}
-
+	failed, errs := validatorRegistry.releaseAll()
 	log.Info("Closing all validators...done!")
 
-	return len(errs) != 0, errs
+	return failed, errs
 }
 
 // Private Methods
@@ -130,30 +96,3 @@ func CloseAllValidators() (bool, []error) {
 func newValidator() *validatorImpl {
 	return &validatorImpl{&peerImpl{&nodeImpl{}, sync.RWMutex{}, nil, false}, false, nil}
 }
-
-func closeValidatorInternal(peer Peer, force bool) error {
-	if peer == nil {
-		return utils.ErrNilArgument
-	}
-
-	name := peer.GetName()
-	log.Info("Closing validator [%s]...", name)
-	entry, ok := validators[name]
-	if !ok {
-		return utils.ErrInvalidReference
-	}
-	if entry.counter == 1 || force {
-		defer delete(validators, name)
-		err := validators[name].validator.(*validatorImpl).close()
-		log.Info("Closing validator [%s]...done! [%s].", name, utils.ErrToString(err))
-
-		return err
-	}
-
-	// decrease counter
-	entry.counter--
-	validators[name] = entry
-	log.Info("Closing validator [%s]...decreased counter at [%d].", name, validators[name].counter)
-
-	return nil
-}
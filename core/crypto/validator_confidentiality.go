@@ -50,6 +50,8 @@ func (validator *validatorImpl) deepCloneAndDecryptTx(tx *obc.Transaction) (*obc
 		return validator.deepCloneAndDecryptTx1_1(tx)
 	case "1.2":
 		return validator.deepCloneAndDecryptTx1_2(tx)
+	case "1.3":
+		return validator.deepCloneAndDecryptTx1_3(tx)
 	}
 	return nil, utils.ErrInvalidProtocolVersion
 }
@@ -80,7 +82,7 @@ func (validator *validatorImpl) deepCloneAndDecryptTx1_1(tx *obc.Transaction) (*
 
 	// Decrypt Payload
 	payloadKey := primitives.HMACAESTruncated(key, []byte{1})
-	payload, err := primitives.CBCPKCS7Decrypt(payloadKey, utils.Clone(clone.Payload))
+	payload, err := validator.csp.Decrypt(payloadKey, utils.Clone(clone.Payload))
 	if err != nil {
 		validator.error("Failed decrypting payload [%s].", err.Error())
 		return nil, err
@@ -89,7 +91,7 @@ func (validator *validatorImpl) deepCloneAndDecryptTx1_1(tx *obc.Transaction) (*
 
 	// Decrypt ChaincodeID
 	chaincodeIDKey := primitives.HMACAESTruncated(key, []byte{2})
-	chaincodeID, err := primitives.CBCPKCS7Decrypt(chaincodeIDKey, utils.Clone(clone.ChaincodeID))
+	chaincodeID, err := validator.csp.Decrypt(chaincodeIDKey, utils.Clone(clone.ChaincodeID))
 	if err != nil {
 		validator.error("Failed decrypting chaincode [%s].", err.Error())
 		return nil, err
@@ -99,7 +101,7 @@ func (validator *validatorImpl) deepCloneAndDecryptTx1_1(tx *obc.Transaction) (*
 	// Decrypt metadata
 	if len(clone.Metadata) != 0 {
 		metadataKey := primitives.HMACAESTruncated(key, []byte{3})
-		metadata, err := primitives.CBCPKCS7Decrypt(metadataKey, utils.Clone(clone.Metadata))
+		metadata, err := validator.csp.Decrypt(metadataKey, utils.Clone(clone.Metadata))
 		if err != nil {
 			validator.error("Failed decrypting metadata [%s].", err.Error())
 			return nil, err
@@ -190,3 +192,78 @@ func (validator *validatorImpl) deepCloneAndDecryptTx1_2(tx *obc.Transaction) (*
 
 	return clone, nil
 }
+
+func (validator *validatorImpl) deepCloneAndDecryptTx1_3(tx *obc.Transaction) (*obc.Transaction, error) {
+	if tx.Nonce == nil || len(tx.Nonce) == 0 {
+		return nil, errors.New("Failed decrypting payload. Invalid nonce.")
+	}
+
+	// clone tx
+	clone, err := validator.deepCloneTransaction(tx)
+	if err != nil {
+		validator.error("Failed deep cloning [%s].", err.Error())
+		return nil, err
+	}
+
+	validator.debug("Transaction type [%s].", tx.Type.String())
+
+	validator.debug("Extract payload key...")
+
+	msgToValidators, err := validator.getMessageToValidators1_3(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	validator.debug("Extract payload key...done")
+
+	// Decrypt Payload
+	payload, err := primitives.GCMDecrypt(msgToValidators.PayloadKey, clone.Payload, confidentialityAD1_3(clone.Nonce, 2))
+	if err != nil {
+		validator.error("Failed decrypting payload [%s].", err.Error())
+		return nil, utils.ErrDecrypt
+	}
+	clone.Payload = payload
+
+	// Decrypt ChaincodeID
+	chaincodeID, err := primitives.GCMDecrypt(msgToValidators.PayloadKey, clone.ChaincodeID, confidentialityAD1_3(clone.Nonce, 1))
+	if err != nil {
+		validator.error("Failed decrypting chaincode [%s].", err.Error())
+		return nil, utils.ErrDecrypt
+	}
+	clone.ChaincodeID = chaincodeID
+
+	// Decrypt metadata
+	if len(clone.Metadata) != 0 {
+		metadata, err := primitives.GCMDecrypt(msgToValidators.PayloadKey, clone.Metadata, confidentialityAD1_3(clone.Nonce, 3))
+		if err != nil {
+			validator.error("Failed decrypting metadata [%s].", err.Error())
+			return nil, utils.ErrDecrypt
+		}
+		clone.Metadata = metadata
+	}
+
+	return clone, nil
+}
+
+func (validator *validatorImpl) getMessageToValidators1_3(tx *obc.Transaction) (*chainCodeValidatorMessage1_3, error) {
+	cipher, err := validator.eciesSPI.NewAsymmetricCipherFromPrivateKey(validator.chainPrivateKey)
+	if err != nil {
+		validator.error("Failed init decryption engine [%s].", err.Error())
+		return nil, err
+	}
+
+	msgToValidatorsRaw, err := cipher.Process(tx.ToValidators)
+	if err != nil {
+		validator.error("Failed decrypting message to validators [% x]: [%s].", tx.ToValidators, err.Error())
+		return nil, err
+	}
+
+	msgToValidators := new(chainCodeValidatorMessage1_3)
+	_, err = asn1.Unmarshal(msgToValidatorsRaw, msgToValidators)
+	if err != nil {
+		validator.error("Failed unmarshalling message to validators [%s].", err.Error())
+		return nil, err
+	}
+
+	return msgToValidators, nil
+}
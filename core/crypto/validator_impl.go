@@ -47,6 +47,21 @@ func (validator *validatorImpl) TransactionPreValidation(tx *obc.Transaction) (*
 	return validator.peerImpl.TransactionPreValidation(tx)
 }
 
+// TransactionsPreValidation verifies the transactions of a block as
+// TransactionPreValidation does, concurrently.
+func (validator *validatorImpl) TransactionsPreValidation(txs []*obc.Transaction) []error {
+	if !validator.isInitialized {
+		errs := make([]error, len(txs))
+		for i := range errs {
+			errs[i] = utils.ErrNotInitialized
+		}
+
+		return errs
+	}
+
+	return validator.peerImpl.TransactionsPreValidation(txs)
+}
+
 // TransactionPreValidation verifies that the transaction is
 // well formed with the respect to the security layer
 // prescriptions (i.e. signature verification). If this is the case,
@@ -16,16 +16,12 @@ limitations under the License.
 
 package crypto
 
-import (
-	"github.com/hyperledger/fabric/core/crypto/primitives"
-)
-
 func (validator *validatorImpl) sign(signKey interface{}, msg []byte) ([]byte, error) {
-	sigma, err := primitives.ECDSASign(signKey, msg)
+	sigma, err := validator.csp.Sign(signKey, msg)
 
 	return sigma, err
 }
 
 func (validator *validatorImpl) verify(verKey interface{}, msg, signature []byte) (bool, error) {
-	return primitives.ECDSAVerify(verKey, msg, signature)
+	return validator.csp.Verify(verKey, msg, signature)
 }
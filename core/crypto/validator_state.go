@@ -33,7 +33,8 @@ func (validator *validatorImpl) GetStateEncryptor(deployTx, executeTx *obc.Trans
 	switch executeTx.ConfidentialityProtocolVersion {
 	case "1.1":
 		return validator.getStateEncryptor1_1(deployTx, executeTx)
-	case "1.2":
+	case "1.2", "1.3":
+		// 1.3 differs from 1.2 in the encryption of the transaction only
 		return validator.getStateEncryptor1_2(deployTx, executeTx)
 	}
 
@@ -182,6 +183,15 @@ func (validator *validatorImpl) getStateEncryptor1_2(deployTx, executeTx *obc.Tr
 }
 
 func (validator *validatorImpl) getStateKeyFromTransaction(tx *obc.Transaction) ([]byte, error) {
+	if tx.ConfidentialityProtocolVersion == "1.3" {
+		msgToValidators, err := validator.getMessageToValidators1_3(tx)
+		if err != nil {
+			return nil, err
+		}
+
+		return msgToValidators.StateKey, nil
+	}
+
 	cipher, err := validator.eciesSPI.NewAsymmetricCipherFromPrivateKey(validator.chainPrivateKey)
 	if err != nil {
 		validator.error("Failed init decryption engine [%s].", err.Error())
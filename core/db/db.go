@@ -22,44 +22,60 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
 
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
-	"github.com/tecbot/gorocksdb"
 )
 
 var dbLogger = logging.MustGetLogger("db")
 
-const blockchainCF = "blockchainCF"
-const stateCF = "stateCF"
-const stateDeltaCF = "stateDeltaCF"
-const indexesCF = "indexesCF"
-const persistCF = "persistCF"
+const blockchainCF ColumnFamily = "blockchainCF"
+const stateCF ColumnFamily = "stateCF"
+const stateDeltaCF ColumnFamily = "stateDeltaCF"
+const indexesCF ColumnFamily = "indexesCF"
+const persistCF ColumnFamily = "persistCF"
+const historyCF ColumnFamily = "historyCF"
+const stateIndexCF ColumnFamily = "stateIndexCF"
 
-var columnfamilies = []string{
+var columnfamilies = []ColumnFamily{
 	blockchainCF, // blocks of the block chain
 	stateCF,      // world state
 	stateDeltaCF, // open transaction state
 	indexesCF,    // tx uuid -> blockno
 	persistCF,    // persistent per-peer state (consensus)
+	historyCF,    // chaincode key -> modifications by block and tx
+	stateIndexCF, // chaincode index field value key -> nil
 }
 
-// OpenchainDB encapsulates rocksdb's structures
+// OpenchainDB encapsulates the column families of the store holding the DB
 type OpenchainDB struct {
-	DB           *gorocksdb.DB
-	BlockchainCF *gorocksdb.ColumnFamilyHandle
-	StateCF      *gorocksdb.ColumnFamilyHandle
-	StateDeltaCF *gorocksdb.ColumnFamilyHandle
-	IndexesCF    *gorocksdb.ColumnFamilyHandle
-	PersistCF    *gorocksdb.ColumnFamilyHandle
+	store        Store
+	BlockchainCF ColumnFamily
+	StateCF      ColumnFamily
+	StateDeltaCF ColumnFamily
+	IndexesCF    ColumnFamily
+	PersistCF    ColumnFamily
+	HistoryCF    ColumnFamily
+	StateIndexCF ColumnFamily
+
+	// chain of the DB, empty for the default chain
+	chainID string
 }
 
 var openchainDB *OpenchainDB
 var isOpen bool
 
-// CreateDB creates a rocks db database
+// chainDBs holds the open DBs of the chains other than the default chain
+var chainDBs = make(map[string]*OpenchainDB)
+var chainDBsLock sync.Mutex
+
+// CreateDB creates a database with the store configured in 'peer.db.backend'
 func CreateDB() error {
-	dbPath := getDBPath()
+	return createDB(getDBPath())
+}
+
+func createDB(dbPath string) error {
 	dbLogger.Debug("Creating DB at [%s]", dbPath)
 	missing, err := dirMissingOrEmpty(dbPath)
 	if err != nil {
@@ -74,16 +90,14 @@ func CreateDB() error {
 		dbLogger.Error("Error calling  os.MkdirAll for directory path [%s]: %s", dbPath, err)
 		return fmt.Errorf("Error making directory path [%s]: %s", dbPath, err)
 	}
-	opts := gorocksdb.NewDefaultOptions()
-	defer opts.Destroy()
-	opts.SetCreateIfMissing(true)
-
-	db, err := gorocksdb.OpenDb(opts, dbPath)
+	backend, err := getBackend()
+	if err != nil {
+		return err
+	}
+	err = backend.create(dbPath)
 	if err != nil {
 		return err
 	}
-
-	defer db.Close()
 
 	dbLogger.Debug("DB created at [%s]", dbPath)
 	return nil
@@ -96,7 +110,7 @@ func GetDBHandle() *OpenchainDB {
 		return openchainDB
 	}
 
-	err = createDBIfDBPathEmpty()
+	err = createDBIfDBPathEmpty(getDBPath())
 	if err != nil {
 		panic(fmt.Sprintf("Error while trying to create DB: %s", err))
 	}
@@ -108,13 +122,39 @@ func GetDBHandle() *OpenchainDB {
 	return openchainDB
 }
 
+// GetChainDBHandle returns a handle to the OpenchainDB of a chain, stored
+// apart from the DBs of the other chains in 'peer.fileSystemPath'/chains/<chainID>/db.
+// The empty chainID is the default chain, whose DB is the one of GetDBHandle
+func GetChainDBHandle(chainID string) *OpenchainDB {
+	if chainID == "" {
+		return GetDBHandle()
+	}
+	chainDBsLock.Lock()
+	defer chainDBsLock.Unlock()
+	if chainDB, ok := chainDBs[chainID]; ok {
+		return chainDB
+	}
+
+	dbPath := getChainDBPath(chainID)
+	err := createDBIfDBPathEmpty(dbPath)
+	if err != nil {
+		panic(fmt.Sprintf("Error while trying to create DB of chain [%s]: %s", chainID, err))
+	}
+	chainDB, err := openDBAt(dbPath, chainID)
+	if err != nil {
+		panic(fmt.Sprintf("Could not open db of chain [%s] error = [%s]", chainID, err))
+	}
+	chainDBs[chainID] = chainDB
+	return chainDB
+}
+
 // GetFromBlockchainCF get value for given key from column family - blockchainCF
 func (openchainDB *OpenchainDB) GetFromBlockchainCF(key []byte) ([]byte, error) {
 	return openchainDB.Get(openchainDB.BlockchainCF, key)
 }
 
 // GetFromBlockchainCFSnapshot get value for given key from column family in a DB snapshot - blockchainCF
-func (openchainDB *OpenchainDB) GetFromBlockchainCFSnapshot(snapshot *gorocksdb.Snapshot, key []byte) ([]byte, error) {
+func (openchainDB *OpenchainDB) GetFromBlockchainCFSnapshot(snapshot Snapshot, key []byte) ([]byte, error) {
 	return openchainDB.getFromSnapshot(snapshot, openchainDB.BlockchainCF, key)
 }
 
@@ -123,42 +163,52 @@ func (openchainDB *OpenchainDB) GetFromStateCF(key []byte) ([]byte, error) {
 	return openchainDB.Get(openchainDB.StateCF, key)
 }
 
+// GetFromStateCFSnapshot get value for given key from column family in a DB snapshot - stateCF
+func (openchainDB *OpenchainDB) GetFromStateCFSnapshot(snapshot Snapshot, key []byte) ([]byte, error) {
+	return openchainDB.getFromSnapshot(snapshot, openchainDB.StateCF, key)
+}
+
 // GetFromStateDeltaCF get value for given key from column family - stateDeltaCF
 func (openchainDB *OpenchainDB) GetFromStateDeltaCF(key []byte) ([]byte, error) {
 	return openchainDB.Get(openchainDB.StateDeltaCF, key)
 }
 
+// GetFromStateDeltaCFSnapshot get value for given key from column family in a DB snapshot - stateDeltaCF
+func (openchainDB *OpenchainDB) GetFromStateDeltaCFSnapshot(snapshot Snapshot, key []byte) ([]byte, error) {
+	return openchainDB.getFromSnapshot(snapshot, openchainDB.StateDeltaCF, key)
+}
+
 // GetFromIndexesCF get value for given key from column family - indexCF
 func (openchainDB *OpenchainDB) GetFromIndexesCF(key []byte) ([]byte, error) {
 	return openchainDB.Get(openchainDB.IndexesCF, key)
 }
 
 // GetBlockchainCFIterator get iterator for column family - blockchainCF
-func (openchainDB *OpenchainDB) GetBlockchainCFIterator() *gorocksdb.Iterator {
+func (openchainDB *OpenchainDB) GetBlockchainCFIterator() Iterator {
 	return openchainDB.GetIterator(openchainDB.BlockchainCF)
 }
 
 // GetStateCFIterator get iterator for column family - stateCF
-func (openchainDB *OpenchainDB) GetStateCFIterator() *gorocksdb.Iterator {
+func (openchainDB *OpenchainDB) GetStateCFIterator() Iterator {
 	return openchainDB.GetIterator(openchainDB.StateCF)
 }
 
 // GetStateCFSnapshotIterator get iterator for column family - stateCF. This iterator
 // is based on a snapshot and should be used for long running scans, such as
 // reading the entire state. Remember to call iterator.Close() when you are done.
-func (openchainDB *OpenchainDB) GetStateCFSnapshotIterator(snapshot *gorocksdb.Snapshot) *gorocksdb.Iterator {
-	return openchainDB.getSnapshotIterator(snapshot, openchainDB.StateCF)
+func (openchainDB *OpenchainDB) GetStateCFSnapshotIterator(snapshot Snapshot) Iterator {
+	return snapshot.NewIterator(openchainDB.StateCF)
 }
 
 // GetStateDeltaCFIterator get iterator for column family - stateDeltaCF
-func (openchainDB *OpenchainDB) GetStateDeltaCFIterator() *gorocksdb.Iterator {
+func (openchainDB *OpenchainDB) GetStateDeltaCFIterator() Iterator {
 	return openchainDB.GetIterator(openchainDB.StateDeltaCF)
 }
 
 // GetSnapshot returns a point-in-time view of the DB. You MUST call snapshot.Release()
 // when you are done with the snapshot.
-func (openchainDB *OpenchainDB) GetSnapshot() *gorocksdb.Snapshot {
-	return openchainDB.DB.NewSnapshot()
+func (openchainDB *OpenchainDB) GetSnapshot() Snapshot {
+	return openchainDB.store.NewSnapshot()
 }
 
 func getDBPath() string {
@@ -172,15 +222,18 @@ func getDBPath() string {
 	return dbPath + "db"
 }
 
-func createDBIfDBPathEmpty() error {
-	dbPath := getDBPath()
+func getChainDBPath(chainID string) string {
+	return path.Join(path.Dir(getDBPath()), "chains", chainID, "db")
+}
+
+func createDBIfDBPathEmpty(dbPath string) error {
 	missing, err := dirMissingOrEmpty(dbPath)
 	if err != nil {
 		return err
 	}
 	dbLogger.Debug("Is db path [%s] empty [%t]", dbPath, missing)
 	if missing {
-		err := CreateDB()
+		err := createDB(dbPath)
 		if err != nil {
 			return nil
 		}
@@ -193,93 +246,108 @@ func openDB() (*OpenchainDB, error) {
 		return openchainDB, nil
 	}
 
-	dbPath := getDBPath()
-	opts := gorocksdb.NewDefaultOptions()
-	defer opts.Destroy()
-
-	opts.SetCreateIfMissing(false)
-	opts.SetCreateIfMissingColumnFamilies(true)
-
-	cfNames := []string{"default"}
-	cfNames = append(cfNames, columnfamilies...)
-	var cfOpts []*gorocksdb.Options
-	for range cfNames {
-		cfOpts = append(cfOpts, opts)
+	db, err := openDBAt(getDBPath(), "")
+	if err != nil {
+		return nil, err
 	}
+	isOpen = true
+	return db, nil
+}
 
-	db, cfHandlers, err := gorocksdb.OpenDbColumnFamilies(opts, dbPath, cfNames, cfOpts)
-
+func openDBAt(dbPath string, chainID string) (*OpenchainDB, error) {
+	backend, err := getBackend()
+	if err != nil {
+		return nil, err
+	}
+	store, err := backend.open(dbPath, columnfamilies)
 	if err != nil {
 		fmt.Println("Error opening DB", err)
 		return nil, err
 	}
-	isOpen = true
-	// XXX should we close cfHandlers[0]?
-	return &OpenchainDB{db, cfHandlers[1], cfHandlers[2], cfHandlers[3], cfHandlers[4], cfHandlers[5]}, nil
+	return &OpenchainDB{store, blockchainCF, stateCF, stateDeltaCF, indexesCF, persistCF, historyCF, stateIndexCF, chainID}, nil
 }
 
-// CloseDB releases all column family handles and closes rocksdb
+// CloseDB closes the store holding the DB
 func (openchainDB *OpenchainDB) CloseDB() {
-	openchainDB.BlockchainCF.Destroy()
-	openchainDB.StateCF.Destroy()
-	openchainDB.StateDeltaCF.Destroy()
-	openchainDB.IndexesCF.Destroy()
-	openchainDB.PersistCF.Destroy()
-	openchainDB.DB.Close()
+	openchainDB.store.Close()
+	if openchainDB.chainID != "" {
+		chainDBsLock.Lock()
+		delete(chainDBs, openchainDB.chainID)
+		chainDBsLock.Unlock()
+		return
+	}
 	isOpen = false
 }
 
+// ChainID returns the chain of the DB, empty for the default chain
+func (openchainDB *OpenchainDB) ChainID() string {
+	return openchainDB.chainID
+}
+
 // DeleteState delets ALL state keys/values from the DB. This is generally
 // only used during state synchronization when creating a new state from
 // a snapshot.
 func (openchainDB *OpenchainDB) DeleteState() error {
-	err := openchainDB.DB.DropColumnFamily(openchainDB.StateCF)
+	err := openchainDB.store.DropColumnFamily(openchainDB.StateCF)
 	if err != nil {
 		dbLogger.Error("Error dropping state CF", err)
 		return err
 	}
-	err = openchainDB.DB.DropColumnFamily(openchainDB.StateDeltaCF)
+	err = openchainDB.store.DropColumnFamily(openchainDB.StateDeltaCF)
 	if err != nil {
 		dbLogger.Error("Error dropping state delta CF", err)
 		return err
 	}
-	opts := gorocksdb.NewDefaultOptions()
-	defer opts.Destroy()
-	openchainDB.StateCF, err = openchainDB.DB.CreateColumnFamily(opts, stateCF)
-	if err != nil {
-		dbLogger.Error("Error creating state CF", err)
-		return err
-	}
-	openchainDB.StateDeltaCF, err = openchainDB.DB.CreateColumnFamily(opts, stateDeltaCF)
+	return nil
+}
+
+// DropColumnFamily deletes all the keys of the given column family
+func (openchainDB *OpenchainDB) DropColumnFamily(cf ColumnFamily) error {
+	err := openchainDB.store.DropColumnFamily(cf)
 	if err != nil {
-		dbLogger.Error("Error creating state delta CF", err)
+		dbLogger.Error("Error dropping CF %s: %s", cf, err)
 		return err
 	}
 	return nil
 }
 
+// Compact compacts all the column families, reclaiming the disk space of
+// the keys deleted
+func (openchainDB *OpenchainDB) Compact() {
+	for _, cf := range []ColumnFamily{openchainDB.BlockchainCF, openchainDB.StateCF,
+		openchainDB.StateDeltaCF, openchainDB.IndexesCF, openchainDB.PersistCF, openchainDB.HistoryCF,
+		openchainDB.StateIndexCF} {
+		openchainDB.store.Compact(cf)
+	}
+}
+
+// GetStats returns the statistics of each column family, as reported by the
+// store
+func (openchainDB *OpenchainDB) GetStats() ([]*ColumnFamilyStats, error) {
+	var stats []*ColumnFamilyStats
+	for _, cf := range columnfamilies {
+		cfStats, err := openchainDB.store.Stats(cf)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, cfStats)
+	}
+	return stats, nil
+}
+
 // Get returns the valud for the given column family and key
-func (openchainDB *OpenchainDB) Get(cfHandler *gorocksdb.ColumnFamilyHandle, key []byte) ([]byte, error) {
-	opt := gorocksdb.NewDefaultReadOptions()
-	defer opt.Destroy()
-	slice, err := openchainDB.DB.GetCF(opt, cfHandler, key)
+func (openchainDB *OpenchainDB) Get(cf ColumnFamily, key []byte) ([]byte, error) {
+	value, err := openchainDB.store.Get(cf, key)
 	if err != nil {
 		fmt.Println("Error while trying to retrieve key:", key)
 		return nil, err
 	}
-	defer slice.Free()
-	if slice.Data() == nil {
-		return nil, nil
-	}
-	data := makeCopy(slice.Data())
-	return data, nil
+	return value, nil
 }
 
 // Put saves the key/value in the given column family
-func (openchainDB *OpenchainDB) Put(cfHandler *gorocksdb.ColumnFamilyHandle, key []byte, value []byte) error {
-	opt := gorocksdb.NewDefaultWriteOptions()
-	defer opt.Destroy()
-	err := openchainDB.DB.PutCF(opt, cfHandler, key, value)
+func (openchainDB *OpenchainDB) Put(cf ColumnFamily, key []byte, value []byte) error {
+	err := openchainDB.store.Put(cf, key, value)
 	if err != nil {
 		fmt.Println("Error while trying to write key:", key)
 		return err
@@ -288,10 +356,8 @@ func (openchainDB *OpenchainDB) Put(cfHandler *gorocksdb.ColumnFamilyHandle, key
 }
 
 // Delete delets the given key in the specified column family
-func (openchainDB *OpenchainDB) Delete(cfHandler *gorocksdb.ColumnFamilyHandle, key []byte) error {
-	opt := gorocksdb.NewDefaultWriteOptions()
-	defer opt.Destroy()
-	err := openchainDB.DB.DeleteCF(opt, cfHandler, key)
+func (openchainDB *OpenchainDB) Delete(cf ColumnFamily, key []byte) error {
+	err := openchainDB.store.Delete(cf, key)
 	if err != nil {
 		fmt.Println("Error while trying to delete key:", key)
 		return err
@@ -299,34 +365,23 @@ func (openchainDB *OpenchainDB) Delete(cfHandler *gorocksdb.ColumnFamilyHandle,
 	return nil
 }
 
-func (openchainDB *OpenchainDB) getFromSnapshot(snapshot *gorocksdb.Snapshot, cfHandler *gorocksdb.ColumnFamilyHandle, key []byte) ([]byte, error) {
-	opt := gorocksdb.NewDefaultReadOptions()
-	defer opt.Destroy()
-	opt.SetSnapshot(snapshot)
-	slice, err := openchainDB.DB.GetCF(opt, cfHandler, key)
+// Write applies all the writes of the batch atomically
+func (openchainDB *OpenchainDB) Write(writeBatch *WriteBatch) error {
+	return openchainDB.store.Write(writeBatch)
+}
+
+func (openchainDB *OpenchainDB) getFromSnapshot(snapshot Snapshot, cf ColumnFamily, key []byte) ([]byte, error) {
+	value, err := snapshot.Get(cf, key)
 	if err != nil {
 		fmt.Println("Error while trying to retrieve key:", key)
 		return nil, err
 	}
-	defer slice.Free()
-	data := append([]byte(nil), slice.Data()...)
-	return data, nil
+	return value, nil
 }
 
 // GetIterator returns an iterator for the given column family
-func (openchainDB *OpenchainDB) GetIterator(cfHandler *gorocksdb.ColumnFamilyHandle) *gorocksdb.Iterator {
-	opt := gorocksdb.NewDefaultReadOptions()
-	opt.SetFillCache(true)
-	defer opt.Destroy()
-	return openchainDB.DB.NewIteratorCF(opt, cfHandler)
-}
-
-func (openchainDB *OpenchainDB) getSnapshotIterator(snapshot *gorocksdb.Snapshot, cfHandler *gorocksdb.ColumnFamilyHandle) *gorocksdb.Iterator {
-	opt := gorocksdb.NewDefaultReadOptions()
-	defer opt.Destroy()
-	opt.SetSnapshot(snapshot)
-	iter := openchainDB.DB.NewIteratorCF(opt, cfHandler)
-	return iter
+func (openchainDB *OpenchainDB) GetIterator(cf ColumnFamily) Iterator {
+	return openchainDB.store.NewIterator(cf)
 }
 
 func dirMissingOrEmpty(path string) (bool, error) {
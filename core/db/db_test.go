@@ -20,10 +20,10 @@ import (
 	"bytes"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/spf13/viper"
-	"github.com/tecbot/gorocksdb"
 )
 
 func TestMain(m *testing.M) {
@@ -83,7 +83,7 @@ func TestDBColumnUpgrade(t *testing.T) {
 	db.CloseDB()
 
 	oldcfs := columnfamilies
-	columnfamilies = append([]string{"Testing"}, columnfamilies...)
+	columnfamilies = append([]ColumnFamily{"Testing"}, columnfamilies...)
 	defer func() {
 		columnfamilies = oldcfs
 	}()
@@ -94,6 +94,147 @@ func TestDBColumnUpgrade(t *testing.T) {
 	db.CloseDB()
 }
 
+func TestBackends(t *testing.T) {
+	defer viper.Set("peer.db.backend", "")
+	for _, backend := range backendNames() {
+		viper.Set("peer.db.backend", backend)
+		deleteTestDBPath()
+		performBasicReadWrite(t)
+		performIterationAndSnapshot(t)
+		if _, err := GetDBHandle().GetStats(); err != nil {
+			t.Fatalf("Error getting the stats of backend %s: %s", backend, err)
+		}
+		deleteTestDB()
+	}
+}
+
+func TestUnknownBackend(t *testing.T) {
+	defer viper.Set("peer.db.backend", "")
+	viper.Set("peer.db.backend", "unknown")
+	deleteTestDBPath()
+	if err := CreateDB(); err == nil {
+		t.Fatal("Created a DB with an unknown backend")
+	}
+	deleteTestDBPath()
+}
+
+func TestStats(t *testing.T) {
+	defer viper.Set("peer.db.backend", "")
+	viper.Set("peer.db.backend", "memory")
+	deleteTestDBPath()
+	defer deleteTestDB()
+	performBasicReadWrite(t)
+
+	stats, err := GetDBHandle().GetStats()
+	if err != nil {
+		t.Fatalf("Error getting the stats: %s", err)
+	}
+	if len(stats) != len(columnfamilies) {
+		t.Fatalf("Got the stats of %d column families, expected %d", len(stats), len(columnfamilies))
+	}
+	for _, cfStats := range stats {
+		expected := ColumnFamilyStats{Name: cfStats.Name}
+		if cfStats.Name == blockchainCF {
+			expected.Keys = 1
+			expected.MemorySize = uint64(len("dummyKey") + len("dummyValue"))
+		}
+		if *cfStats != expected {
+			t.Fatalf("Got stats %+v, expected %+v", *cfStats, expected)
+		}
+	}
+}
+
+func TestChainDBs(t *testing.T) {
+	deleteTestDBPath()
+	defer deleteTestDB()
+	performBasicReadWrite(t)
+
+	chainDB := GetChainDBHandle("chain1")
+	if GetChainDBHandle("chain1") != chainDB {
+		t.Fatal("Got another handle to the DB of chain1")
+	}
+	if GetChainDBHandle("") != GetDBHandle() {
+		t.Fatal("The DB of the empty chain is not the default DB")
+	}
+	if chainDB.ChainID() != "chain1" || GetDBHandle().ChainID() != "" {
+		t.Fatalf("Got chains [%s] and [%s], expected chain1 and the default chain", chainDB.ChainID(), GetDBHandle().ChainID())
+	}
+	value, err := chainDB.GetFromBlockchainCF([]byte("dummyKey"))
+	if err != nil {
+		t.Fatalf("read error = [%s]", err)
+	}
+	if value != nil {
+		t.Fatalf("The key written to the default DB is in the DB of chain1: [%s]", value)
+	}
+	if err = chainDB.Put(chainDB.BlockchainCF, []byte("chainKey"), []byte("chainValue")); err != nil {
+		t.Fatalf("write error = [%s]", err)
+	}
+	if value, _ = GetDBHandle().GetFromBlockchainCF([]byte("chainKey")); value != nil {
+		t.Fatalf("The key written to the DB of chain1 is in the default DB: [%s]", value)
+	}
+	if missing, _ := dirMissingOrEmpty(getChainDBPath("chain1")); missing {
+		t.Fatalf("The DB of chain1 is not stored in [%s]", getChainDBPath("chain1"))
+	}
+
+	// reopening finds the values written before closing
+	chainDB.CloseDB()
+	chainDB = GetChainDBHandle("chain1")
+	defer chainDB.CloseDB()
+	if value, _ = chainDB.GetFromBlockchainCF([]byte("chainKey")); string(value) != "chainValue" {
+		t.Fatalf("Got [%s] from the reopened DB of chain1, expected chainValue", value)
+	}
+}
+
+func performIterationAndSnapshot(t *testing.T) {
+	openchainDB := GetDBHandle()
+	writeBatch := NewWriteBatch()
+	for _, key := range []string{"b", "a", "c"} {
+		writeBatch.PutCF(openchainDB.StateCF, []byte(key), []byte("value-"+key))
+	}
+	writeBatch.PutCF(openchainDB.StateDeltaCF, []byte("d"), []byte("value-d"))
+	if err := openchainDB.Write(writeBatch); err != nil {
+		t.Fatalf("Error while writing to db: %s", err)
+	}
+
+	snapshot := openchainDB.GetSnapshot()
+	defer snapshot.Release()
+	if err := openchainDB.Delete(openchainDB.StateCF, []byte("a")); err != nil {
+		t.Fatalf("Error while deleting from db: %s", err)
+	}
+	if value, _ := openchainDB.GetFromStateCF([]byte("a")); value != nil {
+		t.Fatalf("Deleted key still has value %q", value)
+	}
+	if value, _ := openchainDB.getFromSnapshot(snapshot, openchainDB.StateCF, []byte("a")); string(value) != "value-a" {
+		t.Fatalf("Snapshot has value %q, expected value-a", value)
+	}
+
+	expectKeys := func(itr Iterator, expected ...string) {
+		defer itr.Close()
+		var keys []string
+		for itr.SeekToFirst(); itr.Valid(); itr.Next() {
+			if string(itr.Value()) != "value-"+string(itr.Key()) {
+				t.Fatalf("Key %q has value %q", itr.Key(), itr.Value())
+			}
+			keys = append(keys, string(itr.Key()))
+		}
+		if !reflect.DeepEqual(keys, expected) {
+			t.Fatalf("Iterated over keys %v, expected %v", keys, expected)
+		}
+	}
+	expectKeys(openchainDB.GetStateCFIterator(), "b", "c")
+	expectKeys(openchainDB.GetStateCFSnapshotIterator(snapshot), "a", "b", "c")
+	expectKeys(openchainDB.GetStateDeltaCFIterator(), "d")
+
+	if err := openchainDB.DeleteState(); err != nil {
+		t.Fatalf("Error while deleting state: %s", err)
+	}
+	expectKeys(openchainDB.GetStateCFIterator())
+	expectKeys(openchainDB.GetStateDeltaCFIterator())
+	if value, _ := openchainDB.GetFromBlockchainCF([]byte("dummyKey")); string(value) != "dummyValue" {
+		t.Fatal("Deleting the state deleted other column families")
+	}
+}
+
 // db helper functions
 func createTestDBPath() {
 	dbPath := viper.GetString("peer.fileSystemPath")
@@ -130,12 +271,10 @@ func setupTestConfig() {
 
 func performBasicReadWrite(t *testing.T) {
 	openchainDB := GetDBHandle()
-	opt := gorocksdb.NewDefaultWriteOptions()
-	defer opt.Destroy()
-	writeBatch := gorocksdb.NewWriteBatch()
+	writeBatch := NewWriteBatch()
 	defer writeBatch.Destroy()
 	writeBatch.PutCF(openchainDB.BlockchainCF, []byte("dummyKey"), []byte("dummyValue"))
-	err := openchainDB.DB.Write(opt, writeBatch)
+	err := openchainDB.Write(writeBatch)
 	if err != nil {
 		t.Fatal("Error while writing to db")
 	}
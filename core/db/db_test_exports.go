@@ -21,7 +21,6 @@ import (
 	"testing"
 
 	"github.com/spf13/viper"
-	"github.com/tecbot/gorocksdb"
 )
 
 // TestDBWrapper wraps the db. Can be used by other modules for testing
@@ -43,6 +42,7 @@ func (testDB *TestDBWrapper) CreateFreshDB(t testing.TB) {
 	// cleaning up test db here so that each test does not have to call it explicitly
 	// at the end of the test
 	testDB.cleanup()
+	closeChainDBs()
 	testDB.removeDBPath()
 	t.Logf("Creating testDB")
 	err := CreateDB()
@@ -59,16 +59,27 @@ func (testDB *TestDBWrapper) cleanup() {
 	}
 }
 
+// closeChainDBs closes the DBs of the chains other than the default chain
+func closeChainDBs() {
+	chainDBsLock.Lock()
+	open := make([]*OpenchainDB, 0, len(chainDBs))
+	for _, chainDB := range chainDBs {
+		open = append(open, chainDB)
+	}
+	chainDBsLock.Unlock()
+	for _, chainDB := range open {
+		chainDB.CloseDB()
+	}
+}
+
 func (testDB *TestDBWrapper) removeDBPath() {
 	dbPath := viper.GetString("peer.fileSystemPath")
 	os.RemoveAll(dbPath)
 }
 
 // WriteToDB tests can use this method for persisting a given batch to db
-func (testDB *TestDBWrapper) WriteToDB(t testing.TB, writeBatch *gorocksdb.WriteBatch) {
-	opt := gorocksdb.NewDefaultWriteOptions()
-	defer opt.Destroy()
-	err := GetDBHandle().DB.Write(opt, writeBatch)
+func (testDB *TestDBWrapper) WriteToDB(t testing.TB, writeBatch *WriteBatch) {
+	err := GetDBHandle().Write(writeBatch)
 	if err != nil {
 		t.Fatalf("Error while writing to db. Error:%s", err)
 	}
@@ -76,15 +87,10 @@ func (testDB *TestDBWrapper) WriteToDB(t testing.TB, writeBatch *gorocksdb.Write
 
 // GetFromDB gets the value for the given key from default column-family
 func (testDB *TestDBWrapper) GetFromDB(t testing.TB, key []byte) []byte {
-	db := GetDBHandle().DB
-	opt := gorocksdb.NewDefaultReadOptions()
-	defer opt.Destroy()
-	slice, err := db.Get(opt, key)
-	defer slice.Free()
+	value, err := GetDBHandle().Get(defaultCF, key)
 	if err != nil {
 		t.Fatalf("Error while getting key-value from DB: %s", err)
 	}
-	value := append([]byte(nil), slice.Data()...)
 	return value
 }
 
@@ -114,19 +120,33 @@ func (testDB *TestDBWrapper) CloseDB(t testing.TB) {
 	openchainDB.CloseDB()
 }
 
+// propertyStore is implemented by the stores reporting their properties,
+// only rocksdb does
+type propertyStore interface {
+	GetProperty(property string) string
+	GetPropertyCF(property string, cf ColumnFamily) string
+}
+
 // GetEstimatedNumKeys returns estimated number of key-values in db. This is not accurate in all the cases
 func (testDB *TestDBWrapper) GetEstimatedNumKeys(t testing.TB) map[string]string {
 	openchainDB := GetDBHandle()
 	result := make(map[string]string, 5)
-	result["stateCF"] = openchainDB.DB.GetPropertyCF("rocksdb.estimate-num-keys", openchainDB.StateCF)
-	result["stateDeltaCF"] = openchainDB.DB.GetPropertyCF("rocksdb.estimate-num-keys", openchainDB.StateDeltaCF)
-	result["blockchainCF"] = openchainDB.DB.GetPropertyCF("rocksdb.estimate-num-keys", openchainDB.BlockchainCF)
-	result["indexCF"] = openchainDB.DB.GetPropertyCF("rocksdb.estimate-num-keys", openchainDB.IndexesCF)
+	store, ok := openchainDB.store.(propertyStore)
+	if !ok {
+		return result
+	}
+	result["stateCF"] = store.GetPropertyCF("rocksdb.estimate-num-keys", openchainDB.StateCF)
+	result["stateDeltaCF"] = store.GetPropertyCF("rocksdb.estimate-num-keys", openchainDB.StateDeltaCF)
+	result["blockchainCF"] = store.GetPropertyCF("rocksdb.estimate-num-keys", openchainDB.BlockchainCF)
+	result["indexCF"] = store.GetPropertyCF("rocksdb.estimate-num-keys", openchainDB.IndexesCF)
 	return result
 }
 
 // GetDBStats returns statistics for the database
 func (testDB *TestDBWrapper) GetDBStats() string {
-	openchainDB := GetDBHandle()
-	return openchainDB.DB.GetProperty("rocksdb.stats")
+	store, ok := GetDBHandle().store.(propertyStore)
+	if !ok {
+		return ""
+	}
+	return store.GetProperty("rocksdb.stats")
 }
@@ -0,0 +1,184 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// ColumnFamily names a keyspace of the DB, such as the blocks or the state
+type ColumnFamily string
+
+// defaultCF is the column family of the keys written without one
+const defaultCF ColumnFamily = "default"
+
+// Store is a key-value store, keeping the keys of each column family
+// sorted, which holds the DB
+type Store interface {
+	// Get returns the value of key, nil if there is none
+	Get(cf ColumnFamily, key []byte) ([]byte, error)
+	Put(cf ColumnFamily, key []byte, value []byte) error
+	Delete(cf ColumnFamily, key []byte) error
+
+	// Write applies all the writes of the batch atomically
+	Write(writeBatch *WriteBatch) error
+
+	// NewIterator returns an iterator over the keys of the column family,
+	// which sees the keys as they were when it was created
+	NewIterator(cf ColumnFamily) Iterator
+
+	// NewSnapshot returns a point-in-time view of the store
+	NewSnapshot() Snapshot
+
+	// DropColumnFamily deletes all the keys of the column family
+	DropColumnFamily(cf ColumnFamily) error
+
+	// Compact reclaims the space of the keys deleted from the column family
+	Compact(cf ColumnFamily)
+
+	// Stats returns the statistics of the column family
+	Stats(cf ColumnFamily) (*ColumnFamilyStats, error)
+
+	Close()
+}
+
+// ColumnFamilyStats are the statistics of a column family reported by a
+// store. The stores on disk estimate them, a count or size not reported by
+// the store is 0
+type ColumnFamilyStats struct {
+	Name       ColumnFamily
+	Keys       uint64 // number of keys
+	DiskSize   uint64 // bytes of the files holding the keys
+	MemorySize uint64 // bytes of the keys held in memory, e.g. memtables
+	Details    string // statistics specific to the store
+}
+
+// Iterator walks the keys of a column family in order. The key and value
+// returned are only valid until the iterator moves, copy them to keep them.
+type Iterator interface {
+	Seek(key []byte)
+	SeekToFirst()
+	Valid() bool
+
+	// ValidForPrefix returns whether the iterator is at a key with prefix
+	ValidForPrefix(prefix []byte) bool
+	Next()
+	Key() []byte
+	Value() []byte
+	Err() error
+	Close()
+}
+
+// Snapshot is a point-in-time view of a store
+type Snapshot interface {
+	// Get returns the value of key, nil if there is none
+	Get(cf ColumnFamily, key []byte) ([]byte, error)
+	NewIterator(cf ColumnFamily) Iterator
+	Release()
+}
+
+// WriteBatch collects writes to be applied atomically by OpenchainDB.Write
+type WriteBatch struct {
+	writes []batchWrite
+}
+
+type batchWrite struct {
+	cf     ColumnFamily
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// NewWriteBatch returns an empty WriteBatch
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+// Put adds a write of the value of key in the default column family
+func (writeBatch *WriteBatch) Put(key []byte, value []byte) {
+	writeBatch.PutCF(defaultCF, key, value)
+}
+
+// PutCF adds a write of the value of key in the given column family
+func (writeBatch *WriteBatch) PutCF(cf ColumnFamily, key []byte, value []byte) {
+	writeBatch.writes = append(writeBatch.writes, batchWrite{cf, makeCopy(key), makeCopy(value), false})
+}
+
+// DeleteCF adds a deletion of key from the given column family
+func (writeBatch *WriteBatch) DeleteCF(cf ColumnFamily, key []byte) {
+	writeBatch.writes = append(writeBatch.writes, batchWrite{cf, makeCopy(key), nil, true})
+}
+
+// Count returns the number of writes in the batch
+func (writeBatch *WriteBatch) Count() int {
+	return len(writeBatch.writes)
+}
+
+// Destroy drops the writes of the batch
+func (writeBatch *WriteBatch) Destroy() {
+	writeBatch.writes = nil
+}
+
+// backend creates and opens the stores of one kind
+type backend struct {
+	create func(dbPath string) error
+	open   func(dbPath string, cfs []ColumnFamily) (Store, error)
+}
+
+// backends are the backends built into the peer. The backends needing cgo or
+// a Go newer than the one of the peer, rocksdb and badger, add themselves
+// when built with the tag of their name
+var backends = map[string]backend{
+	"leveldb": {createLevelDB, openLevelDB},
+	"memory":  {createMemoryDB, openMemoryDB},
+}
+
+// defaultBackend is used when 'peer.db.backend' is not set, rocksdb when it
+// is built into the peer
+var defaultBackend = "leveldb"
+
+// BackendName returns the kind of store configured in 'peer.db.backend'
+func BackendName() string {
+	name := viper.GetString("peer.db.backend")
+	if name == "" {
+		name = defaultBackend
+	}
+	return name
+}
+
+func getBackend() (backend, error) {
+	name := BackendName()
+	backend, ok := backends[name]
+	if !ok {
+		return backend, fmt.Errorf("Unknown DB backend [%s], expected %s. The rocksdb and badger backends are built with the tag of their name only", name, strings.Join(backendNames(), ", "))
+	}
+	return backend, nil
+}
+
+// backendNames returns the sorted names of the backends built into the peer
+func backendNames() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
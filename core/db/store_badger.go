@@ -0,0 +1,275 @@
+// +build badger
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dgraph-io/badger"
+)
+
+func init() {
+	backends["badger"] = backend{createBadgerDB, openBadgerDB}
+}
+
+// badgerStore is a Store on the pure Go badger, which, like leveldb, has no
+// column families: the keys of a column family are prefixed with its name
+// and a 0 byte. The values are kept in a value log next to the keys
+type badgerStore struct {
+	db *badger.DB
+}
+
+func badgerOptions(dbPath string) badger.Options {
+	opts := badger.DefaultOptions
+	opts.Dir = dbPath
+	opts.ValueDir = dbPath
+	return opts
+}
+
+func createBadgerDB(dbPath string) error {
+	if err := os.MkdirAll(dbPath, 0755); err != nil {
+		return err
+	}
+	db, err := badger.Open(badgerOptions(dbPath))
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+func openBadgerDB(dbPath string, cfs []ColumnFamily) (Store, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, err
+	}
+	db, err := badger.Open(badgerOptions(dbPath))
+	if err != nil {
+		return nil, err
+	}
+	return &badgerStore{db}, nil
+}
+
+func badgerGet(txn *badger.Txn, cf ColumnFamily, key []byte) ([]byte, error) {
+	item, err := txn.Get(levelKey(cf, key))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	value, err := item.Value()
+	if err != nil {
+		return nil, err
+	}
+	return makeCopy(value), nil
+}
+
+func (store *badgerStore) Get(cf ColumnFamily, key []byte) ([]byte, error) {
+	var value []byte
+	err := store.db.View(func(txn *badger.Txn) error {
+		var err error
+		value, err = badgerGet(txn, cf, key)
+		return err
+	})
+	return value, err
+}
+
+func (store *badgerStore) Put(cf ColumnFamily, key []byte, value []byte) error {
+	return store.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(levelKey(cf, key), makeCopy(value))
+	})
+}
+
+func (store *badgerStore) Delete(cf ColumnFamily, key []byte) error {
+	return store.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(levelKey(cf, key))
+	})
+}
+
+// Write applies the batch in a single transaction, which badger limits in
+// size: a batch larger than a fraction of the memtable is refused
+func (store *badgerStore) Write(writeBatch *WriteBatch) error {
+	return store.db.Update(func(txn *badger.Txn) error {
+		for _, write := range writeBatch.writes {
+			var err error
+			if write.delete {
+				err = txn.Delete(levelKey(write.cf, write.key))
+			} else {
+				err = txn.Set(levelKey(write.cf, write.key), write.value)
+			}
+			if err == badger.ErrTxnTooBig {
+				return fmt.Errorf("Batch of %d writes too large for badger: %s", writeBatch.Count(), err)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (store *badgerStore) NewIterator(cf ColumnFamily) Iterator {
+	return newBadgerIterator(store.db.NewTransaction(false), cf, true)
+}
+
+func (store *badgerStore) NewSnapshot() Snapshot {
+	return &badgerSnapshot{store.db.NewTransaction(false)}
+}
+
+func (store *badgerStore) DropColumnFamily(cf ColumnFamily) error {
+	prefix := levelPrefix(cf)
+	for {
+		// a transaction holds a limited number of writes, so the keys are
+		// deleted in several
+		done := true
+		err := store.db.Update(func(txn *badger.Txn) error {
+			itr := txn.NewIterator(badger.IteratorOptions{})
+			defer itr.Close()
+			for itr.Seek(prefix); itr.ValidForPrefix(prefix); itr.Next() {
+				err := txn.Delete(makeCopy(itr.Item().Key()))
+				if err == badger.ErrTxnTooBig {
+					done = false
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// Compact drops the versions of the keys older than the last one, then the
+// value log files mostly holding values no longer referenced. Badger does so
+// for the whole store, not one column family
+func (store *badgerStore) Compact(cf ColumnFamily) {
+	if err := store.db.PurgeOlderVersions(); err != nil {
+		dbLogger.Warning("Error purging the older versions of the keys: %s", err)
+		return
+	}
+	if err := store.db.RunValueLogGC(0.5); err != nil && err != badger.ErrNoRewrite {
+		dbLogger.Warning("Error collecting the garbage of the value log: %s", err)
+	}
+}
+
+// Stats counts the keys of the column family and adds up their estimated
+// size, badger not reporting the size on disk of a key range
+func (store *badgerStore) Stats(cf ColumnFamily) (*ColumnFamilyStats, error) {
+	stats := &ColumnFamilyStats{Name: cf}
+	prefix := levelPrefix(cf)
+	err := store.db.View(func(txn *badger.Txn) error {
+		itr := txn.NewIterator(badger.IteratorOptions{})
+		defer itr.Close()
+		for itr.Seek(prefix); itr.ValidForPrefix(prefix); itr.Next() {
+			stats.Keys++
+			stats.DiskSize += uint64(itr.Item().EstimatedSize())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (store *badgerStore) Close() {
+	if err := store.db.Close(); err != nil {
+		dbLogger.Warning("Error closing badger: %s", err)
+	}
+}
+
+// badgerSnapshot is a read-only transaction, which sees the keys as they were
+// when it started
+type badgerSnapshot struct {
+	txn *badger.Txn
+}
+
+func (snapshot *badgerSnapshot) Get(cf ColumnFamily, key []byte) ([]byte, error) {
+	return badgerGet(snapshot.txn, cf, key)
+}
+
+func (snapshot *badgerSnapshot) NewIterator(cf ColumnFamily) Iterator {
+	return newBadgerIterator(snapshot.txn, cf, false)
+}
+
+func (snapshot *badgerSnapshot) Release() {
+	snapshot.txn.Discard()
+}
+
+// badgerIterator walks the keys of a column family, stripping them of the
+// prefix of the column family. An iterator of the store has a transaction of
+// its own, discarded when the iterator is closed
+type badgerIterator struct {
+	txn     *badger.Txn
+	itr     *badger.Iterator
+	prefix  []byte
+	ownsTxn bool
+	err     error
+}
+
+func newBadgerIterator(txn *badger.Txn, cf ColumnFamily, ownsTxn bool) *badgerIterator {
+	return &badgerIterator{txn, txn.NewIterator(badger.DefaultIteratorOptions), levelPrefix(cf), ownsTxn, nil}
+}
+
+func (itr *badgerIterator) Seek(key []byte) {
+	itr.itr.Seek(append(itr.prefix[:len(itr.prefix):len(itr.prefix)], key...))
+}
+
+func (itr *badgerIterator) SeekToFirst() {
+	itr.itr.Seek(itr.prefix)
+}
+
+func (itr *badgerIterator) Valid() bool {
+	return itr.itr.ValidForPrefix(itr.prefix)
+}
+
+func (itr *badgerIterator) ValidForPrefix(prefix []byte) bool {
+	return itr.itr.ValidForPrefix(append(itr.prefix[:len(itr.prefix):len(itr.prefix)], prefix...))
+}
+
+func (itr *badgerIterator) Next() {
+	itr.itr.Next()
+}
+
+func (itr *badgerIterator) Key() []byte {
+	return itr.itr.Item().Key()[len(itr.prefix):]
+}
+
+func (itr *badgerIterator) Value() []byte {
+	value, err := itr.itr.Item().Value()
+	if err != nil {
+		itr.err = err
+	}
+	return value
+}
+
+func (itr *badgerIterator) Err() error {
+	return itr.err
+}
+
+func (itr *badgerIterator) Close() {
+	itr.itr.Close()
+	if itr.ownsTxn {
+		itr.txn.Discard()
+	}
+}
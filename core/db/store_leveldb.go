@@ -0,0 +1,194 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"bytes"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// levelStore is a Store on the pure Go leveldb, which has no column
+// families: the keys of a column family are prefixed with its name and a
+// 0 byte
+type levelStore struct {
+	db *leveldb.DB
+}
+
+func createLevelDB(dbPath string) error {
+	db, err := leveldb.OpenFile(dbPath, nil)
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+func openLevelDB(dbPath string, cfs []ColumnFamily) (Store, error) {
+	db, err := leveldb.OpenFile(dbPath, &opt.Options{ErrorIfMissing: true})
+	if err != nil {
+		return nil, err
+	}
+	return &levelStore{db}, nil
+}
+
+func levelPrefix(cf ColumnFamily) []byte {
+	return append([]byte(cf), 0)
+}
+
+func levelKey(cf ColumnFamily, key []byte) []byte {
+	return append(levelPrefix(cf), key...)
+}
+
+func levelGet(value []byte, err error) ([]byte, error) {
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	return value, err
+}
+
+func (store *levelStore) Get(cf ColumnFamily, key []byte) ([]byte, error) {
+	return levelGet(store.db.Get(levelKey(cf, key), nil))
+}
+
+func (store *levelStore) Put(cf ColumnFamily, key []byte, value []byte) error {
+	return store.db.Put(levelKey(cf, key), value, nil)
+}
+
+func (store *levelStore) Delete(cf ColumnFamily, key []byte) error {
+	return store.db.Delete(levelKey(cf, key), nil)
+}
+
+func (store *levelStore) Write(writeBatch *WriteBatch) error {
+	levelBatch := new(leveldb.Batch)
+	for _, write := range writeBatch.writes {
+		if write.delete {
+			levelBatch.Delete(levelKey(write.cf, write.key))
+		} else {
+			levelBatch.Put(levelKey(write.cf, write.key), write.value)
+		}
+	}
+	return store.db.Write(levelBatch, nil)
+}
+
+func (store *levelStore) NewIterator(cf ColumnFamily) Iterator {
+	prefix := levelPrefix(cf)
+	return &levelIterator{store.db.NewIterator(util.BytesPrefix(prefix), nil), prefix}
+}
+
+func (store *levelStore) NewSnapshot() Snapshot {
+	snapshot, err := store.db.GetSnapshot()
+	if err != nil {
+		// the DB is closed
+		panic(err)
+	}
+	return &levelSnapshot{snapshot}
+}
+
+func (store *levelStore) DropColumnFamily(cf ColumnFamily) error {
+	prefix := levelPrefix(cf)
+	itr := store.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer itr.Release()
+	levelBatch := new(leveldb.Batch)
+	for itr.Next() {
+		levelBatch.Delete(itr.Key())
+	}
+	if err := itr.Error(); err != nil {
+		return err
+	}
+	return store.db.Write(levelBatch, nil)
+}
+
+func (store *levelStore) Compact(cf ColumnFamily) {
+	if err := store.db.CompactRange(*util.BytesPrefix(levelPrefix(cf))); err != nil {
+		dbLogger.Warning("Error compacting %s: %s", cf, err)
+	}
+}
+
+func (store *levelStore) Stats(cf ColumnFamily) (*ColumnFamilyStats, error) {
+	sizes, err := store.db.SizeOf([]util.Range{*util.BytesPrefix(levelPrefix(cf))})
+	if err != nil {
+		return nil, err
+	}
+	return &ColumnFamilyStats{Name: cf, DiskSize: uint64(sizes.Sum())}, nil
+}
+
+func (store *levelStore) Close() {
+	store.db.Close()
+}
+
+type levelSnapshot struct {
+	snapshot *leveldb.Snapshot
+}
+
+func (snapshot *levelSnapshot) Get(cf ColumnFamily, key []byte) ([]byte, error) {
+	return levelGet(snapshot.snapshot.Get(levelKey(cf, key), nil))
+}
+
+func (snapshot *levelSnapshot) NewIterator(cf ColumnFamily) Iterator {
+	prefix := levelPrefix(cf)
+	return &levelIterator{snapshot.snapshot.NewIterator(util.BytesPrefix(prefix), nil), prefix}
+}
+
+func (snapshot *levelSnapshot) Release() {
+	snapshot.snapshot.Release()
+}
+
+// levelIterator walks the keys of a column family, stripping them of the
+// prefix of the column family
+type levelIterator struct {
+	itr    iterator.Iterator
+	prefix []byte
+}
+
+func (itr *levelIterator) Seek(key []byte) {
+	itr.itr.Seek(append(itr.prefix[:len(itr.prefix):len(itr.prefix)], key...))
+}
+
+func (itr *levelIterator) SeekToFirst() {
+	itr.itr.First()
+}
+
+func (itr *levelIterator) Valid() bool {
+	return itr.itr.Valid()
+}
+
+func (itr *levelIterator) ValidForPrefix(prefix []byte) bool {
+	return itr.itr.Valid() && bytes.HasPrefix(itr.Key(), prefix)
+}
+
+func (itr *levelIterator) Next() {
+	itr.itr.Next()
+}
+
+func (itr *levelIterator) Key() []byte {
+	return itr.itr.Key()[len(itr.prefix):]
+}
+
+func (itr *levelIterator) Value() []byte {
+	return itr.itr.Value()
+}
+
+func (itr *levelIterator) Err() error {
+	return itr.itr.Error()
+}
+
+func (itr *levelIterator) Close() {
+	itr.itr.Release()
+}
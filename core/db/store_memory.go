@@ -0,0 +1,199 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// memoryStore is a Store holding the DB in memory, which is lost when the
+// store is closed. The values are never modified once stored, they are
+// shared by the iterators and snapshots.
+type memoryStore struct {
+	lock sync.RWMutex
+	cfs  map[ColumnFamily]map[string][]byte
+}
+
+func createMemoryDB(dbPath string) error {
+	return nil
+}
+
+func openMemoryDB(dbPath string, cfs []ColumnFamily) (Store, error) {
+	return &memoryStore{cfs: make(map[ColumnFamily]map[string][]byte)}, nil
+}
+
+func (store *memoryStore) Get(cf ColumnFamily, key []byte) ([]byte, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return memoryGet(store.cfs, cf, key), nil
+}
+
+func memoryGet(cfs map[ColumnFamily]map[string][]byte, cf ColumnFamily, key []byte) []byte {
+	value, ok := cfs[cf][string(key)]
+	if !ok {
+		return nil
+	}
+	return makeCopy(value)
+}
+
+func (store *memoryStore) Put(cf ColumnFamily, key []byte, value []byte) error {
+	writeBatch := NewWriteBatch()
+	writeBatch.PutCF(cf, key, value)
+	return store.Write(writeBatch)
+}
+
+func (store *memoryStore) Delete(cf ColumnFamily, key []byte) error {
+	writeBatch := NewWriteBatch()
+	writeBatch.DeleteCF(cf, key)
+	return store.Write(writeBatch)
+}
+
+func (store *memoryStore) Write(writeBatch *WriteBatch) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for _, write := range writeBatch.writes {
+		if write.delete {
+			delete(store.cfs[write.cf], string(write.key))
+			continue
+		}
+		if store.cfs[write.cf] == nil {
+			store.cfs[write.cf] = make(map[string][]byte)
+		}
+		store.cfs[write.cf][string(write.key)] = write.value
+	}
+	return nil
+}
+
+func (store *memoryStore) NewIterator(cf ColumnFamily) Iterator {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return newMemoryIterator(store.cfs[cf])
+}
+
+func (store *memoryStore) NewSnapshot() Snapshot {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	cfs := make(map[ColumnFamily]map[string][]byte, len(store.cfs))
+	for cf, keys := range store.cfs {
+		cfs[cf] = make(map[string][]byte, len(keys))
+		for key, value := range keys {
+			cfs[cf][key] = value
+		}
+	}
+	return &memorySnapshot{cfs}
+}
+
+func (store *memoryStore) DropColumnFamily(cf ColumnFamily) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	delete(store.cfs, cf)
+	return nil
+}
+
+func (store *memoryStore) Compact(cf ColumnFamily) {
+}
+
+func (store *memoryStore) Stats(cf ColumnFamily) (*ColumnFamilyStats, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	stats := &ColumnFamilyStats{Name: cf}
+	for key, value := range store.cfs[cf] {
+		stats.Keys++
+		stats.MemorySize += uint64(len(key) + len(value))
+	}
+	return stats, nil
+}
+
+func (store *memoryStore) Close() {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.cfs = nil
+}
+
+type memorySnapshot struct {
+	cfs map[ColumnFamily]map[string][]byte
+}
+
+func (snapshot *memorySnapshot) Get(cf ColumnFamily, key []byte) ([]byte, error) {
+	return memoryGet(snapshot.cfs, cf, key), nil
+}
+
+func (snapshot *memorySnapshot) NewIterator(cf ColumnFamily) Iterator {
+	return newMemoryIterator(snapshot.cfs[cf])
+}
+
+func (snapshot *memorySnapshot) Release() {
+}
+
+// memoryIterator walks the keys of a column family sorted when it was
+// created
+type memoryIterator struct {
+	keys   []string
+	values [][]byte
+	pos    int
+}
+
+func newMemoryIterator(cf map[string][]byte) *memoryIterator {
+	itr := &memoryIterator{keys: make([]string, 0, len(cf))}
+	for key := range cf {
+		itr.keys = append(itr.keys, key)
+	}
+	sort.Strings(itr.keys)
+	itr.values = make([][]byte, len(itr.keys))
+	for i, key := range itr.keys {
+		itr.values[i] = cf[key]
+	}
+	itr.pos = len(itr.keys)
+	return itr
+}
+
+func (itr *memoryIterator) Seek(key []byte) {
+	itr.pos = sort.SearchStrings(itr.keys, string(key))
+}
+
+func (itr *memoryIterator) SeekToFirst() {
+	itr.pos = 0
+}
+
+func (itr *memoryIterator) Valid() bool {
+	return itr.pos < len(itr.keys)
+}
+
+func (itr *memoryIterator) ValidForPrefix(prefix []byte) bool {
+	return itr.Valid() && bytes.HasPrefix(itr.Key(), prefix)
+}
+
+func (itr *memoryIterator) Next() {
+	itr.pos++
+}
+
+func (itr *memoryIterator) Key() []byte {
+	return []byte(itr.keys[itr.pos])
+}
+
+func (itr *memoryIterator) Value() []byte {
+	return itr.values[itr.pos]
+}
+
+func (itr *memoryIterator) Err() error {
+	return nil
+}
+
+func (itr *memoryIterator) Close() {
+}
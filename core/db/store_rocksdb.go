@@ -0,0 +1,274 @@
+// +build rocksdb
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/tecbot/gorocksdb"
+)
+
+// rocksStore is a Store on rocksdb, with a rocksdb column family per
+// column family of the store, tuned by the configuration 'peer.db.rocksdb'
+type rocksStore struct {
+	db        *gorocksdb.DB
+	cfHandles map[ColumnFamily]*gorocksdb.ColumnFamilyHandle
+	config    *rocksConfig
+	cache     *gorocksdb.Cache
+}
+
+func init() {
+	backends["rocksdb"] = backend{createRocksDB, openRocksDB}
+	defaultBackend = "rocksdb"
+}
+
+func createRocksDB(dbPath string) error {
+	config, err := getRocksConfig()
+	if err != nil {
+		return err
+	}
+	opts := config.newOptions(defaultCF, nil)
+	defer opts.Destroy()
+	opts.SetCreateIfMissing(true)
+
+	db, err := gorocksdb.OpenDb(opts, dbPath)
+	if err != nil {
+		return err
+	}
+	db.Close()
+	return nil
+}
+
+func openRocksDB(dbPath string, cfs []ColumnFamily) (Store, error) {
+	config, err := getRocksConfig()
+	if err != nil {
+		return nil, err
+	}
+	cache := config.newBlockCache()
+	opts := config.newOptions(defaultCF, cache)
+	defer opts.Destroy()
+
+	opts.SetCreateIfMissing(false)
+	opts.SetCreateIfMissingColumnFamilies(true)
+
+	cfNames := []string{string(defaultCF)}
+	cfOpts := []*gorocksdb.Options{opts}
+	for _, cf := range cfs {
+		cfNames = append(cfNames, string(cf))
+		cfOpts = append(cfOpts, config.newOptions(cf, cache))
+	}
+
+	db, cfHandlers, err := gorocksdb.OpenDbColumnFamilies(opts, dbPath, cfNames, cfOpts)
+	for _, cfOpt := range cfOpts[1:] {
+		cfOpt.Destroy()
+	}
+	if err != nil {
+		if cache != nil {
+			cache.Destroy()
+		}
+		return nil, err
+	}
+	store := &rocksStore{db, make(map[ColumnFamily]*gorocksdb.ColumnFamilyHandle), config, cache}
+	for i, name := range cfNames {
+		store.cfHandles[ColumnFamily(name)] = cfHandlers[i]
+	}
+	return store, nil
+}
+
+func (store *rocksStore) Get(cf ColumnFamily, key []byte) ([]byte, error) {
+	opt := gorocksdb.NewDefaultReadOptions()
+	defer opt.Destroy()
+	return store.get(opt, cf, key)
+}
+
+func (store *rocksStore) get(opt *gorocksdb.ReadOptions, cf ColumnFamily, key []byte) ([]byte, error) {
+	slice, err := store.db.GetCF(opt, store.cfHandles[cf], key)
+	if err != nil {
+		return nil, err
+	}
+	defer slice.Free()
+	if slice.Data() == nil {
+		return nil, nil
+	}
+	return makeCopy(slice.Data()), nil
+}
+
+func (store *rocksStore) Put(cf ColumnFamily, key []byte, value []byte) error {
+	opt := store.config.newWriteOptions()
+	defer opt.Destroy()
+	return store.db.PutCF(opt, store.cfHandles[cf], key, value)
+}
+
+func (store *rocksStore) Delete(cf ColumnFamily, key []byte) error {
+	opt := store.config.newWriteOptions()
+	defer opt.Destroy()
+	return store.db.DeleteCF(opt, store.cfHandles[cf], key)
+}
+
+func (store *rocksStore) Write(writeBatch *WriteBatch) error {
+	rocksBatch := gorocksdb.NewWriteBatch()
+	defer rocksBatch.Destroy()
+	for _, write := range writeBatch.writes {
+		if write.delete {
+			rocksBatch.DeleteCF(store.cfHandles[write.cf], write.key)
+		} else {
+			rocksBatch.PutCF(store.cfHandles[write.cf], write.key, write.value)
+		}
+	}
+	opt := store.config.newWriteOptions()
+	defer opt.Destroy()
+	return store.db.Write(opt, rocksBatch)
+}
+
+func (store *rocksStore) NewIterator(cf ColumnFamily) Iterator {
+	opt := gorocksdb.NewDefaultReadOptions()
+	opt.SetFillCache(true)
+	defer opt.Destroy()
+	return &rocksIterator{store.db.NewIteratorCF(opt, store.cfHandles[cf])}
+}
+
+func (store *rocksStore) NewSnapshot() Snapshot {
+	return &rocksSnapshot{store, store.db.NewSnapshot()}
+}
+
+func (store *rocksStore) DropColumnFamily(cf ColumnFamily) error {
+	err := store.db.DropColumnFamily(store.cfHandles[cf])
+	if err != nil {
+		return err
+	}
+	opts := store.config.newOptions(cf, store.cache)
+	defer opts.Destroy()
+	cfHandle, err := store.db.CreateColumnFamily(opts, string(cf))
+	if err != nil {
+		return err
+	}
+	store.cfHandles[cf] = cfHandle
+	return nil
+}
+
+func (store *rocksStore) Compact(cf ColumnFamily) {
+	store.db.CompactRangeCF(store.cfHandles[cf], gorocksdb.Range{})
+}
+
+func (store *rocksStore) Close() {
+	for _, cfHandle := range store.cfHandles {
+		cfHandle.Destroy()
+	}
+	store.db.Close()
+	if store.cache != nil {
+		store.cache.Destroy()
+	}
+}
+
+func (store *rocksStore) Stats(cf ColumnFamily) (*ColumnFamilyStats, error) {
+	cfHandle := store.cfHandles[cf]
+	return &ColumnFamilyStats{
+		Name:       cf,
+		Keys:       rocksUintProperty(store.db.GetPropertyCF("rocksdb.estimate-num-keys", cfHandle)),
+		DiskSize:   rocksUintProperty(store.db.GetPropertyCF("rocksdb.total-sst-files-size", cfHandle)),
+		MemorySize: rocksUintProperty(store.db.GetPropertyCF("rocksdb.cur-size-all-mem-tables", cfHandle)),
+		Details:    store.db.GetPropertyCF("rocksdb.cfstats", cfHandle),
+	}, nil
+}
+
+// rocksUintProperty parses a numeric rocksdb property, 0 if the rocksdb
+// version does not report it
+func rocksUintProperty(value string) uint64 {
+	number, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return number
+}
+
+// GetProperty returns the rocksdb property of the DB, such as "rocksdb.stats"
+func (store *rocksStore) GetProperty(property string) string {
+	return store.db.GetProperty(property)
+}
+
+// GetPropertyCF returns the rocksdb property of the column family
+func (store *rocksStore) GetPropertyCF(property string, cf ColumnFamily) string {
+	return store.db.GetPropertyCF(property, store.cfHandles[cf])
+}
+
+type rocksSnapshot struct {
+	store    *rocksStore
+	snapshot *gorocksdb.Snapshot
+}
+
+func (snapshot *rocksSnapshot) Get(cf ColumnFamily, key []byte) ([]byte, error) {
+	opt := gorocksdb.NewDefaultReadOptions()
+	defer opt.Destroy()
+	opt.SetSnapshot(snapshot.snapshot)
+	return snapshot.store.get(opt, cf, key)
+}
+
+func (snapshot *rocksSnapshot) NewIterator(cf ColumnFamily) Iterator {
+	opt := gorocksdb.NewDefaultReadOptions()
+	defer opt.Destroy()
+	opt.SetSnapshot(snapshot.snapshot)
+	return &rocksIterator{snapshot.store.db.NewIteratorCF(opt, snapshot.store.cfHandles[cf])}
+}
+
+func (snapshot *rocksSnapshot) Release() {
+	snapshot.snapshot.Release()
+}
+
+// rocksIterator returns the keys and values of a rocksdb iterator without
+// copying them, the rocksdb iterator frees their memory when it moves
+type rocksIterator struct {
+	itr *gorocksdb.Iterator
+}
+
+func (itr *rocksIterator) Seek(key []byte) {
+	itr.itr.Seek(key)
+}
+
+func (itr *rocksIterator) SeekToFirst() {
+	itr.itr.SeekToFirst()
+}
+
+func (itr *rocksIterator) Valid() bool {
+	return itr.itr.Valid()
+}
+
+func (itr *rocksIterator) ValidForPrefix(prefix []byte) bool {
+	return itr.itr.Valid() && bytes.HasPrefix(itr.Key(), prefix)
+}
+
+func (itr *rocksIterator) Next() {
+	itr.itr.Next()
+}
+
+func (itr *rocksIterator) Key() []byte {
+	return itr.itr.Key().Data()
+}
+
+func (itr *rocksIterator) Value() []byte {
+	return itr.itr.Value().Data()
+}
+
+func (itr *rocksIterator) Err() error {
+	return itr.itr.Err()
+}
+
+func (itr *rocksIterator) Close() {
+	itr.itr.Close()
+}
@@ -0,0 +1,196 @@
+// +build rocksdb
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+	"github.com/tecbot/gorocksdb"
+)
+
+var rocksCompressions = map[string]gorocksdb.CompressionType{
+	"none":   gorocksdb.NoCompression,
+	"snappy": gorocksdb.SnappyCompression,
+	"zlib":   gorocksdb.ZLibCompression,
+	"bz2":    gorocksdb.Bz2Compression,
+}
+
+var rocksCompactionStyles = map[string]gorocksdb.CompactionStyle{
+	"level":     gorocksdb.LevelCompactionStyle,
+	"universal": gorocksdb.UniversalCompactionStyle,
+	"fifo":      gorocksdb.FIFOCompactionStyle,
+}
+
+// rocksConfig is the tuning of rocksdb, from the configuration
+// 'peer.db.rocksdb'. The sizes are in MB, 0 keeps the rocksdb default
+type rocksConfig struct {
+	blockCacheSize           int
+	writeBufferSize          int
+	maxOpenFiles             int
+	maxBackgroundCompactions int
+
+	walDir        string
+	walTTLSeconds uint64
+	walSizeLimit  uint64
+	syncWrites    bool
+
+	// the compaction style and compression of the column families not
+	// configured on their own, and of those configured
+	defaultCF rocksCFConfig
+	cfs       map[ColumnFamily]rocksCFConfig
+}
+
+type rocksCFConfig struct {
+	compactionStyle gorocksdb.CompactionStyle
+	compression     gorocksdb.CompressionType
+}
+
+func getRocksConfig() (*rocksConfig, error) {
+	config := &rocksConfig{
+		blockCacheSize:           viper.GetInt("peer.db.rocksdb.blockCacheSize"),
+		writeBufferSize:          viper.GetInt("peer.db.rocksdb.writeBufferSize"),
+		maxOpenFiles:             viper.GetInt("peer.db.rocksdb.maxOpenFiles"),
+		maxBackgroundCompactions: viper.GetInt("peer.db.rocksdb.maxBackgroundCompactions"),
+		walDir:                   viper.GetString("peer.db.rocksdb.wal.dir"),
+		syncWrites:               viper.GetBool("peer.db.rocksdb.wal.sync"),
+		cfs:                      make(map[ColumnFamily]rocksCFConfig),
+	}
+	if config.blockCacheSize < 0 || config.writeBufferSize < 0 || config.maxBackgroundCompactions < 0 {
+		return nil, fmt.Errorf("The sizes and the number of compactions in 'peer.db.rocksdb' must not be negative")
+	}
+	walTTLSeconds := viper.GetInt("peer.db.rocksdb.wal.ttlSeconds")
+	walSizeLimit := viper.GetInt("peer.db.rocksdb.wal.sizeLimit")
+	if walTTLSeconds < 0 || walSizeLimit < 0 {
+		return nil, fmt.Errorf("The ttlSeconds and sizeLimit in 'peer.db.rocksdb.wal' must not be negative")
+	}
+	config.walTTLSeconds = uint64(walTTLSeconds)
+	config.walSizeLimit = uint64(walSizeLimit)
+
+	var err error
+	config.defaultCF, err = parseRocksCFConfig("peer.db.rocksdb",
+		viper.GetString("peer.db.rocksdb.compactionStyle"), viper.GetString("peer.db.rocksdb.compression"),
+		rocksCFConfig{gorocksdb.LevelCompactionStyle, gorocksdb.SnappyCompression})
+	if err != nil {
+		return nil, err
+	}
+	for name, cfConfig := range cast.ToStringMap(viper.Get("peer.db.rocksdb.columnFamilies")) {
+		cf, ok := columnFamilyNamed(name)
+		if !ok {
+			return nil, fmt.Errorf("Unknown column family [%s] in 'peer.db.rocksdb.columnFamilies'", name)
+		}
+		// the configuration keys may be lowercased
+		settings := make(map[string]string)
+		for key, value := range cast.ToStringMapString(cfConfig) {
+			settings[strings.ToLower(key)] = value
+		}
+		config.cfs[cf], err = parseRocksCFConfig("peer.db.rocksdb.columnFamilies."+name,
+			settings["compactionstyle"], settings["compression"], config.defaultCF)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// parseRocksCFConfig parses the compaction style and compression configured
+// in key, an empty one is taken from defaults
+func parseRocksCFConfig(key string, compactionStyle string, compression string, defaults rocksCFConfig) (rocksCFConfig, error) {
+	config := defaults
+	if compactionStyle != "" {
+		style, ok := rocksCompactionStyles[compactionStyle]
+		if !ok {
+			return config, fmt.Errorf("Unknown compaction style [%s] in '%s', expected level, universal or fifo", compactionStyle, key)
+		}
+		config.compactionStyle = style
+	}
+	if compression != "" {
+		compressionType, ok := rocksCompressions[compression]
+		if !ok {
+			return config, fmt.Errorf("Unknown compression [%s] in '%s', expected none, snappy, zlib or bz2", compression, key)
+		}
+		config.compression = compressionType
+	}
+	return config, nil
+}
+
+// columnFamilyNamed returns the column family of the DB with name, ignoring
+// its case
+func columnFamilyNamed(name string) (ColumnFamily, bool) {
+	for _, cf := range append([]ColumnFamily{defaultCF}, columnfamilies...) {
+		if strings.EqualFold(name, string(cf)) {
+			return cf, true
+		}
+	}
+	return "", false
+}
+
+// newOptions returns the options of the DB and of the column family cf,
+// using the block cache shared by the column families. The options must be
+// destroyed by the caller
+func (config *rocksConfig) newOptions(cf ColumnFamily, cache *gorocksdb.Cache) *gorocksdb.Options {
+	opts := gorocksdb.NewDefaultOptions()
+	if cache != nil {
+		tableOpts := gorocksdb.NewDefaultBlockBasedTableOptions()
+		defer tableOpts.Destroy()
+		tableOpts.SetBlockCache(cache)
+		opts.SetBlockBasedTableFactory(tableOpts)
+	}
+	if config.writeBufferSize > 0 {
+		opts.SetWriteBufferSize(config.writeBufferSize * 1024 * 1024)
+	}
+	if config.maxOpenFiles != 0 {
+		opts.SetMaxOpenFiles(config.maxOpenFiles)
+	}
+	if config.maxBackgroundCompactions > 0 {
+		opts.SetMaxBackgroundCompactions(config.maxBackgroundCompactions)
+	}
+	if config.walDir != "" {
+		opts.SetWalDir(config.walDir)
+	}
+	opts.SetWALTtlSeconds(config.walTTLSeconds)
+	opts.SetWalSizeLimitMb(config.walSizeLimit)
+
+	cfConfig, ok := config.cfs[cf]
+	if !ok {
+		cfConfig = config.defaultCF
+	}
+	opts.SetCompactionStyle(cfConfig.compactionStyle)
+	opts.SetCompression(cfConfig.compression)
+	return opts
+}
+
+// newBlockCache returns the block cache shared by the column families, nil
+// for the rocksdb default of a cache per column family
+func (config *rocksConfig) newBlockCache() *gorocksdb.Cache {
+	if config.blockCacheSize == 0 {
+		return nil
+	}
+	return gorocksdb.NewLRUCache(config.blockCacheSize * 1024 * 1024)
+}
+
+// newWriteOptions returns the options of the writes, which sync the
+// write-ahead log if configured. The options must be destroyed by the caller
+func (config *rocksConfig) newWriteOptions() *gorocksdb.WriteOptions {
+	opt := gorocksdb.NewDefaultWriteOptions()
+	opt.SetSync(config.syncWrites)
+	return opt
+}
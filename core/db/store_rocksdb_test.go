@@ -0,0 +1,65 @@
+// +build rocksdb
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestRocksDBConfig(t *testing.T) {
+	defer func() {
+		for _, key := range []string{"compression", "writeBufferSize", "wal.sizeLimit", "columnFamilies"} {
+			viper.Set("peer.db.rocksdb."+key, nil)
+		}
+	}()
+	viper.Set("peer.db.rocksdb.compression", "zlib")
+	viper.Set("peer.db.rocksdb.writeBufferSize", 64)
+	viper.Set("peer.db.rocksdb.wal.sizeLimit", 512)
+	viper.Set("peer.db.rocksdb.columnFamilies", map[string]interface{}{
+		"blockchaincf": map[string]interface{}{"compression": "bz2", "compactionStyle": "universal"},
+	})
+	config, err := getRocksConfig()
+	if err != nil {
+		t.Fatalf("Error reading the rocksdb configuration: %s", err)
+	}
+	if config.writeBufferSize != 64 || config.walSizeLimit != 512 {
+		t.Fatalf("Got writeBufferSize %d and wal.sizeLimit %d, expected 64 and 512", config.writeBufferSize, config.walSizeLimit)
+	}
+	if config.defaultCF != (rocksCFConfig{rocksCompactionStyles["level"], rocksCompressions["zlib"]}) {
+		t.Fatalf("Got default column family configuration %+v", config.defaultCF)
+	}
+	if config.cfs[blockchainCF] != (rocksCFConfig{rocksCompactionStyles["universal"], rocksCompressions["bz2"]}) {
+		t.Fatalf("Got blockchainCF configuration %+v", config.cfs[blockchainCF])
+	}
+
+	viper.Set("peer.db.rocksdb.columnFamilies", map[string]interface{}{
+		"blockchainCF": map[string]interface{}{"compression": "lzma"},
+	})
+	if _, err = getRocksConfig(); err == nil {
+		t.Fatal("Expected an error for an unknown compression")
+	}
+	viper.Set("peer.db.rocksdb.columnFamilies", map[string]interface{}{
+		"unknownCF": map[string]interface{}{"compression": "zlib"},
+	})
+	if _, err = getRocksConfig(); err == nil {
+		t.Fatal("Expected an error for an unknown column family")
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ledger
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/minio/minio-go"
+	"github.com/spf13/viper"
+)
+
+// blockArchive is the cold storage of the old blocks moved out of the DB.
+// The blocks are stored as serialized in the DB.
+type blockArchive interface {
+	// put stores the block blockNumber, replacing it if it was stored
+	put(blockNumber uint64, blockBytes []byte) error
+
+	// get returns the block blockNumber, nil if it is not stored
+	get(blockNumber uint64) ([]byte, error)
+}
+
+// archiveStores are the stores of blocks available for
+// 'ledger.archive.store', each configured under 'ledger.archive.<store>'.
+// The blocks of every chain are kept apart
+var archiveStores = map[string]func(chainID string) (blockArchive, error){
+	"filesystem": newFileSystemArchive,
+	"s3":         objectStoreArchiveOpener("s3"),
+	"gcs":        objectStoreArchiveOpener("gcs"),
+}
+
+// openBlockArchive opens the archive configured in 'ledger.archive.store',
+// nil if no store is configured
+func openBlockArchive(chainID string) (blockArchive, error) {
+	store := viper.GetString("ledger.archive.store")
+	if store == "" {
+		return nil, nil
+	}
+	open, ok := archiveStores[store]
+	if !ok {
+		return nil, fmt.Errorf("Unknown block archive store '%s'", store)
+	}
+	return open(chainID)
+}
+
+// blocksPerArchiveDir is the number of blocks in each directory of the file
+// system archive, so that no directory grows too large
+const blocksPerArchiveDir = 10000
+
+// fileSystemArchive stores every block in a file of its own, under a
+// directory for every blocksPerArchiveDir blocks. The directory may be a
+// mount of a network or object storage.
+type fileSystemArchive struct {
+	path string
+}
+
+func newFileSystemArchive(chainID string) (blockArchive, error) {
+	path := viper.GetString("ledger.archive.filesystem.path")
+	if path == "" {
+		return nil, fmt.Errorf("No path given to the file system block archive, set 'ledger.archive.filesystem.path'")
+	}
+	if chainID != "" {
+		path = filepath.Join(path, "chains", chainID)
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, fmt.Errorf("Error making the block archive directory [%s]: %s", path, err)
+	}
+	return &fileSystemArchive{path}, nil
+}
+
+func (archive *fileSystemArchive) blockPath(blockNumber uint64) string {
+	return filepath.Join(archive.path, strconv.FormatUint(blockNumber/blocksPerArchiveDir, 10),
+		strconv.FormatUint(blockNumber, 10))
+}
+
+func (archive *fileSystemArchive) put(blockNumber uint64, blockBytes []byte) error {
+	path := archive.blockPath(blockNumber)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// write a temporary file first, so that a block is never read partly written
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, blockBytes, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func (archive *fileSystemArchive) get(blockNumber uint64) ([]byte, error) {
+	blockBytes, err := ioutil.ReadFile(archive.blockPath(blockNumber))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return blockBytes, err
+}
+
+// objectStoreArchive stores every block in an object of a bucket of an S3
+// compatible object storage, as S3 and GCS, the latter through its XML API
+// with an HMAC key. The objects are named as the files of the file system
+// archive, under an optional prefix.
+type objectStoreArchive struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// objectStoreArchiveOpener returns the function opening the object storage
+// archive configured under 'ledger.archive.<store>'
+func objectStoreArchiveOpener(store string) func(chainID string) (blockArchive, error) {
+	return func(chainID string) (blockArchive, error) {
+		return newObjectStoreArchive(store, chainID)
+	}
+}
+
+func newObjectStoreArchive(store string, chainID string) (blockArchive, error) {
+	key := "ledger.archive." + store
+	bucket := viper.GetString(key + ".bucket")
+	if bucket == "" {
+		return nil, fmt.Errorf("No bucket given to the %s block archive, set '%s.bucket'", store, key)
+	}
+	client, err := minio.New(viper.GetString(key+".endpoint"), viper.GetString(key+".accessKey"),
+		viper.GetString(key+".secretKey"), !viper.GetBool(key+".insecure"))
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to the %s block archive: %s", store, err)
+	}
+	prefix := viper.GetString(key + ".prefix")
+	if chainID != "" {
+		prefix = path.Join(prefix, "chains", chainID)
+	}
+	return &objectStoreArchive{client, bucket, prefix}, nil
+}
+
+func (archive *objectStoreArchive) blockObject(blockNumber uint64) string {
+	return path.Join(archive.prefix, strconv.FormatUint(blockNumber/blocksPerArchiveDir, 10),
+		strconv.FormatUint(blockNumber, 10))
+}
+
+// put uploads the block in a single request, so that a block is never read
+// partly written
+func (archive *objectStoreArchive) put(blockNumber uint64, blockBytes []byte) error {
+	_, err := archive.client.PutObject(archive.bucket, archive.blockObject(blockNumber),
+		bytes.NewReader(blockBytes), "application/octet-stream")
+	return err
+}
+
+func (archive *objectStoreArchive) get(blockNumber uint64) ([]byte, error) {
+	object, err := archive.client.GetObject(archive.bucket, archive.blockObject(blockNumber))
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	blockBytes, err := ioutil.ReadAll(object)
+	if err != nil && minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, nil
+	}
+	return blockBytes, err
+}
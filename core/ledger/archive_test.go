@@ -0,0 +1,114 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ledger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/ledger/testutil"
+	"github.com/spf13/viper"
+)
+
+// fakeObjectStore answers the few requests of the S3 API the object storage
+// archive sends, keeping the objects in memory
+type fakeObjectStore struct {
+	sync.Mutex
+	objects map[string][]byte
+}
+
+func (store *fakeObjectStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	store.Lock()
+	defer store.Unlock()
+	if _, ok := r.URL.Query()["location"]; ok {
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">us-east-1</LocationConstraint>`)
+		return
+	}
+	switch r.Method {
+	case "PUT":
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		store.objects[r.URL.Path] = body
+		w.Header().Set("ETag", `"etag"`)
+	case "GET", "HEAD":
+		object, ok := store.objects[r.URL.Path]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message><Key>%s</Key></Error>`, r.URL.Path)
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", fmt.Sprint(len(object)))
+		if r.Method == "GET" {
+			w.Write(object)
+		}
+	default:
+		http.Error(w, "Unexpected method "+r.Method, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestObjectStoreArchive(t *testing.T) {
+	store := &fakeObjectStore{objects: make(map[string][]byte)}
+	server := httptest.NewServer(store)
+	defer server.Close()
+	viper.Set("ledger.archive.s3.endpoint", strings.TrimPrefix(server.URL, "http://"))
+	viper.Set("ledger.archive.s3.bucket", "blocks")
+	viper.Set("ledger.archive.s3.prefix", "peer0")
+	viper.Set("ledger.archive.s3.accessKey", "access")
+	viper.Set("ledger.archive.s3.secretKey", "secret")
+	viper.Set("ledger.archive.s3.insecure", true)
+	defer viper.Set("ledger.archive.s3.bucket", "")
+
+	archive, err := archiveStores["s3"]("")
+	testutil.AssertNoError(t, err, "Error opening the archive")
+	chainArchive, err := archiveStores["s3"]("chain1")
+	testutil.AssertNoError(t, err, "Error opening the archive of the chain")
+
+	testutil.AssertNoError(t, archive.put(12345, []byte("block12345")), "Error archiving block")
+	testutil.AssertNoError(t, chainArchive.put(12345, []byte("chain block12345")), "Error archiving block of the chain")
+	testutil.AssertEquals(t, store.objects["/blocks/peer0/1/12345"], []byte("block12345"))
+	testutil.AssertEquals(t, store.objects["/blocks/peer0/chains/chain1/1/12345"], []byte("chain block12345"))
+
+	blockBytes, err := archive.get(12345)
+	testutil.AssertNoError(t, err, "Error fetching archived block")
+	testutil.AssertEquals(t, blockBytes, []byte("block12345"))
+	blockBytes, err = chainArchive.get(12345)
+	testutil.AssertNoError(t, err, "Error fetching archived block of the chain")
+	testutil.AssertEquals(t, blockBytes, []byte("chain block12345"))
+
+	// a block not archived is not found, without an error
+	blockBytes, err = archive.get(1)
+	testutil.AssertNoError(t, err, "Error fetching block not archived")
+	testutil.AssertNil(t, blockBytes)
+
+	// the bucket is required
+	viper.Set("ledger.archive.s3.bucket", "")
+	_, err = archiveStores["s3"]("")
+	testutil.AssertError(t, err, "Expected an error opening an archive without bucket")
+}
@@ -0,0 +1,263 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ledger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/golang/snappy"
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
+)
+
+// A compressed block is stored as blockCodecMarker, the id of its codec, then
+// the compressed block. A serialized block never starts with a zero byte, the
+// field numbers of protobuf start at 1, so the blocks stored uncompressed,
+// e.g. before compression was configured, are read as they are.
+const blockCodecMarker byte = 0
+
+// blockCodec compresses the blocks stored in the DB and in the archive
+type blockCodec struct {
+	id     byte
+	encode func([]byte) ([]byte, error)
+	decode func([]byte) ([]byte, error)
+}
+
+// blockCodecs are the compressions available for
+// 'ledger.blockchain.compression'. The ids are stored with the blocks, they
+// must not change.
+var blockCodecs = map[string]*blockCodec{
+	"snappy": {1, snappyEncode, snappyDecode},
+	"gzip":   {2, gzipEncode, gzipDecode},
+}
+
+func snappyEncode(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func snappyDecode(data []byte) ([]byte, error) {
+	return snappy.Decode(nil, data)
+}
+
+func gzipEncode(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gzipDecode(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// getBlockCodec returns the codec configured in
+// 'ledger.blockchain.compression', nil to store the blocks uncompressed
+func getBlockCodec() (*blockCodec, error) {
+	name := viper.GetString("ledger.blockchain.compression")
+	if name == "" || name == "none" {
+		return nil, nil
+	}
+	codec, ok := blockCodecs[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown block compression '%s' in 'ledger.blockchain.compression'", name)
+	}
+	return codec, nil
+}
+
+// encodeBlock serializes the block and compresses it with codec, if any
+func encodeBlock(block *protos.Block, codec *blockCodec) ([]byte, error) {
+	blockBytes, err := block.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return compressBlockBytes(blockBytes, codec)
+}
+
+func compressBlockBytes(blockBytes []byte, codec *blockCodec) ([]byte, error) {
+	if codec == nil {
+		return blockBytes, nil
+	}
+	compressed, err := codec.encode(blockBytes)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{blockCodecMarker, codec.id}, compressed...), nil
+}
+
+// decompressBlockBytes returns the serialized block stored as storedBytes,
+// whichever codec compressed it
+func decompressBlockBytes(storedBytes []byte) ([]byte, error) {
+	if len(storedBytes) < 2 || storedBytes[0] != blockCodecMarker {
+		return storedBytes, nil
+	}
+	for _, codec := range blockCodecs {
+		if codec.id == storedBytes[1] {
+			return codec.decode(storedBytes[2:])
+		}
+	}
+	return nil, fmt.Errorf("Unknown codec %d of a stored block", storedBytes[1])
+}
+
+// storedBlockCodecID returns the id of the codec of the stored block, 0 if
+// it is not compressed
+func storedBlockCodecID(storedBytes []byte) byte {
+	if len(storedBytes) < 2 || storedBytes[0] != blockCodecMarker {
+		return 0
+	}
+	return storedBytes[1]
+}
+
+// decodeBlock deserializes a block read from the DB or the archive
+func decodeBlock(storedBytes []byte) (*protos.Block, error) {
+	blockBytes, err := decompressBlockBytes(storedBytes)
+	if err != nil {
+		return nil, err
+	}
+	return protos.UnmarshallBlock(blockBytes)
+}
+
+// BlockRecompression reports the blocks rewritten by RecompressBlocks
+type BlockRecompression struct {
+	Blocks      uint64
+	BytesBefore uint64
+	BytesAfter  uint64
+}
+
+func (r *BlockRecompression) String() string {
+	return fmt.Sprintf("Recompressed %d blocks from %d to %d bytes", r.Blocks, r.BytesBefore, r.BytesAfter)
+}
+
+// RecompressBlocks rewrites the blocks of the default chain stored in the DB and in the archive
+// with the codec configured in 'ledger.blockchain.compression', or
+// uncompressed if none is configured. The blocks already stored with the
+// codec are left as they are. It must be called while the ledger is not
+// open, since the ledger may write blocks meanwhile. An interrupted
+// recompression may be run again.
+func RecompressBlocks() (*BlockRecompression, error) {
+	codec, err := getBlockCodec()
+	if err != nil {
+		return nil, err
+	}
+	openchainDB := db.GetDBHandle()
+	size, err := fetchBlockchainSizeFromDB(openchainDB)
+	if err != nil {
+		return nil, err
+	}
+	archivedBelow, err := fetchArchivedBelowFromDB(openchainDB)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := openBlockArchive("")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BlockRecompression{}
+	for start := uint64(0); start < size; start += pruneBatchSize {
+		end := start + pruneBatchSize
+		if end > size {
+			end = size
+		}
+		if err = recompressBlockRange(openchainDB, start, end, codec, result); err != nil {
+			return result, err
+		}
+	}
+	if archive != nil {
+		for blockNumber := uint64(1); blockNumber < archivedBelow; blockNumber++ {
+			storedBytes, err := archive.get(blockNumber)
+			if err != nil {
+				return result, fmt.Errorf("Error fetching block %d from the archive: %s", blockNumber, err)
+			}
+			newBytes, err := recompressBlockBytes(storedBytes, codec, result)
+			if err != nil {
+				return result, fmt.Errorf("Error recompressing archived block %d: %s", blockNumber, err)
+			}
+			if newBytes == nil {
+				continue
+			}
+			if err = archive.put(blockNumber, newBytes); err != nil {
+				return result, fmt.Errorf("Error archiving block %d: %s", blockNumber, err)
+			}
+		}
+	}
+	ledgerLogger.Info(result.String())
+	return result, nil
+}
+
+// recompressBlockRange rewrites the blocks from start up to end stored in
+// the DB in one write
+func recompressBlockRange(openchainDB *db.OpenchainDB, start, end uint64, codec *blockCodec, result *BlockRecompression) error {
+	writeBatch := db.NewWriteBatch()
+	defer writeBatch.Destroy()
+
+	for blockNumber := start; blockNumber < end; blockNumber++ {
+		storedBytes, err := openchainDB.GetFromBlockchainCF(encodeBlockNumberDBKey(blockNumber))
+		if err != nil {
+			return err
+		}
+		newBytes, err := recompressBlockBytes(storedBytes, codec, result)
+		if err != nil {
+			return fmt.Errorf("Error recompressing block %d: %s", blockNumber, err)
+		}
+		if newBytes != nil {
+			writeBatch.PutCF(openchainDB.BlockchainCF, encodeBlockNumberDBKey(blockNumber), newBytes)
+		}
+	}
+	return openchainDB.Write(writeBatch)
+}
+
+// recompressBlockBytes returns the stored block compressed with codec and
+// counts it in result, nil if the block is not stored or already compressed
+// with codec
+func recompressBlockBytes(storedBytes []byte, codec *blockCodec, result *BlockRecompression) ([]byte, error) {
+	if storedBytes == nil {
+		return nil, nil
+	}
+	var codecID byte
+	if codec != nil {
+		codecID = codec.id
+	}
+	if storedBlockCodecID(storedBytes) == codecID {
+		return nil, nil
+	}
+	blockBytes, err := decompressBlockBytes(storedBytes)
+	if err != nil {
+		return nil, err
+	}
+	newBytes, err := compressBlockBytes(blockBytes, codec)
+	if err != nil {
+		return nil, err
+	}
+	result.Blocks++
+	result.BytesBefore += uint64(len(storedBytes))
+	result.BytesAfter += uint64(len(newBytes))
+	return newBytes, nil
+}
@@ -19,12 +19,13 @@ package ledger
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"strconv"
+	"sync/atomic"
 
 	"github.com/hyperledger/fabric/core/db"
 	"github.com/hyperledger/fabric/core/util"
 	"github.com/hyperledger/fabric/protos"
-	"github.com/tecbot/gorocksdb"
 	"golang.org/x/net/context"
 )
 
@@ -35,6 +36,11 @@ type blockchain struct {
 	previousBlockHash  []byte
 	indexer            blockchainIndexer
 	lastProcessedBlock *lastProcessedBlock
+	prunedBelow        uint64
+	archive            blockArchive
+	archivedBelow      uint64
+	codec              *blockCodec
+	openchainDB        *db.OpenchainDB
 }
 
 type lastProcessedBlock struct {
@@ -45,15 +51,37 @@ type lastProcessedBlock struct {
 
 var indexBlockDataSynchronously = true
 
-func newBlockchain() (*blockchain, error) {
-	size, err := fetchBlockchainSizeFromDB()
+// pruneBatchSize is the number of blocks deleted by each write while pruning
+const pruneBatchSize = 100
+
+func newBlockchain(openchainDB *db.OpenchainDB) (*blockchain, error) {
+	size, err := fetchBlockchainSizeFromDB(openchainDB)
+	if err != nil {
+		return nil, err
+	}
+	prunedBelow, err := fetchPrunedBelowFromDB(openchainDB)
+	if err != nil {
+		return nil, err
+	}
+	archivedBelow, err := fetchArchivedBelowFromDB(openchainDB)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := openBlockArchive(openchainDB.ChainID())
+	if err != nil {
+		return nil, err
+	}
+	if archive == nil && archivedBelow > 0 {
+		return nil, fmt.Errorf("The blocks below %d were archived, but no block archive is configured in 'ledger.archive.store'", archivedBelow)
+	}
+	codec, err := getBlockCodec()
 	if err != nil {
 		return nil, err
 	}
-	blockchain := &blockchain{0, nil, nil, nil}
+	blockchain := &blockchain{0, nil, nil, nil, prunedBelow, archive, archivedBelow, codec, openchainDB}
 	blockchain.size = size
 	if size > 0 {
-		previousBlock, err := fetchBlockFromDB(size - 1)
+		previousBlock, err := fetchBlockFromDB(openchainDB, size-1)
 		if err != nil {
 			return nil, err
 		}
@@ -73,7 +101,7 @@ func newBlockchain() (*blockchain, error) {
 
 func (blockchain *blockchain) startIndexer() (err error) {
 	if indexBlockDataSynchronously {
-		blockchain.indexer = newBlockchainIndexerSync()
+		blockchain.indexer = newBlockchainIndexerSync(blockchain.openchainDB)
 	} else {
 		blockchain.indexer = newBlockchainIndexerAsync()
 	}
@@ -96,7 +124,190 @@ func (blockchain *blockchain) getSize() uint64 {
 
 // getBlock get block at arbitrary height in block chain
 func (blockchain *blockchain) getBlock(blockNumber uint64) (*protos.Block, error) {
-	return fetchBlockFromDB(blockNumber)
+	block, err := fetchBlockFromDB(blockchain.openchainDB, blockNumber)
+	if err == nil && block == nil && blockchain.isArchived(blockNumber) {
+		block, err = blockchain.fetchBlockFromArchive(blockNumber)
+	}
+	if err == nil && block == nil && blockchain.isPruned(blockNumber) {
+		return nil, ErrPruned
+	}
+	return block, err
+}
+
+// getBlockFromSnapshot get block at arbitrary height in a DB snapshot of the
+// block chain, or in the archive if it was moved there
+func (blockchain *blockchain) getBlockFromSnapshot(snapshot db.Snapshot, blockNumber uint64) (*protos.Block, error) {
+	block, err := fetchBlockFromSnapshot(blockchain.openchainDB, snapshot, blockNumber)
+	if err == nil && block == nil && blockchain.isArchived(blockNumber) {
+		return blockchain.fetchBlockFromArchive(blockNumber)
+	}
+	return block, err
+}
+
+// getPrunedBelow returns the number of the lowest block kept after the
+// genesis block, 0 if the blockchain was never pruned
+func (blockchain *blockchain) getPrunedBelow() uint64 {
+	return atomic.LoadUint64(&blockchain.prunedBelow)
+}
+
+// isPruned tells whether the block was deleted by pruning
+func (blockchain *blockchain) isPruned(blockNumber uint64) bool {
+	return blockNumber > 0 && blockNumber < blockchain.getPrunedBelow()
+}
+
+// setPrunedBelow marks the blocks below blockNumber but the genesis block as
+// pruned, e.g. when they were never stored
+func (blockchain *blockchain) setPrunedBelow(blockNumber uint64) error {
+	err := blockchain.openchainDB.Put(blockchain.openchainDB.BlockchainCF, prunedBelowKey, encodeUint64(blockNumber))
+	if err != nil {
+		return err
+	}
+	atomic.StoreUint64(&blockchain.prunedBelow, blockNumber)
+	return nil
+}
+
+// getArchivedBelow returns the number of the lowest block kept in the DB
+// after the genesis block, 0 if no block was ever archived
+func (blockchain *blockchain) getArchivedBelow() uint64 {
+	return atomic.LoadUint64(&blockchain.archivedBelow)
+}
+
+// isArchived tells whether the block was moved to the archive
+func (blockchain *blockchain) isArchived(blockNumber uint64) bool {
+	return blockNumber > 0 && blockNumber < blockchain.getArchivedBelow()
+}
+
+func (blockchain *blockchain) fetchBlockFromArchive(blockNumber uint64) (*protos.Block, error) {
+	blockBytes, err := blockchain.archive.get(blockNumber)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching block %d from the archive: %s", blockNumber, err)
+	}
+	if blockBytes == nil {
+		return nil, nil
+	}
+	return decodeBlock(blockBytes)
+}
+
+// archiveBlocks moves the blocks below blockNumber but the genesis block
+// from the DB to the archive. Their indexes are kept in the DB. Returns the
+// number of blocks archived.
+func (blockchain *blockchain) archiveBlocks(blockNumber uint64) (uint64, error) {
+	if blockchain.archive == nil {
+		return 0, fmt.Errorf("No block archive is configured in 'ledger.archive.store'")
+	}
+	if blockNumber >= blockchain.getSize() {
+		return 0, ErrOutOfBounds
+	}
+	start := blockchain.getArchivedBelow()
+	if start == 0 {
+		start = 1
+	}
+	var archived uint64
+	for ; start < blockNumber; start += pruneBatchSize {
+		end := start + pruneBatchSize
+		if end > blockNumber {
+			end = blockNumber
+		}
+		n, err := blockchain.archiveBlockRange(start, end)
+		archived += n
+		if err != nil {
+			return archived, err
+		}
+	}
+	return archived, nil
+}
+
+// archiveBlockRange stores the blocks from start up to end in the archive,
+// then deletes them from the DB in one write and moves the archiving mark
+// to end. The blocks stored again by an archiving interrupted before the
+// write are replaced.
+func (blockchain *blockchain) archiveBlockRange(start, end uint64) (uint64, error) {
+	writeBatch := db.NewWriteBatch()
+	defer writeBatch.Destroy()
+
+	var archived uint64
+	for blockNumber := start; blockNumber < end; blockNumber++ {
+		blockBytes, err := blockchain.openchainDB.GetFromBlockchainCF(encodeBlockNumberDBKey(blockNumber))
+		if err != nil {
+			return 0, err
+		}
+		if blockBytes == nil {
+			// the block was never transferred to this peer, or was pruned
+			continue
+		}
+		if err = blockchain.archive.put(blockNumber, blockBytes); err != nil {
+			return 0, fmt.Errorf("Error archiving block %d: %s", blockNumber, err)
+		}
+		writeBatch.DeleteCF(blockchain.openchainDB.BlockchainCF, encodeBlockNumberDBKey(blockNumber))
+		archived++
+	}
+	writeBatch.PutCF(blockchain.openchainDB.BlockchainCF, archivedBelowKey, encodeUint64(end))
+
+	err := blockchain.openchainDB.Write(writeBatch)
+	if err != nil {
+		return 0, err
+	}
+	atomic.StoreUint64(&blockchain.archivedBelow, end)
+	return archived, nil
+}
+
+// pruneBlocks deletes the blocks below blockNumber along with their
+// indexes, but the genesis block. Returns the number of blocks deleted.
+func (blockchain *blockchain) pruneBlocks(blockNumber uint64) (uint64, error) {
+	if blockNumber >= blockchain.getSize() {
+		return 0, ErrOutOfBounds
+	}
+	start := blockchain.getPrunedBelow()
+	if start == 0 {
+		start = 1
+	}
+	var pruned uint64
+	for ; start < blockNumber; start += pruneBatchSize {
+		end := start + pruneBatchSize
+		if end > blockNumber {
+			end = blockNumber
+		}
+		n, err := blockchain.pruneBlockRange(start, end)
+		pruned += n
+		if err != nil {
+			return pruned, err
+		}
+	}
+	return pruned, nil
+}
+
+// pruneBlockRange deletes the blocks from start up to end in one write and
+// moves the pruning mark to end
+func (blockchain *blockchain) pruneBlockRange(start, end uint64) (uint64, error) {
+	writeBatch := db.NewWriteBatch()
+	defer writeBatch.Destroy()
+
+	var pruned uint64
+	for blockNumber := start; blockNumber < end; blockNumber++ {
+		block, err := fetchBlockFromDB(blockchain.openchainDB, blockNumber)
+		if err != nil {
+			return 0, err
+		}
+		if block == nil {
+			// the block was never transferred to this peer, or was archived
+			continue
+		}
+		blockHash, err := block.GetHash()
+		if err != nil {
+			return 0, err
+		}
+		removeIndexDataForPersistence(blockchain.openchainDB, block, blockNumber, blockHash, writeBatch)
+		writeBatch.DeleteCF(blockchain.openchainDB.BlockchainCF, encodeBlockNumberDBKey(blockNumber))
+		pruned++
+	}
+	writeBatch.PutCF(blockchain.openchainDB.BlockchainCF, prunedBelowKey, encodeUint64(end))
+
+	err := blockchain.openchainDB.Write(writeBatch)
+	if err != nil {
+		return 0, err
+	}
+	atomic.StoreUint64(&blockchain.prunedBelow, end)
+	return pruned, nil
 }
 
 // getBlockByHash get block by block hash
@@ -184,11 +395,12 @@ func (blockchain *blockchain) getBlockchainInfoForBlock(height uint64, block *pr
 func (blockchain *blockchain) buildBlock(block *protos.Block, stateHash []byte) *protos.Block {
 	block.SetPreviousBlockHash(blockchain.previousBlockHash)
 	block.StateHash = stateHash
+	block.ChainID = blockchain.openchainDB.ChainID()
 	return block
 }
 
 func (blockchain *blockchain) addPersistenceChangesForNewBlock(ctx context.Context,
-	block *protos.Block, stateHash []byte, writeBatch *gorocksdb.WriteBatch) (uint64, error) {
+	block *protos.Block, stateHash []byte, writeBatch *db.WriteBatch) (uint64, error) {
 	block = blockchain.buildBlock(block, stateHash)
 	if block.NonHashData == nil {
 		block.NonHashData = &protos.NonHashData{LocalLedgerCommitTimestamp: util.CreateUtcTimestamp()}
@@ -200,12 +412,12 @@ func (blockchain *blockchain) addPersistenceChangesForNewBlock(ctx context.Conte
 	if err != nil {
 		return 0, err
 	}
-	blockBytes, blockBytesErr := block.Bytes()
+	blockBytes, blockBytesErr := encodeBlock(block, blockchain.codec)
 	if blockBytesErr != nil {
 		return 0, blockBytesErr
 	}
-	writeBatch.PutCF(db.GetDBHandle().BlockchainCF, encodeBlockNumberDBKey(blockNumber), blockBytes)
-	writeBatch.PutCF(db.GetDBHandle().BlockchainCF, blockCountKey, encodeUint64(blockNumber+1))
+	writeBatch.PutCF(blockchain.openchainDB.BlockchainCF, encodeBlockNumberDBKey(blockNumber), blockBytes)
+	writeBatch.PutCF(blockchain.openchainDB.BlockchainCF, blockCountKey, encodeUint64(blockNumber+1))
 	if blockchain.indexer.isSynchronous() {
 		blockchain.indexer.createIndexesSync(block, blockNumber, blockHash, writeBatch)
 	}
@@ -226,13 +438,13 @@ func (blockchain *blockchain) blockPersistenceStatus(success bool) {
 }
 
 func (blockchain *blockchain) persistRawBlock(block *protos.Block, blockNumber uint64) error {
-	blockBytes, blockBytesErr := block.Bytes()
+	blockBytes, blockBytesErr := encodeBlock(block, blockchain.codec)
 	if blockBytesErr != nil {
 		return blockBytesErr
 	}
-	writeBatch := gorocksdb.NewWriteBatch()
+	writeBatch := db.NewWriteBatch()
 	defer writeBatch.Destroy()
-	writeBatch.PutCF(db.GetDBHandle().BlockchainCF, encodeBlockNumberDBKey(blockNumber), blockBytes)
+	writeBatch.PutCF(blockchain.openchainDB.BlockchainCF, encodeBlockNumberDBKey(blockNumber), blockBytes)
 
 	blockHash, err := block.GetHash()
 	if err != nil {
@@ -243,7 +455,7 @@ func (blockchain *blockchain) persistRawBlock(block *protos.Block, blockNumber u
 	// really blockchain height, not size.
 	if blockchain.getSize() < blockNumber+1 {
 		sizeBytes := encodeUint64(blockNumber + 1)
-		writeBatch.PutCF(db.GetDBHandle().BlockchainCF, blockCountKey, sizeBytes)
+		writeBatch.PutCF(blockchain.openchainDB.BlockchainCF, blockCountKey, sizeBytes)
 		blockchain.size = blockNumber + 1
 		blockchain.previousBlockHash = blockHash
 	}
@@ -252,9 +464,7 @@ func (blockchain *blockchain) persistRawBlock(block *protos.Block, blockNumber u
 		blockchain.indexer.createIndexesSync(block, blockNumber, blockHash, writeBatch)
 	}
 
-	opt := gorocksdb.NewDefaultWriteOptions()
-	defer opt.Destroy()
-	err = db.GetDBHandle().DB.Write(opt, writeBatch)
+	err = blockchain.openchainDB.Write(writeBatch)
 	if err != nil {
 		return err
 	}
@@ -288,27 +498,38 @@ func (blockchain *blockchain) persistRawBlock(block *protos.Block, blockNumber u
 // 	return nil
 // }
 
-func fetchBlockFromDB(blockNumber uint64) (*protos.Block, error) {
-	blockBytes, err := db.GetDBHandle().GetFromBlockchainCF(encodeBlockNumberDBKey(blockNumber))
+func fetchBlockFromDB(openchainDB *db.OpenchainDB, blockNumber uint64) (*protos.Block, error) {
+	blockBytes, err := openchainDB.GetFromBlockchainCF(encodeBlockNumberDBKey(blockNumber))
 	if err != nil {
 		return nil, err
 	}
 	if blockBytes == nil {
 		return nil, nil
 	}
-	return protos.UnmarshallBlock(blockBytes)
+	return decodeBlock(blockBytes)
 }
 
-func fetchTransactionFromDB(blockNum uint64, txIndex uint64) (*protos.Transaction, error) {
-	block, err := fetchBlockFromDB(blockNum)
+func fetchBlockFromSnapshot(openchainDB *db.OpenchainDB, snapshot db.Snapshot, blockNumber uint64) (*protos.Block, error) {
+	blockBytes, err := openchainDB.GetFromBlockchainCFSnapshot(snapshot, encodeBlockNumberDBKey(blockNumber))
+	if err != nil {
+		return nil, err
+	}
+	if blockBytes == nil {
+		return nil, nil
+	}
+	return decodeBlock(blockBytes)
+}
+
+func fetchTransactionFromDB(openchainDB *db.OpenchainDB, blockNum uint64, txIndex uint64) (*protos.Transaction, error) {
+	block, err := fetchBlockFromDB(openchainDB, blockNum)
 	if err != nil {
 		return nil, err
 	}
 	return block.GetTransactions()[txIndex], nil
 }
 
-func fetchBlockchainSizeFromDB() (uint64, error) {
-	bytes, err := db.GetDBHandle().GetFromBlockchainCF(blockCountKey)
+func fetchBlockchainSizeFromDB(openchainDB *db.OpenchainDB) (uint64, error) {
+	bytes, err := openchainDB.GetFromBlockchainCF(blockCountKey)
 	if err != nil {
 		return 0, err
 	}
@@ -318,8 +539,8 @@ func fetchBlockchainSizeFromDB() (uint64, error) {
 	return decodeToUint64(bytes), nil
 }
 
-func fetchBlockchainSizeFromSnapshot(snapshot *gorocksdb.Snapshot) (uint64, error) {
-	blockNumberBytes, err := db.GetDBHandle().GetFromBlockchainCFSnapshot(snapshot, blockCountKey)
+func fetchBlockchainSizeFromSnapshot(openchainDB *db.OpenchainDB, snapshot db.Snapshot) (uint64, error) {
+	blockNumberBytes, err := openchainDB.GetFromBlockchainCFSnapshot(snapshot, blockCountKey)
 	if err != nil {
 		return 0, err
 	}
@@ -330,7 +551,31 @@ func fetchBlockchainSizeFromSnapshot(snapshot *gorocksdb.Snapshot) (uint64, erro
 	return blockNumber, nil
 }
 
+func fetchPrunedBelowFromDB(openchainDB *db.OpenchainDB) (uint64, error) {
+	bytes, err := openchainDB.GetFromBlockchainCF(prunedBelowKey)
+	if err != nil {
+		return 0, err
+	}
+	if bytes == nil {
+		return 0, nil
+	}
+	return decodeToUint64(bytes), nil
+}
+
+func fetchArchivedBelowFromDB(openchainDB *db.OpenchainDB) (uint64, error) {
+	bytes, err := openchainDB.GetFromBlockchainCF(archivedBelowKey)
+	if err != nil {
+		return 0, err
+	}
+	if bytes == nil {
+		return 0, nil
+	}
+	return decodeToUint64(bytes), nil
+}
+
 var blockCountKey = []byte("blockCount")
+var prunedBelowKey = []byte("prunedBelow")
+var archivedBelowKey = []byte("archivedBelow")
 
 func encodeBlockNumberDBKey(blockNumber uint64) []byte {
 	return encodeUint64(blockNumber)
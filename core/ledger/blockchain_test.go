@@ -20,9 +20,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/hyperledger/fabric/core/db"
 	"github.com/hyperledger/fabric/core/ledger/testutil"
 	"github.com/hyperledger/fabric/core/util"
 	"github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
 )
 
 func TestBlockChain_SingleBlock(t *testing.T) {
@@ -112,3 +114,58 @@ func TestBlockchainBlockLedgerCommitTimestamp(t *testing.T) {
 		t.Fatal("Expected block time to be after start time")
 	}
 }
+
+func TestBlockchainCompression(t *testing.T) {
+	testDBWrapper.CreateFreshDB(t)
+	defer viper.Set("ledger.blockchain.compression", "")
+	storedCodecID := func(blockNumber uint64) byte {
+		storedBytes, err := db.GetDBHandle().GetFromBlockchainCF(encodeBlockNumberDBKey(blockNumber))
+		testutil.AssertNoError(t, err, "Error reading the stored block")
+		return storedBlockCodecID(storedBytes)
+	}
+
+	// blocks stored uncompressed stay readable once compression is configured
+	blockchainTestWrapper := newTestBlockchainWrapper(t)
+	allBlocks, _, err := blockchainTestWrapper.populateBlockChainWithSampleData()
+	testutil.AssertNoError(t, err, "Error populating the blockchain")
+	testutil.AssertEquals(t, storedCodecID(0), byte(0))
+
+	viper.Set("ledger.blockchain.compression", "snappy")
+	blockchainTestWrapper = newTestBlockchainWrapper(t)
+	newBlockNumber := blockchainTestWrapper.addNewBlock(protos.NewBlock(nil, []byte("payload")), []byte("stateHash"))
+	testutil.AssertEquals(t, storedCodecID(newBlockNumber), blockCodecs["snappy"].id)
+	testutil.AssertEquals(t, storedCodecID(0), byte(0))
+	testutil.AssertEquals(t, blockchainTestWrapper.getBlock(newBlockNumber).ConsensusMetadata, []byte("payload"))
+
+	checkBlocks := func() {
+		for i := range allBlocks {
+			expectedBlockHash, _ := allBlocks[i].GetHash()
+			blockHash, _ := blockchainTestWrapper.getBlock(uint64(i)).GetHash()
+			testutil.AssertEquals(t, blockHash, expectedBlockHash)
+		}
+	}
+	checkBlocks()
+
+	// the recompression rewrites the blocks not stored with the codec
+	viper.Set("ledger.blockchain.compression", "gzip")
+	result, err := RecompressBlocks()
+	testutil.AssertNoError(t, err, "Error recompressing the blocks")
+	testutil.AssertEquals(t, result.Blocks, newBlockNumber+1)
+	for i := uint64(0); i <= newBlockNumber; i++ {
+		testutil.AssertEquals(t, storedCodecID(i), blockCodecs["gzip"].id)
+	}
+	checkBlocks()
+	result, err = RecompressBlocks()
+	testutil.AssertNoError(t, err, "Error recompressing the blocks")
+	testutil.AssertEquals(t, result.Blocks, uint64(0))
+
+	viper.Set("ledger.blockchain.compression", "none")
+	_, err = RecompressBlocks()
+	testutil.AssertNoError(t, err, "Error decompressing the blocks")
+	testutil.AssertEquals(t, storedCodecID(newBlockNumber), byte(0))
+	checkBlocks()
+
+	viper.Set("ledger.blockchain.compression", "zip")
+	_, err = newBlockchain(db.GetDBHandle())
+	testutil.AssertError(t, err, "Expected an error for an unknown compression")
+}
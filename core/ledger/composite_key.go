@@ -0,0 +1,88 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ledger
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/compositekey"
+)
+
+// GetStateByPartialCompositeKey returns an iterator to get the keys (and values) of chaincodeID which
+// are composite keys of objectType starting with attributes, see package statemgmt/compositekey.
+// Unlike those of GetStateRangeScanIterator, the key-values are in lexical order of the keys, and so
+// of their attributes. committed is as for GetStateRangeScanIterator
+func (ledger *Ledger) GetStateByPartialCompositeKey(chaincodeID string, objectType string, attributes []string, committed bool) (statemgmt.RangeScanIterator, error) {
+	return getStateByPartialCompositeKey(ledger, chaincodeID, objectType, attributes, committed)
+}
+
+// GetStateByPartialCompositeKey - see Ledger.GetStateByPartialCompositeKey
+func (simulator *TxSimulator) GetStateByPartialCompositeKey(chaincodeID string, objectType string, attributes []string, committed bool) (statemgmt.RangeScanIterator, error) {
+	return getStateByPartialCompositeKey(simulator, chaincodeID, objectType, attributes, committed)
+}
+
+func getStateByPartialCompositeKey(txState TxState, chaincodeID string, objectType string, attributes []string, committed bool) (statemgmt.RangeScanIterator, error) {
+	startKey, endKey, err := compositekey.Range(objectType, attributes)
+	if err != nil {
+		return nil, newLedgerError(ErrorTypeInvalidArgument, err.Error())
+	}
+	itr, err := txState.GetStateRangeScanIterator(chaincodeID, startKey, endKey, committed)
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Close()
+
+	sortedItr := &sortedRangeScanIterator{index: -1}
+	values := make(map[string][]byte)
+	for itr.Next() {
+		key, value := itr.GetKeyValue()
+		if strings.HasPrefix(key, startKey) {
+			sortedItr.keys = append(sortedItr.keys, key)
+			values[key] = value
+		}
+	}
+	sort.Strings(sortedItr.keys)
+	for _, key := range sortedItr.keys {
+		sortedItr.values = append(sortedItr.values, values[key])
+	}
+	return sortedItr, nil
+}
+
+// sortedRangeScanIterator - an implementation of interface 'statemgmt.RangeScanIterator'
+// over key-values sorted by key
+type sortedRangeScanIterator struct {
+	keys   []string
+	values [][]byte
+	index  int
+}
+
+// Next - see interface 'statemgmt.RangeScanIterator' for details
+func (itr *sortedRangeScanIterator) Next() bool {
+	itr.index++
+	return itr.index < len(itr.keys)
+}
+
+// GetKeyValue - see interface 'statemgmt.RangeScanIterator' for details
+func (itr *sortedRangeScanIterator) GetKeyValue() (string, []byte) {
+	return itr.keys[itr.index], itr.values[itr.index]
+}
+
+// Close - see interface 'statemgmt.RangeScanIterator' for details
+func (itr *sortedRangeScanIterator) Close() {
+}
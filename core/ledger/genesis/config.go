@@ -25,6 +25,7 @@ import (
 var loadConfigOnce sync.Once
 
 var genesis map[string]interface{}
+var genesisFile string
 var mode string
 var deploySystemChaincodeEnabled bool
 
@@ -35,6 +36,7 @@ func initConfigs() {
 func loadConfigs() {
 	genesisLogger.Info("Loading configurations...")
 	genesis = viper.GetStringMap("ledger.blockchain.genesisBlock")
+	genesisFile = viper.GetString("ledger.blockchain.genesisBlock.file")
 	mode = viper.GetString("chaincode.chaincoderunmode")
 	genesisLogger.Info("Configurations loaded: genesis=%s, mode=[%s], deploySystemChaincodeEnabled=[%t]",
 		genesis, mode, deploySystemChaincodeEnabled)
@@ -52,6 +54,11 @@ func getGenesis() map[string]interface{} {
 	return genesis
 }
 
+func getGenesisFile() string {
+	initConfigs()
+	return genesisFile
+}
+
 func getMode() string {
 	initConfigs()
 	return mode
@@ -17,158 +17,265 @@ limitations under the License.
 package genesis
 
 import (
+	"bytes"
 	"fmt"
 	"sync"
 
 	"golang.org/x/net/context"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/chaincode"
 	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/genesismaker"
 	"github.com/hyperledger/fabric/protos"
 	"github.com/op/go-logging"
 )
 
 var genesisLogger = logging.MustGetLogger("genesis")
 
-var makeGenesisError error
-var once sync.Once
+// chainGenesis records the genesis block of a chain being made once
+type chainGenesis struct {
+	once sync.Once
+	err  error
+}
+
+var chainGeneses = make(map[string]*chainGenesis)
+var chainGenesesLock sync.Mutex
 
 // MakeGenesis creates the genesis block based on configuration in core.yaml
-// and adds it to the blockchain.
+// and adds it to the blockchain of the default chain. The genesis block made
+// by genesismaker and stored in 'ledger.blockchain.genesisBlock.file', if
+// set, replaces the genesis chaincodes of the configuration.
 func MakeGenesis() error {
-	once.Do(func() {
-		ledger, err := ledger.GetLedger()
-		if err != nil {
-			makeGenesisError = err
-			return
-		}
+	return MakeChainGenesis("")
+}
 
-		var genesisBlockExists bool
-		if ledger.GetBlockchainSize() == 0 {
-			genesisLogger.Info("Creating genesis block.")
-			ledger.BeginTxBatch(0)
-		} else {
-			genesisBlockExists = true
-		}
+// MakeChainGenesis creates the genesis block of the chain with the given ID,
+// the empty ID being the default chain, and adds it to the blockchain of the
+// chain. The genesis chaincodes of the configuration are deployed on every
+// chain, the genesis block made by genesismaker only on the default chain.
+func MakeChainGenesis(chainID string) error {
+	chainGenesesLock.Lock()
+	g, ok := chainGeneses[chainID]
+	if !ok {
+		g = &chainGenesis{}
+		chainGeneses[chainID] = g
+	}
+	chainGenesesLock.Unlock()
+
+	g.once.Do(func() {
+		g.err = makeGenesis(chainID)
+	})
+	return g.err
+}
 
-		var genesisTransactions []*protos.Transaction
+// makeGenesis makes the genesis block of the chain, see MakeChainGenesis
+func makeGenesis(chainID string) (makeGenesisError error) {
+	ledger, err := ledger.GetChainLedger(chainID)
+	if err != nil {
+		makeGenesisError = err
+		return
+	}
 
-		defer func() {
-			if !genesisBlockExists && makeGenesisError == nil {
-				genesisLogger.Info("Adding %d system chaincodes to the genesis block.", len(genesisTransactions))
-				ledger.CommitTxBatch(0, genesisTransactions, nil, nil)
-			}
-		}()
+	if file := getGenesisFile(); file != "" && chainID == "" {
+		makeGenesisError = makeGenesisFromFile(ledger, file)
+		return
+	}
 
-		//We are disabling the validity period deployment for now, we shouldn't even allow it if it's enabled in the configuration
-		allowDeployValidityPeriod := false
+	var genesisBlockExists bool
+	if ledger.GetBlockchainSize() == 0 {
+		genesisLogger.Info("Creating genesis block.")
+		ledger.BeginTxBatch(0)
+	} else {
+		genesisBlockExists = true
+	}
 
-		if isDeploySystemChaincodeEnabled() && allowDeployValidityPeriod {
-			vpTransaction, deployErr := deployUpdateValidityPeriodChaincode(genesisBlockExists)
+	var genesisTransactions []*protos.Transaction
 
-			if deployErr != nil {
-				genesisLogger.Error("Error deploying validity period system chaincode for genesis block.", deployErr)
-				makeGenesisError = deployErr
-				return
-			}
+	defer func() {
+		if !genesisBlockExists && makeGenesisError == nil {
+			genesisLogger.Info("Adding %d system chaincodes to the genesis block.", len(genesisTransactions))
+			ledger.CommitTxBatch(0, genesisTransactions, nil, nil)
+		}
+	}()
 
-			genesisTransactions = append(genesisTransactions, vpTransaction)
+	//We are disabling the validity period deployment for now, we shouldn't even allow it if it's enabled in the configuration
+	allowDeployValidityPeriod := false
+
+	if isDeploySystemChaincodeEnabled() && allowDeployValidityPeriod {
+		vpTransaction, deployErr := deployUpdateValidityPeriodChaincode(chainID, genesisBlockExists)
+
+		if deployErr != nil {
+			genesisLogger.Error("Error deploying validity period system chaincode for genesis block.", deployErr)
+			makeGenesisError = deployErr
+			return
 		}
 
-		if getGenesis() == nil {
+		genesisTransactions = append(genesisTransactions, vpTransaction)
+	}
+
+	if getGenesis() == nil {
+		genesisLogger.Info("No genesis block chaincodes defined.")
+	} else {
+
+		chaincodes, chaincodesOK := genesis["chaincodes"].(map[interface{}]interface{})
+		if !chaincodesOK {
 			genesisLogger.Info("No genesis block chaincodes defined.")
-		} else {
+			ledger.CommitTxBatch(0, genesisTransactions, nil, nil)
+			return
+		}
+
+		genesisLogger.Debug("Genesis chaincodes are %s", chaincodes)
+
+		for i := range chaincodes {
+			name := i.(string)
+			genesisLogger.Debug("Chaincode %s", name)
 
-			chaincodes, chaincodesOK := genesis["chaincodes"].(map[interface{}]interface{})
-			if !chaincodesOK {
-				genesisLogger.Info("No genesis block chaincodes defined.")
-				ledger.CommitTxBatch(0, genesisTransactions, nil, nil)
+			chaincode := chaincodes[name]
+			chaincodeMap, chaincodeMapOK := chaincode.(map[interface{}]interface{})
+			if !chaincodeMapOK {
+				genesisLogger.Error("Invalid chaincode defined in genesis configuration:", chaincode)
+				makeGenesisError = fmt.Errorf("Invalid chaincode defined in genesis configuration: %s", chaincode)
 				return
 			}
 
-			genesisLogger.Debug("Genesis chaincodes are %s", chaincodes)
+			path, pathOK := chaincodeMap["path"].(string)
+			if !pathOK {
+				genesisLogger.Error("Invalid chaincode URL defined in genesis configuration:", chaincodeMap["path"])
+				makeGenesisError = fmt.Errorf("Invalid chaincode URL defined in genesis configuration: %s", chaincodeMap["path"])
+				return
+			}
 
-			for i := range chaincodes {
-				name := i.(string)
-				genesisLogger.Debug("Chaincode %s", name)
+			chaincodeType, chaincodeTypeOK := chaincodeMap["type"].(string)
+			if !chaincodeTypeOK {
+				genesisLogger.Error("Invalid chaincode type defined in genesis configuration:", chaincodeMap["type"])
+				makeGenesisError = fmt.Errorf("Invalid chaincode type defined in genesis configuration: %s", chaincodeMap["type"])
+				return
+			}
 
-				chaincode := chaincodes[name]
-				chaincodeMap, chaincodeMapOK := chaincode.(map[interface{}]interface{})
-				if !chaincodeMapOK {
-					genesisLogger.Error("Invalid chaincode defined in genesis configuration:", chaincode)
-					makeGenesisError = fmt.Errorf("Invalid chaincode defined in genesis configuration: %s", chaincode)
-					return
-				}
+			if chaincodeType == "" {
+				chaincodeType = "GOLANG"
+			}
 
-				path, pathOK := chaincodeMap["path"].(string)
-				if !pathOK {
-					genesisLogger.Error("Invalid chaincode URL defined in genesis configuration:", chaincodeMap["path"])
-					makeGenesisError = fmt.Errorf("Invalid chaincode URL defined in genesis configuration: %s", chaincodeMap["path"])
-					return
-				}
+			chaincodeID := &protos.ChaincodeID{Path: path, Name: name}
 
-				chaincodeType, chaincodeTypeOK := chaincodeMap["type"].(string)
-				if !chaincodeTypeOK {
-					genesisLogger.Error("Invalid chaincode type defined in genesis configuration:", chaincodeMap["type"])
-					makeGenesisError = fmt.Errorf("Invalid chaincode type defined in genesis configuration: %s", chaincodeMap["type"])
-					return
-				}
+			genesisLogger.Debug("Genesis chaincodeID %s", chaincodeID)
 
-				if chaincodeType == "" {
-					chaincodeType = "GOLANG"
-				}
-
-				chaincodeID := &protos.ChaincodeID{Path: path, Name: name}
+			constructorMap, constructorMapOK := chaincodeMap["constructor"].(map[interface{}]interface{})
+			if !constructorMapOK {
+				genesisLogger.Error("Invalid chaincode constructor defined in genesis configuration:", chaincodeMap["constructor"])
+				makeGenesisError = fmt.Errorf("Invalid chaincode constructor defined in genesis configuration: %s", chaincodeMap["constructor"])
+				return
+			}
 
-				genesisLogger.Debug("Genesis chaincodeID %s", chaincodeID)
+			var spec protos.ChaincodeSpec
+			if constructorMap == nil {
+				genesisLogger.Debug("Genesis chaincode has no constructor.")
+				spec = protos.ChaincodeSpec{Type: protos.ChaincodeSpec_Type(protos.ChaincodeSpec_Type_value[chaincodeType]), ChaincodeID: chaincodeID}
+			} else {
 
-				constructorMap, constructorMapOK := chaincodeMap["constructor"].(map[interface{}]interface{})
-				if !constructorMapOK {
-					genesisLogger.Error("Invalid chaincode constructor defined in genesis configuration:", chaincodeMap["constructor"])
-					makeGenesisError = fmt.Errorf("Invalid chaincode constructor defined in genesis configuration: %s", chaincodeMap["constructor"])
+				_, ctorArgsOK := constructorMap["args"]
+				if !ctorArgsOK {
+					genesisLogger.Error("Invalid chaincode constructor args defined in genesis configuration:", constructorMap["args"])
+					makeGenesisError = fmt.Errorf("Invalid chaincode constructor args defined in genesis configuration: %s", constructorMap["args"])
 					return
 				}
 
-				var spec protos.ChaincodeSpec
-				if constructorMap == nil {
-					genesisLogger.Debug("Genesis chaincode has no constructor.")
-					spec = protos.ChaincodeSpec{Type: protos.ChaincodeSpec_Type(protos.ChaincodeSpec_Type_value[chaincodeType]), ChaincodeID: chaincodeID}
-				} else {
-
-					_, ctorArgsOK := constructorMap["args"]
-					if !ctorArgsOK {
-						genesisLogger.Error("Invalid chaincode constructor args defined in genesis configuration:", constructorMap["args"])
-						makeGenesisError = fmt.Errorf("Invalid chaincode constructor args defined in genesis configuration: %s", constructorMap["args"])
-						return
-					}
-
-					ctorArgs, ctorArgsOK := constructorMap["args"].([]interface{})
-					var ctorArgsStringArray []string
-					if ctorArgsOK {
-						genesisLogger.Debug("Genesis chaincode constructor args %s", ctorArgs)
-						for j := 0; j < len(ctorArgs); j++ {
-							ctorArgsStringArray = append(ctorArgsStringArray, ctorArgs[j].(string))
-						}
+				ctorArgs, ctorArgsOK := constructorMap["args"].([]interface{})
+				var ctorArgsStringArray []string
+				if ctorArgsOK {
+					genesisLogger.Debug("Genesis chaincode constructor args %s", ctorArgs)
+					for j := 0; j < len(ctorArgs); j++ {
+						ctorArgsStringArray = append(ctorArgsStringArray, ctorArgs[j].(string))
 					}
-					spec = protos.ChaincodeSpec{Type: protos.ChaincodeSpec_Type(protos.ChaincodeSpec_Type_value[chaincodeType]), ChaincodeID: chaincodeID, CtorMsg: &protos.ChaincodeInput{Args: ctorArgsStringArray}}
 				}
+				spec = protos.ChaincodeSpec{Type: protos.ChaincodeSpec_Type(protos.ChaincodeSpec_Type_value[chaincodeType]), ChaincodeID: chaincodeID, CtorMsg: &protos.ChaincodeInput{Args: ctorArgsStringArray}}
+			}
 
-				transaction, _, deployErr := DeployLocal(context.Background(), &spec, genesisBlockExists)
-				if deployErr != nil {
-					genesisLogger.Error("Error deploying chaincode for genesis block.", deployErr)
-					makeGenesisError = deployErr
-					return
-				}
+			transaction, _, deployErr := deployLocal(context.Background(), chainID, &spec, genesisBlockExists)
+			if deployErr != nil {
+				genesisLogger.Error("Error deploying chaincode for genesis block.", deployErr)
+				makeGenesisError = deployErr
+				return
+			}
 
-				genesisTransactions = append(genesisTransactions, transaction)
+			genesisTransactions = append(genesisTransactions, transaction)
 
-			} //for
+		} //for
 
-		} //else
-	})
+	} //else
 	return makeGenesisError
 }
 
+// makeGenesisFromFile deploys the chaincodes of the genesis block stored in
+// file, and adds the block to the blockchain if it is empty. The block is
+// added as made, so all the peers bootstrapping from the file have the same
+// genesis block. Fails if the blockchain started from another genesis block.
+func makeGenesisFromFile(lgr *ledger.Ledger, file string) error {
+	block, err := genesismaker.Read(file)
+	if err != nil {
+		return err
+	}
+	genesisBlockExists := lgr.GetBlockchainSize() > 0
+	if genesisBlockExists {
+		existing, err := lgr.GetBlockByNumber(0)
+		if err != nil {
+			return err
+		}
+		if !sameGenesisBlock(existing, block) {
+			return fmt.Errorf("The blockchain did not start from the genesis block %s", file)
+		}
+	} else {
+		genesisLogger.Info("Creating genesis block from %s.", file)
+		lgr.BeginTxBatch(0)
+	}
+
+	for _, transaction := range block.Transactions {
+		_, err = chaincode.Execute(context.Background(), chaincode.GetChain(chaincode.DefaultChain), transaction)
+		if err != nil {
+			genesisLogger.Error("Error deploying chaincode %s for genesis block: %s", transaction.Uuid, err)
+			if !genesisBlockExists {
+				lgr.RollbackTxBatch(0)
+			}
+			return err
+		}
+	}
+	if genesisBlockExists {
+		return nil
+	}
+	genesisLogger.Info("Adding %d chaincodes to the genesis block.", len(block.Transactions))
+	return lgr.CommitTxBatch(0, block.Transactions, nil, block.ConsensusMetadata)
+}
+
+// sameGenesisBlock tells whether the genesis block of the blockchain was made
+// from the block made by genesismaker
+func sameGenesisBlock(genesisBlock *protos.Block, made *protos.Block) bool {
+	if !bytes.Equal(genesisBlock.ConsensusMetadata, made.ConsensusMetadata) ||
+		len(genesisBlock.Transactions) != len(made.Transactions) {
+		return false
+	}
+	for i, transaction := range made.Transactions {
+		if !proto.Equal(genesisBlock.Transactions[i], transaction) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetConfig returns the consortium and the consensus settings carried by the
+// genesis block of the blockchain, nil if it was not made by genesismaker
+func GetConfig() (*genesismaker.Config, error) {
+	lgr, err := ledger.GetLedger()
+	if err != nil {
+		return nil, err
+	}
+	block, err := lgr.GetBlockByNumber(0)
+	if err != nil {
+		return nil, err
+	}
+	return genesismaker.GetConfig(block)
+}
+
 //BuildLocal builds a given chaincode code
 func BuildLocal(context context.Context, spec *protos.ChaincodeSpec) (*protos.ChaincodeDeploymentSpec, error) {
 	genesisLogger.Debug("Received build request for chaincode spec: %v", spec)
@@ -194,6 +301,16 @@ func BuildLocal(context context.Context, spec *protos.ChaincodeSpec) (*protos.Ch
 
 // DeployLocal deploys the supplied chaincode image to the local peer
 func DeployLocal(ctx context.Context, spec *protos.ChaincodeSpec, gbexists bool) (*protos.Transaction, []byte, error) {
+	return deployLocal(ctx, "", spec, gbexists)
+}
+
+// deployLocal deploys the supplied chaincode image on the chain with the given ID
+func deployLocal(ctx context.Context, chainID string, spec *protos.ChaincodeSpec, gbexists bool) (*protos.Transaction, []byte, error) {
+	chain := chaincode.GetChain(chaincode.GetChainName(chainID))
+	if chain == nil {
+		return nil, nil, fmt.Errorf("No chaincode support for chain [%s]", chainID)
+	}
+
 	// First build and get the deployment spec
 	chaincodeDeploymentSpec, err := BuildLocal(ctx, spec)
 
@@ -204,7 +321,7 @@ func DeployLocal(ctx context.Context, spec *protos.ChaincodeSpec, gbexists bool)
 
 	var transaction *protos.Transaction
 	if gbexists {
-		ledger, err := ledger.GetLedger()
+		ledger, err := ledger.GetChainLedger(chainID)
 		if err != nil {
 			return nil, nil, fmt.Errorf("Failed to get handle to ledger (%s)", err)
 		}
@@ -222,16 +339,17 @@ func DeployLocal(ctx context.Context, spec *protos.ChaincodeSpec, gbexists bool)
 		if err != nil {
 			return nil, nil, fmt.Errorf("Error deploying chaincode: %s ", err)
 		}
+		transaction.ChainID = chainID
 	}
 
 	//chaincode.NewChaincodeSupport(chaincode.DefaultChain, peer.GetPeerEndpoint, false, 120000)
 	// The secHelper is set during creat ChaincodeSupport, so we don't need this step
 	//ctx = context.WithValue(ctx, "security", secCxt)
-	result, err := chaincode.Execute(ctx, chaincode.GetChain(chaincode.DefaultChain), transaction)
+	result, err := chaincode.Execute(ctx, chain, transaction)
 	return transaction, result, err
 }
 
-func deployUpdateValidityPeriodChaincode(gbexists bool) (*protos.Transaction, error) {
+func deployUpdateValidityPeriodChaincode(chainID string, gbexists bool) (*protos.Transaction, error) {
 	//TODO It should be configurable, not hardcoded
 	vpChaincodePath := "github.com/hyperledger/fabric/core/system_chaincode/validity_period_update"
 	vpFunction := "init"
@@ -253,11 +371,10 @@ func deployUpdateValidityPeriodChaincode(gbexists bool) (*protos.Transaction, er
 
 	validityPeriodSpec.SecureContext = string(vpToken)
 
-	vpTransaction, _, deployErr := DeployLocal(context.Background(), validityPeriodSpec, gbexists)
+	vpTransaction, _, deployErr := deployLocal(context.Background(), chainID, validityPeriodSpec, gbexists)
 
 	if deployErr != nil {
 		genesisLogger.Error("Error deploying validity period chaincode for genesis block.", deployErr)
-		makeGenesisError = deployErr
 		return nil, deployErr
 	}
 
@@ -0,0 +1,216 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genesismaker
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	google_protobuf "google/protobuf"
+	"gopkg.in/yaml.v2"
+
+	"github.com/hyperledger/fabric/protos"
+)
+
+// Spec declares the content of a genesis block. The same spec always makes
+// the same block, which all the peers of a network then bootstrap from, see
+// 'ledger.blockchain.genesisBlock.file'.
+type Spec struct {
+	Timestamp  string            `yaml:"timestamp"`  // of the deploy transactions, RFC 3339, the epoch if empty
+	Chaincodes []ChaincodeSpec   `yaml:"chaincodes"` // deployed in this order
+	Consortium []Member          `yaml:"consortium"`
+	Consensus  map[string]string `yaml:"consensus"` // settings of the consensus plugin
+}
+
+// ChaincodeSpec is a system chaincode deployed by the genesis block
+type ChaincodeSpec struct {
+	Name     string   `yaml:"name"`
+	Path     string   `yaml:"path"`
+	Type     string   `yaml:"type"` // GOLANG if empty
+	Function string   `yaml:"function"`
+	Args     []string `yaml:"args"`
+}
+
+// Member is an organization of the consortium running the network
+type Member struct {
+	Name     string `yaml:"name" json:"name"`
+	Cert     string `yaml:"cert" json:"cert"`  // PEM certificate of the member
+	CertFile string `yaml:"certFile" json:"-"` // read into Cert, relative to the spec
+}
+
+// Config is the part of the spec not executed, carried by the genesis block
+// in its consensus metadata
+type Config struct {
+	Consortium []Member          `json:"consortium,omitempty"`
+	Consensus  map[string]string `json:"consensus,omitempty"`
+}
+
+// LoadSpec reads a spec from a YAML file. The certificate files of the
+// members are read relative to the directory of the spec.
+func LoadSpec(path string) (*Spec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec := &Spec{}
+	if err = yaml.Unmarshal(data, spec); err != nil {
+		return nil, fmt.Errorf("Error parsing the genesis spec %s: %s", path, err)
+	}
+	for i := range spec.Consortium {
+		member := &spec.Consortium[i]
+		if member.CertFile == "" {
+			continue
+		}
+		certFile := member.CertFile
+		if !filepath.IsAbs(certFile) {
+			certFile = filepath.Join(filepath.Dir(path), certFile)
+		}
+		cert, err := ioutil.ReadFile(certFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading the certificate of member %s: %s", member.Name, err)
+		}
+		member.Cert = string(cert)
+		member.CertFile = ""
+	}
+	return spec, nil
+}
+
+// Make builds the genesis block of the spec. The state hash of the block is
+// left empty, the peers fill it in as they execute the deploy transactions.
+func Make(spec *Spec) (*protos.Block, error) {
+	timestamp := &google_protobuf.Timestamp{}
+	if spec.Timestamp != "" {
+		t, err := time.Parse(time.RFC3339, spec.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid genesis timestamp '%s': %s", spec.Timestamp, err)
+		}
+		timestamp = &google_protobuf.Timestamp{Seconds: t.Unix(), Nanos: int32(t.Nanosecond())}
+	}
+
+	var transactions []*protos.Transaction
+	names := make(map[string]bool)
+	for _, chaincode := range spec.Chaincodes {
+		if chaincode.Name == "" || chaincode.Path == "" {
+			return nil, fmt.Errorf("Genesis chaincodes need a name and a path, got %+v", chaincode)
+		}
+		if names[chaincode.Name] {
+			return nil, fmt.Errorf("Genesis chaincode %s is deployed twice", chaincode.Name)
+		}
+		names[chaincode.Name] = true
+		transaction, err := newDeployTransaction(chaincode, timestamp)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	for _, member := range spec.Consortium {
+		if err := checkMember(member); err != nil {
+			return nil, err
+		}
+	}
+	var metadata []byte
+	if len(spec.Consortium) > 0 || len(spec.Consensus) > 0 {
+		// the map keys are sorted, the metadata is the same for a spec
+		var err error
+		metadata, err = json.Marshal(&Config{spec.Consortium, spec.Consensus})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return protos.NewBlock(transactions, metadata), nil
+}
+
+// newDeployTransaction deploys a system chaincode, as the genesis block made
+// from the configuration of the peer does. The chaincode name is the UUID.
+func newDeployTransaction(chaincode ChaincodeSpec, timestamp *google_protobuf.Timestamp) (*protos.Transaction, error) {
+	chaincodeType := chaincode.Type
+	if chaincodeType == "" {
+		chaincodeType = "GOLANG"
+	}
+	typeValue, ok := protos.ChaincodeSpec_Type_value[chaincodeType]
+	if !ok {
+		return nil, fmt.Errorf("Unknown type %s of genesis chaincode %s", chaincodeType, chaincode.Name)
+	}
+	spec := &protos.ChaincodeSpec{Type: protos.ChaincodeSpec_Type(typeValue),
+		ChaincodeID: &protos.ChaincodeID{Path: chaincode.Path, Name: chaincode.Name}}
+	if chaincode.Function != "" || len(chaincode.Args) > 0 {
+		spec.CtorMsg = &protos.ChaincodeInput{Function: chaincode.Function, Args: chaincode.Args}
+	}
+	deploymentSpec := &protos.ChaincodeDeploymentSpec{ExecEnv: protos.ChaincodeDeploymentSpec_SYSTEM, ChaincodeSpec: spec}
+	transaction, err := protos.NewChaincodeDeployTransaction(deploymentSpec, chaincode.Name)
+	if err != nil {
+		return nil, err
+	}
+	transaction.Timestamp = timestamp
+	return transaction, nil
+}
+
+func checkMember(member Member) error {
+	if member.Name == "" {
+		return fmt.Errorf("Consortium members need a name")
+	}
+	block, _ := pem.Decode([]byte(member.Cert))
+	if block == nil {
+		return fmt.Errorf("No PEM certificate for consortium member %s", member.Name)
+	}
+	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+		return fmt.Errorf("Invalid certificate for consortium member %s: %s", member.Name, err)
+	}
+	return nil
+}
+
+// GetConfig returns the config carried by a genesis block made by Make, nil
+// if it has none
+func GetConfig(block *protos.Block) (*Config, error) {
+	if len(block.ConsensusMetadata) == 0 {
+		return nil, nil
+	}
+	config := &Config{}
+	if err := json.Unmarshal(block.ConsensusMetadata, config); err != nil {
+		return nil, fmt.Errorf("Invalid genesis config: %s", err)
+	}
+	return config, nil
+}
+
+// Write stores the genesis block in a file
+func Write(block *protos.Block, path string) error {
+	data, err := proto.Marshal(block)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Read loads a genesis block stored by Write
+func Read(path string) (*protos.Block, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, err := protos.UnmarshallBlock(data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid genesis block %s: %s", path, err)
+	}
+	return block, nil
+}
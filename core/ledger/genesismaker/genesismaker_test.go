@@ -0,0 +1,166 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genesismaker
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/protos"
+)
+
+func newTestCert(t *testing.T, name string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestMakeFromSpecFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "genesismaker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "org1.pem"), newTestCert(t, "org1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	specFile := filepath.Join(dir, "genesis.yaml")
+	err = ioutil.WriteFile(specFile, []byte(`
+timestamp: 2016-09-01T00:00:00Z
+chaincodes:
+  - name: sample_syscc
+    path: github.com/hyperledger/fabric/core/system_chaincode/sample_syscc
+    args: [greetings, hello world]
+consortium:
+  - name: org1
+    certFile: org1.pem
+consensus:
+  plugin: pbft
+  pbft.N: "4"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec, err := LoadSpec(specFile)
+	if err != nil {
+		t.Fatalf("Error loading the spec: %s", err)
+	}
+	block, err := Make(spec)
+	if err != nil {
+		t.Fatalf("Error making the genesis block: %s", err)
+	}
+	if len(block.Transactions) != 1 || block.Transactions[0].Uuid != "sample_syscc" {
+		t.Fatalf("Expected the deploy transaction of sample_syscc, got %v", block.Transactions)
+	}
+	if block.Transactions[0].Timestamp.Seconds != 1472688000 {
+		t.Fatalf("Expected the timestamp of the spec, got %v", block.Transactions[0].Timestamp)
+	}
+	deploymentSpec := &protos.ChaincodeDeploymentSpec{}
+	if err = proto.Unmarshal(block.Transactions[0].Payload, deploymentSpec); err != nil {
+		t.Fatal(err)
+	}
+	if deploymentSpec.ExecEnv != protos.ChaincodeDeploymentSpec_SYSTEM ||
+		deploymentSpec.ChaincodeSpec.CtorMsg.Args[1] != "hello world" {
+		t.Fatalf("Unexpected deployment spec %v", deploymentSpec)
+	}
+
+	config, err := GetConfig(block)
+	if err != nil {
+		t.Fatalf("Error reading the genesis config: %s", err)
+	}
+	if len(config.Consortium) != 1 || config.Consortium[0].Name != "org1" || config.Consortium[0].Cert == "" {
+		t.Fatalf("Expected the certificate of org1, got %v", config.Consortium)
+	}
+	if config.Consensus["pbft.N"] != "4" {
+		t.Fatalf("Expected the consensus settings, got %v", config.Consensus)
+	}
+
+	// the same spec makes the same block
+	blockFile := filepath.Join(dir, "genesis.block")
+	if err = Write(block, blockFile); err != nil {
+		t.Fatal(err)
+	}
+	again, err := Make(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	againFile := filepath.Join(dir, "again.block")
+	if err = Write(again, againFile); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := ioutil.ReadFile(blockFile)
+	second, _ := ioutil.ReadFile(againFile)
+	if !bytes.Equal(first, second) {
+		t.Fatal("Expected the same spec to make the same genesis block")
+	}
+	read, err := Read(blockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(read, block) {
+		t.Fatal("Expected to read the genesis block written")
+	}
+}
+
+func TestMakeInvalidSpec(t *testing.T) {
+	chaincode := ChaincodeSpec{Name: "mycc", Path: "github.com/mycc"}
+	for _, spec := range []*Spec{
+		{Timestamp: "yesterday"},
+		{Chaincodes: []ChaincodeSpec{{Name: "mycc"}}},
+		{Chaincodes: []ChaincodeSpec{chaincode, chaincode}},
+		{Chaincodes: []ChaincodeSpec{{Name: "mycc", Path: "github.com/mycc", Type: "COBOL"}}},
+		{Consortium: []Member{{Name: "org1", Cert: "not a certificate"}}},
+		{Consortium: []Member{{Cert: string(newTestCert(t, "org1"))}}},
+	} {
+		if _, err := Make(spec); err == nil {
+			t.Fatalf("Expected an error making the genesis block of %+v", spec)
+		}
+	}
+
+	block, err := Make(&Spec{Chaincodes: []ChaincodeSpec{chaincode}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config, _ := GetConfig(block); config != nil {
+		t.Fatalf("Expected no genesis config, got %v", config)
+	}
+}
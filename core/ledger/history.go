@@ -0,0 +1,106 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ledger
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/protos"
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
+)
+
+var historyLogger = logging.MustGetLogger("history")
+
+// historyEnabled tells whether the history of the keys is indexed, from the
+// configuration 'ledger.history.enabled'
+func historyEnabled() bool {
+	return viper.GetBool("ledger.history.enabled")
+}
+
+// addHistoryForPersistence adds to writeBatch a modification of each key
+// changed by the transactions of block blockNumber, from the state changes
+// made by each transaction. A modification is keyed by the chaincode and the
+// key, then by the block number and the index of the transaction in the
+// block, so that the history of a key is stored from the oldest to the latest.
+func addHistoryForPersistence(openchainDB *db.OpenchainDB, blockNumber uint64, transactions []*protos.Transaction,
+	txStateDeltas map[string]*statemgmt.StateDelta, writeBatch *db.WriteBatch) error {
+	cf := openchainDB.HistoryCF
+	for txIndex, tx := range transactions {
+		txStateDelta, ok := txStateDeltas[tx.Uuid]
+		if !ok {
+			continue
+		}
+		for _, chaincodeID := range txStateDelta.GetUpdatedChaincodeIds(false) {
+			for key, updatedValue := range txStateDelta.GetUpdates(chaincodeID) {
+				modification := &protos.KeyModification{
+					TxUuid:      tx.Uuid,
+					BlockNumber: blockNumber,
+					Value:       updatedValue.GetValue(),
+					IsDelete:    updatedValue.IsDelete(),
+				}
+				modificationBytes, err := proto.Marshal(modification)
+				if err != nil {
+					return err
+				}
+				writeBatch.PutCF(cf, encodeHistoryKey(chaincodeID, key, blockNumber, uint64(txIndex)), modificationBytes)
+			}
+		}
+	}
+	historyLogger.Debug("Added history of the keys changed by block [%d]", blockNumber)
+	return nil
+}
+
+// fetchHistoryFromDB returns the modifications of key of chaincodeID, from
+// the oldest to the latest
+func fetchHistoryFromDB(openchainDB *db.OpenchainDB, chaincodeID string, key string) ([]*protos.KeyModification, error) {
+	itr := openchainDB.GetIterator(openchainDB.HistoryCF)
+	defer itr.Close()
+
+	prefix := encodeHistoryKeyPrefix(chaincodeID, key)
+	var modifications []*protos.KeyModification
+	for itr.Seek(prefix); itr.ValidForPrefix(prefix); itr.Next() {
+		modification := &protos.KeyModification{}
+		if err := proto.Unmarshal(itr.Value(), modification); err != nil {
+			return nil, err
+		}
+		modifications = append(modifications, modification)
+	}
+	if err := itr.Err(); err != nil {
+		return nil, err
+	}
+	return modifications, nil
+}
+
+func encodeHistoryKeyPrefix(chaincodeID string, key string) []byte {
+	var buffer bytes.Buffer
+	buffer.Write(statemgmt.ConstructCompositeKey(chaincodeID, key))
+	buffer.WriteByte(0)
+	return buffer.Bytes()
+}
+
+func encodeHistoryKey(chaincodeID string, key string, blockNumber uint64, txIndex uint64) []byte {
+	historyKey := encodeHistoryKeyPrefix(chaincodeID, key)
+	suffix := make([]byte, 16)
+	binary.BigEndian.PutUint64(suffix, blockNumber)
+	binary.BigEndian.PutUint64(suffix[8:], txIndex)
+	return append(historyKey, suffix...)
+}
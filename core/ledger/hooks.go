@@ -0,0 +1,120 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ledger
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/protos"
+)
+
+// CommitEvent describes a block committed to the ledger
+type CommitEvent struct {
+	BlockNumber uint64
+	Block       *protos.Block
+	// StateDelta holds the state changes of the transactions of the block,
+	// nil for a block stored by state transfer, whose state changes are
+	// applied on their own
+	StateDelta *statemgmt.StateDelta
+	// InvalidTxs maps the UUIDs of the transactions of the block that failed
+	// to their error. Their state changes are not in StateDelta
+	InvalidTxs map[string]string
+}
+
+// CommitHook is called in-process once a block is written to the DB, before
+// the block event is sent. The hooks are called one at a time, in the order
+// of the blocks, by the goroutine committing them: they must return quickly,
+// e.g. to update a projection or invalidate a cache, and must not modify the
+// event nor call back into the ledger to commit.
+type CommitHook func(event *CommitEvent)
+
+type namedCommitHook struct {
+	name string
+	hook CommitHook
+}
+
+var (
+	commitHooks     []namedCommitHook
+	commitHooksLock sync.RWMutex
+)
+
+// RegisterCommitHook registers hook under name, to be called after each
+// block commit. The hooks are called in the order they are registered.
+func RegisterCommitHook(name string, hook CommitHook) error {
+	if name == "" || hook == nil {
+		return fmt.Errorf("Invalid commit hook [%s]", name)
+	}
+
+	commitHooksLock.Lock()
+	defer commitHooksLock.Unlock()
+
+	for _, h := range commitHooks {
+		if h.name == name {
+			return fmt.Errorf("Commit hook [%s] already registered", name)
+		}
+	}
+	commitHooks = append(commitHooks, namedCommitHook{name, hook})
+	return nil
+}
+
+// UnregisterCommitHook removes the hook registered under name, if any
+func UnregisterCommitHook(name string) {
+	commitHooksLock.Lock()
+	defer commitHooksLock.Unlock()
+
+	for i, h := range commitHooks {
+		if h.name == name {
+			commitHooks = append(commitHooks[:i:i], commitHooks[i+1:]...)
+			return
+		}
+	}
+}
+
+// fireCommitHooks calls the registered hooks with the block committed. A
+// hook panicking is logged and does not fail the commit
+func fireCommitHooks(block *protos.Block, blockNumber uint64, stateDelta *statemgmt.StateDelta) {
+	commitHooksLock.RLock()
+	hooks := commitHooks
+	commitHooksLock.RUnlock()
+	if len(hooks) == 0 {
+		return
+	}
+
+	event := &CommitEvent{BlockNumber: blockNumber, Block: block, StateDelta: stateDelta,
+		InvalidTxs: make(map[string]string)}
+	if block.NonHashData != nil {
+		for _, result := range block.NonHashData.TransactionResults {
+			if result != nil && result.ErrorCode != 0 {
+				event.InvalidTxs[result.Uuid] = result.Error
+			}
+		}
+	}
+	for _, h := range hooks {
+		callCommitHook(h, event)
+	}
+}
+
+func callCommitHook(h namedCommitHook, event *CommitEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			ledgerLogger.Error("Commit hook [%s] panicked on block [%d]: %v", h.name, event.BlockNumber, r)
+		}
+	}()
+	h.hook(event)
+}
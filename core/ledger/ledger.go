@@ -20,15 +20,16 @@ import (
 	"bytes"
 	"fmt"
 	"reflect"
+	"regexp"
 	"sync"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/db"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/query"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt/state"
 	"github.com/hyperledger/fabric/events/producer"
 	"github.com/op/go-logging"
-	"github.com/tecbot/gorocksdb"
 
 	"github.com/hyperledger/fabric/protos"
 	"golang.org/x/net/context"
@@ -46,6 +47,10 @@ const (
 	ErrorTypeOutOfBounds = ErrorType("OutOfBounds")
 	//ErrorTypeResourceNotFound used to indicate if a resource is not found
 	ErrorTypeResourceNotFound = ErrorType("ResourceNotFound")
+	//ErrorTypePruned used to indicate that a block was deleted by pruning the ledger
+	ErrorTypePruned = ErrorType("Pruned")
+	//ErrorTypeReadConflict used to indicate that a transaction read a key changed by a previous transaction
+	ErrorTypeReadConflict = ErrorType("ReadConflict")
 )
 
 //Error can be used for throwing an error from ledger code.
@@ -73,35 +78,100 @@ var (
 
 	// ErrResourceNotFound is returned if a resource is not found
 	ErrResourceNotFound = newLedgerError(ErrorTypeResourceNotFound, "ledger: resource not found")
+
+	// ErrPruned is returned if a block was deleted by pruning the ledger
+	ErrPruned = newLedgerError(ErrorTypePruned, "ledger: block pruned")
+
+	// ErrHistoryDisabled is returned if the history of the keys is requested
+	// while it is not indexed
+	ErrHistoryDisabled = newLedgerError(ErrorTypeResourceNotFound, "ledger: history of keys not enabled")
+
+	// ErrProofNotSupported is returned if a state proof is requested from a
+	// state implementation without a crypto-hash structure, such as raw
+	ErrProofNotSupported = newLedgerError(ErrorTypeResourceNotFound, "ledger: state proofs not supported")
+
+	// ErrReadConflict is returned if a simulated transaction read a key
+	// changed by a transaction applied to the batch after its simulation began
+	ErrReadConflict = newLedgerError(ErrorTypeReadConflict, "ledger: transaction read keys changed by a previous transaction")
+
+	// ErrIndexNotFound is returned if a state index which is not declared in
+	// 'ledger.state.indexes' is scanned
+	ErrIndexNotFound = newLedgerError(ErrorTypeResourceNotFound, "ledger: state index not declared")
 )
 
 // Ledger - the struct for openchain ledger
 type Ledger struct {
-	blockchain *blockchain
-	state      *state.State
-	currentID  interface{}
+	blockchain  *blockchain
+	state       *state.State
+	currentID   interface{}
+	history     bool
+	pipeline    bool
+	openchainDB *db.OpenchainDB
+
+	simulations     map[string]*TxSimulator
+	simulationsLock sync.RWMutex
+
+	// block committed last while it is written to the DB in the background
+	pending     *pendingCommit
+	pendingLock sync.Mutex
 }
 
 var ledger *Ledger
 var ledgerError error
 var once sync.Once
 
-// GetLedger - gives a reference to a 'singleton' ledger
+// GetLedger - gives a reference to a 'singleton' ledger, the ledger of the default chain
 func GetLedger() (*Ledger, error) {
 	once.Do(func() {
-		ledger, ledgerError = newLedger()
+		ledger, ledgerError = newLedger(db.GetDBHandle())
 	})
 	return ledger, ledgerError
 }
 
-func newLedger() (*Ledger, error) {
-	blockchain, err := newBlockchain()
+// chainLedgers are the ledgers of the chains other than the default chain
+var chainLedgers = make(map[string]*Ledger)
+var chainLedgersLock sync.Mutex
+
+var chainIDPattern = regexp.MustCompile("^[a-zA-Z0-9][a-zA-Z0-9_.-]*$")
+
+// GetChainLedger gives a reference to the ledger of a chain. Every chain has
+// a blockchain and a state of its own, stored in a DB of its own. The empty
+// chainID is the default chain, whose ledger is the one of GetLedger
+func GetChainLedger(chainID string) (*Ledger, error) {
+	if chainID == "" {
+		return GetLedger()
+	}
+	if !chainIDPattern.MatchString(chainID) {
+		return nil, newLedgerError(ErrorTypeInvalidArgument,
+			fmt.Sprintf("Invalid chain ID [%s], expected letters, digits, '_', '.' and '-'", chainID))
+	}
+	chainLedgersLock.Lock()
+	defer chainLedgersLock.Unlock()
+	if chainLedger, ok := chainLedgers[chainID]; ok {
+		return chainLedger, nil
+	}
+	chainLedger, err := newLedger(db.GetChainDBHandle(chainID))
+	if err != nil {
+		return nil, err
+	}
+	chainLedgers[chainID] = chainLedger
+	return chainLedger, nil
+}
+
+func newLedger(openchainDB *db.OpenchainDB) (*Ledger, error) {
+	blockchain, err := newBlockchain(openchainDB)
 	if err != nil {
 		return nil, err
 	}
 
-	state := state.NewState()
-	return &Ledger{blockchain, state, nil}, nil
+	state := state.NewState(openchainDB)
+	return &Ledger{blockchain: blockchain, state: state, history: historyEnabled(), pipeline: commitPipelineEnabled(),
+		openchainDB: openchainDB, simulations: make(map[string]*TxSimulator)}, nil
+}
+
+// GetChainID returns the chain of the ledger, empty for the default chain
+func (ledger *Ledger) GetChainID() string {
+	return ledger.openchainDB.ChainID()
 }
 
 /////////////////// Transaction-batch related methods ///////////////////////////////
@@ -128,6 +198,9 @@ func (ledger *Ledger) GetTXBatchPreviewBlockInfo(id interface{},
 	if err != nil {
 		return nil, err
 	}
+	if err = ledger.waitPersisted(); err != nil {
+		return nil, err
+	}
 	stateHash, err := ledger.state.GetHash()
 	if err != nil {
 		return nil, err
@@ -139,34 +212,38 @@ func (ledger *Ledger) GetTXBatchPreviewBlockInfo(id interface{},
 
 // CommitTxBatch - gets invoked when the current transaction-batch needs to be committed
 // This function returns successfully iff the transactions details and state changes (that
-// may have happened during execution of this transaction-batch) have been committed to permanent storage
+// may have happened during execution of this transaction-batch) have been committed to permanent storage.
+// With 'ledger.commit.pipeline', it returns once they are committed in memory, and they are written to
+// permanent storage while the next transaction-batch executes. The write is retried, and the peer halted
+// if it keeps failing
 func (ledger *Ledger) CommitTxBatch(id interface{}, transactions []*protos.Transaction, transactionResults []*protos.TransactionResult, metadata []byte) error {
 	err := ledger.checkValidIDCommitORRollback(id)
 	if err != nil {
 		return err
 	}
-
-	stateHash, err := ledger.state.GetHash()
-	if err != nil {
-		ledger.resetForNextTxGroup(false)
-		ledger.blockchain.blockPersistenceStatus(false)
+	// the state hash is computed from the DB, which must hold the previous block
+	if err = ledger.waitPersisted(); err != nil {
 		return err
 	}
 
-	writeBatch := gorocksdb.NewWriteBatch()
-	defer writeBatch.Destroy()
-	block := protos.NewBlock(transactions, metadata)
-	block.NonHashData = &protos.NonHashData{TransactionResults: transactionResults}
-	newBlockNumber, err := ledger.blockchain.addPersistenceChangesForNewBlock(context.TODO(), block, stateHash, writeBatch)
+	writeBatch := db.NewWriteBatch()
+	block, newBlockNumber, err := ledger.addPersistenceChangesForTxBatch(transactions, transactionResults, metadata, writeBatch)
 	if err != nil {
+		writeBatch.Destroy()
 		ledger.resetForNextTxGroup(false)
 		ledger.blockchain.blockPersistenceStatus(false)
 		return err
 	}
-	ledger.state.AddChangesForPersistence(newBlockNumber, writeBatch)
-	opt := gorocksdb.NewDefaultWriteOptions()
-	defer opt.Destroy()
-	dbErr := db.GetDBHandle().DB.Write(opt, writeBatch)
+	stateDelta := ledger.state.GetStateDelta()
+	if ledger.pipeline {
+		ledger.currentID = nil
+		ledger.state.ClearInMemoryChangesPersisting()
+		ledger.blockchain.blockPersistenceStatus(true)
+		ledger.persistInBackground(block, newBlockNumber, stateDelta, writeBatch)
+		return nil
+	}
+	dbErr := ledger.openchainDB.Write(writeBatch)
+	writeBatch.Destroy()
 	if dbErr != nil {
 		ledger.resetForNextTxGroup(false)
 		ledger.blockchain.blockPersistenceStatus(false)
@@ -176,10 +253,35 @@ func (ledger *Ledger) CommitTxBatch(id interface{}, transactions []*protos.Trans
 	ledger.resetForNextTxGroup(true)
 	ledger.blockchain.blockPersistenceStatus(true)
 
+	fireCommitHooks(block, newBlockNumber, stateDelta)
 	sendProducerBlockEvent(block)
 	return nil
 }
 
+// addPersistenceChangesForTxBatch adds to writeBatch the block of the transactions of the current
+// transaction-batch and the state changes they made. Returns the block and its number
+func (ledger *Ledger) addPersistenceChangesForTxBatch(transactions []*protos.Transaction,
+	transactionResults []*protos.TransactionResult, metadata []byte, writeBatch *db.WriteBatch) (*protos.Block, uint64, error) {
+	stateHash, err := ledger.state.GetHash()
+	if err != nil {
+		return nil, 0, err
+	}
+	block := protos.NewBlock(transactions, metadata)
+	block.NonHashData = &protos.NonHashData{TransactionResults: transactionResults}
+	newBlockNumber, err := ledger.blockchain.addPersistenceChangesForNewBlock(context.TODO(), block, stateHash, writeBatch)
+	if err != nil {
+		return nil, 0, err
+	}
+	ledger.state.AddChangesForPersistence(newBlockNumber, writeBatch)
+	if ledger.history {
+		err = addHistoryForPersistence(ledger.openchainDB, newBlockNumber, transactions, ledger.state.GetTxStateDeltas(), writeBatch)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return block, newBlockNumber, nil
+}
+
 // RollbackTxBatch - Descards all the state changes that may have taken place during the execution of
 // current transaction-batch
 func (ledger *Ledger) RollbackTxBatch(id interface{}) error {
@@ -209,6 +311,9 @@ func (ledger *Ledger) TxFinished(txUUID string, txSuccessful bool) {
 // GetTempStateHash - Computes state hash by taking into account the state changes that may have taken
 // place during the execution of current transaction-batch
 func (ledger *Ledger) GetTempStateHash() ([]byte, error) {
+	if err := ledger.waitPersisted(); err != nil {
+		return nil, err
+	}
 	return ledger.state.GetHash()
 }
 
@@ -216,6 +321,9 @@ func (ledger *Ledger) GetTempStateHash() ([]byte, error) {
 // this method returns a map [txUuid of Tx --> cryptoHash(stateChangesMadeByTx)]
 // Only successful txs appear in this map
 func (ledger *Ledger) GetTempStateHashWithTxDeltaStateHashes() ([]byte, map[string][]byte, error) {
+	if err := ledger.waitPersisted(); err != nil {
+		return nil, nil, err
+	}
 	stateHash, err := ledger.state.GetHash()
 	return stateHash, ledger.state.GetTxStateDeltaHash(), err
 }
@@ -235,6 +343,44 @@ func (ledger *Ledger) GetStateRangeScanIterator(chaincodeID string, startKey str
 	return ledger.state.GetRangeScanIterator(chaincodeID, startKey, endKey, committed)
 }
 
+// GetStateQueryIterator returns an iterator to get the keys (and values), in lexical order, of a chaincodeID
+// whose values are JSON documents matching the query. The syntax of the query is described in package
+// statemgmt/query. If committed is true, the key-values are retrieved only from the db. If committed is
+// false, the results from db are mergerd with the results in memory (giving preference to in-memory data)
+func (ledger *Ledger) GetStateQueryIterator(chaincodeID string, queryString string, committed bool) (statemgmt.RangeScanIterator, error) {
+	q, err := query.Parse(queryString)
+	if err != nil {
+		return nil, newLedgerError(ErrorTypeInvalidArgument, err.Error())
+	}
+	return ledger.state.GetQueryIterator(chaincodeID, q, committed)
+}
+
+// GetStateByIndex returns an iterator to get the keys (and values) of a chaincodeID whose values are JSON
+// documents with the field of index between startValue and endValue, inclusive, in order of the values of
+// the field, then of the keys. The values of the field are compared as strings, the numbers and booleans by
+// their JSON text, and an empty endValue sets no upper bound. The indexes are declared by chaincode in
+// 'ledger.state.indexes', ErrIndexNotFound is returned for others. committed is as for GetStateQueryIterator
+func (ledger *Ledger) GetStateByIndex(chaincodeID string, index string, startValue string, endValue string, committed bool) (statemgmt.RangeScanIterator, error) {
+	itr, err := ledger.state.GetIndexIterator(chaincodeID, index, startValue, endValue, committed)
+	if err == state.ErrIndexNotFound {
+		return nil, ErrIndexNotFound
+	}
+	return itr, err
+}
+
+// GetHistoryForKey returns the modifications of key of chaincodeID by the committed transactions,
+// from the oldest to the latest. The history is only kept by ledgers with 'ledger.history.enabled',
+// since they were so configured.
+func (ledger *Ledger) GetHistoryForKey(chaincodeID string, key string) ([]*protos.KeyModification, error) {
+	if !ledger.history {
+		return nil, ErrHistoryDisabled
+	}
+	if err := ledger.waitPersisted(); err != nil {
+		return nil, err
+	}
+	return fetchHistoryFromDB(ledger.openchainDB, chaincodeID, key)
+}
+
 // SetState sets state to given value for chaincodeID and key. Does not immideatly writes to DB
 func (ledger *Ledger) SetState(chaincodeID string, key string, value []byte) error {
 	if key == "" || value == nil {
@@ -261,17 +407,31 @@ func (ledger *Ledger) GetStateMultipleKeys(chaincodeID string, keys []string, co
 }
 
 // SetStateMultipleKeys sets the values for the multiple keys.
-// This method is mainly to amortize the cost of grpc communication between chaincode shim peer
+// This method is mainly to amortize the cost of grpc communication between chaincode shim peer.
+// None of the keys is set if one of them is invalid
 func (ledger *Ledger) SetStateMultipleKeys(chaincodeID string, kvs map[string][]byte) error {
+	if err := validateKeyValues(kvs); err != nil {
+		return err
+	}
 	return ledger.state.SetMultipleKeys(chaincodeID, kvs)
 }
 
+func validateKeyValues(kvs map[string][]byte) error {
+	for key, value := range kvs {
+		if key == "" || value == nil {
+			return newLedgerError(ErrorTypeInvalidArgument,
+				fmt.Sprintf("An empty string key or a nil value is not supported. Method invoked with key='%s', value='%#v'", key, value))
+		}
+	}
+	return nil
+}
+
 // GetStateSnapshot returns a point-in-time view of the global state for the current block. This
 // should be used when transferring the state from one peer to another peer. You must call
 // stateSnapshot.Release() once you are done with the snapsnot to free up resources.
 func (ledger *Ledger) GetStateSnapshot() (*state.StateSnapshot, error) {
-	dbSnapshot := db.GetDBHandle().GetSnapshot()
-	blockHeight, err := fetchBlockchainSizeFromSnapshot(dbSnapshot)
+	dbSnapshot := ledger.openchainDB.GetSnapshot()
+	blockHeight, err := fetchBlockchainSizeFromSnapshot(ledger.openchainDB, dbSnapshot)
 	if err != nil {
 		dbSnapshot.Release()
 		return nil, err
@@ -289,6 +449,9 @@ func (ledger *Ledger) GetStateDelta(blockNumber uint64) (*statemgmt.StateDelta,
 	if blockNumber >= ledger.GetBlockchainSize() {
 		return nil, ErrOutOfBounds
 	}
+	if err := ledger.waitPersisted(); err != nil {
+		return nil, err
+	}
 	return ledger.state.FetchStateDeltaFromDB(blockNumber)
 }
 
@@ -315,6 +478,9 @@ func (ledger *Ledger) ApplyStateDelta(id interface{}, delta *statemgmt.StateDelt
 	if err != nil {
 		return err
 	}
+	if err = ledger.waitPersisted(); err != nil {
+		return err
+	}
 	ledger.currentID = id
 	ledger.state.ApplyStateDelta(delta)
 	return nil
@@ -346,6 +512,9 @@ func (ledger *Ledger) RollbackStateDelta(id interface{}) error {
 // This is generally only used during state synchronization when creating a
 // new state from a snapshot.
 func (ledger *Ledger) DeleteALLStateKeysAndValues() error {
+	if err := ledger.waitPersisted(); err != nil {
+		return err
+	}
 	return ledger.state.DeleteState()
 }
 
@@ -364,6 +533,9 @@ func (ledger *Ledger) GetBlockByNumber(blockNumber uint64) (*protos.Block, error
 	if blockNumber >= ledger.GetBlockchainSize() {
 		return nil, ErrOutOfBounds
 	}
+	if err := ledger.waitPersisted(); err != nil {
+		return nil, err
+	}
 	return ledger.blockchain.getBlock(blockNumber)
 }
 
@@ -374,16 +546,24 @@ func (ledger *Ledger) GetBlockchainSize() uint64 {
 
 // GetTransactionByUUID return transaction by it's uuid
 func (ledger *Ledger) GetTransactionByUUID(txUUID string) (*protos.Transaction, error) {
+	if err := ledger.waitPersisted(); err != nil {
+		return nil, err
+	}
 	return ledger.blockchain.getTransactionByUUID(txUUID)
 }
 
 // PutRawBlock puts a raw block on the chain. This function should only be
 // used for synchronization between peers.
 func (ledger *Ledger) PutRawBlock(block *protos.Block, blockNumber uint64) error {
-	err := ledger.blockchain.persistRawBlock(block, blockNumber)
+	err := ledger.waitPersisted()
 	if err != nil {
 		return err
 	}
+	err = ledger.blockchain.persistRawBlock(block, blockNumber)
+	if err != nil {
+		return err
+	}
+	fireCommitHooks(block, blockNumber, nil)
 	sendProducerBlockEvent(block)
 	return nil
 }
@@ -435,6 +615,75 @@ func (ledger *Ledger) VerifyChain(highBlock, lowBlock uint64) (uint64, error) {
 	return 0, nil
 }
 
+/////////////////// pruning related methods ////////////////////////////////////////
+/////////////////////////////////////////////////////////////////////////////////////
+
+// Prune deletes the blocks of the blockchain but the genesis block and the
+// last retainBlocks ones, and the state deltas older than the history kept
+// (ledger.state.deltaHistorySize). A retainBlocks of 0 keeps all the blocks.
+// Pruned blocks are no longer served, GetBlockByNumber returns ErrPruned for
+// them. Returns the number of blocks and of state deltas deleted.
+func (ledger *Ledger) Prune(retainBlocks uint64) (blocks uint64, deltas uint64, err error) {
+	if err = ledger.waitPersisted(); err != nil {
+		return
+	}
+	size := ledger.GetBlockchainSize()
+	if size == 0 {
+		return 0, 0, nil
+	}
+	if retainBlocks > 0 && retainBlocks < size {
+		blocks, err = ledger.blockchain.pruneBlocks(size - retainBlocks)
+		if err != nil {
+			return
+		}
+	}
+	deltas, err = ledger.state.PruneStateDeltas(size - 1)
+	if err != nil {
+		return
+	}
+	ledgerLogger.Info("Pruned %d blocks and %d state deltas, keeping blocks from %d", blocks, deltas, ledger.GetPrunedBelow())
+	return
+}
+
+// GetPrunedBelow returns the number of the lowest block kept after the
+// genesis block, 0 if the blockchain was never pruned
+func (ledger *Ledger) GetPrunedBelow() uint64 {
+	return ledger.blockchain.getPrunedBelow()
+}
+
+// Archive moves the blocks of the blockchain but the genesis block and the
+// last retainBlocks ones from the DB to the archive configured in
+// 'ledger.archive.store'. A retainBlocks of 0 keeps all the blocks. Archived
+// blocks are still served, fetched from the archive, and their indexes are
+// kept in the DB. Returns the number of blocks archived.
+func (ledger *Ledger) Archive(retainBlocks uint64) (uint64, error) {
+	if err := ledger.waitPersisted(); err != nil {
+		return 0, err
+	}
+	size := ledger.GetBlockchainSize()
+	if retainBlocks == 0 || retainBlocks >= size {
+		return 0, nil
+	}
+	blocks, err := ledger.blockchain.archiveBlocks(size - retainBlocks)
+	if err != nil {
+		return blocks, err
+	}
+	ledgerLogger.Info("Archived %d blocks, keeping blocks from %d", blocks, ledger.GetArchivedBelow())
+	return blocks, nil
+}
+
+// GetArchivedBelow returns the number of the lowest block kept in the DB
+// after the genesis block, 0 if no block was ever archived
+func (ledger *Ledger) GetArchivedBelow() uint64 {
+	return ledger.blockchain.getArchivedBelow()
+}
+
+// Compact compacts the DB to reclaim the disk space of the blocks and state
+// deltas pruned or archived
+func (ledger *Ledger) Compact() {
+	ledger.openchainDB.Compact()
+}
+
 func (ledger *Ledger) checkValidIDBegin() error {
 	if ledger.currentID != nil {
 		return fmt.Errorf("Another TxGroup [%s] already in-progress", ledger.currentID)
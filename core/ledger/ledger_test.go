@@ -18,12 +18,19 @@ package ledger
 
 import (
 	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
 	"strconv"
 	"testing"
+	"time"
 
+	"github.com/hyperledger/fabric/core/db"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/compositekey"
 	"github.com/hyperledger/fabric/core/ledger/testutil"
 	"github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
 )
 
 func TestLedgerCommit(t *testing.T) {
@@ -881,6 +888,22 @@ func TestGetSetMultipleKeys(t *testing.T) {
 	testutil.AssertEquals(t, values, [][]byte{[]byte("value1"), []byte("value2")})
 }
 
+func TestSetMultipleKeysInvalidInput(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	l := ledgerTestWrapper.ledger
+	l.BeginTxBatch(1)
+	l.TxBegin("txUUID")
+	err := l.SetStateMultipleKeys("chaincodeID", map[string][]byte{"key1": []byte("value1"), "key2": nil})
+	testutil.AssertError(t, err, "Expected an error for a nil value")
+	err = l.SetStateMultipleKeys("chaincodeID", map[string][]byte{"key1": []byte("value1"), "": []byte("value2")})
+	testutil.AssertError(t, err, "Expected an error for an empty key")
+	l.TxFinished("txUUID", true)
+
+	// none of the keys was set
+	values, _ := l.GetStateMultipleKeys("chaincodeID", []string{"key1", "key2"}, false)
+	testutil.AssertEquals(t, values, [][]byte{nil, nil})
+}
+
 func TestCopyState(t *testing.T) {
 	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
 	l := ledgerTestWrapper.ledger
@@ -953,3 +976,713 @@ func TestLedgerInvalidInput(t *testing.T) {
 	value, _ := l.GetState("chaincodeID1", "key1", true)
 	testutil.AssertEquals(t, value, []byte("value1"))
 }
+
+func TestLedgerPrune(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	ledger := ledgerTestWrapper.ledger
+
+	var uuids []string
+	for i := 0; i < 20; i++ {
+		ledger.BeginTxBatch(i)
+		ledger.TxBegin("txUuid" + strconv.Itoa(i))
+		ledger.SetState("chaincode"+strconv.Itoa(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i)))
+		ledger.TxFinished("txUuid"+strconv.Itoa(i), true)
+		transaction, uuid := buildTestTx(t)
+		ledger.CommitTxBatch(i, []*protos.Transaction{transaction}, nil, []byte("proof"))
+		uuids = append(uuids, uuid)
+	}
+
+	blocks, _, err := ledger.Prune(0)
+	testutil.AssertNoError(t, err, "Error pruning the ledger")
+	testutil.AssertEquals(t, blocks, uint64(0))
+
+	blocks, _, err = ledger.Prune(5)
+	testutil.AssertNoError(t, err, "Error pruning the ledger")
+	testutil.AssertEquals(t, blocks, uint64(14))
+	testutil.AssertEquals(t, ledger.GetPrunedBelow(), uint64(15))
+	ledger.Compact()
+
+	testutil.AssertNotNil(t, ledgerTestWrapper.GetBlockByNumber(0))
+	for i := uint64(1); i < 15; i++ {
+		_, err := ledger.GetBlockByNumber(i)
+		testutil.AssertEquals(t, err, ErrPruned)
+		_, err = ledger.GetTransactionByUUID(uuids[i])
+		testutil.AssertEquals(t, err, ErrResourceNotFound)
+	}
+	for i := uint64(15); i < 20; i++ {
+		testutil.AssertNotNil(t, ledgerTestWrapper.GetBlockByNumber(i))
+	}
+	testutil.AssertEquals(t, ledgerTestWrapper.VerifyChain(19, 15), uint64(0))
+	testutil.AssertEquals(t, ledgerTestWrapper.GetState("chaincode3", "key3", true), []byte("value3"))
+
+	// pruning again only deletes the blocks committed since
+	blocks, _, err = ledger.Prune(5)
+	testutil.AssertNoError(t, err, "Error pruning the ledger")
+	testutil.AssertEquals(t, blocks, uint64(0))
+
+	// the pruning mark survives a restart
+	blockchain, err := newBlockchain(db.GetDBHandle())
+	testutil.AssertNoError(t, err, "Error reloading the blockchain")
+	testutil.AssertEquals(t, blockchain.getPrunedBelow(), uint64(15))
+}
+
+func TestLedgerArchive(t *testing.T) {
+	archiveDir, err := ioutil.TempDir("", "archive")
+	testutil.AssertNoError(t, err, "Error creating the archive directory")
+	defer os.RemoveAll(archiveDir)
+	viper.Set("ledger.archive.store", "filesystem")
+	viper.Set("ledger.archive.filesystem.path", archiveDir)
+	defer viper.Set("ledger.archive.store", "")
+
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	ledger := ledgerTestWrapper.ledger
+
+	var uuids []string
+	for i := 0; i < 20; i++ {
+		ledger.BeginTxBatch(i)
+		ledger.TxBegin("txUuid" + strconv.Itoa(i))
+		ledger.SetState("chaincode"+strconv.Itoa(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i)))
+		ledger.TxFinished("txUuid"+strconv.Itoa(i), true)
+		transaction, uuid := buildTestTx(t)
+		ledger.CommitTxBatch(i, []*protos.Transaction{transaction}, nil, []byte("proof"))
+		uuids = append(uuids, uuid)
+	}
+	block5 := ledgerTestWrapper.GetBlockByNumber(5)
+
+	blocks, err := ledger.Archive(5)
+	testutil.AssertNoError(t, err, "Error archiving the ledger")
+	testutil.AssertEquals(t, blocks, uint64(14))
+	testutil.AssertEquals(t, ledger.GetArchivedBelow(), uint64(15))
+
+	// the archived blocks are served from the archive
+	archivedBlock, err := fetchBlockFromDB(db.GetDBHandle(), 5)
+	testutil.AssertNoError(t, err, "Error fetching block from DB")
+	testutil.AssertNil(t, archivedBlock)
+	testutil.AssertEquals(t, ledgerTestWrapper.GetBlockByNumber(5), block5)
+	for i := uint64(1); i < 20; i++ {
+		testutil.AssertNotNil(t, ledgerTestWrapper.GetBlockByNumber(i))
+		tx, err := ledger.GetTransactionByUUID(uuids[i])
+		testutil.AssertNoError(t, err, "Error fetching transaction")
+		testutil.AssertEquals(t, tx.Uuid, uuids[i])
+	}
+	testutil.AssertEquals(t, ledgerTestWrapper.VerifyChain(19, 0), uint64(0))
+
+	// pruning leaves the archived blocks
+	blocks, _, err = ledger.Prune(2)
+	testutil.AssertNoError(t, err, "Error pruning the ledger")
+	testutil.AssertEquals(t, blocks, uint64(3))
+	testutil.AssertNotNil(t, ledgerTestWrapper.GetBlockByNumber(5))
+	_, err = ledger.GetBlockByNumber(16)
+	testutil.AssertEquals(t, err, ErrPruned)
+
+	// the archiving mark survives a restart, which needs the archive
+	blockchain, err := newBlockchain(db.GetDBHandle())
+	testutil.AssertNoError(t, err, "Error reloading the blockchain")
+	testutil.AssertEquals(t, blockchain.getArchivedBelow(), uint64(15))
+	viper.Set("ledger.archive.store", "")
+	_, err = newBlockchain(db.GetDBHandle())
+	testutil.AssertError(t, err, "Expected an error reloading the blockchain without its archive")
+}
+
+func TestLedgerStateQuery(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	ledger := ledgerTestWrapper.ledger
+
+	ledger.BeginTxBatch(1)
+	ledger.TxBegin("txUuid1")
+	ledger.SetState("chaincode1", "key1", []byte(`{"owner": "alice"}`))
+	ledger.SetState("chaincode1", "key2", []byte(`{"owner": "bob"}`))
+	ledger.TxFinished("txUuid1", true)
+	transaction, _ := buildTestTx(t)
+	ledger.CommitTxBatch(1, []*protos.Transaction{transaction}, nil, []byte("proof"))
+
+	ledger.BeginTxBatch(2)
+	ledger.TxBegin("txUuid2")
+	ledger.SetState("chaincode1", "key3", []byte(`{"owner": "alice"}`))
+	ledger.TxFinished("txUuid2", true)
+
+	itr, err := ledger.GetStateQueryIterator("chaincode1", `{"selector": {"owner": "alice"}}`, true)
+	testutil.AssertNoError(t, err, "Error querying state")
+	statemgmt.AssertIteratorContains(t, itr, map[string][]byte{"key1": []byte(`{"owner": "alice"}`)})
+
+	itr, err = ledger.GetStateQueryIterator("chaincode1", `{"selector": {"owner": "alice"}}`, false)
+	testutil.AssertNoError(t, err, "Error querying state")
+	statemgmt.AssertIteratorContains(t, itr, map[string][]byte{
+		"key1": []byte(`{"owner": "alice"}`),
+		"key3": []byte(`{"owner": "alice"}`),
+	})
+
+	_, err = ledger.GetStateQueryIterator("chaincode1", `{"owner": "alice"}`, true)
+	testutil.AssertEquals(t, err.(*Error).Type(), ErrorTypeInvalidArgument)
+}
+
+func TestLedgerGetHistoryForKey(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	ledger := ledgerTestWrapper.ledger
+	_, err := ledger.GetHistoryForKey("chaincode1", "key1")
+	testutil.AssertSame(t, err, ErrHistoryDisabled)
+
+	viper.Set("ledger.history.enabled", true)
+	defer viper.Set("ledger.history.enabled", false)
+	ledgerTestWrapper = createFreshDBAndTestLedgerWrapper(t)
+	ledger = ledgerTestWrapper.ledger
+
+	tx1, uuid1 := buildTestTx(t)
+	tx2, uuid2 := buildTestTx(t)
+	ledger.BeginTxBatch(1)
+	ledger.TxBegin(uuid1)
+	ledger.SetState("chaincode1", "key1", []byte("value1"))
+	ledger.SetState("chaincode1", "key2", []byte("value2"))
+	ledger.TxFinished(uuid1, true)
+	ledger.TxBegin(uuid2)
+	ledger.SetState("chaincode1", "key1", []byte("value1-2"))
+	ledger.TxFinished(uuid2, true)
+	ledger.CommitTxBatch(1, []*protos.Transaction{tx1, tx2}, nil, []byte("proof"))
+
+	tx3, uuid3 := buildTestTx(t)
+	tx4, uuid4 := buildTestTx(t)
+	ledger.BeginTxBatch(2)
+	ledger.TxBegin(uuid3)
+	ledger.SetState("chaincode1", "key1", []byte("value1-3"))
+	ledger.TxFinished(uuid3, false)
+	ledger.TxBegin(uuid4)
+	ledger.DeleteState("chaincode1", "key1")
+	ledger.TxFinished(uuid4, true)
+	ledger.CommitTxBatch(2, []*protos.Transaction{tx3, tx4}, nil, []byte("proof"))
+
+	history, err := ledger.GetHistoryForKey("chaincode1", "key1")
+	testutil.AssertNoError(t, err, "Error getting history")
+	testutil.AssertEquals(t, history, []*protos.KeyModification{
+		{TxUuid: uuid1, BlockNumber: 0, Value: []byte("value1")},
+		{TxUuid: uuid2, BlockNumber: 0, Value: []byte("value1-2")},
+		{TxUuid: uuid4, BlockNumber: 1, IsDelete: true},
+	})
+
+	history, err = ledger.GetHistoryForKey("chaincode1", "key2")
+	testutil.AssertNoError(t, err, "Error getting history")
+	testutil.AssertEquals(t, len(history), 1)
+
+	history, err = ledger.GetHistoryForKey("chaincode2", "key1")
+	testutil.AssertNoError(t, err, "Error getting history")
+	testutil.AssertEquals(t, len(history), 0)
+}
+
+func TestLedgerStateProof(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	ledger := ledgerTestWrapper.ledger
+
+	ledger.BeginTxBatch(1)
+	ledger.TxBegin("txUuid1")
+	ledger.SetState("chaincode1", "key1", []byte("value1"))
+	ledger.SetState("chaincode1", "key2", []byte("value2"))
+	ledger.TxFinished("txUuid1", true)
+	transaction, _ := buildTestTx(t)
+	ledger.CommitTxBatch(1, []*protos.Transaction{transaction}, nil, []byte("proof"))
+
+	ledger.BeginTxBatch(2)
+	ledger.TxBegin("txUuid2")
+	ledger.SetState("chaincode1", "key1", []byte("value1-2"))
+	ledger.DeleteState("chaincode1", "key2")
+	ledger.TxFinished("txUuid2", true)
+	transaction, _ = buildTestTx(t)
+	ledger.CommitTxBatch(2, []*protos.Transaction{transaction}, nil, []byte("proof"))
+	block, err := ledger.GetBlockByNumber(1)
+	testutil.AssertNoError(t, err, "Error getting block")
+
+	proof, err := ledger.GetStateProof("chaincode1", "key1")
+	testutil.AssertNoError(t, err, "Error getting state proof")
+	testutil.AssertEquals(t, proof.BlockNumber, uint64(1))
+	testutil.AssertEquals(t, proof.Exists, true)
+	testutil.AssertEquals(t, proof.Value, []byte("value1-2"))
+	testutil.AssertNoError(t, VerifyStateProof(proof, block), "Error verifying state proof")
+
+	proof, err = ledger.GetStateProof("chaincode1", "key2")
+	testutil.AssertNoError(t, err, "Error getting state proof")
+	testutil.AssertEquals(t, proof.Exists, false)
+	testutil.AssertNoError(t, VerifyStateProof(proof, block), "Error verifying state proof")
+
+	// the proof does not hold for another value, nor against another block
+	proof.Exists = true
+	proof.Value = []byte("value2")
+	testutil.AssertError(t, VerifyStateProof(proof, block), "Expected an error verifying a tampered state proof")
+	proof, _ = ledger.GetStateProof("chaincode1", "key1")
+	previousBlock, _ := ledger.GetBlockByNumber(0)
+	testutil.AssertError(t, VerifyStateProof(proof, previousBlock), "Expected an error verifying a state proof against another block")
+}
+
+func TestLedgerTxSimulation(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	ledger := ledgerTestWrapper.ledger
+
+	ledger.BeginTxBatch(1)
+	ledger.TxBegin("txUuid1")
+	ledger.SetState("chaincode1", "key1", []byte("value1"))
+	ledger.TxFinished("txUuid1", true)
+	transaction, _ := buildTestTx(t)
+	ledger.CommitTxBatch(1, []*protos.Transaction{transaction}, nil, []byte("proof"))
+
+	ledger.BeginTxBatch(2)
+	testutil.AssertNoError(t, ledger.BeginTxSimulation("txUuid2"), "Error beginning a simulation")
+	testutil.AssertNoError(t, ledger.BeginTxSimulation("txUuid3"), "Error beginning a simulation")
+	testutil.AssertNoError(t, ledger.BeginTxSimulation("txUuid4"), "Error beginning a simulation")
+	testutil.AssertError(t, ledger.BeginTxSimulation("txUuid2"), "Expected an error simulating a transaction twice")
+	testutil.AssertEquals(t, ledger.IsTxSimulated("txUuid2"), true)
+	testutil.AssertSame(t, ledger.GetTxState("txUuid5"), ledger)
+
+	// txUuid2 and txUuid3 both increment key1, txUuid4 writes another key
+	for _, txUUID := range []string{"txUuid2", "txUuid3"} {
+		txState := ledger.GetTxState(txUUID)
+		value, err := txState.GetState("chaincode1", "key1", true)
+		testutil.AssertNoError(t, err, "Error reading a simulated state")
+		testutil.AssertEquals(t, value, []byte("value1"))
+		testutil.AssertNoError(t, txState.SetState("chaincode1", "key1", []byte(txUUID)), "Error writing a simulated state")
+	}
+	txState := ledger.GetTxState("txUuid4")
+	testutil.AssertError(t, txState.SetState("chaincode1", "", []byte("value")), "Expected an error for an empty key")
+	testutil.AssertNoError(t, txState.SetStateMultipleKeys("chaincode1", map[string][]byte{"key2": []byte("value2"), "key3": []byte("value3")}),
+		"Error writing a simulated state")
+	values, err := txState.GetStateMultipleKeys("chaincode1", []string{"key3", "key4", "key2"}, true)
+	testutil.AssertNoError(t, err, "Error reading a simulated state")
+	testutil.AssertEquals(t, values, [][]byte{[]byte("value3"), nil, []byte("value2")})
+
+	// the first of the conflicting transactions in the batch wins
+	testutil.AssertNoError(t, ledger.EndTxSimulation("txUuid2", true), "Error ending a simulation")
+	testutil.AssertEquals(t, ledger.EndTxSimulation("txUuid3", true), ErrReadConflict)
+	testutil.AssertNoError(t, ledger.EndTxSimulation("txUuid4", true), "Error ending a simulation")
+	testutil.AssertEquals(t, ledger.IsTxSimulated("txUuid2"), false)
+	testutil.AssertError(t, ledger.EndTxSimulation("txUuid2", true), "Expected an error ending a simulation twice")
+
+	transaction, _ = buildTestTx(t)
+	ledger.CommitTxBatch(2, []*protos.Transaction{transaction}, nil, []byte("proof"))
+	testutil.AssertEquals(t, ledgerTestWrapper.GetState("chaincode1", "key1", true), []byte("txUuid2"))
+	testutil.AssertEquals(t, ledgerTestWrapper.GetState("chaincode1", "key2", true), []byte("value2"))
+	testutil.AssertEquals(t, ledgerTestWrapper.GetState("chaincode1", "key3", true), []byte("value3"))
+}
+
+func TestLedgerGetStateByPartialCompositeKey(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	ledger := ledgerTestWrapper.ledger
+
+	key := func(attributes ...string) string {
+		compositeKey, err := compositekey.Create("car", attributes)
+		testutil.AssertNoError(t, err, "Error creating a composite key")
+		return compositeKey
+	}
+	keys := func(itr statemgmt.RangeScanIterator) []string {
+		var keys []string
+		for itr.Next() {
+			k, _ := itr.GetKeyValue()
+			keys = append(keys, k)
+		}
+		itr.Close()
+		return keys
+	}
+
+	ledger.BeginTxBatch(1)
+	ledger.TxBegin("txUuid1")
+	ledger.SetState("chaincode1", key("red", "2016"), []byte("value1"))
+	ledger.SetState("chaincode1", key("blue", "2015"), []byte("value2"))
+	ledger.SetState("chaincode1", key("red", "2014"), []byte("value3"))
+	ledger.SetState("chaincode1", key("redish", "2016"), []byte("value4"))
+	ledger.SetState("chaincode1", "car", []byte("value5"))
+	ledger.SetState("chaincode2", key("red", "2013"), []byte("value6"))
+	ledger.TxFinished("txUuid1", true)
+	transaction, _ := buildTestTx(t)
+	ledger.CommitTxBatch(1, []*protos.Transaction{transaction}, nil, []byte("proof"))
+
+	itr, err := ledger.GetStateByPartialCompositeKey("chaincode1", "car", []string{"red"}, true)
+	testutil.AssertNoError(t, err, "Error getting state by partial composite key")
+	testutil.AssertEquals(t, keys(itr), []string{key("red", "2014"), key("red", "2016")})
+
+	itr, _ = ledger.GetStateByPartialCompositeKey("chaincode1", "car", nil, true)
+	testutil.AssertEquals(t, keys(itr), []string{key("blue", "2015"), key("red", "2014"), key("red", "2016"), key("redish", "2016")})
+
+	// uncommitted changes are seen, in order
+	ledger.BeginTxBatch(2)
+	ledger.TxBegin("txUuid2")
+	ledger.SetState("chaincode1", key("red", "2015"), []byte("value7"))
+	ledger.DeleteState("chaincode1", key("red", "2016"))
+	itr, _ = ledger.GetStateByPartialCompositeKey("chaincode1", "car", []string{"red"}, false)
+	testutil.AssertEquals(t, keys(itr), []string{key("red", "2014"), key("red", "2015")})
+	ledger.TxFinished("txUuid2", true)
+
+	_, err = ledger.GetStateByPartialCompositeKey("chaincode1", "car", []string{"red\x00"}, true)
+	testutil.AssertError(t, err, "Expected an error for an invalid attribute")
+}
+
+func TestLedgerRebuildState(t *testing.T) {
+	viper.Set("ledger.history.enabled", true)
+	defer viper.Set("ledger.history.enabled", false)
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	ledger := ledgerTestWrapper.ledger
+
+	tx1, uuid1 := buildTestTx(t)
+	ledger.BeginTxBatch(1)
+	ledger.TxBegin(uuid1)
+	ledger.SetState("chaincode1", "key1", []byte("value1"))
+	ledger.SetState("chaincode1", "key2", []byte("value2"))
+	ledger.TxFinished(uuid1, true)
+	ledger.CommitTxBatch(1, []*protos.Transaction{tx1}, nil, []byte("proof"))
+
+	tx2, uuid2 := buildTestTx(t)
+	ledger.BeginTxBatch(2)
+	ledger.TxBegin(uuid2)
+	ledger.SetState("chaincode1", "key1", []byte("value1-2"))
+	ledger.DeleteState("chaincode1", "key2")
+	ledger.TxFinished(uuid2, true)
+	ledger.CommitTxBatch(2, []*protos.Transaction{tx2}, nil, []byte("proof"))
+	stateHash := ledgerTestWrapper.GetTempStateHash()
+
+	err := ResetState()
+	testutil.AssertNoError(t, err, "Error resetting the state")
+	ledger, err = newLedger(db.GetDBHandle())
+	testutil.AssertNoError(t, err, "Error while constructing ledger")
+	ledgerTestWrapper.ledger = ledger
+	testutil.AssertNil(t, ledgerTestWrapper.GetState("chaincode1", "key1", true))
+	testutil.AssertEquals(t, ledger.GetBlockchainSize(), uint64(2))
+	_, err = ledger.GetTransactionByUUID(uuid1)
+	testutil.AssertError(t, err, "Expected the indexes to be deleted")
+	next, rebuilding, err := GetStateRebuildFrom()
+	testutil.AssertNoError(t, err, "Error getting the next block to replay")
+	testutil.AssertEquals(t, rebuilding, true)
+	testutil.AssertEquals(t, next, uint64(0))
+
+	// blocks are replayed in order
+	ledger.BeginTxBatch(1)
+	err = ledger.CommitRebuiltBlock(1, 1)
+	testutil.AssertError(t, err, "Expected an error replaying block 1 before block 0")
+
+	// a replay not matching the state hash of the block is discarded
+	ledger.BeginTxBatch(0)
+	ledger.TxBegin(uuid1)
+	ledger.SetState("chaincode1", "key1", []byte("value1-other"))
+	ledger.TxFinished(uuid1, true)
+	err = ledger.CommitRebuiltBlock(0, 0)
+	testutil.AssertError(t, err, "Expected an error for a state hash mismatch")
+	testutil.AssertNil(t, ledgerTestWrapper.GetState("chaincode1", "key1", false))
+
+	ledger.BeginTxBatch(0)
+	ledger.TxBegin(uuid1)
+	ledger.SetState("chaincode1", "key1", []byte("value1"))
+	ledger.SetState("chaincode1", "key2", []byte("value2"))
+	ledger.TxFinished(uuid1, true)
+	err = ledger.CommitRebuiltBlock(0, 0)
+	testutil.AssertNoError(t, err, "Error committing the replayed block 0")
+	next, rebuilding, _ = GetStateRebuildFrom()
+	testutil.AssertEquals(t, rebuilding, true)
+	testutil.AssertEquals(t, next, uint64(1))
+
+	ledger.BeginTxBatch(1)
+	ledger.TxBegin(uuid2)
+	ledger.SetState("chaincode1", "key1", []byte("value1-2"))
+	ledger.DeleteState("chaincode1", "key2")
+	ledger.TxFinished(uuid2, true)
+	err = ledger.CommitRebuiltBlock(1, 1)
+	testutil.AssertNoError(t, err, "Error committing the replayed block 1")
+
+	_, rebuilding, _ = GetStateRebuildFrom()
+	testutil.AssertEquals(t, rebuilding, false)
+	testutil.AssertEquals(t, ledger.GetBlockchainSize(), uint64(2))
+	testutil.AssertEquals(t, ledgerTestWrapper.GetTempStateHash(), stateHash)
+	testutil.AssertEquals(t, ledgerTestWrapper.GetState("chaincode1", "key1", true), []byte("value1-2"))
+	testutil.AssertNil(t, ledgerTestWrapper.GetState("chaincode1", "key2", true))
+	testutil.AssertNotNil(t, ledgerTestWrapper.GetStateDelta(1))
+	tx, err := ledger.GetTransactionByUUID(uuid2)
+	testutil.AssertNoError(t, err, "Error getting a transaction indexed again")
+	testutil.AssertEquals(t, tx.Uuid, uuid2)
+	history, err := ledger.GetHistoryForKey("chaincode1", "key1")
+	testutil.AssertNoError(t, err, "Error getting history")
+	testutil.AssertEquals(t, len(history), 2)
+}
+
+func TestLedgerGetStateAt(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	ledger := ledgerTestWrapper.ledger
+
+	commit := func(blockNumber uint64, changes func()) {
+		tx, uuid := buildTestTx(t)
+		ledger.BeginTxBatch(blockNumber)
+		ledger.TxBegin(uuid)
+		changes()
+		ledger.TxFinished(uuid, true)
+		ledger.CommitTxBatch(blockNumber, []*protos.Transaction{tx}, nil, []byte("proof"))
+	}
+	commit(0, func() { ledger.SetState("chaincode1", "key1", []byte("value1")) })
+	commit(1, func() { ledger.SetState("chaincode1", "key2", []byte("value2")) })
+	commit(2, func() { ledger.SetState("chaincode1", "key1", []byte("value1-2")) })
+	commit(3, func() { ledger.DeleteState("chaincode1", "key1") })
+
+	for _, test := range []struct {
+		height   uint64
+		key      string
+		expected []byte
+	}{
+		{0, "key1", nil},
+		{1, "key1", []byte("value1")},
+		{2, "key1", []byte("value1")},
+		{3, "key1", []byte("value1-2")},
+		{4, "key1", nil},
+		{1, "key2", nil},
+		{2, "key2", []byte("value2")},
+		{4, "key2", []byte("value2")},
+	} {
+		value, err := ledger.GetStateAt(test.height, "chaincode1", test.key)
+		testutil.AssertNoError(t, err, "Error getting the state at a height")
+		testutil.AssertEquals(t, value, test.expected)
+	}
+
+	_, err := ledger.GetStateAt(5, "chaincode1", "key1")
+	testutil.AssertSame(t, err, ErrOutOfBounds)
+
+	// a state delta no longer kept cannot be rewound
+	openchainDB := db.GetDBHandle()
+	blockZeroKey := make([]byte, 8)
+	err = openchainDB.Delete(openchainDB.StateDeltaCF, blockZeroKey)
+	testutil.AssertNoError(t, err, "Error deleting the state delta of block 0")
+	_, err = ledger.GetStateAt(0, "chaincode1", "key1")
+	testutil.AssertError(t, err, "Expected an error for a state delta no longer kept")
+	value, err := ledger.GetStateAt(1, "chaincode1", "key1")
+	testutil.AssertNoError(t, err, "Error getting key1 at height 1")
+	testutil.AssertEquals(t, value, []byte("value1"))
+}
+
+func TestLedgerGetStateByIndexNotDeclared(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	ledger := ledgerTestWrapper.ledger
+
+	_, err := ledger.GetStateByIndex("chaincode1", "owner", "alice", "alice", true)
+	testutil.AssertSame(t, err, ErrIndexNotFound)
+
+	ledger.BeginTxBatch(1)
+	testutil.AssertNoError(t, ledger.BeginTxSimulation("txUuid1"), "Error beginning the simulation")
+	_, err = ledger.GetTxState("txUuid1").GetStateByIndex("chaincode1", "owner", "alice", "alice", false)
+	testutil.AssertSame(t, err, ErrIndexNotFound)
+	testutil.AssertNoError(t, ledger.EndTxSimulation("txUuid1", false), "Error ending the simulation")
+	ledger.RollbackTxBatch(1)
+}
+
+func TestLedgerCommitPipeline(t *testing.T) {
+	viper.Set("ledger.commit.pipeline", true)
+	defer viper.Set("ledger.commit.pipeline", false)
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	ledger := ledgerTestWrapper.ledger
+
+	// each write of a block waits for the error to return, nil to write it
+	writes := make(chan error, 1)
+	defaultWriteBlockBatch := writeBlockBatch
+	writeBlockBatch = func(openchainDB *db.OpenchainDB, writeBatch *db.WriteBatch) error {
+		if err := <-writes; err != nil {
+			return err
+		}
+		return defaultWriteBlockBatch(openchainDB, writeBatch)
+	}
+	defer func() { writeBlockBatch = defaultWriteBlockBatch }()
+
+	commit := func(blockNumber uint64, changes func()) error {
+		tx, uuid := buildTestTx(t)
+		ledger.BeginTxBatch(blockNumber)
+		ledger.TxBegin(uuid)
+		changes()
+		ledger.TxFinished(uuid, true)
+		return ledger.CommitTxBatch(blockNumber, []*protos.Transaction{tx}, nil, []byte("proof"))
+	}
+	err := commit(0, func() {
+		ledger.SetState("chaincode1", "key1", []byte("value1"))
+		ledger.SetState("chaincode1", "key2", []byte("value2"))
+	})
+	testutil.AssertNoError(t, err, "Error committing block 0")
+
+	// block 0 is committed while it is not written yet
+	size, _ := fetchBlockchainSizeFromDB(db.GetDBHandle())
+	testutil.AssertEquals(t, size, uint64(0))
+	testutil.AssertEquals(t, ledger.GetBlockchainSize(), uint64(1))
+	value, _ := ledger.GetState("chaincode1", "key1", true)
+	testutil.AssertEquals(t, value, []byte("value1"))
+	itr, _ := ledger.GetStateRangeScanIterator("chaincode1", "", "", true)
+	keys := 0
+	for itr.Next() {
+		keys++
+	}
+	itr.Close()
+	testutil.AssertEquals(t, keys, 2)
+
+	// block 1 executes on top of block 0, and commits once it is written
+	writes <- nil
+	err = commit(1, func() {
+		ledger.SetState("chaincode1", "key1", []byte("value1-2"))
+		ledger.DeleteState("chaincode1", "key2")
+	})
+	testutil.AssertNoError(t, err, "Error committing block 1")
+	writes <- nil
+	block, err := ledger.GetBlockByNumber(1)
+	testutil.AssertNoError(t, err, "Error getting block 1")
+	delta, _ := ledger.GetStateDelta(1)
+	testutil.AssertEquals(t, delta.Get("chaincode1", "key2").GetPreviousValue(), []byte("value2"))
+	size, _ = fetchBlockchainSizeFromDB(db.GetDBHandle())
+	testutil.AssertEquals(t, size, uint64(2))
+
+	// a failed write is retried, the DB and the ledger end up at the same height
+	defaultPersistRetryInterval := persistRetryInterval
+	persistRetryInterval = time.Millisecond
+	defer func() { persistRetryInterval = defaultPersistRetryInterval }()
+	err = commit(2, func() { ledger.SetState("chaincode1", "key3", []byte("value3")) })
+	testutil.AssertNoError(t, err, "Error committing block 2")
+	writes <- errors.New("disk full")
+	writes <- nil
+	_, err = ledger.GetBlockByNumber(2)
+	testutil.AssertNoError(t, err, "Error getting block 2")
+	size, _ = fetchBlockchainSizeFromDB(db.GetDBHandle())
+	testutil.AssertEquals(t, size, uint64(3))
+	testutil.AssertEquals(t, ledger.GetBlockchainSize(), size)
+	_, err = ledger.VerifyChain(2, 0)
+	testutil.AssertNoError(t, err, "Error verifying the chain")
+	previousBlock, _ := ledger.GetBlockByNumber(2)
+	previousHash, _ := block.GetHash()
+	testutil.AssertEquals(t, previousBlock.PreviousBlockHash, previousHash)
+	value, _ = ledger.GetState("chaincode1", "key3", true)
+	testutil.AssertEquals(t, value, []byte("value3"))
+
+	// a block failing to be written for good halts the peer, it is not dropped
+	var halted error
+	defaultHaltPeer := haltPeer
+	haltPeer = func(err error) { halted = err }
+	defer func() { haltPeer = defaultHaltPeer }()
+	err = commit(3, func() { ledger.SetState("chaincode1", "key4", []byte("value4")) })
+	testutil.AssertNoError(t, err, "Error committing block 3")
+	for i := 0; i < persistRetries; i++ {
+		writes <- errors.New("disk full")
+	}
+	_, err = ledger.GetBlockByNumber(3)
+	testutil.AssertError(t, err, "Expected an error for a block failing to be written")
+	testutil.AssertError(t, halted, "Expected the peer to be halted")
+	err = commit(4, func() { ledger.SetState("chaincode1", "key5", []byte("value5")) })
+	testutil.AssertError(t, err, "Expected an error for a block failing to be written")
+	testutil.AssertEquals(t, ledger.GetBlockchainSize(), uint64(4))
+	value, _ = ledger.GetState("chaincode1", "key4", true)
+	testutil.AssertEquals(t, value, []byte("value4"))
+}
+
+func TestLedgerCommitHooks(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	ledger := ledgerTestWrapper.ledger
+
+	var events []*CommitEvent
+	err := RegisterCommitHook("projection", func(event *CommitEvent) {
+		events = append(events, event)
+	})
+	testutil.AssertNoError(t, err, "Error registering the commit hook")
+	defer UnregisterCommitHook("projection")
+	err = RegisterCommitHook("projection", func(event *CommitEvent) {})
+	testutil.AssertError(t, err, "Expected an error registering a hook twice")
+	// a hook panicking does not fail the commit
+	err = RegisterCommitHook("panicking", func(event *CommitEvent) { panic("hook failure") })
+	testutil.AssertNoError(t, err, "Error registering the commit hook")
+	defer UnregisterCommitHook("panicking")
+
+	tx1, uuid1 := buildTestTx(t)
+	tx2, uuid2 := buildTestTx(t)
+	ledger.BeginTxBatch(0)
+	ledger.TxBegin(uuid1)
+	ledger.SetState("chaincode1", "key1", []byte("value1"))
+	ledger.TxFinished(uuid1, true)
+	ledger.TxBegin(uuid2)
+	ledger.SetState("chaincode1", "key2", []byte("value2"))
+	ledger.TxFinished(uuid2, false)
+	results := []*protos.TransactionResult{{Uuid: uuid1}, {Uuid: uuid2, ErrorCode: 1, Error: "failed"}}
+	err = ledger.CommitTxBatch(0, []*protos.Transaction{tx1, tx2}, results, []byte("proof"))
+	testutil.AssertNoError(t, err, "Error committing block 0")
+
+	testutil.AssertEquals(t, len(events), 1)
+	testutil.AssertEquals(t, events[0].BlockNumber, uint64(0))
+	testutil.AssertEquals(t, len(events[0].Block.Transactions), 2)
+	testutil.AssertEquals(t, events[0].StateDelta.Get("chaincode1", "key1").GetValue(), []byte("value1"))
+	testutil.AssertNil(t, events[0].StateDelta.Get("chaincode1", "key2"))
+	testutil.AssertEquals(t, events[0].InvalidTxs, map[string]string{uuid2: "failed"})
+
+	// the hooks of the blocks written in the background are called in order
+	viper.Set("ledger.commit.pipeline", true)
+	defer viper.Set("ledger.commit.pipeline", false)
+	ledgerTestWrapper = createFreshDBAndTestLedgerWrapper(t)
+	ledger = ledgerTestWrapper.ledger
+	events = nil
+	for blockNumber := uint64(0); blockNumber < 3; blockNumber++ {
+		tx, uuid := buildTestTx(t)
+		ledger.BeginTxBatch(blockNumber)
+		ledger.TxBegin(uuid)
+		ledger.SetState("chaincode1", "key1", []byte{byte(blockNumber)})
+		ledger.TxFinished(uuid, true)
+		err = ledger.CommitTxBatch(blockNumber, []*protos.Transaction{tx}, nil, []byte("proof"))
+		testutil.AssertNoError(t, err, "Error committing a block")
+	}
+	_, err = ledger.GetBlockByNumber(2)
+	testutil.AssertNoError(t, err, "Error getting block 2")
+	testutil.AssertEquals(t, len(events), 3)
+	for i, event := range events {
+		testutil.AssertEquals(t, event.BlockNumber, uint64(i))
+		testutil.AssertEquals(t, event.StateDelta.Get("chaincode1", "key1").GetValue(), []byte{byte(i)})
+	}
+
+	UnregisterCommitHook("projection")
+	ledger.BeginTxBatch(3)
+	err = ledger.CommitTxBatch(3, nil, nil, []byte("proof"))
+	testutil.AssertNoError(t, err, "Error committing block 3")
+	ledger.GetBlockByNumber(3)
+	testutil.AssertEquals(t, len(events), 3)
+}
+
+func TestChainLedgers(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	defaultLedger := ledgerTestWrapper.ledger
+	chainLedgersLock.Lock()
+	chainLedgers = make(map[string]*Ledger)
+	chainLedgersLock.Unlock()
+
+	_, err := GetChainLedger("../chain1")
+	testutil.AssertError(t, err, "Got the ledger of an invalid chain ID")
+	chain1, err := GetChainLedger("chain1")
+	testutil.AssertNoError(t, err, "Error getting the ledger of chain1")
+	chain2, err := GetChainLedger("chain2")
+	testutil.AssertNoError(t, err, "Error getting the ledger of chain2")
+	again, _ := GetChainLedger("chain1")
+	testutil.AssertSame(t, again, chain1)
+	testutil.AssertEquals(t, chain1.GetChainID(), "chain1")
+	testutil.AssertEquals(t, defaultLedger.GetChainID(), "")
+
+	commit := func(ledger *Ledger, value string) *protos.Transaction {
+		tx, uuid := buildTestTx(t)
+		ledger.BeginTxBatch(1)
+		ledger.TxBegin(uuid)
+		ledger.SetState("chaincode1", "key1", []byte(value))
+		ledger.TxFinished(uuid, true)
+		err := ledger.CommitTxBatch(1, []*protos.Transaction{tx}, nil, []byte("proof"))
+		testutil.AssertNoError(t, err, "Error committing a block")
+		return tx
+	}
+	defaultTx := commit(defaultLedger, "default")
+	chain1Tx := commit(chain1, "chain1")
+	commit(chain1, "chain1 again")
+
+	// every chain has a blockchain and a state of its own
+	testutil.AssertEquals(t, defaultLedger.GetBlockchainSize(), uint64(1))
+	testutil.AssertEquals(t, chain1.GetBlockchainSize(), uint64(2))
+	testutil.AssertEquals(t, chain2.GetBlockchainSize(), uint64(0))
+	value, _ := defaultLedger.GetState("chaincode1", "key1", true)
+	testutil.AssertEquals(t, value, []byte("default"))
+	value, _ = chain1.GetState("chaincode1", "key1", true)
+	testutil.AssertEquals(t, value, []byte("chain1 again"))
+	value, _ = chain2.GetState("chaincode1", "key1", true)
+	testutil.AssertNil(t, value)
+
+	block, err := chain1.GetBlockByNumber(0)
+	testutil.AssertNoError(t, err, "Error getting block 0 of chain1")
+	testutil.AssertEquals(t, block.ChainID, "chain1")
+	block, _ = defaultLedger.GetBlockByNumber(0)
+	testutil.AssertEquals(t, block.ChainID, "")
+
+	tx, _ := chain1.GetTransactionByUUID(chain1Tx.Uuid)
+	testutil.AssertNotNil(t, tx)
+	tx, _ = defaultLedger.GetTransactionByUUID(chain1Tx.Uuid)
+	testutil.AssertNil(t, tx)
+	tx, _ = chain1.GetTransactionByUUID(defaultTx.Uuid)
+	testutil.AssertNil(t, tx)
+}
@@ -30,8 +30,11 @@ func InitTestLedger(t *testing.T) *Ledger {
 	testDBWrapper.CreateFreshDB(t)
 	_, err := GetLedger()
 	testutil.AssertNoError(t, err, "Error while constructing ledger")
-	newLedger, err := newLedger()
+	newLedger, err := newLedger(db.GetDBHandle())
 	testutil.AssertNoError(t, err, "Error while constructing ledger")
 	ledger = newLedger
+	chainLedgersLock.Lock()
+	chainLedgers = make(map[string]*Ledger)
+	chainLedgersLock.Unlock()
 	return newLedger
 }
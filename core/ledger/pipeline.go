@@ -0,0 +1,121 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ledger
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
+)
+
+// commitPipelineEnabled tells whether the blocks committed are written to the
+// DB in the background, from the configuration 'ledger.commit.pipeline'
+func commitPipelineEnabled() bool {
+	return viper.GetBool("ledger.commit.pipeline")
+}
+
+// writeBlockBatch writes to the DB the batch of a block committed in the
+// background. Tests replace it to delay or fail the write
+var writeBlockBatch = func(openchainDB *db.OpenchainDB, writeBatch *db.WriteBatch) error {
+	return openchainDB.Write(writeBatch)
+}
+
+// A block committed in the background is written up to persistRetries times,
+// waiting persistRetryInterval after the first failure, twice as long after
+// the next one, and so on
+var (
+	persistRetries       = 5
+	persistRetryInterval = 100 * time.Millisecond
+)
+
+// haltPeer is called when a block committed in the background cannot be
+// written to the DB. CommitTxBatch has already returned for the block, which
+// can neither be dropped nor written, so the peer stops. Tests replace it
+var haltPeer = func(err error) {
+	ledgerLogger.Fatalf("Halting the peer. %s", err)
+}
+
+// pendingCommit is a block committed by CommitTxBatch while it is written to
+// the DB in the background
+type pendingCommit struct {
+	blockNumber uint64
+	done        chan struct{}
+	err         error
+}
+
+// persistInBackground writes writeBatch, holding the block blockNumber and the
+// state changes of its transactions, to the DB in the background, then calls
+// the commit hooks and sends the block event. The in-memory blockchain and
+// state must already have moved on to the block. The write is retried, and the
+// peer halted if it keeps failing. The batch is destroyed once written
+func (ledger *Ledger) persistInBackground(block *protos.Block, blockNumber uint64, stateDelta *statemgmt.StateDelta, writeBatch *db.WriteBatch) {
+	pending := &pendingCommit{blockNumber: blockNumber, done: make(chan struct{})}
+	ledger.pendingLock.Lock()
+	ledger.pending = pending
+	ledger.pendingLock.Unlock()
+	go func() {
+		defer close(pending.done)
+		pending.err = writeBlockBatchWithRetries(ledger.openchainDB, blockNumber, writeBatch)
+		writeBatch.Destroy()
+		if pending.err != nil {
+			haltPeer(fmt.Errorf("Error writing block [%d] to the DB: %s", blockNumber, pending.err))
+			return
+		}
+		fireCommitHooks(block, blockNumber, stateDelta)
+		sendProducerBlockEvent(block)
+	}()
+}
+
+// writeBlockBatchWithRetries writes writeBatch, holding the block blockNumber,
+// to openchainDB, retrying as long as persistRetries allows. Returns the error of
+// the last attempt
+func writeBlockBatchWithRetries(openchainDB *db.OpenchainDB, blockNumber uint64, writeBatch *db.WriteBatch) error {
+	interval := persistRetryInterval
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = writeBlockBatch(openchainDB, writeBatch); err == nil || attempt >= persistRetries {
+			return err
+		}
+		ledgerLogger.Warning("Error writing block [%d] to the DB, attempt %d of %d: %s", blockNumber, attempt, persistRetries, err)
+		time.Sleep(interval)
+		interval *= 2
+	}
+}
+
+// waitPersisted waits for the block committed last to be written to the DB,
+// if it is written in the background, so that the DB holds all the blocks of
+// the blockchain. Once the write failed for good, it returns the error, as
+// the ledger cannot move on without the block
+func (ledger *Ledger) waitPersisted() error {
+	ledger.pendingLock.Lock()
+	defer ledger.pendingLock.Unlock()
+	pending := ledger.pending
+	if pending == nil {
+		return nil
+	}
+	<-pending.done
+	if pending.err != nil {
+		return fmt.Errorf("Error writing block [%d] to the DB: %s", pending.blockNumber, pending.err)
+	}
+	ledger.pending = nil
+	ledger.state.ChangesPersisted()
+	return nil
+}
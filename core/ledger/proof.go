@@ -0,0 +1,63 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ledger
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/state"
+	"github.com/hyperledger/fabric/protos"
+)
+
+// GetStateProof returns a proof that key of chaincodeID holds its committed value, or none, in the
+// state recorded in the last block. Light clients check it with VerifyStateProof, without trusting
+// this peer. The proof is read from a DB snapshot, so it agrees with the block despite new commits
+func (ledger *Ledger) GetStateProof(chaincodeID string, key string) (*protos.StateProof, error) {
+	if !ledger.state.ProofSupported() {
+		return nil, ErrProofNotSupported
+	}
+	dbSnapshot := ledger.openchainDB.GetSnapshot()
+	defer dbSnapshot.Release()
+	blockHeight, err := fetchBlockchainSizeFromSnapshot(ledger.openchainDB, dbSnapshot)
+	if err != nil {
+		return nil, err
+	}
+	if blockHeight == 0 {
+		return nil, fmt.Errorf("Blockchain has no blocks, cannot determine block number")
+	}
+	block, err := ledger.blockchain.getBlockFromSnapshot(dbSnapshot, blockHeight-1)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := ledger.state.GetProof(dbSnapshot, chaincodeID, key)
+	if err != nil {
+		return nil, err
+	}
+	proof.BlockNumber = blockHeight - 1
+	proof.StateHash = block.StateHash
+	return proof, nil
+}
+
+// VerifyStateProof checks that proof proves the value of its key in the state of block, a block the
+// caller trusts, for instance from the hashes linking it to a known block. It needs no peer nor DB
+func VerifyStateProof(proof *protos.StateProof, block *protos.Block) error {
+	if !bytes.Equal(proof.StateHash, block.StateHash) {
+		return fmt.Errorf("State hash [%x] of the proof is not the state hash [%x] of the block", proof.StateHash, block.StateHash)
+	}
+	return state.VerifyProof(proof)
+}
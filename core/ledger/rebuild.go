@@ -0,0 +1,165 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ledger
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/db"
+)
+
+// stateRebuildKey holds, while the world state is rebuilt, the number of the
+// next block to replay
+var stateRebuildKey = []byte("stateRebuildFrom")
+
+// ResetState deletes the world state of the default chain, the state deltas, the history of the
+// keys, and the indexes of the state and of the blockchain from the DB, and
+// marks the state to be rebuilt by replaying the blocks from the genesis
+// block, see CommitRebuiltBlock. The blocks are kept. This recovers from a
+// corrupted state DB without rejoining the network. It must be called while
+// the ledger is not open, since the ledger caches the state and the indexes.
+// Fails if blocks older than the head block were pruned, the state could not
+// be rebuilt without them.
+func ResetState() error {
+	openchainDB := db.GetDBHandle()
+	size, err := fetchBlockchainSizeFromDB(openchainDB)
+	if err != nil {
+		return err
+	}
+	prunedBelow, err := fetchPrunedBelowFromDB(openchainDB)
+	if err != nil {
+		return err
+	}
+	if prunedBelow > 1 {
+		return newLedgerError(ErrorTypePruned,
+			fmt.Sprintf("Cannot rebuild the state, blocks 1 to %d were pruned", prunedBelow-1))
+	}
+
+	// mark the rebuild first, so that the state is not left partially
+	// deleted and unmarked if the reset is interrupted
+	if size > 0 {
+		err = openchainDB.Put(openchainDB.BlockchainCF, stateRebuildKey, encodeUint64(0))
+		if err != nil {
+			return err
+		}
+	}
+	for _, cf := range []db.ColumnFamily{openchainDB.StateCF, openchainDB.StateDeltaCF,
+		openchainDB.HistoryCF, openchainDB.IndexesCF, openchainDB.StateIndexCF} {
+		if err = openchainDB.DropColumnFamily(cf); err != nil {
+			return err
+		}
+	}
+	ledgerLogger.Info("Deleted the state, to be rebuilt from %d blocks", size)
+	return nil
+}
+
+// GetStateRebuildFrom returns the number of the next block to replay, and
+// whether the state is being rebuilt after ResetState. A rebuild from block 0
+// may follow an interrupted ResetState, which should then be run again.
+func GetStateRebuildFrom() (uint64, bool, error) {
+	return getStateRebuildFrom(db.GetDBHandle())
+}
+
+// GetStateRebuildFrom returns the number of the next block to replay, and
+// whether the state of the chain of the ledger is being rebuilt.
+func (ledger *Ledger) GetStateRebuildFrom() (uint64, bool, error) {
+	return getStateRebuildFrom(ledger.openchainDB)
+}
+
+func getStateRebuildFrom(openchainDB *db.OpenchainDB) (uint64, bool, error) {
+	value, err := openchainDB.GetFromBlockchainCF(stateRebuildKey)
+	if err != nil || value == nil {
+		return 0, false, err
+	}
+	return decodeToUint64(value), true, nil
+}
+
+// CommitRebuiltBlock commits the state changes of the current
+// transaction-batch, which replayed the transactions of block blockNumber,
+// the next block to replay. The state hash must match the one of the block,
+// else the changes are discarded. The block is not appended to the
+// blockchain again, only the state, its delta, the history of the keys and
+// the indexes of the block are persisted. The rebuild is over once the last
+// block of the blockchain is committed.
+func (ledger *Ledger) CommitRebuiltBlock(id interface{}, blockNumber uint64) error {
+	err := ledger.checkValidIDCommitORRollback(id)
+	if err != nil {
+		return err
+	}
+
+	next, rebuilding, err := getStateRebuildFrom(ledger.openchainDB)
+	if err == nil && (!rebuilding || blockNumber != next) {
+		err = newLedgerError(ErrorTypeInvalidArgument,
+			fmt.Sprintf("Block %d is not the next block to replay to rebuild the state", blockNumber))
+	}
+	if err != nil {
+		ledger.resetForNextTxGroup(false)
+		return err
+	}
+	block, err := ledger.GetBlockByNumber(blockNumber)
+	if err != nil {
+		ledger.resetForNextTxGroup(false)
+		return err
+	}
+	stateHash, err := ledger.state.GetHash()
+	if err != nil {
+		ledger.resetForNextTxGroup(false)
+		return err
+	}
+	if !bytes.Equal(stateHash, block.StateHash) {
+		ledger.resetForNextTxGroup(false)
+		return fmt.Errorf("Replaying block %d results in state hash %x, the block has %x", blockNumber, stateHash, block.StateHash)
+	}
+
+	writeBatch := db.NewWriteBatch()
+	defer writeBatch.Destroy()
+	ledger.state.AddChangesForPersistence(blockNumber, writeBatch)
+	if ledger.history {
+		err = addHistoryForPersistence(ledger.openchainDB, blockNumber, block.Transactions, ledger.state.GetTxStateDeltas(), writeBatch)
+		if err != nil {
+			ledger.resetForNextTxGroup(false)
+			return err
+		}
+	}
+	// the async indexer indexes the blocks again on its own when the ledger
+	// is opened
+	if ledger.blockchain.indexer.isSynchronous() {
+		blockHash, err := block.GetHash()
+		if err != nil {
+			ledger.resetForNextTxGroup(false)
+			return err
+		}
+		err = ledger.blockchain.indexer.createIndexesSync(block, blockNumber, blockHash, writeBatch)
+		if err != nil {
+			ledger.resetForNextTxGroup(false)
+			return err
+		}
+	}
+	cf := ledger.openchainDB.BlockchainCF
+	if blockNumber+1 < ledger.GetBlockchainSize() {
+		writeBatch.PutCF(cf, stateRebuildKey, encodeUint64(blockNumber+1))
+	} else {
+		writeBatch.DeleteCF(cf, stateRebuildKey)
+	}
+	if err = ledger.openchainDB.Write(writeBatch); err != nil {
+		ledger.resetForNextTxGroup(false)
+		return err
+	}
+	ledger.resetForNextTxGroup(true)
+	return nil
+}
@@ -0,0 +1,168 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ledger
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/query"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/state"
+)
+
+// TxState is the state a transaction executes against: the ledger, or the simulator of the
+// transaction when it is simulated concurrently with the other transactions of its batch
+type TxState interface {
+	GetState(chaincodeID string, key string, committed bool) ([]byte, error)
+	GetStateMultipleKeys(chaincodeID string, keys []string, committed bool) ([][]byte, error)
+	GetStateRangeScanIterator(chaincodeID string, startKey string, endKey string, committed bool) (statemgmt.RangeScanIterator, error)
+	GetStateQueryIterator(chaincodeID string, queryString string, committed bool) (statemgmt.RangeScanIterator, error)
+	GetStateByPartialCompositeKey(chaincodeID string, objectType string, attributes []string, committed bool) (statemgmt.RangeScanIterator, error)
+	GetStateByIndex(chaincodeID string, index string, startValue string, endValue string, committed bool) (statemgmt.RangeScanIterator, error)
+	SetState(chaincodeID string, key string, value []byte) error
+	SetStateMultipleKeys(chaincodeID string, kvs map[string][]byte) error
+	DeleteState(chaincodeID string, key string) error
+}
+
+// TxSimulator is the TxState of a simulated transaction. It reads the state of the current batch
+// along with the changes of the transaction, whatever committed, and records the keys read and
+// the changes made, the read-write set which EndTxSimulation validates and applies
+type TxSimulator struct {
+	simulation *state.TxSimulation
+}
+
+// GetState - see Ledger.GetState
+func (simulator *TxSimulator) GetState(chaincodeID string, key string, committed bool) ([]byte, error) {
+	return simulator.simulation.Get(chaincodeID, key)
+}
+
+// GetStateMultipleKeys - see Ledger.GetStateMultipleKeys
+func (simulator *TxSimulator) GetStateMultipleKeys(chaincodeID string, keys []string, committed bool) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		value, err := simulator.simulation.Get(chaincodeID, key)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// GetStateRangeScanIterator - see Ledger.GetStateRangeScanIterator
+func (simulator *TxSimulator) GetStateRangeScanIterator(chaincodeID string, startKey string, endKey string, committed bool) (statemgmt.RangeScanIterator, error) {
+	return simulator.simulation.GetRangeScanIterator(chaincodeID, startKey, endKey)
+}
+
+// GetStateQueryIterator - see Ledger.GetStateQueryIterator
+func (simulator *TxSimulator) GetStateQueryIterator(chaincodeID string, queryString string, committed bool) (statemgmt.RangeScanIterator, error) {
+	q, err := query.Parse(queryString)
+	if err != nil {
+		return nil, newLedgerError(ErrorTypeInvalidArgument, err.Error())
+	}
+	return simulator.simulation.GetQueryIterator(chaincodeID, q)
+}
+
+// GetStateByIndex - see Ledger.GetStateByIndex
+func (simulator *TxSimulator) GetStateByIndex(chaincodeID string, index string, startValue string, endValue string, committed bool) (statemgmt.RangeScanIterator, error) {
+	itr, err := simulator.simulation.GetIndexIterator(chaincodeID, index, startValue, endValue)
+	if err == state.ErrIndexNotFound {
+		return nil, ErrIndexNotFound
+	}
+	return itr, err
+}
+
+// SetState - see Ledger.SetState
+func (simulator *TxSimulator) SetState(chaincodeID string, key string, value []byte) error {
+	if key == "" || value == nil {
+		return newLedgerError(ErrorTypeInvalidArgument,
+			fmt.Sprintf("An empty string key or a nil value is not supported. Method invoked with key='%s', value='%#v'", key, value))
+	}
+	simulator.simulation.Set(chaincodeID, key, value)
+	return nil
+}
+
+// SetStateMultipleKeys - see Ledger.SetStateMultipleKeys
+func (simulator *TxSimulator) SetStateMultipleKeys(chaincodeID string, kvs map[string][]byte) error {
+	if err := validateKeyValues(kvs); err != nil {
+		return err
+	}
+	for key, value := range kvs {
+		simulator.simulation.Set(chaincodeID, key, value)
+	}
+	return nil
+}
+
+// DeleteState - see Ledger.DeleteState
+func (simulator *TxSimulator) DeleteState(chaincodeID string, key string) error {
+	simulator.simulation.Delete(chaincodeID, key)
+	return nil
+}
+
+// BeginTxSimulation starts simulating transaction txUUID against the state of the current batch.
+// Transactions of a batch may be simulated concurrently, but not while transactions are applied
+// to the batch. Until EndTxSimulation, GetTxState returns the simulator of the transaction
+func (ledger *Ledger) BeginTxSimulation(txUUID string) error {
+	ledger.simulationsLock.Lock()
+	defer ledger.simulationsLock.Unlock()
+	if _, ok := ledger.simulations[txUUID]; ok {
+		return fmt.Errorf("Transaction [%s] is already simulated", txUUID)
+	}
+	ledger.simulations[txUUID] = &TxSimulator{ledger.state.NewTxSimulation(txUUID)}
+	return nil
+}
+
+// IsTxSimulated tells whether transaction txUUID is being simulated
+func (ledger *Ledger) IsTxSimulated(txUUID string) bool {
+	ledger.simulationsLock.RLock()
+	defer ledger.simulationsLock.RUnlock()
+	_, ok := ledger.simulations[txUUID]
+	return ok
+}
+
+// GetTxState returns the state transaction txUUID executes against, its simulator if it is
+// simulated, the ledger otherwise
+func (ledger *Ledger) GetTxState(txUUID string) TxState {
+	ledger.simulationsLock.RLock()
+	defer ledger.simulationsLock.RUnlock()
+	if simulator, ok := ledger.simulations[txUUID]; ok {
+		return simulator
+	}
+	return ledger
+}
+
+// EndTxSimulation stops simulating transaction txUUID. If the simulation was successful, the keys it
+// read are validated against the transactions applied to the batch since it began, and its changes
+// are applied to the batch as the next transaction. ErrReadConflict is returned if a key read changed.
+// The transactions of a batch must end in the order of the batch for their validation to be deterministic
+func (ledger *Ledger) EndTxSimulation(txUUID string, txSuccessful bool) error {
+	ledger.simulationsLock.Lock()
+	simulator, ok := ledger.simulations[txUUID]
+	delete(ledger.simulations, txUUID)
+	ledger.simulationsLock.Unlock()
+	if !ok {
+		return fmt.Errorf("Transaction [%s] is not simulated", txUUID)
+	}
+	if !txSuccessful {
+		return nil
+	}
+	err := ledger.state.ApplyTxSimulation(simulator.simulation)
+	if err == state.ErrReadConflict {
+		return ErrReadConflict
+	}
+	return err
+}
@@ -0,0 +1,332 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ledger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/protos"
+)
+
+// A snapshot of the ledger is a LedgerSnapshot followed by
+// LedgerSnapshotChunks, first the headers of the blocks and then the state,
+// each record prefixed with its length as a varint
+
+// snapshotChunkSize is the number of block headers, or of state keys, in a
+// chunk of a snapshot
+const snapshotChunkSize = 1000
+
+// maxSnapshotRecordSize bounds the records read from a snapshot
+const maxSnapshotRecordSize = 1 << 28
+
+// snapshotTxBatchID identifies the import of a snapshot as a batch of the ledger
+const snapshotTxBatchID = "snapshot"
+
+// ExportSnapshot writes to w a consistent snapshot of the ledger at height,
+// 0 being the current height: the genesis block and the block height-1 in
+// full, the headers of the blocks up to it, and the world state at height.
+// The state is rolled back with the state deltas kept to export it below
+// the current height. Returns the BlockchainInfo of the snapshot.
+func (ledger *Ledger) ExportSnapshot(height uint64, w io.Writer) (*protos.BlockchainInfo, error) {
+	dbSnapshot := ledger.openchainDB.GetSnapshot()
+	size, err := fetchBlockchainSizeFromSnapshot(ledger.openchainDB, dbSnapshot)
+	if err != nil {
+		dbSnapshot.Release()
+		return nil, err
+	}
+	if height == 0 {
+		height = size
+	}
+	if height == 0 || height > size {
+		dbSnapshot.Release()
+		return nil, ErrOutOfBounds
+	}
+	stateSnapshot, err := ledger.state.GetSnapshot(size-1, dbSnapshot)
+	if err != nil {
+		dbSnapshot.Release()
+		return nil, err
+	}
+	defer stateSnapshot.Release()
+
+	rolledBack, err := ledger.rolledBackState(size, height, dbSnapshot)
+	if err != nil {
+		return nil, err
+	}
+	genesisBlock, err := ledger.blockchain.getBlockFromSnapshot(dbSnapshot, 0)
+	if err != nil {
+		return nil, err
+	}
+	headBlock, err := ledger.blockchain.getBlockFromSnapshot(dbSnapshot, height-1)
+	if err != nil {
+		return nil, err
+	}
+	if genesisBlock == nil || headBlock == nil {
+		return nil, ErrPruned
+	}
+
+	bw := bufio.NewWriter(w)
+	err = writeSnapshotRecord(bw, &protos.LedgerSnapshot{Height: height, GenesisBlock: genesisBlock, HeadBlock: headBlock})
+	if err != nil {
+		return nil, err
+	}
+
+	// the headers of the blocks which were not pruned
+	var headers []*protos.BlockHeader
+	for blockNumber := uint64(0); blockNumber < height; blockNumber++ {
+		block, err := ledger.blockchain.getBlockFromSnapshot(dbSnapshot, blockNumber)
+		if err != nil {
+			return nil, err
+		}
+		if block == nil {
+			continue
+		}
+		blockHash, err := block.GetHash()
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, &protos.BlockHeader{Number: blockNumber, Hash: blockHash,
+			PreviousBlockHash: block.PreviousBlockHash, StateHash: block.StateHash})
+		if len(headers) == snapshotChunkSize || blockNumber == height-1 {
+			if err = writeSnapshotRecord(bw, &protos.LedgerSnapshotChunk{Headers: headers}); err != nil {
+				return nil, err
+			}
+			headers = nil
+		}
+	}
+
+	// the state, in chunks of snapshotChunkSize keys
+	delta := statemgmt.NewStateDelta()
+	keys := 0
+	addKeyValue := func(compositeKey, value []byte) error {
+		chaincodeID, key := statemgmt.DecodeCompositeKey(compositeKey)
+		delta.Set(chaincodeID, key, value, nil)
+		if keys++; keys < snapshotChunkSize {
+			return nil
+		}
+		err := writeSnapshotRecord(bw, &protos.LedgerSnapshotChunk{Delta: delta.Marshal()})
+		delta = statemgmt.NewStateDelta()
+		keys = 0
+		return err
+	}
+	for stateSnapshot.Next() {
+		k, v := stateSnapshot.GetRawKeyValue()
+		if value, ok := rolledBack[string(k)]; ok {
+			delete(rolledBack, string(k))
+			if value == nil {
+				continue
+			}
+			v = value
+		}
+		if err = addKeyValue(k, v); err != nil {
+			return nil, err
+		}
+	}
+	// the keys deleted since height
+	var deleted []string
+	for k, v := range rolledBack {
+		if v != nil {
+			deleted = append(deleted, k)
+		}
+	}
+	sort.Strings(deleted)
+	for _, k := range deleted {
+		if err = addKeyValue([]byte(k), rolledBack[k]); err != nil {
+			return nil, err
+		}
+	}
+	if keys > 0 {
+		if err = writeSnapshotRecord(bw, &protos.LedgerSnapshotChunk{Delta: delta.Marshal()}); err != nil {
+			return nil, err
+		}
+	}
+	if err = bw.Flush(); err != nil {
+		return nil, err
+	}
+
+	info := ledger.blockchain.getBlockchainInfoForBlock(height, headBlock)
+	ledgerLogger.Info("Exported a snapshot of the ledger at height %d, block hash %x", height, info.CurrentBlockHash)
+	return info, nil
+}
+
+// rolledBackState returns the values at height of the state keys changed
+// since, by composite key, nil for the keys which did not exist yet
+func (ledger *Ledger) rolledBackState(size, height uint64, dbSnapshot db.Snapshot) (map[string][]byte, error) {
+	values := make(map[string][]byte)
+	for blockNumber := size - 1; blockNumber >= height; blockNumber-- {
+		delta, err := ledger.state.FetchStateDeltaFromSnapshot(blockNumber, dbSnapshot)
+		if err != nil {
+			return nil, err
+		}
+		if delta == nil {
+			return nil, fmt.Errorf("The state delta of block %d is no longer kept, cannot export the state at height %d", blockNumber, height)
+		}
+		// the oldest delta changing a key holds its value at height
+		for _, chaincodeID := range delta.GetUpdatedChaincodeIds(false) {
+			for key, updatedValue := range delta.GetUpdates(chaincodeID) {
+				values[string(statemgmt.ConstructCompositeKey(chaincodeID, key))] = updatedValue.GetPreviousValue()
+			}
+		}
+	}
+	return values, nil
+}
+
+// ImportSnapshot bootstraps the empty ledger from a snapshot written by
+// ExportSnapshot and read from r. The headers of the blocks must link the
+// genesis block to the head block of the snapshot, and the state imported
+// must have the hash recorded in the head block. The blocks between them
+// are not imported, GetBlockByNumber returns ErrPruned for them. Returns the
+// BlockchainInfo of the ledger imported.
+func (ledger *Ledger) ImportSnapshot(r io.Reader) (*protos.BlockchainInfo, error) {
+	if size := ledger.GetBlockchainSize(); size > 0 {
+		return nil, fmt.Errorf("Cannot import a snapshot into a ledger of %d blocks", size)
+	}
+
+	br := bufio.NewReader(r)
+	snapshot := &protos.LedgerSnapshot{}
+	if err := readSnapshotRecord(br, snapshot); err != nil {
+		return nil, fmt.Errorf("Error reading the snapshot: %s", err)
+	}
+	if snapshot.Height == 0 || snapshot.GenesisBlock == nil || snapshot.HeadBlock == nil {
+		return nil, fmt.Errorf("Invalid snapshot %s", snapshot)
+	}
+	genesisHash, err := snapshot.GenesisBlock.GetHash()
+	if err != nil {
+		return nil, err
+	}
+	headHash, err := snapshot.HeadBlock.GetHash()
+	if err != nil {
+		return nil, err
+	}
+
+	err = ledger.importSnapshotChunks(br, snapshot.Height, genesisHash, headHash)
+	if err == nil {
+		var stateHash []byte
+		if stateHash, err = ledger.state.GetHash(); err == nil && !bytes.Equal(stateHash, snapshot.HeadBlock.StateHash) {
+			err = fmt.Errorf("The state imported has hash %x, block %d expects %x", stateHash, snapshot.Height-1, snapshot.HeadBlock.StateHash)
+		}
+	}
+	if err != nil {
+		if deleteErr := ledger.DeleteALLStateKeysAndValues(); deleteErr != nil {
+			ledgerLogger.Error("Error deleting the state partially imported: %s", deleteErr)
+		}
+		return nil, err
+	}
+
+	if err = ledger.blockchain.persistRawBlock(snapshot.GenesisBlock, 0); err != nil {
+		return nil, err
+	}
+	if snapshot.Height > 1 {
+		if err = ledger.blockchain.persistRawBlock(snapshot.HeadBlock, snapshot.Height-1); err != nil {
+			return nil, err
+		}
+	}
+	if snapshot.Height > 2 {
+		if err = ledger.blockchain.setPrunedBelow(snapshot.Height - 1); err != nil {
+			return nil, err
+		}
+	}
+
+	info := ledger.blockchain.getBlockchainInfoForBlock(snapshot.Height, snapshot.HeadBlock)
+	ledgerLogger.Info("Imported a snapshot of the ledger at height %d, block hash %x", info.Height, info.CurrentBlockHash)
+	return info, nil
+}
+
+// importSnapshotChunks checks the headers of the blocks and commits the
+// state read from the snapshot
+func (ledger *Ledger) importSnapshotChunks(r *bufio.Reader, height uint64, genesisHash, headHash []byte) error {
+	var last *protos.BlockHeader
+	for {
+		chunk := &protos.LedgerSnapshotChunk{}
+		err := readSnapshotRecord(r, chunk)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading the snapshot: %s", err)
+		}
+
+		for _, header := range chunk.Headers {
+			switch {
+			case last == nil:
+				if header.Number != 0 || !bytes.Equal(header.Hash, genesisHash) {
+					return fmt.Errorf("The headers of the snapshot do not start with the genesis block")
+				}
+			case header.Number <= last.Number || header.Number >= height:
+				return fmt.Errorf("Unexpected header of block %d after block %d", header.Number, last.Number)
+			case header.Number == last.Number+1 && !bytes.Equal(header.PreviousBlockHash, last.Hash):
+				return fmt.Errorf("The header of block %d does not link to block %d", header.Number, last.Number)
+			}
+			last = header
+		}
+
+		if chunk.Delta != nil {
+			delta := statemgmt.NewStateDelta()
+			if err = delta.Unmarshal(chunk.Delta); err != nil {
+				return fmt.Errorf("Error reading the state of the snapshot: %s", err)
+			}
+			if err = ledger.ApplyStateDelta(snapshotTxBatchID, delta); err != nil {
+				return err
+			}
+			if err = ledger.CommitStateDelta(snapshotTxBatchID); err != nil {
+				return err
+			}
+		}
+	}
+	if last == nil || last.Number != height-1 || !bytes.Equal(last.Hash, headHash) {
+		return fmt.Errorf("The headers of the snapshot do not end with block %d", height-1)
+	}
+	return nil
+}
+
+func writeSnapshotRecord(w io.Writer, msg proto.Message) error {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(proto.EncodeVarint(uint64(len(data)))); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// readSnapshotRecord reads the next record into msg, io.EOF after the last
+func readSnapshotRecord(r *bufio.Reader, msg proto.Message) error {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	if size > maxSnapshotRecordSize {
+		return fmt.Errorf("Record of %d bytes exceeds the limit of %d", size, maxSnapshotRecordSize)
+	}
+	data := make([]byte, size)
+	if _, err = io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return proto.Unmarshal(data, msg)
+}
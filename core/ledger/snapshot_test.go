@@ -0,0 +1,112 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ledger
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/ledger/testutil"
+	"github.com/hyperledger/fabric/protos"
+)
+
+func TestLedgerSnapshotExportImport(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	ledger := ledgerTestWrapper.ledger
+
+	// every block sets a key, updates key0 and deletes the key of the block
+	// before the last
+	for i := 0; i < 10; i++ {
+		ledger.BeginTxBatch(i)
+		ledger.TxBegin("txUuid" + strconv.Itoa(i))
+		ledger.SetState("chaincode1", "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i)))
+		ledger.SetState("chaincode2", "key0", []byte("value"+strconv.Itoa(i)))
+		if i > 1 {
+			ledger.DeleteState("chaincode1", "key"+strconv.Itoa(i-2))
+		}
+		ledger.TxFinished("txUuid"+strconv.Itoa(i), true)
+		transaction, _ := buildTestTx(t)
+		ledger.CommitTxBatch(i, []*protos.Transaction{transaction}, nil, []byte("proof"))
+	}
+
+	var current, past bytes.Buffer
+	info, err := ledger.ExportSnapshot(0, &current)
+	testutil.AssertNoError(t, err, "Error exporting a snapshot")
+	testutil.AssertEquals(t, info.Height, uint64(10))
+	pastInfo, err := ledger.ExportSnapshot(6, &past)
+	testutil.AssertNoError(t, err, "Error exporting a snapshot")
+	testutil.AssertEquals(t, pastInfo.Height, uint64(6))
+	pastHash, _ := ledgerTestWrapper.GetBlockByNumber(5).GetHash()
+	testutil.AssertEquals(t, pastInfo.CurrentBlockHash, pastHash)
+
+	_, err = ledger.ExportSnapshot(11, &bytes.Buffer{})
+	testutil.AssertEquals(t, err, ErrOutOfBounds)
+
+	// a snapshot is only imported into an empty ledger
+	_, err = ledger.ImportSnapshot(bytes.NewReader(current.Bytes()))
+	testutil.AssertError(t, err, "Expected error importing into a ledger with blocks")
+
+	ledgerTestWrapper = createFreshDBAndTestLedgerWrapper(t)
+	ledger = ledgerTestWrapper.ledger
+	importedInfo, err := ledger.ImportSnapshot(&current)
+	testutil.AssertNoError(t, err, "Error importing a snapshot")
+	testutil.AssertEquals(t, importedInfo, info)
+	testutil.AssertEquals(t, ledger.GetBlockchainSize(), uint64(10))
+	testutil.AssertNotNil(t, ledgerTestWrapper.GetBlockByNumber(0))
+	testutil.AssertNotNil(t, ledgerTestWrapper.GetBlockByNumber(9))
+	_, err = ledger.GetBlockByNumber(5)
+	testutil.AssertEquals(t, err, ErrPruned)
+	testutil.AssertNil(t, ledgerTestWrapper.GetState("chaincode1", "key7", true))
+	testutil.AssertEquals(t, ledgerTestWrapper.GetState("chaincode1", "key9", true), []byte("value9"))
+	testutil.AssertEquals(t, ledgerTestWrapper.GetState("chaincode2", "key0", true), []byte("value9"))
+
+	ledgerTestWrapper = createFreshDBAndTestLedgerWrapper(t)
+	ledger = ledgerTestWrapper.ledger
+	importedInfo, err = ledger.ImportSnapshot(&past)
+	testutil.AssertNoError(t, err, "Error importing a snapshot")
+	testutil.AssertEquals(t, importedInfo, pastInfo)
+	testutil.AssertEquals(t, ledgerTestWrapper.GetState("chaincode1", "key4", true), []byte("value4"))
+	testutil.AssertEquals(t, ledgerTestWrapper.GetState("chaincode1", "key5", true), []byte("value5"))
+	testutil.AssertNil(t, ledgerTestWrapper.GetState("chaincode1", "key3", true))
+	testutil.AssertNil(t, ledgerTestWrapper.GetState("chaincode1", "key6", true))
+	testutil.AssertEquals(t, ledgerTestWrapper.GetState("chaincode2", "key0", true), []byte("value5"))
+}
+
+func TestLedgerSnapshotTampered(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	ledger := ledgerTestWrapper.ledger
+	for i := 0; i < 3; i++ {
+		ledger.BeginTxBatch(i)
+		ledger.TxBegin("txUuid" + strconv.Itoa(i))
+		ledger.SetState("chaincode1", "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i)))
+		ledger.TxFinished("txUuid"+strconv.Itoa(i), true)
+		transaction, _ := buildTestTx(t)
+		ledger.CommitTxBatch(i, []*protos.Transaction{transaction}, nil, []byte("proof"))
+	}
+	var snapshot bytes.Buffer
+	_, err := ledger.ExportSnapshot(0, &snapshot)
+	testutil.AssertNoError(t, err, "Error exporting a snapshot")
+
+	// change a value of the state
+	tampered := bytes.Replace(snapshot.Bytes(), []byte("value1"), []byte("value7"), 1)
+	ledgerTestWrapper = createFreshDBAndTestLedgerWrapper(t)
+	_, err = ledgerTestWrapper.ledger.ImportSnapshot(bytes.NewReader(tampered))
+	testutil.AssertError(t, err, "Expected error importing a snapshot of another state")
+	testutil.AssertEquals(t, ledgerTestWrapper.ledger.GetBlockchainSize(), uint64(0))
+	testutil.AssertNil(t, ledgerTestWrapper.GetState("chaincode1", "key0", true))
+}
@@ -0,0 +1,78 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ledger
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/db"
+)
+
+// GetStateAt returns the committed value of key of chaincodeID as of the block height, i.e. in the
+// state recorded in block height-1, nil if the key had no value. The state deltas of the blocks since
+// height must still be kept ('ledger.state.deltaHistorySize'): the value is the previous value of the
+// key in the first of those deltas changing it, or else the current value
+func (ledger *Ledger) GetStateAt(height uint64, chaincodeID string, key string) ([]byte, error) {
+	if err := ledger.waitPersisted(); err != nil {
+		return nil, err
+	}
+	for {
+		dbSnapshot := ledger.openchainDB.GetSnapshot()
+		value, found, size, err := ledger.getStateAtFromDeltas(dbSnapshot, height, chaincodeID, key)
+		dbSnapshot.Release()
+		if err != nil || found {
+			return value, err
+		}
+		// no block since height changed the key, it still holds its value unless a block was
+		// committed since the snapshot
+		value, err = ledger.state.Get(chaincodeID, key, true)
+		if err != nil {
+			return nil, err
+		}
+		currentSize, err := fetchBlockchainSizeFromDB(ledger.openchainDB)
+		if err != nil || currentSize == size {
+			return value, err
+		}
+	}
+}
+
+// getStateAtFromDeltas looks for the value of key as of height in the state deltas of the DB
+// snapshot. Returns whether a delta changed the key since height, and the blockchain size of the
+// snapshot
+func (ledger *Ledger) getStateAtFromDeltas(dbSnapshot db.Snapshot, height uint64, chaincodeID string, key string) ([]byte, bool, uint64, error) {
+	size, err := fetchBlockchainSizeFromSnapshot(ledger.openchainDB, dbSnapshot)
+	if err != nil {
+		return nil, false, 0, err
+	}
+	if height > size {
+		return nil, false, 0, ErrOutOfBounds
+	}
+	for blockNumber := height; blockNumber < size; blockNumber++ {
+		delta, err := ledger.state.FetchStateDeltaFromSnapshot(blockNumber, dbSnapshot)
+		if err != nil {
+			return nil, false, 0, err
+		}
+		if delta == nil {
+			return nil, false, 0, newLedgerError(ErrorTypePruned,
+				fmt.Sprintf("The state delta of block %d is no longer kept, the state at height %d is not available", blockNumber, height))
+		}
+		if updatedValue := delta.Get(chaincodeID, key); updatedValue != nil {
+			return updatedValue.GetPreviousValue(), true, size, nil
+		}
+	}
+	return nil, false, size, nil
+}
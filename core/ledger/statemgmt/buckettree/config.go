@@ -102,6 +102,12 @@ func (config *config) computeBucketHash(data []byte) uint32 {
 	return config.hashFunc(data)
 }
 
+// computeBucketNumber returns the bucket at the lowest level of a composite key
+func (config *config) computeBucketNumber(compositeKey []byte) int {
+	// Adding one because - we start bucket-numbers 1 onwards
+	return int(config.computeBucketHash(compositeKey))%config.getNumBucketsAtLowestLevel() + 1
+}
+
 func (config *config) getLowestLevel() int {
 	return config.lowestLevel
 }
@@ -31,9 +31,7 @@ type dataKey struct {
 func newDataKey(chaincodeID string, key string) *dataKey {
 	logger.Debug("Enter - newDataKey. chaincodeID=[%s], key=[%s]", chaincodeID, key)
 	compositeKey := statemgmt.ConstructCompositeKey(chaincodeID, key)
-	bucketHash := conf.computeBucketHash(compositeKey)
-	// Adding one because - we start bucket-numbers 1 onwards
-	bucketNumber := int(bucketHash)%conf.getNumBucketsAtLowestLevel() + 1
+	bucketNumber := conf.computeBucketNumber(compositeKey)
 	dataKey := &dataKey{newBucketKeyAtLowestLevel(bucketNumber), compositeKey}
 	logger.Debug("Exit - newDataKey=[%s]", dataKey)
 	return dataKey
@@ -21,8 +21,7 @@ import (
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
 )
 
-func fetchDataNodeFromDB(dataKey *dataKey) (*dataNode, error) {
-	openchainDB := db.GetDBHandle()
+func fetchDataNodeFromDB(openchainDB *db.OpenchainDB, dataKey *dataKey) (*dataNode, error) {
 	nodeBytes, err := openchainDB.GetFromStateCF(dataKey.getEncodedBytes())
 	if err != nil {
 		return nil, err
@@ -39,8 +38,7 @@ func fetchDataNodeFromDB(dataKey *dataKey) (*dataNode, error) {
 	return unmarshalDataNode(dataKey, nodeBytes), nil
 }
 
-func fetchBucketNodeFromDB(bucketKey *bucketKey) (*bucketNode, error) {
-	openchainDB := db.GetDBHandle()
+func fetchBucketNodeFromDB(openchainDB *db.OpenchainDB, bucketKey *bucketKey) (*bucketNode, error) {
 	nodeBytes, err := openchainDB.GetFromStateCF(bucketKey.getEncodedBytes())
 	if err != nil {
 		return nil, err
@@ -51,13 +49,34 @@ func fetchBucketNodeFromDB(bucketKey *bucketKey) (*bucketNode, error) {
 	return unmarshalBucketNode(bucketKey, nodeBytes), nil
 }
 
+func fetchBucketNodeFromSnapshot(openchainDB *db.OpenchainDB, snapshot db.Snapshot, bucketKey *bucketKey) (*bucketNode, error) {
+	nodeBytes, err := openchainDB.GetFromStateCFSnapshot(snapshot, bucketKey.getEncodedBytes())
+	if err != nil {
+		return nil, err
+	}
+	if nodeBytes == nil {
+		return nil, nil
+	}
+	return unmarshalBucketNode(bucketKey, nodeBytes), nil
+}
+
 type rawKey []byte
 
-func fetchDataNodesFromDBFor(bucketKey *bucketKey) (dataNodes, error) {
+func fetchDataNodesFromDBFor(openchainDB *db.OpenchainDB, bucketKey *bucketKey) (dataNodes, error) {
 	logger.Debug("Fetching from DB data nodes for bucket [%s]", bucketKey)
-	openchainDB := db.GetDBHandle()
 	itr := openchainDB.GetStateCFIterator()
 	defer itr.Close()
+	return fetchDataNodesFromIteratorFor(itr, bucketKey)
+}
+
+func fetchDataNodesFromSnapshotFor(openchainDB *db.OpenchainDB, snapshot db.Snapshot, bucketKey *bucketKey) (dataNodes, error) {
+	logger.Debug("Fetching from DB snapshot data nodes for bucket [%s]", bucketKey)
+	itr := openchainDB.GetStateCFSnapshotIterator(snapshot)
+	defer itr.Close()
+	return fetchDataNodesFromIteratorFor(itr, bucketKey)
+}
+
+func fetchDataNodesFromIteratorFor(itr db.Iterator, bucketKey *bucketKey) (dataNodes, error) {
 	minimumDataKeyBytes := minimumPossibleDataKeyBytesFor(bucketKey)
 
 	var dataNodes dataNodes
@@ -68,8 +87,8 @@ func fetchDataNodesFromDBFor(bucketKey *bucketKey) (dataNodes, error) {
 
 		// making a copy of key-value bytes because, underlying key bytes are reused by itr.
 		// no need to free slices as iterator frees memory when closed.
-		keyBytes := statemgmt.Copy(itr.Key().Data())
-		valueBytes := statemgmt.Copy(itr.Value().Data())
+		keyBytes := statemgmt.Copy(itr.Key())
+		valueBytes := statemgmt.Copy(itr.Value())
 
 		dataKey := newDataKeyFromEncodedBytes(keyBytes)
 		logger.Debug("Retrieved data key [%s] from DB for bucket [%s]", dataKey, bucketKey)
@@ -0,0 +1,149 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buckettree
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	openchainUtil "github.com/hyperledger/fabric/core/util"
+	"github.com/hyperledger/fabric/protos"
+)
+
+// GetProof - method implementation for interface 'statemgmt.ProvableState'
+func (stateImpl *StateImpl) GetProof(snapshot db.Snapshot, chaincodeID string, key string) (*protos.StateProof, error) {
+	dataKey := newDataKey(chaincodeID, key)
+	dataNodes, err := fetchDataNodesFromSnapshotFor(stateImpl.openchainDB, snapshot, dataKey.getBucketKey())
+	if err != nil {
+		return nil, err
+	}
+	proof := &protos.StateProof{ChaincodeID: chaincodeID, Key: key}
+	bucketTreeProof := &protos.BucketTreeProof{
+		NumBuckets:             uint32(conf.getNumBucketsAtLowestLevel()),
+		MaxGroupingAtEachLevel: uint32(conf.getMaxGroupingAtEachLevel()),
+	}
+	for _, dataNode := range dataNodes {
+		if bytes.Equal(dataNode.getCompositeKey(), dataKey.compositeKey) {
+			proof.Exists = true
+			proof.Value = dataNode.getValue()
+		}
+		nodeChaincodeID, nodeKey := dataNode.getKeyElements()
+		bucketTreeProof.Bucket = append(bucketTreeProof.Bucket,
+			&protos.StateProofKeyValue{ChaincodeID: nodeChaincodeID, Key: nodeKey, Value: dataNode.getValue()})
+	}
+
+	childKey := dataKey.getBucketKey()
+	for childKey.level > 0 {
+		parentKey := childKey.getParentKey()
+		bucketNode, err := fetchBucketNodeFromSnapshot(stateImpl.openchainDB, snapshot, parentKey)
+		if err != nil {
+			return nil, err
+		}
+		proofNode := &protos.StateProofNode{}
+		if bucketNode != nil {
+			pathIndex := parentKey.getChildIndex(childKey)
+			for i, childCryptoHash := range bucketNode.childrenCryptoHash {
+				if i != pathIndex && childCryptoHash != nil {
+					proofNode.Children = append(proofNode.Children,
+						&protos.StateProofChild{Index: uint32(i), CryptoHash: childCryptoHash})
+				}
+			}
+		}
+		bucketTreeProof.Nodes = append(bucketTreeProof.Nodes, proofNode)
+		childKey = parentKey
+	}
+	proof.BucketTree = bucketTreeProof
+	return proof, nil
+}
+
+// VerifyProof computes the crypto-hash of the root of the bucket tree from the proof that key of chaincodeID
+// holds value, or no value if exists is false. The proof holds if the crypto-hash is the state hash of a block.
+// VerifyProof needs neither the DB nor the configuration of the peer, the bucket tree is described by the proof
+func VerifyProof(chaincodeID string, key string, exists bool, value []byte, proof *protos.BucketTreeProof) ([]byte, error) {
+	if proof.NumBuckets < 2 || proof.MaxGroupingAtEachLevel < 2 {
+		return nil, fmt.Errorf("Invalid bucket tree of [%d] buckets grouped by [%d]", proof.NumBuckets, proof.MaxGroupingAtEachLevel)
+	}
+	proofConf := newConfig(int(proof.NumBuckets), int(proof.MaxGroupingAtEachLevel), fnvHash)
+	if len(proof.Nodes) != proofConf.getLowestLevel() {
+		return nil, fmt.Errorf("Expected [%d] buckets on the path of the key, found [%d]", proofConf.getLowestLevel(), len(proof.Nodes))
+	}
+
+	compositeKey := statemgmt.ConstructCompositeKey(chaincodeID, key)
+	bucketNumber := proofConf.computeBucketNumber(compositeKey)
+	pathKey := &bucketKey{proofConf.getLowestLevel(), bucketNumber}
+	bucketHashCalculator := newBucketHashCalculator(pathKey)
+	found := false
+	var previousCompositeKey []byte
+	for i, keyValue := range proof.Bucket {
+		nodeCompositeKey := statemgmt.ConstructCompositeKey(keyValue.ChaincodeID, keyValue.Key)
+		if proofConf.computeBucketNumber(nodeCompositeKey) != bucketNumber {
+			return nil, fmt.Errorf("Key [%s] of chaincode [%s] does not belong to bucket [%d]", keyValue.Key, keyValue.ChaincodeID, bucketNumber)
+		}
+		if i > 0 && bytes.Compare(previousCompositeKey, nodeCompositeKey) >= 0 {
+			return nil, fmt.Errorf("Key [%s] of chaincode [%s] is out of order in the bucket", keyValue.Key, keyValue.ChaincodeID)
+		}
+		previousCompositeKey = nodeCompositeKey
+		if bytes.Equal(nodeCompositeKey, compositeKey) {
+			if !exists || !bytes.Equal(keyValue.Value, value) {
+				return nil, fmt.Errorf("The bucket holds another value for key [%s] of chaincode [%s]", key, chaincodeID)
+			}
+			found = true
+		}
+		bucketHashCalculator.addNextNode(newDataNode(&dataKey{pathKey, nodeCompositeKey}, keyValue.Value))
+	}
+	if exists && !found {
+		return nil, fmt.Errorf("The bucket does not hold key [%s] of chaincode [%s]", key, chaincodeID)
+	}
+
+	cryptoHash := bucketHashCalculator.computeCryptoHash()
+	for _, node := range proof.Nodes {
+		parentBucketNumber := proofConf.computeParentBucketNumber(pathKey.bucketNumber)
+		pathIndex := pathKey.bucketNumber - ((parentBucketNumber-1)*proofConf.getMaxGroupingAtEachLevel() + 1)
+		childrenCryptoHash := make([][]byte, proofConf.getMaxGroupingAtEachLevel())
+		for _, child := range node.Children {
+			if int(child.Index) >= len(childrenCryptoHash) || int(child.Index) == pathIndex || len(child.CryptoHash) == 0 {
+				return nil, fmt.Errorf("Invalid child [%d] of bucket [%d] at level [%d]", child.Index, parentBucketNumber, pathKey.level-1)
+			}
+			childrenCryptoHash[child.Index] = child.CryptoHash
+		}
+		childrenCryptoHash[pathIndex] = cryptoHash
+		cryptoHash = computeChildrenCryptoHash(childrenCryptoHash)
+		pathKey = &bucketKey{pathKey.level - 1, parentBucketNumber}
+	}
+	return cryptoHash, nil
+}
+
+// computeChildrenCryptoHash hashes the children of a bucket the way bucketNode.computeCryptoHash does
+func computeChildrenCryptoHash(childrenCryptoHash [][]byte) []byte {
+	cryptoHashContent := []byte{}
+	numChildren := 0
+	for _, childCryptoHash := range childrenCryptoHash {
+		if childCryptoHash != nil {
+			numChildren++
+			cryptoHashContent = append(cryptoHashContent, childCryptoHash...)
+		}
+	}
+	switch numChildren {
+	case 0:
+		return nil
+	case 1:
+		return cryptoHashContent
+	}
+	return openchainUtil.ComputeCryptoHash(cryptoHashContent)
+}
@@ -0,0 +1,80 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buckettree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/ledger/testutil"
+)
+
+func TestStateImpl_Proof(t *testing.T) {
+	// number of buckets at each level 26,9,3,1
+	testDBWrapper.CreateFreshDB(t)
+	stateImplTestWrapper := newStateImplTestWrapperWithCustomConfig(t, 26, 3)
+	stateDelta := statemgmt.NewStateDelta()
+	for i := 0; i < 40; i++ {
+		stateDelta.Set("chaincodeID1", fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("value%d", i)), nil)
+	}
+	stateDelta.Set("chaincodeID2", "key1", []byte("value1"), nil)
+	rootHash := stateImplTestWrapper.prepareWorkingSetAndComputeCryptoHash(stateDelta)
+	stateImplTestWrapper.persistChangesAndResetInMemoryChanges()
+
+	snapshot := db.GetDBHandle().GetSnapshot()
+	defer snapshot.Release()
+	verify := func(chaincodeID string, key string, exists bool, value []byte) {
+		proof, err := stateImplTestWrapper.stateImpl.GetProof(snapshot, chaincodeID, key)
+		testutil.AssertNoError(t, err, "Error while getting proof")
+		testutil.AssertEquals(t, proof.Exists, exists)
+		testutil.AssertEquals(t, proof.Value, value)
+		cryptoHash, err := VerifyProof(chaincodeID, key, proof.Exists, proof.Value, proof.BucketTree)
+		testutil.AssertNoError(t, err, "Error while verifying proof")
+		testutil.AssertEquals(t, cryptoHash, rootHash)
+
+		// another value, or none, is refused or does not hash up to the root
+		cryptoHash, err = VerifyProof(chaincodeID, key, true, []byte("tampered"), proof.BucketTree)
+		if err == nil && bytes.Equal(cryptoHash, rootHash) {
+			t.Fatalf("Proof of a tampered value for key [%s] hashes up to the root", key)
+		}
+		if exists {
+			_, err = VerifyProof(chaincodeID, key, false, nil, proof.BucketTree)
+			testutil.AssertError(t, err, fmt.Sprintf("Expected an error verifying no value for key [%s]", key))
+		}
+	}
+	verify("chaincodeID1", "key1", true, []byte("value1"))
+	verify("chaincodeID1", "key39", true, []byte("value39"))
+	verify("chaincodeID2", "key1", true, []byte("value1"))
+	verify("chaincodeID2", "key2", false, nil)
+
+	// a key-value left out of the bucket changes its hash
+	proof, _ := stateImplTestWrapper.stateImpl.GetProof(snapshot, "chaincodeID1", "key1")
+	testutil.AssertNotEquals(t, len(proof.BucketTree.Bucket), 1)
+	for i, keyValue := range proof.BucketTree.Bucket {
+		if keyValue.Key != "key1" || keyValue.ChaincodeID != "chaincodeID1" {
+			proof.BucketTree.Bucket = append(proof.BucketTree.Bucket[:i], proof.BucketTree.Bucket[i+1:]...)
+			cryptoHash, _ := VerifyProof("chaincodeID1", "key1", true, []byte("value1"), proof.BucketTree)
+			if bytes.Equal(cryptoHash, rootHash) {
+				t.Fatal("Proof missing a key-value of the bucket hashes up to the root")
+			}
+			break
+		}
+	}
+}
@@ -19,24 +19,27 @@ package buckettree
 import (
 	"github.com/hyperledger/fabric/core/db"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
-	"github.com/tecbot/gorocksdb"
 )
 
 // StateSnapshotIterator implements the interface 'statemgmt.StateSnapshotIterator'
 type StateSnapshotIterator struct {
-	dbItr *gorocksdb.Iterator
+	dbItr   db.Iterator
+	started bool
 }
 
-func newStateSnapshotIterator(snapshot *gorocksdb.Snapshot) (*StateSnapshotIterator, error) {
-	dbItr := db.GetDBHandle().GetStateCFSnapshotIterator(snapshot)
+func newStateSnapshotIterator(openchainDB *db.OpenchainDB, snapshot db.Snapshot) (*StateSnapshotIterator, error) {
+	dbItr := openchainDB.GetStateCFSnapshotIterator(snapshot)
 	dbItr.Seek([]byte{0x01})
-	dbItr.Prev()
-	return &StateSnapshotIterator{dbItr}, nil
+	return &StateSnapshotIterator{dbItr, false}, nil
 }
 
 // Next - see interface 'statemgmt.StateSnapshotIterator' for details
 func (snapshotItr *StateSnapshotIterator) Next() bool {
-	snapshotItr.dbItr.Next()
+	// the iterator is already at the first data node on the first call
+	if snapshotItr.started {
+		snapshotItr.dbItr.Next()
+	}
+	snapshotItr.started = true
 	return snapshotItr.dbItr.Valid()
 }
 
@@ -45,8 +48,8 @@ func (snapshotItr *StateSnapshotIterator) GetRawKeyValue() ([]byte, []byte) {
 
 	// making a copy of key-value bytes because, underlying key bytes are reused by itr.
 	// no need to free slices as iterator frees memory when closed.
-	keyBytes := statemgmt.Copy(snapshotItr.dbItr.Key().Data())
-	valueBytes := statemgmt.Copy(snapshotItr.dbItr.Value().Data())
+	keyBytes := statemgmt.Copy(snapshotItr.dbItr.Key())
+	valueBytes := statemgmt.Copy(snapshotItr.dbItr.Value())
 	dataNode := unmarshalDataNodeFromBytes(keyBytes, valueBytes)
 	return dataNode.getCompositeKey(), dataNode.getValue()
 }
@@ -0,0 +1,80 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package compositekey encodes the keys of the state made of an object type
+// and attributes, such as the type "car" and the attributes "red", "2016",
+// so that chaincodes can look the objects up by the leading attributes of
+// their keys. The object type and the attributes are each followed by the
+// separator U+0000, which is less than any other character: the composite
+// keys sort in lexical order of their attributes, and the keys starting with
+// the same attributes are the contiguous range returned by Range.
+package compositekey
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const separator = "\x00"
+
+// maxKeySuffix follows the keys of a range, it sorts after any valid UTF-8
+const maxKeySuffix = "\xff"
+
+// Create returns the composite key of objectType and attributes
+func Create(objectType string, attributes []string) (string, error) {
+	if err := validate(objectType); err != nil {
+		return "", err
+	}
+	key := objectType + separator
+	for _, attribute := range attributes {
+		if err := validate(attribute); err != nil {
+			return "", err
+		}
+		key += attribute + separator
+	}
+	return key, nil
+}
+
+// Split returns the object type and attributes of compositeKey
+func Split(compositeKey string) (string, []string, error) {
+	if !strings.HasSuffix(compositeKey, separator) {
+		return "", nil, fmt.Errorf("Not a composite key: %q", compositeKey)
+	}
+	components := strings.Split(compositeKey[:len(compositeKey)-len(separator)], separator)
+	return components[0], components[1:], nil
+}
+
+// Range returns the first and last keys, inclusive, of the range holding the composite keys
+// of objectType which start with attributes. These keys are those of the range starting with
+// startKey, the other keys of the range are not composite keys
+func Range(objectType string, attributes []string) (startKey string, endKey string, err error) {
+	startKey, err = Create(objectType, attributes)
+	if err != nil {
+		return "", "", err
+	}
+	return startKey, startKey + maxKeySuffix, nil
+}
+
+func validate(component string) error {
+	if !utf8.ValidString(component) {
+		return fmt.Errorf("Not a valid utf8 string: %q", component)
+	}
+	if strings.Contains(component, separator) {
+		return fmt.Errorf("U+0000 is not allowed in the object type or attributes of a composite key: %q", component)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compositekey
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/ledger/testutil"
+)
+
+func TestCreateAndSplit(t *testing.T) {
+	for _, attributes := range [][]string{{"red", "2016"}, {""}, {}} {
+		key, err := Create("car", attributes)
+		testutil.AssertNoError(t, err, "Error creating a composite key")
+		objectType, splitAttributes, err := Split(key)
+		testutil.AssertNoError(t, err, "Error splitting a composite key")
+		testutil.AssertEquals(t, objectType, "car")
+		testutil.AssertEquals(t, len(splitAttributes), len(attributes))
+		for i := range attributes {
+			testutil.AssertEquals(t, splitAttributes[i], attributes[i])
+		}
+	}
+
+	_, err := Create("car", []string{"red\x00"})
+	testutil.AssertError(t, err, "Expected an error for U+0000 in an attribute")
+	_, err = Create("car\xff", nil)
+	testutil.AssertError(t, err, "Expected an error for invalid utf8")
+	_, _, err = Split("car")
+	testutil.AssertError(t, err, "Expected an error splitting a simple key")
+}
+
+func TestOrder(t *testing.T) {
+	var keys []string
+	for _, attributes := range [][]string{{"b"}, {"ab", "x"}, {"a", "z"}, {"a"}, {"a", "b"}} {
+		key, _ := Create("car", attributes)
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// the keys sort by their attributes, the shorter prefix first
+	var sorted [][]string
+	for _, key := range keys {
+		_, attributes, _ := Split(key)
+		sorted = append(sorted, attributes)
+	}
+	testutil.AssertEquals(t, sorted, [][]string{{"a"}, {"a", "b"}, {"a", "z"}, {"ab", "x"}, {"b"}})
+
+	// the range of "a" holds the keys starting with the attribute "a", not "ab"
+	startKey, endKey, err := Range("car", []string{"a"})
+	testutil.AssertNoError(t, err, "Error getting a range")
+	var inRange int
+	for _, key := range keys {
+		if key >= startKey && key <= endKey {
+			inRange++
+		}
+	}
+	testutil.AssertEquals(t, inRange, 3)
+}
@@ -0,0 +1,293 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package query selects the state values which are JSON documents by their
+// content. A query is a JSON object such as
+//
+//	{"selector": {"owner": "alice", "size": {"$gte": 10}}, "limit": 20}
+//
+// The selector matches the documents whose every field, a dot separated path
+// into nested objects, matches its condition. A condition is either a value
+// the field must equal, or an object of operators all of which must hold:
+// $eq, $ne, $gt, $gte, $lt, $lte, $in and $nin given an array, and $exists
+// given a boolean. The selector may also combine selectors with $and and $or
+// given an array, and $not. Numbers only compare to numbers, strings to
+// strings, $ne and $nin match the documents missing the field.
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Query is a parsed query
+type Query struct {
+	// Limit is the most documents returned by the query, 0 for no limit
+	Limit int
+
+	selector condition
+}
+
+// condition tells whether a document matches
+type condition func(doc interface{}) bool
+
+// Parse parses a query
+func Parse(query string) (*Query, error) {
+	var raw struct {
+		Selector interface{} `json:"selector"`
+		Limit    int         `json:"limit"`
+	}
+	if err := json.Unmarshal([]byte(query), &raw); err != nil {
+		return nil, fmt.Errorf("Invalid query: %s", err)
+	}
+	if raw.Selector == nil {
+		return nil, fmt.Errorf("Invalid query: no selector")
+	}
+	if raw.Limit < 0 {
+		return nil, fmt.Errorf("Invalid query: negative limit %d", raw.Limit)
+	}
+	selector, err := parseSelector(raw.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid query: %s", err)
+	}
+	return &Query{raw.Limit, selector}, nil
+}
+
+// ParseDocument returns the JSON document held by value, false if value is
+// not a JSON object
+func ParseDocument(value []byte) (interface{}, bool) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(value, &doc); err != nil || doc == nil {
+		return nil, false
+	}
+	return doc, true
+}
+
+// Field returns the value of field, a dot separated path into nested
+// objects, in the document as returned by ParseDocument, false if the
+// document has no such field
+func Field(doc interface{}, field string) (interface{}, bool) {
+	return lookup(doc, strings.Split(field, "."))
+}
+
+// Matches returns whether the document, as returned by ParseDocument,
+// matches the selector of the query
+func (query *Query) Matches(doc interface{}) bool {
+	return query.selector(doc)
+}
+
+func parseSelector(raw interface{}) (condition, error) {
+	selector, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("selector %v is not an object", raw)
+	}
+	var conditions []condition
+	for field, value := range selector {
+		var cond condition
+		var err error
+		switch field {
+		case "$and", "$or":
+			cond, err = parseCombination(field, value)
+		case "$not":
+			cond, err = parseSelector(value)
+			if err == nil {
+				negated := cond
+				cond = func(doc interface{}) bool { return !negated(doc) }
+			}
+		default:
+			if strings.HasPrefix(field, "$") {
+				return nil, fmt.Errorf("unknown operator %s", field)
+			}
+			cond, err = parseField(strings.Split(field, "."), value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, cond)
+	}
+	return all(conditions), nil
+}
+
+func parseCombination(operator string, value interface{}) (condition, error) {
+	raws, ok := value.([]interface{})
+	if !ok || len(raws) == 0 {
+		return nil, fmt.Errorf("%s expects a non empty array of selectors", operator)
+	}
+	var conditions []condition
+	for _, raw := range raws {
+		cond, err := parseSelector(raw)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, cond)
+	}
+	if operator == "$and" {
+		return all(conditions), nil
+	}
+	return func(doc interface{}) bool {
+		for _, cond := range conditions {
+			if cond(doc) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func all(conditions []condition) condition {
+	return func(doc interface{}) bool {
+		for _, cond := range conditions {
+			if !cond(doc) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// parseField parses the condition on the field at path
+func parseField(path []string, value interface{}) (condition, error) {
+	operators, ok := value.(map[string]interface{})
+	if !ok || !isOperators(operators) {
+		return func(doc interface{}) bool {
+			fieldValue, found := lookup(doc, path)
+			return found && equal(fieldValue, value)
+		}, nil
+	}
+
+	var conditions []condition
+	for operator, operand := range operators {
+		cond, err := parseOperator(path, operator, operand)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, cond)
+	}
+	return all(conditions), nil
+}
+
+func isOperators(object map[string]interface{}) bool {
+	if len(object) == 0 {
+		return false
+	}
+	for key := range object {
+		if !strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+func parseOperator(path []string, operator string, operand interface{}) (condition, error) {
+	// test is applied to the value of the field, found tells whether the
+	// document has the field
+	var test func(fieldValue interface{}, found bool) bool
+	switch operator {
+	case "$eq":
+		test = func(fieldValue interface{}, found bool) bool { return found && equal(fieldValue, operand) }
+	case "$ne":
+		test = func(fieldValue interface{}, found bool) bool { return !found || !equal(fieldValue, operand) }
+	case "$gt", "$gte", "$lt", "$lte":
+		if _, ok := compare(operand, operand); !ok {
+			return nil, fmt.Errorf("%s expects a number or a string", operator)
+		}
+		test = func(fieldValue interface{}, found bool) bool {
+			c, ok := compare(fieldValue, operand)
+			if !found || !ok {
+				return false
+			}
+			switch operator {
+			case "$gt":
+				return c > 0
+			case "$gte":
+				return c >= 0
+			case "$lt":
+				return c < 0
+			}
+			return c <= 0
+		}
+	case "$in", "$nin":
+		values, ok := operand.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s expects an array", operator)
+		}
+		in := operator == "$in"
+		test = func(fieldValue interface{}, found bool) bool {
+			if found {
+				for _, value := range values {
+					if equal(fieldValue, value) {
+						return in
+					}
+				}
+			}
+			return !in
+		}
+	case "$exists":
+		exists, ok := operand.(bool)
+		if !ok {
+			return nil, fmt.Errorf("$exists expects a boolean")
+		}
+		test = func(fieldValue interface{}, found bool) bool { return found == exists }
+	default:
+		return nil, fmt.Errorf("unknown operator %s", operator)
+	}
+	return func(doc interface{}) bool {
+		fieldValue, found := lookup(doc, path)
+		return test(fieldValue, found)
+	}, nil
+}
+
+// lookup returns the value of the field at path in doc
+func lookup(doc interface{}, path []string) (interface{}, bool) {
+	for _, name := range path {
+		object, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if doc, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+func equal(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// compare returns -1, 0 or 1 as a is less than, equal to or greater than b,
+// and whether a and b are both numbers or both strings
+func compare(a, b interface{}) (int, bool) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			switch {
+			case a < b:
+				return -1, true
+			case a > b:
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	}
+	return 0, false
+}
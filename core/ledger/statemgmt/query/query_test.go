@@ -0,0 +1,92 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/ledger/testutil"
+)
+
+var testDocs = map[string]string{
+	"alice": `{"owner": "alice", "size": 10, "color": "red", "tags": ["a", "b"], "box": {"weight": 3}}`,
+	"bob":   `{"owner": "bob", "size": 20, "color": "blue", "box": {"weight": 5}}`,
+	"carol": `{"owner": "carol", "size": "large"}`,
+}
+
+func matching(t *testing.T, q string) []string {
+	query, err := Parse(q)
+	testutil.AssertNoError(t, err, "Error parsing query "+q)
+	var keys []string
+	for _, key := range []string{"alice", "bob", "carol"} {
+		doc, ok := ParseDocument([]byte(testDocs[key]))
+		testutil.AssertEquals(t, ok, true)
+		if query.Matches(doc) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func TestQuerySelectors(t *testing.T) {
+	testutil.AssertEquals(t, matching(t, `{"selector": {"owner": "alice"}}`), []string{"alice"})
+	testutil.AssertEquals(t, matching(t, `{"selector": {"box.weight": 5}}`), []string{"bob"})
+	testutil.AssertEquals(t, matching(t, `{"selector": {"tags": ["a", "b"]}}`), []string{"alice"})
+	testutil.AssertEquals(t, matching(t, `{"selector": {"size": {"$gt": 10}}}`), []string{"bob"})
+	testutil.AssertEquals(t, matching(t, `{"selector": {"size": {"$gte": 10, "$lt": 20}}}`), []string{"alice"})
+	testutil.AssertEquals(t, matching(t, `{"selector": {"size": {"$gte": "a"}}}`), []string{"carol"})
+	testutil.AssertEquals(t, matching(t, `{"selector": {"color": {"$ne": "red"}}}`), []string{"bob", "carol"})
+	testutil.AssertEquals(t, matching(t, `{"selector": {"color": {"$in": ["red", "blue"]}}}`), []string{"alice", "bob"})
+	testutil.AssertEquals(t, matching(t, `{"selector": {"color": {"$nin": ["red"]}}}`), []string{"bob", "carol"})
+	testutil.AssertEquals(t, matching(t, `{"selector": {"box": {"$exists": false}}}`), []string{"carol"})
+	testutil.AssertEquals(t, matching(t, `{"selector": {"$or": [{"owner": "alice"}, {"box.weight": {"$gt": 4}}]}}`),
+		[]string{"alice", "bob"})
+	testutil.AssertEquals(t, matching(t, `{"selector": {"$and": [{"size": {"$lt": 100}}, {"$not": {"owner": "bob"}}]}}`),
+		[]string{"alice"})
+	testutil.AssertEquals(t, len(matching(t, `{"selector": {}}`)), 3)
+}
+
+func TestQueryLimit(t *testing.T) {
+	query, err := Parse(`{"selector": {}, "limit": 2}`)
+	testutil.AssertNoError(t, err, "Error parsing query")
+	testutil.AssertEquals(t, query.Limit, 2)
+}
+
+func TestQueryInvalid(t *testing.T) {
+	for _, q := range []string{
+		`not json`,
+		`{"limit": 1}`,
+		`{"selector": []}`,
+		`{"selector": {}, "limit": -1}`,
+		`{"selector": {"$nor": []}}`,
+		`{"selector": {"$or": []}}`,
+		`{"selector": {"size": {"$gt": true}}}`,
+		`{"selector": {"size": {"$in": 1}}}`,
+		`{"selector": {"size": {"$exists": 1}}}`,
+		`{"selector": {"size": {"$regex": "a"}}}`,
+	} {
+		_, err := Parse(q)
+		testutil.AssertError(t, err, "Expected an error parsing query "+q)
+	}
+}
+
+func TestParseDocument(t *testing.T) {
+	for _, value := range []string{`not json`, `"a string"`, `[1, 2]`, `null`} {
+		_, ok := ParseDocument([]byte(value))
+		testutil.AssertEquals(t, ok, false)
+	}
+}
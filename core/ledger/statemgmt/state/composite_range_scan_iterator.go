@@ -38,6 +38,14 @@ func newCompositeRangeScanIterator(
 	return &CompositeRangeScanIterator{itrs, 0}
 }
 
+// newPersistingRangeScanIterator wraps the iterator over the changes being written to the db on top
+// of the iterator over the db
+func newPersistingRangeScanIterator(
+	persistingItr *statemgmt.StateDeltaIterator,
+	implItr statemgmt.RangeScanIterator) statemgmt.RangeScanIterator {
+	return &CompositeRangeScanIterator{[]statemgmt.RangeScanIterator{persistingItr, implItr}, 0}
+}
+
 // Next - see interface 'statemgmt.RangeScanIterator' for details
 // The specific implementation below starts from first underlying iterator and
 // after exhausting the first underlying iterator, move to the second underlying iterator.
@@ -69,7 +77,7 @@ func (itr *CompositeRangeScanIterator) Next() bool {
 		break
 	}
 
-	if keyAvailable || currentItrNumber == 2 {
+	if keyAvailable || currentItrNumber == len(itr.itrs)-1 {
 		logger.Debug("Returning for current key")
 		return keyAvailable
 	}
@@ -86,5 +94,5 @@ func (itr *CompositeRangeScanIterator) GetKeyValue() (string, []byte) {
 
 // Close - see interface 'statemgmt.RangeScanIterator' for details
 func (itr *CompositeRangeScanIterator) Close() {
-	itr.itrs[2].Close()
+	itr.itrs[len(itr.itrs)-1].Close()
 }
@@ -18,8 +18,10 @@ package state
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 
+	"github.com/spf13/cast"
 	"github.com/spf13/viper"
 )
 
@@ -28,6 +30,8 @@ var loadConfigOnce sync.Once
 var stateImplName string
 var stateImplConfigs map[string]interface{}
 var deltaHistorySize int
+var queryIndexEnabled bool
+var indexesConfig stateIndexes
 
 func initConfig() {
 	loadConfigOnce.Do(func() { loadConfig() })
@@ -38,8 +42,13 @@ func loadConfig() {
 	stateImplName = viper.GetString("ledger.state.dataStructure.name")
 	stateImplConfigs = viper.GetStringMap("ledger.state.dataStructure.configs")
 	deltaHistorySize = viper.GetInt("ledger.state.deltaHistorySize")
-	logger.Info("Configurations loaded. stateImplName=[%s], stateImplConfigs=%s, deltaHistorySize=[%d]",
-		stateImplName, stateImplConfigs, deltaHistorySize)
+	queryIndexEnabled = viper.GetBool("ledger.state.queryIndex")
+	indexesConfig = make(stateIndexes)
+	for chaincodeID, indexes := range cast.ToStringMap(viper.Get("ledger.state.indexes")) {
+		indexesConfig[chaincodeID] = cast.ToStringMapString(indexes)
+	}
+	logger.Info("Configurations loaded. stateImplName=[%s], stateImplConfigs=%s, deltaHistorySize=[%d], queryIndex=[%t], indexes=%v",
+		stateImplName, stateImplConfigs, deltaHistorySize, queryIndexEnabled, indexesConfig)
 
 	if len(stateImplName) == 0 {
 		stateImplName = detaultStateImpl
@@ -51,4 +60,12 @@ func loadConfig() {
 	if deltaHistorySize < 0 {
 		panic(fmt.Errorf("Delta history size must be greater than or equal to 0. Current value is %d.", deltaHistorySize))
 	}
+
+	for chaincodeID, indexes := range indexesConfig {
+		for name, field := range indexes {
+			if strings.Contains(chaincodeID+name, "\x00") || field == "" {
+				panic(fmt.Errorf("Index '%s' of chaincode '%s' is not valid. It must name a field and no U+0000.", name, chaincodeID))
+			}
+		}
+	}
 }
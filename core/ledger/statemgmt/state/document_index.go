@@ -0,0 +1,176 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/query"
+)
+
+// document is a state value holding a JSON document
+type document struct {
+	value []byte
+	doc   interface{}
+}
+
+// documentIndex keeps the JSON documents among the committed values of the
+// chaincodes parsed, so that queries do not read and parse all the state of
+// a chaincode. The documents of a chaincode are loaded on its first query,
+// and kept up to date as state changes are committed.
+type documentIndex struct {
+	lock       sync.RWMutex
+	chaincodes map[string]map[string]*document
+}
+
+func newDocumentIndex() *documentIndex {
+	return &documentIndex{chaincodes: make(map[string]map[string]*document)}
+}
+
+// forEach calls f on every committed document of chaincodeID
+func (index *documentIndex) forEach(stateImpl statemgmt.HashableState, chaincodeID string, f func(key string, doc *document)) error {
+	index.lock.RLock()
+	docs, ok := index.chaincodes[chaincodeID]
+	if !ok {
+		index.lock.RUnlock()
+		if err := index.load(stateImpl, chaincodeID); err != nil {
+			return err
+		}
+		index.lock.RLock()
+		docs = index.chaincodes[chaincodeID]
+	}
+	defer index.lock.RUnlock()
+	for key, doc := range docs {
+		f(key, doc)
+	}
+	return nil
+}
+
+func (index *documentIndex) load(stateImpl statemgmt.HashableState, chaincodeID string) error {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	if _, ok := index.chaincodes[chaincodeID]; ok {
+		return nil
+	}
+	docs := make(map[string]*document)
+	err := scanDocuments(stateImpl, chaincodeID, func(key string, doc *document) {
+		docs[key] = doc
+	})
+	if err != nil {
+		return err
+	}
+	logger.Debug("Loaded %d documents of chaincode [%s] in the document index", len(docs), chaincodeID)
+	index.chaincodes[chaincodeID] = docs
+	return nil
+}
+
+// update applies the changes committed to the documents of the chaincodes
+// loaded
+func (index *documentIndex) update(delta *statemgmt.StateDelta) {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	for _, chaincodeID := range delta.GetUpdatedChaincodeIds(false) {
+		docs, ok := index.chaincodes[chaincodeID]
+		if !ok {
+			continue
+		}
+		for key, updatedValue := range delta.GetUpdates(chaincodeID) {
+			if doc := parseDocument(updatedValue); doc != nil {
+				docs[key] = doc
+			} else {
+				delete(docs, key)
+			}
+		}
+	}
+}
+
+// clear drops all the documents, to be loaded again
+func (index *documentIndex) clear() {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	index.chaincodes = make(map[string]map[string]*document)
+}
+
+// scanDocuments calls f on every document in the committed state of
+// chaincodeID
+func scanDocuments(stateImpl statemgmt.HashableState, chaincodeID string, f func(key string, doc *document)) error {
+	itr, err := stateImpl.GetRangeScanIterator(chaincodeID, "", "")
+	if err != nil {
+		return err
+	}
+	defer itr.Close()
+	for itr.Next() {
+		key, value := itr.GetKeyValue()
+		if doc, ok := query.ParseDocument(value); ok {
+			f(key, &document{statemgmt.Copy(value), doc})
+		}
+	}
+	return nil
+}
+
+// parseDocument returns the document held by the updated value, nil if it
+// is deleted or not a document
+func parseDocument(updatedValue *statemgmt.UpdatedValue) *document {
+	if updatedValue.IsDelete() {
+		return nil
+	}
+	doc, ok := query.ParseDocument(updatedValue.GetValue())
+	if !ok {
+		return nil
+	}
+	return &document{updatedValue.GetValue(), doc}
+}
+
+// queryResultIterator - an implementation of interface 'statemgmt.RangeScanIterator'
+// over the results of a query, sorted by key
+type queryResultIterator struct {
+	keys   []string
+	values [][]byte
+	index  int
+}
+
+func newQueryResultIterator(results map[string][]byte, limit int) *queryResultIterator {
+	itr := &queryResultIterator{index: -1}
+	for key := range results {
+		itr.keys = append(itr.keys, key)
+	}
+	sort.Strings(itr.keys)
+	if limit > 0 && len(itr.keys) > limit {
+		itr.keys = itr.keys[:limit]
+	}
+	for _, key := range itr.keys {
+		itr.values = append(itr.values, results[key])
+	}
+	return itr
+}
+
+// Next - see interface 'statemgmt.RangeScanIterator' for details
+func (itr *queryResultIterator) Next() bool {
+	itr.index++
+	return itr.index < len(itr.keys)
+}
+
+// GetKeyValue - see interface 'statemgmt.RangeScanIterator' for details
+func (itr *queryResultIterator) GetKeyValue() (string, []byte) {
+	return itr.keys[itr.index], itr.values[itr.index]
+}
+
+// Close - see interface 'statemgmt.RangeScanIterator' for details
+func (itr *queryResultIterator) Close() {
+}
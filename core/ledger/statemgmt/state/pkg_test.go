@@ -22,8 +22,8 @@ import (
 
 	"github.com/hyperledger/fabric/core/db"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/query"
 	"github.com/hyperledger/fabric/core/ledger/testutil"
-	"github.com/tecbot/gorocksdb"
 )
 
 var testDBWrapper = db.NewTestDBWrapper()
@@ -45,7 +45,7 @@ type stateTestWrapper struct {
 }
 
 func newStateTestWrapper(t *testing.T) *stateTestWrapper {
-	return &stateTestWrapper{t, NewState()}
+	return &stateTestWrapper{t, NewState(db.GetDBHandle())}
 }
 
 func (testWrapper *stateTestWrapper) get(chaincodeID string, key string, committed bool) []byte {
@@ -62,7 +62,7 @@ func (testWrapper *stateTestWrapper) getSnapshot() *StateSnapshot {
 }
 
 func (testWrapper *stateTestWrapper) persistAndClearInMemoryChanges(blockNumber uint64) {
-	writeBatch := gorocksdb.NewWriteBatch()
+	writeBatch := db.NewWriteBatch()
 	defer writeBatch.Destroy()
 	testWrapper.state.AddChangesForPersistence(blockNumber, writeBatch)
 	testDBWrapper.WriteToDB(testWrapper.t, writeBatch)
@@ -74,3 +74,29 @@ func (testWrapper *stateTestWrapper) fetchStateDeltaFromDB(blockNumber uint64) *
 	delta.Unmarshal(testDBWrapper.GetFromStateDeltaCF(testWrapper.t, encodeStateDeltaKey(blockNumber)))
 	return delta
 }
+
+func (testWrapper *stateTestWrapper) query(chaincodeID string, queryString string, committed bool) []string {
+	q, err := query.Parse(queryString)
+	testutil.AssertNoError(testWrapper.t, err, "Error while parsing query")
+	itr, err := testWrapper.state.GetQueryIterator(chaincodeID, q, committed)
+	testutil.AssertNoError(testWrapper.t, err, "Error while querying state")
+	defer itr.Close()
+	var keys []string
+	for itr.Next() {
+		key, _ := itr.GetKeyValue()
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (testWrapper *stateTestWrapper) scanIndex(chaincodeID string, name string, startValue string, endValue string, committed bool) []string {
+	itr, err := testWrapper.state.GetIndexIterator(chaincodeID, name, startValue, endValue, committed)
+	testutil.AssertNoError(testWrapper.t, err, "Error while scanning index")
+	defer itr.Close()
+	var keys []string
+	for itr.Next() {
+		key, _ := itr.GetKeyValue()
+		keys = append(keys, key)
+	}
+	return keys
+}
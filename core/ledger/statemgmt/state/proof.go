@@ -0,0 +1,66 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/buckettree"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/trie"
+	"github.com/hyperledger/fabric/protos"
+)
+
+// ProofSupported tells whether the state implementation can prove the values of keys
+func (state *State) ProofSupported() bool {
+	_, ok := state.stateImpl.(statemgmt.ProvableState)
+	return ok
+}
+
+// GetProof returns the proof that key of chaincodeID holds its value, or none, in the committed
+// state of the DB snapshot. The block and the state hash of the proof are left to the caller
+func (state *State) GetProof(dbSnapshot db.Snapshot, chaincodeID string, key string) (*protos.StateProof, error) {
+	provableState, ok := state.stateImpl.(statemgmt.ProvableState)
+	if !ok {
+		return nil, fmt.Errorf("State implementation [%s] can not prove the values of keys", stateImplName)
+	}
+	return provableState.GetProof(dbSnapshot, chaincodeID, key)
+}
+
+// VerifyProof checks that the key-value of the proof hashes up to the state hash of the proof along
+// its path in a bucket tree or a trie. It needs neither the DB nor the configuration of the peer
+func VerifyProof(proof *protos.StateProof) error {
+	var cryptoHash []byte
+	var err error
+	switch {
+	case proof.BucketTree != nil && proof.Trie == nil:
+		cryptoHash, err = buckettree.VerifyProof(proof.ChaincodeID, proof.Key, proof.Exists, proof.Value, proof.BucketTree)
+	case proof.Trie != nil && proof.BucketTree == nil:
+		cryptoHash, err = trie.VerifyProof(proof.ChaincodeID, proof.Key, proof.Exists, proof.Value, proof.Trie)
+	default:
+		return fmt.Errorf("A state proof holds either a bucket tree or a trie path")
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(cryptoHash, proof.StateHash) {
+		return fmt.Errorf("State proof hashes up to [%x] instead of the state hash [%x]", cryptoHash, proof.StateHash)
+	}
+	return nil
+}
@@ -19,22 +19,21 @@ package state
 import (
 	"encoding/binary"
 	"fmt"
+	"sync"
 
 	"github.com/hyperledger/fabric/core/db"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt/buckettree"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/query"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt/raw"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt/trie"
 	"github.com/op/go-logging"
-	"github.com/tecbot/gorocksdb"
 )
 
 var logger = logging.MustGetLogger("state")
 
 const detaultStateImpl = "buckettree"
 
-var stateImpl statemgmt.HashableState
-
 // State structure for maintaining world state.
 // This encapsulates a particular implementation for managing the state persistence
 // This is not thread safe
@@ -44,21 +43,36 @@ type State struct {
 	currentTxStateDelta   *statemgmt.StateDelta
 	currentTxUUID         string
 	txStateDeltaHash      map[string][]byte
+	txStateDeltas         map[string]*statemgmt.StateDelta
 	updateStateImpl       bool
 	historyStateDeltaSize uint64
+	documentIndex         *documentIndex
+	indexes               stateIndexes
+
+	// versions of the keys changed by the current batch, by chaincodeID and key: the number of
+	// transactions applied in the batch when the key was last changed
+	keyVersions map[string]map[string]uint64
+	txsApplied  uint64
+
+	// changes of the batch committed last, while they are written to the db in the background
+	persisting     *statemgmt.StateDelta
+	persistingLock *sync.RWMutex
+
+	openchainDB *db.OpenchainDB
 }
 
-// NewState constructs a new State. This Initializes encapsulated state implementation
-func NewState() *State {
+// NewState constructs a new State stored in the given DB. This Initializes encapsulated state implementation
+func NewState(openchainDB *db.OpenchainDB) *State {
 	initConfig()
+	var stateImpl statemgmt.HashableState
 	logger.Info("Initializing state implementation [%s]", stateImplName)
 	switch stateImplName {
 	case "buckettree":
-		stateImpl = buckettree.NewStateImpl()
+		stateImpl = buckettree.NewStateImpl(openchainDB)
 	case "trie":
-		stateImpl = trie.NewStateTrie()
+		stateImpl = trie.NewStateTrie(openchainDB)
 	case "raw":
-		stateImpl = raw.NewRawState()
+		stateImpl = raw.NewRawState(openchainDB)
 	default:
 		panic("Should not reach here. Configs should have checked for the stateImplName being a valid names ")
 	}
@@ -66,8 +80,17 @@ func NewState() *State {
 	if err != nil {
 		panic(fmt.Errorf("Error during initialization of state implementation: %s", err))
 	}
-	return &State{stateImpl, statemgmt.NewStateDelta(), statemgmt.NewStateDelta(), "", make(map[string][]byte),
-		false, uint64(deltaHistorySize)}
+	var index *documentIndex
+	if queryIndexEnabled {
+		index = newDocumentIndex()
+	}
+	state := &State{stateImpl, statemgmt.NewStateDelta(), statemgmt.NewStateDelta(), "", make(map[string][]byte),
+		make(map[string]*statemgmt.StateDelta), false, uint64(deltaHistorySize), index, indexesConfig,
+		make(map[string]map[string]uint64), 0, nil, new(sync.RWMutex), openchainDB}
+	if err = state.syncIndexes(); err != nil {
+		panic(fmt.Errorf("Error during initialization of state indexes: %s", err))
+	}
+	return state
 }
 
 // TxBegin marks begin of a new tx. If a tx is already in progress, this call panics
@@ -86,10 +109,13 @@ func (state *State) TxFinish(txUUID string, txSuccessful bool) {
 		panic(fmt.Errorf("Different Uuid in tx-begin [%s] and tx-finish [%s]", state.currentTxUUID, txUUID))
 	}
 	if txSuccessful {
+		state.txsApplied++
+		state.updateKeyVersions(state.currentTxStateDelta)
 		if !state.currentTxStateDelta.IsEmpty() {
 			logger.Debug("txFinish() for txUuid [%s] merging state changes", txUUID)
 			state.stateDelta.ApplyChanges(state.currentTxStateDelta)
 			state.txStateDeltaHash[txUUID] = state.currentTxStateDelta.ComputeCryptoHash()
+			state.txStateDeltas[txUUID] = state.currentTxStateDelta
 			state.updateStateImpl = true
 		} else {
 			state.txStateDeltaHash[txUUID] = nil
@@ -99,6 +125,20 @@ func (state *State) TxFinish(txUUID string, txSuccessful bool) {
 	state.currentTxUUID = ""
 }
 
+// updateKeyVersions sets the version of the keys changed by the transaction just applied
+func (state *State) updateKeyVersions(txStateDelta *statemgmt.StateDelta) {
+	for _, chaincodeID := range txStateDelta.GetUpdatedChaincodeIds(false) {
+		versions, ok := state.keyVersions[chaincodeID]
+		if !ok {
+			versions = make(map[string]uint64)
+			state.keyVersions[chaincodeID] = versions
+		}
+		for key := range txStateDelta.GetUpdates(chaincodeID) {
+			versions[key] = state.txsApplied
+		}
+	}
+}
+
 func (state *State) txInProgress() bool {
 	return state.currentTxUUID != ""
 }
@@ -116,13 +156,48 @@ func (state *State) Get(chaincodeID string, key string, committed bool) ([]byte,
 			return valueHolder.GetValue(), nil
 		}
 	}
+	return state.getCommitted(chaincodeID, key)
+}
+
+// getCommitted returns the committed state for chaincodeID and key, from the changes being written
+// to the db in the background if they hold the key, else from the db
+func (state *State) getCommitted(chaincodeID string, key string) ([]byte, error) {
+	if persisting := state.getPersisting(); persisting != nil {
+		if valueHolder := persisting.Get(chaincodeID, key); valueHolder != nil {
+			return valueHolder.GetValue(), nil
+		}
+	}
 	return state.stateImpl.Get(chaincodeID, key)
 }
 
+// getCommittedRangeScanIterator returns an iterator over the committed state of chaincodeID between
+// startKey and endKey, the changes being written to the db in the background in preference to the db
+func (state *State) getCommittedRangeScanIterator(chaincodeID string, startKey string, endKey string) (statemgmt.RangeScanIterator, error) {
+	stateImplItr, err := state.stateImpl.GetRangeScanIterator(chaincodeID, startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	persisting := state.getPersisting()
+	if persisting == nil {
+		return stateImplItr, nil
+	}
+	return newPersistingRangeScanIterator(
+		statemgmt.NewStateDeltaRangeScanIterator(persisting, chaincodeID, startKey, endKey),
+		stateImplItr), nil
+}
+
+// withPersisting prepends the changes being written to the db in the background, if any, to deltas
+func (state *State) withPersisting(deltas ...*statemgmt.StateDelta) []*statemgmt.StateDelta {
+	if persisting := state.getPersisting(); persisting != nil {
+		return append([]*statemgmt.StateDelta{persisting}, deltas...)
+	}
+	return deltas
+}
+
 // GetRangeScanIterator returns an iterator to get all the keys (and values) between startKey and endKey
 // (assuming lexical order of the keys) for a chaincodeID.
 func (state *State) GetRangeScanIterator(chaincodeID string, startKey string, endKey string, committed bool) (statemgmt.RangeScanIterator, error) {
-	stateImplItr, err := state.stateImpl.GetRangeScanIterator(chaincodeID, startKey, endKey)
+	stateImplItr, err := state.getCommittedRangeScanIterator(chaincodeID, startKey, endKey)
 	if err != nil {
 		return nil, err
 	}
@@ -136,6 +211,62 @@ func (state *State) GetRangeScanIterator(chaincodeID string, startKey string, en
 		stateImplItr), nil
 }
 
+// GetQueryIterator returns an iterator to get the keys (and values), in lexical order, of a chaincodeID
+// whose values are JSON documents matching the query. If committed is false, the changes in memory
+// are queried along with the db, in preference to it.
+func (state *State) GetQueryIterator(chaincodeID string, q *query.Query, committed bool) (statemgmt.RangeScanIterator, error) {
+	if committed {
+		return state.getQueryIterator(chaincodeID, q, state.withPersisting()...)
+	}
+	return state.getQueryIterator(chaincodeID, q, state.withPersisting(state.stateDelta, state.currentTxStateDelta)...)
+}
+
+// GetIndexIterator returns an iterator to get the keys (and values) of a chaincodeID whose values are
+// JSON documents with the field of index name between startValue and endValue (assuming lexical order
+// of the indexed values, no upper bound if endValue is empty), in order of the indexed values, then of
+// the keys. ErrIndexNotFound is returned if the index is not declared. If committed is false, the
+// changes in memory are scanned along with the db, in preference to it.
+func (state *State) GetIndexIterator(chaincodeID string, name string, startValue string, endValue string, committed bool) (statemgmt.RangeScanIterator, error) {
+	if committed {
+		return state.getIndexIterator(chaincodeID, name, startValue, endValue, state.withPersisting()...)
+	}
+	return state.getIndexIterator(chaincodeID, name, startValue, endValue,
+		state.withPersisting(state.stateDelta, state.currentTxStateDelta)...)
+}
+
+// getQueryIterator queries the db along with the changes in the deltas, each delta in
+// preference to the db and to the deltas before it
+func (state *State) getQueryIterator(chaincodeID string, q *query.Query, deltas ...*statemgmt.StateDelta) (statemgmt.RangeScanIterator, error) {
+	changes := make(map[string]*statemgmt.UpdatedValue)
+	for _, delta := range deltas {
+		for key, updatedValue := range delta.GetUpdates(chaincodeID) {
+			changes[key] = updatedValue
+		}
+	}
+
+	results := make(map[string][]byte)
+	match := func(key string, doc *document) {
+		if _, changed := changes[key]; !changed && q.Matches(doc.doc) {
+			results[key] = doc.value
+		}
+	}
+	var err error
+	if state.documentIndex != nil {
+		err = state.documentIndex.forEach(state.stateImpl, chaincodeID, match)
+	} else {
+		err = scanDocuments(state.stateImpl, chaincodeID, match)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for key, updatedValue := range changes {
+		if doc := parseDocument(updatedValue); doc != nil && q.Matches(doc.doc) {
+			results[key] = doc.value
+		}
+	}
+	return newQueryResultIterator(results, q.Limit), nil
+}
+
 // Set sets state to given value for chaincodeID and key. Does not immideatly writes to DB
 func (state *State) Set(chaincodeID string, key string, value []byte) error {
 	logger.Debug("set() chaincodeID=[%s], key=[%s], value=[%#v]", chaincodeID, key, value)
@@ -247,27 +378,68 @@ func (state *State) GetTxStateDeltaHash() map[string][]byte {
 	return state.txStateDeltaHash
 }
 
+// GetTxStateDeltas returns the changes in state made by each successful tx
+// since the most recent call to method ClearInMemoryChanges, by tx uuid
+func (state *State) GetTxStateDeltas() map[string]*statemgmt.StateDelta {
+	return state.txStateDeltas
+}
+
 // ClearInMemoryChanges remove from memory all the changes to state
 func (state *State) ClearInMemoryChanges(changesPersisted bool) {
+	if changesPersisted && state.documentIndex != nil {
+		state.documentIndex.update(state.stateDelta)
+	}
 	state.stateDelta = statemgmt.NewStateDelta()
 	state.txStateDeltaHash = make(map[string][]byte)
+	state.txStateDeltas = make(map[string]*statemgmt.StateDelta)
+	state.keyVersions = make(map[string]map[string]uint64)
+	state.txsApplied = 0
 	state.stateImpl.ClearWorkingSet(changesPersisted)
 }
 
-// getStateDelta get changes in state after most recent call to method clearInMemoryChanges
-func (state *State) getStateDelta() *statemgmt.StateDelta {
+// ClearInMemoryChangesPersisting removes from memory all the changes to state, as ClearInMemoryChanges
+// does once they are persisted, while they are written to the db in the background. Until
+// ChangesPersisted is called, the reads of the state, committed or not, see the changes as if they
+// were in the db
+func (state *State) ClearInMemoryChangesPersisting() {
+	state.persistingLock.Lock()
+	state.persisting = state.stateDelta
+	state.persistingLock.Unlock()
+	state.ClearInMemoryChanges(true)
+}
+
+// ChangesPersisted marks the end of the write to the db of the changes passed to
+// ClearInMemoryChangesPersisting
+func (state *State) ChangesPersisted() {
+	state.persistingLock.Lock()
+	defer state.persistingLock.Unlock()
+	if state.persisting != nil && state.documentIndex != nil {
+		// the documents of a chaincode loaded from the db during the write may miss the changes
+		state.documentIndex.update(state.persisting)
+	}
+	state.persisting = nil
+}
+
+func (state *State) getPersisting() *statemgmt.StateDelta {
+	state.persistingLock.RLock()
+	defer state.persistingLock.RUnlock()
+	return state.persisting
+}
+
+// GetStateDelta get changes in state after most recent call to method ClearInMemoryChanges
+func (state *State) GetStateDelta() *statemgmt.StateDelta {
 	return state.stateDelta
 }
 
 // GetSnapshot returns a snapshot of the global state for the current block. stateSnapshot.Release()
 // must be called once you are done.
-func (state *State) GetSnapshot(blockNumber uint64, dbSnapshot *gorocksdb.Snapshot) (*StateSnapshot, error) {
-	return newStateSnapshot(blockNumber, dbSnapshot)
+func (state *State) GetSnapshot(blockNumber uint64, dbSnapshot db.Snapshot) (*StateSnapshot, error) {
+	return newStateSnapshot(state.stateImpl, blockNumber, dbSnapshot)
 }
 
 // FetchStateDeltaFromDB fetches the StateDelta corrsponding to given blockNumber
 func (state *State) FetchStateDeltaFromDB(blockNumber uint64) (*statemgmt.StateDelta, error) {
-	stateDeltaBytes, err := db.GetDBHandle().GetFromStateDeltaCF(encodeStateDeltaKey(blockNumber))
+	stateDeltaBytes, err := state.openchainDB.GetFromStateDeltaCF(encodeStateDeltaKey(blockNumber))
 	if err != nil {
 		return nil, err
 	}
@@ -279,17 +451,35 @@ func (state *State) FetchStateDeltaFromDB(blockNumber uint64) (*statemgmt.StateD
 	return stateDelta, nil
 }
 
+// FetchStateDeltaFromSnapshot fetches the StateDelta corrsponding to given
+// blockNumber from a DB snapshot
+func (state *State) FetchStateDeltaFromSnapshot(blockNumber uint64, dbSnapshot db.Snapshot) (*statemgmt.StateDelta, error) {
+	stateDeltaBytes, err := state.openchainDB.GetFromStateDeltaCFSnapshot(dbSnapshot, encodeStateDeltaKey(blockNumber))
+	if err != nil {
+		return nil, err
+	}
+	if stateDeltaBytes == nil {
+		return nil, nil
+	}
+	stateDelta := statemgmt.NewStateDelta()
+	if err := stateDelta.Unmarshal(stateDeltaBytes); err != nil {
+		return nil, err
+	}
+	return stateDelta, nil
+}
+
 // AddChangesForPersistence adds key-value pairs to writeBatch
-func (state *State) AddChangesForPersistence(blockNumber uint64, writeBatch *gorocksdb.WriteBatch) {
+func (state *State) AddChangesForPersistence(blockNumber uint64, writeBatch *db.WriteBatch) {
 	logger.Debug("state.addChangesForPersistence()...start")
 	if state.updateStateImpl {
 		state.stateImpl.PrepareWorkingSet(state.stateDelta)
 		state.updateStateImpl = false
 	}
 	state.stateImpl.AddChangesForPersistence(writeBatch)
+	state.addIndexChangesForPersistence(state.stateDelta, writeBatch)
 
 	serializedStateDelta := state.stateDelta.Marshal()
-	cf := db.GetDBHandle().StateDeltaCF
+	cf := state.openchainDB.StateDeltaCF
 	logger.Debug("Adding state-delta corresponding to block number[%d]", blockNumber)
 	writeBatch.PutCF(cf, encodeStateDeltaKey(blockNumber), serializedStateDelta)
 	if blockNumber >= state.historyStateDeltaSize {
@@ -303,6 +493,46 @@ func (state *State) AddChangesForPersistence(blockNumber uint64, writeBatch *gor
 	logger.Debug("state.addChangesForPersistence()...finished")
 }
 
+// PruneStateDeltas deletes the state-deltas older than the history kept
+// behind blockNumber, the latest block. Committing a block only deletes the
+// state-delta falling out of the history, this catches up with the deltas
+// left behind, e.g. when the history size was reduced. Returns the number
+// of state-deltas deleted.
+func (state *State) PruneStateDeltas(blockNumber uint64) (uint64, error) {
+	if blockNumber < state.historyStateDeltaSize {
+		return 0, nil
+	}
+	keepFrom := blockNumber - state.historyStateDeltaSize + 1
+
+	openchainDB := state.openchainDB
+	itr := openchainDB.GetStateDeltaCFIterator()
+	defer itr.Close()
+	writeBatch := db.NewWriteBatch()
+	defer writeBatch.Destroy()
+
+	var pruned uint64
+	for itr.SeekToFirst(); itr.Valid(); itr.Next() {
+		keyBytes := statemgmt.Copy(itr.Key())
+		if decodeStateDeltaKey(keyBytes) >= keepFrom {
+			break
+		}
+		writeBatch.DeleteCF(openchainDB.StateDeltaCF, keyBytes)
+		pruned++
+	}
+	if err := itr.Err(); err != nil {
+		return 0, err
+	}
+	if pruned == 0 {
+		return 0, nil
+	}
+
+	logger.Debug("Deleting %d state-deltas older than block number [%d]", pruned, keepFrom)
+	if err := openchainDB.Write(writeBatch); err != nil {
+		return 0, err
+	}
+	return pruned, nil
+}
+
 // ApplyStateDelta applies already prepared stateDelta to the existing state.
 // This is an in memory change only. state.CommitStateDelta must be used to
 // commit the state to the DB. This method is to be used in state transfer.
@@ -319,12 +549,11 @@ func (state *State) CommitStateDelta() error {
 		state.updateStateImpl = false
 	}
 
-	writeBatch := gorocksdb.NewWriteBatch()
+	writeBatch := db.NewWriteBatch()
 	defer writeBatch.Destroy()
 	state.stateImpl.AddChangesForPersistence(writeBatch)
-	opt := gorocksdb.NewDefaultWriteOptions()
-	defer opt.Destroy()
-	return db.GetDBHandle().DB.Write(opt, writeBatch)
+	state.addIndexChangesForPersistence(state.stateDelta, writeBatch)
+	return state.openchainDB.Write(writeBatch)
 }
 
 // DeleteState deletes ALL state keys/values from the DB. This is generally
@@ -332,11 +561,20 @@ func (state *State) CommitStateDelta() error {
 // a snapshot.
 func (state *State) DeleteState() error {
 	state.ClearInMemoryChanges(false)
-	err := db.GetDBHandle().DeleteState()
+	if state.documentIndex != nil {
+		state.documentIndex.clear()
+	}
+	openchainDB := state.openchainDB
+	err := openchainDB.DeleteState()
 	if err != nil {
 		logger.Error("Error deleting state", err)
+		return err
+	}
+	// the indexes of the empty state are empty
+	if err = openchainDB.DropColumnFamily(openchainDB.StateIndexCF); err != nil {
+		return err
 	}
-	return err
+	return state.syncIndexes()
 }
 
 func encodeStateDeltaKey(blockNumber uint64) []byte {
@@ -0,0 +1,292 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/query"
+)
+
+// ErrIndexNotFound is returned when scanning an index not declared for the chaincode
+var ErrIndexNotFound = errors.New("state: index not declared")
+
+// stateIndexes are the secondary indexes of the state, declared in 'ledger.state.indexes': by
+// chaincodeID and index name, the field of the JSON documents held by the values of the chaincode
+// that the index sorts the keys by. The indexes are kept in the DB and changed along with the state
+// as blocks are committed, so that the keys whose field holds some values are read without scanning
+// the state of the chaincode.
+//
+// The DB holds the field of each index built, so that the indexes declared since, or whose field
+// changed, are built from the state when the state is constructed, and those no longer declared
+// are dropped. Each key of an index is held by an entry keyed by the chaincode, the index name,
+// the indexed value of the field and the key: a string field is indexed by its value, a number or
+// a boolean by its JSON text. The keys whose values are not documents, whose field is missing,
+// an object or an array, or holds U+0000 are not indexed.
+type stateIndexes map[string]map[string]string
+
+const (
+	indexDefinitionPrefix = byte(0)
+	indexEntryPrefix      = byte(1)
+)
+
+// indexedValue returns the value which key is indexed by in the index of field, false if
+// value is not indexed
+func indexedValue(field string, value []byte) (string, bool) {
+	if value == nil {
+		return "", false
+	}
+	doc, ok := query.ParseDocument(value)
+	if !ok {
+		return "", false
+	}
+	fieldValue, ok := query.Field(doc, field)
+	if !ok {
+		return "", false
+	}
+	var indexed string
+	switch fieldValue := fieldValue.(type) {
+	case string:
+		indexed = fieldValue
+	case float64:
+		indexed = strconv.FormatFloat(fieldValue, 'f', -1, 64)
+	case bool:
+		indexed = strconv.FormatBool(fieldValue)
+	default:
+		return "", false
+	}
+	if strings.Contains(indexed, "\x00") {
+		return "", false
+	}
+	return indexed, true
+}
+
+// syncIndexes drops from the DB the indexes no longer declared and builds those declared since
+func (state *State) syncIndexes() error {
+	openchainDB := state.openchainDB
+	built := make(stateIndexes)
+	itr := openchainDB.GetIterator(openchainDB.StateIndexCF)
+	prefix := []byte{indexDefinitionPrefix}
+	for itr.Seek(prefix); itr.ValidForPrefix(prefix); itr.Next() {
+		components := strings.SplitN(string(itr.Key()[1:]), "\x00", 2)
+		if _, ok := built[components[0]]; !ok {
+			built[components[0]] = make(map[string]string)
+		}
+		built[components[0]][components[1]] = string(itr.Value())
+	}
+	err := itr.Err()
+	itr.Close()
+	if err != nil {
+		return err
+	}
+
+	for chaincodeID, indexes := range built {
+		for name, field := range indexes {
+			if state.indexes[chaincodeID][name] != field {
+				if err := state.dropIndex(chaincodeID, name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for chaincodeID, indexes := range state.indexes {
+		for name, field := range indexes {
+			if built[chaincodeID][name] != field {
+				if err := state.buildIndex(chaincodeID, name, field); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// dropIndex deletes an index from the DB
+func (state *State) dropIndex(chaincodeID string, name string) error {
+	openchainDB := state.openchainDB
+	writeBatch := db.NewWriteBatch()
+	defer writeBatch.Destroy()
+	writeBatch.DeleteCF(openchainDB.StateIndexCF, encodeIndexDefinitionKey(chaincodeID, name))
+	itr := openchainDB.GetIterator(openchainDB.StateIndexCF)
+	defer itr.Close()
+	prefix := encodeIndexEntryPrefix(chaincodeID, name)
+	for itr.Seek(prefix); itr.ValidForPrefix(prefix); itr.Next() {
+		writeBatch.DeleteCF(openchainDB.StateIndexCF, statemgmt.Copy(itr.Key()))
+	}
+	if err := itr.Err(); err != nil {
+		return err
+	}
+	logger.Info("Dropping index [%s] of chaincode [%s]", name, chaincodeID)
+	return openchainDB.Write(writeBatch)
+}
+
+// buildIndex writes an index of the committed state of chaincodeID to the DB
+func (state *State) buildIndex(chaincodeID string, name string, field string) error {
+	openchainDB := state.openchainDB
+	writeBatch := db.NewWriteBatch()
+	defer writeBatch.Destroy()
+	itr, err := state.stateImpl.GetRangeScanIterator(chaincodeID, "", "")
+	if err != nil {
+		return err
+	}
+	defer itr.Close()
+	var keys int
+	for itr.Next() {
+		key, value := itr.GetKeyValue()
+		if indexed, ok := indexedValue(field, value); ok {
+			writeBatch.PutCF(openchainDB.StateIndexCF, encodeIndexEntryKey(chaincodeID, name, indexed, key), []byte{})
+			keys++
+		}
+	}
+	writeBatch.PutCF(openchainDB.StateIndexCF, encodeIndexDefinitionKey(chaincodeID, name), []byte(field))
+	logger.Info("Building index [%s] of chaincode [%s] on field [%s] with %d keys", name, chaincodeID, field, keys)
+	return openchainDB.Write(writeBatch)
+}
+
+// addIndexChangesForPersistence adds to writeBatch the changes to the indexes made by delta
+func (state *State) addIndexChangesForPersistence(delta *statemgmt.StateDelta, writeBatch *db.WriteBatch) {
+	cf := state.openchainDB.StateIndexCF
+	for chaincodeID, indexes := range state.indexes {
+		for key, updatedValue := range delta.GetUpdates(chaincodeID) {
+			previousValue, value := updatedValue.GetPreviousValue(), updatedValue.GetValue()
+			if delta.RollBackwards {
+				previousValue, value = value, previousValue
+			}
+			for name, field := range indexes {
+				previous, wasIndexed := indexedValue(field, previousValue)
+				current, isIndexed := indexedValue(field, value)
+				if wasIndexed && (!isIndexed || previous != current) {
+					writeBatch.DeleteCF(cf, encodeIndexEntryKey(chaincodeID, name, previous, key))
+				}
+				if isIndexed {
+					writeBatch.PutCF(cf, encodeIndexEntryKey(chaincodeID, name, current, key), []byte{})
+				}
+			}
+		}
+	}
+}
+
+// indexResult is a key-value whose key an index scan returned
+type indexResult struct {
+	indexed string
+	key     string
+	value   []byte
+}
+
+// getIndexIterator scans an index of the db along with the changes in the deltas, each delta in
+// preference to the db and to the deltas before it. The keys whose indexed values are between
+// startValue and endValue (assuming lexical order, no upper bound if endValue is empty) are
+// returned in order of the indexed values, then of the keys
+func (state *State) getIndexIterator(chaincodeID string, name string, startValue string, endValue string,
+	deltas ...*statemgmt.StateDelta) (statemgmt.RangeScanIterator, error) {
+	field, ok := state.indexes[chaincodeID][name]
+	if !ok {
+		return nil, ErrIndexNotFound
+	}
+	inRange := func(indexed string) bool {
+		return indexed >= startValue && (endValue == "" || indexed <= endValue)
+	}
+	changes := make(map[string]*statemgmt.UpdatedValue)
+	for _, delta := range deltas {
+		for key, updatedValue := range delta.GetUpdates(chaincodeID) {
+			changes[key] = updatedValue
+		}
+	}
+
+	var results []*indexResult
+	openchainDB := state.openchainDB
+	itr := openchainDB.GetIterator(openchainDB.StateIndexCF)
+	defer itr.Close()
+	prefix := encodeIndexEntryPrefix(chaincodeID, name)
+	for itr.Seek(append(statemgmt.Copy(prefix), startValue...)); itr.ValidForPrefix(prefix); itr.Next() {
+		components := strings.SplitN(string(itr.Key()[len(prefix):]), "\x00", 2)
+		if !inRange(components[0]) {
+			break
+		}
+		if _, changed := changes[components[1]]; changed {
+			continue
+		}
+		value, err := state.stateImpl.Get(chaincodeID, components[1])
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			continue
+		}
+		results = append(results, &indexResult{components[0], components[1], value})
+	}
+	if err := itr.Err(); err != nil {
+		return nil, err
+	}
+	for key, updatedValue := range changes {
+		if indexed, ok := indexedValue(field, updatedValue.GetValue()); ok && inRange(indexed) {
+			results = append(results, &indexResult{indexed, key, updatedValue.GetValue()})
+		}
+	}
+
+	sort.Sort(indexResults(results))
+	itrResults := &queryResultIterator{index: -1}
+	for _, result := range results {
+		itrResults.keys = append(itrResults.keys, result.key)
+		itrResults.values = append(itrResults.values, result.value)
+	}
+	return itrResults, nil
+}
+
+// indexResults sorts the results of an index scan by indexed value, then by key
+type indexResults []*indexResult
+
+func (results indexResults) Len() int      { return len(results) }
+func (results indexResults) Swap(i, j int) { results[i], results[j] = results[j], results[i] }
+func (results indexResults) Less(i, j int) bool {
+	if results[i].indexed != results[j].indexed {
+		return results[i].indexed < results[j].indexed
+	}
+	return results[i].key < results[j].key
+}
+
+func encodeIndexDefinitionKey(chaincodeID string, name string) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteByte(indexDefinitionPrefix)
+	buffer.WriteString(chaincodeID)
+	buffer.WriteByte(0)
+	buffer.WriteString(name)
+	return buffer.Bytes()
+}
+
+func encodeIndexEntryPrefix(chaincodeID string, name string) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteByte(indexEntryPrefix)
+	buffer.WriteString(chaincodeID)
+	buffer.WriteByte(0)
+	buffer.WriteString(name)
+	buffer.WriteByte(0)
+	return buffer.Bytes()
+}
+
+func encodeIndexEntryKey(chaincodeID string, name string, indexed string, key string) []byte {
+	entryKey := encodeIndexEntryPrefix(chaincodeID, name)
+	entryKey = append(entryKey, indexed...)
+	entryKey = append(entryKey, 0)
+	return append(entryKey, key...)
+}
@@ -33,7 +33,7 @@ func TestStateChanges(t *testing.T) {
 	testutil.AssertEquals(t, stateTestWrapper.get("chaincode1", "key1", false), []byte("value1"))
 	testutil.AssertNil(t, stateTestWrapper.get("chaincode1", "key1", true))
 
-	delta := state.getStateDelta()
+	delta := state.GetStateDelta()
 	// save to db
 	stateTestWrapper.persistAndClearInMemoryChanges(0)
 	testutil.AssertEquals(t, stateTestWrapper.get("chaincode1", "key1", true), []byte("value1"))
@@ -55,7 +55,7 @@ func TestStateChanges(t *testing.T) {
 	state.Set("chaincode2", "key4", []byte("value4"))
 	state.TxFinish("txUuid", true)
 
-	delta = state.getStateDelta()
+	delta = state.GetStateDelta()
 	stateTestWrapper.persistAndClearInMemoryChanges(1)
 	testutil.AssertEquals(t, stateTestWrapper.fetchStateDeltaFromDB(1), delta)
 
@@ -131,7 +131,7 @@ func TestDeleteState(t *testing.T) {
 	state.Set("chaincode1", "key1", []byte("value1"))
 	state.Set("chaincode1", "key2", []byte("value2"))
 	state.TxFinish("txUuid", true)
-	state.getStateDelta()
+	state.GetStateDelta()
 	stateTestWrapper.persistAndClearInMemoryChanges(0)
 
 	// confirm keys are present
@@ -155,7 +155,7 @@ func TestDeleteState(t *testing.T) {
 	state.Set("chaincode1", "key1", []byte("value1"))
 	state.Set("chaincode1", "key2", []byte("value2"))
 	state.TxFinish("txUuid", true)
-	state.getStateDelta()
+	state.GetStateDelta()
 	stateTestWrapper.persistAndClearInMemoryChanges(1)
 
 	// confirm keys are present
@@ -169,3 +169,122 @@ func TestStateDeltaSizeSetting(t *testing.T) {
 		t.Fatalf("Error reading historyStateDeltaSize. Expected 500, but got %d", state.historyStateDeltaSize)
 	}
 }
+
+func TestPruneStateDeltas(t *testing.T) {
+	stateTestWrapper, state := createFreshDBAndConstructState(t)
+	for i := uint64(0); i < 10; i++ {
+		state.TxBegin("txUuid")
+		state.Set("chaincode1", "key1", []byte{byte(i)})
+		state.TxFinish("txUuid", true)
+		stateTestWrapper.persistAndClearInMemoryChanges(i)
+	}
+
+	pruned, err := state.PruneStateDeltas(9)
+	testutil.AssertNoError(t, err, "Error pruning state-deltas")
+	testutil.AssertEquals(t, pruned, uint64(0))
+
+	// shorten the history, as if reconfigured
+	state.historyStateDeltaSize = 3
+	pruned, err = state.PruneStateDeltas(9)
+	testutil.AssertNoError(t, err, "Error pruning state-deltas")
+	testutil.AssertEquals(t, pruned, uint64(7))
+	for i := uint64(0); i < 10; i++ {
+		delta, err := state.FetchStateDeltaFromDB(i)
+		testutil.AssertNoError(t, err, "Error fetching state-delta")
+		if i < 7 {
+			testutil.AssertNil(t, delta)
+		} else {
+			testutil.AssertNotNil(t, delta)
+		}
+	}
+	testutil.AssertEquals(t, stateTestWrapper.get("chaincode1", "key1", true), []byte{9})
+}
+
+func TestStateQuery(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		stateTestWrapper, state := createFreshDBAndConstructState(t)
+		if indexed {
+			state.documentIndex = newDocumentIndex()
+		}
+		state.TxBegin("txUuid")
+		state.Set("chaincode1", "key1", []byte(`{"owner": "alice", "size": 1}`))
+		state.Set("chaincode1", "key2", []byte(`{"owner": "bob", "size": 2}`))
+		state.Set("chaincode1", "key3", []byte(`{"owner": "alice", "size": 3}`))
+		state.Set("chaincode1", "key4", []byte("not a document"))
+		state.Set("chaincode2", "key1", []byte(`{"owner": "alice", "size": 1}`))
+		state.TxFinish("txUuid", true)
+		stateTestWrapper.persistAndClearInMemoryChanges(0)
+
+		testutil.AssertEquals(t, stateTestWrapper.query("chaincode1", `{"selector": {"owner": "alice"}}`, true),
+			[]string{"key1", "key3"})
+		testutil.AssertEquals(t, stateTestWrapper.query("chaincode1", `{"selector": {"owner": "alice"}, "limit": 1}`, true),
+			[]string{"key1"})
+
+		// the changes in memory are only seen by uncommitted queries
+		state.TxBegin("txUuid")
+		state.Set("chaincode1", "key2", []byte(`{"owner": "alice", "size": 2}`))
+		state.Delete("chaincode1", "key3")
+		state.TxFinish("txUuid", true)
+		testutil.AssertEquals(t, stateTestWrapper.query("chaincode1", `{"selector": {"owner": "alice"}}`, true),
+			[]string{"key1", "key3"})
+		testutil.AssertEquals(t, stateTestWrapper.query("chaincode1", `{"selector": {"owner": "alice"}}`, false),
+			[]string{"key1", "key2"})
+
+		stateTestWrapper.persistAndClearInMemoryChanges(1)
+		testutil.AssertEquals(t, stateTestWrapper.query("chaincode1", `{"selector": {"size": {"$gte": 2}}}`, true),
+			[]string{"key2"})
+	}
+}
+
+func TestStateIndex(t *testing.T) {
+	stateTestWrapper, state := createFreshDBAndConstructState(t)
+	state.TxBegin("txUuid")
+	state.Set("chaincode1", "key1", []byte(`{"owner": "alice", "size": 1}`))
+	state.Set("chaincode1", "key2", []byte(`{"owner": "bob", "size": 2}`))
+	state.Set("chaincode1", "key3", []byte(`{"owner": "alice", "size": 3}`))
+	state.Set("chaincode1", "key4", []byte("not a document"))
+	state.Set("chaincode2", "key1", []byte(`{"owner": "alice", "size": 1}`))
+	state.TxFinish("txUuid", true)
+	stateTestWrapper.persistAndClearInMemoryChanges(0)
+
+	_, err := state.GetIndexIterator("chaincode1", "owner", "", "", true)
+	testutil.AssertSame(t, err, ErrIndexNotFound)
+
+	// the indexes declared are built from the state
+	state.indexes = stateIndexes{"chaincode1": {"owner": "owner", "size": "size"}}
+	testutil.AssertNoError(t, state.syncIndexes(), "Error building indexes")
+	testutil.AssertEquals(t, stateTestWrapper.scanIndex("chaincode1", "owner", "alice", "alice", true),
+		[]string{"key1", "key3"})
+	testutil.AssertEquals(t, stateTestWrapper.scanIndex("chaincode1", "owner", "", "", true),
+		[]string{"key1", "key3", "key2"})
+	testutil.AssertEquals(t, stateTestWrapper.scanIndex("chaincode1", "size", "2", "", true),
+		[]string{"key2", "key3"})
+
+	// the changes in memory are only seen by uncommitted scans
+	state.TxBegin("txUuid")
+	state.Set("chaincode1", "key2", []byte(`{"owner": "alice", "size": 2}`))
+	state.Delete("chaincode1", "key3")
+	state.Set("chaincode1", "key5", []byte(`{"owner": "carol"}`))
+	state.TxFinish("txUuid", true)
+	testutil.AssertEquals(t, stateTestWrapper.scanIndex("chaincode1", "owner", "alice", "alice", true),
+		[]string{"key1", "key3"})
+	testutil.AssertEquals(t, stateTestWrapper.scanIndex("chaincode1", "owner", "alice", "alice", false),
+		[]string{"key1", "key2"})
+	testutil.AssertEquals(t, stateTestWrapper.scanIndex("chaincode1", "owner", "b", "", false),
+		[]string{"key5"})
+
+	// and the indexes are changed along with the state when committed
+	stateTestWrapper.persistAndClearInMemoryChanges(1)
+	testutil.AssertEquals(t, stateTestWrapper.scanIndex("chaincode1", "owner", "", "", true),
+		[]string{"key1", "key2", "key5"})
+	testutil.AssertEquals(t, stateTestWrapper.scanIndex("chaincode1", "size", "", "", true),
+		[]string{"key1", "key2"})
+
+	// the indexes no longer declared are dropped
+	state.indexes = stateIndexes{"chaincode1": {"owner": "owner"}}
+	testutil.AssertNoError(t, state.syncIndexes(), "Error dropping indexes")
+	state.indexes = stateIndexes{"chaincode1": {"owner": "owner", "size": "owner"}}
+	testutil.AssertNoError(t, state.syncIndexes(), "Error building indexes")
+	testutil.AssertEquals(t, stateTestWrapper.scanIndex("chaincode1", "size", "", "", true),
+		[]string{"key1", "key2", "key5"})
+}
@@ -0,0 +1,187 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/query"
+)
+
+// ErrReadConflict is returned when a simulated transaction read a key which a transaction
+// applied after the simulation started changed
+var ErrReadConflict = errors.New("state: transaction read keys changed by a previous transaction")
+
+type keyRange struct {
+	chaincodeID string
+	startKey    string
+	endKey      string
+}
+
+// TxSimulation executes a transaction on its own against the state of the current batch, so that
+// the transactions of a batch can be simulated concurrently. The simulation captures the read-write
+// set of the transaction: the keys, ranges and queries it read, and the changes it made, which
+// ApplyTxSimulation validates against the transactions applied since and applies to the batch
+type TxSimulation struct {
+	state   *State
+	txUUID  string
+	version uint64
+	lock    sync.Mutex
+
+	reads   map[string]map[string]bool
+	ranges  []keyRange
+	queries map[string]bool
+	writes  *statemgmt.StateDelta
+}
+
+// NewTxSimulation starts the simulation of transaction txUUID against the state of the current batch.
+// Simulations only read the state, they may run concurrently with each other but not with the
+// transactions of the batch
+func (state *State) NewTxSimulation(txUUID string) *TxSimulation {
+	logger.Debug("NewTxSimulation() for txUuid [%s] at version [%d]", txUUID, state.txsApplied)
+	return &TxSimulation{state: state, txUUID: txUUID, version: state.txsApplied,
+		reads: make(map[string]map[string]bool), queries: make(map[string]bool), writes: statemgmt.NewStateDelta()}
+}
+
+// GetTxUUID returns the transaction simulated
+func (sim *TxSimulation) GetTxUUID() string {
+	return sim.txUUID
+}
+
+// Get returns the value of key for chaincodeID, as changed by the transaction or else in the batch
+func (sim *TxSimulation) Get(chaincodeID string, key string) ([]byte, error) {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+	if valueHolder := sim.writes.Get(chaincodeID, key); valueHolder != nil {
+		return valueHolder.GetValue(), nil
+	}
+	keys, ok := sim.reads[chaincodeID]
+	if !ok {
+		keys = make(map[string]bool)
+		sim.reads[chaincodeID] = keys
+	}
+	keys[key] = true
+	if valueHolder := sim.state.stateDelta.Get(chaincodeID, key); valueHolder != nil {
+		return valueHolder.GetValue(), nil
+	}
+	return sim.state.getCommitted(chaincodeID, key)
+}
+
+// GetRangeScanIterator returns an iterator over the keys of chaincodeID between startKey and endKey,
+// as changed by the transaction or else in the batch
+func (sim *TxSimulation) GetRangeScanIterator(chaincodeID string, startKey string, endKey string) (statemgmt.RangeScanIterator, error) {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+	stateImplItr, err := sim.state.getCommittedRangeScanIterator(chaincodeID, startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	sim.ranges = append(sim.ranges, keyRange{chaincodeID, startKey, endKey})
+	return newCompositeRangeScanIterator(
+		statemgmt.NewStateDeltaRangeScanIterator(sim.writes, chaincodeID, startKey, endKey),
+		statemgmt.NewStateDeltaRangeScanIterator(sim.state.stateDelta, chaincodeID, startKey, endKey),
+		stateImplItr), nil
+}
+
+// GetQueryIterator returns an iterator over the keys of chaincodeID whose values match the query,
+// as changed by the transaction or else in the batch
+func (sim *TxSimulation) GetQueryIterator(chaincodeID string, q *query.Query) (statemgmt.RangeScanIterator, error) {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+	sim.queries[chaincodeID] = true
+	return sim.state.getQueryIterator(chaincodeID, q, sim.state.withPersisting(sim.state.stateDelta, sim.writes)...)
+}
+
+// GetIndexIterator returns an iterator over the keys of chaincodeID whose field of index name is
+// between startValue and endValue, as changed by the transaction or else in the batch
+func (sim *TxSimulation) GetIndexIterator(chaincodeID string, name string, startValue string, endValue string) (statemgmt.RangeScanIterator, error) {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+	sim.queries[chaincodeID] = true
+	return sim.state.getIndexIterator(chaincodeID, name, startValue, endValue, sim.state.withPersisting(sim.state.stateDelta, sim.writes)...)
+}
+
+// Set records that the transaction sets key of chaincodeID to value
+func (sim *TxSimulation) Set(chaincodeID string, key string, value []byte) {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+	sim.writes.Set(chaincodeID, key, value, nil)
+}
+
+// Delete records that the transaction deletes key of chaincodeID
+func (sim *TxSimulation) Delete(chaincodeID string, key string) {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+	sim.writes.Delete(chaincodeID, key, nil)
+}
+
+// conflicts tells whether a key the transaction read was changed since the simulation started
+func (sim *TxSimulation) conflicts(keyVersions map[string]map[string]uint64) bool {
+	for chaincodeID, keys := range sim.reads {
+		for key := range keys {
+			if keyVersions[chaincodeID][key] > sim.version {
+				return true
+			}
+		}
+	}
+	for _, r := range sim.ranges {
+		for key, version := range keyVersions[r.chaincodeID] {
+			if version > sim.version && (r.startKey == "" || key >= r.startKey) && (r.endKey == "" || key <= r.endKey) {
+				return true
+			}
+		}
+	}
+	for chaincodeID := range sim.queries {
+		for _, version := range keyVersions[chaincodeID] {
+			if version > sim.version {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ApplyTxSimulation validates the read set of the simulation against the transactions applied to
+// the batch since the simulation started and, unless they changed a key the simulation read, applies
+// its changes to the batch as a transaction. ErrReadConflict is returned otherwise
+func (state *State) ApplyTxSimulation(sim *TxSimulation) error {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+	if sim.conflicts(state.keyVersions) {
+		logger.Debug("Rejecting txUuid [%s], it read keys changed since version [%d]", sim.txUUID, sim.version)
+		return ErrReadConflict
+	}
+	state.TxBegin(sim.txUUID)
+	for _, chaincodeID := range sim.writes.GetUpdatedChaincodeIds(true) {
+		for key, updatedValue := range sim.writes.GetUpdates(chaincodeID) {
+			var err error
+			if updatedValue.IsDelete() {
+				err = state.Delete(chaincodeID, key)
+			} else {
+				err = state.Set(chaincodeID, key, updatedValue.GetValue())
+			}
+			if err != nil {
+				state.TxFinish(sim.txUUID, false)
+				return err
+			}
+		}
+	}
+	state.TxFinish(sim.txUUID, true)
+	return nil
+}
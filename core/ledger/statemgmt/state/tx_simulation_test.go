@@ -0,0 +1,118 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/query"
+	"github.com/hyperledger/fabric/core/ledger/testutil"
+)
+
+func TestTxSimulationAppliesChanges(t *testing.T) {
+	stateTestWrapper, state := createFreshDBAndConstructState(t)
+	state.TxBegin("txUuid")
+	state.Set("chaincode1", "key1", []byte("value1"))
+	state.Set("chaincode1", "key2", []byte("value2"))
+	state.TxFinish("txUuid", true)
+	stateTestWrapper.persistAndClearInMemoryChanges(0)
+
+	sim1 := state.NewTxSimulation("txUuid1")
+	sim2 := state.NewTxSimulation("txUuid2")
+
+	// a simulation reads its own changes, and the batch does not see them until applied
+	value, err := sim1.Get("chaincode1", "key1")
+	testutil.AssertNoError(t, err, "Error while reading a key")
+	testutil.AssertEquals(t, value, []byte("value1"))
+	sim1.Set("chaincode1", "key1", []byte("value1_new"))
+	sim1.Delete("chaincode1", "key2")
+	value, _ = sim1.Get("chaincode1", "key1")
+	testutil.AssertEquals(t, value, []byte("value1_new"))
+	testutil.AssertEquals(t, stateTestWrapper.get("chaincode1", "key1", false), []byte("value1"))
+
+	// the simulations do not see the changes of each other
+	value, _ = sim2.Get("chaincode1", "key2")
+	testutil.AssertEquals(t, value, []byte("value2"))
+	sim2.Set("chaincode1", "key3", []byte("value3"))
+
+	testutil.AssertNoError(t, state.ApplyTxSimulation(sim1), "Error while applying a simulation")
+	testutil.AssertEquals(t, stateTestWrapper.get("chaincode1", "key1", false), []byte("value1_new"))
+	testutil.AssertNil(t, stateTestWrapper.get("chaincode1", "key2", false))
+
+	// sim2 read key2, which sim1 deleted
+	testutil.AssertEquals(t, state.ApplyTxSimulation(sim2), ErrReadConflict)
+	testutil.AssertNil(t, stateTestWrapper.get("chaincode1", "key3", false))
+	testutil.AssertEquals(t, state.txInProgress(), false)
+
+	stateTestWrapper.persistAndClearInMemoryChanges(1)
+	testutil.AssertEquals(t, stateTestWrapper.get("chaincode1", "key1", true), []byte("value1_new"))
+	testutil.AssertNil(t, stateTestWrapper.get("chaincode1", "key3", true))
+}
+
+func TestTxSimulationConflicts(t *testing.T) {
+	_, state := createFreshDBAndConstructState(t)
+
+	testCases := []struct {
+		name     string
+		read     func(sim *TxSimulation)
+		conflict bool
+	}{
+		{"disjoint key", func(sim *TxSimulation) { sim.Get("chaincode1", "key1") }, false},
+		{"same key", func(sim *TxSimulation) { sim.Get("chaincode1", "key5") }, true},
+		{"other chaincode", func(sim *TxSimulation) { sim.Get("chaincode2", "key5") }, false},
+		{"range before", func(sim *TxSimulation) { sim.GetRangeScanIterator("chaincode1", "key1", "key4") }, false},
+		{"range over", func(sim *TxSimulation) { sim.GetRangeScanIterator("chaincode1", "key4", "key6") }, true},
+		{"range ending at", func(sim *TxSimulation) { sim.GetRangeScanIterator("chaincode1", "key1", "key5") }, true},
+		{"open range", func(sim *TxSimulation) { sim.GetRangeScanIterator("chaincode1", "key4", "") }, true},
+		{"query", func(sim *TxSimulation) {
+			q, _ := query.Parse(`{"selector": {"owner": "alice"}}`)
+			sim.GetQueryIterator("chaincode1", q)
+		}, true},
+		{"query other chaincode", func(sim *TxSimulation) {
+			q, _ := query.Parse(`{"selector": {"owner": "alice"}}`)
+			sim.GetQueryIterator("chaincode2", q)
+		}, false},
+	}
+	for _, testCase := range testCases {
+		sim := state.NewTxSimulation("txUuid")
+		testCase.read(sim)
+		sim.Set("chaincode3", testCase.name, []byte("value"))
+
+		// a transaction is applied after the simulation started
+		state.TxBegin("txUuid")
+		state.Set("chaincode1", "key5", []byte("value5"))
+		state.TxFinish("txUuid", true)
+
+		err := state.ApplyTxSimulation(sim)
+		if conflict := err == ErrReadConflict; conflict != testCase.conflict {
+			t.Fatalf("Expected conflict [%t] for test case [%s], got error [%v]", testCase.conflict, testCase.name, err)
+		}
+	}
+
+	// changes applied before the simulation started do not conflict
+	sim := state.NewTxSimulation("txUuid")
+	sim.Get("chaincode1", "key5")
+	testutil.AssertNoError(t, state.ApplyTxSimulation(sim), "Error while applying a simulation")
+
+	// nor changes of a failed transaction
+	sim = state.NewTxSimulation("txUuid")
+	sim.Get("chaincode1", "key5")
+	state.TxBegin("txUuid")
+	state.Set("chaincode1", "key5", []byte("value5_new"))
+	state.TxFinish("txUuid", false)
+	testutil.AssertNoError(t, state.ApplyTxSimulation(sim), "Error while applying a simulation")
+}
@@ -0,0 +1,113 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trie
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/hyperledger/fabric/protos"
+)
+
+// GetProof - method implementation for interface 'statemgmt.ProvableState'
+func (stateTrie *StateTrie) GetProof(snapshot db.Snapshot, chaincodeID string, key string) (*protos.StateProof, error) {
+	proof := &protos.StateProof{ChaincodeID: chaincodeID, Key: key}
+	trieProof := &protos.TrieProof{}
+	trieKey := newTrieKey(chaincodeID, key)
+	// the node of the key is not reached from a child
+	pathIndex := -1
+	for {
+		trieNode, err := fetchTrieNodeFromSnapshot(stateTrie.openchainDB, snapshot, trieKey)
+		if err != nil {
+			return nil, err
+		}
+		proofNode := &protos.StateProofNode{}
+		if trieNode != nil {
+			if pathIndex < 0 {
+				proof.Exists = trieNode.value != nil
+				proof.Value = trieNode.value
+			} else if trieNode.containsValue() {
+				proofNode.HasValue = true
+				proofNode.Value = trieNode.value
+			}
+			for _, index := range trieNode.getSortedChildrenIndex() {
+				if index != pathIndex {
+					proofNode.Children = append(proofNode.Children,
+						&protos.StateProofChild{Index: uint32(index), CryptoHash: trieNode.childrenCryptoHashes[index]})
+				}
+			}
+		}
+		trieProof.Nodes = append(trieProof.Nodes, proofNode)
+		if trieKey.isRootKey() {
+			break
+		}
+		pathIndex = trieKey.getIndexInParent()
+		trieKey = trieKey.getParentTrieKey()
+	}
+	proof.Trie = trieProof
+	return proof, nil
+}
+
+// VerifyProof computes the crypto-hash of the root of the trie from the proof that key of chaincodeID holds
+// value, or no value if exists is false. The proof holds if the crypto-hash is the state hash of a block.
+// VerifyProof does not need the DB, the trie is described by the proof
+func VerifyProof(chaincodeID string, key string, exists bool, value []byte, proof *protos.TrieProof) ([]byte, error) {
+	trieKey := newTrieKey(chaincodeID, key)
+	if len(proof.Nodes) != trieKey.getLevel()+1 {
+		return nil, fmt.Errorf("Expected [%d] trie nodes on the path of the key, found [%d]", trieKey.getLevel()+1, len(proof.Nodes))
+	}
+	var cryptoHash []byte
+	pathIndex := -1
+	for _, node := range proof.Nodes {
+		trieNode := newTrieNode(trieKey, nil, false)
+		if pathIndex < 0 {
+			if exists {
+				trieNode.value = nonNilValue(value)
+			}
+		} else if node.HasValue {
+			if trieKey.isRootKey() {
+				return nil, fmt.Errorf("The root of the trie can not hold a value")
+			}
+			trieNode.value = nonNilValue(node.Value)
+		}
+		for _, child := range node.Children {
+			index := int(child.Index)
+			_, duplicate := trieNode.childrenCryptoHashes[index]
+			if index >= trieKeyEncoderImpl.getMaxTrieWidth() || index == pathIndex || duplicate || len(child.CryptoHash) == 0 {
+				return nil, fmt.Errorf("Invalid child [%d] of trie node at level [%d]", index, trieKey.getLevel())
+			}
+			trieNode.childrenCryptoHashes[index] = child.CryptoHash
+		}
+		if pathIndex >= 0 && cryptoHash != nil {
+			trieNode.childrenCryptoHashes[pathIndex] = cryptoHash
+		}
+		cryptoHash = trieNode.computeCryptoHash()
+		if !trieKey.isRootKey() {
+			pathIndex = trieKey.getIndexInParent()
+			trieKey = trieKey.getParentTrieKey()
+		}
+	}
+	return cryptoHash, nil
+}
+
+// nonNilValue tells an empty value apart from no value, which protobuf decodes alike
+func nonNilValue(value []byte) []byte {
+	if value == nil {
+		return []byte{}
+	}
+	return value
+}
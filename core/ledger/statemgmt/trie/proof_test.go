@@ -0,0 +1,73 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trie
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/ledger/testutil"
+)
+
+func TestStateTrie_Proof(t *testing.T) {
+	testDBWrapper.CreateFreshDB(t)
+	stateTrieTestWrapper := newStateTrieTestWrapper(t)
+	stateDelta := statemgmt.NewStateDelta()
+	stateDelta.Set("chaincodeID1", "key1", []byte("value1"), nil)
+	stateDelta.Set("chaincodeID1", "key1x", []byte("value1x"), nil)
+	stateDelta.Set("chaincodeID1", "key2", []byte("value2"), nil)
+	stateDelta.Set("chaincodeID2", "key3", []byte("value3"), nil)
+	rootHash := stateTrieTestWrapper.PrepareWorkingSetAndComputeCryptoHash(stateDelta)
+	stateTrieTestWrapper.PersistChangesAndResetInMemoryChanges()
+
+	snapshot := db.GetDBHandle().GetSnapshot()
+	defer snapshot.Release()
+	verify := func(chaincodeID string, key string, exists bool, value []byte) {
+		proof, err := stateTrieTestWrapper.stateTrie.GetProof(snapshot, chaincodeID, key)
+		testutil.AssertNoError(t, err, "Error while getting proof")
+		testutil.AssertEquals(t, proof.Exists, exists)
+		testutil.AssertEquals(t, proof.Value, value)
+		cryptoHash, err := VerifyProof(chaincodeID, key, proof.Exists, proof.Value, proof.Trie)
+		testutil.AssertNoError(t, err, "Error while verifying proof")
+		testutil.AssertEquals(t, cryptoHash, rootHash)
+
+		// another value, or none, does not hash up to the root
+		cryptoHash, _ = VerifyProof(chaincodeID, key, true, []byte("tampered"), proof.Trie)
+		if bytes.Equal(cryptoHash, rootHash) {
+			t.Fatalf("Proof of a tampered value for key [%s] hashes up to the root", key)
+		}
+		if exists {
+			cryptoHash, _ = VerifyProof(chaincodeID, key, false, nil, proof.Trie)
+			if bytes.Equal(cryptoHash, rootHash) {
+				t.Fatalf("Proof of no value for key [%s] hashes up to the root", key)
+			}
+		}
+	}
+	verify("chaincodeID1", "key1", true, []byte("value1"))
+	// the node of key1 on the path holds a value
+	verify("chaincodeID1", "key1x", true, []byte("value1x"))
+	verify("chaincodeID2", "key3", true, []byte("value3"))
+	verify("chaincodeID1", "key3", false, nil)
+	// the node of key holds no value but has children
+	verify("chaincodeID1", "key", false, nil)
+
+	proof, _ := stateTrieTestWrapper.stateTrie.GetProof(snapshot, "chaincodeID1", "key2")
+	_, err := VerifyProof("chaincodeID1", "key22", true, []byte("value2"), proof.Trie)
+	testutil.AssertError(t, err, "Expected an error verifying the proof of a key against the path of another")
+}
@@ -19,18 +19,17 @@ package trie
 import (
 	"github.com/hyperledger/fabric/core/db"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
-	"github.com/tecbot/gorocksdb"
 )
 
 // StateSnapshotIterator implements the interface 'statemgmt.StateSnapshotIterator'
 type StateSnapshotIterator struct {
-	dbItr        *gorocksdb.Iterator
+	dbItr        db.Iterator
 	currentKey   []byte
 	currentValue []byte
 }
 
-func newStateSnapshotIterator(snapshot *gorocksdb.Snapshot) (*StateSnapshotIterator, error) {
-	dbItr := db.GetDBHandle().GetStateCFSnapshotIterator(snapshot)
+func newStateSnapshotIterator(openchainDB *db.OpenchainDB, snapshot db.Snapshot) (*StateSnapshotIterator, error) {
+	dbItr := openchainDB.GetStateCFSnapshotIterator(snapshot)
 	dbItr.SeekToFirst()
 	// skip the root key, because, the value test in Next method is misleading for root key as the value field
 	dbItr.Next()
@@ -44,8 +43,8 @@ func (snapshotItr *StateSnapshotIterator) Next() bool {
 
 		// making a copy of key-value bytes because, underlying key bytes are reused by itr.
 		// no need to free slices as iterator frees memory when closed.
-		trieKeyBytes := statemgmt.Copy(snapshotItr.dbItr.Key().Data())
-		trieNodeBytes := statemgmt.Copy(snapshotItr.dbItr.Value().Data())
+		trieKeyBytes := statemgmt.Copy(snapshotItr.dbItr.Key())
+		trieNodeBytes := statemgmt.Copy(snapshotItr.dbItr.Value())
 		value := unmarshalTrieNodeValue(trieNodeBytes)
 		if value != nil {
 			snapshotItr.currentKey = trieKeyEncoderImpl.decodeTrieKeyBytes(statemgmt.Copy(trieKeyBytes))
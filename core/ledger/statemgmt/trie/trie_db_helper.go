@@ -18,9 +18,8 @@ package trie
 
 import "github.com/hyperledger/fabric/core/db"
 
-func fetchTrieNodeFromDB(key *trieKey) (*trieNode, error) {
+func fetchTrieNodeFromDB(openchainDB *db.OpenchainDB, key *trieKey) (*trieNode, error) {
 	stateTrieLogger.Debug("Enter fetchTrieNodeFromDB() for trieKey [%s]", key)
-	openchainDB := db.GetDBHandle()
 	trieNodeBytes, err := openchainDB.GetFromStateCF(key.getEncodedBytes())
 	if err != nil {
 		stateTrieLogger.Error("Error in retrieving trie node from DB for triekey [%s]. Error:%s", key, err)
@@ -39,3 +38,17 @@ func fetchTrieNodeFromDB(key *trieKey) (*trieNode, error) {
 	stateTrieLogger.Debug("Exit fetchTrieNodeFromDB() for trieKey [%s]", key)
 	return trieNode, nil
 }
+
+func fetchTrieNodeFromSnapshot(openchainDB *db.OpenchainDB, snapshot db.Snapshot, key *trieKey) (*trieNode, error) {
+	stateTrieLogger.Debug("Enter fetchTrieNodeFromSnapshot() for trieKey [%s]", key)
+	trieNodeBytes, err := openchainDB.GetFromStateCFSnapshot(snapshot, key.getEncodedBytes())
+	if err != nil {
+		stateTrieLogger.Error("Error in retrieving trie node from DB snapshot for triekey [%s]. Error:%s", key, err)
+		return nil, err
+	}
+
+	if trieNodeBytes == nil {
+		return nil, nil
+	}
+	return unmarshalTrieNode(key, trieNodeBytes)
+}
@@ -0,0 +1,59 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/hyperledger/fabric/core/ledger"
+)
+
+// ChainIDMetadataKey is the gRPC metadata key carrying the ID of the chain
+// a request to the Openchain or Admin service is for. The requests without
+// it are for the default chain.
+const ChainIDMetadataKey = "chainid"
+
+// NewChainContext returns a context for the requests on the chain with the
+// given ID, the empty ID being the default chain
+func NewChainContext(ctx context.Context, chainID string) context.Context {
+	if chainID == "" {
+		return ctx
+	}
+	return metadata.NewContext(ctx, metadata.Pairs(ChainIDMetadataKey, chainID))
+}
+
+// GetChainIDFromContext returns the ID of the chain a request is for, empty
+// for the default chain
+func GetChainIDFromContext(ctx context.Context) string {
+	md, ok := metadata.FromContext(ctx)
+	if !ok || len(md[ChainIDMetadataKey]) == 0 {
+		return ""
+	}
+	return md[ChainIDMetadataKey][0]
+}
+
+// GetChainLedger returns the ledger of the chain with the given ID, which
+// the peer must keep
+func GetChainLedger(chainID string) (*ledger.Ledger, error) {
+	if !HostsChain(chainID) {
+		return nil, fmt.Errorf("The peer does not keep the ledger of chain %s", chainID)
+	}
+	return ledger.GetChainLedger(chainID)
+}
@@ -0,0 +1,91 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestChainValidators(t *testing.T) {
+	id, enabled := viper.GetString("peer.id"), viper.GetBool("peer.validator.enabled")
+	defer func() {
+		viper.Set("peer.id", id)
+		viper.Set("peer.validator.enabled", enabled)
+		viper.Set("peer.chains", []string{})
+		CacheConfiguration()
+	}()
+	viper.Set("peer.id", "vp1")
+	viper.Set("peer.validator.enabled", true)
+	viper.Set("peer.chains", []string{"chain1", "chain.2"})
+	viper.Set("peer.chainValidators.chain1", []string{"vp1", "vp2", "vp3", "vp4"})
+	viper.Set("peer.chainValidators", map[string]interface{}{"chain.2": []string{"vp0", "vp1", "vp2", "vp3"}})
+	if err := CacheConfiguration(); err != nil {
+		t.Fatalf("Error caching the configuration: %s", err)
+	}
+
+	if !HostsChain("") || !HostsChain("chain1") || !HostsChain("chain.2") || HostsChain("chain3") {
+		t.Fatal("The peer must keep the ledgers of the default chain, chain1 and chain.2 only")
+	}
+	if !IsChainValidator("", &pb.PeerID{Name: "vp0"}) {
+		t.Fatal("Every validating peer must be a validator of the default chain")
+	}
+	if !IsChainValidator("chain1", &pb.PeerID{Name: "vp4"}) || IsChainValidator("chain1", &pb.PeerID{Name: "vp0"}) || IsChainValidator("chain1", nil) {
+		t.Fatal("The validators of chain1 must be vp1 to vp4")
+	}
+	if !IsChainValidator("chain.2", &pb.PeerID{Name: "vp0"}) || IsChainValidator("chain.2", &pb.PeerID{Name: "vp4"}) {
+		t.Fatal("The validators of chain.2 must be vp0 to vp3")
+	}
+	if validators := GetChainValidators("chain1"); len(validators) != 4 || validators[0] != "vp1" {
+		t.Fatalf("Got validators %v of chain1, expected them in configuration order", validators)
+	}
+
+	// a validating peer is a validator of every chain it lists
+	viper.Set("peer.id", "vp0")
+	if err := CacheConfiguration(); err == nil {
+		t.Fatal("A validating peer must be a validator of every chain it lists")
+	}
+	viper.Set("peer.validator.enabled", false)
+	if err := CacheConfiguration(); err != nil {
+		t.Fatalf("Error caching the configuration of a non-validating peer: %s", err)
+	}
+
+	viper.Set("peer.chainValidators.chain1", []string{"vp1", "vp1"})
+	if err := CacheConfiguration(); err == nil {
+		t.Fatal("A validator must be listed once")
+	}
+	viper.Set("peer.chains", []string{"chain3"})
+	if err := CacheConfiguration(); err == nil {
+		t.Fatal("Every chain must have validators")
+	}
+}
+
+func TestChainContext(t *testing.T) {
+	if chainID := GetChainIDFromContext(context.Background()); chainID != "" {
+		t.Fatalf("Got chain [%s] from a context without chain, expected the default chain", chainID)
+	}
+	if chainID := GetChainIDFromContext(NewChainContext(context.Background(), "chain1")); chainID != "chain1" {
+		t.Fatalf("Got chain [%s] from the context of chain1", chainID)
+	}
+	if _, err := GetChainLedger("chain1"); err == nil {
+		t.Fatal("Got the ledger of a chain the peer does not keep")
+	}
+}
@@ -33,6 +33,7 @@ import (
 	"fmt"
 	"net"
 
+	"github.com/spf13/cast"
 	"github.com/spf13/viper"
 
 	pb "github.com/hyperledger/fabric/protos"
@@ -56,6 +57,11 @@ var syncBlocksChannelSize int
 var validatorEnabled bool
 var tlsEnabled bool
 
+// The peer IDs of the validators of each chain the peer keeps a ledger of
+// besides the default chain, by chain ID, and their error value
+var chainValidators map[string][]string
+var chainValidatorsError error
+
 // Note: There is some kind of circular import issue that prevents us from
 // importing the "core" package into the "peer" package. The
 // 'peer.SecurityEnabled' bit is a duplicate of the 'core.SecurityEnabled'
@@ -123,16 +129,49 @@ func CacheConfiguration() (err error) {
 
 	securityEnabled = viper.GetBool("security.enabled")
 
+	chainValidators, chainValidatorsError = getChainValidators()
+
 	configurationCached = true
 
 	if localAddressError != nil {
 		return localAddressError
 	} else if peerEndpointError != nil {
 		return peerEndpointError
+	} else if chainValidatorsError != nil {
+		return chainValidatorsError
 	}
 	return
 }
 
+// getChainValidators returns the validators of the chains listed in
+// peer.chains, set by peer ID in peer.chainValidators.<chainID>. A
+// validating peer must be a validator of every chain it lists.
+func getChainValidators() (map[string][]string, error) {
+	validators := make(map[string][]string)
+	for _, chainID := range viper.GetStringSlice("peer.chains") {
+		ids := viper.GetStringSlice("peer.chainValidators." + chainID)
+		if len(ids) == 0 {
+			// The IDs with a '.' are not found as a key path
+			ids = cast.ToStringSlice(viper.GetStringMap("peer.chainValidators")[chainID])
+		}
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("No validators of chain %s in peer.chainValidators.%s", chainID, chainID)
+		}
+		members := make(map[string]bool)
+		for _, id := range ids {
+			if members[id] {
+				return nil, fmt.Errorf("Validator %s listed twice in peer.chainValidators.%s", id, chainID)
+			}
+			members[id] = true
+		}
+		validators[chainID] = ids
+		if viper.GetBool("peer.validator.enabled") && !members[viper.GetString("peer.id")] {
+			return nil, fmt.Errorf("The validating peer %s is not a validator of chain %s in peer.chainValidators.%s", viper.GetString("peer.id"), chainID, chainID)
+		}
+	}
+	return validators, nil
+}
+
 // cacheConfiguration logs an error if error checks have failed.
 func cacheConfiguration() {
 	if err := CacheConfiguration(); err != nil {
@@ -205,6 +244,47 @@ func TLSEnabled() bool {
 	return tlsEnabled
 }
 
+// HostsChain returns true if the peer keeps the ledger of the chain with the
+// given ID, the empty ID being the default chain
+func HostsChain(chainID string) bool {
+	if !configurationCached {
+		cacheConfiguration()
+	}
+	_, ok := chainValidators[chainID]
+	return chainID == "" || ok
+}
+
+// IsChainValidator returns true if the validating peer with the given ID is
+// a validator of the chain with the given ID. Every validating peer is a
+// validator of the default chain.
+func IsChainValidator(chainID string, peerID *pb.PeerID) bool {
+	if !configurationCached {
+		cacheConfiguration()
+	}
+	if chainID == "" {
+		return true
+	}
+	if peerID == nil {
+		return false
+	}
+	for _, id := range chainValidators[chainID] {
+		if id == peerID.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// GetChainValidators returns the peer IDs of the validators of the chain
+// with the given ID in the order of peer.chainValidators, nil for the
+// default chain whose validators are all the validating peers
+func GetChainValidators(chainID string) []string {
+	if !configurationCached {
+		cacheConfiguration()
+	}
+	return chainValidators[chainID]
+}
+
 // SecurityEnabled returns the security.enabled property
 func SecurityEnabled() bool {
 	if !configurationCached {
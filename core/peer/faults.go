@@ -0,0 +1,206 @@
+// +build faults
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// faultScenario lists the faults injected in the messages the peer sends,
+// read from the file of peer.faults.scenario. The same file may be given
+// to all the peers of a network, the rules apply to the peers they name.
+type faultScenario struct {
+	Seed  int64       `yaml:"seed"` // seeds the draws, to repeat an experiment
+	Rules []faultRule `yaml:"rules"`
+}
+
+// faultRule injects faults in the messages of some types sent from some
+// peers to others, for a window of time. The first rule matching a message
+// applies to it.
+type faultRule struct {
+	Types     []string      `yaml:"types"`     // message types, such as CONSENSUS, all if empty
+	From      []string      `yaml:"from"`      // IDs of the sending peers, all if empty
+	To        []string      `yaml:"to"`        // IDs of the receiving peers, all if empty
+	After     time.Duration `yaml:"after"`     // start of the window, since the first message sent
+	Until     time.Duration `yaml:"until"`     // end of the window, never if 0
+	Drop      float64       `yaml:"drop"`      // probability to drop a message
+	Duplicate float64       `yaml:"duplicate"` // probability to send a message twice
+	Corrupt   float64       `yaml:"corrupt"`   // probability to flip a byte of the payload
+	Delay     time.Duration `yaml:"delay"`     // delay of every message
+	Jitter    time.Duration `yaml:"jitter"`    // random delay added to delay, up to jitter
+}
+
+// faultInjector applies a scenario to the messages of a peer
+type faultInjector struct {
+	lock  sync.Mutex
+	rand  *rand.Rand
+	self  string
+	start time.Time
+	rules []faultRule
+}
+
+var (
+	injector     *faultInjector
+	injectorOnce sync.Once
+)
+
+// sendWithFaults sends a message through the stream of a handler, once the
+// faults of the scenario of the peer are injected
+func sendWithFaults(d *Handler, msg *pb.Message) error {
+	injectorOnce.Do(func() {
+		path := viper.GetString("peer.faults.scenario")
+		if path == "" {
+			return
+		}
+		var err error
+		if injector, err = loadFaultInjector(path, viper.GetString("peer.id")); err != nil {
+			panic(err)
+		}
+		peerLogger.Warning("Injecting the faults of scenario %s in the messages sent", path)
+	})
+	if injector == nil {
+		return d.sendMessage(msg)
+	}
+	to := ""
+	if endpoint, err := d.To(); err == nil && endpoint.ID != nil {
+		to = endpoint.ID.Name
+	}
+	return injector.send(to, msg, d.sendMessage)
+}
+
+func loadFaultInjector(path string, self string) (*faultInjector, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read fault scenario: %s", err)
+	}
+	scenario := &faultScenario{}
+	if err = yaml.Unmarshal(raw, scenario); err != nil {
+		return nil, fmt.Errorf("Could not parse fault scenario %s: %s", path, err)
+	}
+	return newFaultInjector(scenario, self)
+}
+
+func newFaultInjector(scenario *faultScenario, self string) (*faultInjector, error) {
+	for i, rule := range scenario.Rules {
+		for _, typ := range rule.Types {
+			if _, ok := pb.Message_Type_value[typ]; !ok {
+				return nil, fmt.Errorf("Rule %d of the fault scenario has unknown message type %s", i, typ)
+			}
+		}
+	}
+	return &faultInjector{
+		rand:  rand.New(rand.NewSource(scenario.Seed)),
+		self:  self,
+		start: time.Now(),
+		rules: scenario.Rules,
+	}, nil
+}
+
+// match returns the rule applying to a message sent to a peer, nil if none
+func (fi *faultInjector) match(to string, msg *pb.Message, elapsed time.Duration) *faultRule {
+	for i := range fi.rules {
+		rule := &fi.rules[i]
+		if elapsed < rule.After || (rule.Until > 0 && elapsed >= rule.Until) {
+			continue
+		}
+		if matches(rule.Types, msg.Type.String()) && matches(rule.From, fi.self) && matches(rule.To, to) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func matches(names []string, name string) bool {
+	if len(names) == 0 {
+		return true
+	}
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// send sends a message with send, after it dropped, duplicated, corrupted
+// or delayed it as the rule matching it draws. Delayed messages are sent
+// asynchronously, which may reorder them.
+func (fi *faultInjector) send(to string, msg *pb.Message, send func(*pb.Message) error) error {
+	rule := fi.match(to, msg, time.Since(fi.start))
+	if rule == nil {
+		return send(msg)
+	}
+
+	fi.lock.Lock()
+	drop := fi.rand.Float64() < rule.Drop
+	copies := 1
+	if fi.rand.Float64() < rule.Duplicate {
+		copies = 2
+	}
+	corrupt := -1
+	if fi.rand.Float64() < rule.Corrupt && len(msg.Payload) > 0 {
+		corrupt = fi.rand.Intn(len(msg.Payload))
+	}
+	delay := rule.Delay
+	if rule.Jitter > 0 {
+		delay += time.Duration(fi.rand.Int63n(int64(rule.Jitter)))
+	}
+	fi.lock.Unlock()
+
+	if drop {
+		peerLogger.Debug("Dropping %s message to %s", msg.Type, to)
+		return nil
+	}
+	if corrupt >= 0 {
+		peerLogger.Debug("Corrupting byte %d of %s message to %s", corrupt, msg.Type, to)
+		msg = proto.Clone(msg).(*pb.Message)
+		msg.Payload[corrupt] ^= 0xff
+	}
+	if copies > 1 {
+		peerLogger.Debug("Duplicating %s message to %s", msg.Type, to)
+	}
+
+	if delay == 0 {
+		for i := 0; i < copies; i++ {
+			if err := send(msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	go func() {
+		time.Sleep(delay)
+		for i := 0; i < copies; i++ {
+			if err := send(msg); err != nil {
+				peerLogger.Warning("Error sending %s message to %s delayed by %v: %s", msg.Type, to, delay, err)
+			}
+		}
+	}()
+	return nil
+}
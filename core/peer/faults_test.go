@@ -0,0 +1,96 @@
+// +build faults
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestFaultScenario(t *testing.T) {
+	raw := []byte(`
+seed: 7
+rules:
+  - types: [CONSENSUS]
+    from: [vp0]
+    to: [vp1]
+    drop: 1
+  - types: [CONSENSUS, SYNC_BLOCKS]
+    after: 1s
+    until: 2s
+    duplicate: 1
+    corrupt: 1
+`)
+	scenario := &faultScenario{}
+	if err := yaml.Unmarshal(raw, scenario); err != nil {
+		t.Fatalf("Could not parse scenario: %s", err)
+	}
+	fi, err := newFaultInjector(scenario, "vp0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []*pb.Message
+	send := func(msg *pb.Message) error {
+		sent = append(sent, msg)
+		return nil
+	}
+	msg := &pb.Message{Type: pb.Message_CONSENSUS, Payload: []byte("payload")}
+
+	fi.send("vp1", msg, send)
+	if len(sent) != 0 {
+		t.Fatalf("Expected the message to vp1 to be dropped, %d were sent", len(sent))
+	}
+	fi.send("vp2", msg, send)
+	if len(sent) != 1 || sent[0] != msg {
+		t.Fatalf("Expected the message to vp2 to be sent untouched before the window of the second rule")
+	}
+
+	sent = nil
+	fi.start = time.Now().Add(-1500 * time.Millisecond)
+	fi.send("vp2", msg, send)
+	if len(sent) != 2 {
+		t.Fatalf("Expected the message to be duplicated, %d were sent", len(sent))
+	}
+	if bytes.Equal(sent[0].Payload, msg.Payload) || !bytes.Equal(msg.Payload, []byte("payload")) {
+		t.Fatal("Expected a corrupted copy of the message to be sent")
+	}
+
+	if _, err := newFaultInjector(&faultScenario{Rules: []faultRule{{Types: []string{"GOSSIP"}}}}, "vp0"); err == nil {
+		t.Fatal("Expected a scenario with an unknown message type to be rejected")
+	}
+}
+
+func TestFaultDelay(t *testing.T) {
+	fi, _ := newFaultInjector(&faultScenario{Rules: []faultRule{{Delay: 50 * time.Millisecond}}}, "vp0")
+	sent := make(chan time.Time, 1)
+	start := time.Now()
+	fi.send("vp1", &pb.Message{Type: pb.Message_SYNC_BLOCK_ADDED}, func(msg *pb.Message) error {
+		sent <- time.Now()
+		return nil
+	})
+	if at := <-sent; at.Sub(start) < 50*time.Millisecond {
+		t.Fatalf("Expected the message to be delayed by 50ms, sent after %v", at.Sub(start))
+	}
+}
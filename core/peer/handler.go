@@ -26,23 +26,23 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/state"
 	pb "github.com/hyperledger/fabric/protos"
 )
 
 // Handler peer handler implementation.
 type Handler struct {
-	chatMutex                     sync.Mutex
-	ToPeerEndpoint                *pb.PeerEndpoint
-	Coordinator                   MessageHandlerCoordinator
-	ChatStream                    ChatStream
-	doneChan                      chan struct{}
-	FSM                           *fsm.FSM
-	initiatedStream               bool // Was the stream initiated within this Peer
-	registered                    bool
-	syncBlocks                    chan *pb.SyncBlocks
-	snapshotRequestHandler        *syncStateSnapshotRequestHandler
-	syncStateDeltasRequestHandler *syncStateDeltasHandler
-	syncBlocksRequestHandler      *syncBlocksRequestHandler
+	chatMutex        sync.Mutex
+	ToPeerEndpoint   *pb.PeerEndpoint
+	Coordinator      MessageHandlerCoordinator
+	ChatStream       ChatStream
+	doneChan         chan struct{}
+	FSM              *fsm.FSM
+	initiatedStream  bool // Was the stream initiated within this Peer
+	registered       bool
+	syncBlocks       chan *pb.SyncBlocks
+	syncHandlers     map[string]*chainSyncHandlers // handlers of the sync requests sent for each chain
+	syncHandlersLock sync.Mutex
 }
 
 // NewPeerHandler returns a new Peer handler
@@ -56,9 +56,7 @@ func NewPeerHandler(coord MessageHandlerCoordinator, stream ChatStream, initiate
 	}
 	d.doneChan = make(chan struct{})
 
-	d.snapshotRequestHandler = newSyncStateSnapshotRequestHandler()
-	d.syncStateDeltasRequestHandler = newSyncStateDeltasHandler()
-	d.syncBlocksRequestHandler = newSyncBlocksRequestHandler()
+	d.syncHandlers = map[string]*chainSyncHandlers{"": newChainSyncHandlers()}
 	d.FSM = fsm.NewFSM(
 		"created",
 		fsm.Events{
@@ -266,6 +264,10 @@ func (d *Handler) HandleMessage(msg *pb.Message) error {
 
 // SendMessage sends a message to the remote PEER through the stream
 func (d *Handler) SendMessage(msg *pb.Message) error {
+	return sendWithFaults(d, msg)
+}
+
+func (d *Handler) sendMessage(msg *pb.Message) error {
 	//make sure Sends are serialized. Also make sure everyone uses SendMessage
 	//instead of calling Send directly on the grpc stream
 	d.chatMutex.Lock()
@@ -349,14 +351,80 @@ func (d *Handler) start() error {
 	}
 }
 
+// syncLedger is the ledger the sync requests for a chain are served from
+type syncLedger interface {
+	GetBlockByNumber(blockNumber uint64) (*pb.Block, error)
+	GetStateSnapshot() (*state.StateSnapshot, error)
+	GetStateDelta(blockNumber uint64) (*statemgmt.StateDelta, error)
+}
+
+// getSyncLedger returns the ledger the sync requests of the other
+// PeerEndpoint for the chain with the given ID are served from. Only the
+// validators of a chain are served its ledger.
+func (d *Handler) getSyncLedger(chainID string) (syncLedger, error) {
+	if chainID == "" {
+		return d.Coordinator, nil
+	}
+	if d.ToPeerEndpoint == nil || !IsChainValidator(chainID, d.ToPeerEndpoint.ID) {
+		return nil, fmt.Errorf("The peer %v is not a validator of chain %s", d.ToPeerEndpoint, chainID)
+	}
+	return GetChainLedger(chainID)
+}
+
+// getSyncHandlers returns the handlers of the sync requests sent to the
+// other PeerEndpoint for the chain with the given ID
+func (d *Handler) getSyncHandlers(chainID string) *chainSyncHandlers {
+	d.syncHandlersLock.Lock()
+	defer d.syncHandlersLock.Unlock()
+	sh, ok := d.syncHandlers[chainID]
+	if !ok {
+		sh = newChainSyncHandlers()
+		d.syncHandlers[chainID] = sh
+	}
+	return sh
+}
+
+// GetChainRemoteLedger returns the RemoteLedger of the other PeerEndpoint
+// for the chain with the given ID, the empty ID being the default chain
+func (d *Handler) GetChainRemoteLedger(chainID string) RemoteLedger {
+	return &chainRemoteLedger{handler: d, chainID: chainID}
+}
+
+// chainRemoteLedger requests the blocks and state of a chain from the other PeerEndpoint
+type chainRemoteLedger struct {
+	handler *Handler
+	chainID string
+}
+
+// RequestBlocks requests the blocks of the chain, see Handler.RequestBlocks
+func (l *chainRemoteLedger) RequestBlocks(syncBlockRange *pb.SyncBlockRange) (<-chan *pb.SyncBlocks, error) {
+	return l.handler.requestBlocks(l.chainID, syncBlockRange)
+}
+
+// RequestStateSnapshot requests the state of the chain, see Handler.RequestStateSnapshot
+func (l *chainRemoteLedger) RequestStateSnapshot(chunk, chunks uint32) (<-chan *pb.SyncStateSnapshot, error) {
+	return l.handler.requestStateSnapshot(l.chainID, chunk, chunks)
+}
+
+// RequestStateDeltas requests the state deltas of the chain, see Handler.RequestStateDeltas
+func (l *chainRemoteLedger) RequestStateDeltas(syncBlockRange *pb.SyncBlockRange) (<-chan *pb.SyncStateDeltas, error) {
+	return l.handler.requestStateDeltas(l.chainID, syncBlockRange)
+}
+
 // RequestBlocks get the blocks from the other PeerEndpoint based upon supplied SyncBlockRange, will provide them through the returned channel.
 // this will also stop writing any received blocks to channels created from Prior calls to RequestBlocks(..)
 func (d *Handler) RequestBlocks(syncBlockRange *pb.SyncBlockRange) (<-chan *pb.SyncBlocks, error) {
-	d.syncBlocksRequestHandler.Lock()
-	defer d.syncBlocksRequestHandler.Unlock()
+	return d.requestBlocks("", syncBlockRange)
+}
 
-	d.syncBlocksRequestHandler.reset()
-	syncBlockRange.CorrelationId = d.syncBlocksRequestHandler.correlationID
+// requestBlocks sends the request of RequestBlocks for the chain with the given ID
+func (d *Handler) requestBlocks(chainID string, syncBlockRange *pb.SyncBlockRange) (<-chan *pb.SyncBlocks, error) {
+	sh := d.getSyncHandlers(chainID)
+	sh.syncBlocksRequestHandler.Lock()
+	defer sh.syncBlocksRequestHandler.Unlock()
+
+	sh.syncBlocksRequestHandler.reset()
+	syncBlockRange.CorrelationId = sh.syncBlocksRequestHandler.correlationID
 
 	// Marshal the SyncBlockRange as the payload
 	syncBlockRangeBytes, err := proto.Marshal(syncBlockRange)
@@ -364,10 +432,10 @@ func (d *Handler) RequestBlocks(syncBlockRange *pb.SyncBlockRange) (<-chan *pb.S
 		return nil, fmt.Errorf("Error marshaling syncBlockRange during GetBlocks: %s", err)
 	}
 	peerLogger.Debug("Sending %s with Range %s", pb.Message_SYNC_GET_BLOCKS.String(), syncBlockRange)
-	if err := d.SendMessage(&pb.Message{Type: pb.Message_SYNC_GET_BLOCKS, Payload: syncBlockRangeBytes}); err != nil {
+	if err := d.SendMessage(&pb.Message{Type: pb.Message_SYNC_GET_BLOCKS, Payload: syncBlockRangeBytes, ChainID: chainID}); err != nil {
 		return nil, fmt.Errorf("Error sending %s during GetBlocks: %s", pb.Message_SYNC_GET_BLOCKS, err)
 	}
-	return d.syncBlocksRequestHandler.channel, nil
+	return sh.syncBlocksRequestHandler.channel, nil
 }
 
 func (d *Handler) beforeSyncGetBlocks(e *fsm.Event) {
@@ -385,7 +453,7 @@ func (d *Handler) beforeSyncGetBlocks(e *fsm.Event) {
 		return
 	}
 
-	go d.sendBlocks(syncBlockRange)
+	go d.sendBlocks(msg.ChainID, syncBlockRange)
 }
 
 func (d *Handler) beforeSyncBlocks(e *fsm.Event) {
@@ -405,6 +473,7 @@ func (d *Handler) beforeSyncBlocks(e *fsm.Event) {
 
 	peerLogger.Debug("Sending block onto channel for start = %d and end = %d", syncBlocks.Range.Start, syncBlocks.Range.End)
 
+	sh := d.getSyncHandlers(msg.ChainID)
 	// Send the message onto the channel, allow for the fact that channel may be closed on send attempt.
 	defer func() {
 		if x := recover(); x != nil {
@@ -412,25 +481,30 @@ func (d *Handler) beforeSyncBlocks(e *fsm.Event) {
 		}
 	}()
 
-	d.syncBlocksRequestHandler.Lock()
-	defer d.syncBlocksRequestHandler.Unlock()
+	sh.syncBlocksRequestHandler.Lock()
+	defer sh.syncBlocksRequestHandler.Unlock()
 	// Use non-blocking send, will WARN if missed message.
-	if d.syncBlocksRequestHandler.shouldHandle(syncBlocks.Range.CorrelationId) {
+	if sh.syncBlocksRequestHandler.shouldHandle(syncBlocks.Range.CorrelationId) {
 		select {
-		case d.syncBlocksRequestHandler.channel <- syncBlocks:
+		case sh.syncBlocksRequestHandler.channel <- syncBlocks:
 		default:
 			peerLogger.Warning("Did NOT send SyncBlocks message to channel for range: %d - %d", syncBlocks.Range.Start, syncBlocks.Range.End)
-			d.syncBlocksRequestHandler.reset()
+			sh.syncBlocksRequestHandler.reset()
 		}
 	} else {
 		//Ignore the message, does not match the current correlationId
-		peerLogger.Warning("Ignoring SyncBlocks message with correlationId = %d, blocks %d to %d, as current correlationId = %d", syncBlocks.Range.CorrelationId, syncBlocks.Range.Start, syncBlocks.Range.End, d.syncBlocksRequestHandler.correlationID)
+		peerLogger.Warning("Ignoring SyncBlocks message with correlationId = %d, blocks %d to %d, as current correlationId = %d", syncBlocks.Range.CorrelationId, syncBlocks.Range.Start, syncBlocks.Range.End, sh.syncBlocksRequestHandler.correlationID)
 	}
 }
 
 // sendBlocks sends the blocks based upon the supplied SyncBlockRange over the stream.
-func (d *Handler) sendBlocks(syncBlockRange *pb.SyncBlockRange) {
+func (d *Handler) sendBlocks(chainID string, syncBlockRange *pb.SyncBlockRange) {
 	peerLogger.Debug("Sending blocks %d-%d", syncBlockRange.Start, syncBlockRange.End)
+	syncLedger, err := d.getSyncLedger(chainID)
+	if err != nil {
+		peerLogger.Error(fmt.Sprintf("Error serving sync request: %s", err))
+		return
+	}
 	var blockNums []uint64
 	if syncBlockRange.Start > syncBlockRange.End {
 		// Send in reverse order
@@ -447,7 +521,7 @@ func (d *Handler) sendBlocks(syncBlockRange *pb.SyncBlockRange) {
 	}
 	for _, currBlockNum := range blockNums {
 		// Get the Block from
-		block, err := d.Coordinator.GetBlockByNumber(currBlockNum)
+		block, err := syncLedger.GetBlockByNumber(currBlockNum)
 		if err != nil {
 			peerLogger.Error(fmt.Sprintf("Error sending blockNum %d: %s", currBlockNum, err))
 			break
@@ -459,7 +533,7 @@ func (d *Handler) sendBlocks(syncBlockRange *pb.SyncBlockRange) {
 			peerLogger.Error(fmt.Sprintf("Error marshalling syncBlocks for BlockNum = %d: %s", currBlockNum, err))
 			break
 		}
-		if err := d.SendMessage(&pb.Message{Type: pb.Message_SYNC_BLOCKS, Payload: syncBlocksBytes}); err != nil {
+		if err := d.SendMessage(&pb.Message{Type: pb.Message_SYNC_BLOCKS, Payload: syncBlocksBytes, ChainID: chainID}); err != nil {
 			peerLogger.Error(fmt.Sprintf("Error sending blockNum %d: %s", currBlockNum, err))
 			break
 		}
@@ -475,24 +549,31 @@ func (d *Handler) sendBlocks(syncBlockRange *pb.SyncBlockRange) {
 
 // RequestStateSnapshot request the state snapshot deltas from the other PeerEndpoint, will provide them through the returned channel.
 // this will also stop writing any received syncStateSnapshot(s) to channels created from Prior calls to RequestStateSnapshot()
-func (d *Handler) RequestStateSnapshot() (<-chan *pb.SyncStateSnapshot, error) {
-	d.snapshotRequestHandler.Lock()
-	defer d.snapshotRequestHandler.Unlock()
+// When chunks is not 0, only the chunk of the state out of chunks is requested, see StateSnapshotChunk
+func (d *Handler) RequestStateSnapshot(chunk, chunks uint32) (<-chan *pb.SyncStateSnapshot, error) {
+	return d.requestStateSnapshot("", chunk, chunks)
+}
+
+// requestStateSnapshot sends the request of RequestStateSnapshot for the chain with the given ID
+func (d *Handler) requestStateSnapshot(chainID string, chunk, chunks uint32) (<-chan *pb.SyncStateSnapshot, error) {
+	sh := d.getSyncHandlers(chainID)
+	sh.snapshotRequestHandler.Lock()
+	defer sh.snapshotRequestHandler.Unlock()
 	// Reset the handler
-	d.snapshotRequestHandler.reset()
+	sh.snapshotRequestHandler.reset()
 
 	// Create the syncStateSnapshotRequest
-	syncStateSnapshotRequest := d.snapshotRequestHandler.createRequest()
+	syncStateSnapshotRequest := sh.snapshotRequestHandler.createRequest(chunk, chunks)
 	syncStateSnapshotRequestBytes, err := proto.Marshal(syncStateSnapshotRequest)
 	if err != nil {
 		return nil, fmt.Errorf("Error marshaling syncStateSnapshotRequest during GetStateSnapshot: %s", err)
 	}
 	peerLogger.Debug("Sending %s with syncStateSnapshotRequest = %s", pb.Message_SYNC_STATE_GET_SNAPSHOT.String(), syncStateSnapshotRequest)
-	if err := d.SendMessage(&pb.Message{Type: pb.Message_SYNC_STATE_GET_SNAPSHOT, Payload: syncStateSnapshotRequestBytes}); err != nil {
+	if err := d.SendMessage(&pb.Message{Type: pb.Message_SYNC_STATE_GET_SNAPSHOT, Payload: syncStateSnapshotRequestBytes, ChainID: chainID}); err != nil {
 		return nil, fmt.Errorf("Error sending %s during GetStateSnapshot: %s", pb.Message_SYNC_STATE_GET_SNAPSHOT, err)
 	}
 
-	return d.snapshotRequestHandler.channel, nil
+	return sh.snapshotRequestHandler.channel, nil
 }
 
 // beforeSyncStateGetSnapshot triggers the sending of State Snapshot deltas to remote Peer.
@@ -512,7 +593,7 @@ func (d *Handler) beforeSyncStateGetSnapshot(e *fsm.Event) {
 	}
 
 	// Start a separate go FUNC to send the State snapshot
-	go d.sendStateSnapshot(syncStateSnapshotRequest)
+	go d.sendStateSnapshot(msg.ChainID, syncStateSnapshotRequest)
 }
 
 // beforeSyncStateSnapshot will write the State Snapshot deltas to the respective channel.
@@ -531,6 +612,7 @@ func (d *Handler) beforeSyncStateSnapshot(e *fsm.Event) {
 		return
 	}
 
+	sh := d.getSyncHandlers(msg.ChainID)
 	// Send the message onto the channel, allow for the fact that channel may be closed on send attempt.
 	defer func() {
 		if x := recover(); x != nil {
@@ -538,29 +620,34 @@ func (d *Handler) beforeSyncStateSnapshot(e *fsm.Event) {
 		}
 	}()
 	// Use non-blocking send, will WARN and close channel if missed message.
-	d.snapshotRequestHandler.Lock()
-	defer d.snapshotRequestHandler.Unlock()
+	sh.snapshotRequestHandler.Lock()
+	defer sh.snapshotRequestHandler.Unlock()
 	// Make sure the correlationID matches
-	if d.snapshotRequestHandler.shouldHandle(syncStateSnapshot.Request.CorrelationId) {
+	if sh.snapshotRequestHandler.shouldHandle(syncStateSnapshot.Request.CorrelationId) {
 		select {
-		case d.snapshotRequestHandler.channel <- syncStateSnapshot:
+		case sh.snapshotRequestHandler.channel <- syncStateSnapshot:
 		default:
 			// Was not able to write to the channel, in which case the Snapshot stream is incomplete, and must be discarded, closing the channel
 			// without sending the terminating message which would have had an empty byte slice.
 			peerLogger.Warning("Did NOT send SyncStateSnapshot message to channel for correlationId = %d, sequence = %d, closing channel as the message has been discarded", syncStateSnapshot.Request.CorrelationId, syncStateSnapshot.Sequence)
-			d.snapshotRequestHandler.reset()
+			sh.snapshotRequestHandler.reset()
 		}
 	} else {
 		//Ignore the message, does not match the current correlationId
-		peerLogger.Warning("Ignoring SyncStateSnapshot message with correlationId = %d, sequence = %d, as current correlationId = %d", syncStateSnapshot.Request.CorrelationId, syncStateSnapshot.Sequence, d.snapshotRequestHandler.correlationID)
+		peerLogger.Warning("Ignoring SyncStateSnapshot message with correlationId = %d, sequence = %d, as current correlationId = %d", syncStateSnapshot.Request.CorrelationId, syncStateSnapshot.Sequence, sh.snapshotRequestHandler.correlationID)
 	}
 }
 
 // sendBlocks sends the blocks based upon the supplied SyncBlockRange over the stream.
-func (d *Handler) sendStateSnapshot(syncStateSnapshotRequest *pb.SyncStateSnapshotRequest) {
-	peerLogger.Debug("Sending state snapshot with correlationId = %d", syncStateSnapshotRequest.CorrelationId)
+func (d *Handler) sendStateSnapshot(chainID string, syncStateSnapshotRequest *pb.SyncStateSnapshotRequest) {
+	peerLogger.Debug("Sending state snapshot with correlationId = %d, chunk %d of %d", syncStateSnapshotRequest.CorrelationId, syncStateSnapshotRequest.Chunk, syncStateSnapshotRequest.Chunks)
+	syncLedger, err := d.getSyncLedger(chainID)
+	if err != nil {
+		peerLogger.Error(fmt.Sprintf("Error serving sync request: %s", err))
+		return
+	}
 
-	snapshot, err := d.Coordinator.GetStateSnapshot()
+	snapshot, err := syncLedger.GetStateSnapshot()
 	if err != nil {
 		peerLogger.Error(fmt.Sprintf("Error getting snapshot: %s", err))
 		return
@@ -570,37 +657,42 @@ func (d *Handler) sendStateSnapshot(syncStateSnapshotRequest *pb.SyncStateSnapsh
 	// Iterate over the state deltas and send to requestor
 	currBlockNumber := snapshot.GetBlockNumber()
 	var sequence uint64
-	// Loop through and send the Deltas
-	for i := 0; snapshot.Next(); i++ {
-		delta := statemgmt.NewStateDelta()
+	var hash []byte
+	// Loop through and send the Deltas of the chunk requested
+	for snapshot.Next() {
 		k, v := snapshot.GetRawKeyValue()
+		if syncStateSnapshotRequest.Chunks > 0 && StateSnapshotChunk(k, syncStateSnapshotRequest.Chunks) != syncStateSnapshotRequest.Chunk {
+			continue
+		}
+		delta := statemgmt.NewStateDelta()
 		cID, kID := statemgmt.DecodeCompositeKey(k)
 		delta.Set(cID, kID, v, nil)
 
 		deltaAsBytes := delta.Marshal()
+		hash = HashStateSnapshotDelta(hash, deltaAsBytes)
 		// Encode a SyncStateSnapsot into the payload
-		sequence = uint64(i)
 		syncStateSnapshot := &pb.SyncStateSnapshot{Delta: deltaAsBytes, Sequence: sequence, BlockNumber: currBlockNumber, Request: syncStateSnapshotRequest}
+		sequence++
 
 		syncStateSnapshotBytes, err := proto.Marshal(syncStateSnapshot)
 		if err != nil {
 			peerLogger.Error(fmt.Sprintf("Error marshalling syncStateSnapsot for BlockNum = %d: %s", currBlockNumber, err))
 			break
 		}
-		if err := d.SendMessage(&pb.Message{Type: pb.Message_SYNC_STATE_SNAPSHOT, Payload: syncStateSnapshotBytes}); err != nil {
+		if err := d.SendMessage(&pb.Message{Type: pb.Message_SYNC_STATE_SNAPSHOT, Payload: syncStateSnapshotBytes, ChainID: chainID}); err != nil {
 			peerLogger.Error(fmt.Sprintf("Error sending syncStateSnapsot for BlockNum = %d: %s", currBlockNumber, err))
 			break
 		}
 	}
 
 	// Now send the terminating message
-	syncStateSnapshot := &pb.SyncStateSnapshot{Delta: []byte{}, Sequence: sequence + 1, BlockNumber: currBlockNumber, Request: syncStateSnapshotRequest}
+	syncStateSnapshot := &pb.SyncStateSnapshot{Delta: []byte{}, Sequence: sequence, BlockNumber: currBlockNumber, Request: syncStateSnapshotRequest, Hash: hash}
 	syncStateSnapshotBytes, err := proto.Marshal(syncStateSnapshot)
 	if err != nil {
 		peerLogger.Error(fmt.Sprintf("Error marshalling terminating syncStateSnapsot message for correlationId = %d, BlockNum = %d: %s", syncStateSnapshotRequest.CorrelationId, currBlockNumber, err))
 		return
 	}
-	if err := d.SendMessage(&pb.Message{Type: pb.Message_SYNC_STATE_SNAPSHOT, Payload: syncStateSnapshotBytes}); err != nil {
+	if err := d.SendMessage(&pb.Message{Type: pb.Message_SYNC_STATE_SNAPSHOT, Payload: syncStateSnapshotBytes, ChainID: chainID}); err != nil {
 		peerLogger.Error(fmt.Sprintf("Error sending terminating syncStateSnapsot for correlationId = %d, BlockNum = %d: %s", syncStateSnapshotRequest.CorrelationId, currBlockNumber, err))
 		return
 	}
@@ -617,24 +709,30 @@ func (d *Handler) sendStateSnapshot(syncStateSnapshotRequest *pb.SyncStateSnapsh
 // RequestStateDeltas get the state snapshot deltas from the other PeerEndpoint, will provide them through the returned channel.
 // this will also stop writing any received syncStateSnapshot(s) to channels created from Prior calls to GetStateSnapshot()
 func (d *Handler) RequestStateDeltas(syncBlockRange *pb.SyncBlockRange) (<-chan *pb.SyncStateDeltas, error) {
-	d.syncStateDeltasRequestHandler.Lock()
-	defer d.syncStateDeltasRequestHandler.Unlock()
+	return d.requestStateDeltas("", syncBlockRange)
+}
+
+// requestStateDeltas sends the request of RequestStateDeltas for the chain with the given ID
+func (d *Handler) requestStateDeltas(chainID string, syncBlockRange *pb.SyncBlockRange) (<-chan *pb.SyncStateDeltas, error) {
+	sh := d.getSyncHandlers(chainID)
+	sh.syncStateDeltasRequestHandler.Lock()
+	defer sh.syncStateDeltasRequestHandler.Unlock()
 	// Reset the handler
-	d.syncStateDeltasRequestHandler.reset()
-	syncBlockRange.CorrelationId = d.syncStateDeltasRequestHandler.correlationID
+	sh.syncStateDeltasRequestHandler.reset()
+	syncBlockRange.CorrelationId = sh.syncStateDeltasRequestHandler.correlationID
 
 	// Create the syncStateSnapshotRequest
-	syncStateDeltasRequest := d.syncStateDeltasRequestHandler.createRequest(syncBlockRange)
+	syncStateDeltasRequest := sh.syncStateDeltasRequestHandler.createRequest(syncBlockRange)
 	syncStateDeltasRequestBytes, err := proto.Marshal(syncStateDeltasRequest)
 	if err != nil {
 		return nil, fmt.Errorf("Error marshaling syncStateDeltasRequest during RequestStateDeltas: %s", err)
 	}
 	peerLogger.Debug("Sending %s with syncStateDeltasRequest = %s", pb.Message_SYNC_STATE_GET_DELTAS.String(), syncStateDeltasRequest)
-	if err := d.SendMessage(&pb.Message{Type: pb.Message_SYNC_STATE_GET_DELTAS, Payload: syncStateDeltasRequestBytes}); err != nil {
+	if err := d.SendMessage(&pb.Message{Type: pb.Message_SYNC_STATE_GET_DELTAS, Payload: syncStateDeltasRequestBytes, ChainID: chainID}); err != nil {
 		return nil, fmt.Errorf("Error sending %s during RequestStateDeltas: %s", pb.Message_SYNC_STATE_GET_DELTAS, err)
 	}
 
-	return d.syncStateDeltasRequestHandler.channel, nil
+	return sh.syncStateDeltasRequestHandler.channel, nil
 }
 
 // beforeSyncStateGetDeltas triggers the sending of Get SyncStateDeltas to remote Peer.
@@ -654,12 +752,17 @@ func (d *Handler) beforeSyncStateGetDeltas(e *fsm.Event) {
 	}
 
 	// Start a separate go FUNC to send the State Deltas
-	go d.sendStateDeltas(syncStateDeltasRequest)
+	go d.sendStateDeltas(msg.ChainID, syncStateDeltasRequest)
 }
 
 // sendBlocks sends the blocks based upon the supplied SyncBlockRange over the stream.
-func (d *Handler) sendStateDeltas(syncStateDeltasRequest *pb.SyncStateDeltasRequest) {
+func (d *Handler) sendStateDeltas(chainID string, syncStateDeltasRequest *pb.SyncStateDeltasRequest) {
 	peerLogger.Debug("Sending state deltas for block range %d-%d", syncStateDeltasRequest.Range.Start, syncStateDeltasRequest.Range.End)
+	syncLedger, err := d.getSyncLedger(chainID)
+	if err != nil {
+		peerLogger.Error(fmt.Sprintf("Error serving sync request: %s", err))
+		return
+	}
 	var blockNums []uint64
 	syncBlockRange := syncStateDeltasRequest.Range
 	if syncBlockRange.Start > syncBlockRange.End {
@@ -676,7 +779,7 @@ func (d *Handler) sendStateDeltas(syncStateDeltasRequest *pb.SyncStateDeltasRequ
 	}
 	for _, currBlockNum := range blockNums {
 		// Get the state deltas for Block from coordinator
-		stateDelta, err := d.Coordinator.GetStateDelta(currBlockNum)
+		stateDelta, err := syncLedger.GetStateDelta(currBlockNum)
 		if err != nil {
 			peerLogger.Error(fmt.Sprintf("Error sending stateDelta for blockNum %d: %s", currBlockNum, err))
 			break
@@ -693,7 +796,7 @@ func (d *Handler) sendStateDeltas(syncStateDeltasRequest *pb.SyncStateDeltasRequ
 			peerLogger.Error(fmt.Sprintf("Error marshalling syncStateDeltas for BlockNum = %d: %s", currBlockNum, err))
 			break
 		}
-		if err := d.SendMessage(&pb.Message{Type: pb.Message_SYNC_STATE_DELTAS, Payload: syncStateDeltasBytes}); err != nil {
+		if err := d.SendMessage(&pb.Message{Type: pb.Message_SYNC_STATE_DELTAS, Payload: syncStateDeltasBytes, ChainID: chainID}); err != nil {
 			peerLogger.Error(fmt.Sprintf("Error sending stateDeltas for blockNum %d: %s", currBlockNum, err))
 			break
 		}
@@ -716,6 +819,7 @@ func (d *Handler) beforeSyncStateDeltas(e *fsm.Event) {
 	}
 	peerLogger.Debug("Sending state delta onto channel for start = %d and end = %d", syncStateDeltas.Range.Start, syncStateDeltas.Range.End)
 
+	sh := d.getSyncHandlers(msg.ChainID)
 	// Send the message onto the channel, allow for the fact that channel may be closed on send attempt.
 	defer func() {
 		if x := recover(); x != nil {
@@ -724,19 +828,19 @@ func (d *Handler) beforeSyncStateDeltas(e *fsm.Event) {
 	}()
 
 	// Use non-blocking send, will WARN and close channel if missed message.
-	d.syncStateDeltasRequestHandler.Lock()
-	defer d.syncStateDeltasRequestHandler.Unlock()
-	if d.syncStateDeltasRequestHandler.shouldHandle(syncStateDeltas.Range.CorrelationId) {
+	sh.syncStateDeltasRequestHandler.Lock()
+	defer sh.syncStateDeltasRequestHandler.Unlock()
+	if sh.syncStateDeltasRequestHandler.shouldHandle(syncStateDeltas.Range.CorrelationId) {
 		select {
-		case d.syncStateDeltasRequestHandler.channel <- syncStateDeltas:
+		case sh.syncStateDeltasRequestHandler.channel <- syncStateDeltas:
 		default:
 			// Was not able to write to the channel, in which case the SyncStateDeltasRequest stream is incomplete, and must be discarded, closing the channel
 			peerLogger.Warning("Did NOT send SyncStateDeltas message to channel for block range %d-%d, closing channel as the message has been discarded", syncStateDeltas.Range.Start, syncStateDeltas.Range.End)
-			d.syncStateDeltasRequestHandler.reset()
+			sh.syncStateDeltasRequestHandler.reset()
 		}
 	} else {
 		//Ignore the message, does not match the current correlationId
-		peerLogger.Warning("Ignoring SyncStateDeltas message with correlationId = %d, blocks %d to %d, as current correlationId = %d", syncStateDeltas.Range.CorrelationId, syncStateDeltas.Range.Start, syncStateDeltas.Range.End, d.syncStateDeltasRequestHandler.correlationID)
+		peerLogger.Warning("Ignoring SyncStateDeltas message with correlationId = %d, blocks %d to %d, as current correlationId = %d", syncStateDeltas.Range.CorrelationId, syncStateDeltas.Range.Start, syncStateDeltas.Range.End, sh.syncStateDeltasRequestHandler.correlationID)
 	}
 
 }
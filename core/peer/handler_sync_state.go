@@ -17,8 +17,10 @@ limitations under the License.
 package peer
 
 import (
+	"hash/fnv"
 	"sync"
 
+	"github.com/hyperledger/fabric/core/util"
 	pb "github.com/hyperledger/fabric/protos"
 )
 
@@ -37,6 +39,27 @@ func (sh *syncHandler) shouldHandle(correlationID uint64) bool {
 	return correlationID == sh.correlationID
 }
 
+//-----------------------------------------------------------------------------
+//
+// Chain Sync Handlers
+//
+//-----------------------------------------------------------------------------
+
+// chainSyncHandlers holds the handlers of the sync requests sent for a chain
+type chainSyncHandlers struct {
+	snapshotRequestHandler        *syncStateSnapshotRequestHandler
+	syncStateDeltasRequestHandler *syncStateDeltasHandler
+	syncBlocksRequestHandler      *syncBlocksRequestHandler
+}
+
+func newChainSyncHandlers() *chainSyncHandlers {
+	return &chainSyncHandlers{
+		snapshotRequestHandler:        newSyncStateSnapshotRequestHandler(),
+		syncStateDeltasRequestHandler: newSyncStateDeltasHandler(),
+		syncBlocksRequestHandler:      newSyncBlocksRequestHandler(),
+	}
+}
+
 //-----------------------------------------------------------------------------
 //
 // Sync Blocks Handler
@@ -81,8 +104,8 @@ func (srh *syncStateSnapshotRequestHandler) reset() {
 	srh.correlationID++
 }
 
-func (srh *syncStateSnapshotRequestHandler) createRequest() *pb.SyncStateSnapshotRequest {
-	return &pb.SyncStateSnapshotRequest{CorrelationId: srh.correlationID}
+func (srh *syncStateSnapshotRequestHandler) createRequest(chunk, chunks uint32) *pb.SyncStateSnapshotRequest {
+	return &pb.SyncStateSnapshotRequest{CorrelationId: srh.correlationID, Chunk: chunk, Chunks: chunks}
 }
 
 func newSyncStateSnapshotRequestHandler() *syncStateSnapshotRequestHandler {
@@ -91,6 +114,20 @@ func newSyncStateSnapshotRequestHandler() *syncStateSnapshotRequestHandler {
 	return srh
 }
 
+// StateSnapshotChunk returns the chunk out of chunks a key of the state belongs to
+func StateSnapshotChunk(key []byte, chunks uint32) uint32 {
+	h := fnv.New32a()
+	h.Write(key)
+	return h.Sum32() % chunks
+}
+
+// HashStateSnapshotDelta chains the hash of the deltas of a state snapshot sent so far with the next one
+func HashStateSnapshotDelta(hash []byte, delta []byte) []byte {
+	chained := make([]byte, 0, len(hash)+len(delta))
+	chained = append(chained, hash...)
+	return util.ComputeCryptoHash(append(chained, delta...))
+}
+
 //-----------------------------------------------------------------------------
 //
 // Sync State Deltas Handler
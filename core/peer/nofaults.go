@@ -0,0 +1,29 @@
+// +build !faults
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// sendWithFaults sends a message through the stream of a handler. Peers
+// built with the faults tag inject the faults of a scenario on the way.
+func sendWithFaults(d *Handler, msg *pb.Message) error {
+	return d.sendMessage(msg)
+}
@@ -57,7 +57,7 @@ type BlocksRetriever interface {
 
 // StateRetriever interface for retrieving state deltas, etc.
 type StateRetriever interface {
-	RequestStateSnapshot() (<-chan *pb.SyncStateSnapshot, error)
+	RequestStateSnapshot(chunk, chunks uint32) (<-chan *pb.SyncStateSnapshot, error)
 	RequestStateDeltas(syncBlockRange *pb.SyncBlockRange) (<-chan *pb.SyncStateDeltas, error)
 }
 
@@ -102,6 +102,7 @@ type MessageHandler interface {
 	SendMessage(msg *pb.Message) error
 	To() (pb.PeerEndpoint, error)
 	Stop() error
+	GetChainRemoteLedger(chainID string) RemoteLedger
 }
 
 // MessageHandlerCoordinator responsible for coordinating between the registered MessageHandler's
@@ -118,6 +119,7 @@ type MessageHandlerCoordinator interface {
 	Unicast(*pb.Message, *pb.PeerID) error
 	GetPeers() (*pb.PeersMessage, error)
 	GetRemoteLedger(receiver *pb.PeerID) (RemoteLedger, error)
+	GetRemoteChainLedger(receiver *pb.PeerID, chainID string) (RemoteLedger, error)
 	PeersDiscovered(*pb.PeersMessage) error
 	ExecuteTransaction(transaction *pb.Transaction) *pb.Response
 }
@@ -298,6 +300,13 @@ func (p *PeerImpl) Chat(stream pb.Peer_ChatServer) error {
 // ProcessTransaction implementation of the ProcessTransaction RPC function
 func (p *PeerImpl) ProcessTransaction(ctx context.Context, tx *pb.Transaction) (response *pb.Response, err error) {
 	peerLogger.Debug("ProcessTransaction processing transaction uuid = %s", tx.Uuid)
+	// Check that the TLS certificate of the caller is bound to its enrollment certificate
+	if secHelper := p.secHelper; nil != secHelper {
+		if _, err = secHelper.VerifyTLSBinding(ctx); err != nil {
+			peerLogger.Error("ProcessTransaction failed to verify TLS binding %v", err)
+			return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(err.Error())}, nil
+		}
+	}
 	// Need to validate the Tx's signature if we are a validator.
 	if p.isValidator {
 		// Verify transaction signature if security is enabled
@@ -341,6 +350,18 @@ func (p *PeerImpl) GetRemoteLedger(receiverHandle *pb.PeerID) (RemoteLedger, err
 	return remoteLedger, nil
 }
 
+// GetRemoteChainLedger returns the RemoteLedger interface for the chain with
+// the given ID of the remote Peer Endpoint
+func (p *PeerImpl) GetRemoteChainLedger(receiverHandle *pb.PeerID, chainID string) (RemoteLedger, error) {
+	p.handlerMap.RLock()
+	defer p.handlerMap.RUnlock()
+	msgHandler, ok := p.handlerMap.m[*receiverHandle]
+	if !ok {
+		return nil, fmt.Errorf("Remote ledger not found for receiver %s", receiverHandle.Name)
+	}
+	return msgHandler.GetChainRemoteLedger(chainID), nil
+}
+
 // PeersDiscovered used by MessageHandlers for notifying this coordinator of discovered PeerEndoints. May include this Peer's PeerEndpoint.
 func (p *PeerImpl) PeersDiscovered(peersMessage *pb.PeersMessage) error {
 	thisPeersEndpoint, err := GetPeerEndpoint()
@@ -514,7 +535,7 @@ func (p *PeerImpl) sendTransactionsToLocalEngine(transaction *pb.Transaction) *p
 	}
 
 	var response *pb.Response
-	msg := &pb.Message{Type: pb.Message_CHAIN_TRANSACTION, Payload: data, Timestamp: util.CreateUtcTimestamp()}
+	msg := &pb.Message{Type: pb.Message_CHAIN_TRANSACTION, Payload: data, Timestamp: util.CreateUtcTimestamp(), ChainID: transaction.ChainID}
 	peerLogger.Debug("Sending message %s with timestamp %v to local engine", msg.Type, msg.Timestamp)
 	response = p.engine.ProcessTransactionMsg(msg, transaction)
 
@@ -129,6 +129,10 @@ type StateTransferState struct {
 	maxBlockRange      uint64 // The maximum number blocks to attempt to retrieve at once, to prevent from overflowing the peer's buffer
 	maxStateDeltaRange uint64 // The maximum number of state deltas to attempt to retrieve at once, to prevent from overflowing the peer's buffer
 
+	stateChunks      uint32            // The number of chunks the state snapshot is retrieved in
+	maxStateFetchers int               // The maximum number of peers to retrieve chunks of the state snapshot from in parallel
+	snapshotProgress *snapshotProgress // The chunks of an incomplete state snapshot already applied, to resume its retrieval from, used only by the state thread
+
 	stateTransferListeners     []Listener  // A list of listeners to call when state transfer is initiated/errored/completed
 	stateTransferListenersLock *sync.Mutex // Used to lock the above list when adding a listener
 }
@@ -359,7 +363,18 @@ func threadlessNewStateTransferState(stack PartialStack) *StateTransferState {
 		panic(fmt.Errorf("sts.maxdeltas must be greater than 0"))
 	}
 
-	tmp := viper.GetInt("peer.sync.blocks.channelSize")
+	tmp := viper.GetInt("statetransfer.statechunks")
+	if tmp <= 0 {
+		panic(fmt.Errorf("statetransfer.statechunks must be greater than 0"))
+	}
+	sts.stateChunks = uint32(tmp)
+
+	sts.maxStateFetchers = viper.GetInt("statetransfer.maxstatefetchers")
+	if sts.maxStateFetchers <= 0 {
+		panic(fmt.Errorf("statetransfer.maxstatefetchers must be greater than 0"))
+	}
+
+	tmp = viper.GetInt("peer.sync.blocks.channelSize")
 	if tmp <= 0 {
 		panic(fmt.Errorf("peer.sync.blocks.channelSize must be greater than 0"))
 	}
@@ -414,6 +429,22 @@ type blockSyncReq struct {
 	firstBlockHash []byte
 }
 
+// snapshotProgress records the block number of the state each chunk of a
+// state snapshot was retrieved at, as the chunks are applied
+type snapshotProgress struct {
+	chunks uint32
+	done   map[uint32]uint64
+}
+
+// stateChunk is a chunk of the state snapshot retrieved from a peer, verified but not applied yet
+type stateChunk struct {
+	chunk       uint32
+	blockNumber uint64
+	peerID      *protos.PeerID
+	pieces      []*protos.SyncStateSnapshot
+	deltas      []*statemgmt.StateDelta
+}
+
 type blockRange struct {
 	highBlock   uint64
 	lowBlock    uint64
@@ -440,18 +471,17 @@ func (a blockRangeSlice) Less(i, j int) bool {
 // helper functions for state transfer
 // =============================================================================
 
-// Executes a func trying each peer included in peerIDs until successful
-// Attempts to execute over all peers if peerIDs is nil
-func (sts *StateTransferState) tryOverPeers(passedPeerIDs []*protos.PeerID, do func(peerID *protos.PeerID) error) (err error) {
+// Returns the peers to sync from, all the validating peers connected if peerIDs is nil
+func (sts *StateTransferState) candidatePeers(passedPeerIDs []*protos.PeerID) ([]*protos.PeerID, error) {
 
 	peerIDs := passedPeerIDs
 
 	if nil == passedPeerIDs {
-		logger.Debug("%v tryOverPeers, no peerIDs given, discovering", sts.id)
+		logger.Debug("%v no peerIDs given, discovering", sts.id)
 
 		peersMsg, err := sts.stack.GetPeers()
 		if err != nil {
-			return fmt.Errorf("Couldn't retrieve list of peers: %v", err)
+			return nil, fmt.Errorf("Couldn't retrieve list of peers: %v", err)
 		}
 		peers := peersMsg.GetPeers()
 		for _, endpoint := range peers {
@@ -466,15 +496,27 @@ func (sts *StateTransferState) tryOverPeers(passedPeerIDs []*protos.PeerID, do f
 		logger.Debug("%v discovered %d peerIDs", sts.id, len(peerIDs))
 	}
 
-	logger.Debug("%v in tryOverPeers, using peerIDs: %v", sts.id, peerIDs)
-
 	if 0 == len(peerIDs) {
-		logger.Error("%v invoked tryOverPeers with no peers specified, throttling thread", sts.id)
+		logger.Error("%v has no peers to sync from, throttling thread", sts.id)
 		// Unless we throttle here, this condition will likely cause a tight loop which will adversely affect the rest of the system
 		time.Sleep(sts.DiscoveryThrottleTime)
-		return fmt.Errorf("No peers available to try over")
+		return nil, fmt.Errorf("No peers available to try over")
 	}
 
+	return peerIDs, nil
+}
+
+// Executes a func trying each peer included in peerIDs until successful
+// Attempts to execute over all peers if peerIDs is nil
+func (sts *StateTransferState) tryOverPeers(passedPeerIDs []*protos.PeerID, do func(peerID *protos.PeerID) error) (err error) {
+
+	peerIDs, err := sts.candidatePeers(passedPeerIDs)
+	if err != nil {
+		return err
+	}
+
+	logger.Debug("%v in tryOverPeers, using peerIDs: %v", sts.id, peerIDs)
+
 	numReplicas := len(peerIDs)
 	startIndex := rand.Int() % numReplicas
 
@@ -796,9 +838,13 @@ func (sts *StateTransferState) blockThread() {
 func (sts *StateTransferState) attemptStateTransfer(currentStateBlockNumber *uint64, mark **blockHashReply, blockHReply **blockHashReply, blocksValid *bool) error {
 	var err error
 
+	// The chunks of a state snapshot may be as of different blocks, up to this one, in which case
+	// the state is only valid once played forward to it
+	snapshotHighBlockNumber := uint64(0)
+
 	if !sts.stateValid {
 		// Our state is currently bad, so get a new one
-		*currentStateBlockNumber, err = sts.syncStateSnapshot((*mark).blockNumber, (*mark).peerIDs)
+		*currentStateBlockNumber, snapshotHighBlockNumber, err = sts.syncStateSnapshot((*mark).blockNumber, (*mark).peerIDs)
 
 		if nil != err {
 			*mark = &blockHashReply{ // Let's try to just sync state from anyone, for any sequence number
@@ -810,25 +856,30 @@ func (sts *StateTransferState) attemptStateTransfer(currentStateBlockNumber *uin
 			return fmt.Errorf("%v could not retrieve state as recent as %d from any of specified peers", sts.id, (*mark).blockNumber)
 		}
 
-		logger.Debug("%v completed state transfer to block %d", sts.id, *currentStateBlockNumber)
+		logger.Debug("%v completed state transfer to blocks %d through %d", sts.id, *currentStateBlockNumber, snapshotHighBlockNumber)
 	} else {
 		*currentStateBlockNumber = sts.stack.GetBlockchainSize() - 1 // The block height is one more than the latest block number
 	}
 
+	verifyBlockNumber := *currentStateBlockNumber
+	if snapshotHighBlockNumber > verifyBlockNumber {
+		verifyBlockNumber = snapshotHighBlockNumber
+	}
+
 	// TODO, eventually we should allow lower block numbers and rewind transactions as needed
-	if nil == *blockHReply || (*blockHReply).blockNumber < *currentStateBlockNumber {
+	if nil == *blockHReply || (*blockHReply).blockNumber < verifyBlockNumber {
 
 		if nil == *blockHReply {
 			logger.Debug("%v has no valid block hash to validate the blockchain with yet, waiting for a known valid block hash", sts.id)
 		} else {
-			logger.Debug("%v already has valid blocks through %d but needs to validate the state for block %d", sts.id, (*blockHReply).blockNumber, *currentStateBlockNumber)
+			logger.Debug("%v already has valid blocks through %d but needs to validate the state for block %d", sts.id, (*blockHReply).blockNumber, verifyBlockNumber)
 		}
 
 	outer:
 		for {
 			select {
 			case *blockHReply = <-sts.blockHashReceiver:
-				if (*blockHReply).blockNumber < *currentStateBlockNumber {
+				if (*blockHReply).blockNumber < verifyBlockNumber {
 					logger.Debug("%v received a block hash reply for block number %d, which is not high enough", sts.id, (*blockHReply).blockNumber)
 				} else {
 					break outer
@@ -880,33 +931,37 @@ func (sts *StateTransferState) attemptStateTransfer(currentStateBlockNumber *uin
 			sts.id, *currentStateBlockNumber, (*blockHReply).blockNumber, sts.maxStateDeltas)
 	}
 
-	stateHash, err := sts.stack.GetCurrentStateHash()
-	if nil != err {
-		sts.stateValid = false
-		return fmt.Errorf("%v could not compute its current state hash: %x", sts.id, err)
+	if verifyBlockNumber == *currentStateBlockNumber {
+		stateHash, err := sts.stack.GetCurrentStateHash()
+		if nil != err {
+			sts.stateValid = false
+			return fmt.Errorf("%v could not compute its current state hash: %x", sts.id, err)
 
-	}
+		}
 
-	block, err := sts.stack.GetBlockByNumber(*currentStateBlockNumber)
-	if nil != err {
-		*blocksValid = false
-		return fmt.Errorf("%v believed its state for block %d to be valid, but it could not retrieve it : %s", sts.id, *currentStateBlockNumber, err)
-	}
+		block, err := sts.stack.GetBlockByNumber(*currentStateBlockNumber)
+		if nil != err {
+			*blocksValid = false
+			return fmt.Errorf("%v believed its state for block %d to be valid, but it could not retrieve it : %s", sts.id, *currentStateBlockNumber, err)
+		}
 
-	if !bytes.Equal(stateHash, block.StateHash) {
-		if sts.stateValid {
-			sts.stateValid = false
-			return fmt.Errorf("%v believed its state for block %d to be valid, but its hash (%x) did not match the recovered blockchain's (%x)", sts.id, (*currentStateBlockNumber), stateHash, block.StateHash)
+		if !bytes.Equal(stateHash, block.StateHash) {
+			if sts.stateValid {
+				sts.stateValid = false
+				return fmt.Errorf("%v believed its state for block %d to be valid, but its hash (%x) did not match the recovered blockchain's (%x)", sts.id, (*currentStateBlockNumber), stateHash, block.StateHash)
+			}
+			return fmt.Errorf("%v recovered to an incorrect state at block number %d, (%x %x) retrying", sts.id, *currentStateBlockNumber, stateHash, block.StateHash)
 		}
-		return fmt.Errorf("%v recovered to an incorrect state at block number %d, (%x %x) retrying", sts.id, *currentStateBlockNumber, stateHash, block.StateHash)
-	}
 
-	logger.Debug("%v state is now valid", sts.id)
+		logger.Debug("%v state is now valid", sts.id)
+	} else {
+		logger.Debug("%v state is made of chunks as of blocks %d through %d, it will be valid once played forward to block %d", sts.id, *currentStateBlockNumber, verifyBlockNumber, verifyBlockNumber)
+	}
 
 	sts.stateValid = true
 
 	if *currentStateBlockNumber < (*blockHReply).blockNumber {
-		*currentStateBlockNumber, err = sts.playStateUpToBlockNumber(*currentStateBlockNumber+uint64(1), (*blockHReply).blockNumber, (*blockHReply).peerIDs)
+		*currentStateBlockNumber, err = sts.playStateUpToBlockNumber(*currentStateBlockNumber+uint64(1), (*blockHReply).blockNumber, verifyBlockNumber, (*blockHReply).peerIDs)
 		if nil != err {
 			// This is unlikely, in the future, we may wish to play transactions forward rather than retry
 			sts.stateValid = false
@@ -1014,7 +1069,8 @@ func (sts *StateTransferState) informListeners(blockNumber uint64, blockHash []b
 	}
 }
 
-func (sts *StateTransferState) playStateUpToBlockNumber(fromBlockNumber, toBlockNumber uint64, peerIDs []*protos.PeerID) (uint64, error) {
+// Plays the state forward with the state deltas of the blocks, verifying it against those from verifyBlockNumber on
+func (sts *StateTransferState) playStateUpToBlockNumber(fromBlockNumber, toBlockNumber, verifyBlockNumber uint64, peerIDs []*protos.PeerID) (uint64, error) {
 	logger.Debug("%v attempting to play state forward from %v to block %d", sts.id, peerIDs, toBlockNumber)
 	currentBlock := fromBlockNumber
 	err := sts.tryOverPeers(peerIDs, func(peerID *protos.PeerID) error {
@@ -1059,7 +1115,10 @@ func (sts *StateTransferState) playStateUpToBlockNumber(fromBlockNumber, toBlock
 
 				testBlock, err := sts.stack.GetBlockByNumber(deltaMessage.Range.End)
 
-				if nil != err {
+				if deltaMessage.Range.End < verifyBlockNumber {
+					logger.Debug("%v has played state forward from %v to block %d, its state is not verifiable before block %d", sts.id, peerID, deltaMessage.Range.End, verifyBlockNumber)
+					success = true
+				} else if nil != err {
 					logger.Warning("%v could not retrieve block %d, though it should be present", sts.id, deltaMessage.Range.End)
 				} else {
 
@@ -1104,66 +1163,199 @@ func (sts *StateTransferState) playStateUpToBlockNumber(fromBlockNumber, toBlock
 	return currentBlock, err
 }
 
-// This function will retrieve the current state from a peer.
+// This function will retrieve the current state from the peers, in chunks fetched in parallel from several
+// of them. Each chunk is verified to be complete before being applied, and those applied are kept across
+// attempts, so that a failed retrieval resumes with the chunks missing. The chunks may be as of different
+// blocks, the lowest and highest are returned.
 // Note that no state verification can occur yet, we must wait for the next checkpoint, so it is important
 // not to consider this state as valid
-func (sts *StateTransferState) syncStateSnapshot(minBlockNumber uint64, peerIDs []*protos.PeerID) (uint64, error) {
+func (sts *StateTransferState) syncStateSnapshot(minBlockNumber uint64, peerIDs []*protos.PeerID) (uint64, uint64, error) {
 
 	logger.Debug("%v attempting to retrieve state snapshot from recovery from %v", sts.id, peerIDs)
 
-	currentStateBlock := uint64(0)
-
-	ok := sts.tryOverPeers(peerIDs, func(peerID *protos.PeerID) error {
-		logger.Debug("%v is initiating state recovery from %v", sts.id, peerID)
-
+	progress := sts.snapshotProgress
+	if nil == progress {
 		if err := sts.stack.EmptyState(); nil != err {
 			logger.Error("Could not empty the current state: %s", err)
 		}
+		progress = &snapshotProgress{
+			chunks: sts.stateChunks,
+			done:   make(map[uint32]uint64),
+		}
+		sts.snapshotProgress = progress
+	} else {
+		logger.Debug("%v resuming state recovery, %d chunks of %d already applied", sts.id, len(progress.done), progress.chunks)
+	}
 
-		stateChan, err := sts.GetRemoteStateSnapshot(peerID)
+	peerIDs, err := sts.candidatePeers(peerIDs)
+	if nil != err {
+		return 0, 0, err
+	}
 
-		if err != nil {
-			return err
+	pending := make(chan uint32, progress.chunks)
+	for chunk := uint32(0); chunk < progress.chunks; chunk++ {
+		if _, ok := progress.done[chunk]; !ok {
+			pending <- chunk
 		}
+	}
+	remaining := len(pending)
 
-		timer := time.NewTimer(sts.StateSnapshotRequestTimeout)
-		counter := 0
+	fetchers := len(peerIDs)
+	if fetchers > sts.maxStateFetchers {
+		fetchers = sts.maxStateFetchers
+	}
+	chunks := make(chan *stateChunk)
+	exited := make(chan struct{})
+	abort := make(chan struct{})
+	defer close(abort)
+
+	startIndex := rand.Int() % len(peerIDs)
+	for i := 0; i < fetchers; i++ {
+		go sts.fetchStateChunks(peerIDs[(startIndex+i)%len(peerIDs)], progress.chunks, pending, chunks, exited, abort)
+	}
 
-		for {
-			select {
-			case piece, ok := <-stateChan:
-				if !ok {
-					return fmt.Errorf("%v had state snapshot channel close prematurely after %d deltas: %s", sts.id, counter, err)
-				}
-				if 0 == len(piece.Delta) {
-					stateHash, err := sts.stack.GetCurrentStateHash()
-					if nil != err {
-						sts.stateValid = false
-						return fmt.Errorf("%v could not compute its current state hash: %x", sts.id, err)
+	for remaining > 0 && fetchers > 0 {
+		select {
+		case chunk := <-chunks:
+			if err := sts.applyStateChunk(chunk); nil != err {
+				// The chunk may be partially applied, so the state must be retrieved anew
+				sts.snapshotProgress = nil
+				return 0, 0, err
+			}
+			progress.done[chunk.chunk] = chunk.blockNumber
+			remaining--
+		case <-exited:
+			fetchers--
+		case <-sts.threadExit:
+			return 0, 0, fmt.Errorf("%v interrupted with request to exit while in state recovery", sts.id)
+		}
+	}
 
-					}
+	if remaining > 0 {
+		return 0, 0, fmt.Errorf("%v could not retrieve %d chunks of the state out of %d from %v, will resume", sts.id, remaining, progress.chunks, peerIDs)
+	}
 
-					logger.Debug("%v received final piece of state snapshot from %v after %d deltas, now has hash %x", sts.id, peerID, counter, stateHash)
-					return nil
-				}
-				umDelta := &statemgmt.StateDelta{}
-				if err := umDelta.Unmarshal(piece.Delta); nil != err {
-					return fmt.Errorf("%v received a corrupt delta from %v after %d deltas : %s", sts.id, peerID, counter, err)
+	sts.snapshotProgress = nil
+
+	low, high := ^uint64(0), uint64(0)
+	for _, blockNumber := range progress.done {
+		if blockNumber < low {
+			low = blockNumber
+		}
+		if blockNumber > high {
+			high = blockNumber
+		}
+	}
+
+	stateHash, err := sts.stack.GetCurrentStateHash()
+	if nil != err {
+		sts.stateValid = false
+		return 0, 0, fmt.Errorf("%v could not compute its current state hash: %x", sts.id, err)
+	}
+	logger.Debug("%v received all %d chunks of the state snapshot as of blocks %d through %d, now has hash %x", sts.id, progress.chunks, low, high, stateHash)
+
+	return low, high, nil
+}
+
+// fetchStateChunks retrieves the pending chunks of the state snapshot from a peer until there are
+// none left, or the peer fails to deliver one, which is then left for another peer to retrieve
+func (sts *StateTransferState) fetchStateChunks(peerID *protos.PeerID, chunks uint32, pending chan uint32, fetched chan<- *stateChunk, exited chan<- struct{}, abort <-chan struct{}) {
+	defer func() {
+		select {
+		case exited <- struct{}{}:
+		case <-abort:
+		}
+	}()
+
+	for {
+		var chunk uint32
+		select {
+		case chunk = <-pending:
+		default:
+			return
+		}
+
+		sc, err := sts.fetchStateChunk(peerID, chunk, chunks)
+		if nil != err {
+			logger.Warning("%v could not retrieve chunk %d of the state from %v: %s", sts.id, chunk, peerID, err)
+			pending <- chunk
+			return
+		}
+
+		select {
+		case fetched <- sc:
+		case <-abort:
+			return
+		}
+	}
+}
+
+// fetchStateChunk retrieves a chunk of the state snapshot from a peer, and verifies that none of its deltas is
+// missing, corrupt or out of order
+func (sts *StateTransferState) fetchStateChunk(peerID *protos.PeerID, chunk, chunks uint32) (*stateChunk, error) {
+	logger.Debug("%v is retrieving chunk %d of %d of the state from %v", sts.id, chunk, chunks, peerID)
+
+	stateChan, err := sts.GetRemoteStateSnapshot(peerID, chunk, chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	sc := &stateChunk{
+		chunk:  chunk,
+		peerID: peerID,
+	}
+	var hash []byte
+
+	timer := time.NewTimer(sts.StateSnapshotRequestTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case piece, ok := <-stateChan:
+			if !ok {
+				return nil, fmt.Errorf("state snapshot channel closed prematurely after %d deltas", len(sc.deltas))
+			}
+			if piece.Sequence != uint64(len(sc.deltas)) {
+				return nil, fmt.Errorf("received delta %d while expecting delta %d", piece.Sequence, len(sc.deltas))
+			}
+			if 0 == len(piece.Delta) {
+				if !bytes.Equal(piece.Hash, hash) {
+					return nil, fmt.Errorf("received %d deltas whose hash (%x) does not match the one advertised (%x)", len(sc.deltas), hash, piece.Hash)
 				}
-				sts.stack.ApplyStateDelta(piece, umDelta)
-				currentStateBlock = piece.BlockNumber
-				if err := sts.stack.CommitStateDelta(piece); nil != err {
-					return fmt.Errorf("%v could not commit state delta from %v after %d deltas: %s", sts.id, counter, peerID, err)
+				if 0 == len(sc.deltas) {
+					sc.blockNumber = piece.BlockNumber // The chunk is empty as of this block
 				}
-				counter++
-			case <-timer.C:
-				return fmt.Errorf("%v timed out during state recovery from %v", sts.id, peerID)
+				return sc, nil
+			}
+			if 0 != len(sc.deltas) && piece.BlockNumber != sc.blockNumber {
+				return nil, fmt.Errorf("received deltas as of blocks %d and %d", sc.blockNumber, piece.BlockNumber)
+			}
+			umDelta := &statemgmt.StateDelta{}
+			if err := umDelta.Unmarshal(piece.Delta); nil != err {
+				return nil, fmt.Errorf("received a corrupt delta after %d deltas : %s", len(sc.deltas), err)
 			}
+			hash = peer.HashStateSnapshotDelta(hash, piece.Delta)
+			sc.blockNumber = piece.BlockNumber
+			sc.pieces = append(sc.pieces, piece)
+			sc.deltas = append(sc.deltas, umDelta)
+		case <-timer.C:
+			return nil, fmt.Errorf("timed out after %d deltas", len(sc.deltas))
 		}
+	}
+}
 
-	})
-
-	return currentStateBlock, ok
+// applyStateChunk applies and commits the deltas of a chunk of the state snapshot
+func (sts *StateTransferState) applyStateChunk(sc *stateChunk) error {
+	for i, piece := range sc.pieces {
+		if err := sts.stack.ApplyStateDelta(piece, sc.deltas[i]); nil != err {
+			return fmt.Errorf("%v could not apply delta %d of chunk %d of the state from %v: %s", sts.id, i, sc.chunk, sc.peerID, err)
+		}
+		if err := sts.stack.CommitStateDelta(piece); nil != err {
+			return fmt.Errorf("%v could not commit delta %d of chunk %d of the state from %v: %s", sts.id, i, sc.chunk, sc.peerID, err)
+		}
+	}
+	logger.Debug("%v applied chunk %d of the state as of block %d from %v, %d deltas", sts.id, sc.chunk, sc.blockNumber, sc.peerID, len(sc.deltas))
+	return nil
 }
 
 // The below were stolen from helper.go, they should eventually be removed there, and probably made private here
@@ -1180,13 +1372,13 @@ func (sts *StateTransferState) GetRemoteBlocks(replicaID *protos.PeerID, start,
 	})
 }
 
-// GetRemoteStateSnapshot will return a channel to stream a state snapshot from the desired replicaID
-func (sts *StateTransferState) GetRemoteStateSnapshot(replicaID *protos.PeerID) (<-chan *protos.SyncStateSnapshot, error) {
+// GetRemoteStateSnapshot will return a channel to stream a chunk of a state snapshot from the desired replicaID
+func (sts *StateTransferState) GetRemoteStateSnapshot(replicaID *protos.PeerID, chunk, chunks uint32) (<-chan *protos.SyncStateSnapshot, error) {
 	remoteLedger, err := sts.stack.GetRemoteLedger(replicaID)
 	if nil != err {
 		return nil, err
 	}
-	return remoteLedger.RequestStateSnapshot()
+	return remoteLedger.RequestStateSnapshot(chunk, chunks)
 }
 
 // GetRemoteStateDeltas will return a channel to stream a state snapshot deltas from the desired replicaID
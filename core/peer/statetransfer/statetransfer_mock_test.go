@@ -198,8 +198,8 @@ type remoteLedger struct {
 func (rl *remoteLedger) RequestBlocks(rng *protos.SyncBlockRange) (<-chan *protos.SyncBlocks, error) {
 	return rl.mockLedger.GetRemoteBlocks(rl.peerID, rng.Start, rng.End)
 }
-func (rl *remoteLedger) RequestStateSnapshot() (<-chan *protos.SyncStateSnapshot, error) {
-	return rl.mockLedger.GetRemoteStateSnapshot(rl.peerID)
+func (rl *remoteLedger) RequestStateSnapshot(chunk, chunks uint32) (<-chan *protos.SyncStateSnapshot, error) {
+	return rl.mockLedger.GetRemoteStateSnapshot(rl.peerID, chunk, chunks)
 }
 func (rl *remoteLedger) RequestStateDeltas(rng *protos.SyncBlockRange) (<-chan *protos.SyncStateDeltas, error) {
 	return rl.mockLedger.GetRemoteStateDeltas(rl.peerID, rng.Start, rng.End)
@@ -308,7 +308,9 @@ func (mock *MockLedger) GetRemoteBlocks(peerID *protos.PeerID, start, finish uin
 	return res, nil
 }
 
-func (mock *MockLedger) GetRemoteStateSnapshot(peerID *protos.PeerID) (<-chan *protos.SyncStateSnapshot, error) {
+// GetRemoteStateSnapshot streams the deltas of the blocks of the remote ledger, those of the blocks
+// whose number modulo chunks is chunk when chunks is not 0
+func (mock *MockLedger) GetRemoteStateSnapshot(peerID *protos.PeerID, chunk, chunks uint32) (<-chan *protos.SyncStateSnapshot, error) {
 
 	rl, ok := mock.remoteLedgers.GetLedgerByPeerID(peerID)
 	if !ok {
@@ -345,25 +347,30 @@ func (mock *MockLedger) GetRemoteStateSnapshot(peerID *protos.PeerID) (<-chan *p
 			fallthrough
 		case Normal:
 			i := uint64(0)
+			var hash []byte
 			for deltas := range rds {
-				for _, delta := range deltas.Deltas {
-					res <- &protos.SyncStateSnapshot{
-						Delta:       delta,
-						Sequence:    i,
-						BlockNumber: remoteBlockHeight - 1,
-						Request:     nil,
+				if chunks == 0 || deltas.Range.Start%uint64(chunks) == uint64(chunk) {
+					for _, delta := range deltas.Deltas {
+						res <- &protos.SyncStateSnapshot{
+							Delta:       delta,
+							Sequence:    i,
+							BlockNumber: remoteBlockHeight - 1,
+							Request:     nil,
+						}
+						hash = peer.HashStateSnapshotDelta(hash, delta)
+						i++
 					}
-					i++
 				}
-				if i == remoteBlockHeight {
+				if deltas.Range.End == remoteBlockHeight-1 {
 					break
 				}
 			}
 			res <- &protos.SyncStateSnapshot{
 				Delta:       []byte{},
 				Sequence:    i,
-				BlockNumber: ^uint64(0),
+				BlockNumber: remoteBlockHeight - 1,
 				Request:     nil,
+				Hash:        hash,
 			}
 		default:
 			mock.t.Fatalf("Unsupported filter result %d", ft)
@@ -767,7 +774,7 @@ func TestMockLedger(t *testing.T) {
 		t.Fatalf("Mangled blockchain did not detect the correct block with the wrong hash, error in mock ledger implementation.")
 	}
 
-	syncStateMessages, err := ml.GetRemoteStateSnapshot(rlPeerID)
+	syncStateMessages, err := ml.GetRemoteStateSnapshot(rlPeerID, 0, 0)
 
 	if nil != err {
 		t.Fatalf("Remote state snapshot call failed, error in mock ledger implementation: %s", err)
@@ -395,6 +395,90 @@ func TestCatchupSyncSnapshotError(t *testing.T) {
 	}
 }
 
+func TestCatchupSnapshotChunksFromSeveralPeers(t *testing.T) {
+	mrls := createRemoteLedgers(1, 3)
+
+	mutex := &sync.Mutex{}
+	snapshotPeers := make(map[protos.PeerID]bool)
+	ml := NewMockLedger(mrls, func(request mockRequest, peerID *protos.PeerID) mockResponse {
+		if request == SyncSnapshot {
+			mutex.Lock()
+			snapshotPeers[*peerID] = true
+			mutex.Unlock()
+		}
+		return Normal
+	}, t)
+
+	sts := newTestStateTransfer(ml, mrls)
+	defer sts.Stop()
+	sts.InvalidateState()
+	if err := executeStateTransfer(sts, ml, 7, 10, mrls); nil != err {
+		t.Fatalf("Snapshot chunks case: %s", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(snapshotPeers) < 2 {
+		t.Fatalf("Expected the chunks of the state to be retrieved from several peers, got %v", snapshotPeers)
+	}
+}
+
+func TestCatchupSnapshotResume(t *testing.T) {
+	mrls := createRemoteLedgers(1, 3)
+	for peerID := range mrls.remoteLedgers {
+		mrls.GetMockRemoteLedgerByPeerID(&peerID).blockHeight = 8
+	}
+
+	// Let the first few chunks through, then time out until the snapshot is resumed
+	mutex := &sync.Mutex{}
+	requests := 0
+	resumed := false
+	ml := NewMockLedger(mrls, func(request mockRequest, peerID *protos.PeerID) mockResponse {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if request != SyncSnapshot || resumed {
+			return Normal
+		}
+		requests++
+		if requests > 6 {
+			return Timeout
+		}
+		return Normal
+	}, t)
+
+	sts := newTestThreadlessStateTransfer(ml, mrls)
+	sts.StateSnapshotRequestTimeout = 10 * time.Millisecond
+
+	if _, _, err := sts.syncStateSnapshot(7, nil); nil == err {
+		t.Fatalf("Expected the state snapshot to fail as peers time out")
+	}
+	if nil == sts.snapshotProgress || 0 == len(sts.snapshotProgress.done) {
+		t.Fatalf("Expected the chunks retrieved before the failure to be kept")
+	}
+	if len(sts.snapshotProgress.done) == int(sts.stateChunks) {
+		t.Fatalf("Expected some chunks to be missing after the failure")
+	}
+
+	mutex.Lock()
+	resumed = true
+	mutex.Unlock()
+
+	low, high, err := sts.syncStateSnapshot(7, nil)
+	if nil != err {
+		t.Fatalf("Expected the state snapshot to resume: %s", err)
+	}
+	if low != 7 || high != 7 {
+		t.Fatalf("Expected the state snapshot as of block 7, got blocks %d through %d", low, high)
+	}
+	// The mock state adds up the deltas, any chunk applied twice would show
+	if stateHash, _ := ml.GetCurrentStateHash(); !bytes.Equal(stateHash, SimpleGetStateHash(7)) {
+		t.Fatalf("Expected the resumed state to be the state of block 7, got %s", stateHash)
+	}
+	if nil != sts.snapshotProgress {
+		t.Fatalf("Expected the progress to be discarded once the snapshot is complete")
+	}
+}
+
 func TestCatchupSyncDeltasError(t *testing.T) {
 	for _, failureType := range AllFailures {
 		mrls := createRemoteLedgers(1, 3)
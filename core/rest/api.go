@@ -26,7 +26,9 @@ import (
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/peer"
 	pb "github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
 )
 
 var (
@@ -41,7 +43,9 @@ type PeerInfo interface {
 }
 
 // ServerOpenchain defines the Openchain server object, which holds the
-// Ledger data structure and the pointer to the peerServer.
+// Ledger data structure and the pointer to the peerServer. A request is for
+// the chain set in its context with peer.NewChainContext, the default chain
+// if none is set.
 type ServerOpenchain struct {
 	ledger   *ledger.Ledger
 	peerInfo PeerInfo
@@ -73,10 +77,23 @@ func NewOpenchainServerWithPeerInfo(peerServer PeerInfo) (*ServerOpenchain, erro
 	return s, nil
 }
 
+// getLedger returns the ledger of the chain the request is for
+func (s *ServerOpenchain) getLedger(ctx context.Context) (*ledger.Ledger, error) {
+	chainID := peer.GetChainIDFromContext(ctx)
+	if chainID == "" {
+		return s.ledger, nil
+	}
+	return peer.GetChainLedger(chainID)
+}
+
 // GetBlockchainInfo returns information about the blockchain ledger such as
 // height, current block hash, and previous block hash.
 func (s *ServerOpenchain) GetBlockchainInfo(ctx context.Context, e *google_protobuf1.Empty) (*pb.BlockchainInfo, error) {
-	blockchainInfo, err := s.ledger.GetBlockchainInfo()
+	l, err := s.getLedger(ctx)
+	if err != nil {
+		return nil, err
+	}
+	blockchainInfo, err := l.GetBlockchainInfo()
 	if blockchainInfo.Height == 0 {
 		return nil, fmt.Errorf("No blocks in blockchain.")
 	}
@@ -86,7 +103,11 @@ func (s *ServerOpenchain) GetBlockchainInfo(ctx context.Context, e *google_proto
 // GetBlockByNumber returns the data contained within a specific block in the
 // blockchain. The genesis block is block zero.
 func (s *ServerOpenchain) GetBlockByNumber(ctx context.Context, num *pb.BlockNumber) (*pb.Block, error) {
-	block, err := s.ledger.GetBlockByNumber(num.Number)
+	l, err := s.getLedger(ctx)
+	if err != nil {
+		return nil, err
+	}
+	block, err := l.GetBlockByNumber(num.Number)
 	if err != nil {
 		switch err {
 		case ledger.ErrOutOfBounds:
@@ -120,11 +141,154 @@ func (s *ServerOpenchain) GetBlockByNumber(ctx context.Context, num *pb.BlockNum
 	return block, nil
 }
 
+// GetHistoryForKey returns the modifications of a key of the state of a
+// chaincode by the committed transactions, from the oldest to the latest.
+func (s *ServerOpenchain) GetHistoryForKey(ctx context.Context, historyKey *pb.HistoryKey) (*pb.KeyHistory, error) {
+	l, err := s.getLedger(ctx)
+	if err != nil {
+		return nil, err
+	}
+	modifications, err := l.GetHistoryForKey(historyKey.ChaincodeID, historyKey.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.KeyHistory{Modifications: modifications}, nil
+}
+
+// GetStateProof returns a proof that a key of the state of a chaincode holds
+// its value, or none, in the state recorded in the last block.
+func (s *ServerOpenchain) GetStateProof(ctx context.Context, stateKey *pb.StateKey) (*pb.StateProof, error) {
+	l, err := s.getLedger(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return l.GetStateProof(stateKey.ChaincodeID, stateKey.Key)
+}
+
+// GetStateAt returns the value of a key of the state of a chaincode as of a
+// block height, in the state recorded in the block before that height, which
+// the state deltas kept since then must allow to rewind to.
+func (s *ServerOpenchain) GetStateAt(ctx context.Context, stateAtHeight *pb.StateAtHeight) (*pb.StateValue, error) {
+	l, err := s.getLedger(ctx)
+	if err != nil {
+		return nil, err
+	}
+	value, err := l.GetStateAt(stateAtHeight.Height, stateAtHeight.ChaincodeID, stateAtHeight.Key)
+	if err != nil {
+		if err == ledger.ErrOutOfBounds {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &pb.StateValue{Value: value}, nil
+}
+
+// defaultRangeFetchLimit is the most blocks or transactions returned by a
+// range fetch if 'ledger.blockchain.rangeFetchLimit' is not set
+const defaultRangeFetchLimit = 100
+
+// rangeFetchCount caps the count of blocks or transactions asked by a range
+// fetch to 'ledger.blockchain.rangeFetchLimit', a count of 0 to the limit
+func rangeFetchCount(count uint64) uint64 {
+	limit := uint64(defaultRangeFetchLimit)
+	if configured := viper.GetInt("ledger.blockchain.rangeFetchLimit"); configured > 0 {
+		limit = uint64(configured)
+	}
+	if count == 0 || count > limit {
+		return limit
+	}
+	return count
+}
+
+// GetBlockRange returns the blocks of the range which are in the
+// blockchain, none if the range starts past the last block. The payloads of
+// the deploy transactions are removed, as by GetBlockByNumber.
+func (s *ServerOpenchain) GetBlockRange(ctx context.Context, blockRange *pb.BlockRange) ([]*pb.Block, error) {
+	l, err := s.getLedger(ctx)
+	if err != nil {
+		return nil, err
+	}
+	end := blockRange.Start + rangeFetchCount(blockRange.Count)
+	if size := l.GetBlockchainSize(); end > size {
+		end = size
+	}
+	var blocks []*pb.Block
+	for blockNumber := blockRange.Start; blockNumber < end; blockNumber++ {
+		block, err := s.GetBlockByNumber(ctx, &pb.BlockNumber{Number: blockNumber})
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// GetTransactionRange returns the transactions of the range which are in
+// the blockchain, with their positions, none if the range starts past the
+// last transaction. The payloads of the deploy transactions are removed, as
+// by GetBlockByNumber.
+func (s *ServerOpenchain) GetTransactionRange(ctx context.Context, txRange *pb.TransactionRange) ([]*pb.BlockTransaction, error) {
+	l, err := s.getLedger(ctx)
+	if err != nil {
+		return nil, err
+	}
+	count := rangeFetchCount(txRange.Count)
+	size := l.GetBlockchainSize()
+	var transactions []*pb.BlockTransaction
+	index := txRange.Index
+	for blockNumber := txRange.Block; blockNumber < size && uint64(len(transactions)) < count; blockNumber++ {
+		block, err := s.GetBlockByNumber(ctx, &pb.BlockNumber{Number: blockNumber})
+		if err != nil {
+			return nil, err
+		}
+		blockTransactions := block.GetTransactions()
+		for ; index < uint64(len(blockTransactions)) && uint64(len(transactions)) < count; index++ {
+			transactions = append(transactions, &pb.BlockTransaction{Block: blockNumber, Index: index, Transaction: blockTransactions[index]})
+		}
+		index = 0
+	}
+	return transactions, nil
+}
+
+// GetBlocks streams the blocks of a range of the blockchain, see
+// GetBlockRange.
+func (s *ServerOpenchain) GetBlocks(blockRange *pb.BlockRange, stream pb.Openchain_GetBlocksServer) error {
+	blocks, err := s.GetBlockRange(stream.Context(), blockRange)
+	if err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		if err = stream.Send(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTransactions streams the transactions of a range of the blockchain, see
+// GetTransactionRange.
+func (s *ServerOpenchain) GetTransactions(txRange *pb.TransactionRange, stream pb.Openchain_GetTransactionsServer) error {
+	transactions, err := s.GetTransactionRange(stream.Context(), txRange)
+	if err != nil {
+		return err
+	}
+	for _, transaction := range transactions {
+		if err = stream.Send(transaction); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // GetBlockCount returns the current number of blocks in the blockchain data
 // structure.
 func (s *ServerOpenchain) GetBlockCount(ctx context.Context, e *google_protobuf1.Empty) (*pb.BlockCount, error) {
+	l, err := s.getLedger(ctx)
+	if err != nil {
+		return nil, err
+	}
 	// Total number of blocks in the blockchain.
-	size := s.ledger.GetBlockchainSize()
+	size := l.GetBlockchainSize()
 
 	// Check the number of blocks in the blockchain. If the blockchain is empty,
 	// return error. There will always be at least one block in the blockchain,
@@ -139,12 +303,41 @@ func (s *ServerOpenchain) GetBlockCount(ctx context.Context, e *google_protobuf1
 
 // GetState returns the value for a particular chaincode ID and key
 func (s *ServerOpenchain) GetState(ctx context.Context, chaincodeID, key string) ([]byte, error) {
-	return s.ledger.GetState(chaincodeID, key, true)
+	l, err := s.getLedger(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return l.GetState(chaincodeID, key, true)
+}
+
+// QueryState returns the committed key-values of a chaincode ID whose values are JSON documents
+// matching the query, in lexical order of the keys
+func (s *ServerOpenchain) QueryState(ctx context.Context, chaincodeID, query string) ([]*pb.RangeQueryStateKeyValue, error) {
+	l, err := s.getLedger(ctx)
+	if err != nil {
+		return nil, err
+	}
+	itr, err := l.GetStateQueryIterator(chaincodeID, query, true)
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Close()
+
+	var keysAndValues []*pb.RangeQueryStateKeyValue
+	for itr.Next() {
+		key, value := itr.GetKeyValue()
+		keysAndValues = append(keysAndValues, &pb.RangeQueryStateKeyValue{Key: key, Value: value})
+	}
+	return keysAndValues, nil
 }
 
 // GetTransactionByUUID returns a transaction matching the specified UUID
 func (s *ServerOpenchain) GetTransactionByUUID(ctx context.Context, txUUID string) (*pb.Transaction, error) {
-	transaction, err := s.ledger.GetTransactionByUUID(txUUID)
+	l, err := s.getLedger(ctx)
+	if err != nil {
+		return nil, err
+	}
+	transaction, err := l.GetTransactionByUUID(txUUID)
 	if err != nil {
 		switch err {
 		case ledger.ErrResourceNotFound:
@@ -157,8 +350,24 @@ func (s *ServerOpenchain) GetTransactionByUUID(ctx context.Context, txUUID strin
 }
 
 // GetPeers returns a list of all peer nodes currently connected to the target peer.
+// For a chain other than the default chain, the validating peers which are not
+// validators of the chain are left out.
 func (s *ServerOpenchain) GetPeers(ctx context.Context, e *google_protobuf1.Empty) (*pb.PeersMessage, error) {
-	return s.peerInfo.GetPeers()
+	peersMessage, err := s.peerInfo.GetPeers()
+	chainID := peer.GetChainIDFromContext(ctx)
+	if err != nil || chainID == "" {
+		return peersMessage, err
+	}
+	if !peer.HostsChain(chainID) {
+		return nil, fmt.Errorf("The peer does not keep the ledger of chain %s", chainID)
+	}
+	peers := []*pb.PeerEndpoint{}
+	for _, endpoint := range peersMessage.Peers {
+		if endpoint.Type != pb.PeerEndpoint_VALIDATOR || peer.IsChainValidator(chainID, endpoint.ID) {
+			peers = append(peers, endpoint)
+		}
+	}
+	return &pb.PeersMessage{Peers: peers}, nil
 }
 
 // GetPeerEndpoint returns PeerEndpoint info of target peer.
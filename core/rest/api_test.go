@@ -20,11 +20,13 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"reflect"
 	"testing"
 
 	"google/protobuf"
 
 	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/peer"
 	"github.com/hyperledger/fabric/core/util"
 	"github.com/hyperledger/fabric/protos"
 	"github.com/spf13/viper"
@@ -255,7 +257,277 @@ func TestServerOpenchain_API_GetState(t *testing.T) {
 
 }
 
+func TestServerOpenchain_API_QueryState(t *testing.T) {
+	ledger1 := ledger.InitTestLedger(t)
+	ledger1.BeginTxBatch(0)
+	ledger1.TxBegin("txUuid")
+	ledger1.SetState("MyContract1", "asset1", []byte(`{"owner": "alice", "size": 1}`))
+	ledger1.SetState("MyContract1", "asset2", []byte(`{"owner": "bob", "size": 2}`))
+	ledger1.SetState("MyContract1", "code", []byte("code example"))
+	ledger1.TxFinished("txUuid", true)
+	if err := ledger1.CommitTxBatch(0, []*protos.Transaction{}, nil, []byte("dummy-proof")); err != nil {
+		t.Fatalf("Error in commit: %s", err)
+	}
+
+	server, err := NewOpenchainServerWithPeerInfo(new(peerInfo))
+	if err != nil {
+		t.Fatalf("Error creating OpenchainServer: %s", err)
+	}
+
+	keysAndValues, err := server.QueryState(context.Background(), "MyContract1", `{"selector": {"size": {"$gt": 1}}}`)
+	if err != nil {
+		t.Fatalf("Error querying state: %s", err)
+	}
+	if len(keysAndValues) != 1 || keysAndValues[0].Key != "asset2" {
+		t.Fatalf("Expected asset2 to match, but got %v", keysAndValues)
+	}
+
+	if _, err = server.QueryState(context.Background(), "MyContract1", "not a query"); err == nil {
+		t.Fatal("Expected an error querying state with an invalid query")
+	}
+}
+
+func TestServerOpenchain_API_GetHistoryForKey(t *testing.T) {
+	viper.Set("ledger.history.enabled", true)
+	defer viper.Set("ledger.history.enabled", false)
+	ledger1 := ledger.InitTestLedger(t)
+	uuid := generateUUID(t)
+	tx, err := protos.NewTransaction(protos.ChaincodeID{Path: "MyContract1"}, uuid, "setCode", []string{"code example"})
+	if err != nil {
+		t.Fatalf("Error creating NewTransaction: %s", err)
+	}
+	ledger1.BeginTxBatch(0)
+	ledger1.TxBegin(uuid)
+	ledger1.SetState("MyContract1", "code", []byte("code example"))
+	ledger1.TxFinished(uuid, true)
+	if err = ledger1.CommitTxBatch(0, []*protos.Transaction{tx}, nil, []byte("dummy-proof")); err != nil {
+		t.Fatalf("Error in commit: %s", err)
+	}
+
+	server, err := NewOpenchainServerWithPeerInfo(new(peerInfo))
+	if err != nil {
+		t.Fatalf("Error creating OpenchainServer: %s", err)
+	}
+
+	history, err := server.GetHistoryForKey(context.Background(), &protos.HistoryKey{ChaincodeID: "MyContract1", Key: "code"})
+	if err != nil {
+		t.Fatalf("Error getting history: %s", err)
+	}
+	if len(history.Modifications) != 1 || history.Modifications[0].TxUuid != uuid ||
+		string(history.Modifications[0].Value) != "code example" {
+		t.Fatalf("Expected the modification of tx %s, but got %v", uuid, history.Modifications)
+	}
+}
+
+func TestServerOpenchain_API_GetStateProof(t *testing.T) {
+	ledger1 := ledger.InitTestLedger(t)
+	uuid := generateUUID(t)
+	tx, err := protos.NewTransaction(protos.ChaincodeID{Path: "MyContract1"}, uuid, "setCode", []string{"code example"})
+	if err != nil {
+		t.Fatalf("Error creating NewTransaction: %s", err)
+	}
+	ledger1.BeginTxBatch(0)
+	ledger1.TxBegin(uuid)
+	ledger1.SetState("MyContract1", "code", []byte("code example"))
+	ledger1.TxFinished(uuid, true)
+	if err = ledger1.CommitTxBatch(0, []*protos.Transaction{tx}, nil, []byte("dummy-proof")); err != nil {
+		t.Fatalf("Error in commit: %s", err)
+	}
+
+	server, err := NewOpenchainServerWithPeerInfo(new(peerInfo))
+	if err != nil {
+		t.Fatalf("Error creating OpenchainServer: %s", err)
+	}
+
+	proof, err := server.GetStateProof(context.Background(), &protos.StateKey{ChaincodeID: "MyContract1", Key: "code"})
+	if err != nil {
+		t.Fatalf("Error getting state proof: %s", err)
+	}
+	if !proof.Exists || string(proof.Value) != "code example" {
+		t.Fatalf("Expected a proof of value 'code example', but got %v", proof)
+	}
+	block, err := server.GetBlockByNumber(context.Background(), &protos.BlockNumber{Number: proof.BlockNumber})
+	if err != nil {
+		t.Fatalf("Error getting block: %s", err)
+	}
+	if err = ledger.VerifyStateProof(proof, block); err != nil {
+		t.Fatalf("Error verifying state proof: %s", err)
+	}
+}
+
+func TestServerOpenchain_API_GetStateAt(t *testing.T) {
+	ledger1 := ledger.InitTestLedger(t)
+	for i, value := range []string{"code example", "code example 2"} {
+		ledger1.BeginTxBatch(i)
+		ledger1.TxBegin("txUuid")
+		ledger1.SetState("MyContract1", "code", []byte(value))
+		ledger1.TxFinished("txUuid", true)
+		if err := ledger1.CommitTxBatch(i, []*protos.Transaction{}, nil, []byte("dummy-proof")); err != nil {
+			t.Fatalf("Error in commit: %s", err)
+		}
+	}
+
+	server, err := NewOpenchainServerWithPeerInfo(new(peerInfo))
+	if err != nil {
+		t.Fatalf("Error creating OpenchainServer: %s", err)
+	}
+
+	for height, expected := range []string{"", "code example", "code example 2"} {
+		value, err := server.GetStateAt(context.Background(), &protos.StateAtHeight{ChaincodeID: "MyContract1", Key: "code", Height: uint64(height)})
+		if err != nil {
+			t.Fatalf("Error getting state at height %d: %s", height, err)
+		}
+		if string(value.Value) != expected {
+			t.Fatalf("Expected '%s' at height %d, but got '%s'", expected, height, value.Value)
+		}
+	}
+
+	if _, err = server.GetStateAt(context.Background(), &protos.StateAtHeight{ChaincodeID: "MyContract1", Key: "code", Height: 3}); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound past the blockchain, but got %v", err)
+	}
+}
+
+func TestServerOpenchain_API_GetBlockRange(t *testing.T) {
+	viper.Set("ledger.blockchain.rangeFetchLimit", 3)
+	defer viper.Set("ledger.blockchain.rangeFetchLimit", 0)
+	// A blockchain of 5 blocks
+	buildTestLedger2(ledger.InitTestLedger(t), t)
+	server, err := NewOpenchainServerWithPeerInfo(new(peerInfo))
+	if err != nil {
+		t.Fatalf("Error creating OpenchainServer: %s", err)
+	}
+
+	for _, test := range []struct {
+		start, count uint64
+		expected     int
+	}{
+		{0, 2, 2},
+		{1, 0, 3},  // as many as allowed
+		{1, 10, 3}, // capped
+		{3, 0, 2},  // up to the last block
+		{5, 0, 0},
+	} {
+		blocks, err := server.GetBlockRange(context.Background(), &protos.BlockRange{Start: test.start, Count: test.count})
+		if err != nil {
+			t.Fatalf("Error getting blocks from %d: %s", test.start, err)
+		}
+		if len(blocks) != test.expected {
+			t.Fatalf("Expected %d blocks from %d, but got %d", test.expected, test.start, len(blocks))
+		}
+		for i, block := range blocks {
+			if len(block.Transactions) != int(test.start)+i {
+				t.Fatalf("Expected block %d, but got a block of %d transactions", int(test.start)+i, len(block.Transactions))
+			}
+		}
+	}
+}
+
+func TestServerOpenchain_API_GetTransactionRange(t *testing.T) {
+	viper.Set("ledger.blockchain.rangeFetchLimit", 4)
+	defer viper.Set("ledger.blockchain.rangeFetchLimit", 0)
+	// Block i of the 5 blocks holds i transactions
+	buildTestLedger2(ledger.InitTestLedger(t), t)
+	server, err := NewOpenchainServerWithPeerInfo(new(peerInfo))
+	if err != nil {
+		t.Fatalf("Error creating OpenchainServer: %s", err)
+	}
+
+	// page through all the transactions
+	var positions []string
+	txRange := &protos.TransactionRange{}
+	for {
+		transactions, err := server.GetTransactionRange(context.Background(), txRange)
+		if err != nil {
+			t.Fatalf("Error getting transactions from block %d: %s", txRange.Block, err)
+		}
+		for _, transaction := range transactions {
+			if transaction.Transaction == nil {
+				t.Fatalf("Expected the transaction at %d:%d", transaction.Block, transaction.Index)
+			}
+			positions = append(positions, fmt.Sprintf("%d:%d", transaction.Block, transaction.Index))
+		}
+		if len(transactions) < 4 {
+			break
+		}
+		last := transactions[len(transactions)-1]
+		txRange = &protos.TransactionRange{Block: last.Block, Index: last.Index + 1}
+	}
+	expected := []string{"1:0", "2:0", "2:1", "3:0", "3:1", "3:2", "4:0", "4:1", "4:2", "4:3"}
+	if !reflect.DeepEqual(positions, expected) {
+		t.Fatalf("Expected the transactions at %v, but got %v", expected, positions)
+	}
+
+	transactions, err := server.GetTransactionRange(context.Background(), &protos.TransactionRange{Block: 3, Index: 1, Count: 2})
+	if err != nil {
+		t.Fatalf("Error getting transactions: %s", err)
+	}
+	if len(transactions) != 2 || transactions[0].Index != 1 || transactions[1].Index != 2 {
+		t.Fatalf("Expected transactions 1 and 2 of block 3, but got %v", transactions)
+	}
+}
+
 // buildTestLedger1 builds a simple ledger data structure that contains a blockchain with 3 blocks.
+func TestServerOpenchain_API_Chains(t *testing.T) {
+	defaultLedger := ledger.InitTestLedger(t)
+	buildTestLedger1(defaultLedger, t)
+	server, err := NewOpenchainServerWithPeerInfo(new(peerInfo))
+	if err != nil {
+		t.Fatalf("Error creating OpenchainServer: %s", err)
+	}
+	chainCtx := peer.NewChainContext(context.Background(), "chain1")
+
+	// the peer does not keep the ledger of chain1 yet
+	if _, err = server.GetBlockCount(chainCtx, &google_protobuf.Empty{}); err == nil {
+		t.Fatal("Got the block count of a chain the peer does not keep")
+	}
+	if _, err = server.GetPeers(chainCtx, &google_protobuf.Empty{}); err == nil {
+		t.Fatal("Got the peers of a chain the peer does not keep")
+	}
+
+	enabled := viper.GetBool("peer.validator.enabled")
+	defer func() {
+		viper.Set("peer.validator.enabled", enabled)
+		viper.Set("peer.chains", []string{})
+		peer.CacheConfiguration()
+	}()
+	viper.Set("peer.validator.enabled", false)
+	viper.Set("peer.chains", []string{"chain1"})
+	viper.Set("peer.chainValidators.chain1", []string{"other"})
+	if err = peer.CacheConfiguration(); err != nil {
+		t.Fatalf("Error caching the configuration: %s", err)
+	}
+
+	chainLedger, err := ledger.GetChainLedger("chain1")
+	if err != nil {
+		t.Fatalf("Error getting the ledger of chain1: %s", err)
+	}
+	chainLedger.BeginTxBatch(0)
+	if err = chainLedger.CommitTxBatch(0, []*protos.Transaction{}, nil, []byte("dummy-proof")); err != nil {
+		t.Fatalf("Error in commit: %s", err)
+	}
+
+	count, err := server.GetBlockCount(chainCtx, &google_protobuf.Empty{})
+	if err != nil {
+		t.Fatalf("Error retrieving BlockCount of chain1: %s", err)
+	}
+	if count.Count != chainLedger.GetBlockchainSize() {
+		t.Fatalf("Got %d blocks on chain1, expected %d", count.Count, chainLedger.GetBlockchainSize())
+	}
+	count, err = server.GetBlockCount(context.Background(), &google_protobuf.Empty{})
+	if err != nil || count.Count != 3 {
+		t.Fatalf("The default chain must still have 3 blocks: %v %v", count, err)
+	}
+
+	// the validator of peerInfo is not a validator of chain1
+	peers, err := server.GetPeers(chainCtx, &google_protobuf.Empty{})
+	if err != nil {
+		t.Fatalf("Error retrieving the peers of chain1: %s", err)
+	}
+	if len(peers.Peers) != 0 {
+		t.Fatalf("Got validators %v of chain1, expected none", peers.Peers)
+	}
+}
+
 func buildTestLedger1(ledger1 *ledger.Ledger, t *testing.T) {
 	// -----------------------------<Block #0>---------------------
 	// Add the 0th (genesis block)
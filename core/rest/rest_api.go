@@ -40,6 +40,7 @@ import (
 	"github.com/hyperledger/fabric/core/chaincode"
 	"github.com/hyperledger/fabric/core/crypto"
 	"github.com/hyperledger/fabric/core/crypto/utils"
+	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/hyperledger/fabric/core/peer"
 	pb "github.com/hyperledger/fabric/protos"
 )
@@ -171,6 +172,12 @@ func (s *ServerOpenchainREST) SetResponseType(rw web.ResponseWriter, req *web.Re
 	next(rw, req)
 }
 
+// chainContext returns the context of a request on the chain set in its
+// chainID query parameter, the default chain if it is not set
+func chainContext(req *web.Request) context.Context {
+	return peer.NewChainContext(context.Background(), req.URL.Query().Get("chainID"))
+}
+
 // getRESTFilePath is a helper function to retrieve the local storage directory
 // of client login tokens.
 func getRESTFilePath() string {
@@ -587,7 +594,7 @@ func (s *ServerOpenchainREST) GetTransactionCert(rw web.ResponseWriter, req *web
 // GetBlockchainInfo returns information about the blockchain ledger such as
 // height, current block hash, and previous block hash.
 func (s *ServerOpenchainREST) GetBlockchainInfo(rw web.ResponseWriter, req *web.Request) {
-	info, err := s.server.GetBlockchainInfo(context.Background(), &google_protobuf.Empty{})
+	info, err := s.server.GetBlockchainInfo(chainContext(req), &google_protobuf.Empty{})
 
 	encoder := json.NewEncoder(rw)
 
@@ -616,7 +623,7 @@ func (s *ServerOpenchainREST) GetBlockByNumber(rw web.ResponseWriter, req *web.R
 		fmt.Fprintf(rw, "{\"Error\": \"Block id must be an integer (uint64).\"}")
 	} else {
 		// Retrieve Block from blockchain
-		block, err := s.server.GetBlockByNumber(context.Background(), &pb.BlockNumber{Number: blockNumber})
+		block, err := s.server.GetBlockByNumber(chainContext(req), &pb.BlockNumber{Number: blockNumber})
 
 		// Check for error
 		if err != nil {
@@ -637,13 +644,106 @@ func (s *ServerOpenchainREST) GetBlockByNumber(rw web.ResponseWriter, req *web.R
 	}
 }
 
+// blockPage is a page of the blocks of a range, with the start of the next
+// page if the page is full
+type blockPage struct {
+	Blocks []*pb.Block `json:"blocks"`
+	Next   *uint64     `json:"next,omitempty"`
+}
+
+// transactionPage is a page of the transactions of a range, with the start
+// of the next page if the page is full
+type transactionPage struct {
+	Transactions []*pb.BlockTransaction `json:"transactions"`
+	Next         *pb.TransactionRange   `json:"next,omitempty"`
+}
+
+// parseRangeParams parses the query parameters of a range fetch, which are
+// integers (uint64), 0 if absent
+func parseRangeParams(req *web.Request, names ...string) ([]uint64, error) {
+	query := req.URL.Query()
+	values := make([]uint64, len(names))
+	for i, name := range names {
+		if query.Get(name) == "" {
+			continue
+		}
+		value, err := strconv.ParseUint(query.Get(name), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an integer (uint64).", name)
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// GetBlockRange returns a page of the blocks of the blockchain, from block
+// start on, at most count blocks and as many as the server allows at once.
+func (s *ServerOpenchainREST) GetBlockRange(rw web.ResponseWriter, req *web.Request) {
+	params, err := parseRangeParams(req, "start", "count")
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
+		return
+	}
+	blockRange := &pb.BlockRange{Start: params[0], Count: params[1]}
+
+	blocks, err := s.server.GetBlockRange(chainContext(req), blockRange)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
+		restLogger.Error(fmt.Sprintf("Error getting blocks from %d: %s", blockRange.Start, err))
+		return
+	}
+
+	page := blockPage{Blocks: []*pb.Block{}}
+	page.Blocks = append(page.Blocks, blocks...)
+	if uint64(len(blocks)) == rangeFetchCount(blockRange.Count) {
+		next := blockRange.Start + uint64(len(blocks))
+		page.Next = &next
+	}
+	rw.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(rw)
+	encoder.Encode(page)
+}
+
+// GetTransactionRange returns a page of the transactions of the blockchain,
+// from the transaction at index in block on, across blocks, at most count
+// transactions and as many as the server allows at once.
+func (s *ServerOpenchainREST) GetTransactionRange(rw web.ResponseWriter, req *web.Request) {
+	params, err := parseRangeParams(req, "block", "index", "count")
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
+		return
+	}
+	txRange := &pb.TransactionRange{Block: params[0], Index: params[1], Count: params[2]}
+
+	transactions, err := s.server.GetTransactionRange(chainContext(req), txRange)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
+		restLogger.Error(fmt.Sprintf("Error getting transactions from block %d: %s", txRange.Block, err))
+		return
+	}
+
+	page := transactionPage{Transactions: []*pb.BlockTransaction{}}
+	page.Transactions = append(page.Transactions, transactions...)
+	if count := uint64(len(transactions)); count > 0 && count == rangeFetchCount(txRange.Count) {
+		last := transactions[count-1]
+		page.Next = &pb.TransactionRange{Block: last.Block, Index: last.Index + 1}
+	}
+	rw.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(rw)
+	encoder.Encode(page)
+}
+
 // GetTransactionByUUID returns a transaction matching the specified UUID
 func (s *ServerOpenchainREST) GetTransactionByUUID(rw web.ResponseWriter, req *web.Request) {
 	// Parse out the transaction UUID
 	txUUID := req.PathParams["uuid"]
 
 	// Retrieve the transaction matching the UUID
-	tx, err := s.server.GetTransactionByUUID(context.Background(), txUUID)
+	tx, err := s.server.GetTransactionByUUID(chainContext(req), txUUID)
 
 	// Check for Error
 	if err != nil {
@@ -665,6 +765,123 @@ func (s *ServerOpenchainREST) GetTransactionByUUID(rw web.ResponseWriter, req *w
 	}
 }
 
+// stateDocument is a key-value returned by a state query, whose value is a
+// JSON document
+type stateDocument struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// QueryState returns the committed values of a chaincode which are JSON
+// documents matching the query in the request body, with their keys.
+func (s *ServerOpenchainREST) QueryState(rw web.ResponseWriter, req *web.Request) {
+	chaincodeID := req.PathParams["chaincodeID"]
+
+	query, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(rw, "{\"Error\": \"Internal JSON error when reading request body.\"}")
+		restLogger.Error("Internal JSON error when reading request body.")
+		return
+	}
+
+	keysAndValues, err := s.server.QueryState(chainContext(req), chaincodeID, string(query))
+	if err != nil {
+		if ledgerErr, ok := err.(*ledger.Error); ok && ledgerErr.Type() == ledger.ErrorTypeInvalidArgument {
+			rw.WriteHeader(http.StatusBadRequest)
+		} else {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
+		restLogger.Error(fmt.Sprintf("Error querying state of chaincode %s: %s", chaincodeID, err))
+		return
+	}
+
+	documents := []stateDocument{}
+	for _, keyAndValue := range keysAndValues {
+		documents = append(documents, stateDocument{keyAndValue.Key, keyAndValue.Value})
+	}
+	rw.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(rw)
+	encoder.Encode(documents)
+}
+
+// GetHistoryForKey returns the values the committed transactions wrote to a
+// key of the state of a chaincode, from the oldest to the latest.
+func (s *ServerOpenchainREST) GetHistoryForKey(rw web.ResponseWriter, req *web.Request) {
+	chaincodeID := req.PathParams["chaincodeID"]
+	key := req.PathParams["key"]
+
+	history, err := s.server.GetHistoryForKey(chainContext(req), &pb.HistoryKey{ChaincodeID: chaincodeID, Key: key})
+	if err != nil {
+		if err == ledger.ErrHistoryDisabled {
+			rw.WriteHeader(http.StatusNotFound)
+		} else {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
+		restLogger.Error(fmt.Sprintf("Error getting history of key %s of chaincode %s: %s", key, chaincodeID, err))
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(rw)
+	encoder.Encode(history)
+}
+
+// GetStateProof returns a proof that a key of the state of a chaincode holds
+// its value, or none, in the state recorded in the last block.
+func (s *ServerOpenchainREST) GetStateProof(rw web.ResponseWriter, req *web.Request) {
+	chaincodeID := req.PathParams["chaincodeID"]
+	key := req.PathParams["key"]
+
+	proof, err := s.server.GetStateProof(chainContext(req), &pb.StateKey{ChaincodeID: chaincodeID, Key: key})
+	if err != nil {
+		if err == ledger.ErrProofNotSupported {
+			rw.WriteHeader(http.StatusNotFound)
+		} else {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
+		restLogger.Error(fmt.Sprintf("Error getting proof of key %s of chaincode %s: %s", key, chaincodeID, err))
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(rw)
+	encoder.Encode(proof)
+}
+
+// GetStateAt returns the value of a key of the state of a chaincode as of a
+// block height, in the state recorded in the block before that height.
+func (s *ServerOpenchainREST) GetStateAt(rw web.ResponseWriter, req *web.Request) {
+	chaincodeID := req.PathParams["chaincodeID"]
+	key := req.PathParams["key"]
+
+	height, err := strconv.ParseUint(req.PathParams["height"], 10, 64)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(rw, "{\"Error\": \"Height must be an integer (uint64).\"}")
+		return
+	}
+
+	value, err := s.server.GetStateAt(chainContext(req), &pb.StateAtHeight{ChaincodeID: chaincodeID, Key: key, Height: height})
+	if err != nil {
+		if ledgerErr, ok := err.(*ledger.Error); err == ErrNotFound || (ok && ledgerErr.Type() == ledger.ErrorTypePruned) {
+			rw.WriteHeader(http.StatusNotFound)
+		} else {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
+		restLogger.Error(fmt.Sprintf("Error getting key %s of chaincode %s at height %d: %s", key, chaincodeID, height, err))
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(rw)
+	encoder.Encode(value)
+}
+
 // Deploy first builds the chaincode package and subsequently deploys it to the
 // blockchain.
 func (s *ServerOpenchainREST) Deploy(rw web.ResponseWriter, req *web.Request) {
@@ -1661,8 +1878,8 @@ func (s *ServerOpenchainREST) processChaincodeInvokeOrQuery(method string, spec
 
 // GetPeers returns a list of all peer nodes currently connected to the target peer, including itself
 func (s *ServerOpenchainREST) GetPeers(rw web.ResponseWriter, req *web.Request) {
-	peers, err := s.server.GetPeers(context.Background(), &google_protobuf.Empty{})
-	currentPeer, err1 := s.server.GetPeerEndpoint(context.Background(), &google_protobuf.Empty{})
+	peers, err := s.server.GetPeers(chainContext(req), &google_protobuf.Empty{})
+	currentPeer, err1 := s.server.GetPeerEndpoint(chainContext(req), &google_protobuf.Empty{})
 
 	encoder := json.NewEncoder(rw)
 
@@ -1727,7 +1944,9 @@ func StartOpenchainRESTServer(server *ServerOpenchain, devops *core.Devops) {
 	router.Get("/registrar/:id/tcert", (*ServerOpenchainREST).GetTransactionCert)
 
 	router.Get("/chain", (*ServerOpenchainREST).GetBlockchainInfo)
+	router.Get("/chain/blocks", (*ServerOpenchainREST).GetBlockRange)
 	router.Get("/chain/blocks/:id", (*ServerOpenchainREST).GetBlockByNumber)
+	router.Get("/chain/transactions", (*ServerOpenchainREST).GetTransactionRange)
 
 	// The /devops endpoint is now considered deprecated and superseded by the /chaincode endpoint
 	router.Post("/devops/deploy", (*ServerOpenchainREST).Deploy)
@@ -1739,6 +1958,12 @@ func StartOpenchainRESTServer(server *ServerOpenchain, devops *core.Devops) {
 
 	router.Get("/transactions/:uuid", (*ServerOpenchainREST).GetTransactionByUUID)
 
+	// Add query of the state by value
+	router.Post("/state/:chaincodeID/query", (*ServerOpenchainREST).QueryState)
+	router.Get("/state/:chaincodeID/:key/history", (*ServerOpenchainREST).GetHistoryForKey)
+	router.Get("/state/:chaincodeID/:key/proof", (*ServerOpenchainREST).GetStateProof)
+	router.Get("/state/:chaincodeID/:key/at/:height", (*ServerOpenchainREST).GetStateAt)
+
 	router.Get("/network/peers", (*ServerOpenchainREST).GetPeers)
 
 	// Add not found page
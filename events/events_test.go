@@ -44,7 +44,7 @@ var adapter *Adapter
 var obcEHClient *consumer.EventsClient
 
 func (a *Adapter) GetInterestedEvents() ([]*ehpb.Interest, error) {
-	return []*ehpb.Interest{{"block", ehpb.Interest_PROTOBUF}}, nil
+	return []*ehpb.Interest{{EventType: "block", ResponseType: ehpb.Interest_PROTOBUF}}, nil
 	//return [] *ehpb.Interest{ &ehpb.InterestedEvent{"block", ehpb.Interest_JSON }}, nil
 }
 
@@ -105,6 +105,21 @@ func TestReceiveMessage(t *testing.T) {
 	}
 }
 
+// Test that the block events of another chain are not sent
+func TestReceiveMessageOfChain(t *testing.T) {
+	adapter.count = 1
+	emsg := producer.CreateBlockEvent(&ehpb.Block{Transactions: []*ehpb.Transaction{}, ChainID: "chain1"})
+	if err := producer.Send(emsg); err != nil {
+		t.Fatalf("Error sending message %s", err)
+	}
+
+	select {
+	case <-adapter.notfy:
+		t.Fatal("Received the block event of a chain the consumer did not register for")
+	case <-time.After(time.Second):
+	}
+}
+
 func BenchmarkMessages(b *testing.B) {
 	numMessages := 10000
 
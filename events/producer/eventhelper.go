@@ -17,6 +17,8 @@ limitations under the License.
 package producer
 
 import (
+	"encoding/json"
+
 	ehpb "github.com/hyperledger/fabric/protos"
 )
 
@@ -24,3 +26,11 @@ import (
 func CreateBlockEvent(te *ehpb.Block) *ehpb.Event {
 	return &ehpb.Event{&ehpb.Event_Block{Block: te}}
 }
+
+//CreateRevokedCertificateEvent creates a generic Event reporting that the
+//transaction uuid was rejected because it is signed with the revoked
+//certificate serialNumber
+func CreateRevokedCertificateEvent(uuid string, serialNumber string) *ehpb.Event {
+	payload, _ := json.Marshal(map[string]string{"uuid": uuid, "serialNumber": serialNumber})
+	return &ehpb.Event{&ehpb.Event_Generic{Generic: &ehpb.Generic{EventType: RevokedCertificateType, Payload: payload}}}
+}
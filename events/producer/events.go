@@ -80,7 +80,12 @@ func (ep *eventProcessor) start() {
 		hl.Lock()
 		ep.Unlock()
 
+		//the events of a chain are only sent to the consumers interested in the chain
+		chainID, chained := getEventChainID(e)
 		for h := range hl.handlers {
+			if chained && !h.isInterestedInChain(eType, chainID) {
+				continue
+			}
 			if rType := h.responseType(eType); rType != pb.Interest_DONTSEND {
 				//if Message is already a generic message, producer must have already converted
 				if eType != "generic" {
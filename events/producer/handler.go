@@ -54,6 +54,7 @@ func (d *handler) register(iEvents []*pb.Interest) error {
 		if ie, ok := d.interestedEvents[v.EventType]; ok {
 			producerLogger.Error(fmt.Sprintf("event %s already registered", v.EventType))
 			ie.ResponseType = v.ResponseType
+			ie.ChainID = v.ChainID
 			continue
 		}
 		if err := registerHandler(v, d); err != nil {
@@ -91,6 +92,15 @@ func (d *handler) responseType(eventType string) pb.Interest_ResponseType {
 	return rType
 }
 
+//isInterestedInChain returns true if the consumer registered for the events of
+//the type of the chain with the given ID, the empty ID being the default chain
+func (d *handler) isInterestedInChain(eventType string, chainID string) bool {
+	if ie, _ := d.interestedEvents[eventType]; ie != nil {
+		return ie.ChainID == chainID
+	}
+	return false
+}
+
 // HandleMessage handles the Openchain messages for the Peer.
 func (d *handler) HandleMessage(msg *pb.Event) error {
 	producerLogger.Debug("Handling Event")
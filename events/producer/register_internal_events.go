@@ -29,6 +29,12 @@ import (
 const (
 	RegisterType = "register"
 	BlockType    = "block"
+	GenericType  = "generic"
+)
+
+//----Generic Event Types -----
+const (
+	RevokedCertificateType = "revokedcertificate"
 )
 
 func getMessageType(e *pb.Event) string {
@@ -38,14 +44,24 @@ func getMessageType(e *pb.Event) string {
 	case *pb.Event_Block:
 		return "block"
 	case *pb.Event_Generic:
-		return "generic"
+		return GenericType
 	default:
 		return ""
 	}
 }
 
+//getEventChainID returns the ID of the chain of a block event, empty for the
+//default chain, and false for the events of no chain
+func getEventChainID(e *pb.Event) (string, bool) {
+	if block, ok := e.Event.(*pb.Event_Block); ok {
+		return block.Block.ChainID, true
+	}
+	return "", false
+}
+
 //should be called at init time to register supported internal events
 func addInternalEventTypes() {
 	AddEventType(BlockType)
 	AddEventType(RegisterType)
+	AddEventType(GenericType)
 }
@@ -81,9 +81,14 @@ func (t *SimpleChaincode) Invoke(stub *shim.ChaincodeStub, function string, args
 	}
 }
 
-// Query has two functions
+// Query has three functions
 // get - takes one argument, a key, and returns the value for the key
 // keys - returns all keys stored in this chaincode
+// query - takes one argument, a query, and returns the keys whose values are
+//         JSON documents matching the query
+// index - takes an index and a value, and optionally an end value, and returns
+//         the keys whose field of the index holds the value, or is between the
+//         value and the end value
 func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
 
 	switch function {
@@ -123,6 +128,62 @@ func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args
 
 		return jsonKeys, nil
 
+	case "query":
+		if len(args) < 1 {
+			return nil, errors.New("query operation must include one argument, a query")
+		}
+		resultsIter, err := stub.GetQueryResult(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("query operation failed. Error accessing state: %s", err)
+		}
+		defer resultsIter.Close()
+
+		var keys []string
+		for resultsIter.HasNext() {
+			key, _, iterErr := resultsIter.Next()
+			if iterErr != nil {
+				return nil, fmt.Errorf("query operation failed. Error accessing state: %s", iterErr)
+			}
+			keys = append(keys, key)
+		}
+
+		jsonKeys, err := json.Marshal(keys)
+		if err != nil {
+			return nil, fmt.Errorf("query operation failed. Error marshaling JSON: %s", err)
+		}
+
+		return jsonKeys, nil
+
+	case "index":
+		if len(args) < 2 {
+			return nil, errors.New("index operation must include at least two arguments, an index and a start value")
+		}
+		endValue := args[1]
+		if len(args) > 2 {
+			endValue = args[2]
+		}
+		resultsIter, err := stub.GetStateByIndex(args[0], args[1], endValue)
+		if err != nil {
+			return nil, fmt.Errorf("index operation failed. Error accessing state: %s", err)
+		}
+		defer resultsIter.Close()
+
+		var keys []string
+		for resultsIter.HasNext() {
+			key, _, iterErr := resultsIter.Next()
+			if iterErr != nil {
+				return nil, fmt.Errorf("index operation failed. Error accessing state: %s", iterErr)
+			}
+			keys = append(keys, key)
+		}
+
+		jsonKeys, err := json.Marshal(keys)
+		if err != nil {
+			return nil, fmt.Errorf("index operation failed. Error marshaling JSON: %s", err)
+		}
+
+		return jsonKeys, nil
+
 	default:
 		return nil, errors.New("Unsupported operation")
 	}
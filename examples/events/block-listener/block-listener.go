@@ -29,12 +29,13 @@ import (
 )
 
 type adapter struct {
-	notfy chan *pb.Event_Block
+	notfy   chan *pb.Event_Block
+	chainID string
 }
 
 //GetInterestedEvents implements consumer.EventAdapter interface for registering interested events
 func (a *adapter) GetInterestedEvents() ([]*pb.Interest, error) {
-	return []*pb.Interest{{EventType: "block", ResponseType: pb.Interest_PROTOBUF}}, nil
+	return []*pb.Interest{{EventType: "block", ResponseType: pb.Interest_PROTOBUF, ChainID: a.chainID}}, nil
 }
 
 //Recv implements consumer.EventAdapter interface for receiving events
@@ -55,11 +56,11 @@ func (a *adapter) Disconnected(err error) {
 	os.Exit(1)
 }
 
-func createEventClient(eventAddress string) *adapter {
+func createEventClient(eventAddress string, chainID string) *adapter {
 	var obcEHClient *consumer.EventsClient
 
 	done := make(chan *pb.Event_Block)
-	adapter := &adapter{notfy: done}
+	adapter := &adapter{notfy: done, chainID: chainID}
 	obcEHClient = consumer.NewEventsClient(eventAddress, adapter)
 	if err := obcEHClient.Start(); err != nil {
 		fmt.Printf("could not start chat %s\n", err)
@@ -72,12 +73,14 @@ func createEventClient(eventAddress string) *adapter {
 
 func main() {
 	var eventAddress string
+	var chainID string
 	flag.StringVar(&eventAddress, "events-address", "0.0.0.0:31315", "address of events server")
+	flag.StringVar(&chainID, "chainID", "", "chain whose blocks are received, the default chain if not set")
 	flag.Parse()
 
 	fmt.Printf("Event Address: %s\n", eventAddress)
 
-	a := createEventClient(eventAddress)
+	a := createEventClient(eventAddress, chainID)
 	if a == nil {
 		fmt.Printf("Error creating event client\n")
 		return
@@ -0,0 +1,446 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"github.com/spf13/viper"
+)
+
+// AttributeProvider is a source of the attributes the TCA embeds in
+// TCerts, such as a database or the HR or entitlement system of an
+// organization. Providers holding resources may implement io.Closer.
+//
+type AttributeProvider interface {
+	// Attributes returns the values of the attributes names of the user
+	// id, a member of affiliation. Attributes the user does not have are
+	// left out.
+	Attributes(id, affiliation string, names []string) (map[string]string, error)
+}
+
+// AttributeProviderFactory creates the attribute provider configured by
+// the properties under prefix, e.g. tca.attributes.ldap
+//
+type AttributeProviderFactory func(prefix string) (AttributeProvider, error)
+
+var (
+	attributeProviderFactories = map[string]AttributeProviderFactory{
+		"database": newDBAttributeProvider,
+		"ldap":     newLDAPAttributeProvider,
+		"rest":     newRESTAttributeProvider,
+	}
+	attributeProviderFactoriesLock sync.RWMutex
+)
+
+// RegisterAttributeProvider registers factory as the attribute source
+// name, which the TCA asks once it is listed by tca.attributes.sources
+//
+func RegisterAttributeProvider(name string, factory AttributeProviderFactory) error {
+	if name == "" || factory == nil {
+		return errors.New("Invalid attribute provider " + name)
+	}
+
+	attributeProviderFactoriesLock.Lock()
+	defer attributeProviderFactoriesLock.Unlock()
+
+	if _, ok := attributeProviderFactories[name]; ok {
+		return errors.New("Attribute provider " + name + " already registered")
+	}
+	attributeProviderFactories[name] = factory
+
+	return nil
+}
+
+// attributeSource is an attribute provider whose answers, including the
+// attributes a user does not have, are cached for ttl
+//
+type attributeSource struct {
+	name     string
+	provider AttributeProvider
+	ttl      time.Duration
+
+	mutex sync.Mutex
+	cache map[string]cachedAttribute
+}
+
+type cachedAttribute struct {
+	value   string
+	found   bool
+	expires time.Time
+}
+
+// readAttributeSources creates the attribute sources listed, in the order
+// they are asked in, by tca.attributes.sources. The oidc source reads the
+// attributes the ECA took from the ID tokens of the users.
+//
+func readAttributeSources(eca *ECA) ([]*attributeSource, error) {
+	var sources []*attributeSource
+	for _, name := range strings.Fields(GetConfigString("tca.attributes.sources")) {
+		prefix := "tca.attributes." + name
+
+		var provider AttributeProvider
+		var err error
+		if name == "oidc" {
+			provider = &tokenAttributeProvider{eca.db}
+		} else {
+			attributeProviderFactoriesLock.RLock()
+			factory, ok := attributeProviderFactories[name]
+			attributeProviderFactoriesLock.RUnlock()
+			if !ok {
+				return nil, errors.New("Unknown attribute source " + name)
+			}
+
+			if provider, err = factory(prefix); err != nil {
+				return nil, err
+			}
+		}
+
+		source := &attributeSource{name: name, provider: provider, cache: make(map[string]cachedAttribute)}
+		if ttl := GetConfigString(prefix + ".ttl"); ttl != "" {
+			if source.ttl, err = time.ParseDuration(ttl); err != nil {
+				return nil, err
+			}
+		}
+		sources = append(sources, source)
+	}
+
+	return sources, nil
+}
+
+// attributes returns the values of the attributes names of the user id,
+// asking the provider only for those not cached anymore
+//
+func (source *attributeSource) attributes(id, affiliation string, names []string) (map[string]string, error) {
+	now := time.Now()
+	values := make(map[string]string)
+	var missing []string
+
+	source.mutex.Lock()
+	for _, name := range names {
+		cached, ok := source.cache[id+"\x00"+name]
+		if !ok || now.After(cached.expires) {
+			missing = append(missing, name)
+			continue
+		}
+		if cached.found {
+			values[name] = cached.value
+		}
+	}
+	source.mutex.Unlock()
+
+	if len(missing) == 0 {
+		return values, nil
+	}
+
+	fetched, err := source.provider.Attributes(id, affiliation, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+
+	for key, cached := range source.cache {
+		if now.After(cached.expires) {
+			delete(source.cache, key)
+		}
+	}
+	for _, name := range missing {
+		value, found := fetched[name]
+		if found {
+			values[name] = value
+		}
+		if source.ttl > 0 {
+			source.cache[id+"\x00"+name] = cachedAttribute{value, found, now.Add(source.ttl)}
+		}
+	}
+
+	return values, nil
+}
+
+// resolveAttributes returns the attributes requested for the user of
+// enrollmentID with the values the attribute sources, asked in order, have
+// for them. Attributes no source has are left out. The requested values
+// stand if no source is configured.
+//
+func (tca *TCA) resolveAttributes(enrollmentID string, requested []*pb.TCertAttribute) ([]*pb.TCertAttribute, error) {
+	if len(tca.attrSources) == 0 || len(requested) == 0 {
+		return requested, nil
+	}
+
+	id, _, affiliation, err := tca.eca.parseEnrollID(enrollmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, a := range requested {
+		if !seen[a.AttributeName] {
+			seen[a.AttributeName] = true
+			names = append(names, a.AttributeName)
+		}
+	}
+
+	values := make(map[string]string)
+	var asked []string
+	for _, source := range tca.attrSources {
+		var pending []string
+		for _, name := range names {
+			if _, ok := values[name]; !ok {
+				pending = append(pending, name)
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+
+		fetched, err := source.attributes(id, affiliation, pending)
+		if err != nil {
+			Error.Println("Failed reading attributes of "+id+" from "+source.name+":", err)
+			return nil, err
+		}
+		asked = append(asked, source.name)
+		for name, value := range fetched {
+			values[name] = value
+		}
+	}
+
+	var attributes []*pb.TCertAttribute
+	for _, name := range names {
+		value, ok := values[name]
+		if !ok {
+			Trace.Println("No attribute source has attribute " + name + " of " + id + ".")
+			continue
+		}
+		attributes = append(attributes, &pb.TCertAttribute{AttributeName: name, AttributeValue: value})
+	}
+	tca.eca.auditLog.append(id, auditAttributes, id, "names="+strings.Join(names, ",")+" sources="+strings.Join(asked, ","))
+
+	return attributes, nil
+}
+
+// closeAttributeSources closes the providers of the attribute sources
+//
+func (tca *TCA) closeAttributeSources() {
+	for _, source := range tca.attrSources {
+		if closer, ok := source.provider.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				Error.Println(err)
+			}
+		}
+	}
+}
+
+// dbAttributeProvider reads attributes from a database, such as one kept in
+// sync with an HR system. Its query has two parameters, the enrollment ID
+// and the name of the attribute, and returns the value of the attribute.
+//
+type dbAttributeProvider struct {
+	db    *sql.DB
+	query string
+}
+
+func newDBAttributeProvider(prefix string) (AttributeProvider, error) {
+	driver := GetConfigString(prefix + ".driver")
+	if driver == "" {
+		driver = DBDriverSQLite
+	}
+	query := GetConfigString(prefix + ".query")
+	if query == "" {
+		return nil, errors.New("The query of the attribute database is missing")
+	}
+
+	db, err := sql.Open(driver, GetConfigString(prefix+".datasource"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &dbAttributeProvider{db, query}, nil
+}
+
+func (provider *dbAttributeProvider) Attributes(id, affiliation string, names []string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, name := range names {
+		var value string
+		err := provider.db.QueryRow(provider.query, id, name).Scan(&value)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[name] = value
+	}
+
+	return values, nil
+}
+
+func (provider *dbAttributeProvider) Close() error {
+	return provider.db.Close()
+}
+
+// ldapAttributeProvider reads attributes from the entries of the users
+// of an LDAP or Active Directory server, binding as binddn. Attributes
+// are mapped to the LDAP attributes they are read from.
+//
+type ldapAttributeProvider struct {
+	dir        *ldapDirectory
+	bindDN     string
+	password   []byte
+	attributes map[string]string
+}
+
+func newLDAPAttributeProvider(prefix string) (AttributeProvider, error) {
+	dir, err := readLDAPDirectory(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ldapAttributeProvider{
+		dir:        dir,
+		bindDN:     GetConfigString(prefix + ".binddn"),
+		password:   []byte(GetConfigString(prefix + ".password")),
+		attributes: viper.GetStringMapString(prefix + ".attributes"),
+	}, nil
+}
+
+func (provider *ldapAttributeProvider) Attributes(id, affiliation string, names []string) (map[string]string, error) {
+	conn, err := provider.dir.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(provider.dir.timeout))
+
+	ldap := &ldapConn{conn: conn, r: bufio.NewReader(conn)}
+	defer ldap.unbind()
+
+	if provider.bindDN != "" {
+		if err := ldap.bind(provider.bindDN, provider.password); err != nil {
+			return nil, err
+		}
+	}
+
+	dn := fmt.Sprintf(provider.dir.userDN, escapeDN(id))
+	values := make(map[string]string)
+	for _, name := range names {
+		// the keys of maps read by viper are lower case
+		attr, ok := provider.attributes[strings.ToLower(name)]
+		if !ok {
+			continue
+		}
+		vals, err := ldap.readAttribute(dn, attr)
+		if err != nil {
+			return nil, err
+		}
+		if len(vals) > 0 {
+			values[name] = vals[0]
+		}
+	}
+
+	return values, nil
+}
+
+// restAttributeProvider asks a web service for attributes with
+// GET url?id=<id>&affiliation=<affiliation>&attribute=<name>&..., answered
+// with a JSON object of the values of the attributes the user has. Users
+// the service does not know are answered with 404.
+//
+type restAttributeProvider struct {
+	url    *url.URL
+	token  string
+	client *http.Client
+}
+
+func newRESTAttributeProvider(prefix string) (AttributeProvider, error) {
+	u, err := url.Parse(GetConfigString(prefix + ".url"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("Invalid attribute service URL " + u.String())
+	}
+
+	timeout := 5 * time.Second
+	if t := GetConfigString(prefix + ".timeout"); t != "" {
+		if timeout, err = time.ParseDuration(t); err != nil {
+			return nil, err
+		}
+	}
+
+	return &restAttributeProvider{u, GetConfigString(prefix + ".token"), &http.Client{Timeout: timeout}}, nil
+}
+
+func (provider *restAttributeProvider) Attributes(id, affiliation string, names []string) (map[string]string, error) {
+	u := *provider.url
+	query := u.Query()
+	query.Set("id", id)
+	query.Set("affiliation", affiliation)
+	for _, name := range names {
+		query.Add("attribute", name)
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if provider.token != "" {
+		req.Header.Set("Authorization", "Bearer "+provider.token)
+	}
+
+	resp, err := provider.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return map[string]string{}, nil
+	default:
+		return nil, errors.New("The attribute service answered " + resp.Status)
+	}
+
+	var answer map[string]string
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&answer); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for _, name := range names {
+		if value, ok := answer[name]; ok {
+			values[name] = value
+		}
+	}
+
+	return values, nil
+}
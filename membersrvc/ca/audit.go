@@ -0,0 +1,266 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	protobuf "google/protobuf"
+
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+)
+
+// Operations recorded in the audit log
+//
+const (
+	auditRegister   = "register"
+	auditEnroll     = "enroll"
+	auditRotate     = "rotate"
+	auditTCerts     = "tcerts"
+	auditAttributes = "attributes"
+	auditRevoke     = "revoke"
+	auditEscrow     = "escrow"
+)
+
+// maxAuditRecords is the most records read from the audit log at once
+//
+const maxAuditRecords = 1000
+
+// auditAttempts is how many times a record is appended before giving up,
+// replicas appending concurrently taking the same sequence number
+//
+const auditAttempts = 5
+
+// auditLog is the tamper-evident log of the operations of the CAs. Each
+// record carries the hash of its contents and of the hash of the previous
+// record, so that altering, inserting or removing records, except the
+// last ones, breaks the chain of hashes.
+//
+type auditLog struct {
+	db    *caDB
+	mutex sync.Mutex
+}
+
+// append records operation, requested by requester, on the user subject.
+// The requester is empty for requests that are not authenticated. The
+// operation is not undone if it cannot be recorded, but the failure is
+// logged.
+//
+func (log *auditLog) append(requester, operation, subject, details string) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	var err error
+	for i := 0; i < auditAttempts; i++ {
+		if err = log.appendRecord(requester, operation, subject, details); err == nil {
+			return
+		}
+	}
+	Error.Println("Failed recording "+operation+" of "+subject+" in the audit log:", err)
+}
+
+// appendRecord appends a record after the last one, failing if a replica
+// appended another meanwhile
+//
+func (log *auditLog) appendRecord(requester, operation, subject, details string) error {
+	tx, err := log.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var seq int64
+	var prev []byte
+	err = tx.QueryRow("SELECT row, hash FROM AuditLog ORDER BY row DESC LIMIT 1").Scan(&seq, &prev)
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		return err
+	}
+
+	now := time.Now()
+	rec := &pb.AuditRecord{
+		Seq:       uint64(seq + 1),
+		Ts:        &protobuf.Timestamp{Seconds: now.Unix(), Nanos: int32(now.Nanosecond())},
+		Requester: requester,
+		Operation: operation,
+		Subject:   subject,
+		Details:   details,
+	}
+	rec.Hash = auditHash(prev, rec)
+
+	if _, err := tx.Exec("INSERT INTO AuditLog (row, timestamp, requester, operation, subject, details, hash) VALUES (?, ?, ?, ?, ?, ?, ?)", seq+1, now.UnixNano(), requester, operation, subject, details, rec.Hash); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// auditHash returns the hash chaining rec to the record whose hash is prev:
+// SHA-256(prev | seq | seconds | nanos | requester | operation | subject |
+// details), integers big-endian and strings prefixed with their length as
+// 32 bit big-endian integers
+//
+func auditHash(prev []byte, rec *pb.AuditRecord) []byte {
+	h := sha256.New()
+	h.Write(prev)
+	binary.Write(h, binary.BigEndian, rec.Seq)
+	binary.Write(h, binary.BigEndian, rec.Ts.Seconds)
+	binary.Write(h, binary.BigEndian, rec.Ts.Nanos)
+	for _, field := range []string{rec.Requester, rec.Operation, rec.Subject, rec.Details} {
+		binary.Write(h, binary.BigEndian, uint32(len(field)))
+		h.Write([]byte(field))
+	}
+
+	return h.Sum(nil)
+}
+
+// registrationDetails describes the registration of a user of role,
+// member of affiliation
+//
+func registrationDetails(role pb.Role, affiliation string) string {
+	details := "role=" + strconv.Itoa(int(role))
+	if affiliation != "" {
+		details += " affiliation=" + affiliation
+	}
+
+	return details
+}
+
+// tcertDetails describes the issuance of num TCerts with attributes
+//
+func tcertDetails(num int, attributes []*pb.TCertAttribute) string {
+	details := "num=" + strconv.Itoa(num)
+	if len(attributes) > 0 {
+		names := make([]string, len(attributes))
+		for i, a := range attributes {
+			names[i] = a.AttributeName
+		}
+		details += " attributes=" + strings.Join(names, ",")
+	}
+
+	return details
+}
+
+// scanAuditRecord reads the audit record at the cursor of rows
+//
+func scanAuditRecord(rows *sql.Rows) (*pb.AuditRecord, error) {
+	var seq, ts int64
+	rec := new(pb.AuditRecord)
+	if err := rows.Scan(&seq, &ts, &rec.Requester, &rec.Operation, &rec.Subject, &rec.Details, &rec.Hash); err != nil {
+		return nil, err
+	}
+	rec.Seq = uint64(seq)
+	rec.Ts = &protobuf.Timestamp{Seconds: ts / 1e9, Nanos: int32(ts % 1e9)}
+
+	return rec, nil
+}
+
+// read returns the records matching the request in, in order, along with
+// the hash of the record before the first one
+//
+func (log *auditLog) read(in *pb.AuditLogReadReq) ([]*pb.AuditRecord, []byte, error) {
+	query := "SELECT row, timestamp, requester, operation, subject, details, hash FROM AuditLog WHERE row>?"
+	args := []interface{}{int64(in.After)}
+	if in.Begin != nil {
+		query += " AND timestamp>=?"
+		args = append(args, in.Begin.Seconds*1e9+int64(in.Begin.Nanos))
+	}
+	if in.End != nil {
+		query += " AND timestamp<?"
+		args = append(args, in.End.Seconds*1e9+int64(in.End.Nanos))
+	}
+	if in.Requester != "" {
+		query += " AND requester=?"
+		args = append(args, in.Requester)
+	}
+	if in.Operation != "" {
+		query += " AND operation=?"
+		args = append(args, in.Operation)
+	}
+	if in.Subject != "" {
+		query += " AND subject=?"
+		args = append(args, in.Subject)
+	}
+	max := int(in.Max)
+	if max <= 0 || max > maxAuditRecords {
+		max = maxAuditRecords
+	}
+	query += " ORDER BY row LIMIT " + strconv.Itoa(max)
+
+	rows, err := log.db.Query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var records []*pb.AuditRecord
+	for rows.Next() {
+		rec, err := scanAuditRecord(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		records = append(records, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var prev []byte
+	if len(records) > 0 && records[0].Seq > 1 {
+		err := log.db.QueryRow("SELECT hash FROM AuditLog WHERE row=?", int64(records[0].Seq-1)).Scan(&prev)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, nil, err
+		}
+	}
+
+	return records, prev, nil
+}
+
+// verify checks the chain of hashes of the whole log and returns the
+// sequence number of the first record that does not match it, 0 if all do
+//
+func (log *auditLog) verify() (uint64, error) {
+	rows, err := log.db.Query("SELECT row, timestamp, requester, operation, subject, details, hash FROM AuditLog ORDER BY row")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var prev []byte
+	var seq uint64
+	for rows.Next() {
+		rec, err := scanAuditRecord(rows)
+		if err != nil {
+			return 0, err
+		}
+		seq++
+		if rec.Seq != seq || !bytes.Equal(rec.Hash, auditHash(prev, rec)) {
+			Warning.Println("The audit log is broken at record " + strconv.FormatUint(seq, 10) + ".")
+			return seq, nil
+		}
+		prev = rec.Hash
+	}
+
+	return 0, rows.Err()
+}
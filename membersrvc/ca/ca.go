@@ -17,6 +17,7 @@ limitations under the License.
 package ca
 
 import (
+	gocrypto "crypto"
 	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/x509"
@@ -29,22 +30,51 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/primitives/secp256k1"
+	"github.com/hyperledger/fabric/core/util"
 	pb "github.com/hyperledger/fabric/membersrvc/protos"
-	_ "github.com/mattn/go-sqlite3" // TODO: justify this blank import or remove
 )
 
 // CA is the base certificate authority.
 type CA struct {
-	db *sql.DB
+	db *caDB
+
+	// name is the name of the CA, e.g. in its metrics
+	name string
 
 	path string
 
-	priv *ecdsa.PrivateKey
+	priv gocrypto.Signer
 	cert *x509.Certificate
 	raw  []byte
+
+	// hsm holds priv if the CA keeps its signing key on an HSM
+	hsm HSM
+
+	// chain holds the DER encoded intermediate certificates linking
+	// cert to its root, when cert is not self-signed
+	chain []byte
+
+	// crl is the last published CRL, valid until crlNextUpdate.
+	// crlRevocations is the last revocation it lists; replicas
+	// sharing the database may have revoked certificates since.
+	crlMutex       sync.Mutex
+	crl            []byte
+	crlNextUpdate  time.Time
+	crlValidity    time.Duration
+	crlRevocations int64
+
+	// crlSchedule is the period CRLs are published at, if any
+	crlSchedule time.Duration
+	crlStop     chan struct{}
+	crlDone     chan struct{}
+
+	// replica identifies the CA among the replicas sharing its database
+	replica string
 }
 
 // CertificateSpec defines the parameter used to create a new certificate.
@@ -182,9 +212,31 @@ func (spec *CertificateSpec) GetExtensions() *[]pkix.Extension {
 	return spec.ext
 }
 
+// caMigrations create and update the tables common to all CAs
+//
+var caMigrations = []string{
+	"CREATE TABLE IF NOT EXISTS Certificates (row INTEGER PRIMARY KEY, id VARCHAR(64), timestamp INTEGER, usage INTEGER, cert BLOB, hash BLOB, kdfkey BLOB)",
+	"CREATE TABLE IF NOT EXISTS Users (row INTEGER PRIMARY KEY, id VARCHAR(64), enrollmentId VARCHAR(100), role INTEGER, token BLOB, state INTEGER, key BLOB)",
+	"CREATE TABLE IF NOT EXISTS AffiliationGroups (row INTEGER PRIMARY KEY, name VARCHAR(64), parent INTEGER, FOREIGN KEY(parent) REFERENCES AffiliationGroups(row))",
+	"CREATE TABLE IF NOT EXISTS Revocations (row INTEGER PRIMARY KEY, serial VARCHAR(64) UNIQUE, revoked INTEGER, expires INTEGER)",
+	"ALTER TABLE Certificates ADD COLUMN serial VARCHAR(64)",
+	"ALTER TABLE Certificates ADD COLUMN replica VARCHAR(64)",
+	"CREATE UNIQUE INDEX CertificatesSerial ON Certificates (serial)",
+	"ALTER TABLE Users ADD COLUMN enrollments INTEGER DEFAULT 0",
+	"ALTER TABLE Users ADD COLUMN maxEnrollments INTEGER DEFAULT 0",
+	"ALTER TABLE Users ADD COLUMN expires INTEGER DEFAULT 0",
+	"UPDATE Users SET enrollments=1 WHERE state=2",
+}
+
+// maxSerialAttempts bounds the serial numbers drawn for a certificate
+// before giving up
+//
+const maxSerialAttempts = 8
+
 // NewCA sets up a new CA.
 func NewCA(name string) *CA {
 	ca := new(CA)
+	ca.name = name
 	ca.path = GetConfigString("server.rootpath") + "/" + GetConfigString("server.cadir")
 
 	if _, err := os.Stat(ca.path); err != nil {
@@ -196,38 +248,60 @@ func NewCA(name string) *CA {
 	}
 
 	// open or create certificate database
-	db, err := sql.Open("sqlite3", ca.path+"/"+name+".db")
+	db, err := openCADB(ca.path, name)
 	if err != nil {
 		Panic.Panicln(err)
 	}
-
-	if err := db.Ping(); err != nil {
+	if err := db.migrate("ca", caMigrations); err != nil {
 		Panic.Panicln(err)
 	}
-	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS Certificates (row INTEGER PRIMARY KEY, id VARCHAR(64), timestamp INTEGER, usage INTEGER, cert BLOB, hash BLOB, kdfkey BLOB)"); err != nil {
-		Panic.Panicln(err)
+	ca.db = db
+
+	// replicas sharing the database tell apart the certificates they issue
+	ca.replica = GetConfigString("server.replica")
+	if ca.replica == "" {
+		if ca.replica, err = os.Hostname(); err != nil {
+			Panic.Panicln(err)
+		}
 	}
-	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS Users (row INTEGER PRIMARY KEY, id VARCHAR(64), enrollmentId VARCHAR(100), role INTEGER, token BLOB, state INTEGER, key BLOB)"); err != nil {
-		Panic.Panicln(err)
+	Info.Println("Starting " + name + " as replica " + ca.replica + ".")
+	metrics.register(name, ca)
+
+	ca.crlValidity = 24 * time.Hour
+	if validity := GetConfigString("pki.crl.validity"); validity != "" {
+		d, err := time.ParseDuration(validity)
+		if err != nil {
+			Panic.Panicln(err)
+		}
+		ca.crlValidity = d
 	}
-	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS AffiliationGroups (row INTEGER PRIMARY KEY, name VARCHAR(64), parent INTEGER, FOREIGN KEY(parent) REFERENCES AffiliationGroups(row))"); err != nil {
-		Panic.Panicln(err)
+	if schedule := GetConfigString("pki.crl.schedule"); schedule != "" {
+		d, err := time.ParseDuration(schedule)
+		if err != nil {
+			Panic.Panicln(err)
+		}
+		ca.crlSchedule = d
 	}
-	ca.db = db
 
-	// read or create signing key pair
-	priv, err := ca.readCAPrivateKey(name)
-	if err != nil {
-		priv = ca.createCAKeyPair(name)
+	// read or create signing key pair, on the HSM if the CA keeps its key there
+	raw, certErr := ca.readCACertificate(name)
+	if isKeyInHSM(name) {
+		if ca.priv, err = ca.readHSMKey(name, certErr != nil); err != nil {
+			Panic.Panicln(err)
+		}
+	} else {
+		priv, err := ca.readCAPrivateKey(name)
+		if err != nil {
+			priv = ca.createCAKeyPair(name)
+		}
+		ca.priv = priv
 	}
-	ca.priv = priv
 
-	// read CA certificate, or create a self-signed CA certificate
-	raw, err := ca.readCACertificate(name)
-	if err != nil {
-		raw = ca.createCACertificate(name, &ca.priv.PublicKey)
+	// or create a self-signed CA certificate
+	if certErr != nil {
+		raw = ca.createCACertificate(name, ca.priv.Public())
 	}
-	cert, err := x509.ParseCertificate(raw)
+	cert, err := secp256k1.ParseCertificate(raw)
 	if err != nil {
 		Panic.Panicln(err)
 	}
@@ -235,12 +309,34 @@ func NewCA(name string) *CA {
 	ca.raw = raw
 	ca.cert = cert
 
+	if err := ca.checkCAKey(); err != nil {
+		Panic.Panicln(err)
+	}
+
+	// read the intermediate certificates, if any, the CA certificate chains to
+	chain, err := ca.readCACertificateChain(name)
+	if err != nil {
+		Panic.Panicln(err)
+	}
+	ca.chain = chain
+
 	return ca
 }
 
 // Close closes down the CA.
 func (ca *CA) Close() {
+	if ca.crlStop != nil {
+		close(ca.crlStop)
+		<-ca.crlDone
+		ca.crlStop = nil
+	}
+
+	metrics.unregister(ca.name, ca)
 	ca.db.Close()
+
+	if ca.hsm != nil {
+		ca.hsm.Close()
+	}
 }
 
 func (ca *CA) createCAKeyPair(name string) *ecdsa.PrivateKey {
@@ -291,7 +387,7 @@ func (ca *CA) readCAPrivateKey(name string) (*ecdsa.PrivateKey, error) {
 	return x509.ParseECPrivateKey(block.Bytes)
 }
 
-func (ca *CA) createCACertificate(name string, pub *ecdsa.PublicKey) []byte {
+func (ca *CA) createCACertificate(name string, pub gocrypto.PublicKey) []byte {
 	Trace.Println("Creating CA certificate.")
 
 	raw, err := ca.newCertificate(name, pub, x509.KeyUsageDigitalSignature|x509.KeyUsageCertSign, nil)
@@ -324,9 +420,74 @@ func (ca *CA) readCACertificate(name string) ([]byte, error) {
 	return block.Bytes, nil
 }
 
+// readCACertificateChain reads the PEM bundle of intermediate certificates
+// stored next to the CA certificate. A missing bundle means the CA
+// certificate is a root.
+func (ca *CA) readCACertificateChain(name string) ([]byte, error) {
+	Trace.Println("Reading CA certificate chain.")
+
+	cooked, err := ioutil.ReadFile(ca.path + "/" + name + ".chain")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var chain []byte
+	for {
+		var block *pem.Block
+		block, cooked = pem.Decode(cooked)
+		if block == nil {
+			break
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, err
+		}
+		chain = append(chain, block.Bytes...)
+	}
+
+	return chain, nil
+}
+
+// certificateChain returns the CA certificate followed by its intermediate
+// certificates, DER encoded and concatenated.
+func (ca *CA) certificateChain() []byte {
+	return append(append([]byte(nil), ca.raw...), ca.chain...)
+}
+
 func (ca *CA) createCertificate(id string, pub interface{}, usage x509.KeyUsage, timestamp int64, kdfKey []byte, opt ...pkix.Extension) ([]byte, error) {
-	spec := NewDefaultCertificateSpec(id, pub, usage, opt...)
-	return ca.createCertificateFromSpec(spec, timestamp, kdfKey)
+	return ca.createCertificateWithSerial(func(serial *big.Int) (*CertificateSpec, error) {
+		return NewDefaultPeriodCertificateSpec(id, serial, pub, usage, opt...), nil
+	}, timestamp, kdfKey)
+}
+
+// createCertificateWithSerial creates the certificate newSpec specifies
+// for a random serial number. Replicas sharing the database allocate
+// serial numbers optimistically: on a collision another one is drawn.
+//
+func (ca *CA) createCertificateWithSerial(newSpec func(serial *big.Int) (*CertificateSpec, error), timestamp int64, kdfKey []byte) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		spec, err := newSpec(util.GenerateIntUUID())
+		if err != nil {
+			return nil, err
+		}
+
+		raw, err := ca.createCertificateFromSpec(spec, timestamp, kdfKey)
+		if err == nil || attempt == maxSerialAttempts || !ca.isSerialTaken(spec.GetSerialNumber()) {
+			return raw, err
+		}
+		Warning.Println("Serial number " + spec.GetSerialNumber().String() + " taken, drawing another.")
+	}
+}
+
+// isSerialTaken returns true if a certificate with serial was issued
+//
+func (ca *CA) isSerialTaken(serial *big.Int) bool {
+	var count int
+	err := ca.db.QueryRow("SELECT count(row) FROM Certificates WHERE serial=?", serial.String()).Scan(&count)
+
+	return err == nil && count > 0
 }
 
 func (ca *CA) createCertificateFromSpec(spec *CertificateSpec, timestamp int64, kdfKey []byte) ([]byte, error) {
@@ -338,13 +499,23 @@ func (ca *CA) createCertificateFromSpec(spec *CertificateSpec, timestamp int64,
 		return nil, err
 	}
 
+	err = ca.storeCertificate(spec.GetID(), timestamp, spec.GetUsage(), raw, kdfKey, spec.GetSerialNumber())
+
+	return raw, err
+}
+
+// storeCertificate records the certificate raw, of serial, as issued to id
+//
+func (ca *CA) storeCertificate(id string, timestamp int64, usage x509.KeyUsage, raw, kdfKey []byte, serial *big.Int) error {
 	hash := primitives.NewHash()
 	hash.Write(raw)
-	if _, err = ca.db.Exec("INSERT INTO Certificates (id, timestamp, usage, cert, hash, kdfkey) VALUES (?, ?, ?, ?, ?, ?)", spec.GetID(), timestamp, spec.GetUsage(), raw, hash.Sum(nil), kdfKey); err != nil {
+	if _, err := ca.db.Exec("INSERT INTO Certificates (id, timestamp, usage, cert, hash, kdfkey, serial, replica) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", id, timestamp, usage, raw, hash.Sum(nil), kdfKey, serial.String(), ca.replica); err != nil {
 		Error.Println(err)
+		return err
 	}
+	metrics.countIssued(ca.name)
 
-	return raw, err
+	return nil
 }
 
 func (ca *CA) newCertificate(id string, pub interface{}, usage x509.KeyUsage, ext []pkix.Extension) ([]byte, error) {
@@ -385,7 +556,7 @@ func (ca *CA) newCertificateFromSpec(spec *CertificateSpec) ([]byte, error) {
 		parent = &tmpl
 	}
 
-	raw, err := x509.CreateCertificate(
+	raw, err := secp256k1.CreateCertificate(
 		rand.Reader,
 		&tmpl,
 		parent,
@@ -403,7 +574,7 @@ func (ca *CA) readCertificate(id string, usage x509.KeyUsage) ([]byte, error) {
 	Trace.Println("Reading certificate for " + id + ".")
 
 	var raw []byte
-	err := ca.db.QueryRow("SELECT cert FROM Certificates WHERE id=? AND usage=?", id, usage).Scan(&raw)
+	err := ca.db.QueryRow("SELECT cert FROM Certificates WHERE id=? AND usage=? ORDER BY timestamp DESC", id, usage).Scan(&raw)
 
 	return raw, err
 }
@@ -424,7 +595,7 @@ func (ca *CA) readCertificates(id string, opt ...int64) (*sql.Rows, error) {
 		return ca.db.Query("SELECT cert, kdfkey FROM Certificates WHERE id=? AND timestamp=? ORDER BY usage", id, opt[0])
 	}
 
-	return ca.db.Query("SELECT cert, kdfkey FROM Certificates WHERE id=?", id)
+	return ca.db.Query("SELECT cert, kdfkey FROM Certificates WHERE id=? ORDER BY timestamp DESC, usage", id)
 }
 
 func (ca *CA) readCertificateSets(id string, start, end int64) (*sql.Rows, error) {
@@ -443,6 +614,228 @@ func (ca *CA) readCertificateByHash(hash []byte) ([]byte, error) {
 	return raw, err
 }
 
+// readCertificateOwner returns the id and the timestamp of the certificate
+// with the passed hash.
+//
+func (ca *CA) readCertificateOwner(hash []byte) (string, int64, error) {
+	var id string
+	var ts int64
+	err := ca.db.QueryRow("SELECT id, timestamp FROM Certificates WHERE hash=?", hash).Scan(&id, &ts)
+
+	return id, ts, err
+}
+
+// revokeCertificate revokes the certificate with the passed hash and
+// invalidates the published CRL.
+//
+func (ca *CA) revokeCertificate(hash []byte) error {
+	raw, err := ca.readCertificateByHash(hash)
+	if err != nil {
+		return err
+	}
+
+	return ca.revoke([][]byte{raw})
+}
+
+// revokeCertificates revokes the certificates of id issued at timestamp ts
+// and invalidates the published CRL.
+//
+func (ca *CA) revokeCertificates(id string, ts int64) error {
+	rows, err := ca.readCertificates(id, ts)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var certs [][]byte
+	for rows.Next() {
+		var raw, kdfKey []byte
+		if err := rows.Scan(&raw, &kdfKey); err != nil {
+			return err
+		}
+		certs = append(certs, raw)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(certs) == 0 {
+		return errors.New("No certificates to revoke.")
+	}
+
+	return ca.revoke(certs)
+}
+
+// revokeMatchingCertificates revokes the certificates selected by cond and
+// invalidates the published CRL. It returns the number of certificates.
+//
+func (ca *CA) revokeMatchingCertificates(cond string, args ...interface{}) (int, error) {
+	rows, err := ca.db.Query("SELECT cert FROM Certificates WHERE "+cond, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var certs [][]byte
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			return 0, err
+		}
+		certs = append(certs, raw)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	return len(certs), ca.revoke(certs)
+}
+
+// isRevoked returns true if the certificate with serial is revoked, or
+// if that cannot be told
+//
+func (ca *CA) isRevoked(serial *big.Int) bool {
+	var count int
+	err := ca.db.QueryRow("SELECT count(row) FROM Revocations WHERE serial=?", serial.String()).Scan(&count)
+
+	return err != nil || count > 0
+}
+
+func (ca *CA) revoke(certs [][]byte) error {
+	now := time.Now().Unix()
+	for _, raw := range certs {
+		cert, err := secp256k1.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+
+		Trace.Println("Revoking certificate " + cert.SerialNumber.String() + ".")
+		if _, err := ca.db.Exec("INSERT OR IGNORE INTO Revocations (serial, revoked, expires) VALUES (?, ?, ?)", cert.SerialNumber.String(), now, cert.NotAfter.Unix()); err != nil {
+			return err
+		}
+	}
+
+	ca.crlMutex.Lock()
+	ca.crl = nil
+	ca.crlMutex.Unlock()
+
+	return nil
+}
+
+// readCRL returns the last published CRL, publishing a new one if there is
+// none, it is about to expire, or certificates were revoked since.
+//
+func (ca *CA) readCRL() ([]byte, error) {
+	ca.crlMutex.Lock()
+	defer ca.crlMutex.Unlock()
+
+	last, err := ca.lastRevocation()
+	if err != nil {
+		return nil, err
+	}
+	if ca.crl != nil && last == ca.crlRevocations && time.Now().Add(ca.crlValidity/2).Before(ca.crlNextUpdate) {
+		return ca.crl, nil
+	}
+
+	return ca.createCRL()
+}
+
+// publishCRL creates and publishes a new CRL.
+//
+func (ca *CA) publishCRL() ([]byte, error) {
+	ca.crlMutex.Lock()
+	defer ca.crlMutex.Unlock()
+
+	return ca.createCRL()
+}
+
+// startCRLPublication publishes a new CRL every crlSchedule, if set,
+// until the CA is closed.
+//
+func (ca *CA) startCRLPublication() {
+	if ca.crlSchedule <= 0 {
+		return
+	}
+
+	ca.crlStop = make(chan struct{})
+	ca.crlDone = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+
+		ticker := time.NewTicker(ca.crlSchedule)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			if _, err := ca.publishCRL(); err != nil {
+				Error.Println(err)
+			}
+		}
+	}(ca.crlStop, ca.crlDone)
+}
+
+// createCRL creates a CRL listing the revoked certificates that are not
+// expired yet. The caller must hold crlMutex.
+//
+func (ca *CA) createCRL() ([]byte, error) {
+	Trace.Println("Creating CRL.")
+
+	last, err := ca.lastRevocation()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	rows, err := ca.db.Query("SELECT serial, revoked FROM Revocations WHERE expires>? AND row<=? ORDER BY row", now.Unix(), last)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revoked []pkix.RevokedCertificate
+	for rows.Next() {
+		var serial string
+		var ts int64
+		if err := rows.Scan(&serial, &ts); err != nil {
+			return nil, err
+		}
+
+		serialNumber, ok := new(big.Int).SetString(serial, 10)
+		if !ok {
+			return nil, errors.New("Invalid serial number " + serial + ".")
+		}
+		revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: serialNumber, RevocationTime: time.Unix(ts, 0).UTC()})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	nextUp := now.Add(ca.crlValidity)
+	raw, err := ca.cert.CreateCRL(rand.Reader, ca.priv, revoked, now, nextUp)
+	if err != nil {
+		Error.Println(err)
+		return nil, err
+	}
+
+	ca.crl = raw
+	ca.crlNextUpdate = nextUp
+	ca.crlRevocations = last
+
+	return raw, nil
+}
+
+// lastRevocation returns the row of the last revocation, by any replica
+//
+func (ca *CA) lastRevocation() (int64, error) {
+	var last int64
+	err := ca.db.QueryRow("SELECT COALESCE(MAX(row), 0) FROM Revocations").Scan(&last)
+
+	return last, err
+}
+
 func (ca *CA) isValidAffiliation(affiliation string) (bool, error) {
 	Trace.Println("Validating affiliation: " + affiliation)
 
@@ -632,6 +1025,15 @@ func (ca *CA) readRole(id string) int {
 	return role
 }
 
+// readState reads the enrollment state of the user id
+//
+func (ca *CA) readState(id string) int {
+	var state int
+	ca.db.QueryRow("SELECT state FROM Users WHERE id=?", id).Scan(&state)
+
+	return state
+}
+
 func (ca *CA) readAffiliationGroups() ([]*AffiliationGroup, error) {
 	Trace.Println("Reading affilition groups.")
 
@@ -17,12 +17,44 @@ limitations under the License.
 package ca
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	protobuf "google/protobuf"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/crypto"
+	"github.com/hyperledger/fabric/core/crypto/attributes"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/primitives/ecies"
+	"github.com/hyperledger/fabric/core/crypto/primitives/secp256k1"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 const (
@@ -34,6 +66,40 @@ var (
 	caFiles = [4]string{name + ".cert", name + ".db", name + ".priv", name + ".pub"}
 )
 
+func TestEd25519Certificate(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(os.Stdout, os.Stdout, os.Stdout, os.Stderr, os.Stdout)
+
+	ca := NewCA(name)
+	defer cleanupFiles(ca.path)
+
+	key, err := primitives.NewEd25519Key()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s]", err)
+	}
+	spec := NewDefaultCertificateSpecWithCommonName("ed25519-user", "ed25519-user", key.Public(), x509.KeyUsageDigitalSignature)
+	raw, err := ca.newCertificateFromSpec(spec)
+	if err != nil {
+		t.Fatalf("Failed issuing certificate for an Ed25519 key [%s]", err)
+	}
+
+	cert, err := secp256k1.ParseCertificate(raw)
+	if err != nil {
+		t.Fatalf("Failed parsing certificate [%s]", err)
+	}
+	if pub, ok := cert.PublicKey.(ed25519.PublicKey); !ok || !bytes.Equal(pub, key.Public().(ed25519.PublicKey)) {
+		t.Fatalf("Certificate does not carry the Ed25519 key [%v]", cert.PublicKey)
+	}
+	if err := cert.CheckSignatureFrom(ca.cert); err != nil {
+		t.Fatalf("Certificate does not verify against the CA [%s]", err)
+	}
+	if err := primitives.CheckCertPKAgainstSK(cert, key); err != nil {
+		t.Fatalf("Certificate does not match the Ed25519 key [%s]", err)
+	}
+}
+
 func TestNewCA(t *testing.T) {
 
 	//init the crypto layer
@@ -99,3 +165,1882 @@ func TestNewCA(t *testing.T) {
 func cleanupFiles(path string) error {
 	return os.RemoveAll(path)
 }
+
+func TestVerifySignature(t *testing.T) {
+	raw := []byte("request")
+
+	key, err := primitives.NewEd25519Key()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s]", err)
+	}
+	sig, _ := primitives.Ed25519Sign(key, raw)
+	if err := verifySignature(key.Public(), &pb.Signature{Type: pb.CryptoType_ED25519, R: sig[:32], S: sig[32:]}, raw); err != nil {
+		t.Fatalf("Failed verifying Ed25519 signature [%s]", err)
+	}
+	if err := verifySignature(key.Public(), &pb.Signature{Type: pb.CryptoType_ECDSA, R: sig[:32], S: sig[32:]}, raw); err == nil {
+		t.Fatal("Signature of the wrong type must not verify")
+	}
+	if err := verifySignature(key.Public(), nil, raw); err == nil {
+		t.Fatal("Missing signature must not verify")
+	}
+}
+
+func newTestKey(t *testing.T) (*ecdsa.PrivateKey, *pb.PublicKey) {
+	key, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s]", err)
+	}
+	raw, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	return key, &pb.PublicKey{Type: pb.CryptoType_ECDSA, Key: raw}
+}
+
+func signTestRequest(t *testing.T, key *ecdsa.PrivateKey, msg proto.Message) *pb.Signature {
+	raw, _ := proto.Marshal(msg)
+	r, s, err := primitives.ECDSASignDirect(key, raw)
+	if err != nil {
+		t.Fatalf("Failed signing [%s]", err)
+	}
+	R, _ := r.MarshalText()
+	S, _ := s.MarshalText()
+	return &pb.Signature{Type: pb.CryptoType_ECDSA, R: R, S: S}
+}
+
+func TestRotateCertificatePair(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	eca.rotationGrace = 0
+	ecap := &ECAP{eca}
+
+	// enroll a user
+	newKey := func() (*ecdsa.PrivateKey, *pb.PublicKey) { return newTestKey(t) }
+	sign := func(key *ecdsa.PrivateKey, msg proto.Message) *pb.Signature { return signTestRequest(t, key, msg) }
+
+	id := "rotation_user"
+	if _, err := eca.registerUserWithErollID(id, id, pb.Role_CLIENT); err != nil {
+		t.Fatalf("Failed registering user [%s]", err)
+	}
+	oldKey, _ := newKey()
+	encKey, _ := newKey()
+	oldCert, _, _, err := eca.createCertificatePair(id, id, &oldKey.PublicKey, &encKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed creating certificate pair [%s]", err)
+	}
+	eca.db.Exec("UPDATE Users SET state=? WHERE id=?", 2, id)
+
+	// rotate
+	signKey, signPub := newKey()
+	_, encPub := newKey()
+	req := &pb.ECertRotateReq{Id: &pb.Identity{Id: id}, Cert: oldCert, Sign: signPub, Enc: encPub}
+	req.Pop = sign(signKey, req)
+	req.Sig = sign(oldKey, req)
+	replay := proto.Clone(req).(*pb.ECertRotateReq)
+
+	withoutPop := proto.Clone(req).(*pb.ECertRotateReq)
+	withoutPop.Pop, withoutPop.Sig = nil, nil
+	withoutPop.Sig = sign(oldKey, withoutPop)
+	if _, err := ecap.RotateCertificatePair(nil, withoutPop); err == nil {
+		t.Fatal("Rotating without proof of possession of the new key must fail")
+	}
+
+	resp, err := ecap.RotateCertificatePair(nil, req)
+	if err != nil {
+		t.Fatalf("Failed rotating certificate pair [%s]", err)
+	}
+	current, err := eca.readCertificate(id, x509.KeyUsageDigitalSignature)
+	if err != nil || !bytes.Equal(current, resp.Certs.Sign) {
+		t.Fatalf("Rotated certificate must be the current one [%s]", err)
+	}
+
+	// the replaced certificate is retired once the grace period is over
+	time.Sleep(time.Millisecond)
+	if _, err := ecap.ReadCertificateByHash(nil, &pb.Hash{Hash: primitives.Hash(oldCert)}); err == nil {
+		t.Fatal("Reading a retired certificate after the grace period must fail")
+	}
+	if _, err := ecap.RotateCertificatePair(nil, replay); err == nil {
+		t.Fatal("Rotating a replaced certificate must fail")
+	}
+}
+
+func TestRevokeCertificatePair(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	ecap := &ECAP{eca}
+
+	// enroll two users
+	enroll := func(id string) (*ecdsa.PrivateKey, []byte, []byte) {
+		if _, err := eca.registerUserWithErollID(id, id, pb.Role_CLIENT); err != nil {
+			t.Fatalf("Failed registering user [%s]", err)
+		}
+		signKey, _ := newTestKey(t)
+		encKey, _ := newTestKey(t)
+		sraw, eraw, _, err := eca.createCertificatePair(id, id, &signKey.PublicKey, &encKey.PublicKey)
+		if err != nil {
+			t.Fatalf("Failed creating certificate pair [%s]", err)
+		}
+		return signKey, sraw, eraw
+	}
+	ownerKey, ownerCert, ownerEncCert := enroll("revocation_owner")
+	otherKey, _, _ := enroll("revocation_other")
+
+	req := &pb.ECertRevokeReq{Id: &pb.Identity{Id: "revocation_other"}, Cert: &pb.Cert{ownerCert}}
+	req.Sig = signTestRequest(t, otherKey, req)
+	if _, err := ecap.RevokeCertificatePair(nil, req); err == nil {
+		t.Fatal("Revoking the certificate of another user must fail")
+	}
+
+	req = &pb.ECertRevokeReq{Id: &pb.Identity{Id: "revocation_owner"}, Cert: &pb.Cert{ownerCert}}
+	req.Sig = signTestRequest(t, ownerKey, req)
+	if _, err := ecap.RevokeCertificatePair(nil, req); err != nil {
+		t.Fatalf("Failed revoking certificate pair [%s]", err)
+	}
+
+	// the CRL lists both certificates of the pair
+	resp, err := ecap.ReadCRL(nil, &pb.Empty{})
+	if err != nil {
+		t.Fatalf("Failed reading CRL [%s]", err)
+	}
+	crl, err := x509.ParseCRL(resp.Crl)
+	if err != nil {
+		t.Fatalf("Failed parsing CRL [%s]", err)
+	}
+	if err := eca.cert.CheckCRLSignature(crl); err != nil {
+		t.Fatalf("Failed verifying CRL signature [%s]", err)
+	}
+	revoked := make(map[string]bool)
+	for _, entry := range crl.TBSCertList.RevokedCertificates {
+		revoked[entry.SerialNumber.String()] = true
+	}
+	for _, raw := range [][]byte{ownerCert, ownerEncCert} {
+		cert, _ := x509.ParseCertificate(raw)
+		if !revoked[cert.SerialNumber.String()] {
+			t.Fatalf("CRL must list revoked certificate [%s]", cert.SerialNumber)
+		}
+	}
+	if len(revoked) != 2 {
+		t.Fatalf("CRL must list only the revoked certificates, got [%d]", len(revoked))
+	}
+}
+
+func TestAttributeKeyRotation(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	if err := eca.registerAffiliationGroup("institution_a", ""); err != nil {
+		t.Fatalf("Failed registering affiliation group [%s]", err)
+	}
+	tca := NewTCA(eca)
+	defer tca.Close()
+	tcap := &TCAP{tca}
+
+	viper.Set("tca.attribute-encryption.enabled", true)
+	defer viper.Set("tca.attribute-encryption.enabled", false)
+
+	key, _ := newTestKey(t)
+	ecert := &x509.Certificate{Subject: pkix.Name{CommonName: "attribute_owner\\institution_a\\client"}}
+	issue := func(serial int64) *x509.Certificate {
+		attrs := []*pb.TCertAttribute{&pb.TCertAttribute{AttributeName: "company", AttributeValue: "ACompany"}}
+		exts, _, err := tcap.generateExtensions(big.NewInt(serial), []byte{1}, ecert, attrs)
+		if err != nil {
+			t.Fatalf("Failed generating extensions [%s]", err)
+		}
+		tmpl := &x509.Certificate{SerialNumber: big.NewInt(serial), ExtraExtensions: exts}
+		raw, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		if err != nil {
+			t.Fatalf("Failed creating certificate [%s]", err)
+		}
+		cert, _ := x509.ParseCertificate(raw)
+		return cert
+	}
+
+	before := issue(1)
+	if err := tca.rotateAttributeKey(true); err != nil {
+		t.Fatalf("Failed rotating attribute key [%s]", err)
+	}
+	after := issue(2)
+
+	if id, _ := attributes.GetKeyID(after); id != 1 {
+		t.Fatalf("TCerts must carry the id of the current key, got [%d]", id)
+	}
+	current, preKeys := tca.readAttributeKeys("institution_a")
+	if current != 1 || len(preKeys) != 2 {
+		t.Fatalf("Both keys must be in use during the retention window, got [%d] [%d]", current, len(preKeys))
+	}
+	for _, cert := range []*x509.Certificate{before, after} {
+		value, err := attributes.DecryptTCertAttribute(cert, "company", preKeys)
+		if err != nil || string(value) != "ACompany" {
+			t.Fatalf("Failed decrypting attribute [%s] [%v]", value, err)
+		}
+	}
+
+	// once the retention window is over only the current key is handed out
+	tca.attrKeyRetention = 0
+	if _, preKeys = tca.readAttributeKeys("institution_a"); len(preKeys) != 1 {
+		t.Fatalf("Retired keys must expire, got [%d]", len(preKeys))
+	}
+	if _, err := attributes.DecryptTCertAttribute(before, "company", preKeys); err == nil {
+		t.Fatal("Attributes under expired keys must not decrypt")
+	}
+}
+
+func TestDualKeyTCerts(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	if err := eca.registerAffiliationGroup("institution_a", ""); err != nil {
+		t.Fatalf("Failed registering affiliation group [%s]", err)
+	}
+	tca := NewTCA(eca)
+	defer tca.Close()
+	tcap := &TCAP{tca}
+
+	id := "dual_key_user"
+	signKey, _ := newTestKey(t)
+	encKey, _ := newTestKey(t)
+	if _, _, _, err := eca.createCertificatePair(id, id+"\\institution_a\\client", &signKey.PublicKey, &encKey.PublicKey); err != nil {
+		t.Fatalf("Failed creating certificate pair [%s]", err)
+	}
+
+	issue := func(dualKey bool) *x509.Certificate {
+		req := &pb.TCertCreateSetReq{Ts: &protobuf.Timestamp{Seconds: time.Now().Unix()}, Id: &pb.Identity{Id: id}, Num: 1, DualKey: dualKey}
+		req.Sig = signTestRequest(t, signKey, req)
+		resp, err := tcap.CreateCertificateSet(nil, req)
+		if err != nil {
+			t.Fatalf("Failed creating TCerts [%s]", err)
+		}
+		cert, err := x509.ParseCertificate(resp.Certs.Certs[0].Cert)
+		if err != nil {
+			t.Fatalf("Failed parsing TCert [%s]", err)
+		}
+		return cert
+	}
+
+	if pub, err := utils.GetTCertEncryptionKey(issue(false)); err != nil || pub != nil {
+		t.Fatalf("Single key TCerts must not carry an encryption key [%v]", err)
+	}
+	cert := issue(true)
+	pub, err := utils.GetTCertEncryptionKey(cert)
+	if err != nil || pub == nil {
+		t.Fatalf("Dual-key TCerts must carry an encryption key [%v]", err)
+	}
+	if !pub.Curve.IsOnCurve(pub.X, pub.Y) || pub.X.Cmp(cert.PublicKey.(*ecdsa.PublicKey).X) == 0 {
+		t.Fatal("The encryption key must be a point distinct from the signing key")
+	}
+}
+
+// testTCertStream collects the TCerts streamed by the TCA, cancelling
+// the stream once it has received cancelAfter of them
+type testTCertStream struct {
+	grpc.ServerStream
+	ctx         context.Context
+	cancel      context.CancelFunc
+	cancelAfter int
+	resps       []*pb.TCertCreateSetResp
+}
+
+func (stream *testTCertStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *testTCertStream) Send(resp *pb.TCertCreateSetResp) error {
+	stream.resps = append(stream.resps, resp)
+	if len(stream.resps) == stream.cancelAfter {
+		stream.cancel()
+	}
+	return nil
+}
+
+func TestStreamedTCerts(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	if err := eca.registerAffiliationGroup("institution_a", ""); err != nil {
+		t.Fatalf("Failed registering affiliation group [%s]", err)
+	}
+	tca := NewTCA(eca)
+	defer tca.Close()
+	tcap := &TCAP{tca}
+
+	id := "streaming_user"
+	signKey, _ := newTestKey(t)
+	encKey, _ := newTestKey(t)
+	if _, _, _, err := eca.createCertificatePair(id, id+"\\institution_a\\client", &signKey.PublicKey, &encKey.PublicKey); err != nil {
+		t.Fatalf("Failed creating certificate pair [%s]", err)
+	}
+
+	stream := func(num uint32, cancelAfter int) (*testTCertStream, error) {
+		req := &pb.TCertCreateSetReq{Ts: &protobuf.Timestamp{Seconds: time.Now().Unix()}, Id: &pb.Identity{Id: id}, Num: num}
+		req.Sig = signTestRequest(t, signKey, req)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s := &testTCertStream{ctx: ctx, cancel: cancel, cancelAfter: cancelAfter}
+		return s, tcap.CreateCertificateSetStream(req, s)
+	}
+
+	s, err := stream(3, 0)
+	if err != nil {
+		t.Fatalf("Failed streaming TCerts [%s]", err)
+	}
+	if len(s.resps) != 3 {
+		t.Fatalf("Expected 3 TCerts, got %d", len(s.resps))
+	}
+	for _, resp := range s.resps {
+		if len(resp.Certs.Certs) != 1 || !bytes.Equal(resp.Certs.Key, s.resps[0].Certs.Key) {
+			t.Fatal("Each response must carry one TCert and the key of the batch")
+		}
+		if _, err := x509.ParseCertificate(resp.Certs.Certs[0].Cert); err != nil {
+			t.Fatalf("Failed parsing TCert [%s]", err)
+		}
+	}
+
+	// the TCA stops issuing once the client cancels
+	if s, err = stream(5, 2); err == nil {
+		t.Fatal("A cancelled stream must fail")
+	}
+	if len(s.resps) != 2 {
+		t.Fatalf("Expected the TCA to stop after 2 TCerts, got %d", len(s.resps))
+	}
+}
+
+// testAttributeProvider has the company of every user, counting how
+// often it is asked
+type testAttributeProvider struct {
+	calls int
+}
+
+func (provider *testAttributeProvider) Attributes(id, affiliation string, names []string) (map[string]string, error) {
+	provider.calls++
+	values := make(map[string]string)
+	for _, name := range names {
+		if name == "company" {
+			values[name] = "company_of_" + id
+		}
+	}
+	return values, nil
+}
+
+func TestAttributeSources(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	provider := &testAttributeProvider{}
+	if err := RegisterAttributeProvider("test_source", func(prefix string) (AttributeProvider, error) { return provider, nil }); err != nil {
+		t.Fatalf("Failed registering attribute provider [%s]", err)
+	}
+	if err := RegisterAttributeProvider("test_source", func(prefix string) (AttributeProvider, error) { return provider, nil }); err == nil {
+		t.Fatal("Attribute providers must be registered once")
+	}
+
+	hr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer hr_token" || r.URL.Query().Get("affiliation") != "institution_a" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if r.URL.Query().Get("id") != "attribute_user" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"company": "hr_company", "position": "engineer"}`))
+	}))
+	defer hr.Close()
+
+	dir, _ := ioutil.TempDir("", "attributes")
+	defer os.RemoveAll(dir)
+	db, _ := sql.Open("sqlite3", dir+"/attributes.db")
+	db.Exec("CREATE TABLE Attributes (id VARCHAR(64), name VARCHAR(64), value VARCHAR(64))")
+	db.Exec("INSERT INTO Attributes (id, name, value) VALUES ('attribute_user', 'level', 'senior')")
+	db.Close()
+
+	settings := map[string]interface{}{
+		"tca.attributes.sources":             "test_source rest database",
+		"tca.attributes.test_source.ttl":     "1h",
+		"tca.attributes.rest.url":            hr.URL,
+		"tca.attributes.rest.token":          "hr_token",
+		"tca.attributes.database.datasource": dir + "/attributes.db",
+		"tca.attributes.database.query":      "SELECT value FROM Attributes WHERE id=? AND name=?",
+	}
+	for key, value := range settings {
+		viper.Set(key, value)
+		defer viper.Set(key, "")
+	}
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	if err := eca.registerAffiliationGroup("institution_a", ""); err != nil {
+		t.Fatalf("Failed registering affiliation group [%s]", err)
+	}
+	tca := NewTCA(eca)
+	defer tca.Close()
+	tcap := &TCAP{tca}
+
+	id := "attribute_user"
+	signKey, _ := newTestKey(t)
+	encKey, _ := newTestKey(t)
+	if _, _, _, err := eca.createCertificatePair(id, id+"\\institution_a\\client", &signKey.PublicKey, &encKey.PublicKey); err != nil {
+		t.Fatalf("Failed creating certificate pair [%s]", err)
+	}
+
+	requested := []*pb.TCertAttribute{
+		&pb.TCertAttribute{AttributeName: "company", AttributeValue: "forged_company"},
+		&pb.TCertAttribute{AttributeName: "position"},
+		&pb.TCertAttribute{AttributeName: "level"},
+		&pb.TCertAttribute{AttributeName: "clearance", AttributeValue: "top_secret"},
+	}
+	req := &pb.TCertCreateSetReq{Ts: &protobuf.Timestamp{Seconds: time.Now().Unix()}, Id: &pb.Identity{Id: id}, Num: 1, Attributes: requested}
+	req.Sig = signTestRequest(t, signKey, req)
+	resp, err := tcap.CreateCertificateSet(nil, req)
+	if err != nil {
+		t.Fatalf("Failed creating TCerts [%s]", err)
+	}
+	cert, err := x509.ParseCertificate(resp.Certs.Certs[0].Cert)
+	if err != nil {
+		t.Fatalf("Failed parsing TCert [%s]", err)
+	}
+
+	// the first source having an attribute wins
+	expected := map[string]string{"company": "company_of_attribute_user", "position": "engineer", "level": "senior"}
+	for name, value := range expected {
+		if actual, err := attributes.ReadTCertAttribute(cert, name); err != nil || string(actual) != value {
+			t.Fatalf("Expected attribute %s to be [%s], got [%s] [%v]", name, value, actual, err)
+		}
+	}
+	if _, err := attributes.ReadTCertAttribute(cert, "clearance"); err == nil {
+		t.Fatal("Attributes no source has must be left out")
+	}
+
+	// answers are cached for the ttl of the source
+	if _, err := tca.resolveAttributes(id+"\\institution_a\\client", requested[:1]); err != nil {
+		t.Fatalf("Failed resolving attributes [%s]", err)
+	}
+	if provider.calls != 1 {
+		t.Fatalf("Cached attributes must not be asked again, got %d calls", provider.calls)
+	}
+
+	// users a source does not know have none of its attributes
+	resolved, err := tca.resolveAttributes("other_user\\institution_a\\client", requested)
+	if err != nil {
+		t.Fatalf("Failed resolving attributes [%s]", err)
+	}
+	if len(resolved) != 1 || resolved[0].AttributeValue != "company_of_other_user" {
+		t.Fatalf("Expected the company only, got %v", resolved)
+	}
+}
+
+func TestTCertPolicies(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	viper.Set("tca.policies", map[string]interface{}{
+		"default": map[string]interface{}{"validity": "48h"},
+		"roles": map[string]interface{}{
+			"client": map[string]interface{}{"validity": "1h", "maxbatch": 5},
+		},
+		"affiliations": map[string]interface{}{
+			"institution_a": map[string]interface{}{"validity": "24h", "maxbatch": 2, "attributes": "company"},
+		},
+	})
+	defer viper.Set("tca.policies", nil)
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	for _, group := range []string{"institution_a", "bank_a"} {
+		if err := eca.registerAffiliationGroup(group, ""); err != nil {
+			t.Fatalf("Failed registering affiliation group [%s]", err)
+		}
+	}
+	tca := NewTCA(eca)
+	defer tca.Close()
+	tcap := &TCAP{tca}
+
+	enroll := func(id, affiliation string) *ecdsa.PrivateKey {
+		enrollID := id + "\\" + affiliation + "\\client"
+		if _, err := eca.registerUserWithErollID(id, enrollID, pb.Role_CLIENT); err != nil {
+			t.Fatalf("Failed registering user [%s]", err)
+		}
+		signKey, _ := newTestKey(t)
+		encKey, _ := newTestKey(t)
+		if _, _, _, err := eca.createCertificatePair(id, enrollID, &signKey.PublicKey, &encKey.PublicKey); err != nil {
+			t.Fatalf("Failed creating certificate pair [%s]", err)
+		}
+		return signKey
+	}
+	issue := func(id string, key *ecdsa.PrivateKey, num uint32, attrs ...string) (*x509.Certificate, error) {
+		req := &pb.TCertCreateSetReq{Ts: &protobuf.Timestamp{Seconds: time.Now().Unix()}, Id: &pb.Identity{Id: id}, Num: num}
+		for _, attr := range attrs {
+			req.Attributes = append(req.Attributes, &pb.TCertAttribute{AttributeName: attr, AttributeValue: "value"})
+		}
+		req.Sig = signTestRequest(t, key, req)
+		resp, err := tcap.CreateCertificateSet(nil, req)
+		if err != nil {
+			return nil, err
+		}
+		return x509.ParseCertificate(resp.Certs.Certs[0].Cert)
+	}
+
+	// the policy of the affiliation comes first
+	key := enroll("policy_user", "institution_a")
+	cert, err := issue("policy_user", key, 2, "company")
+	if err != nil {
+		t.Fatalf("Failed creating TCerts [%s]", err)
+	}
+	if validity := cert.NotAfter.Sub(cert.NotBefore); validity != 24*time.Hour {
+		t.Fatalf("Expected TCerts valid for 24h, got %s", validity)
+	}
+	if _, err := issue("policy_user", key, 3); err == nil {
+		t.Fatal("Batches larger than allowed must be rejected")
+	}
+	if _, err := issue("policy_user", key, 1, "company", "position"); err == nil {
+		t.Fatal("Attributes not allowed must be rejected")
+	}
+
+	// then the one of the role
+	key = enroll("policy_other", "bank_a")
+	if cert, err = issue("policy_other", key, 5, "position"); err != nil {
+		t.Fatalf("Failed creating TCerts [%s]", err)
+	}
+	if validity := cert.NotAfter.Sub(cert.NotBefore); validity != time.Hour {
+		t.Fatalf("Expected TCerts valid for 1h, got %s", validity)
+	}
+	if _, err := issue("policy_other", key, 6); err == nil {
+		t.Fatal("Batches larger than allowed must be rejected")
+	}
+}
+
+// serveTestLDAP answers the binds and searches of one LDAP connection.
+// Only alice, with password secret, is a member of bank_a_clients.
+func serveTestLDAP(lis net.Listener) {
+	conn, err := lis.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	const aliceDN = "uid=alice,ou=people,dc=example,dc=com"
+	reply := func(msgID []byte, ops ...[]byte) {
+		for _, op := range ops {
+			conn.Write(berEncode(berSequence, berEncode(berInteger, msgID), op))
+		}
+	}
+	result := func(tag byte, code int) []byte {
+		return berEncode(tag, berInt(berEnumerated, code), berEncode(berOctetString), berEncode(berOctetString))
+	}
+
+	for {
+		msg, err := berRead(conn)
+		if err != nil {
+			return
+		}
+		parts, _ := berChildren(msg.content)
+		fields, _ := berChildren(parts[1].content)
+		switch parts[1].tag {
+		case ldapBindRequest:
+			code := 49 // invalidCredentials
+			if string(fields[1].content) == aliceDN && string(fields[2].content) == "secret" {
+				code = 0
+			}
+			reply(parts[0].content, result(ldapBindResponse, code))
+		case ldapSearchRequest:
+			attr := berEncode(berSequence, berEncode(berOctetString, []byte("memberOf")),
+				berEncode(berSet, berEncode(berOctetString, []byte("cn=Bank_A_Clients,ou=groups,dc=example,dc=com"))))
+			entry := berEncode(ldapSearchResultEntry, berEncode(berOctetString, fields[0].content), berEncode(berSequence, attr))
+			reply(parts[0].content, entry, result(ldapSearchResultDone, 0))
+		default:
+			return
+		}
+	}
+}
+
+func TestLDAPDirectoryEnrollment(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	if err := eca.registerAffiliationGroup("bank_a", ""); err != nil {
+		t.Fatalf("Failed registering affiliation group [%s]", err)
+	}
+	ecap := &ECAP{eca}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed listening [%s]", err)
+	}
+	defer lis.Close()
+	go func() {
+		for i := 0; i < 3; i++ {
+			serveTestLDAP(lis)
+		}
+	}()
+
+	u, _ := url.Parse("ldap://" + lis.Addr().String())
+	eca.directory = &ldapDirectory{url: u, userDN: "uid=%s,ou=people,dc=example,dc=com", groupAttribute: "memberOf", timeout: 5 * time.Second}
+	eca.directoryGroups = map[string]directoryGroup{"bank_a_clients": {pb.Role_CLIENT, "bank_a", "00001"}}
+
+	_, encPub := newTestKey(t)
+	enroll := func(id, password string) (*pb.ECertCreateResp, error) {
+		return ecap.CreateCertificatePair(nil, &pb.ECertCreateReq{Id: &pb.Identity{Id: id}, Tok: &pb.Token{Tok: []byte(password)}, Enc: encPub})
+	}
+
+	if _, err := enroll("alice", "wrong"); err == nil {
+		t.Fatal("Enrolling with a wrong directory password must fail")
+	}
+	if _, err := enroll("bob", "secret"); err == nil {
+		t.Fatal("Enrolling a user unknown to the directory must fail")
+	}
+	resp, err := enroll("alice", "secret")
+	if err != nil || resp.Tok == nil {
+		t.Fatalf("Failed enrolling directory user [%v]", err)
+	}
+
+	var role, state int
+	var enrollID string
+	var tok, key []byte
+	if err := eca.readUser("alice").Scan(&role, &tok, &state, &key, &enrollID); err != nil {
+		t.Fatalf("Directory user must be registered [%s]", err)
+	}
+	if pb.Role(role) != pb.Role_CLIENT || enrollID != "alice\\bank_a\\00001" || state != 1 {
+		t.Fatalf("Directory user registered as [%d] [%s] [%d]", role, enrollID, state)
+	}
+
+	if name := groupName("CN=Bank\\, A,OU=Groups"); name != "bank\\, a" {
+		t.Fatalf("Unexpected group name [%s]", name)
+	}
+	if dn := escapeDN(" a,b=c "); dn != "\\ a\\,b\\=c\\ " {
+		t.Fatalf("Unexpected escaped DN [%s]", dn)
+	}
+}
+
+func TestDBDialects(t *testing.T) {
+	create := "CREATE TABLE IF NOT EXISTS AffiliationGroups (row INTEGER PRIMARY KEY, name VARCHAR(64), parent INTEGER, FOREIGN KEY(parent) REFERENCES AffiliationGroups(row))"
+	if q := dbDialects[DBDriverPostgres].rewrite(create); q != `CREATE TABLE IF NOT EXISTS AffiliationGroups ("row" BIGSERIAL PRIMARY KEY, name VARCHAR(64), parent BIGINT)` {
+		t.Fatalf("Unexpected postgres statement [%s]", q)
+	}
+	if q := dbDialects[DBDriverMySQL].rewrite(create); q != "CREATE TABLE IF NOT EXISTS AffiliationGroups (`row` BIGINT PRIMARY KEY AUTO_INCREMENT, name VARCHAR(64), parent BIGINT)" {
+		t.Fatalf("Unexpected mysql statement [%s]", q)
+	}
+
+	insert := "INSERT OR IGNORE INTO AttributeKeys (id, key, created, retired) VALUES (0, ?, ?, 0)"
+	if q := dbDialects[DBDriverPostgres].rewrite(insert); q != `INSERT INTO AttributeKeys (id, "key", created, retired) VALUES (0, $1, $2, 0) ON CONFLICT DO NOTHING` {
+		t.Fatalf("Unexpected postgres statement [%s]", q)
+	}
+	if q := dbDialects[DBDriverMySQL].rewrite(insert); q != "INSERT IGNORE INTO AttributeKeys (id, `key`, created, retired) VALUES (0, ?, ?, 0)" {
+		t.Fatalf("Unexpected mysql statement [%s]", q)
+	}
+	if q := dbDialects[DBDriverSQLite].rewrite(insert); q != insert {
+		t.Fatalf("SQLite statements must not be rewritten [%s]", q)
+	}
+}
+
+func TestDBMigrations(t *testing.T) {
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	dir, err := ioutil.TempDir("", "TestDBMigrations")
+	if err != nil {
+		t.Fatalf("Failed creating directory [%s]", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := openCADB(dir, "test")
+	if err != nil {
+		t.Fatalf("Failed opening database [%s]", err)
+	}
+	defer db.Close()
+
+	migrations := []string{"CREATE TABLE Migrated (row INTEGER PRIMARY KEY, name VARCHAR(64))"}
+	if err := db.migrate("test", migrations); err != nil {
+		t.Fatalf("Failed migrating [%s]", err)
+	}
+	// migrations already run are not run again
+	migrations = append(migrations, "ALTER TABLE Migrated ADD COLUMN value INTEGER")
+	if err := db.migrate("test", migrations); err != nil {
+		t.Fatalf("Failed migrating again [%s]", err)
+	}
+	if _, err := db.Exec("INSERT INTO Migrated (name, value) VALUES (?, ?)", "name", 1); err != nil {
+		t.Fatalf("Migrations must be run in order [%s]", err)
+	}
+	if err := db.migrate("test", migrations[:1]); err == nil {
+		t.Fatal("Migrating tables newer than the migrations must fail")
+	}
+}
+
+func TestReplicas(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	// two replicas sharing the key files and the database
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	replica := NewECA()
+	defer replica.Close()
+
+	if _, err := eca.registerUserWithErollID("replicated_user", "replicated_user", pb.Role_CLIENT); err != nil {
+		t.Fatalf("Failed registering user [%s]", err)
+	}
+
+	// only one of concurrent requests moves the user to the next state
+	if err := rowsUpdated(replica.db.Exec("UPDATE Users SET state=? WHERE id=? AND state=?", 2, "replicated_user", 1)); err != errConcurrentUpdate {
+		t.Fatalf("Updating a user in another state must fail, got [%v]", err)
+	}
+
+	// a serial number taken by a replica is not issued again
+	signKey, _ := newTestKey(t)
+	sraw, _, _, err := eca.createCertificatePair("replicated_user", "replicated_user", &signKey.PublicKey, &signKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed creating certificate pair [%s]", err)
+	}
+	cert, _ := x509.ParseCertificate(sraw)
+	drawn := 0
+	raw, err := replica.createCertificateWithSerial(func(serial *big.Int) (*CertificateSpec, error) {
+		if drawn++; drawn == 1 {
+			serial = cert.SerialNumber
+		}
+		return NewDefaultPeriodCertificateSpec("replicated_user", serial, &signKey.PublicKey, x509.KeyUsageDigitalSignature), nil
+	}, time.Now().UnixNano(), nil)
+	if err != nil {
+		t.Fatalf("Failed creating certificate [%s]", err)
+	}
+	if other, _ := x509.ParseCertificate(raw); drawn != 2 || other.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+		t.Fatal("A taken serial number must be drawn again")
+	}
+
+	// revocations by a replica show in the CRL of the others
+	if _, err := replica.readCRL(); err != nil {
+		t.Fatalf("Failed reading CRL [%s]", err)
+	}
+	if err := eca.revokeCertificate(primitives.Hash(sraw)); err != nil {
+		t.Fatalf("Failed revoking certificate [%s]", err)
+	}
+	raw, err = replica.readCRL()
+	if err != nil {
+		t.Fatalf("Failed reading CRL [%s]", err)
+	}
+	crl, err := x509.ParseCRL(raw)
+	if err != nil {
+		t.Fatalf("Failed parsing CRL [%s]", err)
+	}
+	if len(crl.TBSCertList.RevokedCertificates) != 1 || crl.TBSCertList.RevokedCertificates[0].SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Fatal("The CRL must list the certificate revoked by another replica")
+	}
+}
+
+func TestCRLPublication(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	viper.Set("pki.crl.schedule", "10ms")
+	defer viper.Set("pki.crl.schedule", "")
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	tca := NewTCA(eca)
+	defer tca.Close()
+
+	srv := httptest.NewServer(NewCRLHandler(eca, tca))
+	defer srv.Close()
+
+	for _, ca := range []*CA{eca.CA, tca.CA} {
+		ca.startCRLPublication()
+	}
+
+	for path, ca := range map[string]*CA{"/eca.crl": eca.CA, "/tca.crl": tca.CA} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("Failed getting %s [%s]", path, err)
+		}
+		raw, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed reading %s [%v, %d]", path, err, resp.StatusCode)
+		}
+		if resp.Header.Get("Content-Type") != "application/pkix-crl" {
+			t.Fatalf("Unexpected content type [%s]", resp.Header.Get("Content-Type"))
+		}
+		crl, err := x509.ParseCRL(raw)
+		if err != nil {
+			t.Fatalf("Failed parsing CRL [%s]", err)
+		}
+		if err := ca.cert.CheckCRLSignature(crl); err != nil {
+			t.Fatalf("Failed verifying CRL signature [%s]", err)
+		}
+
+		// a new CRL is published on schedule
+		time.Sleep(50 * time.Millisecond)
+		if again, _ := ca.readCRL(); bytes.Equal(again, raw) {
+			t.Fatalf("A new CRL must be published on schedule for %s", path)
+		}
+	}
+
+	if resp, err := http.Post(srv.URL+"/eca.crl", "text/plain", nil); err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatal("Only GET and HEAD must be allowed")
+	}
+}
+
+func TestAdminRevocation(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	if err := eca.registerAffiliationGroup("institution_a", ""); err != nil {
+		t.Fatalf("Failed registering affiliation group [%s]", err)
+	}
+	tca := NewTCA(eca)
+	defer tca.Close()
+	ecaa, tcaa, tcap := &ECAA{eca}, &TCAA{tca}, &TCAP{tca}
+
+	enroll := func(id, enrollID string, role pb.Role) (*ecdsa.PrivateKey, []byte) {
+		if _, err := eca.registerUserWithErollID(id, enrollID, role); err != nil {
+			t.Fatalf("Failed registering user [%s]", err)
+		}
+		signKey, _ := newTestKey(t)
+		encKey, _ := newTestKey(t)
+		sraw, _, _, err := eca.createCertificatePair(id, enrollID, &signKey.PublicKey, &encKey.PublicKey)
+		if err != nil {
+			t.Fatalf("Failed creating certificate pair [%s]", err)
+		}
+		return signKey, sraw
+	}
+	adminKey, _ := enroll("revocation_admin", "revocation_admin", pb.Role_AUDITOR)
+	userKey, userCert := enroll("revoked_user", "revoked_user\\institution_a\\client", pb.Role_CLIENT)
+
+	issue := func() (*pb.TCertCreateSetResp, error) {
+		req := &pb.TCertCreateSetReq{Ts: &protobuf.Timestamp{Seconds: time.Now().Unix()}, Id: &pb.Identity{Id: "revoked_user"}, Num: 2}
+		req.Sig = signTestRequest(t, userKey, req)
+		return tcap.CreateCertificateSet(nil, req)
+	}
+	listed := func(ca *CA, raw []byte) bool {
+		crl, err := ca.readCRL()
+		if err != nil {
+			t.Fatalf("Failed reading CRL [%s]", err)
+		}
+		list, err := x509.ParseCRL(crl)
+		if err != nil {
+			t.Fatalf("Failed parsing CRL [%s]", err)
+		}
+		cert, _ := x509.ParseCertificate(raw)
+		for _, entry := range list.TBSCertList.RevokedCertificates {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	// a TCert batch is revoked by its key derivation key
+	resp, err := issue()
+	if err != nil {
+		t.Fatalf("Failed creating TCerts [%s]", err)
+	}
+	setReq := &pb.TCertRevokeSetReq{Id: &pb.Identity{Id: "revoked_user"}, Key: resp.Certs.Key}
+	setReq.Sig = signTestRequest(t, userKey, setReq)
+	if _, err := tcaa.RevokeCertificateSet(nil, setReq); err == nil {
+		t.Fatal("Only admins must revoke TCert batches by key")
+	}
+	setReq = &pb.TCertRevokeSetReq{Id: &pb.Identity{Id: "revocation_admin"}, Key: resp.Certs.Key}
+	setReq.Sig = signTestRequest(t, adminKey, setReq)
+	if _, err := tcaa.RevokeCertificateSet(nil, setReq); err != nil {
+		t.Fatalf("Failed revoking TCert batch [%s]", err)
+	}
+	for _, tcert := range resp.Certs.Certs {
+		if !listed(tca.CA, tcert.Cert) {
+			t.Fatal("The TCA CRL must list the revoked TCert batch")
+		}
+	}
+
+	// revoking the user revokes its certificates and TCert requests
+	if resp, err = issue(); err != nil {
+		t.Fatalf("Failed creating TCerts [%s]", err)
+	}
+	userReq := &pb.ECertRevokeUserReq{Id: &pb.Identity{Id: "revocation_admin"}, User: &pb.Identity{Id: "revoked_user"}}
+	userReq.Sig = signTestRequest(t, adminKey, userReq)
+	if _, err := ecaa.RevokeUser(nil, userReq); err != nil {
+		t.Fatalf("Failed revoking user [%s]", err)
+	}
+	if !listed(eca.CA, userCert) || !listed(tca.CA, resp.Certs.Certs[0].Cert) {
+		t.Fatal("The CRLs must list the certificates of the revoked user")
+	}
+	if _, err := issue(); err == nil {
+		t.Fatal("TCert requests of a revoked user must fail")
+	}
+
+	userReq = &pb.ECertRevokeUserReq{Id: &pb.Identity{Id: "revocation_admin"}, User: &pb.Identity{Id: "unknown_user"}}
+	userReq.Sig = signTestRequest(t, adminKey, userReq)
+	if _, err := ecaa.RevokeUser(nil, userReq); err == nil {
+		t.Fatal("Revoking an unknown user must fail")
+	}
+}
+
+func TestCSREnrollment(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	ecap := &ECAP{eca}
+
+	tok, err := eca.registerUserWithErollID("csr_user", "csr_user", pb.Role_CLIENT)
+	if err != nil {
+		t.Fatalf("Failed registering user [%s]", err)
+	}
+
+	signKey, _ := newTestKey(t)
+	encKey, _ := newTestKey(t)
+	csr := func(cn string, key *ecdsa.PrivateKey) []byte {
+		raw, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: cn}}, key)
+		if err != nil {
+			t.Fatalf("Failed creating certificate request [%s]", err)
+		}
+		return raw
+	}
+
+	req := &pb.ECertCSRReq{Id: &pb.Identity{Id: "csr_user"}, Tok: &pb.Token{Tok: []byte(tok)}, Sign: csr("other_user", signKey), Enc: csr("csr_user", encKey)}
+	if _, err := ecap.CreateCertificatePairFromCSR(nil, req); err == nil {
+		t.Fatal("A certificate request for another identity must fail")
+	}
+	tampered := csr("csr_user", signKey)
+	tampered[len(tampered)-1] ^= 1
+	req.Sign = tampered
+	if _, err := ecap.CreateCertificatePairFromCSR(nil, req); err == nil {
+		t.Fatal("A certificate request with an invalid signature must fail")
+	}
+
+	req.Sign = csr("csr_user", signKey)
+	resp, err := ecap.CreateCertificatePairFromCSR(nil, req)
+	if err != nil {
+		t.Fatalf("Failed enrolling from certificate requests [%s]", err)
+	}
+	for raw, key := range map[*[]byte]*ecdsa.PrivateKey{&resp.Certs.Sign: signKey, &resp.Certs.Enc: encKey} {
+		cert, err := x509.ParseCertificate(*raw)
+		if err != nil {
+			t.Fatalf("Failed parsing certificate [%s]", err)
+		}
+		if pub := cert.PublicKey.(*ecdsa.PublicKey); pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+			t.Fatal("The certificates must certify the keys of the requests")
+		}
+	}
+
+	if _, err := ecap.CreateCertificatePairFromCSR(nil, req); err == nil {
+		t.Fatal("Enrolling twice must fail")
+	}
+}
+
+func TestEnrollmentLimits(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	if err := eca.registerAffiliationGroup("institution_a", ""); err != nil {
+		t.Fatalf("Failed registering affiliation group [%s]", err)
+	}
+	ecaa, ecap := &ECAA{eca}, &ECAP{eca}
+
+	register := func(id string, maxEnrollments int32, expires *protobuf.Timestamp) []byte {
+		tok, err := ecaa.RegisterUser(nil, &pb.RegisterUserReq{Id: &pb.Identity{Id: id}, Role: pb.Role_CLIENT, Account: "institution_a", Affiliation: "00001", MaxEnrollments: maxEnrollments, Expires: expires})
+		if err != nil {
+			t.Fatalf("Failed registering user [%s]", err)
+		}
+		return tok.Tok
+	}
+	enroll := func(id string, tok []byte) error {
+		csr := func(key *ecdsa.PrivateKey) []byte {
+			raw, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: id}}, key)
+			return raw
+		}
+		signKey, _ := newTestKey(t)
+		encKey, _ := newTestKey(t)
+		_, err := ecap.CreateCertificatePairFromCSR(nil, &pb.ECertCSRReq{Id: &pb.Identity{Id: id}, Tok: &pb.Token{Tok: tok}, Sign: csr(signKey), Enc: csr(encKey)})
+		return err
+	}
+
+	// users enroll again with their token as long as the registration allows
+	tok := register("reenrolling_user", 2, nil)
+	for i := 0; i < 2; i++ {
+		if err := enroll("reenrolling_user", tok); err != nil {
+			t.Fatalf("Failed enrolling [%s]", err)
+		}
+	}
+	var retired int
+	eca.db.QueryRow("SELECT count(*) FROM RetiredCertificates WHERE id=?", "reenrolling_user").Scan(&retired)
+	if retired != 2 {
+		t.Fatalf("The certificates of the first enrollment must be retired, got %d", retired)
+	}
+	if err := enroll("reenrolling_user", tok); grpc.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Enrolling more than allowed must fail with ResourceExhausted [%v]", err)
+	}
+
+	tok = register("expired_user", -1, &protobuf.Timestamp{Seconds: time.Now().Unix() - 1})
+	if err := enroll("expired_user", tok); grpc.Code(err) != codes.PermissionDenied {
+		t.Fatalf("Enrolling after the registration expired must fail with PermissionDenied [%v]", err)
+	}
+}
+
+func TestBulkRegistration(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	if err := eca.registerAffiliationGroup("institution_a", ""); err != nil {
+		t.Fatalf("Failed registering affiliation group [%s]", err)
+	}
+	ecaa := &ECAA{eca}
+
+	enroll := func(id string, role pb.Role) *ecdsa.PrivateKey {
+		if _, err := eca.registerUserWithErollID(id, id, role); err != nil {
+			t.Fatalf("Failed registering user [%s]", err)
+		}
+		signKey, _ := newTestKey(t)
+		encKey, _ := newTestKey(t)
+		if _, _, _, err := eca.createCertificatePair(id, id, &signKey.PublicKey, &encKey.PublicKey); err != nil {
+			t.Fatalf("Failed creating certificate pair [%s]", err)
+		}
+		return signKey
+	}
+	adminKey := enroll("registration_admin", pb.Role_AUDITOR)
+	validatorKey := enroll("registration_validator", pb.Role_VALIDATOR)
+
+	users := []*pb.RegisterUserReq{
+		{Id: &pb.Identity{Id: "bulk_user0"}, Role: pb.Role_CLIENT, Account: "institution_a", Affiliation: "00001"},
+		{Id: &pb.Identity{Id: "bulk_user1"}, Role: pb.Role_CLIENT, Account: "unknown_group", Affiliation: "00001"},
+		{Id: &pb.Identity{Id: "bulk_vp0"}, Role: pb.Role_VALIDATOR, MaxEnrollments: -1},
+		{Id: &pb.Identity{Id: "bulk_user0"}, Role: pb.Role_CLIENT, Account: "institution_a", Affiliation: "00001"},
+		{Id: &pb.Identity{Id: "bulk_user2"}, Role: pb.Role_NONE, Account: "institution_a", Affiliation: "00001"},
+		{Role: pb.Role_CLIENT, Account: "institution_a", Affiliation: "00001"},
+	}
+
+	req := &pb.RegisterUserSetReq{Id: &pb.Identity{Id: "registration_validator"}, Users: users}
+	req.Sig = signTestRequest(t, validatorKey, req)
+	if _, err := ecaa.RegisterUserSet(nil, req); err == nil {
+		t.Fatal("Only admins must register users in bulk")
+	}
+
+	req = &pb.RegisterUserSetReq{Id: &pb.Identity{Id: "registration_admin"}, Users: users}
+	req.Sig = signTestRequest(t, adminKey, req)
+	resp, err := ecaa.RegisterUserSet(nil, req)
+	if err != nil {
+		t.Fatalf("Failed registering users [%s]", err)
+	}
+	if len(resp.Results) != len(users) {
+		t.Fatalf("Expected %d results, got %d", len(users), len(resp.Results))
+	}
+
+	// each user is registered, or not, on its own
+	for i, registered := range []bool{true, false, true, false, false, false} {
+		result := resp.Results[i]
+		if registered != (result.Error == "") || registered != (result.Tok != nil) {
+			t.Fatalf("Unexpected result of registration %d [%v]", i, result)
+		}
+		if registered {
+			_, _, _, _, err := eca.authenticateUser(result.Id.Id, result.Tok.Tok)
+			if err != nil {
+				t.Fatalf("Failed authenticating user registered in bulk [%s]", err)
+			}
+		}
+	}
+	var maxEnrollments int
+	eca.db.QueryRow("SELECT maxEnrollments FROM Users WHERE id=?", "bulk_vp0").Scan(&maxEnrollments)
+	if maxEnrollments != -1 {
+		t.Fatalf("The registration must keep the enrollment limit, got %d", maxEnrollments)
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	settings := map[string]interface{}{
+		"eca.ratelimit.rate":     1,
+		"eca.ratelimit.interval": "1h",
+		"tca.ratelimit.rate":     1,
+		"tca.ratelimit.interval": "1h",
+		"tca.ratelimit.burst":    2,
+	}
+	for key, value := range settings {
+		viper.Set(key, value)
+		defer viper.Set(key, nil)
+	}
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	if err := eca.registerAffiliationGroup("institution_a", ""); err != nil {
+		t.Fatalf("Failed registering affiliation group [%s]", err)
+	}
+	tca := NewTCA(eca)
+	defer tca.Close()
+	ecap, tcap := &ECAP{eca}, &TCAP{tca}
+
+	if _, err := eca.registerUserWithErollID("limited_user", "limited_user\\institution_a\\client", pb.Role_CLIENT); err != nil {
+		t.Fatalf("Failed registering user [%s]", err)
+	}
+	userKey, _ := newTestKey(t)
+	encKey, _ := newTestKey(t)
+	if _, _, _, err := eca.createCertificatePair("limited_user", "limited_user\\institution_a\\client", &userKey.PublicKey, &encKey.PublicKey); err != nil {
+		t.Fatalf("Failed creating certificate pair [%s]", err)
+	}
+
+	// enrollment attempts count whether they succeed or not
+	enroll := func() error {
+		_, err := ecap.CreateCertificatePairFromCSR(nil, &pb.ECertCSRReq{Id: &pb.Identity{Id: "guessed_user"}, Tok: &pb.Token{Tok: []byte("guess")}})
+		return err
+	}
+	if err := enroll(); err == nil || grpc.Code(err) == codes.ResourceExhausted {
+		t.Fatalf("The first enrollment attempt must be let through [%v]", err)
+	}
+	if err := enroll(); grpc.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Enrolling too often must fail with ResourceExhausted [%v]", err)
+	}
+
+	// TCert batches are limited per user, with bursts
+	issue := func() error {
+		req := &pb.TCertCreateSetReq{Ts: &protobuf.Timestamp{Seconds: time.Now().Unix()}, Id: &pb.Identity{Id: "limited_user"}, Num: 1}
+		req.Sig = signTestRequest(t, userKey, req)
+		_, err := tcap.CreateCertificateSet(nil, req)
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		if err := issue(); err != nil {
+			t.Fatalf("Failed creating TCerts [%s]", err)
+		}
+	}
+	if err := issue(); grpc.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Requesting TCerts too often must fail with ResourceExhausted [%v]", err)
+	}
+
+	viper.Set("tca.ratelimit.burst", -1)
+	if _, err := readRateLimiter("tca.ratelimit"); err == nil {
+		t.Fatal("Invalid rate limits must be rejected")
+	}
+}
+
+type testCAHSM struct {
+	keys map[string]*ecdsa.PrivateKey
+}
+
+func (hsm *testCAHSM) FindKey(label string) (*ecdsa.PublicKey, error) {
+	key, ok := hsm.keys[label]
+	if !ok {
+		return nil, errors.New("key not found")
+	}
+	return &key.PublicKey, nil
+}
+
+func (hsm *testCAHSM) Sign(label string, digest []byte) ([]byte, error) {
+	return hsm.keys[label].Sign(rand.Reader, digest, nil)
+}
+
+func (hsm *testCAHSM) ImportKey(label string, key *ecdsa.PrivateKey, persistent bool) error {
+	hsm.keys[label] = key
+	return nil
+}
+
+func (hsm *testCAHSM) Close() error {
+	return nil
+}
+
+func TestHSMCAKeys(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	hsm := &testCAHSM{make(map[string]*ecdsa.PrivateKey)}
+	if err := RegisterHSMProvider("TestHSMCAKeys", func(name string) (HSM, error) { return hsm, nil }); err != nil {
+		t.Fatalf("Failed registering HSM provider [%s]", err)
+	}
+	viper.Set("server.hsm.provider", "TestHSMCAKeys")
+	viper.Set("server.hsm.keys", "eca")
+	defer viper.Set("server.hsm.provider", "")
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	if hsm.keys["eca"] == nil {
+		eca.Close()
+		t.Fatal("The signing key of the ECA must be created on the HSM")
+	}
+	if _, err := os.Stat(eca.path + "/eca.priv"); err == nil {
+		eca.Close()
+		t.Fatal("The signing key of the ECA must not be written to disk")
+	}
+
+	// certificates are signed on the HSM
+	key, _ := newTestKey(t)
+	raw, err := eca.createCertificate("hsm_user", &key.PublicKey, x509.KeyUsageDigitalSignature, time.Now().UnixNano(), nil)
+	eca.Close()
+	if err != nil {
+		t.Fatalf("Failed creating certificate [%s]", err)
+	}
+	cert, _ := x509.ParseCertificate(raw)
+	if err := cert.CheckSignatureFrom(eca.cert); err != nil {
+		t.Fatalf("The certificate must be signed by the ECA key [%s]", err)
+	}
+
+	// the ECA starts again with its key on the HSM
+	eca = NewECA()
+	eca.Close()
+
+	// but not if the key on the HSM is not the one of its certificate
+	hsm.keys["eca"], _ = newTestKey(t)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("The ECA must not start with a key not matching its certificate")
+		}
+	}()
+	eca = NewECA()
+	eca.Close()
+}
+
+func TestAuditLog(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	if err := eca.registerAffiliationGroup("institution_a", ""); err != nil {
+		t.Fatalf("Failed registering affiliation group [%s]", err)
+	}
+	ecaa := &ECAA{eca}
+
+	enroll := func(id string, role pb.Role) *ecdsa.PrivateKey {
+		if _, err := eca.registerUserWithErollID(id, id, role); err != nil {
+			t.Fatalf("Failed registering user [%s]", err)
+		}
+		signKey, _ := newTestKey(t)
+		encKey, _ := newTestKey(t)
+		if _, _, _, err := eca.createCertificatePair(id, id, &signKey.PublicKey, &encKey.PublicKey); err != nil {
+			t.Fatalf("Failed creating certificate pair [%s]", err)
+		}
+		return signKey
+	}
+	auditorKey := enroll("audit_auditor", pb.Role_AUDITOR)
+	clientKey := enroll("audit_client", pb.Role_CLIENT)
+
+	if _, err := ecaa.RegisterUser(nil, &pb.RegisterUserReq{Id: &pb.Identity{Id: "audit_user"}, Role: pb.Role_CLIENT, Account: "institution_a", Affiliation: "00001"}); err != nil {
+		t.Fatalf("Failed registering user [%s]", err)
+	}
+	revoke := &pb.ECertRevokeUserReq{Id: &pb.Identity{Id: "audit_auditor"}, User: &pb.Identity{Id: "audit_user"}}
+	revoke.Sig = signTestRequest(t, auditorKey, revoke)
+	if _, err := ecaa.RevokeUser(nil, revoke); err != nil {
+		t.Fatalf("Failed revoking user [%s]", err)
+	}
+
+	read := func(id string, key *ecdsa.PrivateKey, in *pb.AuditLogReadReq) (*pb.AuditLog, error) {
+		in.Id = &pb.Identity{Id: id}
+		in.Sig = signTestRequest(t, key, in)
+		return ecaa.ReadAuditLog(nil, in)
+	}
+
+	if _, err := read("audit_client", clientKey, &pb.AuditLogReadReq{}); err == nil {
+		t.Fatal("Only auditors must read the audit log")
+	}
+
+	log, err := read("audit_auditor", auditorKey, &pb.AuditLogReadReq{Subject: "audit_user"})
+	if err != nil {
+		t.Fatalf("Failed reading the audit log [%s]", err)
+	}
+	if len(log.Records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(log.Records))
+	}
+	if rec := log.Records[0]; rec.Operation != auditRegister || rec.Requester != "" {
+		t.Fatalf("Unexpected registration record [%v]", rec)
+	}
+	if rec := log.Records[1]; rec.Operation != auditRevoke || rec.Requester != "audit_auditor" {
+		t.Fatalf("Unexpected revocation record [%v]", rec)
+	}
+
+	// the records chain to each other
+	log, err = read("audit_auditor", auditorKey, &pb.AuditLogReadReq{After: 1, Verify: true})
+	if err != nil {
+		t.Fatalf("Failed reading the audit log [%s]", err)
+	}
+	if log.Broken != 0 {
+		t.Fatalf("The audit log must verify, broken at %d", log.Broken)
+	}
+	prev := log.Prev
+	for _, rec := range log.Records {
+		if !bytes.Equal(rec.Hash, auditHash(prev, rec)) {
+			t.Fatalf("Record %d does not chain to the previous one", rec.Seq)
+		}
+		prev = rec.Hash
+	}
+
+	// tampering breaks the chain
+	seq := log.Records[0].Seq
+	if _, err := eca.db.Exec("UPDATE AuditLog SET details=? WHERE row=?", "role=8", seq); err != nil {
+		t.Fatal(err)
+	}
+	if log, err = read("audit_auditor", auditorKey, &pb.AuditLogReadReq{Max: 1, Verify: true}); err != nil {
+		t.Fatalf("Failed reading the audit log [%s]", err)
+	}
+	if log.Broken != seq {
+		t.Fatalf("The audit log must be broken at %d, got %d", seq, log.Broken)
+	}
+	if len(log.Records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(log.Records))
+	}
+}
+
+func TestRESTGateway(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	if err := eca.registerAffiliationGroup("institution_a", ""); err != nil {
+		t.Fatalf("Failed registering affiliation group [%s]", err)
+	}
+	tca := NewTCA(eca)
+	defer tca.Close()
+
+	server := httptest.NewServer(NewRESTHandler(eca, tca))
+	defer server.Close()
+
+	call := func(method, path, body string, out proto.Message) int {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed calling the REST gateway [%s]", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK && out != nil {
+			if err := jsonpb.Unmarshal(resp.Body, out); err != nil {
+				t.Fatalf("Failed decoding the response [%s]", err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	cert := new(pb.Cert)
+	if status := call("GET", "/v1/eca/cert", "", cert); status != http.StatusOK {
+		t.Fatalf("Failed reading the ECA certificate, status %d", status)
+	}
+	if !bytes.Equal(cert.Cert, eca.certificateChain()) {
+		t.Fatal("The REST gateway must serve the ECA certificate")
+	}
+	if status := call("GET", "/v1/tcerts", "", nil); status != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected status %d, got %d", http.StatusMethodNotAllowed, status)
+	}
+	if status := call("POST", "/v1/registrations/bulk", "{", nil); status != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, status)
+	}
+
+	// users are registered by admins only
+	if _, err := eca.registerUserWithErollID("rest_admin", "rest_admin", pb.Role_AUDITOR); err != nil {
+		t.Fatalf("Failed registering admin [%s]", err)
+	}
+	adminKey, _ := newTestKey(t)
+	adminEncKey, _ := newTestKey(t)
+	if _, _, _, err := eca.createCertificatePair("rest_admin", "rest_admin", &adminKey.PublicKey, &adminEncKey.PublicKey); err != nil {
+		t.Fatalf("Failed creating certificate pair [%s]", err)
+	}
+	m := jsonpb.Marshaler{}
+	req := &pb.RegisterUserSetReq{Id: &pb.Identity{Id: "rest_admin"}, Users: []*pb.RegisterUserReq{
+		{Id: &pb.Identity{Id: "rest_user"}, Role: pb.Role_CLIENT, Account: "institution_a", Affiliation: "00001"},
+	}}
+	body, err := m.MarshalToString(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := call("POST", "/v1/registrations/bulk", body, nil); status == http.StatusOK {
+		t.Fatal("Unsigned registrations must be rejected")
+	}
+	if status := call("POST", "/v1/registrations", body, nil); status != http.StatusNotFound {
+		t.Fatalf("Expected status %d, got %d", http.StatusNotFound, status)
+	}
+
+	req.Sig = signTestRequest(t, adminKey, req)
+	if body, err = m.MarshalToString(req); err != nil {
+		t.Fatal(err)
+	}
+	registrations := new(pb.RegisterUserSetResp)
+	if status := call("POST", "/v1/registrations/bulk", body, registrations); status != http.StatusOK {
+		t.Fatalf("Failed registering user, status %d", status)
+	}
+	if registrations.Results[0].Tok == nil {
+		t.Fatalf("Failed registering user [%s]", registrations.Results[0].Error)
+	}
+	tok := registrations.Results[0].Tok
+
+	csr := func(key *ecdsa.PrivateKey) []byte {
+		raw, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "rest_user"}}, key)
+		if err != nil {
+			t.Fatalf("Failed creating certificate request [%s]", err)
+		}
+		return raw
+	}
+	signKey, _ := newTestKey(t)
+	encKey, _ := newTestKey(t)
+	body, err = m.MarshalToString(&pb.ECertCSRReq{Id: &pb.Identity{Id: "rest_user"}, Tok: tok, Sign: csr(signKey), Enc: csr(encKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := new(pb.ECertCreateResp)
+	if status := call("POST", "/v1/enrollments/csr", body, resp); status != http.StatusOK {
+		t.Fatalf("Failed enrolling, status %d", status)
+	}
+	if _, err := x509.ParseCertificate(resp.Certs.Sign); err != nil {
+		t.Fatalf("Failed parsing the enrollment certificate [%s]", err)
+	}
+	if status := call("POST", "/v1/enrollments/csr", body, nil); status != http.StatusTooManyRequests {
+		t.Fatalf("Enrolling more than allowed must fail with status %d, got %d", http.StatusTooManyRequests, status)
+	}
+}
+
+func TestMetricsAndHealth(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	if err := eca.registerAffiliationGroup("institution_a", ""); err != nil {
+		t.Fatalf("Failed registering affiliation group [%s]", err)
+	}
+
+	health, err := (&ECAP{eca}).CheckHealth(nil, &pb.Empty{})
+	if err != nil {
+		t.Fatalf("Failed checking health [%s]", err)
+	}
+	if health.Status != pb.CAHealth_SERVING {
+		t.Fatalf("Expected the ECA to serve, got [%s]", health.Reason)
+	}
+
+	if _, err := eca.registerUserWithErollID("metrics_user", "metrics_user", pb.Role_CLIENT); err != nil {
+		t.Fatalf("Failed registering user [%s]", err)
+	}
+	signKey, _ := newTestKey(t)
+	encKey, _ := newTestKey(t)
+	if _, _, _, err := eca.createCertificatePair("metrics_user", "metrics_user", &signKey.PublicKey, &encKey.PublicKey); err != nil {
+		t.Fatalf("Failed creating certificate pair [%s]", err)
+	}
+	if _, err := (&ECAP{eca}).ReadCACertificate(nil, &pb.Empty{}); err != nil {
+		t.Fatalf("Failed reading the ECA certificate [%s]", err)
+	}
+
+	server := httptest.NewServer(NewMetricsHandler(eca))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Failed reading metrics [%s]", err)
+	}
+	raw, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, metric := range []string{
+		`membersrvc_certificates_issued_total{ca="eca"}`,
+		`membersrvc_rpc_duration_seconds_count{method="ECAP.ReadCACertificate"}`,
+		`membersrvc_rpc_pending{method="ECAP.ReadCACertificate"} 0`,
+		`membersrvc_rpc_duration_seconds_bucket{method="ECAP.ReadCACertificate",le="+Inf"}`,
+		`membersrvc_db_open_connections{ca="eca"}`,
+	} {
+		if !strings.Contains(string(raw), metric) {
+			t.Fatalf("Expected metric %s in:\n%s", metric, raw)
+		}
+	}
+
+	resp, err = http.Get(server.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("Failed reading health [%s]", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	// a CA whose database is gone cannot serve
+	eca.db.Close()
+	if health := eca.checkHealth(); health.Status != pb.CAHealth_NOT_SERVING {
+		t.Fatal("Expected the ECA not to serve without its database")
+	}
+	resp, err = http.Get(server.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("Failed reading health [%s]", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+}
+
+func TestOIDCEnrollment(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	if err := eca.registerAffiliationGroup("bank_a", ""); err != nil {
+		t.Fatalf("Failed registering affiliation group [%s]", err)
+	}
+	ecap := &ECAP{eca}
+
+	issuerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed generating key [%s]", err)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	// JWK coordinates and JWS signatures are padded to the size of the field
+	padded := func(n *big.Int) []byte {
+		b := n.Bytes()
+		return append(make([]byte, 32-len(b)), b...)
+	}
+
+	var issuer string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"issuer": issuer, "jwks_uri": issuer + "/keys"})
+		case "/keys":
+			json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+				"kty": "EC", "kid": "k1", "use": "sig", "crv": "P-256",
+				"x": b64(padded(issuerKey.X)), "y": b64(padded(issuerKey.Y)),
+			}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	issuer = server.URL
+
+	eca.oidc = &oidcVerifier{
+		issuer:      issuer,
+		clientID:    "membersrvc",
+		idClaim:     "email",
+		groupsClaim: "groups",
+		groups:      map[string]directoryGroup{"bank_a_clients": {pb.Role_CLIENT, "bank_a", "00001"}},
+		attributes:  map[string]string{"position": "title"},
+		client:      http.DefaultClient,
+	}
+
+	idToken := func(claims map[string]interface{}) string {
+		header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": "k1", "typ": "JWT"})
+		payload, _ := json.Marshal(claims)
+		signed := b64(header) + "." + b64(payload)
+		digest := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, issuerKey, digest[:])
+		if err != nil {
+			t.Fatalf("Failed signing ID token [%s]", err)
+		}
+		return signed + "." + b64(append(padded(r), padded(s)...))
+	}
+	claims := func(email string) map[string]interface{} {
+		return map[string]interface{}{
+			"iss": issuer, "aud": "membersrvc", "exp": time.Now().Add(time.Hour).Unix(),
+			"email": email, "groups": []string{"bank_a_clients"}, "title": "teller",
+		}
+	}
+
+	_, encPub := newTestKey(t)
+	enroll := func(id, tok string) (*pb.ECertCreateResp, error) {
+		return ecap.CreateCertificatePair(nil, &pb.ECertCreateReq{Id: &pb.Identity{Id: id}, Tok: &pb.Token{Tok: []byte(tok)}, Enc: encPub})
+	}
+
+	if _, err := enroll("carol@example.com", idToken(claims("dave@example.com"))); err == nil {
+		t.Fatal("Enrolling with the ID token of another user must fail")
+	}
+	expired := claims("carol@example.com")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	if _, err := enroll("carol@example.com", idToken(expired)); err == nil {
+		t.Fatal("Enrolling with an expired ID token must fail")
+	}
+	foreign := claims("carol@example.com")
+	foreign["aud"] = "another-client"
+	if _, err := enroll("carol@example.com", idToken(foreign)); err == nil {
+		t.Fatal("Enrolling with an ID token issued to another client must fail")
+	}
+	forged := idToken(claims("carol@example.com"))
+	if _, err := enroll("carol@example.com", forged[:len(forged)-4]+"AAAA"); err == nil {
+		t.Fatal("Enrolling with a forged ID token must fail")
+	}
+
+	resp, err := enroll("carol@example.com", idToken(claims("carol@example.com")))
+	if err != nil {
+		t.Fatalf("Failed enrolling with an ID token [%s]", err)
+	}
+	if resp.Tok == nil {
+		t.Fatal("Expected an enrollment challenge")
+	}
+	if role := eca.readRole("carol@example.com"); role != int(pb.Role_CLIENT) {
+		t.Fatalf("Expected carol to be registered as a client, got %d", role)
+	}
+
+	values, err := (&tokenAttributeProvider{eca.db}).Attributes("carol@example.com", "bank_a", []string{"position", "company"})
+	if err != nil {
+		t.Fatalf("Failed reading token attributes [%s]", err)
+	}
+	if len(values) != 1 || values["position"] != "teller" {
+		t.Fatalf("Expected the position taken from the ID token, got %v", values)
+	}
+
+	// users registered otherwise keep enrolling with their token
+	if _, err := eca.registerUser("erin@example.com", "bank_a", "00001", pb.Role_CLIENT); err != nil {
+		t.Fatalf("Failed registering user [%s]", err)
+	}
+	if _, err := enroll("erin@example.com", idToken(claims("erin@example.com"))); err == nil {
+		t.Fatal("Users not registered by the OIDC issuer must not enroll with ID tokens")
+	}
+}
+
+func TestTCertInventory(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	viper.Set("tca.inventory.clients", "inventory_user")
+	viper.Set("tca.inventory.size", "3")
+	defer viper.Set("tca.inventory.clients", "")
+	defer viper.Set("tca.inventory.size", "")
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	if err := eca.registerAffiliationGroup("institution_a", ""); err != nil {
+		t.Fatalf("Failed registering affiliation group [%s]", err)
+	}
+	tca := NewTCA(eca)
+	defer tca.Close()
+	tcap := &TCAP{tca}
+	if tca.inventory == nil {
+		t.Fatal("Expected the TCert inventory to be configured")
+	}
+
+	enrollID := "inventory_user\\institution_a\\client"
+	if _, err := eca.registerUserWithErollID("inventory_user", enrollID, pb.Role_CLIENT); err != nil {
+		t.Fatalf("Failed registering user [%s]", err)
+	}
+	signKey, _ := newTestKey(t)
+	encKey, _ := newTestKey(t)
+	if _, _, _, err := eca.createCertificatePair("inventory_user", enrollID, &signKey.PublicKey, &encKey.PublicKey); err != nil {
+		t.Fatalf("Failed creating certificate pair [%s]", err)
+	}
+
+	count := func(query string) int {
+		var n int
+		if err := tca.db.QueryRow(query, "inventory_user").Scan(&n); err != nil {
+			t.Fatalf("Failed counting [%s]", err)
+		}
+		return n
+	}
+	stocked := func() int { return count("SELECT count(row) FROM TCertInventory WHERE id=?") }
+	issued := func() int { return count("SELECT count(row) FROM Certificates WHERE id=?") }
+	issue := func(num uint32, dualKey bool) *pb.CertSet {
+		req := &pb.TCertCreateSetReq{Ts: &protobuf.Timestamp{Seconds: time.Now().Unix()}, Id: &pb.Identity{Id: "inventory_user"}, Num: num, DualKey: dualKey}
+		req.Sig = signTestRequest(t, signKey, req)
+		resp, err := tcap.CreateCertificateSet(nil, req)
+		if err != nil {
+			t.Fatalf("Failed creating TCerts [%s]", err)
+		}
+		if len(resp.Certs.Certs) != int(num) {
+			t.Fatalf("Expected %d TCerts, got %d", num, len(resp.Certs.Certs))
+		}
+		return resp.Certs
+	}
+
+	tca.inventory.fill(nil)
+	if n := stocked(); n != 3 {
+		t.Fatalf("Expected 3 TCerts in the inventory, got %d", n)
+	}
+	if n := issued(); n != 0 {
+		t.Fatalf("TCerts in the inventory must not be recorded as issued, got %d", n)
+	}
+
+	set := issue(2, false)
+	if n := stocked(); n != 1 {
+		t.Fatalf("Expected 1 TCert left in the inventory, got %d", n)
+	}
+	if n := issued(); n != 2 {
+		t.Fatalf("Expected the served TCerts to be recorded as issued, got %d", n)
+	}
+	for _, tcert := range set.Certs {
+		cert, err := x509.ParseCertificate(tcert.Cert)
+		if err != nil {
+			t.Fatalf("Failed parsing TCert [%s]", err)
+		}
+		if len(tcert.Keys["enrollmentId"]) == 0 {
+			t.Fatal("Expected the key of the enrollment ID of the TCert")
+		}
+		if cert.Subject.CommonName != "inventory_user" {
+			t.Fatalf("Expected a TCert of inventory_user, got %s", cert.Subject.CommonName)
+		}
+	}
+
+	// the inventory runs out, the rest is issued on the spot
+	issue(3, false)
+	if n := stocked(); n != 0 {
+		t.Fatalf("Expected an empty inventory, got %d", n)
+	}
+	if n := issued(); n != 5 {
+		t.Fatalf("Expected 5 TCerts issued, got %d", n)
+	}
+
+	// dual-key TCerts are never served from the inventory
+	tca.inventory.fill(nil)
+	issue(1, true)
+	if n := stocked(); n != 3 {
+		t.Fatalf("Expected 3 TCerts in the inventory, got %d", n)
+	}
+
+	// nor are TCerts issued under a retired attribute master key
+	if err := tca.rotateAttributeKey(true); err != nil {
+		t.Fatalf("Failed rotating the attribute key [%s]", err)
+	}
+	issue(1, false)
+	if n := stocked(); n != 3 {
+		t.Fatalf("Expected the stale TCerts to stay unserved, got %d", n)
+	}
+	tca.inventory.fill(nil)
+	var stale int
+	if err := tca.db.QueryRow("SELECT count(row) FROM TCertInventory WHERE keyid<>?", tca.inventory.currentKeyID()).Scan(&stale); err != nil || stale != 0 {
+		t.Fatalf("Expected the stale TCerts to be discarded, got %d [%v]", stale, err)
+	}
+}
+
+func TestEscrowKeyExport(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	ecaa := &ECAA{eca}
+
+	enroll := func(id string, role pb.Role) (*ecdsa.PrivateKey, *ecdsa.PrivateKey) {
+		if _, err := eca.registerUserWithErollID(id, id, role); err != nil {
+			t.Fatalf("Failed registering user [%s]", err)
+		}
+		signKey, _ := newTestKey(t)
+		encKey, _ := newTestKey(t)
+		if _, _, _, err := eca.createCertificatePair(id, id, &signKey.PublicKey, &encKey.PublicKey); err != nil {
+			t.Fatalf("Failed creating certificate pair [%s]", err)
+		}
+		return signKey, encKey
+	}
+	auditorKey, _ := enroll("escrow_auditor", pb.Role_AUDITOR)
+	_, otherEncKey := enroll("escrow_other_auditor", pb.Role_AUDITOR)
+	nonAdminKey, _ := enroll("escrow_non_admin", pb.Role_AUDITOR)
+	clientKey, _ := enroll("escrow_client", pb.Role_CLIENT)
+	eca.escrowAdmins = map[string]bool{"escrow_auditor": true, "escrow_client": true}
+	eca.escrowAuditors = map[string]bool{"escrow_other_auditor": true, "escrow_client": true}
+
+	export := func(id string, key *ecdsa.PrivateKey, auditor string) (*pb.EscrowKeys, error) {
+		in := &pb.EscrowKeysExportReq{Ts: &protobuf.Timestamp{Seconds: time.Now().Unix()}, Id: &pb.Identity{Id: id}, Auditor: &pb.Identity{Id: auditor}}
+		in.Sig = signTestRequest(t, key, in)
+		return ecaa.ExportEscrowKeys(nil, in)
+	}
+
+	if _, err := export("escrow_client", clientKey, "escrow_client"); err == nil {
+		t.Fatal("Only auditors must export the chain keys")
+	}
+	if _, err := export("escrow_non_admin", nonAdminKey, "escrow_other_auditor"); err == nil {
+		t.Fatal("Auditors that are not escrow admins must not export the chain keys")
+	}
+	if _, err := export("escrow_auditor", auditorKey, "escrow_client"); err == nil {
+		t.Fatal("The chain keys must only be exported for auditors")
+	}
+	if _, err := export("escrow_auditor", auditorKey, "escrow_non_admin"); err == nil {
+		t.Fatal("The chain keys must only be exported for the escrow auditors")
+	}
+	if _, err := export("escrow_auditor", auditorKey, ""); err == nil {
+		t.Fatal("The chain keys must only be exported for the escrow auditors")
+	}
+
+	keys, err := export("escrow_auditor", auditorKey, "escrow_other_auditor")
+	if err != nil {
+		t.Fatalf("Failed exporting the chain keys [%s]", err)
+	}
+	enc, err := x509.ParseCertificate(keys.Enc.Cert)
+	if err != nil || enc.PublicKey.(*ecdsa.PublicKey).X.Cmp(otherEncKey.PublicKey.X) != 0 {
+		t.Fatalf("Expected the keys wrapped under the encryption ECert of the auditor [%v]", err)
+	}
+
+	spi := ecies.NewSPI()
+	priv, err := spi.NewPrivateKey(nil, otherEncKey)
+	if err != nil {
+		t.Fatalf("Failed loading the encryption key [%s]", err)
+	}
+	cipher, err := spi.NewAsymmetricCipherFromPrivateKey(priv)
+	if err != nil {
+		t.Fatalf("Failed loading the encryption key [%s]", err)
+	}
+	if chain, err := cipher.Process(keys.Chain); err != nil || !bytes.Equal(chain, eca.obcKey) {
+		t.Fatalf("Expected the symmetric chain key [%v]", err)
+	}
+	if pkchain, err := cipher.Process(keys.Pkchain); err != nil || !bytes.Equal(pkchain, eca.obcPriv) {
+		t.Fatalf("Expected the chain ECIES private key [%v]", err)
+	}
+
+	log, _, err := eca.auditLog.read(&pb.AuditLogReadReq{Operation: auditEscrow})
+	if err != nil || len(log) != 1 || log[0].Requester != "escrow_auditor" || log[0].Subject != "escrow_other_auditor" {
+		t.Fatalf("Expected the export to be audited [%v]", err)
+	}
+}
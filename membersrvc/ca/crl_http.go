@@ -0,0 +1,58 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// NewCRLHandler returns the HTTP handler serving the last CRLs published
+// by the ECA and the TCA, DER encoded, at /eca.crl and /tca.crl. Peers and
+// other relying parties can poll them without a gRPC client.
+//
+func NewCRLHandler(eca *ECA, tca *TCA) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/eca.crl", crlHandler{eca.CA})
+	mux.Handle("/tca.crl", crlHandler{tca.CA})
+
+	return mux
+}
+
+type crlHandler struct {
+	ca *CA
+}
+
+func (h crlHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	Trace.Println("HTTP GET " + r.URL.Path)
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	raw, err := h.ca.readCRL()
+	if err != nil {
+		Error.Println(err)
+		http.Error(w, "Failed reading CRL.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Header().Set("Content-Length", strconv.Itoa(len(raw)))
+	w.Write(raw)
+}
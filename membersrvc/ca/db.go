@@ -0,0 +1,300 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Database drivers the CAs can keep their tables with. Drivers other
+// than SQLite are available in builds with the tag of the same name.
+//
+const (
+	DBDriverSQLite   = "sqlite3"
+	DBDriverPostgres = "postgres"
+	DBDriverMySQL    = "mysql"
+)
+
+// dbDialect rewrites the statements of the CAs, written for SQLite,
+// for the database they are run against
+//
+type dbDialect struct {
+	quote       string
+	primaryKey  string
+	blob        string
+	insertOr    string
+	onConflict  string
+	placeholder func(n int) string
+
+	// lock and unlock take and release, in the migration transaction,
+	// the lock serializing the migrations of CA replicas sharing the
+	// database. forUpdate makes a select lock the rows it reads.
+	lock, unlock string
+	forUpdate    string
+}
+
+var dbDialects = map[string]*dbDialect{
+	DBDriverSQLite: nil,
+	DBDriverPostgres: {
+		quote:       `"`,
+		primaryKey:  "BIGSERIAL PRIMARY KEY",
+		blob:        "BYTEA",
+		insertOr:    "INSERT INTO",
+		onConflict:  " ON CONFLICT DO NOTHING",
+		placeholder: func(n int) string { return "$" + strconv.Itoa(n) },
+		lock:        "SELECT pg_advisory_xact_lock(7311)",
+		forUpdate:   " FOR UPDATE",
+	},
+	DBDriverMySQL: {
+		quote:      "`",
+		primaryKey: "BIGINT PRIMARY KEY AUTO_INCREMENT",
+		blob:       "LONGBLOB",
+		insertOr:   "INSERT IGNORE INTO",
+		lock:       "SELECT GET_LOCK('membersrvc_migrations', 60)",
+		unlock:     "SELECT RELEASE_LOCK('membersrvc_migrations')",
+		forUpdate:  " FOR UPDATE",
+	},
+}
+
+var (
+	// reservedColumns are the column names reserved by MySQL or Postgres
+	reservedColumns = regexp.MustCompile(`\b(row|key|usage|timestamp)\b`)
+
+	// foreignKeys are not enforced by SQLite; rows refer to parent 0
+	foreignKeys = regexp.MustCompile(`, FOREIGN KEY\([^)]*\) REFERENCES \w+\([^)]*\)`)
+)
+
+// rewrite returns query rewritten for the dialect
+//
+func (dialect *dbDialect) rewrite(query string) string {
+	if dialect == nil {
+		return query
+	}
+
+	query = reservedColumns.ReplaceAllString(query, dialect.quote+"$1"+dialect.quote)
+
+	if strings.HasPrefix(query, "CREATE TABLE") {
+		query = strings.Replace(query, "INTEGER PRIMARY KEY", dialect.primaryKey, -1)
+		query = strings.Replace(query, "INTEGER", "BIGINT", -1)
+		query = strings.Replace(query, "BLOB", dialect.blob, -1)
+		query = foreignKeys.ReplaceAllString(query, "")
+	}
+	if strings.HasPrefix(query, "INSERT OR IGNORE INTO") {
+		query = dialect.insertOr + strings.TrimPrefix(query, "INSERT OR IGNORE INTO") + dialect.onConflict
+	}
+
+	if dialect.placeholder != nil {
+		parts := strings.Split(query, "?")
+		query = parts[0]
+		for i, part := range parts[1:] {
+			query += dialect.placeholder(i+1) + part
+		}
+	}
+
+	return query
+}
+
+// caDB is the database of a CA
+//
+type caDB struct {
+	*sql.DB
+	dialect *dbDialect
+}
+
+// openCADB opens the database of the CA name. It is the SQLite database
+// name.db in path, unless server.database names another driver and its
+// data source, where %s stands for the name of the CA.
+//
+func openCADB(path, name string) (*caDB, error) {
+	driver := GetConfigString("server.database.driver")
+	if driver == "" {
+		driver = DBDriverSQLite
+	}
+	dialect, ok := dbDialects[driver]
+	if !ok {
+		return nil, errors.New("Unsupported database driver " + driver)
+	}
+
+	source := path + "/" + name + ".db"
+	if driver != DBDriverSQLite {
+		source = strings.Replace(GetConfigString("server.database.datasource"), "%s", name, -1)
+	}
+
+	db, err := sql.Open(driver, source)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &caDB{db, dialect}, nil
+}
+
+// Exec runs query, rewritten for the database
+//
+func (db *caDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.dialect.rewrite(query), args...)
+}
+
+// Query runs query, rewritten for the database
+//
+func (db *caDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.dialect.rewrite(query), args...)
+}
+
+// QueryRow runs query, rewritten for the database
+//
+func (db *caDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.dialect.rewrite(query), args...)
+}
+
+// Begin starts a transaction whose statements are rewritten for the database
+//
+func (db *caDB) Begin() (*caTx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &caTx{tx, db.dialect}, nil
+}
+
+// caTx is a transaction on the database of a CA
+//
+type caTx struct {
+	*sql.Tx
+	dialect *dbDialect
+}
+
+// Exec runs query in the transaction, rewritten for the database
+//
+func (tx *caTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.rewrite(query), args...)
+}
+
+// QueryRow runs query in the transaction, rewritten for the database
+//
+func (tx *caTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.rewrite(query), args...)
+}
+
+// errConcurrentUpdate is returned when a conditional update finds the row
+// changed, typically by a replica serving a concurrent request
+//
+var errConcurrentUpdate = errors.New("The row was updated concurrently")
+
+// rowsUpdated returns the error of an update expected to change exactly
+// one row, errConcurrentUpdate if it changed none
+//
+func rowsUpdated(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return errConcurrentUpdate
+	}
+
+	return nil
+}
+
+// migrate brings the tables of component up to date by running the
+// migrations it has not run yet. Migrations are only ever appended to.
+// Replicas starting together migrate one after the other.
+//
+func (db *caDB) migrate(component string, migrations []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := tx.migrate(component, migrations); err != nil {
+		tx.unlock()
+		tx.Rollback()
+		return err
+	}
+	if err := tx.unlock(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// migrate runs the migrations of component in the transaction, holding
+// the migration lock. MySQL commits DDL statements at once and keeps
+// its lock until unlock is called; reading the version for update then
+// makes the next replica wait for the version update to be committed.
+//
+func (tx *caTx) migrate(component string, migrations []string) error {
+	forUpdate := ""
+	if tx.dialect != nil {
+		if _, err := tx.Tx.Exec(tx.dialect.lock); err != nil {
+			return err
+		}
+		forUpdate = tx.dialect.forUpdate
+	}
+
+	if _, err := tx.Exec("CREATE TABLE IF NOT EXISTS SchemaVersions (component VARCHAR(64) PRIMARY KEY, version INTEGER)"); err != nil {
+		return err
+	}
+
+	var version int
+	err := tx.QueryRow("SELECT version FROM SchemaVersions WHERE component=?"+forUpdate, component).Scan(&version)
+	switch {
+	case err == sql.ErrNoRows:
+		if _, err = tx.Exec("INSERT INTO SchemaVersions (component, version) VALUES (?, 0)", component); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	}
+	if version > len(migrations) {
+		return errors.New("The " + component + " tables are newer than this CA")
+	}
+
+	for i, migration := range migrations[version:] {
+		Info.Println("Migrating " + component + " tables to version " + strconv.Itoa(version+i+1) + ".")
+		if _, err := tx.Exec(migration); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("UPDATE SchemaVersions SET version=? WHERE component=?", len(migrations), component)
+
+	return err
+}
+
+// unlock releases the migration lock, unless the database releases it
+// with the transaction
+//
+func (tx *caTx) unlock() error {
+	if tx.dialect == nil || tx.dialect.unlock == "" {
+		return nil
+	}
+	_, err := tx.Tx.Exec(tx.dialect.unlock)
+
+	return err
+}
@@ -23,6 +23,7 @@ import (
 	"crypto/subtle"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"database/sql"
 	"encoding/asn1"
 	"encoding/base64"
 	"encoding/pem"
@@ -33,14 +34,19 @@ import (
 	"strings"
 	"time"
 
+	protobuf "google/protobuf"
+
 	"github.com/hyperledger/fabric/core/crypto/primitives/ecies"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/primitives/secp256k1"
 	pb "github.com/hyperledger/fabric/membersrvc/protos"
 	"github.com/spf13/viper"
+	"golang.org/x/crypto/ed25519"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 var (
@@ -55,8 +61,57 @@ type ECA struct {
 	*CA
 	obcKey          []byte
 	obcPriv, obcPub []byte
+
+	// rotationGrace is how long the certificates replaced by a key
+	// rotation remain readable by hash
+	rotationGrace time.Duration
+
+	// maxEnrollments is how many times users can enroll unless their
+	// registration says otherwise, -1 for no limit. Registrations expire
+	// registrationExpiry after they are made, if set.
+	maxEnrollments     int
+	registrationExpiry time.Duration
+
+	// limiter limits the enrollment attempts of each user
+	limiter *rateLimiter
+
+	// directory authenticates the users not listed in the users table.
+	// They are registered as the groups they are members of are mapped.
+	directory       directory
+	directoryGroups map[string]directoryGroup
+
+	// oidc authenticates the users presenting an ID token of an OpenID
+	// Connect issuer instead of their token
+	oidc *oidcVerifier
+
+	// tca is the TCA issuing TCerts to the users of the ECA, if any
+	tca *TCA
+
+	// auditLog records the operations of the ECA and the TCA
+	auditLog *auditLog
+
+	// escrowAdmins may export the chain keys held in escrow, wrapped
+	// under the encryption ECerts of escrowAuditors only
+	escrowAdmins, escrowAuditors map[string]bool
 }
 
+// userStateRevoked is the state of a user revoked by an admin. Revoked
+// users can neither enroll nor be issued TCerts anymore.
+//
+const userStateRevoked = 3
+
+var (
+	// errEnrollmentLimit is returned to users enrolling more often
+	// than their registration allows
+	//
+	errEnrollmentLimit = grpc.Errorf(codes.ResourceExhausted, "Maximum number of enrollments reached.")
+
+	// errRegistrationExpired is returned to users enrolling once
+	// their registration has expired
+	//
+	errRegistrationExpired = grpc.Errorf(codes.PermissionDenied, "Registration expired.")
+)
+
 // ECAP serves the public GRPC interface of the ECA.
 //
 type ECAP struct {
@@ -69,10 +124,70 @@ type ECAA struct {
 	eca *ECA
 }
 
+// ecaMigrations create and update the tables of the ECA
+//
+var ecaMigrations = []string{
+	"CREATE TABLE IF NOT EXISTS RetiredCertificates (row INTEGER PRIMARY KEY, id VARCHAR(64), hash BLOB, expires INTEGER)",
+	"CREATE TABLE IF NOT EXISTS AuditLog (row INTEGER PRIMARY KEY, timestamp INTEGER, requester VARCHAR(64), operation VARCHAR(16), subject VARCHAR(64), details VARCHAR(1024), hash BLOB)",
+	"ALTER TABLE Users ADD COLUMN issuer VARCHAR(256) DEFAULT ''",
+	"CREATE TABLE IF NOT EXISTS TokenAttributes (row INTEGER PRIMARY KEY, id VARCHAR(64), name VARCHAR(64), value VARCHAR(1024))",
+}
+
 // NewECA sets up a new ECA.
 //
 func NewECA() *ECA {
-	eca := &ECA{NewCA("eca"), nil, nil, nil}
+	eca := &ECA{NewCA("eca"), nil, nil, nil, 24 * time.Hour, 1, 0, nil, nil, nil, nil, nil, nil, nil, nil}
+
+	if err := eca.db.migrate("eca", ecaMigrations); err != nil {
+		Panic.Panicln(err)
+	}
+	eca.auditLog = &auditLog{db: eca.db}
+
+	if grace := GetConfigString("eca.rotation.grace"); grace != "" {
+		d, err := time.ParseDuration(grace)
+		if err != nil {
+			Panic.Panicln(err)
+		}
+		eca.rotationGrace = d
+	}
+	if max := GetConfigString("eca.registration.maxenrollments"); max != "" {
+		n, err := strconv.Atoi(max)
+		if err != nil {
+			Panic.Panicln(err)
+		}
+		eca.maxEnrollments = n
+	}
+	if expiry := GetConfigString("eca.registration.expiry"); expiry != "" {
+		d, err := time.ParseDuration(expiry)
+		if err != nil {
+			Panic.Panicln(err)
+		}
+		eca.registrationExpiry = d
+	}
+
+	eca.escrowAdmins = readUserSet("eca.escrow.admins")
+	eca.escrowAuditors = readUserSet("eca.escrow.auditors")
+
+	limiter, err := readRateLimiter("eca.ratelimit")
+	if err != nil {
+		Panic.Panicln(err)
+	}
+	eca.limiter = limiter
+
+	dir, err := newLDAPDirectory()
+	if err != nil {
+		Panic.Panicln(err)
+	}
+	if dir != nil {
+		if eca.directoryGroups, err = readDirectoryGroups("eca.ldap.groups"); err != nil {
+			Panic.Panicln(err)
+		}
+		eca.directory = dir
+	}
+
+	if eca.oidc, err = newOIDCVerifier(); err != nil {
+		Panic.Panicln(err)
+	}
 
 	{
 		// read or create global symmetric encryption key
@@ -158,7 +273,9 @@ func (eca *ECA) populateUsersTable() {
 			affiliation = vals[2]
 			affiliationRole = vals[3]
 		}
-		eca.registerUser(id, affiliation, affiliationRole, pb.Role(role), vals[1])
+		if _, err := eca.registerUser(id, affiliation, affiliationRole, pb.Role(role), vals[1]); err == nil {
+			eca.auditLog.append("", auditRegister, id, registrationDetails(pb.Role(role), affiliation))
+		}
 	}
 }
 
@@ -189,6 +306,7 @@ func (eca *ECA) populateAffiliationGroupsTable() {
 func (eca *ECA) Start(srv *grpc.Server) {
 	eca.startECAP(srv)
 	eca.startECAA(srv)
+	eca.startCRLPublication()
 
 	Info.Println("ECA started.")
 }
@@ -201,29 +319,32 @@ func (eca *ECA) startECAA(srv *grpc.Server) {
 	pb.RegisterECAAServer(srv, &ECAA{eca})
 }
 
-// ReadCACertificate reads the certificate of the ECA.
+// ReadCACertificate reads the certificate of the ECA, followed by its intermediate certificates.
 //
 func (ecap *ECAP) ReadCACertificate(ctx context.Context, in *pb.Empty) (*pb.Cert, error) {
 	Trace.Println("gRPC ECAP:ReadCACertificate")
+	defer observeRPC("ECAP.ReadCACertificate")()
 
-	return &pb.Cert{ecap.eca.raw}, nil
+	return &pb.Cert{ecap.eca.certificateChain()}, nil
 }
 
 // CreateCertificatePair requests the creation of a new enrollment certificate pair by the ECA.
 //
 func (ecap *ECAP) CreateCertificatePair(ctx context.Context, in *pb.ECertCreateReq) (*pb.ECertCreateResp, error) {
 	Trace.Println("gRPC ECAP:CreateCertificate")
+	defer observeRPC("ECAP.CreateCertificatePair")()
 
 	// validate token
-	var tok, prev []byte
-	var role, state int
-	var enrollID string
-
 	id := in.Id.Id
-	err := ecap.eca.readUser(id).Scan(&role, &tok, &state, &prev, &enrollID)
-
-	if err != nil || !bytes.Equal(tok, in.Tok.Tok) {
-		return nil, errors.New("Identity or token does not match.")
+	if err := ecap.eca.limiter.allow(id); err != nil {
+		return nil, err
+	}
+	role, state, prev, enrollID, err := ecap.eca.authenticateUser(id, in.Tok.Tok)
+	if err != nil {
+		return nil, err
+	}
+	if err := ecap.eca.checkEnrollment(id); err != nil {
+		return nil, err
 	}
 
 	ekey, err := x509.ParsePKIXPublicKey(in.Enc.Key)
@@ -232,28 +353,18 @@ func (ecap *ECAP) CreateCertificatePair(ctx context.Context, in *pb.ECertCreateR
 	}
 
 	switch {
-	case state == 0:
-		// initial request, create encryption challenge
-		tok = []byte(randomString(12))
+	case state == 0 || state == 2:
+		// initial request or re-enrollment, create encryption challenge
+		tok := []byte(randomString(12))
 
-		_, err = ecap.eca.db.Exec("UPDATE Users SET token=?, state=?, key=? WHERE id=?", tok, 1, in.Enc.Key, id)
+		// another replica may be serving a concurrent request of the user
+		err = rowsUpdated(ecap.eca.db.Exec("UPDATE Users SET token=?, state=?, key=? WHERE id=? AND state=?", tok, 1, in.Enc.Key, id, state))
 		if err != nil {
 			Error.Println(err)
 			return nil, err
 		}
 
-		spi := ecies.NewSPI()
-		eciesKey, err := spi.NewPublicKey(nil, ekey.(*ecdsa.PublicKey))
-		if err != nil {
-			return nil, err
-		}
-
-		ecies, err := spi.NewAsymmetricCipherFromPublicKey(eciesKey)
-		if err != nil {
-			return nil, err
-		}
-
-		out, err := ecies.Process(tok)
+		out, err := eciesEncrypt(ekey.(*ecdsa.PublicKey), tok)
 		return &pb.ECertCreateResp{Certs: nil, Chain: nil, Pkchain: nil, Tok: &pb.Token{Tok: out}}, err
 
 	case state == 1:
@@ -266,68 +377,392 @@ func (ecap *ECAP) CreateCertificatePair(ctx context.Context, in *pb.ECertCreateR
 		sig := in.Sig
 		in.Sig = nil
 
-		r, s := big.NewInt(0), big.NewInt(0)
-		r.UnmarshalText(sig.R)
-		s.UnmarshalText(sig.S)
-
-		if in.Sign.Type != pb.CryptoType_ECDSA {
+		if in.Sign.Type != pb.CryptoType_ECDSA && in.Sign.Type != pb.CryptoType_ED25519 {
 			return nil, errors.New("Unsupported (signing) key type.")
 		}
-		skey, err := x509.ParsePKIXPublicKey(in.Sign.Key)
+		skey, err := secp256k1.ParsePKIXPublicKey(in.Sign.Key)
 		if err != nil {
 			return nil, err
 		}
 
-		hash := primitives.NewHash()
 		raw, _ := proto.Marshal(in)
-		hash.Write(raw)
-		if ecdsa.Verify(skey.(*ecdsa.PublicKey), hash.Sum(nil), r, s) == false {
-			return nil, errors.New("Signature verification failed.")
+		if err := verifySignature(skey, sig, raw); err != nil {
+			return nil, err
 		}
 
 		// create new certificate pair
-		ts := time.Now().Add(-1 * time.Minute).UnixNano()
-
-		spec := NewDefaultCertificateSpecWithCommonName(id, enrollID, skey.(*ecdsa.PublicKey), x509.KeyUsageDigitalSignature, pkix.Extension{Id: ECertSubjectRole, Critical: true, Value: []byte(strconv.Itoa(ecap.eca.readRole(id)))})
-		sraw, err := ecap.eca.createCertificateFromSpec(spec, ts, nil)
+		sraw, eraw, ts, err := ecap.eca.createCertificatePair(id, enrollID, skey, ekey.(*ecdsa.PublicKey))
 		if err != nil {
-			Error.Println(err)
 			return nil, err
 		}
 
-		spec = NewDefaultCertificateSpecWithCommonName(id, enrollID, ekey.(*ecdsa.PublicKey), x509.KeyUsageDataEncipherment, pkix.Extension{Id: ECertSubjectRole, Critical: true, Value: []byte(strconv.Itoa(ecap.eca.readRole(id)))})
-		eraw, err := ecap.eca.createCertificateFromSpec(spec, ts, nil)
+		err = rowsUpdated(ecap.eca.db.Exec("UPDATE Users SET state=?, enrollments=enrollments+1 WHERE id=? AND state=?", 2, id, 1))
 		if err != nil {
-			ecap.eca.db.Exec("DELETE FROM Certificates Where id=?", id)
+			ecap.eca.db.Exec("DELETE FROM Certificates Where id=? AND timestamp=?", id, ts)
 			Error.Println(err)
 			return nil, err
 		}
 
-		_, err = ecap.eca.db.Exec("UPDATE Users SET state=? WHERE id=?", 2, id)
-		if err != nil {
-			ecap.eca.db.Exec("DELETE FROM Certificates Where id=?", id)
+		// the certificates of a previous enrollment are replaced
+		if err := ecap.eca.retireCertificates(id, ts); err != nil {
 			Error.Println(err)
-			return nil, err
 		}
+		ecap.eca.auditLog.append(id, auditEnroll, id, "")
 
-		var obcECKey []byte
-		if role == int(pb.Role_VALIDATOR) {
-			obcECKey = ecap.eca.obcPriv
-		} else {
-			obcECKey = ecap.eca.obcPub
+		return ecap.eca.newECertCreateResp(role, sraw, eraw), nil
+
+	}
+
+	return nil, errors.New("Invalid (=expired) certificate creation token provided.")
+}
+
+// authenticateUser checks tok against the token of the user id, registering
+// the user first if it is not listed but the directory authenticates it.
+// tok may also be an ID token of the OIDC issuer. It returns the role,
+// state, encryption key and enrollment ID of the user.
+//
+func (eca *ECA) authenticateUser(id string, tok []byte) (int, int, []byte, string, error) {
+	if eca.oidc != nil && isIDToken(tok) {
+		return eca.authenticateIDToken(id, tok)
+	}
+
+	var userTok, key []byte
+	var role, state int
+	var enrollID string
+
+	err := eca.readUser(id).Scan(&role, &userTok, &state, &key, &enrollID)
+
+	// users not listed may be authenticated by the directory
+	if err == sql.ErrNoRows && eca.directory != nil {
+		var regTok string
+		if regTok, err = eca.registerDirectoryUser(id, tok); err == nil {
+			tok = []byte(regTok)
+			err = eca.readUser(id).Scan(&role, &userTok, &state, &key, &enrollID)
 		}
+	}
 
-		return &pb.ECertCreateResp{Certs: &pb.CertPair{Sign: sraw, Enc: eraw}, Chain: &pb.Token{Tok: ecap.eca.obcKey}, Pkchain: obcECKey, Tok: nil}, nil
+	if err != nil || !bytes.Equal(userTok, tok) {
+		return 0, 0, nil, "", errors.New("Identity or token does not match.")
+	}
+
+	return role, state, key, enrollID, nil
+}
 
+// checkEnrollment returns an error if the registration of the user id
+// does not allow it to enroll anymore
+//
+func (eca *ECA) checkEnrollment(id string) error {
+	var enrollments, maxEnrollments int
+	var expires int64
+	err := eca.db.QueryRow("SELECT enrollments, maxEnrollments, expires FROM Users WHERE id=?", id).Scan(&enrollments, &maxEnrollments, &expires)
+	if err != nil {
+		return err
 	}
 
-	return nil, errors.New("Invalid (=expired) certificate creation token provided.")
+	if expires != 0 && time.Now().Unix() >= expires {
+		return errRegistrationExpired
+	}
+	if maxEnrollments == 0 {
+		maxEnrollments = eca.maxEnrollments
+	}
+	if maxEnrollments >= 0 && enrollments >= maxEnrollments {
+		return errEnrollmentLimit
+	}
+
+	return nil
+}
+
+// limitRegistration sets how many times the user id can enroll and until
+// when, by default as long as registrations made now are valid
+//
+func (eca *ECA) limitRegistration(id string, maxEnrollments int32, expires *protobuf.Timestamp) error {
+	var exp int64
+	switch {
+	case expires != nil:
+		exp = expires.Seconds
+	case eca.registrationExpiry > 0:
+		exp = time.Now().Add(eca.registrationExpiry).Unix()
+	}
+
+	_, err := eca.db.Exec("UPDATE Users SET maxEnrollments=?, expires=? WHERE id=?", maxEnrollments, exp, id)
+	return err
+}
+
+// CreateCertificatePairFromCSR enrolls a user from PKCS#10 requests for the
+// signature and encryption keys it generated, possibly on a device they never
+// leave. The requests are signed by the keys, so no challenge is needed.
+//
+func (ecap *ECAP) CreateCertificatePairFromCSR(ctx context.Context, in *pb.ECertCSRReq) (*pb.ECertCreateResp, error) {
+	Trace.Println("gRPC ECAP:CreateCertificatePairFromCSR")
+	defer observeRPC("ECAP.CreateCertificatePairFromCSR")()
+
+	if in.Id == nil || in.Tok == nil {
+		return nil, errors.New("Invalid enrollment request.")
+	}
+
+	id := in.Id.Id
+	if err := ecap.eca.limiter.allow(id); err != nil {
+		return nil, err
+	}
+	role, state, _, enrollID, err := ecap.eca.authenticateUser(id, in.Tok.Tok)
+	if err != nil {
+		return nil, err
+	}
+	if state != 0 && state != 2 {
+		return nil, errors.New("Invalid (=expired) certificate creation token provided.")
+	}
+	if err := ecap.eca.checkEnrollment(id); err != nil {
+		return nil, err
+	}
+
+	skey, err := parseCertificateRequest(in.Sign, id)
+	if err != nil {
+		return nil, err
+	}
+	switch skey.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+	default:
+		return nil, errors.New("Unsupported (signing) key type.")
+	}
+	ekey, err := parseCertificateRequest(in.Enc, id)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ekey.(*ecdsa.PublicKey); !ok {
+		return nil, errors.New("Unsupported (encryption) key type.")
+	}
+	encKey, err := x509.MarshalPKIXPublicKey(ekey)
+	if err != nil {
+		return nil, err
+	}
+
+	sraw, eraw, ts, err := ecap.eca.createCertificatePair(id, enrollID, skey, ekey.(*ecdsa.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	// another replica may be serving a concurrent request of the user
+	err = rowsUpdated(ecap.eca.db.Exec("UPDATE Users SET state=?, key=?, enrollments=enrollments+1 WHERE id=? AND state=?", 2, encKey, id, state))
+	if err != nil {
+		ecap.eca.db.Exec("DELETE FROM Certificates Where id=? AND timestamp=?", id, ts)
+		Error.Println(err)
+		return nil, err
+	}
+
+	// the certificates of a previous enrollment are replaced
+	if err := ecap.eca.retireCertificates(id, ts); err != nil {
+		Error.Println(err)
+	}
+	ecap.eca.auditLog.append(id, auditEnroll, id, "csr")
+
+	return ecap.eca.newECertCreateResp(role, sraw, eraw), nil
+}
+
+// parseCertificateRequest returns the public key of the DER encoded PKCS#10
+// request raw of the user id, once checked that it signed the request.
+//
+func parseCertificateRequest(raw []byte, id string) (interface{}, error) {
+	csr, err := x509.ParseCertificateRequest(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, errors.New("Invalid certificate request signature.")
+	}
+	if csr.Subject.CommonName != id {
+		return nil, errors.New("Certificate request subject does not match identity.")
+	}
+
+	return csr.PublicKey, nil
+}
+
+// createCertificatePair creates the signature and encryption certificates
+// of the user id for the passed keys and returns them with their timestamp.
+//
+func (eca *ECA) createCertificatePair(id, enrollID string, skey interface{}, ekey *ecdsa.PublicKey) ([]byte, []byte, int64, error) {
+	ts := time.Now().Add(-1 * time.Minute).UnixNano()
+
+	// distinct serial numbers, so that the certificates can be revoked
+	role := pkix.Extension{Id: ECertSubjectRole, Critical: true, Value: []byte(strconv.Itoa(eca.readRole(id)))}
+	sraw, err := eca.createCertificateWithSerial(func(serial *big.Int) (*CertificateSpec, error) {
+		return NewDefaultPeriodCertificateSpecWithCommonName(id, enrollID, serial, skey, x509.KeyUsageDigitalSignature, role), nil
+	}, ts, nil)
+	if err != nil {
+		Error.Println(err)
+		return nil, nil, 0, err
+	}
+
+	eraw, err := eca.createCertificateWithSerial(func(serial *big.Int) (*CertificateSpec, error) {
+		return NewDefaultPeriodCertificateSpecWithCommonName(id, enrollID, serial, ekey, x509.KeyUsageDataEncipherment, role), nil
+	}, ts, nil)
+	if err != nil {
+		eca.db.Exec("DELETE FROM Certificates Where id=? AND timestamp=?", id, ts)
+		Error.Println(err)
+		return nil, nil, 0, err
+	}
+
+	return sraw, eraw, ts, nil
+}
+
+// newECertCreateResp returns the response carrying a new enrollment certificate
+// pair together with the chain keys for the passed role.
+//
+func (eca *ECA) newECertCreateResp(role int, sraw, eraw []byte) *pb.ECertCreateResp {
+	var obcECKey []byte
+	if role == int(pb.Role_VALIDATOR) || role == int(pb.Role_AUDITOR) {
+		// auditors hold the chain key in escrow
+		obcECKey = eca.obcPriv
+	} else {
+		obcECKey = eca.obcPub
+	}
+
+	return &pb.ECertCreateResp{Certs: &pb.CertPair{Sign: sraw, Enc: eraw}, Chain: &pb.Token{Tok: eca.obcKey}, Pkchain: obcECKey, Tok: nil}
+}
+
+// RotateCertificatePair replaces the enrollment certificate pair of an enrolled user with
+// one certifying a new key pair, without registering the user again. The request is signed
+// with the key being replaced and carries a proof of possession of the new signing key.
+// The replaced certificates can still be read by hash for the configured grace period.
+//
+func (ecap *ECAP) RotateCertificatePair(ctx context.Context, in *pb.ECertRotateReq) (*pb.ECertCreateResp, error) {
+	Trace.Println("gRPC ECAP:RotateCertificatePair")
+	defer observeRPC("ECAP.RotateCertificatePair")()
+
+	var tok, prev []byte
+	var role, state int
+	var enrollID string
+
+	id := in.Id.Id
+	err := ecap.eca.readUser(id).Scan(&role, &tok, &state, &prev, &enrollID)
+	if err != nil || state != 2 {
+		return nil, errors.New("Identity not enrolled.")
+	}
+
+	// only the current certificate can be replaced
+	current, err := ecap.eca.readCertificate(id, x509.KeyUsageDigitalSignature)
+	if err != nil || !bytes.Equal(current, in.Cert) {
+		return nil, errors.New("Certificate is not the current enrollment certificate.")
+	}
+	cert, err := secp256k1.ParseCertificate(in.Cert)
+	if err != nil {
+		return nil, err
+	}
+
+	if in.Sign == nil || in.Enc == nil {
+		return nil, errors.New("Keys missing.")
+	}
+	if in.Sign.Type != pb.CryptoType_ECDSA && in.Sign.Type != pb.CryptoType_ED25519 {
+		return nil, errors.New("Unsupported (signing) key type.")
+	}
+	skey, err := secp256k1.ParsePKIXPublicKey(in.Sign.Key)
+	if err != nil {
+		return nil, err
+	}
+	ekey, err := x509.ParsePKIXPublicKey(in.Enc.Key)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ekey.(*ecdsa.PublicKey); !ok {
+		return nil, errors.New("Unsupported (encryption) key type.")
+	}
+
+	// validate request signature by the current key
+	sig := in.Sig
+	in.Sig = nil
+
+	raw, _ := proto.Marshal(in)
+	if err := verifySignature(cert.PublicKey, sig, raw); err != nil {
+		return nil, err
+	}
+
+	// validate proof of possession of the new key
+	pop := in.Pop
+	in.Pop = nil
+
+	raw, _ = proto.Marshal(in)
+	if err := verifySignature(skey, pop, raw); err != nil {
+		return nil, err
+	}
+
+	sraw, eraw, ts, err := ecap.eca.createCertificatePair(id, enrollID, skey, ekey.(*ecdsa.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ecap.eca.retireCertificates(id, ts); err != nil {
+		ecap.eca.db.Exec("DELETE FROM Certificates Where id=? AND timestamp=?", id, ts)
+		Error.Println(err)
+		return nil, err
+	}
+	ecap.eca.auditLog.append(id, auditRotate, id, "")
+
+	return ecap.eca.newECertCreateResp(role, sraw, eraw), nil
+}
+
+// retireCertificates retires the certificates of the user id issued before ts.
+// They expire once the rotation grace period is over.
+//
+func (eca *ECA) retireCertificates(id string, ts int64) error {
+	expires := time.Now().Add(eca.rotationGrace).UnixNano()
+
+	_, err := eca.db.Exec("INSERT INTO RetiredCertificates (id, hash, expires) SELECT id, hash, ? FROM Certificates WHERE id=? AND timestamp<? AND hash NOT IN (SELECT hash FROM RetiredCertificates)", expires, id, ts)
+	return err
+}
+
+// isExpired returns true if the certificate with the passed hash has been
+// retired by a key rotation and its grace period is over.
+//
+func (eca *ECA) isExpired(hash []byte) bool {
+	var expires int64
+	if err := eca.db.QueryRow("SELECT expires FROM RetiredCertificates WHERE hash=?", hash).Scan(&expires); err != nil {
+		return false
+	}
+
+	return time.Now().UnixNano() >= expires
+}
+
+// verifySignature verifies the signature sig of the request raw against the
+// enrollment public key pub. ECDSA signatures are over the hash of raw,
+// Ed25519 ones over raw itself and carried in two halves in R and S.
+//
+func verifySignature(pub interface{}, sig *pb.Signature, raw []byte) error {
+	if sig == nil {
+		return errors.New("Signature missing.")
+	}
+
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		if sig.Type != pb.CryptoType_ECDSA {
+			break
+		}
+
+		r, s := big.NewInt(0), big.NewInt(0)
+		r.UnmarshalText(sig.R)
+		s.UnmarshalText(sig.S)
+
+		hash := primitives.NewHash()
+		hash.Write(raw)
+		if ecdsa.Verify(key, hash.Sum(nil), r, s) {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if sig.Type != pb.CryptoType_ED25519 {
+			break
+		}
+
+		if ed25519.Verify(key, raw, append(append([]byte{}, sig.R...), sig.S...)) {
+			return nil
+		}
+	}
+
+	return errors.New("Signature verification failed.")
 }
 
 // ReadCertificatePair reads an enrollment certificate pair from the ECA.
 //
 func (ecap *ECAP) ReadCertificatePair(ctx context.Context, in *pb.ECertReadReq) (*pb.CertPair, error) {
 	Trace.Println("gRPC ECAP:ReadCertificate")
+	defer observeRPC("ECAP.ReadCertificatePair")()
 
 	rows, err := ecap.eca.readCertificates(in.Id.Id)
 	defer rows.Close()
@@ -349,33 +784,173 @@ func (ecap *ECAP) ReadCertificatePair(ctx context.Context, in *pb.ECertReadReq)
 //
 func (ecap *ECAP) ReadCertificateByHash(ctx context.Context, hash *pb.Hash) (*pb.Cert, error) {
 	Trace.Println("gRPC ECAP:ReadCertificateByHash")
+	defer observeRPC("ECAP.ReadCertificateByHash")()
+
+	if ecap.eca.isExpired(hash.Hash) {
+		return nil, errors.New("Certificate retired.")
+	}
 
 	raw, err := ecap.eca.readCertificateByHash(hash.Hash)
 	return &pb.Cert{raw}, err
 }
 
-// RevokeCertificatePair revokes a certificate pair from the ECA.  Not yet implemented.
+// RevokeCertificatePair revokes a certificate pair of the requester from the ECA.
 //
-func (ecap *ECAP) RevokeCertificatePair(context.Context, *pb.ECertRevokeReq) (*pb.CAStatus, error) {
+func (ecap *ECAP) RevokeCertificatePair(ctx context.Context, in *pb.ECertRevokeReq) (*pb.CAStatus, error) {
 	Trace.Println("gRPC ECAP:RevokeCertificate")
+	defer observeRPC("ECAP.RevokeCertificatePair")()
+
+	return ecap.eca.revokeCertificatePair(in, false)
+}
+
+// ReadCRL returns the last CRL published by the ECA.
+//
+func (ecap *ECAP) ReadCRL(ctx context.Context, in *pb.Empty) (*pb.CRL, error) {
+	Trace.Println("gRPC ECAP:ReadCRL")
+	defer observeRPC("ECAP.ReadCRL")()
 
-	return nil, errors.New("ECAP:RevokeCertificate method not (yet) implemented")
+	raw, err := ecap.eca.readCRL()
+	return &pb.CRL{raw}, err
+}
+
+// verifyRequest verifies that the request raw has been signed with the
+// current enrollment signing key of id and, if role is not zero, that id
+// has that role.
+//
+func (eca *ECA) verifyRequest(id string, role pb.Role, sig *pb.Signature, raw []byte) error {
+	if role != 0 && eca.readRole(id)&int(role) == 0 {
+		return errors.New("Access denied.")
+	}
+
+	cooked, err := eca.readCertificate(id, x509.KeyUsageDigitalSignature)
+	if err != nil {
+		return err
+	}
+	cert, err := secp256k1.ParseCertificate(cooked)
+	if err != nil {
+		return err
+	}
+	if eca.isRevoked(cert.SerialNumber) {
+		return errors.New("Certificate revoked.")
+	}
+
+	return verifySignature(cert.PublicKey, sig, raw)
+}
+
+// revokeCertificatePair revokes the certificate pair in.Cert belongs to.
+// Unless admin is set, the requester can only revoke its own certificates.
+//
+func (eca *ECA) revokeCertificatePair(in *pb.ECertRevokeReq, admin bool) (*pb.CAStatus, error) {
+	if in.Id == nil || in.Cert == nil {
+		return nil, errors.New("Invalid revocation request.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+
+	var role pb.Role
+	if admin {
+		role = pb.Role_AUDITOR
+	}
+	raw, _ := proto.Marshal(in)
+	if err := eca.verifyRequest(in.Id.Id, role, sig, raw); err != nil {
+		return nil, err
+	}
+
+	hash := primitives.NewHash()
+	hash.Write(in.Cert.Cert)
+	id, ts, err := eca.readCertificateOwner(hash.Sum(nil))
+	if err != nil {
+		return nil, errors.New("Unknown certificate.")
+	}
+	if !admin && id != in.Id.Id {
+		return nil, errors.New("Access denied.")
+	}
+
+	if err := eca.revokeCertificates(id, ts); err != nil {
+		Error.Println(err)
+		return nil, err
+	}
+	eca.auditLog.append(in.Id.Id, auditRevoke, id, "ecert "+strconv.FormatInt(ts, 10))
+
+	return &pb.CAStatus{pb.CAStatus_OK}, nil
 }
 
 // RegisterUser registers a new user with the ECA.  If the user had been registered before
-// an error is returned.
+// an error is returned. The registration may limit how many times the user can
+// enroll, re-enrolling with its token, and until when.
 //
 func (ecaa *ECAA) RegisterUser(ctx context.Context, in *pb.RegisterUserReq) (*pb.Token, error) {
 	Trace.Println("gRPC ECAA:RegisterUser")
+	defer observeRPC("ECAA.RegisterUser")()
 
-	tok, err := ecaa.eca.registerUser(in.Id.Id, in.Account, in.Affiliation, in.Role)
+	tok, err := ecaa.eca.registerUserReq("", in)
 	return &pb.Token{[]byte(tok)}, err
 }
 
+// RegisterUserSet registers users in bulk on behalf of an admin. Each user
+// is checked and registered on its own, so that users failing to register
+// do not prevent the others from registering. The results tell which did.
+//
+func (ecaa *ECAA) RegisterUserSet(ctx context.Context, in *pb.RegisterUserSetReq) (*pb.RegisterUserSetResp, error) {
+	Trace.Println("gRPC ECAA:RegisterUserSet")
+	defer observeRPC("ECAA.RegisterUserSet")()
+
+	if in.Id == nil {
+		return nil, errors.New("Invalid registration request.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+
+	raw, _ := proto.Marshal(in)
+	if err := ecaa.eca.verifyRequest(in.Id.Id, pb.Role_AUDITOR, sig, raw); err != nil {
+		return nil, err
+	}
+
+	Info.Println(in.Id.Id + " registers " + strconv.Itoa(len(in.Users)) + " users.")
+
+	results := make([]*pb.RegisterUserResult, len(in.Users))
+	for i, user := range in.Users {
+		results[i] = &pb.RegisterUserResult{Id: user.GetId()}
+		tok, err := ecaa.eca.registerUserReq(in.Id.Id, user)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Tok = &pb.Token{[]byte(tok)}
+	}
+
+	return &pb.RegisterUserSetResp{results}, nil
+}
+
+// registerUserReq checks the registration request in, made by requester,
+// registers the user and returns its token
+//
+func (eca *ECA) registerUserReq(requester string, in *pb.RegisterUserReq) (string, error) {
+	switch {
+	case in.Id == nil || in.Id.Id == "":
+		return "", errors.New("The enrollment ID is missing.")
+	case in.Role == pb.Role_NONE || in.Role&^(pb.Role_CLIENT|pb.Role_PEER|pb.Role_VALIDATOR|pb.Role_AUDITOR) != 0:
+		return "", errors.New("Invalid role " + strconv.Itoa(int(in.Role)) + ".")
+	case in.MaxEnrollments < -1:
+		return "", errors.New("Invalid maximum number of enrollments " + strconv.Itoa(int(in.MaxEnrollments)) + ".")
+	}
+
+	tok, err := eca.registerUser(in.Id.Id, in.Account, in.Affiliation, in.Role)
+	if err != nil {
+		return "", err
+	}
+	eca.auditLog.append(requester, auditRegister, in.Id.Id, registrationDetails(in.Role, in.Account))
+
+	return tok, eca.limitRegistration(in.Id.Id, in.MaxEnrollments, in.Expires)
+}
+
 // ReadUserSet returns a list of users matching the parameters set in the read request.
 //
 func (ecaa *ECAA) ReadUserSet(ctx context.Context, in *pb.ReadUserSetReq) (*pb.UserSet, error) {
 	Trace.Println("gRPC ECAA:ReadUserSet")
+	defer observeRPC("ECAA.ReadUserSet")()
 
 	req := in.Req.Id
 	if ecaa.eca.readRole(req)&int(pb.Role_AUDITOR) == 0 {
@@ -386,7 +961,7 @@ func (ecaa *ECAA) ReadUserSet(ctx context.Context, in *pb.ReadUserSetReq) (*pb.U
 	if err != nil {
 		return nil, err
 	}
-	cert, err := x509.ParseCertificate(raw)
+	cert, err := secp256k1.ParseCertificate(raw)
 	if err != nil {
 		return nil, err
 	}
@@ -394,15 +969,9 @@ func (ecaa *ECAA) ReadUserSet(ctx context.Context, in *pb.ReadUserSetReq) (*pb.U
 	sig := in.Sig
 	in.Sig = nil
 
-	r, s := big.NewInt(0), big.NewInt(0)
-	r.UnmarshalText(sig.R)
-	s.UnmarshalText(sig.S)
-
-	hash := primitives.NewHash()
 	raw, _ = proto.Marshal(in)
-	hash.Write(raw)
-	if ecdsa.Verify(cert.PublicKey.(*ecdsa.PublicKey), hash.Sum(nil), r, s) == false {
-		return nil, errors.New("Signature verification failed.")
+	if err := verifySignature(cert.PublicKey, sig, raw); err != nil {
+		return nil, err
 	}
 
 	rows, err := ecaa.eca.readUsers(int(in.Role))
@@ -426,18 +995,236 @@ func (ecaa *ECAA) ReadUserSet(ctx context.Context, in *pb.ReadUserSetReq) (*pb.U
 	return &pb.UserSet{users}, err
 }
 
-// RevokeCertificate revokes a certificate from the ECA.  Not yet implemented.
+// RevokeCertificate revokes the certificate pair of any user from the ECA.
 //
-func (ecaa *ECAA) RevokeCertificate(context.Context, *pb.ECertRevokeReq) (*pb.CAStatus, error) {
+func (ecaa *ECAA) RevokeCertificate(ctx context.Context, in *pb.ECertRevokeReq) (*pb.CAStatus, error) {
 	Trace.Println("gRPC ECAA:RevokeCertificate")
+	defer observeRPC("ECAA.RevokeCertificate")()
+
+	return ecaa.eca.revokeCertificatePair(in, true)
+}
+
+// RevokeUser revokes a user along with all the ECerts and TCerts issued to
+// it, and publishes the new CRLs. The user cannot be issued TCerts anymore.
+//
+func (ecaa *ECAA) RevokeUser(ctx context.Context, in *pb.ECertRevokeUserReq) (*pb.CAStatus, error) {
+	Trace.Println("gRPC ECAA:RevokeUser")
+	defer observeRPC("ECAA.RevokeUser")()
+
+	if in.Id == nil || in.User == nil {
+		return nil, errors.New("Invalid revocation request.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
 
-	return nil, errors.New("ECAA:RevokeCertificate method not (yet) implemented")
+	raw, _ := proto.Marshal(in)
+	if err := ecaa.eca.verifyRequest(in.Id.Id, pb.Role_AUDITOR, sig, raw); err != nil {
+		return nil, err
+	}
+
+	if err := ecaa.eca.revokeUser(in.User.Id); err != nil {
+		Error.Println(err)
+		return nil, err
+	}
+	ecaa.eca.auditLog.append(in.Id.Id, auditRevoke, in.User.Id, "user")
+
+	return &pb.CAStatus{pb.CAStatus_OK}, nil
 }
 
-// PublishCRL requests the creation of a certificate revocation list from the ECA.  Not yet implemented.
+// revokeUser marks the user id revoked, revokes its ECerts and TCerts and
+// publishes the new CRLs.
 //
-func (ecaa *ECAA) PublishCRL(context.Context, *pb.ECertCRLReq) (*pb.CAStatus, error) {
+func (eca *ECA) revokeUser(id string) error {
+	var row int
+	if err := eca.db.QueryRow("SELECT row FROM Users WHERE id=?", id).Scan(&row); err != nil {
+		return errors.New("Unknown user.")
+	}
+
+	Info.Println("Revoking user " + id + ".")
+	if _, err := eca.db.Exec("UPDATE Users SET state=? WHERE id=?", userStateRevoked, id); err != nil {
+		return err
+	}
+
+	cas := []*CA{eca.CA}
+	if eca.tca != nil {
+		cas = append(cas, eca.tca.CA)
+	}
+	for _, ca := range cas {
+		if _, err := ca.revokeMatchingCertificates("id=?", id); err != nil {
+			return err
+		}
+		if _, err := ca.publishCRL(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PublishCRL requests the creation of a certificate revocation list from the ECA.
+//
+func (ecaa *ECAA) PublishCRL(ctx context.Context, in *pb.ECertCRLReq) (*pb.CAStatus, error) {
 	Trace.Println("gRPC ECAA:CreateCRL")
+	defer observeRPC("ECAA.PublishCRL")()
+
+	if in.Id == nil {
+		return nil, errors.New("Invalid CRL request.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+
+	raw, _ := proto.Marshal(in)
+	if err := ecaa.eca.verifyRequest(in.Id.Id, pb.Role_AUDITOR, sig, raw); err != nil {
+		return nil, err
+	}
+
+	if _, err := ecaa.eca.publishCRL(); err != nil {
+		return nil, err
+	}
+
+	return &pb.CAStatus{pb.CAStatus_OK}, nil
+}
+
+// ReadAuditLog returns the records of the audit log matching the request,
+// which auditors can verify with the hashes chaining them. The chain of
+// the whole log is checked as well if the request asks for it.
+//
+func (ecaa *ECAA) ReadAuditLog(ctx context.Context, in *pb.AuditLogReadReq) (*pb.AuditLog, error) {
+	Trace.Println("gRPC ECAA:ReadAuditLog")
+	defer observeRPC("ECAA.ReadAuditLog")()
+
+	if in.Id == nil {
+		return nil, errors.New("Invalid audit log request.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+
+	raw, _ := proto.Marshal(in)
+	if err := ecaa.eca.verifyRequest(in.Id.Id, pb.Role_AUDITOR, sig, raw); err != nil {
+		return nil, err
+	}
+
+	records, prev, err := ecaa.eca.auditLog.read(in)
+	if err != nil {
+		Error.Println(err)
+		return nil, err
+	}
+
+	var broken uint64
+	if in.Verify {
+		if broken, err = ecaa.eca.auditLog.verify(); err != nil {
+			Error.Println(err)
+			return nil, err
+		}
+	}
+
+	return &pb.AuditLog{records, prev, broken}, nil
+}
+
+// ExportEscrowKeys returns the chain keys the ECA holds in escrow, wrapped
+// under the encryption ECert of an auditor, so that the auditor can read
+// the confidential transactions of the chain. Only the escrow admins can
+// export them, for the escrow auditors only.
+//
+func (ecaa *ECAA) ExportEscrowKeys(ctx context.Context, in *pb.EscrowKeysExportReq) (*pb.EscrowKeys, error) {
+	Trace.Println("gRPC ECAA:ExportEscrowKeys")
+	defer observeRPC("ECAA.ExportEscrowKeys")()
+
+	if in.Id == nil {
+		return nil, errors.New("Invalid escrow keys request.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+
+	raw, _ := proto.Marshal(in)
+	if err := ecaa.eca.verifyRequest(in.Id.Id, pb.Role_AUDITOR, sig, raw); err != nil {
+		return nil, err
+	}
+	if !ecaa.eca.escrowAdmins[in.Id.Id] {
+		Warning.Println(in.Id.Id + " is not allowed to export the chain keys.")
+		return nil, errors.New("Access denied.")
+	}
+
+	auditor := in.Id.Id
+	if in.Auditor != nil && in.Auditor.Id != "" {
+		auditor = in.Auditor.Id
+	}
+
+	keys, err := ecaa.eca.exportEscrowKeys(auditor)
+	if err != nil {
+		Error.Println(err)
+		return nil, err
+	}
+	Info.Println(in.Id.Id + " exports the chain keys for " + auditor + ".")
+	ecaa.eca.auditLog.append(in.Id.Id, auditEscrow, auditor, "")
+
+	return keys, nil
+}
+
+// readUserSet reads the space separated user ids configured under key
+//
+func readUserSet(key string) map[string]bool {
+	ids := make(map[string]bool)
+	for _, id := range strings.Fields(GetConfigString(key)) {
+		ids[id] = true
+	}
+
+	return ids
+}
+
+// exportEscrowKeys wraps the chain keys under the current encryption ECert
+// of the auditor id, which must be an escrow auditor, enrolled and not revoked
+//
+func (eca *ECA) exportEscrowKeys(id string) (*pb.EscrowKeys, error) {
+	if !eca.escrowAuditors[id] || eca.readRole(id)&int(pb.Role_AUDITOR) == 0 || eca.readState(id) == userStateRevoked {
+		return nil, errors.New("Chain keys can only be exported for escrow auditors.")
+	}
+
+	raw, err := eca.readCertificate(id, x509.KeyUsageDataEncipherment)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := secp256k1.ParseCertificate(raw)
+	if err != nil {
+		return nil, err
+	}
+	if eca.isRevoked(cert.SerialNumber) {
+		return nil, errors.New("Certificate revoked.")
+	}
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("Chain keys can only be wrapped under ECDSA encryption certificates.")
+	}
+
+	chain, err := eciesEncrypt(pub, eca.obcKey)
+	if err != nil {
+		return nil, err
+	}
+	pkchain, err := eciesEncrypt(pub, eca.obcPriv)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.EscrowKeys{Enc: &pb.Cert{raw}, Chain: chain, Pkchain: pkchain}, nil
+}
+
+// eciesEncrypt encrypts msg under pub with ECIES
+//
+func eciesEncrypt(pub *ecdsa.PublicKey, msg []byte) ([]byte, error) {
+	spi := ecies.NewSPI()
+	eciesKey, err := spi.NewPublicKey(nil, pub)
+	if err != nil {
+		return nil, err
+	}
+
+	cipher, err := spi.NewAsymmetricCipherFromPublicKey(eciesKey)
+	if err != nil {
+		return nil, err
+	}
 
-	return nil, errors.New("ECAA:PublishCRL method not (yet) implemented")
+	return cipher.Process(msg)
 }
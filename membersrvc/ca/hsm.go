@@ -0,0 +1,190 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"bytes"
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/primitives/secp256k1"
+)
+
+// HSM is a hardware security module, typically accessed via PKCS#11,
+// keeping the signing key of a CA on the device. The HSMs of the crypto
+// layer implement it.
+//
+type HSM interface {
+	// FindKey returns the public part of the key stored under label
+	FindKey(label string) (*ecdsa.PublicKey, error)
+
+	// Sign signs digest on the device with the key stored under label.
+	// The signature is ASN.1 encoded, as for ecdsa keys.
+	Sign(label string, digest []byte) ([]byte, error)
+
+	// Close releases the session with the device
+	Close() error
+}
+
+// HSMKeyGenerator is implemented by the HSMs able to generate keys on
+// the device, on the curve of the security level
+//
+type HSMKeyGenerator interface {
+	GenerateKey(label string) (*ecdsa.PublicKey, error)
+}
+
+// HSMKeyImporter is implemented by the HSMs able to store keys generated
+// in software
+//
+type HSMKeyImporter interface {
+	ImportKey(label string, key *ecdsa.PrivateKey, persistent bool) error
+}
+
+// HSMProvider opens a session with an HSM on behalf of the CA name
+//
+type HSMProvider func(name string) (HSM, error)
+
+var (
+	hsmProviders     = make(map[string]HSMProvider)
+	hsmProvidersLock sync.RWMutex
+)
+
+// RegisterHSMProvider registers provider under name. The CAs listed by
+// server.hsm.keys keep their signing keys on the HSM of the provider
+// server.hsm.provider.
+//
+func RegisterHSMProvider(name string, provider HSMProvider) error {
+	if name == "" || provider == nil {
+		return errors.New("Invalid HSM provider " + name)
+	}
+
+	hsmProvidersLock.Lock()
+	defer hsmProvidersLock.Unlock()
+
+	if _, ok := hsmProviders[name]; ok {
+		return errors.New("HSM provider " + name + " already registered")
+	}
+	hsmProviders[name] = provider
+
+	return nil
+}
+
+// isKeyInHSM returns true if the CA name keeps its signing key on an HSM
+//
+func isKeyInHSM(name string) bool {
+	if GetConfigString("server.hsm.provider") == "" {
+		return false
+	}
+	for _, n := range strings.Fields(GetConfigString("server.hsm.keys")) {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hsmSigner signs with a key kept on an HSM
+//
+type hsmSigner struct {
+	hsm   HSM
+	label string
+	pub   *ecdsa.PublicKey
+}
+
+func (signer *hsmSigner) Public() gocrypto.PublicKey {
+	return signer.pub
+}
+
+func (signer *hsmSigner) Sign(rand io.Reader, digest []byte, opts gocrypto.SignerOpts) ([]byte, error) {
+	return signer.hsm.Sign(signer.label, digest)
+}
+
+// readHSMKey opens the session of the CA name with the HSM and returns a
+// handle to its signing key, stored on the device under the label name.
+// Unless the key exists, it is created if fresh is set, the CA having
+// no certificate yet: on the device if the HSM can generate keys, else in
+// software, then imported and never written to disk.
+//
+func (ca *CA) readHSMKey(name string, fresh bool) (gocrypto.Signer, error) {
+	Trace.Println("Reading CA private key from the HSM.")
+
+	provider := GetConfigString("server.hsm.provider")
+	hsmProvidersLock.RLock()
+	newHSM, ok := hsmProviders[provider]
+	hsmProvidersLock.RUnlock()
+	if !ok {
+		return nil, errors.New("HSM provider " + provider + " not registered")
+	}
+
+	hsm, err := newHSM(name)
+	if err != nil {
+		return nil, err
+	}
+	ca.hsm = hsm
+
+	pub, err := hsm.FindKey(name)
+	if err != nil && fresh {
+		Info.Println("Creating the signing key of " + name + " on the HSM.")
+
+		switch hsm := hsm.(type) {
+		case HSMKeyGenerator:
+			pub, err = hsm.GenerateKey(name)
+		case HSMKeyImporter:
+			var priv *ecdsa.PrivateKey
+			if priv, err = ecdsa.GenerateKey(primitives.GetDefaultCurve(), rand.Reader); err != nil {
+				return nil, err
+			}
+			if err = hsm.ImportKey(name, priv, true); err == nil {
+				pub = &priv.PublicKey
+			}
+		default:
+			err = errors.New("The HSM can neither generate nor import the signing key of " + name)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &hsmSigner{hsm, name, pub}, nil
+}
+
+// checkCAKey returns an error unless the signing key of the CA is the key
+// its certificate was issued for, as when the key stored on the HSM is not
+// the one the published certificate certifies
+//
+func (ca *CA) checkCAKey() error {
+	certPub, err := secp256k1.MarshalPKIXPublicKey(ca.cert.PublicKey)
+	if err != nil {
+		return err
+	}
+	keyPub, err := secp256k1.MarshalPKIXPublicKey(ca.priv.Public())
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(certPub, keyPub) {
+		return errors.New("The signing key of " + ca.cert.Subject.CommonName + " does not match its certificate")
+	}
+
+	return nil
+}
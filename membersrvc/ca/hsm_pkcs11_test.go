@@ -0,0 +1,182 @@
+// +build cgo
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto"
+	"github.com/hyperledger/fabric/core/crypto/hsm"
+	"github.com/miekg/pkcs11"
+	"github.com/spf13/viper"
+)
+
+const (
+	testSoftHSMLabel = "membersrvc"
+	testSoftHSMPin   = "98765432"
+)
+
+// softHSMLibrary returns the path of the SoftHSM module, set by
+// SOFTHSM2_LIB or found where the distributions install it
+func softHSMLibrary() string {
+	if lib := os.Getenv("SOFTHSM2_LIB"); lib != "" {
+		return lib
+	}
+	for _, lib := range []string{
+		"/usr/lib/softhsm/libsofthsm2.so",
+		"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+		"/usr/local/lib/softhsm/libsofthsm2.so",
+		"/usr/lib64/pkcs11/libsofthsm2.so",
+	} {
+		if _, err := os.Stat(lib); err == nil {
+			return lib
+		}
+	}
+
+	return ""
+}
+
+// initSoftHSM creates a SoftHSM token in dir, labelled testSoftHSMLabel
+func initSoftHSM(t *testing.T, lib, dir string) {
+	conf := filepath.Join(dir, "softhsm2.conf")
+	tokens := filepath.Join(dir, "tokens")
+	if err := os.Mkdir(tokens, 0700); err != nil {
+		t.Fatalf("Failed creating token directory [%s]", err)
+	}
+	if err := ioutil.WriteFile(conf, []byte(fmt.Sprintf("directories.tokendir = %s\nobjectstore.backend = file\n", tokens)), 0600); err != nil {
+		t.Fatalf("Failed writing SoftHSM configuration [%s]", err)
+	}
+	os.Setenv("SOFTHSM2_CONF", conf)
+
+	ctx := pkcs11.New(lib)
+	if ctx == nil {
+		t.Fatalf("Failed loading [%s]", lib)
+	}
+	defer ctx.Destroy()
+	if err := ctx.Initialize(); err != nil {
+		t.Fatalf("Failed initializing SoftHSM [%s]", err)
+	}
+	defer ctx.Finalize()
+
+	slots, err := ctx.GetSlotList(true)
+	if err != nil || len(slots) == 0 {
+		t.Fatalf("Failed listing slots [%s]", err)
+	}
+	if err := ctx.InitToken(slots[0], testSoftHSMPin, testSoftHSMLabel); err != nil {
+		t.Fatalf("Failed initializing token [%s]", err)
+	}
+
+	// The token moves to another slot once initialized
+	slots, err = ctx.GetSlotList(true)
+	if err != nil {
+		t.Fatalf("Failed listing slots [%s]", err)
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil || info.Label != testSoftHSMLabel {
+			continue
+		}
+		session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+		if err != nil {
+			t.Fatalf("Failed opening session [%s]", err)
+		}
+		defer ctx.CloseSession(session)
+		if err := ctx.Login(session, pkcs11.CKU_SO, testSoftHSMPin); err != nil {
+			t.Fatalf("Failed logging in [%s]", err)
+		}
+		if err := ctx.InitPIN(session, testSoftHSMPin); err != nil {
+			t.Fatalf("Failed setting PIN [%s]", err)
+		}
+		ctx.Logout(session)
+
+		return
+	}
+	t.Fatal("Initialized token not found")
+}
+
+func TestPKCS11CAKeys(t *testing.T) {
+	lib := softHSMLibrary()
+	if lib == "" {
+		t.Skip("SoftHSM not installed")
+	}
+	dir, err := ioutil.TempDir("", "TestPKCS11CAKeys")
+	if err != nil {
+		t.Fatalf("Failed creating directory [%s]", err)
+	}
+	defer os.RemoveAll(dir)
+	initSoftHSM(t, lib, dir)
+
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	viper.Set("server.hsm.provider", HSMProviderPKCS11)
+	viper.Set("server.hsm.library", lib)
+	viper.Set("server.hsm.label", testSoftHSMLabel)
+	viper.Set("server.hsm.pin", testSoftHSMPin)
+	viper.Set("server.hsm.keys", "eca")
+	defer viper.Set("server.hsm.provider", "")
+
+	// the signing key of the ECA is generated on the token
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	if _, err := os.Stat(eca.path + "/eca.priv"); err == nil {
+		eca.Close()
+		t.Fatal("The signing key of the ECA must not be written to disk")
+	}
+	token, err := hsm.OpenPKCS11(&hsm.PKCS11Config{Library: lib, Label: testSoftHSMLabel, Pin: testSoftHSMPin})
+	if err != nil {
+		eca.Close()
+		t.Fatalf("Failed opening HSM [%s]", err)
+	}
+	pub, err := token.FindKey("eca")
+	token.Close()
+	if err != nil {
+		eca.Close()
+		t.Fatalf("The signing key of the ECA must be on the token [%s]", err)
+	}
+	if certPub := eca.cert.PublicKey.(*ecdsa.PublicKey); pub.X.Cmp(certPub.X) != 0 || pub.Y.Cmp(certPub.Y) != 0 {
+		eca.Close()
+		t.Fatal("The certificate of the ECA must certify the key on the token")
+	}
+
+	// certificates are signed on the token
+	key, _ := newTestKey(t)
+	raw, err := eca.createCertificate("pkcs11_user", &key.PublicKey, x509.KeyUsageDigitalSignature, time.Now().UnixNano(), nil)
+	eca.Close()
+	if err != nil {
+		t.Fatalf("Failed creating certificate [%s]", err)
+	}
+	cert, _ := x509.ParseCertificate(raw)
+	if err := cert.CheckSignatureFrom(eca.cert); err != nil {
+		t.Fatalf("The certificate must be signed by the ECA key [%s]", err)
+	}
+
+	// the ECA starts again with its key on the token
+	eca = NewECA()
+	eca.Close()
+}
@@ -0,0 +1,500 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"github.com/spf13/viper"
+)
+
+// directory authenticates users the ECA does not list, such as
+// the users of an LDAP or Active Directory server
+//
+type directory interface {
+	// authenticate checks the password of the user id and
+	// returns the groups the user is a member of
+	authenticate(id string, password []byte) ([]string, error)
+}
+
+// directoryGroup is what the users of a directory group are registered as
+//
+type directoryGroup struct {
+	role            pb.Role
+	affiliation     string
+	affiliationRole string
+}
+
+// registerDirectoryUser registers the user id, unknown to the ECA, if the
+// directory authenticates it and it is a member of a mapped group. The
+// user is registered as the first such group says and its token returned.
+//
+func (eca *ECA) registerDirectoryUser(id string, password []byte) (string, error) {
+	groups, err := eca.directory.authenticate(id, password)
+	if err != nil {
+		return "", err
+	}
+
+	for _, dn := range groups {
+		group, ok := eca.directoryGroups[groupName(dn)]
+		if !ok {
+			continue
+		}
+
+		Info.Println("Registering directory user " + id + " as a member of " + dn + ".")
+		tok, err := eca.registerUser(id, group.affiliation, group.affiliationRole, group.role)
+		if err == nil {
+			eca.auditLog.append(id, auditRegister, id, registrationDetails(group.role, group.affiliation)+" directory="+dn)
+		}
+		return tok, err
+	}
+
+	return "", errors.New("User " + id + " is not a member of any directory group mapped to a role.")
+}
+
+// ldapDirectory authenticates users with an LDAP simple bind
+//
+type ldapDirectory struct {
+	url            *url.URL
+	userDN         string
+	groupAttribute string
+	timeout        time.Duration
+	tlsConfig      *tls.Config
+}
+
+// newLDAPDirectory returns the directory configured under eca.ldap,
+// nil if none is
+//
+func newLDAPDirectory() (*ldapDirectory, error) {
+	if !viper.GetBool("eca.ldap.enabled") {
+		return nil, nil
+	}
+
+	return readLDAPDirectory("eca.ldap")
+}
+
+// readLDAPDirectory reads the settings of the LDAP server under prefix
+//
+func readLDAPDirectory(prefix string) (*ldapDirectory, error) {
+	u, err := url.Parse(GetConfigString(prefix + ".url"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return nil, errors.New("Invalid LDAP URL " + u.String())
+	}
+
+	dir := &ldapDirectory{
+		url:            u,
+		userDN:         GetConfigString(prefix + ".userdn"),
+		groupAttribute: "memberOf",
+		timeout:        5 * time.Second,
+		tlsConfig:      &tls.Config{ServerName: u.Hostname()},
+	}
+	if !strings.Contains(dir.userDN, "%s") {
+		return nil, errors.New("The LDAP user DN must contain %s, replaced by the enrollment ID")
+	}
+	if attr := GetConfigString(prefix + ".groupattribute"); attr != "" {
+		dir.groupAttribute = attr
+	}
+	if timeout := GetConfigString(prefix + ".timeout"); timeout != "" {
+		if dir.timeout, err = time.ParseDuration(timeout); err != nil {
+			return nil, err
+		}
+	}
+
+	return dir, nil
+}
+
+// readDirectoryGroups reads the mapping of directory groups to roles and
+// affiliations under key, e.g. eca.ldap.groups. Groups are named after the value
+// of the first RDN of their DN, e.g. bank_a_clients for
+// cn=bank_a_clients,ou=groups,dc=example,dc=com.
+//
+func readDirectoryGroups(key string) (map[string]directoryGroup, error) {
+	groups := make(map[string]directoryGroup)
+	for name, flds := range viper.GetStringMapString(key) {
+		vals := strings.Fields(flds)
+		if len(vals) == 0 {
+			return nil, errors.New("Invalid group mapping " + name)
+		}
+		role, err := strconv.Atoi(vals[0])
+		if err != nil {
+			return nil, err
+		}
+
+		group := directoryGroup{role: pb.Role(role)}
+		if len(vals) >= 3 {
+			group.affiliation = vals[1]
+			group.affiliationRole = vals[2]
+		}
+		groups[strings.ToLower(name)] = group
+	}
+
+	return groups, nil
+}
+
+// groupName returns the value of the first RDN of the DN dn
+//
+func groupName(dn string) string {
+	rdn := dn
+	for i := 0; i < len(dn); i++ {
+		if dn[i] == '\\' {
+			i++
+		} else if dn[i] == ',' {
+			rdn = dn[:i]
+			break
+		}
+	}
+	if i := strings.Index(rdn, "="); i >= 0 {
+		rdn = rdn[i+1:]
+	}
+
+	return strings.ToLower(strings.TrimSpace(rdn))
+}
+
+// escapeDN escapes the special characters of an attribute value in a DN
+//
+func escapeDN(value string) string {
+	var b bytes.Buffer
+	for i, c := range value {
+		switch {
+		case strings.ContainsRune(",+\"\\<>;=", c),
+			c == '#' && i == 0,
+			c == ' ' && (i == 0 || i == len(value)-1):
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+func (dir *ldapDirectory) authenticate(id string, password []byte) ([]string, error) {
+	// An empty password is an unauthenticated bind, which servers accept
+	if len(password) == 0 {
+		return nil, errors.New("Identity or token does not match.")
+	}
+
+	conn, err := dir.dial()
+	if err != nil {
+		Error.Println("Failed connecting to LDAP server:", err)
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dir.timeout))
+
+	ldap := &ldapConn{conn: conn, r: bufio.NewReader(conn)}
+	defer ldap.unbind()
+
+	dn := fmt.Sprintf(dir.userDN, escapeDN(id))
+	if err := ldap.bind(dn, password); err != nil {
+		Trace.Println("LDAP bind failed for " + dn + ": " + err.Error())
+		return nil, errors.New("Identity or token does not match.")
+	}
+
+	groups, err := ldap.readAttribute(dn, dir.groupAttribute)
+	if err != nil {
+		Error.Println("Failed reading groups from LDAP server:", err)
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+func (dir *ldapDirectory) dial() (net.Conn, error) {
+	host := dir.url.Host
+	if dir.url.Port() == "" {
+		if dir.url.Scheme == "ldaps" {
+			host = net.JoinHostPort(host, "636")
+		} else {
+			host = net.JoinHostPort(host, "389")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: dir.timeout}
+	if dir.url.Scheme == "ldaps" {
+		return tls.DialWithDialer(dialer, "tcp", host, dir.tlsConfig)
+	}
+
+	return dialer.Dial("tcp", host)
+}
+
+// BER tags of the LDAP protocol (RFC 4511)
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0a
+	berBoolean     = 0x01
+	berSequence    = 0x30
+	berSet         = 0x31
+
+	ldapBindRequest       = 0x60
+	ldapBindResponse      = 0x61
+	ldapUnbindRequest     = 0x42
+	ldapSearchRequest     = 0x63
+	ldapSearchResultEntry = 0x64
+	ldapSearchResultDone  = 0x65
+	ldapSimpleAuth        = 0x80
+	ldapPresentFilter     = 0x87
+)
+
+// berElement is a decoded BER element
+//
+type berElement struct {
+	tag     byte
+	content []byte
+}
+
+func berEncode(tag byte, content ...[]byte) []byte {
+	var body []byte
+	for _, c := range content {
+		body = append(body, c...)
+	}
+
+	var length []byte
+	switch n := len(body); {
+	case n < 0x80:
+		length = []byte{byte(n)}
+	default:
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		length = append([]byte{0x80 | byte(len(length))}, length...)
+	}
+
+	return append(append([]byte{tag}, length...), body...)
+}
+
+func berInt(tag byte, v int) []byte {
+	b := []byte{byte(v)}
+	for v >>= 8; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+
+	return berEncode(tag, b)
+}
+
+// berRead reads a BER element from r. Lengths in the long form,
+// as sent by Active Directory, are accepted.
+//
+func berRead(r io.Reader) (berElement, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return berElement{}, err
+	}
+
+	length := int(head[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 {
+			return berElement{}, errors.New("Unsupported BER length")
+		}
+		raw := make([]byte, n)
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return berElement{}, err
+		}
+		length = 0
+		for _, b := range raw {
+			length = length<<8 | int(b)
+		}
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return berElement{}, err
+	}
+
+	return berElement{head[0], content}, nil
+}
+
+// berChildren decodes the elements content is made of
+//
+func berChildren(content []byte) ([]berElement, error) {
+	var children []berElement
+	r := strings.NewReader(string(content))
+	for r.Len() > 0 {
+		child, err := berRead(r)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+
+	return children, nil
+}
+
+// ldapConn is a connection to an LDAP server
+//
+type ldapConn struct {
+	conn  net.Conn
+	r     *bufio.Reader
+	msgID int
+}
+
+// send sends op in a new message and returns the message ID
+//
+func (ldap *ldapConn) send(op []byte) (int, error) {
+	ldap.msgID++
+	_, err := ldap.conn.Write(berEncode(berSequence, berInt(berInteger, ldap.msgID), op))
+
+	return ldap.msgID, err
+}
+
+// receive returns the operation of the next message answering msgID
+//
+func (ldap *ldapConn) receive(msgID int) (berElement, error) {
+	for {
+		msg, err := berRead(ldap.r)
+		if err != nil {
+			return berElement{}, err
+		}
+		parts, err := berChildren(msg.content)
+		if err != nil {
+			return berElement{}, err
+		}
+		if len(parts) < 2 || parts[0].tag != berInteger {
+			return berElement{}, errors.New("Malformed LDAP message")
+		}
+
+		id := 0
+		for _, b := range parts[0].content {
+			id = id<<8 | int(b)
+		}
+		if id == msgID {
+			return parts[1], nil
+		}
+	}
+}
+
+// ldapResult checks the LDAPResult of the operation op
+//
+func ldapResult(op berElement) error {
+	parts, err := berChildren(op.content)
+	if err != nil {
+		return err
+	}
+	if len(parts) < 3 || parts[0].tag != berEnumerated || len(parts[0].content) == 0 {
+		return errors.New("Malformed LDAP result")
+	}
+
+	code := 0
+	for _, b := range parts[0].content {
+		code = code<<8 | int(b)
+	}
+	if code != 0 {
+		return fmt.Errorf("LDAP result %d: %s", code, parts[2].content)
+	}
+
+	return nil
+}
+
+func (ldap *ldapConn) bind(dn string, password []byte) error {
+	msgID, err := ldap.send(berEncode(ldapBindRequest,
+		berInt(berInteger, 3),
+		berEncode(berOctetString, []byte(dn)),
+		berEncode(ldapSimpleAuth, password),
+	))
+	if err != nil {
+		return err
+	}
+
+	op, err := ldap.receive(msgID)
+	if err != nil {
+		return err
+	}
+	if op.tag != ldapBindResponse {
+		return errors.New("Unexpected LDAP response")
+	}
+
+	return ldapResult(op)
+}
+
+// readAttribute returns the values of the attribute attr of the entry dn
+//
+func (ldap *ldapConn) readAttribute(dn, attr string) ([]string, error) {
+	msgID, err := ldap.send(berEncode(ldapSearchRequest,
+		berEncode(berOctetString, []byte(dn)),
+		berInt(berEnumerated, 0), // baseObject
+		berInt(berEnumerated, 0), // neverDerefAliases
+		berInt(berInteger, 0),
+		berInt(berInteger, 0),
+		berEncode(berBoolean, []byte{0}),
+		berEncode(ldapPresentFilter, []byte("objectClass")),
+		berEncode(berSequence, berEncode(berOctetString, []byte(attr))),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	for {
+		op, err := ldap.receive(msgID)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op.tag {
+		case ldapSearchResultDone:
+			return values, ldapResult(op)
+
+		case ldapSearchResultEntry:
+			parts, err := berChildren(op.content)
+			if err != nil || len(parts) < 2 {
+				return nil, errors.New("Malformed LDAP search result")
+			}
+			attrs, err := berChildren(parts[1].content)
+			if err != nil {
+				return nil, err
+			}
+			for _, a := range attrs {
+				fields, err := berChildren(a.content)
+				if err != nil || len(fields) < 2 {
+					return nil, errors.New("Malformed LDAP attribute")
+				}
+				if !strings.EqualFold(string(fields[0].content), attr) {
+					continue
+				}
+				vals, err := berChildren(fields[1].content)
+				if err != nil {
+					return nil, err
+				}
+				for _, v := range vals {
+					values = append(values, string(v.content))
+				}
+			}
+		}
+	}
+}
+
+func (ldap *ldapConn) unbind() {
+	ldap.send(berEncode(ldapUnbindRequest))
+}
@@ -0,0 +1,247 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"golang.org/x/net/context"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the buckets of the
+// latency histograms of the gRPC calls
+//
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// rpcMetrics are the measurements of the calls of a gRPC method
+//
+type rpcMetrics struct {
+	pending int64
+	count   uint64
+	sum     float64
+	buckets []uint64
+}
+
+// caMetrics are the measurements of the CAs of the process, exposed in the
+// Prometheus text format. They are counted by each replica.
+//
+type caMetrics struct {
+	mutex  sync.Mutex
+	issued map[string]uint64
+	calls  map[string]*rpcMetrics
+	cas    map[string]*CA
+}
+
+var metrics = &caMetrics{
+	issued: make(map[string]uint64),
+	calls:  make(map[string]*rpcMetrics),
+	cas:    make(map[string]*CA),
+}
+
+// observeRPC counts a call of method as pending until the returned function
+// is called, which records its latency. gRPC methods defer it first thing.
+//
+func observeRPC(method string) func() {
+	start := time.Now()
+
+	metrics.mutex.Lock()
+	call, ok := metrics.calls[method]
+	if !ok {
+		call = &rpcMetrics{buckets: make([]uint64, len(latencyBuckets))}
+		metrics.calls[method] = call
+	}
+	call.pending++
+	metrics.mutex.Unlock()
+
+	return func() {
+		elapsed := time.Since(start).Seconds()
+
+		metrics.mutex.Lock()
+		defer metrics.mutex.Unlock()
+
+		call.pending--
+		call.count++
+		call.sum += elapsed
+		for i, bound := range latencyBuckets {
+			if elapsed <= bound {
+				call.buckets[i]++
+			}
+		}
+	}
+}
+
+// pendingRPCs returns how many calls of methods are being served
+//
+func pendingRPCs(methods ...string) int64 {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	var pending int64
+	for _, method := range methods {
+		if call, ok := metrics.calls[method]; ok {
+			pending += call.pending
+		}
+	}
+
+	return pending
+}
+
+// countIssued counts a certificate issued by the CA name
+//
+func (m *caMetrics) countIssued(name string) {
+	m.mutex.Lock()
+	m.issued[name]++
+	m.mutex.Unlock()
+}
+
+// register adds the database pool of ca to the metrics, under its name
+//
+func (m *caMetrics) register(name string, ca *CA) {
+	m.mutex.Lock()
+	m.cas[name] = ca
+	m.mutex.Unlock()
+}
+
+// unregister removes the database pool of ca from the metrics, unless
+// another CA of the same name replaced it
+//
+func (m *caMetrics) unregister(name string, ca *CA) {
+	m.mutex.Lock()
+	if m.cas[name] == ca {
+		delete(m.cas, name)
+	}
+	m.mutex.Unlock()
+}
+
+// write writes the metrics in the Prometheus text format
+//
+func (m *caMetrics) write(w *bufio.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	fmt.Fprintln(w, "# HELP membersrvc_certificates_issued_total Certificates issued by each CA.")
+	fmt.Fprintln(w, "# TYPE membersrvc_certificates_issued_total counter")
+	issuers := make([]string, 0, len(m.issued))
+	for name := range m.issued {
+		issuers = append(issuers, name)
+	}
+	sort.Strings(issuers)
+	for _, name := range issuers {
+		fmt.Fprintf(w, "membersrvc_certificates_issued_total{ca=%q} %d\n", name, m.issued[name])
+	}
+
+	methods := make([]string, 0, len(m.calls))
+	for method := range m.calls {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	fmt.Fprintln(w, "# HELP membersrvc_rpc_pending gRPC calls being served.")
+	fmt.Fprintln(w, "# TYPE membersrvc_rpc_pending gauge")
+	for _, method := range methods {
+		fmt.Fprintf(w, "membersrvc_rpc_pending{method=%q} %d\n", method, m.calls[method].pending)
+	}
+
+	fmt.Fprintln(w, "# HELP membersrvc_rpc_duration_seconds Latency of the gRPC calls served.")
+	fmt.Fprintln(w, "# TYPE membersrvc_rpc_duration_seconds histogram")
+	for _, method := range methods {
+		call := m.calls[method]
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "membersrvc_rpc_duration_seconds_bucket{method=%q,le=\"%g\"} %d\n", method, bound, call.buckets[i])
+		}
+		fmt.Fprintf(w, "membersrvc_rpc_duration_seconds_bucket{method=%q,le=\"+Inf\"} %d\n", method, call.count)
+		fmt.Fprintf(w, "membersrvc_rpc_duration_seconds_sum{method=%q} %g\n", method, call.sum)
+		fmt.Fprintf(w, "membersrvc_rpc_duration_seconds_count{method=%q} %d\n", method, call.count)
+	}
+
+	names := make([]string, 0, len(m.cas))
+	for name := range m.cas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "# HELP membersrvc_db_open_connections Connections open to the database of each CA.")
+	fmt.Fprintln(w, "# TYPE membersrvc_db_open_connections gauge")
+	for _, name := range names {
+		fmt.Fprintf(w, "membersrvc_db_open_connections{ca=%q} %d\n", name, m.cas[name].db.Stats().OpenConnections)
+	}
+}
+
+// CheckHealth tells whether the CAs can serve requests, that is whether
+// their databases can be reached. Peers probe it before enrolling.
+//
+func (ecap *ECAP) CheckHealth(ctx context.Context, in *pb.Empty) (*pb.CAHealth, error) {
+	Trace.Println("gRPC ECAP:CheckHealth")
+	defer observeRPC("ECAP.CheckHealth")()
+
+	return ecap.eca.checkHealth(), nil
+}
+
+// checkHealth pings the databases of the CAs of the process
+//
+func (eca *ECA) checkHealth() *pb.CAHealth {
+	metrics.mutex.Lock()
+	cas := make(map[string]*CA, len(metrics.cas))
+	for name, ca := range metrics.cas {
+		cas[name] = ca
+	}
+	metrics.mutex.Unlock()
+	// the ECA is checked even if another ECA of the process replaced it
+	cas["eca"] = eca.CA
+
+	for _, name := range []string{"eca", "tca", "tlsca"} {
+		ca, ok := cas[name]
+		if !ok {
+			continue
+		}
+		if err := ca.db.Ping(); err != nil {
+			Warning.Println("The database of the "+name+" cannot be reached:", err)
+			return &pb.CAHealth{Status: pb.CAHealth_NOT_SERVING, Reason: "The database of the " + name + " cannot be reached."}
+		}
+	}
+
+	return &pb.CAHealth{Status: pb.CAHealth_SERVING}
+}
+
+// NewMetricsHandler returns the HTTP handler serving the metrics of the CAs
+// at /metrics, in the Prometheus text format, and their health at /healthz
+//
+func NewMetricsHandler(eca *ECA) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		buf := bufio.NewWriter(w)
+		metrics.write(buf)
+		buf.Flush()
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		health := eca.checkHealth()
+		if health.Status != pb.CAHealth_SERVING {
+			http.Error(w, health.Reason, http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	return mux
+}
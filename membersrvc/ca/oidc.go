@@ -0,0 +1,561 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// oidcKeysRefresh is how often at most the keys of the issuer are fetched
+// again when a token is signed with a key not known yet
+//
+const oidcKeysRefresh = time.Minute
+
+// oidcSkew is the clock skew tolerated when checking the validity of tokens
+//
+const oidcSkew = time.Minute
+
+// oidcVerifier checks the ID tokens of an OpenID Connect issuer, users can
+// enroll with instead of a token of the ECA
+//
+type oidcVerifier struct {
+	issuer      string
+	clientID    string
+	idClaim     string
+	groupsClaim string
+	jwksURL     string
+
+	// groups maps the groups of the tokens to what their members are
+	// registered as, attributes the TCert attributes to the claims
+	// they are read from
+	groups     map[string]directoryGroup
+	attributes map[string]string
+
+	client *http.Client
+
+	mutex   sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// newOIDCVerifier returns the verifier of the ID tokens of the issuer
+// configured under eca.oidc, nil if none is
+//
+func newOIDCVerifier() (*oidcVerifier, error) {
+	issuer := GetConfigString("eca.oidc.issuer")
+	if issuer == "" {
+		return nil, nil
+	}
+
+	v := &oidcVerifier{
+		issuer:      issuer,
+		clientID:    GetConfigString("eca.oidc.clientid"),
+		idClaim:     "sub",
+		groupsClaim: "groups",
+		jwksURL:     GetConfigString("eca.oidc.jwksurl"),
+		attributes:  viper.GetStringMapString("eca.oidc.attributes"),
+		client:      &http.Client{Timeout: 5 * time.Second},
+	}
+	if v.clientID == "" {
+		return nil, errors.New("The client ID of the OIDC issuer is missing")
+	}
+	if claim := GetConfigString("eca.oidc.idclaim"); claim != "" {
+		v.idClaim = claim
+	}
+	if claim := GetConfigString("eca.oidc.groupsclaim"); claim != "" {
+		v.groupsClaim = claim
+	}
+	if timeout := GetConfigString("eca.oidc.timeout"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, err
+		}
+		v.client.Timeout = d
+	}
+
+	var err error
+	if v.groups, err = readDirectoryGroups("eca.oidc.groups"); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// isIDToken tells whether tok is a JWT rather than a token of the ECA
+//
+func isIDToken(tok []byte) bool {
+	return bytes.Count(tok, []byte(".")) == 2 && bytes.HasPrefix(tok, []byte("eyJ"))
+}
+
+// verify checks the signature and the validity of the ID token tok and
+// returns its claims
+//
+func (v *oidcVerifier) verify(tok string) (map[string]interface{}, error) {
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Malformed ID token.")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWS(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := v.checkClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// checkClaims checks that claims were issued by the issuer, to the client
+// of the ECA, and are valid at now
+//
+func (v *oidcVerifier) checkClaims(claims map[string]interface{}, now time.Time) error {
+	if iss, _ := claims["iss"].(string); iss != v.issuer {
+		return errors.New("ID token issued by " + iss + ".")
+	}
+
+	var audiences []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audiences = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+	found := false
+	for _, aud := range audiences {
+		found = found || aud == v.clientID
+	}
+	if !found {
+		return errors.New("ID token not issued to " + v.clientID + ".")
+	}
+	if azp, ok := claims["azp"].(string); ok && len(audiences) > 1 && azp != v.clientID {
+		return errors.New("ID token authorized for " + azp + ".")
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.Add(-oidcSkew).After(time.Unix(int64(exp), 0)) {
+		return errors.New("ID token expired.")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(oidcSkew).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("ID token not valid yet.")
+	}
+	if iat, ok := claims["iat"].(float64); ok && now.Add(oidcSkew).Before(time.Unix(int64(iat), 0)) {
+		return errors.New("ID token issued in the future.")
+	}
+
+	return nil
+}
+
+// key returns the key of the issuer identified by kid, fetching the keys
+// of the issuer again if it is not known yet
+//
+func (v *oidcVerifier) key(kid string) (crypto.PublicKey, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(v.fetched) < oidcKeysRefresh {
+		return nil, errors.New("Unknown key " + kid + " of the OIDC issuer.")
+	}
+
+	keys, err := v.fetchKeys()
+	if err != nil {
+		return nil, err
+	}
+	v.keys, v.fetched = keys, time.Now()
+
+	key, ok := v.keys[kid]
+	if !ok {
+		return nil, errors.New("Unknown key " + kid + " of the OIDC issuer.")
+	}
+
+	return key, nil
+}
+
+// fetchKeys reads the keys the issuer publishes, at the JWKS URL its
+// discovery document gives unless one is configured
+//
+func (v *oidcVerifier) fetchKeys() (map[string]crypto.PublicKey, error) {
+	jwksURL := v.jwksURL
+	if jwksURL == "" {
+		var discovery struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := v.getJSON(strings.TrimSuffix(v.issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, err
+		}
+		if discovery.Issuer != v.issuer || discovery.JWKSURI == "" {
+			return nil, errors.New("Invalid discovery document of the OIDC issuer " + v.issuer)
+		}
+		jwksURL = discovery.JWKSURI
+	}
+
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.getJSON(jwksURL, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			Warning.Println("Ignoring key "+k.Kid+" of the OIDC issuer:", err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (v *oidcVerifier) getJSON(url string, out interface{}) error {
+	resp, err := v.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s answered %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// jwk is a public key of a JSON Web Key Set
+//
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, errors.New("Unsupported curve " + k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("Invalid EC key")
+		}
+		return pub, nil
+	}
+
+	return nil, errors.New("Unsupported key type " + k.Kty)
+}
+
+// verifyJWS checks the signature sig of signed with key under the JWS
+// algorithm alg. Only asymmetric algorithms are accepted.
+//
+func verifyJWS(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512":
+		hash = crypto.SHA512
+	default:
+		return errors.New("Unsupported ID token algorithm " + alg)
+	}
+
+	var digest []byte
+	switch hash {
+	case crypto.SHA256:
+		sum := sha256.Sum256(signed)
+		digest = sum[:]
+	case crypto.SHA384:
+		sum := sha512.Sum384(signed)
+		digest = sum[:]
+	default:
+		sum := sha512.Sum512(signed)
+		digest = sum[:]
+	}
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] != 'R' {
+			return errors.New("Key and algorithm " + alg + " of the ID token do not match.")
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if alg[0] != 'E' || pub.Curve.Params().BitSize != hash.Size()*8 {
+			return errors.New("Key and algorithm " + alg + " of the ID token do not match.")
+		}
+		if len(sig) != 2*size {
+			return errors.New("Invalid ID token signature.")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("Invalid ID token signature.")
+		}
+		return nil
+	}
+
+	return errors.New("Unsupported ID token key.")
+}
+
+func decodeJWTPart(part string, out interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, out)
+}
+
+// claimString returns the value of a claim as a string, the values of
+// array claims separated by commas
+//
+func claimString(claim interface{}) string {
+	switch c := claim.(type) {
+	case nil:
+		return ""
+	case string:
+		return c
+	case []interface{}:
+		values := make([]string, len(c))
+		for i, v := range c {
+			values[i] = claimString(v)
+		}
+		return strings.Join(values, ",")
+	}
+
+	return fmt.Sprint(claim)
+}
+
+// claimStrings returns the values of a claim that is a string or an array
+//
+func claimStrings(claim interface{}) []string {
+	switch c := claim.(type) {
+	case string:
+		return []string{c}
+	case []interface{}:
+		values := make([]string, len(c))
+		for i, v := range c {
+			values[i] = claimString(v)
+		}
+		return values
+	}
+
+	return nil
+}
+
+// authenticateIDToken authenticates the user id with the ID token tok of
+// the OIDC issuer, registering the user first if it is not listed and
+// the token says it is a member of a mapped group. Users registered
+// otherwise cannot authenticate with ID tokens. The attributes mapped to
+// the claims of the token are kept for the TCA.
+//
+func (eca *ECA) authenticateIDToken(id string, tok []byte) (int, int, []byte, string, error) {
+	claims, err := eca.oidc.verify(string(tok))
+	if err != nil {
+		Warning.Println("Invalid ID token presented for "+id+":", err)
+		return 0, 0, nil, "", errors.New("Identity or token does not match.")
+	}
+	if claimString(claims[eca.oidc.idClaim]) != id {
+		return 0, 0, nil, "", errors.New("Identity or token does not match.")
+	}
+
+	var issuer string
+	err = eca.db.QueryRow("SELECT issuer FROM Users WHERE id=?", id).Scan(&issuer)
+	if err == sql.ErrNoRows {
+		err = eca.registerTokenUser(id, claims)
+		issuer = eca.oidc.issuer
+	}
+	if err != nil {
+		return 0, 0, nil, "", err
+	}
+	if issuer != eca.oidc.issuer {
+		return 0, 0, nil, "", errors.New("User " + id + " cannot enroll with an ID token.")
+	}
+
+	if err := eca.storeTokenAttributes(id, claims); err != nil {
+		Error.Println(err)
+		return 0, 0, nil, "", err
+	}
+
+	var role, state int
+	var key []byte
+	var userTok []byte
+	var enrollID string
+	if err := eca.readUser(id).Scan(&role, &userTok, &state, &key, &enrollID); err != nil {
+		return 0, 0, nil, "", err
+	}
+
+	return role, state, key, enrollID, nil
+}
+
+// registerTokenUser registers the user id as the first of the groups of
+// claims that is mapped says. Users enrolling with ID tokens are
+// authenticated anew each time, so they can enroll as often as they like.
+//
+func (eca *ECA) registerTokenUser(id string, claims map[string]interface{}) error {
+	for _, name := range claimStrings(claims[eca.oidc.groupsClaim]) {
+		group, ok := eca.oidc.groups[groupName(name)]
+		if !ok {
+			continue
+		}
+
+		Info.Println("Registering OIDC user " + id + " as a member of " + name + ".")
+		if _, err := eca.registerUser(id, group.affiliation, group.affiliationRole, group.role); err != nil {
+			return err
+		}
+		if _, err := eca.db.Exec("UPDATE Users SET issuer=?, maxEnrollments=? WHERE id=?", eca.oidc.issuer, -1, id); err != nil {
+			return err
+		}
+		eca.auditLog.append(id, auditRegister, id, registrationDetails(group.role, group.affiliation)+" issuer="+eca.oidc.issuer+" group="+name)
+		return nil
+	}
+
+	return errors.New("User " + id + " is not a member of any OIDC group mapped to a role.")
+}
+
+// storeTokenAttributes replaces the attributes of the user id by those
+// mapped to claims
+//
+func (eca *ECA) storeTokenAttributes(id string, claims map[string]interface{}) error {
+	tx, err := eca.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM TokenAttributes WHERE id=?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for name, claim := range eca.oidc.attributes {
+		value, ok := claims[claim]
+		if !ok {
+			continue
+		}
+		if _, err := tx.Exec("INSERT INTO TokenAttributes (id, name, value) VALUES (?, ?, ?)", id, name, claimString(value)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// tokenAttributeProvider reads the attributes taken from the claims of the
+// ID tokens users last enrolled with
+//
+type tokenAttributeProvider struct {
+	db *caDB
+}
+
+func (provider *tokenAttributeProvider) Attributes(id, affiliation string, names []string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, name := range names {
+		var value string
+		err := provider.db.QueryRow("SELECT value FROM TokenAttributes WHERE id=? AND name=?", id, name).Scan(&value)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[name] = value
+	}
+
+	return values, nil
+}
@@ -0,0 +1,168 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// defaultTCertValidity is how long TCerts are valid unless a policy says otherwise
+//
+const defaultTCertValidity = 90 * 24 * time.Hour
+
+// tcertPolicy restricts the TCerts issued to the users it applies to.
+// Zero fields leave the restriction to the policies of lower precedence.
+//
+type tcertPolicy struct {
+	validity time.Duration
+	maxBatch int
+
+	// attributes are the attributes allowed in TCerts, nil if any is
+	attributes map[string]bool
+}
+
+// tcertPolicies are the TCert policies read from tca.policies
+//
+type tcertPolicies struct {
+	def          *tcertPolicy
+	roles        map[pb.Role]*tcertPolicy
+	affiliations map[string]*tcertPolicy
+}
+
+// readTCertPolicies reads the default policy and the policies by role and
+// by affiliation under tca.policies
+//
+func readTCertPolicies() (*tcertPolicies, error) {
+	settings := cast.ToStringMap(viper.Get("tca.policies"))
+
+	policies := &tcertPolicies{roles: make(map[pb.Role]*tcertPolicy), affiliations: make(map[string]*tcertPolicy)}
+
+	var err error
+	if policies.def, err = parseTCertPolicy("default", settings["default"]); err != nil {
+		return nil, err
+	}
+	for name, value := range cast.ToStringMap(settings["roles"]) {
+		role, ok := pb.Role_value[strings.ToUpper(name)]
+		if !ok {
+			return nil, errors.New("Unknown role " + name + " in TCert policies")
+		}
+		if policies.roles[pb.Role(role)], err = parseTCertPolicy(name, value); err != nil {
+			return nil, err
+		}
+	}
+	for name, value := range cast.ToStringMap(settings["affiliations"]) {
+		if policies.affiliations[name], err = parseTCertPolicy(name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return policies, nil
+}
+
+// parseTCertPolicy parses the settings of the policy name
+//
+func parseTCertPolicy(name string, value interface{}) (*tcertPolicy, error) {
+	settings := cast.ToStringMap(value)
+	policy := new(tcertPolicy)
+
+	if validity := cast.ToString(settings["validity"]); validity != "" {
+		d, err := time.ParseDuration(validity)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, errors.New("Invalid TCert validity in policy " + name)
+		}
+		policy.validity = d
+	}
+	if maxBatch, ok := settings["maxbatch"]; ok {
+		policy.maxBatch = cast.ToInt(maxBatch)
+		if policy.maxBatch <= 0 {
+			return nil, errors.New("Invalid maximum TCert batch size in policy " + name)
+		}
+	}
+	if attributes, ok := settings["attributes"]; ok {
+		policy.attributes = make(map[string]bool)
+		for _, attr := range strings.Fields(cast.ToString(attributes)) {
+			policy.attributes[attr] = true
+		}
+	}
+
+	return policy, nil
+}
+
+// policyFor returns the policy of the users of role that are members of
+// affiliation. Each restriction is taken from the policy of the
+// affiliation, else of the role, else from the default policy.
+//
+func (policies *tcertPolicies) policyFor(affiliation string, role pb.Role) *tcertPolicy {
+	policy := &tcertPolicy{validity: defaultTCertValidity}
+
+	// the keys of maps read by viper are lower case
+	for _, p := range []*tcertPolicy{policies.def, policies.roles[role], policies.affiliations[strings.ToLower(affiliation)]} {
+		if p == nil {
+			continue
+		}
+		if p.validity != 0 {
+			policy.validity = p.validity
+		}
+		if p.maxBatch != 0 {
+			policy.maxBatch = p.maxBatch
+		}
+		if p.attributes != nil {
+			policy.attributes = p.attributes
+		}
+	}
+
+	return policy
+}
+
+// check returns an error if the policy does not allow num TCerts
+// with attributes
+//
+func (policy *tcertPolicy) check(num int, attributes []*pb.TCertAttribute) error {
+	if policy.maxBatch != 0 && num > policy.maxBatch {
+		return errors.New("At most " + strconv.Itoa(policy.maxBatch) + " TCerts can be requested at once.")
+	}
+	if policy.attributes != nil {
+		for _, a := range attributes {
+			if !policy.attributes[a.AttributeName] {
+				return errors.New("Attribute " + a.AttributeName + " is not allowed in TCerts.")
+			}
+		}
+	}
+
+	return nil
+}
+
+// readTCertPolicy returns the policy of the user of enrollmentID
+//
+func (tca *TCA) readTCertPolicy(enrollmentID string) (*tcertPolicy, error) {
+	id, _, affiliation, err := tca.eca.parseEnrollID(enrollmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	return tca.policies.policyFor(affiliation, pb.Role(tca.eca.readRole(id))), nil
+}
@@ -0,0 +1,138 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// rateLimiter limits the requests of each identity with a token bucket:
+// up to burst requests at once, refilled at rate requests per second.
+// The buckets are kept in memory, so that each replica limits the
+// requests it serves.
+//
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mutex   sync.Mutex
+	buckets map[string]*rateBucket
+	swept   time.Time
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// readRateLimiter reads the rate limits under prefix: rate requests per
+// interval (a second by default) with bursts of up to burst requests (rate
+// by default). No limiter, which allows any request, is returned if the
+// rate is not set.
+//
+func readRateLimiter(prefix string) (*rateLimiter, error) {
+	rate := GetConfigInt(prefix + ".rate")
+	if rate == 0 {
+		return nil, nil
+	}
+	if rate < 0 {
+		return nil, errors.New("Invalid rate limit " + prefix + ".rate")
+	}
+
+	interval := time.Second
+	if s := GetConfigString(prefix + ".interval"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, errors.New("Invalid rate limit " + prefix + ".interval")
+		}
+		interval = d
+	}
+
+	burst := rate
+	if viper.IsSet(prefix + ".burst") {
+		if burst = GetConfigInt(prefix + ".burst"); burst <= 0 {
+			return nil, errors.New("Invalid rate limit " + prefix + ".burst")
+		}
+	}
+
+	return &rateLimiter{
+		rate:    float64(rate) / interval.Seconds(),
+		burst:   float64(burst),
+		buckets: make(map[string]*rateBucket),
+	}, nil
+}
+
+// allow counts a request of id against its limit. A resource exhausted
+// error telling when to retry is returned if id made too many requests.
+//
+func (limiter *rateLimiter) allow(id string) error {
+	if limiter == nil {
+		return nil
+	}
+
+	now := time.Now()
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limiter.sweep(now)
+
+	bucket, ok := limiter.buckets[id]
+	if !ok {
+		bucket = &rateBucket{limiter.burst, now}
+		limiter.buckets[id] = bucket
+	}
+	bucket.tokens = math.Min(limiter.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*limiter.rate)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / limiter.rate * float64(time.Second))
+		Warning.Println("Rate limit of " + id + " exceeded.")
+
+		return grpc.Errorf(codes.ResourceExhausted, "Too many requests, retry in %s.", wait)
+	}
+	bucket.tokens--
+
+	return nil
+}
+
+// sweep drops the buckets that are full again, which is no different from
+// having none. It runs at most once per time a bucket takes to fill up.
+//
+func (limiter *rateLimiter) sweep(now time.Time) {
+	full := time.Duration(limiter.burst / limiter.rate * float64(time.Second))
+	if now.Sub(limiter.swept) < full {
+		return
+	}
+
+	for id, bucket := range limiter.buckets {
+		if now.Sub(bucket.last) >= full {
+			delete(limiter.buckets, id)
+		}
+	}
+	limiter.swept = now
+}
@@ -0,0 +1,162 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// maxRESTRequestSize is the largest request body the REST gateway reads,
+// the default largest message of gRPC
+//
+const maxRESTRequestSize = 4 << 20
+
+// restRoute is a call of the gRPC services of the CAs the REST gateway
+// serves at some path
+//
+type restRoute struct {
+	method string
+	newReq func() proto.Message
+	call   func(ctx context.Context, in proto.Message) (proto.Message, error)
+}
+
+// NewRESTHandler returns the HTTP handler of the REST gateway to the ECA
+// and the TCA, for clients without a gRPC stack. Requests and responses
+// are the JSON encodings of the messages of the gRPC services, bytes
+// being base64 encoded. Signatures are computed over the protobuf encoding
+// of the requests, as with gRPC; enrolling from certificate requests needs
+// none. Users are registered by admins only, in bulk, as the requests must
+// be signed. Errors are answered with a JSON object of a code and a message.
+//
+func NewRESTHandler(eca *ECA, tca *TCA) http.Handler {
+	ecap, ecaa := &ECAP{eca}, &ECAA{eca}
+	tcap := &TCAP{tca}
+
+	routes := map[string]restRoute{
+		"/v1/eca/cert": {"GET", func() proto.Message { return &pb.Empty{} }, func(ctx context.Context, in proto.Message) (proto.Message, error) {
+			return ecap.ReadCACertificate(ctx, in.(*pb.Empty))
+		}},
+		"/v1/tca/cert": {"GET", func() proto.Message { return &pb.Empty{} }, func(ctx context.Context, in proto.Message) (proto.Message, error) {
+			return tcap.ReadCACertificate(ctx, in.(*pb.Empty))
+		}},
+		"/v1/registrations/bulk": {"POST", func() proto.Message { return &pb.RegisterUserSetReq{} }, func(ctx context.Context, in proto.Message) (proto.Message, error) {
+			return ecaa.RegisterUserSet(ctx, in.(*pb.RegisterUserSetReq))
+		}},
+		"/v1/enrollments": {"POST", func() proto.Message { return &pb.ECertCreateReq{} }, func(ctx context.Context, in proto.Message) (proto.Message, error) {
+			return ecap.CreateCertificatePair(ctx, in.(*pb.ECertCreateReq))
+		}},
+		"/v1/enrollments/csr": {"POST", func() proto.Message { return &pb.ECertCSRReq{} }, func(ctx context.Context, in proto.Message) (proto.Message, error) {
+			return ecap.CreateCertificatePairFromCSR(ctx, in.(*pb.ECertCSRReq))
+		}},
+		"/v1/enrollments/rotate": {"POST", func() proto.Message { return &pb.ECertRotateReq{} }, func(ctx context.Context, in proto.Message) (proto.Message, error) {
+			return ecap.RotateCertificatePair(ctx, in.(*pb.ECertRotateReq))
+		}},
+		"/v1/ecerts/revoke": {"POST", func() proto.Message { return &pb.ECertRevokeReq{} }, func(ctx context.Context, in proto.Message) (proto.Message, error) {
+			return ecap.RevokeCertificatePair(ctx, in.(*pb.ECertRevokeReq))
+		}},
+		"/v1/tcerts": {"POST", func() proto.Message { return &pb.TCertCreateSetReq{} }, func(ctx context.Context, in proto.Message) (proto.Message, error) {
+			return tcap.CreateCertificateSet(ctx, in.(*pb.TCertCreateSetReq))
+		}},
+		"/v1/tcerts/revoke": {"POST", func() proto.Message { return &pb.TCertRevokeReq{} }, func(ctx context.Context, in proto.Message) (proto.Message, error) {
+			return tcap.RevokeCertificate(ctx, in.(*pb.TCertRevokeReq))
+		}},
+		"/v1/tcerts/revoke/set": {"POST", func() proto.Message { return &pb.TCertRevokeSetReq{} }, func(ctx context.Context, in proto.Message) (proto.Message, error) {
+			return tcap.RevokeCertificateSet(ctx, in.(*pb.TCertRevokeSetReq))
+		}},
+	}
+
+	mux := http.NewServeMux()
+	for path, route := range routes {
+		mux.Handle(path, route)
+	}
+
+	return mux
+}
+
+func (route restRoute) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	Trace.Println("HTTP " + r.Method + " " + r.URL.Path)
+
+	if r.Method != route.method {
+		w.Header().Set("Allow", route.method)
+		writeRESTError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+		return
+	}
+
+	in := route.newReq()
+	if r.Method == "POST" {
+		if err := jsonpb.Unmarshal(http.MaxBytesReader(w, r.Body, maxRESTRequestSize), in); err != nil {
+			writeRESTError(w, http.StatusBadRequest, "Invalid request: "+err.Error())
+			return
+		}
+	}
+
+	out, err := route.call(context.Background(), in)
+	if err != nil {
+		writeRESTError(w, restStatus(grpc.Code(err)), grpc.ErrorDesc(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	m := jsonpb.Marshaler{}
+	if err := m.Marshal(w, out); err != nil {
+		Error.Println(err)
+	}
+}
+
+// restStatus returns the HTTP status answering a gRPC call failing with
+// code. The CAs fail most invalid requests with plain errors.
+//
+func restStatus(code codes.Code) int {
+	switch code {
+	case codes.InvalidArgument, codes.Unknown:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeRESTError answers a request with status and a JSON object of the
+// status and message
+//
+func writeRESTError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}{status, message})
+}
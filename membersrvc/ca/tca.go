@@ -24,17 +24,20 @@ import (
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"io/ioutil"
 	"math"
 	"math/big"
 	"strconv"
+	"sync"
+	"time"
 
 	protobuf "google/protobuf"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
-	"github.com/hyperledger/fabric/core/util"
+	"github.com/hyperledger/fabric/core/crypto/primitives/secp256k1"
 	pb "github.com/hyperledger/fabric/membersrvc/protos"
 	"github.com/spf13/viper"
 	"golang.org/x/net/context"
@@ -51,6 +54,14 @@ var (
 	// TCertAttributesHeaders is the ASN1 object identifier of attributes header.
 	TCertAttributesHeaders = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 9}
 
+	// TCertAttributesKeyID is the ASN1 object identifier of the id of the
+	// attribute master key the TCert extensions are encrypted under.
+	TCertAttributesKeyID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 7, 1}
+
+	// TCertEncryptionKey is the ASN1 object identifier of the encryption
+	// public key of dual-key TCerts.
+	TCertEncryptionKey = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 7, 2}
+
 	// Padding for encryption.
 	Padding = []byte{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255}
 )
@@ -61,7 +72,35 @@ type TCA struct {
 	eca        *ECA
 	hmacKey    []byte
 	rootPreKey []byte
-	preKeys    map[string][]byte
+
+	// attrKeys holds, by id, the attribute master keys in use along with
+	// the affiliation keys derived from them. New TCerts are issued under
+	// the master key attrKeyID.
+	attrKeysMutex    sync.RWMutex
+	attrKeys         map[uint32]*attributeKey
+	attrKeyID        uint32
+	attrKeyRotation  time.Duration
+	attrKeyRetention time.Duration
+
+	// attrSources are asked, in order, for the values of the attributes
+	// embedded in TCerts
+	attrSources []*attributeSource
+
+	// policies restrict the TCerts issued by affiliation and by role
+	policies *tcertPolicies
+
+	// limiter limits the TCert batches requested by each user
+	limiter *rateLimiter
+
+	// inventory holds TCerts issued ahead for high-volume clients, if any
+	inventory *tcertInventory
+}
+
+// attributeKey is a master key of the attribute key hierarchy
+type attributeKey struct {
+	preKeys map[string][]byte
+	created time.Time
+	retired time.Time // zero while the key is current
 }
 
 // TCAP serves the public GRPC interface of the TCA.
@@ -74,9 +113,17 @@ type TCAA struct {
 	tca *TCA
 }
 
+// tcaMigrations create and update the tables of the TCA
+//
+var tcaMigrations = []string{
+	"CREATE TABLE IF NOT EXISTS AttributeKeys (row INTEGER PRIMARY KEY, id INTEGER UNIQUE, key BLOB, created INTEGER, retired INTEGER)",
+	"CREATE TABLE IF NOT EXISTS TCertInventory (row INTEGER PRIMARY KEY, id VARCHAR(64), ecert VARCHAR(64), keyid INTEGER, cert BLOB, serial VARCHAR(64), kdfkey BLOB, enrollmentkey BLOB, created INTEGER, expires INTEGER)",
+}
+
 // NewTCA sets up a new TCA.
 func NewTCA(eca *ECA) *TCA {
-	tca := &TCA{NewCA("tca"), eca, nil, nil, nil}
+	tca := &TCA{CA: NewCA("tca"), eca: eca}
+	eca.tca = tca
 
 	err := tca.readHmacKey()
 	if err != nil {
@@ -88,13 +135,60 @@ func NewTCA(eca *ECA) *TCA {
 		Panic.Panicln(err)
 	}
 
+	// The root pre-key is the master key TCerts were issued under before
+	// the first rotation
+	if err := tca.db.migrate("tca", tcaMigrations); err != nil {
+		Panic.Panicln(err)
+	}
+	if _, err := tca.db.Exec("INSERT OR IGNORE INTO AttributeKeys (id, key, created, retired) VALUES (0, ?, ?, 0)", tca.rootPreKey, time.Now().Unix()); err != nil {
+		Panic.Panicln(err)
+	}
+
+	tca.attrKeyRotation = 0
+	if rotation := GetConfigString("tca.attribute-encryption.rotation"); rotation != "" {
+		tca.attrKeyRotation, err = time.ParseDuration(rotation)
+		if err != nil {
+			Panic.Panicln(err)
+		}
+	}
+	tca.attrKeyRetention = 24 * time.Hour
+	if retention := GetConfigString("tca.attribute-encryption.retention"); retention != "" {
+		tca.attrKeyRetention, err = time.ParseDuration(retention)
+		if err != nil {
+			Panic.Panicln(err)
+		}
+	}
+
 	err = tca.initializePreKeyTree()
 	if err != nil {
 		Panic.Panicln(err)
 	}
+
+	if tca.attrSources, err = readAttributeSources(tca.eca); err != nil {
+		Panic.Panicln(err)
+	}
+	if tca.policies, err = readTCertPolicies(); err != nil {
+		Panic.Panicln(err)
+	}
+	if tca.limiter, err = readRateLimiter("tca.ratelimit"); err != nil {
+		Panic.Panicln(err)
+	}
+	if tca.inventory, err = readTCertInventory(tca); err != nil {
+		Panic.Panicln(err)
+	}
 	return tca
 }
 
+// Close stops the TCert inventory and closes the attribute sources and the
+// database of the TCA.
+func (tca *TCA) Close() {
+	if tca.inventory != nil {
+		tca.inventory.stop()
+	}
+	tca.closeAttributeSources()
+	tca.CA.Close()
+}
+
 // Read the hcmac key from the file system.
 func (tca *TCA) readHmacKey() error {
 	var cooked string
@@ -146,62 +240,235 @@ func (tca *TCA) calculatePreKey(variant []byte, preKey []byte) ([]byte, error) {
 	return mac.Sum(nil), nil
 }
 
-func (tca *TCA) initializePreKeyNonRootGroup(group *AffiliationGroup) error {
+func (tca *TCA) initializePreKeyNonRootGroup(group *AffiliationGroup, rootPreKey []byte) error {
 	if group.parent.preKey == nil {
 		//Initialize parent if it is not initialized yet.
-		tca.initializePreKeyGroup(group.parent)
+		tca.initializePreKeyGroup(group.parent, rootPreKey)
 	}
 	var err error
 	group.preKey, err = tca.calculatePreKey([]byte(group.name), group.parent.preKey)
 	return err
 }
 
-func (tca *TCA) initializePreKeyGroup(group *AffiliationGroup) error {
+func (tca *TCA) initializePreKeyGroup(group *AffiliationGroup, rootPreKey []byte) error {
 	if group.parentID == 0 {
 		// This group is root ("top level")
-		group.preKey = tca.rootPreKey
+		group.preKey = rootPreKey
 		return nil
 	}
-	return tca.initializePreKeyNonRootGroup(group)
+	return tca.initializePreKeyNonRootGroup(group, rootPreKey)
 }
 
-func (tca *TCA) initializePreKeyTree() error {
-	Trace.Println("Initializing Pre-Keys")
+// calculatePreKeys derives the pre-key of every affiliation group from the
+// master key rootPreKey
+func (tca *TCA) calculatePreKeys(rootPreKey []byte) (map[string][]byte, error) {
 	groups, err := tca.eca.readAffiliationGroups()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	tca.preKeys = make(map[string][]byte)
+	preKeys := make(map[string][]byte)
 	for _, group := range groups {
 		if group.preKey == nil {
-			err = tca.initializePreKeyGroup(group)
+			err = tca.initializePreKeyGroup(group, rootPreKey)
 			if err != nil {
-				return err
+				return nil, err
 			}
 		}
 		Trace.Println("Initializing Pre-Key for group '", group.name, "'")
-		tca.preKeys[group.name] = group.preKey
+		preKeys[group.name] = group.preKey
+	}
+
+	return preKeys, nil
+}
+
+// loadAttributeKeys reads the master keys which are current or retired less
+// than attrKeyRetention ago. The caller holds attrKeysMutex.
+func (tca *TCA) loadAttributeKeys() error {
+	rows, err := tca.db.Query("SELECT id, key, created, retired FROM AttributeKeys WHERE retired=0 OR retired>?", time.Now().Add(-tca.attrKeyRetention).Unix())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	attrKeys := make(map[uint32]*attributeKey)
+	var current uint32
+	for rows.Next() {
+		var id uint32
+		var key []byte
+		var created, retired int64
+		if err := rows.Scan(&id, &key, &created, &retired); err != nil {
+			return err
+		}
+
+		preKeys, err := tca.calculatePreKeys(key)
+		if err != nil {
+			return err
+		}
+		attrKey := &attributeKey{preKeys: preKeys, created: time.Unix(created, 0)}
+		if retired != 0 {
+			attrKey.retired = time.Unix(retired, 0)
+		} else {
+			current = id
+		}
+		attrKeys[id] = attrKey
+	}
+	if err := rows.Err(); err != nil {
+		return err
 	}
 
+	tca.attrKeys = attrKeys
+	tca.attrKeyID = current
+
 	return nil
 }
 
-func (tca *TCA) getPreKFrom(enrollmentCertificate *x509.Certificate) ([]byte, error) {
+func (tca *TCA) initializePreKeyTree() error {
+	Trace.Println("Initializing Pre-Keys")
+
+	tca.attrKeysMutex.Lock()
+	defer tca.attrKeysMutex.Unlock()
+
+	return tca.loadAttributeKeys()
+}
+
+// rotateAttributeKey retires the current attribute master key in favour of a
+// fresh one. Unless force is set, the key is rotated only once it is older
+// than attrKeyRotation.
+func (tca *TCA) rotateAttributeKey(force bool) error {
+	if err := tca.refreshAttributeKeys(); err != nil {
+		return err
+	}
+
+	if !force {
+		tca.attrKeysMutex.RLock()
+		due := tca.attributeKeyDue()
+		tca.attrKeysMutex.RUnlock()
+		if !due {
+			return nil
+		}
+	}
+
+	tca.attrKeysMutex.Lock()
+	defer tca.attrKeysMutex.Unlock()
+
+	// Another request may have rotated the key meanwhile
+	if !force && !tca.attributeKeyDue() {
+		return nil
+	}
+	Info.Println("Rotating attribute master key", tca.attrKeyID)
+
+	key := make([]byte, 49)
+	if _, err := rand.Reader.Read(key); err != nil {
+		return err
+	}
+
+	tx, err := tca.db.Begin()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if _, err := tx.Exec("UPDATE AttributeKeys SET retired=? WHERE retired=0", now.Unix()); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO AttributeKeys (id, key, created, retired) VALUES (?, ?, ?, 0)", tca.attrKeyID+1, key, now.Unix()); err != nil {
+		tx.Rollback()
+
+		// Another replica rotated the key first, the ids being unique
+		previous := tca.attrKeyID
+		if tca.loadAttributeKeys() == nil && tca.attrKeyID > previous {
+			return nil
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return tca.loadAttributeKeys()
+}
+
+// refreshAttributeKeys reloads the master keys if another replica
+// sharing the database rotated them.
+func (tca *TCA) refreshAttributeKeys() error {
+	var current uint32
+	if err := tca.db.QueryRow("SELECT MAX(id) FROM AttributeKeys").Scan(&current); err != nil {
+		return err
+	}
+
+	tca.attrKeysMutex.RLock()
+	stale := current != tca.attrKeyID
+	tca.attrKeysMutex.RUnlock()
+	if !stale {
+		return nil
+	}
+
+	tca.attrKeysMutex.Lock()
+	defer tca.attrKeysMutex.Unlock()
+
+	return tca.loadAttributeKeys()
+}
+
+// attributeKeyDue tells whether the current attribute master key is due
+// for rotation. The caller holds attrKeysMutex.
+func (tca *TCA) attributeKeyDue() bool {
+	return tca.attrKeyRotation > 0 && time.Since(tca.attrKeys[tca.attrKeyID].created) >= tca.attrKeyRotation
+}
+
+// getPreKFrom returns the pre-key of the affiliation of the owner of
+// enrollmentCertificate under the current master key, along with its id.
+func (tca *TCA) getPreKFrom(enrollmentCertificate *x509.Certificate) (uint32, []byte, error) {
 	_, _, affiliation, err := tca.eca.parseEnrollID(enrollmentCertificate.Subject.CommonName)
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
-	preK := tca.preKeys[affiliation]
+
+	if err := tca.rotateAttributeKey(false); err != nil {
+		return 0, nil, err
+	}
+
+	tca.attrKeysMutex.RLock()
+	defer tca.attrKeysMutex.RUnlock()
+
+	preK := tca.attrKeys[tca.attrKeyID].preKeys[affiliation]
 	if preK == nil {
-		return nil, errors.New("Could not find a Pre-Key corresponding to affiliation group '" + affiliation + "'")
+		return 0, nil, errors.New("Could not find a Pre-Key corresponding to affiliation group '" + affiliation + "'")
 	}
-	return preK, nil
+	return tca.attrKeyID, preK, nil
+}
+
+// readAttributeKeys returns the pre-keys of affiliation under the master
+// keys in use, by id, along with the id of the current one.
+func (tca *TCA) readAttributeKeys(affiliation string) (uint32, map[uint32][]byte) {
+	if err := tca.refreshAttributeKeys(); err != nil {
+		Error.Println(err)
+	}
+
+	tca.attrKeysMutex.RLock()
+	defer tca.attrKeysMutex.RUnlock()
+
+	now := time.Now()
+	preKeys := make(map[uint32][]byte)
+	for id, attrKey := range tca.attrKeys {
+		if !attrKey.retired.IsZero() && now.Sub(attrKey.retired) >= tca.attrKeyRetention {
+			continue
+		}
+		if preK := attrKey.preKeys[affiliation]; preK != nil {
+			preKeys[id] = preK
+		}
+	}
+
+	return tca.attrKeyID, preKeys
 }
 
 // Start starts the TCA.
 func (tca *TCA) Start(srv *grpc.Server) {
 	tca.startTCAP(srv)
 	tca.startTCAA(srv)
+	tca.startCRLPublication()
+	if tca.inventory != nil {
+		tca.inventory.start()
+	}
 
 	tca.startValidityPeriodUpdate()
 	Info.Println("TCA started.")
@@ -221,27 +488,68 @@ func (tca *TCA) startTCAA(srv *grpc.Server) {
 	pb.RegisterTCAAServer(srv, &TCAA{tca})
 }
 
-// ReadCACertificate reads the certificate of the TCA.
+// ReadCACertificate reads the certificate of the TCA, followed by its intermediate certificates.
 func (tcap *TCAP) ReadCACertificate(ctx context.Context, in *pb.Empty) (*pb.Cert, error) {
 	Trace.Println("gRPC TCAP:ReadCACertificate")
+	defer observeRPC("TCAP.ReadCACertificate")()
 
-	return &pb.Cert{tcap.tca.raw}, nil
+	return &pb.Cert{tcap.tca.certificateChain()}, nil
 }
 
 // CreateCertificateSet requests the creation of a new transaction certificate set by the TCA.
 func (tcap *TCAP) CreateCertificateSet(ctx context.Context, in *pb.TCertCreateSetReq) (*pb.TCertCreateSetResp, error) {
 	Trace.Println("gRPC TCAP:CreateCertificateSet")
+	defer observeRPC("TCAP.CreateCertificateSet")()
+
+	var key []byte
+	var set []*pb.TCert
+	err := tcap.createCertificateSet(in, func(kdfKey []byte, tcert *pb.TCert) error {
+		key = kdfKey
+		set = append(set, tcert)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.TCertCreateSetResp{Certs: &pb.CertSet{Ts: in.Ts, Id: in.Id, Key: key, Certs: set}}, nil
+}
 
+// CreateCertificateSetStream creates a new transaction certificate set as
+// CreateCertificateSet does, sending each TCert as soon as it is issued.
+// Issuance stops once the client cancels the stream.
+func (tcap *TCAP) CreateCertificateSetStream(in *pb.TCertCreateSetReq, stream pb.TCAP_CreateCertificateSetStreamServer) error {
+	Trace.Println("gRPC TCAP:CreateCertificateSetStream")
+	defer observeRPC("TCAP.CreateCertificateSetStream")()
+
+	return tcap.createCertificateSet(in, func(kdfKey []byte, tcert *pb.TCert) error {
+		if err := stream.Context().Err(); err != nil {
+			return err
+		}
+		return stream.Send(&pb.TCertCreateSetResp{Certs: &pb.CertSet{Ts: in.Ts, Id: in.Id, Key: kdfKey, Certs: []*pb.TCert{tcert}}})
+	})
+}
+
+// createCertificateSet issues the TCerts requested by in, passing each
+// to send along with the key derivation key of the set as it is issued.
+func (tcap *TCAP) createCertificateSet(in *pb.TCertCreateSetReq, send func(kdfKey []byte, tcert *pb.TCert) error) error {
 	id := in.Id.Id
 	raw, err := tcap.tca.eca.readCertificate(id, x509.KeyUsageDigitalSignature)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	cert, err := x509.ParseCertificate(raw)
+	cert, err := secp256k1.ParseCertificate(raw)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if tcap.tca.eca.readState(id) == userStateRevoked || tcap.tca.eca.isRevoked(cert.SerialNumber) {
+		return errors.New("Enrollment certificate revoked.")
+	}
+	// TCert keys derive from the enrollment key by elliptic curve point addition
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New("TCerts can only be issued for ECDSA enrollment certificates")
 	}
-	pub := cert.PublicKey.(*ecdsa.PublicKey)
 
 	r, s := big.NewInt(0), big.NewInt(0)
 	r.UnmarshalText(in.Sig.R)
@@ -254,75 +562,176 @@ func (tcap *TCAP) CreateCertificateSet(ctx context.Context, in *pb.TCertCreateSe
 	raw, _ = proto.Marshal(in)
 	hash.Write(raw)
 	if ecdsa.Verify(pub, hash.Sum(nil), r, s) == false {
-		return nil, errors.New("Signature verification failed")
+		return errors.New("Signature verification failed")
+	}
+	if err := tcap.tca.limiter.allow(id); err != nil {
+		return err
+	}
+
+	num := int(in.Num)
+	if num == 0 {
+		num = 1
+	}
+
+	policy, err := tcap.tca.readTCertPolicy(cert.Subject.CommonName)
+	if err != nil {
+		return err
+	}
+	if err := policy.check(num, in.Attributes); err != nil {
+		return err
+	}
+	notBefore := time.Now().Add(-1 * time.Minute)
+	notAfter := notBefore.Add(policy.validity)
+
+	// The attribute sources, if any, have the last word on the values
+	attributes, err := tcap.tca.resolveAttributes(cert.Subject.CommonName, in.Attributes)
+	if err != nil {
+		return err
 	}
 
 	// Generate nonce for TCertIndex
 	nonce := make([]byte, 16) // 8 bytes rand, 8 bytes timestamp
 	rand.Reader.Read(nonce[:8])
 
-	mac := hmac.New(primitives.GetDefaultHash(), tcap.tca.hmacKey)
-	raw, _ = x509.MarshalPKIXPublicKey(pub)
-	mac.Write(raw)
-	kdfKey := mac.Sum(nil)
+	batch := &tcertBatch{id, cert, pub, tcap.tca.tcertKDFKey(pub), attributes, in.DualKey, notBefore, notAfter}
 
-	num := int(in.Num)
-	if num == 0 {
-		num = 1
-	}
+	// the TCerts issued are recorded even if the client stops the stream
+	issued := 0
+	defer func() {
+		if issued > 0 {
+			tcap.tca.eca.auditLog.append(id, auditTCerts, id, tcertDetails(issued, attributes))
+		}
+	}()
 
-	// the batch of TCerts
-	var set []*pb.TCert
+	// TCerts without attributes are served from the inventory first, if any
+	if inventory := tcap.tca.inventory; inventory != nil {
+		inventory.record(id, num)
+		if len(attributes) == 0 && !in.DualKey {
+			tcerts, err := inventory.take(id, cert, policy.validity, num, in.Ts.Seconds)
+			if err != nil {
+				Error.Println(err)
+			}
+			for _, tcert := range tcerts {
+				issued++
+				if err := send(batch.kdfKey, tcert); err != nil {
+					return err
+				}
+			}
+			num -= len(tcerts)
+		}
+	}
 
 	for i := 0; i < num; i++ {
-		tcertid := util.GenerateIntUUID()
+		var ks map[string][]byte
+		newSpec, err := tcap.newTCertSpec(batch, tcertIndex(i, nonce), &ks)
+		if err != nil {
+			return err
+		}
+		if raw, err = tcap.tca.createCertificateWithSerial(newSpec, in.Ts.Seconds, batch.kdfKey); err != nil {
+			Error.Println(err)
+			return err
+		}
+		issued++
 
-		// Compute TCertIndex
-		tidx := []byte(strconv.Itoa(2*i + 1))
-		tidx = append(tidx[:], nonce[:]...)
-		tidx = append(tidx[:], Padding...)
+		if err := send(batch.kdfKey, &pb.TCert{raw, ks}); err != nil {
+			return err
+		}
+	}
 
-		mac := hmac.New(primitives.GetDefaultHash(), kdfKey)
-		mac.Write([]byte{1})
-		extKey := mac.Sum(nil)[:32]
+	return nil
+}
 
-		mac = hmac.New(primitives.GetDefaultHash(), kdfKey)
-		mac.Write([]byte{2})
-		mac = hmac.New(primitives.GetDefaultHash(), mac.Sum(nil))
-		mac.Write(tidx)
+// tcertBatch is what the TCerts of a batch are issued for
+type tcertBatch struct {
+	id         string
+	cert       *x509.Certificate
+	pub        *ecdsa.PublicKey
+	kdfKey     []byte
+	attributes []*pb.TCertAttribute
+	dualKey    bool
+	notBefore  time.Time
+	notAfter   time.Time
+}
 
-		one := new(big.Int).SetInt64(1)
-		k := new(big.Int).SetBytes(mac.Sum(nil))
-		k.Mod(k, new(big.Int).Sub(pub.Curve.Params().N, one))
-		k.Add(k, one)
+// tcertKDFKey returns the key derivation key of the TCerts issued for the
+// enrollment key pub
+func (tca *TCA) tcertKDFKey(pub *ecdsa.PublicKey) []byte {
+	mac := hmac.New(primitives.GetDefaultHash(), tca.hmacKey)
+	raw, _ := secp256k1.MarshalPKIXPublicKey(pub)
+	mac.Write(raw)
 
-		tmpX, tmpY := pub.ScalarBaseMult(k.Bytes())
-		txX, txY := pub.Curve.Add(pub.X, pub.Y, tmpX, tmpY)
-		txPub := ecdsa.PublicKey{Curve: pub.Curve, X: txX, Y: txY}
+	return mac.Sum(nil)
+}
 
-		// Compute encrypted TCertIndex
-		encryptedTidx, err := CBCEncrypt(extKey, tidx)
-		if err != nil {
-			return nil, err
-		}
+// tcertIndex returns the TCertIndex of the i-th TCert of a batch
+func tcertIndex(i int, nonce []byte) []byte {
+	tidx := []byte(strconv.Itoa(2*i + 1))
+	tidx = append(tidx[:], nonce[:]...)
+	tidx = append(tidx[:], Padding...)
 
+	return tidx
+}
+
+// newTCertSpec returns the function specifying, for a serial number, the
+// TCert of batch with index tidx. The function stores in ks the keys the
+// extensions of the TCert are encrypted under.
+func (tcap *TCAP) newTCertSpec(batch *tcertBatch, tidx []byte, ks *map[string][]byte) (func(*big.Int) (*CertificateSpec, error), error) {
+	mac := hmac.New(primitives.GetDefaultHash(), batch.kdfKey)
+	mac.Write([]byte{1})
+	extKey := mac.Sum(nil)[:32]
+
+	txPub := deriveTCertPublicKey(batch.pub, batch.kdfKey, 2, tidx)
+
+	// Compute encrypted TCertIndex
+	encryptedTidx, err := CBCEncrypt(extKey, tidx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the extensions depend on the serial number (tcertid) of the TCert
+	return func(tcertid *big.Int) (*CertificateSpec, error) {
 		// TODO: We are storing each K used on the TCert in the ks array (the second return value of this call), but not returning it to the user.
 		// We need to design a structure to return each TCert and the associated Ks.
-		extensions, ks, err := tcap.generateExtensions(tcertid, encryptedTidx, cert, in.Attributes)
+		extensions, keys, err := tcap.generateExtensions(tcertid, encryptedTidx, batch.cert, batch.attributes)
 		if err != nil {
 			return nil, err
 		}
+		*ks = keys
 
-		spec := NewDefaultPeriodCertificateSpec(id, tcertid, &txPub, x509.KeyUsageDigitalSignature, extensions...)
-		if raw, err = tcap.tca.createCertificateFromSpec(spec, in.Ts.Seconds, kdfKey); err != nil {
-			Error.Println(err)
-			return nil, err
+		// Dual-key TCerts carry a second key pair, used for encryption only,
+		// which derives from the enrollment key under its own expansion key
+		if batch.dualKey {
+			encPub := deriveTCertPublicKey(batch.pub, batch.kdfKey, 3, tidx)
+			raw, err := secp256k1.MarshalPKIXPublicKey(&encPub)
+			if err != nil {
+				return nil, err
+			}
+			extensions = append(extensions, pkix.Extension{Id: TCertEncryptionKey, Value: raw})
 		}
 
-		set = append(set, &pb.TCert{raw, ks})
-	}
+		notBefore, notAfter := batch.notBefore, batch.notAfter
+		return NewCertificateSpec(batch.id, batch.id, tcertid, &txPub, x509.KeyUsageDigitalSignature, &notBefore, &notAfter, extensions...), nil
+	}, nil
+}
+
+// deriveTCertPublicKey computes the TCert public key
+// EnrollPub_Key + ExpansionValue G, the expansion value being
+// HMAC(HMAC(kdfKey, variant), tidx)
+func deriveTCertPublicKey(pub *ecdsa.PublicKey, kdfKey []byte, variant byte, tidx []byte) ecdsa.PublicKey {
+	mac := hmac.New(primitives.GetDefaultHash(), kdfKey)
+	mac.Write([]byte{variant})
+	mac = hmac.New(primitives.GetDefaultHash(), mac.Sum(nil))
+	mac.Write(tidx)
+
+	one := new(big.Int).SetInt64(1)
+	k := new(big.Int).SetBytes(mac.Sum(nil))
+	k.Mod(k, new(big.Int).Sub(pub.Curve.Params().N, one))
+	k.Add(k, one)
 
-	return &pb.TCertCreateSetResp{Certs: &pb.CertSet{Ts: in.Ts, Id: in.Id, Key: kdfKey, Certs: set}}, nil
+	tmpX, tmpY := pub.ScalarBaseMult(k.Bytes())
+	txX, txY := pub.Curve.Add(pub.X, pub.Y, tmpX, tmpY)
+
+	return ecdsa.PublicKey{Curve: pub.Curve, X: txX, Y: txY}
 }
 
 // Generate encrypted extensions to be included into the TCert (TCertIndex, EnrollmentID and attributes).
@@ -332,7 +741,7 @@ func (tcap *TCAP) generateExtensions(tcertid *big.Int, tidx []byte, enrollmentCe
 	extensions := make([]pkix.Extension, len(attributes))
 
 	// Compute preK_1 to encrypt attributes and enrollment ID
-	preK1, err := tcap.tca.getPreKFrom(enrollmentCert)
+	keyID, preK1, err := tcap.tca.getPreKFrom(enrollmentCert)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -401,6 +810,13 @@ func (tcap *TCAP) generateExtensions(tcertid *big.Int, tidx []byte, enrollmentCe
 		extensions = append(extensions, pkix.Extension{Id: TCertAttributesHeaders, Critical: false, Value: buildAttributesHeader(attributesHeader)})
 	}
 
+	// Append the id of the master key preK_1 derives from
+	rawKeyID, err := asn1.Marshal(int64(keyID))
+	if err != nil {
+		return nil, nil, err
+	}
+	extensions = append(extensions, pkix.Extension{Id: TCertAttributesKeyID, Critical: false, Value: rawKeyID})
+
 	return extensions, ks, nil
 }
 
@@ -415,6 +831,7 @@ func buildAttributesHeader(attributesHeader map[string]int) []byte {
 // ReadCertificate reads a transaction certificate from the TCA.
 func (tcap *TCAP) ReadCertificate(ctx context.Context, in *pb.TCertReadReq) (*pb.Cert, error) {
 	Trace.Println("gRPC TCAP:ReadCertificate")
+	defer observeRPC("TCAP.ReadCertificate")()
 
 	req := in.Req.Id
 	id := in.Id.Id
@@ -427,7 +844,7 @@ func (tcap *TCAP) ReadCertificate(ctx context.Context, in *pb.TCertReadReq) (*pb
 	if err != nil {
 		return nil, err
 	}
-	cert, err := x509.ParseCertificate(raw)
+	cert, err := secp256k1.ParseCertificate(raw)
 	if err != nil {
 		return nil, err
 	}
@@ -435,15 +852,9 @@ func (tcap *TCAP) ReadCertificate(ctx context.Context, in *pb.TCertReadReq) (*pb
 	sig := in.Sig
 	in.Sig = nil
 
-	r, s := big.NewInt(0), big.NewInt(0)
-	r.UnmarshalText(sig.R)
-	s.UnmarshalText(sig.S)
-
-	hash := primitives.NewHash()
 	raw, _ = proto.Marshal(in)
-	hash.Write(raw)
-	if ecdsa.Verify(cert.PublicKey.(*ecdsa.PublicKey), hash.Sum(nil), r, s) == false {
-		return nil, errors.New("Signature verification failed")
+	if err := verifySignature(cert.PublicKey, sig, raw); err != nil {
+		return nil, err
 	}
 
 	if in.Ts.Seconds != 0 {
@@ -461,6 +872,7 @@ func (tcap *TCAP) ReadCertificate(ctx context.Context, in *pb.TCertReadReq) (*pb
 // ReadCertificateSet reads a transaction certificate set from the TCA.  Not yet implemented.
 func (tcap *TCAP) ReadCertificateSet(ctx context.Context, in *pb.TCertReadSetReq) (*pb.CertSet, error) {
 	Trace.Println("gRPC TCAP:ReadCertificateSet")
+	defer observeRPC("TCAP.ReadCertificateSet")()
 
 	req := in.Req.Id
 	id := in.Id.Id
@@ -473,7 +885,7 @@ func (tcap *TCAP) ReadCertificateSet(ctx context.Context, in *pb.TCertReadSetReq
 	if err != nil {
 		return nil, err
 	}
-	cert, err := x509.ParseCertificate(raw)
+	cert, err := secp256k1.ParseCertificate(raw)
 	if err != nil {
 		return nil, err
 	}
@@ -481,15 +893,9 @@ func (tcap *TCAP) ReadCertificateSet(ctx context.Context, in *pb.TCertReadSetReq
 	sig := in.Sig
 	in.Sig = nil
 
-	r, s := big.NewInt(0), big.NewInt(0)
-	r.UnmarshalText(sig.R)
-	s.UnmarshalText(sig.S)
-
-	hash := primitives.NewHash()
 	raw, _ = proto.Marshal(in)
-	hash.Write(raw)
-	if ecdsa.Verify(cert.PublicKey.(*ecdsa.PublicKey), hash.Sum(nil), r, s) == false {
-		return nil, errors.New("Signature verification failed")
+	if err := verifySignature(cert.PublicKey, sig, raw); err != nil {
+		return nil, err
 	}
 
 	rows, err := tcap.tca.readCertificates(id, in.Ts.Seconds)
@@ -516,23 +922,102 @@ func (tcap *TCAP) ReadCertificateSet(ctx context.Context, in *pb.TCertReadSetReq
 	return &pb.CertSet{in.Ts, in.Id, kdfKey, certs}, nil
 }
 
-// RevokeCertificate revokes a certificate from the TCA.  Not yet implemented.
-func (tcap *TCAP) RevokeCertificate(context.Context, *pb.TCertRevokeReq) (*pb.CAStatus, error) {
+// RevokeCertificate revokes a certificate of the requester from the TCA.
+func (tcap *TCAP) RevokeCertificate(ctx context.Context, in *pb.TCertRevokeReq) (*pb.CAStatus, error) {
 	Trace.Println("gRPC TCAP:RevokeCertificate")
+	defer observeRPC("TCAP.RevokeCertificate")()
 
-	return nil, errors.New("TCAP:RevokeCertificate method not (yet) implemented")
+	return tcap.tca.revokeTCert(in, false)
 }
 
-// RevokeCertificateSet revokes a certificate set from the TCA.  Not yet implemented.
-func (tcap *TCAP) RevokeCertificateSet(context.Context, *pb.TCertRevokeSetReq) (*pb.CAStatus, error) {
+// RevokeCertificateSet revokes a certificate set of the requester from the TCA.
+func (tcap *TCAP) RevokeCertificateSet(ctx context.Context, in *pb.TCertRevokeSetReq) (*pb.CAStatus, error) {
 	Trace.Println("gRPC TCAP:RevokeCertificateSet")
+	defer observeRPC("TCAP.RevokeCertificateSet")()
+
+	if in.Id == nil {
+		return nil, errors.New("Invalid revocation request.")
+	}
 
-	return nil, errors.New("TCAP:RevokeCertificateSet method not (yet) implemented")
+	sig := in.Sig
+	in.Sig = nil
+
+	raw, _ := proto.Marshal(in)
+	if err := tcap.tca.eca.verifyRequest(in.Id.Id, 0, sig, raw); err != nil {
+		return nil, err
+	}
+
+	var ts int64
+	if in.Ts != nil {
+		ts = in.Ts.Seconds
+	}
+	if ts == 0 {
+		// the latest set
+		if err := tcap.tca.db.QueryRow("SELECT MAX(timestamp) FROM Certificates WHERE id=?", in.Id.Id).Scan(&ts); err != nil {
+			return nil, errors.New("No certificates to revoke.")
+		}
+	}
+
+	if err := tcap.tca.revokeCertificates(in.Id.Id, ts); err != nil {
+		Error.Println(err)
+		return nil, err
+	}
+	tcap.tca.eca.auditLog.append(in.Id.Id, auditRevoke, in.Id.Id, "tcerts "+strconv.FormatInt(ts, 10))
+
+	return &pb.CAStatus{pb.CAStatus_OK}, nil
+}
+
+// ReadCRL returns the last CRL published by the TCA.
+func (tcap *TCAP) ReadCRL(ctx context.Context, in *pb.Empty) (*pb.CRL, error) {
+	Trace.Println("gRPC TCAP:ReadCRL")
+	defer observeRPC("TCAP.ReadCRL")()
+
+	raw, err := tcap.tca.readCRL()
+	return &pb.CRL{raw}, err
+}
+
+// revokeTCert revokes the TCert in.Cert. Unless admin is set, the
+// requester can only revoke its own TCerts.
+func (tca *TCA) revokeTCert(in *pb.TCertRevokeReq, admin bool) (*pb.CAStatus, error) {
+	if in.Id == nil || in.Cert == nil {
+		return nil, errors.New("Invalid revocation request.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+
+	var role pb.Role
+	if admin {
+		role = pb.Role_AUDITOR
+	}
+	raw, _ := proto.Marshal(in)
+	if err := tca.eca.verifyRequest(in.Id.Id, role, sig, raw); err != nil {
+		return nil, err
+	}
+
+	hash := primitives.NewHash()
+	hash.Write(in.Cert.Cert)
+	id, _, err := tca.readCertificateOwner(hash.Sum(nil))
+	if err != nil {
+		return nil, errors.New("Unknown certificate.")
+	}
+	if !admin && id != in.Id.Id {
+		return nil, errors.New("Access denied.")
+	}
+
+	if err := tca.revokeCertificate(hash.Sum(nil)); err != nil {
+		Error.Println(err)
+		return nil, err
+	}
+	tca.eca.auditLog.append(in.Id.Id, auditRevoke, id, "tcert "+hex.EncodeToString(hash.Sum(nil)))
+
+	return &pb.CAStatus{pb.CAStatus_OK}, nil
 }
 
 // ReadCertificateSets returns all certificates matching the filter criteria of the request.
 func (tcaa *TCAA) ReadCertificateSets(ctx context.Context, in *pb.TCertReadSetsReq) (*pb.CertSets, error) {
 	Trace.Println("gRPC TCAA:ReadCertificateSets")
+	defer observeRPC("TCAA.ReadCertificateSets")()
 
 	req := in.Req.Id
 	if tcaa.tca.eca.readRole(req)&int(pb.Role_AUDITOR) == 0 {
@@ -543,7 +1028,7 @@ func (tcaa *TCAA) ReadCertificateSets(ctx context.Context, in *pb.TCertReadSetsR
 	if err != nil {
 		return nil, err
 	}
-	cert, err := x509.ParseCertificate(raw)
+	cert, err := secp256k1.ParseCertificate(raw)
 	if err != nil {
 		return nil, err
 	}
@@ -551,15 +1036,9 @@ func (tcaa *TCAA) ReadCertificateSets(ctx context.Context, in *pb.TCertReadSetsR
 	sig := in.Sig
 	in.Sig = nil
 
-	r, s := big.NewInt(0), big.NewInt(0)
-	r.UnmarshalText(sig.R)
-	s.UnmarshalText(sig.S)
-
-	hash := primitives.NewHash()
 	raw, _ = proto.Marshal(in)
-	hash.Write(raw)
-	if ecdsa.Verify(cert.PublicKey.(*ecdsa.PublicKey), hash.Sum(nil), r, s) == false {
-		return nil, errors.New("Signature verification failed")
+	if err := verifySignature(cert.PublicKey, sig, raw); err != nil {
+		return nil, err
 	}
 
 	users, err := tcaa.tca.eca.readUsers(int(in.Role))
@@ -627,23 +1106,129 @@ func (tcaa *TCAA) ReadCertificateSets(ctx context.Context, in *pb.TCertReadSetsR
 	return &pb.CertSets{sets}, nil
 }
 
-// RevokeCertificate revokes a certificate from the TCA.  Not yet implemented.
-func (tcaa *TCAA) RevokeCertificate(context.Context, *pb.TCertRevokeReq) (*pb.CAStatus, error) {
+// RevokeCertificate revokes a certificate of any user from the TCA.
+func (tcaa *TCAA) RevokeCertificate(ctx context.Context, in *pb.TCertRevokeReq) (*pb.CAStatus, error) {
 	Trace.Println("gRPC TCAA:RevokeCertificate")
+	defer observeRPC("TCAA.RevokeCertificate")()
 
-	return nil, errors.New("TCAA:RevokeCertificate method not (yet) implemented")
+	return tcaa.tca.revokeTCert(in, true)
 }
 
-// RevokeCertificateSet revokes a certificate set from the TCA.  Not yet implemented.
-func (tcaa *TCAA) RevokeCertificateSet(context.Context, *pb.TCertRevokeSetReq) (*pb.CAStatus, error) {
+// RevokeCertificateSet revokes a certificate set of any user from the TCA,
+// identified by its key derivation key, and publishes the new CRL.
+func (tcaa *TCAA) RevokeCertificateSet(ctx context.Context, in *pb.TCertRevokeSetReq) (*pb.CAStatus, error) {
 	Trace.Println("gRPC TCAA:RevokeCertificateSet")
+	defer observeRPC("TCAA.RevokeCertificateSet")()
+
+	if in.Id == nil || len(in.Key) == 0 {
+		return nil, errors.New("Invalid revocation request.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+
+	raw, _ := proto.Marshal(in)
+	if err := tcaa.tca.eca.verifyRequest(in.Id.Id, pb.Role_AUDITOR, sig, raw); err != nil {
+		return nil, err
+	}
+
+	// the owner of the set, for the audit log
+	var owner string
+	tcaa.tca.db.QueryRow("SELECT id FROM Certificates WHERE kdfkey=?", in.Key).Scan(&owner)
 
-	return nil, errors.New("TCAA:RevokeCertificateSet method not (yet) implemented")
+	n, err := tcaa.tca.revokeMatchingCertificates("kdfkey=?", in.Key)
+	if err != nil {
+		Error.Println(err)
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errors.New("No certificates to revoke.")
+	}
+	tcaa.tca.eca.auditLog.append(in.Id.Id, auditRevoke, owner, "tcerts kdfkey "+hex.EncodeToString(in.Key))
+	if _, err := tcaa.tca.publishCRL(); err != nil {
+		return nil, err
+	}
+
+	return &pb.CAStatus{pb.CAStatus_OK}, nil
 }
 
-// PublishCRL requests the creation of a certificate revocation list from the TCA.  Not yet implemented.
-func (tcaa *TCAA) PublishCRL(context.Context, *pb.TCertCRLReq) (*pb.CAStatus, error) {
+// PublishCRL requests the creation of a certificate revocation list from the TCA.
+func (tcaa *TCAA) PublishCRL(ctx context.Context, in *pb.TCertCRLReq) (*pb.CAStatus, error) {
 	Trace.Println("gRPC TCAA:CreateCRL")
+	defer observeRPC("TCAA.PublishCRL")()
+
+	if in.Id == nil {
+		return nil, errors.New("Invalid CRL request.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+
+	raw, _ := proto.Marshal(in)
+	if err := tcaa.tca.eca.verifyRequest(in.Id.Id, pb.Role_AUDITOR, sig, raw); err != nil {
+		return nil, err
+	}
+
+	if _, err := tcaa.tca.publishCRL(); err != nil {
+		return nil, err
+	}
+
+	return &pb.CAStatus{pb.CAStatus_OK}, nil
+}
+
+// RotateAttributeKey replaces the attribute master key of the TCA. TCerts
+// issued under the previous key remain readable for the retention window.
+func (tcaa *TCAA) RotateAttributeKey(ctx context.Context, in *pb.TCertAttributeKeyRotateReq) (*pb.CAStatus, error) {
+	Trace.Println("gRPC TCAA:RotateAttributeKey")
+	defer observeRPC("TCAA.RotateAttributeKey")()
+
+	if in.Id == nil {
+		return nil, errors.New("Invalid attribute key rotation request.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+
+	raw, _ := proto.Marshal(in)
+	if err := tcaa.tca.eca.verifyRequest(in.Id.Id, pb.Role_AUDITOR, sig, raw); err != nil {
+		return nil, err
+	}
+
+	if err := tcaa.tca.rotateAttributeKey(true); err != nil {
+		return nil, err
+	}
+
+	return &pb.CAStatus{pb.CAStatus_OK}, nil
+}
+
+// ReadAttributeKeys reads the keys of an affiliation under the attribute
+// master keys in use, so that validators and auditors can decrypt the
+// attributes of TCerts issued under any of them.
+func (tcaa *TCAA) ReadAttributeKeys(ctx context.Context, in *pb.TCertAttributeKeysReq) (*pb.TCertAttributeKeys, error) {
+	Trace.Println("gRPC TCAA:ReadAttributeKeys")
+	defer observeRPC("TCAA.ReadAttributeKeys")()
+
+	if in.Id == nil {
+		return nil, errors.New("Invalid attribute keys request.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+
+	raw, _ := proto.Marshal(in)
+	if err := tcaa.tca.eca.verifyRequest(in.Id.Id, pb.Role_VALIDATOR|pb.Role_AUDITOR, sig, raw); err != nil {
+		return nil, err
+	}
+
+	current, preKeys := tcaa.tca.readAttributeKeys(in.Affiliation)
+	if len(preKeys) == 0 {
+		return nil, errors.New("Could not find a Pre-Key corresponding to affiliation group '" + in.Affiliation + "'")
+	}
+
+	keys := &pb.TCertAttributeKeys{Current: current}
+	for id, preK := range preKeys {
+		keys.Keys = append(keys.Keys, &pb.TCertAttributeKey{Id: id, Key: preK})
+	}
 
-	return nil, errors.New("TCAA:PublichCRL method not (yet) implemented")
+	return keys, nil
 }
@@ -0,0 +1,346 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives/secp256k1"
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+)
+
+// tcertInventory issues TCerts ahead, while the TCA is idle, for the
+// clients requesting the most, so that their batches are served without
+// elliptic curve work. Only TCerts without attributes, that are not
+// dual-key, are issued ahead. They are kept in the database of the TCA,
+// shared by the replicas, and recorded as issued once served.
+//
+type tcertInventory struct {
+	tca *TCA
+
+	// clients are always stocked for, as well as the users requesting at
+	// least threshold TCerts over an interval
+	clients   []string
+	threshold int
+
+	// size is how many TCerts are kept for each client, for up to maxAge
+	size     int
+	maxAge   time.Duration
+	interval time.Duration
+
+	mutex     sync.Mutex
+	requested map[string]int
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// readTCertInventory reads the settings of the TCert inventory under
+// tca.inventory. It returns nil if no clients are stocked for.
+//
+func readTCertInventory(tca *TCA) (*tcertInventory, error) {
+	inv := &tcertInventory{
+		tca:       tca,
+		clients:   strings.Fields(GetConfigString("tca.inventory.clients")),
+		size:      100,
+		maxAge:    time.Hour,
+		interval:  time.Minute,
+		requested: make(map[string]int),
+	}
+
+	var err error
+	if threshold := GetConfigString("tca.inventory.threshold"); threshold != "" {
+		if inv.threshold, err = strconv.Atoi(threshold); err != nil {
+			return nil, err
+		}
+	}
+	if len(inv.clients) == 0 && inv.threshold <= 0 {
+		return nil, nil
+	}
+
+	if size := GetConfigString("tca.inventory.size"); size != "" {
+		if inv.size, err = strconv.Atoi(size); err != nil {
+			return nil, err
+		}
+	}
+	if maxAge := GetConfigString("tca.inventory.maxage"); maxAge != "" {
+		if inv.maxAge, err = time.ParseDuration(maxAge); err != nil {
+			return nil, err
+		}
+	}
+	if interval := GetConfigString("tca.inventory.interval"); interval != "" {
+		if inv.interval, err = time.ParseDuration(interval); err != nil {
+			return nil, err
+		}
+	}
+	if inv.size <= 0 || inv.maxAge <= 0 || inv.interval <= 0 {
+		return nil, errors.New("Invalid TCert inventory settings")
+	}
+
+	return inv, nil
+}
+
+// start stocks the inventory every interval until the TCA is closed
+//
+func (inv *tcertInventory) start() {
+	inv.stopCh = make(chan struct{})
+	inv.doneCh = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+
+		ticker := time.NewTicker(inv.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			inv.fill(stop)
+		}
+	}(inv.stopCh, inv.doneCh)
+}
+
+// stop stops stocking the inventory
+//
+func (inv *tcertInventory) stop() {
+	if inv.stopCh != nil {
+		close(inv.stopCh)
+		<-inv.doneCh
+		inv.stopCh = nil
+	}
+}
+
+// record counts num TCerts requested by the user id
+//
+func (inv *tcertInventory) record(id string, num int) {
+	inv.mutex.Lock()
+	inv.requested[id] += num
+	inv.mutex.Unlock()
+}
+
+// stocked returns the clients to stock for: those configured, then those
+// who requested at least threshold TCerts since the last call
+//
+func (inv *tcertInventory) stocked() []string {
+	inv.mutex.Lock()
+	defer inv.mutex.Unlock()
+
+	configured := make(map[string]bool)
+	for _, id := range inv.clients {
+		configured[id] = true
+	}
+	var busy []string
+	for id, num := range inv.requested {
+		if inv.threshold > 0 && num >= inv.threshold && !configured[id] {
+			busy = append(busy, id)
+		}
+	}
+	sort.Strings(busy)
+	inv.requested = make(map[string]int)
+
+	return append(append([]string(nil), inv.clients...), busy...)
+}
+
+// idle tells whether the TCA serves no TCert batch
+//
+func (inv *tcertInventory) idle() bool {
+	return pendingRPCs("TCAP.CreateCertificateSet", "TCAP.CreateCertificateSetStream") == 0
+}
+
+// currentKeyID returns the id of the attribute master key TCerts are
+// issued under
+//
+func (inv *tcertInventory) currentKeyID() uint32 {
+	if err := inv.tca.refreshAttributeKeys(); err != nil {
+		Error.Println(err)
+	}
+
+	inv.tca.attrKeysMutex.RLock()
+	defer inv.tca.attrKeysMutex.RUnlock()
+
+	return inv.tca.attrKeyID
+}
+
+// fill discards the TCerts kept too long or issued under a retired
+// attribute master key, then stocks TCerts for the clients while the TCA
+// is idle, until stop is closed
+//
+func (inv *tcertInventory) fill(stop chan struct{}) {
+	if _, err := inv.tca.db.Exec("DELETE FROM TCertInventory WHERE created<=? OR keyid<>?", time.Now().Add(-inv.maxAge).Unix(), inv.currentKeyID()); err != nil {
+		Error.Println(err)
+		return
+	}
+
+	for _, id := range inv.stocked() {
+		if err := inv.stock(id, stop); err != nil {
+			Warning.Println("Failed stocking TCerts for "+id+":", err)
+		}
+	}
+}
+
+// stock issues TCerts for the user id until it has size of them, as long
+// as the TCA is idle and stop is not closed
+//
+func (inv *tcertInventory) stock(id string, stop chan struct{}) error {
+	tca := inv.tca
+
+	raw, err := tca.eca.readCertificate(id, x509.KeyUsageDigitalSignature)
+	if err != nil {
+		return err
+	}
+	cert, err := secp256k1.ParseCertificate(raw)
+	if err != nil {
+		return err
+	}
+	if tca.eca.readState(id) == userStateRevoked || tca.eca.isRevoked(cert.SerialNumber) {
+		_, err := tca.db.Exec("DELETE FROM TCertInventory WHERE id=?", id)
+		return err
+	}
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New("TCerts can only be issued for ECDSA enrollment certificates")
+	}
+
+	// the TCerts issued for a previous enrollment are of no use
+	ecert := cert.SerialNumber.String()
+	if _, err := tca.db.Exec("DELETE FROM TCertInventory WHERE id=? AND ecert<>?", id, ecert); err != nil {
+		return err
+	}
+
+	var count int
+	if err := tca.db.QueryRow("SELECT count(row) FROM TCertInventory WHERE id=?", id).Scan(&count); err != nil {
+		return err
+	}
+	if count >= inv.size {
+		return nil
+	}
+
+	policy, err := tca.readTCertPolicy(cert.Subject.CommonName)
+	if err != nil {
+		return err
+	}
+	notBefore := time.Now().Add(-1 * time.Minute)
+	notAfter := notBefore.Add(policy.validity)
+
+	nonce := make([]byte, 16)
+	rand.Reader.Read(nonce[:8])
+
+	tcap := &TCAP{tca}
+	batch := &tcertBatch{id, cert, pub, tca.tcertKDFKey(pub), nil, false, notBefore, notAfter}
+	keyID := inv.currentKeyID()
+	for i := 0; count+i < inv.size; i++ {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		if !inv.idle() {
+			return nil
+		}
+
+		var ks map[string][]byte
+		newSpec, err := tcap.newTCertSpec(batch, tcertIndex(i, nonce), &ks)
+		if err != nil {
+			return err
+		}
+		spec, err := newSpec(util.GenerateIntUUID())
+		if err != nil {
+			return err
+		}
+		raw, err := tca.newCertificateFromSpec(spec)
+		if err != nil {
+			return err
+		}
+
+		_, err = tca.db.Exec("INSERT INTO TCertInventory (id, ecert, keyid, cert, serial, kdfkey, enrollmentkey, created, expires) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			id, ecert, keyID, raw, spec.GetSerialNumber().String(), batch.kdfKey, ks["enrollmentId"], time.Now().Unix(), notAfter.Unix())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// take serves up to num TCerts of the user id from the inventory, recorded
+// as issued at timestamp. Only TCerts issued for cert, under the current
+// attribute master key, and not valid for longer than validity from now
+// are served.
+//
+func (inv *tcertInventory) take(id string, cert *x509.Certificate, validity time.Duration, num int, timestamp int64) ([]*pb.TCert, error) {
+	tca := inv.tca
+	now := time.Now()
+
+	rows, err := tca.db.Query("SELECT row, cert, serial, kdfkey, enrollmentkey FROM TCertInventory WHERE id=? AND ecert=? AND keyid=? AND created>? AND expires<=? ORDER BY row LIMIT "+strconv.Itoa(num),
+		id, cert.SerialNumber.String(), inv.currentKeyID(), now.Add(-inv.maxAge).Unix(), now.Add(validity).Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	type stockedTCert struct {
+		row                   int64
+		raw                   []byte
+		serial                string
+		kdfKey, enrollmentKey []byte
+	}
+	var stocked []stockedTCert
+	for rows.Next() {
+		var s stockedTCert
+		if err := rows.Scan(&s.row, &s.raw, &s.serial, &s.kdfKey, &s.enrollmentKey); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stocked = append(stocked, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var tcerts []*pb.TCert
+	for _, s := range stocked {
+		// another replica may be serving the same TCert
+		if err := rowsUpdated(tca.db.Exec("DELETE FROM TCertInventory WHERE row=?", s.row)); err != nil {
+			continue
+		}
+
+		serial, ok := new(big.Int).SetString(s.serial, 10)
+		if !ok {
+			continue
+		}
+		// the serial number may have been drawn since for another certificate
+		if err := tca.storeCertificate(id, timestamp, x509.KeyUsageDigitalSignature, s.raw, s.kdfKey, serial); err != nil {
+			continue
+		}
+		tcerts = append(tcerts, &pb.TCert{s.raw, map[string][]byte{"enrollmentId": s.enrollmentKey}})
+	}
+	Trace.Println("Served " + strconv.Itoa(len(tcerts)) + " TCerts of " + id + " from the inventory.")
+
+	return tcerts, nil
+}
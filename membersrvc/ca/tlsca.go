@@ -21,6 +21,8 @@ import (
 	"crypto/x509"
 	"errors"
 	"math/big"
+	"sync"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
@@ -34,6 +36,14 @@ import (
 type TLSCA struct {
 	*CA
 	eca *ECA
+
+	// overlap is how long the roots replaced by a rotation are still
+	// trusted unless the rotation says otherwise
+	overlap time.Duration
+
+	// rootMutex guards the signing key and certificate of the TLSCA,
+	// which rotations replace
+	rootMutex sync.RWMutex
 }
 
 // TLSCAP serves the public GRPC interface of the TLSCA.
@@ -51,7 +61,18 @@ type TLSCAA struct {
 // NewTLSCA sets up a new TLSCA.
 //
 func NewTLSCA(eca *ECA) *TLSCA {
-	tlsca := &TLSCA{NewCA("tlsca"), eca}
+	tlsca := &TLSCA{CA: NewCA("tlsca"), eca: eca, overlap: defaultTLSCAOverlap}
+
+	if err := tlsca.db.migrate("tlsca", tlscaMigrations); err != nil {
+		Panic.Panicln(err)
+	}
+	if overlap := GetConfigString("tlsca.rotation.overlap"); overlap != "" {
+		d, err := time.ParseDuration(overlap)
+		if err != nil {
+			Panic.Panicln(err)
+		}
+		tlsca.overlap = d
+	}
 
 	return tlsca
 }
@@ -77,6 +98,10 @@ func (tlsca *TLSCA) startTLSCAA(srv *grpc.Server) {
 //
 func (tlscap *TLSCAP) ReadCACertificate(ctx context.Context, in *pb.Empty) (*pb.Cert, error) {
 	Trace.Println("grpc TLSCAP:ReadCACertificate")
+	defer observeRPC("TLSCAP.ReadCACertificate")()
+
+	tlscap.tlsca.rootMutex.RLock()
+	defer tlscap.tlsca.rootMutex.RUnlock()
 
 	return &pb.Cert{tlscap.tlsca.raw}, nil
 }
@@ -85,6 +110,7 @@ func (tlscap *TLSCAP) ReadCACertificate(ctx context.Context, in *pb.Empty) (*pb.
 //
 func (tlscap *TLSCAP) CreateCertificate(ctx context.Context, in *pb.TLSCertCreateReq) (*pb.TLSCertCreateResp, error) {
 	Trace.Println("grpc TLSCAP:CreateCertificate")
+	defer observeRPC("TLSCAP.CreateCertificate")()
 
 	id := in.Id.Id
 
@@ -111,6 +137,9 @@ func (tlscap *TLSCAP) CreateCertificate(ctx context.Context, in *pb.TLSCertCreat
 		return nil, errors.New("signature does not verify")
 	}
 
+	tlscap.tlsca.rootMutex.RLock()
+	defer tlscap.tlsca.rootMutex.RUnlock()
+
 	if raw, err = tlscap.tlsca.createCertificate(id, pub.(*ecdsa.PublicKey), x509.KeyUsageDigitalSignature, in.Ts.Seconds, nil); err != nil {
 		Error.Println(err)
 		return nil, err
@@ -123,6 +152,7 @@ func (tlscap *TLSCAP) CreateCertificate(ctx context.Context, in *pb.TLSCertCreat
 //
 func (tlscap *TLSCAP) ReadCertificate(ctx context.Context, in *pb.TLSCertReadReq) (*pb.Cert, error) {
 	Trace.Println("grpc TLSCAP:ReadCertificate")
+	defer observeRPC("TLSCAP.ReadCertificate")()
 
 	raw, err := tlscap.tlsca.readCertificate(in.Id.Id, x509.KeyUsageKeyAgreement)
 	if err != nil {
@@ -136,6 +166,7 @@ func (tlscap *TLSCAP) ReadCertificate(ctx context.Context, in *pb.TLSCertReadReq
 //
 func (tlscap *TLSCAP) RevokeCertificate(context.Context, *pb.TLSCertRevokeReq) (*pb.CAStatus, error) {
 	Trace.Println("grpc TLSCAP:RevokeCertificate")
+	defer observeRPC("TLSCAP.RevokeCertificate")()
 
 	return nil, errors.New("not yet implemented")
 }
@@ -144,6 +175,7 @@ func (tlscap *TLSCAP) RevokeCertificate(context.Context, *pb.TLSCertRevokeReq) (
 //
 func (tlscaa *TLSCAA) RevokeCertificate(context.Context, *pb.TLSCertRevokeReq) (*pb.CAStatus, error) {
 	Trace.Println("grpc TLSCAA:RevokeCertificate")
+	defer observeRPC("TLSCAA.RevokeCertificate")()
 
 	return nil, errors.New("not yet implemented")
 }
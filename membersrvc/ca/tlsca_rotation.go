@@ -0,0 +1,287 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	protobuf "google/protobuf"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/primitives/secp256k1"
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"golang.org/x/net/context"
+)
+
+// defaultTLSCAOverlap is how long the roots replaced by a rotation are
+// still trusted unless configured otherwise
+//
+const defaultTLSCAOverlap = 7 * 24 * time.Hour
+
+// tlscaMigrations create and update the tables of the TLSCA
+//
+var tlscaMigrations = []string{
+	"CREATE TABLE IF NOT EXISTS RetiredRoots (row INTEGER PRIMARY KEY, cert BLOB, rotated INTEGER, retires INTEGER)",
+}
+
+// ReadCACertificates returns the roots peers trust TLS certificates
+// issued under: the current root, followed by the roots replaced by
+// rotations less than their overlap ago.
+//
+func (tlscap *TLSCAP) ReadCACertificates(ctx context.Context, in *pb.Empty) (*pb.TLSCARoots, error) {
+	Trace.Println("grpc TLSCAP:ReadCACertificates")
+	defer observeRPC("TLSCAP.ReadCACertificates")()
+
+	return tlscap.tlsca.readRoots(false)
+}
+
+// RotateCACertificate replaces the root of the TLSCA by a new self-signed
+// root, under which TLS certificates are issued from then on. The replaced
+// root is still trusted for the overlap of the request, or of the TLSCA,
+// so that peers can renew their TLS certificates meanwhile.
+//
+func (tlscaa *TLSCAA) RotateCACertificate(ctx context.Context, in *pb.TLSCARotateReq) (*pb.TLSCARoots, error) {
+	Trace.Println("grpc TLSCAA:RotateCACertificate")
+	defer observeRPC("TLSCAA.RotateCACertificate")()
+
+	if in.Id == nil || in.Overlap < 0 {
+		return nil, errors.New("Invalid rotation request.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+
+	raw, _ := proto.Marshal(in)
+	if err := tlscaa.tlsca.eca.verifyRequest(in.Id.Id, pb.Role_AUDITOR, sig, raw); err != nil {
+		return nil, err
+	}
+
+	overlap := tlscaa.tlsca.overlap
+	if in.Overlap != 0 {
+		overlap = time.Duration(in.Overlap) * time.Second
+	}
+
+	Info.Println(in.Id.Id + " rotates the root of the TLSCA.")
+	if err := tlscaa.tlsca.rotate(overlap); err != nil {
+		Error.Println(err)
+		return nil, err
+	}
+
+	return tlscaa.tlsca.readRoots(true)
+}
+
+// ReadRotation returns the roots of the TLSCA along with how many
+// unexpired TLS certificates each has issued, telling how far peers
+// have renewed their TLS certificates since a rotation.
+//
+func (tlscaa *TLSCAA) ReadRotation(ctx context.Context, in *pb.TLSCARotationReq) (*pb.TLSCARoots, error) {
+	Trace.Println("grpc TLSCAA:ReadRotation")
+	defer observeRPC("TLSCAA.ReadRotation")()
+
+	if in.Id == nil {
+		return nil, errors.New("Invalid rotation request.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+
+	raw, _ := proto.Marshal(in)
+	if err := tlscaa.tlsca.eca.verifyRequest(in.Id.Id, pb.Role_AUDITOR, sig, raw); err != nil {
+		return nil, err
+	}
+
+	return tlscaa.tlsca.readRoots(true)
+}
+
+// rotate replaces the root of the TLSCA by a new self-signed root, keeping
+// the replaced root trusted for overlap. Other replicas go on issuing TLS
+// certificates under the replaced root until they are given the new key
+// and certificate files and restarted.
+//
+func (tlsca *TLSCA) rotate(overlap time.Duration) error {
+	if tlsca.hsm != nil {
+		return errors.New("The key of the TLSCA is kept on an HSM and cannot be rotated.")
+	}
+	if tlsca.chain != nil {
+		return errors.New("The certificate of the TLSCA is issued by another CA and cannot be rotated.")
+	}
+
+	priv, err := ecdsa.GenerateKey(primitives.GetDefaultCurve(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	// a CA without certificate self-signs
+	raw, err := (&CA{priv: priv}).newCertificate("tlsca", &priv.PublicKey, x509.KeyUsageDigitalSignature|x509.KeyUsageCertSign, nil)
+	if err != nil {
+		return err
+	}
+	cert, err := secp256k1.ParseCertificate(raw)
+	if err != nil {
+		return err
+	}
+
+	tlsca.rootMutex.Lock()
+	defer tlsca.rootMutex.Unlock()
+
+	now := time.Now()
+	tx, err := tlsca.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO RetiredRoots (cert, rotated, retires) VALUES (?, ?, ?)", tlsca.raw, now.Unix(), now.Add(overlap).Unix()); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tlsca.writeRoot(priv, raw); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	tlsca.priv, tlsca.cert, tlsca.raw = priv, cert, raw
+	Info.Println("TLSCA root rotated, the previous root is trusted until " + now.Add(overlap).Format(time.RFC3339) + ".")
+
+	return nil
+}
+
+// writeRoot replaces the key and certificate files of the TLSCA. The new
+// files are written aside first, so that a failure leaves the previous ones.
+//
+func (tlsca *TLSCA) writeRoot(priv *ecdsa.PrivateKey, raw []byte) error {
+	rawPriv, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	rawPub, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	files := []struct {
+		name string
+		typ  string
+		der  []byte
+	}{
+		{"tlsca.priv", "ECDSA PRIVATE KEY", rawPriv},
+		{"tlsca.pub", "ECDSA PUBLIC KEY", rawPub},
+		{"tlsca.cert", "CERTIFICATE", raw},
+	}
+	for _, file := range files {
+		cooked := pem.EncodeToMemory(&pem.Block{Type: file.typ, Bytes: file.der})
+		if err := ioutil.WriteFile(tlsca.path+"/"+file.name+".new", cooked, 0600); err != nil {
+			return err
+		}
+	}
+	for _, file := range files {
+		if err := os.Rename(tlsca.path+"/"+file.name+".new", tlsca.path+"/"+file.name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readRoots returns the current root followed by the roots still trusted
+// after being replaced, most recent first. If count is set, each root
+// comes with how many unexpired TLS certificates it has issued.
+//
+func (tlsca *TLSCA) readRoots(count bool) (*pb.TLSCARoots, error) {
+	tlsca.rootMutex.RLock()
+	current := tlsca.raw
+	tlsca.rootMutex.RUnlock()
+
+	roots := []*pb.TLSCARoot{{Cert: &pb.Cert{current}}}
+
+	rows, err := tlsca.db.Query("SELECT cert, rotated, retires FROM RetiredRoots WHERE retires>? ORDER BY rotated DESC", time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var raw []byte
+		var rotated, retires int64
+		if err := rows.Scan(&raw, &rotated, &retires); err != nil {
+			return nil, err
+		}
+		// replicas not restarted since a rotation still issue under the replaced root
+		if bytes.Equal(raw, current) {
+			continue
+		}
+		roots = append(roots, &pb.TLSCARoot{Cert: &pb.Cert{raw}, Rotated: &protobuf.Timestamp{Seconds: rotated}, Retires: &protobuf.Timestamp{Seconds: retires}})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if count {
+		if err := tlsca.countValidCertificates(roots); err != nil {
+			return nil, err
+		}
+	}
+
+	return &pb.TLSCARoots{roots}, nil
+}
+
+// countValidCertificates counts the unexpired TLS certificates each of
+// roots has issued, telling them apart by their authority key identifier
+//
+func (tlsca *TLSCA) countValidCertificates(roots []*pb.TLSCARoot) error {
+	byKeyID := make(map[string]*pb.TLSCARoot)
+	for _, root := range roots {
+		cert, err := secp256k1.ParseCertificate(root.Cert.Cert)
+		if err != nil {
+			return err
+		}
+		byKeyID[string(cert.SubjectKeyId)] = root
+	}
+
+	rows, err := tlsca.db.Query("SELECT cert FROM Certificates")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			return err
+		}
+		cert, err := secp256k1.ParseCertificate(raw)
+		if err != nil {
+			Warning.Println("Failed parsing TLS certificate:", err)
+			continue
+		}
+		if root, ok := byKeyID[string(cert.AuthorityKeyId)]; ok && now.Before(cert.NotAfter) {
+			root.Valid++
+		}
+	}
+
+	return rows.Err()
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package ca
 
 import (
+	"bytes"
 	"io/ioutil"
 	"net"
 	"os"
@@ -36,6 +37,7 @@ import (
 	"path/filepath"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/crypto"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/hyperledger/fabric/core/crypto/utils"
 	"github.com/hyperledger/fabric/core/util"
@@ -183,3 +185,93 @@ func storeCert(alias string, der []byte, t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestTLSCARotation(t *testing.T) {
+	if err := crypto.Init(); err != nil {
+		t.Fatalf("Failed initializing the crypto layer [%s]", err)
+	}
+	LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	eca := NewECA()
+	defer cleanupFiles(eca.path)
+	defer eca.Close()
+	tlsca := NewTLSCA(eca)
+	tlscap, tlscaa := &TLSCAP{tlsca}, &TLSCAA{tlsca}
+
+	enroll := func(id string, role membersrvc.Role) *ecdsa.PrivateKey {
+		if _, err := eca.registerUserWithErollID(id, id, role); err != nil {
+			t.Fatalf("Failed registering user [%s]", err)
+		}
+		signKey, _ := newTestKey(t)
+		encKey, _ := newTestKey(t)
+		if _, _, _, err := eca.createCertificatePair(id, id, &signKey.PublicKey, &encKey.PublicKey); err != nil {
+			t.Fatalf("Failed creating certificate pair [%s]", err)
+		}
+		return signKey
+	}
+	adminKey := enroll("tlsca_admin", membersrvc.Role_AUDITOR)
+	clientKey := enroll("tlsca_client", membersrvc.Role_CLIENT)
+
+	issue := func() *x509.Certificate {
+		key, _ := newTestKey(t)
+		raw, err := tlsca.createCertificate("tls_peer", &key.PublicKey, x509.KeyUsageDigitalSignature, 0, nil)
+		if err != nil {
+			t.Fatalf("Failed creating TLS certificate [%s]", err)
+		}
+		cert, _ := x509.ParseCertificate(raw)
+		return cert
+	}
+	issue()
+	previous := tlsca.raw
+
+	req := &membersrvc.TLSCARotateReq{Id: &membersrvc.Identity{Id: "tlsca_client"}}
+	req.Sig = signTestRequest(t, clientKey, req)
+	if _, err := tlscaa.RotateCACertificate(nil, req); err == nil {
+		t.Fatal("Only admins must rotate the root of the TLSCA")
+	}
+
+	req = &membersrvc.TLSCARotateReq{Id: &membersrvc.Identity{Id: "tlsca_admin"}, Overlap: 3600}
+	req.Sig = signTestRequest(t, adminKey, req)
+	roots, err := tlscaa.RotateCACertificate(nil, req)
+	if err != nil {
+		t.Fatalf("Failed rotating the root of the TLSCA [%s]", err)
+	}
+	if len(roots.Roots) != 2 || bytes.Equal(roots.Roots[0].Cert.Cert, previous) || !bytes.Equal(roots.Roots[1].Cert.Cert, previous) {
+		t.Fatal("The previous root must be trusted along with the new one")
+	}
+	if retires := roots.Roots[1].Retires.Seconds - roots.Roots[1].Rotated.Seconds; retires != 3600 {
+		t.Fatalf("The previous root must retire after the overlap, got %d seconds", retires)
+	}
+	if roots.Roots[0].Valid != 0 || roots.Roots[1].Valid != 1 {
+		t.Fatalf("Unexpected TLS certificate counts %d and %d", roots.Roots[0].Valid, roots.Roots[1].Valid)
+	}
+
+	// TLS certificates are issued under the new root
+	root, _ := x509.ParseCertificate(roots.Roots[0].Cert.Cert)
+	if err := issue().CheckSignatureFrom(root); err != nil {
+		t.Fatalf("The TLS certificate must be issued under the new root [%s]", err)
+	}
+
+	// the new root survives a restart
+	tlsca.Close()
+	tlsca = NewTLSCA(eca)
+	defer tlsca.Close()
+	tlscap = &TLSCAP{tlsca}
+	if roots, err = tlscap.ReadCACertificates(nil, &membersrvc.Empty{}); err != nil {
+		t.Fatalf("Failed reading the roots of the TLSCA [%s]", err)
+	}
+	if len(roots.Roots) != 2 || !bytes.Equal(roots.Roots[0].Cert.Cert, root.Raw) || roots.Roots[0].Valid != 0 {
+		t.Fatal("The TLSCA must restart with the new root")
+	}
+
+	// the previous root is not trusted anymore after the overlap
+	if _, err := tlsca.db.Exec("UPDATE RetiredRoots SET retires=?", time.Now().Unix()-1); err != nil {
+		t.Fatal(err)
+	}
+	if roots, err = tlscap.ReadCACertificates(nil, &membersrvc.Empty{}); err != nil {
+		t.Fatalf("Failed reading the roots of the TLSCA [%s]", err)
+	}
+	if len(roots.Roots) != 1 {
+		t.Fatalf("Expected 1 root, got %d", len(roots.Roots))
+	}
+}
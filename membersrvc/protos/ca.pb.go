@@ -18,14 +18,25 @@ It has these top-level messages:
 	PrivateKey
 	Signature
 	RegisterUserReq
+	RegisterUserSetReq
+	RegisterUserResult
+	RegisterUserSetResp
 	ReadUserSetReq
 	User
 	UserSet
 	ECertCreateReq
 	ECertCreateResp
+	ECertCSRReq
+	ECertRotateReq
 	ECertReadReq
 	ECertRevokeReq
+	ECertRevokeUserReq
 	ECertCRLReq
+	AuditLogReadReq
+	AuditRecord
+	AuditLog
+	EscrowKeysExportReq
+	EscrowKeys
 	TCertCreateReq
 	TCertCreateResp
 	TCertCreateSetReq
@@ -37,15 +48,25 @@ It has these top-level messages:
 	TCertRevokeReq
 	TCertRevokeSetReq
 	TCertCRLReq
+	TCertAttributeKeyRotateReq
+	TCertAttributeKeysReq
+	TCertAttributeKey
+	TCertAttributeKeys
 	TLSCertCreateReq
 	TLSCertCreateResp
 	TLSCertReadReq
 	TLSCertRevokeReq
+	TLSCARotateReq
+	TLSCARotationReq
+	TLSCARoot
+	TLSCARoots
 	Cert
 	TCert
 	CertSet
 	CertSets
+	CRL
 	CertPair
+	CAHealth
 */
 package protos
 
@@ -69,20 +90,23 @@ var _ = math.Inf
 type CryptoType int32
 
 const (
-	CryptoType_ECDSA CryptoType = 0
-	CryptoType_RSA   CryptoType = 1
-	CryptoType_DSA   CryptoType = 2
+	CryptoType_ECDSA   CryptoType = 0
+	CryptoType_RSA     CryptoType = 1
+	CryptoType_DSA     CryptoType = 2
+	CryptoType_ED25519 CryptoType = 3
 )
 
 var CryptoType_name = map[int32]string{
 	0: "ECDSA",
 	1: "RSA",
 	2: "DSA",
+	3: "ED25519",
 }
 var CryptoType_value = map[string]int32{
-	"ECDSA": 0,
-	"RSA":   1,
-	"DSA":   2,
+	"ECDSA":   0,
+	"RSA":     1,
+	"DSA":     2,
+	"ED25519": 3,
 }
 
 func (x CryptoType) String() string {
@@ -143,6 +167,26 @@ func (x CAStatus_StatusCode) String() string {
 	return proto.EnumName(CAStatus_StatusCode_name, int32(x))
 }
 
+type CAHealth_Status int32
+
+const (
+	CAHealth_SERVING     CAHealth_Status = 0
+	CAHealth_NOT_SERVING CAHealth_Status = 1
+)
+
+var CAHealth_Status_name = map[int32]string{
+	0: "SERVING",
+	1: "NOT_SERVING",
+}
+var CAHealth_Status_value = map[string]int32{
+	"SERVING":     0,
+	"NOT_SERVING": 1,
+}
+
+func (x CAHealth_Status) String() string {
+	return proto.EnumName(CAHealth_Status_name, int32(x))
+}
+
 // Status codes shared by both CAs.
 //
 type CAStatus struct {
@@ -219,10 +263,12 @@ func (m *Signature) String() string { return proto.CompactTextString(m) }
 func (*Signature) ProtoMessage()    {}
 
 type RegisterUserReq struct {
-	Id          *Identity `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
-	Role        Role      `protobuf:"varint,2,opt,name=role,enum=protos.Role" json:"role,omitempty"`
-	Account     string    `protobuf:"bytes,3,opt,name=account" json:"account,omitempty"`
-	Affiliation string    `protobuf:"bytes,4,opt,name=affiliation" json:"affiliation,omitempty"`
+	Id             *Identity                  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Role           Role                       `protobuf:"varint,2,opt,name=role,enum=protos.Role" json:"role,omitempty"`
+	Account        string                     `protobuf:"bytes,3,opt,name=account" json:"account,omitempty"`
+	Affiliation    string                     `protobuf:"bytes,4,opt,name=affiliation" json:"affiliation,omitempty"`
+	MaxEnrollments int32                      `protobuf:"varint,5,opt,name=maxEnrollments" json:"maxEnrollments,omitempty"`
+	Expires        *google_protobuf.Timestamp `protobuf:"bytes,6,opt,name=expires" json:"expires,omitempty"`
 }
 
 func (m *RegisterUserReq) Reset()         { *m = RegisterUserReq{} }
@@ -236,6 +282,83 @@ func (m *RegisterUserReq) GetId() *Identity {
 	return nil
 }
 
+func (m *RegisterUserReq) GetExpires() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Expires
+	}
+	return nil
+}
+
+type RegisterUserSetReq struct {
+	Id    *Identity          `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Users []*RegisterUserReq `protobuf:"bytes,2,rep,name=users" json:"users,omitempty"`
+	Sig   *Signature         `protobuf:"bytes,3,opt,name=sig" json:"sig,omitempty"`
+}
+
+func (m *RegisterUserSetReq) Reset()         { *m = RegisterUserSetReq{} }
+func (m *RegisterUserSetReq) String() string { return proto.CompactTextString(m) }
+func (*RegisterUserSetReq) ProtoMessage()    {}
+
+func (m *RegisterUserSetReq) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *RegisterUserSetReq) GetUsers() []*RegisterUserReq {
+	if m != nil {
+		return m.Users
+	}
+	return nil
+}
+
+func (m *RegisterUserSetReq) GetSig() *Signature {
+	if m != nil {
+		return m.Sig
+	}
+	return nil
+}
+
+type RegisterUserResult struct {
+	Id    *Identity `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Tok   *Token    `protobuf:"bytes,2,opt,name=tok" json:"tok,omitempty"`
+	Error string    `protobuf:"bytes,3,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *RegisterUserResult) Reset()         { *m = RegisterUserResult{} }
+func (m *RegisterUserResult) String() string { return proto.CompactTextString(m) }
+func (*RegisterUserResult) ProtoMessage()    {}
+
+func (m *RegisterUserResult) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *RegisterUserResult) GetTok() *Token {
+	if m != nil {
+		return m.Tok
+	}
+	return nil
+}
+
+type RegisterUserSetResp struct {
+	Results []*RegisterUserResult `protobuf:"bytes,1,rep,name=results" json:"results,omitempty"`
+}
+
+func (m *RegisterUserSetResp) Reset()         { *m = RegisterUserSetResp{} }
+func (m *RegisterUserSetResp) String() string { return proto.CompactTextString(m) }
+func (*RegisterUserSetResp) ProtoMessage()    {}
+
+func (m *RegisterUserSetResp) GetResults() []*RegisterUserResult {
+	if m != nil {
+		return m.Results
+	}
+	return nil
+}
+
 type ReadUserSetReq struct {
 	Req  *Identity  `protobuf:"bytes,1,opt,name=req" json:"req,omitempty"`
 	Role Role       `protobuf:"varint,2,opt,name=role,enum=protos.Role" json:"role,omitempty"`
@@ -380,6 +503,95 @@ func (m *ECertCreateResp) GetTok() *Token {
 	return nil
 }
 
+type ECertCSRReq struct {
+	Ts   *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=ts" json:"ts,omitempty"`
+	Id   *Identity                  `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
+	Tok  *Token                     `protobuf:"bytes,3,opt,name=tok" json:"tok,omitempty"`
+	Sign []byte                     `protobuf:"bytes,4,opt,name=sign,proto3" json:"sign,omitempty"`
+	Enc  []byte                     `protobuf:"bytes,5,opt,name=enc,proto3" json:"enc,omitempty"`
+}
+
+func (m *ECertCSRReq) Reset()         { *m = ECertCSRReq{} }
+func (m *ECertCSRReq) String() string { return proto.CompactTextString(m) }
+func (*ECertCSRReq) ProtoMessage()    {}
+
+func (m *ECertCSRReq) GetTs() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Ts
+	}
+	return nil
+}
+
+func (m *ECertCSRReq) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *ECertCSRReq) GetTok() *Token {
+	if m != nil {
+		return m.Tok
+	}
+	return nil
+}
+
+type ECertRotateReq struct {
+	Ts   *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=ts" json:"ts,omitempty"`
+	Id   *Identity                  `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
+	Cert []byte                     `protobuf:"bytes,3,opt,name=cert,proto3" json:"cert,omitempty"`
+	Sign *PublicKey                 `protobuf:"bytes,4,opt,name=sign" json:"sign,omitempty"`
+	Enc  *PublicKey                 `protobuf:"bytes,5,opt,name=enc" json:"enc,omitempty"`
+	Pop  *Signature                 `protobuf:"bytes,6,opt,name=pop" json:"pop,omitempty"`
+	Sig  *Signature                 `protobuf:"bytes,7,opt,name=sig" json:"sig,omitempty"`
+}
+
+func (m *ECertRotateReq) Reset()         { *m = ECertRotateReq{} }
+func (m *ECertRotateReq) String() string { return proto.CompactTextString(m) }
+func (*ECertRotateReq) ProtoMessage()    {}
+
+func (m *ECertRotateReq) GetTs() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Ts
+	}
+	return nil
+}
+
+func (m *ECertRotateReq) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *ECertRotateReq) GetSign() *PublicKey {
+	if m != nil {
+		return m.Sign
+	}
+	return nil
+}
+
+func (m *ECertRotateReq) GetEnc() *PublicKey {
+	if m != nil {
+		return m.Enc
+	}
+	return nil
+}
+
+func (m *ECertRotateReq) GetPop() *Signature {
+	if m != nil {
+		return m.Pop
+	}
+	return nil
+}
+
+func (m *ECertRotateReq) GetSig() *Signature {
+	if m != nil {
+		return m.Sig
+	}
+	return nil
+}
+
 type ECertReadReq struct {
 	Id *Identity `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
 }
@@ -426,6 +638,37 @@ func (m *ECertRevokeReq) GetSig() *Signature {
 	return nil
 }
 
+type ECertRevokeUserReq struct {
+	Id   *Identity  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	User *Identity  `protobuf:"bytes,2,opt,name=user" json:"user,omitempty"`
+	Sig  *Signature `protobuf:"bytes,3,opt,name=sig" json:"sig,omitempty"`
+}
+
+func (m *ECertRevokeUserReq) Reset()         { *m = ECertRevokeUserReq{} }
+func (m *ECertRevokeUserReq) String() string { return proto.CompactTextString(m) }
+func (*ECertRevokeUserReq) ProtoMessage()    {}
+
+func (m *ECertRevokeUserReq) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *ECertRevokeUserReq) GetUser() *Identity {
+	if m != nil {
+		return m.User
+	}
+	return nil
+}
+
+func (m *ECertRevokeUserReq) GetSig() *Signature {
+	if m != nil {
+		return m.Sig
+	}
+	return nil
+}
+
 type ECertCRLReq struct {
 	Id  *Identity  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
 	Sig *Signature `protobuf:"bytes,2,opt,name=sig" json:"sig,omitempty"`
@@ -449,6 +692,145 @@ func (m *ECertCRLReq) GetSig() *Signature {
 	return nil
 }
 
+type AuditLogReadReq struct {
+	Id        *Identity                  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	After     uint64                     `protobuf:"varint,2,opt,name=after" json:"after,omitempty"`
+	Max       uint32                     `protobuf:"varint,3,opt,name=max" json:"max,omitempty"`
+	Begin     *google_protobuf.Timestamp `protobuf:"bytes,4,opt,name=begin" json:"begin,omitempty"`
+	End       *google_protobuf.Timestamp `protobuf:"bytes,5,opt,name=end" json:"end,omitempty"`
+	Requester string                     `protobuf:"bytes,6,opt,name=requester" json:"requester,omitempty"`
+	Operation string                     `protobuf:"bytes,7,opt,name=operation" json:"operation,omitempty"`
+	Subject   string                     `protobuf:"bytes,8,opt,name=subject" json:"subject,omitempty"`
+	Verify    bool                       `protobuf:"varint,9,opt,name=verify" json:"verify,omitempty"`
+	Sig       *Signature                 `protobuf:"bytes,10,opt,name=sig" json:"sig,omitempty"`
+}
+
+func (m *AuditLogReadReq) Reset()         { *m = AuditLogReadReq{} }
+func (m *AuditLogReadReq) String() string { return proto.CompactTextString(m) }
+func (*AuditLogReadReq) ProtoMessage()    {}
+
+func (m *AuditLogReadReq) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *AuditLogReadReq) GetBegin() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Begin
+	}
+	return nil
+}
+
+func (m *AuditLogReadReq) GetEnd() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.End
+	}
+	return nil
+}
+
+func (m *AuditLogReadReq) GetSig() *Signature {
+	if m != nil {
+		return m.Sig
+	}
+	return nil
+}
+
+type AuditRecord struct {
+	Seq       uint64                     `protobuf:"varint,1,opt,name=seq" json:"seq,omitempty"`
+	Ts        *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=ts" json:"ts,omitempty"`
+	Requester string                     `protobuf:"bytes,3,opt,name=requester" json:"requester,omitempty"`
+	Operation string                     `protobuf:"bytes,4,opt,name=operation" json:"operation,omitempty"`
+	Subject   string                     `protobuf:"bytes,5,opt,name=subject" json:"subject,omitempty"`
+	Details   string                     `protobuf:"bytes,6,opt,name=details" json:"details,omitempty"`
+	Hash      []byte                     `protobuf:"bytes,7,opt,name=hash,proto3" json:"hash,omitempty"`
+}
+
+func (m *AuditRecord) Reset()         { *m = AuditRecord{} }
+func (m *AuditRecord) String() string { return proto.CompactTextString(m) }
+func (*AuditRecord) ProtoMessage()    {}
+
+func (m *AuditRecord) GetTs() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Ts
+	}
+	return nil
+}
+
+type AuditLog struct {
+	Records []*AuditRecord `protobuf:"bytes,1,rep,name=records" json:"records,omitempty"`
+	Prev    []byte         `protobuf:"bytes,2,opt,name=prev,proto3" json:"prev,omitempty"`
+	Broken  uint64         `protobuf:"varint,3,opt,name=broken" json:"broken,omitempty"`
+}
+
+func (m *AuditLog) Reset()         { *m = AuditLog{} }
+func (m *AuditLog) String() string { return proto.CompactTextString(m) }
+func (*AuditLog) ProtoMessage()    {}
+
+func (m *AuditLog) GetRecords() []*AuditRecord {
+	if m != nil {
+		return m.Records
+	}
+	return nil
+}
+
+type EscrowKeysExportReq struct {
+	Ts      *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=ts" json:"ts,omitempty"`
+	Id      *Identity                  `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
+	Auditor *Identity                  `protobuf:"bytes,3,opt,name=auditor" json:"auditor,omitempty"`
+	Sig     *Signature                 `protobuf:"bytes,4,opt,name=sig" json:"sig,omitempty"`
+}
+
+func (m *EscrowKeysExportReq) Reset()         { *m = EscrowKeysExportReq{} }
+func (m *EscrowKeysExportReq) String() string { return proto.CompactTextString(m) }
+func (*EscrowKeysExportReq) ProtoMessage()    {}
+
+func (m *EscrowKeysExportReq) GetTs() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Ts
+	}
+	return nil
+}
+
+func (m *EscrowKeysExportReq) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *EscrowKeysExportReq) GetAuditor() *Identity {
+	if m != nil {
+		return m.Auditor
+	}
+	return nil
+}
+
+func (m *EscrowKeysExportReq) GetSig() *Signature {
+	if m != nil {
+		return m.Sig
+	}
+	return nil
+}
+
+type EscrowKeys struct {
+	Enc     *Cert  `protobuf:"bytes,1,opt,name=enc" json:"enc,omitempty"`
+	Chain   []byte `protobuf:"bytes,2,opt,name=chain,proto3" json:"chain,omitempty"`
+	Pkchain []byte `protobuf:"bytes,3,opt,name=pkchain,proto3" json:"pkchain,omitempty"`
+}
+
+func (m *EscrowKeys) Reset()         { *m = EscrowKeys{} }
+func (m *EscrowKeys) String() string { return proto.CompactTextString(m) }
+func (*EscrowKeys) ProtoMessage()    {}
+
+func (m *EscrowKeys) GetEnc() *Cert {
+	if m != nil {
+		return m.Enc
+	}
+	return nil
+}
+
 type TCertCreateReq struct {
 	Ts  *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=ts" json:"ts,omitempty"`
 	Id  *Identity                  `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
@@ -509,6 +891,7 @@ type TCertCreateSetReq struct {
 	Num        uint32                     `protobuf:"varint,3,opt,name=num" json:"num,omitempty"`
 	Attributes []*TCertAttribute          `protobuf:"bytes,4,rep,name=attributes" json:"attributes,omitempty"`
 	Sig        *Signature                 `protobuf:"bytes,5,opt,name=sig" json:"sig,omitempty"`
+	DualKey    bool                       `protobuf:"varint,6,opt,name=dualKey" json:"dualKey,omitempty"`
 }
 
 func (m *TCertCreateSetReq) Reset()         { *m = TCertCreateSetReq{} }
@@ -729,6 +1112,7 @@ type TCertRevokeSetReq struct {
 	Id  *Identity                  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
 	Ts  *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=ts" json:"ts,omitempty"`
 	Sig *Signature                 `protobuf:"bytes,3,opt,name=sig" json:"sig,omitempty"`
+	Key []byte                     `protobuf:"bytes,4,opt,name=key,proto3" json:"key,omitempty"`
 }
 
 func (m *TCertRevokeSetReq) Reset()         { *m = TCertRevokeSetReq{} }
@@ -779,6 +1163,78 @@ func (m *TCertCRLReq) GetSig() *Signature {
 	return nil
 }
 
+type TCertAttributeKeyRotateReq struct {
+	Id  *Identity  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Sig *Signature `protobuf:"bytes,2,opt,name=sig" json:"sig,omitempty"`
+}
+
+func (m *TCertAttributeKeyRotateReq) Reset()         { *m = TCertAttributeKeyRotateReq{} }
+func (m *TCertAttributeKeyRotateReq) String() string { return proto.CompactTextString(m) }
+func (*TCertAttributeKeyRotateReq) ProtoMessage()    {}
+
+func (m *TCertAttributeKeyRotateReq) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *TCertAttributeKeyRotateReq) GetSig() *Signature {
+	if m != nil {
+		return m.Sig
+	}
+	return nil
+}
+
+type TCertAttributeKeysReq struct {
+	Id          *Identity  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Affiliation string     `protobuf:"bytes,2,opt,name=affiliation" json:"affiliation,omitempty"`
+	Sig         *Signature `protobuf:"bytes,3,opt,name=sig" json:"sig,omitempty"`
+}
+
+func (m *TCertAttributeKeysReq) Reset()         { *m = TCertAttributeKeysReq{} }
+func (m *TCertAttributeKeysReq) String() string { return proto.CompactTextString(m) }
+func (*TCertAttributeKeysReq) ProtoMessage()    {}
+
+func (m *TCertAttributeKeysReq) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *TCertAttributeKeysReq) GetSig() *Signature {
+	if m != nil {
+		return m.Sig
+	}
+	return nil
+}
+
+type TCertAttributeKey struct {
+	Id  uint32 `protobuf:"varint,1,opt,name=id" json:"id,omitempty"`
+	Key []byte `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+}
+
+func (m *TCertAttributeKey) Reset()         { *m = TCertAttributeKey{} }
+func (m *TCertAttributeKey) String() string { return proto.CompactTextString(m) }
+func (*TCertAttributeKey) ProtoMessage()    {}
+
+type TCertAttributeKeys struct {
+	Current uint32               `protobuf:"varint,1,opt,name=current" json:"current,omitempty"`
+	Keys    []*TCertAttributeKey `protobuf:"bytes,2,rep,name=keys" json:"keys,omitempty"`
+}
+
+func (m *TCertAttributeKeys) Reset()         { *m = TCertAttributeKeys{} }
+func (m *TCertAttributeKeys) String() string { return proto.CompactTextString(m) }
+func (*TCertAttributeKeys) ProtoMessage()    {}
+
+func (m *TCertAttributeKeys) GetKeys() []*TCertAttributeKey {
+	if m != nil {
+		return m.Keys
+	}
+	return nil
+}
+
 type TLSCertCreateReq struct {
 	Ts  *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=ts" json:"ts,omitempty"`
 	Id  *Identity                  `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
@@ -887,6 +1343,100 @@ func (m *TLSCertRevokeReq) GetSig() *Signature {
 	return nil
 }
 
+type TLSCARotateReq struct {
+	Id      *Identity  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Overlap int64      `protobuf:"varint,2,opt,name=overlap" json:"overlap,omitempty"`
+	Sig     *Signature `protobuf:"bytes,3,opt,name=sig" json:"sig,omitempty"`
+}
+
+func (m *TLSCARotateReq) Reset()         { *m = TLSCARotateReq{} }
+func (m *TLSCARotateReq) String() string { return proto.CompactTextString(m) }
+func (*TLSCARotateReq) ProtoMessage()    {}
+
+func (m *TLSCARotateReq) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *TLSCARotateReq) GetSig() *Signature {
+	if m != nil {
+		return m.Sig
+	}
+	return nil
+}
+
+type TLSCARotationReq struct {
+	Id  *Identity  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Sig *Signature `protobuf:"bytes,2,opt,name=sig" json:"sig,omitempty"`
+}
+
+func (m *TLSCARotationReq) Reset()         { *m = TLSCARotationReq{} }
+func (m *TLSCARotationReq) String() string { return proto.CompactTextString(m) }
+func (*TLSCARotationReq) ProtoMessage()    {}
+
+func (m *TLSCARotationReq) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *TLSCARotationReq) GetSig() *Signature {
+	if m != nil {
+		return m.Sig
+	}
+	return nil
+}
+
+type TLSCARoot struct {
+	Cert    *Cert                      `protobuf:"bytes,1,opt,name=cert" json:"cert,omitempty"`
+	Rotated *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=rotated" json:"rotated,omitempty"`
+	Retires *google_protobuf.Timestamp `protobuf:"bytes,3,opt,name=retires" json:"retires,omitempty"`
+	Valid   uint64                     `protobuf:"varint,4,opt,name=valid" json:"valid,omitempty"`
+}
+
+func (m *TLSCARoot) Reset()         { *m = TLSCARoot{} }
+func (m *TLSCARoot) String() string { return proto.CompactTextString(m) }
+func (*TLSCARoot) ProtoMessage()    {}
+
+func (m *TLSCARoot) GetCert() *Cert {
+	if m != nil {
+		return m.Cert
+	}
+	return nil
+}
+
+func (m *TLSCARoot) GetRotated() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Rotated
+	}
+	return nil
+}
+
+func (m *TLSCARoot) GetRetires() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Retires
+	}
+	return nil
+}
+
+type TLSCARoots struct {
+	Roots []*TLSCARoot `protobuf:"bytes,1,rep,name=roots" json:"roots,omitempty"`
+}
+
+func (m *TLSCARoots) Reset()         { *m = TLSCARoots{} }
+func (m *TLSCARoots) String() string { return proto.CompactTextString(m) }
+func (*TLSCARoots) ProtoMessage()    {}
+
+func (m *TLSCARoots) GetRoots() []*TLSCARoot {
+	if m != nil {
+		return m.Roots
+	}
+	return nil
+}
+
 // Certificate issued by either the ECA or TCA.
 //
 type Cert struct {
@@ -962,6 +1512,14 @@ func (m *CertSets) GetSets() []*CertSet {
 	return nil
 }
 
+type CRL struct {
+	Crl []byte `protobuf:"bytes,1,opt,name=crl,proto3" json:"crl,omitempty"`
+}
+
+func (m *CRL) Reset()         { *m = CRL{} }
+func (m *CRL) String() string { return proto.CompactTextString(m) }
+func (*CRL) ProtoMessage()    {}
+
 type CertPair struct {
 	Sign []byte `protobuf:"bytes,1,opt,name=sign,proto3" json:"sign,omitempty"`
 	Enc  []byte `protobuf:"bytes,2,opt,name=enc,proto3" json:"enc,omitempty"`
@@ -971,10 +1529,23 @@ func (m *CertPair) Reset()         { *m = CertPair{} }
 func (m *CertPair) String() string { return proto.CompactTextString(m) }
 func (*CertPair) ProtoMessage()    {}
 
+// Health of the CAs, NOT_SERVING with the reason when a database cannot be
+// reached.
+//
+type CAHealth struct {
+	Status CAHealth_Status `protobuf:"varint,1,opt,name=status,enum=protos.CAHealth_Status" json:"status,omitempty"`
+	Reason string          `protobuf:"bytes,2,opt,name=reason" json:"reason,omitempty"`
+}
+
+func (m *CAHealth) Reset()         { *m = CAHealth{} }
+func (m *CAHealth) String() string { return proto.CompactTextString(m) }
+func (*CAHealth) ProtoMessage()    {}
+
 func init() {
 	proto.RegisterEnum("protos.CryptoType", CryptoType_name, CryptoType_value)
 	proto.RegisterEnum("protos.Role", Role_name, Role_value)
 	proto.RegisterEnum("protos.CAStatus_StatusCode", CAStatus_StatusCode_name, CAStatus_StatusCode_value)
+	proto.RegisterEnum("protos.CAHealth_Status", CAHealth_Status_name, CAHealth_Status_value)
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -989,6 +1560,10 @@ type ECAPClient interface {
 	ReadCertificatePair(ctx context.Context, in *ECertReadReq, opts ...grpc.CallOption) (*CertPair, error)
 	ReadCertificateByHash(ctx context.Context, in *Hash, opts ...grpc.CallOption) (*Cert, error)
 	RevokeCertificatePair(ctx context.Context, in *ECertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error)
+	RotateCertificatePair(ctx context.Context, in *ECertRotateReq, opts ...grpc.CallOption) (*ECertCreateResp, error)
+	ReadCRL(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CRL, error)
+	CreateCertificatePairFromCSR(ctx context.Context, in *ECertCSRReq, opts ...grpc.CallOption) (*ECertCreateResp, error)
+	CheckHealth(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CAHealth, error)
 }
 
 type eCAPClient struct {
@@ -1035,9 +1610,45 @@ func (c *eCAPClient) ReadCertificateByHash(ctx context.Context, in *Hash, opts .
 	return out, nil
 }
 
-func (c *eCAPClient) RevokeCertificatePair(ctx context.Context, in *ECertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error) {
-	out := new(CAStatus)
-	err := grpc.Invoke(ctx, "/protos.ECAP/RevokeCertificatePair", in, out, c.cc, opts...)
+func (c *eCAPClient) RevokeCertificatePair(ctx context.Context, in *ECertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error) {
+	out := new(CAStatus)
+	err := grpc.Invoke(ctx, "/protos.ECAP/RevokeCertificatePair", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eCAPClient) RotateCertificatePair(ctx context.Context, in *ECertRotateReq, opts ...grpc.CallOption) (*ECertCreateResp, error) {
+	out := new(ECertCreateResp)
+	err := grpc.Invoke(ctx, "/protos.ECAP/RotateCertificatePair", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eCAPClient) ReadCRL(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CRL, error) {
+	out := new(CRL)
+	err := grpc.Invoke(ctx, "/protos.ECAP/ReadCRL", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eCAPClient) CreateCertificatePairFromCSR(ctx context.Context, in *ECertCSRReq, opts ...grpc.CallOption) (*ECertCreateResp, error) {
+	out := new(ECertCreateResp)
+	err := grpc.Invoke(ctx, "/protos.ECAP/CreateCertificatePairFromCSR", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eCAPClient) CheckHealth(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CAHealth, error) {
+	out := new(CAHealth)
+	err := grpc.Invoke(ctx, "/protos.ECAP/CheckHealth", in, out, c.cc, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -1052,6 +1663,10 @@ type ECAPServer interface {
 	ReadCertificatePair(context.Context, *ECertReadReq) (*CertPair, error)
 	ReadCertificateByHash(context.Context, *Hash) (*Cert, error)
 	RevokeCertificatePair(context.Context, *ECertRevokeReq) (*CAStatus, error)
+	RotateCertificatePair(context.Context, *ECertRotateReq) (*ECertCreateResp, error)
+	ReadCRL(context.Context, *Empty) (*CRL, error)
+	CreateCertificatePairFromCSR(context.Context, *ECertCSRReq) (*ECertCreateResp, error)
+	CheckHealth(context.Context, *Empty) (*CAHealth, error)
 }
 
 func RegisterECAPServer(s *grpc.Server, srv ECAPServer) {
@@ -1118,6 +1733,54 @@ func _ECAP_RevokeCertificatePair_Handler(srv interface{}, ctx context.Context, d
 	return out, nil
 }
 
+func _ECAP_RotateCertificatePair_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ECertRotateReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAPServer).RotateCertificatePair(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _ECAP_ReadCRL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAPServer).ReadCRL(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _ECAP_CreateCertificatePairFromCSR_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ECertCSRReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAPServer).CreateCertificatePairFromCSR(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _ECAP_CheckHealth_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAPServer).CheckHealth(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 var _ECAP_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "protos.ECAP",
 	HandlerType: (*ECAPServer)(nil),
@@ -1142,6 +1805,22 @@ var _ECAP_serviceDesc = grpc.ServiceDesc{
 			MethodName: "RevokeCertificatePair",
 			Handler:    _ECAP_RevokeCertificatePair_Handler,
 		},
+		{
+			MethodName: "RotateCertificatePair",
+			Handler:    _ECAP_RotateCertificatePair_Handler,
+		},
+		{
+			MethodName: "ReadCRL",
+			Handler:    _ECAP_ReadCRL_Handler,
+		},
+		{
+			MethodName: "CreateCertificatePairFromCSR",
+			Handler:    _ECAP_CreateCertificatePairFromCSR_Handler,
+		},
+		{
+			MethodName: "CheckHealth",
+			Handler:    _ECAP_CheckHealth_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{},
 }
@@ -1150,9 +1829,13 @@ var _ECAP_serviceDesc = grpc.ServiceDesc{
 
 type ECAAClient interface {
 	RegisterUser(ctx context.Context, in *RegisterUserReq, opts ...grpc.CallOption) (*Token, error)
+	RegisterUserSet(ctx context.Context, in *RegisterUserSetReq, opts ...grpc.CallOption) (*RegisterUserSetResp, error)
 	ReadUserSet(ctx context.Context, in *ReadUserSetReq, opts ...grpc.CallOption) (*UserSet, error)
 	RevokeCertificate(ctx context.Context, in *ECertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error)
+	RevokeUser(ctx context.Context, in *ECertRevokeUserReq, opts ...grpc.CallOption) (*CAStatus, error)
 	PublishCRL(ctx context.Context, in *ECertCRLReq, opts ...grpc.CallOption) (*CAStatus, error)
+	ReadAuditLog(ctx context.Context, in *AuditLogReadReq, opts ...grpc.CallOption) (*AuditLog, error)
+	ExportEscrowKeys(ctx context.Context, in *EscrowKeysExportReq, opts ...grpc.CallOption) (*EscrowKeys, error)
 }
 
 type eCAAClient struct {
@@ -1172,6 +1855,15 @@ func (c *eCAAClient) RegisterUser(ctx context.Context, in *RegisterUserReq, opts
 	return out, nil
 }
 
+func (c *eCAAClient) RegisterUserSet(ctx context.Context, in *RegisterUserSetReq, opts ...grpc.CallOption) (*RegisterUserSetResp, error) {
+	out := new(RegisterUserSetResp)
+	err := grpc.Invoke(ctx, "/protos.ECAA/RegisterUserSet", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *eCAAClient) ReadUserSet(ctx context.Context, in *ReadUserSetReq, opts ...grpc.CallOption) (*UserSet, error) {
 	out := new(UserSet)
 	err := grpc.Invoke(ctx, "/protos.ECAA/ReadUserSet", in, out, c.cc, opts...)
@@ -1190,6 +1882,15 @@ func (c *eCAAClient) RevokeCertificate(ctx context.Context, in *ECertRevokeReq,
 	return out, nil
 }
 
+func (c *eCAAClient) RevokeUser(ctx context.Context, in *ECertRevokeUserReq, opts ...grpc.CallOption) (*CAStatus, error) {
+	out := new(CAStatus)
+	err := grpc.Invoke(ctx, "/protos.ECAA/RevokeUser", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *eCAAClient) PublishCRL(ctx context.Context, in *ECertCRLReq, opts ...grpc.CallOption) (*CAStatus, error) {
 	out := new(CAStatus)
 	err := grpc.Invoke(ctx, "/protos.ECAA/PublishCRL", in, out, c.cc, opts...)
@@ -1199,13 +1900,35 @@ func (c *eCAAClient) PublishCRL(ctx context.Context, in *ECertCRLReq, opts ...gr
 	return out, nil
 }
 
+func (c *eCAAClient) ReadAuditLog(ctx context.Context, in *AuditLogReadReq, opts ...grpc.CallOption) (*AuditLog, error) {
+	out := new(AuditLog)
+	err := grpc.Invoke(ctx, "/protos.ECAA/ReadAuditLog", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eCAAClient) ExportEscrowKeys(ctx context.Context, in *EscrowKeysExportReq, opts ...grpc.CallOption) (*EscrowKeys, error) {
+	out := new(EscrowKeys)
+	err := grpc.Invoke(ctx, "/protos.ECAA/ExportEscrowKeys", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for ECAA service
 
 type ECAAServer interface {
 	RegisterUser(context.Context, *RegisterUserReq) (*Token, error)
+	RegisterUserSet(context.Context, *RegisterUserSetReq) (*RegisterUserSetResp, error)
 	ReadUserSet(context.Context, *ReadUserSetReq) (*UserSet, error)
 	RevokeCertificate(context.Context, *ECertRevokeReq) (*CAStatus, error)
+	RevokeUser(context.Context, *ECertRevokeUserReq) (*CAStatus, error)
 	PublishCRL(context.Context, *ECertCRLReq) (*CAStatus, error)
+	ReadAuditLog(context.Context, *AuditLogReadReq) (*AuditLog, error)
+	ExportEscrowKeys(context.Context, *EscrowKeysExportReq) (*EscrowKeys, error)
 }
 
 func RegisterECAAServer(s *grpc.Server, srv ECAAServer) {
@@ -1224,6 +1947,18 @@ func _ECAA_RegisterUser_Handler(srv interface{}, ctx context.Context, dec func(i
 	return out, nil
 }
 
+func _ECAA_RegisterUserSet_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(RegisterUserSetReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAAServer).RegisterUserSet(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func _ECAA_ReadUserSet_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
 	in := new(ReadUserSetReq)
 	if err := dec(in); err != nil {
@@ -1248,6 +1983,18 @@ func _ECAA_RevokeCertificate_Handler(srv interface{}, ctx context.Context, dec f
 	return out, nil
 }
 
+func _ECAA_RevokeUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ECertRevokeUserReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAAServer).RevokeUser(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func _ECAA_PublishCRL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
 	in := new(ECertCRLReq)
 	if err := dec(in); err != nil {
@@ -1260,6 +2007,30 @@ func _ECAA_PublishCRL_Handler(srv interface{}, ctx context.Context, dec func(int
 	return out, nil
 }
 
+func _ECAA_ReadAuditLog_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(AuditLogReadReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAAServer).ReadAuditLog(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _ECAA_ExportEscrowKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(EscrowKeysExportReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAAServer).ExportEscrowKeys(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 var _ECAA_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "protos.ECAA",
 	HandlerType: (*ECAAServer)(nil),
@@ -1268,6 +2039,10 @@ var _ECAA_serviceDesc = grpc.ServiceDesc{
 			MethodName: "RegisterUser",
 			Handler:    _ECAA_RegisterUser_Handler,
 		},
+		{
+			MethodName: "RegisterUserSet",
+			Handler:    _ECAA_RegisterUserSet_Handler,
+		},
 		{
 			MethodName: "ReadUserSet",
 			Handler:    _ECAA_ReadUserSet_Handler,
@@ -1276,10 +2051,22 @@ var _ECAA_serviceDesc = grpc.ServiceDesc{
 			MethodName: "RevokeCertificate",
 			Handler:    _ECAA_RevokeCertificate_Handler,
 		},
+		{
+			MethodName: "RevokeUser",
+			Handler:    _ECAA_RevokeUser_Handler,
+		},
 		{
 			MethodName: "PublishCRL",
 			Handler:    _ECAA_PublishCRL_Handler,
 		},
+		{
+			MethodName: "ReadAuditLog",
+			Handler:    _ECAA_ReadAuditLog_Handler,
+		},
+		{
+			MethodName: "ExportEscrowKeys",
+			Handler:    _ECAA_ExportEscrowKeys_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{},
 }
@@ -1293,6 +2080,8 @@ type TCAPClient interface {
 	ReadCertificateSet(ctx context.Context, in *TCertReadSetReq, opts ...grpc.CallOption) (*CertSet, error)
 	RevokeCertificate(ctx context.Context, in *TCertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error)
 	RevokeCertificateSet(ctx context.Context, in *TCertRevokeSetReq, opts ...grpc.CallOption) (*CAStatus, error)
+	ReadCRL(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CRL, error)
+	CreateCertificateSetStream(ctx context.Context, in *TCertCreateSetReq, opts ...grpc.CallOption) (TCAP_CreateCertificateSetStreamClient, error)
 }
 
 type tCAPClient struct {
@@ -1357,6 +2146,47 @@ func (c *tCAPClient) RevokeCertificateSet(ctx context.Context, in *TCertRevokeSe
 	return out, nil
 }
 
+func (c *tCAPClient) ReadCRL(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CRL, error) {
+	out := new(CRL)
+	err := grpc.Invoke(ctx, "/protos.TCAP/ReadCRL", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tCAPClient) CreateCertificateSetStream(ctx context.Context, in *TCertCreateSetReq, opts ...grpc.CallOption) (TCAP_CreateCertificateSetStreamClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_TCAP_serviceDesc.Streams[0], c.cc, "/protos.TCAP/CreateCertificateSetStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &tCAPCreateCertificateSetStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TCAP_CreateCertificateSetStreamClient interface {
+	Recv() (*TCertCreateSetResp, error)
+	grpc.ClientStream
+}
+
+type tCAPCreateCertificateSetStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *tCAPCreateCertificateSetStreamClient) Recv() (*TCertCreateSetResp, error) {
+	m := new(TCertCreateSetResp)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // Server API for TCAP service
 
 type TCAPServer interface {
@@ -1366,6 +2196,8 @@ type TCAPServer interface {
 	ReadCertificateSet(context.Context, *TCertReadSetReq) (*CertSet, error)
 	RevokeCertificate(context.Context, *TCertRevokeReq) (*CAStatus, error)
 	RevokeCertificateSet(context.Context, *TCertRevokeSetReq) (*CAStatus, error)
+	ReadCRL(context.Context, *Empty) (*CRL, error)
+	CreateCertificateSetStream(*TCertCreateSetReq, TCAP_CreateCertificateSetStreamServer) error
 }
 
 func RegisterTCAPServer(s *grpc.Server, srv TCAPServer) {
@@ -1444,6 +2276,39 @@ func _TCAP_RevokeCertificateSet_Handler(srv interface{}, ctx context.Context, de
 	return out, nil
 }
 
+func _TCAP_ReadCRL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(TCAPServer).ReadCRL(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _TCAP_CreateCertificateSetStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TCertCreateSetReq)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TCAPServer).CreateCertificateSetStream(m, &tCAPCreateCertificateSetStreamServer{stream})
+}
+
+type TCAP_CreateCertificateSetStreamServer interface {
+	Send(*TCertCreateSetResp) error
+	grpc.ServerStream
+}
+
+type tCAPCreateCertificateSetStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *tCAPCreateCertificateSetStreamServer) Send(m *TCertCreateSetResp) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 var _TCAP_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "protos.TCAP",
 	HandlerType: (*TCAPServer)(nil),
@@ -1472,8 +2337,18 @@ var _TCAP_serviceDesc = grpc.ServiceDesc{
 			MethodName: "RevokeCertificateSet",
 			Handler:    _TCAP_RevokeCertificateSet_Handler,
 		},
+		{
+			MethodName: "ReadCRL",
+			Handler:    _TCAP_ReadCRL_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "CreateCertificateSetStream",
+			Handler:       _TCAP_CreateCertificateSetStream_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams: []grpc.StreamDesc{},
 }
 
 // Client API for TCAA service
@@ -1483,6 +2358,8 @@ type TCAAClient interface {
 	RevokeCertificate(ctx context.Context, in *TCertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error)
 	RevokeCertificateSet(ctx context.Context, in *TCertRevokeSetReq, opts ...grpc.CallOption) (*CAStatus, error)
 	PublishCRL(ctx context.Context, in *TCertCRLReq, opts ...grpc.CallOption) (*CAStatus, error)
+	RotateAttributeKey(ctx context.Context, in *TCertAttributeKeyRotateReq, opts ...grpc.CallOption) (*CAStatus, error)
+	ReadAttributeKeys(ctx context.Context, in *TCertAttributeKeysReq, opts ...grpc.CallOption) (*TCertAttributeKeys, error)
 }
 
 type tCAAClient struct {
@@ -1529,6 +2406,24 @@ func (c *tCAAClient) PublishCRL(ctx context.Context, in *TCertCRLReq, opts ...gr
 	return out, nil
 }
 
+func (c *tCAAClient) RotateAttributeKey(ctx context.Context, in *TCertAttributeKeyRotateReq, opts ...grpc.CallOption) (*CAStatus, error) {
+	out := new(CAStatus)
+	err := grpc.Invoke(ctx, "/protos.TCAA/RotateAttributeKey", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tCAAClient) ReadAttributeKeys(ctx context.Context, in *TCertAttributeKeysReq, opts ...grpc.CallOption) (*TCertAttributeKeys, error) {
+	out := new(TCertAttributeKeys)
+	err := grpc.Invoke(ctx, "/protos.TCAA/ReadAttributeKeys", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for TCAA service
 
 type TCAAServer interface {
@@ -1536,6 +2431,8 @@ type TCAAServer interface {
 	RevokeCertificate(context.Context, *TCertRevokeReq) (*CAStatus, error)
 	RevokeCertificateSet(context.Context, *TCertRevokeSetReq) (*CAStatus, error)
 	PublishCRL(context.Context, *TCertCRLReq) (*CAStatus, error)
+	RotateAttributeKey(context.Context, *TCertAttributeKeyRotateReq) (*CAStatus, error)
+	ReadAttributeKeys(context.Context, *TCertAttributeKeysReq) (*TCertAttributeKeys, error)
 }
 
 func RegisterTCAAServer(s *grpc.Server, srv TCAAServer) {
@@ -1590,6 +2487,30 @@ func _TCAA_PublishCRL_Handler(srv interface{}, ctx context.Context, dec func(int
 	return out, nil
 }
 
+func _TCAA_RotateAttributeKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(TCertAttributeKeyRotateReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(TCAAServer).RotateAttributeKey(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _TCAA_ReadAttributeKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(TCertAttributeKeysReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(TCAAServer).ReadAttributeKeys(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 var _TCAA_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "protos.TCAA",
 	HandlerType: (*TCAAServer)(nil),
@@ -1610,6 +2531,14 @@ var _TCAA_serviceDesc = grpc.ServiceDesc{
 			MethodName: "PublishCRL",
 			Handler:    _TCAA_PublishCRL_Handler,
 		},
+		{
+			MethodName: "RotateAttributeKey",
+			Handler:    _TCAA_RotateAttributeKey_Handler,
+		},
+		{
+			MethodName: "ReadAttributeKeys",
+			Handler:    _TCAA_ReadAttributeKeys_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{},
 }
@@ -1621,6 +2550,7 @@ type TLSCAPClient interface {
 	CreateCertificate(ctx context.Context, in *TLSCertCreateReq, opts ...grpc.CallOption) (*TLSCertCreateResp, error)
 	ReadCertificate(ctx context.Context, in *TLSCertReadReq, opts ...grpc.CallOption) (*Cert, error)
 	RevokeCertificate(ctx context.Context, in *TLSCertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error)
+	ReadCACertificates(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*TLSCARoots, error)
 }
 
 type tLSCAPClient struct {
@@ -1667,6 +2597,15 @@ func (c *tLSCAPClient) RevokeCertificate(ctx context.Context, in *TLSCertRevokeR
 	return out, nil
 }
 
+func (c *tLSCAPClient) ReadCACertificates(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*TLSCARoots, error) {
+	out := new(TLSCARoots)
+	err := grpc.Invoke(ctx, "/protos.TLSCAP/ReadCACertificates", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for TLSCAP service
 
 type TLSCAPServer interface {
@@ -1674,6 +2613,7 @@ type TLSCAPServer interface {
 	CreateCertificate(context.Context, *TLSCertCreateReq) (*TLSCertCreateResp, error)
 	ReadCertificate(context.Context, *TLSCertReadReq) (*Cert, error)
 	RevokeCertificate(context.Context, *TLSCertRevokeReq) (*CAStatus, error)
+	ReadCACertificates(context.Context, *Empty) (*TLSCARoots, error)
 }
 
 func RegisterTLSCAPServer(s *grpc.Server, srv TLSCAPServer) {
@@ -1728,6 +2668,18 @@ func _TLSCAP_RevokeCertificate_Handler(srv interface{}, ctx context.Context, dec
 	return out, nil
 }
 
+func _TLSCAP_ReadCACertificates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(TLSCAPServer).ReadCACertificates(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 var _TLSCAP_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "protos.TLSCAP",
 	HandlerType: (*TLSCAPServer)(nil),
@@ -1748,6 +2700,10 @@ var _TLSCAP_serviceDesc = grpc.ServiceDesc{
 			MethodName: "RevokeCertificate",
 			Handler:    _TLSCAP_RevokeCertificate_Handler,
 		},
+		{
+			MethodName: "ReadCACertificates",
+			Handler:    _TLSCAP_ReadCACertificates_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{},
 }
@@ -1756,6 +2712,8 @@ var _TLSCAP_serviceDesc = grpc.ServiceDesc{
 
 type TLSCAAClient interface {
 	RevokeCertificate(ctx context.Context, in *TLSCertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error)
+	RotateCACertificate(ctx context.Context, in *TLSCARotateReq, opts ...grpc.CallOption) (*TLSCARoots, error)
+	ReadRotation(ctx context.Context, in *TLSCARotationReq, opts ...grpc.CallOption) (*TLSCARoots, error)
 }
 
 type tLSCAAClient struct {
@@ -1775,10 +2733,30 @@ func (c *tLSCAAClient) RevokeCertificate(ctx context.Context, in *TLSCertRevokeR
 	return out, nil
 }
 
+func (c *tLSCAAClient) RotateCACertificate(ctx context.Context, in *TLSCARotateReq, opts ...grpc.CallOption) (*TLSCARoots, error) {
+	out := new(TLSCARoots)
+	err := grpc.Invoke(ctx, "/protos.TLSCAA/RotateCACertificate", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tLSCAAClient) ReadRotation(ctx context.Context, in *TLSCARotationReq, opts ...grpc.CallOption) (*TLSCARoots, error) {
+	out := new(TLSCARoots)
+	err := grpc.Invoke(ctx, "/protos.TLSCAA/ReadRotation", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for TLSCAA service
 
 type TLSCAAServer interface {
 	RevokeCertificate(context.Context, *TLSCertRevokeReq) (*CAStatus, error)
+	RotateCACertificate(context.Context, *TLSCARotateReq) (*TLSCARoots, error)
+	ReadRotation(context.Context, *TLSCARotationReq) (*TLSCARoots, error)
 }
 
 func RegisterTLSCAAServer(s *grpc.Server, srv TLSCAAServer) {
@@ -1797,6 +2775,30 @@ func _TLSCAA_RevokeCertificate_Handler(srv interface{}, ctx context.Context, dec
 	return out, nil
 }
 
+func _TLSCAA_RotateCACertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(TLSCARotateReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(TLSCAAServer).RotateCACertificate(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _TLSCAA_ReadRotation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(TLSCARotationReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(TLSCAAServer).ReadRotation(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 var _TLSCAA_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "protos.TLSCAA",
 	HandlerType: (*TLSCAAServer)(nil),
@@ -1805,6 +2807,14 @@ var _TLSCAA_serviceDesc = grpc.ServiceDesc{
 			MethodName: "RevokeCertificate",
 			Handler:    _TLSCAA_RevokeCertificate_Handler,
 		},
+		{
+			MethodName: "RotateCACertificate",
+			Handler:    _TLSCAA_RotateCACertificate_Handler,
+		},
+		{
+			MethodName: "ReadRotation",
+			Handler:    _TLSCAA_ReadRotation_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{},
 }
@@ -21,6 +21,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -83,6 +84,20 @@ func main() {
 	ca.LogInit(iotrace, ioinfo, iowarning, ioerror, iopanic)
 	ca.Info.Println("CA Server (" + viper.GetString("server.version") + ")")
 
+	// The CAs keep their signing keys on the HSMs of the crypto layer. The
+	// providers of the ca package, such as pkcs11 reading its settings
+	// under server.hsm, are registered first and take precedence
+	for _, name := range crypto.GetHSMProviders() {
+		provider := name
+		ca.RegisterHSMProvider(provider, func(caName string) (ca.HSM, error) {
+			hsm, err := crypto.OpenHSM(provider, caName)
+			if err != nil {
+				return nil, err
+			}
+			return hsm, nil
+		})
+	}
+
 	eca := ca.NewECA()
 	defer eca.Close()
 
@@ -108,6 +123,42 @@ func main() {
 	tca.Start(srv)
 	tlsca.Start(srv)
 
+	// the CRLs are also served over HTTP, if a port is configured
+	if port := ca.GetConfigString("server.crl.port"); port != "" {
+		go func() {
+			if err := http.ListenAndServe(port, ca.NewCRLHandler(eca, tca)); err != nil {
+				ca.Error.Println("Fail to serve CRLs: ", err)
+			}
+		}()
+	}
+
+	// the REST gateway serves clients without a gRPC stack, over TLS if
+	// the gRPC services are
+	if port := ca.GetConfigString("server.rest.port"); port != "" {
+		go func() {
+			var err error
+			if certfile := viper.GetString("server.tls.certfile"); certfile != "" {
+				err = http.ListenAndServeTLS(port, certfile, viper.GetString("server.tls.keyfile"), ca.NewRESTHandler(eca, tca))
+			} else {
+				ca.Warning.Println("The REST gateway is served without TLS.")
+				err = http.ListenAndServe(port, ca.NewRESTHandler(eca, tca))
+			}
+			if err != nil {
+				ca.Error.Println("Fail to serve the REST gateway: ", err)
+			}
+		}()
+	}
+
+	// the metrics and health of the CAs are served over HTTP, if a port is
+	// configured
+	if port := ca.GetConfigString("server.metrics.port"); port != "" {
+		go func() {
+			if err := http.ListenAndServe(port, ca.NewMetricsHandler(eca)); err != nil {
+				ca.Error.Println("Fail to serve metrics: ", err)
+			}
+		}()
+	}
+
 	if sock, err := net.Listen("tcp", ca.GetConfigString("server.port")); err != nil {
 		ca.Error.Println("Fail to start CA Server: ", err)
 		os.Exit(1)
@@ -0,0 +1,97 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/hyperledger/fabric/consensus/util"
+	"github.com/hyperledger/fabric/orderer/solo"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+var logger = logging.MustGetLogger("orderer")
+
+func main() {
+	viper.SetEnvPrefix("orderer")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+	viper.SetConfigName("orderer")
+	viper.SetConfigType("yaml")
+	viper.AddConfigPath("./")
+	// Path to look for the config file based on GOPATH
+	gopath := os.Getenv("GOPATH")
+	for _, p := range filepath.SplitList(gopath) {
+		cfgpath := filepath.Join(p, "src/github.com/hyperledger/fabric/orderer")
+		viper.AddConfigPath(cfgpath)
+	}
+	err := viper.ReadInConfig()
+	if err != nil {
+		panic(fmt.Errorf("Fatal error when reading %s config file: %s\n", "orderer", err))
+	}
+
+	level, err := logging.LogLevel(viper.GetString("logging.level"))
+	if err != nil {
+		level = logging.INFO
+	}
+	logging.SetLevel(level, "")
+
+	path := viper.GetString("ledger.path")
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logger.Critical("Fail to create the directory of the log: %s", err)
+		os.Exit(1)
+	}
+	log, err := solo.NewLog(path)
+	if err != nil {
+		logger.Critical("Fail to open the log: %s", err)
+		os.Exit(1)
+	}
+	defer log.Close()
+
+	batchTimeouts := util.NewBatchTimeout(viper.GetDuration("general.timeout.batchmin"), viper.GetDuration("general.timeout.batch"))
+	orderer := solo.NewOrderer(log, viper.GetInt("general.batchsize"), batchTimeouts, viper.GetInt("general.queuesize"))
+	defer orderer.Stop()
+
+	var opts []grpc.ServerOption
+	if viper.GetBool("server.tls.enabled") {
+		creds, err := credentials.NewServerTLSFromFile(viper.GetString("server.tls.cert.file"), viper.GetString("server.tls.key.file"))
+		if err != nil {
+			panic(err)
+		}
+		opts = []grpc.ServerOption{grpc.Creds(creds)}
+	}
+	srv := grpc.NewServer(opts...)
+	pb.RegisterAtomicBroadcastServer(srv, orderer)
+
+	logger.Info("Orderer serving on %s from batch %d", viper.GetString("server.address"), log.Height())
+	if sock, err := net.Listen("tcp", viper.GetString("server.address")); err != nil {
+		logger.Critical("Fail to start the orderer: %s", err)
+		os.Exit(1)
+	} else {
+		srv.Serve(sock)
+		sock.Close()
+	}
+}
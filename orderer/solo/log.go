@@ -0,0 +1,176 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package solo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// Log is the sequence of ordered batches, appended to a file as they
+// are cut. Every batch is a record of its length as 4 bytes big endian
+// followed by the batch marshalled.
+type Log struct {
+	lock     sync.Mutex
+	file     *os.File
+	offsets  []int64       // offset in the file of each batch, by number
+	size     int64         // offset of the next batch
+	lastHash []byte        // hash of the last batch
+	appended chan struct{} // closed, and replaced, when a batch is appended
+}
+
+// NewLog opens the log kept in a file, creating it if it does not exist.
+// A record cut short by a crash while it was appended is discarded.
+func NewLog(path string) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open log %s: %s", path, err)
+	}
+	l := &Log{
+		file:     file,
+		appended: make(chan struct{}),
+	}
+	if err = l.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	logger.Info("Opened log %s with %d batches", path, len(l.offsets))
+	return l, nil
+}
+
+// load reads the offsets of the batches and the hash of the last one
+func (l *Log) load() error {
+	for {
+		batch, next, err := l.read(l.size)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if batch.Number != uint64(len(l.offsets)) {
+			return fmt.Errorf("Log has batch %d in place of %d", batch.Number, len(l.offsets))
+		}
+		if !bytes.Equal(batch.PreviousBatchHash, l.lastHash) {
+			return fmt.Errorf("Log has batch %d not chained to the previous one", batch.Number)
+		}
+		if l.lastHash, err = batch.GetHash(); err != nil {
+			return err
+		}
+		l.offsets = append(l.offsets, l.size)
+		l.size = next
+	}
+
+	// discard a partial record
+	if err := l.file.Truncate(l.size); err != nil {
+		return fmt.Errorf("Could not truncate log: %s", err)
+	}
+	return nil
+}
+
+// read returns the batch of the record at an offset, and the offset
+// of the next record
+func (l *Log) read(offset int64) (*pb.OrderedBatch, int64, error) {
+	var header [4]byte
+	if _, err := l.file.ReadAt(header[:], offset); err != nil {
+		return nil, 0, err
+	}
+	raw := make([]byte, binary.BigEndian.Uint32(header[:]))
+	if _, err := l.file.ReadAt(raw, offset+int64(len(header))); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	batch := &pb.OrderedBatch{}
+	if err := proto.Unmarshal(raw, batch); err != nil {
+		return nil, 0, fmt.Errorf("Could not unmarshal batch at offset %d: %s", offset, err)
+	}
+	return batch, offset + int64(len(header)+len(raw)), nil
+}
+
+// Append cuts a batch of transactions, numbered after the last one and
+// chained to it, and returns it once persisted
+func (l *Log) Append(txs []*pb.Transaction) (*pb.OrderedBatch, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	batch := &pb.OrderedBatch{
+		Number:            uint64(len(l.offsets)),
+		PreviousBatchHash: l.lastHash,
+		Transactions:      txs,
+	}
+	raw, err := proto.Marshal(batch)
+	if err != nil {
+		return nil, fmt.Errorf("Could not marshal batch %d: %s", batch.Number, err)
+	}
+	hash, err := batch.GetHash()
+	if err != nil {
+		return nil, err
+	}
+
+	record := make([]byte, 4+len(raw))
+	binary.BigEndian.PutUint32(record, uint32(len(raw)))
+	copy(record[4:], raw)
+	if _, err = l.file.WriteAt(record, l.size); err != nil {
+		return nil, fmt.Errorf("Could not write batch %d: %s", batch.Number, err)
+	}
+	if err = l.file.Sync(); err != nil {
+		return nil, fmt.Errorf("Could not sync batch %d: %s", batch.Number, err)
+	}
+
+	l.offsets = append(l.offsets, l.size)
+	l.size += int64(len(record))
+	l.lastHash = hash
+	close(l.appended)
+	l.appended = make(chan struct{})
+	return batch, nil
+}
+
+// Get returns the batch numbered n. If it is not cut yet, it returns
+// nil and a channel closed once the next batch is appended.
+func (l *Log) Get(n uint64) (*pb.OrderedBatch, <-chan struct{}, error) {
+	l.lock.Lock()
+	if n >= uint64(len(l.offsets)) {
+		defer l.lock.Unlock()
+		return nil, l.appended, nil
+	}
+	offset := l.offsets[n]
+	l.lock.Unlock()
+
+	batch, _, err := l.read(offset)
+	return batch, nil, err
+}
+
+// Height returns the number of batches cut
+func (l *Log) Height() uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return uint64(len(l.offsets))
+}
+
+// Close closes the file of the log
+func (l *Log) Close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,156 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package solo
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+
+	"github.com/hyperledger/fabric/consensus/util"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+var logger *logging.Logger // package-level logger
+
+func init() {
+	logger = logging.MustGetLogger("orderer/solo")
+}
+
+// Orderer is an ordering service run by a single node. It cuts the
+// transactions broadcast to it into batches, which it appends to its log
+// and delivers to whoever asks for them: validating peers execute them,
+// non-validating peers and clients may follow the order directly.
+type Orderer struct {
+	log           *Log
+	batchSize     int
+	batchTimeouts *util.BatchTimeout
+	queue         chan *pb.Transaction
+	exit          chan struct{}
+	done          chan struct{}
+}
+
+// NewOrderer starts an orderer appending to a log batches of at most
+// batchSize transactions, cut at latest batchTimeouts after their first
+// transaction was received. At most queueSize transactions wait to be
+// cut, beyond which Broadcast turns transactions down.
+func NewOrderer(log *Log, batchSize int, batchTimeouts *util.BatchTimeout, queueSize int) *Orderer {
+	o := &Orderer{
+		log:           log,
+		batchSize:     batchSize,
+		batchTimeouts: batchTimeouts,
+		queue:         make(chan *pb.Transaction, queueSize),
+		exit:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go o.main()
+	return o
+}
+
+// Broadcast queues a transaction to be ordered
+func (o *Orderer) Broadcast(ctx context.Context, tx *pb.Transaction) (*pb.BroadcastResponse, error) {
+	if tx == nil || tx.Uuid == "" {
+		return &pb.BroadcastResponse{Status: pb.BroadcastResponse_BAD_REQUEST, Info: "Transaction has no UUID"}, nil
+	}
+	select {
+	case o.queue <- tx:
+		return &pb.BroadcastResponse{Status: pb.BroadcastResponse_SUCCESS}, nil
+	case <-o.exit:
+	default:
+	}
+	return &pb.BroadcastResponse{Status: pb.BroadcastResponse_SERVICE_UNAVAILABLE, Info: "Too many transactions waiting to be ordered"}, nil
+}
+
+// Deliver streams the batches from the one numbered as requested, then
+// each batch as it is cut, until the client goes away
+func (o *Orderer) Deliver(req *pb.DeliverRequest, stream pb.AtomicBroadcast_DeliverServer) error {
+	for n := req.Start; ; {
+		batch, appended, err := o.log.Get(n)
+		if err != nil {
+			return err
+		}
+		if batch == nil {
+			select {
+			case <-appended:
+				continue
+			case <-stream.Context().Done():
+				return nil
+			case <-o.exit:
+				return fmt.Errorf("Orderer stopped")
+			}
+		}
+		if err = stream.Send(batch); err != nil {
+			return err
+		}
+		n++
+	}
+}
+
+// Stop cuts the transactions queued so far, and stops the orderer
+func (o *Orderer) Stop() {
+	close(o.exit)
+	<-o.done
+}
+
+func (o *Orderer) main() {
+	defer close(o.done)
+
+	var pending []*pb.Transaction
+	var timer <-chan time.Time
+	cut := func() {
+		timer = nil
+		if len(pending) == 0 {
+			return
+		}
+		o.batchTimeouts.Sent(len(pending), o.batchSize)
+		batch, err := o.log.Append(pending)
+		if err != nil {
+			// the transactions are lost, clients resubmit those not
+			// delivered in a batch
+			logger.Error("Could not append %d transactions: %s", len(pending), err)
+		} else {
+			logger.Debug("Cut batch %d of %d transactions", batch.Number, len(pending))
+		}
+		pending = nil
+	}
+
+	for {
+		select {
+		case tx := <-o.queue:
+			pending = append(pending, tx)
+			if len(pending) >= o.batchSize {
+				cut()
+			} else if timer == nil {
+				timer = time.After(o.batchTimeouts.Timeout())
+			}
+		case <-timer:
+			cut()
+		case <-o.exit:
+			for {
+				select {
+				case tx := <-o.queue:
+					pending = append(pending, tx)
+				default:
+					cut()
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package solo
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/hyperledger/fabric/consensus/util"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// mockDeliverStream collects the batches delivered
+type mockDeliverStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	batches chan *pb.OrderedBatch
+}
+
+func (s *mockDeliverStream) Send(batch *pb.OrderedBatch) error {
+	s.batches <- batch
+	return nil
+}
+
+func (s *mockDeliverStream) Context() context.Context {
+	return s.ctx
+}
+
+func newTestLog(t *testing.T) (*Log, string) {
+	dir, err := ioutil.TempDir("", "orderer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "batches")
+	log, err := NewLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return log, path
+}
+
+func testTx(i int) *pb.Transaction {
+	return &pb.Transaction{Uuid: fmt.Sprintf("tx%d", i)}
+}
+
+func TestLogReopen(t *testing.T) {
+	log, path := newTestLog(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	for i := 0; i < 3; i++ {
+		if _, err := log.Append([]*pb.Transaction{testTx(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log.Close()
+
+	// a record cut short is discarded
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	f.Write([]byte{0, 0, 1, 0, 42})
+	f.Close()
+
+	log, err := NewLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	if log.Height() != 3 {
+		t.Fatalf("Expected 3 batches, got %d", log.Height())
+	}
+	batch, err := log.Append([]*pb.Transaction{testTx(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch.Number != 3 {
+		t.Fatalf("Expected batch 3, got %d", batch.Number)
+	}
+
+	var previous []byte
+	for n := uint64(0); n < 4; n++ {
+		batch, _, err := log.Get(n)
+		if err != nil || batch == nil {
+			t.Fatalf("Could not get batch %d: %v", n, err)
+		}
+		if batch.Transactions[0].Uuid != fmt.Sprintf("tx%d", n) {
+			t.Fatalf("Batch %d has transaction %s", n, batch.Transactions[0].Uuid)
+		}
+		if !bytes.Equal(batch.PreviousBatchHash, previous) {
+			t.Fatalf("Batch %d is not chained to the previous one", n)
+		}
+		previous, _ = batch.GetHash()
+	}
+}
+
+func TestBroadcastDeliver(t *testing.T) {
+	log, path := newTestLog(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	defer log.Close()
+
+	o := NewOrderer(log, 2, util.NewBatchTimeout(0, 50*time.Millisecond), 10)
+	defer o.Stop()
+
+	if resp, _ := o.Broadcast(context.Background(), &pb.Transaction{}); resp.Status != pb.BroadcastResponse_BAD_REQUEST {
+		t.Fatalf("Expected a transaction without UUID to be rejected, got %v", resp.Status)
+	}
+	for i := 0; i < 3; i++ {
+		if resp, _ := o.Broadcast(context.Background(), testTx(i)); resp.Status != pb.BroadcastResponse_SUCCESS {
+			t.Fatalf("Expected transaction %d to be accepted, got %v", i, resp.Status)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &mockDeliverStream{ctx: ctx, batches: make(chan *pb.OrderedBatch)}
+	done := make(chan error)
+	go func() {
+		done <- o.Deliver(&pb.DeliverRequest{Start: 1}, stream)
+	}()
+
+	// the first batch is cut when full, the second on timeout
+	select {
+	case batch := <-stream.batches:
+		if batch.Number != 1 || len(batch.Transactions) != 1 || batch.Transactions[0].Uuid != "tx2" {
+			t.Fatalf("Expected batch 1 with tx2, got %v", batch)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for batch 1")
+	}
+	if first, _, _ := log.Get(0); len(first.Transactions) != 2 {
+		t.Fatalf("Expected batch 0 to hold 2 transactions, got %d", len(first.Transactions))
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Expected delivery to end with the client, got %s", err)
+	}
+}
+
+func TestBroadcastQueueFull(t *testing.T) {
+	log, path := newTestLog(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	defer log.Close()
+
+	// an orderer whose batches are never cut
+	o := &Orderer{log: log, queue: make(chan *pb.Transaction, 1), exit: make(chan struct{})}
+	o.Broadcast(context.Background(), testTx(0))
+	if resp, _ := o.Broadcast(context.Background(), testTx(1)); resp.Status != pb.BroadcastResponse_SERVICE_UNAVAILABLE {
+		t.Fatalf("Expected the orderer to be unavailable, got %v", resp.Status)
+	}
+}
@@ -18,6 +18,7 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -36,6 +37,7 @@ import (
 
 	google_protobuf "google/protobuf"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/howeyc/gopass"
 	"github.com/op/go-logging"
 	"github.com/spf13/cobra"
@@ -47,15 +49,20 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 
+	"github.com/hyperledger/fabric/consensus"
 	"github.com/hyperledger/fabric/consensus/helper"
 	"github.com/hyperledger/fabric/core"
 	"github.com/hyperledger/fabric/core/chaincode"
 	"github.com/hyperledger/fabric/core/crypto"
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/hyperledger/fabric/core/ledger/genesis"
+	"github.com/hyperledger/fabric/core/ledger/genesismaker"
 	"github.com/hyperledger/fabric/core/peer"
 	"github.com/hyperledger/fabric/core/rest"
 	"github.com/hyperledger/fabric/core/system_chaincode"
 	"github.com/hyperledger/fabric/events/producer"
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
 	pb "github.com/hyperledger/fabric/protos"
 )
 
@@ -118,6 +125,92 @@ var nodeStopCmd = &cobra.Command{
 	},
 }
 
+// nodeChainID is the chain the tune, compact, dbstats and snapshot commands
+// are for, the default chain if empty
+var nodeChainID string
+
+var (
+	tuneBatchSize      uint32
+	tuneBatchTimeout   string
+	tuneRequestTimeout string
+)
+
+var nodeTuneCmd = &cobra.Command{
+	Use:   "tune",
+	Short: "Adjusts the consensus parameters of the network.",
+	Long:  `Requests through the running node new consensus parameters, which the validators adjust once they agree on them, on behalf of a consensus administrator logged in to CLI when security is enabled. Prints the parameters in effect if none is given.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return tune(args)
+	},
+}
+
+var nodeCompactCmd = &cobra.Command{
+	Use:   "compact",
+	Short: "Archives, prunes and compacts the ledger of the node.",
+	Long:  `Moves the blocks of the running node older than kept by the ledger.archive configuration to the archive, deletes the blocks and state deltas older than kept by the ledger.pruning configuration, and compacts its DB to reclaim their disk space.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return compact()
+	},
+}
+
+var nodeDBStatsCmd = &cobra.Command{
+	Use:   "dbstats",
+	Short: "Prints the statistics of the DB of the node.",
+	Long:  `Prints the number of keys, the disk and memory used, and the statistics of the store of each column family of the DB of the running node, to tune the store with the peer.db.rocksdb configuration.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return dbStats()
+	},
+}
+
+var snapshotHeight uint64
+
+var nodeSnapshotCmd = &cobra.Command{
+	Use:   "snapshot <file>",
+	Short: "Exports a snapshot of the ledger of the node.",
+	Long:  `Writes to a new file a snapshot of the ledger of the running node: the world state, the genesis and head blocks, and the headers of the blocks in between. A new peer joins faster once bootstrapped with "node import" from the snapshot.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return snapshot(args)
+	},
+}
+
+var rebuildStateOnStart bool
+
+var nodeRebuildStateCmd = &cobra.Command{
+	Use:   "rebuildstate",
+	Short: "Rebuilds the world state of the node from its blockchain.",
+	Long:  `Deletes the world state, the state deltas, the history of the keys and the indexes of the blockchain of the validating node, while it is not running, and rebuilds them by executing again the transactions of all the blocks. This recovers from a corrupted state DB without rejoining the network, the chaincodes must be deterministic. "node start --rebuild-state" rebuilds the state before the node starts.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return rebuildStateOffline()
+	},
+}
+
+var nodeRecompressCmd = &cobra.Command{
+	Use:   "recompress",
+	Short: "Recompresses the blocks stored by the node.",
+	Long:  `Rewrites the blocks stored in the DB and in the archive of the node, while it is not running, with the compression set in ledger.blockchain.compression. The blocks stored before the compression was set are otherwise kept as they were.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return recompressBlocksOffline()
+	},
+}
+
+var nodeMakeGenesisCmd = &cobra.Command{
+	Use:   "makegenesis <spec> <file>",
+	Short: "Makes a genesis block from a spec.",
+	Long:  `Writes to a file the genesis block declared by a YAML spec: the system chaincodes deployed, the certificates of the consortium members and the consensus settings. The same spec always makes the same block, which the peers of a network bootstrap from with ledger.blockchain.genesisBlock.file.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return makeGenesis(args)
+	},
+}
+
+var nodeImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Bootstraps the ledger of the node from a snapshot.",
+	Long:  `Imports into the empty ledger of the node, while it is not running, a snapshot exported with "node snapshot". The node then only fetches from the network the blocks committed after the snapshot.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return importSnapshot(args)
+	},
+}
+
 var networkCmd = &cobra.Command{
 	Use:   networkFuncName,
 	Short: fmt.Sprintf("%s specific commands.", networkFuncName),
@@ -136,6 +229,33 @@ var networkLoginCmd = &cobra.Command{
 	},
 }
 
+var networkExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports the keystore of a user.",
+	Long:  `Exports the keystore of a user logged in to CLI to an encrypted archive. Must supply username and archive path as parameters.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return networkExport(args)
+	},
+}
+
+var networkRegisterCmd = &cobra.Command{
+	Use:   "register",
+	Short: "Registers users in bulk.",
+	Long:  `Registers with the CA the users listed in a JSON file, on behalf of an auditor logged in to CLI, and prints the token or the error of each. Must supply username and file path as parameters.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return networkRegister(args)
+	},
+}
+
+var networkImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Imports the keystore of a user.",
+	Long:  `Imports the keystore of a user from an archive created by export, and logs the user in to CLI. Must supply username and archive path as parameters.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return networkImport(args)
+	},
+}
+
 // var vmCmd = &cobra.Command{
 // 	Use:   "vm",
 // 	Short: "Accesses VM specific functionality.",
@@ -169,6 +289,11 @@ var (
 	loginPW string
 )
 
+// export and import related variables.
+var (
+	archivePassphrase string
+)
+
 // Chaincode-related variables.
 var (
 	chaincodeLang     string
@@ -177,6 +302,7 @@ var (
 	chaincodeName     string
 	chaincodeDevMode  bool
 	chaincodeUsr      string
+	chaincodeChainID  string
 	chaincodeQueryRaw bool
 	chaincodeQueryHex bool
 )
@@ -245,6 +371,7 @@ func main() {
 	flags.Bool("peer-discovery-enabled", true, "Whether peer discovery is enabled")
 
 	flags.BoolVarP(&chaincodeDevMode, "peer-chaincodedev", "", false, "Whether peer in chaincode development mode")
+	flags.BoolVarP(&rebuildStateOnStart, "rebuild-state", "", false, "Whether to rebuild the world state from the blockchain before starting")
 
 	viper.BindPFlag("peer_tls_enabled", flags.Lookup("peer-tls-enabled"))
 	viper.BindPFlag("peer_tls_cert_file", flags.Lookup("peer-tls-cert-file"))
@@ -274,6 +401,22 @@ func main() {
 	nodeStopCmd.Flags().StringVarP(&stopPidFile, "stop-peer-pid-file", "", viper.GetString("peer.fileSystemPath"), "Location of peer pid local file, for forces kill")
 	nodeCmd.AddCommand(nodeStopCmd)
 
+	nodeTuneCmd.Flags().Uint32VarP(&tuneBatchSize, "batchsize", "", 0, "How many requests the primary orders at once in batch mode")
+	nodeTuneCmd.Flags().StringVarP(&tuneBatchTimeout, "batchtimeout", "", undefinedParamValue, "How long the primary waits for a batch to fill, e.g. 2s")
+	nodeTuneCmd.Flags().StringVarP(&tuneRequestTimeout, "requesttimeout", "", undefinedParamValue, "How long a request may take between reception and execution, e.g. 2s")
+	nodeCmd.AddCommand(nodeTuneCmd)
+	nodeCmd.AddCommand(nodeCompactCmd)
+	nodeCmd.AddCommand(nodeDBStatsCmd)
+	nodeSnapshotCmd.Flags().Uint64VarP(&snapshotHeight, "height", "", 0, "Height of the ledger snapshot, the current height if 0")
+	for _, cmd := range []*cobra.Command{nodeTuneCmd, nodeCompactCmd, nodeDBStatsCmd, nodeSnapshotCmd} {
+		cmd.Flags().StringVar(&nodeChainID, "chainID", "", "Chain the command is for, the default chain if not set")
+	}
+	nodeCmd.AddCommand(nodeSnapshotCmd)
+	nodeCmd.AddCommand(nodeImportCmd)
+	nodeCmd.AddCommand(nodeRebuildStateCmd)
+	nodeCmd.AddCommand(nodeRecompressCmd)
+	nodeCmd.AddCommand(nodeMakeGenesisCmd)
+
 	mainCmd.AddCommand(nodeCmd)
 
 	// Set the flags on the login command.
@@ -281,6 +424,14 @@ func main() {
 
 	networkCmd.AddCommand(networkLoginCmd)
 
+	// Set the flags on the export and import commands.
+	networkExportCmd.Flags().StringVarP(&archivePassphrase, "passphrase", "", undefinedParamValue, "The passphrase protecting the archive. You will be requested to enter the passphrase if this flag is not specified.")
+	networkImportCmd.Flags().StringVarP(&archivePassphrase, "passphrase", "", undefinedParamValue, "The passphrase protecting the archive. You will be requested to enter the passphrase if this flag is not specified.")
+
+	networkCmd.AddCommand(networkExportCmd)
+	networkCmd.AddCommand(networkImportCmd)
+	networkCmd.AddCommand(networkRegisterCmd)
+
 	// vmCmd.AddCommand(vmPrimeCmd)
 	// mainCmd.AddCommand(vmCmd)
 
@@ -293,6 +444,7 @@ func main() {
 	chaincodeCmd.PersistentFlags().StringVarP(&chaincodePath, "path", "p", undefinedParamValue, fmt.Sprintf("Path to %s", chainFuncName))
 	chaincodeCmd.PersistentFlags().StringVarP(&chaincodeName, "name", "n", undefinedParamValue, fmt.Sprintf("Name of the chaincode returned by the deploy transaction"))
 	chaincodeCmd.PersistentFlags().StringVarP(&chaincodeUsr, "username", "u", undefinedParamValue, fmt.Sprintf("Username for chaincode operations when security is enabled"))
+	chaincodeCmd.PersistentFlags().StringVar(&chaincodeChainID, "chainID", "", fmt.Sprintf("Chain the %s is deployed on or executed on, the default chain if not set", chainFuncName))
 
 	chaincodeQueryCmd.Flags().BoolVarP(&chaincodeQueryRaw, "raw", "r", false, "If true, output the query value as raw bytes, otherwise format as a printable string")
 	chaincodeQueryCmd.Flags().BoolVarP(&chaincodeQueryHex, "hex", "x", false, "If true, output the query value byte array in hexadecimal. Incompatible with --raw")
@@ -330,7 +482,7 @@ func createEventHubServer() (net.Listener, *grpc.Server, error) {
 		//TODO - do we need different SSL material for events ?
 		var opts []grpc.ServerOption
 		if peer.TLSEnabled() {
-			creds, err := credentials.NewServerTLSFromFile(viper.GetString("peer.tls.cert.file"), viper.GetString("peer.tls.key.file"))
+			creds, err := getServerTLSCredentials()
 			if err != nil {
 				return nil, nil, fmt.Errorf("Failed to generate credentials %v", err)
 			}
@@ -344,6 +496,113 @@ func createEventHubServer() (net.Listener, *grpc.Server, error) {
 	return lis, grpcServer, err
 }
 
+// getServerTLSCredentials returns the TLS credentials of the peer server.
+// The key is read from the secret store configured under security.secrets,
+// if it names a path for it, otherwise from peer.tls.key.file.
+func getServerTLSCredentials() (credentials.TransportAuthenticator, error) {
+	key, err := crypto.ReadSecret(viper.GetString("security.enrollID"), crypto.SecretTLSKey)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return credentials.NewServerTLSFromFile(viper.GetString("peer.tls.cert.file"), viper.GetString("peer.tls.key.file"))
+	}
+
+	cert, err := ioutil.ReadFile(viper.GetString("peer.tls.cert.file"))
+	if err != nil {
+		return nil, err
+	}
+	pair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials.NewServerTLSFromCert(&pair), nil
+}
+
+// getServerOpts returns the options of the peer gRPC server, with the TLS
+// credentials if TLS is enabled
+func getServerOpts() ([]grpc.ServerOption, error) {
+	if !peer.TLSEnabled() {
+		return nil, nil
+	}
+	creds, err := getServerTLSCredentials()
+	if err != nil {
+		return nil, err
+	}
+	return []grpc.ServerOption{grpc.Creds(creds)}, nil
+}
+
+// getListenAddress returns the address the peer gRPC server listens to,
+// peer.listenAddress or else the address of the peer endpoint
+func getListenAddress(peerEndpoint *pb.PeerEndpoint) string {
+	listenAddr := viper.GetString("peer.listenAddress")
+	if "" == listenAddr {
+		logger.Debug("Listen address not specified, using peer endpoint address")
+		listenAddr = peerEndpoint.Address
+	}
+	return listenAddr
+}
+
+// resetState deletes the state to rebuild it if force is set, or if the
+// previous reset was interrupted. It must run before the ledger is opened.
+func resetState(force bool) error {
+	next, rebuilding, err := ledger.GetStateRebuildFrom()
+	if err != nil {
+		return err
+	}
+	if force || (rebuilding && next == 0) {
+		return ledger.ResetState()
+	}
+	return nil
+}
+
+// rebuildState replays the blocks left to replay since the state was reset,
+// if any, serving the chaincodes executing their transactions with a gRPC
+// server of its own at listenAddr, stopped once the state is rebuilt
+func rebuildState(listenAddr string, opts []grpc.ServerOption, ccSupport *chaincode.ChaincodeSupport) error {
+	if _, rebuilding, err := ledger.GetStateRebuildFrom(); err != nil || !rebuilding {
+		return err
+	}
+	if !peer.ValidatorEnabled() {
+		return errors.New("The state can only be rebuilt by a validating peer, which executes the transactions")
+	}
+
+	lis, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+	defer lis.Close()
+	grpcServer := grpc.NewServer(opts...)
+	pb.RegisterChaincodeSupportServer(grpcServer, ccSupport)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	blocks, err := chaincode.RebuildState(context.Background(), chaincode.DefaultChain)
+	if err != nil {
+		return fmt.Errorf("Error rebuilding the state: %s", err)
+	}
+	logger.Info("Rebuilt the state replaying %d blocks", blocks)
+	return nil
+}
+
+// getChainIDs returns the IDs of the chains the peer keeps a ledger of
+// besides the default chain, set in 'peer.chains'
+func getChainIDs() ([]string, error) {
+	chainIDs := viper.GetStringSlice("peer.chains")
+	seen := make(map[string]bool)
+	for _, chainID := range chainIDs {
+		if chainID == string(chaincode.DefaultChain) || seen[chainID] {
+			return nil, fmt.Errorf("Invalid chain ID %s in peer.chains, the IDs are unique and %s is the name of the default chain", chainID, chaincode.DefaultChain)
+		}
+		seen[chainID] = true
+		if _, err := ledger.GetChainLedger(chainID); err != nil {
+			return nil, err
+		}
+	}
+	return chainIDs, nil
+}
+
 var once sync.Once
 
 //this should be called exactly once and the result cached
@@ -403,6 +662,9 @@ func serve(args []string) error {
 	if err := peer.CacheConfiguration(); err != nil {
 		return err
 	}
+	if err := resetState(rebuildStateOnStart); err != nil {
+		return err
+	}
 
 	//register all system chaincodes. This just registers chaincodes, they must be
 	//still be deployed and launched
@@ -413,17 +675,7 @@ func serve(args []string) error {
 		return err
 	}
 
-	listenAddr := viper.GetString("peer.listenAddress")
-
-	if "" == listenAddr {
-		logger.Debug("Listen address not specified, using peer endpoint address")
-		listenAddr = peerEndpoint.Address
-	}
-
-	lis, err := net.Listen("tcp", listenAddr)
-	if err != nil {
-		grpclog.Fatalf("Failed to listen: %v", err)
-	}
+	listenAddr := getListenAddress(peerEndpoint)
 
 	ehubLis, ehubGrpcServer, err := createEventHubServer()
 	if err != nil {
@@ -433,17 +685,11 @@ func serve(args []string) error {
 	logger.Info("Security enabled status: %t", core.SecurityEnabled())
 	logger.Info("Privacy enabled status: %t", viper.GetBool("security.privacy"))
 
-	var opts []grpc.ServerOption
-	if peer.TLSEnabled() {
-		creds, err := credentials.NewServerTLSFromFile(viper.GetString("peer.tls.cert.file"), viper.GetString("peer.tls.key.file"))
-		if err != nil {
-			grpclog.Fatalf("Failed to generate credentials %v", err)
-		}
-		opts = []grpc.ServerOption{grpc.Creds(creds)}
+	opts, err := getServerOpts()
+	if err != nil {
+		grpclog.Fatalf("Failed to generate credentials %v", err)
 	}
 
-	grpcServer := grpc.NewServer(opts...)
-
 	secHelper, err := getSecHelper()
 	if err != nil {
 		return err
@@ -453,7 +699,30 @@ func serve(args []string) error {
 		return secHelper
 	}
 
-	registerChaincodeSupport(chaincode.DefaultChain, grpcServer, secHelper)
+	ccSupport := newChaincodeSupport(chaincode.DefaultChain, secHelper)
+
+	// the chaincodes of the other chains register with the chaincode
+	// support of the default chain, which hands them to their chain
+	chainIDs, err := getChainIDs()
+	if err != nil {
+		return err
+	}
+	for _, chainID := range chainIDs {
+		newChaincodeSupport(chaincode.GetChainName(chainID), secHelper)
+	}
+
+	// rebuild the state reset before the peer joins the network
+	if err = rebuildState(listenAddr, opts, ccSupport); err != nil {
+		return err
+	}
+
+	lis, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		grpclog.Fatalf("Failed to listen: %v", err)
+	}
+
+	grpcServer := grpc.NewServer(opts...)
+	pb.RegisterChaincodeSupportServer(grpcServer, ccSupport)
 
 	var peerServer *peer.PeerImpl
 
@@ -464,6 +733,11 @@ func serve(args []string) error {
 		if makeGenesisError != nil {
 			return makeGenesisError
 		}
+		for _, chainID := range chainIDs {
+			if makeGenesisError = genesis.MakeChainGenesis(chainID); makeGenesisError != nil {
+				return makeGenesisError
+			}
+		}
 		logger.Debug("Running as validating peer - installing consensus %s", viper.GetString("peer.validator.consensus"))
 		peerServer, err = peer.NewPeerWithEngine(secHelperFunc, helper.GetEngine)
 	} else {
@@ -481,8 +755,35 @@ func serve(args []string) error {
 	//pb.RegisterPeerServer(grpcServer, openchain.NewPeer())
 	pb.RegisterPeerServer(grpcServer, peerServer)
 
-	// Register the Admin server
-	pb.RegisterAdminServer(grpcServer, core.NewAdminServer())
+	// Install the consensus of the other chains, the peer hands their
+	// transactions and consensus messages to them, and collect the tuners
+	// of the consensus of every chain for the Admin server
+	tuners := make(map[string]consensus.Tuner)
+	if peer.ValidatorEnabled() {
+		engine, _ := helper.GetEngine(peerServer)
+		if tuner := engine.(*helper.EngineImpl).GetTuner(); tuner != nil {
+			tuners[""] = tuner
+		}
+		for _, chainID := range chainIDs {
+			logger.Debug("Running as validating peer - installing consensus of chain %s", chainID)
+			if engine, err = helper.GetChainEngine(peerServer, chainID); err != nil {
+				return err
+			}
+			if tuner := engine.(*helper.EngineImpl).GetTuner(); tuner != nil {
+				tuners[chainID] = tuner
+			}
+		}
+	}
+
+	// Register the Admin server, adjusting the consensus parameters of a validator
+	adminServer := core.NewAdminServer()
+	if len(tuners) > 0 {
+		adminServer = core.NewAdminServerWithConsensus(tuners, secHelper)
+	}
+	pb.RegisterAdminServer(grpcServer, adminServer)
+	if interval := viper.GetDuration("ledger.pruning.interval"); interval > 0 {
+		core.StartLedgerCompaction(interval)
+	}
 
 	// Register Devops server
 	serverDevops := core.NewDevopsServer(peerServer)
@@ -602,6 +903,237 @@ func stop() (err error) {
 	return err
 }
 
+// tune requests new consensus parameters through the local peer, signed
+// with the enrollment key of the user given when security is enabled, and
+// prints the parameters in effect
+func tune(args []string) (err error) {
+	clientConn, err := peer.NewPeerClientConnection()
+	if err != nil {
+		err = fmt.Errorf("Error trying to connect to local peer: %s", err)
+		return
+	}
+	serverClient := pb.NewAdminClient(clientConn)
+
+	params := &pb.ConsensusParameters{
+		BatchSize:      tuneBatchSize,
+		BatchTimeout:   tuneBatchTimeout,
+		RequestTimeout: tuneRequestTimeout,
+	}
+	if params.BatchSize == 0 && params.BatchTimeout == "" && params.RequestTimeout == "" {
+		params, err = serverClient.GetConsensusParameters(peer.NewChainContext(context.Background(), nodeChainID), &google_protobuf.Empty{})
+		if err != nil {
+			err = fmt.Errorf("Error getting consensus parameters: %s", err)
+			return
+		}
+		fmt.Println(params)
+		return nil
+	}
+
+	req := &pb.ConsensusParametersRequest{Parameters: params}
+	if core.SecurityEnabled() {
+		if len(args) != 1 {
+			err = errors.New("Must supply username as the only parameter")
+			return
+		}
+		if _, err = os.Stat(getCliFilePath() + "loginToken_" + args[0]); err != nil {
+			err = fmt.Errorf("User '%s' must log in first", args[0])
+			return
+		}
+
+		var client crypto.Client
+		client, err = crypto.InitClient(args[0], nil)
+		if err != nil {
+			err = fmt.Errorf("Error initializing client of user '%s': %s", args[0], err)
+			return
+		}
+		defer crypto.CloseClient(client)
+
+		var handler crypto.CertificateHandler
+		handler, err = client.GetEnrollmentCertificateHandler()
+		if err != nil {
+			err = fmt.Errorf("Error getting enrollment certificate of user '%s': %s", args[0], err)
+			return
+		}
+		var raw []byte
+		if raw, err = proto.Marshal(params); err != nil {
+			return
+		}
+		req.Cert = handler.GetCertificate()
+		if req.Signature, err = handler.Sign(raw); err != nil {
+			err = fmt.Errorf("Error signing consensus parameters: %s", err)
+			return
+		}
+	}
+
+	params, err = serverClient.SetConsensusParameters(peer.NewChainContext(context.Background(), nodeChainID), req)
+	if err != nil {
+		err = fmt.Errorf("Error requesting consensus parameters: %s", err)
+		return
+	}
+	fmt.Println(params)
+	return nil
+}
+
+// compact prunes and compacts the ledger of the local peer, and prints what
+// was pruned
+func compact() (err error) {
+	clientConn, err := peer.NewPeerClientConnection()
+	if err != nil {
+		err = fmt.Errorf("Error trying to connect to local peer: %s", err)
+		return
+	}
+	serverClient := pb.NewAdminClient(clientConn)
+
+	compaction, err := serverClient.CompactLedger(peer.NewChainContext(context.Background(), nodeChainID), &google_protobuf.Empty{})
+	if err != nil {
+		err = fmt.Errorf("Error compacting the ledger: %s", err)
+		return
+	}
+	fmt.Println(compaction)
+	return nil
+}
+
+// dbStats prints the statistics of the DB of the local peer
+func dbStats() (err error) {
+	clientConn, err := peer.NewPeerClientConnection()
+	if err != nil {
+		err = fmt.Errorf("Error trying to connect to local peer: %s", err)
+		return
+	}
+	serverClient := pb.NewAdminClient(clientConn)
+
+	stats, err := serverClient.GetDBStats(peer.NewChainContext(context.Background(), nodeChainID), &google_protobuf.Empty{})
+	if err != nil {
+		err = fmt.Errorf("Error getting the DB statistics: %s", err)
+		return
+	}
+	fmt.Printf("Backend: %s\n", stats.Backend)
+	for _, cf := range stats.ColumnFamilies {
+		fmt.Printf("%s: keys=%d disk=%d memory=%d\n", cf.Name, cf.Keys, cf.DiskSize, cf.MemorySize)
+		if cf.Details != "" {
+			fmt.Println(cf.Details)
+		}
+	}
+	return nil
+}
+
+// snapshot exports a snapshot of the ledger of the local peer to a file
+func snapshot(args []string) (err error) {
+	if len(args) != 1 {
+		return errors.New("Must supply the snapshot file as the only parameter")
+	}
+	// the file is written by the peer
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		return
+	}
+	clientConn, err := peer.NewPeerClientConnection()
+	if err != nil {
+		err = fmt.Errorf("Error trying to connect to local peer: %s", err)
+		return
+	}
+	serverClient := pb.NewAdminClient(clientConn)
+
+	info, err := serverClient.ExportLedgerSnapshot(peer.NewChainContext(context.Background(), nodeChainID), &pb.LedgerSnapshotRequest{Path: path, Height: snapshotHeight})
+	if err != nil {
+		err = fmt.Errorf("Error exporting a snapshot of the ledger: %s", err)
+		return
+	}
+	fmt.Println(info)
+	return nil
+}
+
+// importSnapshot bootstraps the ledger of the peer, which must not be
+// running, from a snapshot file
+func importSnapshot(args []string) (err error) {
+	if len(args) != 1 {
+		return errors.New("Must supply the snapshot file as the only parameter")
+	}
+	file, err := os.Open(args[0])
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	l, err := ledger.GetLedger()
+	if err != nil {
+		return
+	}
+	defer db.GetDBHandle().CloseDB()
+	info, err := l.ImportSnapshot(file)
+	if err != nil {
+		err = fmt.Errorf("Error importing the snapshot %s: %s", args[0], err)
+		return
+	}
+	fmt.Println(info)
+	return nil
+}
+
+// rebuildStateOffline resets the state of the node and rebuilds it from the
+// blocks, without joining the network
+func rebuildStateOffline() error {
+	if err := peer.CacheConfiguration(); err != nil {
+		return err
+	}
+	if !peer.ValidatorEnabled() {
+		return errors.New("The state can only be rebuilt by a validating peer, which executes the transactions")
+	}
+	defer db.GetDBHandle().CloseDB()
+	if err := ledger.ResetState(); err != nil {
+		return err
+	}
+
+	system_chaincode.RegisterSysCCs()
+	peerEndpoint, err := peer.GetPeerEndpoint()
+	if err != nil {
+		return fmt.Errorf("Failed to get Peer Endpoint: %s", err)
+	}
+	opts, err := getServerOpts()
+	if err != nil {
+		return err
+	}
+	secHelper, err := getSecHelper()
+	if err != nil {
+		return err
+	}
+	return rebuildState(getListenAddress(peerEndpoint), opts, newChaincodeSupport(chaincode.DefaultChain, secHelper))
+}
+
+// recompressBlocksOffline rewrites the blocks of the node with the
+// configured compression
+func recompressBlocksOffline() error {
+	if err := peer.CacheConfiguration(); err != nil {
+		return err
+	}
+	defer db.GetDBHandle().CloseDB()
+	result, err := ledger.RecompressBlocks()
+	if err != nil {
+		return err
+	}
+	fmt.Println(result)
+	return nil
+}
+
+// makeGenesis writes the genesis block of a spec to a file
+func makeGenesis(args []string) error {
+	if len(args) != 2 {
+		return errors.New("Must supply the spec and the genesis block file as the only parameters")
+	}
+	spec, err := genesismaker.LoadSpec(args[0])
+	if err != nil {
+		return err
+	}
+	block, err := genesismaker.Make(spec)
+	if err != nil {
+		return err
+	}
+	if err = genesismaker.Write(block, args[1]); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote the genesis block with %d chaincodes to %s\n", len(block.Transactions), args[1])
+	return nil
+}
+
 // login confirms the enrollmentID and secret password of the client with the
 // CA and stores the enrollment certificate and key in the Devops server.
 func networkLogin(args []string) (err error) {
@@ -688,6 +1220,206 @@ func networkLogin(args []string) (err error) {
 	return nil
 }
 
+// networkExport writes to a file the keystore of a client logged in to CLI,
+// encrypted under a passphrase. The peer must not be using the client.
+func networkExport(args []string) (err error) {
+	if len(args) != 2 {
+		err = errors.New("Must supply username and archive path as the only parameters")
+		return
+	}
+
+	passphrase, err := getArchivePassphrase()
+	if err != nil {
+		return
+	}
+
+	logger.Info("Exporting keystore of user '%s' to '%s'...\n", args[0], args[1])
+	archive, err := crypto.ExportClient(args[0], nil, passphrase)
+	if err != nil {
+		err = fmt.Errorf("Error exporting keystore of user '%s': %s", args[0], err)
+		return
+	}
+	if err = ioutil.WriteFile(args[1], archive, 0600); err != nil {
+		err = fmt.Errorf("Error writing archive: %s", err)
+		return
+	}
+
+	logger.Info("Keystore of user '%s' exported to '%s'.\n", args[0], args[1])
+	return nil
+}
+
+// networkImport creates the keystore of a client out of an archive written
+// by networkExport and stores the login token of the client.
+func networkImport(args []string) (err error) {
+	if len(args) != 2 {
+		err = errors.New("Must supply username and archive path as the only parameters")
+		return
+	}
+
+	archive, err := ioutil.ReadFile(args[1])
+	if err != nil {
+		err = fmt.Errorf("Error reading archive: %s", err)
+		return
+	}
+	passphrase, err := getArchivePassphrase()
+	if err != nil {
+		return
+	}
+
+	logger.Info("Importing keystore of user '%s' from '%s'...\n", args[0], args[1])
+	if err = crypto.ImportClient(args[0], nil, passphrase, archive); err != nil {
+		err = fmt.Errorf("Error importing keystore of user '%s': %s", args[0], err)
+		return
+	}
+
+	// Store client security context into a file
+	localStore := getCliFilePath()
+	if err = os.MkdirAll(localStore, 0755); err != nil {
+		err = fmt.Errorf("Error creating %s directory: %s", localStore, err)
+		return
+	}
+	if err = ioutil.WriteFile(localStore+"loginToken_"+args[0], []byte(args[0]), 0755); err != nil {
+		err = fmt.Errorf("Error storing client login token: %s", err)
+		return
+	}
+
+	logger.Info("Keystore of user '%s' imported.\n", args[0])
+	return nil
+}
+
+// registerBatchSize is how many users networkRegister sends the CA at once
+const registerBatchSize = 1000
+
+// userRegistration is a user in the file read by networkRegister, e.g.
+// {"id": "alice", "role": "client", "affiliation": "bank_a",
+// "affiliationRole": "00001", "maxEnrollments": 2, "expires": "2017-01-01T00:00:00Z"}.
+// The role lists the roles of the user separated by spaces.
+type userRegistration struct {
+	ID              string     `json:"id"`
+	Role            string     `json:"role"`
+	Affiliation     string     `json:"affiliation"`
+	AffiliationRole string     `json:"affiliationRole"`
+	MaxEnrollments  int32      `json:"maxEnrollments"`
+	Expires         *time.Time `json:"expires"`
+}
+
+// registerUserReq returns the registration request of the user
+func (user *userRegistration) registerUserReq() (*membersrvc.RegisterUserReq, error) {
+	var role membersrvc.Role
+	for _, name := range strings.Fields(user.Role) {
+		r, ok := membersrvc.Role_value[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("Unknown role %s", name)
+		}
+		role |= membersrvc.Role(r)
+	}
+
+	req := &membersrvc.RegisterUserReq{
+		Id:             &membersrvc.Identity{Id: user.ID},
+		Role:           role,
+		Account:        user.Affiliation,
+		Affiliation:    user.AffiliationRole,
+		MaxEnrollments: user.MaxEnrollments,
+	}
+	if user.Expires != nil {
+		req.Expires = &google_protobuf.Timestamp{Seconds: user.Expires.Unix()}
+	}
+
+	return req, nil
+}
+
+// networkRegister registers the users listed in a JSON file on behalf of a
+// client logged in to CLI, which must be an auditor, and prints the token
+// of each user registered and why the others were not. Users failing to
+// register do not prevent the others from registering.
+func networkRegister(args []string) (err error) {
+	if len(args) != 2 {
+		err = errors.New("Must supply username and file path as the only parameters")
+		return
+	}
+
+	if _, err = os.Stat(getCliFilePath() + "loginToken_" + args[0]); err != nil {
+		err = fmt.Errorf("User '%s' must log in first", args[0])
+		return
+	}
+
+	raw, err := ioutil.ReadFile(args[1])
+	if err != nil {
+		err = fmt.Errorf("Error reading users file: %s", err)
+		return
+	}
+	var users []userRegistration
+	if err = json.Unmarshal(raw, &users); err != nil {
+		err = fmt.Errorf("Error parsing users file: %s", err)
+		return
+	}
+
+	var reqs []*membersrvc.RegisterUserReq
+	failed := 0
+	for i := range users {
+		req, e := users[i].registerUserReq()
+		if e != nil {
+			fmt.Printf("%s: error: %s\n", users[i].ID, e)
+			failed++
+			continue
+		}
+		reqs = append(reqs, req)
+	}
+
+	client, err := crypto.InitClient(args[0], nil)
+	if err != nil {
+		err = fmt.Errorf("Error initializing client of user '%s': %s", args[0], err)
+		return
+	}
+	defer crypto.CloseClient(client)
+
+	logger.Info("Registering %d users on behalf of user '%s'...\n", len(reqs), args[0])
+	for start := 0; start < len(reqs); start += registerBatchSize {
+		end := start + registerBatchSize
+		if end > len(reqs) {
+			end = len(reqs)
+		}
+
+		results, e := client.RegisterUsers(reqs[start:end])
+		if e != nil {
+			err = fmt.Errorf("Error registering users: %s", e)
+			return
+		}
+		for _, result := range results {
+			if result.Error != "" {
+				fmt.Printf("%s: error: %s\n", result.Id.Id, result.Error)
+				failed++
+				continue
+			}
+			fmt.Printf("%s: %s\n", result.Id.Id, result.Tok.Tok)
+		}
+	}
+
+	if failed > 0 {
+		err = fmt.Errorf("%d of %d users not registered", failed, len(users))
+		return
+	}
+
+	logger.Info("%d users registered.\n", len(users))
+	return nil
+}
+
+// getArchivePassphrase returns the passphrase set by the '--passphrase'
+// flag, reading it from the terminal if the flag is not specified.
+func getArchivePassphrase() ([]byte, error) {
+	if archivePassphrase != "" {
+		return []byte(archivePassphrase), nil
+	}
+
+	fmt.Print("Enter archive passphrase: ")
+	pw, err := gopass.GetPasswdMasked()
+	if err != nil {
+		return nil, fmt.Errorf("Error trying to read passphrase from console: %s", err)
+	}
+
+	return pw, nil
+}
+
 // getCliFilePath is a helper function to retrieve the local storage directory
 // of client login tokens.
 func getCliFilePath() string {
@@ -699,7 +1431,7 @@ func getCliFilePath() string {
 	return localStore
 }
 
-func registerChaincodeSupport(chainname chaincode.ChainName, grpcServer *grpc.Server, secHelper crypto.Peer) {
+func newChaincodeSupport(chainname chaincode.ChainName, secHelper crypto.Peer) *chaincode.ChaincodeSupport {
 	//get user mode
 	userRunsCC := false
 	if viper.GetString("chaincode.mode") == chaincode.DevModeUserRunsChaincode {
@@ -714,7 +1446,7 @@ func registerChaincodeSupport(chainname chaincode.ChainName, grpcServer *grpc.Se
 	}
 	ccStartupTimeout := time.Duration(tOut) * time.Millisecond
 
-	pb.RegisterChaincodeSupportServer(grpcServer, chaincode.NewChaincodeSupport(chainname, peer.GetPeerEndpoint, userRunsCC, ccStartupTimeout, secHelper))
+	return chaincode.NewChaincodeSupport(chainname, peer.GetPeerEndpoint, userRunsCC, ccStartupTimeout, secHelper)
 }
 
 func checkChaincodeCmdParams(cmd *cobra.Command) (err error) {
@@ -789,7 +1521,7 @@ func chaincodeDeploy(cmd *cobra.Command, args []string) (err error) {
 	}
 	chaincodeLang = strings.ToUpper(chaincodeLang)
 	spec := &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_Type(pb.ChaincodeSpec_Type_value[chaincodeLang]),
-		ChaincodeID: &pb.ChaincodeID{Path: chaincodePath, Name: chaincodeName}, CtorMsg: input}
+		ChaincodeID: &pb.ChaincodeID{Path: chaincodePath, Name: chaincodeName}, CtorMsg: input, ChainID: chaincodeChainID}
 
 	// If security is enabled, add client login token
 	if core.SecurityEnabled() {
@@ -880,7 +1612,7 @@ func chaincodeInvokeOrQuery(cmd *cobra.Command, args []string, invoke bool) (err
 	}
 	chaincodeLang = strings.ToUpper(chaincodeLang)
 	spec := &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_Type(pb.ChaincodeSpec_Type_value[chaincodeLang]),
-		ChaincodeID: &pb.ChaincodeID{Name: chaincodeName}, CtorMsg: input}
+		ChaincodeID: &pb.ChaincodeID{Name: chaincodeName}, CtorMsg: input, ChainID: chaincodeChainID}
 
 	// If security is enabled, add client login token
 	if core.SecurityEnabled() {
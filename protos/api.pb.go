@@ -7,6 +7,7 @@ Package protos is a generated protocol buffer package.
 
 It is generated from these files:
 	api.proto
+	atomicbroadcast.proto
 	chaincode.proto
 	devops.proto
 	events.proto
@@ -16,6 +17,16 @@ It is generated from these files:
 It has these top-level messages:
 	BlockNumber
 	BlockCount
+	HistoryKey
+	StateKey
+	BlockRange
+	TransactionRange
+	BlockTransaction
+	StateAtHeight
+	StateValue
+	BroadcastResponse
+	DeliverRequest
+	OrderedBatch
 	ChaincodeID
 	ChaincodeInput
 	ChaincodeSpec
@@ -24,11 +35,19 @@ It has these top-level messages:
 	ChaincodeSecurityContext
 	ChaincodeMessage
 	PutStateInfo
+	GetStateMultiInfo
+	GetStateMultiResponse
+	PutStateMultiInfo
 	RangeQueryState
 	RangeQueryStateNext
 	RangeQueryStateClose
+	QueryState
+	PartialCompositeKey
+	IndexRange
 	RangeQueryStateKeyValue
 	RangeQueryStateResponse
+	KeyModification
+	KeyHistory
 	Secret
 	BuildResult
 	Interest
@@ -40,6 +59,15 @@ It has these top-level messages:
 	TransactionResult
 	Block
 	BlockchainInfo
+	LedgerSnapshot
+	BlockHeader
+	LedgerSnapshotChunk
+	StateProof
+	BucketTreeProof
+	TrieProof
+	StateProofKeyValue
+	StateProofNode
+	StateProofChild
 	NonHashData
 	PeerAddress
 	PeerID
@@ -56,6 +84,12 @@ It has these top-level messages:
 	SyncStateDeltasRequest
 	SyncStateDeltas
 	ServerStatus
+	ConsensusParameters
+	ConsensusParametersRequest
+	LedgerCompaction
+	LedgerSnapshotRequest
+	DBStats
+	ColumnFamilyStats
 */
 package protos
 
@@ -92,6 +126,91 @@ func (m *BlockCount) Reset()         { *m = BlockCount{} }
 func (m *BlockCount) String() string { return proto.CompactTextString(m) }
 func (*BlockCount) ProtoMessage()    {}
 
+// Specifies the key of the state of a chaincode whose history is returned.
+type HistoryKey struct {
+	ChaincodeID string `protobuf:"bytes,1,opt,name=chaincodeID" json:"chaincodeID,omitempty"`
+	Key         string `protobuf:"bytes,2,opt,name=key" json:"key,omitempty"`
+}
+
+func (m *HistoryKey) Reset()         { *m = HistoryKey{} }
+func (m *HistoryKey) String() string { return proto.CompactTextString(m) }
+func (*HistoryKey) ProtoMessage()    {}
+
+// Specifies the key of the state of a chaincode whose proof is returned.
+type StateKey struct {
+	ChaincodeID string `protobuf:"bytes,1,opt,name=chaincodeID" json:"chaincodeID,omitempty"`
+	Key         string `protobuf:"bytes,2,opt,name=key" json:"key,omitempty"`
+}
+
+func (m *StateKey) Reset()         { *m = StateKey{} }
+func (m *StateKey) String() string { return proto.CompactTextString(m) }
+func (*StateKey) ProtoMessage()    {}
+
+// Specifies a range of blocks of the blockchain, from block start on. At
+// most count blocks are returned, fewer if the server allows fewer at once,
+// as many as allowed if count is 0.
+type BlockRange struct {
+	Start uint64 `protobuf:"varint,1,opt,name=start" json:"start,omitempty"`
+	Count uint64 `protobuf:"varint,2,opt,name=count" json:"count,omitempty"`
+}
+
+func (m *BlockRange) Reset()         { *m = BlockRange{} }
+func (m *BlockRange) String() string { return proto.CompactTextString(m) }
+func (*BlockRange) ProtoMessage()    {}
+
+// Specifies a range of transactions of the blockchain, from the transaction
+// at index in block on, across blocks. At most count transactions are
+// returned, fewer if the server allows fewer at once, as many as allowed if
+// count is 0.
+type TransactionRange struct {
+	Block uint64 `protobuf:"varint,1,opt,name=block" json:"block,omitempty"`
+	Index uint64 `protobuf:"varint,2,opt,name=index" json:"index,omitempty"`
+	Count uint64 `protobuf:"varint,3,opt,name=count" json:"count,omitempty"`
+}
+
+func (m *TransactionRange) Reset()         { *m = TransactionRange{} }
+func (m *TransactionRange) String() string { return proto.CompactTextString(m) }
+func (*TransactionRange) ProtoMessage()    {}
+
+// A transaction of a range, with its position in the blockchain.
+type BlockTransaction struct {
+	Block       uint64       `protobuf:"varint,1,opt,name=block" json:"block,omitempty"`
+	Index       uint64       `protobuf:"varint,2,opt,name=index" json:"index,omitempty"`
+	Transaction *Transaction `protobuf:"bytes,3,opt,name=transaction" json:"transaction,omitempty"`
+}
+
+func (m *BlockTransaction) Reset()         { *m = BlockTransaction{} }
+func (m *BlockTransaction) String() string { return proto.CompactTextString(m) }
+func (*BlockTransaction) ProtoMessage()    {}
+
+func (m *BlockTransaction) GetTransaction() *Transaction {
+	if m != nil {
+		return m.Transaction
+	}
+	return nil
+}
+
+// Specifies the key of the state of a chaincode whose value as of a block
+// height is returned.
+type StateAtHeight struct {
+	ChaincodeID string `protobuf:"bytes,1,opt,name=chaincodeID" json:"chaincodeID,omitempty"`
+	Key         string `protobuf:"bytes,2,opt,name=key" json:"key,omitempty"`
+	Height      uint64 `protobuf:"varint,3,opt,name=height" json:"height,omitempty"`
+}
+
+func (m *StateAtHeight) Reset()         { *m = StateAtHeight{} }
+func (m *StateAtHeight) String() string { return proto.CompactTextString(m) }
+func (*StateAtHeight) ProtoMessage()    {}
+
+// The value of a key of the state of a chaincode, empty if the key had none.
+type StateValue struct {
+	Value []byte `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (m *StateValue) Reset()         { *m = StateValue{} }
+func (m *StateValue) String() string { return proto.CompactTextString(m) }
+func (*StateValue) ProtoMessage()    {}
+
 // Reference imports to suppress errors if they are not otherwise used.
 var _ context.Context
 var _ grpc.ClientConn
@@ -111,6 +230,21 @@ type OpenchainClient interface {
 	// GetPeers returns a list of all peer nodes currently connected to the target
 	// peer.
 	GetPeers(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*PeersMessage, error)
+	// GetHistoryForKey returns the modifications of a key of the state of a
+	// chaincode by the committed transactions, from the oldest to the latest.
+	GetHistoryForKey(ctx context.Context, in *HistoryKey, opts ...grpc.CallOption) (*KeyHistory, error)
+	// GetStateProof returns a proof that a key of the state of a chaincode
+	// holds its value, or none, in the state recorded in the last block.
+	GetStateProof(ctx context.Context, in *StateKey, opts ...grpc.CallOption) (*StateProof, error)
+	// GetBlocks streams the blocks of a range of the blockchain, at most as
+	// many as the server allows at once. Clients page through longer ranges.
+	GetBlocks(ctx context.Context, in *BlockRange, opts ...grpc.CallOption) (Openchain_GetBlocksClient, error)
+	// GetTransactions streams the transactions of a range of the blockchain,
+	// across blocks, at most as many as the server allows at once.
+	GetTransactions(ctx context.Context, in *TransactionRange, opts ...grpc.CallOption) (Openchain_GetTransactionsClient, error)
+	// GetStateAt returns the value of a key of the state of a chaincode as of
+	// a block height, in the state recorded in the block before that height.
+	GetStateAt(ctx context.Context, in *StateAtHeight, opts ...grpc.CallOption) (*StateValue, error)
 }
 
 type openchainClient struct {
@@ -157,6 +291,97 @@ func (c *openchainClient) GetPeers(ctx context.Context, in *google_protobuf1.Emp
 	return out, nil
 }
 
+func (c *openchainClient) GetHistoryForKey(ctx context.Context, in *HistoryKey, opts ...grpc.CallOption) (*KeyHistory, error) {
+	out := new(KeyHistory)
+	err := grpc.Invoke(ctx, "/protos.Openchain/GetHistoryForKey", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *openchainClient) GetStateProof(ctx context.Context, in *StateKey, opts ...grpc.CallOption) (*StateProof, error) {
+	out := new(StateProof)
+	err := grpc.Invoke(ctx, "/protos.Openchain/GetStateProof", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *openchainClient) GetBlocks(ctx context.Context, in *BlockRange, opts ...grpc.CallOption) (Openchain_GetBlocksClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Openchain_serviceDesc.Streams[0], c.cc, "/protos.Openchain/GetBlocks", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &openchainGetBlocksClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Openchain_GetBlocksClient interface {
+	Recv() (*Block, error)
+	grpc.ClientStream
+}
+
+type openchainGetBlocksClient struct {
+	grpc.ClientStream
+}
+
+func (x *openchainGetBlocksClient) Recv() (*Block, error) {
+	m := new(Block)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *openchainClient) GetTransactions(ctx context.Context, in *TransactionRange, opts ...grpc.CallOption) (Openchain_GetTransactionsClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Openchain_serviceDesc.Streams[1], c.cc, "/protos.Openchain/GetTransactions", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &openchainGetTransactionsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Openchain_GetTransactionsClient interface {
+	Recv() (*BlockTransaction, error)
+	grpc.ClientStream
+}
+
+type openchainGetTransactionsClient struct {
+	grpc.ClientStream
+}
+
+func (x *openchainGetTransactionsClient) Recv() (*BlockTransaction, error) {
+	m := new(BlockTransaction)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *openchainClient) GetStateAt(ctx context.Context, in *StateAtHeight, opts ...grpc.CallOption) (*StateValue, error) {
+	out := new(StateValue)
+	err := grpc.Invoke(ctx, "/protos.Openchain/GetStateAt", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for Openchain service
 
 type OpenchainServer interface {
@@ -172,6 +397,21 @@ type OpenchainServer interface {
 	// GetPeers returns a list of all peer nodes currently connected to the target
 	// peer.
 	GetPeers(context.Context, *google_protobuf1.Empty) (*PeersMessage, error)
+	// GetHistoryForKey returns the modifications of a key of the state of a
+	// chaincode by the committed transactions, from the oldest to the latest.
+	GetHistoryForKey(context.Context, *HistoryKey) (*KeyHistory, error)
+	// GetStateProof returns a proof that a key of the state of a chaincode
+	// holds its value, or none, in the state recorded in the last block.
+	GetStateProof(context.Context, *StateKey) (*StateProof, error)
+	// GetBlocks streams the blocks of a range of the blockchain, at most as
+	// many as the server allows at once. Clients page through longer ranges.
+	GetBlocks(*BlockRange, Openchain_GetBlocksServer) error
+	// GetTransactions streams the transactions of a range of the blockchain,
+	// across blocks, at most as many as the server allows at once.
+	GetTransactions(*TransactionRange, Openchain_GetTransactionsServer) error
+	// GetStateAt returns the value of a key of the state of a chaincode as of
+	// a block height, in the state recorded in the block before that height.
+	GetStateAt(context.Context, *StateAtHeight) (*StateValue, error)
 }
 
 func RegisterOpenchainServer(s *grpc.Server, srv OpenchainServer) {
@@ -226,6 +466,84 @@ func _Openchain_GetPeers_Handler(srv interface{}, ctx context.Context, dec func(
 	return out, nil
 }
 
+func _Openchain_GetHistoryForKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(HistoryKey)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(OpenchainServer).GetHistoryForKey(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Openchain_GetStateProof_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(StateKey)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(OpenchainServer).GetStateProof(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Openchain_GetBlocks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BlockRange)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(OpenchainServer).GetBlocks(m, &openchainGetBlocksServer{stream})
+}
+
+type Openchain_GetBlocksServer interface {
+	Send(*Block) error
+	grpc.ServerStream
+}
+
+type openchainGetBlocksServer struct {
+	grpc.ServerStream
+}
+
+func (x *openchainGetBlocksServer) Send(m *Block) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Openchain_GetTransactions_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TransactionRange)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(OpenchainServer).GetTransactions(m, &openchainGetTransactionsServer{stream})
+}
+
+type Openchain_GetTransactionsServer interface {
+	Send(*BlockTransaction) error
+	grpc.ServerStream
+}
+
+type openchainGetTransactionsServer struct {
+	grpc.ServerStream
+}
+
+func (x *openchainGetTransactionsServer) Send(m *BlockTransaction) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Openchain_GetStateAt_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(StateAtHeight)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(OpenchainServer).GetStateAt(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 var _Openchain_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "protos.Openchain",
 	HandlerType: (*OpenchainServer)(nil),
@@ -246,6 +564,29 @@ var _Openchain_serviceDesc = grpc.ServiceDesc{
 			MethodName: "GetPeers",
 			Handler:    _Openchain_GetPeers_Handler,
 		},
+		{
+			MethodName: "GetHistoryForKey",
+			Handler:    _Openchain_GetHistoryForKey_Handler,
+		},
+		{
+			MethodName: "GetStateProof",
+			Handler:    _Openchain_GetStateProof_Handler,
+		},
+		{
+			MethodName: "GetStateAt",
+			Handler:    _Openchain_GetStateAt_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "GetBlocks",
+			Handler:       _Openchain_GetBlocks_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "GetTransactions",
+			Handler:       _Openchain_GetTransactions_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams: []grpc.StreamDesc{},
 }
@@ -0,0 +1,208 @@
+// Code generated by protoc-gen-go.
+// source: atomicbroadcast.proto
+// DO NOT EDIT!
+
+package protos
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+type BroadcastResponse_StatusCode int32
+
+const (
+	BroadcastResponse_SUCCESS             BroadcastResponse_StatusCode = 0
+	BroadcastResponse_BAD_REQUEST         BroadcastResponse_StatusCode = 1
+	BroadcastResponse_SERVICE_UNAVAILABLE BroadcastResponse_StatusCode = 2
+)
+
+var BroadcastResponse_StatusCode_name = map[int32]string{
+	0: "SUCCESS",
+	1: "BAD_REQUEST",
+	2: "SERVICE_UNAVAILABLE",
+}
+var BroadcastResponse_StatusCode_value = map[string]int32{
+	"SUCCESS":             0,
+	"BAD_REQUEST":         1,
+	"SERVICE_UNAVAILABLE": 2,
+}
+
+func (x BroadcastResponse_StatusCode) String() string {
+	return proto.EnumName(BroadcastResponse_StatusCode_name, int32(x))
+}
+
+type BroadcastResponse struct {
+	Status BroadcastResponse_StatusCode `protobuf:"varint,1,opt,name=status,enum=protos.BroadcastResponse_StatusCode" json:"status,omitempty"`
+	Info   string                       `protobuf:"bytes,2,opt,name=info" json:"info,omitempty"`
+}
+
+func (m *BroadcastResponse) Reset()         { *m = BroadcastResponse{} }
+func (m *BroadcastResponse) String() string { return proto.CompactTextString(m) }
+func (*BroadcastResponse) ProtoMessage()    {}
+
+type DeliverRequest struct {
+	Start uint64 `protobuf:"varint,1,opt,name=start" json:"start,omitempty"`
+}
+
+func (m *DeliverRequest) Reset()         { *m = DeliverRequest{} }
+func (m *DeliverRequest) String() string { return proto.CompactTextString(m) }
+func (*DeliverRequest) ProtoMessage()    {}
+
+// A batch of transactions in the order agreed on. Batches are numbered
+// from 0, and each is chained to the previous one by its hash.
+type OrderedBatch struct {
+	Number            uint64         `protobuf:"varint,1,opt,name=number" json:"number,omitempty"`
+	PreviousBatchHash []byte         `protobuf:"bytes,2,opt,name=previousBatchHash,proto3" json:"previousBatchHash,omitempty"`
+	Transactions      []*Transaction `protobuf:"bytes,3,rep,name=transactions" json:"transactions,omitempty"`
+}
+
+func (m *OrderedBatch) Reset()         { *m = OrderedBatch{} }
+func (m *OrderedBatch) String() string { return proto.CompactTextString(m) }
+func (*OrderedBatch) ProtoMessage()    {}
+
+func (m *OrderedBatch) GetTransactions() []*Transaction {
+	if m != nil {
+		return m.Transactions
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterEnum("protos.BroadcastResponse_StatusCode", BroadcastResponse_StatusCode_name, BroadcastResponse_StatusCode_value)
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// Client API for AtomicBroadcast service
+
+type AtomicBroadcastClient interface {
+	// Submit a transaction to be ordered.
+	Broadcast(ctx context.Context, in *Transaction, opts ...grpc.CallOption) (*BroadcastResponse, error)
+	// Stream the ordered batches from a batch number on, as they are cut.
+	Deliver(ctx context.Context, in *DeliverRequest, opts ...grpc.CallOption) (AtomicBroadcast_DeliverClient, error)
+}
+
+type atomicBroadcastClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewAtomicBroadcastClient(cc *grpc.ClientConn) AtomicBroadcastClient {
+	return &atomicBroadcastClient{cc}
+}
+
+func (c *atomicBroadcastClient) Broadcast(ctx context.Context, in *Transaction, opts ...grpc.CallOption) (*BroadcastResponse, error) {
+	out := new(BroadcastResponse)
+	err := grpc.Invoke(ctx, "/protos.AtomicBroadcast/Broadcast", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *atomicBroadcastClient) Deliver(ctx context.Context, in *DeliverRequest, opts ...grpc.CallOption) (AtomicBroadcast_DeliverClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_AtomicBroadcast_serviceDesc.Streams[0], c.cc, "/protos.AtomicBroadcast/Deliver", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &atomicBroadcastDeliverClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type AtomicBroadcast_DeliverClient interface {
+	Recv() (*OrderedBatch, error)
+	grpc.ClientStream
+}
+
+type atomicBroadcastDeliverClient struct {
+	grpc.ClientStream
+}
+
+func (x *atomicBroadcastDeliverClient) Recv() (*OrderedBatch, error) {
+	m := new(OrderedBatch)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Server API for AtomicBroadcast service
+
+type AtomicBroadcastServer interface {
+	// Submit a transaction to be ordered.
+	Broadcast(context.Context, *Transaction) (*BroadcastResponse, error)
+	// Stream the ordered batches from a batch number on, as they are cut.
+	Deliver(*DeliverRequest, AtomicBroadcast_DeliverServer) error
+}
+
+func RegisterAtomicBroadcastServer(s *grpc.Server, srv AtomicBroadcastServer) {
+	s.RegisterService(&_AtomicBroadcast_serviceDesc, srv)
+}
+
+func _AtomicBroadcast_Broadcast_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(Transaction)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AtomicBroadcastServer).Broadcast(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _AtomicBroadcast_Deliver_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DeliverRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AtomicBroadcastServer).Deliver(m, &atomicBroadcastDeliverServer{stream})
+}
+
+type AtomicBroadcast_DeliverServer interface {
+	Send(*OrderedBatch) error
+	grpc.ServerStream
+}
+
+type atomicBroadcastDeliverServer struct {
+	grpc.ServerStream
+}
+
+func (x *atomicBroadcastDeliverServer) Send(m *OrderedBatch) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _AtomicBroadcast_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "protos.AtomicBroadcast",
+	HandlerType: (*AtomicBroadcastServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Broadcast",
+			Handler:    _AtomicBroadcast_Broadcast_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Deliver",
+			Handler:       _AtomicBroadcast_Deliver_Handler,
+			ServerStreams: true,
+		},
+	},
+}
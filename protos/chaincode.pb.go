@@ -89,26 +89,32 @@ func (x ChaincodeDeploymentSpec_ExecutionEnvironment) String() string {
 type ChaincodeMessage_Type int32
 
 const (
-	ChaincodeMessage_UNDEFINED               ChaincodeMessage_Type = 0
-	ChaincodeMessage_REGISTER                ChaincodeMessage_Type = 1
-	ChaincodeMessage_REGISTERED              ChaincodeMessage_Type = 2
-	ChaincodeMessage_INIT                    ChaincodeMessage_Type = 3
-	ChaincodeMessage_READY                   ChaincodeMessage_Type = 4
-	ChaincodeMessage_TRANSACTION             ChaincodeMessage_Type = 5
-	ChaincodeMessage_COMPLETED               ChaincodeMessage_Type = 6
-	ChaincodeMessage_ERROR                   ChaincodeMessage_Type = 7
-	ChaincodeMessage_GET_STATE               ChaincodeMessage_Type = 8
-	ChaincodeMessage_PUT_STATE               ChaincodeMessage_Type = 9
-	ChaincodeMessage_DEL_STATE               ChaincodeMessage_Type = 10
-	ChaincodeMessage_INVOKE_CHAINCODE        ChaincodeMessage_Type = 11
-	ChaincodeMessage_INVOKE_QUERY            ChaincodeMessage_Type = 12
-	ChaincodeMessage_RESPONSE                ChaincodeMessage_Type = 13
-	ChaincodeMessage_QUERY                   ChaincodeMessage_Type = 14
-	ChaincodeMessage_QUERY_COMPLETED         ChaincodeMessage_Type = 15
-	ChaincodeMessage_QUERY_ERROR             ChaincodeMessage_Type = 16
-	ChaincodeMessage_RANGE_QUERY_STATE       ChaincodeMessage_Type = 17
-	ChaincodeMessage_RANGE_QUERY_STATE_NEXT  ChaincodeMessage_Type = 18
-	ChaincodeMessage_RANGE_QUERY_STATE_CLOSE ChaincodeMessage_Type = 19
+	ChaincodeMessage_UNDEFINED                          ChaincodeMessage_Type = 0
+	ChaincodeMessage_REGISTER                           ChaincodeMessage_Type = 1
+	ChaincodeMessage_REGISTERED                         ChaincodeMessage_Type = 2
+	ChaincodeMessage_INIT                               ChaincodeMessage_Type = 3
+	ChaincodeMessage_READY                              ChaincodeMessage_Type = 4
+	ChaincodeMessage_TRANSACTION                        ChaincodeMessage_Type = 5
+	ChaincodeMessage_COMPLETED                          ChaincodeMessage_Type = 6
+	ChaincodeMessage_ERROR                              ChaincodeMessage_Type = 7
+	ChaincodeMessage_GET_STATE                          ChaincodeMessage_Type = 8
+	ChaincodeMessage_PUT_STATE                          ChaincodeMessage_Type = 9
+	ChaincodeMessage_DEL_STATE                          ChaincodeMessage_Type = 10
+	ChaincodeMessage_INVOKE_CHAINCODE                   ChaincodeMessage_Type = 11
+	ChaincodeMessage_INVOKE_QUERY                       ChaincodeMessage_Type = 12
+	ChaincodeMessage_RESPONSE                           ChaincodeMessage_Type = 13
+	ChaincodeMessage_QUERY                              ChaincodeMessage_Type = 14
+	ChaincodeMessage_QUERY_COMPLETED                    ChaincodeMessage_Type = 15
+	ChaincodeMessage_QUERY_ERROR                        ChaincodeMessage_Type = 16
+	ChaincodeMessage_RANGE_QUERY_STATE                  ChaincodeMessage_Type = 17
+	ChaincodeMessage_RANGE_QUERY_STATE_NEXT             ChaincodeMessage_Type = 18
+	ChaincodeMessage_RANGE_QUERY_STATE_CLOSE            ChaincodeMessage_Type = 19
+	ChaincodeMessage_QUERY_STATE                        ChaincodeMessage_Type = 20
+	ChaincodeMessage_GET_HISTORY_FOR_KEY                ChaincodeMessage_Type = 21
+	ChaincodeMessage_GET_STATE_MULTI                    ChaincodeMessage_Type = 22
+	ChaincodeMessage_PUT_STATE_MULTI                    ChaincodeMessage_Type = 23
+	ChaincodeMessage_GET_STATE_BY_PARTIAL_COMPOSITE_KEY ChaincodeMessage_Type = 24
+	ChaincodeMessage_GET_STATE_BY_INDEX                 ChaincodeMessage_Type = 25
 )
 
 var ChaincodeMessage_Type_name = map[int32]string{
@@ -132,28 +138,40 @@ var ChaincodeMessage_Type_name = map[int32]string{
 	17: "RANGE_QUERY_STATE",
 	18: "RANGE_QUERY_STATE_NEXT",
 	19: "RANGE_QUERY_STATE_CLOSE",
+	20: "QUERY_STATE",
+	21: "GET_HISTORY_FOR_KEY",
+	22: "GET_STATE_MULTI",
+	23: "PUT_STATE_MULTI",
+	24: "GET_STATE_BY_PARTIAL_COMPOSITE_KEY",
+	25: "GET_STATE_BY_INDEX",
 }
 var ChaincodeMessage_Type_value = map[string]int32{
-	"UNDEFINED":               0,
-	"REGISTER":                1,
-	"REGISTERED":              2,
-	"INIT":                    3,
-	"READY":                   4,
-	"TRANSACTION":             5,
-	"COMPLETED":               6,
-	"ERROR":                   7,
-	"GET_STATE":               8,
-	"PUT_STATE":               9,
-	"DEL_STATE":               10,
-	"INVOKE_CHAINCODE":        11,
-	"INVOKE_QUERY":            12,
-	"RESPONSE":                13,
-	"QUERY":                   14,
-	"QUERY_COMPLETED":         15,
-	"QUERY_ERROR":             16,
-	"RANGE_QUERY_STATE":       17,
-	"RANGE_QUERY_STATE_NEXT":  18,
-	"RANGE_QUERY_STATE_CLOSE": 19,
+	"UNDEFINED":                          0,
+	"REGISTER":                           1,
+	"REGISTERED":                         2,
+	"INIT":                               3,
+	"READY":                              4,
+	"TRANSACTION":                        5,
+	"COMPLETED":                          6,
+	"ERROR":                              7,
+	"GET_STATE":                          8,
+	"PUT_STATE":                          9,
+	"DEL_STATE":                          10,
+	"INVOKE_CHAINCODE":                   11,
+	"INVOKE_QUERY":                       12,
+	"RESPONSE":                           13,
+	"QUERY":                              14,
+	"QUERY_COMPLETED":                    15,
+	"QUERY_ERROR":                        16,
+	"RANGE_QUERY_STATE":                  17,
+	"RANGE_QUERY_STATE_NEXT":             18,
+	"RANGE_QUERY_STATE_CLOSE":            19,
+	"QUERY_STATE":                        20,
+	"GET_HISTORY_FOR_KEY":                21,
+	"GET_STATE_MULTI":                    22,
+	"PUT_STATE_MULTI":                    23,
+	"GET_STATE_BY_PARTIAL_COMPOSITE_KEY": 24,
+	"GET_STATE_BY_INDEX":                 25,
 }
 
 func (x ChaincodeMessage_Type) String() string {
@@ -199,6 +217,8 @@ type ChaincodeSpec struct {
 	SecureContext        string               `protobuf:"bytes,5,opt,name=secureContext" json:"secureContext,omitempty"`
 	ConfidentialityLevel ConfidentialityLevel `protobuf:"varint,6,opt,name=confidentialityLevel,enum=protos.ConfidentialityLevel" json:"confidentialityLevel,omitempty"`
 	Metadata             []byte               `protobuf:"bytes,7,opt,name=metadata,proto3" json:"metadata,omitempty"`
+	// chain the chaincode is deployed on or executed on, empty for the default chain
+	ChainID string `protobuf:"bytes,8,opt,name=chainID" json:"chainID,omitempty"`
 }
 
 func (m *ChaincodeSpec) Reset()         { *m = ChaincodeSpec{} }
@@ -323,6 +343,41 @@ func (m *PutStateInfo) Reset()         { *m = PutStateInfo{} }
 func (m *PutStateInfo) String() string { return proto.CompactTextString(m) }
 func (*PutStateInfo) ProtoMessage()    {}
 
+// The keys read by a GET_STATE_MULTI
+type GetStateMultiInfo struct {
+	Keys []string `protobuf:"bytes,1,rep,name=keys" json:"keys,omitempty"`
+}
+
+func (m *GetStateMultiInfo) Reset()         { *m = GetStateMultiInfo{} }
+func (m *GetStateMultiInfo) String() string { return proto.CompactTextString(m) }
+func (*GetStateMultiInfo) ProtoMessage()    {}
+
+// The response to a GET_STATE_MULTI, the values of the keys in the order
+// they were read, empty for the keys not set
+type GetStateMultiResponse struct {
+	Values [][]byte `protobuf:"bytes,1,rep,name=values,proto3" json:"values,omitempty"`
+}
+
+func (m *GetStateMultiResponse) Reset()         { *m = GetStateMultiResponse{} }
+func (m *GetStateMultiResponse) String() string { return proto.CompactTextString(m) }
+func (*GetStateMultiResponse) ProtoMessage()    {}
+
+// The key-values written by a PUT_STATE_MULTI
+type PutStateMultiInfo struct {
+	KeyValues []*PutStateInfo `protobuf:"bytes,1,rep,name=keyValues" json:"keyValues,omitempty"`
+}
+
+func (m *PutStateMultiInfo) Reset()         { *m = PutStateMultiInfo{} }
+func (m *PutStateMultiInfo) String() string { return proto.CompactTextString(m) }
+func (*PutStateMultiInfo) ProtoMessage()    {}
+
+func (m *PutStateMultiInfo) GetKeyValues() []*PutStateInfo {
+	if m != nil {
+		return m.KeyValues
+	}
+	return nil
+}
+
 type RangeQueryState struct {
 	StartKey string `protobuf:"bytes,1,opt,name=startKey" json:"startKey,omitempty"`
 	EndKey   string `protobuf:"bytes,2,opt,name=endKey" json:"endKey,omitempty"`
@@ -348,6 +403,41 @@ func (m *RangeQueryStateClose) Reset()         { *m = RangeQueryStateClose{} }
 func (m *RangeQueryStateClose) String() string { return proto.CompactTextString(m) }
 func (*RangeQueryStateClose) ProtoMessage()    {}
 
+// The results of a QUERY_STATE are returned in a RangeQueryStateResponse,
+// and iterated with RANGE_QUERY_STATE_NEXT and RANGE_QUERY_STATE_CLOSE
+type QueryState struct {
+	Query string `protobuf:"bytes,1,opt,name=query" json:"query,omitempty"`
+}
+
+func (m *QueryState) Reset()         { *m = QueryState{} }
+func (m *QueryState) String() string { return proto.CompactTextString(m) }
+func (*QueryState) ProtoMessage()    {}
+
+// The composite keys whose state a GET_STATE_BY_PARTIAL_COMPOSITE_KEY reads,
+// those of objectType starting with attributes
+type PartialCompositeKey struct {
+	ObjectType string   `protobuf:"bytes,1,opt,name=objectType" json:"objectType,omitempty"`
+	Attributes []string `protobuf:"bytes,2,rep,name=attributes" json:"attributes,omitempty"`
+}
+
+func (m *PartialCompositeKey) Reset()         { *m = PartialCompositeKey{} }
+func (m *PartialCompositeKey) String() string { return proto.CompactTextString(m) }
+func (*PartialCompositeKey) ProtoMessage()    {}
+
+// The keys whose state a GET_STATE_BY_INDEX reads, those whose field of the
+// index of the chaincode is between startValue and endValue, inclusive, or
+// from startValue on if endValue is empty. The results are returned in order
+// of the values of the field, then of the keys, as for a QUERY_STATE
+type IndexRange struct {
+	Index      string `protobuf:"bytes,1,opt,name=index" json:"index,omitempty"`
+	StartValue string `protobuf:"bytes,2,opt,name=startValue" json:"startValue,omitempty"`
+	EndValue   string `protobuf:"bytes,3,opt,name=endValue" json:"endValue,omitempty"`
+}
+
+func (m *IndexRange) Reset()         { *m = IndexRange{} }
+func (m *IndexRange) String() string { return proto.CompactTextString(m) }
+func (*IndexRange) ProtoMessage()    {}
+
 type RangeQueryStateKeyValue struct {
 	Key   string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
 	Value []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
@@ -374,6 +464,35 @@ func (m *RangeQueryStateResponse) GetKeysAndValues() []*RangeQueryStateKeyValue
 	return nil
 }
 
+// A change of the value of a key by a transaction
+type KeyModification struct {
+	TxUuid      string `protobuf:"bytes,1,opt,name=txUuid" json:"txUuid,omitempty"`
+	BlockNumber uint64 `protobuf:"varint,2,opt,name=blockNumber" json:"blockNumber,omitempty"`
+	Value       []byte `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	IsDelete    bool   `protobuf:"varint,4,opt,name=isDelete" json:"isDelete,omitempty"`
+}
+
+func (m *KeyModification) Reset()         { *m = KeyModification{} }
+func (m *KeyModification) String() string { return proto.CompactTextString(m) }
+func (*KeyModification) ProtoMessage()    {}
+
+// The response to a GET_HISTORY_FOR_KEY, the modifications of the key from
+// the oldest to the latest
+type KeyHistory struct {
+	Modifications []*KeyModification `protobuf:"bytes,1,rep,name=modifications" json:"modifications,omitempty"`
+}
+
+func (m *KeyHistory) Reset()         { *m = KeyHistory{} }
+func (m *KeyHistory) String() string { return proto.CompactTextString(m) }
+func (*KeyHistory) ProtoMessage()    {}
+
+func (m *KeyHistory) GetModifications() []*KeyModification {
+	if m != nil {
+		return m.Modifications
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("protos.ConfidentialityLevel", ConfidentialityLevel_name, ConfidentialityLevel_value)
 	proto.RegisterEnum("protos.ChaincodeSpec_Type", ChaincodeSpec_Type_name, ChaincodeSpec_Type_value)
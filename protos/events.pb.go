@@ -47,6 +47,7 @@ func (x Interest_ResponseType) String() string {
 type Interest struct {
 	EventType    string                `protobuf:"bytes,1,opt,name=eventType" json:"eventType,omitempty"`
 	ResponseType Interest_ResponseType `protobuf:"varint,2,opt,name=responseType,enum=protos.Interest_ResponseType" json:"responseType,omitempty"`
+	ChainID      string                `protobuf:"bytes,3,opt,name=chainID" json:"chainID,omitempty"`
 }
 
 func (m *Interest) Reset()         { *m = Interest{} }
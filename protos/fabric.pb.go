@@ -179,6 +179,8 @@ type Transaction struct {
 	ToValidators                   []byte                     `protobuf:"bytes,10,opt,name=toValidators,proto3" json:"toValidators,omitempty"`
 	Cert                           []byte                     `protobuf:"bytes,11,opt,name=cert,proto3" json:"cert,omitempty"`
 	Signature                      []byte                     `protobuf:"bytes,12,opt,name=signature,proto3" json:"signature,omitempty"`
+	// chain the transaction is executed on, empty for the default chain
+	ChainID string `protobuf:"bytes,13,opt,name=chainID" json:"chainID,omitempty"`
 }
 
 func (m *Transaction) Reset()         { *m = Transaction{} }
@@ -245,6 +247,7 @@ type Block struct {
 	PreviousBlockHash []byte                     `protobuf:"bytes,5,opt,name=previousBlockHash,proto3" json:"previousBlockHash,omitempty"`
 	ConsensusMetadata []byte                     `protobuf:"bytes,6,opt,name=consensusMetadata,proto3" json:"consensusMetadata,omitempty"`
 	NonHashData       *NonHashData               `protobuf:"bytes,7,opt,name=nonHashData" json:"nonHashData,omitempty"`
+	ChainID           string                     `protobuf:"bytes,8,opt,name=chainID" json:"chainID,omitempty"`
 }
 
 func (m *Block) Reset()         { *m = Block{} }
@@ -284,6 +287,178 @@ func (m *BlockchainInfo) Reset()         { *m = BlockchainInfo{} }
 func (m *BlockchainInfo) String() string { return proto.CompactTextString(m) }
 func (*BlockchainInfo) ProtoMessage()    {}
 
+// Header of a snapshot of the ledger at some height, followed in the
+// snapshot by LedgerSnapshotChunks.
+type LedgerSnapshot struct {
+	Height       uint64 `protobuf:"varint,1,opt,name=height" json:"height,omitempty"`
+	GenesisBlock *Block `protobuf:"bytes,2,opt,name=genesisBlock" json:"genesisBlock,omitempty"`
+	HeadBlock    *Block `protobuf:"bytes,3,opt,name=headBlock" json:"headBlock,omitempty"`
+}
+
+func (m *LedgerSnapshot) Reset()         { *m = LedgerSnapshot{} }
+func (m *LedgerSnapshot) String() string { return proto.CompactTextString(m) }
+func (*LedgerSnapshot) ProtoMessage()    {}
+
+func (m *LedgerSnapshot) GetGenesisBlock() *Block {
+	if m != nil {
+		return m.GenesisBlock
+	}
+	return nil
+}
+
+func (m *LedgerSnapshot) GetHeadBlock() *Block {
+	if m != nil {
+		return m.HeadBlock
+	}
+	return nil
+}
+
+// Hashes linking a block to the blockchain and to the state.
+type BlockHeader struct {
+	Number            uint64 `protobuf:"varint,1,opt,name=number" json:"number,omitempty"`
+	Hash              []byte `protobuf:"bytes,2,opt,name=hash,proto3" json:"hash,omitempty"`
+	PreviousBlockHash []byte `protobuf:"bytes,3,opt,name=previousBlockHash,proto3" json:"previousBlockHash,omitempty"`
+	StateHash         []byte `protobuf:"bytes,4,opt,name=stateHash,proto3" json:"stateHash,omitempty"`
+}
+
+func (m *BlockHeader) Reset()         { *m = BlockHeader{} }
+func (m *BlockHeader) String() string { return proto.CompactTextString(m) }
+func (*BlockHeader) ProtoMessage()    {}
+
+// Part of a snapshot of the ledger, either the headers of consecutive
+// blocks or a state delta setting part of the state.
+type LedgerSnapshotChunk struct {
+	Headers []*BlockHeader `protobuf:"bytes,1,rep,name=headers" json:"headers,omitempty"`
+	Delta   []byte         `protobuf:"bytes,2,opt,name=delta,proto3" json:"delta,omitempty"`
+}
+
+func (m *LedgerSnapshotChunk) Reset()         { *m = LedgerSnapshotChunk{} }
+func (m *LedgerSnapshotChunk) String() string { return proto.CompactTextString(m) }
+func (*LedgerSnapshotChunk) ProtoMessage()    {}
+
+func (m *LedgerSnapshotChunk) GetHeaders() []*BlockHeader {
+	if m != nil {
+		return m.Headers
+	}
+	return nil
+}
+
+// Proof that the key of a chaincode holds value, or no value if exists is
+// false, in the state whose hash stateHash is recorded in block blockNumber.
+// It carries the path from the key up to the root of the state data
+// structure of the peer, either a bucket tree or a trie.
+type StateProof struct {
+	BlockNumber uint64           `protobuf:"varint,1,opt,name=blockNumber" json:"blockNumber,omitempty"`
+	StateHash   []byte           `protobuf:"bytes,2,opt,name=stateHash,proto3" json:"stateHash,omitempty"`
+	ChaincodeID string           `protobuf:"bytes,3,opt,name=chaincodeID" json:"chaincodeID,omitempty"`
+	Key         string           `protobuf:"bytes,4,opt,name=key" json:"key,omitempty"`
+	Exists      bool             `protobuf:"varint,5,opt,name=exists" json:"exists,omitempty"`
+	Value       []byte           `protobuf:"bytes,6,opt,name=value,proto3" json:"value,omitempty"`
+	BucketTree  *BucketTreeProof `protobuf:"bytes,7,opt,name=bucketTree" json:"bucketTree,omitempty"`
+	Trie        *TrieProof       `protobuf:"bytes,8,opt,name=trie" json:"trie,omitempty"`
+}
+
+func (m *StateProof) Reset()         { *m = StateProof{} }
+func (m *StateProof) String() string { return proto.CompactTextString(m) }
+func (*StateProof) ProtoMessage()    {}
+
+func (m *StateProof) GetBucketTree() *BucketTreeProof {
+	if m != nil {
+		return m.BucketTree
+	}
+	return nil
+}
+
+func (m *StateProof) GetTrie() *TrieProof {
+	if m != nil {
+		return m.Trie
+	}
+	return nil
+}
+
+// Path of a key in a bucket tree: all the key-values of the bucket of the
+// key, in the order of their composite keys, then the buckets from the
+// parent of the bucket of the key up to the root.
+type BucketTreeProof struct {
+	NumBuckets             uint32                `protobuf:"varint,1,opt,name=numBuckets" json:"numBuckets,omitempty"`
+	MaxGroupingAtEachLevel uint32                `protobuf:"varint,2,opt,name=maxGroupingAtEachLevel" json:"maxGroupingAtEachLevel,omitempty"`
+	Bucket                 []*StateProofKeyValue `protobuf:"bytes,3,rep,name=bucket" json:"bucket,omitempty"`
+	Nodes                  []*StateProofNode     `protobuf:"bytes,4,rep,name=nodes" json:"nodes,omitempty"`
+}
+
+func (m *BucketTreeProof) Reset()         { *m = BucketTreeProof{} }
+func (m *BucketTreeProof) String() string { return proto.CompactTextString(m) }
+func (*BucketTreeProof) ProtoMessage()    {}
+
+func (m *BucketTreeProof) GetBucket() []*StateProofKeyValue {
+	if m != nil {
+		return m.Bucket
+	}
+	return nil
+}
+
+func (m *BucketTreeProof) GetNodes() []*StateProofNode {
+	if m != nil {
+		return m.Nodes
+	}
+	return nil
+}
+
+// Path of a key in a trie: the nodes from the node of the key up to the
+// root. The value of the node of the key is the value proven.
+type TrieProof struct {
+	Nodes []*StateProofNode `protobuf:"bytes,1,rep,name=nodes" json:"nodes,omitempty"`
+}
+
+func (m *TrieProof) Reset()         { *m = TrieProof{} }
+func (m *TrieProof) String() string { return proto.CompactTextString(m) }
+func (*TrieProof) ProtoMessage()    {}
+
+func (m *TrieProof) GetNodes() []*StateProofNode {
+	if m != nil {
+		return m.Nodes
+	}
+	return nil
+}
+
+type StateProofKeyValue struct {
+	ChaincodeID string `protobuf:"bytes,1,opt,name=chaincodeID" json:"chaincodeID,omitempty"`
+	Key         string `protobuf:"bytes,2,opt,name=key" json:"key,omitempty"`
+	Value       []byte `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (m *StateProofKeyValue) Reset()         { *m = StateProofKeyValue{} }
+func (m *StateProofKeyValue) String() string { return proto.CompactTextString(m) }
+func (*StateProofKeyValue) ProtoMessage()    {}
+
+// Node on the path of a proof, with the hashes of its children but the one
+// on the path, which the verifier computes. Only trie nodes hold a value.
+type StateProofNode struct {
+	HasValue bool               `protobuf:"varint,1,opt,name=hasValue" json:"hasValue,omitempty"`
+	Value    []byte             `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Children []*StateProofChild `protobuf:"bytes,3,rep,name=children" json:"children,omitempty"`
+}
+
+func (m *StateProofNode) Reset()         { *m = StateProofNode{} }
+func (m *StateProofNode) String() string { return proto.CompactTextString(m) }
+func (*StateProofNode) ProtoMessage()    {}
+
+func (m *StateProofNode) GetChildren() []*StateProofChild {
+	if m != nil {
+		return m.Children
+	}
+	return nil
+}
+
+type StateProofChild struct {
+	Index      uint32 `protobuf:"varint,1,opt,name=index" json:"index,omitempty"`
+	CryptoHash []byte `protobuf:"bytes,2,opt,name=cryptoHash,proto3" json:"cryptoHash,omitempty"`
+}
+
+func (m *StateProofChild) Reset()         { *m = StateProofChild{} }
+func (m *StateProofChild) String() string { return proto.CompactTextString(m) }
+func (*StateProofChild) ProtoMessage()    {}
+
 // NonHashData is data that is recorded on the block, but not included in
 // the block hash when verifying the blockchain.
 // localLedgerCommitTimestamp - The time at which the block was added
@@ -390,6 +565,8 @@ type Message struct {
 	Timestamp *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=timestamp" json:"timestamp,omitempty"`
 	Payload   []byte                     `protobuf:"bytes,3,opt,name=payload,proto3" json:"payload,omitempty"`
 	Signature []byte                     `protobuf:"bytes,4,opt,name=signature,proto3" json:"signature,omitempty"`
+	// chain the message is about, empty for the default chain
+	ChainID string `protobuf:"bytes,5,opt,name=chainID" json:"chainID,omitempty"`
 }
 
 func (m *Message) Reset()         { *m = Message{} }
@@ -474,8 +651,12 @@ func (m *SyncBlocks) GetBlocks() []*Block {
 }
 
 // SyncSnapshotRequest Payload for the penchainMessage.SYNC_GET_SNAPSHOT message.
+// When chunks is not 0, only the chunk of the state made of the keys whose
+// hash modulo chunks is chunk is requested.
 type SyncStateSnapshotRequest struct {
 	CorrelationId uint64 `protobuf:"varint,1,opt,name=correlationId" json:"correlationId,omitempty"`
+	Chunk         uint32 `protobuf:"varint,2,opt,name=chunk" json:"chunk,omitempty"`
+	Chunks        uint32 `protobuf:"varint,3,opt,name=chunks" json:"chunks,omitempty"`
 }
 
 func (m *SyncStateSnapshotRequest) Reset()         { *m = SyncStateSnapshotRequest{} }
@@ -485,12 +666,14 @@ func (*SyncStateSnapshotRequest) ProtoMessage()    {}
 // SyncState is the payload of Message.SYNC_SNAPSHOT, which is a response
 // to penchainMessage.SYNC_GET_SNAPSHOT. It contains the snapshot or a chunk of the
 // snapshot on stream, and in which case, the sequence indicate the order
-// starting at 0.  The terminating message will have len(delta) == 0.
+// starting at 0.  The terminating message will have len(delta) == 0, and
+// the chained hash of the deltas sent, to verify none was lost.
 type SyncStateSnapshot struct {
 	Delta       []byte                    `protobuf:"bytes,1,opt,name=delta,proto3" json:"delta,omitempty"`
 	Sequence    uint64                    `protobuf:"varint,2,opt,name=sequence" json:"sequence,omitempty"`
 	BlockNumber uint64                    `protobuf:"varint,3,opt,name=blockNumber" json:"blockNumber,omitempty"`
 	Request     *SyncStateSnapshotRequest `protobuf:"bytes,4,opt,name=request" json:"request,omitempty"`
+	Hash        []byte                    `protobuf:"bytes,5,opt,name=hash,proto3" json:"hash,omitempty"`
 }
 
 func (m *SyncStateSnapshot) Reset()         { *m = SyncStateSnapshot{} }
@@ -0,0 +1,33 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protos
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/util"
+)
+
+// GetHash returns the hash of this batch, which the next batch is chained to.
+func (batch *OrderedBatch) GetHash() ([]byte, error) {
+	data, err := proto.Marshal(batch)
+	if err != nil {
+		return nil, fmt.Errorf("Could not calculate hash of batch: %s", err)
+	}
+	return util.ComputeCryptoHash(data), nil
+}
@@ -59,6 +59,87 @@ func (m *ServerStatus) Reset()         { *m = ServerStatus{} }
 func (m *ServerStatus) String() string { return proto.CompactTextString(m) }
 func (*ServerStatus) ProtoMessage()    {}
 
+// Parameters of the consensus plugin adjustable at runtime. Values left
+// to zero or empty are kept as they are.
+type ConsensusParameters struct {
+	BatchSize      uint32 `protobuf:"varint,1,opt,name=batchSize" json:"batchSize,omitempty"`
+	BatchTimeout   string `protobuf:"bytes,2,opt,name=batchTimeout" json:"batchTimeout,omitempty"`
+	RequestTimeout string `protobuf:"bytes,3,opt,name=requestTimeout" json:"requestTimeout,omitempty"`
+}
+
+func (m *ConsensusParameters) Reset()         { *m = ConsensusParameters{} }
+func (m *ConsensusParameters) String() string { return proto.CompactTextString(m) }
+func (*ConsensusParameters) ProtoMessage()    {}
+
+type ConsensusParametersRequest struct {
+	Parameters *ConsensusParameters `protobuf:"bytes,1,opt,name=parameters" json:"parameters,omitempty"`
+	Cert       []byte               `protobuf:"bytes,2,opt,name=cert,proto3" json:"cert,omitempty"`
+	Signature  []byte               `protobuf:"bytes,3,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *ConsensusParametersRequest) Reset()         { *m = ConsensusParametersRequest{} }
+func (m *ConsensusParametersRequest) String() string { return proto.CompactTextString(m) }
+func (*ConsensusParametersRequest) ProtoMessage()    {}
+
+func (m *ConsensusParametersRequest) GetParameters() *ConsensusParameters {
+	if m != nil {
+		return m.Parameters
+	}
+	return nil
+}
+
+// What compacting the ledger pruned.
+type LedgerCompaction struct {
+	BlocksPruned   uint64 `protobuf:"varint,1,opt,name=blocksPruned" json:"blocksPruned,omitempty"`
+	DeltasPruned   uint64 `protobuf:"varint,2,opt,name=deltasPruned" json:"deltasPruned,omitempty"`
+	PrunedBelow    uint64 `protobuf:"varint,3,opt,name=prunedBelow" json:"prunedBelow,omitempty"`
+	BlocksArchived uint64 `protobuf:"varint,4,opt,name=blocksArchived" json:"blocksArchived,omitempty"`
+	ArchivedBelow  uint64 `protobuf:"varint,5,opt,name=archivedBelow" json:"archivedBelow,omitempty"`
+}
+
+func (m *LedgerCompaction) Reset()         { *m = LedgerCompaction{} }
+func (m *LedgerCompaction) String() string { return proto.CompactTextString(m) }
+func (*LedgerCompaction) ProtoMessage()    {}
+
+type LedgerSnapshotRequest struct {
+	Path   string `protobuf:"bytes,1,opt,name=path" json:"path,omitempty"`
+	Height uint64 `protobuf:"varint,2,opt,name=height" json:"height,omitempty"`
+}
+
+func (m *LedgerSnapshotRequest) Reset()         { *m = LedgerSnapshotRequest{} }
+func (m *LedgerSnapshotRequest) String() string { return proto.CompactTextString(m) }
+func (*LedgerSnapshotRequest) ProtoMessage()    {}
+
+// Statistics of the DB, as reported by its store. The counts and sizes not
+// reported by the store are 0.
+type DBStats struct {
+	Backend        string               `protobuf:"bytes,1,opt,name=backend" json:"backend,omitempty"`
+	ColumnFamilies []*ColumnFamilyStats `protobuf:"bytes,2,rep,name=columnFamilies" json:"columnFamilies,omitempty"`
+}
+
+func (m *DBStats) Reset()         { *m = DBStats{} }
+func (m *DBStats) String() string { return proto.CompactTextString(m) }
+func (*DBStats) ProtoMessage()    {}
+
+func (m *DBStats) GetColumnFamilies() []*ColumnFamilyStats {
+	if m != nil {
+		return m.ColumnFamilies
+	}
+	return nil
+}
+
+type ColumnFamilyStats struct {
+	Name       string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Keys       uint64 `protobuf:"varint,2,opt,name=keys" json:"keys,omitempty"`
+	DiskSize   uint64 `protobuf:"varint,3,opt,name=diskSize" json:"diskSize,omitempty"`
+	MemorySize uint64 `protobuf:"varint,4,opt,name=memorySize" json:"memorySize,omitempty"`
+	Details    string `protobuf:"bytes,5,opt,name=details" json:"details,omitempty"`
+}
+
+func (m *ColumnFamilyStats) Reset()         { *m = ColumnFamilyStats{} }
+func (m *ColumnFamilyStats) String() string { return proto.CompactTextString(m) }
+func (*ColumnFamilyStats) ProtoMessage()    {}
+
 func init() {
 	proto.RegisterEnum("protos.ServerStatus_StatusCode", ServerStatus_StatusCode_name, ServerStatus_StatusCode_value)
 }
@@ -74,6 +155,16 @@ type AdminClient interface {
 	GetStatus(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*ServerStatus, error)
 	StartServer(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*ServerStatus, error)
 	StopServer(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*ServerStatus, error)
+	// Return the consensus parameters in effect.
+	GetConsensusParameters(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*ConsensusParameters, error)
+	// Adjust the consensus parameters of the network, once the validators agree on them.
+	SetConsensusParameters(ctx context.Context, in *ConsensusParametersRequest, opts ...grpc.CallOption) (*ConsensusParameters, error)
+	// Prune the ledger as configured and compact its DB.
+	CompactLedger(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*LedgerCompaction, error)
+	// Export a snapshot of the ledger to a file of the peer.
+	ExportLedgerSnapshot(ctx context.Context, in *LedgerSnapshotRequest, opts ...grpc.CallOption) (*BlockchainInfo, error)
+	// Return the statistics of the column families of the DB.
+	GetDBStats(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*DBStats, error)
 }
 
 type adminClient struct {
@@ -111,6 +202,51 @@ func (c *adminClient) StopServer(ctx context.Context, in *google_protobuf1.Empty
 	return out, nil
 }
 
+func (c *adminClient) GetConsensusParameters(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*ConsensusParameters, error) {
+	out := new(ConsensusParameters)
+	err := grpc.Invoke(ctx, "/protos.Admin/GetConsensusParameters", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) SetConsensusParameters(ctx context.Context, in *ConsensusParametersRequest, opts ...grpc.CallOption) (*ConsensusParameters, error) {
+	out := new(ConsensusParameters)
+	err := grpc.Invoke(ctx, "/protos.Admin/SetConsensusParameters", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) CompactLedger(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*LedgerCompaction, error) {
+	out := new(LedgerCompaction)
+	err := grpc.Invoke(ctx, "/protos.Admin/CompactLedger", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ExportLedgerSnapshot(ctx context.Context, in *LedgerSnapshotRequest, opts ...grpc.CallOption) (*BlockchainInfo, error) {
+	out := new(BlockchainInfo)
+	err := grpc.Invoke(ctx, "/protos.Admin/ExportLedgerSnapshot", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetDBStats(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*DBStats, error) {
+	out := new(DBStats)
+	err := grpc.Invoke(ctx, "/protos.Admin/GetDBStats", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for Admin service
 
 type AdminServer interface {
@@ -118,6 +254,16 @@ type AdminServer interface {
 	GetStatus(context.Context, *google_protobuf1.Empty) (*ServerStatus, error)
 	StartServer(context.Context, *google_protobuf1.Empty) (*ServerStatus, error)
 	StopServer(context.Context, *google_protobuf1.Empty) (*ServerStatus, error)
+	// Return the consensus parameters in effect.
+	GetConsensusParameters(context.Context, *google_protobuf1.Empty) (*ConsensusParameters, error)
+	// Adjust the consensus parameters of the network, once the validators agree on them.
+	SetConsensusParameters(context.Context, *ConsensusParametersRequest) (*ConsensusParameters, error)
+	// Prune the ledger as configured and compact its DB.
+	CompactLedger(context.Context, *google_protobuf1.Empty) (*LedgerCompaction, error)
+	// Export a snapshot of the ledger to a file of the peer.
+	ExportLedgerSnapshot(context.Context, *LedgerSnapshotRequest) (*BlockchainInfo, error)
+	// Return the statistics of the column families of the DB.
+	GetDBStats(context.Context, *google_protobuf1.Empty) (*DBStats, error)
 }
 
 func RegisterAdminServer(s *grpc.Server, srv AdminServer) {
@@ -160,6 +306,66 @@ func _Admin_StopServer_Handler(srv interface{}, ctx context.Context, dec func(in
 	return out, nil
 }
 
+func _Admin_GetConsensusParameters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(google_protobuf1.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).GetConsensusParameters(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_SetConsensusParameters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ConsensusParametersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).SetConsensusParameters(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_CompactLedger_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(google_protobuf1.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).CompactLedger(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_ExportLedgerSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(LedgerSnapshotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).ExportLedgerSnapshot(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_GetDBStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(google_protobuf1.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).GetDBStats(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 var _Admin_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "protos.Admin",
 	HandlerType: (*AdminServer)(nil),
@@ -176,6 +382,26 @@ var _Admin_serviceDesc = grpc.ServiceDesc{
 			MethodName: "StopServer",
 			Handler:    _Admin_StopServer_Handler,
 		},
+		{
+			MethodName: "GetConsensusParameters",
+			Handler:    _Admin_GetConsensusParameters_Handler,
+		},
+		{
+			MethodName: "SetConsensusParameters",
+			Handler:    _Admin_SetConsensusParameters_Handler,
+		},
+		{
+			MethodName: "CompactLedger",
+			Handler:    _Admin_CompactLedger_Handler,
+		},
+		{
+			MethodName: "ExportLedgerSnapshot",
+			Handler:    _Admin_ExportLedgerSnapshot_Handler,
+		},
+		{
+			MethodName: "GetDBStats",
+			Handler:    _Admin_GetDBStats_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{},
 }
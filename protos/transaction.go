@@ -68,6 +68,9 @@ func NewChaincodeDeployTransaction(chaincodeDeploymentSpec *ChaincodeDeploymentS
 	transaction.Type = Transaction_CHAINCODE_DEPLOY
 	transaction.Uuid = uuid
 	transaction.Timestamp = util.CreateUtcTimestamp()
+	if chaincodeDeploymentSpec.ChaincodeSpec != nil {
+		transaction.ChainID = chaincodeDeploymentSpec.ChaincodeSpec.ChainID
+	}
 	cID := chaincodeDeploymentSpec.ChaincodeSpec.GetChaincodeID()
 	if cID != nil {
 		data, err := proto.Marshal(cID)
@@ -95,6 +98,9 @@ func NewChaincodeExecute(chaincodeInvocationSpec *ChaincodeInvocationSpec, uuid
 	transaction.Type = typ
 	transaction.Uuid = uuid
 	transaction.Timestamp = util.CreateUtcTimestamp()
+	if chaincodeInvocationSpec.ChaincodeSpec != nil {
+		transaction.ChainID = chaincodeInvocationSpec.ChaincodeSpec.ChainID
+	}
 	cID := chaincodeInvocationSpec.ChaincodeSpec.GetChaincodeID()
 	if cID != nil {
 		data, err := proto.Marshal(cID)
@@ -46,3 +46,29 @@ func Test_Transaction_CreateNew(t *testing.T) {
 	}
 
 }
+
+func Test_Transaction_ChainID(t *testing.T) {
+	spec := &ChaincodeSpec{ChaincodeID: &ChaincodeID{Name: "mycc"}, ChainID: "chain1"}
+	deployTx, err := NewChaincodeDeployTransaction(&ChaincodeDeploymentSpec{ChaincodeSpec: spec}, "uuid1")
+	if err != nil {
+		t.Fatalf("Error creating the deploy transaction: %s", err)
+	}
+	invokeTx, err := NewChaincodeExecute(&ChaincodeInvocationSpec{ChaincodeSpec: spec}, "uuid2", Transaction_CHAINCODE_INVOKE)
+	if err != nil {
+		t.Fatalf("Error creating the invoke transaction: %s", err)
+	}
+
+	for _, tx := range []*Transaction{deployTx, invokeTx} {
+		data, err := proto.Marshal(tx)
+		if err != nil {
+			t.Fatalf("Error marshalling transaction: %s", err)
+		}
+		txUnmarshalled := &Transaction{}
+		if err = proto.Unmarshal(data, txUnmarshalled); err != nil {
+			t.Fatalf("Error unmarshalling transaction: %s", err)
+		}
+		if txUnmarshalled.ChainID != "chain1" {
+			t.Fatalf("Transaction %s is on chain [%s], expected chain1", tx.Uuid, txUnmarshalled.ChainID)
+		}
+	}
+}